@@ -0,0 +1,197 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+var (
+	ErrKeyNotFound      = errors.New("api key not found")
+	ErrInvalidRole      = errors.New("invalid api key role")
+	defaultKeysFilePath = "api-keys.yaml"
+	fnGetKeys           = config.GetConfig[[]*Key]
+	fnSetKeys           = config.SetConfig[[]*Key]
+)
+
+func init() {
+	config.Backups.Register(defaultKeysFilePath, "API keys")
+}
+
+// Role is what the holder of a key is allowed to do.
+type Role string
+
+const (
+	RoleKiosk    = Role("kiosk")    // RoleKiosk can only read the summary of the key's group, e.g. for a display.
+	RoleViewer   = Role("viewer")   // RoleViewer can read the dashboards but change nothing.
+	RoleOperator = Role("operator") // RoleOperator can also give groups time, but can't change their config.
+	RoleAdmin    = Role("admin")    // RoleAdmin has full control.
+)
+
+// Key is an API key with a role. Kiosk keys can only read the summary of a single group.
+// Only a hash of the token is saved so tokens can't be recovered from the config file.
+type Key struct {
+	ID        string       `yaml:"id" json:"id"`
+	Name      string       `yaml:"name" json:"name"`
+	Group     models.Group `yaml:"group" json:"group,omitempty"`
+	Role      Role         `yaml:"role,omitempty" json:"role"`
+	TokenHash string       `yaml:"tokenHash" json:"-"`
+	CreatedAt time.Time    `yaml:"createdAt" json:"createdAt"`
+}
+
+// KeyRole returns the key's role. Keys saved before roles existed are kiosk keys.
+func (k Key) KeyRole() Role {
+	if k.Role == "" {
+		return RoleKiosk
+	}
+	return k.Role
+}
+
+// Store saves API keys in a YAML file in the app home directory.
+type Store struct {
+	mu      sync.Mutex // mu protects keys and the file via config.GetConfig/SetConfig.
+	muStore sync.Mutex // muStore serialises Create and Delete so that changes aren't lost.
+	keys    []*Key
+	nowFunc func() time.Time
+}
+
+// NewStore loads existing API keys from disk.
+func NewStore() (*Store, error) {
+	s := &Store{nowFunc: time.Now}
+	var err error
+	s.keys, err = fnGetKeys(&s.mu, defaultKeysFilePath, func() []*Key { return make([]*Key, 0) })
+	if err != nil {
+		return nil, fmt.Errorf("failed to load api keys: %w", err)
+	}
+	return s, nil
+}
+
+// List returns a copy of all keys.
+func (s *Store) List() []Key {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	retval := make([]Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		k := *k
+		k.Role = k.KeyRole()
+		retval = append(retval, k)
+	}
+	return retval
+}
+
+// HasRole returns true if any key has the role.
+func (s *Store) HasRole(role Role) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.ContainsFunc(s.keys, func(k *Key) bool { return k.KeyRole() == role })
+}
+
+// Create generates a new key with the role and saves it. Kiosk keys need a group, which other roles mustn't have.
+// The plain text token is returned once and can't be fetched again.
+func (s *Store) Create(name string, group models.Group, role Role) (Key, string, error) {
+	switch role {
+	case RoleKiosk:
+		if group == "" {
+			return Key{}, "", fmt.Errorf("group must be supplied")
+		}
+	case RoleViewer, RoleOperator, RoleAdmin:
+		if group != "" {
+			return Key{}, "", fmt.Errorf("%w: only kiosk keys have a group", ErrInvalidRole)
+		}
+	default:
+		return Key{}, "", fmt.Errorf("%w: %q", ErrInvalidRole, role)
+	}
+
+	token, err := randomHex(32)
+	if err != nil {
+		return Key{}, "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	id, err := randomHex(4)
+	if err != nil {
+		return Key{}, "", fmt.Errorf("failed to generate key id: %w", err)
+	}
+
+	k := &Key{
+		ID:        id,
+		Name:      name,
+		Group:     group,
+		Role:      role,
+		TokenHash: hashToken(token),
+		CreatedAt: s.nowFunc().UTC(),
+	}
+
+	s.muStore.Lock()
+	defer s.muStore.Unlock()
+	if err = s.save(append(s.copyKeys(), k)); err != nil {
+		return Key{}, "", err
+	}
+	return *k, token, nil
+}
+
+// Delete removes the key with the given ID.
+func (s *Store) Delete(id string) error {
+	s.muStore.Lock()
+	defer s.muStore.Unlock()
+	keys := s.copyKeys()
+	idx := slices.IndexFunc(keys, func(k *Key) bool { return k.ID == id })
+	if idx < 0 {
+		return ErrKeyNotFound
+	}
+	return s.save(slices.Delete(keys, idx, idx+1))
+}
+
+// Lookup returns the key matching the plain text token.
+func (s *Store) Lookup(token string) (Key, bool) {
+	if token == "" {
+		return Key{}, false
+	}
+	h := []byte(hashToken(token))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.keys {
+		if subtle.ConstantTimeCompare(h, []byte(k.TokenHash)) == 1 {
+			found := *k
+			found.Role = found.KeyRole()
+			return found, true
+		}
+	}
+	return Key{}, false
+}
+
+// copyKeys returns a shallow copy of the keys slice.
+func (s *Store) copyKeys() []*Key {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.keys)
+}
+
+// save writes the keys to disk and replaces the in-memory copy.
+func (s *Store) save(keys []*Key) error {
+	err := fnSetKeys(&s.mu, defaultKeysFilePath, nil, func(v []*Key) { s.keys = v }, keys)
+	if err != nil {
+		return fmt.Errorf("failed to save api keys: %w", err)
+	}
+	return nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(numBytes int) (string, error) {
+	b := make([]byte, numBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,85 @@
+package apikeys
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/models"
+)
+
+func mockKeysFile(t *testing.T) *[]*Key {
+	origGet, origSet := fnGetKeys, fnSetKeys
+	t.Cleanup(func() {
+		fnGetKeys, fnSetKeys = origGet, origSet
+	})
+	var saved []*Key
+	fnGetKeys = func(mu *sync.Mutex, configPath string, newInstance func() []*Key) ([]*Key, error) {
+		return saved, nil
+	}
+	fnSetKeys = func(mu *sync.Mutex, configPath string, validate func(v []*Key) error, updateInMemory func(v []*Key), v []*Key) error {
+		mu.Lock()
+		defer mu.Unlock()
+		saved = v
+		updateInMemory(v)
+		return nil
+	}
+	return &saved
+}
+
+func TestStore_CreateLookupDelete(t *testing.T) {
+	saved := mockKeysFile(t)
+
+	s, err := NewStore()
+	assert.NoError(t, err)
+
+	_, _, err = s.Create("no group", "", RoleKiosk)
+	assert.Error(t, err, "expected error when group is empty")
+
+	k, token, err := s.Create("kids room kiosk", models.Group("kids"), RoleKiosk)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, token)
+	assert.Equal(t, models.Group("kids"), k.Group)
+	assert.Len(t, *saved, 1, "expected key to be saved")
+	assert.NotEqual(t, token, (*saved)[0].TokenHash, "expected only the token hash to be saved")
+
+	found, ok := s.Lookup(token)
+	assert.True(t, ok, "expected token to be found")
+	assert.Equal(t, k.ID, found.ID)
+
+	_, ok = s.Lookup("bad-token")
+	assert.False(t, ok, "expected bad token to be rejected")
+	_, ok = s.Lookup("")
+	assert.False(t, ok, "expected empty token to be rejected")
+
+	assert.ErrorIs(t, s.Delete("missing"), ErrKeyNotFound)
+	assert.NoError(t, s.Delete(k.ID))
+	assert.Empty(t, s.List())
+	_, ok = s.Lookup(token)
+	assert.False(t, ok, "expected deleted token to be rejected")
+}
+
+func TestStore_Roles(t *testing.T) {
+	saved := mockKeysFile(t)
+	*saved = []*Key{{ID: "old", Name: "saved before roles", Group: "kids", TokenHash: hashToken("old-token")}}
+
+	s, err := NewStore()
+	assert.NoError(t, err)
+	found, ok := s.Lookup("old-token")
+	assert.True(t, ok)
+	assert.Equal(t, RoleKiosk, found.Role, "expected keys without a role to be kiosk keys")
+	assert.False(t, s.HasRole(RoleAdmin))
+
+	_, _, err = s.Create("grandma", "kids", RoleOperator)
+	assert.ErrorIs(t, err, ErrInvalidRole, "expected only kiosk keys to have a group")
+	_, _, err = s.Create("root", "", Role("root"))
+	assert.ErrorIs(t, err, ErrInvalidRole)
+
+	k, token, err := s.Create("grandma", "", RoleOperator)
+	assert.NoError(t, err)
+	found, ok = s.Lookup(token)
+	assert.True(t, ok)
+	assert.Equal(t, k.ID, found.ID)
+	assert.Equal(t, RoleOperator, found.Role)
+	assert.True(t, s.HasRole(RoleOperator))
+}
@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"relloyd/tubetimeout/config"
+)
+
+var (
+	defaultAuditFilePath = "audit.jsonl"
+	maxLineSize          = 1024 * 1024 // maxLineSize allows for large before/after values like the DHCP config.
+)
+
+// Entry is a single admin action saved as one line of JSON.
+type Entry struct {
+	Time     time.Time       `json:"time"`
+	SourceIP string          `json:"sourceIp"`
+	Actor    string          `json:"actor,omitempty"` // Actor is the name and ID of the API key used, if any.
+	Action   string          `json:"action"`
+	Target   string          `json:"target,omitempty"` // Target is the group, key ID etc. affected by the action, if any.
+	Before   json.RawMessage `json:"before,omitempty"`
+	After    json.RawMessage `json:"after,omitempty"`
+}
+
+// Log is an append-only JSONL file of admin actions.
+type Log struct {
+	mu       sync.Mutex
+	filePath string
+	nowFunc  func() time.Time
+}
+
+// NewLog returns a Log that appends to the audit file in the app home directory.
+func NewLog() (*Log, error) {
+	filePath, err := config.DefaultStore.Path(defaultAuditFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit file path: %w", err)
+	}
+	return &Log{filePath: filePath, nowFunc: time.Now}, nil
+}
+
+// Snapshot marshals v immediately so the value can be recorded as it was before it is changed.
+// It returns nil if v can't be marshalled.
+func Snapshot(v any) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// Record appends the entry to the audit file, setting the time if it is empty.
+func (l *Log) Record(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = l.nowFunc().UTC()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.OpenFile(l.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return f.Sync()
+}
+
+// Page returns up to limit entries, newest first, after skipping offset entries.
+// It also returns the total number of entries.
+func (l *Log) Page(offset, limit int) ([]Entry, int, error) {
+	entries, err := l.readAll()
+	if err != nil {
+		return nil, 0, err
+	}
+	total := len(entries)
+	slices.Reverse(entries)
+
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= total || limit <= 0 {
+		return []Entry{}, total, nil
+	}
+	end := min(offset+limit, total)
+	return entries[offset:end], total, nil
+}
+
+// readAll reads every entry in the audit file, skipping lines that can't be parsed.
+func (l *Log) readAll() ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Entry{}, nil
+		}
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+	defer f.Close()
+
+	entries := make([]Entry, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var e Entry
+		if err = json.Unmarshal(scanner.Bytes(), &e); err != nil { // if the line is corrupt, e.g. after a power cut...
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit file: %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog_RecordAndPage(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "audit.jsonl")
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := &Log{filePath: filePath, nowFunc: func() time.Time { return now }}
+
+	// Empty log.
+	entries, total, err := l.Page(0, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, total)
+	assert.Empty(t, entries)
+
+	for _, action := range []string{"first", "second", "third"} {
+		assert.NoError(t, l.Record(Entry{SourceIP: "192.168.1.10", Action: action, Before: Snapshot(map[string]int{"a": 1}), After: Snapshot(map[string]int{"a": 2})}))
+	}
+
+	// Corrupt lines are skipped.
+	f, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	_, _ = f.WriteString("{not json\n")
+	_ = f.Close()
+
+	entries, total, err = l.Page(0, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, total)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "third", entries[0].Action, "expected newest entry first")
+		assert.Equal(t, "second", entries[1].Action)
+		assert.Equal(t, now, entries[0].Time)
+		assert.JSONEq(t, `{"a":1}`, string(entries[0].Before))
+		assert.JSONEq(t, `{"a":2}`, string(entries[0].After))
+	}
+
+	entries, _, err = l.Page(2, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "first", entries[0].Action)
+	}
+
+	entries, _, err = l.Page(5, 2)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,188 @@
+package bypass
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+const expireInterval = time.Minute // expireInterval is how often blocks that have ended are lifted.
+
+// dnsPorts are the ports of the bypass ports that are DNS, and DNS over TLS, rather than VPNs.
+var dnsPorts = []uint16{53, 853}
+
+// Blocker drops the traffic from the local IPs to the bypass ports and IPs, e.g. with NFT rules.
+type Blocker interface {
+	UpdateBypassBlocked(ips []models.Ip)
+}
+
+type alertKey struct {
+	ip   models.Ip
+	kind models.BypassKind
+}
+
+// Detector spots the tracked devices trying to get around the filter, from the packets they send to the bypass ports
+// and IPs, and notifies its receivers. If blocking is on, the devices caught are blocked from the bypass ports and IPs
+// for a while.
+type Detector struct {
+	logger    *zap.SugaredLogger
+	cfg       *config.FilterConfig
+	blocker   Blocker
+	ports     map[uint16]models.BypassKind
+	ips       map[models.Ip]models.BypassKind
+	receivers []models.BypassAttemptReceiver
+	changed   chan struct{} // changed is signalled when a device is blocked, so the worker updates the blocker.
+	mu        sync.Mutex
+	groups    models.MapIpGroups      // groups are the groups of the tracked IPs, guarded by mu.
+	alerted   map[alertKey]time.Time  // alerted is when each device was last notified for each kind of attempt, guarded by mu.
+	blocked   map[models.Ip]time.Time // blocked are the devices blocked from the bypass ports and IPs and until when, guarded by mu.
+	nowFunc   func() time.Time
+}
+
+// NewDetector returns a Detector of the bypass ports and IPs in cfg. The devices caught are blocked by blocker if
+// cfg.BypassBlock is set, until ctx is done.
+func NewDetector(ctx context.Context, logger *zap.SugaredLogger, cfg *config.FilterConfig, blocker Blocker) (*Detector, error) {
+	d := &Detector{
+		logger:  logger,
+		cfg:     cfg,
+		blocker: blocker,
+		ports:   make(map[uint16]models.BypassKind),
+		ips:     make(map[models.Ip]models.BypassKind),
+		changed: make(chan struct{}, 1),
+		groups:  make(models.MapIpGroups),
+		alerted: make(map[alertKey]time.Time),
+		blocked: make(map[models.Ip]time.Time),
+		nowFunc: time.Now,
+	}
+	for _, port := range cfg.BypassPorts {
+		d.ports[port] = models.BypassVPN
+		if slices.Contains(dnsPorts, port) {
+			d.ports[port] = models.BypassDNS
+		}
+	}
+	for kind, ips := range map[models.BypassKind][]string{models.BypassDoH: cfg.BypassResolverIPs, models.BypassVPN: cfg.BypassVPNIPs} {
+		for _, s := range ips {
+			ip := net.ParseIP(s).To4()
+			if ip == nil {
+				return nil, fmt.Errorf("bypass IP %q isn't an IPv4 address", s)
+			}
+			d.ips[models.Ip(ip.String())] = kind
+		}
+	}
+
+	if cfg.BypassBlock {
+		if blocker == nil {
+			return nil, fmt.Errorf("bypass blocking needs a blocker")
+		}
+		go d.startWorker(ctx)
+	}
+	return d, nil
+}
+
+// RegisterBypassAttemptReceivers adds receivers to be notified of the devices caught.
+func (d *Detector) RegisterBypassAttemptReceivers(receivers ...models.BypassAttemptReceiver) {
+	d.receivers = append(d.receivers, receivers...)
+}
+
+// UpdateSourceIpGroups implements the SourceIpGroupsReceiver interface so that only the tracked devices are caught.
+func (d *Detector) UpdateSourceIpGroups(newData models.MapIpGroups) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.groups = newData
+}
+
+// Detect is sent each outbound packet from src to dst and its destination port, or 0 if it has none. Packets that
+// aren't from a tracked device to a bypass port or IP are ignored. Detect doesn't block, so it's safe to call for
+// every packet.
+func (d *Detector) Detect(src, dst models.Ip, port uint16) {
+	kind, ok := d.ports[port] // match the port first since resolvers answer plain DNS too.
+	if !ok {
+		if kind, ok = d.ips[dst]; !ok {
+			return
+		}
+	}
+
+	d.mu.Lock()
+	groups := d.groups[src]
+	if len(groups) == 0 { // if the device isn't tracked...
+		d.mu.Unlock()
+		return
+	}
+	now := d.nowFunc()
+	key := alertKey{ip: src, kind: kind}
+	if last, ok := d.alerted[key]; ok && now.Sub(last) < d.cfg.BypassAlertInterval { // if the device was notified recently...
+		d.mu.Unlock()
+		return
+	}
+	d.alerted[key] = now
+	blocked := d.cfg.BypassBlock
+	if blocked {
+		d.blocked[src] = now.Add(d.cfg.BypassBlockDuration)
+	}
+	d.mu.Unlock()
+
+	if blocked {
+		select {
+		case d.changed <- struct{}{}:
+		default: // the worker has an update to make already.
+		}
+	}
+	a := models.BypassAttempt{Time: now, IP: src, Groups: slices.Clone(groups), Kind: kind, Remote: dst, Port: port, Blocked: blocked}
+	d.logger.Warnf("Device %v in groups %v tried to get around the filter (%v to %v port %v, blocked=%v)", src, groups, kind, dst, port, blocked)
+	for _, r := range d.receivers {
+		r.UpdateBypassAttempt(a)
+	}
+}
+
+// startWorker updates the blocker as devices are blocked and their blocks end, until ctx is done.
+func (d *Detector) startWorker(ctx context.Context) {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !d.expire(d.nowFunc()) {
+				continue
+			}
+		case <-d.changed:
+		}
+		d.blocker.UpdateBypassBlocked(d.blockedIPs())
+	}
+}
+
+// expire lifts the blocks that have ended and forgets the alerts that are old enough to be sent again. It returns
+// true if any blocks were lifted.
+func (d *Detector) expire(now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	lifted := false
+	for ip, until := range d.blocked {
+		if !now.Before(until) {
+			delete(d.blocked, ip)
+			lifted = true
+		}
+	}
+	for key, last := range d.alerted {
+		if now.Sub(last) >= d.cfg.BypassAlertInterval {
+			delete(d.alerted, key)
+		}
+	}
+	return lifted
+}
+
+// blockedIPs returns the devices blocked from the bypass ports and IPs, sorted.
+func (d *Detector) blockedIPs() []models.Ip {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Sorted(maps.Keys(d.blocked))
+}
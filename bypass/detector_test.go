@@ -0,0 +1,120 @@
+package bypass
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+type mockBlocker struct {
+	mu  sync.Mutex
+	ips [][]models.Ip
+}
+
+func (m *mockBlocker) UpdateBypassBlocked(ips []models.Ip) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ips = append(m.ips, ips)
+}
+
+func (m *mockBlocker) last() []models.Ip {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.ips) == 0 {
+		return nil
+	}
+	return m.ips[len(m.ips)-1]
+}
+
+type mockReceiver struct {
+	attempts []models.BypassAttempt
+}
+
+func (m *mockReceiver) UpdateBypassAttempt(a models.BypassAttempt) {
+	m.attempts = append(m.attempts, a)
+}
+
+func testConfig() *config.FilterConfig {
+	return &config.FilterConfig{
+		BypassPorts:         []uint16{53, 853, 51820},
+		BypassResolverIPs:   []string{"1.1.1.1"},
+		BypassVPNIPs:        []string{"203.0.113.7"},
+		BypassBlockDuration: time.Hour,
+		BypassAlertInterval: time.Hour,
+	}
+}
+
+func TestDetector_Detect(t *testing.T) {
+	d, err := NewDetector(context.Background(), config.MustGetLogger(), testConfig(), nil)
+	require.NoError(t, err)
+	now := time.Date(2025, 3, 1, 19, 0, 0, 0, time.UTC)
+	d.nowFunc = func() time.Time { return now }
+	r := &mockReceiver{}
+	d.RegisterBypassAttemptReceivers(r)
+	d.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}})
+
+	d.Detect("192.168.1.10", "142.250.1.1", 443) // ordinary traffic isn't reported.
+	d.Detect("192.168.1.99", "1.1.1.1", 443)     // untracked devices aren't reported.
+	assert.Empty(t, r.attempts)
+
+	d.Detect("192.168.1.10", "1.1.1.1", 443)
+	d.Detect("192.168.1.10", "1.1.1.1", 53) // the port is matched before the IP.
+	d.Detect("192.168.1.10", "142.250.1.1", 51820)
+	d.Detect("192.168.1.10", "203.0.113.7", 443) // a VPN was reported already.
+	if assert.Len(t, r.attempts, 3) {
+		assert.Equal(t, models.BypassAttempt{Time: now, IP: "192.168.1.10", Groups: []models.Group{"kids"}, Kind: models.BypassDoH, Remote: "1.1.1.1", Port: 443}, r.attempts[0])
+		assert.Equal(t, models.BypassDNS, r.attempts[1].Kind)
+		assert.Equal(t, models.BypassVPN, r.attempts[2].Kind)
+		assert.False(t, r.attempts[2].Blocked)
+	}
+
+	// Expect the device to be reported again once the alert interval has passed.
+	now = now.Add(time.Hour)
+	d.Detect("192.168.1.10", "1.1.1.1", 443)
+	assert.Len(t, r.attempts, 4)
+}
+
+func TestDetector_Block(t *testing.T) {
+	cfg := testConfig()
+	cfg.BypassBlock = true
+	_, err := NewDetector(context.Background(), config.MustGetLogger(), cfg, nil)
+	assert.Error(t, err, "expected blocking to need a blocker")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := &mockBlocker{}
+	d, err := NewDetector(ctx, config.MustGetLogger(), cfg, b)
+	require.NoError(t, err)
+	now := time.Date(2025, 3, 1, 19, 0, 0, 0, time.UTC)
+	d.nowFunc = func() time.Time { return now }
+	r := &mockReceiver{}
+	d.RegisterBypassAttemptReceivers(r)
+	d.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}, "192.168.1.11": {"teens"}})
+
+	d.Detect("192.168.1.11", "203.0.113.7", 443)
+	d.Detect("192.168.1.10", "1.1.1.1", 853)
+	if assert.Len(t, r.attempts, 2) {
+		assert.True(t, r.attempts[0].Blocked)
+	}
+	assert.Eventually(t, func() bool { return len(b.last()) == 2 }, time.Second, time.Millisecond)
+	assert.Equal(t, []models.Ip{"192.168.1.10", "192.168.1.11"}, d.blockedIPs())
+
+	// Expect the blocks to be lifted once they've ended.
+	assert.False(t, d.expire(now.Add(time.Minute)))
+	assert.True(t, d.expire(now.Add(time.Hour)))
+	assert.Empty(t, d.blockedIPs())
+	assert.Empty(t, d.alerted, "expected old alerts to be forgotten")
+}
+
+func TestNewDetector_BadIP(t *testing.T) {
+	cfg := testConfig()
+	cfg.BypassVPNIPs = []string{"vpn.example.com"}
+	_, err := NewDetector(context.Background(), config.MustGetLogger(), cfg, nil)
+	assert.Error(t, err)
+}
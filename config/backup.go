@@ -0,0 +1,301 @@
+package config
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	backupManifestName    = "manifest.yaml"
+	backupManifestVersion = 1
+	maxBackupFileSize     = 16 << 20 // 16 MiB per file is far more than any config or samples file needs.
+	restoreStagingSuffix  = ".restore"
+	restorePreviousSuffix = ".pre-restore"
+)
+
+var (
+	// Backups knows about every config file that should be included in a backup.
+	// Packages that own config files register them in init().
+	Backups = &backups{}
+
+	ErrInvalidBackup = errors.New("invalid backup archive")
+)
+
+func init() {
+	Backups.Register(groupMACsFileName, "device groups")
+	Backups.Register(groupDomainsFileName, "domain groups")
+	Backups.Register(EnvFileName, "environment settings")
+}
+
+// BackupFile is a config file included in backups.
+type BackupFile struct {
+	Name  string `yaml:"name" json:"name"`   // Name is the file name in the app home directory.
+	Owner string `yaml:"owner" json:"owner"` // Owner describes the feature that uses the file.
+}
+
+// backupManifest is saved in each archive to describe its contents.
+type backupManifest struct {
+	Version      int          `yaml:"version"`
+	BuildVersion string       `yaml:"buildVersion"`
+	CreatedAt    time.Time    `yaml:"createdAt"`
+	Files        []BackupFile `yaml:"files"`
+}
+
+type backups struct {
+	mu    sync.Mutex
+	files []BackupFile
+}
+
+// Register adds a file in the app home directory to backups.
+func (b *backups) Register(name, owner string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if slices.ContainsFunc(b.files, func(f BackupFile) bool { return f.Name == name }) {
+		return
+	}
+	b.files = append(b.files, BackupFile{Name: name, Owner: owner})
+}
+
+// Files returns the registered files.
+func (b *backups) Files() []BackupFile {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.files)
+}
+
+func (b *backups) lookup(name string) (BackupFile, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	i := slices.IndexFunc(b.files, func(f BackupFile) bool { return f.Name == name })
+	if i < 0 {
+		return BackupFile{}, false
+	}
+	return b.files[i], true
+}
+
+// WriteBackup writes a tar.gz archive of all registered files that exist, plus a manifest.
+func (b *backups) WriteBackup(w io.Writer) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	manifest := backupManifest{Version: backupManifestVersion, BuildVersion: BuildVersion, CreatedAt: now.UTC()}
+	contents := make(map[string][]byte)
+	for _, f := range b.Files() {
+		path, err := DefaultStore.Path(f.Name)
+		if err != nil {
+			return fmt.Errorf("failed to get path for %v: %w", f.Name, err)
+		}
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) { // if the feature hasn't saved anything yet...
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to read %v: %w", f.Name, err)
+		}
+		contents[f.Name] = data
+		manifest.Files = append(manifest.Files, f)
+	}
+
+	manifestData, err := yaml.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal backup manifest: %w", err)
+	}
+	if err = writeTarFile(tw, backupManifestName, manifestData, now); err != nil {
+		return err
+	}
+	for _, f := range manifest.Files {
+		if err = writeTarFile(tw, f.Name, contents[f.Name], now); err != nil {
+			return err
+		}
+	}
+
+	if err = tw.Close(); err != nil {
+		return fmt.Errorf("failed to close backup archive: %w", err)
+	}
+	return gz.Close()
+}
+
+func writeTarFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: modTime, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write backup header for %v: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write backup data for %v: %w", name, err)
+	}
+	return nil
+}
+
+// RestoreBackup validates the archive and then replaces the registered files with the archived copies.
+// Nothing is written unless every file in the archive is valid, and files are swapped into place together, so a
+// failure part way through rolls back to the previous files. Files that aren't in the archive are left alone.
+// It returns the names of the files restored. The app must be restarted to load the restored config.
+func (b *backups) RestoreBackup(r io.Reader) ([]string, error) {
+	files, err := b.readBackup(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Stage every file next to its target.
+	type staged struct {
+		name, path string
+	}
+	var stagedFiles []staged
+	cleanup := func() {
+		for _, s := range stagedFiles {
+			_ = os.Remove(s.path + restoreStagingSuffix)
+		}
+	}
+	for name, data := range files {
+		path, err := DefaultStore.Path(name)
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("failed to get path for %v: %w", name, err)
+		}
+		if err = writeSynced(path+restoreStagingSuffix, data); err != nil {
+			cleanup()
+			return nil, fmt.Errorf("failed to stage %v: %w", name, err)
+		}
+		stagedFiles = append(stagedFiles, staged{name: name, path: path})
+	}
+
+	// Swap the staged files into place, keeping the previous files until all renames succeed.
+	var swapped []staged
+	hadPrevious := make(map[string]bool)
+	rollback := func() {
+		for _, s := range swapped {
+			if hadPrevious[s.path] {
+				_ = os.Rename(s.path+restorePreviousSuffix, s.path)
+			} else {
+				_ = os.Remove(s.path)
+			}
+		}
+		cleanup()
+	}
+	for _, s := range stagedFiles {
+		if err = os.Rename(s.path, s.path+restorePreviousSuffix); err == nil {
+			hadPrevious[s.path] = true
+		} else if !errors.Is(err, os.ErrNotExist) {
+			rollback()
+			return nil, fmt.Errorf("failed to move aside %v: %w", s.name, err)
+		}
+		if err = os.Rename(s.path+restoreStagingSuffix, s.path); err != nil {
+			if hadPrevious[s.path] {
+				_ = os.Rename(s.path+restorePreviousSuffix, s.path)
+			}
+			rollback()
+			return nil, fmt.Errorf("failed to restore %v: %w", s.name, err)
+		}
+		swapped = append(swapped, s)
+	}
+
+	restored := make([]string, 0, len(swapped))
+	for _, s := range swapped {
+		_ = os.Remove(s.path + restorePreviousSuffix)
+		restored = append(restored, s.name)
+	}
+	slices.Sort(restored)
+	return restored, nil
+}
+
+// readBackup reads and validates every file in the archive.
+func (b *backups) readBackup(r io.Reader) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	files := make(map[string][]byte)
+	var manifest *backupManifest
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("%w: %v is not a regular file", ErrInvalidBackup, hdr.Name)
+		}
+		if hdr.Size > maxBackupFileSize {
+			return nil, fmt.Errorf("%w: %v is too large", ErrInvalidBackup, hdr.Name)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxBackupFileSize))
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to read %v: %v", ErrInvalidBackup, hdr.Name, err)
+		}
+
+		if hdr.Name == backupManifestName {
+			manifest = &backupManifest{}
+			if err = yaml.Unmarshal(data, manifest); err != nil {
+				return nil, fmt.Errorf("%w: bad manifest: %v", ErrInvalidBackup, err)
+			}
+			continue
+		}
+		if _, ok := b.lookup(hdr.Name); !ok { // if this isn't a file we know about, e.g. a path outside the home dir...
+			return nil, fmt.Errorf("%w: unexpected file %v", ErrInvalidBackup, hdr.Name)
+		}
+		if err = validateBackupFile(hdr.Name, data); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidBackup, err)
+		}
+		files[hdr.Name] = data
+	}
+
+	if manifest == nil {
+		return nil, fmt.Errorf("%w: missing %v", ErrInvalidBackup, backupManifestName)
+	}
+	if manifest.Version > backupManifestVersion {
+		return nil, fmt.Errorf("%w: manifest version %v is newer than supported version %v", ErrInvalidBackup, manifest.Version, backupManifestVersion)
+	}
+	return files, nil
+}
+
+// validateBackupFile checks that config files parse, so a corrupt archive can't stop the app from starting.
+func validateBackupFile(name string, data []byte) error {
+	var v any
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("%v is not valid YAML: %w", name, err)
+		}
+	case ".json":
+		if len(bytes.TrimSpace(data)) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(data, &v); err != nil {
+			return fmt.Errorf("%v is not valid JSON: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func writeSynced(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
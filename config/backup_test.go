@@ -0,0 +1,100 @@
+package config
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func setupBackupDir(t *testing.T) (string, *backups) {
+	t.Helper()
+	dir := t.TempDir()
+	orig := DefaultStore
+	DefaultStore = NewStore(dir)
+	t.Cleanup(func() { DefaultStore = orig })
+
+	b := &backups{}
+	b.Register("group-macs.yaml", "device groups")
+	b.Register("samples.json", "usage samples")
+	b.Register("missing.yaml", "not saved yet")
+	return dir, b
+}
+
+func makeArchive(t *testing.T, files map[string]string) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range files {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(data))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	return buf
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	dir, b := setupBackupDir(t)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "group-macs.yaml"), []byte("groups:\n  kids: []\n"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "samples.json"), []byte(`{"kids":{}}`), 0644))
+
+	archive := &bytes.Buffer{}
+	assert.NoError(t, b.WriteBackup(archive))
+
+	// Change the files after the backup.
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "group-macs.yaml"), []byte("groups: {}\n"), 0644))
+	assert.NoError(t, os.Remove(filepath.Join(dir, "samples.json")))
+
+	restored, err := b.RestoreBackup(archive)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"group-macs.yaml", "samples.json"}, restored, "expected the missing file to be skipped in the backup")
+
+	data, _ := os.ReadFile(filepath.Join(dir, "group-macs.yaml"))
+	assert.Equal(t, "groups:\n  kids: []\n", string(data))
+	data, _ = os.ReadFile(filepath.Join(dir, "samples.json"))
+	assert.Equal(t, `{"kids":{}}`, string(data))
+
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "*.restore"))
+	assert.Empty(t, leftovers, "expected staged files to be cleaned up")
+	leftovers, _ = filepath.Glob(filepath.Join(dir, "*.pre-restore"))
+	assert.Empty(t, leftovers, "expected previous files to be cleaned up")
+}
+
+func TestRestoreBackup_Invalid(t *testing.T) {
+	manifest := "version: 1\n"
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{
+		{"Missing manifest", map[string]string{"group-macs.yaml": "groups: {}\n"}},
+		{"Unknown file", map[string]string{backupManifestName: manifest, "../../etc/passwd": "root"}},
+		{"Corrupt YAML", map[string]string{backupManifestName: manifest, "group-macs.yaml": "groups: [\n"}},
+		{"Corrupt JSON", map[string]string{backupManifestName: manifest, "samples.json": "{"}},
+		{"Newer manifest", map[string]string{backupManifestName: "version: 99\n"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, b := setupBackupDir(t)
+			assert.NoError(t, os.WriteFile(filepath.Join(dir, "group-macs.yaml"), []byte("groups: {}\n"), 0644))
+
+			_, err := b.RestoreBackup(makeArchive(t, tt.files))
+			assert.True(t, errors.Is(err, ErrInvalidBackup), "expected ErrInvalidBackup, got %v", err)
+
+			data, _ := os.ReadFile(filepath.Join(dir, "group-macs.yaml"))
+			assert.Equal(t, "groups: {}\n", string(data), "expected existing config to be untouched")
+		})
+	}
+
+	_, b := setupBackupDir(t)
+	_, err := b.RestoreBackup(bytes.NewBufferString("not a gzip file"))
+	assert.True(t, errors.Is(err, ErrInvalidBackup), "expected ErrInvalidBackup, got %v", err)
+}
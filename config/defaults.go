@@ -1,8 +1,12 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
+	"net"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
@@ -11,7 +15,8 @@ import (
 
 var (
 	AppHomeDir = ".tubetimeout"
-	// AppCfg is the application configuration.
+	// AppCfg is the application configuration loaded at startup. Reloads don't change it, so read settings that can be
+	// reloaded from Current instead.
 	AppCfg AppConfig
 	// BuildTime is set by the go build command - probably see the Makefile.
 	BuildTime string
@@ -30,14 +35,45 @@ func init() {
 
 type AppConfig struct {
 	LogLevel              string                `envconfig:"LOG_LEVEL" default:"info"`
+	LogConfig             LogConfig             `envconfig:"LOG"`
 	DelayStart            bool                  `envconfig:"DELAY_START" default:"true"`
-	DebugConfig           DebugConfig           `envconfig:"DEBUG"`
-	DHCPServerDisabled    bool                  `envconfig:"DHCP_SERVER_DISABLED" default:"false"` // DHCPServerDisabled is a hack to indicate whether we attempt to start DHCP server functionality at all, aiming to help debugging which needs a stable eth0 IP.
+	DryRun                bool                  `envconfig:"DRY_RUN" default:"false" reload:"startup"` // DryRun logs the changes to the system's network setup instead of making them, as does the --dry-run flag.
+	DebugConfig           DebugConfig           `envconfig:"DEBUG" reload:"startup"`
+	DHCPServerDisabled    bool                  `envconfig:"DHCP_SERVER_DISABLED" default:"false" reload:"startup"` // DHCPServerDisabled is a hack to indicate whether we attempt to start DHCP server functionality at all, aiming to help debugging which needs a stable eth0 IP.
+	DHCPConfig            DHCPConfig            `envconfig:"DHCP"`
+	IPv6Config            IPv6Config            `envconfig:"IPV6"`
 	FilterConfig          FilterConfig          `envconfig:"FILTER"`
 	WebConfig             WebConfig             `envconfig:"WEB"`
 	MonitorConfig         MonitorConfig         `envconfig:"MONITOR"`
 	TrackerConfig         models.TrackerConfig  `envconfig:"TRACKER"`
 	ActivityMonitorConfig ActivityMonitorConfig `envconfig:"ACTIVITY_MONITOR"`
+	DNSBlockConfig        DNSBlockConfig        `envconfig:"DNS_BLOCK"`
+	TelemetryConfig       TelemetryConfig       `envconfig:"TELEMETRY" reload:"startup"`
+	DiscoveryConfig       DiscoveryConfig       `envconfig:"DISCOVERY" reload:"startup"`
+	RouterConfig          RouterConfig          `envconfig:"ROUTER" reload:"startup"`
+	InventoryConfig       InventoryConfig       `envconfig:"INVENTORY" reload:"startup"`
+	ResolverConfig        ResolverConfig        `envconfig:"RESOLVER" reload:"startup"`
+	PiholeConfig          PiholeConfig          `envconfig:"PIHOLE"`
+	DNSForwarderConfig    DNSForwarderConfig    `envconfig:"DNS_FORWARDER" reload:"startup"`
+	KillSwitchConfig      KillSwitchConfig      `envconfig:"KILL_SWITCH"`
+	GuestConfig           GuestConfig           `envconfig:"GUEST"`
+	WatchdogConfig        WatchdogConfig        `envconfig:"WATCHDOG" reload:"startup"`
+	ReportConfig          ReportConfig          `envconfig:"REPORT" reload:"startup"`
+	StorageConfig         StorageConfig         `envconfig:"STORAGE" reload:"startup"`
+	SnapshotConfig        SnapshotConfig        `envconfig:"SNAPSHOT" reload:"startup"`
+}
+
+type LogConfig struct {
+	// Levels overrides LogLevel for the packages named, e.g. "nfq:debug,dhcp:warn", so that one can be debugged
+	// without logging every packet.
+	Levels map[string]string `envconfig:"LEVELS"`
+	// File is written as well as stderr if set, and rotated once it reaches FileMaxSize MB, keeping FileMaxBackups old
+	// files as File.1, File.2 and so on.
+	File           string `envconfig:"FILE" reload:"startup"`
+	FileMaxSize    int    `envconfig:"FILE_MAX_SIZE_MB" default:"10" reload:"startup"`
+	FileMaxBackups int    `envconfig:"FILE_MAX_BACKUPS" default:"3" reload:"startup"`
+	// Console writes to stderr, which the journal reads when run by systemd. Turn it off to keep only the file.
+	Console bool `envconfig:"CONSOLE" default:"true" reload:"startup"`
 }
 
 type DebugConfig struct {
@@ -47,7 +83,44 @@ type DebugConfig struct {
 	DebugTime time.Duration `envconfig:"TIME_SECONDS" default:"30s"`
 }
 
+type DHCPConfig struct {
+	// Backend selects the DHCP server: "dnsmasq" manages the dnsmasq service with systemctl and nmcli, while "native"
+	// serves DHCPv4 from this process for systems without dnsmasq or NetworkManager.
+	Backend string `envconfig:"BACKEND" default:"dnsmasq" reload:"startup"`
+	// LeaseDuration is the lease time handed out by the native backend.
+	LeaseDuration time.Duration `envconfig:"LEASE_DURATION" default:"12h"`
+	// ConflictMitigation makes the native backend try to win devices back while the router's DHCP server is still
+	// running: leases are cut to ConflictLeaseDuration so that devices come back sooner, and ConflictNAK can be set
+	// too. It's off by default.
+	ConflictMitigation    bool          `envconfig:"CONFLICT_MITIGATION" default:"false"`
+	ConflictLeaseDuration time.Duration `envconfig:"CONFLICT_LEASE_DURATION" default:"10m"`
+	// ConflictNAK sends a DHCPNAK to devices confirming a lease that this server didn't give, so that they start over
+	// and may take our offer instead. Servers are meant to stay silent about devices they don't know, so it needs
+	// ConflictMitigation too and each device is sent at most one NAK in ConflictNAKInterval.
+	ConflictNAK         bool          `envconfig:"CONFLICT_NAK" default:"false"`
+	ConflictNAKInterval time.Duration `envconfig:"CONFLICT_NAK_INTERVAL" default:"15m"`
+	// ConflictNAKMACs limits the NAKs to these devices, e.g. the ones you're tracking. Empty allows any device.
+	ConflictNAKMACs []string `envconfig:"CONFLICT_NAK_MACS"`
+	// TopologyCheckInterval is how often the network topology, e.g. double NAT, is checked again after the check at
+	// startup. Zero or less only checks it at startup.
+	TopologyCheckInterval time.Duration `envconfig:"TOPOLOGY_CHECK_INTERVAL" default:"10m" reload:"startup"`
+}
+
+type IPv6Config struct {
+	// Neighbours scans the IPv6 neighbour table along with the ARP table, so that the IPv6 addresses devices give
+	// themselves with SLAAC are tied to their MACs and put in their groups too. Link-local addresses are ignored.
+	Neighbours bool `envconfig:"NEIGHBOURS" default:"false"`
+	// RouterAdvertisements makes dnsmasq announce this gateway as the LAN's IPv6 router, with itself as the DNS server
+	// (RDNSS), so that devices resolve via it over IPv6 too. Devices pick their own addresses with SLAAC, unless
+	// Managed is set for them to ask DHCPv6 instead.
+	RouterAdvertisements bool `envconfig:"RA" default:"false"`
+	Managed              bool `envconfig:"RA_MANAGED" default:"false"`
+}
+
 type FilterConfig struct {
+	// Simulate decides each tracked packet's verdict as usual but accepts it without dropping or delaying it, counting
+	// what would have been done per group for /health, so that group and domain matching can be checked first.
+	Simulate bool `envconfig:"SIMULATE" default:"false"`
 	// PacketDropPercentage is the percentage of packets to drop.
 	PacketDropPercentage float32 `envconfig:"PACKET_DROP_PCT" default:"0.40"`
 	// PacketDelayPercentage is the percentage of packets to delay evaluated after dropping.
@@ -55,13 +128,221 @@ type FilterConfig struct {
 	PacketDelayMs         time.Duration `envconfig:"PACKET_DELAY_MS" default:"100ms"`
 	PacketJitterMs        time.Duration `envconfig:"PACKET_DELAY_JITTER_MS" default:"50ms"`
 	PacketDropUDP         bool          `envconfig:"PACKET_DROP_UDP" default:"true"`
-	OutboundQueueNumber   uint16        `envconfig:"OUTBOUND_QUEUE_NUMBER" default:"100"`
-	InboundQueueNumber    uint16        `envconfig:"INBOUND_QUEUE_NUMBER" default:"101"`
+	// WarnDelay is added to every packet of groups in their warning stage, i.e. past their tracker's warnAt or in its
+	// grace period, to slow them slightly as a hint. 0 only sends the warning.
+	WarnDelay time.Duration `envconfig:"WARN_DELAY" default:"20ms"`
+	// UDPRateLimitKbps shapes UDP, e.g. QUIC, to this rate per direction for groups over their threshold, instead of
+	// dropping it when PacketDropUDP is set. 0 drops it.
+	UDPRateLimitKbps int `envconfig:"UDP_RATE_LIMIT_KBPS" default:"0"`
+	// UDPPorts are the destination ports of UDP from the tracked devices that UDPMode applies to, whatever the remote
+	// IP, so that QUIC to IPs that haven't resolved yet is caught too.
+	UDPPorts []uint16 `envconfig:"UDP_PORTS" default:"443,500,4500" reload:"startup"`
+	// UDPMode is what's done with UDP to UDPPorts: queue to filter it like other packets, drop so that QUIC falls back
+	// to TCP, or accept to leave it alone.
+	UDPMode             string `envconfig:"UDP_MODE" default:"queue" reload:"startup"`
+	OutboundQueueNumber uint16 `envconfig:"OUTBOUND_QUEUE_NUMBER" default:"100" reload:"startup"`
+	InboundQueueNumber  uint16 `envconfig:"INBOUND_QUEUE_NUMBER" default:"101" reload:"startup"`
+	// QueueAutoSelect picks alternate queue numbers at startup if the configured ones are bound by another process.
+	QueueAutoSelect bool `envconfig:"QUEUE_AUTO_SELECT" default:"true" reload:"startup"`
+	// RateLimitKbps shapes traffic for groups over their threshold to this rate per direction, instead of dropping and
+	// delaying packets at random. 0 disables rate limiting.
+	RateLimitKbps int `envconfig:"RATE_LIMIT_KBPS" default:"0"`
+	// RateLimitMaxDelay is the longest a packet is held to keep to the rate before it is dropped instead.
+	RateLimitMaxDelay time.Duration `envconfig:"RATE_LIMIT_MAX_DELAY" default:"200ms"`
+	// SampleRate is N where 1 in N packets are queued for groups with packet sampling enabled. Traffic counts are
+	// scaled up by N to make up for the packets that aren't seen. 1 or less disables sampling.
+	SampleRate uint32 `envconfig:"SAMPLE_RATE" default:"10" reload:"startup"`
+	// Workers is the number of goroutines handling packets for each queue. Packets are spread across them by IP pair
+	// so that each flow's packets stay in order. 1 or less handles packets in the queue's netlink callback.
+	Workers int `envconfig:"WORKERS" default:"4" reload:"startup"`
+	// WorkerQueueLen is the number of packets buffered for each worker before the queue stops being read.
+	WorkerQueueLen int `envconfig:"WORKER_QUEUE_LEN" default:"256" reload:"startup"`
+	// LocalDevice adds rules for traffic to and from the gateway's own apps, which aren't forwarded, and lists the
+	// gateway as a device that can be added to groups.
+	LocalDevice bool `envconfig:"LOCAL_DEVICE" default:"false" reload:"startup"`
+	// WriteTimeout is how long sending a verdict to the kernel may take. A warning is logged if the 99th percentile
+	// time from receiving packets to deciding their verdicts is longer.
+	WriteTimeout time.Duration `envconfig:"WRITE_TIMEOUT" default:"15ms" reload:"startup"`
+	// Backpressure is what's done when the packet handler can't keep up, going by the workers' backlog and how many
+	// verdicts take longer than BackpressureLatency: off; reduce to skip the work that isn't needed to enforce the
+	// thresholds, e.g. captures and bypass detection; or failopen to then also let the tracked devices' traffic
+	// through without queuing it for BackpressureFailOpenDuration, so that a slow handler doesn't stall the network.
+	Backpressure                 string        `envconfig:"BACKPRESSURE" default:"failopen" reload:"startup"`
+	BackpressureLatency          time.Duration `envconfig:"BACKPRESSURE_LATENCY" default:"100ms" reload:"startup"`
+	BackpressureWindow           time.Duration `envconfig:"BACKPRESSURE_WINDOW" default:"5s" reload:"startup"` // BackpressureWindow is how long the handler must be behind, or keeping up, before the level changes.
+	BackpressureFailOpenDuration time.Duration `envconfig:"BACKPRESSURE_FAIL_OPEN_DURATION" default:"1m" reload:"startup"`
+	// CaptureMaxPackets is the number of the most recent packets kept for each group being captured.
+	CaptureMaxPackets int `envconfig:"CAPTURE_MAX_PACKETS" default:"10000" reload:"startup"`
+	// TraceMaxEntries is the number of decisions kept for a device being traced, after which the rest are skipped.
+	TraceMaxEntries int `envconfig:"TRACE_MAX_ENTRIES" default:"20000" reload:"startup"`
+	// TraceMaxDuration is the longest that a device can be traced for.
+	TraceMaxDuration time.Duration `envconfig:"TRACE_MAX_DURATION" default:"10m" reload:"startup"`
+	// NFTReconcileInterval is how often the NFT table is checked and repaired if its rules have been removed, e.g. by
+	// another firewall tool. 0 disables the check.
+	NFTReconcileInterval time.Duration `envconfig:"NFT_RECONCILE_INTERVAL" default:"1m" reload:"startup"`
+	// BlockPagePort is the port of the "time's up" page that plain HTTP from devices whose groups are all over their
+	// thresholds is redirected to, instead of being dropped. It must differ from WEB_PORT. 0 disables the page.
+	BlockPagePort int `envconfig:"BLOCK_PAGE_PORT" default:"0" reload:"startup"`
+	// TableFamily is the family of the NFT table: inet, ip, or auto to use inet unless the kernel is too old for it.
+	TableFamily string `envconfig:"TABLE_FAMILY" default:"auto" reload:"startup"`
+	// FirewallBackend is the firewall that queues packets to the filter: nftables, iptables, or auto to use nftables
+	// unless the kernel doesn't support it, e.g. on older boxes that only have legacy iptables.
+	FirewallBackend string `envconfig:"FIREWALL_BACKEND" default:"auto" reload:"startup"`
+	// BypassDetection queues the traffic from the tracked devices to BypassPorts, BypassResolverIPs and BypassVPNIPs so
+	// that attempts to get around the filter, e.g. with a VPN or another DNS resolver, are logged and notified.
+	BypassDetection bool `envconfig:"BYPASS_DETECTION" default:"false" reload:"startup"`
+	// BypassPorts are the TCP and UDP ports of DNS, DNS over TLS and VPNs, which the tracked devices shouldn't use to
+	// reach the internet since the gateway answers their DNS.
+	BypassPorts []uint16 `envconfig:"BYPASS_PORTS" default:"53,853,1194,1723,51820" reload:"startup"`
+	// BypassResolverIPs are the IPs of public DNS over HTTPS resolvers.
+	BypassResolverIPs []string `envconfig:"BYPASS_RESOLVER_IPS" default:"1.1.1.1,1.0.0.1,8.8.8.8,8.8.4.4,9.9.9.9,149.112.112.112,208.67.222.222,208.67.220.220,94.140.14.14,94.140.15.15" reload:"startup"`
+	// BypassVPNIPs are the IPs of VPN endpoints, e.g. a VPN provider's servers.
+	BypassVPNIPs []string `envconfig:"BYPASS_VPN_IPS" default:"" reload:"startup"`
+	// BypassBlock drops the traffic of a device to the bypass ports and IPs once it's caught, for BypassBlockDuration.
+	BypassBlock         bool          `envconfig:"BYPASS_BLOCK" default:"false" reload:"startup"`
+	BypassBlockDuration time.Duration `envconfig:"BYPASS_BLOCK_DURATION" default:"1h" reload:"startup"`
+	// BypassAlertInterval is how long before a device caught again in the same way is notified again.
+	BypassAlertInterval time.Duration `envconfig:"BYPASS_ALERT_INTERVAL" default:"1h" reload:"startup"`
+	// DNSInspection queues the DNS answers to the tracked devices so that the IPs they're given for the tracked
+	// domains, and their subdomains, are filtered straight away instead of after the next time the domains resolve.
+	DNSInspection bool `envconfig:"DNS_INSPECTION" default:"false" reload:"startup"`
+	// LANCIDRs are the IPv4 networks of the LAN. Traffic between two of their addresses is never queued or throttled,
+	// e.g. casting to a TV or copying to a NAS, even if an address is also one a tracked domain resolved to. DNS
+	// answers between them are still inspected. Empty leaves LAN traffic to the other rules.
+	LANCIDRs []string `envconfig:"LAN_CIDRS" default:"10.0.0.0/8,172.16.0.0/12,192.168.0.0/16,169.254.0.0/16" reload:"startup"`
+}
+
+// LANNets returns the LANCIDRs sorted by address, leaving out the networks inside others so that none overlap.
+func (c *FilterConfig) LANNets() ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range c.LANCIDRs {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(s))
+		if err != nil || n.IP.To4() == nil {
+			return nil, fmt.Errorf("LAN CIDR %q isn't an IPv4 network", s)
+		}
+		nets = append(nets, n)
+	}
+	slices.SortFunc(nets, func(a, b *net.IPNet) int { // sort the larger networks first where they start at the same IP.
+		if c := bytes.Compare(a.IP.To4(), b.IP.To4()); c != 0 {
+			return c
+		}
+		return bytes.Compare(a.Mask, b.Mask)
+	})
+	var merged []*net.IPNet
+	for _, n := range nets {
+		if len(merged) > 0 && merged[len(merged)-1].Contains(n.IP) { // if the network is inside the last one...
+			continue
+		}
+		merged = append(merged, n)
+	}
+	return merged, nil
+}
+
+// GroupPacketPolicy returns the group's policy for packets over its threshold, or the policy made from the filter
+// config if the group doesn't have its own.
+func (c *FilterConfig) GroupPacketPolicy(p *models.PacketPolicy) models.PacketPolicy {
+	if p != nil {
+		return *p
+	}
+	return models.PacketPolicy{
+		DropPercentage:   c.PacketDropPercentage,
+		DelayPercentage:  c.PacketDelayPercentage,
+		Delay:            c.PacketDelayMs,
+		Jitter:           c.PacketJitterMs,
+		DropUDP:          c.PacketDropUDP,
+		RateLimitKbps:    c.RateLimitKbps,
+		UDPRateLimitKbps: c.UDPRateLimitKbps,
+	}
+}
+
+type KillSwitchConfig struct {
+	// ButtonPin is the sysfs GPIO number of a button that toggles the kill switch. -1 disables the button.
+	ButtonPin int `envconfig:"BUTTON_PIN" default:"-1" reload:"startup"`
+	// ButtonActiveLow is true if the pin reads 0 while the button is pressed, e.g. a button to ground with a pull-up.
+	ButtonActiveLow bool `envconfig:"BUTTON_ACTIVE_LOW" default:"true" reload:"startup"`
+	// BlockDuration is how long the groups are put in Block mode by the kill switch. The mode is saved, so it ends by
+	// itself if the switch isn't turned off first, e.g. after a restart.
+	BlockDuration time.Duration `envconfig:"BLOCK_DURATION" default:"12h"`
+}
+
+type GuestConfig struct {
+	// Enabled puts the devices seen on the network that aren't in a group, or kept out of one, in the Group of
+	// guests, whose time is tracked with the tracker defaults until the group is given config of its own.
+	Enabled bool   `envconfig:"ENABLED" default:"false"`
+	Group   string `envconfig:"GROUP" default:"guests"`
+	// Expiry is how long a guest can go unseen before it's removed from the group-macs config.
+	Expiry time.Duration `envconfig:"EXPIRY" default:"168h"`
+}
+
+type WatchdogConfig struct {
+	// Enabled removes the firewall rules so that traffic fails open if the packet handler stops responding, since
+	// the tracked devices' traffic is dropped while it's queued without a verdict.
+	Enabled bool `envconfig:"ENABLED" default:"true"`
+	// Interval is how often the packet handler is checked.
+	Interval time.Duration `envconfig:"INTERVAL" default:"5s"`
+	// StallDuration is how long the handler can have packets waiting without finishing any before a check counts
+	// as a stall, and Stalls is the number of checks in a row that have to find it stalled for the rules to be removed.
+	StallDuration time.Duration `envconfig:"STALL_DURATION" default:"10s"`
+	Stalls        int           `envconfig:"STALLS" default:"3"`
+	// Panics is the number of panics recovered in the packet handler within PanicWindow for the rules to be removed.
+	Panics      int           `envconfig:"PANICS" default:"5"`
+	PanicWindow time.Duration `envconfig:"PANIC_WINDOW" default:"1m"`
+}
+
+type ReportConfig struct {
+	// ReportEnabled records the minutes and domains each group uses so that weekly reports can be made.
+	ReportEnabled bool `envconfig:"ENABLED" default:"true"`
+	// Day is the day of the week the reports are sent on, 0 for Sunday.
+	Day int `envconfig:"DAY" default:"0"`
+	// Hour is the hour of the day, in local time, that the reports are sent at.
+	Hour int `envconfig:"HOUR" default:"18"`
+}
+
+type StorageConfig struct {
+	// Backend is where the tracker's samples and the mode history are kept: "file" for files in the app's home
+	// directory, or "sqlite" for a database that batches the writes to spare SD cards.
+	Backend string `envconfig:"BACKEND" default:"file"`
+	// Path is the database file used by the sqlite backend, relative to the app's home directory.
+	Path string `envconfig:"PATH" default:"tubetimeout.db"`
+	// FlushInterval is how often the sqlite backend writes the changes held in memory to the database.
+	FlushInterval time.Duration `envconfig:"FLUSH_INTERVAL" default:"30s"`
+}
+
+type SnapshotConfig struct {
+	// Time is the local time of day, as HH:MM, that a snapshot of every file included in backups is taken. Empty
+	// disables the snapshots.
+	Time string `envconfig:"TIME" default:"03:00"`
+	// Dir is where the snapshots are kept, relative to the app's home directory unless it's absolute.
+	Dir string `envconfig:"DIR" default:"snapshots"`
+	// Keep is the number of snapshots kept in Dir and a directory Target, after which the oldest are deleted.
+	Keep int `envconfig:"KEEP" default:"7"`
+	// Target is an optional copy of each snapshot off the SD card: the directory of a mounted SMB or NFS share, or
+	// s3://bucket/prefix to upload them to S3. Old snapshots in S3 are left to the bucket's lifecycle rules.
+	Target string `envconfig:"TARGET" default:""`
+	// S3Endpoint is the host, or URL, of an S3-compatible service such as MinIO. Empty uses AWS in S3Region.
+	S3Endpoint  string `envconfig:"S3_ENDPOINT" default:""`
+	S3Region    string `envconfig:"S3_REGION" default:"us-east-1"`
+	S3AccessKey string `envconfig:"S3_ACCESS_KEY" default:""`
+	S3SecretKey string `envconfig:"S3_SECRET_KEY" default:""`
 }
 
 type WebConfig struct {
-	WebEnabled bool `envconfig:"ENABLED" default:"true"`
-	WebPort    int  `envconfig:"PORT" default:"80"`
+	WebEnabled bool `envconfig:"ENABLED" default:"true" reload:"startup"`
+	WebPort    int  `envconfig:"PORT" default:"80" reload:"startup"`
+	// TLSEnabled serves the dashboard and API over HTTPS on TLSPort, and redirects plain HTTP on WebPort to it.
+	TLSEnabled bool `envconfig:"TLS_ENABLED" default:"false" reload:"startup"`
+	TLSPort    int  `envconfig:"TLS_PORT" default:"443" reload:"startup"`
+	// TLSCertFile and TLSKeyFile are the PEM files of a certificate to serve. If they're empty, a self-signed
+	// certificate for the gateway's hostname is made on the first run and kept in the app's home directory.
+	TLSCertFile string `envconfig:"TLS_CERT_FILE" default:"" reload:"startup"`
+	TLSKeyFile  string `envconfig:"TLS_KEY_FILE" default:"" reload:"startup"`
+	// AuthRequired rejects requests without an API key once an admin key exists, other than the pages devices use
+	// such as /my-time. Browsers sign in at /login with a key.
+	AuthRequired bool `envconfig:"AUTH_REQUIRED" default:"false"`
+	// GRPCPort serves the gRPC API on this port, with the same API keys, and over TLS if TLSEnabled. Zero disables it.
+	GRPCPort int `envconfig:"GRPC_PORT" default:"0" reload:"startup"`
+	// Language is the locale the pages are shown in when the browser doesn't ask for one that's available.
+	Language string `envconfig:"LANGUAGE" default:"en"`
 }
 
 type MonitorConfig struct {
@@ -74,3 +355,124 @@ type ActivityMonitorConfig struct {
 	// EnableThresholdLogic true causes monitor.isActive() to require ingress to be higher than egress to consider traffic as active.
 	EnableThresholdLogic bool `envconfig:"ENABLE_THRESHOLD_LOGIC" default:"false"`
 }
+
+type DNSBlockConfig struct {
+	// DNSBlockEnabled when set true causes dnsmasq to answer lookups for tracked domains with BlockAddress while any group is over its threshold.
+	DNSBlockEnabled bool `envconfig:"ENABLED" default:"false" reload:"startup"`
+	// BlockAddress is the address returned by dnsmasq for blocked domains.
+	BlockAddress string `envconfig:"ADDRESS" default:"0.0.0.0"`
+	// Groups optionally limits DNS blocking to the given tracker groups; empty means all groups.
+	Groups []string `envconfig:"GROUPS" reload:"startup"`
+}
+
+type RouterConfig struct {
+	// Backend names the router API used to mirror group block state to the router's own client blocking, as a second
+	// line of defense when this device is bypassed or offline: "unifi", "openwrt" or "fritzbox". Empty disables it.
+	Backend string `envconfig:"BACKEND" default:""`
+	// URL is the base URL of the router API, e.g. https://192.168.1.1 for UniFi or OpenWrt and http://fritz.box:49000 for Fritz!Box.
+	URL      string `envconfig:"URL"`
+	Username string `envconfig:"USERNAME"`
+	Password string `envconfig:"PASSWORD"`
+	// Site is the UniFi site that clients belong to.
+	Site string `envconfig:"SITE" default:"default"`
+	// InsecureSkipVerify accepts the self-signed certificates that routers usually serve.
+	InsecureSkipVerify bool `envconfig:"INSECURE_SKIP_VERIFY" default:"true"`
+	// Interval is how often changes that failed to apply are retried.
+	Interval time.Duration `envconfig:"INTERVAL" default:"1m"`
+}
+
+type InventoryConfig struct {
+	// Backend names how the router's client table is read, so that devices which haven't talked to this device
+	// recently, and aren't in its ARP table, can still be added to groups: "upnp" for the TR-064 Hosts service, e.g.
+	// on Fritz!Box routers, or "snmp" for the router's ARP table over SNMPv2c. Empty disables it.
+	Backend string `envconfig:"BACKEND" default:""`
+	// Address is the router's TR-064 base URL for upnp, e.g. http://fritz.box:49000, or its SNMP agent for snmp,
+	// e.g. 192.168.1.1 or 192.168.1.1:161.
+	Address  string `envconfig:"ADDRESS"`
+	Username string `envconfig:"USERNAME"`
+	Password string `envconfig:"PASSWORD"`
+	// Community is the SNMP community with read access to the router's ARP table.
+	Community string `envconfig:"COMMUNITY" default:"public"`
+	// Interval is how often the client table is read.
+	Interval time.Duration `envconfig:"INTERVAL" default:"5m"`
+}
+
+type ResolverConfig struct {
+	// Servers are the upstream DNS resolvers used to find the IPs of tracked domains, tried in order.
+	// Plain addresses like 8.8.8.8 use UDP, tls://1.1.1.1 or tls://dns.quad9.net use DNS over TLS and
+	// https://dns.google/dns-query uses DNS over HTTPS. A port may be given for UDP and TLS.
+	Servers []string `envconfig:"SERVERS" default:"8.8.8.8,1.1.1.1,9.9.9.9"`
+	// Parallel queries all healthy servers at once and uses the first answer, instead of failing over in order.
+	Parallel bool `envconfig:"PARALLEL" default:"false"`
+	// Timeout is how long to wait for each server to answer.
+	Timeout time.Duration `envconfig:"TIMEOUT" default:"5s"`
+	// IPRetention is how long the IPs of tracked domains are kept after they last resolved. CDNs like YouTube's rotate
+	// the IPs they hand out faster than domains are refreshed, while clients keep using the IPs they were given.
+	IPRetention time.Duration `envconfig:"IP_RETENTION" default:"24h"`
+	// PauseDuration is how long resolving a domain group stays paused, when paused without a duration, before it
+	// resumes automatically.
+	PauseDuration time.Duration `envconfig:"PAUSE_DURATION" default:"1h"`
+	// Workers is the most domains looked up at once.
+	Workers int `envconfig:"WORKERS" default:"8"`
+	// CacheMinTTL and CacheMaxTTL bound how long a domain's answer is used before it's looked up again, going by the
+	// TTL of its records. A CacheMaxTTL of 0 disables the cache.
+	CacheMinTTL time.Duration `envconfig:"CACHE_MIN_TTL" default:"30s"`
+	CacheMaxTTL time.Duration `envconfig:"CACHE_MAX_TTL" default:"1h"`
+	// FailureBackoff is how long a domain that failed to resolve twice in a row is left before it's tried again,
+	// doubling with each further failure up to MaxFailureBackoff.
+	FailureBackoff    time.Duration `envconfig:"FAILURE_BACKOFF" default:"5m"`
+	MaxFailureBackoff time.Duration `envconfig:"MAX_FAILURE_BACKOFF" default:"1h"`
+}
+
+type PiholeConfig struct {
+	// URL is the base URL of a Pi-hole v6 web server, e.g. http://pi.hole, whose query log is read to find the hosts
+	// of tracked domains that devices look up. Empty disables the integration.
+	URL      string `envconfig:"URL" reload:"startup"`
+	Password string `envconfig:"PASSWORD" reload:"startup"`
+	// InsecureSkipVerify accepts the self-signed certificate that Pi-hole serves over HTTPS.
+	InsecureSkipVerify bool `envconfig:"INSECURE_SKIP_VERIFY" default:"true" reload:"startup"`
+	// Interval is how often the query log is read.
+	Interval time.Duration `envconfig:"INTERVAL" default:"1m" reload:"startup"`
+	// DomainRetention is how long a host found in the query log is tracked after it was last looked up.
+	DomainRetention time.Duration `envconfig:"DOMAIN_RETENTION" default:"24h" reload:"startup"`
+	// DNSServer is the address of the Pi-hole DNS server handed out to DHCP clients instead of the DHCP settings'
+	// DNS IPs. dnsmasq stops serving DNS so it doesn't conflict with Pi-hole, which means DNS blocking is unavailable.
+	// Empty keeps serving DNS as configured in the DHCP settings.
+	DNSServer string `envconfig:"DNS_SERVER"`
+}
+
+type DNSForwarderConfig struct {
+	// Enabled runs a caching DNS forwarder on this gateway, which DHCP clients are given as their DNS server in place
+	// of dnsmasq. It logs each device's lookups, applies the per-group DNS policy and sends the answers for tracked
+	// domains to the filter straight away. Pi-hole's DNS server takes precedence if it's set.
+	Enabled bool `envconfig:"ENABLED" default:"false"`
+	// ListenAddress is the UDP and TCP address to serve DNS on. Set it to this gateway's IP if another resolver, such
+	// as systemd-resolved, holds port 53 on the loopback address.
+	ListenAddress string `envconfig:"LISTEN_ADDRESS" default:":53"`
+	// Upstreams are the DNS servers that lookups are forwarded to, tried in order. A port may be given, e.g. 9.9.9.9:53.
+	Upstreams []string `envconfig:"UPSTREAMS" default:"8.8.8.8,1.1.1.1"`
+	// Timeout is how long to wait for each upstream to answer.
+	Timeout time.Duration `envconfig:"TIMEOUT" default:"2s"`
+	// CacheSize is the most answers kept. 0 disables the cache.
+	CacheSize int `envconfig:"CACHE_SIZE" default:"2000"`
+	// CacheMaxTTL caps how long an answer is kept, whatever the TTL of its records.
+	CacheMaxTTL time.Duration `envconfig:"CACHE_MAX_TTL" default:"1h"`
+	// QueryLogSize is the number of recent lookups kept for each device.
+	QueryLogSize int `envconfig:"QUERY_LOG_SIZE" default:"200"`
+}
+
+type TelemetryConfig struct {
+	// Endpoint is the URL to which anonymized stats are posted once the user opts in via the web UI.
+	// Nothing is sent while this is empty.
+	Endpoint string `envconfig:"ENDPOINT" default:""`
+	// Interval is the time between stats submissions.
+	Interval time.Duration `envconfig:"INTERVAL" default:"24h"`
+}
+
+type DiscoveryConfig struct {
+	// DiscoveryEnabled when set true listens for mDNS/SSDP announcements to suggest names for unnamed devices.
+	DiscoveryEnabled bool `envconfig:"ENABLED" default:"true"`
+	// ProbeInterval is how often every address in the DHCP range is probed so that idle devices stay in the
+	// ARP table and are still tracked when they start streaming again. Zero disables probing.
+	ProbeInterval time.Duration `envconfig:"PROBE_INTERVAL" default:"1m"`
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"relloyd/tubetimeout/models"
+)
+
+func TestFilterConfig_GroupPacketPolicy(t *testing.T) {
+	cfg := &FilterConfig{PacketDropPercentage: 0.4, PacketDelayPercentage: 0.9, PacketDelayMs: 100 * time.Millisecond, PacketJitterMs: 50 * time.Millisecond, PacketDropUDP: true, RateLimitKbps: 200, UDPRateLimitKbps: 500}
+	assert.Equal(t, models.PacketPolicy{DropPercentage: 0.4, DelayPercentage: 0.9, Delay: 100 * time.Millisecond, Jitter: 50 * time.Millisecond, DropUDP: true, RateLimitKbps: 200, UDPRateLimitKbps: 500},
+		cfg.GroupPacketPolicy(nil), "expected the filter config to be used for groups without a policy")
+
+	gentle := &models.PacketPolicy{DelayPercentage: 1, Delay: 200 * time.Millisecond}
+	assert.Equal(t, *gentle, cfg.GroupPacketPolicy(gentle), "expected the group's policy to replace the filter config")
+}
+
+func TestFilterConfig_LANNets(t *testing.T) {
+	cfg := &FilterConfig{LANCIDRs: []string{"192.168.1.0/24", "10.0.0.0/8", " 192.168.0.0/16", "10.1.2.0/24"}}
+	nets, err := cfg.LANNets()
+	require.NoError(t, err)
+	var got []string
+	for _, n := range nets {
+		got = append(got, n.String())
+	}
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.0.0/16"}, got, "expected the networks inside others to be left out")
+
+	for _, bad := range []string{"192.168.1.1", "fd00::/8", "nonsense"} {
+		_, err = (&FilterConfig{LANCIDRs: []string{bad}}).LANNets()
+		assert.Error(t, err, bad)
+	}
+	nets, err = (&FilterConfig{}).LANNets()
+	assert.NoError(t, err)
+	assert.Empty(t, nets)
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Commands runs the external commands, e.g. nmcli and systemctl, and writes the system files, e.g. /etc/dnsmasq.conf.
+// Replace it with a FakeExec in tests, or a dry run Exec to log the changes instead of making them.
+var Commands Exec = osExec{}
+
+// Exec runs external commands and writes files outside the app home directory. Commands that only read the
+// system's state are run by Query so that they still run in a dry run.
+type Exec interface {
+	// Query runs a command that doesn't change the system, e.g. "arp -n -a", and returns its standard output.
+	Query(name string, args ...string) ([]byte, error)
+	// Change runs a command that changes the system, e.g. "nmcli dev mod", and returns its combined output.
+	Change(name string, args ...string) ([]byte, error)
+	// WriteFile writes a system file, e.g. /etc/dnsmasq.conf.
+	WriteFile(path string, data []byte, perm os.FileMode) error
+	// RemoveFile removes a system file, e.g. the systemd unit. It isn't an error if the file doesn't exist.
+	RemoveFile(path string) error
+}
+
+// CommandLine joins the command and its arguments as they'd be typed in a shell, quoting empty arguments.
+func CommandLine(name string, args ...string) string {
+	words := []string{name}
+	for _, a := range args {
+		if a == "" || strings.ContainsAny(a, " \t\"") {
+			a = fmt.Sprintf("%q", a)
+		}
+		words = append(words, a)
+	}
+	return strings.Join(words, " ")
+}
+
+// osExec runs the commands on this device.
+type osExec struct{}
+
+func (osExec) Query(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).Output()
+}
+
+func (osExec) Change(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+func (osExec) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return os.WriteFile(path, data, perm)
+}
+
+func (osExec) RemoveFile(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// dryRunExec runs the queries but only logs the changes, as if they'd succeeded with no output.
+type dryRunExec struct {
+	logger *zap.SugaredLogger
+	osExec
+}
+
+// NewDryRunExec returns an Exec that logs the commands that would change the system, and the files that would be
+// written, instead of running or writing them.
+func NewDryRunExec(logger *zap.SugaredLogger) Exec {
+	return &dryRunExec{logger: logger}
+}
+
+func (d *dryRunExec) Change(name string, args ...string) ([]byte, error) {
+	d.logger.Infof("Dry run: would run: %v", CommandLine(name, args...))
+	return nil, nil
+}
+
+func (d *dryRunExec) WriteFile(path string, data []byte, _ os.FileMode) error {
+	d.logger.Infof("Dry run: would write %v:\n%s", path, data)
+	return nil
+}
+
+func (d *dryRunExec) RemoveFile(path string) error {
+	d.logger.Infof("Dry run: would remove %v", path)
+	return nil
+}
+
+// FakeResult is the output and error returned by a FakeExec for a command line.
+type FakeResult struct {
+	Output string
+	Err    error
+}
+
+// FakeExec is an Exec for tests. It records the command lines run, as returned by CommandLine, and the files written
+// and removed, and returns the result set in Results for each command line, or no output if there isn't one.
+type FakeExec struct {
+	Results map[string]FakeResult
+	mu      sync.Mutex
+	calls   []string
+	files   map[string]string
+	removed []string
+}
+
+func (f *FakeExec) Query(name string, args ...string) ([]byte, error) {
+	return f.run(name, args...)
+}
+
+func (f *FakeExec) Change(name string, args ...string) ([]byte, error) {
+	return f.run(name, args...)
+}
+
+func (f *FakeExec) run(name string, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	line := CommandLine(name, args...)
+	f.calls = append(f.calls, line)
+	r := f.Results[line]
+	return []byte(r.Output), r.Err
+}
+
+func (f *FakeExec) WriteFile(path string, data []byte, _ os.FileMode) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.files == nil {
+		f.files = make(map[string]string)
+	}
+	f.files[path] = string(data)
+	return nil
+}
+
+func (f *FakeExec) RemoveFile(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.files, path)
+	f.removed = append(f.removed, path)
+	return nil
+}
+
+// Removed returns the paths of the files removed so far.
+func (f *FakeExec) Removed() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.removed)
+}
+
+// Calls returns the command lines run so far.
+func (f *FakeExec) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.calls)
+}
+
+// File returns the contents of the file written at path and true, or false if it hasn't been written.
+func (f *FakeExec) File(path string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.files[path]
+	return data, ok
+}
+
+// UseFakeExec replaces Commands with a new FakeExec until the test ends.
+func UseFakeExec(t interface{ Cleanup(func()) }) *FakeExec {
+	orig := Commands
+	f := &FakeExec{}
+	Commands = f
+	t.Cleanup(func() { Commands = orig })
+	return f
+}
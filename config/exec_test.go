@@ -0,0 +1,54 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestCommandLine(t *testing.T) {
+	assert.Equal(t, "arp -n -a", CommandLine("arp", "-n", "-a"))
+	assert.Equal(t, `nmcli dev mod eth0 ipv4.dns "1.1.1.1 8.8.8.8" ipv4.gateway ""`, CommandLine("nmcli", "dev", "mod", "eth0", "ipv4.dns", "1.1.1.1 8.8.8.8", "ipv4.gateway", ""))
+}
+
+func TestDryRunExec(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	d := NewDryRunExec(zap.New(core).Sugar())
+
+	output, err := d.Change("sudo", "systemctl", "restart", "dnsmasq")
+	assert.NoError(t, err)
+	assert.Empty(t, output)
+	path := filepath.Join(t.TempDir(), "dnsmasq.conf")
+	assert.NoError(t, d.WriteFile(path, []byte("interface=eth0"), 0644))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err), "expected the file not to be written")
+	kept := filepath.Join(t.TempDir(), "kept.conf")
+	assert.NoError(t, os.WriteFile(kept, nil, 0644))
+	assert.NoError(t, d.RemoveFile(kept))
+	assert.FileExists(t, kept, "expected the file not to be removed")
+
+	output, err = d.Query("echo", "hello")
+	assert.NoError(t, err)
+	assert.Equal(t, "hello\n", string(output), "expected queries to still run")
+
+	if assert.Equal(t, 3, logs.Len()) {
+		assert.Equal(t, "Dry run: would run: sudo systemctl restart dnsmasq", logs.All()[0].Message)
+		assert.Equal(t, "Dry run: would write "+path+":\ninterface=eth0", logs.All()[1].Message)
+		assert.Equal(t, "Dry run: would remove "+kept, logs.All()[2].Message)
+	}
+}
+
+func TestFakeExec(t *testing.T) {
+	f := UseFakeExec(t)
+	assert.Same(t, f, Commands)
+	f.Results = map[string]FakeResult{"arp -n -a": {Output: "? (192.168.1.10) at aa:bb:cc:dd:ee:ff [ether] on eth0"}}
+
+	output, err := ARPCmd()
+	assert.NoError(t, err)
+	assert.Contains(t, output, "192.168.1.10")
+	assert.Equal(t, []string{"arp -n -a"}, f.Calls())
+}
@@ -4,12 +4,15 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
-// TestCreateAppHomeDirForConfigFile tests the createAppHomeDirAndGetConfigFile function.
+// TestCreateAppHomeDirForConfigFile tests that the default store keeps files in the app home dir.
 func TestCreateAppHomeDirForConfigFile(t *testing.T) {
 	// Setup
 	AppHomeDir = ".myapp"
@@ -31,7 +34,7 @@ func TestCreateAppHomeDirForConfigFile(t *testing.T) {
 	}()
 
 	// Call the function under test
-	result, err := createAppHomeDirAndGetConfigFile(fileName)
+	result, err := NewStore("").Path(fileName)
 
 	assert.NoError(t, err, "Unexpected error")
 	assert.Equal(t, expectedPath, result, "Unexpected path")
@@ -55,7 +58,7 @@ func TestSafeWriteViaTemp(t *testing.T) {
 	}()
 
 	// Run the function
-	FnDefaultSafeWriteViaTemp(testFilePath, testData)
+	_ = SafeWriteViaTemp(testFilePath, testData)
 
 	// Verify the original file exists
 	if _, err := os.Stat(testFilePath); os.IsNotExist(err) {
@@ -77,3 +80,53 @@ func TestSafeWriteViaTemp(t *testing.T) {
 		t.Fatalf("Expected file contents '%s', got '%s'", testData, string(content))
 	}
 }
+
+func TestStore_Path(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "store")
+	s := NewStore(dir)
+
+	p, err := s.Path("config.yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "config.yaml"), p)
+	assert.DirExists(t, dir, "expected the store's directory to be created")
+
+	p, err = s.Path("/var/lib/tubetimeout/samples.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/lib/tubetimeout/samples.json", p, "expected absolute paths to be kept")
+
+	other := NewStore(t.TempDir())
+	o, err := other.Path("config.yaml")
+	assert.NoError(t, err)
+	assert.NotEqual(t, o, filepath.Join(dir, "config.yaml"), "expected stores not to share their directory")
+}
+
+func TestStore_WriteFile(t *testing.T) {
+	s := NewStore(t.TempDir())
+	p, err := s.Path("config.yaml")
+	assert.NoError(t, err)
+	assert.NoError(t, s.WriteFile(p, "a: 1\n"))
+	data, err := os.ReadFile(p)
+	assert.NoError(t, err)
+	assert.Equal(t, "a: 1\n", string(data))
+
+	// Expect writes of the same file to wait for each other.
+	var writing, overlaps atomic.Int32
+	s.SetWriter(func(path, data string) error {
+		if writing.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		time.Sleep(time.Millisecond)
+		writing.Add(-1)
+		return nil
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = s.WriteFile(p, "")
+		}()
+	}
+	wg.Wait()
+	assert.Zero(t, overlaps.Load())
+}
@@ -0,0 +1,302 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"relloyd/tubetimeout/models"
+)
+
+const (
+	fsckBackupSuffix     = ".fsck-"   // fsckBackupSuffix plus a timestamp names the copy of a file taken before it's repaired.
+	fsckQuarantineSuffix = ".broken-" // fsckQuarantineSuffix plus a timestamp names a file moved aside because it can't be used.
+	fsckTimeFormat       = "20060102-150405"
+)
+
+// Fsck checks every file registered with Backups. Packages that own config files register checks for them in
+// init(); files without a check are only checked to parse.
+var Fsck = &fsck{backups: Backups, checks: make(map[string]FileCheck)}
+
+func init() {
+	Fsck.Register(groupMACsFileName, checkGroupMACsFile)
+	Fsck.Register(groupDomainsFileName, checkGroupDomainsFile)
+}
+
+// FileCheck checks the contents of a registered file. files holds the contents of the other registered files that
+// exist, by name, so that references between them can be checked. It returns the data with any problems fixed, or
+// nil if there is nothing to fix, and a description of each problem found. An error means the file can't be used.
+type FileCheck func(data []byte, files map[string][]byte) (fixed []byte, problems []string, err error)
+
+// FsckStatus is the outcome of checking a single file.
+type FsckStatus string
+
+const (
+	FsckOK          = FsckStatus("ok")
+	FsckMissing     = FsckStatus("missing")     // FsckMissing means the feature hasn't saved anything yet, which is fine.
+	FsckProblems    = FsckStatus("problems")    // FsckProblems means the file is usable but has problems that weren't fixed.
+	FsckBroken      = FsckStatus("broken")      // FsckBroken means the file can't be used and wasn't quarantined.
+	FsckRepaired    = FsckStatus("repaired")    // FsckRepaired means the problems were fixed after taking a backup.
+	FsckQuarantined = FsckStatus("quarantined") // FsckQuarantined means the broken file was moved aside so the app starts with defaults.
+)
+
+// FsckResult describes the checks of a single file.
+type FsckResult struct {
+	Name     string     `json:"name"`
+	Owner    string     `json:"owner"`
+	Status   FsckStatus `json:"status"`
+	Problems []string   `json:"problems,omitempty"`
+	Backup   string     `json:"backup,omitempty"` // Backup is the path of the original file if it was repaired or quarantined.
+}
+
+type fsck struct {
+	backups *backups
+	mu      sync.Mutex
+	checks  map[string]FileCheck
+}
+
+// Register sets the check for a file that is also registered with Backups.
+func (f *fsck) Register(name string, check FileCheck) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.checks[name] = check
+}
+
+func (f *fsck) check(name string) FileCheck {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.checks[name]
+}
+
+// Run checks every registered file in the order they were registered. If repair is true, files with problems that
+// can be fixed are rewritten and files that can't be used are moved aside, keeping a copy of the original either way.
+// Files left behind by interrupted writes and restores are cleaned up too. It should only be run while the app is
+// stopped since the app keeps its own copy of the files in memory.
+func (f *fsck) Run(repair bool, now time.Time) ([]FsckResult, error) {
+	files := f.backups.Files()
+	paths := make(map[string]string)
+	leftovers := make(map[string][]string)
+	contents := make(map[string][]byte)
+	for _, bf := range files {
+		path, err := DefaultStore.Path(bf.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get path for %v: %w", bf.Name, err)
+		}
+		paths[bf.Name] = path
+		leftovers[bf.Name] = cleanupInterruptedWrites(path, repair)
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read %v: %w", bf.Name, err)
+		}
+		contents[bf.Name] = data
+	}
+
+	// Check each file against the others, which have already had any fixes applied by the time later files are checked.
+	var results []FsckResult
+	for _, bf := range files {
+		path := paths[bf.Name]
+		r := FsckResult{Name: bf.Name, Owner: bf.Owner, Status: FsckOK, Problems: leftovers[bf.Name]}
+		if len(r.Problems) > 0 {
+			r.Status = FsckProblems
+			if repair {
+				r.Status = FsckRepaired
+			}
+		}
+		if _, ok := contents[bf.Name]; !ok {
+			if r.Status == FsckOK {
+				r.Status = FsckMissing
+			}
+			results = append(results, r)
+			continue
+		}
+
+		fixed, problems, err := f.checkFile(bf.Name, contents)
+		r.Problems = append(r.Problems, problems...)
+		switch {
+		case err != nil:
+			r.Problems = append(r.Problems, err.Error())
+			r.Status = FsckBroken
+			if repair {
+				r.Backup = path + fsckQuarantineSuffix + now.Format(fsckTimeFormat)
+				if err = os.Rename(path, r.Backup); err != nil {
+					return results, fmt.Errorf("failed to quarantine %v: %w", bf.Name, err)
+				}
+				r.Status = FsckQuarantined
+				delete(contents, bf.Name)
+			}
+		case len(problems) > 0:
+			r.Status = FsckProblems
+			if repair && fixed != nil {
+				r.Backup = path + fsckBackupSuffix + now.Format(fsckTimeFormat)
+				if err = writeSynced(r.Backup, contents[bf.Name]); err != nil {
+					return results, fmt.Errorf("failed to back up %v: %w", bf.Name, err)
+				}
+				if err = DefaultStore.WriteFile(path, string(fixed)); err != nil {
+					return results, fmt.Errorf("failed to repair %v: %w", bf.Name, err)
+				}
+				r.Status = FsckRepaired
+				contents[bf.Name] = fixed
+			}
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+// checkFile runs the registered check for name, or checks that it parses if there isn't one.
+func (f *fsck) checkFile(name string, contents map[string][]byte) (fixed []byte, problems []string, err error) {
+	data := contents[name]
+	if err = validateBackupFile(name, data); err != nil {
+		return nil, nil, err
+	}
+	check := f.check(name)
+	if check == nil {
+		return nil, nil, nil
+	}
+	others := make(map[string][]byte, len(contents))
+	for k, v := range contents {
+		if k != name {
+			others[k] = v
+		}
+	}
+	return check(data, others)
+}
+
+// cleanupInterruptedWrites finds files left next to path by a write or restore that didn't finish, and removes them
+// if repair is true. A file that was moved aside by a restore is put back if the restored file never arrived.
+func cleanupInterruptedWrites(path string, repair bool) []string {
+	var problems []string
+	for _, suffix := range []string{".tmp", restoreStagingSuffix, restorePreviousSuffix} {
+		leftover := path + suffix
+		if _, err := os.Stat(leftover); err != nil {
+			continue
+		}
+		_, err := os.Stat(path)
+		if suffix == restorePreviousSuffix && errors.Is(err, os.ErrNotExist) { // if a restore stopped part way...
+			problems = append(problems, fmt.Sprintf("the file was moved aside by an interrupted restore (%v)", leftover))
+			if repair {
+				_ = os.Rename(leftover, path)
+			}
+			continue
+		}
+		problems = append(problems, fmt.Sprintf("found %v left by an interrupted write", leftover))
+		if repair {
+			_ = os.Remove(leftover)
+		}
+	}
+	return problems
+}
+
+// WriteFsckReport writes a line per file with its problems indented underneath.
+// It returns true if every file is usable as is, i.e. nothing needs repairing.
+func WriteFsckReport(w io.Writer, results []FsckResult) bool {
+	clean := true
+	for _, r := range results {
+		_, _ = fmt.Fprintf(w, "%-12v %v (%v)\n", r.Status, r.Name, r.Owner)
+		for _, p := range r.Problems {
+			_, _ = fmt.Fprintf(w, "             - %v\n", p)
+		}
+		if r.Backup != "" {
+			_, _ = fmt.Fprintf(w, "             original kept at %v\n", r.Backup)
+		}
+		if r.Status == FsckProblems || r.Status == FsckBroken {
+			clean = false
+		}
+	}
+	return clean
+}
+
+// checkGroupMACsFile checks that each MAC is valid and is only listed once in each group.
+// Repairs remove invalid MACs and duplicates.
+func checkGroupMACsFile(data []byte, _ map[string][]byte) ([]byte, []string, error) {
+	var gc GroupMACsConfig
+	if err := yaml.Unmarshal(data, &gc); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal group-macs: %w", err)
+	}
+	var problems []string
+	for g, macs := range gc.Groups {
+		if g == "" || string(g) != models.NewGroup(string(g)) {
+			problems = append(problems, fmt.Sprintf("group %q has an invalid name", g))
+			delete(gc.Groups, g)
+			continue
+		}
+		seen := make(map[string]bool)
+		var kept []models.NamedMAC
+		for _, m := range macs {
+			var mac models.MAC
+			if err := mac.UnmarshalText([]byte(m.MAC)); err != nil {
+				problems = append(problems, fmt.Sprintf("group %v has an invalid MAC %q", g, m.MAC))
+				continue
+			}
+			if seen[string(mac)] {
+				problems = append(problems, fmt.Sprintf("group %v lists MAC %v more than once", g, m.MAC))
+				continue
+			}
+			seen[string(mac)] = true
+			kept = append(kept, m)
+		}
+		gc.Groups[g] = kept
+	}
+	if len(problems) == 0 {
+		return nil, nil, nil
+	}
+	slices.Sort(problems)
+	fixed, err := yaml.Marshal(gc)
+	if err != nil {
+		return nil, problems, fmt.Errorf("failed to marshal group-macs: %w", err)
+	}
+	return fixed, problems, nil
+}
+
+// checkGroupDomainsFile checks that no group has blank domains, which repairs remove.
+func checkGroupDomainsFile(data []byte, _ map[string][]byte) ([]byte, []string, error) {
+	var gc GroupDomainsConfig
+	if err := yaml.Unmarshal(data, &gc); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal group-domains: %w", err)
+	}
+	var problems []string
+	for g, domains := range gc.GroupDomains {
+		kept := slices.DeleteFunc(slices.Clone(domains), func(d models.Domain) bool { return strings.TrimSpace(string(d)) == "" })
+		if len(kept) != len(domains) {
+			problems = append(problems, fmt.Sprintf("group %v has blank domains", g))
+			gc.GroupDomains[g] = kept
+		}
+	}
+	if len(problems) == 0 {
+		return nil, nil, nil
+	}
+	slices.Sort(problems)
+	fixed, err := yaml.Marshal(gc)
+	if err != nil {
+		return nil, problems, fmt.Errorf("failed to marshal group-domains: %w", err)
+	}
+	return fixed, problems, nil
+}
+
+// FsckGroups returns the device groups in the group-macs file in files, for checks of other files that refer to them.
+// ok is false if the file is missing or can't be parsed.
+func FsckGroups(files map[string][]byte) (groups map[models.Group][]models.MAC, ok bool) {
+	data, exists := files[groupMACsFileName]
+	if !exists {
+		return nil, false
+	}
+	var gc GroupMACsConfig
+	if err := yaml.Unmarshal(data, &gc); err != nil {
+		return nil, false
+	}
+	groups = make(map[models.Group][]models.MAC)
+	for g, macs := range gc.Groups {
+		for _, m := range macs {
+			groups[g] = append(groups[g], models.MAC(models.NewMAC(m.MAC)))
+		}
+	}
+	return groups, true
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFsck_Run(t *testing.T) {
+	dir, b := setupBackupDir(t)
+	b.Register("broken.json", "broken file")
+	f := &fsck{backups: b, checks: make(map[string]FileCheck)}
+	f.Register("group-macs.yaml", checkGroupMACsFile)
+	var seen map[string][]byte
+	f.Register("samples.json", func(data []byte, files map[string][]byte) ([]byte, []string, error) {
+		seen = files
+		return nil, nil, nil
+	})
+	groupMACs := "groups:\n  kids:\n    - mac: aa:bb:cc:dd:ee:ff\n    - mac: AA-BB-CC-DD-EE-FF\n    - mac: nope\n"
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "group-macs.yaml"), []byte(groupMACs), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "samples.json"), []byte(`{}`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "samples.json.tmp"), []byte(`{`), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{`), 0644))
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// Check without repairing.
+	results, err := f.Run(false, now)
+	assert.NoError(t, err)
+	assert.Equal(t, []FsckResult{
+		{Name: "group-macs.yaml", Owner: "device groups", Status: FsckProblems, Problems: []string{`group kids has an invalid MAC "nope"`, "group kids lists MAC AA-BB-CC-DD-EE-FF more than once"}},
+		{Name: "samples.json", Owner: "usage samples", Status: FsckProblems, Problems: []string{"found " + filepath.Join(dir, "samples.json.tmp") + " left by an interrupted write"}},
+		{Name: "missing.yaml", Owner: "not saved yet", Status: FsckMissing},
+		{Name: "broken.json", Owner: "broken file", Status: FsckBroken, Problems: []string{"broken.json is not valid JSON: unexpected end of JSON input"}},
+	}, results)
+	assert.Equal(t, groupMACs, string(seen["group-macs.yaml"]), "expected other files to be passed to checks")
+	assert.NotContains(t, seen, "samples.json")
+	assert.False(t, WriteFsckReport(io.Discard, results))
+	data, _ := os.ReadFile(filepath.Join(dir, "group-macs.yaml"))
+	assert.Equal(t, groupMACs, string(data), "expected nothing to change without repair")
+
+	// Repair.
+	results, err = f.Run(true, now)
+	assert.NoError(t, err)
+	assert.Equal(t, FsckRepaired, results[0].Status)
+	assert.Equal(t, filepath.Join(dir, "group-macs.yaml.fsck-20240601-120000"), results[0].Backup)
+	assert.Equal(t, FsckRepaired, results[1].Status)
+	assert.Equal(t, FsckQuarantined, results[3].Status)
+	assert.True(t, WriteFsckReport(io.Discard, results))
+
+	data, _ = os.ReadFile(filepath.Join(dir, "group-macs.yaml"))
+	assert.Equal(t, "groups:\n    kids:\n        - mac: aa:bb:cc:dd:ee:ff\n          name: \"\"\nunusedMACs: []\n", string(data))
+	assert.Contains(t, string(seen["group-macs.yaml"]), "aa:bb:cc:dd:ee:ff", "expected later checks to see the repaired file")
+	data, _ = os.ReadFile(results[0].Backup)
+	assert.Equal(t, groupMACs, string(data), "expected the original to be kept")
+	_, err = os.Stat(filepath.Join(dir, "samples.json.tmp"))
+	assert.True(t, errors.Is(err, os.ErrNotExist), "expected the leftover temp file to be removed")
+	_, err = os.Stat(filepath.Join(dir, "broken.json"))
+	assert.True(t, errors.Is(err, os.ErrNotExist), "expected the broken file to be moved aside")
+	_, err = os.Stat(filepath.Join(dir, "broken.json.broken-20240601-120000"))
+	assert.NoError(t, err)
+
+	// Check again.
+	results, err = f.Run(false, now)
+	assert.NoError(t, err)
+	assert.Equal(t, FsckOK, results[0].Status)
+	assert.Equal(t, FsckOK, results[1].Status)
+	assert.Equal(t, FsckMissing, results[3].Status)
+}
+
+func TestFsck_InterruptedRestore(t *testing.T) {
+	dir, b := setupBackupDir(t)
+	f := &fsck{backups: b, checks: make(map[string]FileCheck)}
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "group-macs.yaml"+restorePreviousSuffix), []byte("groups: {}\n"), 0644))
+
+	results, err := f.Run(true, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, FsckRepaired, results[0].Status)
+	data, err := os.ReadFile(filepath.Join(dir, "group-macs.yaml"))
+	assert.NoError(t, err, "expected the file moved aside by the restore to be put back")
+	assert.Equal(t, "groups: {}\n", string(data))
+}
@@ -9,7 +9,9 @@ import (
 	"io/fs"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
+	"sync"
 
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
@@ -17,13 +19,13 @@ import (
 )
 
 var (
-	defaultGroupDomainsFilePath            = "group-domains.yaml"
-	defaultYouTubeGroupName                = models.Group("youtube")
-	defaultGroupDomains                    = models.MapGroupDomains{defaultYouTubeGroupName: {"www.youtube.com", "youtube.com", "googlevideo.com", "youtu.be"}}
-	groupDomainsFileUpdated                = false
-	youtubeDomainsURL                      = "https://raw.githubusercontent.com/nickspaargaren/no-google/master/categories/youtubeparsed"
-	youtubeDomainsFile                     = "youtube-domains.txt"
-	httpClient                  HTTPClient = &http.Client{} // Default HTTP client, can be replaced for testing
+	GroupDomains                       = &groupDomains{}
+	ErrInvalidDomain                   = errors.New("invalid domain")
+	defaultYouTubeGroupName            = models.Group("youtube")
+	defaultGroupDomains                = models.MapGroupDomains{defaultYouTubeGroupName: {"www.youtube.com", "youtube.com", "googlevideo.com", "youtu.be"}}
+	youtubeDomainsURL                  = "https://raw.githubusercontent.com/nickspaargaren/no-google/master/categories/youtubeparsed"
+	youtubeDomainsFile                 = "youtube-domains.txt"
+	httpClient              HTTPClient = &http.Client{} // Default HTTP client, can be replaced for testing
 )
 
 // HTTPClient interface for mocking
@@ -41,36 +43,107 @@ type GroupDomainsConfig struct {
 	GroupDomains models.MapGroupDomains `yaml:"groups"` // group: [domain1, domain2, ...]
 }
 
-// LoadGroupDomains parses the default group domains YAML file and returns the map of group domains.
-// Superseded by FetchYouTubeDomains for now to fetch the latest list of YouTube domains.
-func LoadGroupDomains() (models.MapGroupDomains, error) {
-	if !groupMACsFileUpdated { // if we should update the file path with the app home dir...
-		var err error
-		defaultGroupDomainsFilePath, err = FnDefaultCreateAppHomeDirAndGetConfigFilePath(defaultGroupDomainsFilePath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create home directory for group-domains config file: %w", err)
-		} else {
-			groupDomainsFileUpdated = true
+// groupDomains is used as a package variable to load and save the group-domains file.
+type groupDomains struct {
+	mu sync.Mutex
+}
+
+const groupDomainsFileName = "group-domains.yaml"
+
+// GetGroupDomains returns the domains of each group saved in the group-domains file. Until the file is saved, the
+// latest list of YouTube domains is fetched for the youtube group instead.
+func (g *groupDomains) GetGroupDomains(logger *zap.SugaredLogger) (models.MapGroupDomains, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	m, exists, err := g.readFile()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return FetchYouTubeDomains(logger)
+	}
+	if m == nil {
+		m = make(models.MapGroupDomains)
+	}
+	return m, nil
+}
+
+// SaveGroupDomains replaces the groups and their domains in the group-domains file. Domains are trimmed, lower
+// cased and de-duplicated, and blank ones are dropped. An error wrapping ErrInvalidDomain is returned if any domain
+// isn't a host name.
+func (g *groupDomains) SaveGroupDomains(logger *zap.SugaredLogger, m models.MapGroupDomains) error {
+	cleaned := make(models.MapGroupDomains)
+	for group, domains := range m {
+		group = models.Group(strings.TrimSpace(models.NewGroup(string(group))))
+		if group == "" {
+			continue
+		}
+		for _, d := range domains {
+			d = models.Domain(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(string(d))), "."))
+			if d == "" {
+				continue
+			}
+			if strings.ContainsAny(string(d), " \t/:@") {
+				return fmt.Errorf("%w %q in group %v", ErrInvalidDomain, d, group)
+			}
+			if !slices.Contains(cleaned[group], d) {
+				cleaned[group] = append(cleaned[group], d)
+			}
+		}
+		if _, ok := cleaned[group]; !ok { // if the group has no domains, keep it so it's still listed...
+			cleaned[group] = []models.Domain{}
 		}
 	}
 
-	_, err := os.Stat(defaultGroupDomainsFilePath)
-	if err != nil && errors.Is(err, fs.ErrNotExist) {
-		return defaultGroupDomains, nil
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	path, err := DefaultStore.Path(groupDomainsFileName)
+	if err != nil {
+		return fmt.Errorf("failed to create home directory for group-domains config file: %w", err)
+	}
+	yamlBytes, err := yaml.Marshal(GroupDomainsConfig{GroupDomains: cleaned})
+	if err != nil {
+		return fmt.Errorf("failed to marshal group-domains to YAML: %w", err)
 	}
+	if err = DefaultStore.WriteFile(path, string(yamlBytes)); err != nil {
+		return fmt.Errorf("failed to write group-domains to file: %w", err)
+	}
+	logger.Infof("Saved the domains of %v groups", len(cleaned))
+	return nil
+}
 
-	yamlFile, err := os.ReadFile(defaultGroupDomainsFilePath)
+// LoadGroupDomains parses the default group domains YAML file and returns the map of group domains, or the default
+// YouTube domains if the file doesn't exist.
+func LoadGroupDomains() (models.MapGroupDomains, error) {
+	m, exists, err := GroupDomains.readFile()
 	if err != nil {
-		return models.MapGroupDomains{}, fmt.Errorf("error reading YAML file: %w", err)
+		return models.MapGroupDomains{}, err
 	}
+	if !exists {
+		return defaultGroupDomains, nil
+	}
+	return m, nil
+}
 
-	var groupDomains GroupDomainsConfig
-	err = yaml.Unmarshal(yamlFile, &groupDomains)
+// readFile parses the group domains YAML file. exists is false if the file hasn't been saved.
+func (g *groupDomains) readFile() (m models.MapGroupDomains, exists bool, err error) {
+	path, err := DefaultStore.Path(groupDomainsFileName)
 	if err != nil {
-		return models.MapGroupDomains{}, fmt.Errorf("error unmarshalling YAML: %w", err)
+		return nil, false, fmt.Errorf("failed to create home directory for group-domains config file: %w", err)
 	}
 
-	return groupDomains.GroupDomains, nil
+	yamlFile, err := os.ReadFile(path)
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("error reading YAML file: %w", err)
+	}
+
+	var gc GroupDomainsConfig
+	if err = yaml.Unmarshal(yamlFile, &gc); err != nil {
+		return nil, true, fmt.Errorf("error unmarshalling YAML: %w", err)
+	}
+	return gc.GroupDomains, true, nil
 }
 
 // FetchYouTubeDomains retrieves the list of domains from the specified URL.
@@ -6,6 +6,7 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path/filepath"
 	"slices"
 	"testing"
 
@@ -15,8 +16,11 @@ import (
 
 // TestLoadGroupDomains tests the LoadGroupDomains function
 func TestLoadGroupDomains(t *testing.T) {
-	// Override the default home dir to just return the tmp dir so that LoadGroupDomains doesn't try the app home dir.
-	FnDefaultCreateAppHomeDirAndGetConfigFilePath = func(f string) (string, error) { return f, nil }
+	// Keep the group domains in a temp dir so that LoadGroupDomains doesn't try the app home dir.
+	dir := t.TempDir()
+	origStore := DefaultStore
+	t.Cleanup(func() { DefaultStore = origStore })
+	DefaultStore = NewStore(dir)
 
 	// Define test cases
 	tests := []struct {
@@ -57,23 +61,12 @@ groups:
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Create a temporary file
-			tmpFile, err := os.CreateTemp("", "test-*.yaml")
-			if err != nil {
-				t.Fatalf("Failed to create temporary file: %v", err)
-			}
-			defer func(name string) {
-				_ = os.Remove(name)
-			}(tmpFile.Name()) // Clean up after test
-
 			// Write the test YAML content to the file
-			if _, err := tmpFile.Write([]byte(tt.yamlContent)); err != nil {
+			if err := os.WriteFile(filepath.Join(dir, groupDomainsFileName), []byte(tt.yamlContent), 0644); err != nil {
 				t.Fatalf("Failed to write to temporary file: %v", err)
 			}
-			_ = tmpFile.Close()
 
 			// Call the function under test
-			defaultGroupDomainsFilePath = tmpFile.Name()
 			result, err := LoadGroupDomains()
 
 			// Check for expected errors
@@ -195,3 +188,30 @@ func compareDomains(got, expected models.MapGroupDomains) bool {
 	}
 	return true
 }
+
+func TestGroupDomains_SaveAndGet(t *testing.T) {
+	origStore := DefaultStore
+	t.Cleanup(func() { DefaultStore = origStore })
+	DefaultStore = NewStore(t.TempDir())
+	logger := MustGetLogger()
+
+	err := GroupDomains.SaveGroupDomains(logger, models.MapGroupDomains{
+		"Kids Games": {" Roblox.com ", "roblox.com", "rbxcdn.com.", ""},
+		"empty":      {},
+		" ":          {"ignored.com"},
+	})
+	assert.NoError(t, err)
+
+	got, err := GroupDomains.GetGroupDomains(logger)
+	assert.NoError(t, err)
+	assert.Equal(t, models.MapGroupDomains{
+		"Kids Games": {"roblox.com", "rbxcdn.com"},
+		"empty":      {},
+	}, got, "expected the domains to be cleaned and blank groups dropped")
+
+	err = GroupDomains.SaveGroupDomains(logger, models.MapGroupDomains{"kids": {"https://roblox.com/games"}})
+	assert.ErrorIs(t, err, ErrInvalidDomain)
+	got, err = GroupDomains.GetGroupDomains(logger)
+	assert.NoError(t, err)
+	assert.Contains(t, got, models.Group("Kids Games"), "expected an invalid save to leave the file alone")
+}
@@ -3,25 +3,35 @@ package config
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v3"
 	"relloyd/tubetimeout/models"
+	"relloyd/tubetimeout/oui"
 )
 
 var (
 	GroupMACs                 = &groupMACs{}
 	ErrorGroupMacFileNotFound = fmt.Errorf("group-macs file not found")
-	defaultGroupMacFilePath   = "group-macs.yaml"
-	groupMACsFileUpdated      = false
+	localDeviceName           = "This gateway" // localDeviceName is shown for models.LocalDeviceMAC until it's added to a group.
+	guestSeenInterval         = time.Hour      // guestSeenInterval is how stale a guest's LastSeen gets before it's saved again.
 )
 
+const groupMACsFileName = "group-macs.yaml"
+
 var ARPCmd = func() (string, error) {
-	output, err := exec.Command("arp", "-n", "-a").Output() // -n: show numerical addresses, -a: show all hosts
+	output, err := Commands.Query("arp", "-n", "-a") // -n: show numerical addresses, -a: show all hosts
+	return string(output), err
+}
+
+// NDPCmd lists the IPv6 neighbour table, the IPv6 counterpart of the ARP table.
+var NDPCmd = func() (string, error) {
+	output, err := Commands.Query("ip", "-6", "neigh", "show")
 	return string(output), err
 }
 
@@ -33,41 +43,74 @@ type GroupMACsConfig struct {
 
 // FlatGroupMAC represents the JSON structure used to get/set the group-macs from the web API.
 type FlatGroupMAC struct {
-	Group string `json:"group"`
-	MAC   string `json:"mac"`
-	Name  string `json:"name"`
+	Group     string            `json:"group"`
+	MAC       string            `json:"mac"`
+	Name      string            `json:"name"`
+	Placement *models.Placement `json:"placement,omitempty"` // Placement is why the device is in its effective group, if it has been seen on the network. It is ignored on save.
+	Identity  string            `json:"identity,omitempty"`  // Identity binds the device to its stable identity, see models.NamedMAC.
+	// SeenIdentity is the identity the device currently has, which can be saved as its Identity, Randomized is
+	// true if the MAC is a private one that the device may change, and Vendor is the maker of the device according
+	// to its MAC. They are ignored on save.
+	SeenIdentity string `json:"seenIdentity,omitempty"`
+	Randomized   bool   `json:"randomized,omitempty"`
+	Vendor       string `json:"vendor,omitempty"`
 }
 
 // groupMACs is used as a package variable to load the group-macs from disk.
 type groupMACs struct {
-	mu sync.Mutex
+	mu             sync.Mutex
+	nameSource     func(mac string) (string, bool)
+	deviceSources  []models.DeviceSource
+	identitySource models.IdentitySource
+}
+
+// RegisterNameSource sets a function used to suggest names for MACs that haven't been named by the user,
+// e.g. names discovered from mDNS/SSDP announcements.
+func (g *groupMACs) RegisterNameSource(fn func(mac string) (string, bool)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.nameSource = fn
+}
+
+// RegisterDeviceSources adds sources of devices that may be missing from the ARP scan, e.g. the router's client table.
+func (g *groupMACs) RegisterDeviceSources(sources ...models.DeviceSource) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.deviceSources = append(g.deviceSources, sources...)
+}
+
+// RegisterIdentitySource sets where the devices' stable identities are found, e.g. the DHCP leases, so that devices
+// using private MACs can be bound to their identity.
+func (g *groupMACs) RegisterIdentitySource(src models.IdentitySource) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.identitySource = src
 }
 
-// GetConfig parses the defaultGroupMacFilePath YAML file.
+// GetConfig parses the group-macs YAML file.
 func (g *groupMACs) GetConfig(logger *zap.SugaredLogger) (GroupMACsConfig, error) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
+	return g.getConfig()
+}
 
-	if !groupMACsFileUpdated {
-		var err error
-		defaultGroupMacFilePath, err = FnDefaultCreateAppHomeDirAndGetConfigFilePath(defaultGroupMacFilePath)
-		if err != nil {
-			return GroupMACsConfig{}, fmt.Errorf("failed to create home directory for group-macs config file: %w", err)
-		} else {
-			groupMACsFileUpdated = true
-		}
+// getConfig parses the group-macs YAML file. It should be called under lock.
+func (g *groupMACs) getConfig() (GroupMACsConfig, error) {
+	path, err := DefaultStore.Path(groupMACsFileName)
+	if err != nil {
+		return GroupMACsConfig{}, fmt.Errorf("failed to create home directory for group-macs config file: %w", err)
 	}
 
-	yamlFile, err := os.ReadFile(defaultGroupMacFilePath)
+	yamlFile, err := os.ReadFile(path)
 	if err != nil && os.IsNotExist(err) { // if the file needs creating...
 		// Create the file with zero data.
-		err = FnDefaultSafeWriteViaTemp(defaultGroupMacFilePath, "")
+		err = DefaultStore.WriteFile(path, "")
 		if err != nil {
 			return GroupMACsConfig{}, fmt.Errorf("failed to create group-macs file: %w", err)
 		}
 		return GroupMACsConfig{}, nil
 	} else if err != nil {
-		return GroupMACsConfig{}, fmt.Errorf("%w: %v: %v", ErrorGroupMacFileNotFound, err, defaultGroupMacFilePath)
+		return GroupMACsConfig{}, fmt.Errorf("%w: %v: %v", ErrorGroupMacFileNotFound, err, path)
 	}
 
 	var gc GroupMACsConfig
@@ -79,7 +122,9 @@ func (g *groupMACs) GetConfig(logger *zap.SugaredLogger) (GroupMACsConfig, error
 	return gc, nil
 }
 
-// GetAllGroupMACs returns all the group-macs from the config file and ARP scan.
+// GetAllGroupMACs returns all the group-macs from the config file, ARP scan and registered device sources.
+// Names that are blank are filled from the registered name source, if any, then from the device sources.
+// MACs in the ARP scan whose identity is bound to a group MAC are left out, since they're the same device.
 func (g *groupMACs) GetAllGroupMACs(logger *zap.SugaredLogger) ([]FlatGroupMAC, error) {
 	// Load the configured group-macs from disk.
 	gm, err := g.GetConfig(logger)
@@ -87,17 +132,30 @@ func (g *groupMACs) GetAllGroupMACs(logger *zap.SugaredLogger) ([]FlatGroupMAC,
 		return nil, err
 	}
 
+	g.mu.Lock()
+	nameSource, deviceSources, identitySource := g.nameSource, slices.Clone(g.deviceSources), g.identitySource
+	g.mu.Unlock()
+	var identities models.MapMACIdentity
+	if identitySource != nil {
+		identities = identitySource.Identities()
+	}
+
 	// Convert the group-macs to the JSON structure
 	var allGroupMACs []FlatGroupMAC
 	macs := make(map[string]bool)
+	bound := make(map[string]bool) // bound are the identities of the group MACs.
 	for group, namedMacs := range gm.Groups {
 		for _, namedMAC := range namedMacs {
 			allGroupMACs = append(allGroupMACs, FlatGroupMAC{
-				Group: string(group),
-				MAC:   namedMAC.MAC,
-				Name:  namedMAC.Name,
+				Group:    string(group),
+				MAC:      namedMAC.MAC,
+				Name:     namedMAC.Name,
+				Identity: namedMAC.Identity,
 			})
 			macs[namedMAC.MAC] = true
+			if namedMAC.Identity != "" {
+				bound[namedMAC.Identity] = true
+			}
 		}
 	}
 	// Add the unused MACs with names to allGroupMACs.
@@ -130,7 +188,11 @@ func (g *groupMACs) GetAllGroupMACs(logger *zap.SugaredLogger) ([]FlatGroupMAC,
 		if !re.MatchString(arpMAC) { // if the MAC address is invalid...
 			continue
 		}
-		arpMAC = models.NewMAC(arpMAC)      // sanitise the MAC
+		arpMAC = models.NewMAC(arpMAC) // sanitise the MAC
+		// Skip the MAC if the device is in a group by another MAC.
+		if id, ok := identities[models.MAC(arpMAC)]; ok && bound[id] && !macs[arpMAC] {
+			continue
+		}
 		if _, seen := macs[arpMAC]; !seen { // if we don't already have config for this MAC...
 			// Add the MAC to the list.
 			allGroupMACs = append(allGroupMACs, FlatGroupMAC{
@@ -142,14 +204,77 @@ func (g *groupMACs) GetAllGroupMACs(logger *zap.SugaredLogger) ([]FlatGroupMAC,
 		}
 	}
 
+	// Add the devices known to other sources, e.g. the router, that haven't talked to us recently.
+	sourceNames := make(map[string]string)
+	for _, src := range deviceSources {
+		for _, d := range src.Devices() {
+			mac := models.NewMAC(d.MAC)
+			if sourceNames[mac] == "" { // if an earlier source didn't name it...
+				sourceNames[mac] = d.Name
+			}
+			if !macs[mac] {
+				allGroupMACs = append(allGroupMACs, FlatGroupMAC{MAC: mac})
+				macs[mac] = true
+			}
+		}
+	}
+
+	// Offer the gateway itself if its own traffic can be filtered.
+	if AppCfg.FilterConfig.LocalDevice && !macs[string(models.LocalDeviceMAC)] {
+		allGroupMACs = append(allGroupMACs, FlatGroupMAC{MAC: string(models.LocalDeviceMAC), Name: localDeviceName})
+	}
+
+	// Fill blank names with discovered ones, or else the names the device sources know them by, and add the makers.
+	for i := range allGroupMACs {
+		allGroupMACs[i].SeenIdentity = identities[models.MAC(allGroupMACs[i].MAC)]
+		allGroupMACs[i].Randomized = models.MAC(allGroupMACs[i].MAC).IsRandomized()
+		if models.MAC(allGroupMACs[i].MAC) != models.LocalDeviceMAC {
+			allGroupMACs[i].Vendor = oui.Vendor(allGroupMACs[i].MAC)
+		}
+		if allGroupMACs[i].Name != "" { // if the user named the device already...
+			continue
+		}
+		if nameSource != nil {
+			if name, ok := nameSource(allGroupMACs[i].MAC); ok {
+				allGroupMACs[i].Name = name
+				continue
+			}
+		}
+		allGroupMACs[i].Name = sourceNames[allGroupMACs[i].MAC]
+	}
+
 	return allGroupMACs, nil
 }
 
 // SaveGroupMACs saves the group-macs to the config file.
+// MACs that are new to a group are saved with the time they were assigned, while existing ones keep theirs.
+// Guests that are taken out of the guests group without being put in another group are saved as unused MACs, so
+// they aren't made guests again.
 func (g *groupMACs) SaveGroupMACs(logger *zap.SugaredLogger, flatGroupMACs []FlatGroupMAC) error {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
+	// Remember when the existing MACs were assigned, and which ones were kept out of groups.
+	existing := make(map[models.Group]map[string]models.NamedMAC)
+	ungrouped := make(map[string]bool)
+	if prev, err := g.getConfig(); err == nil {
+		for group, namedMacs := range prev.Groups {
+			existing[group] = make(map[string]models.NamedMAC)
+			for _, namedMAC := range namedMacs {
+				existing[group][namedMAC.MAC] = namedMAC
+				if namedMAC.Guest {
+					ungrouped[namedMAC.MAC] = true
+				}
+			}
+		}
+		for _, namedMAC := range prev.UnusedMACs {
+			ungrouped[namedMAC.MAC] = true
+		}
+	} else {
+		logger.Warnf("Unable to load existing group-macs, assignment times will be reset: %v", err)
+	}
+	now := time.Now()
+
 	// Convert the JSON structure to the group-macs YAML structure.
 	groups := make(map[models.Group][]models.NamedMAC)
 	unusedMACs := make([]models.NamedMAC, 0)
@@ -165,11 +290,19 @@ func (g *groupMACs) SaveGroupMACs(logger *zap.SugaredLogger, flatGroupMACs []Fla
 			}
 
 			// Append the namedMAC to the group.
+			prev, ok := existing[group][flatGroupMAC.MAC]
+			if !ok { // if the MAC is new to the group...
+				prev = models.NamedMAC{AssignedAt: now}
+			}
 			groups[group] = append(groups[group], models.NamedMAC{
-				MAC:  flatGroupMAC.MAC,
-				Name: flatGroupMAC.Name, // Name may be blank.
+				MAC:        flatGroupMAC.MAC,
+				Name:       flatGroupMAC.Name, // Name may be blank.
+				AssignedAt: prev.AssignedAt,
+				Identity:   flatGroupMAC.Identity,
+				Guest:      prev.Guest,
+				LastSeen:   prev.LastSeen,
 			})
-		} else if flatGroupMAC.MAC != "" { // else if the MAC has a name and is worth remembering...
+		} else if flatGroupMAC.MAC != "" && ungrouped[flatGroupMAC.MAC] { // else if the MAC was kept out of groups...
 			// Append the MAC to the unusedMACs.
 			unusedMACs = append(unusedMACs, models.NamedMAC{
 				MAC:  flatGroupMAC.MAC,
@@ -180,15 +313,99 @@ func (g *groupMACs) SaveGroupMACs(logger *zap.SugaredLogger, flatGroupMACs []Fla
 
 	// Marshal the group-macs to YAML.
 	gc := GroupMACsConfig{Groups: groups}
+	if len(unusedMACs) > 0 {
+		gc.UnusedMACs = unusedMACs
+	}
+	return g.saveConfig(gc)
+}
+
+// saveConfig writes the group-macs to the config file. It should be called under lock.
+func (g *groupMACs) saveConfig(gc GroupMACsConfig) error {
 	yamlBytes, err := yaml.Marshal(gc)
 	if err != nil {
 		return fmt.Errorf("failed to marshal group-macs to YAML: %w", err)
 	}
 
-	err = FnDefaultSafeWriteViaTemp(defaultGroupMacFilePath, string(yamlBytes))
+	path, err := DefaultStore.Path(groupMACsFileName)
+	if err != nil {
+		return fmt.Errorf("failed to create home directory for group-macs config file: %w", err)
+	}
+	err = DefaultStore.WriteFile(path, string(yamlBytes))
 	if err != nil {
 		return fmt.Errorf("failed to write group-macs to file: %w", err)
 	}
 
 	return nil
 }
+
+// UpdateGuests puts the MACs seen that aren't in a group, or kept out of one, in the guests group, notes when the
+// guests were seen and removes the guests that haven't been seen for GuestConfig.Expiry. It returns true if the
+// group-macs were changed.
+func (g *groupMACs) UpdateGuests(logger *zap.SugaredLogger, seen []models.MAC, now time.Time) (bool, error) {
+	cfg := Current().GuestConfig
+	group := models.Group(models.NewGroup(cfg.Group))
+	if group == "" {
+		return false, fmt.Errorf("the guests group isn't set")
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	gc, err := g.getConfig()
+	if err != nil {
+		return false, err
+	}
+
+	known := make(map[string]bool)
+	for _, namedMacs := range gc.Groups {
+		for _, namedMAC := range namedMacs {
+			known[namedMAC.MAC] = true
+		}
+	}
+	for _, namedMAC := range gc.UnusedMACs {
+		known[namedMAC.MAC] = true
+	}
+	isSeen := make(map[string]bool, len(seen))
+	for _, mac := range seen {
+		isSeen[string(mac)] = true
+	}
+
+	changed := false
+	for name, namedMacs := range gc.Groups {
+		kept := namedMacs[:0]
+		for _, namedMAC := range namedMacs {
+			switch {
+			case !namedMAC.Guest:
+			case isSeen[namedMAC.MAC]:
+				if now.Sub(namedMAC.LastSeen) >= guestSeenInterval {
+					namedMAC.LastSeen, changed = now, true
+				}
+			case namedMAC.LastSeen.IsZero():
+				namedMAC.LastSeen, changed = now, true // start the clock for guests added by hand.
+			case now.Sub(namedMAC.LastSeen) > cfg.Expiry:
+				logger.Infof("Removing guest %v from group %v, last seen %v", namedMAC.MAC, name, namedMAC.LastSeen)
+				changed = true
+				continue
+			}
+			kept = append(kept, namedMAC)
+		}
+		gc.Groups[name] = kept
+	}
+	for _, mac := range seen {
+		if known[string(mac)] || mac == models.LocalDeviceMAC {
+			continue
+		}
+		logger.Infof("Adding guest %v to group %v", mac, group)
+		if gc.Groups == nil {
+			gc.Groups = make(map[models.Group][]models.NamedMAC)
+		}
+		gc.Groups[group] = append(gc.Groups[group], models.NamedMAC{MAC: string(mac), AssignedAt: now, Guest: true, LastSeen: now})
+		known[string(mac)], changed = true, true
+	}
+	if !changed {
+		return false, nil
+	}
+	if err = g.saveConfig(gc); err != nil {
+		return false, err
+	}
+	return true, nil
+}
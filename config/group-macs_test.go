@@ -4,6 +4,7 @@ import (
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"relloyd/tubetimeout/models"
@@ -29,34 +30,14 @@ unusedMACs:
   - mac: "22-33-44-55-66-77"
     name: "unused-device-2"
 `
-	// Create a temporary file to hold the YAML content
-	tempFile, err := os.CreateTemp("", "test-mac-groups-*.yaml")
-	if err != nil {
-		t.Fatalf("Failed to create temp file: %v", err)
-	}
-	t.Cleanup(func() {
-		_ = os.Remove(tempFile.Name())
-	})
-
-	// Write the YAML content to the file
-	if _, err := tempFile.WriteString(yamlContent); err != nil {
+	// Write the YAML content to the group-macs file of a temp store.
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, groupMACsFileName), []byte(yamlContent), 0644); err != nil {
 		t.Fatalf("Failed to write to temp file: %v", err)
 	}
-	if err := tempFile.Close(); err != nil {
-		t.Fatalf("Failed to close temp file: %v", err)
-	}
-
-	oldDefaultCreateAppHomeDirAndGetConfigFilePathFunc := FnDefaultCreateAppHomeDirAndGetConfigFilePath
-	oldDefaultGroupMacFilePath := defaultGroupMacFilePath
-	t.Cleanup(func() {
-		FnDefaultCreateAppHomeDirAndGetConfigFilePath = oldDefaultCreateAppHomeDirAndGetConfigFilePathFunc
-		defaultGroupMacFilePath = oldDefaultGroupMacFilePath
-		groupMACsFileUpdated = false
-	})
-
-	// Hack functions so the temp file is returned to GetConfig().
-	defaultGroupMacFilePath = tempFile.Name()                                                        // override the default file path with temp file above.
-	FnDefaultCreateAppHomeDirAndGetConfigFilePath = func(f string) (string, error) { return f, nil } // override the function that uses the home dir for config files.
+	origStore := DefaultStore
+	t.Cleanup(func() { DefaultStore = origStore })
+	DefaultStore = NewStore(dir)
 }
 
 func TestGetGroupMACs(t *testing.T) {
@@ -89,7 +70,7 @@ func TestGetGroupMACs(t *testing.T) {
 		}
 		for i, v := range namedMacs {
 			if parsedMacs[i].MAC != v.MAC {
-				t.Errorf("Group %q: expected MAC %q, got %q", group, v.MAC, parsedMacs[i])
+				t.Errorf("Group %q: expected MAC %q, got %q", group, v.MAC, parsedMacs[i].MAC)
 			}
 			if parsedMacs[i].Name != v.Name {
 				t.Errorf("Group %q: expected Name %q, got %q", group, v.Name, parsedMacs[i].Name)
@@ -126,30 +107,213 @@ func TestGetAllGroupMACs(t *testing.T) {
 }
 
 func TestGetGroupMACsFileNotFound(t *testing.T) {
-	td, err := os.MkdirTemp("", "test-mac-groups-config-*")
-	assert.NoError(t, err, "Failed to create temp dir")
-
-	// Save values to restore later.
-	oldDefaultGroupMacFilePath := defaultGroupMacFilePath
-	oldDefaultCreateAppHomeDirAndGetConfigFilePathFunc := FnDefaultCreateAppHomeDirAndGetConfigFilePath
-
-	// Hack functions so the temp file is returned to GetConfig().
-	defaultGroupMacFilePath = "auto-created-file.yaml"
-	groupMACsFileUpdated = false
-	expectedConfigFilePath := path.Join(td, defaultGroupMacFilePath)
-	FnDefaultCreateAppHomeDirAndGetConfigFilePath = func(f string) (string, error) { return expectedConfigFilePath, nil }
-
-	// Cleanup.
-	t.Cleanup(func() {
-		_ = os.Remove(expectedConfigFilePath)
-		defaultGroupMacFilePath = oldDefaultGroupMacFilePath
-		FnDefaultCreateAppHomeDirAndGetConfigFilePath = oldDefaultCreateAppHomeDirAndGetConfigFilePathFunc
-		groupMACsFileUpdated = false
-	})
+	td := t.TempDir()
+	origStore := DefaultStore
+	t.Cleanup(func() { DefaultStore = origStore })
+	DefaultStore = NewStore(td)
+	expectedConfigFilePath := path.Join(td, groupMACsFileName)
 
 	// Call the function under test.
-	_, err = GroupMACs.GetConfig(MustGetLogger())
+	_, err := GroupMACs.GetConfig(MustGetLogger())
+	assert.NoError(t, err)
 	_, err = os.Stat(expectedConfigFilePath)
 	assert.NoError(t, err, "Failed to stat the config file")
 	assert.False(t, os.IsNotExist(err), "Expected a config file to be created")
 }
+
+func TestGetAllGroupMACs_DiscoveredNames(t *testing.T) {
+	setupConfig(t)
+
+	ARPCmd = func() (string, error) {
+		return `
+? (192.168.1.10) at 00:11:22:33:44:55
+? (192.168.1.14) at 12:34:56:78:9A:BC
+`, nil
+	}
+
+	GroupMACs.RegisterNameSource(func(mac string) (string, bool) {
+		return "discovered-" + mac, true
+	})
+	t.Cleanup(func() { GroupMACs.RegisterNameSource(nil) })
+
+	allGroupMACs, err := GroupMACs.GetAllGroupMACs(MustGetLogger())
+	assert.NoError(t, err, "GetAllGroupMACs returned an error")
+
+	names := make(map[string]string)
+	for _, gm := range allGroupMACs {
+		names[gm.MAC] = gm.Name
+	}
+	assert.Equal(t, "my-device", names["00-11-22-33-44-55"], "expected the user's name to be kept")
+	assert.Equal(t, "discovered-66-77-88-99-AA-BB", names["66-77-88-99-AA-BB"], "expected a blank name in config to be filled")
+	assert.Equal(t, "discovered-12-34-56-78-9A-BC", names["12-34-56-78-9A-BC"], "expected a blank name from the ARP scan to be filled")
+}
+
+type mockDeviceSource []models.NamedMAC
+
+func (m mockDeviceSource) Devices() []models.NamedMAC {
+	return m
+}
+
+func TestGetAllGroupMACs_DeviceSources(t *testing.T) {
+	setupConfig(t)
+
+	ARPCmd = func() (string, error) {
+		return "? (192.168.1.10) at 00:11:22:33:44:55\n", nil
+	}
+	origSources := GroupMACs.deviceSources
+	t.Cleanup(func() { GroupMACs.deviceSources = origSources })
+	GroupMACs.RegisterDeviceSources(mockDeviceSource{
+		{MAC: "00:11:22:33:44:55", Name: "router-name"},
+		{MAC: "66-77-88-99-AA-BB", Name: "printer"},
+		{MAC: "de:ad:be:ef:00:01", Name: "tablet"},
+		{MAC: "DE-AD-BE-EF-00-02"},
+	})
+
+	allGroupMACs, err := GroupMACs.GetAllGroupMACs(MustGetLogger())
+	assert.NoError(t, err, "GetAllGroupMACs returned an error")
+
+	names := make(map[string]string)
+	for _, gm := range allGroupMACs {
+		names[gm.MAC] = gm.Name
+	}
+	assert.Len(t, allGroupMACs, 7, "expected the devices missing from the ARP scan to be added once")
+	assert.Equal(t, "my-device", names["00-11-22-33-44-55"], "expected the user's name to be kept")
+	assert.Equal(t, "printer", names["66-77-88-99-AA-BB"], "expected a blank name in config to be filled")
+	assert.Equal(t, "tablet", names["DE-AD-BE-EF-00-01"])
+	assert.Contains(t, names, "DE-AD-BE-EF-00-02")
+}
+
+func TestSaveGroupMACs_AssignedAt(t *testing.T) {
+	setupConfig(t)
+
+	save := []FlatGroupMAC{
+		{Group: "group1", MAC: "00-11-22-33-44-55", Name: "my-device"},
+		{Group: "group2", MAC: "66-77-88-99-AA-BB"}, // moved from group1.
+	}
+	before := time.Now()
+	assert.NoError(t, GroupMACs.SaveGroupMACs(MustGetLogger(), save))
+	gm, err := GroupMACs.GetConfig(MustGetLogger())
+	assert.NoError(t, err)
+	assert.True(t, gm.Groups["group1"][0].AssignedAt.IsZero(), "expected an existing MAC to keep its unknown assignment time")
+	assignedAt := gm.Groups["group2"][0].AssignedAt
+	assert.False(t, assignedAt.Before(before), "expected a MAC that is new to the group to be saved with the time it was assigned")
+
+	assert.NoError(t, GroupMACs.SaveGroupMACs(MustGetLogger(), save))
+	gm, err = GroupMACs.GetConfig(MustGetLogger())
+	assert.NoError(t, err)
+	assert.True(t, assignedAt.Equal(gm.Groups["group2"][0].AssignedAt), "expected the assignment time to be kept on later saves")
+}
+
+type mockIdentitySource models.MapMACIdentity
+
+func (m mockIdentitySource) Identities() models.MapMACIdentity {
+	return models.MapMACIdentity(m)
+}
+
+func TestGroupMACs_Identity(t *testing.T) {
+	setupConfig(t)
+	origSource := GroupMACs.identitySource
+	t.Cleanup(func() { GroupMACs.identitySource = origSource })
+	GroupMACs.RegisterIdentitySource(mockIdentitySource{
+		"62-11-22-33-44-55": "hostname:kids-iphone",
+		"7A-11-22-33-44-66": "hostname:kids-iphone",
+		"00-11-22-33-44-55": "hostname:laptop",
+	})
+	ARPCmd = func() (string, error) { return "? (192.168.1.10) at 62:11:22:33:44:55\n", nil }
+
+	// Expect the identity to be saved with the group MAC.
+	assert.NoError(t, GroupMACs.SaveGroupMACs(MustGetLogger(), []FlatGroupMAC{
+		{Group: "kids", MAC: "62-11-22-33-44-55", Name: "Kids iPhone", Identity: "hostname:kids-iphone", SeenIdentity: "ignored", Randomized: true},
+	}))
+	gm, err := GroupMACs.GetConfig(MustGetLogger())
+	assert.NoError(t, err)
+	assert.Equal(t, []models.NamedMAC{{MAC: "62-11-22-33-44-55", Name: "Kids iPhone", AssignedAt: gm.Groups["kids"][0].AssignedAt, Identity: "hostname:kids-iphone"}}, gm.Groups["kids"])
+
+	all, err := GroupMACs.GetAllGroupMACs(MustGetLogger())
+	assert.NoError(t, err)
+	if assert.Len(t, all, 1) {
+		assert.Equal(t, "hostname:kids-iphone", all[0].SeenIdentity)
+		assert.True(t, all[0].Randomized)
+		assert.Empty(t, all[0].Vendor, "expected private MACs not to have a maker")
+	}
+
+	// Expect the phone's new private MAC to be left out since it's the same device, unlike other devices.
+	ARPCmd = func() (string, error) {
+		return "? (192.168.1.11) at 7a:11:22:33:44:66\n? (192.168.1.12) at 00:11:22:33:44:55\n", nil
+	}
+	all, err = GroupMACs.GetAllGroupMACs(MustGetLogger())
+	assert.NoError(t, err)
+	if assert.Len(t, all, 2) {
+		assert.Equal(t, "00-11-22-33-44-55", all[1].MAC)
+		assert.Equal(t, "hostname:laptop", all[1].SeenIdentity)
+		assert.False(t, all[1].Randomized)
+		assert.Equal(t, "CIMSYS", all[1].Vendor)
+	}
+}
+
+func TestGroupMACs_UpdateGuests(t *testing.T) {
+	setupConfig(t)
+	origGuests := AppCfg.GuestConfig
+	t.Cleanup(func() { AppCfg.GuestConfig = origGuests })
+	AppCfg.GuestConfig = GuestConfig{Enabled: true, Group: "guests", Expiry: 24 * time.Hour}
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	seen := []models.MAC{"00-11-22-33-44-55", "11-22-33-44-55-66", "DE-AD-BE-EF-00-01", models.LocalDeviceMAC}
+
+	// Expect only the MAC that isn't in a group, or kept out of one, to become a guest.
+	changed, err := GroupMACs.UpdateGuests(MustGetLogger(), seen, now)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	gm, err := GroupMACs.GetConfig(MustGetLogger())
+	assert.NoError(t, err)
+	assert.Equal(t, []models.NamedMAC{{MAC: "DE-AD-BE-EF-00-01", AssignedAt: now, Guest: true, LastSeen: now}}, gm.Groups["guests"])
+	assert.Len(t, gm.UnusedMACs, 2, "expected the unused MACs to be kept")
+
+	changed, err = GroupMACs.UpdateGuests(MustGetLogger(), seen, now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.False(t, changed, "expected the config not to be saved for every scan")
+
+	later := now.Add(2 * time.Hour)
+	changed, err = GroupMACs.UpdateGuests(MustGetLogger(), seen, later)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	gm, _ = GroupMACs.GetConfig(MustGetLogger())
+	assert.Equal(t, later, gm.Groups["guests"][0].LastSeen)
+
+	// Expect a guest to be removed once it hasn't been seen for the expiry.
+	changed, err = GroupMACs.UpdateGuests(MustGetLogger(), nil, later.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	changed, err = GroupMACs.UpdateGuests(MustGetLogger(), nil, later.Add(25*time.Hour))
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	gm, _ = GroupMACs.GetConfig(MustGetLogger())
+	assert.Empty(t, gm.Groups["guests"])
+	assert.Len(t, gm.Groups["group1"], 2, "expected devices the user added to be kept")
+}
+
+func TestSaveGroupMACs_Guests(t *testing.T) {
+	setupConfig(t)
+	origGuests := AppCfg.GuestConfig
+	t.Cleanup(func() { AppCfg.GuestConfig = origGuests })
+	AppCfg.GuestConfig = GuestConfig{Enabled: true, Group: "guests", Expiry: 24 * time.Hour}
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	_, err := GroupMACs.UpdateGuests(MustGetLogger(), []models.MAC{"DE-AD-BE-EF-00-01", "DE-AD-BE-EF-00-02", "DE-AD-BE-EF-00-03"}, now)
+	assert.NoError(t, err)
+
+	assert.NoError(t, GroupMACs.SaveGroupMACs(MustGetLogger(), []FlatGroupMAC{
+		{Group: "guests", MAC: "DE-AD-BE-EF-00-01", Name: "visitor"},
+		{Group: "kids", MAC: "DE-AD-BE-EF-00-02"},
+		{MAC: "DE-AD-BE-EF-00-03"},
+		{MAC: "DE-AD-BE-EF-00-04"},
+	}))
+	gm, err := GroupMACs.GetConfig(MustGetLogger())
+	assert.NoError(t, err)
+	assert.Equal(t, []models.NamedMAC{{MAC: "DE-AD-BE-EF-00-01", Name: "visitor", AssignedAt: now, Guest: true, LastSeen: now}}, gm.Groups["guests"],
+		"expected a guest to stay a guest")
+	assert.False(t, gm.Groups["kids"][0].Guest, "expected a guest moved to another group to stop being a guest")
+	assert.Equal(t, []models.NamedMAC{{MAC: "DE-AD-BE-EF-00-03"}}, gm.UnusedMACs, "expected only the guest taken out of its group to be kept out of groups")
+
+	changed, err := GroupMACs.UpdateGuests(MustGetLogger(), []models.MAC{"DE-AD-BE-EF-00-03"}, now)
+	assert.NoError(t, err)
+	assert.False(t, changed, "expected a guest taken out of its group not to be made a guest again")
+}
@@ -1,44 +1,164 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"maps"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"relloyd/tubetimeout/models"
 )
 
-var defaultLogger *zap.SugaredLogger
+var (
+	defaultLogger *zap.SugaredLogger
+	// Logging holds the levels of the default logger, overall and for the named loggers of packages, so that they can
+	// be changed at runtime.
+	Logging            = newLogLevels()
+	ErrInvalidLogLevel = errors.New("invalid log level")
+)
 
 func MustGetLogger() *zap.SugaredLogger {
 	if defaultLogger != nil {
 		return defaultLogger
 	}
 
-	c := zap.NewDevelopmentConfig()
-	c.DisableStacktrace = true // Disable stack traces
+	Logging.apply(AppCfg.LogLevel, AppCfg.LogConfig.Levels)
 
-	if AppCfg.LogLevel == "debug" {
-		c.Level = zap.NewAtomicLevelAt(zap.DebugLevel) // Set log level to DEBUG
-	} else if AppCfg.LogLevel == "info" {
-		c.Level = zap.NewAtomicLevelAt(zap.InfoLevel) // Set log level to INFO
-	} else if AppCfg.LogLevel == "warn" {
-		c.Level = zap.NewAtomicLevelAt(zap.WarnLevel) // Set log level to WARN
-	} else if AppCfg.LogLevel == "error" {
-		c.Level = zap.NewAtomicLevelAt(zap.ErrorLevel) // Set log level to ERROR
-	} else if AppCfg.LogLevel == "dpanic" {
-		c.Level = zap.NewAtomicLevelAt(zap.DPanicLevel) // Set log level to DPANIC
-	} else if AppCfg.LogLevel == "panic" {
-		c.Level = zap.NewAtomicLevelAt(zap.PanicLevel) // Set log level to PANIC
-	} else if AppCfg.LogLevel == "fatal" {
-		c.Level = zap.NewAtomicLevelAt(zap.FatalLevel) // Set log level to FATAL
+	var sinks []zapcore.WriteSyncer
+	var fileErr error
+	if AppCfg.LogConfig.File != "" {
+		f, err := openRotatingFile(AppCfg.LogConfig.File, AppCfg.LogConfig.FileMaxSize, AppCfg.LogConfig.FileMaxBackups)
+		if err == nil {
+			sinks = append(sinks, f)
+		}
+		fileErr = err
 	}
-
-	logger, err := c.Build()
-	if err != nil {
-		fmt.Printf("Failed to create logger: %v", err)
-		os.Exit(1)
+	if AppCfg.LogConfig.Console || len(sinks) == 0 { // fall back to stderr if the file can't be opened...
+		sinks = append(sinks, zapcore.Lock(os.Stderr))
 	}
 
+	// Write everything and let levelCore filter by level, so that it can be changed per package.
+	encoder := zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig())
+	core := zapcore.NewCore(encoder, zapcore.NewMultiWriteSyncer(sinks...), zapcore.DebugLevel)
+	logger := zap.New(&levelCore{Core: core, levels: Logging}, zap.Development(), zap.AddCaller(), zap.ErrorOutput(zapcore.Lock(os.Stderr)))
+
 	defaultLogger = logger.Sugar()
+	if fileErr != nil {
+		defaultLogger.Errorf("Failed to open log file, logging to stderr instead: %v", fileErr)
+	}
 	return defaultLogger
 }
+
+// logLevels is the overall level and the levels of packages, whose loggers are named after them, e.g. nfq.
+type logLevels struct {
+	mu       sync.Mutex // mu guards changes to packages.
+	base     zap.AtomicLevel
+	packages atomic.Pointer[map[string]zapcore.Level] // packages is replaced, not changed, so that it's read lock-free.
+	min      atomic.Int32                             // min is the lowest level of base and packages.
+}
+
+func newLogLevels() *logLevels {
+	l := &logLevels{base: zap.NewAtomicLevel()}
+	l.packages.Store(&map[string]zapcore.Level{})
+	return l
+}
+
+// apply sets the overall level and replaces the package levels, e.g. after the app config is reloaded. Unknown
+// levels are ignored.
+func (l *logLevels) apply(level string, packages map[string]string) {
+	m := make(map[string]zapcore.Level, len(packages))
+	for pkg, level := range packages {
+		if lvl, err := zapcore.ParseLevel(level); err == nil && pkg != "" {
+			m[pkg] = lvl
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if lvl, err := zapcore.ParseLevel(level); err == nil {
+		l.base.SetLevel(lvl)
+	}
+	l.packages.Store(&m)
+	l.updateMin()
+}
+
+// Set changes the level of the package, or the overall level if pkg is empty. An empty level removes the package's
+// own level so that it follows the overall level again.
+func (l *logLevels) Set(pkg, level string) (models.LogLevels, error) {
+	var lvl zapcore.Level
+	if level != "" || pkg == "" {
+		var err error
+		if lvl, err = zapcore.ParseLevel(level); err != nil {
+			return models.LogLevels{}, fmt.Errorf("%w: %q", ErrInvalidLogLevel, level)
+		}
+	}
+	l.mu.Lock()
+	if pkg == "" {
+		l.base.SetLevel(lvl)
+	} else {
+		m := maps.Clone(*l.packages.Load())
+		if level == "" {
+			delete(m, pkg)
+		} else {
+			m[pkg] = lvl
+		}
+		l.packages.Store(&m)
+	}
+	l.updateMin()
+	l.mu.Unlock()
+	return l.State(), nil
+}
+
+// State returns the overall level and the package levels.
+func (l *logLevels) State() models.LogLevels {
+	s := models.LogLevels{Level: l.base.Level().String(), Packages: make(map[string]string)}
+	for pkg, lvl := range *l.packages.Load() {
+		s.Packages[pkg] = lvl.String()
+	}
+	return s
+}
+
+func (l *logLevels) updateMin() {
+	lowest := l.base.Level()
+	for _, lvl := range *l.packages.Load() {
+		lowest = min(lowest, lvl)
+	}
+	l.min.Store(int32(lowest))
+}
+
+// enabled returns true if the level is enabled for the logger name, whose first part is the package.
+func (l *logLevels) enabled(name string, lvl zapcore.Level) bool {
+	if name != "" {
+		pkg, _, _ := strings.Cut(name, ".")
+		if pl, ok := (*l.packages.Load())[pkg]; ok {
+			return lvl >= pl
+		}
+	}
+	return l.base.Enabled(lvl)
+}
+
+// levelCore filters the entries of its core by the level of the logger that wrote them.
+type levelCore struct {
+	zapcore.Core
+	levels *logLevels
+}
+
+// Enabled lets through any level enabled for some package, since Check knows which logger the entry came from.
+func (c *levelCore) Enabled(lvl zapcore.Level) bool {
+	return lvl >= zapcore.Level(c.levels.min.Load())
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), levels: c.levels}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.levels.enabled(ent.LoggerName, ent.Level) {
+		return ce.AddCore(ent, c.Core)
+	}
+	return ce
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"relloyd/tubetimeout/models"
+)
+
+func TestLogLevels(t *testing.T) {
+	levels := newLogLevels()
+	levels.apply("warn", map[string]string{"nfq": "debug", "dhcp": "nonsense"})
+	obs, logs := observer.New(zap.DebugLevel)
+	logger := zap.New(&levelCore{Core: obs, levels: levels}).Sugar()
+
+	logger.Info("dropped")
+	logger.Named("nfq").Debug("kept")
+	logger.Named("nfq").Named("worker").Debug("kept for sub-loggers")
+	logger.Named("dhcp").Info("dropped, as the level is unknown")
+	logger.Named("dhcp").Warn("kept")
+	assert.Equal(t, 3, logs.Len())
+	assert.Equal(t, models.LogLevels{Level: "warn", Packages: map[string]string{"nfq": "debug"}}, levels.State())
+
+	_, err := levels.Set("", "verbose")
+	assert.ErrorIs(t, err, ErrInvalidLogLevel)
+	state, err := levels.Set("nfq", "")
+	require.NoError(t, err)
+	assert.Empty(t, state.Packages, "expected an empty level to remove the package's own level")
+	logger.Named("nfq").Info("dropped")
+	assert.Equal(t, 3, logs.Len())
+
+	_, err = levels.Set("", "debug")
+	require.NoError(t, err)
+	logger.With("key", "value").Debug("kept")
+	assert.Equal(t, 4, logs.Len())
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "tubetimeout.log")
+	f, err := openRotatingFile(path, 1, 2)
+	require.NoError(t, err)
+	f.maxSize = 10 // rotate after 10 bytes rather than 1 MB.
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err = f.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Sync())
+	for name, want := range map[string]string{path: "fourth\n", path + ".1": "third\n", path + ".2": "second\n"} {
+		b, err := os.ReadFile(name)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(b), name)
+	}
+	_, err = os.Stat(path + ".3")
+	assert.True(t, os.IsNotExist(err), "expected only two old files to be kept")
+
+	// Expect an existing file to be appended to.
+	f, err = openRotatingFile(path, 1, 2)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("fifth\n"))
+	require.NoError(t, err)
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(b), "fourth\nfifth\n"))
+}
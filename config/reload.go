@@ -0,0 +1,123 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"go.uber.org/zap"
+)
+
+var (
+	// EnvFileName is an optional file of KEY=VALUE lines in the app home directory that is applied to the
+	// environment before the app config is reloaded. The systemd unit loads the same file at startup.
+	EnvFileName = "tubetimeout.env"
+)
+
+// current is the app config as last loaded or reloaded. It starts as AppCfg and each reload stores a new one, so
+// that the config a caller holds is never changed under it.
+var current atomic.Pointer[AppConfig]
+
+func init() {
+	current.Store(&AppCfg)
+}
+
+// Current returns the app config as last loaded or reloaded, for the settings that can be reloaded. It's shared, so
+// it mustn't be changed.
+func Current() *AppConfig {
+	return current.Load()
+}
+
+// ReloadAppConfig re-reads the app config from the environment after applying the optional env file, and makes it
+// the one that Current returns.
+// Settings tagged reload:"startup" are only read at startup, so they keep their current values and a warning is
+// logged for each one that differs, since they need a full restart to take effect.
+func ReloadAppConfig(logger *zap.SugaredLogger) error {
+	envFile, err := DefaultStore.Path(EnvFileName)
+	if err != nil {
+		return fmt.Errorf("failed to get env file path: %w", err)
+	}
+	if err = loadEnvFile(envFile); err != nil {
+		return err
+	}
+
+	var newCfg AppConfig
+	if err = envconfig.Process("", &newCfg); err != nil {
+		return fmt.Errorf("failed to process app config: %w", err)
+	}
+
+	for _, name := range keepStartupSettings(Current(), &newCfg) {
+		logger.Warnf("App config %v has changed but requires a restart to take effect", name)
+	}
+
+	current.Store(&newCfg)
+	Logging.apply(newCfg.LogLevel, newCfg.LogConfig.Levels)
+	logger.Info("App config reloaded")
+	return nil
+}
+
+// loadEnvFile sets environment variables from the KEY=VALUE lines in filePath.
+// Blank lines and lines starting with # are ignored. A missing file is not an error.
+func loadEnvFile(filePath string) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read env file: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return fmt.Errorf("invalid env file line %d: %q", lineNum, line)
+		}
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		if err = os.Setenv(strings.TrimSpace(key), value); err != nil {
+			return fmt.Errorf("failed to set env var on line %d: %w", lineNum, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// keepStartupSettings copies the settings tagged reload:"startup" from cur into next and returns the env var names
+// of those that differed. Every setting in a tagged struct is kept.
+func keepStartupSettings(cur, next *AppConfig) []string {
+	var changed []string
+	keepSettings(&changed, "", reflect.ValueOf(cur).Elem(), reflect.ValueOf(next).Elem(), false)
+	return changed
+}
+
+func keepSettings(changed *[]string, prefix string, cur, next reflect.Value, startup bool) {
+	for i := range cur.NumField() {
+		f := cur.Type().Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := f.Tag.Get("envconfig")
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		keep := startup || f.Tag.Get("reload") == "startup"
+		if f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeOf(time.Time{}) { // if this is a group of settings...
+			keepSettings(changed, name, cur.Field(i), next.Field(i), keep)
+		} else if keep && !reflect.DeepEqual(cur.Field(i).Interface(), next.Field(i).Interface()) {
+			*changed = append(*changed, name)
+			next.Field(i).Set(cur.Field(i))
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadEnvFile(t *testing.T) {
+	tempDir := t.TempDir()
+	envFile := filepath.Join(tempDir, "test.env")
+
+	// Missing file is not an error.
+	assert.NoError(t, loadEnvFile(envFile))
+
+	content := "# comment\n\nTT_TEST_A=1\nexport TT_TEST_B = \"two\"\n"
+	assert.NoError(t, os.WriteFile(envFile, []byte(content), 0644))
+	t.Cleanup(func() {
+		_ = os.Unsetenv("TT_TEST_A")
+		_ = os.Unsetenv("TT_TEST_B")
+	})
+
+	assert.NoError(t, loadEnvFile(envFile))
+	assert.Equal(t, "1", os.Getenv("TT_TEST_A"))
+	assert.Equal(t, "two", os.Getenv("TT_TEST_B"))
+
+	// Bad lines are reported.
+	assert.NoError(t, os.WriteFile(envFile, []byte("NOT_A_SETTING\n"), 0644))
+	assert.Error(t, loadEnvFile(envFile))
+}
+
+func TestKeepStartupSettings(t *testing.T) {
+	cur := AppConfig{LogLevel: "info"}
+	cur.WebConfig.WebPort = 80
+	cur.FilterConfig.PacketDropPercentage = 0.4
+	cur.FilterConfig.BypassPorts = []uint16{53}
+	cur.RouterConfig.Password = "secret"
+	cur.TrackerConfig.SampleFilePath = "samples.json"
+
+	next := cur
+	next.LogLevel = "debug"
+	next.WebConfig.WebPort = 8080
+	next.FilterConfig.PacketDropPercentage = 0.5
+	next.FilterConfig.BypassPorts = []uint16{53, 853}
+	next.RouterConfig.Password = "changed"
+	next.TrackerConfig.SampleFilePath = "other.json"
+	next.TrackerConfig.Threshold = time.Hour
+
+	changed := keepStartupSettings(&cur, &next)
+
+	assert.Equal(t, []string{"FILTER_BYPASS_PORTS", "WEB_PORT", "TRACKER_FILE_PATH", "ROUTER_PASSWORD"}, changed)
+	assert.Equal(t, 80, next.WebConfig.WebPort, "expected startup-only setting to be kept")
+	assert.Equal(t, []uint16{53}, next.FilterConfig.BypassPorts, "expected startup-only setting to be kept")
+	assert.Equal(t, "secret", next.RouterConfig.Password, "expected the settings of a startup-only struct to be kept")
+	assert.Equal(t, "samples.json", next.TrackerConfig.SampleFilePath)
+	assert.Equal(t, "debug", next.LogLevel, "expected runtime setting to be reloaded")
+	assert.Equal(t, float32(0.5), next.FilterConfig.PacketDropPercentage, "expected runtime setting to be reloaded")
+	assert.Equal(t, time.Hour, next.TrackerConfig.Threshold, "expected runtime setting to be reloaded")
+}
+
+func TestReloadAppConfig(t *testing.T) {
+	origStore := DefaultStore
+	t.Cleanup(func() {
+		DefaultStore = origStore
+		current.Store(&AppCfg)
+		Logging.apply(AppCfg.LogLevel, AppCfg.LogConfig.Levels)
+	})
+	dir := t.TempDir()
+	envFile := filepath.Join(dir, EnvFileName)
+	DefaultStore = NewStore(dir)
+	require.NoError(t, os.WriteFile(envFile, []byte("FILTER_PACKET_DROP_PCT=0.55\nWEB_PORT=8081\n"), 0644))
+	t.Cleanup(func() {
+		_ = os.Unsetenv("FILTER_PACKET_DROP_PCT")
+		_ = os.Unsetenv("WEB_PORT")
+	})
+	before := Current()
+	held := *before
+	done := make(chan struct{})
+	go func() { // read the config while it's reloaded, as the filter does, for the race detector.
+		defer close(done)
+		for range 100 {
+			_ = Current().FilterConfig.PacketDropPercentage
+		}
+	}()
+
+	require.NoError(t, ReloadAppConfig(MustGetLogger()))
+	<-done
+
+	assert.Equal(t, float32(0.55), Current().FilterConfig.PacketDropPercentage, "expected runtime setting to be reloaded")
+	assert.Equal(t, before.WebConfig.WebPort, Current().WebConfig.WebPort, "expected startup-only setting to be kept")
+	assert.Equal(t, held, *before, "expected the config that callers already hold not to change")
+}
@@ -0,0 +1,399 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/models"
+)
+
+const (
+	snapshotPrefix     = "tubetimeout-snapshot-"
+	snapshotSuffix     = ".tar.gz"
+	snapshotTimeFormat = "20060102-150405"
+	snapshotCatchUp    = time.Minute // snapshotCatchUp is the delay after startup before a missed snapshot is taken.
+)
+
+var (
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	// snapshotHTTPClient uploads snapshots to an S3 target.
+	snapshotHTTPClient HTTPClient = &http.Client{Timeout: 2 * time.Minute}
+)
+
+// SnapshotInfo describes a snapshot kept in the snapshot directory.
+type SnapshotInfo struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+}
+
+// Snapshotter takes a backup archive of every registered file once a day, keeping the latest few in a local
+// directory and optionally copying each to a target off the SD card, so that a corrupt card can be recovered from
+// without having remembered to download a backup.
+type Snapshotter struct {
+	logger    *zap.SugaredLogger
+	cfg       *SnapshotConfig
+	backups   *backups
+	dir       string
+	at        time.Duration // at is the time of day the snapshots are taken, since midnight.
+	mu        sync.Mutex    // mu serialises snapshots and restores, and guards the fields below.
+	last      SnapshotInfo
+	lastErr   error
+	targetErr error
+	nowFunc   func() time.Time
+}
+
+// NewSnapshotter checks the config and creates the snapshot directory. Call Start to take the snapshots.
+func NewSnapshotter(logger *zap.SugaredLogger, cfg *SnapshotConfig) (*Snapshotter, error) {
+	s := &Snapshotter{logger: logger, cfg: cfg, backups: Backups, nowFunc: time.Now}
+	t, err := time.Parse("15:04", cfg.Time)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot time must be HH:MM, got %q", cfg.Time)
+	}
+	s.at = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if cfg.Keep < 1 {
+		return nil, fmt.Errorf("snapshots kept must be at least 1, got %v", cfg.Keep)
+	}
+	if cfg.Target != "" && strings.HasPrefix(cfg.Target, "s3://") {
+		if _, _, err = s3Location(cfg.Target); err != nil {
+			return nil, err
+		}
+	}
+	s.dir = cfg.Dir
+	if !filepath.IsAbs(s.dir) {
+		if s.dir, err = DefaultStore.Path(cfg.Dir); err != nil {
+			return nil, fmt.Errorf("failed to get snapshot directory: %w", err)
+		}
+	}
+	if err = os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	if list, err := s.Snapshots(); err == nil && len(list) > 0 {
+		s.last = list[0]
+	}
+	return s, nil
+}
+
+// Start takes a snapshot at the configured time each day until ctx is done. If the latest snapshot is over a day
+// old, e.g. because the gateway was off at the time, one is taken shortly after starting instead.
+func (s *Snapshotter) Start(ctx context.Context) {
+	go func() {
+		now := s.nowFunc()
+		next := nextSnapshot(now, s.at)
+		if s.latest().Created.Before(now.Add(-24 * time.Hour)) {
+			next = now.Add(snapshotCatchUp)
+		}
+		for {
+			timer := time.NewTimer(next.Sub(s.nowFunc()))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				if _, err := s.Snapshot(); err != nil {
+					s.logger.Errorf("Failed to take the nightly snapshot: %v", err)
+				}
+				next = nextSnapshot(s.nowFunc(), s.at)
+			}
+		}
+	}()
+}
+
+// Snapshot takes a snapshot now and deletes the oldest past the number kept. The snapshot is kept even if copying it
+// to the target fails, which is only logged and reported by Health.
+func (s *Snapshotter) Snapshot() (SnapshotInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, err := s.snapshot()
+	s.lastErr = err
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	s.last = info
+	return info, nil
+}
+
+// snapshot should be called under s.mu.
+func (s *Snapshotter) snapshot() (SnapshotInfo, error) {
+	var buf bytes.Buffer
+	if err := s.backups.WriteBackup(&buf); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	now := s.nowFunc()
+	info := SnapshotInfo{Name: snapshotPrefix + now.Format(snapshotTimeFormat) + snapshotSuffix, Size: int64(buf.Len()), Created: now}
+	p := filepath.Join(s.dir, info.Name)
+	if err := writeSynced(p+restoreStagingSuffix, buf.Bytes()); err != nil {
+		_ = os.Remove(p + restoreStagingSuffix)
+		return SnapshotInfo{}, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	if err := os.Rename(p+restoreStagingSuffix, p); err != nil {
+		return SnapshotInfo{}, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	if err := rotateSnapshots(s.dir, s.cfg.Keep); err != nil {
+		s.logger.Warnf("Failed to delete old snapshots: %v", err)
+	}
+	s.logger.Infof("Took snapshot %v of %v bytes", info.Name, info.Size)
+
+	s.targetErr = nil
+	if s.cfg.Target != "" {
+		if s.targetErr = s.copyToTarget(info.Name, buf.Bytes()); s.targetErr != nil {
+			s.logger.Errorf("Failed to copy snapshot %v to %v: %v", info.Name, s.cfg.Target, s.targetErr)
+		}
+	}
+	return info, nil
+}
+
+// copyToTarget uploads the snapshot to an S3 target, or copies it to a directory target such as a mounted SMB or
+// NFS share, where the oldest past the number kept are deleted too.
+func (s *Snapshotter) copyToTarget(name string, data []byte) error {
+	if strings.HasPrefix(s.cfg.Target, "s3://") {
+		return s.putS3(name, data)
+	}
+	if err := writeSynced(filepath.Join(s.cfg.Target, name), data); err != nil {
+		return err
+	}
+	return rotateSnapshots(s.cfg.Target, s.cfg.Keep)
+}
+
+// Snapshots returns the snapshots in the snapshot directory, newest first.
+func (s *Snapshotter) Snapshots() ([]SnapshotInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+	var result []SnapshotInfo
+	for _, e := range entries {
+		created, ok := snapshotTime(e.Name())
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		result = append(result, SnapshotInfo{Name: e.Name(), Size: fi.Size(), Created: created})
+	}
+	slices.SortFunc(result, func(a, b SnapshotInfo) int { return strings.Compare(b.Name, a.Name) })
+	return result, nil
+}
+
+// OpenSnapshot opens the named snapshot in the snapshot directory, e.g. to download it. An error wrapping
+// ErrSnapshotNotFound is returned if there isn't one by that name.
+func (s *Snapshotter) OpenSnapshot(name string) (io.ReadCloser, error) {
+	if _, ok := snapshotTime(name); !ok || filepath.Base(name) != name {
+		return nil, fmt.Errorf("%w: %q", ErrSnapshotNotFound, name)
+	}
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %q", ErrSnapshotNotFound, name)
+	}
+	return f, err
+}
+
+// RestoreSnapshot restores the files in the named snapshot as RestoreBackup does, after taking a snapshot of the
+// current files so that the restore can be undone. The app must be restarted to load the restored config.
+func (s *Snapshotter) RestoreSnapshot(name string) ([]string, error) {
+	f, err := s.OpenSnapshot(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(f, 64*maxBackupFileSize))
+	_ = f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %v: %w", name, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err = s.backups.readBackup(bytes.NewReader(data)); err != nil { // check it before taking another snapshot...
+		return nil, err
+	}
+	info, err := s.snapshot()
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot the current files before restoring: %w", err)
+	}
+	s.last = info
+	return s.backups.RestoreBackup(bytes.NewReader(data))
+}
+
+// Health reports the snapshots as degraded if the last one failed or couldn't be copied to the target.
+func (s *Snapshotter) Health() models.SubsystemHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	details := map[string]any{"last": s.last.Name, "time": s.cfg.Time, "keep": s.cfg.Keep}
+	if !s.last.Created.IsZero() {
+		details["lastCreated"] = s.last.Created
+	}
+	if s.cfg.Target != "" {
+		details["target"] = redactTarget(s.cfg.Target)
+	}
+	h := models.SubsystemHealth{Name: "snapshots", Status: models.HealthOK, Details: details}
+	if s.lastErr != nil {
+		h.Status = models.HealthDegraded
+		h.Message = fmt.Sprintf("the last snapshot failed: %v", s.lastErr)
+	} else if s.targetErr != nil {
+		h.Status = models.HealthDegraded
+		h.Message = fmt.Sprintf("the last snapshot wasn't copied to the target: %v", s.targetErr)
+	}
+	return h
+}
+
+func (s *Snapshotter) latest() SnapshotInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.last
+}
+
+// putS3 uploads the snapshot with a request signed by AWS Signature Version 4, using path-style URLs so that
+// S3-compatible services such as MinIO work too.
+func (s *Snapshotter) putS3(name string, data []byte) error {
+	bucket, prefix, err := s3Location(s.cfg.Target)
+	if err != nil {
+		return err
+	}
+	scheme, host := "https", "s3."+s.cfg.S3Region+".amazonaws.com"
+	if e := s.cfg.S3Endpoint; e != "" {
+		if before, after, ok := strings.Cut(e, "://"); ok {
+			scheme, host = before, after
+		} else {
+			host = e
+		}
+		host = strings.TrimSuffix(host, "/")
+	}
+	u := &url.URL{Scheme: scheme, Host: host, Path: "/" + path.Join(bucket, prefix, name)}
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create S3 request: %w", err)
+	}
+	signS3(req, data, s.cfg.S3Region, s.cfg.S3AccessKey, s.cfg.S3SecretKey, s.nowFunc())
+	resp, err := snapshotHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload to S3: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("S3 upload failed with status %v: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// signS3 adds the headers of AWS Signature Version 4 to the request for the payload.
+func signS3(req *http.Request, payload []byte, region, accessKey, secretKey string, now time.Time) {
+	now = now.UTC()
+	amzDate, date := now.Format("20060102T150405Z"), now.Format("20060102")
+	payloadHash := sha256Hex(payload)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonical))
+	signature := hex.EncodeToString(hmacSHA256(signingKey(secretKey, date, region, "s3"), toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v", accessKey, scope, signedHeaders, signature))
+}
+
+func signingKey(secretKey, date, region, service string) []byte {
+	k := hmacSHA256([]byte("AWS4"+secretKey), date)
+	k = hmacSHA256(k, region)
+	k = hmacSHA256(k, service)
+	return hmacSHA256(k, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(data))
+	return m.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Location returns the bucket and key prefix of an s3://bucket/prefix URL.
+func s3Location(target string) (bucket, prefix string, err error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return "", "", fmt.Errorf("snapshot target must be a directory or s3://bucket/prefix, got %q", redactTarget(target))
+	}
+	return u.Host, strings.Trim(u.Path, "/"), nil
+}
+
+// redactTarget drops any credentials from a target URL before it's shown.
+func redactTarget(target string) string {
+	if u, err := url.Parse(target); err == nil && u.User != nil {
+		u.User = nil
+		return u.String()
+	}
+	return target
+}
+
+// rotateSnapshots deletes the oldest snapshots in dir past the number to keep.
+func rotateSnapshots(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, e := range entries {
+		if _, ok := snapshotTime(e.Name()); ok && e.Type().IsRegular() {
+			names = append(names, e.Name())
+		}
+	}
+	slices.Sort(names) // the timestamps sort oldest first.
+	var errs []error
+	for i := 0; i < len(names)-keep; i++ {
+		if err = os.Remove(filepath.Join(dir, names[i])); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// snapshotTime returns the time in a snapshot's file name, and false if it isn't the name of a snapshot.
+func snapshotTime(name string) (time.Time, bool) {
+	ts, ok := strings.CutPrefix(name, snapshotPrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	if ts, ok = strings.CutSuffix(ts, snapshotSuffix); !ok {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(snapshotTimeFormat, ts, time.Local)
+	return t, err == nil
+}
+
+// nextSnapshot returns the first time of day at after now, in local time.
+func nextSnapshot(now time.Time, at time.Duration) time.Time {
+	local := now.In(time.Local)
+	next := time.Date(local.Year(), local.Month(), local.Day(), int(at/time.Hour), int(at%time.Hour/time.Minute), 0, 0, time.Local)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
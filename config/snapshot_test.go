@@ -0,0 +1,148 @@
+package config
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"relloyd/tubetimeout/models"
+)
+
+func newTestSnapshotter(t *testing.T, cfg *SnapshotConfig) (string, *Snapshotter) {
+	t.Helper()
+	dir, b := setupBackupDir(t)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "group-macs.yaml"), []byte("groups: {}\n"), 0644))
+	s, err := NewSnapshotter(MustGetLogger(), cfg)
+	require.NoError(t, err)
+	s.backups = b
+	return dir, s
+}
+
+func TestNewSnapshotter_InvalidConfig(t *testing.T) {
+	setupBackupDir(t)
+	for _, cfg := range []SnapshotConfig{
+		{Time: "3am", Dir: "snapshots", Keep: 7},
+		{Time: "03:00", Dir: "snapshots", Keep: 0},
+		{Time: "03:00", Dir: "snapshots", Keep: 7, Target: "s3:///prefix"},
+	} {
+		_, err := NewSnapshotter(MustGetLogger(), &cfg)
+		assert.Error(t, err, "expected config %+v to be rejected", cfg)
+	}
+}
+
+func TestSnapshotter_SnapshotRotatesAndCopies(t *testing.T) {
+	target := t.TempDir()
+	dir, s := newTestSnapshotter(t, &SnapshotConfig{Time: "03:00", Dir: "snapshots", Keep: 2, Target: target})
+	now := time.Date(2024, 3, 1, 3, 0, 0, 0, time.Local)
+	s.nowFunc = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		_, err := s.Snapshot()
+		require.NoError(t, err)
+		now = now.Add(24 * time.Hour)
+	}
+
+	list, err := s.Snapshots()
+	require.NoError(t, err)
+	require.Len(t, list, 2, "expected the oldest snapshot to be deleted")
+	assert.Equal(t, "tubetimeout-snapshot-20240303-030000.tar.gz", list[0].Name)
+	assert.Equal(t, "tubetimeout-snapshot-20240302-030000.tar.gz", list[1].Name)
+	assert.Equal(t, time.Date(2024, 3, 3, 3, 0, 0, 0, time.Local), list[0].Created)
+
+	copied, err := os.ReadDir(target)
+	require.NoError(t, err)
+	assert.Len(t, copied, 2, "expected the target to be rotated too")
+	assert.DirExists(t, filepath.Join(dir, "snapshots"))
+
+	h := s.Health()
+	assert.Equal(t, models.HealthOK, h.Status)
+}
+
+func TestSnapshotter_TargetFailureIsDegraded(t *testing.T) {
+	_, s := newTestSnapshotter(t, &SnapshotConfig{Time: "03:00", Dir: "snapshots", Keep: 2, Target: filepath.Join(t.TempDir(), "missing")})
+	_, err := s.Snapshot()
+	assert.NoError(t, err, "expected the local snapshot to succeed")
+	list, _ := s.Snapshots()
+	assert.Len(t, list, 1)
+	assert.Equal(t, models.HealthDegraded, s.Health().Status)
+}
+
+func TestSnapshotter_RestoreSnapshot(t *testing.T) {
+	dir, s := newTestSnapshotter(t, &SnapshotConfig{Time: "03:00", Dir: "snapshots", Keep: 7})
+	now := time.Date(2024, 3, 1, 3, 0, 0, 0, time.Local)
+	s.nowFunc = func() time.Time { return now }
+	info, err := s.Snapshot()
+	require.NoError(t, err)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "group-macs.yaml"), []byte("groups: {kids: []}\n"), 0644))
+	now = now.Add(time.Hour)
+	restored, err := s.RestoreSnapshot(info.Name)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"group-macs.yaml"}, restored)
+
+	data, err := os.ReadFile(filepath.Join(dir, "group-macs.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, "groups: {}\n", string(data))
+	list, _ := s.Snapshots()
+	assert.Len(t, list, 2, "expected the files to be snapshotted before being restored")
+
+	for _, name := range []string{"missing.tar.gz", "../group-macs.yaml", "tubetimeout-snapshot-20000101-000000.tar.gz"} {
+		_, err = s.RestoreSnapshot(name)
+		assert.True(t, errors.Is(err, ErrSnapshotNotFound), "expected %q not to be found, got %v", name, err)
+	}
+}
+
+func TestNextSnapshot(t *testing.T) {
+	at := 3 * time.Hour
+	assert.Equal(t, time.Date(2024, 3, 1, 3, 0, 0, 0, time.Local), nextSnapshot(time.Date(2024, 3, 1, 2, 59, 0, 0, time.Local), at))
+	assert.Equal(t, time.Date(2024, 3, 2, 3, 0, 0, 0, time.Local), nextSnapshot(time.Date(2024, 3, 1, 3, 0, 0, 0, time.Local), at))
+}
+
+func TestSigningKey(t *testing.T) {
+	// The example from the AWS Signature Version 4 documentation.
+	k := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	assert.Equal(t, "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d", hex.EncodeToString(k))
+}
+
+type mockSnapshotClient struct {
+	req  *http.Request
+	body []byte
+}
+
+func (m *mockSnapshotClient) Do(req *http.Request) (*http.Response, error) {
+	m.req = req
+	m.body, _ = io.ReadAll(req.Body)
+	return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(bytes.NewReader(nil))}, nil
+}
+
+func TestSnapshotter_PutS3(t *testing.T) {
+	client := &mockSnapshotClient{}
+	orig := snapshotHTTPClient
+	snapshotHTTPClient = client
+	t.Cleanup(func() { snapshotHTTPClient = orig })
+
+	_, s := newTestSnapshotter(t, &SnapshotConfig{Time: "03:00", Dir: "snapshots", Keep: 7, Target: "s3://backups/gateway/",
+		S3Endpoint: "http://minio.lan:9000", S3Region: "eu-west-2", S3AccessKey: "AKID", S3SecretKey: "secret"})
+	s.nowFunc = func() time.Time { return time.Date(2024, 3, 1, 3, 0, 0, 0, time.UTC) }
+	info, err := s.Snapshot()
+	require.NoError(t, err)
+	assert.Equal(t, models.HealthOK, s.Health().Status)
+
+	require.NotNil(t, client.req)
+	assert.Equal(t, http.MethodPut, client.req.Method)
+	assert.Equal(t, "http://minio.lan:9000/backups/gateway/"+info.Name, client.req.URL.String())
+	assert.Equal(t, "20240301T030000Z", client.req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, sha256Hex(client.body), client.req.Header.Get("X-Amz-Content-Sha256"))
+	assert.True(t, strings.HasPrefix(client.req.Header.Get("Authorization"),
+		"AWS4-HMAC-SHA256 Credential=AKID/20240301/eu-west-2/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature="))
+	assert.Equal(t, info.Size, int64(len(client.body)))
+}
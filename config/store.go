@@ -0,0 +1,94 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultStore keeps the app's files in AppHomeDir in the user's home directory.
+var DefaultStore = NewStore("")
+
+// Store is a directory that the app's config and data files are kept in. Each file has a lock of its own, so two
+// writers of the same file can't clash over its temp file, and stores share nothing, so tests and other binaries that
+// embed the packages can each use their own directory.
+type Store struct {
+	dir   string                        // dir is where the files are kept, or the app home dir if blank.
+	write func(path, data string) error // write writes a whole file, see SetWriter.
+
+	mu      sync.Mutex
+	created bool                   // created is true once the directory exists, guarded by mu.
+	locks   map[string]*sync.Mutex // locks are the locks of the files by path, guarded by mu.
+}
+
+// NewStore returns a store of the files in dir, which is created when a file's path is first needed. The files are
+// kept in AppHomeDir in the user's home directory if dir is blank.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir, write: SafeWriteViaTemp, locks: make(map[string]*sync.Mutex)}
+}
+
+// SetWriter replaces how the store writes files, e.g. so tests can make writes fail. Files are still written one at
+// a time.
+func (s *Store) SetWriter(fn func(path, data string) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.write = fn
+}
+
+// Dir returns the store's directory, creating it the first time.
+func (s *Store) Dir() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ensureDir()
+}
+
+// ensureDir returns the store's directory, creating it if it hasn't been already. It should be called under s.mu.
+func (s *Store) ensureDir() (string, error) {
+	dir := s.dir
+	if dir == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %w", err)
+		}
+		dir = filepath.Join(homeDir, AppHomeDir)
+	}
+	if !s.created {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", fmt.Errorf("failed to create app directory: %v", err)
+		}
+		s.created = true
+	}
+	return dir, nil
+}
+
+// Path returns the path of the named file in the store, creating the store's directory if needed. Names that are
+// absolute paths are returned as they are.
+func (s *Store) Path(name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dir, err := s.ensureDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// WriteFile replaces the file at path with data, waiting for any other write of the file to finish first.
+func (s *Store) WriteFile(path, data string) error {
+	s.mu.Lock()
+	l, ok := s.locks[path]
+	if !ok {
+		l = &sync.Mutex{}
+		s.locks[path] = l
+	}
+	write := s.write
+	s.mu.Unlock()
+
+	l.Lock()
+	defer l.Unlock()
+	return write(path, data)
+}
@@ -0,0 +1,260 @@
+// Package control makes the changes to device groups, tracker config and modes that the web and gRPC APIs offer, so
+// that both APIs validate, save and audit them the same way and store the same config for the same request.
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/audit"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+var ErrInvalidChange = errors.New("invalid change")
+
+// UsageTracker saves the tracker config and modes of the groups.
+type UsageTracker interface {
+	GetConfig() (models.MapGroupTrackerConfig, error)
+	SetConfig(m models.MapGroupTrackerConfig) error
+	GetModeEndTime(id string) (models.TrackerMode, error)
+	SetMode(id string, d time.Duration, mode models.UsageTrackerMode) error
+}
+
+// GroupMACs loads and saves the devices in each group.
+type GroupMACs interface {
+	GetAllGroupMACs(logger *zap.SugaredLogger) ([]config.FlatGroupMAC, error)
+	SaveGroupMACs(logger *zap.SugaredLogger, flatGroupMACs []config.FlatGroupMAC) error
+}
+
+// AuditLog records the changes.
+type AuditLog interface {
+	Record(e audit.Entry) error
+}
+
+// Origin is where a change came from, for the audit log.
+type Origin struct {
+	SourceIP string
+	Actor    string // Actor is the name and ID of the API key used, if any.
+}
+
+// Service makes the changes and records them in the audit log.
+type Service struct {
+	logger    *zap.SugaredLogger
+	tracker   UsageTracker
+	groupMACs GroupMACs
+	auditLog  AuditLog
+}
+
+func NewService(logger *zap.SugaredLogger, ut UsageTracker, gm GroupMACs, al AuditLog) *Service {
+	return &Service{logger: logger, tracker: ut, groupMACs: gm, auditLog: al}
+}
+
+// audit records a change with the value before it was made, which should be a snapshot taken beforehand.
+func (s *Service) audit(o Origin, action, target string, before json.RawMessage, after any) {
+	err := s.auditLog.Record(audit.Entry{
+		SourceIP: o.SourceIP,
+		Actor:    o.Actor,
+		Action:   action,
+		Target:   target,
+		Before:   before,
+		After:    audit.Snapshot(after),
+	})
+	if err != nil {
+		s.logger.Errorf("Error recording audit entry for %v: %v", action, err)
+	}
+}
+
+// SaveGroupMACs replaces the devices in every group.
+func (s *Service) SaveGroupMACs(o Origin, gm []config.FlatGroupMAC) error {
+	before, _ := s.groupMACs.GetAllGroupMACs(s.logger)
+	if err := s.groupMACs.SaveGroupMACs(s.logger, gm); err != nil {
+		return fmt.Errorf("failed to save device groups: %w", err)
+	}
+	s.audit(o, "groupMACs.save", "", audit.Snapshot(before), gm)
+	return nil
+}
+
+// SetDeviceGroup moves the device to the group, or out of its group if group is empty, keeping the rest of the
+// devices as they are. The device's name is replaced if name isn't nil.
+func (s *Service) SetDeviceGroup(o Origin, mac, group string, name *string) (config.FlatGroupMAC, error) {
+	if _, err := net.ParseMAC(strings.ReplaceAll(mac, "-", ":")); err != nil {
+		return config.FlatGroupMAC{}, fmt.Errorf("%w: the MAC %q isn't valid", ErrInvalidChange, mac)
+	}
+	mac = models.NewMAC(mac)
+	before, err := s.groupMACs.GetAllGroupMACs(s.logger)
+	if err != nil {
+		return config.FlatGroupMAC{}, fmt.Errorf("failed to get device groups: %w", err)
+	}
+	after := slices.Clone(before)
+	i := slices.IndexFunc(after, func(d config.FlatGroupMAC) bool { return d.MAC == mac })
+	if i < 0 { // if the device hasn't been seen...
+		after = append(after, config.FlatGroupMAC{MAC: mac})
+		i = len(after) - 1
+	}
+	after[i].Group = models.NewGroup(group)
+	if name != nil {
+		after[i].Name = *name
+	}
+	if err = s.groupMACs.SaveGroupMACs(s.logger, after); err != nil {
+		return config.FlatGroupMAC{}, fmt.Errorf("failed to save device groups: %w", err)
+	}
+	s.audit(o, "groupMACs.save", mac, audit.Snapshot(before), after)
+	return after[i], nil
+}
+
+// SaveTrackerConfig replaces the tracker config of every group. Groups without a name are skipped.
+func (s *Service) SaveTrackerConfig(o Origin, flat []models.FlatTrackerConfig) error {
+	before, err := s.tracker.GetConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get tracker config: %w", err)
+	}
+	after := make(models.MapGroupTrackerConfig, len(flat))
+	for _, v := range flat {
+		if v.Group == "" {
+			continue
+		}
+		after[v.Group] = fromFlat(v, before[v.Group])
+	}
+	return s.saveTrackerConfig(o, "", before, after)
+}
+
+// SaveGroupTrackerConfig adds or replaces the tracker config of one group, keeping the config of the other groups,
+// and returns the group's config as it was saved.
+func (s *Service) SaveGroupTrackerConfig(o Origin, c models.FlatTrackerConfig) (models.FlatTrackerConfig, error) {
+	group := models.Group(models.NewGroup(string(c.Group)))
+	if group == "" {
+		return models.FlatTrackerConfig{}, fmt.Errorf("%w: the group is missing", ErrInvalidChange)
+	}
+	before, err := s.tracker.GetConfig()
+	if err != nil {
+		return models.FlatTrackerConfig{}, fmt.Errorf("failed to get tracker config: %w", err)
+	}
+	after := make(models.MapGroupTrackerConfig, len(before)+1)
+	for k, v := range before {
+		cfg := *v
+		after[k] = &cfg
+	}
+	after[group] = fromFlat(c, before[group])
+	if err = s.saveTrackerConfig(o, string(group), before, after); err != nil {
+		return models.FlatTrackerConfig{}, err
+	}
+	return ToFlatTrackerConfig(group, after[group]), nil
+}
+
+func (s *Service) saveTrackerConfig(o Origin, target string, before, after models.MapGroupTrackerConfig) error {
+	snapshot := audit.Snapshot(before)
+	if err := s.tracker.SetConfig(after); err != nil {
+		return fmt.Errorf("failed to set tracker config: %w", err)
+	}
+	s.audit(o, "trackerConfig.save", target, snapshot, after)
+	return nil
+}
+
+// fromFlat returns the tracker config of v with the mode of the group's existing config, if any, since modes are
+// changed by SetMode rather than by saving the config.
+func fromFlat(v models.FlatTrackerConfig, prev *models.TrackerConfig) *models.TrackerConfig {
+	c := FromFlatTrackerConfig(v)
+	c.Mode, c.ModeEndTime = models.ModeMonitor, time.Time{}
+	if prev != nil {
+		c.Mode, c.ModeEndTime = prev.Mode, prev.ModeEndTime
+	}
+	return c
+}
+
+// SetMode allows or blocks the group for d, rounded down to the minute, or resumes monitoring it, and returns the
+// group's new mode.
+func (s *Service) SetMode(o Origin, group string, d time.Duration, mode models.UsageTrackerMode) (models.TrackerMode, error) {
+	d = d.Truncate(time.Minute)
+	switch {
+	case group == "":
+		return models.TrackerMode{}, fmt.Errorf("%w: the group is missing", ErrInvalidChange)
+	case mode < models.ModeMonitor || mode > models.ModeBlock:
+		return models.TrackerMode{}, fmt.Errorf("%w: unknown mode %v", ErrInvalidChange, mode)
+	case mode == models.ModeMonitor:
+		d = 0
+	case d <= 0:
+		return models.TrackerMode{}, fmt.Errorf("%w: the duration must be at least a minute", ErrInvalidChange)
+	}
+	before, err := s.tracker.GetModeEndTime(group)
+	if err != nil {
+		return models.TrackerMode{}, err
+	}
+	if err = s.tracker.SetMode(group, d, mode); err != nil {
+		return models.TrackerMode{}, fmt.Errorf("failed to set the mode: %w", err)
+	}
+	after, _ := s.tracker.GetModeEndTime(group)
+	action := "mode.set"
+	if mode == models.ModeMonitor {
+		action = "mode.resume"
+	}
+	s.audit(o, action, group, audit.Snapshot(before), after)
+	return after, nil
+}
+
+// ToFlatTrackerConfig returns the group's tracker config as the APIs show it.
+func ToFlatTrackerConfig(group models.Group, c *models.TrackerConfig) models.FlatTrackerConfig {
+	return models.FlatTrackerConfig{
+		Group:             group,
+		Retention:         c.Retention,
+		Threshold:         c.Threshold,
+		DayThresholds:     c.DayThresholds,
+		StartDayInt:       c.StartDayInt,
+		StartDayOfMonth:   c.StartDayOfMonth,
+		Window:            c.Window,
+		StartDuration:     c.StartDuration,
+		CountFrom:         c.CountFrom,
+		CountUntil:        c.CountUntil,
+		BlockOutsideHours: c.BlockOutsideHours,
+		MaxSession:        c.MaxSession,
+		BreakDuration:     c.BreakDuration,
+		MinActive:         c.MinActive,
+		MinActiveWindow:   c.MinActiveWindow,
+		PacketSampling:    c.PacketSampling,
+		Rollover:          c.Rollover,
+		RolloverCap:       c.RolloverCap,
+		WarnAt:            c.WarnAt,
+		GracePeriod:       c.GracePeriod,
+		PacketPolicy:      c.PacketPolicy,
+		Allowlist:         c.Allowlist,
+		Categories:        c.Categories,
+		Mode:              c.Mode,
+		ModeEndTime:       c.ModeEndTime,
+	}
+}
+
+// FromFlatTrackerConfig returns the tracker config of v. The tracker validates the values when the config is saved.
+func FromFlatTrackerConfig(v models.FlatTrackerConfig) *models.TrackerConfig {
+	return &models.TrackerConfig{
+		Retention:         v.Retention,
+		Threshold:         v.Threshold,
+		DayThresholds:     v.DayThresholds,
+		StartDayInt:       v.StartDayInt,
+		StartDayOfMonth:   v.StartDayOfMonth,
+		Window:            v.Window,
+		StartDuration:     v.StartDuration,
+		CountFrom:         v.CountFrom,
+		CountUntil:        v.CountUntil,
+		BlockOutsideHours: v.BlockOutsideHours,
+		MaxSession:        v.MaxSession,
+		BreakDuration:     v.BreakDuration,
+		MinActive:         v.MinActive,
+		MinActiveWindow:   v.MinActiveWindow,
+		PacketSampling:    v.PacketSampling,
+		Rollover:          v.Rollover,
+		RolloverCap:       v.RolloverCap,
+		WarnAt:            v.WarnAt,
+		GracePeriod:       v.GracePeriod,
+		PacketPolicy:      v.PacketPolicy,
+		Allowlist:         v.Allowlist,
+		Categories:        v.Categories,
+		Mode:              v.Mode,
+		ModeEndTime:       v.ModeEndTime,
+	}
+}
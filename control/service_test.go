@@ -0,0 +1,158 @@
+package control
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/audit"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+type mockTracker struct {
+	cfg   models.MapGroupTrackerConfig
+	modes map[string]models.TrackerMode
+}
+
+func (m *mockTracker) GetConfig() (models.MapGroupTrackerConfig, error) { return m.cfg, nil }
+func (m *mockTracker) SetConfig(c models.MapGroupTrackerConfig) error {
+	m.cfg = c
+	return nil
+}
+func (m *mockTracker) GetModeEndTime(id string) (models.TrackerMode, error) {
+	mode, ok := m.modes[id]
+	if !ok {
+		return models.TrackerMode{}, models.ErrGroupNotFound
+	}
+	return mode, nil
+}
+func (m *mockTracker) SetMode(id string, d time.Duration, mode models.UsageTrackerMode) error {
+	m.modes[id] = models.TrackerMode{Mode: mode, ModeEndTime: time.Date(2026, 10, 14, 19, 0, 0, 0, time.UTC).Add(d)}
+	return nil
+}
+
+type mockGroupMACs struct {
+	macs []config.FlatGroupMAC
+}
+
+func (m *mockGroupMACs) GetAllGroupMACs(*zap.SugaredLogger) ([]config.FlatGroupMAC, error) {
+	return m.macs, nil
+}
+func (m *mockGroupMACs) SaveGroupMACs(_ *zap.SugaredLogger, macs []config.FlatGroupMAC) error {
+	m.macs = macs
+	return nil
+}
+
+type mockAuditLog struct {
+	entries []audit.Entry
+}
+
+func (m *mockAuditLog) Record(e audit.Entry) error {
+	m.entries = append(m.entries, e)
+	return nil
+}
+
+var testOrigin = Origin{SourceIP: "192.168.1.10", Actor: "admin (a1)"}
+
+func TestService_SetDeviceGroup(t *testing.T) {
+	gm := &mockGroupMACs{macs: []config.FlatGroupMAC{{MAC: "11-22-33-44-55-66", Name: "tv"}, {Group: "kids", MAC: "AA-BB-CC-DD-EE-FF"}}}
+	al := &mockAuditLog{}
+	s := NewService(config.MustGetLogger(), &mockTracker{}, gm, al)
+
+	d, err := s.SetDeviceGroup(testOrigin, "11:22:33:44:55:66", "kids", nil)
+	require.NoError(t, err)
+	assert.Equal(t, config.FlatGroupMAC{Group: "kids", MAC: "11-22-33-44-55-66", Name: "tv"}, d, "expected the name to be kept")
+	assert.Len(t, gm.macs, 2)
+
+	name := "laptop"
+	d, err = s.SetDeviceGroup(testOrigin, "01-02-03-04-05-06", "", &name)
+	require.NoError(t, err)
+	assert.Equal(t, config.FlatGroupMAC{MAC: "01-02-03-04-05-06", Name: "laptop"}, d, "expected a new device to be added")
+	assert.Len(t, gm.macs, 3)
+	if assert.Len(t, al.entries, 2) {
+		assert.Equal(t, "groupMACs.save", al.entries[0].Action)
+		assert.Equal(t, "11-22-33-44-55-66", al.entries[0].Target)
+		assert.Equal(t, testOrigin.Actor, al.entries[0].Actor)
+		assert.Equal(t, testOrigin.SourceIP, al.entries[0].SourceIP)
+	}
+
+	_, err = s.SetDeviceGroup(testOrigin, "not-a-mac", "kids", nil)
+	assert.True(t, errors.Is(err, ErrInvalidChange))
+	assert.Len(t, al.entries, 2, "expected invalid changes not to be audited")
+}
+
+func TestService_SaveTrackerConfig(t *testing.T) {
+	blockedUntil := time.Date(2026, 10, 14, 20, 0, 0, 0, time.UTC)
+	ut := &mockTracker{cfg: models.MapGroupTrackerConfig{
+		"kids":  {Threshold: time.Hour, Mode: models.ModeBlock, ModeEndTime: blockedUntil},
+		"teens": {Threshold: 2 * time.Hour},
+	}}
+	al := &mockAuditLog{}
+	s := NewService(config.MustGetLogger(), ut, &mockGroupMACs{}, al)
+
+	saved, err := s.SaveGroupTrackerConfig(testOrigin, models.FlatTrackerConfig{Group: "kids", Threshold: 90 * time.Minute, Mode: models.ModeAllow})
+	require.NoError(t, err)
+	assert.Equal(t, models.Group("kids"), saved.Group)
+	assert.Equal(t, models.ModeBlock, saved.Mode, "expected the mode to be kept")
+	assert.Equal(t, blockedUntil, ut.cfg["kids"].ModeEndTime)
+	assert.Equal(t, 90*time.Minute, ut.cfg["kids"].Threshold)
+	assert.Equal(t, 2*time.Hour, ut.cfg["teens"].Threshold, "expected the other groups to be kept")
+
+	_, err = s.SaveGroupTrackerConfig(testOrigin, models.FlatTrackerConfig{})
+	assert.True(t, errors.Is(err, ErrInvalidChange))
+
+	err = s.SaveTrackerConfig(testOrigin, []models.FlatTrackerConfig{{Group: "kids", Threshold: time.Hour}, {Group: "new", Threshold: time.Hour, Mode: models.ModeBlock}, {}})
+	require.NoError(t, err)
+	assert.Len(t, ut.cfg, 2, "expected the groups to be replaced and groups without a name skipped")
+	assert.Equal(t, models.ModeBlock, ut.cfg["kids"].Mode)
+	assert.Equal(t, models.ModeMonitor, ut.cfg["new"].Mode, "expected new groups to be monitored")
+	if assert.Len(t, al.entries, 2) {
+		assert.Equal(t, "trackerConfig.save", al.entries[0].Action)
+		assert.Equal(t, "kids", al.entries[0].Target)
+		assert.Equal(t, "", al.entries[1].Target)
+	}
+}
+
+func TestService_SetMode(t *testing.T) {
+	ut := &mockTracker{modes: map[string]models.TrackerMode{"kids": {}}}
+	al := &mockAuditLog{}
+	s := NewService(config.MustGetLogger(), ut, &mockGroupMACs{}, al)
+
+	m, err := s.SetMode(testOrigin, "kids", 30*time.Minute+20*time.Second, models.ModeBlock)
+	require.NoError(t, err)
+	assert.Equal(t, models.TrackerMode{Mode: models.ModeBlock, ModeEndTime: time.Date(2026, 10, 14, 19, 30, 0, 0, time.UTC)}, m,
+		"expected the duration to be rounded down to the minute")
+
+	tests := []struct {
+		name  string
+		group string
+		d     time.Duration
+		mode  models.UsageTrackerMode
+		want  error
+	}{
+		{"no group", "", time.Hour, models.ModeAllow, ErrInvalidChange},
+		{"unknown mode", "kids", time.Hour, 7, ErrInvalidChange},
+		{"no duration", "kids", 0, models.ModeAllow, ErrInvalidChange},
+		{"under a minute", "kids", 30 * time.Second, models.ModeBlock, ErrInvalidChange},
+		{"unknown group", "nobody", time.Hour, models.ModeBlock, models.ErrGroupNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.SetMode(testOrigin, tt.group, tt.d, tt.mode)
+			assert.True(t, errors.Is(err, tt.want), "got %v", err)
+		})
+	}
+
+	m, err = s.SetMode(testOrigin, "kids", 0, models.ModeMonitor)
+	require.NoError(t, err)
+	assert.Equal(t, models.ModeMonitor, m.Mode)
+	if assert.Len(t, al.entries, 2, "expected only the changes made to be audited") {
+		assert.Equal(t, "mode.set", al.entries[0].Action)
+		assert.Equal(t, "mode.resume", al.entries[1].Action)
+		assert.Equal(t, "kids", al.entries[1].Target)
+	}
+}
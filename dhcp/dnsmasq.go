@@ -12,17 +12,28 @@ import (
 
 	"go.uber.org/zap"
 	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/eventbus"
 	"relloyd/tubetimeout/models"
 )
 
 func init() {
-	if runtime.GOOS == "linux" {
-		cmd := "nmcli"
-		err := config.CheckCmdAvailability(cmd)
-		if err != nil {
-			config.MustGetLogger().Fatalf("Error: %v. Please ensure the '%v' command is installed and available on your PATH.", cmd, err)
-		}
+	config.Backups.Register(configFileDHCPSettings, "DHCP settings and address reservations")
+}
+
+// RequiredCommands returns the commands that the DHCP backend runs, which must be on the PATH. The dnsmasq backend
+// changes connections with nmcli and, unless the DHCP server is disabled, restarts dnsmasq with systemctl, while the
+// native backend only sets the interface address with ip. None are needed except on Linux.
+func RequiredCommands() []string {
+	if runtime.GOOS != "linux" {
+		return nil
 	}
+	if config.AppCfg.DHCPConfig.Backend == backendNative { // if NetworkManager isn't needed...
+		return []string{"ip"}
+	}
+	if config.AppCfg.DHCPServerDisabled {
+		return []string{"nmcli"}
+	}
+	return []string{"nmcli", "systemctl", "dnsmasq"}
 }
 
 type systemctlAction string
@@ -51,6 +62,7 @@ var (
 	configFileDHCPSettings   = "dhcp-config.yaml"
 	fallbackDNSIPs           = []net.IP{net.ParseIP("1.1.1.1"), net.ParseIP("8.8.8.8")} // default DNS IPs to CloudFlare and Google.
 	dhcpMutex                = &sync.Mutex{}
+	workerInterval           = 15 * time.Second // workerInterval is how often the worker re-evaluates the service state.
 )
 
 type DNSMasqConfig struct {
@@ -63,8 +75,9 @@ type DNSMasqConfig struct {
 	ServiceEnabled      bool          `yaml:"serviceEnabled" json:"serviceEnabled"` // want state
 	ServiceState        serviceState  `yaml:"serviceState" json:"serviceState"`     // current state // TODO: put the service into this state at boot time
 
-	needsAction  bool // needsAction allows worker to continually try to up the dnsmasq service until the router DHCP server is stopped.
-	needsRestart bool // needsRestart allows dnsmasq to be restart once, until set false
+	needsAction    bool            // needsAction allows worker to continually try to up the dnsmasq service until the router DHCP server is stopped.
+	needsRestart   bool            // needsRestart allows dnsmasq to be restart once, until set false
+	blockedDomains []models.Domain // blockedDomains are answered with the DNS block address when DNS blocking is enabled.
 }
 
 type Reservation struct {
@@ -91,6 +104,11 @@ type restarter interface {
 	setDnsmasqServiceState(action systemctlAction) error
 }
 
+// conflictMitigator is implemented by restarters that can act on the router's DHCP server running alongside ours.
+type conflictMitigator interface {
+	mitigateConflict(active bool)
+}
+
 type Server struct {
 	logger                         *zap.SugaredLogger
 	chanWorker                     chan struct{}
@@ -100,6 +118,10 @@ type Server struct {
 	hwAddr                         net.HardwareAddr
 	dnsMasqServiceDisabledForDebug bool
 	ledWarning                     LEDController
+	backend                        string
+	lastChecked                    time.Time     // lastChecked is the time the worker last evaluated the service state, guarded by dhcpMutex.
+	conflict                       bool          // conflict is true while the router's DHCP server is running alongside ours, guarded by dhcpMutex.
+	bus                            *eventbus.Bus // bus is where service state changes are published.
 }
 
 type LEDController interface {
@@ -107,20 +129,49 @@ type LEDController interface {
 	DisableWarning()
 }
 
-func NewServer(ctx context.Context, logger *zap.SugaredLogger, dnsMasqServiceDisabledForDebug bool, ledWarning LEDController) (*Server, error) {
+// NewServer returns a Server that publishes its service state changes to the bus, or to a bus of its own if that's
+// nil, and starts its worker.
+func NewServer(ctx context.Context, logger *zap.SugaredLogger, dnsMasqServiceDisabledForDebug bool, ledWarning LEDController, bus *eventbus.Bus) (*Server, error) {
+	if bus == nil {
+		bus = eventbus.NewBus()
+	}
 	s := &Server{
 		logger:                         logger,
+		bus:                            bus,
 		chanWorker:                     make(chan struct{}, 2),
-		dhcpService:                    defaultDhcpService,
 		dnsMasqServiceDisabledForDebug: dnsMasqServiceDisabledForDebug, // hacky way of disabling dnsmasq start/stopping activity for stable network connectivity.
 		ledWarning:                     ledWarning,
+		backend:                        config.AppCfg.DHCPConfig.Backend,
 		// nil cfg so that it is fetched by s.GetConfig() below.
 	}
 
+	var err error
+	s.dhcpService, err = newRestarter(logger, s.backend)
+	if err != nil {
+		return nil, err
+	}
+	if s.backend == backendNative && config.AppCfg.DNSBlockConfig.DNSBlockEnabled {
+		logger.Warn("DNS blocking needs the dnsmasq DHCP backend and is disabled while the native backend is in use")
+	}
+	if ip := piholeDNSServer(); ip != nil {
+		logger.Infof("Handing out Pi-hole at %v as the DNS server", ip)
+		if config.AppCfg.DNSBlockConfig.DNSBlockEnabled {
+			logger.Warn("DNS blocking needs dnsmasq to serve DNS and is disabled while DNS is handed to Pi-hole")
+		}
+	} else if config.AppCfg.PiholeConfig.DNSServer != "" {
+		logger.Warnf("Ignoring invalid PIHOLE_DNS_SERVER %q, expected an IPv4 address", config.AppCfg.PiholeConfig.DNSServer)
+	}
+	if piholeDNSServer() == nil && config.AppCfg.DNSForwarderConfig.Enabled {
+		logger.Info("Handing out this gateway as the DNS server for the DNS forwarder")
+		if config.AppCfg.DNSBlockConfig.DNSBlockEnabled {
+			logger.Warn("DNS blocking needs dnsmasq to serve DNS and is disabled while the DNS forwarder does, use the DNS policy instead")
+		}
+	}
+
 	// TODO: set dynamic network adapter at startup before doing anything as a power failure will leave it in static mode
 	//   and we will rely on the previous dhcp config to be valid for a force start of dnsmasq to work.
 
-	_, err := s.GetConfig(s.logger) // GetConfig() sets the server config.
+	_, err = s.GetConfig(s.logger) // GetConfig() sets the server config.
 	if err != nil {
 		return nil, err
 	}
@@ -146,7 +197,7 @@ func NewServer(ctx context.Context, logger *zap.SugaredLogger, dnsMasqServiceDis
 }
 
 func (s *Server) startWorker(ctx context.Context) {
-	ticker := time.NewTicker(15 * time.Second)
+	ticker := time.NewTicker(workerInterval)
 	var err error
 	for {
 		select {
@@ -164,15 +215,30 @@ func (s *Server) startWorker(ctx context.Context) {
 			s.chanWorker <- struct{}{}
 		case <-s.chanWorker:
 			dhcpMutex.Lock()
+			prev := s.cfg.ServiceState
 			s.cfg.ServiceState, err = s.maybeStartOrStopDnsmasq(s.logger, s.dhcpService)
 			if err != nil {
 				s.logger.Errorf("Worker: %v", err)
 			}
+			s.lastChecked = time.Now()
+			state := s.cfg.ServiceState
 			dhcpMutex.Unlock()
+			if state != prev {
+				events.publish(Event{Type: EventState, State: string(state)})
+				s.bus.DHCPStates.Publish(string(state))
+			}
 		}
 	}
 }
 
+// RegisterDHCPStateReceivers subscribes receivers to the bus's DHCP states, to be notified when the service state
+// changes.
+func (s *Server) RegisterDHCPStateReceivers(receivers ...models.DHCPStateReceiver) {
+	for _, r := range receivers {
+		s.bus.DHCPStates.Subscribe(r.UpdateDHCPState)
+	}
+}
+
 // maybeStartOrStopDnsmasq checks if it's okay to start dnsmasq based on config.
 // If the service is config disabled, then return false without an error.
 // Return true if config wants dnsmasq started and the service could be started,
@@ -218,6 +284,7 @@ func (s *Server) maybeStartOrStopDnsmasq(logger *zap.SugaredLogger, svc restarte
 			logger.Errorf("Error checking if DHCP server is running: %v", err)
 			continue
 		}
+		s.updateConflict(wantEnabled && dhcpRunningRouter)
 
 		// Maybe stop dnsmasq.
 		if !wantEnabled { // if dnsmasq is disabled by the user...
@@ -284,6 +351,42 @@ func (s *Server) maybeStartOrStopDnsmasq(logger *zap.SugaredLogger, svc restarte
 	return
 }
 
+// updateConflict publishes an event when the router's DHCP server is first found running alongside ours, and again
+// once it's gone. This should be done under dhcpMutex.
+func (s *Server) updateConflict(conflict bool) {
+	if conflict == s.conflict {
+		return
+	}
+	s.conflict = conflict
+	if m, ok := s.dhcpService.(conflictMitigator); ok {
+		m.mitigateConflict(conflict)
+	} else if conflict && config.Current().DHCPConfig.ConflictMitigation {
+		s.logger.Warnf("DHCP conflict mitigation is only supported by the %v backend", backendNative)
+	}
+	if conflict {
+		events.publish(Event{Type: EventConflict, Message: "the router's DHCP server is still running, stop it so that devices get their leases from this gateway"})
+	} else {
+		events.publish(Event{Type: EventConflictCleared, Message: "the router's DHCP server is no longer running"})
+	}
+}
+
+// piholeDNSServer returns the Pi-hole DNS server to hand out to clients, or nil to use the configured DNS IPs.
+func piholeDNSServer() net.IP {
+	return net.ParseIP(strings.TrimSpace(config.Current().PiholeConfig.DNSServer)).To4()
+}
+
+// clientDNSServer returns the DNS server to hand out to clients in place of the configured DNS IPs, or nil to use
+// them. That's Pi-hole if it's set, or else this gateway if the DNS forwarder serves DNS on it.
+func clientDNSServer(thisGateway net.IP) net.IP {
+	if ip := piholeDNSServer(); ip != nil {
+		return ip
+	}
+	if config.AppCfg.DNSForwarderConfig.Enabled {
+		return thisGateway.To4()
+	}
+	return nil
+}
+
 func (s *Server) Stop() error {
 	// Reset to dynamic IP allocation in case we need another DHCP server to issue an IP to us.
 	if err := s.dhcpService.unsetStaticIP(s.logger, s.ifaceName); err != nil {
@@ -298,6 +401,60 @@ func (s *Server) Stop() error {
 	return nil
 }
 
+// Health reports the DHCP service state. The router's DHCP server still running is degraded since devices that get
+// a lease from it use the router as their gateway and aren't filtered.
+func (s *Server) Health() models.SubsystemHealth {
+	dhcpMutex.Lock()
+	defer dhcpMutex.Unlock()
+
+	h := models.SubsystemHealth{Name: "dhcp", Status: models.HealthOK}
+	if s.cfg == nil {
+		h.Status = models.HealthUnhealthy
+		h.Message = "DHCP config is not loaded"
+		return h
+	}
+	backend := s.backend
+	if backend == "" {
+		backend = backendDNSMasq
+	}
+	h.Details = map[string]any{"backend": backend, "enabled": s.cfg.ServiceEnabled, "state": s.cfg.ServiceState}
+
+	switch {
+	case s.dnsMasqServiceDisabledForDebug:
+		h.Message = "DHCP server is disabled for debugging"
+	case s.cfg.ServiceState == serviceStateActive:
+	case s.cfg.ServiceState == serviceStateInactive && !s.cfg.ServiceEnabled:
+		h.Message = "DHCP server is disabled in settings"
+	case s.cfg.ServiceState == serviceStateFailedCheckConfig:
+		h.Status = models.HealthUnhealthy
+		h.Message = "DHCP server failed to start"
+	case s.cfg.ServiceState == "":
+		h.Status = models.HealthDegraded
+		h.Message = "DHCP server is starting"
+	default:
+		h.Status = models.HealthDegraded
+		h.Message = string(s.cfg.ServiceState)
+	}
+	return h
+}
+
+// Freshness returns when the worker last evaluated the DHCP service state.
+func (s *Server) Freshness() models.Freshness {
+	dhcpMutex.Lock()
+	defer dhcpMutex.Unlock()
+	return models.Freshness{Source: models.FreshnessDHCP, LastUpdated: s.lastChecked, Interval: workerInterval}
+}
+
+// Range returns the first and last addresses of the DHCP range.
+func (s *Server) Range() (net.IP, net.IP) {
+	dhcpMutex.Lock()
+	defer dhcpMutex.Unlock()
+	if s.cfg == nil {
+		return nil, nil
+	}
+	return s.cfg.LowerBound, s.cfg.UpperBound
+}
+
 func (s *Server) GetConfig(logger *zap.SugaredLogger) (*DNSMasqConfig, error) {
 	// Allow lazy mocking of the func that gets config so we don't have to mock
 	// the whole inner workings of config.GetConfig in tests.
@@ -409,6 +566,9 @@ func SetConfig(_ *zap.SugaredLogger, oldCfg **DNSMasqConfig, newCfg *DNSMasqConf
 	fnUpdateInMem := func(cfg *DNSMasqConfig) {
 		cfg.needsAction = true // assume something has changed for now and that we want a restart; this should be done under lock in SetConfig().
 		cfg.needsRestart = true
+		if *oldCfg != nil { // if there are runtime values to carry over...
+			cfg.blockedDomains = (*oldCfg).blockedDomains
+		}
 		*oldCfg = cfg
 	}
 
@@ -423,3 +583,57 @@ func SetConfig(_ *zap.SugaredLogger, oldCfg **DNSMasqConfig, newCfg *DNSMasqConf
 func (s *Server) restart() {
 	s.chanWorker <- struct{}{}
 }
+
+// Reload re-reads the DHCP settings file and asks the worker to restart dnsmasq so that changes to the file
+// or to the app config (e.g. DNS block settings) are applied.
+func (s *Server) Reload() error {
+	var newCfg *DNSMasqConfig
+	if err := defaultGetConfig(s.logger, &newCfg); err != nil {
+		return err
+	}
+
+	dhcpMutex.Lock()
+	if s.cfg != nil { // if there are runtime values to carry over...
+		newCfg.ServiceState = s.cfg.ServiceState
+		newCfg.blockedDomains = s.cfg.blockedDomains
+	}
+	newCfg.needsAction = true
+	newCfg.needsRestart = true
+	s.cfg = newCfg
+	dhcpMutex.Unlock()
+
+	s.restart()
+	return nil
+}
+
+// SetBlockedDomains saves the domains that dnsmasq should answer with the DNS block address.
+// If dnsmasq is already running it is reconfigured and restarted, otherwise the domains are applied on the next start.
+func (s *Server) SetBlockedDomains(domains []models.Domain) error {
+	dhcpMutex.Lock()
+	defer dhcpMutex.Unlock()
+
+	if s.cfg == nil {
+		return fmt.Errorf("dnsmasq config is not loaded")
+	}
+	s.cfg.blockedDomains = domains
+
+	if s.backend == backendNative || s.dnsMasqServiceDisabledForDebug ||
+		(s.cfg.ServiceState != serviceStateActive && s.cfg.ServiceState != serviceStateActiveRouterCanBeStopped) { // if dnsmasq isn't running...
+		return nil
+	}
+
+	app := config.Current()
+	dat, err := generateDnsmasqConfig(s.ifaceName, s.cfg.ThisGateway, s.cfg.LowerBound, s.cfg.UpperBound, s.hwAddr.String(), s.cfg.DnsIPs, s.cfg.AddressReservations, &app.DNSBlockConfig, s.cfg.blockedDomains, clientDNSServer(s.cfg.ThisGateway), &app.IPv6Config)
+	if err != nil {
+		return fmt.Errorf("error generating dnsmasq config: %w", err)
+	}
+	if err = writeDnsmasqConfig(configFileDNSMasqService, dat); err != nil {
+		return fmt.Errorf("error writing dnsmasq config: %w", err)
+	}
+	if err = s.dhcpService.setDnsmasqServiceState(serviceRestart); err != nil {
+		return fmt.Errorf("error restarting dnsmasq: %w", err)
+	}
+
+	s.logger.Infof("Dnsmasq reloaded with %d blocked domains", len(domains))
+	return nil
+}
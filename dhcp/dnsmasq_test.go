@@ -4,11 +4,14 @@ package dhcp
 import (
 	"errors"
 	"net"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
 )
 
 type mockRestarter struct {
@@ -240,3 +243,60 @@ func TestMaybeStartDnsmasq_LEDControllerBehavior(t *testing.T) {
 	assert.Equal(t, serviceStateInactive, state)
 	mockLED.AssertCalled(t, "EnableWarning")
 }
+
+func TestServer_Health(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *DNSMasqConfig
+		debug   bool
+		want    models.HealthStatus
+		message string
+	}{
+		{"Not loaded", nil, false, models.HealthUnhealthy, "DHCP config is not loaded"},
+		{"Active", &DNSMasqConfig{ServiceEnabled: true, ServiceState: serviceStateActive}, false, models.HealthOK, ""},
+		{"Router still running", &DNSMasqConfig{ServiceEnabled: true, ServiceState: serviceStateActiveRouterCanBeStopped}, false, models.HealthDegraded, string(serviceStateActiveRouterCanBeStopped)},
+		{"Disabled", &DNSMasqConfig{ServiceState: serviceStateInactive}, false, models.HealthOK, "DHCP server is disabled in settings"},
+		{"Failed", &DNSMasqConfig{ServiceEnabled: true, ServiceState: serviceStateFailedCheckConfig}, false, models.HealthUnhealthy, "DHCP server failed to start"},
+		{"Starting", &DNSMasqConfig{ServiceEnabled: true}, false, models.HealthDegraded, "DHCP server is starting"},
+		{"Disabled for debug", &DNSMasqConfig{ServiceEnabled: true}, true, models.HealthOK, "DHCP server is disabled for debugging"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{cfg: tt.cfg, dnsMasqServiceDisabledForDebug: tt.debug}
+			h := s.Health()
+			assert.Equal(t, tt.want, h.Status)
+			assert.Equal(t, tt.message, h.Message)
+		})
+	}
+}
+
+func TestClientDNSServer(t *testing.T) {
+	origPihole, origForwarder := config.AppCfg.PiholeConfig, config.AppCfg.DNSForwarderConfig
+	t.Cleanup(func() { config.AppCfg.PiholeConfig, config.AppCfg.DNSForwarderConfig = origPihole, origForwarder })
+	gateway := net.ParseIP("192.168.1.2")
+
+	config.AppCfg.PiholeConfig.DNSServer, config.AppCfg.DNSForwarderConfig.Enabled = "", false
+	assert.Nil(t, clientDNSServer(gateway), "expected the configured DNS IPs to be used")
+
+	config.AppCfg.DNSForwarderConfig.Enabled = true
+	assert.Equal(t, gateway.To4(), clientDNSServer(gateway), "expected this gateway to be handed out for the DNS forwarder")
+
+	config.AppCfg.PiholeConfig.DNSServer = "192.168.1.3"
+	assert.Equal(t, net.ParseIP("192.168.1.3").To4(), clientDNSServer(gateway), "expected Pi-hole to take precedence")
+}
+
+func TestRequiredCommands(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("commands are only required on Linux")
+	}
+	origBackend, origDisabled := config.AppCfg.DHCPConfig.Backend, config.AppCfg.DHCPServerDisabled
+	t.Cleanup(func() { config.AppCfg.DHCPConfig.Backend, config.AppCfg.DHCPServerDisabled = origBackend, origDisabled })
+
+	config.AppCfg.DHCPConfig.Backend, config.AppCfg.DHCPServerDisabled = backendDNSMasq, false
+	assert.Equal(t, []string{"nmcli", "systemctl", "dnsmasq"}, RequiredCommands())
+	config.AppCfg.DHCPServerDisabled = true
+	assert.Equal(t, []string{"nmcli"}, RequiredCommands(), "expected dnsmasq not to be needed while the DHCP server is disabled")
+	config.AppCfg.DHCPConfig.Backend = backendNative
+	assert.Equal(t, []string{"ip"}, RequiredCommands())
+}
@@ -0,0 +1,101 @@
+package dhcp
+
+import (
+	"sync"
+	"time"
+)
+
+// EventType is the kind of change to the DHCP server reported by an Event.
+type EventType string
+
+const (
+	EventState           = EventType("state")           // EventState is sent when the service state changes, e.g. from active to inactive.
+	EventConflict        = EventType("conflict")        // EventConflict is sent when the router's DHCP server is found running alongside ours.
+	EventConflictCleared = EventType("conflictCleared") // EventConflictCleared is sent once the router's DHCP server is no longer found.
+	EventStaticIPSet     = EventType("staticIPSet")     // EventStaticIPSet is sent when this gateway's static IP is set, or fails to be.
+	EventStaticIPUnset   = EventType("staticIPUnset")   // EventStaticIPUnset is sent when the static IP is removed, or fails to be.
+)
+
+const eventSubscriberBuffer = 16 // eventSubscriberBuffer is the number of events queued for each subscriber before they're dropped.
+
+// Event is a change to the DHCP server, streamed to the web UI as it happens.
+type Event struct {
+	ID      uint64    `json:"id"` // ID increases with each event published and starts again from 1 when the app restarts.
+	Type    EventType `json:"type"`
+	Time    time.Time `json:"time"`
+	State   string    `json:"state"`             // State is the service state when the event was published.
+	Message string    `json:"message,omitempty"` // Message describes the event, e.g. the address set.
+	Error   string    `json:"error,omitempty"`   // Error is set if the operation failed.
+}
+
+// eventBus fans out events to subscribers. Subscribers that fall behind miss events rather than holding up the
+// worker that publishes them.
+type eventBus struct {
+	mu     sync.Mutex
+	subs   map[chan Event]struct{}
+	nextID uint64
+	state  string // state is the latest service state, included in each event.
+	last   *Event // last is the latest state event, sent to new subscribers first.
+}
+
+// events is the bus that the DHCP server and the restarters publish to.
+var events = newEventBus()
+
+func newEventBus() *eventBus {
+	return &eventBus{subs: make(map[chan Event]struct{})}
+}
+
+// publish sets the event's ID, time and state and sends it to the subscribers. State events also update the state
+// given to later events.
+func (b *eventBus) publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nextID++
+	e.ID = b.nextID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.Type == EventState {
+		b.state = e.State
+		b.last = &e
+	}
+	e.State = b.state
+	for c := range b.subs {
+		select {
+		case c <- e:
+		default: // drop the event if the subscriber's buffer is full.
+		}
+	}
+}
+
+// subscribe returns a channel of events, starting with the latest state event if there is one, and a func to call
+// once the caller is no longer reading from it.
+func (b *eventBus) subscribe() (<-chan Event, func()) {
+	c := make(chan Event, eventSubscriberBuffer)
+	b.mu.Lock()
+	if b.last != nil {
+		c <- *b.last
+	}
+	b.subs[c] = struct{}{}
+	b.mu.Unlock()
+	return c, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, c)
+	}
+}
+
+// publishStaticIP publishes the result of setting or unsetting the static IP.
+func publishStaticIP(t EventType, msg string, err error) {
+	e := Event{Type: t, Message: msg}
+	if err != nil {
+		e.Error = err.Error()
+	}
+	events.publish(e)
+}
+
+// SubscribeEvents returns a channel of the server's state changes, DHCP conflicts and static IP changes, starting
+// with the current state, and a func to call once the caller is no longer reading from it.
+func (s *Server) SubscribeEvents() (<-chan Event, func()) {
+	return events.subscribe()
+}
@@ -0,0 +1,95 @@
+package dhcp
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"relloyd/tubetimeout/config"
+)
+
+// useEventBus replaces the package's event bus with a new one for the test.
+func useEventBus(t *testing.T) *eventBus {
+	t.Helper()
+	orig := events
+	events = newEventBus()
+	t.Cleanup(func() { events = orig })
+	return events
+}
+
+// drain returns the events queued on c.
+func drain(c <-chan Event) []Event {
+	var got []Event
+	for {
+		select {
+		case e := <-c:
+			got = append(got, e)
+		default:
+			return got
+		}
+	}
+}
+
+func TestEventBus(t *testing.T) {
+	b := useEventBus(t)
+	early, unsubscribeEarly := b.subscribe()
+	assert.Empty(t, drain(early), "expected no state before the first state event")
+
+	b.publish(Event{Type: EventState, State: string(serviceStateActive)})
+	b.publish(Event{Type: EventConflict, Message: "router"})
+	got := drain(early)
+	require.Len(t, got, 2)
+	assert.Equal(t, uint64(1), got[0].ID)
+	assert.Equal(t, string(serviceStateActive), got[1].State, "expected later events to carry the current state")
+	assert.False(t, got[1].Time.IsZero())
+
+	late, unsubscribeLate := b.subscribe()
+	defer unsubscribeLate()
+	got = drain(late)
+	require.Len(t, got, 1, "expected new subscribers to get the current state first")
+	assert.Equal(t, EventState, got[0].Type)
+
+	// Expect subscribers that fall behind to miss events rather than block, and unsubscribed ones to get none.
+	unsubscribeEarly()
+	for range eventSubscriberBuffer + 1 {
+		b.publish(Event{Type: EventStaticIPSet})
+	}
+	assert.Len(t, drain(late), eventSubscriberBuffer)
+	assert.Empty(t, drain(early))
+}
+
+func TestServer_UpdateConflict(t *testing.T) {
+	b := useEventBus(t)
+	c, unsubscribe := b.subscribe()
+	defer unsubscribe()
+
+	s := &Server{}
+	s.updateConflict(false)
+	s.updateConflict(true)
+	s.updateConflict(true)
+	s.updateConflict(false)
+	got := drain(c)
+	require.Len(t, got, 2, "expected an event only when the conflict starts and ends")
+	assert.Equal(t, EventConflict, got[0].Type)
+	assert.Equal(t, EventConflictCleared, got[1].Type)
+}
+
+func TestNativeService_StaticIPEvents(t *testing.T) {
+	b := useEventBus(t)
+	c, unsubscribe := b.subscribe()
+	defer unsubscribe()
+	cmds := config.UseFakeExec(t)
+	n, _ := setupNativeService(t)
+	logger := config.MustGetLogger()
+
+	assert.NoError(t, n.setStaticIP(logger, "eth0", newTestPoolConfig(), findSmallestSingleCIDR))
+	cmds.Results = map[string]config.FakeResult{"ip addr del 192.168.1.2/29 dev eth0": {Err: errors.New("exit status 2")}}
+	assert.Error(t, n.unsetStaticIP(logger, "eth0"))
+
+	got := drain(c)
+	require.Len(t, got, 2)
+	assert.Equal(t, Event{ID: 1, Type: EventStaticIPSet, Time: got[0].Time, Message: "192.168.1.2/29 on eth0"}, got[0])
+	assert.Equal(t, EventStaticIPUnset, got[1].Type)
+	assert.Contains(t, got[1].Error, "exit status 2")
+}
@@ -7,14 +7,15 @@ import (
 	"fmt"
 	"math/big"
 	"net"
-	"os"
-	"os/exec"
 	"runtime"
 	"strings"
+
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
 )
 
 func defaultRouteCmd() (string, error) {
-	output, err := exec.Command("netstat", routeCmdArgs...).Output() // -n: show numerical addresses, -a: show all hosts
+	output, err := config.Commands.Query("netstat", routeCmdArgs...) // -n: show numerical addresses, -a: show all hosts
 	return string(output), err
 }
 
@@ -273,7 +274,13 @@ func chooseIPFromBottom(lower, upper net.IP) (chosenIP, newLower, newUpper net.I
 }
 
 // generateDnsmasqConfig builds the full dnsmasq configuration as a string.
-func generateDnsmasqConfig(interfaceName string, thisGateway, subnetLower, subnetUpper net.IP, thisGatewayHardwareAddress string, dnsIPS []net.IP, reservations []Reservation) (string, error) {
+// If dnsBlockCfg is enabled, clients are told to resolve via thisGateway so that blockedDomains can be answered with
+// the configured block address.
+// If clientDNS is set, clients are told to resolve via it instead and dnsmasq only serves DHCP, so that it doesn't
+// take over DNS from a Pi-hole or the DNS forwarder on the same host; DNS blocking doesn't apply.
+// If ipv6Cfg enables router advertisements, this gateway is announced as the IPv6 router, and as the DNS server unless
+// DNS is handed to clientDNS.
+func generateDnsmasqConfig(interfaceName string, thisGateway, subnetLower, subnetUpper net.IP, thisGatewayHardwareAddress string, dnsIPS []net.IP, reservations []Reservation, dnsBlockCfg *config.DNSBlockConfig, blockedDomains []models.Domain, clientDNS net.IP, ipv6Cfg *config.IPv6Config) (string, error) {
 	// Global configuration settings.
 	if len(dnsIPS) != 2 {
 		return "", fmt.Errorf("expected two DNS IPs: %v", dnsIPS)
@@ -284,17 +291,41 @@ func generateDnsmasqConfig(interfaceName string, thisGateway, subnetLower, subne
 		ipStrings = append(ipStrings, ip.String())
 	}
 
+	dnsBlockEnabled := dnsBlockCfg != nil && dnsBlockCfg.DNSBlockEnabled && clientDNS == nil
+	if dnsBlockEnabled { // if clients need to resolve via dnsmasq for blocking to work...
+		ipStrings = []string{thisGateway.String()}
+	} else if clientDNS != nil {
+		ipStrings = []string{clientDNS.String()}
+	}
+
 	lines := []string{
 		"# dnsmasq configuration generated programmatically",
 		fmt.Sprintf("interface=%v", interfaceName),
 		fmt.Sprintf("dhcp-range=%v,%v,%v", subnetLower, subnetUpper, defaultLeaseDuration),
 		fmt.Sprintf("dhcp-option=option:router,%v", thisGateway),
 		fmt.Sprintf("dhcp-option=option:dns-server,%v", strings.Join(ipStrings, ",")),
-		"no-resolv", // no-resolv will use server entries below as the upstream DNS servers, instead of resolv.conf.
-		fmt.Sprintf("server=%v", dnsIPS[0]),
-		fmt.Sprintf("server=%v", dnsIPS[1]),
-		"",
 	}
+	if clientDNS != nil { // if DNS is handed to another server...
+		lines = append(lines, "port=0") // port=0 disables the DNS server.
+	} else {
+		lines = append(lines,
+			"no-resolv", // no-resolv will use server entries below as the upstream DNS servers, instead of resolv.conf.
+			fmt.Sprintf("server=%v", dnsIPS[0]),
+			fmt.Sprintf("server=%v", dnsIPS[1]),
+		)
+	}
+	if ipv6Cfg != nil && ipv6Cfg.RouterAdvertisements {
+		lines = append(lines, "", "# IPv6 router advertisements", "enable-ra")
+		if ipv6Cfg.Managed { // if devices should ask DHCPv6 for addresses (the M flag)...
+			lines = append(lines, fmt.Sprintf("dhcp-range=::100,::ffff,constructor:%v,64,%v", interfaceName, defaultLeaseDuration))
+		} else { // else they use SLAAC, with the DNS server also given by stateless DHCPv6 (the O flag)...
+			lines = append(lines, fmt.Sprintf("dhcp-range=::,constructor:%v,ra-stateless,ra-names", interfaceName))
+		}
+		if clientDNS == nil {
+			lines = append(lines, "dhcp-option=option6:dns-server,[::]") // [::] is this gateway's address, sent as RDNSS too.
+		}
+	}
+	lines = append(lines, "")
 
 	// # Static IP reservations take the form:
 	// dhcp-host=dc:a6:32:68:47:ea,192.168.1.52
@@ -310,6 +341,14 @@ func generateDnsmasqConfig(interfaceName string, thisGateway, subnetLower, subne
 		lines = append(lines, fmt.Sprintf(reservationsPattern, r.MacAddr.WithColons(), r.IpAddr, r.Name))
 	}
 
+	// Answer lookups for blocked domains with the block address; dnsmasq applies this to sub-domains too.
+	if dnsBlockEnabled && len(blockedDomains) > 0 {
+		lines = append(lines, "", "# blocked domains")
+		for _, d := range blockedDomains {
+			lines = append(lines, fmt.Sprintf("address=/%v/%v", d, dnsBlockCfg.BlockAddress))
+		}
+	}
+
 	// Custom exclusions to use the default gw:
 	// TODO: consider given the real gateway to MACs not explicitly configured to use tubetimeout.
 	// Configure a tag to use for custom host entries for each supplied known MAC; assign a tag and set a custom router.
@@ -328,7 +367,7 @@ func generateDnsmasqConfig(interfaceName string, thisGateway, subnetLower, subne
 
 // writeDnsmasqConfig writes the generated config to the given file path.
 func writeDnsmasqConfig(configPath string, configContent string) error {
-	return os.WriteFile(configPath, []byte(configContent), 0644)
+	return config.Commands.WriteFile(configPath, []byte(configContent), 0644)
 }
 
 type cidrFinderFunc func(startIP, endIP net.IP) (string, string)
@@ -3,6 +3,7 @@ package dhcp
 import (
 	"bytes"
 	"context"
+	"flag"
 	"fmt"
 	"net"
 	"os"
@@ -14,6 +15,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
 )
 
 func TestNewServer(t *testing.T) {
@@ -57,7 +59,7 @@ func TestNewServer(t *testing.T) {
 
 			// defaultDhcpService = &mockRestarter{}
 			ctx, cancelFunc := context.WithCancel(context.Background())
-			server, err := NewServer(ctx, config.MustGetLogger(), false, &mockLEDController{})
+			server, err := NewServer(ctx, config.MustGetLogger(), false, &mockLEDController{}, nil)
 
 			if tt.expectError {
 				assert.Error(t, err, tt.errorMsg)
@@ -162,9 +164,6 @@ serviceEnabled: true
 	defer func() {
 		configFileDHCPSettings = originalFile // Restore the original value
 	}()
-	config.FnDefaultCreateAppHomeDirAndGetConfigFilePath = func(fileName string) (string, error) {
-		return tmpFile.Name(), nil
-	}
 
 	_, err = s.GetConfig(config.MustGetLogger())
 	assert.NoError(t, err, "Expected no error when loading config")
@@ -189,8 +188,6 @@ serviceEnabled: true
 }
 
 func TestSetConfig_WritesToFile(t *testing.T) {
-	originalFnDefault := config.FnDefaultCreateAppHomeDirAndGetConfigFilePath
-
 	// Create a temporary file for the config
 	tmpFile, err := os.CreateTemp("", "dnsmasq-config-*.json")
 	assert.NoError(t, err)
@@ -199,26 +196,20 @@ func TestSetConfig_WritesToFile(t *testing.T) {
 		_ = os.Remove(name)
 	}(tmpFile.Name())
 
-	// Override the function to return our temp file path.
-	config.FnDefaultCreateAppHomeDirAndGetConfigFilePath = func(fileName string) (string, error) {
-		return tmpFile.Name(), nil
-	}
-	// Restore the original function after the test completes.
-	defer func() {
-		config.FnDefaultCreateAppHomeDirAndGetConfigFilePath = originalFnDefault
-	}()
-
-	// Change the global config file variable to point to the temp file.
-	originalFile := configFileDNSMasqService
+	// Change the global config file variable to point to the temp file, and keep the settings in a temp store.
+	originalFile, originalStore := configFileDNSMasqService, config.DefaultStore
 	configFileDNSMasqService = tmpFile.Name()
+	dir := t.TempDir()
+	config.DefaultStore = config.NewStore(dir)
 	defer func() {
-		configFileDNSMasqService = originalFile
+		configFileDNSMasqService, config.DefaultStore = originalFile, originalStore
 	}()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, configFileDHCPSettings), nil, 0644))
 
 	// Setup server config.
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
-	s, err := NewServer(ctx, config.MustGetLogger(), false, &mockLEDController{})
+	s, err := NewServer(ctx, config.MustGetLogger(), false, &mockLEDController{}, nil)
 	assert.NoError(t, err)
 
 	// Create a sample config with a known value.
@@ -239,7 +230,9 @@ func TestSetConfig_WritesToFile(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Read back the file contents.
-	b, err := os.ReadFile(tmpFile.Name())
+	settingsFile, err := config.DefaultStore.Path(configFileDHCPSettings)
+	assert.NoError(t, err)
+	b, err := os.ReadFile(settingsFile)
 	assert.NoError(t, err)
 	content := string(b)
 
@@ -637,7 +630,7 @@ func TestGenerateDnsmasqConfig(t *testing.T) {
 	// 	{MAC: "dc:a6:32:68:47:e9", Name: ""},
 	// }
 
-	generatedConfig, err := generateDnsmasqConfig("eth0", thisGateway, subnetLower, subnetUpper, thisGatewayHardwareAddr, fallbackDNSIPs, reservations)
+	generatedConfig, err := generateDnsmasqConfig("eth0", thisGateway, subnetLower, subnetUpper, thisGatewayHardwareAddr, fallbackDNSIPs, reservations, nil, nil, nil, nil)
 	assert.NoError(t, err, "generateDnsmasqConfig should not return an error")
 
 	expectedLines := []string{
@@ -667,6 +660,102 @@ func TestGenerateDnsmasqConfig(t *testing.T) {
 	}
 }
 
+func TestGenerateDnsmasqConfig_DNSBlock(t *testing.T) {
+	thisGateway := net.ParseIP("192.168.1.2")
+	subnetLower := net.ParseIP("192.168.1.10")
+	subnetUpper := net.ParseIP("192.168.1.100")
+	thisGatewayHardwareAddr := net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x00}.String()
+	dnsBlockCfg := &config.DNSBlockConfig{DNSBlockEnabled: true, BlockAddress: "0.0.0.0"}
+	blockedDomains := []models.Domain{"youtube.com", "googlevideo.com"}
+
+	generatedConfig, err := generateDnsmasqConfig("eth0", thisGateway, subnetLower, subnetUpper, thisGatewayHardwareAddr, fallbackDNSIPs, nil, dnsBlockCfg, blockedDomains, nil, nil)
+	assert.NoError(t, err, "generateDnsmasqConfig should not return an error")
+
+	expectedLines := []string{
+		"# dnsmasq configuration generated programmatically",
+		"interface=eth0",
+		"dhcp-range=192.168.1.10,192.168.1.100,12h",
+		"dhcp-option=option:router,192.168.1.2",
+		"dhcp-option=option:dns-server,192.168.1.2",
+		"no-resolv",
+		"server=1.1.1.1",
+		"server=8.8.8.8",
+		"",
+		"# static IP reservations",
+		"dhcp-host=00:00:00:00:00:00,192.168.1.2 # this gateway",
+		"",
+		"# blocked domains",
+		"address=/youtube.com/0.0.0.0",
+		"address=/googlevideo.com/0.0.0.0",
+	}
+	assert.Equal(t, strings.Join(expectedLines, "\n"), generatedConfig, "unexpected dnsmasq config with DNS blocking")
+
+	// Blocked domains are ignored when DNS blocking is disabled.
+	dnsBlockCfg.DNSBlockEnabled = false
+	generatedConfig, err = generateDnsmasqConfig("eth0", thisGateway, subnetLower, subnetUpper, thisGatewayHardwareAddr, fallbackDNSIPs, nil, dnsBlockCfg, blockedDomains, nil, nil)
+	assert.NoError(t, err, "generateDnsmasqConfig should not return an error")
+	assert.NotContains(t, generatedConfig, "address=/", "expected no blocked domains when DNS blocking is disabled")
+	assert.Contains(t, generatedConfig, "dhcp-option=option:dns-server,1.1.1.1,8.8.8.8", "expected upstream DNS servers when DNS blocking is disabled")
+}
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestGenerateDnsmasqConfig_Golden compares the generated config for representative setups with the files in
+// testdata. Run the tests with -update to rewrite them after an intended change.
+func TestGenerateDnsmasqConfig_Golden(t *testing.T) {
+	thisGateway := net.ParseIP("192.168.1.2")
+	subnetLower := net.ParseIP("192.168.1.10")
+	subnetUpper := net.ParseIP("192.168.1.250")
+	thisGatewayHardwareAddr := net.HardwareAddr{0xdc, 0xa6, 0x32, 0x68, 0x47, 0xea}.String()
+	reservations := []Reservation{
+		{MacAddr: "2c:cf:67:b6:37:7e", IpAddr: net.ParseIP("192.168.1.50"), Name: "Living room TV"},
+		{MacAddr: "58-ef-68-e5-f5-8c", IpAddr: net.ParseIP("192.168.1.51"), Name: "Tablet"},
+		{MacAddr: "9A:3B:AD:01:02:03", IpAddr: net.ParseIP("192.168.1.52"), Name: ""},
+	}
+	blockedDomains := []models.Domain{"youtube.com", "googlevideo.com", "ytimg.com"}
+
+	tests := []struct {
+		name           string
+		dnsIPs         []net.IP
+		reservations   []Reservation
+		dnsBlockCfg    *config.DNSBlockConfig
+		blockedDomains []models.Domain
+		piholeDNS      net.IP
+		ipv6Cfg        *config.IPv6Config
+	}{
+		{name: "defaults", dnsIPs: fallbackDNSIPs},
+		{name: "reservations", dnsIPs: fallbackDNSIPs, reservations: reservations},
+		{name: "custom-dns-servers", dnsIPs: []net.IP{net.ParseIP("9.9.9.9"), net.ParseIP("149.112.112.112")}, reservations: reservations[:1]},
+		{name: "dns-block", dnsIPs: fallbackDNSIPs, reservations: reservations, dnsBlockCfg: &config.DNSBlockConfig{DNSBlockEnabled: true, BlockAddress: "0.0.0.0"}, blockedDomains: blockedDomains},
+		{name: "dns-block-nothing-blocked", dnsIPs: fallbackDNSIPs, dnsBlockCfg: &config.DNSBlockConfig{DNSBlockEnabled: true, BlockAddress: "192.168.1.2"}},
+		{name: "dns-block-disabled", dnsIPs: fallbackDNSIPs, dnsBlockCfg: &config.DNSBlockConfig{DNSBlockEnabled: false, BlockAddress: "0.0.0.0"}, blockedDomains: blockedDomains},
+		{name: "pihole-dns", dnsIPs: fallbackDNSIPs, reservations: reservations[:1], dnsBlockCfg: &config.DNSBlockConfig{DNSBlockEnabled: true, BlockAddress: "0.0.0.0"}, blockedDomains: blockedDomains, piholeDNS: net.ParseIP("192.168.1.3")},
+		{name: "ipv6-ra", dnsIPs: fallbackDNSIPs, ipv6Cfg: &config.IPv6Config{RouterAdvertisements: true}},
+		{name: "ipv6-ra-managed", dnsIPs: fallbackDNSIPs, ipv6Cfg: &config.IPv6Config{RouterAdvertisements: true, Managed: true}},
+		{name: "ipv6-ra-pihole-dns", dnsIPs: fallbackDNSIPs, piholeDNS: net.ParseIP("192.168.1.3"), ipv6Cfg: &config.IPv6Config{RouterAdvertisements: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := generateDnsmasqConfig("eth0", thisGateway, subnetLower, subnetUpper, thisGatewayHardwareAddr, tt.dnsIPs, tt.reservations, tt.dnsBlockCfg, tt.blockedDomains, tt.piholeDNS, tt.ipv6Cfg)
+			assert.NoError(t, err)
+			assertGolden(t, "dnsmasq-"+tt.name+".golden", got)
+		})
+	}
+}
+
+// assertGolden compares got with testdata/name, or rewrites the file when the tests are run with -update.
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		assert.NoError(t, os.MkdirAll("testdata", 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(got), 0644))
+	}
+	want, err := os.ReadFile(path)
+	assert.NoError(t, err, "run the tests with -update to create the golden file")
+	assert.Equal(t, string(want), got, "output differs from %v; run the tests with -update if the change is intended", path)
+}
+
 // TestWriteDnsmasqConfig tests the writeDnsmasqConfig function.
 func TestWriteDnsmasqConfig(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
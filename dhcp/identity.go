@@ -0,0 +1,93 @@
+package dhcp
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"relloyd/tubetimeout/models"
+)
+
+var (
+	dnsmasqLeasesPath = "/var/lib/misc/dnsmasq.leases"
+	fnReadLeasesFile  = os.ReadFile
+)
+
+// Identities implements models.IdentitySource. It returns the stable identity of each device with a current lease,
+// going by the client ID or hostname it sent, so that a device using a private MAC can be known by its identity
+// instead. The native server records hostnames only.
+func (s *Server) Identities() models.MapMACIdentity {
+	var leases []Lease
+	var err error
+	if s.backend == backendNative {
+		leases, err = loadLeases()
+	} else {
+		leases, err = readDnsmasqLeases()
+	}
+	if err != nil {
+		s.logger.Warnf("Unable to read the DHCP leases for device identities: %v", err)
+		return nil
+	}
+	now := time.Now()
+	retval := make(models.MapMACIdentity)
+	for _, l := range leases {
+		if !l.Expiry.IsZero() && !l.Expiry.After(now) { // if the lease has expired...
+			continue
+		}
+		if id := leaseIdentity(l); id != "" {
+			retval[models.MAC(models.NewMAC(string(l.MacAddr)))] = id
+		}
+	}
+	return retval
+}
+
+// leaseIdentity returns "client-id:<id>" if the client sent an ID that isn't made from its MAC, since that changes
+// with the MAC, else "hostname:<name>" lower cased, or an empty string if the lease has neither.
+func leaseIdentity(l Lease) string {
+	mac := strings.ToLower(l.MacAddr.WithColons())
+	if id := strings.ToLower(l.ClientID); id != "" && !strings.HasSuffix(id, mac) {
+		return "client-id:" + id
+	}
+	if name := strings.ToLower(strings.TrimSpace(l.Hostname)); name != "" {
+		return "hostname:" + name
+	}
+	return ""
+}
+
+// readDnsmasqLeases reads the leases file that dnsmasq keeps. Each line is
+// "<expiry> <mac> <ip> <hostname> <client-id>", where the expiry is in Unix seconds or 0 for an infinite lease, and
+// a missing hostname or client ID is "*". It isn't an error if the file doesn't exist, e.g. before dnsmasq has run.
+func readDnsmasqLeases() ([]Lease, error) {
+	data, err := fnReadLeasesFile(dnsmasqLeasesPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var leases []Lease
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+		var l Lease
+		if err := l.MacAddr.UnmarshalText([]byte(fields[1])); err != nil { // if it's not a MAC, e.g. a DUID line for IPv6...
+			continue
+		}
+		if secs, err := strconv.ParseInt(fields[0], 10, 64); err == nil && secs > 0 {
+			l.Expiry = time.Unix(secs, 0)
+		}
+		if fields[3] != "*" {
+			l.Hostname = fields[3]
+		}
+		if len(fields) > 4 && fields[4] != "*" {
+			l.ClientID = fields[4]
+		}
+		leases = append(leases, l)
+	}
+	return leases, scanner.Err()
+}
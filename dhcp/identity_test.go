@@ -0,0 +1,43 @@
+package dhcp
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+func TestLeaseIdentity(t *testing.T) {
+	assert.Equal(t, "client-id:ff:00:11:22:33", leaseIdentity(Lease{MacAddr: "AA-BB-CC-DD-EE-FF", ClientID: "FF:00:11:22:33", Hostname: "phone"}))
+	assert.Equal(t, "hostname:kids-iphone", leaseIdentity(Lease{MacAddr: "AA-BB-CC-DD-EE-FF", ClientID: "01:aa:bb:cc:dd:ee:ff", Hostname: "Kids-iPhone"}), "expected a client ID made from the MAC to be skipped")
+	assert.Empty(t, leaseIdentity(Lease{MacAddr: "AA-BB-CC-DD-EE-FF"}))
+}
+
+func TestServer_Identities(t *testing.T) {
+	orig := fnReadLeasesFile
+	t.Cleanup(func() { fnReadLeasesFile = orig })
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	past := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	fnReadLeasesFile = func(name string) ([]byte, error) {
+		assert.Equal(t, dnsmasqLeasesPath, name)
+		return []byte(
+			future + " 62:11:22:33:44:55 192.168.1.10 Kids-iPhone 01:62:11:22:33:44:55\n" +
+				"0 00:11:22:33:44:55 192.168.1.11 tv *\n" +
+				past + " 00:11:22:33:44:66 192.168.1.12 old-laptop *\n" +
+				future + " 00:11:22:33:44:77 192.168.1.13 * *\n" +
+				"duid 00:01:00:01:2c:7b:1a:2b:00:11:22:33:44:55\n"), nil
+	}
+
+	s := &Server{logger: config.MustGetLogger(), backend: backendDNSMasq}
+	assert.Equal(t, models.MapMACIdentity{
+		"62-11-22-33-44-55": "hostname:kids-iphone",
+		"00-11-22-33-44-55": "hostname:tv",
+	}, s.Identities(), "expected expired leases and leases without an identity to be skipped")
+
+	fnReadLeasesFile = func(string) ([]byte, error) { return nil, os.ErrNotExist }
+	assert.Empty(t, s.Identities(), "expected no identities before dnsmasq has written its leases")
+}
@@ -0,0 +1,242 @@
+package dhcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+var (
+	configFileDHCPLeases = "dhcp-leases.yaml"
+	leasesMutex          = &sync.Mutex{}
+	offerHoldDuration    = time.Minute // offerHoldDuration stops an offered address being offered to another client while the first one decides.
+
+	errNoFreeAddress      = errors.New("no free addresses in the DHCP range")
+	errAddressUnavailable = errors.New("address is not available to this client")
+)
+
+func init() {
+	config.Backups.Register(configFileDHCPLeases, "DHCP leases issued by the native server")
+}
+
+// Lease is an address bound to a client by the native DHCP server.
+type Lease struct {
+	MacAddr  models.MAC `yaml:"macAddr" json:"macAddr"`
+	IpAddr   net.IP     `yaml:"ipAddr" json:"ipAddr"`
+	Hostname string     `yaml:"hostname" json:"hostname"`
+	Expiry   time.Time  `yaml:"expiry" json:"expiry"`
+	ClientID string     `yaml:"clientId,omitempty" json:"clientId,omitempty"` // ClientID is the DHCP client identifier, if dnsmasq recorded one.
+}
+
+type leaseFile struct {
+	Leases []Lease `yaml:"leases"`
+}
+
+func newLeaseFile() *leaseFile {
+	return &leaseFile{Leases: make([]Lease, 0)}
+}
+
+// leasePool allocates addresses between the lower and upper bounds of the DHCP range.
+// Reserved addresses are only given to their MAC, even when they are outside the range.
+type leasePool struct {
+	mu           sync.Mutex
+	lower        uint32
+	upper        uint32
+	excluded     map[uint32]bool       // excluded holds the gateway addresses, which are never allocated dynamically.
+	reservations map[models.MAC]uint32 // reservations maps MACs to their reserved address.
+	reservedBy   map[uint32]models.MAC // reservedBy maps reserved addresses back to their MAC.
+	leases       map[models.MAC]Lease  // leases holds bound leases and pending offers by MAC.
+	offered      map[models.MAC]bool   // offered marks leases that haven't been requested yet and so aren't saved.
+	declined     map[uint32]time.Time  // declined holds addresses that a client found in use, until the time given.
+}
+
+// newLeasePool creates a pool for the given config, restoring previously bound leases.
+// The address of this gateway is reserved for hwAddr.
+func newLeasePool(cfg *DNSMasqConfig, hwAddr net.HardwareAddr, leases []Lease) *leasePool {
+	p := &leasePool{
+		lower:        ipToUint32(cfg.LowerBound),
+		upper:        ipToUint32(cfg.UpperBound),
+		excluded:     make(map[uint32]bool),
+		reservations: make(map[models.MAC]uint32),
+		reservedBy:   make(map[uint32]models.MAC),
+		leases:       make(map[models.MAC]Lease),
+		offered:      make(map[models.MAC]bool),
+		declined:     make(map[uint32]time.Time),
+	}
+	for _, ip := range []net.IP{cfg.DefaultGateway, cfg.ThisGateway} {
+		if ip.To4() != nil {
+			p.excluded[ipToUint32(ip)] = true
+		}
+	}
+	addReservation := func(mac models.MAC, ip net.IP) {
+		if ip.To4() == nil {
+			return
+		}
+		p.reservations[mac] = ipToUint32(ip)
+		p.reservedBy[ipToUint32(ip)] = mac
+	}
+	if hwAddr != nil {
+		addReservation(models.MAC(models.NewMAC(hwAddr.String())), cfg.ThisGateway)
+	}
+	for _, r := range cfg.AddressReservations {
+		addReservation(models.MAC(models.NewMAC(string(r.MacAddr))), r.IpAddr)
+	}
+	for _, l := range leases {
+		if l.IpAddr.To4() == nil {
+			continue
+		}
+		l.MacAddr = models.MAC(models.NewMAC(string(l.MacAddr)))
+		p.leases[l.MacAddr] = l
+	}
+	return p
+}
+
+// offer returns the address to offer to mac, preferring its reservation, then its previous lease, then the
+// address it asked for, then the lowest free address. The address is held for offerHoldDuration.
+func (p *leasePool) offer(mac models.MAC, requested net.IP, hostname string, now time.Time) (net.IP, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ip, err := p.choose(mac, requested, now)
+	if err != nil {
+		return nil, err
+	}
+	if l, ok := p.leases[mac]; ok && !p.offered[mac] && ipToUint32(l.IpAddr) == ip && l.Expiry.After(now) { // if the client already holds this address...
+		return uint32ToIP(ip), nil
+	}
+	p.leases[mac] = Lease{MacAddr: mac, IpAddr: uint32ToIP(ip), Hostname: hostname, Expiry: now.Add(offerHoldDuration)}
+	p.offered[mac] = true
+	return uint32ToIP(ip), nil
+}
+
+func (p *leasePool) choose(mac models.MAC, requested net.IP, now time.Time) (uint32, error) {
+	if ip, ok := p.reservations[mac]; ok {
+		return ip, nil
+	}
+	if l, ok := p.leases[mac]; ok && p.available(mac, ipToUint32(l.IpAddr), now) {
+		return ipToUint32(l.IpAddr), nil
+	}
+	if requested.To4() != nil && p.available(mac, ipToUint32(requested), now) {
+		return ipToUint32(requested), nil
+	}
+	for ip := p.lower; ip <= p.upper && ip >= p.lower; ip++ { // ip >= p.lower stops wrap around at the top of the address space.
+		if p.available(mac, ip, now) {
+			return ip, nil
+		}
+	}
+	return 0, errNoFreeAddress
+}
+
+// available returns true if ip can be given to mac.
+// It should be called under p.mu.
+func (p *leasePool) available(mac models.MAC, ip uint32, now time.Time) bool {
+	if owner, ok := p.reservedBy[ip]; ok {
+		return owner == mac
+	}
+	if ip < p.lower || ip > p.upper || p.excluded[ip] {
+		return false
+	}
+	if until, ok := p.declined[ip]; ok && now.Before(until) {
+		return false
+	}
+	for m, l := range p.leases {
+		if m != mac && ipToUint32(l.IpAddr) == ip && l.Expiry.After(now) {
+			return false
+		}
+	}
+	return true
+}
+
+// bind commits a lease for ip to mac in response to a DHCP request.
+func (p *leasePool) bind(mac models.MAC, ip net.IP, hostname string, now time.Time, duration time.Duration) (Lease, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if ip.To4() == nil || !p.available(mac, ipToUint32(ip), now) {
+		return Lease{}, fmt.Errorf("%w: %v", errAddressUnavailable, ip)
+	}
+	if hostname == "" {
+		hostname = p.leases[mac].Hostname
+	}
+	l := Lease{MacAddr: mac, IpAddr: ip.To4(), Hostname: hostname, Expiry: now.Add(duration)}
+	p.leases[mac] = l
+	delete(p.offered, mac)
+	return l, nil
+}
+
+// knows returns true if mac has a reservation or a lease, even an expired one.
+func (p *leasePool) knows(mac models.MAC) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, reserved := p.reservations[mac]
+	_, leased := p.leases[mac]
+	return reserved || leased
+}
+
+// release drops the lease held by mac for ip.
+func (p *leasePool) release(mac models.MAC, ip net.IP) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l, ok := p.leases[mac]; ok && l.IpAddr.Equal(ip) {
+		delete(p.leases, mac)
+		delete(p.offered, mac)
+		return true
+	}
+	return false
+}
+
+// decline drops the lease held by mac and stops ip being allocated for duration, since another host is using it.
+func (p *leasePool) decline(mac models.MAC, ip net.IP, now time.Time, duration time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if l, ok := p.leases[mac]; ok && l.IpAddr.Equal(ip) {
+		delete(p.leases, mac)
+		delete(p.offered, mac)
+	}
+	if ip.To4() != nil {
+		p.declined[ipToUint32(ip)] = now.Add(duration)
+	}
+}
+
+// boundLeases returns the leases that have been requested by clients and have not expired, sorted by address.
+func (p *leasePool) boundLeases(now time.Time) []Lease {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	leases := make([]Lease, 0, len(p.leases))
+	for mac, l := range p.leases {
+		if p.offered[mac] || !l.Expiry.After(now) {
+			continue
+		}
+		leases = append(leases, l)
+	}
+	slices.SortFunc(leases, func(a, b Lease) int { return compareIP(a.IpAddr.To4(), b.IpAddr.To4()) })
+	return leases
+}
+
+func loadLeases() ([]Lease, error) {
+	f, err := config.GetConfig[*leaseFile](leasesMutex, configFileDHCPLeases, newLeaseFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get DHCP leases: %w", err)
+	}
+	if f == nil {
+		return nil, nil
+	}
+	return f.Leases, nil
+}
+
+func saveLeases(leases []Lease) error {
+	if err := config.SetConfig[*leaseFile](leasesMutex, configFileDHCPLeases, nil, nil, &leaseFile{Leases: leases}); err != nil {
+		return fmt.Errorf("failed to save DHCP leases: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package dhcp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/models"
+)
+
+func newTestPoolConfig() *DNSMasqConfig {
+	return &DNSMasqConfig{
+		DefaultGateway: net.ParseIP("192.168.1.1"),
+		ThisGateway:    net.ParseIP("192.168.1.2"),
+		LowerBound:     net.ParseIP("192.168.1.1"),
+		UpperBound:     net.ParseIP("192.168.1.5"),
+		AddressReservations: []Reservation{
+			{MacAddr: "aa:bb:cc:dd:ee:ff", IpAddr: net.ParseIP("192.168.1.50"), Name: "tv"},
+		},
+	}
+}
+
+func TestLeasePool_Offer(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	hw := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	p := newLeasePool(newTestPoolConfig(), hw, nil)
+
+	ip, err := p.offer("01-01-01-01-01-01", nil, "", now)
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.3", ip.String(), "expected the gateways to be skipped")
+
+	ip, _ = p.offer("02-02-02-02-02-02", nil, "", now)
+	assert.Equal(t, "192.168.1.4", ip.String(), "expected a pending offer to be held")
+
+	ip, _ = p.offer("01-01-01-01-01-01", nil, "", now)
+	assert.Equal(t, "192.168.1.3", ip.String(), "expected a repeat discover to get the same address")
+
+	ip, _ = p.offer("AA-BB-CC-DD-EE-FF", nil, "", now)
+	assert.Equal(t, "192.168.1.50", ip.String(), "expected the reservation to be used outside the range")
+
+	ip, _ = p.offer("00-11-22-33-44-55", nil, "", now)
+	assert.Equal(t, "192.168.1.2", ip.String(), "expected this gateway to be reserved for the local interface")
+
+	ip, _ = p.offer("03-03-03-03-03-03", net.ParseIP("192.168.1.5"), "", now)
+	assert.Equal(t, "192.168.1.5", ip.String(), "expected a free requested address to be used")
+
+	_, err = p.offer("04-04-04-04-04-04", nil, "", now)
+	assert.True(t, errors.Is(err, errNoFreeAddress), "expected the range to be exhausted, got %v", err)
+
+	ip, err = p.offer("04-04-04-04-04-04", nil, "", now.Add(offerHoldDuration))
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.3", ip.String(), "expected expired offers to be reused")
+}
+
+func TestLeasePool_BindReleaseDecline(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	p := newLeasePool(newTestPoolConfig(), nil, []Lease{
+		{MacAddr: "01:01:01:01:01:01", IpAddr: net.ParseIP("192.168.1.3"), Hostname: "laptop", Expiry: now.Add(time.Hour)},
+	})
+	mac := models.MAC("01-01-01-01-01-01")
+
+	_, err := p.bind("02-02-02-02-02-02", net.ParseIP("192.168.1.3"), "", now, time.Hour)
+	assert.True(t, errors.Is(err, errAddressUnavailable), "expected another client's lease to be protected")
+	_, err = p.bind("02-02-02-02-02-02", net.ParseIP("192.168.1.50"), "", now, time.Hour)
+	assert.True(t, errors.Is(err, errAddressUnavailable), "expected another client's reservation to be protected")
+	_, err = p.bind("02-02-02-02-02-02", net.ParseIP("10.0.0.1"), "", now, time.Hour)
+	assert.True(t, errors.Is(err, errAddressUnavailable), "expected addresses outside the range to be refused")
+
+	l, err := p.bind(mac, net.ParseIP("192.168.1.3"), "", now, 2*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, "laptop", l.Hostname, "expected the hostname to be kept on renewal")
+	assert.Equal(t, now.Add(2*time.Hour), l.Expiry)
+	assert.True(t, p.knows(mac))
+
+	offered, _ := p.offer("02-02-02-02-02-02", nil, "", now)
+	assert.Len(t, p.boundLeases(now), 1, "expected pending offers not to be saved")
+	assert.True(t, p.release("02-02-02-02-02-02", offered))
+
+	assert.True(t, p.release(mac, net.ParseIP("192.168.1.3")))
+	assert.Empty(t, p.boundLeases(now))
+	assert.False(t, p.knows(mac))
+
+	p.decline(mac, net.ParseIP("192.168.1.3"), now, declineHoldDuration)
+	ip, _ := p.offer(mac, net.ParseIP("192.168.1.3"), "", now)
+	assert.Equal(t, "192.168.1.4", ip.String(), "expected a declined address to be skipped")
+	ip, _ = p.offer("02-02-02-02-02-02", nil, "", now.Add(declineHoldDuration))
+	assert.Equal(t, "192.168.1.3", ip.String(), "expected a declined address to be reused after the hold")
+}
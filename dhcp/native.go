@@ -0,0 +1,406 @@
+package dhcp
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+	"relloyd/tubetimeout/privilege"
+)
+
+const (
+	backendDNSMasq = "dnsmasq" // backendDNSMasq configures dnsmasq via systemctl and nmcli.
+	backendNative  = "native"  // backendNative serves DHCPv4 from this process.
+)
+
+var (
+	declineHoldDuration = 10 * time.Minute // declineHoldDuration is how long an address declined by a client is left unused.
+	fnNewDHCPv4Server   = newDHCPv4Server  // allow mocking
+)
+
+// dhcpv4Server is implemented by server4.Server.
+type dhcpv4Server interface {
+	Serve() error
+	Close() error
+}
+
+func newDHCPv4Server(ifaceName string, handler server4.Handler) (dhcpv4Server, error) {
+	return server4.NewServer(ifaceName, &net.UDPAddr{IP: net.IPv4zero, Port: dhcpv4.ServerPort}, handler)
+}
+
+// newRestarter returns the service that starts and stops the DHCP server for the given backend.
+func newRestarter(logger *zap.SugaredLogger, backend string) (restarter, error) {
+	switch backend {
+	case backendDNSMasq, "":
+		return defaultDhcpService, nil
+	case backendNative:
+		return newNativeService(logger), nil
+	}
+	return nil, fmt.Errorf("unknown DHCP backend %q: expected %q or %q", backend, backendDNSMasq, backendNative)
+}
+
+// CheckPrivileges returns an error if the DHCP backend can't manage the DHCP server with the privileges given.
+// The native backend binds the DHCP ports and sets the interface address itself, while the dnsmasq backend restarts
+// dnsmasq with systemctl and changes connections with nmcli, which needs root.
+func CheckPrivileges(p privilege.Privileges) error {
+	if config.AppCfg.DHCPConfig.Backend == backendNative {
+		return p.Require(privilege.CapNetAdmin, privilege.CapNetBindService, privilege.CapNetRaw)
+	}
+	if !p.Root {
+		return fmt.Errorf("the %v backend needs root, set DHCP_BACKEND=%v to serve DHCP without it", backendDNSMasq, backendNative)
+	}
+	return nil
+}
+
+// nativeOptions are the values handed out to clients, copied from the DHCP settings when the server starts.
+type nativeOptions struct {
+	serverID      net.IP
+	router        net.IP
+	netmask       net.IPMask
+	dnsIPs        []net.IP
+	leaseDuration time.Duration
+}
+
+// nativeService implements the restarter interface using a DHCPv4 server built into this process, so that it works
+// on systems without dnsmasq, systemd or NetworkManager. Leases are saved to configFileDHCPLeases.
+type nativeService struct {
+	dhcpService // dhcpService supplies the probe for other DHCP servers on the network.
+
+	logger     *zap.SugaredLogger
+	mu         sync.Mutex
+	srv        dhcpv4Server
+	ifaceName  string
+	pool       *leasePool
+	opts       nativeOptions
+	staticAddr string                   // staticAddr is the address added to the interface by setStaticIP, or empty if it was already there.
+	conflict   bool                     // conflict is true while the router's DHCP server is running alongside ours.
+	naks       map[models.MAC]time.Time // naks holds when each device was last sent a NAK to mitigate the conflict.
+	saveMu     sync.Mutex               // saveMu keeps lease snapshots in order when they are saved from concurrent handlers.
+}
+
+func newNativeService(logger *zap.SugaredLogger) *nativeService {
+	return &nativeService{logger: logger.With("dhcpBackend", backendNative)}
+}
+
+func (n *nativeService) isDnsmasqServiceActive() (bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.srv != nil, nil
+}
+
+// setStaticIP adds thisGateway to the interface and points the default route at the default gateway, so that this
+// device keeps its address once the router stops issuing leases.
+func (n *nativeService) setStaticIP(logger *zap.SugaredLogger, ifaceName string, cfg *DNSMasqConfig, fnFinder cidrFinderFunc) (err error) {
+	if cfg == nil {
+		return fmt.Errorf("no config provided")
+	}
+
+	_, cidr := fnFinder(cfg.LowerBound, cfg.UpperBound)
+	addr := cfg.ThisGateway.To4().String() + "/" + cidr
+	defer func() { publishStaticIP(EventStaticIPSet, addr+" on "+ifaceName, err) }()
+
+	var output []byte
+	output, err = config.Commands.Query("ip", "-4", "addr", "show", "dev", ifaceName)
+	if err != nil {
+		return fmt.Errorf("error listing addresses on %v: %v: %w", ifaceName, strings.TrimSpace(string(output)), err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !strings.Contains(string(output), " "+addr+" ") { // if the address needs adding...
+		logger.Infof("Adding address %v to %v", addr, ifaceName)
+		if output, err = config.Commands.Change("ip", "addr", "add", addr, "dev", ifaceName); err != nil {
+			return fmt.Errorf("error adding address %v to %v: %v: %w", addr, ifaceName, strings.TrimSpace(string(output)), err)
+		}
+		n.staticAddr = addr
+	}
+
+	if output, err = config.Commands.Change("ip", "route", "replace", "default", "via", cfg.DefaultGateway.To4().String(), "dev", ifaceName); err != nil {
+		return fmt.Errorf("error setting default route via %v: %v: %w", cfg.DefaultGateway, strings.TrimSpace(string(output)), err)
+	}
+	return nil
+}
+
+// unsetStaticIP removes the address added by setStaticIP.
+func (n *nativeService) unsetStaticIP(logger *zap.SugaredLogger, ifaceName string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.staticAddr == "" {
+		return nil
+	}
+	logger.Infof("Removing address %v from %v", n.staticAddr, ifaceName)
+	if output, err := config.Commands.Change("ip", "addr", "del", n.staticAddr, "dev", ifaceName); err != nil {
+		err = fmt.Errorf("error removing address %v from %v: %v: %w", n.staticAddr, ifaceName, strings.TrimSpace(string(output)), err)
+		publishStaticIP(EventStaticIPUnset, n.staticAddr+" on "+ifaceName, err)
+		return err
+	}
+	publishStaticIP(EventStaticIPUnset, n.staticAddr+" on "+ifaceName, nil)
+	n.staticAddr = ""
+	return nil
+}
+
+// startDnsmasq applies cfg and (re)starts the native server on ifaceName.
+// The name matches the restarter interface; dnsmasq isn't used.
+func (n *nativeService) startDnsmasq(logger *zap.SugaredLogger, cfg *DNSMasqConfig, ifaceName string, hwAddr net.HardwareAddr) (err error) {
+	defer func() {
+		if err != nil {
+			if unSetErr := n.unsetStaticIP(logger, ifaceName); unSetErr != nil {
+				err = fmt.Errorf("%v: also failed to unset static IP on interface %v: %w", err, ifaceName, unSetErr)
+			}
+		}
+	}()
+
+	if err = n.setStaticIP(logger, ifaceName, cfg, findSmallestSingleCIDR); err != nil {
+		return fmt.Errorf("startDnsmasq: %w", err)
+	}
+	if err = n.configure(cfg, ifaceName, hwAddr); err != nil {
+		return err
+	}
+	if err = n.setDnsmasqServiceState(serviceRestart); err != nil {
+		return fmt.Errorf("error starting native DHCP server: %w", err)
+	}
+	if config.Current().IPv6Config.RouterAdvertisements {
+		logger.Warn("IPv6 router advertisements are only sent by the dnsmasq backend")
+	}
+	logger.Info("Native DHCP server started successfully")
+	return nil
+}
+
+// configure copies the options to hand out from cfg and rebuilds the lease pool, keeping existing leases.
+func (n *nativeService) configure(cfg *DNSMasqConfig, ifaceName string, hwAddr net.HardwareAddr) error {
+	_, cidr := findSmallestSingleCIDR(cfg.LowerBound, cfg.UpperBound)
+	prefixLen, err := strconv.Atoi(cidr)
+	if err != nil {
+		return fmt.Errorf("failed to find the subnet for %v-%v: %w", cfg.LowerBound, cfg.UpperBound, err)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var leases []Lease
+	if n.pool != nil { // if leases are already loaded...
+		leases = n.pool.boundLeases(time.Now())
+	} else if leases, err = loadLeases(); err != nil {
+		return err
+	}
+
+	dnsIPs := cfg.DnsIPs
+	if ip := clientDNSServer(cfg.ThisGateway); ip != nil {
+		dnsIPs = []net.IP{ip}
+	}
+	n.ifaceName = ifaceName
+	n.pool = newLeasePool(cfg, hwAddr, leases)
+	n.opts = nativeOptions{
+		serverID:      cfg.ThisGateway.To4(),
+		router:        cfg.ThisGateway.To4(),
+		netmask:       net.CIDRMask(prefixLen, 32),
+		dnsIPs:        dnsIPs,
+		leaseDuration: config.Current().DHCPConfig.LeaseDuration,
+	}
+	return nil
+}
+
+// setDnsmasqServiceState starts, restarts or stops the native server.
+// The name matches the restarter interface; dnsmasq isn't used.
+func (n *nativeService) setDnsmasqServiceState(action systemctlAction) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.srv != nil {
+		_ = n.srv.Close()
+		n.srv = nil
+	}
+	if action == serviceStop {
+		return nil
+	}
+	if n.pool == nil {
+		return fmt.Errorf("native DHCP server is not configured")
+	}
+	if config.AppCfg.DryRun { // if leases mustn't be handed out...
+		n.logger.Infof("Dry run: would serve DHCP on %v", n.ifaceName)
+		return nil
+	}
+
+	srv, err := fnNewDHCPv4Server(n.ifaceName, n.serveDHCP)
+	if err != nil {
+		return fmt.Errorf("failed to listen for DHCP requests on %v: %w", n.ifaceName, err)
+	}
+	n.srv = srv
+	go func() {
+		if err := srv.Serve(); err != nil {
+			n.logger.Debugf("Native DHCP server stopped: %v", err) // Serve always returns an error once closed.
+		}
+	}()
+	return nil
+}
+
+// serveDHCP is the server4.Handler that answers each request.
+func (n *nativeService) serveDHCP(conn net.PacketConn, peer net.Addr, req *dhcpv4.DHCPv4) {
+	resp, err := n.reply(req, time.Now())
+	if err != nil {
+		n.logger.Warnf("Failed to answer DHCP %v from %v: %v", req.MessageType(), req.ClientHWAddr, err)
+		return
+	}
+	if resp == nil {
+		return
+	}
+
+	if req.GatewayIPAddr != nil && !req.GatewayIPAddr.IsUnspecified() { // if the request came via a relay...
+		peer = &net.UDPAddr{IP: req.GatewayIPAddr, Port: dhcpv4.ServerPort}
+	} else if resp.MessageType() == dhcpv4.MessageTypeNak { // NAKs are broadcast since the client address may be invalid.
+		peer = &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+	}
+	if _, err = conn.WriteTo(resp.ToBytes(), peer); err != nil {
+		n.logger.Warnf("Failed to send DHCP %v to %v: %v", resp.MessageType(), peer, err)
+	}
+}
+
+// reply returns the response to req, or nil if the request should be ignored.
+func (n *nativeService) reply(req *dhcpv4.DHCPv4, now time.Time) (*dhcpv4.DHCPv4, error) {
+	if req.OpCode != dhcpv4.OpcodeBootRequest {
+		return nil, nil
+	}
+
+	cfg := config.Current().DHCPConfig
+	n.mu.Lock()
+	pool, opts := n.pool, n.opts
+	conflict := n.conflict && cfg.ConflictMitigation
+	n.mu.Unlock()
+	if pool == nil {
+		return nil, nil
+	}
+	if d := cfg.ConflictLeaseDuration; conflict && d > 0 && d < opts.leaseDuration { // if leases should be cut short...
+		opts.leaseDuration = d
+	}
+
+	mac := models.MAC(models.NewMAC(req.ClientHWAddr.String()))
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeDiscover:
+		ip, err := pool.offer(mac, req.RequestedIPAddress(), req.HostName(), now)
+		if err != nil {
+			return nil, err
+		}
+		return newNativeReply(req, opts, dhcpv4.MessageTypeOffer, ip)
+
+	case dhcpv4.MessageTypeRequest:
+		ip := req.RequestedIPAddress()
+		if ip == nil || ip.IsUnspecified() { // if the client is renewing...
+			ip = req.ClientIPAddr
+		}
+		if sid := req.ServerIdentifier(); sid != nil && !sid.Equal(opts.serverID) { // if the client chose another server...
+			pool.release(mac, ip)
+			return nil, nil
+		}
+		if req.ServerIdentifier() == nil && !pool.knows(mac) { // if an unknown client is confirming a lease from another server...
+			if conflict && n.shouldNAK(mac, now) { // if it should start over to be won from the router...
+				n.logger.Infof("Sending DHCP NAK to %v for %v while the router's DHCP server is running", mac, ip)
+				return newNativeReply(req, opts, dhcpv4.MessageTypeNak, nil)
+			}
+			return nil, nil
+		}
+		lease, err := pool.bind(mac, ip, req.HostName(), now, opts.leaseDuration)
+		if err != nil {
+			n.logger.Infof("Declining DHCP request from %v: %v", mac, err)
+			return newNativeReply(req, opts, dhcpv4.MessageTypeNak, nil)
+		}
+		n.saveLeases(pool, now)
+		n.logger.Debugf("Leased %v to %v (%v) until %v", lease.IpAddr, mac, lease.Hostname, lease.Expiry)
+		return newNativeReply(req, opts, dhcpv4.MessageTypeAck, lease.IpAddr)
+
+	case dhcpv4.MessageTypeRelease:
+		if pool.release(mac, req.ClientIPAddr) {
+			n.saveLeases(pool, now)
+		}
+		return nil, nil
+
+	case dhcpv4.MessageTypeDecline:
+		n.logger.Warnf("Client %v declined %v since it is already in use", mac, req.RequestedIPAddress())
+		pool.decline(mac, req.RequestedIPAddress(), now, declineHoldDuration)
+		n.saveLeases(pool, now)
+		return nil, nil
+
+	case dhcpv4.MessageTypeInform:
+		return newNativeReply(req, opts, dhcpv4.MessageTypeAck, nil)
+	}
+	return nil, nil
+}
+
+// mitigateConflict implements the conflictMitigator interface. While active, reply follows the conflict settings in
+// the DHCP config.
+func (n *nativeService) mitigateConflict(active bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.conflict = active
+	n.naks = nil
+}
+
+// shouldNAK returns true if mac is allowed a NAK for a lease from another server, and records the time so that it
+// isn't sent another within the interval.
+func (n *nativeService) shouldNAK(mac models.MAC, now time.Time) bool {
+	cfg := config.Current().DHCPConfig
+	if !cfg.ConflictNAK {
+		return false
+	}
+	if len(cfg.ConflictNAKMACs) > 0 && !slices.ContainsFunc(cfg.ConflictNAKMACs, func(m string) bool {
+		return models.MAC(models.NewMAC(strings.TrimSpace(m))) == mac
+	}) { // if mac isn't one of the devices to NAK...
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if last, ok := n.naks[mac]; ok && now.Sub(last) < cfg.ConflictNAKInterval {
+		return false
+	}
+	if n.naks == nil {
+		n.naks = make(map[models.MAC]time.Time)
+	}
+	n.naks[mac] = now
+	return true
+}
+
+func (n *nativeService) saveLeases(pool *leasePool, now time.Time) {
+	n.saveMu.Lock()
+	defer n.saveMu.Unlock()
+	if err := saveLeases(pool.boundLeases(now)); err != nil {
+		n.logger.Errorf("%v", err)
+	}
+}
+
+// newNativeReply builds a response of type mt offering ip. NAKs and replies to INFORM don't carry a lease.
+func newNativeReply(req *dhcpv4.DHCPv4, opts nativeOptions, mt dhcpv4.MessageType, ip net.IP) (*dhcpv4.DHCPv4, error) {
+	modifiers := []dhcpv4.Modifier{
+		dhcpv4.WithMessageType(mt),
+		dhcpv4.WithServerIP(opts.serverID),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(opts.serverID)),
+	}
+	if mt != dhcpv4.MessageTypeNak {
+		modifiers = append(modifiers,
+			dhcpv4.WithNetmask(opts.netmask),
+			dhcpv4.WithRouter(opts.router),
+			dhcpv4.WithDNS(opts.dnsIPs...),
+		)
+	}
+	if ip != nil {
+		modifiers = append(modifiers,
+			dhcpv4.WithYourIP(ip),
+			dhcpv4.WithLeaseTime(uint32(opts.leaseDuration.Seconds())),
+		)
+	}
+	resp, err := dhcpv4.NewReplyFromRequest(req, modifiers...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build DHCP %v: %w", mt, err)
+	}
+	return resp, nil
+}
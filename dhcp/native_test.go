@@ -0,0 +1,244 @@
+package dhcp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/privilege"
+)
+
+type fakeDHCPv4Server struct {
+	closed chan struct{}
+}
+
+func (f *fakeDHCPv4Server) Serve() error {
+	<-f.closed
+	return net.ErrClosed
+}
+
+func (f *fakeDHCPv4Server) Close() error {
+	close(f.closed)
+	return nil
+}
+
+func setupNativeService(t *testing.T) (*nativeService, string) {
+	t.Helper()
+	dir := t.TempDir()
+	orig := config.DefaultStore
+	config.DefaultStore = config.NewStore(dir)
+	t.Cleanup(func() { config.DefaultStore = orig })
+
+	cfg := newTestPoolConfig()
+	cfg.DnsIPs = []net.IP{net.ParseIP("1.1.1.1")}
+	n := newNativeService(config.MustGetLogger())
+	assert.NoError(t, n.configure(cfg, "eth0", net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}))
+	return n, dir
+}
+
+func newTestRequest(t *testing.T, mt dhcpv4.MessageType, hw net.HardwareAddr, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	t.Helper()
+	req, err := dhcpv4.New(append([]dhcpv4.Modifier{
+		dhcpv4.WithHwAddr(hw),
+		dhcpv4.WithMessageType(mt),
+	}, modifiers...)...)
+	assert.NoError(t, err)
+	return req
+}
+
+func TestNativeService_Reply(t *testing.T) {
+	n, dir := setupNativeService(t)
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	client := net.HardwareAddr{0x01, 0x01, 0x01, 0x01, 0x01, 0x01}
+	serverID := net.ParseIP("192.168.1.2")
+
+	// DISCOVER gets an OFFER with the options.
+	offer, err := n.reply(newTestRequest(t, dhcpv4.MessageTypeDiscover, client, dhcpv4.WithOption(dhcpv4.OptHostName("laptop"))), now)
+	assert.NoError(t, err)
+	assert.Equal(t, dhcpv4.MessageTypeOffer, offer.MessageType())
+	assert.Equal(t, "192.168.1.3", offer.YourIPAddr.String())
+	assert.Equal(t, "192.168.1.2", offer.ServerIdentifier().String())
+	assert.Equal(t, []net.IP{serverID.To4()}, offer.Router())
+	assert.Equal(t, "1.1.1.1", offer.DNS()[0].String())
+	assert.Equal(t, net.CIDRMask(29, 32), offer.SubnetMask())
+	assert.Equal(t, config.AppCfg.DHCPConfig.LeaseDuration, offer.IPAddressLeaseTime(0))
+
+	// REQUEST for the offer gets an ACK and the lease is saved.
+	ack, err := n.reply(newTestRequest(t, dhcpv4.MessageTypeRequest, client,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(offer.YourIPAddr)),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(serverID))), now)
+	assert.NoError(t, err)
+	assert.Equal(t, dhcpv4.MessageTypeAck, ack.MessageType())
+	assert.Equal(t, "192.168.1.3", ack.YourIPAddr.String())
+	data, err := os.ReadFile(filepath.Join(dir, configFileDHCPLeases))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "192.168.1.3")
+	assert.Contains(t, string(data), "laptop", "expected the hostname from the discover to be saved")
+
+	// REQUEST for an address in use by another client gets a NAK.
+	other := net.HardwareAddr{0x02, 0x02, 0x02, 0x02, 0x02, 0x02}
+	nak, err := n.reply(newTestRequest(t, dhcpv4.MessageTypeRequest, other,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP("192.168.1.3"))),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(serverID))), now)
+	assert.NoError(t, err)
+	assert.Equal(t, dhcpv4.MessageTypeNak, nak.MessageType())
+	assert.True(t, nak.YourIPAddr.IsUnspecified())
+
+	// REQUEST for another server's offer is ignored.
+	resp, err := n.reply(newTestRequest(t, dhcpv4.MessageTypeRequest, other,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP("192.168.1.4"))),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.ParseIP("192.168.1.1")))), now)
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	// INIT-REBOOT from a client we have no record of is ignored.
+	resp, err = n.reply(newTestRequest(t, dhcpv4.MessageTypeRequest, other,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP("192.168.1.100")))), now)
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+
+	// Renewal via ciaddr gets an ACK.
+	ack, err = n.reply(newTestRequest(t, dhcpv4.MessageTypeRequest, client, dhcpv4.WithClientIP(net.ParseIP("192.168.1.3"))), now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, dhcpv4.MessageTypeAck, ack.MessageType())
+
+	// RELEASE drops the saved lease.
+	resp, err = n.reply(newTestRequest(t, dhcpv4.MessageTypeRelease, client, dhcpv4.WithClientIP(net.ParseIP("192.168.1.3"))), now)
+	assert.NoError(t, err)
+	assert.Nil(t, resp)
+	data, _ = os.ReadFile(filepath.Join(dir, configFileDHCPLeases))
+	assert.NotContains(t, string(data), "192.168.1.3")
+
+	// INFORM gets options without a lease.
+	ack, err = n.reply(newTestRequest(t, dhcpv4.MessageTypeInform, other, dhcpv4.WithClientIP(net.ParseIP("192.168.1.9"))), now)
+	assert.NoError(t, err)
+	assert.Equal(t, dhcpv4.MessageTypeAck, ack.MessageType())
+	assert.True(t, ack.YourIPAddr.IsUnspecified())
+	assert.Equal(t, []net.IP{serverID.To4()}, ack.Router())
+}
+
+func TestNativeService_LeasesSurviveRestart(t *testing.T) {
+	n, _ := setupNativeService(t)
+	now := time.Now()
+	client := net.HardwareAddr{0x01, 0x01, 0x01, 0x01, 0x01, 0x01}
+
+	_, err := n.reply(newTestRequest(t, dhcpv4.MessageTypeRequest, client,
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP("192.168.1.4"))),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.ParseIP("192.168.1.2")))), now)
+	assert.NoError(t, err)
+
+	restarted := newNativeService(config.MustGetLogger())
+	assert.NoError(t, restarted.configure(newTestPoolConfig(), "eth0", nil))
+	offer, err := restarted.reply(newTestRequest(t, dhcpv4.MessageTypeDiscover, client), now)
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.4", offer.YourIPAddr.String(), "expected the saved lease to be offered again")
+}
+
+func TestNativeService_ConflictMitigation(t *testing.T) {
+	orig := config.AppCfg.DHCPConfig
+	t.Cleanup(func() { config.AppCfg.DHCPConfig = orig })
+	config.AppCfg.DHCPConfig.ConflictMitigation = true
+	config.AppCfg.DHCPConfig.ConflictLeaseDuration = 10 * time.Minute
+	config.AppCfg.DHCPConfig.ConflictNAK = true
+	config.AppCfg.DHCPConfig.ConflictNAKInterval = 15 * time.Minute
+	config.AppCfg.DHCPConfig.ConflictNAKMACs = []string{"02:02:02:02:02:02"}
+
+	n, _ := setupNativeService(t)
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	client := net.HardwareAddr{0x01, 0x01, 0x01, 0x01, 0x01, 0x01}
+	tracked := net.HardwareAddr{0x02, 0x02, 0x02, 0x02, 0x02, 0x02}
+	initReboot := func(hw net.HardwareAddr, at time.Time) *dhcpv4.DHCPv4 {
+		resp, err := n.reply(newTestRequest(t, dhcpv4.MessageTypeRequest, hw,
+			dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.ParseIP("192.168.1.100")))), at)
+		assert.NoError(t, err)
+		return resp
+	}
+
+	// Expect nothing to change until the router's DHCP server is found.
+	assert.Nil(t, initReboot(tracked, now))
+	offer, err := n.reply(newTestRequest(t, dhcpv4.MessageTypeDiscover, client), now)
+	assert.NoError(t, err)
+	assert.Equal(t, config.AppCfg.DHCPConfig.LeaseDuration, offer.IPAddressLeaseTime(0))
+
+	n.mitigateConflict(true)
+	offer, err = n.reply(newTestRequest(t, dhcpv4.MessageTypeDiscover, client), now)
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, offer.IPAddressLeaseTime(0), "expected a short lease during the conflict")
+
+	resp := initReboot(tracked, now)
+	if assert.NotNil(t, resp, "expected a NAK for the router's lease") {
+		assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+	}
+	assert.Nil(t, initReboot(tracked, now.Add(time.Minute)), "expected at most one NAK per interval")
+	assert.NotNil(t, initReboot(tracked, now.Add(16*time.Minute)))
+	assert.Nil(t, initReboot(net.HardwareAddr{0x03, 0x03, 0x03, 0x03, 0x03, 0x03}, now), "expected only the listed MACs to be sent a NAK")
+
+	config.AppCfg.DHCPConfig.ConflictNAK = false
+	assert.Nil(t, initReboot(tracked, now.Add(time.Hour)), "expected no NAKs unless they're enabled")
+
+	n.mitigateConflict(false)
+	offer, err = n.reply(newTestRequest(t, dhcpv4.MessageTypeDiscover, client), now)
+	assert.NoError(t, err)
+	assert.Equal(t, config.AppCfg.DHCPConfig.LeaseDuration, offer.IPAddressLeaseTime(0), "expected full leases once the conflict ends")
+}
+
+func TestNativeService_StartStop(t *testing.T) {
+	cmds := config.UseFakeExec(t)
+	cmds.Results = map[string]config.FakeResult{
+		"ip -4 addr show dev eth0": {Output: "2: eth0: <BROADCAST>\n    inet 192.168.1.20/24 brd 192.168.1.255 scope global dynamic eth0\n"},
+	}
+	origNewServer := fnNewDHCPv4Server
+	var srv *fakeDHCPv4Server
+	fnNewDHCPv4Server = func(ifaceName string, handler server4.Handler) (dhcpv4Server, error) {
+		assert.Equal(t, "eth0", ifaceName)
+		srv = &fakeDHCPv4Server{closed: make(chan struct{})}
+		return srv, nil
+	}
+	defer func() { fnNewDHCPv4Server = origNewServer }()
+
+	n, _ := setupNativeService(t)
+	logger := config.MustGetLogger()
+	cfg := newTestPoolConfig()
+
+	assert.NoError(t, n.startDnsmasq(logger, cfg, "eth0", nil))
+	active, _ := n.isDnsmasqServiceActive()
+	assert.True(t, active)
+	assert.Equal(t, []string{
+		"ip -4 addr show dev eth0",
+		"ip addr add 192.168.1.2/29 dev eth0",
+		"ip route replace default via 192.168.1.1 dev eth0",
+	}, cmds.Calls())
+
+	assert.NoError(t, n.unsetStaticIP(logger, "eth0"))
+	assert.NoError(t, n.setDnsmasqServiceState(serviceStop))
+	active, _ = n.isDnsmasqServiceActive()
+	assert.False(t, active)
+	assert.Equal(t, "ip addr del 192.168.1.2/29 dev eth0", cmds.Calls()[len(cmds.Calls())-1])
+	select {
+	case <-srv.closed:
+	default:
+		t.Error("expected the server to be closed")
+	}
+}
+
+func TestCheckPrivileges(t *testing.T) {
+	orig := config.AppCfg.DHCPConfig.Backend
+	t.Cleanup(func() { config.AppCfg.DHCPConfig.Backend = orig })
+
+	config.AppCfg.DHCPConfig.Backend = backendDNSMasq
+	assert.NoError(t, CheckPrivileges(privilege.New(true)))
+	assert.Error(t, CheckPrivileges(privilege.New(false, privilege.CapNetAdmin, privilege.CapNetBindService, privilege.CapNetRaw)), "expected dnsmasq to need root")
+
+	config.AppCfg.DHCPConfig.Backend = backendNative
+	assert.NoError(t, CheckPrivileges(privilege.New(false, privilege.CapNetAdmin, privilege.CapNetBindService, privilege.CapNetRaw)))
+	err := CheckPrivileges(privilege.New(false, privilege.CapNetAdmin))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "missing CAP_NET_BIND_SERVICE, CAP_NET_RAW")
+	}
+}
@@ -5,13 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"os/exec"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
 )
 
 // dhcpService implements the restarter interface.
@@ -144,7 +144,8 @@ func (d *dhcpService) startDnsmasq(logger *zap.SugaredLogger, cfg *DNSMasqConfig
 	}
 
 	var dat string
-	dat, err = generateDnsmasqConfig(ifaceName, cfg.ThisGateway, cfg.LowerBound, cfg.UpperBound, hwAddr.String(), cfg.DnsIPs, cfg.AddressReservations)
+	app := config.Current()
+	dat, err = generateDnsmasqConfig(ifaceName, cfg.ThisGateway, cfg.LowerBound, cfg.UpperBound, hwAddr.String(), cfg.DnsIPs, cfg.AddressReservations, &app.DNSBlockConfig, cfg.blockedDomains, clientDNSServer(cfg.ThisGateway), &app.IPv6Config)
 	if err != nil {
 		err = fmt.Errorf("error generating dnsmasq config: %v", err)
 		return
@@ -202,15 +203,20 @@ func (d *dhcpService) setStaticIP(logger *zap.SugaredLogger, ifaceName string, c
 		"ipv6.method", "disabled",
 	}
 	logger.Infof("Configuring device: %v %v", cmd, strings.Join(args, " "))
-	output, err := exec.Command(cmd, args...).CombinedOutput()
+	output, err := config.Commands.Change(cmd, args...)
 	if err != nil {
-		return fmt.Errorf("error setting static IP: %v: %v", string(output), err)
+		err = fmt.Errorf("error setting static IP: %v: %v", string(output), err)
+	}
+	publishStaticIP(EventStaticIPSet, fmt.Sprintf("%v/%v on %v", cfg.ThisGateway.To4(), cidr, ifaceName), err)
+	if err != nil {
+		return err
 	}
 	logger.Infof("Command output: %v", strings.TrimRight(string(output), "\n"))
 	return nil
 }
 
-func (d *dhcpService) unsetStaticIP(logger *zap.SugaredLogger, ifaceName string) error {
+func (d *dhcpService) unsetStaticIP(logger *zap.SugaredLogger, ifaceName string) (err error) {
+	defer func() { publishStaticIP(EventStaticIPUnset, "DHCP on "+ifaceName, err) }()
 	logger = logger.With("mode", "unsetting static IP")
 	cmd := "nmcli"
 
@@ -223,7 +229,8 @@ func (d *dhcpService) unsetStaticIP(logger *zap.SugaredLogger, ifaceName string)
 		"ipv4.dns", "",
 	}
 	logger.Infof("Configuring device: %v %v", cmd, strings.Join(args, " "))
-	output, err := exec.Command(cmd, args...).CombinedOutput()
+	var output []byte
+	output, err = config.Commands.Change(cmd, args...)
 	if err != nil {
 		return fmt.Errorf("error unsetting static IP: %v: %v", string(output), err)
 	}
@@ -234,7 +241,7 @@ func (d *dhcpService) unsetStaticIP(logger *zap.SugaredLogger, ifaceName string)
 		"dev", "up", ifaceName,
 	}
 	logger.Infof("Upping device: %v %v", cmd, strings.Join(args, " "))
-	output, err = exec.Command(cmd, args...).CombinedOutput()
+	output, err = config.Commands.Change(cmd, args...)
 	if err != nil {
 		return fmt.Errorf("error unsetting static IP: %v: %v", string(output), err)
 	}
@@ -244,8 +251,7 @@ func (d *dhcpService) unsetStaticIP(logger *zap.SugaredLogger, ifaceName string)
 }
 
 func (d *dhcpService) isDnsmasqServiceActive() (bool, error) {
-	cmd := exec.Command("systemctl", "is-active", "dnsmasq")
-	output, err := cmd.CombinedOutput()
+	output, err := config.Commands.Query("systemctl", "is-active", "dnsmasq")
 	outStr := strings.TrimSpace(string(output))
 	// Check output before err since return code 3 = "inactive" while 0 = "active".
 	if outStr == "active" {
@@ -261,8 +267,10 @@ func (d *dhcpService) isDnsmasqServiceActive() (bool, error) {
 
 // setDnsmasqServiceState restarts the dnsmasq service so that the new config takes effect.
 func (d *dhcpService) setDnsmasqServiceState(action systemctlAction) error {
-	cmd := exec.Command("sudo", "systemctl", string(action), "dnsmasq")
-	return cmd.Run()
+	if output, err := config.Commands.Change("sudo", "systemctl", string(action), "dnsmasq"); err != nil {
+		return fmt.Errorf("%w: %v", err, strings.TrimSpace(string(output)))
+	}
+	return nil
 }
 
 func (d *dhcpService) getLocalIP() net.IP { // new method to get local IP
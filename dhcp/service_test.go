@@ -0,0 +1,64 @@
+package dhcp
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+)
+
+func TestDhcpService_StartDnsmasq(t *testing.T) {
+	cmds := config.UseFakeExec(t)
+	cmds.Results = map[string]config.FakeResult{
+		"systemctl is-active dnsmasq": {Output: "active\n"},
+	}
+	cfg := newTestPoolConfig()
+	cfg.UpperBound = net.ParseIP("192.168.1.6")
+	cfg.DnsIPs = []net.IP{net.ParseIP("1.1.1.1"), net.ParseIP("8.8.8.8")}
+	hwAddr, _ := net.ParseMAC("00:11:22:33:44:55")
+
+	d := &dhcpService{}
+	assert.NoError(t, d.startDnsmasq(config.MustGetLogger(), cfg, "eth0", hwAddr))
+	assert.Equal(t, []string{
+		`nmcli dev mod eth0 ipv4.method manual ipv4.gateway 192.168.1.1 ipv4.addr 192.168.1.2/29 ipv4.dns "1.1.1.1 8.8.8.8" ipv6.method disabled`,
+		"sudo systemctl restart dnsmasq",
+		"systemctl is-active dnsmasq",
+	}, cmds.Calls())
+	written, ok := cmds.File(configFileDNSMasqService)
+	assert.True(t, ok, "expected the dnsmasq config to be written")
+	assert.Contains(t, written, "dhcp-range=192.168.1.1,192.168.1.6,12h")
+}
+
+func TestDhcpService_StartDnsmasqFails(t *testing.T) {
+	cmds := config.UseFakeExec(t)
+	cmds.Results = map[string]config.FakeResult{
+		"sudo systemctl restart dnsmasq": {Output: "Job for dnsmasq.service failed.\n", Err: errors.New("exit status 1")},
+	}
+	cfg := newTestPoolConfig()
+	cfg.DnsIPs = []net.IP{net.ParseIP("1.1.1.1"), net.ParseIP("8.8.8.8")}
+
+	d := &dhcpService{}
+	err := d.startDnsmasq(config.MustGetLogger(), cfg, "eth0", nil)
+	assert.ErrorContains(t, err, "Job for dnsmasq.service failed.")
+	calls := cmds.Calls()
+	assert.Equal(t, `nmcli dev mod eth0 ipv4.method auto ipv4.gateway "" ipv4.addr "" ipv4.dns ""`, calls[len(calls)-2], "expected the static IP to be unset again")
+	assert.Equal(t, "nmcli dev up eth0", calls[len(calls)-1])
+}
+
+func TestDhcpService_IsDnsmasqServiceActive(t *testing.T) {
+	cmds := config.UseFakeExec(t)
+	d := &dhcpService{}
+	for output, want := range map[string]bool{"active\n": true, "inactive\n": false, "": false} {
+		cmds.Results = map[string]config.FakeResult{"systemctl is-active dnsmasq": {Output: output}}
+		active, err := d.isDnsmasqServiceActive()
+		assert.NoError(t, err)
+		assert.Equal(t, want, active, strings.TrimSpace(output))
+	}
+
+	cmds.Results = map[string]config.FakeResult{"systemctl is-active dnsmasq": {Output: "failed\n", Err: errors.New("exit status 3")}}
+	_, err := d.isDnsmasqServiceActive()
+	assert.Error(t, err)
+}
@@ -0,0 +1,54 @@
+package dhcp
+
+import (
+	"fmt"
+	"net"
+	"slices"
+)
+
+var fnSubnetBounds = getSubnetBoundsForInterface // allow mocking
+
+// Network is the network the gateway is on, as detected for the setup wizard.
+type Network struct {
+	Interface      string `json:"interface"`
+	HardwareAddr   string `json:"hardwareAddr"`
+	DefaultGateway net.IP `json:"defaultGateway"` // DefaultGateway is the router.
+	SubnetLower    net.IP `json:"subnetLower"`    // SubnetLower is the first usable address of the interface's subnet.
+	SubnetUpper    net.IP `json:"subnetUpper"`    // SubnetUpper is the last usable address of the interface's subnet.
+}
+
+// ProposeConfig detects the network again and proposes the DHCP settings for it: the larger part of the subnet on
+// either side of the router, with this gateway at its top, and the DNS servers and reservations already saved.
+// Nothing is saved.
+func (s *Server) ProposeConfig() (Network, *DNSMasqConfig, error) {
+	gateway, err := getDefaultGateway()
+	if err != nil {
+		return Network{}, nil, fmt.Errorf("failed to get default gateway: %w", err)
+	}
+	lower, upper, err := fnSubnetBounds(s.ifaceName)
+	if err != nil {
+		return Network{}, nil, fmt.Errorf("failed to get subnet range for interface %s: %w", s.ifaceName, err)
+	}
+	network := Network{
+		Interface:      s.ifaceName,
+		HardwareAddr:   s.hwAddr.String(),
+		DefaultGateway: gateway,
+		SubnetLower:    lower,
+		SubnetUpper:    upper,
+	}
+
+	proposed := &DNSMasqConfig{DefaultGateway: gateway, DnsIPs: fallbackDNSIPs, AddressReservations: make([]Reservation, 0), ServiceEnabled: true}
+	proposed.LowerBound, proposed.UpperBound, proposed.ThisGateway, err = adjustSubnetRange(lower, upper, gateway)
+	if err != nil {
+		return Network{}, nil, fmt.Errorf("failed to adjust subnet range for interface %s: %w", s.ifaceName, err)
+	}
+	if saved, err := s.GetConfig(s.logger); err == nil { // if there are settings to keep...
+		dhcpMutex.Lock()
+		if len(saved.DnsIPs) > 0 {
+			proposed.DnsIPs = slices.Clone(saved.DnsIPs)
+		}
+		proposed.AddressReservations = append(proposed.AddressReservations, saved.AddressReservations...)
+		dhcpMutex.Unlock()
+	}
+	return network, proposed, nil
+}
@@ -0,0 +1,42 @@
+package dhcp
+
+import (
+	"net"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+)
+
+func TestServer_ProposeConfig(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("the route output is mocked for linux")
+	}
+	originalRouteCmd, originalSubnetBounds := routeCmd, fnSubnetBounds
+	t.Cleanup(func() { routeCmd, fnSubnetBounds = originalRouteCmd, originalSubnetBounds })
+	routeCmd = func() (string, error) {
+		return "Kernel IP routing table\nDestination     Gateway         Genmask         Flags   MSS Window  irtt Iface\n0.0.0.0         192.168.1.1     0.0.0.0         UG        0 0          0 eth0\n", nil
+	}
+	fnSubnetBounds = func(string) (net.IP, net.IP, error) {
+		return net.ParseIP("192.168.1.1").To4(), net.ParseIP("192.168.1.254").To4(), nil
+	}
+	hwAddr, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	reservation := Reservation{MacAddr: "11-22-33-44-55-66", IpAddr: net.ParseIP("192.168.1.20"), Name: "tv"}
+	s := &Server{logger: config.MustGetLogger(), ifaceName: "eth0", hwAddr: hwAddr, cfg: &DNSMasqConfig{
+		DnsIPs:              []net.IP{net.ParseIP("9.9.9.9")},
+		AddressReservations: []Reservation{reservation},
+	}}
+
+	network, proposed, err := s.ProposeConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "eth0", network.Interface)
+	assert.Equal(t, "aa:bb:cc:dd:ee:ff", network.HardwareAddr)
+	assert.True(t, net.ParseIP("192.168.1.1").Equal(network.DefaultGateway))
+	assert.True(t, net.ParseIP("192.168.1.2").Equal(proposed.LowerBound), "expected the router to be left out of the range")
+	assert.True(t, net.ParseIP("192.168.1.254").Equal(proposed.UpperBound))
+	assert.True(t, net.ParseIP("192.168.1.254").Equal(proposed.ThisGateway))
+	assert.Equal(t, []net.IP{net.ParseIP("9.9.9.9")}, proposed.DnsIPs, "expected the saved DNS servers to be kept")
+	assert.Equal(t, []Reservation{reservation}, proposed.AddressReservations)
+	assert.True(t, proposed.ServiceEnabled)
+}
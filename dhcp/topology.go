@@ -0,0 +1,379 @@
+package dhcp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+const (
+	topologyCheckGateway     = "gateway"     // topologyCheckGateway checks this gateway's own route and the router DHCP hands out.
+	topologyCheckForwarding  = "forwarding"  // topologyCheckForwarding checks that the kernel forwards the devices' packets.
+	topologyCheckDoubleNAT   = "doubleNAT"   // topologyCheckDoubleNAT checks for a NAT router behind the router.
+	topologyCheckTrafficPath = "trafficPath" // topologyCheckTrafficPath checks that the devices in groups connect through this gateway.
+
+	maxBypassingShown = 5 // maxBypassingShown caps the devices named in the traffic path finding.
+)
+
+var (
+	topologyTraceTarget = "1.1.1.1" // topologyTraceTarget is traced to find the routers on the way to the internet.
+	ipForwardFile       = "/proc/sys/net/ipv4/ip_forward"
+	conntrackFile       = "/proc/net/nf_conntrack"
+	fnInterfaceNets     = getInterfaceNets // allow mocking
+	cgnatNet            = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
+)
+
+// TopologyFinding is a problem with where this gateway sits in the network, with what to do about it.
+type TopologyFinding struct {
+	Check   string              `json:"check"`
+	Status  models.HealthStatus `json:"status"`
+	Message string              `json:"message"`
+}
+
+// TopologyReport is the result of a topology check, returned in the details of the topology subsystem.
+type TopologyReport struct {
+	CheckedAt      time.Time         `json:"checkedAt"`
+	Interface      string            `json:"interface"`
+	Addresses      []string          `json:"addresses,omitempty"`      // Addresses are the interface's IPv4 addresses with their prefix length.
+	DefaultGateway net.IP            `json:"defaultGateway,omitempty"` // DefaultGateway is the router this gateway sends traffic to.
+	Hops           []string          `json:"hops,omitempty"`           // Hops are the first routers on the way to the internet, "*" if one didn't answer.
+	CarrierNAT     bool              `json:"carrierNAT,omitempty"`     // CarrierNAT is true if the ISP shares its address between customers.
+	Bypassing      []models.Ip       `json:"bypassing,omitempty"`      // Bypassing are devices in groups without connections through this gateway.
+	Skipped        map[string]string `json:"skipped,omitempty"`        // Skipped are the checks that couldn't be made and why.
+	Findings       []TopologyFinding `json:"findings"`
+}
+
+// TopologyChecker diagnoses the networks where nothing gets filtered because devices' traffic doesn't pass through
+// this gateway, or can't get any further: the router DHCP hands out isn't this gateway, IP forwarding is off, the
+// router sits behind another NAT router so that devices joining the one in front bypass this gateway, or the devices
+// in groups don't have any connections through it. The checks are made at startup and then periodically, with the
+// findings logged as they change and returned by Health.
+type TopologyChecker struct {
+	logger  *zap.SugaredLogger
+	server  *Server
+	mu      sync.RWMutex // mu protects managed and report.
+	managed map[models.Ip]bool
+	report  *TopologyReport
+}
+
+// NewTopologyChecker creates a TopologyChecker for the server's interface; call Start to begin checking.
+func NewTopologyChecker(logger *zap.SugaredLogger, server *Server) *TopologyChecker {
+	return &TopologyChecker{logger: logger, server: server, managed: make(map[models.Ip]bool)}
+}
+
+// Start checks the topology now and then every interval until ctx is done. Zero or less only checks it now.
+func (c *TopologyChecker) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		c.refresh()
+		if interval <= 0 {
+			return
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.refresh()
+			}
+		}
+	}()
+}
+
+// UpdateSourceIpGroups sets the devices in groups, whose traffic is expected to pass through this gateway.
+func (c *TopologyChecker) UpdateSourceIpGroups(newData models.MapIpGroups) {
+	managed := make(map[models.Ip]bool, len(newData))
+	for ip := range newData {
+		if parsed := net.ParseIP(string(ip)); parsed != nil && parsed.To4() != nil {
+			managed[models.Ip(parsed.String())] = true
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.managed = managed
+}
+
+// Report returns the last topology check, or nil if the first hasn't finished yet.
+func (c *TopologyChecker) Report() *TopologyReport {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.report
+}
+
+// Health reports the worst of the last check's findings, with their messages. IP forwarding being off or devices
+// being handed a router that isn't this gateway is unhealthy, since nothing can be filtered.
+func (c *TopologyChecker) Health() models.SubsystemHealth {
+	h := models.SubsystemHealth{Name: "topology", Status: models.HealthOK}
+	r := c.Report()
+	if r == nil {
+		h.Message = "Network topology hasn't been checked yet"
+		return h
+	}
+	h.Details = r
+	var messages []string
+	for _, f := range r.Findings {
+		h.Status = h.Status.Worse(f.Status)
+		messages = append(messages, f.Message)
+	}
+	h.Message = strings.Join(messages, "; ")
+	return h
+}
+
+// refresh checks the topology and logs the findings that weren't found by the last check.
+func (c *TopologyChecker) refresh() {
+	r := c.check()
+	c.mu.Lock()
+	prev := c.report
+	c.report = r
+	c.mu.Unlock()
+	for _, f := range r.Findings {
+		if prev == nil || !slices.Contains(prev.Findings, f) {
+			c.logger.Warnf("Network topology: %v", f.Message)
+		}
+	}
+	if prev != nil && len(prev.Findings) > 0 && len(r.Findings) == 0 {
+		c.logger.Info("Network topology problems resolved")
+	}
+}
+
+// check makes each of the checks in turn, skipping the ones that depend on a check that failed.
+func (c *TopologyChecker) check() *TopologyReport {
+	r := &TopologyReport{CheckedAt: time.Now(), Interface: c.server.ifaceName, Skipped: make(map[string]string), Findings: make([]TopologyFinding, 0)}
+	find := func(check string, status models.HealthStatus, format string, args ...any) {
+		r.Findings = append(r.Findings, TopologyFinding{Check: check, Status: status, Message: fmt.Sprintf(format, args...)})
+	}
+
+	nets, err := fnInterfaceNets(c.server.ifaceName)
+	if err != nil {
+		find(topologyCheckGateway, models.HealthUnhealthy, "Interface %v has no IPv4 address: %v", c.server.ifaceName, err)
+		return r
+	}
+	for _, n := range nets {
+		r.Addresses = append(r.Addresses, n.String())
+	}
+	c.checkGateway(r, nets, find)
+	c.checkForwarding(r, find)
+	if r.DefaultGateway != nil {
+		c.checkDoubleNAT(r, find)
+	} else {
+		r.Skipped[topologyCheckDoubleNAT] = "there's no default gateway"
+	}
+	c.checkTrafficPath(r, nets, find)
+	if len(r.Skipped) == 0 {
+		r.Skipped = nil
+	}
+	return r
+}
+
+// checkGateway checks that this gateway has a router on its own subnet to send traffic to, and that DHCP hands out
+// this gateway as the devices' router.
+func (c *TopologyChecker) checkGateway(r *TopologyReport, nets []*net.IPNet, find func(string, models.HealthStatus, string, ...any)) {
+	gateway, err := getDefaultGateway()
+	if err != nil {
+		find(topologyCheckGateway, models.HealthUnhealthy, "This gateway has no default route to the internet: %v", err)
+	} else {
+		r.DefaultGateway = gateway
+		switch {
+		case slices.ContainsFunc(nets, func(n *net.IPNet) bool { return n.IP.Equal(gateway) }):
+			find(topologyCheckGateway, models.HealthUnhealthy, "This gateway's default route is to itself at %v, set it to the router's address", gateway)
+		case !slices.ContainsFunc(nets, func(n *net.IPNet) bool { return n.Contains(gateway) }):
+			find(topologyCheckGateway, models.HealthUnhealthy, "The default gateway %v isn't on %v's subnet %v, set it to the router's address", gateway, c.server.ifaceName, r.Addresses[0])
+		}
+	}
+
+	thisGateway, router := c.server.gatewayConfig()
+	if thisGateway != nil && !slices.ContainsFunc(nets, func(n *net.IPNet) bool { return n.IP.Equal(thisGateway) }) {
+		find(topologyCheckGateway, models.HealthUnhealthy, "DHCP hands out %v as the router but %v has %v, run the DHCP setup again", thisGateway, c.server.ifaceName, strings.Join(r.Addresses, ", "))
+	}
+	if router != nil && gateway != nil && !router.Equal(gateway) {
+		find(topologyCheckGateway, models.HealthDegraded, "The DHCP settings have the router at %v but the default gateway is %v, run the DHCP setup again if the router changed", router, gateway)
+	}
+}
+
+// checkForwarding checks that the kernel forwards packets, without which the devices using this gateway can't reach
+// anything beyond it.
+func (c *TopologyChecker) checkForwarding(r *TopologyReport, find func(string, models.HealthStatus, string, ...any)) {
+	if runtime.GOOS != "linux" {
+		r.Skipped[topologyCheckForwarding] = "only checked on Linux"
+		return
+	}
+	data, err := os.ReadFile(ipForwardFile)
+	if err != nil {
+		r.Skipped[topologyCheckForwarding] = err.Error()
+		return
+	}
+	if strings.TrimSpace(string(data)) != "1" {
+		find(topologyCheckForwarding, models.HealthUnhealthy, "IP forwarding is off so devices using this gateway can't reach the internet, set net.ipv4.ip_forward=1 with sysctl")
+	}
+}
+
+// checkDoubleNAT traces the route to the internet and reports a private address beyond the router, which is another
+// NAT router in front of it. Devices on the network of the router in front don't pass through this gateway. An ISP
+// sharing its address between customers (carrier-grade NAT) is recorded in the report but isn't a finding, as it
+// doesn't affect which devices are filtered.
+func (c *TopologyChecker) checkDoubleNAT(r *TopologyReport, find func(string, models.HealthStatus, string, ...any)) {
+	output, err := config.Commands.Query("traceroute", "-n", "-q", "1", "-w", "2", "-m", "3", topologyTraceTarget)
+	if err != nil {
+		r.Skipped[topologyCheckDoubleNAT] = fmt.Sprintf("traceroute failed, is it installed? %v", err)
+		return
+	}
+	r.Hops = parseTracerouteHops(string(output))
+	for i, hop := range r.Hops {
+		ip := net.ParseIP(hop)
+		if i == 0 || ip == nil { // if it's the router, or a hop that didn't answer...
+			continue
+		}
+		if cgnatNet.Contains(ip) {
+			r.CarrierNAT = true
+		} else if ip.IsPrivate() {
+			find(topologyCheckDoubleNAT, models.HealthDegraded, "Double NAT: the router %v is behind another router at %v, so devices joining that router's network bypass this gateway, put one of them in bridge or access point mode", r.DefaultGateway, ip)
+			return
+		}
+	}
+}
+
+// checkTrafficPath checks the kernel's connection tracking for connections from the devices in groups to addresses
+// beyond this gateway's subnets, which it only sees if the devices use it as their router. Devices that get their
+// leases from the router, or have a static address with the router as their gateway, have none.
+func (c *TopologyChecker) checkTrafficPath(r *TopologyReport, nets []*net.IPNet, find func(string, models.HealthStatus, string, ...any)) {
+	c.mu.RLock()
+	managed := make([]models.Ip, 0, len(c.managed))
+	for ip := range c.managed {
+		managed = append(managed, ip)
+	}
+	c.mu.RUnlock()
+	if len(managed) == 0 {
+		r.Skipped[topologyCheckTrafficPath] = "no devices in groups have been found yet"
+		return
+	}
+	if runtime.GOOS != "linux" {
+		r.Skipped[topologyCheckTrafficPath] = "only checked on Linux"
+		return
+	}
+	f, err := os.Open(conntrackFile)
+	if err != nil {
+		r.Skipped[topologyCheckTrafficPath] = err.Error()
+		return
+	}
+	defer f.Close()
+	routed, err := routedSources(f, nets)
+	if err != nil {
+		r.Skipped[topologyCheckTrafficPath] = err.Error()
+		return
+	}
+
+	slices.Sort(managed)
+	for _, ip := range managed {
+		if !routed[ip] {
+			r.Bypassing = append(r.Bypassing, ip)
+		}
+	}
+	switch {
+	case len(r.Bypassing) == 0:
+	case len(r.Bypassing) == len(managed):
+		find(topologyCheckTrafficPath, models.HealthDegraded, "None of the %v devices in groups have connections through this gateway, check that they get their addresses from it and not the router", len(managed))
+	default:
+		shown := r.Bypassing[:min(len(r.Bypassing), maxBypassingShown)]
+		find(topologyCheckTrafficPath, models.HealthDegraded, "%v of the %v devices in groups have no connections through this gateway, e.g. %v, check that they get their addresses from it and not the router", len(r.Bypassing), len(managed), joinIps(shown))
+	}
+}
+
+// gatewayConfig returns the router address that DHCP hands out, i.e. this gateway, and the router that the DHCP
+// settings were made for, or nils if the DHCP server isn't meant to be running.
+func (s *Server) gatewayConfig() (thisGateway, router net.IP) {
+	dhcpMutex.Lock()
+	defer dhcpMutex.Unlock()
+	if s.cfg == nil || !s.cfg.ServiceEnabled || s.dnsMasqServiceDisabledForDebug {
+		return nil, nil
+	}
+	return s.cfg.ThisGateway, s.cfg.DefaultGateway
+}
+
+// getInterfaceNets returns the IPv4 addresses of the interface with their subnets. The native backend adds this
+// gateway's address alongside the one the interface already has, so there may be more than one.
+func getInterfaceNets(ifaceName string) ([]*net.IPNet, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interface %s: %w", ifaceName, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get addresses for interface %s: %w", ifaceName, err)
+	}
+	var nets []*net.IPNet
+	for _, addr := range addrs {
+		if n, ok := addr.(*net.IPNet); ok && n.IP.To4() != nil {
+			nets = append(nets, &net.IPNet{IP: n.IP.To4(), Mask: n.Mask[len(n.Mask)-net.IPv4len:]})
+		}
+	}
+	if len(nets) == 0 {
+		return nil, errors.New("no IPv4 address found on interface")
+	}
+	return nets, nil
+}
+
+// parseTracerouteHops returns the address of each hop in the output of "traceroute -n -q 1", or "*" for those that
+// didn't answer.
+func parseTracerouteHops(output string) []string {
+	var hops []string
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] == "traceroute" {
+			continue
+		}
+		hops = append(hops, fields[1])
+	}
+	return hops
+}
+
+// routedSources reads the IPv4 connections in the format of /proc/net/nf_conntrack and returns the sources of those
+// to addresses outside nets, i.e. the connections routed through this gateway rather than made to it.
+func routedSources(r io.Reader, nets []*net.IPNet) (map[models.Ip]bool, error) {
+	routed := make(map[models.Ip]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "ipv4" {
+			continue
+		}
+		var src, dst net.IP
+		for _, field := range fields { // the first src and dst are the original direction; the reply's follow.
+			if v, ok := strings.CutPrefix(field, "src="); ok && src == nil {
+				src = net.ParseIP(v)
+			} else if v, ok = strings.CutPrefix(field, "dst="); ok && dst == nil {
+				dst = net.ParseIP(v)
+			}
+		}
+		if src == nil || dst == nil || slices.ContainsFunc(nets, func(n *net.IPNet) bool { return n.Contains(dst) }) {
+			continue
+		}
+		routed[models.Ip(src.String())] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read connections: %w", err)
+	}
+	return routed, nil
+}
+
+func joinIps(ips []models.Ip) string {
+	s := make([]string, len(ips))
+	for i, ip := range ips {
+		s[i] = string(ip)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,182 @@
+package dhcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+const (
+	testRoutes     = "Kernel IP routing table\nDestination     Gateway         Genmask         Flags   MSS Window  irtt Iface\n0.0.0.0         192.168.1.1     0.0.0.0         UG        0 0          0 eth0\n"
+	testTraceroute = "traceroute to 1.1.1.1 (1.1.1.1), 3 hops max, 60 byte packets\n 1  192.168.1.1  0.512 ms\n 2  %v  1.234 ms\n 3  *\n"
+	testConntrack  = "ipv4     2 tcp      6 431999 ESTABLISHED src=192.168.1.20 dst=142.250.180.14 sport=51000 dport=443 src=142.250.180.14 dst=192.168.1.254 sport=443 dport=51000 [ASSURED] mark=0 zone=0 use=2\n" +
+		"ipv4     2 udp      17 25 src=192.168.1.30 dst=192.168.1.254 sport=5353 dport=53 src=192.168.1.254 dst=192.168.1.30 sport=53 dport=5353 mark=0 zone=0 use=2\n"
+)
+
+// mockTopology replaces the network the checks see: the routes, the interface's address, the traceroute output and
+// the files for IP forwarding and connection tracking.
+func mockTopology(t *testing.T, secondHop, ipForward string) *config.FakeExec {
+	if runtime.GOOS != "linux" {
+		t.Skip("the route output and /proc files are mocked for linux")
+	}
+	origRouteCmd, origNets, origForward, origConntrack := routeCmd, fnInterfaceNets, ipForwardFile, conntrackFile
+	t.Cleanup(func() {
+		routeCmd, fnInterfaceNets, ipForwardFile, conntrackFile = origRouteCmd, origNets, origForward, origConntrack
+	})
+	routeCmd = func() (string, error) { return testRoutes, nil }
+	fnInterfaceNets = func(string) ([]*net.IPNet, error) {
+		return []*net.IPNet{{IP: net.IPv4(192, 168, 1, 254).To4(), Mask: net.CIDRMask(24, 32)}}, nil
+	}
+	dir := t.TempDir()
+	ipForwardFile, conntrackFile = filepath.Join(dir, "ip_forward"), filepath.Join(dir, "nf_conntrack")
+	require.NoError(t, os.WriteFile(ipForwardFile, []byte(ipForward+"\n"), 0644))
+	require.NoError(t, os.WriteFile(conntrackFile, []byte(testConntrack), 0644))
+	exec := config.UseFakeExec(t)
+	exec.Results = map[string]config.FakeResult{
+		"traceroute -n -q 1 -w 2 -m 3 1.1.1.1": {Output: fmt.Sprintf(testTraceroute, secondHop)},
+	}
+	return exec
+}
+
+func TestTopologyChecker_check(t *testing.T) {
+	tests := []struct {
+		name          string
+		secondHop     string
+		ipForward     string
+		cfg           *DNSMasqConfig
+		managed       []models.Ip
+		wantChecks    []string
+		wantStatus    models.HealthStatus
+		wantBypassing []models.Ip
+		wantCarrier   bool
+	}{
+		{
+			name:       "the devices connect through this gateway",
+			secondHop:  "81.2.69.1",
+			ipForward:  "1",
+			cfg:        &DNSMasqConfig{ServiceEnabled: true, ThisGateway: net.ParseIP("192.168.1.254"), DefaultGateway: net.ParseIP("192.168.1.1")},
+			managed:    []models.Ip{"192.168.1.20"},
+			wantStatus: models.HealthOK,
+		},
+		{
+			name:        "carrier-grade NAT isn't a finding",
+			secondHop:   "100.64.0.1",
+			ipForward:   "1",
+			managed:     []models.Ip{"192.168.1.20"},
+			wantStatus:  models.HealthOK,
+			wantCarrier: true,
+		},
+		{
+			name:       "double NAT",
+			secondHop:  "10.0.0.1",
+			ipForward:  "1",
+			managed:    []models.Ip{"192.168.1.20"},
+			wantChecks: []string{topologyCheckDoubleNAT},
+			wantStatus: models.HealthDegraded,
+		},
+		{
+			name:       "IP forwarding is off",
+			secondHop:  "81.2.69.1",
+			ipForward:  "0",
+			managed:    []models.Ip{"192.168.1.20"},
+			wantChecks: []string{topologyCheckForwarding},
+			wantStatus: models.HealthUnhealthy,
+		},
+		{
+			name:       "DHCP hands out another router",
+			secondHop:  "81.2.69.1",
+			ipForward:  "1",
+			cfg:        &DNSMasqConfig{ServiceEnabled: true, ThisGateway: net.ParseIP("192.168.1.253"), DefaultGateway: net.ParseIP("192.168.1.2")},
+			managed:    []models.Ip{"192.168.1.20"},
+			wantChecks: []string{topologyCheckGateway, topologyCheckGateway},
+			wantStatus: models.HealthUnhealthy,
+		},
+		{
+			name:       "DHCP settings are ignored while the service is disabled",
+			secondHop:  "81.2.69.1",
+			ipForward:  "1",
+			cfg:        &DNSMasqConfig{ThisGateway: net.ParseIP("192.168.1.253")},
+			managed:    []models.Ip{"192.168.1.20"},
+			wantStatus: models.HealthOK,
+		},
+		{
+			name:          "some devices bypass this gateway",
+			secondHop:     "81.2.69.1",
+			ipForward:     "1",
+			managed:       []models.Ip{"192.168.1.20", "192.168.1.30"},
+			wantChecks:    []string{topologyCheckTrafficPath},
+			wantStatus:    models.HealthDegraded,
+			wantBypassing: []models.Ip{"192.168.1.30"},
+		},
+		{
+			name:          "no devices connect through this gateway",
+			secondHop:     "81.2.69.1",
+			ipForward:     "1",
+			managed:       []models.Ip{"192.168.1.30", "192.168.1.40"},
+			wantChecks:    []string{topologyCheckTrafficPath},
+			wantStatus:    models.HealthDegraded,
+			wantBypassing: []models.Ip{"192.168.1.30", "192.168.1.40"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockTopology(t, tt.secondHop, tt.ipForward)
+			c := NewTopologyChecker(config.MustGetLogger(), &Server{ifaceName: "eth0", cfg: tt.cfg})
+			groups := make(models.MapIpGroups)
+			for _, ip := range tt.managed {
+				groups[ip] = []models.Group{"kids"}
+			}
+			c.UpdateSourceIpGroups(groups)
+			c.refresh()
+
+			r := c.Report()
+			require.NotNil(t, r)
+			var checks []string
+			for _, f := range r.Findings {
+				checks = append(checks, f.Check)
+			}
+			assert.Equal(t, tt.wantChecks, checks)
+			assert.Equal(t, tt.wantBypassing, r.Bypassing)
+			assert.Equal(t, tt.wantCarrier, r.CarrierNAT)
+			assert.Equal(t, []string{"192.168.1.254/24"}, r.Addresses)
+			assert.True(t, net.ParseIP("192.168.1.1").Equal(r.DefaultGateway))
+			assert.Equal(t, []string{"192.168.1.1", tt.secondHop, "*"}, r.Hops)
+			assert.Nil(t, r.Skipped)
+			h := c.Health()
+			assert.Equal(t, tt.wantStatus, h.Status)
+			assert.Equal(t, "topology", h.Name)
+		})
+	}
+}
+
+func TestTopologyChecker_Skipped(t *testing.T) {
+	exec := mockTopology(t, "81.2.69.1", "1")
+	exec.Results["traceroute -n -q 1 -w 2 -m 3 1.1.1.1"] = config.FakeResult{Err: errors.New("executable file not found in $PATH")}
+	c := NewTopologyChecker(config.MustGetLogger(), &Server{ifaceName: "eth0"})
+
+	// Expect the health to be ok until the first check has been made.
+	assert.Equal(t, models.HealthOK, c.Health().Status)
+
+	c.refresh()
+	r := c.Report()
+	require.NotNil(t, r)
+	assert.Empty(t, r.Findings)
+	assert.Contains(t, r.Skipped, topologyCheckDoubleNAT)
+	assert.Contains(t, r.Skipped, topologyCheckTrafficPath, "expected the traffic path not to be checked without devices in groups")
+
+	// Expect a missing default route to be unhealthy.
+	routeCmd = func() (string, error) { return "Kernel IP routing table\n", nil }
+	c.refresh()
+	h := c.Health()
+	assert.Equal(t, models.HealthUnhealthy, h.Status)
+	assert.Contains(t, h.Message, "no default route")
+}
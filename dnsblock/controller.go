@@ -0,0 +1,98 @@
+package dnsblock
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+// BlockedDomainsSetter applies the list of blocked domains, e.g. by rewriting and reloading dnsmasq.
+type BlockedDomainsSetter interface {
+	SetBlockedDomains(domains []models.Domain) error
+}
+
+// Controller watches usage tracker threshold states and blocks the tracked domains via DNS while any
+// eligible group is over its threshold.
+// Note that dnsmasq address rules apply to every client resolving via this gateway.
+type Controller struct {
+	logger   *zap.SugaredLogger
+	cfg      *config.DNSBlockConfig
+	setter   BlockedDomainsSetter
+	mu       sync.Mutex
+	exceeded map[models.Group]bool
+	domains  []models.Domain // all known destination domains
+	applied  []models.Domain // domains last applied via the setter
+}
+
+func NewController(logger *zap.SugaredLogger, cfg *config.DNSBlockConfig, setter BlockedDomainsSetter) *Controller {
+	return &Controller{
+		logger:   logger,
+		cfg:      cfg,
+		setter:   setter,
+		exceeded: make(map[models.Group]bool),
+	}
+}
+
+// UpdateThresholdState implements the ThresholdStateReceiver interface.
+func (c *Controller) UpdateThresholdState(group models.Group, exceeded bool) {
+	if !c.isEligible(group) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if exceeded {
+		c.exceeded[group] = true
+	} else {
+		delete(c.exceeded, group)
+	}
+	c.apply()
+}
+
+// UpdateDestDomainGroups implements the DestDomainGroupsReceiver interface.
+func (c *Controller) UpdateDestDomainGroups(newData models.MapDomainGroups) {
+	domains := make([]models.Domain, 0, len(newData))
+	for d := range newData {
+		domains = append(domains, d)
+	}
+	slices.Sort(domains)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.domains = domains
+	c.apply()
+}
+
+// isEligible returns true if the group is subject to DNS blocking.
+func (c *Controller) isEligible(group models.Group) bool {
+	if len(c.cfg.Groups) == 0 {
+		return true
+	}
+	for _, g := range c.cfg.Groups {
+		if strings.TrimSpace(g) == string(group) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply sends the blocked domains to the setter if they have changed.
+// This should be called under lock.
+func (c *Controller) apply() {
+	var want []models.Domain
+	if len(c.exceeded) > 0 { // if any group is blocked...
+		want = c.domains
+	}
+	if slices.Equal(want, c.applied) {
+		return
+	}
+	if err := c.setter.SetBlockedDomains(want); err != nil {
+		c.logger.Errorf("DNS block controller failed to apply %d blocked domains: %v", len(want), err)
+		return
+	}
+	c.applied = want
+	c.logger.Infof("DNS block controller applied %d blocked domains for %d group(s)", len(want), len(c.exceeded))
+}
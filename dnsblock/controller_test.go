@@ -0,0 +1,76 @@
+package dnsblock
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+type mockSetter struct {
+	calls   int
+	domains []models.Domain
+	err     error
+}
+
+func (m *mockSetter) SetBlockedDomains(domains []models.Domain) error {
+	m.calls++
+	if m.err != nil {
+		return m.err
+	}
+	m.domains = domains
+	return nil
+}
+
+func TestController_UpdateThresholdState(t *testing.T) {
+	setter := &mockSetter{}
+	c := NewController(config.MustGetLogger(), &config.DNSBlockConfig{DNSBlockEnabled: true}, setter)
+
+	c.UpdateDestDomainGroups(models.MapDomainGroups{
+		"youtube.com":     {"youtube"},
+		"googlevideo.com": {"youtube"},
+	})
+	assert.Equal(t, 0, setter.calls, "expected no update while no group is exceeded")
+
+	c.UpdateThresholdState("kids", true)
+	assert.Equal(t, 1, setter.calls, "expected an update when a group is exceeded")
+	assert.Equal(t, []models.Domain{"googlevideo.com", "youtube.com"}, setter.domains, "expected sorted domains to be blocked")
+
+	c.UpdateThresholdState("teens", true)
+	assert.Equal(t, 1, setter.calls, "expected no update when the blocked domains don't change")
+
+	c.UpdateThresholdState("kids", false)
+	assert.Equal(t, 1, setter.calls, "expected no update while another group is still exceeded")
+
+	c.UpdateThresholdState("teens", false)
+	assert.Equal(t, 2, setter.calls, "expected an update when no group is exceeded")
+	assert.Nil(t, setter.domains, "expected no domains to be blocked")
+}
+
+func TestController_EligibleGroups(t *testing.T) {
+	setter := &mockSetter{}
+	c := NewController(config.MustGetLogger(), &config.DNSBlockConfig{DNSBlockEnabled: true, Groups: []string{"kids"}}, setter)
+	c.UpdateDestDomainGroups(models.MapDomainGroups{"youtube.com": {"youtube"}})
+
+	c.UpdateThresholdState("adults", true)
+	assert.Equal(t, 0, setter.calls, "expected ineligible groups to be ignored")
+
+	c.UpdateThresholdState("kids", true)
+	assert.Equal(t, 1, setter.calls, "expected eligible groups to block")
+}
+
+func TestController_SetterErrorIsRetried(t *testing.T) {
+	setter := &mockSetter{err: errors.New("mock error")}
+	c := NewController(config.MustGetLogger(), &config.DNSBlockConfig{DNSBlockEnabled: true}, setter)
+	c.UpdateDestDomainGroups(models.MapDomainGroups{"youtube.com": {"youtube"}})
+
+	c.UpdateThresholdState("kids", true)
+	assert.Equal(t, 1, setter.calls, "expected the setter to be called")
+
+	setter.err = nil
+	c.UpdateDestDomainGroups(models.MapDomainGroups{"youtube.com": {"youtube"}})
+	assert.Equal(t, 2, setter.calls, "expected the failed update to be retried")
+	assert.Equal(t, []models.Domain{"youtube.com"}, setter.domains, "expected the domains to be applied after retry")
+}
@@ -0,0 +1,137 @@
+package dnsforward
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"relloyd/tubetimeout/config"
+)
+
+const negativeTTL = 30 * time.Second // negativeTTL is how long an answer without records, e.g. NXDOMAIN, is kept.
+
+// cacheKey is a question with its name lower cased, since devices may vary the case of the names they look up.
+type cacheKey struct {
+	name  string
+	typ   dnsmessage.Type
+	class dnsmessage.Class
+}
+
+func newCacheKey(q dnsmessage.Question) cacheKey {
+	return cacheKey{name: strings.ToLower(q.Name.String()), typ: q.Type, class: q.Class}
+}
+
+// cacheEntry is an upstream answer and when it was stored, so that its TTLs can count down from then.
+type cacheEntry struct {
+	msg     dnsmessage.Message
+	stored  time.Time
+	expires time.Time
+}
+
+// cache keeps the upstream answers until the lowest TTL of their records runs out, within CacheMaxTTL, so that a
+// device looking up the same names over and over doesn't wait for the upstreams each time.
+type cache struct {
+	cfg     *config.DNSForwarderConfig
+	mu      sync.Mutex
+	entries map[cacheKey]*cacheEntry
+	hits    int
+}
+
+func newCache(cfg *config.DNSForwarderConfig) *cache {
+	return &cache{cfg: cfg, entries: make(map[cacheKey]*cacheEntry)}
+}
+
+// get returns the cached answer to the question q asked in the query with header h, with the TTLs of its records
+// reduced by the time it's been cached, and false if there's no fresh answer.
+func (c *cache) get(h dnsmessage.Header, q dnsmessage.Question, now time.Time) ([]byte, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[newCacheKey(q)]
+	if !ok || !now.Before(e.expires) {
+		c.mu.Unlock()
+		return nil, false
+	}
+	c.hits++
+	m := e.msg
+	c.mu.Unlock()
+
+	elapsed := uint32(now.Sub(e.stored) / time.Second)
+	m.Header.ID = h.ID
+	m.Header.RecursionDesired = h.RecursionDesired
+	m.Questions = []dnsmessage.Question{q} // q has the case the device asked with.
+	m.Answers = countDown(m.Answers, elapsed)
+	m.Authorities = countDown(m.Authorities, elapsed)
+	m.Additionals = countDown(m.Additionals, elapsed)
+	resp, err := m.Pack()
+	if err != nil {
+		return nil, false
+	}
+	return resp, true
+}
+
+// put caches the upstream answer to the question. Truncated answers and failures such as SERVFAIL aren't kept. If the
+// cache is full, expired answers are removed, then the one that would expire first.
+func (c *cache) put(q dnsmessage.Question, resp []byte, now time.Time) {
+	if c.cfg.CacheSize <= 0 {
+		return
+	}
+	var m dnsmessage.Message
+	if err := m.Unpack(resp); err != nil || m.Truncated || (m.RCode != dnsmessage.RCodeSuccess && m.RCode != dnsmessage.RCodeNameError) {
+		return
+	}
+	ttl := minTTL(append(slices.Clone(m.Answers), m.Authorities...))
+	ttl = min(ttl, c.cfg.CacheMaxTTL)
+	if ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := newCacheKey(q)
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.cfg.CacheSize {
+		var soonest cacheKey
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			} else if soonest == (cacheKey{}) || e.expires.Before(c.entries[soonest].expires) {
+				soonest = k
+			}
+		}
+		if len(c.entries) >= c.cfg.CacheSize {
+			delete(c.entries, soonest)
+		}
+	}
+	c.entries[key] = &cacheEntry{msg: m, stored: now, expires: now.Add(ttl)}
+}
+
+// len returns the number of cached answers and the number of lookups answered from the cache.
+func (c *cache) len() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries), c.hits
+}
+
+// minTTL returns the lowest TTL of the records, or negativeTTL if there aren't any.
+func minTTL(records []dnsmessage.Resource) time.Duration {
+	if len(records) == 0 {
+		return negativeTTL
+	}
+	lowest := records[0].Header.TTL
+	for _, r := range records[1:] {
+		lowest = min(lowest, r.Header.TTL)
+	}
+	return time.Duration(lowest) * time.Second
+}
+
+// countDown returns a copy of the records with elapsed seconds taken off their TTLs. The OPT record's TTL holds flags
+// so it's left as it is.
+func countDown(records []dnsmessage.Resource, elapsed uint32) []dnsmessage.Resource {
+	records = slices.Clone(records)
+	for i := range records {
+		if records[i].Header.Type != dnsmessage.TypeOPT {
+			records[i].Header.TTL -= min(records[i].Header.TTL, elapsed)
+		}
+	}
+	return records
+}
@@ -0,0 +1,44 @@
+package dnsforward
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+	"relloyd/tubetimeout/config"
+)
+
+func TestCache_PutAndGet(t *testing.T) {
+	c := newCache(&config.DNSForwarderConfig{CacheSize: 2, CacheMaxTTL: time.Minute})
+	now := time.Now()
+	q := func(name string) dnsmessage.Question {
+		return dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}
+	}
+	h := dnsmessage.Header{ID: 9}
+
+	c.put(q("a.example.com."), answerA(t, query(t, 1, "a.example.com.", dnsmessage.TypeA), "192.0.2.1", 3600), now)
+	_, ok := c.get(h, q("a.example.com."), now.Add(59*time.Second))
+	assert.True(t, ok)
+	_, ok = c.get(h, q("a.example.com."), now.Add(time.Minute))
+	assert.False(t, ok, "expected the TTL to be capped at CacheMaxTTL")
+
+	c.put(q("missing.example.com."), answerA(t, query(t, 2, "missing.example.com.", dnsmessage.TypeA), "", 0), now)
+	resp, ok := c.get(h, q("missing.example.com."), now.Add(negativeTTL-time.Second))
+	assert.True(t, ok, "expected NXDOMAIN to be cached for negativeTTL")
+	assert.Equal(t, dnsmessage.RCodeNameError, unpack(t, resp).RCode)
+
+	c.put(q("b.example.com."), answerA(t, query(t, 3, "b.example.com.", dnsmessage.TypeA), "192.0.2.2", 30), now)
+	c.put(q("c.example.com."), answerA(t, query(t, 4, "c.example.com.", dnsmessage.TypeA), "192.0.2.3", 30), now)
+	n, hits := c.len()
+	assert.Equal(t, 2, n, "expected the cache to stay within CacheSize")
+	assert.Equal(t, 2, hits)
+	_, ok = c.get(h, q("a.example.com."), now)
+	assert.True(t, ok)
+	_, ok = c.get(h, q("missing.example.com."), now)
+	assert.False(t, ok, "expected the answers expiring first to be removed")
+
+	c.put(q("d.example.com."), answerA(t, query(t, 5, "d.example.com.", dnsmessage.TypeA), "192.0.2.4", 0), now)
+	_, ok = c.get(h, q("d.example.com."), now)
+	assert.False(t, ok, "expected answers with a TTL of 0 not to be cached")
+}
@@ -0,0 +1,494 @@
+// Package dnsforward is a caching DNS forwarder that DHCP clients are given as their DNS server. It keeps a log of
+// each device's lookups, answers repeated ones from its cache, blocks or redirects domains by each group's DNS policy
+// and sends the answers on to the domain watcher, so that the IPs of tracked domains are filtered straight away.
+package dnsforward
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/dns/dnsmessage"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+const (
+	listenRetryInterval = 10 * time.Second // listenRetryInterval is how often listening is retried, e.g. while dnsmasq still serves DNS.
+	tcpIdleTimeout      = 10 * time.Second // tcpIdleTimeout is how long a TCP connection is kept open waiting for the next lookup.
+	maxInFlight         = 256              // maxInFlight is the most UDP lookups answered at once before more are dropped.
+	minUDPSize          = 512              // minUDPSize is the largest UDP answer a device can take if it doesn't say otherwise with EDNS.
+	redirectTTL         = 60               // redirectTTL is the TTL in seconds of the records answering redirected lookups.
+)
+
+// AnswerObserver is sent each answer from the upstreams or the cache, e.g. to learn the IPs of the tracked domains.
+// ObserveDNSMessage is called while the device waits for the answer so it mustn't block.
+type AnswerObserver interface {
+	ObserveDNSMessage(msg []byte)
+}
+
+// exchangeFunc sends the query to the server over the network, udp or tcp, and returns its answer.
+type exchangeFunc func(ctx context.Context, network, server string, query []byte) ([]byte, error)
+
+// Forwarder serves DNS to the devices, forwarding their lookups to the upstream servers unless the cache or their
+// groups' DNS policy answers them.
+type Forwarder struct {
+	logger    *zap.SugaredLogger
+	cfg       *config.DNSForwarderConfig
+	observers []AnswerObserver
+	cache     *cache
+	exchange  exchangeFunc
+	nowFunc   func() time.Time
+	policyMu  sync.Mutex // policyMu protects the policy file via fnGetPolicy/fnSetPolicy.
+
+	mu          sync.RWMutex
+	ipGroups    models.MapIpGroups
+	policy      models.MapGroupDNSPolicy
+	queries     map[models.Ip][]models.DNSQuery // queries are the recent lookups of each device, oldest first.
+	results     map[string]int                  // results counts the lookups by how they were answered.
+	listening   bool
+	listenErr   error
+	upstreamErr error // upstreamErr is set while the latest lookup failed on every upstream.
+}
+
+// NewForwarder returns a forwarder with the saved DNS policy. Call Start to serve DNS.
+func NewForwarder(logger *zap.SugaredLogger, cfg *config.DNSForwarderConfig) (*Forwarder, error) {
+	f := &Forwarder{
+		logger:   logger,
+		cfg:      cfg,
+		cache:    newCache(cfg),
+		exchange: exchange,
+		nowFunc:  time.Now,
+		policy:   make(models.MapGroupDNSPolicy),
+		queries:  make(map[models.Ip][]models.DNSQuery),
+		results:  make(map[string]int),
+	}
+	if err := f.loadPolicy(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// RegisterAnswerObservers adds observers to be sent each answer. Register them before calling Start.
+func (f *Forwarder) RegisterAnswerObservers(observers ...AnswerObserver) {
+	f.observers = append(f.observers, observers...)
+}
+
+// Start serves DNS over UDP and TCP on cfg.ListenAddress until ctx is done. Listening is retried until it works, since
+// dnsmasq may still be serving DNS on the same port until the DHCP server restarts it without.
+func (f *Forwarder) Start(ctx context.Context) {
+	go func() {
+		for {
+			pc, ln, err := listen(ctx, f.cfg.ListenAddress)
+			f.mu.Lock()
+			f.listening, f.listenErr = err == nil, err
+			f.mu.Unlock()
+			if err == nil {
+				f.logger.Infof("DNS forwarder listening on %v", pc.LocalAddr())
+				f.serve(ctx, pc, ln)
+				return
+			}
+			f.logger.Warnf("DNS forwarder failed to listen on %v, retrying in %v: %v", f.cfg.ListenAddress, listenRetryInterval, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(listenRetryInterval):
+			}
+		}
+	}()
+}
+
+// listen opens the UDP and TCP sockets on the address, using the UDP port for TCP in case the address's port is 0.
+func listen(ctx context.Context, address string) (net.PacketConn, net.Listener, error) {
+	var lc net.ListenConfig
+	pc, err := lc.ListenPacket(ctx, "udp", address)
+	if err != nil {
+		return nil, nil, err
+	}
+	ln, err := lc.Listen(ctx, "tcp", pc.LocalAddr().String())
+	if err != nil {
+		_ = pc.Close()
+		return nil, nil, err
+	}
+	return pc, ln, nil
+}
+
+// serve answers the lookups sent to the sockets until ctx is done, which closes them.
+func (f *Forwarder) serve(ctx context.Context, pc net.PacketConn, ln net.Listener) {
+	go func() {
+		<-ctx.Done()
+		_ = pc.Close()
+		_ = ln.Close()
+	}()
+	go f.serveTCP(ctx, ln)
+	f.serveUDP(ctx, pc)
+}
+
+// serveUDP answers each UDP lookup in its own goroutine, up to maxInFlight at once. Lookups over the limit are dropped
+// for the device to retry.
+func (f *Forwarder) serveUDP(ctx context.Context, pc net.PacketConn) {
+	sem := make(chan struct{}, maxInFlight)
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			f.logger.Debugf("DNS forwarder failed to read a UDP lookup: %v", err)
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		default:
+			f.logger.Debugf("DNS forwarder dropped a lookup from %v since too many are in flight", addr)
+			continue
+		}
+		query := slices.Clone(buf[:n])
+		go func() {
+			defer func() { <-sem }()
+			resp := f.handle(ctx, "udp", clientIp(addr), query)
+			if resp == nil {
+				return
+			}
+			if _, err := pc.WriteTo(truncate(resp, udpSize(query)), addr); err != nil {
+				f.logger.Debugf("DNS forwarder failed to answer %v: %v", addr, err)
+			}
+		}()
+	}
+}
+
+// serveTCP answers the lookups sent on each TCP connection in turn until the device closes it or goes quiet for
+// tcpIdleTimeout.
+func (f *Forwarder) serveTCP(ctx context.Context, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			f.logger.Debugf("DNS forwarder failed to accept a TCP connection: %v", err)
+			continue
+		}
+		go func() {
+			defer func() { _ = conn.Close() }()
+			client := clientIp(conn.RemoteAddr())
+			for {
+				_ = conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+				query, err := readTCPMessage(conn)
+				if err != nil {
+					return
+				}
+				resp := f.handle(ctx, "tcp", client, query)
+				if resp == nil {
+					return
+				}
+				if err = writeTCPMessage(conn, resp); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// handle returns the answer to the query from the client, or nil if it isn't a query that can be answered, and logs
+// the lookup.
+func (f *Forwarder) handle(ctx context.Context, network string, client models.Ip, query []byte) []byte {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil || h.Response {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+	lookup := models.DNSQuery{Time: f.nowFunc(), Client: client, Name: dnsName(q.Name), Type: strings.TrimPrefix(q.Type.String(), "Type")}
+	resp := f.answer(ctx, network, h, q, query, &lookup)
+	f.record(lookup)
+	return resp
+}
+
+// answer returns the answer to q, from the policy of the client's groups, the cache or the upstreams in that order,
+// and sets the lookup's result.
+func (f *Forwarder) answer(ctx context.Context, network string, h dnsmessage.Header, q dnsmessage.Question, query []byte, lookup *models.DNSQuery) []byte {
+	f.mu.RLock()
+	group, redirect, ok := decide(f.policy, f.ipGroups[lookup.Client], lookup.Name)
+	f.mu.RUnlock()
+	if ok {
+		lookup.Group = group
+		if redirect == "" {
+			lookup.Result = models.DNSBlocked
+			return reply(h, q, dnsmessage.RCodeNameError, nil)
+		}
+		lookup.Result = models.DNSRedirected
+		return reply(h, q, dnsmessage.RCodeSuccess, net.ParseIP(string(redirect)).To4())
+	}
+
+	if resp, ok := f.cache.get(h, q, lookup.Time); ok {
+		lookup.Result = models.DNSCached
+		f.observe(resp)
+		return resp
+	}
+	resp, err := f.forward(ctx, network, query)
+	f.mu.Lock()
+	f.upstreamErr = err
+	f.mu.Unlock()
+	if err != nil {
+		lookup.Result = models.DNSFailed
+		f.logger.Debugf("DNS forwarder failed to look up %v for %v: %v", lookup.Name, lookup.Client, err)
+		return reply(h, q, dnsmessage.RCodeServerFailure, nil)
+	}
+	lookup.Result = models.DNSForwarded
+	f.cache.put(q, resp, lookup.Time)
+	f.observe(resp)
+	return resp
+}
+
+// forward sends the query to the upstreams in turn and returns the first answer.
+func (f *Forwarder) forward(ctx context.Context, network string, query []byte) ([]byte, error) {
+	var errs []error
+	for _, server := range upstreamAddresses(f.cfg.Upstreams) {
+		ctxExchange, cancel := context.WithTimeout(ctx, f.cfg.Timeout)
+		resp, err := f.exchange(ctxExchange, network, server, query)
+		cancel()
+		if err == nil {
+			return resp, nil
+		}
+		errs = append(errs, fmt.Errorf("%v: %w", server, err))
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no upstream DNS servers are configured")
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (f *Forwarder) observe(resp []byte) {
+	for _, o := range f.observers {
+		o.ObserveDNSMessage(resp)
+	}
+}
+
+// record adds the lookup to the client's log, dropping its oldest lookups beyond QueryLogSize.
+func (f *Forwarder) record(lookup models.DNSQuery) {
+	f.logger.Debugf("DNS forwarder lookup of %v %v by %v was %v", lookup.Type, lookup.Name, lookup.Client, lookup.Result)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.results[lookup.Result]++
+	if f.cfg.QueryLogSize <= 0 {
+		delete(f.queries, lookup.Client)
+		return
+	}
+	log := append(f.queries[lookup.Client], lookup)
+	if over := len(log) - f.cfg.QueryLogSize; over > 0 {
+		log = slices.Delete(log, 0, over)
+	}
+	f.queries[lookup.Client] = log
+}
+
+// Queries returns the recent lookups of the client, or of every device if client is empty, latest first.
+func (f *Forwarder) Queries(client models.Ip) []models.DNSQuery {
+	f.mu.RLock()
+	var list []models.DNSQuery
+	if client != "" {
+		list = slices.Clone(f.queries[client])
+	} else {
+		for _, l := range f.queries {
+			list = append(list, l...)
+		}
+	}
+	f.mu.RUnlock()
+	slices.SortStableFunc(list, func(a, b models.DNSQuery) int { return b.Time.Compare(a.Time) })
+	return list
+}
+
+// UpdateSourceIpGroups implements the SourceIpGroupsReceiver interface, so that lookups get their device's policy.
+func (f *Forwarder) UpdateSourceIpGroups(newData models.MapIpGroups) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ipGroups = newData
+}
+
+// Health reports whether the forwarder is serving DNS and its upstreams are answering.
+func (f *Forwarder) Health() models.SubsystemHealth {
+	cached, hits := f.cache.len()
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	h := models.SubsystemHealth{Name: "dnsforward", Status: models.HealthOK}
+	h.Details = map[string]any{"listenAddress": f.cfg.ListenAddress, "upstreams": f.cfg.Upstreams, "cached": cached, "cacheHits": hits, "lookups": maps.Clone(f.results)}
+	switch {
+	case f.listenErr != nil:
+		h.Status = models.HealthUnhealthy
+		h.Message = fmt.Sprintf("DNS forwarder can't listen on %v: %v", f.cfg.ListenAddress, f.listenErr)
+	case !f.listening:
+		h.Status = models.HealthDegraded
+		h.Message = "DNS forwarder is starting"
+	case f.upstreamErr != nil:
+		h.Status = models.HealthDegraded
+		h.Message = fmt.Sprintf("upstream DNS servers aren't answering: %v", f.upstreamErr)
+	}
+	return h
+}
+
+// reply returns an answer to q made by the forwarder itself, with an A record for a if it's set and q asks for one.
+func reply(h dnsmessage.Header, q dnsmessage.Question, rcode dnsmessage.RCode, a net.IP) []byte {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, OpCode: h.OpCode, RecursionDesired: h.RecursionDesired, RecursionAvailable: true, RCode: rcode})
+	b.EnableCompression()
+	err := b.StartQuestions()
+	if err == nil {
+		err = b.Question(q)
+	}
+	if err == nil && len(a) == net.IPv4len && q.Type == dnsmessage.TypeA {
+		if err = b.StartAnswers(); err == nil {
+			err = b.AResource(dnsmessage.ResourceHeader{Name: q.Name, Class: q.Class, TTL: redirectTTL}, dnsmessage.AResource{A: [4]byte(a)})
+		}
+	}
+	if err != nil {
+		return nil
+	}
+	msg, err := b.Finish()
+	if err != nil {
+		return nil
+	}
+	return msg
+}
+
+// truncate returns the answer if it fits in limit bytes, or else just its header and question with the TC flag set,
+// so that the device asks again over TCP.
+func truncate(resp []byte, limit int) []byte {
+	if len(resp) <= limit {
+		return resp
+	}
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return nil
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil
+	}
+	h.Truncated = true
+	msg := dnsmessage.Message{Header: h, Questions: questions}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil
+	}
+	return packed
+}
+
+// udpSize returns the largest UDP answer the query says the device takes with EDNS, or minUDPSize.
+func udpSize(query []byte) int {
+	var p dnsmessage.Parser
+	if _, err := p.Start(query); err != nil {
+		return minUDPSize
+	}
+	if p.SkipAllQuestions() != nil || p.SkipAllAnswers() != nil || p.SkipAllAuthorities() != nil {
+		return minUDPSize
+	}
+	for {
+		rh, err := p.AdditionalHeader()
+		if err != nil {
+			return minUDPSize
+		}
+		if rh.Type == dnsmessage.TypeOPT {
+			return max(int(rh.Class), minUDPSize) // the OPT record's class is the UDP size.
+		}
+		if err = p.SkipAdditional(); err != nil {
+			return minUDPSize
+		}
+	}
+}
+
+// exchange sends the query to the server and returns the answer with the same ID.
+func exchange(ctx context.Context, network, server string, query []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if network == "tcp" {
+		if err = writeTCPMessage(conn, query); err != nil {
+			return nil, err
+		}
+		return readTCPMessage(conn)
+	}
+	if _, err = conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n >= 2 && buf[0] == query[0] && buf[1] == query[1] { // if it isn't a late answer to an earlier query...
+			return slices.Clone(buf[:n]), nil
+		}
+	}
+}
+
+// readTCPMessage reads a DNS message preceded by its two byte length, as it's sent over TCP.
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// writeTCPMessage writes the DNS message preceded by its two byte length.
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	_, err := w.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...))
+	return err
+}
+
+// upstreamAddresses returns the upstreams with port 53 added to those without a port.
+func upstreamAddresses(upstreams []string) []string {
+	var addresses []string
+	for _, u := range upstreams {
+		u = strings.TrimSpace(u)
+		if u == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(u); err != nil {
+			u = net.JoinHostPort(strings.Trim(u, "[]"), "53")
+		}
+		addresses = append(addresses, u)
+	}
+	return addresses
+}
+
+// clientIp returns the IP of the device at addr.
+func clientIp(addr net.Addr) models.Ip {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return models.Ip(a.IP.String())
+	case *net.TCPAddr:
+		return models.Ip(a.IP.String())
+	}
+	host, _, _ := net.SplitHostPort(addr.String())
+	return models.Ip(host)
+}
+
+// dnsName returns the name as a domain like the configured ones, i.e. lower case without the trailing dot.
+func dnsName(n dnsmessage.Name) models.Domain {
+	return models.Domain(strings.ToLower(strings.TrimSuffix(n.String(), ".")))
+}
@@ -0,0 +1,239 @@
+package dnsforward
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+type mockObserver struct {
+	mu       sync.Mutex
+	messages [][]byte
+}
+
+func (m *mockObserver) ObserveDNSMessage(msg []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+}
+
+// newTestForwarder returns a forwarder whose policy is saved in memory and whose upstream answers each A lookup with
+// ip and a TTL of ttl seconds, counting the lookups it's sent.
+func newTestForwarder(t *testing.T, cfg *config.DNSForwarderConfig, ip string, ttl uint32) (*Forwarder, *int, *time.Time) {
+	t.Helper()
+	origGet, origSet := fnGetPolicy, fnSetPolicy
+	t.Cleanup(func() { fnGetPolicy, fnSetPolicy = origGet, origSet })
+	var saved *policyFile
+	fnGetPolicy = func(mu *sync.Mutex, configPath string, newInstance func() *policyFile) (*policyFile, error) {
+		return saved, nil
+	}
+	fnSetPolicy = func(mu *sync.Mutex, configPath string, validate func(v *policyFile) error, updateInMemory func(v *policyFile), v *policyFile) error {
+		saved = v
+		updateInMemory(v)
+		return nil
+	}
+
+	f, err := NewForwarder(config.MustGetLogger(), cfg)
+	require.NoError(t, err)
+	f.RegisterAnswerObservers(&mockObserver{})
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	f.nowFunc = func() time.Time { return now }
+	lookups := 0
+	f.exchange = func(ctx context.Context, network, server string, query []byte) ([]byte, error) {
+		lookups++
+		return answerA(t, query, ip, ttl), nil
+	}
+	return f, &lookups, &now
+}
+
+func testConfig() *config.DNSForwarderConfig {
+	return &config.DNSForwarderConfig{Upstreams: []string{"192.0.2.1"}, Timeout: time.Second, CacheSize: 10, CacheMaxTTL: time.Hour, QueryLogSize: 2}
+}
+
+// query returns a lookup of the name and type with the ID.
+func query(t *testing.T, id uint16, name string, typ dnsmessage.Type) []byte {
+	t.Helper()
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := msg.Pack()
+	require.NoError(t, err)
+	return packed
+}
+
+// answerA returns an answer to the query with an A record for ip, or NXDOMAIN if ip is empty.
+func answerA(t *testing.T, query []byte, ip string, ttl uint32) []byte {
+	t.Helper()
+	var msg dnsmessage.Message
+	require.NoError(t, msg.Unpack(query))
+	msg.Response, msg.RecursionAvailable = true, true
+	if ip == "" {
+		msg.RCode = dnsmessage.RCodeNameError
+	} else {
+		msg.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: msg.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   &dnsmessage.AResource{A: [4]byte(net.ParseIP(ip).To4())},
+		}}
+	}
+	packed, err := msg.Pack()
+	require.NoError(t, err)
+	return packed
+}
+
+func unpack(t *testing.T, resp []byte) dnsmessage.Message {
+	t.Helper()
+	var msg dnsmessage.Message
+	require.NoError(t, msg.Unpack(resp))
+	return msg
+}
+
+func TestForwarder_ForwardAndCache(t *testing.T) {
+	f, lookups, now := newTestForwarder(t, testConfig(), "142.250.1.1", 300)
+
+	resp := unpack(t, f.handle(context.Background(), "udp", "192.168.1.10", query(t, 1, "www.YouTube.com.", dnsmessage.TypeA)))
+	assert.Equal(t, uint16(1), resp.ID)
+	require.Len(t, resp.Answers, 1)
+	assert.Equal(t, 1, *lookups)
+
+	*now = now.Add(100 * time.Second)
+	resp = unpack(t, f.handle(context.Background(), "udp", "192.168.1.11", query(t, 2, "www.youtube.com.", dnsmessage.TypeA)))
+	assert.Equal(t, 1, *lookups, "expected the answer to come from the cache whatever the case of the name")
+	assert.Equal(t, uint16(2), resp.ID)
+	assert.Equal(t, "www.youtube.com.", resp.Questions[0].Name.String(), "expected the question to be as the device asked it")
+	require.Len(t, resp.Answers, 1)
+	assert.Equal(t, uint32(200), resp.Answers[0].Header.TTL, "expected the TTL to count down while cached")
+	assert.Len(t, f.observers[0].(*mockObserver).messages, 2, "expected cached answers to be observed too")
+
+	f.handle(context.Background(), "udp", "192.168.1.10", query(t, 3, "www.youtube.com.", dnsmessage.TypeAAAA))
+	assert.Equal(t, 2, *lookups, "expected other record types to be looked up")
+
+	*now = now.Add(300 * time.Second)
+	f.handle(context.Background(), "udp", "192.168.1.10", query(t, 4, "www.youtube.com.", dnsmessage.TypeA))
+	assert.Equal(t, 3, *lookups, "expected expired answers to be looked up again")
+
+	queries := f.Queries("192.168.1.10")
+	require.Len(t, queries, 2, "expected the log to keep QueryLogSize lookups per device")
+	assert.Equal(t, models.DNSQuery{Time: *now, Client: "192.168.1.10", Name: "www.youtube.com", Type: "A", Result: models.DNSForwarded}, queries[0])
+	assert.Equal(t, "AAAA", queries[1].Type)
+	assert.Equal(t, models.DNSCached, f.Queries("192.168.1.11")[0].Result)
+	all := f.Queries("")
+	require.Len(t, all, 3, "expected every device's lookups")
+	assert.Equal(t, queries[0], all[0], "expected the latest first")
+}
+
+func TestForwarder_Policy(t *testing.T) {
+	f, lookups, _ := newTestForwarder(t, testConfig(), "142.250.1.1", 300)
+	require.NoError(t, f.SetPolicy(models.MapGroupDNSPolicy{
+		"kids":  {Block: []models.Domain{"TikTok.com."}, Redirect: map[models.Domain]models.Ip{"*.youtube.com": "216.239.38.120"}},
+		"teens": {Block: []models.Domain{"www.youtube.com"}},
+	}))
+	f.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}, "192.168.1.11": {"teens", "kids"}})
+
+	resp := unpack(t, f.handle(context.Background(), "udp", "192.168.1.10", query(t, 1, "www.tiktok.com.", dnsmessage.TypeA)))
+	assert.Equal(t, dnsmessage.RCodeNameError, resp.RCode, "expected subdomains of blocked domains to be blocked")
+
+	resp = unpack(t, f.handle(context.Background(), "udp", "192.168.1.10", query(t, 2, "www.youtube.com.", dnsmessage.TypeA)))
+	require.Len(t, resp.Answers, 1)
+	assert.Equal(t, [4]byte{216, 239, 38, 120}, resp.Answers[0].Body.(*dnsmessage.AResource).A)
+	resp = unpack(t, f.handle(context.Background(), "udp", "192.168.1.10", query(t, 3, "www.youtube.com.", dnsmessage.TypeAAAA)))
+	assert.Empty(t, resp.Answers, "expected redirects to leave out IPv6")
+
+	resp = unpack(t, f.handle(context.Background(), "udp", "192.168.1.11", query(t, 4, "www.youtube.com.", dnsmessage.TypeA)))
+	assert.Equal(t, dnsmessage.RCodeNameError, resp.RCode, "expected a block in any group to win over a redirect")
+	assert.Equal(t, models.Group("teens"), f.Queries("192.168.1.11")[0].Group)
+
+	f.handle(context.Background(), "udp", "192.168.1.12", query(t, 5, "www.tiktok.com.", dnsmessage.TypeA))
+	assert.Equal(t, 1, *lookups, "expected only devices outside the groups to be forwarded")
+
+	assert.ErrorIs(t, f.SetPolicy(models.MapGroupDNSPolicy{"kids": {Redirect: map[models.Domain]models.Ip{"youtube.com": "restrict.youtube.com"}}}), ErrInvalidPolicy)
+	assert.ErrorIs(t, f.SetPolicy(models.MapGroupDNSPolicy{"kids": {Block: []models.Domain{"https://tiktok.com"}}}), ErrInvalidPolicy)
+	assert.Equal(t, []models.Domain{"tiktok.com"}, f.Policy()["kids"].Block, "expected invalid policies not to be saved")
+}
+
+func TestForwarder_UpstreamFailure(t *testing.T) {
+	cfg := testConfig()
+	cfg.Upstreams = []string{"192.0.2.1", "192.0.2.2:5353"}
+	f, _, _ := newTestForwarder(t, cfg, "", 0)
+	var servers []string
+	f.exchange = func(ctx context.Context, network, server string, query []byte) ([]byte, error) {
+		servers = append(servers, server)
+		return nil, errors.New("timeout")
+	}
+
+	resp := unpack(t, f.handle(context.Background(), "tcp", "192.168.1.10", query(t, 1, "example.com.", dnsmessage.TypeA)))
+	assert.Equal(t, dnsmessage.RCodeServerFailure, resp.RCode)
+	assert.Equal(t, []string{"192.0.2.1:53", "192.0.2.2:5353"}, servers, "expected each upstream to be tried in turn")
+	f.listening = true
+	assert.Equal(t, models.HealthDegraded, f.Health().Status)
+
+	assert.Nil(t, f.handle(context.Background(), "udp", "192.168.1.10", []byte{1, 2, 3}), "expected junk to be ignored")
+}
+
+func TestForwarder_Serve(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = upstream.WriteTo(answerA(t, buf[:n], "142.250.1.1", 300), addr)
+		}
+	}()
+
+	cfg := testConfig()
+	cfg.ListenAddress = "127.0.0.1:0"
+	cfg.Upstreams = []string{upstream.LocalAddr().String()}
+	f, _, _ := newTestForwarder(t, cfg, "", 0)
+	f.exchange = exchange
+	f.nowFunc = time.Now
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pc, ln, err := listen(ctx, cfg.ListenAddress)
+	require.NoError(t, err)
+	go f.serve(ctx, pc, ln)
+
+	resp, err := exchange(ctx, "udp", pc.LocalAddr().String(), query(t, 7, "youtube.com.", dnsmessage.TypeA))
+	require.NoError(t, err)
+	require.Len(t, unpack(t, resp).Answers, 1)
+
+	resp, err = exchange(ctx, "tcp", ln.Addr().String(), query(t, 8, "youtube.com.", dnsmessage.TypeA))
+	require.NoError(t, err, "expected lookups over TCP to be answered too")
+	assert.Equal(t, uint16(8), unpack(t, resp).ID)
+	assert.Equal(t, models.DNSCached, f.Queries("127.0.0.1")[0].Result)
+}
+
+func TestTruncate(t *testing.T) {
+	resp := answerA(t, query(t, 1, "youtube.com.", dnsmessage.TypeA), "142.250.1.1", 300)
+	assert.Equal(t, resp, truncate(resp, minUDPSize))
+	msg := unpack(t, truncate(resp, 20))
+	assert.True(t, msg.Truncated)
+	assert.Empty(t, msg.Answers)
+	assert.Len(t, msg.Questions, 1)
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: 1})
+	require.NoError(t, b.StartQuestions())
+	require.NoError(t, b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName("youtube.com."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}))
+	require.NoError(t, b.StartAdditionals())
+	var opt dnsmessage.ResourceHeader
+	require.NoError(t, opt.SetEDNS0(1232, dnsmessage.RCodeSuccess, false))
+	require.NoError(t, b.OPTResource(opt, dnsmessage.OPTResource{}))
+	withEDNS, err := b.Finish()
+	require.NoError(t, err)
+	assert.Equal(t, 1232, udpSize(withEDNS))
+	assert.Equal(t, minUDPSize, udpSize(query(t, 1, "youtube.com.", dnsmessage.TypeA)))
+}
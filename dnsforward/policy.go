@@ -0,0 +1,156 @@
+package dnsforward
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+var (
+	ErrInvalidPolicy      = errors.New("invalid DNS policy")
+	defaultPolicyFilePath = "dns-policy.yaml"
+	fnGetPolicy           = config.GetConfig[*policyFile]
+	fnSetPolicy           = config.SetConfig[*policyFile]
+)
+
+func init() {
+	config.Backups.Register(defaultPolicyFilePath, "per-group DNS policy")
+}
+
+// policyFile is the YAML structure of the DNS policy file.
+type policyFile struct {
+	Groups models.MapGroupDNSPolicy `yaml:"groups"`
+}
+
+// Policy returns the DNS policy of each group.
+func (f *Forwarder) Policy() models.MapGroupDNSPolicy {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	m := make(models.MapGroupDNSPolicy, len(f.policy))
+	for group, p := range f.policy {
+		m[group] = models.DNSPolicy{Block: slices.Clone(p.Block), Redirect: maps.Clone(p.Redirect)}
+	}
+	return m
+}
+
+// SetPolicy replaces the DNS policy of every group and saves it. Domains are cleaned like the group domains, with a
+// leading "*." dropped since they match subdomains anyway. An error wrapping ErrInvalidPolicy is returned if a domain
+// isn't a host name or a redirect isn't an IPv4 address.
+func (f *Forwarder) SetPolicy(m models.MapGroupDNSPolicy) error {
+	cleaned, err := cleanPolicy(m)
+	if err != nil {
+		return err
+	}
+	return fnSetPolicy(&f.policyMu, defaultPolicyFilePath, nil, func(v *policyFile) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.policy = v.Groups
+	}, &policyFile{Groups: cleaned})
+}
+
+// loadPolicy reads the DNS policy file, leaving the policy empty if it doesn't exist yet.
+func (f *Forwarder) loadPolicy() error {
+	v, err := fnGetPolicy(&f.policyMu, defaultPolicyFilePath, func() *policyFile { return &policyFile{} })
+	if err != nil {
+		return fmt.Errorf("failed to read the DNS policy: %w", err)
+	}
+	if v == nil {
+		return nil
+	}
+	cleaned, err := cleanPolicy(v.Groups)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.policy = cleaned
+	return nil
+}
+
+// cleanPolicy returns a copy of the policy with its domains trimmed, lower cased and de-duplicated, dropping blank
+// ones and groups without any.
+func cleanPolicy(m models.MapGroupDNSPolicy) (models.MapGroupDNSPolicy, error) {
+	cleaned := make(models.MapGroupDNSPolicy, len(m))
+	for group, p := range m {
+		group = models.Group(strings.TrimSpace(models.NewGroup(string(group))))
+		if group == "" {
+			continue
+		}
+		var c models.DNSPolicy
+		for _, d := range p.Block {
+			d, err := cleanDomain(group, d)
+			if err != nil {
+				return nil, err
+			}
+			if d != "" && !slices.Contains(c.Block, d) {
+				c.Block = append(c.Block, d)
+			}
+		}
+		for d, ip := range p.Redirect {
+			d, err := cleanDomain(group, d)
+			if err != nil {
+				return nil, err
+			}
+			if d == "" {
+				continue
+			}
+			parsed := net.ParseIP(strings.TrimSpace(string(ip))).To4()
+			if parsed == nil {
+				return nil, fmt.Errorf("%w: redirect of %v in group %v to %q isn't an IPv4 address", ErrInvalidPolicy, d, group, ip)
+			}
+			if c.Redirect == nil {
+				c.Redirect = make(map[models.Domain]models.Ip)
+			}
+			c.Redirect[d] = models.Ip(parsed.String())
+		}
+		if len(c.Block) > 0 || len(c.Redirect) > 0 {
+			cleaned[group] = c
+		}
+	}
+	return cleaned, nil
+}
+
+// cleanDomain returns the domain lower cased without spaces, a leading "*." or the trailing dot.
+func cleanDomain(group models.Group, d models.Domain) (models.Domain, error) {
+	s := strings.TrimPrefix(strings.TrimSuffix(strings.ToLower(strings.TrimSpace(string(d))), "."), "*.")
+	if strings.ContainsAny(s, " \t/:@*") {
+		return "", fmt.Errorf("%w: domain %q in group %v", ErrInvalidPolicy, d, group)
+	}
+	return models.Domain(s), nil
+}
+
+// decide returns how the policy of the groups answers a lookup of name: the group whose policy blocks it, or else the
+// group and address that it's redirected to, going through the groups in name order. ok is false if no policy applies.
+func decide(policy models.MapGroupDNSPolicy, groups []models.Group, name models.Domain) (group models.Group, redirect models.Ip, ok bool) {
+	groups = slices.Sorted(slices.Values(groups))
+	for _, g := range groups {
+		if slices.ContainsFunc(policy[g].Block, func(d models.Domain) bool { return matchesDomain(d, name) }) {
+			return g, "", true
+		}
+	}
+	for _, g := range groups {
+		for d := name; d != ""; d = parentDomain(d) {
+			if ip, found := policy[g].Redirect[d]; found {
+				return g, ip, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// matchesDomain returns true if name is the domain or one of its subdomains.
+func matchesDomain(domain, name models.Domain) bool {
+	return name == domain || strings.HasSuffix(string(name), "."+string(domain))
+}
+
+// parentDomain returns the domain without its first label, or "" if it has only one.
+func parentDomain(d models.Domain) models.Domain {
+	_, parent, _ := strings.Cut(string(d), ".")
+	return models.Domain(parent)
+}
@@ -0,0 +1,81 @@
+package enforcer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"sort"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+// Backend blocks and unblocks client devices using a router's own parental controls.
+// Block and Unblock should be idempotent since the mirror re-applies state after errors and restarts.
+type Backend interface {
+	Block(ctx context.Context, mac models.MAC) error
+	Unblock(ctx context.Context, mac models.MAC) error
+}
+
+// BackendFactory creates a backend from the router config using client for its API calls.
+type BackendFactory func(cfg *config.RouterConfig, client *http.Client) (Backend, error)
+
+var backends = make(map[string]BackendFactory)
+
+// Register makes a backend available by name to ROUTER_BACKEND.
+// Backends register themselves in init().
+func Register(name string, factory BackendFactory) {
+	backends[name] = factory
+}
+
+// NewBackend creates the backend named by cfg.Backend.
+func NewBackend(cfg *config.RouterConfig) (Backend, error) {
+	factory, ok := backends[cfg.Backend]
+	if !ok {
+		names := make([]string, 0, len(backends))
+		for name := range backends {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown router backend %q, expected one of: %v", cfg.Backend, strings.Join(names, ", "))
+	}
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("router URL must be supplied for backend %v", cfg.Backend)
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cookie jar: %w", err)
+	}
+	client := &http.Client{
+		Timeout:   10 * time.Second,
+		Jar:       jar,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}},
+	}
+	return factory(cfg, client)
+}
+
+// dryRunBackend logs the blocks that would be made on the router instead of making them.
+type dryRunBackend struct {
+	logger *zap.SugaredLogger
+	name   string
+}
+
+func (d *dryRunBackend) Block(_ context.Context, mac models.MAC) error {
+	d.logger.Infof("Dry run: would block %v on the %v router", mac, d.name)
+	return nil
+}
+
+func (d *dryRunBackend) Unblock(_ context.Context, mac models.MAC) error {
+	d.logger.Infof("Dry run: would unblock %v on the %v router", mac, d.name)
+	return nil
+}
+
+// colonMAC converts a MAC from the models format AA-BB-CC-DD-EE-FF to aa:bb:cc:dd:ee:ff as used by router APIs.
+func colonMAC(mac models.MAC) string {
+	return strings.ToLower(strings.ReplaceAll(string(mac), "-", ":"))
+}
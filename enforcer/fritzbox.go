@@ -0,0 +1,69 @@
+package enforcer
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+	"relloyd/tubetimeout/tr064"
+)
+
+const (
+	fritzFilterURL     = "/upnp/control/x_hostfilter"
+	fritzFilterService = "urn:dslforum-org:service:X_AVM-DE_HostFilter:1"
+)
+
+func init() {
+	Register("fritzbox", newFritzBox)
+}
+
+// fritzBox blocks internet access for clients with the TR-064 host filter of AVM Fritz!Box routers, which works
+// by IP, so the IP is looked up from the router's host table on each call.
+// TR-064 access must be enabled on the router under Home Network > Network > Network Settings.
+type fritzBox struct {
+	soap *tr064.Client
+	mu   sync.Mutex // mu stops blocks and unblocks of the same client interleaving.
+}
+
+func newFritzBox(cfg *config.RouterConfig, client *http.Client) (Backend, error) {
+	return &fritzBox{soap: tr064.NewClient(cfg.URL, cfg.Username, cfg.Password, client)}, nil
+}
+
+func (f *fritzBox) Block(ctx context.Context, mac models.MAC) error {
+	return f.setWANAccess(ctx, mac, true)
+}
+
+func (f *fritzBox) Unblock(ctx context.Context, mac models.MAC) error {
+	return f.setWANAccess(ctx, mac, false)
+}
+
+func (f *fritzBox) setWANAccess(ctx context.Context, mac models.MAC, disallow bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	resp, err := f.soap.Call(ctx, tr064.HostsURL, tr064.HostsService, "GetSpecificHostEntry", [][2]string{{"NewMACAddress", strings.ToUpper(colonMAC(mac))}})
+	if err != nil {
+		return fmt.Errorf("failed to look up host: %w", err)
+	}
+	var entry struct {
+		IP string `xml:"Body>GetSpecificHostEntryResponse>NewIPAddress"`
+	}
+	if err := xml.Unmarshal(resp, &entry); err != nil {
+		return fmt.Errorf("failed to decode host entry: %w", err)
+	}
+	if entry.IP == "" {
+		return fmt.Errorf("no IP address known for %v", mac)
+	}
+
+	value := "0"
+	if disallow {
+		value = "1"
+	}
+	_, err = f.soap.Call(ctx, fritzFilterURL, fritzFilterService, "DisallowWANAccessByIP", [][2]string{{"NewIPv4Address", entry.IP}, {"NewDisallow", value}})
+	return err
+}
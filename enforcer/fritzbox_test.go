@@ -0,0 +1,58 @@
+package enforcer
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/tr064"
+)
+
+func TestFritzBox_BlockUnblock(t *testing.T) {
+	hash := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	var filterBodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := tr064.ParseDigestChallenge(r.Header.Get("Authorization"))
+		ha1 := hash("admin:F!Box SOAP-Auth:secret")
+		ha2 := hash(r.Method + ":" + r.URL.Path)
+		if auth == nil || auth["response"] != hash(ha1+":nonce1:"+auth["nc"]+":"+auth["cnonce"]+":auth:"+ha2) {
+			w.Header().Set("WWW-Authenticate", `Digest realm="F!Box SOAP-Auth", nonce="nonce1", algorithm=MD5, qop="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch r.URL.Path {
+		case tr064.HostsURL:
+			assert.Equal(t, tr064.HostsService+"#GetSpecificHostEntry", r.Header.Get("SoapAction"))
+			assert.Contains(t, string(body), "<NewMACAddress>AA:BB:CC:DD:EE:FF</NewMACAddress>")
+			_, _ = io.WriteString(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+				`<u:GetSpecificHostEntryResponse xmlns:u="urn:dslforum-org:service:Hosts:1"><NewIPAddress>192.168.178.20</NewIPAddress>`+
+				`</u:GetSpecificHostEntryResponse></s:Body></s:Envelope>`)
+		case fritzFilterURL:
+			assert.Equal(t, fritzFilterService+"#DisallowWANAccessByIP", r.Header.Get("SoapAction"))
+			filterBodies = append(filterBodies, string(body))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	b, err := NewBackend(&config.RouterConfig{Backend: "fritzbox", URL: srv.URL, Username: "admin", Password: "secret"})
+	assert.NoError(t, err)
+	assert.NoError(t, b.Block(context.Background(), "AA-BB-CC-DD-EE-FF"))
+	assert.NoError(t, b.Unblock(context.Background(), "AA-BB-CC-DD-EE-FF"))
+
+	assert.Len(t, filterBodies, 2)
+	assert.True(t, strings.Contains(filterBodies[0], "<NewIPv4Address>192.168.178.20</NewIPv4Address><NewDisallow>1</NewDisallow>"))
+	assert.True(t, strings.Contains(filterBodies[1], "<NewDisallow>0</NewDisallow>"))
+}
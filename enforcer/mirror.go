@@ -0,0 +1,188 @@
+package enforcer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+var fnGroupMACsLoader = config.GroupMACs.GetConfig // allow mocking
+
+// Mirror copies the block state of groups to the router so that devices stay blocked if they bypass this gateway,
+// e.g. with a static IP, or if it goes offline.
+// Every MAC in a group that is over its threshold is blocked on the router and every other grouped MAC is unblocked.
+// Blocks are left in place when TubeTimeout stops so the router keeps enforcing them.
+type Mirror struct {
+	logger   *zap.SugaredLogger
+	cfg      *config.RouterConfig
+	backend  Backend
+	chanSync chan struct{}
+	mu       sync.Mutex
+	exceeded map[models.Group]bool
+	applied  map[models.MAC]bool // applied holds the state last set on the router by MAC, true when blocked.
+	lastSync time.Time
+	lastErr  error
+}
+
+// NewMirror creates the backend named in cfg and starts syncing block state to it.
+func NewMirror(ctx context.Context, logger *zap.SugaredLogger, cfg *config.RouterConfig) (*Mirror, error) {
+	backend, err := mirrorBackend(logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	m := newMirror(logger, cfg, backend)
+	go m.startWorker(ctx)
+	return m, nil
+}
+
+// mirrorBackend creates the backend named in cfg, or one that only logs the changes in a dry run.
+func mirrorBackend(logger *zap.SugaredLogger, cfg *config.RouterConfig) (Backend, error) {
+	backend, err := NewBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if config.AppCfg.DryRun { // if the router mustn't be changed...
+		return &dryRunBackend{logger: logger, name: cfg.Backend}, nil
+	}
+	return backend, nil
+}
+
+func newMirror(logger *zap.SugaredLogger, cfg *config.RouterConfig, backend Backend) *Mirror {
+	return &Mirror{
+		logger:   logger,
+		cfg:      cfg,
+		backend:  backend,
+		chanSync: make(chan struct{}, 1),
+		exceeded: make(map[models.Group]bool),
+		applied:  make(map[models.MAC]bool),
+	}
+}
+
+// UpdateThresholdState implements the ThresholdStateReceiver interface.
+func (m *Mirror) UpdateThresholdState(group models.Group, exceeded bool) {
+	m.mu.Lock()
+	if exceeded {
+		m.exceeded[group] = true
+	} else {
+		delete(m.exceeded, group)
+	}
+	m.mu.Unlock()
+
+	select {
+	case m.chanSync <- struct{}{}: // sync without blocking the tracker.
+	default: // a sync is already pending.
+	}
+}
+
+// startWorker syncs at startup, which resets the router to the current state, on each threshold change and
+// periodically to retry changes that failed.
+func (m *Mirror) startWorker(ctx context.Context) {
+	ticker := time.NewTicker(m.cfg.Interval)
+	m.sync(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			ticker.Stop()
+			return
+		case <-ticker.C:
+			m.sync(ctx)
+		case <-m.chanSync:
+			m.sync(ctx)
+		}
+	}
+}
+
+// sync blocks or unblocks each grouped MAC whose state on the router differs from what we want.
+// It should only be called by the worker.
+func (m *Mirror) sync(ctx context.Context) {
+	gm, err := fnGroupMACsLoader(m.logger)
+	if err != nil {
+		m.mu.Lock()
+		m.lastErr = fmt.Errorf("failed to load group MACs: %w", err)
+		m.mu.Unlock()
+		m.logger.Errorf("Router enforcer: %v", err)
+		return
+	}
+
+	m.mu.Lock()
+	want := make(map[models.MAC]bool)
+	for group, macs := range gm.Groups {
+		for _, nm := range macs {
+			mac := models.MAC(models.NewMAC(nm.MAC))
+			want[mac] = want[mac] || m.exceeded[group] // block MACs that are in any exceeded group.
+		}
+	}
+	grouped := maps.Clone(want)
+	for mac := range m.applied {
+		if _, ok := want[mac]; !ok { // if the MAC has been removed from all groups since we set its state...
+			want[mac] = false
+		}
+	}
+	applied := maps.Clone(m.applied)
+	m.mu.Unlock()
+
+	var errs []error
+	for _, mac := range slices.Sorted(maps.Keys(want)) {
+		block := want[mac]
+		if cur, ok := applied[mac]; ok && cur == block {
+			continue
+		}
+		if block {
+			err = m.backend.Block(ctx, mac)
+		} else {
+			err = m.backend.Unblock(ctx, mac)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%v (block=%v): %w", mac, block, err))
+			continue
+		}
+		m.logger.Infof("Router enforcer set %v blocked=%v on the router", mac, block)
+		applied[mac] = block
+	}
+	for mac, block := range applied {
+		if _, ok := grouped[mac]; !ok && !block { // if the MAC is unblocked and no longer ours to manage...
+			delete(applied, mac)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.applied = applied
+	m.lastSync = time.Now()
+	m.lastErr = errors.Join(errs...)
+	if m.lastErr != nil {
+		m.logger.Errorf("Router enforcer failed to update the router: %v", m.lastErr)
+	}
+}
+
+// Health reports whether the router has the latest block state.
+func (m *Mirror) Health() models.SubsystemHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	blocked := 0
+	for _, b := range m.applied {
+		if b {
+			blocked++
+		}
+	}
+	h := models.SubsystemHealth{Name: "router", Status: models.HealthOK}
+	h.Details = map[string]any{"backend": m.cfg.Backend, "blocked": blocked, "lastSync": m.lastSync}
+	switch {
+	case m.lastErr != nil:
+		h.Status = models.HealthDegraded
+		h.Message = m.lastErr.Error()
+	case m.lastSync.IsZero():
+		h.Status = models.HealthDegraded
+		h.Message = "router has not been synced yet"
+	}
+	return h
+}
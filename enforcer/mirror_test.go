@@ -0,0 +1,105 @@
+package enforcer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+type mockBackend struct {
+	calls []string
+	err   error
+}
+
+func (m *mockBackend) Block(_ context.Context, mac models.MAC) error {
+	m.calls = append(m.calls, "block "+string(mac))
+	return m.err
+}
+
+func (m *mockBackend) Unblock(_ context.Context, mac models.MAC) error {
+	m.calls = append(m.calls, "unblock "+string(mac))
+	return m.err
+}
+
+func TestMirror_Sync(t *testing.T) {
+	groups := map[models.Group][]models.NamedMAC{
+		"kids":  {{MAC: "aa:aa:aa:aa:aa:aa"}, {MAC: "bb:bb:bb:bb:bb:bb"}},
+		"teens": {{MAC: "bb:bb:bb:bb:bb:bb"}, {MAC: "cc:cc:cc:cc:cc:cc"}},
+	}
+	orig := fnGroupMACsLoader
+	fnGroupMACsLoader = func(logger *zap.SugaredLogger) (config.GroupMACsConfig, error) {
+		return config.GroupMACsConfig{Groups: groups}, nil
+	}
+	defer func() { fnGroupMACsLoader = orig }()
+
+	ctx := context.Background()
+	backend := &mockBackend{}
+	m := newMirror(config.MustGetLogger(), &config.RouterConfig{Backend: "mock"}, backend)
+
+	m.sync(ctx)
+	assert.Equal(t, []string{"unblock AA-AA-AA-AA-AA-AA", "unblock BB-BB-BB-BB-BB-BB", "unblock CC-CC-CC-CC-CC-CC"}, backend.calls,
+		"expected the first sync to reset every grouped MAC")
+
+	backend.calls = nil
+	m.UpdateThresholdState("kids", true)
+	m.sync(ctx)
+	assert.Equal(t, []string{"block AA-AA-AA-AA-AA-AA", "block BB-BB-BB-BB-BB-BB"}, backend.calls)
+
+	backend.calls = nil
+	m.sync(ctx)
+	assert.Empty(t, backend.calls, "expected no calls when nothing has changed")
+
+	backend.calls = nil
+	m.UpdateThresholdState("teens", true)
+	m.UpdateThresholdState("kids", false)
+	m.sync(ctx)
+	assert.Equal(t, []string{"unblock AA-AA-AA-AA-AA-AA", "block CC-CC-CC-CC-CC-CC"}, backend.calls,
+		"expected a MAC in both groups to stay blocked")
+
+	backend.calls = nil
+	groups = map[models.Group][]models.NamedMAC{"teens": {{MAC: "bb:bb:bb:bb:bb:bb"}}}
+	m.sync(ctx)
+	assert.Equal(t, []string{"unblock CC-CC-CC-CC-CC-CC"}, backend.calls, "expected MACs removed from groups to be unblocked")
+	assert.NotContains(t, m.applied, models.MAC("CC-CC-CC-CC-CC-CC"), "expected MACs removed from groups to be forgotten")
+	assert.Equal(t, models.HealthOK, m.Health().Status)
+
+	backend.calls, backend.err = nil, errors.New("router offline")
+	m.UpdateThresholdState("teens", false)
+	m.sync(ctx)
+	assert.Equal(t, models.HealthDegraded, m.Health().Status)
+	backend.calls, backend.err = nil, nil
+	m.sync(ctx)
+	assert.Equal(t, []string{"unblock BB-BB-BB-BB-BB-BB"}, backend.calls, "expected failed changes to be retried")
+	assert.Equal(t, models.HealthOK, m.Health().Status)
+}
+
+func TestNewBackend(t *testing.T) {
+	_, err := NewBackend(&config.RouterConfig{Backend: "unknown", URL: "http://router"})
+	assert.ErrorContains(t, err, "fritzbox, openwrt, unifi")
+
+	_, err = NewBackend(&config.RouterConfig{Backend: "unifi"})
+	assert.ErrorContains(t, err, "URL must be supplied")
+
+	b, err := NewBackend(&config.RouterConfig{Backend: "openwrt", URL: "http://router"})
+	assert.NoError(t, err)
+	assert.IsType(t, &openWrt{}, b)
+}
+
+func TestMirrorBackend_DryRun(t *testing.T) {
+	orig := config.AppCfg.DryRun
+	config.AppCfg.DryRun = true
+	defer func() { config.AppCfg.DryRun = orig }()
+
+	b, err := mirrorBackend(config.MustGetLogger(), &config.RouterConfig{Backend: "openwrt", URL: "http://router"})
+	assert.NoError(t, err)
+	assert.IsType(t, &dryRunBackend{}, b, "expected the router not to be called in a dry run")
+	assert.NoError(t, b.Block(context.Background(), "AA-AA-AA-AA-AA-AA"))
+
+	_, err = mirrorBackend(config.MustGetLogger(), &config.RouterConfig{Backend: "unknown", URL: "http://router"})
+	assert.Error(t, err, "expected the router config to be checked in a dry run too")
+}
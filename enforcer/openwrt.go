@@ -0,0 +1,197 @@
+package enforcer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+const (
+	ubusAnonymousSession = "00000000000000000000000000000000"
+	ubusStatusNotFound   = 4
+	ubusAccessDenied     = -32002 // ubusAccessDenied is the JSON-RPC error returned for expired sessions.
+)
+
+var errUbusAccessDenied = errors.New("ubus access denied")
+
+func init() {
+	Register("openwrt", newOpenWrt)
+}
+
+// openWrt blocks clients with a firewall rule per MAC that rejects forwarding from the LAN to the WAN.
+// Rules are managed with uci via the ubus JSON-RPC API that LuCI uses, so it needs the uhttpd-mod-ubus and rpcd
+// packages and a user allowed to call uci and rc.
+type openWrt struct {
+	cfg     *config.RouterConfig
+	client  *http.Client
+	url     string
+	mu      sync.Mutex
+	session string
+	id      int
+}
+
+func newOpenWrt(cfg *config.RouterConfig, client *http.Client) (Backend, error) {
+	return &openWrt{cfg: cfg, client: client, url: strings.TrimSuffix(cfg.URL, "/") + "/ubus"}, nil
+}
+
+// ruleName returns the uci section name of the firewall rule for mac.
+func ruleName(mac models.MAC) string {
+	return "tubetimeout_" + strings.ReplaceAll(strings.ToLower(string(mac)), "-", "")
+}
+
+func (o *openWrt) Block(ctx context.Context, mac models.MAC) error {
+	return o.withSession(ctx, func() error {
+		if err := o.deleteRule(ctx, mac); err != nil { // replace any existing rule so the call is idempotent.
+			return err
+		}
+		_, err := o.call(ctx, "uci", "add", map[string]any{
+			"config": "firewall",
+			"type":   "rule",
+			"name":   ruleName(mac),
+			"values": map[string]string{
+				"name":    "TubeTimeout block " + colonMAC(mac),
+				"src":     "lan",
+				"dest":    "wan",
+				"src_mac": colonMAC(mac),
+				"target":  "REJECT",
+			},
+		})
+		if err != nil {
+			return err
+		}
+		return o.applyFirewall(ctx)
+	})
+}
+
+func (o *openWrt) Unblock(ctx context.Context, mac models.MAC) error {
+	return o.withSession(ctx, func() error {
+		if err := o.deleteRule(ctx, mac); err != nil {
+			return err
+		}
+		return o.applyFirewall(ctx)
+	})
+}
+
+func (o *openWrt) deleteRule(ctx context.Context, mac models.MAC) error {
+	code, err := o.call(ctx, "uci", "delete", map[string]any{"config": "firewall", "section": ruleName(mac)})
+	if code == ubusStatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// applyFirewall commits the firewall changes and reloads the firewall.
+func (o *openWrt) applyFirewall(ctx context.Context) error {
+	if _, err := o.call(ctx, "uci", "commit", map[string]any{"config": "firewall"}); err != nil {
+		return err
+	}
+	_, err := o.call(ctx, "rc", "init", map[string]any{"name": "firewall", "action": "reload"})
+	return err
+}
+
+// withSession runs fn with a valid session, logging in first if needed and again if the session has expired.
+func (o *openWrt) withSession(ctx context.Context, fn func() error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.session == "" {
+		if err := o.login(ctx); err != nil {
+			return err
+		}
+	}
+	err := fn()
+	if !errors.Is(err, errUbusAccessDenied) {
+		return err
+	}
+	if err := o.login(ctx); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// login should be called under lock.
+func (o *openWrt) login(ctx context.Context) error {
+	o.session = ubusAnonymousSession
+	var result struct {
+		Session string `json:"ubus_rpc_session"`
+	}
+	if _, err := o.callInto(ctx, "session", "login", map[string]any{"username": o.cfg.Username, "password": o.cfg.Password}, &result); err != nil {
+		o.session = ""
+		return fmt.Errorf("openwrt login failed: %w", err)
+	}
+	o.session = result.Session
+	return nil
+}
+
+func (o *openWrt) call(ctx context.Context, object, method string, args map[string]any) (int, error) {
+	return o.callInto(ctx, object, method, args, nil)
+}
+
+// callInto calls method on the ubus object and decodes the result data into v if it is not nil.
+// It returns the ubus status code, which is non-zero alongside an error when the call fails.
+func (o *openWrt) callInto(ctx context.Context, object, method string, args map[string]any, v any) (int, error) {
+	o.id++
+	data, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      o.id,
+		"method":  "call",
+		"params":  []any{o.session, object, method, args},
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode ubus request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.url, bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create ubus request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("ubus request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("ubus %v %v returned status %v", object, method, resp.StatusCode)
+	}
+
+	var rpc struct {
+		Result []json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rpc); err != nil {
+		return 0, fmt.Errorf("failed to decode ubus response: %w", err)
+	}
+	if rpc.Error != nil {
+		if rpc.Error.Code == ubusAccessDenied {
+			return 0, fmt.Errorf("%w: %v %v", errUbusAccessDenied, object, method)
+		}
+		return 0, fmt.Errorf("ubus %v %v failed: %v", object, method, rpc.Error.Message)
+	}
+	if len(rpc.Result) == 0 {
+		return 0, fmt.Errorf("ubus %v %v returned no result", object, method)
+	}
+	var code int
+	if err := json.Unmarshal(rpc.Result[0], &code); err != nil {
+		return 0, fmt.Errorf("failed to decode ubus status: %w", err)
+	}
+	if code != 0 {
+		return code, fmt.Errorf("ubus %v %v returned status %v", object, method, code)
+	}
+	if v != nil && len(rpc.Result) > 1 {
+		if err := json.Unmarshal(rpc.Result[1], v); err != nil {
+			return 0, fmt.Errorf("failed to decode ubus result: %w", err)
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,77 @@
+package enforcer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+)
+
+func TestOpenWrt_BlockUnblock(t *testing.T) {
+	var calls []string
+	rules := make(map[string]map[string]any)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/ubus", r.URL.Path)
+		var req struct {
+			ID     int   `json:"id"`
+			Params []any `json:"params"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		session, object, method := req.Params[0].(string), req.Params[1].(string), req.Params[2].(string)
+		args := req.Params[3].(map[string]any)
+		reply := func(result ...any) {
+			_ = json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": result})
+		}
+
+		if object == "session" && method == "login" {
+			assert.Equal(t, ubusAnonymousSession, session)
+			assert.Equal(t, "root", args["username"])
+			reply(0, map[string]string{"ubus_rpc_session": "abc"})
+			return
+		}
+		if session != "abc" {
+			_ = json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "error": map[string]any{"code": ubusAccessDenied, "message": "Access denied"}})
+			return
+		}
+		calls = append(calls, object+" "+method)
+		switch object + " " + method {
+		case "uci delete":
+			name := args["section"].(string)
+			if _, ok := rules[name]; !ok {
+				reply(ubusStatusNotFound)
+				return
+			}
+			delete(rules, name)
+		case "uci add":
+			rules[args["name"].(string)] = args["values"].(map[string]any)
+		case "uci commit", "rc init":
+		default:
+			reply(3)
+			return
+		}
+		reply(0)
+	}))
+	defer srv.Close()
+
+	b, err := NewBackend(&config.RouterConfig{Backend: "openwrt", URL: srv.URL, Username: "root", Password: "secret"})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	assert.NoError(t, b.Block(ctx, "AA-BB-CC-DD-EE-FF"))
+	assert.Equal(t, map[string]any{
+		"name": "TubeTimeout block aa:bb:cc:dd:ee:ff", "src": "lan", "dest": "wan", "src_mac": "aa:bb:cc:dd:ee:ff", "target": "REJECT",
+	}, rules["tubetimeout_aabbccddeeff"])
+	assert.Equal(t, []string{"uci delete", "uci add", "uci commit", "rc init"}, calls)
+
+	assert.NoError(t, b.Block(ctx, "AA-BB-CC-DD-EE-FF"), "expected blocking twice to replace the rule")
+	assert.Len(t, rules, 1)
+
+	b.(*openWrt).session = "expired"
+	assert.NoError(t, b.Unblock(ctx, "AA-BB-CC-DD-EE-FF"), "expected an expired session to log in again")
+	assert.Empty(t, rules)
+	assert.NoError(t, b.Unblock(ctx, "AA-BB-CC-DD-EE-FF"), "expected unblocking a MAC without a rule to succeed")
+}
@@ -0,0 +1,122 @@
+package enforcer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+func init() {
+	Register("unifi", newUniFi)
+}
+
+// uniFi blocks clients with the UniFi Network controller's block-sta command.
+// It supports UniFi OS consoles, which serve the network API under /proxy/network, and standalone controllers.
+type uniFi struct {
+	cfg      *config.RouterConfig
+	client   *http.Client
+	baseURL  string
+	mu       sync.Mutex
+	loggedIn bool
+	prefix   string // prefix is the path to the network API on UniFi OS, set on login.
+	csrf     string // csrf is the token UniFi OS requires on each request, set on login.
+}
+
+func newUniFi(cfg *config.RouterConfig, client *http.Client) (Backend, error) {
+	return &uniFi{cfg: cfg, client: client, baseURL: strings.TrimSuffix(cfg.URL, "/")}, nil
+}
+
+func (u *uniFi) Block(ctx context.Context, mac models.MAC) error {
+	return u.stationCommand(ctx, "block-sta", mac)
+}
+
+func (u *uniFi) Unblock(ctx context.Context, mac models.MAC) error {
+	return u.stationCommand(ctx, "unblock-sta", mac)
+}
+
+// stationCommand sends cmd for mac to the station manager, logging in first if needed or if the session has expired.
+func (u *uniFi) stationCommand(ctx context.Context, cmd string, mac models.MAC) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	body := map[string]string{"cmd": cmd, "mac": colonMAC(mac)}
+	for attempt := 0; attempt < 2; attempt++ {
+		if !u.loggedIn || attempt > 0 {
+			if err := u.login(ctx); err != nil {
+				return err
+			}
+		}
+		status, err := u.post(ctx, u.prefix+"/api/s/"+u.cfg.Site+"/cmd/stamgr", body)
+		if err != nil {
+			return err
+		}
+		if status == http.StatusUnauthorized || status == http.StatusForbidden { // if the session has expired...
+			continue
+		}
+		if status != http.StatusOK {
+			return fmt.Errorf("unifi %v returned status %v", cmd, status)
+		}
+		return nil
+	}
+	return fmt.Errorf("unifi %v was not authorized", cmd)
+}
+
+// login tries the UniFi OS login first and falls back to the standalone controller login.
+// It should be called under lock.
+func (u *uniFi) login(ctx context.Context) error {
+	creds := map[string]string{"username": u.cfg.Username, "password": u.cfg.Password}
+	u.loggedIn, u.csrf = false, ""
+	status, err := u.post(ctx, "/api/auth/login", creds)
+	if err != nil {
+		return err
+	}
+	if status == http.StatusOK {
+		u.loggedIn, u.prefix = true, "/proxy/network"
+		return nil
+	}
+	if status != http.StatusNotFound {
+		return fmt.Errorf("unifi login returned status %v", status)
+	}
+	status, err = u.post(ctx, "/api/login", creds)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("unifi login returned status %v", status)
+	}
+	u.loggedIn, u.prefix = true, ""
+	return nil
+}
+
+// post sends v as JSON to path and returns the response status.
+// The CSRF token is saved from responses that include one.
+func (u *uniFi) post(ctx context.Context, path string, v any) (int, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, fmt.Errorf("failed to encode unifi request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create unifi request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if u.csrf != "" {
+		req.Header.Set("X-CSRF-Token", u.csrf)
+	}
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("unifi request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if token := resp.Header.Get("X-CSRF-Token"); token != "" {
+		u.csrf = token
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,73 @@
+package enforcer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+)
+
+func TestUniFi_BlockUnblock(t *testing.T) {
+	tests := []struct {
+		name      string
+		unifiOS   bool
+		loginPath string
+		cmdPath   string
+	}{
+		{"UniFi OS console", true, "/api/auth/login", "/proxy/network/api/s/home/cmd/stamgr"},
+		{"Standalone controller", false, "/api/login", "/api/s/home/cmd/stamgr"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logins int
+			var cmds []map[string]string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api/auth/login", "/api/login":
+					if r.URL.Path != tt.loginPath {
+						http.NotFound(w, r)
+						return
+					}
+					var creds map[string]string
+					_ = json.NewDecoder(r.Body).Decode(&creds)
+					assert.Equal(t, "admin", creds["username"])
+					logins++
+					http.SetCookie(w, &http.Cookie{Name: "TOKEN", Value: "session", Path: "/"})
+					if tt.unifiOS {
+						w.Header().Set("X-CSRF-Token", "csrf")
+					}
+				case tt.cmdPath:
+					if c, err := r.Cookie("TOKEN"); err != nil || c.Value != "session" || (tt.unifiOS && r.Header.Get("X-CSRF-Token") != "csrf") {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					var cmd map[string]string
+					_ = json.NewDecoder(r.Body).Decode(&cmd)
+					cmds = append(cmds, cmd)
+					if len(cmds) == 1 { // expire the session after the first command.
+						http.SetCookie(w, &http.Cookie{Name: "TOKEN", Value: "expired", Path: "/"})
+					}
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer srv.Close()
+
+			b, err := NewBackend(&config.RouterConfig{Backend: "unifi", URL: srv.URL, Username: "admin", Password: "secret", Site: "home"})
+			assert.NoError(t, err)
+			assert.NoError(t, b.Block(context.Background(), "AA-BB-CC-DD-EE-FF"))
+			assert.NoError(t, b.Unblock(context.Background(), "AA-BB-CC-DD-EE-FF"))
+
+			assert.Equal(t, []map[string]string{
+				{"cmd": "block-sta", "mac": "aa:bb:cc:dd:ee:ff"},
+				{"cmd": "unblock-sta", "mac": "aa:bb:cc:dd:ee:ff"},
+			}, cmds)
+			assert.Equal(t, 2, logins, "expected a new login after the session expired")
+		})
+	}
+}
@@ -0,0 +1,142 @@
+// Package eventbus is a typed publish/subscribe bus between the subsystems. The group watchers, usage tracker, packet
+// filter, DHCP server and traffic monitor publish to its topics, and consumers such as the firewall rules, the live
+// hub and the notifier subscribe to the topics they need, so a new consumer doesn't need wiring into each producer.
+package eventbus
+
+import (
+	"maps"
+	"slices"
+	"sync"
+
+	"relloyd/tubetimeout/models"
+)
+
+// Topic delivers each value published to it to its subscribers, synchronously and in the order they subscribed, so
+// producers see the same ordering they did calling receivers directly. If the topic has a clone func, each subscriber
+// is sent its own copy so that it can keep or change it. A nil topic drops what's published to it.
+type Topic[T any] struct {
+	mu          sync.RWMutex
+	clone       func(T) T
+	subscribers []*subscriber[T]
+}
+
+type subscriber[T any] struct {
+	fn func(T)
+}
+
+// NewTopic returns a topic that sends each subscriber the result of clone, or the value itself if clone is nil.
+func NewTopic[T any](clone func(T) T) *Topic[T] {
+	return &Topic[T]{clone: clone}
+}
+
+// Subscribe adds fn to the subscribers and returns a func that removes it again.
+func (t *Topic[T]) Subscribe(fn func(T)) (unsubscribe func()) {
+	s := &subscriber[T]{fn: fn}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.subscribers = append(t.subscribers, s)
+	return func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.subscribers = slices.DeleteFunc(t.subscribers, func(o *subscriber[T]) bool { return o == s })
+	}
+}
+
+// Publish sends v to each subscriber. Subscribers may subscribe or unsubscribe while it's sent, taking effect from
+// the next value.
+func (t *Topic[T]) Publish(v T) {
+	if t == nil {
+		return
+	}
+	t.mu.RLock()
+	subscribers := slices.Clone(t.subscribers)
+	t.mu.RUnlock()
+	for _, s := range subscribers {
+		if t.clone != nil {
+			s.fn(t.clone(v))
+		} else {
+			s.fn(v)
+		}
+	}
+}
+
+// Subscribers returns the number of subscribers, so that producers can skip building values nobody wants.
+func (t *Topic[T]) Subscribers() int {
+	if t == nil {
+		return 0
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.subscribers)
+}
+
+// ThresholdState is published when a group crosses its threshold in either direction.
+type ThresholdState struct {
+	Group    models.Group
+	Exceeded bool
+}
+
+// WarningState is published when a group starts or stops being warned that it's nearly out of time.
+type WarningState struct {
+	Group   models.Group
+	Warning bool
+	Reason  string
+}
+
+// Bus is the topics the subsystems publish to. Share one Bus between the producers and consumers of an app.
+type Bus struct {
+	SourceIpGroups   *Topic[models.MapIpGroups]     // SourceIpGroups is the groups of each device IP, when an ARP scan changes them.
+	SourceIpMACs     *Topic[models.MapIpMACs]       // SourceIpMACs is the MAC of each device IP, after each ARP scan.
+	DestIpDomains    *Topic[models.MapIpDomain]     // DestIpDomains is the tracked domain of each destination IP, after each resolution.
+	DestIpGroups     *Topic[models.MapIpGroups]     // DestIpGroups is the groups tracking each destination IP, after each resolution.
+	DestDomainGroups *Topic[models.MapDomainGroups] // DestDomainGroups is the groups tracking each configured domain.
+	AllowedIpGroups  *Topic[models.MapIpGroups]     // AllowedIpGroups is the groups that can always reach each IP.
+	UpstreamState    *Topic[bool]                   // UpstreamState is whether the upstream DNS resolvers answer, when it changes.
+	ThresholdStates  *Topic[ThresholdState]
+	WarningStates    *Topic[WarningState]
+	ModeTransitions  *Topic[models.ModeTransition] // ModeTransitions is each change to whether a group is blocked.
+	DHCPStates       *Topic[string]                // DHCPStates is the state of the local DHCP service, when it changes.
+	FailOpen         *Topic[bool]                  // FailOpen is whether traffic should bypass the queues, when it changes.
+	Failsafe         *Topic[string]                // Failsafe is why the watchdog removed the firewall rules, when it does.
+	Live             *Topic[models.LiveEvent]      // Live is the usage, activity, verdict and backpressure events for the dashboard.
+}
+
+// NewBus returns a bus with every topic ready. Map values are copied per subscriber like the receivers used to be
+// sent, so the group lists in them are shared except for the allowlist IPs.
+func NewBus() *Bus {
+	return &Bus{
+		SourceIpGroups:   NewTopic(maps.Clone[models.MapIpGroups]),
+		SourceIpMACs:     NewTopic(maps.Clone[models.MapIpMACs]),
+		DestIpDomains:    NewTopic(maps.Clone[models.MapIpDomain]),
+		DestIpGroups:     NewTopic(maps.Clone[models.MapIpGroups]),
+		DestDomainGroups: NewTopic(maps.Clone[models.MapDomainGroups]),
+		AllowedIpGroups:  NewTopic(cloneIpGroups),
+		UpstreamState:    NewTopic[bool](nil),
+		ThresholdStates:  NewTopic[ThresholdState](nil),
+		WarningStates:    NewTopic[WarningState](nil),
+		ModeTransitions:  NewTopic[models.ModeTransition](nil),
+		DHCPStates:       NewTopic[string](nil),
+		FailOpen:         NewTopic[bool](nil),
+		Failsafe:         NewTopic[string](nil),
+		Live:             NewTopic[models.LiveEvent](nil),
+	}
+}
+
+// cloneIpGroups copies the map and its group lists.
+func cloneIpGroups(m models.MapIpGroups) models.MapIpGroups {
+	c := make(models.MapIpGroups, len(m))
+	for k, v := range m {
+		c[k] = slices.Clone(v)
+	}
+	return c
+}
+
+// ThresholdStateFunc adapts a receiver to subscribe to ThresholdStates.
+func ThresholdStateFunc(r models.ThresholdStateReceiver) func(ThresholdState) {
+	return func(e ThresholdState) { r.UpdateThresholdState(e.Group, e.Exceeded) }
+}
+
+// WarningStateFunc adapts a receiver to subscribe to WarningStates.
+func WarningStateFunc(r models.WarningStateReceiver) func(WarningState) {
+	return func(e WarningState) { r.UpdateWarningState(e.Group, e.Warning, e.Reason) }
+}
@@ -0,0 +1,79 @@
+package eventbus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/models"
+)
+
+func TestTopic_PublishInOrder(t *testing.T) {
+	topic := NewTopic[int](nil)
+	var got []string
+	topic.Subscribe(func(v int) { got = append(got, "a") })
+	unsubscribe := topic.Subscribe(func(v int) { got = append(got, "b") })
+	topic.Subscribe(func(v int) { got = append(got, "c") })
+	assert.Equal(t, 3, topic.Subscribers())
+
+	topic.Publish(1)
+	assert.Equal(t, []string{"a", "b", "c"}, got, "expected subscribers to be called in the order they subscribed")
+
+	unsubscribe()
+	unsubscribe() // a second call does nothing.
+	got = nil
+	topic.Publish(2)
+	assert.Equal(t, []string{"a", "c"}, got)
+	assert.Equal(t, 2, topic.Subscribers())
+}
+
+func TestTopic_ClonesPerSubscriber(t *testing.T) {
+	bus := NewBus()
+	var first, second models.MapIpGroups
+	bus.AllowedIpGroups.Subscribe(func(m models.MapIpGroups) {
+		first = m
+		m["1.2.3.4"][0] = "changed"
+		m["5.6.7.8"] = nil
+	})
+	bus.AllowedIpGroups.Subscribe(func(m models.MapIpGroups) { second = m })
+
+	published := models.MapIpGroups{"1.2.3.4": {"kids"}}
+	bus.AllowedIpGroups.Publish(published)
+	assert.Equal(t, models.MapIpGroups{"1.2.3.4": {"kids"}}, published, "expected the published map to be left as it is")
+	assert.Equal(t, models.MapIpGroups{"1.2.3.4": {"kids"}}, second, "expected each subscriber to get its own copy")
+	assert.Len(t, first, 2)
+}
+
+func TestTopic_Nil(t *testing.T) {
+	var topic *Topic[bool]
+	assert.NotPanics(t, func() { topic.Publish(true) })
+	assert.Zero(t, topic.Subscribers())
+}
+
+func TestTopic_SubscribeWhilePublishing(t *testing.T) {
+	topic := NewTopic[string](nil)
+	calls := 0
+	topic.Subscribe(func(string) {
+		calls++
+		topic.Subscribe(func(string) { calls++ })
+	})
+	topic.Publish("first")
+	assert.Equal(t, 1, calls, "expected a subscriber added while publishing to get the next value only")
+	topic.Publish("second")
+	assert.Equal(t, 3, calls)
+}
+
+type mockThresholdReceiver struct {
+	updates []ThresholdState
+}
+
+func (m *mockThresholdReceiver) UpdateThresholdState(group models.Group, exceeded bool) {
+	m.updates = append(m.updates, ThresholdState{Group: group, Exceeded: exceeded})
+}
+
+func TestThresholdStateFunc(t *testing.T) {
+	bus := NewBus()
+	r := &mockThresholdReceiver{}
+	bus.ThresholdStates.Subscribe(ThresholdStateFunc(r))
+	bus.ThresholdStates.Publish(ThresholdState{Group: "kids", Exceeded: true})
+	assert.Equal(t, []ThresholdState{{Group: "kids", Exceeded: true}}, r.updates)
+}
@@ -0,0 +1,129 @@
+package exempt
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+var (
+	ErrInvalidExemption       = errors.New("invalid exemption")
+	defaultExemptionsFilePath = "exemptions.yaml"
+	fnGetExemptions           = config.GetConfig[*exemptionsFile]
+	fnSetExemptions           = config.SetConfig[*exemptionsFile]
+)
+
+func init() {
+	config.Backups.Register(defaultExemptionsFilePath, "exempt devices")
+}
+
+// exemptionsFile is the YAML structure of the exemptions file.
+type exemptionsFile struct {
+	Devices []models.Exemption `yaml:"devices"`
+}
+
+// List is the devices whose traffic the firewall leaves alone, e.g. the parents' phones, even if they're in a group
+// or the source IPs are tracked individually. The firewall is sent the list each time it changes, so that the
+// devices' packets are accepted in the kernel rather than queued and accepted by the filter.
+type List struct {
+	logger     *zap.SugaredLogger
+	mu         sync.Mutex   // mu protects the file via fnGetExemptions/fnSetExemptions.
+	muState    sync.RWMutex // muState protects exemptions and receivers.
+	exemptions []models.Exemption
+	receivers  []models.ExemptionReceiver
+}
+
+// NewList loads the exemptions, leaving the list empty if the file doesn't exist yet.
+func NewList(logger *zap.SugaredLogger) (*List, error) {
+	l := &List{logger: logger}
+	v, err := fnGetExemptions(&l.mu, defaultExemptionsFilePath, func() *exemptionsFile { return &exemptionsFile{} })
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the exemptions: %w", err)
+	}
+	if v == nil { // if the file is new...
+		return l, nil
+	}
+	if l.exemptions, err = cleanExemptions(v.Devices); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// RegisterExemptionReceivers sends the receivers the exemptions now and each time they're changed.
+func (l *List) RegisterExemptionReceivers(receivers ...models.ExemptionReceiver) {
+	l.muState.Lock()
+	defer l.muState.Unlock()
+	l.receivers = append(l.receivers, receivers...)
+	for _, r := range receivers {
+		r.UpdateExemptions(slices.Clone(l.exemptions))
+	}
+}
+
+// Exemptions returns the exempt devices.
+func (l *List) Exemptions() []models.Exemption {
+	l.muState.RLock()
+	defer l.muState.RUnlock()
+	if l.exemptions == nil {
+		return []models.Exemption{}
+	}
+	return slices.Clone(l.exemptions)
+}
+
+// SetExemptions replaces the exempt devices, saves them and sends them to the receivers. An error wrapping
+// ErrInvalidExemption is returned if an exemption has neither a MAC nor an IP, or one that isn't valid, e.g. an IPv6
+// address.
+func (l *List) SetExemptions(exemptions []models.Exemption) error {
+	cleaned, err := cleanExemptions(exemptions)
+	if err != nil {
+		return err
+	}
+	return fnSetExemptions(&l.mu, defaultExemptionsFilePath, nil, func(v *exemptionsFile) {
+		l.muState.Lock()
+		defer l.muState.Unlock()
+		l.exemptions = v.Devices
+		for _, r := range l.receivers {
+			r.UpdateExemptions(slices.Clone(v.Devices))
+		}
+		l.logger.Infof("Exemptions updated with %d devices", len(v.Devices))
+	}, &exemptionsFile{Devices: cleaned})
+}
+
+// cleanExemptions returns a copy of the exemptions with their MACs in the usual form and their names and IPs trimmed,
+// dropping any that are repeated.
+func cleanExemptions(exemptions []models.Exemption) ([]models.Exemption, error) {
+	var cleaned []models.Exemption
+	seen := make(map[string]bool)
+	for _, e := range exemptions {
+		c := models.Exemption{Name: strings.TrimSpace(e.Name)}
+		if s := strings.TrimSpace(string(e.MAC)); s != "" {
+			if _, err := net.ParseMAC(strings.ReplaceAll(s, "-", ":")); err != nil {
+				return nil, fmt.Errorf("%w: %q isn't a MAC address", ErrInvalidExemption, e.MAC)
+			}
+			c.MAC = models.MAC(models.NewMAC(s))
+		}
+		if s := strings.TrimSpace(string(e.IP)); s != "" {
+			ip := net.ParseIP(s).To4()
+			if ip == nil {
+				return nil, fmt.Errorf("%w: %q isn't an IPv4 address", ErrInvalidExemption, e.IP)
+			}
+			c.IP = models.Ip(ip.String())
+		}
+		if c.MAC == "" && c.IP == "" {
+			return nil, fmt.Errorf("%w: %q has neither a MAC nor an IP", ErrInvalidExemption, c.Name)
+		}
+		key := string(c.MAC) + "/" + string(c.IP)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		cleaned = append(cleaned, c)
+	}
+	return cleaned, nil
+}
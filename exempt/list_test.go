@@ -0,0 +1,108 @@
+package exempt
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+// mockExemptionsFile replaces the exemptions file with one in memory and returns it.
+func mockExemptionsFile(t *testing.T, saved *exemptionsFile) **exemptionsFile {
+	origGet, origSet := fnGetExemptions, fnSetExemptions
+	t.Cleanup(func() {
+		fnGetExemptions, fnSetExemptions = origGet, origSet
+	})
+	fnGetExemptions = func(mu *sync.Mutex, configPath string, newInstance func() *exemptionsFile) (*exemptionsFile, error) {
+		return saved, nil
+	}
+	fnSetExemptions = func(mu *sync.Mutex, configPath string, validate func(v *exemptionsFile) error, updateInMemory func(v *exemptionsFile), v *exemptionsFile) error {
+		mu.Lock()
+		defer mu.Unlock()
+		saved = v
+		updateInMemory(v)
+		return nil
+	}
+	return &saved
+}
+
+// mockReceiver records the exemptions it's sent.
+type mockReceiver struct {
+	updates [][]models.Exemption
+}
+
+func (m *mockReceiver) UpdateExemptions(exemptions []models.Exemption) {
+	m.updates = append(m.updates, exemptions)
+}
+
+func TestNewList(t *testing.T) {
+	mockExemptionsFile(t, &exemptionsFile{Devices: []models.Exemption{{MAC: "aa:bb:cc:dd:ee:ff", Name: " Work laptop "}}})
+	l, err := NewList(config.MustGetLogger())
+	require.NoError(t, err)
+	assert.Equal(t, []models.Exemption{{MAC: "AA-BB-CC-DD-EE-FF", Name: "Work laptop"}}, l.Exemptions())
+
+	// Expect a new file to leave the list empty.
+	mockExemptionsFile(t, nil)
+	l, err = NewList(config.MustGetLogger())
+	require.NoError(t, err)
+	assert.Equal(t, []models.Exemption{}, l.Exemptions())
+
+	fnGetExemptions = func(*sync.Mutex, string, func() *exemptionsFile) (*exemptionsFile, error) {
+		return nil, errors.New("permission denied")
+	}
+	_, err = NewList(config.MustGetLogger())
+	assert.Error(t, err)
+}
+
+func TestList_SetExemptions(t *testing.T) {
+	saved := mockExemptionsFile(t, nil)
+	l, err := NewList(config.MustGetLogger())
+	require.NoError(t, err)
+
+	// Expect receivers to be sent the exemptions when they register and each time they're saved.
+	r := &mockReceiver{}
+	l.RegisterExemptionReceivers(r)
+	require.NoError(t, l.SetExemptions([]models.Exemption{{IP: " 192.168.1.20 ", Name: "Mum's phone"}, {IP: "192.168.1.20"}}))
+	want := []models.Exemption{{IP: "192.168.1.20", Name: "Mum's phone"}}
+	assert.Equal(t, want, (*saved).Devices)
+	assert.Equal(t, want, l.Exemptions())
+	assert.Equal(t, [][]models.Exemption{nil, want}, r.updates)
+
+	// Expect invalid exemptions to be rejected without saving them.
+	err = l.SetExemptions([]models.Exemption{{IP: "fe80::1"}})
+	assert.ErrorIs(t, err, ErrInvalidExemption)
+	assert.Equal(t, want, (*saved).Devices)
+	assert.Len(t, r.updates, 2)
+}
+
+func TestCleanExemptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      []models.Exemption
+		want    []models.Exemption
+		wantErr bool
+	}{
+		{"MACs use hyphens in upper case", []models.Exemption{{MAC: "aa:bb:cc:dd:ee:ff"}}, []models.Exemption{{MAC: "AA-BB-CC-DD-EE-FF"}}, false},
+		{"a MAC and an IP can be given together", []models.Exemption{{MAC: "AA-BB-CC-DD-EE-FF", IP: "10.0.0.5"}}, []models.Exemption{{MAC: "AA-BB-CC-DD-EE-FF", IP: "10.0.0.5"}}, false},
+		{"repeats are dropped", []models.Exemption{{MAC: "AA-BB-CC-DD-EE-FF", Name: "laptop"}, {MAC: "aa-bb-cc-dd-ee-ff"}}, []models.Exemption{{MAC: "AA-BB-CC-DD-EE-FF", Name: "laptop"}}, false},
+		{"neither a MAC nor an IP", []models.Exemption{{Name: "laptop"}}, nil, true},
+		{"bad MAC", []models.Exemption{{MAC: "laptop"}}, nil, true},
+		{"bad IP", []models.Exemption{{IP: "192.168.1"}}, nil, true},
+		{"IPv6", []models.Exemption{{IP: "2001:db8::1"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cleanExemptions(tt.in)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidExemption)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package firewall
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/iptables"
+	"relloyd/tubetimeout/models"
+	"relloyd/tubetimeout/nft"
+)
+
+// The FILTER_FIREWALL_BACKEND values.
+const (
+	backendAuto     = "auto"
+	backendNFTables = "nftables"
+	backendIPTables = "iptables"
+)
+
+var (
+	fnNFTablesAvailable = nft.Available
+	fnIPTablesAvailable = func() error { return config.CheckCmdAvailability("iptables-restore") }
+	fnNewNFTables       = func(logger *zap.SugaredLogger, cfg *config.FilterConfig, sampler models.PacketSampler) (Backend, error) {
+		rules, err := nft.NewNFTRules(logger, cfg, sampler)
+		if err != nil {
+			return nil, err
+		}
+		return rules, nil
+	}
+	fnNewIPTables = func(logger *zap.SugaredLogger, cfg *config.FilterConfig) (Backend, error) {
+		rules, err := iptables.NewIPTablesRules(logger, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return rules, nil
+	}
+)
+
+// Backend is the firewall that sends the traffic between the local and remote IPs to the NFQs, and drops it for the
+// kill switch and the devices caught getting around the filter. The traffic of the exempt devices is left alone.
+type Backend interface {
+	models.SourceIpGroupsReceiver
+	models.SourceIpMACReceiver
+	models.ExemptionReceiver
+	models.DestIpDomainReceiver
+	models.ThresholdStateReceiver
+	models.KillSwitchReceiver
+	models.FailOpenReceiver
+	UpdateBypassBlocked(ips []models.Ip)
+	SampleRate(ip models.Ip) int
+	Readiness() models.Readiness
+	Health() models.SubsystemHealth
+	Snapshot() (models.NFTSnapshot, error)
+	StartReconciler(ctx context.Context, interval time.Duration)
+	Clean(logger *zap.SugaredLogger) error
+}
+
+// New creates the rules of the backend in cfg.FirewallBackend. With auto, nftables is used unless the kernel doesn't
+// support it, in which case iptables is used if it's installed. If cfg.SampleRate is more than 1, sampler decides
+// which groups only need 1 in SampleRate packets queued, where the backend supports it.
+func New(logger *zap.SugaredLogger, cfg *config.FilterConfig, sampler models.PacketSampler) (Backend, error) {
+	switch cfg.FirewallBackend {
+	case backendNFTables:
+		return fnNewNFTables(logger, cfg, sampler)
+	case backendIPTables:
+		return fnNewIPTables(logger, cfg)
+	case backendAuto, "":
+		nftErr := fnNFTablesAvailable()
+		if nftErr == nil {
+			return fnNewNFTables(logger, cfg, sampler)
+		}
+		if err := fnIPTablesAvailable(); err != nil {
+			return nil, fmt.Errorf("neither nftables (%v) nor iptables (%v) are available", nftErr, err)
+		}
+		logger.Warnf("Using the iptables firewall backend since nftables isn't available: %v", nftErr)
+		return fnNewIPTables(logger, cfg)
+	}
+	return nil, fmt.Errorf("unknown firewall backend %q, expected %v, %v or %v", cfg.FirewallBackend, backendAuto, backendNFTables, backendIPTables)
+}
@@ -0,0 +1,54 @@
+package firewall
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/iptables"
+	"relloyd/tubetimeout/models"
+)
+
+func TestNew(t *testing.T) {
+	defer func(nftOK, iptOK func() error, newNFT func(*zap.SugaredLogger, *config.FilterConfig, models.PacketSampler) (Backend, error)) {
+		fnNFTablesAvailable, fnIPTablesAvailable, fnNewNFTables = nftOK, iptOK, newNFT
+	}(fnNFTablesAvailable, fnIPTablesAvailable, fnNewNFTables)
+	config.UseFakeExec(t)
+	nftBackend := &iptables.Rules{} // stands in for the nftables rules, which need netlink.
+	fnNewNFTables = func(*zap.SugaredLogger, *config.FilterConfig, models.PacketSampler) (Backend, error) {
+		return nftBackend, nil
+	}
+	unavailable := func() error { return errors.New("not supported") }
+	available := func() error { return nil }
+
+	tests := []struct {
+		name         string
+		setting      string
+		nftOK, iptOK func() error
+		wantNFT      bool
+		wantErr      bool
+	}{
+		{"auto uses nftables when available", "auto", available, available, true, false},
+		{"auto falls back to iptables", "", unavailable, available, false, false},
+		{"auto fails without either", "auto", unavailable, unavailable, false, true},
+		{"nftables is used when set", "nftables", unavailable, available, true, false},
+		{"iptables is used when set", "iptables", available, unavailable, false, false},
+		{"unknown backends fail", "pf", available, available, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fnNFTablesAvailable, fnIPTablesAvailable = tt.nftOK, tt.iptOK
+			cfg := &config.FilterConfig{FirewallBackend: tt.setting, OutboundQueueNumber: 100, InboundQueueNumber: 101}
+			b, err := New(config.MustGetLogger(), cfg, nil)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, b)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantNFT, b == nftBackend)
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package group
+
+import (
+	"net"
+	"slices"
+
+	"relloyd/tubetimeout/models"
+)
+
+// RegisterAllowlistSources adds sources of the domains and IPs that groups can always reach.
+func (dw *DomainWatcher) RegisterAllowlistSources(sources ...models.AllowlistSource) {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	dw.allowlistSources = append(dw.allowlistSources, sources...)
+}
+
+// RegisterAllowedIpGroupReceivers subscribes receivers to the bus's resolved allowlist IPs.
+func (dw *DomainWatcher) RegisterAllowedIpGroupReceivers(receivers ...models.AllowedIpGroupsReceiver) {
+	for _, r := range receivers {
+		dw.bus.AllowedIpGroups.Subscribe(r.UpdateAllowedIpGroups)
+	}
+}
+
+// refreshAllowlists resolves the domains in the groups' allowlists and maps each IP to the groups that can always
+// reach it. IPs in the allowlists are used as they are. It should be called under refreshMu.
+func (dw *DomainWatcher) refreshAllowlists() {
+	dw.mu.RLock()
+	sources := slices.Clone(dw.allowlistSources)
+	dw.mu.RUnlock()
+
+	allowlists := make(models.MapGroupAllowlist)
+	for _, src := range sources {
+		for group, entries := range src.Allowlists() {
+			allowlists[group] = append(allowlists[group], entries...)
+		}
+	}
+
+	allowed := make(models.MapIpGroups)
+	add := func(ip models.Ip, group models.Group) {
+		if !slices.Contains(allowed[ip], group) {
+			allowed[ip] = append(allowed[ip], group)
+		}
+	}
+	for group, entries := range allowlists {
+		var domains []models.Domain
+		for _, e := range entries {
+			if ip := net.ParseIP(e); ip != nil {
+				add(models.Ip(ip.String()), group)
+			} else {
+				domains = append(domains, models.Domain(e))
+			}
+		}
+		if len(domains) == 0 {
+			continue
+		}
+		for ip := range dw.resolver(dw.logger, domains) {
+			add(ip, group)
+		}
+	}
+	dw.allowlistGroups = len(allowlists)
+	dw.allowedIpGroups = allowed
+}
+
+// excludeAllowed removes the IPs that every group can always reach from the IPs of tracked domains, so that their
+// packets aren't queued at all. IPs that only some groups can always reach are kept, and left to the NFQ filter to
+// let through for those groups. It should be called under refreshMu.
+func (dw *DomainWatcher) excludeAllowed(ipDomains models.MapIpDomain, ipGroups models.MapIpGroups) {
+	if dw.allowlistGroups == 0 {
+		return
+	}
+	excluded := 0
+	for ip, groups := range dw.allowedIpGroups {
+		if len(groups) < dw.allowlistGroups {
+			continue
+		}
+		if _, ok := ipDomains[ip]; ok {
+			excluded++
+		}
+		delete(ipDomains, ip)
+		delete(ipGroups, ip)
+	}
+	if excluded > 0 {
+		dw.logger.Infof("Domain watcher excluded %v IPs that every group is allowed to reach", excluded)
+	}
+}
+
+// notifyAllowedReceivers publishes the allowlist IPs, which the bus copies for each subscriber. It should be called
+// under refreshMu.
+func (dw *DomainWatcher) notifyAllowedReceivers() {
+	dw.bus.AllowedIpGroups.Publish(dw.allowedIpGroups)
+}
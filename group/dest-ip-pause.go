@@ -0,0 +1,156 @@
+package group
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"relloyd/tubetimeout/models"
+)
+
+// resolutionPause stops a domain group's IPs being updated, so that the blast radius of suspected false positives,
+// e.g. an IP shared by YouTube and Google Meet, can be contained while investigating.
+type resolutionPause struct {
+	mode   models.ResolutionPauseMode
+	until  time.Time
+	frozen models.MapIpDomain // frozen are the group's IPs when it was paused, if the mode is ResolutionFreeze.
+	timer  *time.Timer        // timer resumes resolution at until.
+}
+
+// PauseResolution stops updating the group's IPs for d, or the configured PauseDuration if d isn't positive.
+// ResolutionFreeze keeps the group's current IPs and ResolutionClear removes them. The receivers are sent the change
+// straight away, without resolving any domains.
+func (dw *DomainWatcher) PauseResolution(group models.Group, mode models.ResolutionPauseMode, d time.Duration) (models.ResolutionPause, error) {
+	if mode != models.ResolutionFreeze && mode != models.ResolutionClear {
+		return models.ResolutionPause{}, fmt.Errorf("invalid pause mode %q", mode)
+	}
+	if d <= 0 && dw.cfg != nil {
+		d = dw.cfg.PauseDuration
+	}
+	if d <= 0 {
+		d = time.Hour
+	}
+
+	dw.refreshMu.Lock()
+	defer dw.refreshMu.Unlock()
+	if _, ok := dw.groupDomains[group]; !ok {
+		return models.ResolutionPause{}, models.ErrGroupNotFound
+	}
+	if old, ok := dw.pauses[group]; ok {
+		old.timer.Stop()
+	}
+	p := &resolutionPause{mode: mode, until: time.Now().Add(d)}
+	if mode == models.ResolutionFreeze {
+		p.frozen = dw.groupIPs(group)
+	}
+	p.timer = time.AfterFunc(d, func() { dw.resumeExpired(group, p) })
+	dw.pauses[group] = p
+	ipCount := dw.publish()
+
+	dw.mu.Lock()
+	dw.ipCount = ipCount
+	dw.mu.Unlock()
+	dw.logger.Infof("Domain watcher paused resolution for group %v (%v) until %v with %v IPs", group, mode, p.until.Format(time.RFC3339), len(p.frozen))
+	return pauseState(group, p), nil
+}
+
+// ResumeResolution resumes updating the group's IPs and resolves the domains again.
+// It returns ErrGroupNotFound if the group isn't paused.
+func (dw *DomainWatcher) ResumeResolution(group models.Group) error {
+	dw.refreshMu.Lock()
+	p, ok := dw.pauses[group]
+	if ok {
+		p.timer.Stop()
+		delete(dw.pauses, group)
+	}
+	dw.refreshMu.Unlock()
+	if !ok {
+		return models.ErrGroupNotFound
+	}
+	dw.logger.Infof("Domain watcher resumed resolution for group %v", group)
+	return dw.refresh(false)
+}
+
+// resumeExpired resumes the group if p is still its pause, i.e. it hasn't been resumed or paused again since.
+func (dw *DomainWatcher) resumeExpired(group models.Group, p *resolutionPause) {
+	dw.refreshMu.Lock()
+	current := dw.pauses[group] == p
+	dw.refreshMu.Unlock()
+	if !current {
+		return
+	}
+	if err := dw.ResumeResolution(group); err != nil && err != models.ErrGroupNotFound {
+		dw.logger.Errorf("Error resuming resolution for group %v: %v", group, err)
+	}
+}
+
+// ResolutionPauses returns the groups whose resolution is paused.
+func (dw *DomainWatcher) ResolutionPauses() []models.ResolutionPause {
+	dw.refreshMu.Lock()
+	defer dw.refreshMu.Unlock()
+	retval := make([]models.ResolutionPause, 0, len(dw.pauses))
+	for group, p := range dw.pauses {
+		retval = append(retval, pauseState(group, p))
+	}
+	slices.SortFunc(retval, func(a, b models.ResolutionPause) int { return strings.Compare(string(a.Group), string(b.Group)) })
+	return retval
+}
+
+func pauseState(group models.Group, p *resolutionPause) models.ResolutionPause {
+	return models.ResolutionPause{Group: group, Mode: p.mode, Until: p.until, IPs: len(p.frozen)}
+}
+
+// groupIPs returns the IPs last sent for the group with their domains. It should be called under refreshMu.
+func (dw *DomainWatcher) groupIPs(group models.Group) models.MapIpDomain {
+	dw.destIpGroups.Mu.RLock()
+	defer dw.destIpGroups.Mu.RUnlock()
+	dw.destIpDomains.Mu.RLock()
+	defer dw.destIpDomains.Mu.RUnlock()
+	ips := make(models.MapIpDomain)
+	for ip, groups := range dw.destIpGroups.Data {
+		if slices.Contains(groups, group) {
+			ips[ip] = dw.destIpDomains.Data[ip]
+		}
+	}
+	return ips
+}
+
+// dropRemovedPauses forgets the pauses of groups that are no longer configured. It should be called under refreshMu.
+func (dw *DomainWatcher) dropRemovedPauses() {
+	for group, p := range dw.pauses {
+		if _, ok := dw.groupDomains[group]; !ok {
+			p.timer.Stop()
+			delete(dw.pauses, group)
+		}
+	}
+}
+
+// applyPauses removes paused groups from the IPs they resolved for, then adds frozen groups back to the IPs they had
+// when paused. IPs left without a group are removed.
+func applyPauses(ipDomains models.MapIpDomain, ipGroups models.MapIpGroups, pauses map[models.Group]*resolutionPause) {
+	if len(pauses) == 0 {
+		return
+	}
+	for ip, groups := range ipGroups {
+		groups = slices.DeleteFunc(groups, func(g models.Group) bool {
+			_, paused := pauses[g]
+			return paused
+		})
+		if len(groups) == 0 {
+			delete(ipGroups, ip)
+			delete(ipDomains, ip)
+		} else {
+			ipGroups[ip] = groups
+		}
+	}
+	for _, group := range slices.Sorted(maps.Keys(pauses)) {
+		for ip, domain := range pauses[group].frozen {
+			ipGroups[ip] = append(ipGroups[ip], group)
+			if _, ok := ipDomains[ip]; !ok {
+				ipDomains[ip] = domain
+			}
+		}
+	}
+}
@@ -3,32 +3,48 @@ package group
 import (
 	"context"
 	"fmt"
-	"maps"
 	"net"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"go.uber.org/zap"
 	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/eventbus"
 	"relloyd/tubetimeout/models"
 )
 
 type funcGroupDomainsLoader func(logger *zap.SugaredLogger) (models.MapGroupDomains, error)
 
-var fnGroupDomainLoader = funcGroupDomainsLoader(config.FetchYouTubeDomains)
+var fnGroupDomainLoader = funcGroupDomainsLoader(config.GroupDomains.GetGroupDomains)
 
 type DomainWatcher struct {
-	logger                    *zap.SugaredLogger
-	mu                        sync.RWMutex // TODO: tidy up use of locks on maps that don't need them; make locks consistent.
-	interval                  time.Duration
-	resolver                  resolver
-	groupDomains              models.MapGroupDomains
-	destIpDomains             models.IpDomains
-	destIpGroups              models.IpGroups
-	destDomainGroups          models.DomainGroups
-	destIpDomainReceivers     []models.DestIpDomainReceiver
-	destIpGroupReceivers      []models.DestIpGroupsReceiver
-	destDomainGroupsReceivers []models.DestDomainGroupsReceiver
+	logger           *zap.SugaredLogger
+	mu               sync.RWMutex // TODO: tidy up use of locks on maps that don't need them; make locks consistent.
+	interval         time.Duration
+	resolver         resolver
+	pool             *resolverPool // pool is the resolver pool used by resolver, reported on by Health.
+	cfg              *config.ResolverConfig
+	groupDomains     models.MapGroupDomains
+	destIpDomains    models.IpDomains
+	destIpGroups     models.IpGroups
+	destDomainGroups models.DomainGroups
+	bus              *eventbus.Bus // bus is where the destination IPs, allowlist IPs and upstream state are published.
+	domainSources    []models.DomainSource
+	upstreamDown     bool                              // upstreamDown is true while no domains resolve, guarded by mu.
+	refreshMu        sync.Mutex                        // refreshMu serialises periodic refreshes and reloads.
+	ipLastSeen       map[ipDomain]time.Time            // ipLastSeen is when each IP last resolved for a domain, guarded by refreshMu.
+	lastRefresh      time.Time                         // lastRefresh is the time of the last refresh, guarded by mu.
+	domainCount      int                               // domainCount is the number of domains in the last refresh, guarded by mu.
+	resolvedCount    int                               // resolvedCount is the number of domains that resolved in the last refresh, guarded by mu.
+	ipCount          int                               // ipCount is the number of IPs kept after the last refresh, guarded by mu.
+	learnedCount     int                               // learnedCount is the number of IPs learned from DNS answers, guarded by mu.
+	dnsAnswers       chan []byte                       // dnsAnswers are the DNS answers waiting to be parsed.
+	pauses           map[models.Group]*resolutionPause // pauses are the groups whose resolution is paused, guarded by refreshMu.
+	allowlistSources []models.AllowlistSource
+	allowedIpGroups  models.MapIpGroups // allowedIpGroups are the groups that can always reach each IP, guarded by refreshMu.
+	allowlistGroups  int                // allowlistGroups is the number of groups in the allowlists, guarded by refreshMu.
 }
 
 type resolver func(logger *zap.SugaredLogger, d []models.Domain) models.MapIpDomain
@@ -42,52 +58,75 @@ var (
 	defaultInterval = time.Minute * 5
 )
 
+// RegisterDestIpDomainReceivers subscribes receivers to the bus's destination IP domains.
 func (dw *DomainWatcher) RegisterDestIpDomainReceivers(receivers ...models.DestIpDomainReceiver) {
-	dw.mu.Lock()
-	defer dw.mu.Unlock()
-	dw.destIpDomainReceivers = append(dw.destIpDomainReceivers, receivers...)
+	for _, r := range receivers {
+		dw.bus.DestIpDomains.Subscribe(r.UpdateDestIpDomains)
+	}
 }
 
+// RegisterDestIpGroupReceivers subscribes receivers to the bus's destination IP groups.
 func (dw *DomainWatcher) RegisterDestIpGroupReceivers(receivers ...models.DestIpGroupsReceiver) {
-	dw.mu.Lock()
-	defer dw.mu.Unlock()
-	dw.destIpGroupReceivers = append(dw.destIpGroupReceivers, receivers...)
+	for _, r := range receivers {
+		dw.bus.DestIpGroups.Subscribe(r.UpdateDestIpGroups)
+	}
 }
 
+// RegisterDestDomainGroupReceivers subscribes receivers to the bus's destination domain groups.
 func (dw *DomainWatcher) RegisterDestDomainGroupReceivers(receivers ...models.DestDomainGroupsReceiver) {
+	for _, r := range receivers {
+		dw.bus.DestDomainGroups.Subscribe(r.UpdateDestDomainGroups)
+	}
+}
+
+// RegisterUpstreamStateReceivers subscribes receivers to the bus's upstream state, to be notified when no domains can
+// be resolved, or can be again.
+func (dw *DomainWatcher) RegisterUpstreamStateReceivers(receivers ...models.UpstreamStateReceiver) {
+	for _, r := range receivers {
+		dw.bus.UpstreamState.Subscribe(r.UpdateUpstreamState)
+	}
+}
+
+// RegisterDomainSources adds sources of extra domains to resolve on each refresh.
+func (dw *DomainWatcher) RegisterDomainSources(sources ...models.DomainSource) {
 	dw.mu.Lock()
 	defer dw.mu.Unlock()
-	dw.destDomainGroupsReceivers = append(dw.destDomainGroupsReceivers, receivers...)
+	dw.domainSources = append(dw.domainSources, sources...)
 }
 
-func NewDomainWatcher(logger *zap.SugaredLogger) *DomainWatcher {
+// NewDomainWatcher returns a DomainWatcher that publishes to the bus, or to a bus of its own if that's nil.
+func NewDomainWatcher(logger *zap.SugaredLogger, bus *eventbus.Bus) *DomainWatcher {
+	if bus == nil {
+		bus = eventbus.NewBus()
+	}
+	pool := newResolverPool(logger, &config.AppCfg.ResolverConfig)
 	return &DomainWatcher{
-		logger:                    logger,
-		mu:                        sync.RWMutex{},
-		interval:                  defaultInterval,
-		resolver:                  resolveDomainsConcurrently,
-		groupDomains:              make(models.MapGroupDomains),
-		destIpDomains:             models.IpDomains{Data: make(models.MapIpDomain)},
-		destIpGroups:              models.IpGroups{Data: make(models.MapIpGroups)},
-		destDomainGroups:          models.DomainGroups{Data: make(models.MapDomainGroups)},
-		destIpDomainReceivers:     nil,
-		destIpGroupReceivers:      nil,
-		destDomainGroupsReceivers: nil,
+		logger:           logger,
+		bus:              bus,
+		mu:               sync.RWMutex{},
+		interval:         defaultInterval,
+		resolver:         pool.resolveDomains,
+		pool:             pool,
+		cfg:              &config.AppCfg.ResolverConfig,
+		ipLastSeen:       make(map[ipDomain]time.Time),
+		dnsAnswers:       make(chan []byte, dnsAnswerQueueLen),
+		pauses:           make(map[models.Group]*resolutionPause),
+		groupDomains:     make(models.MapGroupDomains),
+		destIpDomains:    models.IpDomains{Data: make(models.MapIpDomain)},
+		destIpGroups:     models.IpGroups{Data: make(models.MapIpGroups)},
+		destDomainGroups: models.DomainGroups{Data: make(models.MapDomainGroups)},
 	}
 }
 
 // Start starts a new ticket to resolve Ip addresses for the packaged domains and sends a copy to any
-// registered receivers.
+// registered receivers. It also starts learning IPs from the DNS answers sent to ObserveDNSAnswer.
 func (dw *DomainWatcher) Start(ctx context.Context) {
+	go dw.inspectDNSAnswers(ctx)
+
 	fn := func() {
-		dw.loadGroupDomains()
-		// Collect all IPs for all domains in all groups.
-		for _, domains := range dw.groupDomains {
-			m := dw.resolver(dw.logger, domains)
-			maps.Copy(dw.destIpDomains.Data, m)
+		if err := dw.refresh(false); err != nil {
+			dw.logger.Fatalf("Error loading group domain YAML: %v\n", err)
 		}
-		dw.generateIPGroups()
-		dw.notifyReceivers()
 	}
 
 	// Periodically resolve.
@@ -106,15 +145,198 @@ func (dw *DomainWatcher) Start(ctx context.Context) {
 	}()
 }
 
+// Reload discards previously resolved domains and IPs before loading and resolving the group domains again,
+// so that domains removed from the config are also removed from receivers like the NFT sets.
+func (dw *DomainWatcher) Reload() error {
+	return dw.refresh(true)
+}
+
+// refresh loads the group domains, resolves IPs for them and notifies receivers.
+// If reset is true, the existing domains and IPs are cleared first.
+func (dw *DomainWatcher) refresh(reset bool) error {
+	dw.refreshMu.Lock()
+	defer dw.refreshMu.Unlock()
+
+	if reset {
+		dw.ipLastSeen = make(map[ipDomain]time.Time)
+		dw.destDomainGroups.Mu.Lock()
+		dw.destDomainGroups.Data = make(models.MapDomainGroups)
+		dw.destDomainGroups.Mu.Unlock()
+	}
+
+	if err := dw.loadGroupDomains(); err != nil {
+		return err
+	}
+	dw.dropRemovedPauses()
+	dw.refreshAllowlists()
+	// Collect all IPs for all domains in all groups.
+	// CDNs rotate IPs faster than we resolve them, so IPs are kept until they haven't been seen for the retention period.
+	now := time.Now()
+	domainCount, resolved := 0, make(map[models.Domain]bool)
+	for group, domains := range dw.groupDomains {
+		if _, paused := dw.pauses[group]; paused { // if the group's IPs are frozen or cleared...
+			continue
+		}
+		m := dw.resolver(dw.logger, domains)
+		domainCount += len(domains)
+		for ip, d := range m {
+			dw.ipLastSeen[ipDomain{ip: ip, domain: d}] = now
+			resolved[d] = true
+		}
+	}
+	dw.expireIPs(now)
+	ipCount := dw.publish()
+
+	upstreamDown := domainCount > 0 && len(resolved) == 0 // if none resolved, the resolvers can't be reached.
+	dw.mu.Lock()
+	dw.lastRefresh = time.Now()
+	dw.domainCount = domainCount
+	dw.resolvedCount = len(resolved)
+	dw.ipCount = ipCount
+	changed := upstreamDown != dw.upstreamDown
+	dw.upstreamDown = upstreamDown
+	dw.mu.Unlock()
+
+	if changed {
+		dw.bus.UpstreamState.Publish(!upstreamDown)
+	}
+	return nil
+}
+
+// publish sends the IPs kept for the tracked domains to the receivers, with the IPs of paused groups frozen or
+// cleared. It returns the number of IPs sent. It should be called under refreshMu.
+func (dw *DomainWatcher) publish() int {
+	ipDomains := dw.latestIpDomains()
+	ipGroups := dw.generateIPGroups()
+	applyPauses(ipDomains, ipGroups, dw.pauses)
+	dw.excludeAllowed(ipDomains, ipGroups)
+	dw.destIpDomains.Mu.Lock()
+	dw.destIpDomains.Data = ipDomains
+	dw.destIpDomains.Mu.Unlock()
+	dw.destIpGroups.Mu.Lock()
+	dw.destIpGroups.Data = ipGroups
+	dw.destIpGroups.Mu.Unlock()
+	dw.notifyReceivers()
+	dw.notifyAllowedReceivers()
+	return len(ipDomains)
+}
+
+// expireIPs forgets IPs that haven't resolved within the retention period and IPs of domains that are no longer
+// configured. It should be called under refreshMu.
+func (dw *DomainWatcher) expireIPs(now time.Time) {
+	configured := make(map[models.Domain]bool)
+	for _, domains := range dw.groupDomains {
+		for _, d := range domains {
+			configured[d] = true
+		}
+	}
+	var retention time.Duration
+	if dw.cfg != nil {
+		retention = dw.cfg.IPRetention
+	}
+	expired := 0
+	for ipd, seen := range dw.ipLastSeen {
+		if !configured[ipd.domain] || now.Sub(seen) > retention {
+			delete(dw.ipLastSeen, ipd)
+			expired++
+		}
+	}
+	if expired > 0 {
+		dw.logger.Infof("Domain watcher expired %v IPs that haven't resolved for %v", expired, retention)
+	}
+}
+
+// latestIpDomains returns the domain that each IP resolved for most recently. It should be called under refreshMu.
+func (dw *DomainWatcher) latestIpDomains() models.MapIpDomain {
+	mid := make(models.MapIpDomain)
+	lastSeen := make(map[models.Ip]time.Time)
+	for ipd, seen := range dw.ipLastSeen {
+		cur, ok := lastSeen[ipd.ip]
+		if !ok || seen.After(cur) || (seen.Equal(cur) && ipd.domain < mid[ipd.ip]) { // prefer the latest, then the first by name for a stable result.
+			mid[ipd.ip] = ipd.domain
+			lastSeen[ipd.ip] = seen
+		}
+	}
+	return mid
+}
+
+// Health reports how fresh the resolved destination IPs are. No domains resolving is unhealthy since nothing can be
+// filtered, while some failures or a missed refresh are degraded.
+func (dw *DomainWatcher) Health() models.SubsystemHealth {
+	dw.mu.RLock()
+	defer dw.mu.RUnlock()
+
+	h := models.SubsystemHealth{Name: "dns", Status: models.HealthOK}
+	if dw.lastRefresh.IsZero() {
+		h.Status = models.HealthDegraded
+		h.Message = "domains have not been resolved yet"
+		return h
+	}
+	details := map[string]any{"lastResolved": dw.lastRefresh, "domains": dw.domainCount, "resolved": dw.resolvedCount, "ips": dw.ipCount, "learned": dw.learnedCount}
+	h.Details = details
+	var failing []string
+	if dw.pool != nil {
+		resolvers := dw.pool.health()
+		details["resolvers"] = resolvers
+		cached, backingOff := dw.pool.cacheStats()
+		details["cached"], details["backingOff"] = cached, backingOff
+		for _, r := range resolvers {
+			if !r.Healthy {
+				failing = append(failing, r.Server)
+			}
+		}
+	}
+	switch {
+	case dw.domainCount == 0:
+		h.Status = models.HealthDegraded
+		h.Message = "no domains are configured"
+	case dw.resolvedCount == 0:
+		h.Status = models.HealthUnhealthy
+		h.Message = "no domains resolved"
+	case time.Since(dw.lastRefresh) > 3*dw.interval:
+		h.Status = models.HealthDegraded
+		h.Message = fmt.Sprintf("domains were last resolved %v ago", time.Since(dw.lastRefresh).Round(time.Second))
+	case dw.resolvedCount < dw.domainCount:
+		h.Status = models.HealthDegraded
+		h.Message = fmt.Sprintf("%d of %d domains failed to resolve", dw.domainCount-dw.resolvedCount, dw.domainCount)
+	case len(failing) > 0:
+		h.Status = models.HealthDegraded
+		h.Message = fmt.Sprintf("DNS resolvers are failing: %v", strings.Join(failing, ", "))
+	}
+	return h
+}
+
+// Freshness returns when the destination IPs were last resolved.
+func (dw *DomainWatcher) Freshness() models.Freshness {
+	dw.mu.RLock()
+	defer dw.mu.RUnlock()
+	return models.Freshness{Source: models.FreshnessDestinations, LastUpdated: dw.lastRefresh, Interval: dw.interval}
+}
+
+// Readiness reports whether any destination IPs have been resolved for filtering.
+func (dw *DomainWatcher) Readiness() models.Readiness {
+	dw.mu.RLock()
+	defer dw.mu.RUnlock()
+	switch {
+	case dw.lastRefresh.IsZero():
+		return models.Readiness{State: models.ReadinessWaitingForDestinations, Cause: "resolving the tracked domains"}
+	case dw.domainCount == 0:
+		return models.Readiness{State: models.ReadinessWaitingForDestinations, Cause: "no domains are configured"}
+	case dw.resolvedCount == 0:
+		return models.Readiness{State: models.ReadinessWaitingForDestinations, Cause: "DNS resolution is failing for all tracked domains"}
+	}
+	return models.Readiness{State: models.ReadinessReady}
+}
+
 // TODO: fully replace the domains each time, rather than adding to them and test for this!
 //
 //	only notify if they're new
-func (dw *DomainWatcher) loadGroupDomains() {
-	var err error
-	dw.groupDomains, err = fnGroupDomainLoader(dw.logger)
+func (dw *DomainWatcher) loadGroupDomains() error {
+	groupDomains, err := fnGroupDomainLoader(dw.logger)
 	if err != nil {
-		dw.logger.Fatalf("Error loading group domain YAML: %v\n", err)
+		return err
 	}
+	dw.groupDomains = groupDomains
 
 	// Setup DomainGroups.
 	dw.destDomainGroups.Mu.Lock()
@@ -127,91 +349,96 @@ func (dw *DomainWatcher) loadGroupDomains() {
 		}
 	}
 
-	// Notify DomainGroup receivers.
-	for _, gr := range dw.destDomainGroupsReceivers {
-		newData := make(models.MapDomainGroups)
-		for k, v := range dw.destDomainGroups.Data {
-			newData[k] = v
+	// Publish the DomainGroups.
+	dw.bus.DestDomainGroups.Publish(dw.destDomainGroups.Data)
+
+	// Resolve domains from other sources too, after the receivers above have been told about the configured
+	// domains only.
+	dw.mu.RLock()
+	sources := dw.domainSources
+	dw.mu.RUnlock()
+	for _, src := range sources {
+		for group, domains := range src.ObservedDomains() {
+			for _, domain := range domains {
+				if !slices.Contains(dw.groupDomains[group], domain) {
+					dw.groupDomains[group] = append(dw.groupDomains[group], domain)
+				}
+			}
 		}
-		gr.UpdateDestDomainGroups(newData)
 	}
+	return nil
 }
 
-func (dw *DomainWatcher) generateIPGroups() {
-	ipGroups := make(models.MapIpGroups)
-	dw.destIpDomains.Mu.RLock()
-	defer dw.destIpDomains.Mu.RUnlock()
-
-	// TODO: tidy up use of locks on maps that don't need them; make locks consistent.
-
+// generateIPGroups maps each IP to the groups of all domains it has resolved for within the retention period.
+// It should be called under refreshMu.
+func (dw *DomainWatcher) generateIPGroups() models.MapIpGroups {
+	domainGroups := make(map[models.Domain][]models.Group)
 	for group, domains := range dw.groupDomains {
 		for _, domain := range domains {
-			for ip, resolvedDomain := range dw.destIpDomains.Data {
-				if resolvedDomain == domain {
-					ipGroups[ip] = append(ipGroups[ip], group)
-				}
+			domainGroups[domain] = append(domainGroups[domain], group)
+		}
+	}
+
+	ipGroups := make(models.MapIpGroups)
+	for ipd := range dw.ipLastSeen {
+		for _, group := range domainGroups[ipd.domain] {
+			if !slices.Contains(ipGroups[ipd.ip], group) {
+				ipGroups[ipd.ip] = append(ipGroups[ipd.ip], group)
 			}
 		}
 	}
 
-	dw.destIpGroups.Mu.Lock()
-	defer dw.destIpGroups.Mu.Unlock()
-	dw.destIpGroups.Data = ipGroups
+	return ipGroups
 }
 
-// notifyReceivers duplicates the cachedIPs map per receiver and sends it.
+// notifyReceivers publishes the IP domains and IP groups, which the bus copies for each subscriber.
 func (dw *DomainWatcher) notifyReceivers() {
 	dw.mu.RLock()
 	defer dw.mu.RUnlock()
 
 	dw.logger.Infof("Domain watcher notifying receivers of %v IP domains", len(dw.destIpDomains.Data))
 	dw.logger.Debugf("Domain watcher notifying receivers of IP domains: %v", dw.destIpDomains.Data)
-	for _, receiver := range dw.destIpDomainReceivers {
-		newData := make(models.MapIpDomain)
-		dw.destIpDomains.Mu.RLock()
-		for k, v := range dw.destIpDomains.Data {
-			newData[k] = v
-		}
-		receiver.UpdateDestIpDomains(newData)
-		dw.destIpDomains.Mu.RUnlock()
-	}
+	dw.destIpDomains.Mu.RLock()
+	dw.bus.DestIpDomains.Publish(dw.destIpDomains.Data)
+	dw.destIpDomains.Mu.RUnlock()
 
 	dw.logger.Infof("Domain watcher notifying receivers of %v IP groups", len(dw.destIpGroups.Data))
 	dw.logger.Debugf("Domain watcher notifying receivers of IP groups: %v", dw.destIpGroups.Data)
-	for _, gr := range dw.destIpGroupReceivers {
-		newData := make(models.MapIpGroups)
-		dw.destIpGroups.Mu.RLock()
-		for k, v := range dw.destIpGroups.Data {
-			newData[k] = v
-		}
-		gr.UpdateDestIpGroups(newData)
-		dw.destIpGroups.Mu.RUnlock()
-	}
+	dw.destIpGroups.Mu.RLock()
+	dw.bus.DestIpGroups.Publish(dw.destIpGroups.Data)
+	dw.destIpGroups.Mu.RUnlock()
 }
 
-// resolveDomainsConcurrently resolves a list of domains concurrently.
-func resolveDomainsConcurrently(logger *zap.SugaredLogger, domains []models.Domain) models.MapIpDomain { // map[models.Domain][]models.Ip {
+// resolveDomainsConcurrently resolves a list of domains using lookup, with a pool of at most workers goroutines so that
+// a large domain list doesn't flood the resolvers.
+func resolveDomainsConcurrently(logger *zap.SugaredLogger, domains []models.Domain, workers int, lookup func(models.Domain) ([]models.Ip, error)) models.MapIpDomain {
 	var mu sync.Mutex
 	var wg sync.WaitGroup
 	var allIPs []ipDomain
 
-	for _, domain := range domains {
+	jobs := make(chan models.Domain)
+	for range min(max(workers, 1), len(domains)) {
 		wg.Add(1)
-		go func(d models.Domain) {
+		go func() {
 			defer wg.Done()
-			ips, err := resolveDomainUsingGoogle(d)
-			mu.Lock()
-			defer mu.Unlock()
-			if err != nil {
-				logger.Warnf("Error resolving %s: %v", d, err)
-				// results[d] = nil
-			} else {
-				for _, ip := range ips {
-					allIPs = append(allIPs, ipDomain{ip: models.Ip(ip), domain: domain})
+			for d := range jobs {
+				ips, err := lookup(d)
+				mu.Lock()
+				if err != nil {
+					logger.Warnf("Error resolving %s: %v", d, err)
+				} else {
+					for _, ip := range ips {
+						allIPs = append(allIPs, ipDomain{ip: ip, domain: d})
+					}
 				}
+				mu.Unlock()
 			}
-		}(domain)
+		}()
 	}
+	for _, domain := range domains {
+		jobs <- domain
+	}
+	close(jobs)
 
 	wg.Wait()
 	logger.Info("Resolved domains")
@@ -261,29 +488,3 @@ func resolveDomainUsingSystem(domain models.Domain) ([]string, error) {
 	}
 	return ipStrings, nil
 }
-
-// resolveDomainUsingGoogle resolves a single domain to its IP addresses with a timeout.
-func resolveDomainUsingGoogle(domain models.Domain) ([]models.Ip, error) {
-	var result []models.Ip
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	customResolver := &net.Resolver{
-		PreferGo:     true, // Use Go's resolver, not the system resolver
-		StrictErrors: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := net.Dialer{}
-			return d.DialContext(ctx, "udp", "8.8.8.8:53") // Google's public DNS
-		},
-	}
-
-	ips, err := customResolver.LookupIP(ctx, "ip4", string(domain)) // TODO: support IPv6
-	if err != nil {
-		return nil, fmt.Errorf("failed to resolve %s: %w", domain, err)
-	}
-
-	for _, ip := range ips {
-		result = append(result, models.Ip(ip.String()))
-	}
-	return result, nil
-}
@@ -1,12 +1,15 @@
 package group
 
 import (
+	"fmt"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/eventbus"
 	"relloyd/tubetimeout/models"
 )
 
@@ -43,12 +46,12 @@ func TestLoadGroupDomains(t *testing.T) {
 			Data: make(models.MapDomainGroups),
 			Mu:   sync.RWMutex{},
 		},
-		destDomainGroupsReceivers: []models.DestDomainGroupsReceiver{},
+		bus: eventbus.NewBus(),
 	}
 
 	// Add a mock receiver to observe notifications
 	mockReceiver := &MockDestDomainGroupReceiver{}
-	dw.destDomainGroupsReceivers = append(dw.destDomainGroupsReceivers, mockReceiver)
+	dw.RegisterDestDomainGroupReceivers(mockReceiver)
 
 	// Call the method under test
 	dw.loadGroupDomains()
@@ -78,7 +81,7 @@ func TestLoadGroupDomains(t *testing.T) {
 // TestNewDomainWatcher tests the NewDomainWatcher function created by AI overlords.
 func TestNewDomainWatcher(t *testing.T) {
 	// Call the function to create a new instance
-	dw := NewDomainWatcher(config.MustGetLogger())
+	dw := NewDomainWatcher(config.MustGetLogger(), nil)
 
 	// Assert each field is set up correctly
 	assert.NotNil(t, dw, "DomainWatcher instance should not be nil")
@@ -100,7 +103,336 @@ func TestNewDomainWatcher(t *testing.T) {
 	assert.NotNil(t, dw.destDomainGroups.Data, "destDomainGroups.Data should not be nil")
 	assert.IsType(t, models.MapDomainGroups{}, dw.destDomainGroups.Data, "destDomainGroups.Data should be of type MapDomainGroups")
 
-	assert.Nil(t, dw.destIpDomainReceivers, "destIpDomainReceivers should be nil")
-	assert.Nil(t, dw.destIpGroupReceivers, "destIpGroupReceivers should be nil")
-	assert.Nil(t, dw.destDomainGroupsReceivers, "destDomainGroupsReceivers should be nil")
+	assert.NotNil(t, dw.bus, "bus should be created when none is given")
+	assert.Zero(t, dw.bus.DestIpDomains.Subscribers(), "DestIpDomains should have no subscribers")
+	assert.Zero(t, dw.bus.DestIpGroups.Subscribers(), "DestIpGroups should have no subscribers")
+	assert.Zero(t, dw.bus.DestDomainGroups.Subscribers(), "DestDomainGroups should have no subscribers")
+}
+
+// Mock Receiver for Testing
+type MockDestIpDomainReceiver struct {
+	updatedIpDomains models.MapIpDomain
+	mu               sync.Mutex
+}
+
+func (m *MockDestIpDomainReceiver) UpdateDestIpDomains(newData models.MapIpDomain) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.updatedIpDomains = newData
+}
+
+func TestDomainWatcher_Reload(t *testing.T) {
+	originalLoaderFunc := fnGroupDomainLoader
+	defer func() { fnGroupDomainLoader = originalLoaderFunc }()
+
+	groupDomains := models.MapGroupDomains{"GroupA": {"domain1.com", "domain2.com"}}
+	fnGroupDomainLoader = func(logger *zap.SugaredLogger) (models.MapGroupDomains, error) {
+		return groupDomains, nil
+	}
+
+	dw := NewDomainWatcher(config.MustGetLogger(), nil)
+	dw.resolver = func(logger *zap.SugaredLogger, domains []models.Domain) models.MapIpDomain {
+		m := make(models.MapIpDomain)
+		for _, d := range domains {
+			m[models.Ip("ip-"+string(d))] = d
+		}
+		return m
+	}
+	mockReceiver := &MockDestIpDomainReceiver{}
+	dw.RegisterDestIpDomainReceivers(mockReceiver)
+
+	assert.NoError(t, dw.refresh(false))
+	assert.Len(t, mockReceiver.updatedIpDomains, 2)
+
+	// Remove a domain and check that its IP is dropped on reload.
+	groupDomains = models.MapGroupDomains{"GroupA": {"domain1.com"}}
+	assert.NoError(t, dw.Reload())
+	assert.Equal(t, models.MapIpDomain{"ip-domain1.com": "domain1.com"}, mockReceiver.updatedIpDomains)
+	assert.Equal(t, models.MapDomainGroups{"domain1.com": {"GroupA"}}, dw.destDomainGroups.Data)
+}
+
+func TestDomainWatcher_Health(t *testing.T) {
+	originalLoaderFunc := fnGroupDomainLoader
+	defer func() { fnGroupDomainLoader = originalLoaderFunc }()
+	fnGroupDomainLoader = func(logger *zap.SugaredLogger) (models.MapGroupDomains, error) {
+		return models.MapGroupDomains{"GroupA": {"domain1.com", "domain2.com"}}, nil
+	}
+
+	dw := NewDomainWatcher(config.MustGetLogger(), nil)
+	assert.Equal(t, models.HealthDegraded, dw.Health().Status, "expected degraded before the first refresh")
+
+	resolved := models.MapIpDomain{"1.1.1.1": "domain1.com"}
+	dw.resolver = func(logger *zap.SugaredLogger, domains []models.Domain) models.MapIpDomain {
+		return resolved
+	}
+	assert.NoError(t, dw.refresh(false))
+	h := dw.Health()
+	assert.Equal(t, models.HealthDegraded, h.Status)
+	assert.Equal(t, "1 of 2 domains failed to resolve", h.Message)
+
+	resolved = models.MapIpDomain{"1.1.1.1": "domain1.com", "2.2.2.2": "domain2.com"}
+	assert.NoError(t, dw.refresh(false))
+	assert.Equal(t, models.HealthOK, dw.Health().Status)
+
+	dw.pool.upstreams[0].failures = upstreamMaxFailures
+	h = dw.Health()
+	assert.Equal(t, models.HealthDegraded, h.Status, "expected a failing resolver to be degraded")
+	assert.Equal(t, "DNS resolvers are failing: "+dw.pool.upstreams[0].server, h.Message)
+	dw.pool.upstreams[0].failures = 0
+
+	dw.lastRefresh = time.Now().Add(-4 * dw.interval)
+	assert.Equal(t, models.HealthDegraded, dw.Health().Status, "expected stale results to be degraded")
+
+	resolved = models.MapIpDomain{}
+	assert.NoError(t, dw.refresh(false))
+	assert.Equal(t, models.HealthUnhealthy, dw.Health().Status, "expected no resolved domains to be unhealthy")
+}
+
+func TestDomainWatcher_Readiness(t *testing.T) {
+	originalLoaderFunc := fnGroupDomainLoader
+	defer func() { fnGroupDomainLoader = originalLoaderFunc }()
+	groupDomains := models.MapGroupDomains{}
+	fnGroupDomainLoader = func(logger *zap.SugaredLogger) (models.MapGroupDomains, error) {
+		return groupDomains, nil
+	}
+
+	dw := NewDomainWatcher(config.MustGetLogger(), nil)
+	resolved := models.MapIpDomain{}
+	dw.resolver = func(logger *zap.SugaredLogger, domains []models.Domain) models.MapIpDomain {
+		return resolved
+	}
+	assert.Equal(t, models.Readiness{State: models.ReadinessWaitingForDestinations, Cause: "resolving the tracked domains"}, dw.Readiness())
+
+	assert.NoError(t, dw.refresh(false))
+	assert.Equal(t, "no domains are configured", dw.Readiness().Cause)
+	assert.Equal(t, models.HealthDegraded, dw.Health().Status)
+
+	groupDomains = models.MapGroupDomains{"GroupA": {"domain1.com"}}
+	assert.NoError(t, dw.refresh(false))
+	assert.Equal(t, "DNS resolution is failing for all tracked domains", dw.Readiness().Cause)
+
+	resolved = models.MapIpDomain{"1.1.1.1": "domain1.com"}
+	assert.NoError(t, dw.refresh(false))
+	assert.Equal(t, models.Readiness{State: models.ReadinessReady}, dw.Readiness())
+}
+
+type mockUpstreamStateReceiver struct {
+	updates []bool
+}
+
+func (m *mockUpstreamStateReceiver) UpdateUpstreamState(up bool) {
+	m.updates = append(m.updates, up)
+}
+
+func TestDomainWatcher_UpstreamState(t *testing.T) {
+	originalLoaderFunc := fnGroupDomainLoader
+	defer func() { fnGroupDomainLoader = originalLoaderFunc }()
+	fnGroupDomainLoader = func(logger *zap.SugaredLogger) (models.MapGroupDomains, error) {
+		return models.MapGroupDomains{"GroupA": {"domain1.com"}}, nil
+	}
+
+	dw := NewDomainWatcher(config.MustGetLogger(), nil)
+	resolved := models.MapIpDomain{"1.1.1.1": "domain1.com"}
+	dw.resolver = func(logger *zap.SugaredLogger, domains []models.Domain) models.MapIpDomain {
+		return resolved
+	}
+	mockReceiver := &mockUpstreamStateReceiver{}
+	dw.RegisterUpstreamStateReceivers(mockReceiver)
+
+	assert.NoError(t, dw.refresh(false))
+	assert.Empty(t, mockReceiver.updates, "expected no update while the upstream stays up")
+
+	resolved = models.MapIpDomain{}
+	assert.NoError(t, dw.refresh(false))
+	assert.NoError(t, dw.refresh(false))
+	resolved = models.MapIpDomain{"1.1.1.1": "domain1.com"}
+	assert.NoError(t, dw.refresh(false))
+	assert.Equal(t, []bool{false, true}, mockReceiver.updates, "expected an update each time the upstream goes down or comes back")
+}
+
+func TestDomainWatcher_IPRetention(t *testing.T) {
+	originalLoaderFunc := fnGroupDomainLoader
+	defer func() { fnGroupDomainLoader = originalLoaderFunc }()
+	fnGroupDomainLoader = func(logger *zap.SugaredLogger) (models.MapGroupDomains, error) {
+		return models.MapGroupDomains{"GroupA": {"video.com"}, "GroupB": {"cdn.com"}}, nil
+	}
+
+	dw := NewDomainWatcher(config.MustGetLogger(), nil)
+	dw.cfg = &config.ResolverConfig{IPRetention: time.Hour}
+	resolved := map[models.Group]models.MapIpDomain{
+		"GroupA": {"1.1.1.1": "video.com"},
+		"GroupB": {"3.3.3.3": "cdn.com"},
+	}
+	dw.resolver = func(logger *zap.SugaredLogger, domains []models.Domain) models.MapIpDomain {
+		if domains[0] == "video.com" {
+			return resolved["GroupA"]
+		}
+		return resolved["GroupB"]
+	}
+	mockReceiver := &MockDestIpDomainReceiver{}
+	dw.RegisterDestIpDomainReceivers(mockReceiver)
+	assert.NoError(t, dw.refresh(false))
+
+	// The CDN rotates to a new IP and hands out one that is shared with the other domain.
+	resolved["GroupA"] = models.MapIpDomain{"2.2.2.2": "video.com", "3.3.3.3": "video.com"}
+	assert.NoError(t, dw.refresh(false))
+	assert.Equal(t, models.MapIpDomain{"1.1.1.1": "video.com", "2.2.2.2": "video.com", "3.3.3.3": "cdn.com"}, mockReceiver.updatedIpDomains,
+		"expected IPs from earlier refreshes to be kept")
+	assert.ElementsMatch(t, []models.Group{"GroupA", "GroupB"}, dw.destIpGroups.Data["3.3.3.3"], "expected a shared IP to be in the groups of both domains")
+
+	// Age the first IP beyond the retention period.
+	dw.ipLastSeen[ipDomain{ip: "1.1.1.1", domain: "video.com"}] = time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, dw.refresh(false))
+	assert.NotContains(t, mockReceiver.updatedIpDomains, models.Ip("1.1.1.1"), "expected IPs not seen within the retention period to expire")
+	assert.Contains(t, mockReceiver.updatedIpDomains, models.Ip("2.2.2.2"))
+	assert.Equal(t, 2, dw.Health().Details.(map[string]any)["ips"])
+}
+
+type mockDomainSource struct {
+	domains models.MapGroupDomains
+}
+
+func (m *mockDomainSource) ObservedDomains() models.MapGroupDomains {
+	return m.domains
+}
+
+func TestDomainWatcher_DomainSources(t *testing.T) {
+	originalLoaderFunc := fnGroupDomainLoader
+	defer func() { fnGroupDomainLoader = originalLoaderFunc }()
+	fnGroupDomainLoader = func(logger *zap.SugaredLogger) (models.MapGroupDomains, error) {
+		return models.MapGroupDomains{"youtube": {"googlevideo.com"}}, nil
+	}
+
+	dw := NewDomainWatcher(config.MustGetLogger(), nil)
+	dw.cfg = &config.ResolverConfig{IPRetention: time.Hour}
+	dw.resolver = func(logger *zap.SugaredLogger, domains []models.Domain) models.MapIpDomain {
+		m := make(models.MapIpDomain)
+		for i, d := range domains {
+			m[models.Ip(fmt.Sprintf("1.1.1.%v", i+1))] = d
+		}
+		return m
+	}
+	dw.RegisterDomainSources(&mockDomainSource{domains: models.MapGroupDomains{"youtube": {"googlevideo.com", "rr1.googlevideo.com"}}})
+	domainReceiver := &MockDestDomainGroupReceiver{}
+	dw.RegisterDestDomainGroupReceivers(domainReceiver)
+	ipReceiver := &MockDestIpDomainReceiver{}
+	dw.RegisterDestIpDomainReceivers(ipReceiver)
+	assert.NoError(t, dw.refresh(false))
+
+	assert.Equal(t, models.MapIpDomain{"1.1.1.1": "googlevideo.com", "1.1.1.2": "rr1.googlevideo.com"}, ipReceiver.updatedIpDomains,
+		"expected domains from sources to be resolved once each")
+	assert.Equal(t, models.MapDomainGroups{"googlevideo.com": {"youtube"}}, domainReceiver.updatedGroups,
+		"expected domain group receivers to be told about the configured domains only")
+}
+
+func TestDomainWatcher_PauseResolution(t *testing.T) {
+	originalLoaderFunc := fnGroupDomainLoader
+	defer func() { fnGroupDomainLoader = originalLoaderFunc }()
+	fnGroupDomainLoader = func(logger *zap.SugaredLogger) (models.MapGroupDomains, error) {
+		return models.MapGroupDomains{"GroupA": {"video.com"}, "GroupB": {"cdn.com"}}, nil
+	}
+
+	dw := NewDomainWatcher(config.MustGetLogger(), nil)
+	dw.cfg = &config.ResolverConfig{IPRetention: time.Hour, PauseDuration: time.Hour}
+	resolved := map[models.Group]models.MapIpDomain{
+		"GroupA": {"1.1.1.1": "video.com"},
+		"GroupB": {"3.3.3.3": "cdn.com"},
+	}
+	dw.resolver = func(logger *zap.SugaredLogger, domains []models.Domain) models.MapIpDomain {
+		if domains[0] == "video.com" {
+			return resolved["GroupA"]
+		}
+		return resolved["GroupB"]
+	}
+	mockReceiver := &MockDestIpDomainReceiver{}
+	dw.RegisterDestIpDomainReceivers(mockReceiver)
+	assert.NoError(t, dw.refresh(false))
+
+	_, err := dw.PauseResolution("missing", models.ResolutionFreeze, 0)
+	assert.ErrorIs(t, err, models.ErrGroupNotFound)
+	_, err = dw.PauseResolution("GroupA", "bogus", 0)
+	assert.Error(t, err, "expected an unknown mode to be rejected")
+
+	// Freezing keeps the group's IPs while its domains resolve elsewhere.
+	p, err := dw.PauseResolution("GroupA", models.ResolutionFreeze, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p.IPs)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), p.Until, time.Minute, "expected the configured pause duration by default")
+	resolved["GroupA"] = models.MapIpDomain{"2.2.2.2": "video.com"}
+	assert.NoError(t, dw.refresh(false))
+	assert.Equal(t, models.MapIpDomain{"1.1.1.1": "video.com", "3.3.3.3": "cdn.com"}, mockReceiver.updatedIpDomains,
+		"expected a frozen group's IPs not to change")
+
+	// Clearing removes the group's IPs but leaves IPs shared with other groups.
+	resolved["GroupB"] = models.MapIpDomain{"1.1.1.1": "cdn.com", "3.3.3.3": "cdn.com"}
+	assert.NoError(t, dw.refresh(false))
+	_, err = dw.PauseResolution("GroupA", models.ResolutionClear, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Group{"GroupB"}, dw.destIpGroups.Data["1.1.1.1"], "expected the cleared group to be removed from a shared IP")
+	assert.NotContains(t, mockReceiver.updatedIpDomains, models.Ip("2.2.2.2"))
+	assert.Equal(t, []models.ResolutionPause{{Group: "GroupA", Mode: models.ResolutionClear, Until: dw.pauses["GroupA"].until}}, dw.ResolutionPauses())
+
+	// Resuming resolves the group again.
+	assert.NoError(t, dw.ResumeResolution("GroupA"))
+	assert.Contains(t, mockReceiver.updatedIpDomains, models.Ip("2.2.2.2"))
+	assert.Empty(t, dw.ResolutionPauses())
+	assert.ErrorIs(t, dw.ResumeResolution("GroupA"), models.ErrGroupNotFound, "expected an error resuming a group that isn't paused")
+
+	// Pauses resume on their own once the duration has passed.
+	_, err = dw.PauseResolution("GroupB", models.ResolutionClear, 50*time.Millisecond)
+	assert.NoError(t, err)
+	assert.NotContains(t, dw.destIpGroups.Data, models.Ip("3.3.3.3"))
+	assert.Eventually(t, func() bool {
+		dw.destIpGroups.Mu.RLock()
+		defer dw.destIpGroups.Mu.RUnlock()
+		_, ok := dw.destIpGroups.Data["3.3.3.3"]
+		return ok
+	}, time.Second, 10*time.Millisecond, "expected the group's IPs to be resolved again once the pause expired")
+	assert.Empty(t, dw.ResolutionPauses())
+}
+
+type mockAllowlistSource struct {
+	allowlists models.MapGroupAllowlist
+}
+
+func (m *mockAllowlistSource) Allowlists() models.MapGroupAllowlist {
+	return m.allowlists
+}
+
+func TestDomainWatcher_Allowlist(t *testing.T) {
+	originalLoaderFunc := fnGroupDomainLoader
+	defer func() { fnGroupDomainLoader = originalLoaderFunc }()
+	fnGroupDomainLoader = func(logger *zap.SugaredLogger) (models.MapGroupDomains, error) {
+		return models.MapGroupDomains{"youtube": {"youtube.com", "googlevideo.com"}}, nil
+	}
+
+	dw := NewDomainWatcher(config.MustGetLogger(), nil)
+	dw.resolver = func(logger *zap.SugaredLogger, domains []models.Domain) models.MapIpDomain {
+		ips := map[models.Domain]models.Ip{"youtube.com": "1.1.1.1", "googlevideo.com": "2.2.2.2", "khanacademy.org": "2.2.2.2"}
+		m := make(models.MapIpDomain)
+		for _, d := range domains {
+			if ip, ok := ips[d]; ok {
+				m[ip] = d
+			}
+		}
+		return m
+	}
+	source := &mockAllowlistSource{allowlists: models.MapGroupAllowlist{"kids": {"khanacademy.org"}, "teens": {}}}
+	dw.RegisterAllowlistSources(source)
+	ipDomains := &MockDestIpDomainReceiver{}
+	dw.RegisterDestIpDomainReceivers(ipDomains)
+	mgr := NewManager(config.MustGetLogger())
+	dw.RegisterAllowedIpGroupReceivers(mgr)
+
+	assert.NoError(t, dw.refresh(false))
+	assert.Len(t, ipDomains.updatedIpDomains, 2, "expected IPs only some groups can reach to still be filtered")
+	assert.True(t, mgr.IsDestAllowed("kids", "2.2.2.2"))
+	assert.False(t, mgr.IsDestAllowed("teens", "2.2.2.2"))
+	assert.False(t, mgr.IsDestAllowed("kids", "1.1.1.1"))
+
+	// Expect IPs that every group can reach to be left out of the firewall's sets.
+	source.allowlists = models.MapGroupAllowlist{"kids": {"khanacademy.org"}, "teens": {"2.2.2.2"}}
+	assert.NoError(t, dw.refresh(false))
+	assert.Equal(t, models.MapIpDomain{"1.1.1.1": "youtube.com"}, ipDomains.updatedIpDomains)
+	assert.True(t, mgr.IsDestAllowed("teens", "2.2.2.2"))
 }
@@ -0,0 +1,197 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"relloyd/tubetimeout/models"
+)
+
+const (
+	dnsAnswerQueueLen      = 256         // dnsAnswerQueueLen is the number of DNS answers waiting to be parsed before more are dropped.
+	dnsAnswerBatchInterval = time.Second // dnsAnswerBatchInterval is how often the IPs learned from DNS answers are published.
+)
+
+// dnsAnswer is the names and IPv4 addresses in a DNS answer. The names are the question, followed by the names in
+// any CNAME records, which all lead to the IPs.
+type dnsAnswer struct {
+	names []models.Domain
+	ips   []models.Ip
+}
+
+// ObserveDNSAnswer queues an IPv4 packet holding a DNS answer to a tracked device, so that the IPs it hands out for
+// the tracked domains are filtered without waiting for the next refresh. It doesn't block: answers are dropped if
+// they arrive faster than they're parsed, and picked up by the refresh instead.
+func (dw *DomainWatcher) ObserveDNSAnswer(packet []byte) {
+	if msg, ok := udpPayload(packet); ok {
+		dw.ObserveDNSMessage(msg)
+	}
+}
+
+// ObserveDNSMessage queues a DNS answer without its IP and UDP headers, such as one relayed by the DNS forwarder, in
+// the same way as ObserveDNSAnswer.
+func (dw *DomainWatcher) ObserveDNSMessage(msg []byte) {
+	select {
+	case dw.dnsAnswers <- msg:
+	default:
+		dw.logger.Debug("Domain watcher dropped a DNS answer since the queue is full")
+	}
+}
+
+// inspectDNSAnswers parses the queued DNS answers and publishes the new IPs they hold for the tracked domains once
+// per dnsAnswerBatchInterval, so that a burst of lookups doesn't update the receivers for every answer.
+func (dw *DomainWatcher) inspectDNSAnswers(ctx context.Context) {
+	ticker := time.NewTicker(dnsAnswerBatchInterval)
+	defer ticker.Stop()
+	var pending []dnsAnswer
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg := <-dw.dnsAnswers:
+			if a, ok := parseDNSMessage(msg); ok {
+				pending = append(pending, a)
+			}
+		case <-ticker.C:
+			if len(pending) > 0 {
+				dw.learnDNSAnswers(pending, time.Now())
+				pending = nil
+			}
+		}
+	}
+}
+
+// learnDNSAnswers keeps the IPs of the answers for the tracked domains, or their subdomains, as if they'd resolved at
+// now, and publishes them if any are new. Domains whose groups are all paused are skipped, as they are by refresh.
+func (dw *DomainWatcher) learnDNSAnswers(answers []dnsAnswer, now time.Time) {
+	dw.refreshMu.Lock()
+	defer dw.refreshMu.Unlock()
+
+	watched := make(map[models.Domain]bool)
+	for group, domains := range dw.groupDomains {
+		if _, paused := dw.pauses[group]; paused {
+			continue
+		}
+		for _, d := range domains {
+			watched[d] = true
+		}
+	}
+	learned := 0
+	for _, a := range answers {
+		domain, ok := matchWatchedDomain(watched, a.names)
+		if !ok {
+			continue
+		}
+		for _, ip := range a.ips {
+			ipd := ipDomain{ip: ip, domain: domain}
+			if _, seen := dw.ipLastSeen[ipd]; !seen {
+				learned++
+			}
+			dw.ipLastSeen[ipd] = now
+		}
+	}
+	if learned == 0 { // if the receivers already have the IPs...
+		return
+	}
+	ipCount := dw.publish()
+
+	dw.mu.Lock()
+	dw.ipCount = ipCount
+	dw.learnedCount += learned
+	dw.mu.Unlock()
+	dw.logger.Infof("Domain watcher learned %v IPs from DNS answers", learned)
+}
+
+// matchWatchedDomain returns the first watched domain that is one of the names, or that one of them is a subdomain of.
+func matchWatchedDomain(watched map[models.Domain]bool, names []models.Domain) (models.Domain, bool) {
+	for _, name := range names {
+		for d := string(name); d != ""; {
+			if watched[models.Domain(d)] {
+				return models.Domain(d), true
+			}
+			_, parent, found := strings.Cut(d, ".")
+			if !found {
+				break
+			}
+			d = parent
+		}
+	}
+	return "", false
+}
+
+// parseDNSAnswer returns the names and IPv4 addresses in the successful DNS answer held by the IPv4 packet, and false
+// if it isn't one or it has no IPv4 addresses.
+func parseDNSAnswer(packet []byte) (dnsAnswer, bool) {
+	msg, ok := udpPayload(packet)
+	if !ok {
+		return dnsAnswer{}, false
+	}
+	return parseDNSMessage(msg)
+}
+
+// udpPayload returns the payload of the IPv4 UDP packet, and false if it isn't one.
+func udpPayload(packet []byte) ([]byte, bool) {
+	if len(packet) < 20 || packet[0]>>4 != 4 || packet[9] != 17 { // if it isn't IPv4 UDP...
+		return nil, false
+	}
+	ihl := int(packet[0]&0x0f) * 4
+	if ihl < 20 || len(packet) < ihl+8 {
+		return nil, false
+	}
+	return packet[ihl+8:], true // the payload follows the 8 byte UDP header.
+}
+
+// parseDNSMessage returns the names and IPv4 addresses in the successful DNS answer, and false if it isn't one or it
+// has no IPv4 addresses. AAAA records are skipped since the destination IPs are IPv4 only.
+func parseDNSMessage(msg []byte) (dnsAnswer, bool) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil || !h.Response || h.RCode != dnsmessage.RCodeSuccess {
+		return dnsAnswer{}, false
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return dnsAnswer{}, false
+	}
+	var a dnsAnswer
+	for _, q := range questions {
+		a.names = append(a.names, dnsName(q.Name))
+	}
+	for {
+		rh, err := p.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+		if err != nil {
+			return dnsAnswer{}, false
+		}
+		switch rh.Type {
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return dnsAnswer{}, false
+			}
+			a.ips = append(a.ips, models.Ip(net.IP(r.A[:]).String()))
+		case dnsmessage.TypeCNAME:
+			r, err := p.CNAMEResource()
+			if err != nil {
+				return dnsAnswer{}, false
+			}
+			a.names = append(a.names, dnsName(rh.Name), dnsName(r.CNAME))
+		default:
+			if err := p.SkipAnswer(); err != nil {
+				return dnsAnswer{}, false
+			}
+		}
+	}
+	return a, len(a.ips) > 0
+}
+
+// dnsName returns the name as a domain like the configured ones, i.e. lower case without the trailing dot.
+func dnsName(n dnsmessage.Name) models.Domain {
+	return models.Domain(strings.ToLower(strings.TrimSuffix(n.String(), ".")))
+}
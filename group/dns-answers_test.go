@@ -0,0 +1,102 @@
+package group
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"golang.org/x/net/dns/dnsmessage"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+// dnsAnswerPacket returns an IPv4 UDP packet holding a DNS answer for the question, with a CNAME to cname if it's set
+// and an A record for each of the IPs.
+func dnsAnswerPacket(t *testing.T, rcode dnsmessage.RCode, question, cname string, ips ...string) []byte {
+	t.Helper()
+	b := dnsmessage.NewBuilder(make([]byte, 28, 512), dnsmessage.Header{Response: true, RCode: rcode})
+	require.NoError(t, b.StartQuestions())
+	require.NoError(t, b.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(question), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}))
+	require.NoError(t, b.StartAnswers())
+	name := dnsmessage.MustNewName(question)
+	if cname != "" {
+		target := dnsmessage.MustNewName(cname)
+		require.NoError(t, b.CNAMEResource(dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET}, dnsmessage.CNAMEResource{CNAME: target}))
+		name = target
+	}
+	for _, ip := range ips {
+		var a [4]byte
+		copy(a[:], net.ParseIP(ip).To4())
+		require.NoError(t, b.AResource(dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET}, dnsmessage.AResource{A: a}))
+	}
+	require.NoError(t, b.AAAAResource(dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET}, dnsmessage.AAAAResource{}))
+	packet, err := b.Finish()
+	require.NoError(t, err)
+	packet[0], packet[9] = 0x45, 17 // IPv4 UDP with a 20 byte header, followed by the UDP header.
+	return packet
+}
+
+func TestParseDNSAnswer(t *testing.T) {
+	a, ok := parseDNSAnswer(dnsAnswerPacket(t, dnsmessage.RCodeSuccess, "WWW.YouTube.com.", "youtube-ui.l.google.com.", "142.250.1.1", "142.250.1.2"))
+	require.True(t, ok)
+	assert.Equal(t, []models.Domain{"www.youtube.com", "www.youtube.com", "youtube-ui.l.google.com"}, a.names)
+	assert.Equal(t, []models.Ip{"142.250.1.1", "142.250.1.2"}, a.ips, "expected the AAAA record to be skipped")
+
+	_, ok = parseDNSAnswer(dnsAnswerPacket(t, dnsmessage.RCodeNameError, "missing.youtube.com.", ""))
+	assert.False(t, ok, "expected failed lookups to be skipped")
+	_, ok = parseDNSAnswer(dnsAnswerPacket(t, dnsmessage.RCodeSuccess, "youtube.com.", ""))
+	assert.False(t, ok, "expected answers without IPv4 addresses to be skipped")
+	packet := dnsAnswerPacket(t, dnsmessage.RCodeSuccess, "youtube.com.", "", "142.250.1.1")
+	_, ok = parseDNSAnswer(packet[:len(packet)-20])
+	assert.False(t, ok, "expected a truncated answer to be skipped")
+}
+
+func TestDomainWatcher_LearnDNSAnswers(t *testing.T) {
+	originalLoaderFunc := fnGroupDomainLoader
+	defer func() { fnGroupDomainLoader = originalLoaderFunc }()
+	fnGroupDomainLoader = func(logger *zap.SugaredLogger) (models.MapGroupDomains, error) {
+		return models.MapGroupDomains{"GroupA": {"youtube.com", "googlevideo.com"}, "GroupB": {"games.com"}}, nil
+	}
+
+	dw := NewDomainWatcher(config.MustGetLogger(), nil)
+	dw.cfg = &config.ResolverConfig{IPRetention: time.Hour}
+	dw.resolver = func(logger *zap.SugaredLogger, domains []models.Domain) models.MapIpDomain {
+		return models.MapIpDomain{}
+	}
+	mockReceiver := &MockDestIpDomainReceiver{}
+	dw.RegisterDestIpDomainReceivers(mockReceiver)
+	require.NoError(t, dw.refresh(false))
+	_, err := dw.PauseResolution("GroupB", models.ResolutionClear, time.Hour)
+	require.NoError(t, err)
+
+	var answers []dnsAnswer
+	for _, packet := range [][]byte{
+		dnsAnswerPacket(t, dnsmessage.RCodeSuccess, "rr1---sn-abc.googlevideo.com.", "", "10.0.0.1"),
+		dnsAnswerPacket(t, dnsmessage.RCodeSuccess, "www.youtube.com.", "youtube-ui.l.google.com.", "10.0.0.2"),
+		dnsAnswerPacket(t, dnsmessage.RCodeSuccess, "example.com.", "", "10.0.0.3"),
+		dnsAnswerPacket(t, dnsmessage.RCodeSuccess, "games.com.", "", "10.0.0.4"),
+	} {
+		a, ok := parseDNSAnswer(packet)
+		require.True(t, ok)
+		answers = append(answers, a)
+	}
+	dw.learnDNSAnswers(answers, time.Now())
+	assert.Equal(t, models.MapIpDomain{"10.0.0.1": "googlevideo.com", "10.0.0.2": "youtube.com"}, mockReceiver.updatedIpDomains,
+		"expected the IPs of subdomains to be learned, but not those of other or paused domains")
+	assert.Equal(t, []models.Group{"GroupA"}, dw.destIpGroups.Data["10.0.0.1"])
+	assert.Equal(t, 2, dw.Health().Details.(map[string]any)["learned"])
+
+	// Expect answers that are already known not to be published again.
+	mockReceiver.updatedIpDomains = nil
+	dw.learnDNSAnswers(answers[:1], time.Now())
+	assert.Nil(t, mockReceiver.updatedIpDomains)
+
+	// Expect a full queue to drop answers rather than block the packet handler.
+	for range dnsAnswerQueueLen + 1 {
+		dw.ObserveDNSMessage(nil)
+	}
+	assert.Len(t, dw.dnsAnswers, dnsAnswerQueueLen)
+}
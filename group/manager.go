@@ -2,6 +2,7 @@ package group
 
 import (
 	"fmt"
+	"slices"
 
 	"go.uber.org/zap"
 	"relloyd/tubetimeout/models"
@@ -16,6 +17,8 @@ var (
 
 type ManagerI interface {
 	IsSrcDestIpKnown(srcIp, dstIp models.Ip) ([]models.Group, bool)
+	IsDestAllowed(group models.Group, dstIp models.Ip) bool
+	DomainForIP(dstIp models.Ip) (models.Domain, bool)
 }
 
 type Manager struct {
@@ -24,6 +27,8 @@ type Manager struct {
 	destIpGroups     models.IpGroups
 	destIpDomains    models.IpDomains
 	destDomainGroups models.DomainGroups
+	allowedIpGroups  models.IpGroups
+	sourceIpMACs     models.IpMACs
 }
 
 func NewManager(logger *zap.SugaredLogger) *Manager {
@@ -33,6 +38,8 @@ func NewManager(logger *zap.SugaredLogger) *Manager {
 		destIpGroups:     models.IpGroups{Data: make(models.MapIpGroups)},
 		destIpDomains:    models.IpDomains{Data: make(models.MapIpDomain)},
 		destDomainGroups: models.DomainGroups{Data: make(models.MapDomainGroups)},
+		allowedIpGroups:  models.IpGroups{Data: make(models.MapIpGroups)},
+		sourceIpMACs:     models.IpMACs{Data: make(models.MapIpMACs)},
 	}
 	return m
 }
@@ -67,6 +74,84 @@ func (m *Manager) UpdateDestDomainGroups(newData models.MapDomainGroups) {
 	m.destDomainGroups.Data = newData
 }
 
+// UpdateAllowedIpGroups implements the AllowedIpGroupsReceiver interface.
+func (m *Manager) UpdateAllowedIpGroups(newData models.MapIpGroups) {
+	m.allowedIpGroups.Mu.Lock()
+	defer m.allowedIpGroups.Mu.Unlock()
+	m.allowedIpGroups.Data = newData
+	m.logger.Debugf("Manager callback updated allowed IP groups: %v", newData)
+}
+
+// UpdateSourceIpMACs implements the SourceIpMACReceiver interface.
+func (m *Manager) UpdateSourceIpMACs(newData models.MapIpMACs) {
+	m.sourceIpMACs.Mu.Lock()
+	defer m.sourceIpMACs.Mu.Unlock()
+	m.sourceIpMACs.Data = newData
+}
+
+// GroupsForIP returns the sorted groups of the device with the source IP, or nil if it isn't in any.
+func (m *Manager) GroupsForIP(ip models.Ip) []models.Group {
+	groups, _ := m.isSrcIpGroupKnown(ip)
+	if len(groups) == 0 {
+		return nil
+	}
+	groups = slices.Clone(groups)
+	slices.Sort(groups)
+	return slices.Compact(groups)
+}
+
+// IpsForMAC returns the sorted IPs that the device with the MAC was last seen on.
+func (m *Manager) IpsForMAC(mac models.MAC) []models.Ip {
+	mac = models.MAC(models.NewMAC(string(mac)))
+	m.sourceIpMACs.Mu.RLock()
+	defer m.sourceIpMACs.Mu.RUnlock()
+	var ips []models.Ip
+	for ip, ipMAC := range m.sourceIpMACs.Data {
+		if models.MAC(models.NewMAC(string(ipMAC))) == mac {
+			ips = append(ips, ip)
+		}
+	}
+	slices.Sort(ips)
+	return ips
+}
+
+// GroupsForMAC returns the sorted groups of the device with the MAC, going by the IPs it was last seen on, or nil if
+// it isn't in any.
+func (m *Manager) GroupsForMAC(mac models.MAC) []models.Group {
+	var groups []models.Group
+	for _, ip := range m.IpsForMAC(mac) {
+		groups = append(groups, m.GroupsForIP(ip)...)
+	}
+	slices.Sort(groups)
+	return slices.Compact(groups)
+}
+
+// DomainsForGroup returns the sorted domains that are tracked for the group.
+func (m *Manager) DomainsForGroup(group models.Group) []models.Domain {
+	m.destDomainGroups.Mu.RLock()
+	defer m.destDomainGroups.Mu.RUnlock()
+	var domains []models.Domain
+	for domain, groups := range m.destDomainGroups.Data {
+		if slices.Contains(groups, group) {
+			domains = append(domains, domain)
+		}
+	}
+	slices.Sort(domains)
+	return domains
+}
+
+// IsDestAllowed returns true if the group's allowlist lets it always reach the destination IP.
+func (m *Manager) IsDestAllowed(group models.Group, dstIp models.Ip) bool {
+	m.allowedIpGroups.Mu.RLock()
+	defer m.allowedIpGroups.Mu.RUnlock()
+	return slices.Contains(m.allowedIpGroups.Data[dstIp], group)
+}
+
+// DomainForIP returns the tracked domain that the destination IP was resolved from.
+func (m *Manager) DomainForIP(dstIp models.Ip) (models.Domain, bool) {
+	return m.isDstIpDomainKnown(string(dstIp))
+}
+
 // isSrcIpGroupKnown checks if the source IP is known and returns the groups it belongs to.
 func (m *Manager) isSrcIpGroupKnown(ip models.Ip) ([]models.Group, bool) {
 	m.sourceIpGroups.Mu.RLock()
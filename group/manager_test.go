@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 	"relloyd/tubetimeout/models"
 )
 
@@ -80,3 +81,20 @@ func TestIsSrcIpDestDomainKnown(t *testing.T) {
 		})
 	}
 }
+
+func TestManager_Lookups(t *testing.T) {
+	mgr := NewManager(zap.NewNop().Sugar())
+	mgr.UpdateSourceIpGroups(models.MapIpGroups{"192.168.0.2": {"kids", "family"}, "192.168.0.3": {"kids"}, "192.168.0.4": {"guests"}})
+	mgr.UpdateSourceIpMACs(models.MapIpMACs{"192.168.0.2": "AA-BB-CC-DD-EE-FF", "192.168.0.3": "AA-BB-CC-DD-EE-FF", "192.168.0.4": "11-22-33-44-55-66"})
+	mgr.UpdateDestDomainGroups(models.MapDomainGroups{"youtube.com": {"kids"}, "googlevideo.com": {"kids", "family"}, "tiktok.com": {"family"}})
+
+	assert.Equal(t, []models.Group{"family", "kids"}, mgr.GroupsForIP("192.168.0.2"))
+	assert.Nil(t, mgr.GroupsForIP("192.168.0.9"), "expected no groups for unknown IPs")
+
+	assert.Equal(t, []models.Ip{"192.168.0.2", "192.168.0.3"}, mgr.IpsForMAC("aa:bb:cc:dd:ee:ff"), "expected the MAC to be sanitised")
+	assert.Equal(t, []models.Group{"family", "kids"}, mgr.GroupsForMAC("AA-BB-CC-DD-EE-FF"), "expected the groups of every IP without duplicates")
+	assert.Nil(t, mgr.GroupsForMAC("00-00-00-00-00-00"))
+
+	assert.Equal(t, []models.Domain{"googlevideo.com", "youtube.com"}, mgr.DomainsForGroup("kids"))
+	assert.Nil(t, mgr.DomainsForGroup("guests"))
+}
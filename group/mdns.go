@@ -0,0 +1,248 @@
+package group
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/dns/dnsmessage"
+	"relloyd/tubetimeout/models"
+)
+
+var (
+	mdnsAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+	ssdpAddr = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+	// fnFetchSSDPDescription fetches the UPnP device description referenced by an SSDP announcement.
+	fnFetchSSDPDescription = func(location string) (*http.Response, error) {
+		return ssdpClient.Get(location)
+	}
+	ssdpClient = &http.Client{Timeout: 3 * time.Second}
+)
+
+const (
+	maxDiscoveredNameLen = 64
+	maxDatagramSize      = 9000
+)
+
+// namePriority ranks the sources of a discovered name so that friendlier names replace plain hostnames.
+type namePriority int
+
+const (
+	priorityHostname namePriority = iota // A record, e.g. "livingroom-tv.local"
+	priorityInstance                     // service instance name, e.g. "Living Room TV._airplay._tcp.local"
+	priorityFriendly                     // explicit friendly name, e.g. Chromecast TXT fn= or UPnP friendlyName
+)
+
+type discoveredName struct {
+	name     string
+	priority namePriority
+}
+
+// Discovery listens for mDNS/Bonjour and SSDP announcements and remembers the names devices broadcast
+// about themselves, so that unnamed MACs can be given a sensible default name.
+// It implements SourceIpMACReceiver to map the announcing IPs to MACs.
+type Discovery struct {
+	logger    *zap.SugaredLogger
+	mu        sync.RWMutex
+	ipMACs    models.MapIpMACs
+	names     map[models.Ip]discoveredName
+	locations map[string]string // SSDP location URL: friendly name, to avoid refetching descriptions
+}
+
+// NewDiscovery creates a Discovery; call Start to begin listening.
+func NewDiscovery(logger *zap.SugaredLogger) *Discovery {
+	return &Discovery{
+		logger:    logger,
+		ipMACs:    make(models.MapIpMACs),
+		names:     make(map[models.Ip]discoveredName),
+		locations: make(map[string]string),
+	}
+}
+
+// UpdateSourceIpMACs receives the latest IP to MAC mapping from the NetWatcher.
+func (d *Discovery) UpdateSourceIpMACs(newData models.MapIpMACs) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.ipMACs = newData
+}
+
+// Name returns the best discovered name for the MAC.
+func (d *Discovery) Name(mac string) (string, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var best discoveredName
+	found := false
+	for ip, m := range d.ipMACs { // for each IP the MAC is using...
+		if string(m) != mac {
+			continue
+		}
+		if n, ok := d.names[ip]; ok && (!found || n.priority > best.priority) {
+			best = n
+			found = true
+		}
+	}
+	return best.name, found
+}
+
+// Start listens for mDNS and SSDP announcements until the context is cancelled.
+// Failure to join either multicast group is logged and that protocol is skipped.
+func (d *Discovery) Start(ctx context.Context) {
+	d.listen(ctx, "mDNS", mdnsAddr, d.handleMDNS)
+	d.listen(ctx, "SSDP", ssdpAddr, d.handleSSDP)
+}
+
+func (d *Discovery) listen(ctx context.Context, protocol string, addr *net.UDPAddr, handler func(src net.IP, data []byte)) {
+	conn, err := net.ListenMulticastUDP("udp4", nil, addr)
+	if err != nil {
+		d.logger.Warnf("Device name discovery via %v disabled: %v", protocol, err)
+		return
+	}
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, src, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				if ctx.Err() == nil { // if we weren't cancelled...
+					d.logger.Errorf("Device name discovery via %v stopped: %v", protocol, err)
+				}
+				return
+			}
+			handler(src.IP, buf[:n])
+		}
+	}()
+	d.logger.Infof("Device name discovery via %v started", protocol)
+}
+
+// handleMDNS records names found in mDNS responses.
+func (d *Discovery) handleMDNS(src net.IP, data []byte) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(data); err != nil || !msg.Response { // if this isn't an announcement or response...
+		return
+	}
+
+	srcIp := models.Ip(src.String())
+	for _, r := range append(msg.Answers, msg.Additionals...) {
+		switch body := r.Body.(type) {
+		case *dnsmessage.AResource:
+			ip := models.Ip(net.IP(body.A[:]).String())
+			d.setName(ip, hostnameFromMDNS(r.Header.Name.String()), priorityHostname)
+		case *dnsmessage.PTRResource:
+			d.setName(srcIp, instanceFromMDNS(body.PTR.String()), priorityInstance)
+		case *dnsmessage.TXTResource:
+			for _, txt := range body.TXT {
+				if name, ok := strings.CutPrefix(txt, "fn="); ok { // if this is a Chromecast-style friendly name...
+					d.setName(srcIp, name, priorityFriendly)
+				}
+			}
+		}
+	}
+}
+
+// hostnameFromMDNS converts "livingroom-tv.local." to "livingroom-tv".
+func hostnameFromMDNS(name string) string {
+	name = strings.TrimSuffix(name, ".")
+	name = strings.TrimSuffix(name, ".local")
+	if strings.Contains(name, ".") { // if this isn't a plain .local hostname, e.g. a reverse lookup name...
+		return ""
+	}
+	return name
+}
+
+// instanceFromMDNS converts "Living Room TV._airplay._tcp.local." to "Living Room TV".
+func instanceFromMDNS(name string) string {
+	i := strings.Index(name, "._")
+	if i <= 0 { // if this is a service type rather than an instance, e.g. "_airplay._tcp.local."...
+		return ""
+	}
+	return strings.ReplaceAll(name[:i], `\ `, " ")
+}
+
+// handleSSDP records the UPnP friendlyName of devices sending SSDP NOTIFY announcements.
+func (d *Discovery) handleSSDP(src net.IP, data []byte) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+	if err != nil || req.Method != "NOTIFY" {
+		return
+	}
+	location := req.Header.Get("Location")
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme != "http" || u.Hostname() != src.String() { // if the description isn't served by the announcing device...
+		return
+	}
+
+	d.mu.RLock()
+	name, seen := d.locations[location]
+	d.mu.RUnlock()
+	if !seen {
+		name = d.fetchFriendlyName(location)
+		d.mu.Lock()
+		d.locations[location] = name // remember failures too so we don't keep retrying
+		d.mu.Unlock()
+	}
+	d.setName(models.Ip(src.String()), name, priorityFriendly)
+}
+
+func (d *Discovery) fetchFriendlyName(location string) string {
+	resp, err := fnFetchSSDPDescription(location)
+	if err != nil {
+		d.logger.Debugf("Failed to fetch SSDP description %v: %v", location, err)
+		return ""
+	}
+	defer resp.Body.Close()
+	var desc struct {
+		Device struct {
+			FriendlyName string `xml:"friendlyName"`
+		} `xml:"device"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&desc); err != nil {
+		d.logger.Debugf("Failed to parse SSDP description %v: %v", location, err)
+		return ""
+	}
+	return desc.Device.FriendlyName
+}
+
+// setName saves the name for the IP unless a name with higher priority is already known.
+func (d *Discovery) setName(ip models.Ip, name string, priority namePriority) {
+	name = sanitiseDiscoveredName(name)
+	if name == "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if cur, ok := d.names[ip]; ok && cur.priority > priority {
+		return
+	}
+	if cur := d.names[ip]; cur.name != name {
+		d.logger.Debugf("Discovered device name %q for IP %v", name, ip)
+	}
+	d.names[ip] = discoveredName{name: name, priority: priority}
+}
+
+// sanitiseDiscoveredName strips control characters and limits the length of names received from the network.
+func sanitiseDiscoveredName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+	if r := []rune(name); len(r) > maxDiscoveredNameLen {
+		name = string(r[:maxDiscoveredNameLen])
+	}
+	return name
+}
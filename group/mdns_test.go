@@ -0,0 +1,107 @@
+package group
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+func packMDNSResponse(t *testing.T, resources ...dnsmessage.Resource) []byte {
+	t.Helper()
+	msg := dnsmessage.Message{
+		Header:  dnsmessage.Header{Response: true, Authoritative: true},
+		Answers: resources,
+	}
+	data, err := msg.Pack()
+	assert.NoError(t, err, "failed to pack mDNS message")
+	return data
+}
+
+func TestDiscovery_HandleMDNS(t *testing.T) {
+	d := NewDiscovery(config.MustGetLogger())
+	d.UpdateSourceIpMACs(models.MapIpMACs{
+		"192.168.1.10": "00-11-22-33-44-55",
+		"192.168.1.11": "66-77-88-99-AA-BB",
+	})
+
+	// Host announces its hostname.
+	d.handleMDNS(net.ParseIP("192.168.1.10"), packMDNSResponse(t, dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("livingroom-tv.local."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+		Body:   &dnsmessage.AResource{A: [4]byte{192, 168, 1, 10}},
+	}))
+	name, ok := d.Name("00-11-22-33-44-55")
+	assert.True(t, ok)
+	assert.Equal(t, "livingroom-tv", name)
+
+	// Service instance names are friendlier than hostnames.
+	d.handleMDNS(net.ParseIP("192.168.1.10"), packMDNSResponse(t, dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("_airplay._tcp.local."), Type: dnsmessage.TypePTR, Class: dnsmessage.ClassINET},
+		Body:   &dnsmessage.PTRResource{PTR: dnsmessage.MustNewName("Living Room TV._airplay._tcp.local.")},
+	}))
+	name, _ = d.Name("00-11-22-33-44-55")
+	assert.Equal(t, "Living Room TV", name)
+
+	// A later hostname doesn't replace the instance name.
+	d.handleMDNS(net.ParseIP("192.168.1.10"), packMDNSResponse(t, dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("android-1234.local."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET},
+		Body:   &dnsmessage.AResource{A: [4]byte{192, 168, 1, 10}},
+	}))
+	name, _ = d.Name("00-11-22-33-44-55")
+	assert.Equal(t, "Living Room TV", name)
+
+	// Chromecast friendly names are used from TXT records.
+	d.handleMDNS(net.ParseIP("192.168.1.11"), packMDNSResponse(t, dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("Chromecast-abc123._googlecast._tcp.local."), Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET},
+		Body:   &dnsmessage.TXTResource{TXT: []string{"id=abc123", "fn=Kitchen speaker"}},
+	}))
+	name, _ = d.Name("66-77-88-99-AA-BB")
+	assert.Equal(t, "Kitchen speaker", name)
+
+	// Unknown MACs have no name.
+	_, ok = d.Name("CC-DD-EE-FF-00-11")
+	assert.False(t, ok)
+}
+
+func TestDiscovery_HandleSSDP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<?xml version="1.0"?><root><device><friendlyName>Bedroom TV</friendlyName></device></root>`))
+	}))
+	defer srv.Close()
+	host, _, _ := net.SplitHostPort(strings.TrimPrefix(srv.URL, "http://"))
+
+	fetches := 0
+	orig := fnFetchSSDPDescription
+	fnFetchSSDPDescription = func(location string) (*http.Response, error) {
+		fetches++
+		return orig(location)
+	}
+	defer func() { fnFetchSSDPDescription = orig }()
+
+	d := NewDiscovery(config.MustGetLogger())
+	d.UpdateSourceIpMACs(models.MapIpMACs{models.Ip(host): "00-11-22-33-44-55"})
+
+	notify := "NOTIFY * HTTP/1.1\r\nHost: 239.255.255.250:1900\r\nLocation: " + srv.URL + "/desc.xml\r\nNTS: ssdp:alive\r\n\r\n"
+	d.handleSSDP(net.ParseIP(host), []byte(notify))
+	d.handleSSDP(net.ParseIP(host), []byte(notify))
+
+	name, ok := d.Name("00-11-22-33-44-55")
+	assert.True(t, ok)
+	assert.Equal(t, "Bedroom TV", name)
+	assert.Equal(t, 1, fetches, "expected the description to be fetched once")
+
+	// Descriptions hosted elsewhere are ignored.
+	d.handleSSDP(net.ParseIP("192.168.1.99"), []byte(notify))
+	assert.Equal(t, 1, fetches, "expected a description served by another host to be ignored")
+}
+
+func TestSanitiseDiscoveredName(t *testing.T) {
+	assert.Equal(t, "TV", sanitiseDiscoveredName(" T\x00V\n"))
+	assert.Len(t, []rune(sanitiseDiscoveredName(strings.Repeat("é", 100))), maxDiscoveredNameLen)
+}
@@ -0,0 +1,110 @@
+package group
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	maxProbeAddrs = 4096                 // maxProbeAddrs caps the range probed so a misconfigured range can't flood the network.
+	probeGap      = 5 * time.Millisecond // probeGap spaces out the probes so they don't arrive as a burst.
+	probePort     = 9                    // probePort is the discard port, which devices ignore.
+)
+
+var fnProbeIP = probeIP
+
+// ProbeRange returns the first and last IPv4 addresses to probe, e.g. the DHCP range.
+type ProbeRange func() (lower, upper net.IP)
+
+// Prober keeps idle devices in the kernel's neighbor table, and so in the ARP scans, by periodically sending every
+// address in a range a UDP datagram. The kernel sends an ARP request to deliver each one and devices that are up
+// reply, even those that have gone quiet, so they're still tracked when they start streaming again.
+type Prober struct {
+	logger    *zap.SugaredLogger
+	probeFrom ProbeRange
+}
+
+// NewProber creates a Prober for the range returned by probeFrom; call Start to begin probing.
+func NewProber(logger *zap.SugaredLogger, probeFrom ProbeRange) *Prober {
+	return &Prober{logger: logger, probeFrom: probeFrom}
+}
+
+// Start probes the range immediately and then every interval until ctx is done. Zero or less disables it.
+func (p *Prober) Start(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		p.probe(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.probe(ctx)
+			}
+		}
+	}()
+}
+
+// probe sends a probe to every address in the range.
+func (p *Prober) probe(ctx context.Context) {
+	lower, upper := p.probeFrom()
+	ips := probeAddrs(lower, upper)
+	if len(ips) == 0 {
+		p.logger.Debugf("Skipping device probes, no valid range to probe: %v-%v", lower, upper)
+		return
+	}
+
+	failed := 0
+	for _, ip := range ips {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(probeGap):
+		}
+		if err := fnProbeIP(ip); err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		p.logger.Warnf("Failed to probe %v of %v addresses in %v-%v", failed, len(ips), lower, upper)
+	}
+}
+
+// probeAddrs returns the IPv4 addresses from lower to upper inclusive, or nil if the range is invalid or larger than
+// maxProbeAddrs.
+func probeAddrs(lower, upper net.IP) []net.IP {
+	lower, upper = lower.To4(), upper.To4()
+	if lower == nil || upper == nil {
+		return nil
+	}
+	from, to := binary.BigEndian.Uint32(lower), binary.BigEndian.Uint32(upper)
+	if from > to || to-from >= maxProbeAddrs {
+		return nil
+	}
+	ips := make([]net.IP, 0, to-from+1)
+	for n := from; n <= to; n++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, n)
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+// probeIP sends the IP an empty UDP datagram so that the kernel resolves its MAC with an ARP request.
+func probeIP(ip net.IP) error {
+	conn, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: ip, Port: probePort})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(nil)
+	return err
+}
@@ -0,0 +1,38 @@
+package group
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+)
+
+func TestProbeAddrs(t *testing.T) {
+	ips := probeAddrs(net.ParseIP("192.168.1.254"), net.ParseIP("192.168.2.1"))
+	assert.Equal(t, []net.IP{{192, 168, 1, 254}, {192, 168, 1, 255}, {192, 168, 2, 0}, {192, 168, 2, 1}}, ips)
+
+	assert.Nil(t, probeAddrs(nil, net.ParseIP("192.168.1.10")), "expected a missing bound to be rejected")
+	assert.Nil(t, probeAddrs(net.ParseIP("192.168.1.10"), net.ParseIP("192.168.1.1")), "expected a reversed range to be rejected")
+	assert.Nil(t, probeAddrs(net.ParseIP("10.0.0.0"), net.ParseIP("10.255.255.255")), "expected a range larger than maxProbeAddrs to be rejected")
+}
+
+func TestProber_Probe(t *testing.T) {
+	original := fnProbeIP
+	t.Cleanup(func() { fnProbeIP = original })
+	var probed []string
+	fnProbeIP = func(ip net.IP) error {
+		probed = append(probed, ip.String())
+		return nil
+	}
+
+	p := NewProber(config.MustGetLogger(), func() (net.IP, net.IP) { return net.ParseIP("192.168.1.3"), net.ParseIP("192.168.1.5") })
+	p.probe(context.Background())
+	assert.Equal(t, []string{"192.168.1.3", "192.168.1.4", "192.168.1.5"}, probed)
+
+	probed = nil
+	p = NewProber(config.MustGetLogger(), func() (net.IP, net.IP) { return nil, nil })
+	p.probe(context.Background())
+	assert.Empty(t, probed, "expected nothing to be probed until the DHCP range is known")
+}
@@ -0,0 +1,122 @@
+package group
+
+import (
+	"sync"
+	"time"
+
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+const (
+	cacheBackoffAfter = 2              // cacheBackoffAfter is the number of consecutive failures after which a domain is backed off.
+	cacheIdle         = 24 * time.Hour // cacheIdle is how long an entry is kept after it's used, e.g. for domains no longer tracked.
+)
+
+// cacheEntry is the last answer for a domain, or its failures.
+type cacheEntry struct {
+	ips      []models.Ip
+	expires  time.Time // expires is when the IPs should be looked up again.
+	failures int       // failures counts consecutive failed lookups.
+	retryAt  time.Time // retryAt is when a failing domain is next looked up.
+	checked  time.Time // checked is when the domain was last looked up.
+}
+
+// idleSince returns when the entry was last of use.
+func (e *cacheEntry) idleSince() time.Time {
+	latest := e.checked
+	for _, t := range []time.Time{e.expires, e.retryAt} {
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	return latest
+}
+
+// resolveCache keeps the answers for domains for as long as their TTL allows, within the configured bounds, and backs
+// off domains that keep failing to resolve, so that a large domain list doesn't query the resolvers on every refresh.
+type resolveCache struct {
+	cfg     *config.ResolverConfig
+	mu      sync.Mutex
+	entries map[models.Domain]*cacheEntry
+}
+
+func newResolveCache(cfg *config.ResolverConfig) *resolveCache {
+	return &resolveCache{cfg: cfg, entries: make(map[models.Domain]*cacheEntry)}
+}
+
+// get returns the cached IPs of the domain and true if it shouldn't be looked up yet, either because its answer is
+// still fresh or because it's backing off, in which case there are no IPs.
+func (c *resolveCache) get(domain models.Domain, now time.Time) ([]models.Ip, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[domain]
+	switch {
+	case !ok:
+		return nil, false
+	case e.failures >= cacheBackoffAfter && now.Before(e.retryAt):
+		return nil, true
+	case e.failures == 0 && now.Before(e.expires):
+		return e.ips, true
+	}
+	return nil, false
+}
+
+// record saves the outcome of looking up the domain, returning the backoff if it has now failed repeatedly.
+func (c *resolveCache) record(domain models.Domain, ips []models.Ip, ttl time.Duration, err error, now time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[domain]
+	if !ok {
+		e = &cacheEntry{}
+		c.entries[domain] = e
+	}
+	e.checked = now
+	if err == nil {
+		e.ips, e.failures, e.retryAt = ips, 0, time.Time{}
+		e.expires = now
+		if c.cfg.CacheMaxTTL > 0 {
+			e.expires = now.Add(min(max(ttl, c.cfg.CacheMinTTL), c.cfg.CacheMaxTTL))
+		}
+		return 0
+	}
+	e.ips = nil
+	e.failures++
+	if e.failures < cacheBackoffAfter || c.cfg.FailureBackoff <= 0 {
+		return 0
+	}
+	backoff := c.cfg.FailureBackoff
+	for i := cacheBackoffAfter; i < e.failures && backoff < c.cfg.MaxFailureBackoff; i++ {
+		backoff *= 2
+	}
+	if c.cfg.MaxFailureBackoff > 0 {
+		backoff = min(backoff, c.cfg.MaxFailureBackoff)
+	}
+	e.retryAt = now.Add(backoff)
+	return backoff
+}
+
+// prune drops the entries that have been idle for cacheIdle.
+func (c *resolveCache) prune(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for domain, e := range c.entries {
+		if now.Sub(e.idleSince()) > cacheIdle {
+			delete(c.entries, domain)
+		}
+	}
+}
+
+// stats returns the number of domains with a fresh answer and the number backing off.
+func (c *resolveCache) stats(now time.Time) (cached, backingOff int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range c.entries {
+		if e.failures >= cacheBackoffAfter && now.Before(e.retryAt) {
+			backingOff++
+		} else if e.failures == 0 && now.Before(e.expires) {
+			cached++
+		}
+	}
+	return cached, backingOff
+}
@@ -0,0 +1,93 @@
+package group
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+func testResolverConfig() *config.ResolverConfig {
+	return &config.ResolverConfig{Timeout: time.Second, Workers: 4, CacheMinTTL: 30 * time.Second, CacheMaxTTL: time.Hour,
+		FailureBackoff: 5 * time.Minute, MaxFailureBackoff: time.Hour}
+}
+
+func TestResolveCache_TTL(t *testing.T) {
+	c := newResolveCache(testResolverConfig())
+	now := time.Now()
+	_, ok := c.get("example.com", now)
+	assert.False(t, ok, "expected an unknown domain to be looked up")
+
+	c.record("example.com", []models.Ip{"1.2.3.4"}, 5*time.Minute, nil, now)
+	ips, ok := c.get("example.com", now.Add(4*time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, []models.Ip{"1.2.3.4"}, ips)
+	_, ok = c.get("example.com", now.Add(5*time.Minute))
+	assert.False(t, ok, "expected the answer to expire with its TTL")
+
+	c.record("short.example.com", []models.Ip{"1.2.3.4"}, time.Second, nil, now)
+	_, ok = c.get("short.example.com", now.Add(20*time.Second))
+	assert.True(t, ok, "expected a short TTL to be raised to the minimum")
+	c.record("long.example.com", []models.Ip{"1.2.3.4"}, 48*time.Hour, nil, now)
+	_, ok = c.get("long.example.com", now.Add(61*time.Minute))
+	assert.False(t, ok, "expected a long TTL to be capped at the maximum")
+
+	cached, backingOff := c.stats(now)
+	assert.Equal(t, 3, cached)
+	assert.Equal(t, 0, backingOff)
+
+	c.cfg.CacheMaxTTL = 0
+	c.record("example.com", []models.Ip{"1.2.3.4"}, 5*time.Minute, nil, now)
+	_, ok = c.get("example.com", now)
+	assert.False(t, ok, "expected no caching with a max TTL of 0")
+}
+
+func TestResolveCache_Backoff(t *testing.T) {
+	c := newResolveCache(testResolverConfig())
+	now := time.Now()
+	errFailed := errors.New("refused")
+
+	assert.Equal(t, time.Duration(0), c.record("bad.example.com", nil, 0, errFailed, now), "expected no backoff after one failure")
+	_, ok := c.get("bad.example.com", now)
+	assert.False(t, ok)
+
+	for i, want := range []time.Duration{5 * time.Minute, 10 * time.Minute, 20 * time.Minute, 40 * time.Minute, time.Hour, time.Hour} {
+		assert.Equal(t, want, c.record("bad.example.com", nil, 0, errFailed, now), "failure %v", i+2)
+	}
+	ips, ok := c.get("bad.example.com", now.Add(59*time.Minute))
+	assert.True(t, ok, "expected the domain to be backing off")
+	assert.Empty(t, ips)
+	_, backingOff := c.stats(now)
+	assert.Equal(t, 1, backingOff)
+	_, ok = c.get("bad.example.com", now.Add(time.Hour))
+	assert.False(t, ok, "expected the domain to be retried after the backoff")
+
+	c.record("bad.example.com", []models.Ip{"1.2.3.4"}, time.Minute, nil, now)
+	assert.Equal(t, time.Duration(0), c.record("bad.example.com", nil, 0, errFailed, now.Add(time.Minute)), "expected a success to reset the failures")
+
+	c.prune(now.Add(cacheIdle + 2*time.Hour))
+	assert.Empty(t, c.entries, "expected idle entries to be dropped")
+}
+
+func TestResolverPool_ResolveDomains(t *testing.T) {
+	up := &fakeUpstream{ips: []models.Ip{"1.2.3.4"}, ttl: 10 * time.Minute}
+	p := newTestPool(testResolverConfig(), up)
+
+	m := p.resolveDomains(config.MustGetLogger(), []models.Domain{"a.example.com", "b.example.com"})
+	assert.Len(t, m, 1)
+	assert.Equal(t, 2, up.calls)
+	m = p.resolveDomains(config.MustGetLogger(), []models.Domain{"a.example.com", "b.example.com"})
+	assert.Len(t, m, 1, "expected the cached IPs to be returned")
+	assert.Equal(t, 2, up.calls, "expected the cached domains not to be looked up again")
+
+	up.err = errors.New("refused")
+	for range 2 {
+		p.resolveDomains(config.MustGetLogger(), []models.Domain{"c.example.com"})
+	}
+	m = p.resolveDomains(config.MustGetLogger(), []models.Domain{"c.example.com"})
+	assert.Empty(t, m)
+	assert.Equal(t, 4, up.calls, "expected the failing domain to back off after two failures")
+}
@@ -0,0 +1,406 @@
+package group
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/dns/dnsmessage"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+var errTruncated = errors.New("DNS answer is truncated")
+
+const (
+	defaultUpstream     = "8.8.8.8"
+	upstreamMaxFailures = 3           // upstreamMaxFailures is the number of consecutive failures after which a resolver is tried last.
+	upstreamRetryAfter  = time.Minute // upstreamRetryAfter is how long a failing resolver stays at the back of the queue.
+)
+
+// lookupFunc resolves domain, returning its IPs and the lowest TTL of the records in the answer, or 0 if unknown.
+type lookupFunc func(ctx context.Context, domain models.Domain) ([]models.Ip, time.Duration, error)
+
+// upstream is a single DNS resolver in the pool. Its health fields are guarded by the pool's mu.
+type upstream struct {
+	server      string
+	lookup      lookupFunc
+	failures    int // failures counts consecutive failed lookups.
+	lastErr     error
+	lastSuccess time.Time
+	retryAt     time.Time // retryAt is when a failing resolver is next tried in its configured position.
+}
+
+// resolverPool resolves domains using the configured upstream resolvers. Resolvers are tried in order, failing over to
+// the next when one times out or errors, or are all queried at once if cfg.Parallel is set.
+// Resolvers that keep failing are moved to the back until upstreamRetryAfter has passed, so that a resolver blocked
+// by the ISP doesn't slow down every lookup. Answers are cached by resolveDomains for their TTL.
+type resolverPool struct {
+	logger    *zap.SugaredLogger
+	cfg       *config.ResolverConfig
+	cache     *resolveCache
+	mu        sync.Mutex
+	upstreams []*upstream
+}
+
+func newResolverPool(logger *zap.SugaredLogger, cfg *config.ResolverConfig) *resolverPool {
+	p := &resolverPool{logger: logger, cfg: cfg, cache: newResolveCache(cfg)}
+	for _, server := range cfg.Servers {
+		u, err := newUpstream(strings.TrimSpace(server))
+		if err != nil {
+			logger.Warnf("Ignoring DNS resolver %q: %v", server, err)
+			continue
+		}
+		p.upstreams = append(p.upstreams, u)
+	}
+	if len(p.upstreams) == 0 {
+		logger.Warnf("No valid DNS resolvers are configured, using %v", defaultUpstream)
+		u, _ := newUpstream(defaultUpstream)
+		p.upstreams = append(p.upstreams, u)
+	}
+	return p
+}
+
+// newUpstream returns a resolver for server, which is a plain address for UDP, tls://host[:port] for DNS over TLS or
+// an https:// URL for DNS over HTTPS.
+func newUpstream(server string) (*upstream, error) {
+	switch {
+	case strings.HasPrefix(server, "https://"):
+		u, err := url.Parse(server)
+		if err != nil || u.Host == "" {
+			return nil, errors.New("invalid DNS over HTTPS URL")
+		}
+		return &upstream{server: server, lookup: dohLookup(&http.Client{}, server)}, nil
+	case strings.HasPrefix(server, "tls://"):
+		addr, host, err := hostPort(strings.TrimPrefix(server, "tls://"), "853")
+		if err != nil {
+			return nil, err
+		}
+		return &upstream{server: server, lookup: exchangeLookup(server, func(ctx context.Context, _ string) (net.Conn, error) {
+			d := tls.Dialer{Config: &tls.Config{ServerName: host}}
+			return d.DialContext(ctx, "tcp", addr) // TCP framing is used for conns that aren't PacketConns.
+		})}, nil
+	default:
+		addr, _, err := hostPort(strings.TrimPrefix(server, "udp://"), "53")
+		if err != nil {
+			return nil, err
+		}
+		return &upstream{server: server, lookup: exchangeLookup(server, func(ctx context.Context, network string) (net.Conn, error) {
+			d := net.Dialer{}
+			return d.DialContext(ctx, network, addr) // network is udp, or tcp to retry truncated answers.
+		})}, nil
+	}
+}
+
+// hostPort returns s as an address including defaultPort if s has no port, and the host part of s.
+func hostPort(s, defaultPort string) (addr, host string, err error) {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil { // if there's no port...
+		host, port = strings.Trim(s, "[]"), defaultPort
+	}
+	if host == "" {
+		return "", "", errors.New("missing host")
+	}
+	return net.JoinHostPort(host, port), host, nil
+}
+
+// exchangeLookup returns a lookup that sends A queries over the connections made by dial, retrying over TCP if the
+// answer is truncated. The Go resolver isn't used since it doesn't return the TTLs of the records.
+func exchangeLookup(server string, dial func(ctx context.Context, network string) (net.Conn, error)) lookupFunc {
+	return func(ctx context.Context, domain models.Domain) ([]models.Ip, time.Duration, error) {
+		query, err := packQuery(domain, uint16(rand.Uint32()))
+		if err != nil {
+			return nil, 0, err
+		}
+		data, err := exchange(ctx, dial, "udp", query)
+		if err != nil {
+			return nil, 0, err
+		}
+		ips, ttl, err := parseAnswer(data, domain, server)
+		if errors.Is(err, errTruncated) {
+			if data, err = exchange(ctx, dial, "tcp", query); err != nil {
+				return nil, 0, err
+			}
+			ips, ttl, err = parseAnswer(data, domain, server)
+		}
+		return ips, ttl, err
+	}
+}
+
+// exchange sends the packed query over a connection made by dial and returns the packed answer. Streams like TCP and
+// TLS prefix the messages with their length, while answers over UDP whose ID doesn't match the query are ignored.
+func exchange(ctx context.Context, dial func(ctx context.Context, network string) (net.Conn, error), network string, query []byte) ([]byte, error) {
+	conn, err := dial(ctx, network)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Unix(1, 0)) }) // unblock reads if cancelled.
+	defer stop()
+
+	if _, ok := conn.(net.PacketConn); ok {
+		if _, err = conn.Write(query); err != nil {
+			return nil, fmt.Errorf("failed to send DNS query: %w", err)
+		}
+		buf := make([]byte, 65535)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read DNS answer: %w", err)
+			}
+			if n >= 2 && buf[0] == query[0] && buf[1] == query[1] {
+				return buf[:n], nil
+			}
+		}
+	}
+	if _, err = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); err != nil {
+		return nil, fmt.Errorf("failed to send DNS query: %w", err)
+	}
+	var length [2]byte
+	if _, err = io.ReadFull(conn, length[:]); err != nil {
+		return nil, fmt.Errorf("failed to read DNS answer: %w", err)
+	}
+	data := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err = io.ReadFull(conn, data); err != nil {
+		return nil, fmt.Errorf("failed to read DNS answer: %w", err)
+	}
+	return data, nil
+}
+
+// packQuery returns the A query for domain with the id.
+func packQuery(domain models.Domain, id uint16) ([]byte, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(string(domain), ".") + ".")
+	if err != nil {
+		return nil, fmt.Errorf("invalid domain %v: %w", domain, err)
+	}
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack DNS query: %w", err)
+	}
+	return packed, nil
+}
+
+// parseAnswer returns the IPv4 addresses in the packed answer from server and the lowest TTL of its records.
+func parseAnswer(data []byte, domain models.Domain, server string) ([]models.Ip, time.Duration, error) {
+	var answer dnsmessage.Message
+	if err := answer.Unpack(data); err != nil {
+		return nil, 0, fmt.Errorf("failed to unpack DNS response: %w", err)
+	}
+	if answer.Truncated {
+		return nil, 0, errTruncated
+	}
+	switch answer.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return nil, 0, &net.DNSError{Err: "no such host", Name: string(domain), Server: server, IsNotFound: true}
+	default:
+		return nil, 0, fmt.Errorf("DNS server returned %v", answer.RCode)
+	}
+	var result []models.Ip
+	var ttl uint32
+	for i, a := range answer.Answers {
+		if r, ok := a.Body.(*dnsmessage.AResource); ok {
+			result = append(result, models.Ip(net.IP(r.A[:]).String()))
+		}
+		if i == 0 || a.Header.TTL < ttl { // take the lowest TTL along any CNAMEs too.
+			ttl = a.Header.TTL
+		}
+	}
+	if len(result) == 0 {
+		return nil, 0, &net.DNSError{Err: "no such host", Name: string(domain), Server: server, IsNotFound: true}
+	}
+	return result, time.Duration(ttl) * time.Second, nil
+}
+
+// dohLookup returns a lookup that POSTs A queries to the DNS over HTTPS endpoint as described in RFC 8484.
+func dohLookup(client *http.Client, endpoint string) lookupFunc {
+	return func(ctx context.Context, domain models.Domain) ([]models.Ip, time.Duration, error) {
+		packed, err := packQuery(domain, 0) // the ID is 0 so that responses can be cached.
+		if err != nil {
+			return nil, 0, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(packed))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to create DNS over HTTPS request: %w", err)
+		}
+		req.Header.Set("Content-Type", "application/dns-message")
+		req.Header.Set("Accept", "application/dns-message")
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, 0, fmt.Errorf("DNS over HTTPS request failed: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, 0, fmt.Errorf("DNS over HTTPS returned status %v", resp.StatusCode)
+		}
+		data, err := io.ReadAll(io.LimitReader(resp.Body, 65535))
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read DNS over HTTPS response: %w", err)
+		}
+		return parseAnswer(data, domain, endpoint)
+	}
+}
+
+// isNotFound returns true if err is an answer that the domain doesn't exist, which other resolvers would agree with.
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// resolveDomains implements the resolver type using the pool, with at most cfg.Workers lookups at once.
+func (p *resolverPool) resolveDomains(logger *zap.SugaredLogger, domains []models.Domain) models.MapIpDomain {
+	p.cache.prune(time.Now())
+	return resolveDomainsConcurrently(logger, domains, p.cfg.Workers, p.cachedLookup)
+}
+
+// cachedLookup returns the cached IPs of domain, or nothing if it's backing off after failing repeatedly, else looks
+// it up and caches the answer for its TTL.
+func (p *resolverPool) cachedLookup(domain models.Domain) ([]models.Ip, error) {
+	if ips, ok := p.cache.get(domain, time.Now()); ok {
+		return ips, nil
+	}
+	ips, ttl, err := p.lookup(domain)
+	if backoff := p.cache.record(domain, ips, ttl, err, time.Now()); backoff > 0 {
+		err = fmt.Errorf("%w (retrying in %v)", err, backoff)
+	}
+	return ips, err
+}
+
+// cacheStats returns the number of domains with cached answers and the number backing off.
+func (p *resolverPool) cacheStats() (cached, backingOff int) {
+	return p.cache.stats(time.Now())
+}
+
+// lookup resolves domain with the first resolver that answers.
+func (p *resolverPool) lookup(domain models.Domain) ([]models.Ip, time.Duration, error) {
+	healthy, failing := p.candidates(time.Now())
+	if p.cfg.Parallel {
+		if len(healthy) == 0 {
+			healthy = failing
+		}
+		return p.lookupParallel(domain, healthy)
+	}
+
+	var errs []error
+	for _, u := range append(healthy, failing...) {
+		ips, ttl, err := p.lookupWith(context.Background(), u, domain)
+		if err == nil || isNotFound(err) {
+			return ips, ttl, err
+		}
+		errs = append(errs, fmt.Errorf("%v: %w", u.server, err))
+	}
+	return nil, 0, fmt.Errorf("failed to resolve %s: %w", domain, errors.Join(errs...))
+}
+
+// lookupParallel queries all upstreams at once and returns the first answer.
+func (p *resolverPool) lookupParallel(domain models.Domain, upstreams []*upstream) ([]models.Ip, time.Duration, error) {
+	type result struct {
+		server string
+		ips    []models.Ip
+		ttl    time.Duration
+		err    error
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // stop the slower lookups once we have an answer.
+	results := make(chan result, len(upstreams))
+	for _, u := range upstreams {
+		go func() {
+			ips, ttl, err := p.lookupWith(ctx, u, domain)
+			results <- result{server: u.server, ips: ips, ttl: ttl, err: err}
+		}()
+	}
+
+	var errs []error
+	for range upstreams {
+		r := <-results
+		if r.err == nil || isNotFound(r.err) {
+			return r.ips, r.ttl, r.err
+		}
+		errs = append(errs, fmt.Errorf("%v: %w", r.server, r.err))
+	}
+	return nil, 0, fmt.Errorf("failed to resolve %s: %w", domain, errors.Join(errs...))
+}
+
+// lookupWith resolves domain with u and records the outcome in its health.
+func (p *resolverPool) lookupWith(ctx context.Context, u *upstream, domain models.Domain) ([]models.Ip, time.Duration, error) {
+	lookupCtx, cancel := context.WithTimeout(ctx, p.cfg.Timeout)
+	defer cancel()
+	ips, ttl, err := u.lookup(lookupCtx, domain)
+	if ctx.Err() == nil { // if we didn't give up on the lookup because another resolver answered first...
+		p.record(u, err, time.Now())
+	}
+	return ips, ttl, err
+}
+
+// candidates returns the upstreams in their configured order, split by whether they should be tried first.
+func (p *resolverPool) candidates(now time.Time) (healthy, failing []*upstream) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, u := range p.upstreams {
+		if u.failures >= upstreamMaxFailures && now.Before(u.retryAt) {
+			failing = append(failing, u)
+		} else {
+			healthy = append(healthy, u)
+		}
+	}
+	return healthy, failing
+}
+
+func (p *resolverPool) record(u *upstream, err error, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil || isNotFound(err) {
+		if u.failures >= upstreamMaxFailures {
+			p.logger.Infof("DNS resolver %v has recovered", u.server)
+		}
+		u.failures, u.lastErr, u.lastSuccess = 0, nil, now
+		return
+	}
+	u.failures++
+	u.lastErr = err
+	if u.failures >= upstreamMaxFailures {
+		if u.failures == upstreamMaxFailures {
+			p.logger.Warnf("DNS resolver %v is failing, trying it last for %v: %v", u.server, upstreamRetryAfter, err)
+		}
+		u.retryAt = now.Add(upstreamRetryAfter)
+	}
+}
+
+// health returns the status of each upstream in order.
+func (p *resolverPool) health() []models.ResolverHealth {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var h []models.ResolverHealth
+	for _, u := range p.upstreams {
+		rh := models.ResolverHealth{
+			Server:      u.server,
+			Healthy:     u.failures < upstreamMaxFailures,
+			Failures:    u.failures,
+			LastSuccess: u.lastSuccess,
+		}
+		if u.lastErr != nil {
+			rh.LastError = u.lastErr.Error()
+		}
+		h = append(h, rh)
+	}
+	return h
+}
@@ -0,0 +1,292 @@
+package group
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+// fakeUpstream answers lookups with ips or err after delay and records the calls made.
+type fakeUpstream struct {
+	mu    sync.Mutex
+	ips   []models.Ip
+	ttl   time.Duration
+	err   error
+	delay time.Duration
+	calls int
+}
+
+func (f *fakeUpstream) lookup(ctx context.Context, _ models.Domain) ([]models.Ip, time.Duration, error) {
+	f.mu.Lock()
+	f.calls++
+	ips, ttl, err, delay := f.ips, f.ttl, f.err, f.delay
+	f.mu.Unlock()
+	select {
+	case <-time.After(delay):
+		return ips, ttl, err
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+}
+
+func newTestPool(cfg *config.ResolverConfig, fakes ...*fakeUpstream) *resolverPool {
+	p := &resolverPool{logger: config.MustGetLogger(), cfg: cfg, cache: newResolveCache(cfg)}
+	for i, f := range fakes {
+		p.upstreams = append(p.upstreams, &upstream{server: string(rune('a' + i)), lookup: f.lookup})
+	}
+	return p
+}
+
+func TestResolverPool_Failover(t *testing.T) {
+	blocked := &fakeUpstream{err: errors.New("i/o timeout")}
+	backup := &fakeUpstream{ips: []models.Ip{"1.2.3.4"}}
+	p := newTestPool(&config.ResolverConfig{Timeout: time.Second}, blocked, backup)
+
+	for i := 0; i < upstreamMaxFailures; i++ {
+		ips, _, err := p.lookup("example.com")
+		assert.NoError(t, err)
+		assert.Equal(t, []models.Ip{"1.2.3.4"}, ips)
+	}
+	assert.Equal(t, upstreamMaxFailures, blocked.calls)
+	h := p.health()
+	assert.False(t, h[0].Healthy, "expected the blocked resolver to be reported as failing")
+	assert.Equal(t, "i/o timeout", h[0].LastError)
+	assert.True(t, h[1].Healthy)
+
+	_, _, err := p.lookup("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, upstreamMaxFailures, blocked.calls, "expected the failing resolver to be skipped while the backup answers")
+
+	// Retry the failing resolver first once it's due and see it recover.
+	p.upstreams[0].retryAt = time.Now().Add(-time.Second)
+	blocked.err, blocked.ips = nil, []models.Ip{"5.6.7.8"}
+	ips, _, err := p.lookup("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Ip{"5.6.7.8"}, ips)
+	assert.True(t, p.health()[0].Healthy)
+
+	// All resolvers failing.
+	blocked.err, backup.err = errors.New("refused"), errors.New("refused")
+	_, _, err = p.lookup("example.com")
+	assert.ErrorContains(t, err, "a: refused")
+	assert.ErrorContains(t, err, "b: refused")
+}
+
+func TestResolverPool_NotFoundDoesNotFailOver(t *testing.T) {
+	first := &fakeUpstream{err: &net.DNSError{Err: "no such host", IsNotFound: true}}
+	second := &fakeUpstream{ips: []models.Ip{"1.2.3.4"}}
+	p := newTestPool(&config.ResolverConfig{Timeout: time.Second}, first, second)
+
+	_, _, err := p.lookup("missing.example.com")
+	assert.True(t, isNotFound(err))
+	assert.Equal(t, 0, second.calls)
+	assert.True(t, p.health()[0].Healthy, "expected a not found answer to count as a success")
+}
+
+func TestResolverPool_Parallel(t *testing.T) {
+	slow := &fakeUpstream{ips: []models.Ip{"1.1.1.1"}, delay: time.Second}
+	fast := &fakeUpstream{ips: []models.Ip{"2.2.2.2"}}
+	p := newTestPool(&config.ResolverConfig{Parallel: true, Timeout: 5 * time.Second}, slow, fast)
+
+	start := time.Now()
+	ips, _, err := p.lookup("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Ip{"2.2.2.2"}, ips)
+	assert.Less(t, time.Since(start), 500*time.Millisecond, "expected the fastest answer to be used")
+	assert.Eventually(t, func() bool {
+		slow.mu.Lock()
+		defer slow.mu.Unlock()
+		return slow.calls == 1
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(20 * time.Millisecond) // let the cancelled lookup return.
+	assert.Equal(t, 0, p.health()[0].Failures, "expected an abandoned lookup not to count as a failure")
+}
+
+func TestResolverPool_Timeout(t *testing.T) {
+	hung := &fakeUpstream{delay: time.Second}
+	backup := &fakeUpstream{ips: []models.Ip{"1.2.3.4"}}
+	p := newTestPool(&config.ResolverConfig{Timeout: 20 * time.Millisecond}, hung, backup)
+
+	ips, _, err := p.lookup("example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Ip{"1.2.3.4"}, ips)
+	assert.Equal(t, 1, p.health()[0].Failures)
+}
+
+func TestNewResolverPool(t *testing.T) {
+	p := newResolverPool(config.MustGetLogger(), &config.ResolverConfig{
+		Servers: []string{"8.8.8.8", "1.1.1.1:5353", "tls://dns.quad9.net", "https://dns.google/dns-query", "https://", "tls://"},
+	})
+	var servers []string
+	for _, u := range p.upstreams {
+		servers = append(servers, u.server)
+	}
+	assert.Equal(t, []string{"8.8.8.8", "1.1.1.1:5353", "tls://dns.quad9.net", "https://dns.google/dns-query"}, servers, "expected invalid servers to be skipped")
+
+	p = newResolverPool(config.MustGetLogger(), &config.ResolverConfig{})
+	assert.Len(t, p.upstreams, 1)
+	assert.Equal(t, defaultUpstream, p.upstreams[0].server)
+}
+
+func TestHostPort(t *testing.T) {
+	tests := []struct {
+		in, addr, host string
+	}{
+		{"8.8.8.8", "8.8.8.8:53", "8.8.8.8"},
+		{"8.8.8.8:5353", "8.8.8.8:5353", "8.8.8.8"},
+		{"2001:4860:4860::8888", "[2001:4860:4860::8888]:53", "2001:4860:4860::8888"},
+		{"[2001:4860:4860::8888]:853", "[2001:4860:4860::8888]:853", "2001:4860:4860::8888"},
+	}
+	for _, tt := range tests {
+		addr, host, err := hostPort(tt.in, "53")
+		assert.NoError(t, err, tt.in)
+		assert.Equal(t, tt.addr, addr, tt.in)
+		assert.Equal(t, tt.host, host, tt.in)
+	}
+	_, _, err := hostPort("", "53")
+	assert.Error(t, err)
+}
+
+func TestDohLookup(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/dns-message", r.Header.Get("Content-Type"))
+		body, _ := io.ReadAll(r.Body)
+		var q dnsmessage.Message
+		if !assert.NoError(t, q.Unpack(body)) {
+			return
+		}
+		resp := dnsmessage.Message{Header: dnsmessage.Header{Response: true}, Questions: q.Questions}
+		switch q.Questions[0].Name.String() {
+		case "example.com.":
+			resp.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 300},
+				Body:   &dnsmessage.AResource{A: [4]byte{93, 184, 216, 34}},
+			}}
+		default:
+			resp.RCode = dnsmessage.RCodeNameError
+		}
+		packed, _ := resp.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		_, _ = w.Write(packed)
+	}))
+	defer srv.Close()
+
+	lookup := dohLookup(srv.Client(), srv.URL)
+	ips, ttl, err := lookup(context.Background(), "example.com")
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Ip{"93.184.216.34"}, ips)
+	assert.Equal(t, 5*time.Minute, ttl, "expected the lowest TTL of the records")
+
+	_, _, err = lookup(context.Background(), "missing.example.com")
+	assert.True(t, isNotFound(err), "expected NXDOMAIN to be a not found error")
+}
+
+// serveDNS answers A queries for example.com on a UDP and a TCP listener on the same port, truncating the UDP answer if
+// truncate is set.
+func serveDNS(t *testing.T, truncate bool) string {
+	answer := func(query []byte, tc bool) []byte {
+		var q dnsmessage.Message
+		if !assert.NoError(t, q.Unpack(query)) {
+			return nil
+		}
+		resp := dnsmessage.Message{Header: dnsmessage.Header{ID: q.ID, Response: true, Truncated: tc}, Questions: q.Questions}
+		if !tc {
+			resp.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: [4]byte{93, 184, 216, 34}},
+			}}
+		}
+		packed, _ := resp.Pack()
+		return packed
+	}
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = tl.Close() })
+	ul, err := net.ListenPacket("udp", tl.Addr().String())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(func() { _ = ul.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := ul.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = ul.WriteTo([]byte{0, 0}, addr) // an answer with the wrong ID to be ignored.
+			_, _ = ul.WriteTo(answer(buf[:n], truncate), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := tl.Accept()
+			if err != nil {
+				return
+			}
+			var length [2]byte
+			if _, err = io.ReadFull(conn, length[:]); err == nil {
+				query := make([]byte, int(length[0])<<8|int(length[1]))
+				if _, err = io.ReadFull(conn, query); err == nil {
+					resp := answer(query, false)
+					_, _ = conn.Write(append([]byte{byte(len(resp) >> 8), byte(len(resp))}, resp...))
+				}
+			}
+			_ = conn.Close()
+		}
+	}()
+	return tl.Addr().String()
+}
+
+func TestExchangeLookup(t *testing.T) {
+	for _, truncate := range []bool{false, true} {
+		addr := serveDNS(t, truncate)
+		u, err := newUpstream(addr)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		ips, ttl, err := u.lookup(ctx, "example.com")
+		cancel()
+		assert.NoError(t, err, "truncate %v", truncate)
+		assert.Equal(t, []models.Ip{"93.184.216.34"}, ips)
+		assert.Equal(t, time.Minute, ttl)
+	}
+}
+
+func TestResolveDomainsConcurrently_Workers(t *testing.T) {
+	var mu sync.Mutex
+	running, most := 0, 0
+	lookup := func(d models.Domain) ([]models.Ip, error) {
+		mu.Lock()
+		running++
+		most = max(most, running)
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return []models.Ip{models.Ip("10.0.0." + string(d))}, nil
+	}
+	var domains []models.Domain
+	for i := range 20 {
+		domains = append(domains, models.Domain(fmt.Sprint(i)))
+	}
+	m := resolveDomainsConcurrently(config.MustGetLogger(), domains, 3, lookup)
+	assert.Len(t, m, 20)
+	assert.LessOrEqual(t, most, 3, "expected at most the given number of lookups at once")
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"maps"
+	"net"
 	"regexp"
 	"slices"
 	"strings"
@@ -12,19 +13,18 @@ import (
 
 	"go.uber.org/zap"
 	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/eventbus"
 	"relloyd/tubetimeout/models"
 )
 
 const (
 	defaultGroupName = "default"
+	scanInterval     = time.Minute // scanInterval is how often the ARP table is scanned for source IPs.
 )
 
-func init() {
-	cmd := "arp"
-	err := config.CheckCmdAvailability(cmd)
-	if err != nil {
-		config.MustGetLogger().Fatalf("Error: %v. Please ensure the '%v' command is installed and available on your PATH.", cmd, err)
-	}
+// RequiredCommands returns the commands that the scan for source IPs runs, which must be on the PATH.
+func RequiredCommands() []string {
+	return []string{"arp"}
 }
 
 // arpCommand is a function type for executing the ARP command
@@ -32,46 +32,61 @@ type arpCommand func() (string, error)
 
 var (
 	ARPCmd              = config.ARPCmd // ARPCmd is the default ARP command
+	NDPCmd              = config.NDPCmd // NDPCmd lists the IPv6 neighbours when IPv6Config.Neighbours is set.
 	groupMacsLoaderFunc = funcGroupMacsLoader(config.GroupMACs.GetConfig)
+	fnUpdateGuests      = config.GroupMACs.UpdateGuests
+	fnLocalDeviceIPs    = localDeviceIPs
 )
 
 type funcGroupMacsLoader func(logger *zap.SugaredLogger) (config.GroupMACsConfig, error)
 
 // NetWatcher manages ARP scanning and registered callbacks
 type NetWatcher struct {
-	logger               *zap.SugaredLogger
-	sourceIpGroups       models.MapIpGroups
-	callbacksForIpGroups []models.SourceIpGroupsReceiver
-	callbacksForIpMACs   []models.SourceIpMACReceiver
-	mu                   sync.Mutex
+	logger         *zap.SugaredLogger
+	sourceIpGroups models.MapIpGroups
+	bus            *eventbus.Bus // bus is where the source IP groups and MACs are published.
+	mu             sync.Mutex
+	lastScan       time.Time                         // lastScan is the time of the last ARP scan, guarded by mu.
+	placements     map[models.MAC][]models.Placement // placements says why each device seen is in its groups, guarded by mu.
+	identities     models.IdentitySource             // identities are the devices' stable identities, if set.
 }
 
-// NewNetWatcher creates a new NetWatcher instance
-func NewNetWatcher(logger *zap.SugaredLogger) *NetWatcher {
+// NewNetWatcher creates a new NetWatcher instance that publishes to the bus, or to a bus of its own if that's nil.
+func NewNetWatcher(logger *zap.SugaredLogger, bus *eventbus.Bus) *NetWatcher {
+	if bus == nil {
+		bus = eventbus.NewBus()
+	}
 	return &NetWatcher{
-		logger:               logger,
-		sourceIpGroups:       make(map[models.Ip][]models.Group),
-		callbacksForIpGroups: []models.SourceIpGroupsReceiver{},
+		logger:         logger,
+		sourceIpGroups: make(map[models.Ip][]models.Group),
+		bus:            bus,
 	}
 }
 
-// RegisterSourceIpGroupsReceivers registers a callback to be called on updates
+// SetIdentitySource sets where the scan finds the devices' stable identities, so that a device seen with another MAC,
+// e.g. a phone's private address for the network, is treated as the group MAC that's bound to its identity.
+func (nw *NetWatcher) SetIdentitySource(src models.IdentitySource) {
+	nw.identities = src
+}
+
+// RegisterSourceIpGroupsReceivers subscribes receivers to the bus's source IP groups.
 func (nw *NetWatcher) RegisterSourceIpGroupsReceivers(receivers ...models.SourceIpGroupsReceiver) {
-	nw.mu.Lock()
-	defer nw.mu.Unlock()
-	nw.callbacksForIpGroups = append(nw.callbacksForIpGroups, receivers...)
+	for _, r := range receivers {
+		nw.bus.SourceIpGroups.Subscribe(r.UpdateSourceIpGroups)
+	}
 }
 
+// RegisterSourceIpMACReceivers subscribes receivers to the bus's source IP MACs.
 func (nw *NetWatcher) RegisterSourceIpMACReceivers(receivers ...models.SourceIpMACReceiver) {
-	nw.mu.Lock()
-	defer nw.mu.Unlock()
-	nw.callbacksForIpMACs = append(nw.callbacksForIpMACs, receivers...)
+	for _, r := range receivers {
+		nw.bus.SourceIpMACs.Subscribe(r.UpdateSourceIpMACs)
+	}
 }
 
 // Start begins the periodic ARP scanning process and supports cancellation using context
 // TODO: add a test to check that scanNetworkAndNotify is called immediately and repeatedly.
 func (nw *NetWatcher) Start(ctx context.Context) {
-	ticker := time.NewTicker(1 * time.Minute)
+	ticker := time.NewTicker(scanInterval)
 	go func() {
 		scanNetworkAndNotify(nw)
 		for {
@@ -86,41 +101,125 @@ func (nw *NetWatcher) Start(ctx context.Context) {
 	}()
 }
 
+// Reload rescans the network immediately so that changes to the group MACs config are applied without
+// waiting for the next periodic scan.
+func (nw *NetWatcher) Reload() {
+	scanNetworkAndNotify(nw)
+}
+
+// Freshness returns when the source IP groups were last scanned.
+func (nw *NetWatcher) Freshness() models.Freshness {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	return models.Freshness{Source: models.FreshnessSourceIpGroups, LastUpdated: nw.lastScan, Interval: scanInterval}
+}
+
+// Placements returns why each device seen on the network in the last scan is in its groups.
+func (nw *NetWatcher) Placements() map[models.MAC][]models.Placement {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	placements := make(map[models.MAC][]models.Placement, len(nw.placements))
+	for mac, p := range nw.placements {
+		placements[mac] = slices.Clone(p)
+	}
+	return placements
+}
+
+// Readiness reports whether any source IPs have been found for filtering.
+func (nw *NetWatcher) Readiness() models.Readiness {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	switch {
+	case nw.lastScan.IsZero():
+		return models.Readiness{State: models.ReadinessWaitingForSources, Cause: "waiting for the first network scan"}
+	case len(nw.sourceIpGroups) == 0:
+		return models.Readiness{State: models.ReadinessWaitingForSources, Cause: "no devices in groups have been found on the network"}
+	}
+	return models.Readiness{State: models.ReadinessReady}
+}
+
 // TODO: stop always notifying everyone when in managerModeMatchAllSourceIps mode.
 func scanNetworkAndNotify(nw *NetWatcher) {
+	var identities models.MapMACIdentity
+	if nw.identities != nil {
+		identities = nw.identities.Identities()
+	}
+
 	// Perform ARP scan and get updated map
-	newMapIpGroups, newMapIpMACs := scanNetwork(nw.logger, ARPCmd) // Empty map returned if no groups are set up.
+	newMapIpGroups, newMapIpMACs, newPlacements := scanNetwork(nw.logger, ARPCmd, identities) // Empty map returned if no groups are set up.
+
+	// Rescan if guests were added or removed, so they're grouped straight away.
+	if updateGuests(nw.logger, newMapIpMACs) {
+		newMapIpGroups, newMapIpMACs, newPlacements = scanNetwork(nw.logger, ARPCmd, identities)
+	}
 
 	nw.logger.Debugf("ARP scan results: %v", newMapIpGroups)
 
 	nw.mu.Lock()
 	defer nw.mu.Unlock()
+	nw.lastScan = time.Now()
+	if newPlacements != nil { // if the scan worked...
+		nw.placements = mergePlacements(nw.placements, newPlacements, nw.lastScan)
+	}
 
 	// TODO: return all IPs if there is an error loading the YAML data.
 	if managerModeMatchAllSourceIps || !maps.EqualFunc(nw.sourceIpGroups, newMapIpGroups, func(m1 []models.Group, m2 []models.Group) bool {
 		return slices.Equal(m1, m2)
 	}) { // if there is new arp data or if we are defaulting to all source IPs...
-		// Send IpGroups to all registered callbacks.
+		// Publish the IpGroups, which the bus copies for each subscriber.
 		nw.logger.Infof("ARP scan detected changes in source IPs: %v", newMapIpGroups)
 		nw.sourceIpGroups = newMapIpGroups
-		for _, cb := range nw.callbacksForIpGroups { // for each callback...
-			cb.UpdateSourceIpGroups(duplicateMap(newMapIpGroups)) // send a copy of the new IP groups.
-		}
-		nw.logger.Debugf("ARP scan notified %d callbacks", len(nw.callbacksForIpGroups))
+		nw.bus.SourceIpGroups.Publish(newMapIpGroups)
+		nw.logger.Debugf("ARP scan notified %d subscribers", nw.bus.SourceIpGroups.Subscribers())
 	}
 
 	if newMapIpMACs != nil && len(newMapIpMACs) > 0 { // if there are any IP-MACs to notify downstream...
-		for _, cb := range nw.callbacksForIpMACs { // for each callback...
-			cb.UpdateSourceIpMACs(duplicateMap(newMapIpMACs)) // send a copy of the new IP-MACs.
-		}
+		nw.bus.SourceIpMACs.Publish(newMapIpMACs)
 		// TODO: add test for UpdateSourceIpMACs() being called after arp scan.
 	} else {
 		nw.logger.Errorf("no IP-MAC data found to send downstream (usage stats will not work)")
 	}
 }
 
-// scanNetwork performs an ARP scan and maps MAC addresses to IPs
-func scanNetwork(logger *zap.SugaredLogger, arpCmd arpCommand) (models.MapIpGroups, models.MapIpMACs) {
+// updateGuests puts the devices found that aren't in a group in the guests group, if guests are enabled, and removes
+// the guests that have expired. It returns true if the group MACs were changed, so the scan should be repeated.
+func updateGuests(logger *zap.SugaredLogger, mim models.MapIpMACs) bool {
+	if !config.Current().GuestConfig.Enabled || managerModeMatchAllSourceIps || mim == nil {
+		return false
+	}
+	seen := slices.Compact(slices.Sorted(maps.Values(mim)))
+	changed, err := fnUpdateGuests(logger, seen, time.Now())
+	if err != nil {
+		logger.Errorf("Error updating guests: %v", err)
+		return false
+	}
+	return changed
+}
+
+// mergePlacements returns next with unknown assignment times filled from prev, or now for devices that are new to a
+// group, so they say how long the device has been in its group.
+func mergePlacements(prev, next map[models.MAC][]models.Placement, now time.Time) map[models.MAC][]models.Placement {
+	for mac, placements := range next {
+		for i, p := range placements {
+			if !p.Since.IsZero() {
+				continue
+			}
+			placements[i].Since = now
+			for _, old := range prev[mac] {
+				if old.Group == p.Group && old.AssignedBy == p.AssignedBy {
+					placements[i].Since = old.Since
+				}
+			}
+		}
+	}
+	return next
+}
+
+// scanNetwork performs an ARP scan and maps MAC addresses to IPs.
+// It also returns why each MAC found is in its groups.
+// A MAC whose identity is bound to a group MAC is replaced by the group MAC, so each of the IPs that a device has
+// used, under any MAC, map to the one device.
+func scanNetwork(logger *zap.SugaredLogger, arpCmd arpCommand, identities models.MapMACIdentity) (models.MapIpGroups, models.MapIpMACs, map[models.MAC][]models.Placement) {
 	// Load YAML data each time.
 	gm, err := groupMacsLoaderFunc(logger)
 	if errors.Is(err, config.ErrorGroupMacFileNotFound) { // if there is an error loading the YAML data...
@@ -140,12 +239,70 @@ func scanNetwork(logger *zap.SugaredLogger, arpCmd arpCommand) (models.MapIpGrou
 	// Initialize maps
 	mig := make(map[models.Ip][]models.Group)
 	mim := make(map[models.Ip]models.MAC)
+	placements := make(map[models.MAC][]models.Placement)
+	addPlacement := func(mac models.MAC, p models.Placement) {
+		if !slices.ContainsFunc(placements[mac], func(existing models.Placement) bool { return existing.Group == p.Group }) {
+			placements[mac] = append(placements[mac], p)
+		}
+	}
+
+	// Find the group MAC bound to each identity.
+	bound := make(map[string]string)
+	for _, macs := range gm.Groups {
+		for _, gmac := range macs {
+			if gmac.Identity != "" {
+				bound[gmac.Identity] = gmac.MAC
+			}
+		}
+	}
+
+	addToGroups := func(ip models.Ip, mac string, by models.AssignedBy) {
+		// Find group for MAC
+		for group, macs := range gm.Groups {
+			for _, gmac := range macs {
+				if gmac.MAC == mac {
+					assignedBy := by
+					if gmac.Guest && by == models.AssignedByManual {
+						assignedBy = models.AssignedByGuest
+					}
+					addPlacement(models.MAC(mac), models.Placement{Group: group, AssignedBy: assignedBy, Since: gmac.AssignedAt})
+					existingGroups := mig[ip] // retrieve existing groups for the IP.
+					exists := false
+					// Check if we saved the group already.
+					for _, existingGroup := range existingGroups {
+						if existingGroup == group {
+							exists = true
+						}
+					}
+					if !exists { // if the group has not yet been saved...
+						mig[ip] = append(existingGroups, group) // append the new group to the existing groups.
+					}
+				}
+			}
+		}
+	}
+
+	addDevice := func(ip models.Ip, mac string) {
+		by := models.AssignedByManual
+		if gmac, ok := bound[identities[models.MAC(mac)]]; ok && gmac != mac { // if the device is known by another MAC...
+			mac, by = gmac, models.AssignedByIdentity
+		}
+		mim[ip] = models.MAC(mac) // save the MAC address for the IP.
+
+		if managerModeMatchAllSourceIps && gm.Groups == nil { // if there are no groups of MACs found...
+			// Set each source IP into the default group.
+			mig[ip] = []models.Group{defaultGroupName}
+			addPlacement(models.MAC(mac), models.Placement{Group: defaultGroupName, AssignedBy: models.AssignedByDefault})
+		} else {
+			addToGroups(ip, mac, by)
+		}
+	}
 
 	// Execute ARP scan
 	output, err := arpCmd()
 	if err != nil {
 		logger.Errorf("Error running ARP command: %v", err)
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	var macRegex = regexp.MustCompile(`(?i)^(?:[0-9A-F]{2}[:-]){5}[0-9A-F]{2}$`)
@@ -167,46 +324,70 @@ func scanNetwork(logger *zap.SugaredLogger, arpCmd arpCommand) (models.MapIpGrou
 		}
 
 		arpMAC = models.NewMAC(arpMAC) // sanitise the MAC. // TODO: test that MACs are sanitised here
+		addDevice(models.Ip(arpIp), arpMAC)
+	}
 
-		mim[models.Ip(arpIp)] = models.MAC(arpMAC) // save the MAC address for the IP.
+	// Add the IPv6 addresses of the devices, which dnsmasq doesn't hand out so they're only found as neighbours.
+	if config.Current().IPv6Config.Neighbours {
+		for ip, mac := range scanNeighbours(logger, NDPCmd) {
+			addDevice(ip, string(mac))
+		}
+	}
 
-		if managerModeMatchAllSourceIps && gm.Groups == nil { // if there are no groups of MACs found...
-			// Set each source IP into the default group.
-			mig[models.Ip(arpIp)] = []models.Group{defaultGroupName}
-		} else {
-			// Find group for MAC
-			for group, macs := range gm.Groups {
-				for _, gmac := range macs {
-					if gmac.MAC == arpMAC {
-						existingGroups := mig[models.Ip(arpIp)] // retrieve existing groups for the IP.
-						exists := false
-						// Check if we saved the group already.
-						for _, existingGroup := range existingGroups {
-							if existingGroup == group {
-								exists = true
-							}
-						}
-						if !exists { // if the group has not yet been saved...
-							mig[models.Ip(arpIp)] = append(existingGroups, group) // append the new group to the existing groups.
-						}
-					}
-				}
-			}
+	// Add the gateway's own IPs, which aren't in the ARP table, so its apps are filtered if it's in a group.
+	if gm.Groups != nil {
+		for _, ip := range fnLocalDeviceIPs() {
+			mim[ip] = models.LocalDeviceMAC
+			addToGroups(ip, string(models.LocalDeviceMAC), models.AssignedByManual)
 		}
 	}
 
-	return mig, mim
+	return mig, mim, placements
 }
 
-// duplicateMap creates a shallow copy of the original map.
-func duplicateMap[K comparable, V any](original map[K]V) map[K]V {
-	// Create a new map with the same type and capacity as the original.
-	newCopy := make(map[K]V, len(original))
-
-	// Iterate over the original map and copy each key-value pair
-	for key, value := range original {
-		newCopy[key] = value
+// scanNeighbours returns the MACs of the global and unique local IPv6 addresses in the neighbour table. Link-local
+// addresses are skipped since every device has one on each link and they're not used for traffic off the LAN.
+// Lines look like "2001:db8::5 dev eth0 lladdr aa:bb:cc:dd:ee:ff STALE", without lladdr if the neighbour has gone.
+func scanNeighbours(logger *zap.SugaredLogger, ndpCmd arpCommand) models.MapIpMACs {
+	output, err := ndpCmd()
+	if err != nil {
+		logger.Errorf("Error listing IPv6 neighbours: %v", err)
+		return nil
+	}
+	mim := make(models.MapIpMACs)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		i := slices.Index(fields, "lladdr")
+		if len(fields) == 0 || i < 0 || i+1 >= len(fields) {
+			continue
+		}
+		ip := net.ParseIP(fields[0])
+		if ip == nil || ip.To4() != nil || !ip.IsGlobalUnicast() { // IsGlobalUnicast includes unique local addresses.
+			continue
+		}
+		if _, err := net.ParseMAC(fields[i+1]); err != nil {
+			continue
+		}
+		mim[models.Ip(ip.String())] = models.MAC(models.NewMAC(fields[i+1]))
 	}
+	return mim
+}
 
-	return newCopy
+// localDeviceIPs returns the gateway's own IPv4 addresses if its traffic is filtered, i.e. the IPs of
+// models.LocalDeviceMAC.
+func localDeviceIPs() []models.Ip {
+	if !config.AppCfg.FilterConfig.LocalDevice {
+		return nil
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []models.Ip
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && !n.IP.IsLoopback() && n.IP.To4() != nil {
+			ips = append(ips, models.Ip(n.IP.String()))
+		}
+	}
+	return ips
 }
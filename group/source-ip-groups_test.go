@@ -1,8 +1,11 @@
 package group
 
 import (
+	"errors"
 	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
@@ -37,7 +40,7 @@ func TestScanNetwork(t *testing.T) {
 	}
 
 	// Call the function under test.
-	mig, mim := scanNetwork(config.MustGetLogger(), mockARPCommand)
+	mig, mim, _ := scanNetwork(config.MustGetLogger(), mockARPCommand, nil)
 	// Validate the IP MACs.
 	expectedMig := map[models.Ip][]models.Group{
 		"192.168.1.10": {"group1"},
@@ -77,7 +80,7 @@ func TestScanNetwork(t *testing.T) {
 		return config.GroupMACsConfig{}, config.ErrorGroupMacFileNotFound
 	}
 	// Call the function under test.
-	mig, mim = scanNetwork(config.MustGetLogger(), mockARPCommand)
+	mig, mim, _ = scanNetwork(config.MustGetLogger(), mockARPCommand, nil)
 	// Validate the IP Groups.
 	expectedMig = map[models.Ip][]models.Group{
 		"192.168.1.10": {defaultGroupName},
@@ -104,3 +107,204 @@ func TestScanNetwork(t *testing.T) {
 //  and when the MAC-Group mapping is empty and we default to every IP
 //  and in what cases we get zero macs
 //  and that the IP-MACs callbacks are executed when we have data for them
+
+func TestScanNetwork_LocalDevice(t *testing.T) {
+	originalLoaderFunc, originalLocalIPs := groupMacsLoaderFunc, fnLocalDeviceIPs
+	defer func() { groupMacsLoaderFunc, fnLocalDeviceIPs = originalLoaderFunc, originalLocalIPs }()
+	fnLocalDeviceIPs = func() []models.Ip { return []models.Ip{"192.168.1.1"} }
+	arp := func() (string, error) { return "? (192.168.1.10) at 00:11:22:33:44:55\n", nil }
+
+	// Expect the gateway's IPs to be grouped like any other device.
+	groupMacsLoaderFunc = func(logger *zap.SugaredLogger) (config.GroupMACsConfig, error) {
+		return config.GroupMACsConfig{Groups: map[models.Group][]models.NamedMAC{
+			"kids": {{MAC: "00-11-22-33-44-55"}, {MAC: string(models.LocalDeviceMAC)}},
+		}}, nil
+	}
+	mig, mim, placements := scanNetwork(config.MustGetLogger(), arp, nil)
+	assert.Equal(t, []models.Group{"kids"}, mig["192.168.1.1"])
+	assert.Equal(t, models.LocalDeviceMAC, mim["192.168.1.1"])
+	assert.Len(t, placements[models.LocalDeviceMAC], 1)
+
+	// Expect the gateway not to be tracked in the default group when there are no groups.
+	groupMacsLoaderFunc = func(logger *zap.SugaredLogger) (config.GroupMACsConfig, error) {
+		return config.GroupMACsConfig{}, config.ErrorGroupMacFileNotFound
+	}
+	mig, _, _ = scanNetwork(config.MustGetLogger(), arp, nil)
+	assert.NotContains(t, mig, models.Ip("192.168.1.1"))
+}
+
+func TestScanNetwork_Identity(t *testing.T) {
+	originalLoaderFunc := groupMacsLoaderFunc
+	defer func() { groupMacsLoaderFunc = originalLoaderFunc }()
+	groupMacsLoaderFunc = func(logger *zap.SugaredLogger) (config.GroupMACsConfig, error) {
+		return config.GroupMACsConfig{Groups: map[models.Group][]models.NamedMAC{
+			"kids": {{MAC: "62-11-22-33-44-55", Name: "Kids iPhone", Identity: "hostname:kids-iphone"}},
+		}}, nil
+	}
+	arp := func() (string, error) {
+		return "? (192.168.1.10) at 62:11:22:33:44:55\n" +
+			"? (192.168.1.11) at 7a:11:22:33:44:66\n" +
+			"? (192.168.1.12) at 00:11:22:33:44:77\n", nil
+	}
+	identities := models.MapMACIdentity{
+		"62-11-22-33-44-55": "hostname:kids-iphone",
+		"7A-11-22-33-44-66": "hostname:kids-iphone", // expect the phone's new private MAC to be the same device.
+		"00-11-22-33-44-77": "hostname:laptop",
+	}
+
+	mig, mim, placements := scanNetwork(config.MustGetLogger(), arp, identities)
+	assert.Equal(t, models.MapIpGroups{"192.168.1.10": {"kids"}, "192.168.1.11": {"kids"}}, mig)
+	assert.Equal(t, models.MapIpMACs{
+		"192.168.1.10": "62-11-22-33-44-55",
+		"192.168.1.11": "62-11-22-33-44-55",
+		"192.168.1.12": "00-11-22-33-44-77",
+	}, mim, "expected both of the phone's IPs to map to its group MAC")
+	assert.Equal(t, []models.Placement{{Group: "kids", AssignedBy: models.AssignedByManual}}, placements["62-11-22-33-44-55"])
+	assert.NotContains(t, placements, models.MAC("7A-11-22-33-44-66"))
+
+	// Expect the group MAC to be placed by its identity when only the new MAC is seen.
+	arp = func() (string, error) { return "? (192.168.1.11) at 7a:11:22:33:44:66\n", nil }
+	_, mim, placements = scanNetwork(config.MustGetLogger(), arp, identities)
+	assert.Equal(t, models.MapIpMACs{"192.168.1.11": "62-11-22-33-44-55"}, mim)
+	assert.Equal(t, []models.Placement{{Group: "kids", AssignedBy: models.AssignedByIdentity}}, placements["62-11-22-33-44-55"])
+
+	// Expect MACs to be used as they are without identities.
+	_, mim, _ = scanNetwork(config.MustGetLogger(), arp, nil)
+	assert.Equal(t, models.MapIpMACs{"192.168.1.11": "7A-11-22-33-44-66"}, mim)
+}
+
+func TestScanNetwork_Guest(t *testing.T) {
+	originalLoaderFunc := groupMacsLoaderFunc
+	defer func() { groupMacsLoaderFunc = originalLoaderFunc }()
+	groupMacsLoaderFunc = func(logger *zap.SugaredLogger) (config.GroupMACsConfig, error) {
+		return config.GroupMACsConfig{Groups: map[models.Group][]models.NamedMAC{
+			"guests": {{MAC: "00-11-22-33-44-55", Guest: true}, {MAC: "00-11-22-33-44-66"}},
+		}}, nil
+	}
+	arp := func() (string, error) {
+		return "? (192.168.1.10) at 00:11:22:33:44:55\n? (192.168.1.11) at 00:11:22:33:44:66\n", nil
+	}
+	_, _, placements := scanNetwork(config.MustGetLogger(), arp, nil)
+	assert.Equal(t, []models.Placement{{Group: "guests", AssignedBy: models.AssignedByGuest}}, placements["00-11-22-33-44-55"])
+	assert.Equal(t, []models.Placement{{Group: "guests", AssignedBy: models.AssignedByManual}}, placements["00-11-22-33-44-66"],
+		"expected devices the user added to the guests group not to be guests")
+}
+
+func TestUpdateGuests(t *testing.T) {
+	originalUpdateGuests, originalGuests := fnUpdateGuests, config.AppCfg.GuestConfig
+	defer func() { fnUpdateGuests, config.AppCfg.GuestConfig = originalUpdateGuests, originalGuests }()
+	var got []models.MAC
+	fnUpdateGuests = func(logger *zap.SugaredLogger, seen []models.MAC, now time.Time) (bool, error) {
+		got = seen
+		return true, nil
+	}
+	mim := models.MapIpMACs{"192.168.1.10": "00-11-22-33-44-55", "192.168.1.11": "00-11-22-33-44-55", "192.168.1.12": "00-11-22-33-44-66"}
+
+	config.AppCfg.GuestConfig.Enabled = false
+	assert.False(t, updateGuests(config.MustGetLogger(), mim))
+	assert.Nil(t, got, "expected guests not to be updated unless they're enabled")
+
+	config.AppCfg.GuestConfig.Enabled = true
+	assert.True(t, updateGuests(config.MustGetLogger(), mim))
+	assert.Equal(t, []models.MAC{"00-11-22-33-44-55", "00-11-22-33-44-66"}, got, "expected each device to be passed once")
+	assert.False(t, updateGuests(config.MustGetLogger(), nil), "expected a failed scan not to expire the guests")
+}
+
+func TestScanNetwork_IPv6Neighbours(t *testing.T) {
+	originalLoaderFunc, originalNDPCmd, originalNeighbours := groupMacsLoaderFunc, NDPCmd, config.AppCfg.IPv6Config.Neighbours
+	defer func() {
+		groupMacsLoaderFunc, NDPCmd, config.AppCfg.IPv6Config.Neighbours = originalLoaderFunc, originalNDPCmd, originalNeighbours
+	}()
+	groupMacsLoaderFunc = func(logger *zap.SugaredLogger) (config.GroupMACsConfig, error) {
+		return config.GroupMACsConfig{Groups: map[models.Group][]models.NamedMAC{"kids": {{MAC: "00-11-22-33-44-55"}}}}, nil
+	}
+	arp := func() (string, error) { return "? (192.168.1.10) at 00:11:22:33:44:55\n", nil }
+	NDPCmd = func() (string, error) {
+		return "2001:db8::5 dev eth0 lladdr 00:11:22:33:44:55 STALE\n" +
+			"fd00::5 dev eth0 lladdr 00:11:22:33:44:55 REACHABLE\n" +
+			"fe80::211:22ff:fe33:4455 dev eth0 lladdr 00:11:22:33:44:55 router REACHABLE\n" +
+			"2001:db8::6 dev eth0 lladdr 66:77:88:99:aa:bb DELAY\n" +
+			"2001:db8::7 dev eth0 FAILED\n", nil
+	}
+
+	config.AppCfg.IPv6Config.Neighbours = false
+	mig, _, _ := scanNetwork(config.MustGetLogger(), arp, nil)
+	assert.Len(t, mig, 1, "expected the neighbours to be ignored unless enabled")
+
+	config.AppCfg.IPv6Config.Neighbours = true
+	mig, mim, _ := scanNetwork(config.MustGetLogger(), arp, nil)
+	assert.Equal(t, models.MapIpGroups{"192.168.1.10": {"kids"}, "2001:db8::5": {"kids"}, "fd00::5": {"kids"}}, mig)
+	assert.Equal(t, models.MapIpMACs{
+		"192.168.1.10": "00-11-22-33-44-55",
+		"2001:db8::5":  "00-11-22-33-44-55",
+		"fd00::5":      "00-11-22-33-44-55",
+		"2001:db8::6":  "66-77-88-99-AA-BB",
+	}, mim, "expected link-local and failed neighbours to be skipped")
+
+	NDPCmd = func() (string, error) { return "", errors.New("ip not found") }
+	mig, _, _ = scanNetwork(config.MustGetLogger(), arp, nil)
+	assert.Len(t, mig, 1, "expected the ARP scan to be used if the neighbours can't be listed")
+}
+
+func TestNetWatcher_Readiness(t *testing.T) {
+	originalLoaderFunc, originalARPCmd := groupMacsLoaderFunc, ARPCmd
+	defer func() { groupMacsLoaderFunc, ARPCmd = originalLoaderFunc, originalARPCmd }()
+	groupMacsLoaderFunc = func(logger *zap.SugaredLogger) (config.GroupMACsConfig, error) {
+		return config.GroupMACsConfig{Groups: map[models.Group][]models.NamedMAC{"group1": {{MAC: "00-11-22-33-44-55"}}}}, nil
+	}
+	arpOutput := "? (192.168.1.20) at 66:77:88:99:AA:BB\n"
+	ARPCmd = func() (string, error) { return arpOutput, nil }
+
+	nw := NewNetWatcher(config.MustGetLogger(), nil)
+	assert.Equal(t, "waiting for the first network scan", nw.Readiness().Cause)
+
+	nw.Reload()
+	assert.Equal(t, models.Readiness{State: models.ReadinessWaitingForSources, Cause: "no devices in groups have been found on the network"}, nw.Readiness())
+
+	arpOutput = "? (192.168.1.10) at 00:11:22:33:44:55\n"
+	nw.Reload()
+	assert.Equal(t, models.Readiness{State: models.ReadinessReady}, nw.Readiness())
+}
+
+func TestNetWatcher_Placements(t *testing.T) {
+	originalLoaderFunc, originalARPCmd := groupMacsLoaderFunc, ARPCmd
+	defer func() { groupMacsLoaderFunc, ARPCmd = originalLoaderFunc, originalARPCmd }()
+	assignedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	groupMacsLoaderFunc = func(logger *zap.SugaredLogger) (config.GroupMACsConfig, error) {
+		return config.GroupMACsConfig{Groups: map[models.Group][]models.NamedMAC{
+			"group1": {{MAC: "00-11-22-33-44-55", AssignedAt: assignedAt}},
+			"group2": {{MAC: "00-11-22-33-44-55"}},
+		}}, nil
+	}
+	ARPCmd = func() (string, error) {
+		return "? (192.168.1.10) at 00:11:22:33:44:55 on wlan0\n? (192.168.1.10) at 00:11:22:33:44:55 on eth0\n", nil
+	}
+
+	nw := NewNetWatcher(config.MustGetLogger(), nil)
+	nw.Reload()
+	p := nw.Placements()["00-11-22-33-44-55"]
+	if assert.Len(t, p, 2, "expected one placement per group") {
+		slices.SortFunc(p, func(a, b models.Placement) int { return strings.Compare(string(a.Group), string(b.Group)) })
+		assert.Equal(t, models.Placement{Group: "group1", AssignedBy: models.AssignedByManual, Since: assignedAt}, p[0])
+		assert.Equal(t, models.AssignedByManual, p[1].AssignedBy)
+		assert.False(t, p[1].Since.IsZero(), "expected an unknown assignment time to be when the device was first seen")
+	}
+
+	// Keep the time a device was first seen in its group across scans.
+	firstSeen := p[1].Since
+	nw.Reload()
+	p = nw.Placements()["00-11-22-33-44-55"]
+	slices.SortFunc(p, func(a, b models.Placement) int { return strings.Compare(string(a.Group), string(b.Group)) })
+	assert.Equal(t, firstSeen, p[1].Since)
+
+	// Fall back to the default group.
+	groupMacsLoaderFunc = func(logger *zap.SugaredLogger) (config.GroupMACsConfig, error) {
+		return config.GroupMACsConfig{}, config.ErrorGroupMacFileNotFound
+	}
+	nw.Reload()
+	p = nw.Placements()["00-11-22-33-44-55"]
+	if assert.Len(t, p, 1) {
+		assert.Equal(t, models.Group(defaultGroupName), p[0].Group)
+		assert.Equal(t, models.AssignedByDefault, p[0].AssignedBy)
+	}
+}
@@ -0,0 +1,1924 @@
+// The gRPC API for automation, e.g. home automation systems. It serves the same groups, tracker config, modes and
+// usage as the web API, with the same API keys and roles.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.5
+// 	protoc        v5.29.3
+// source: tubetimeout.proto
+
+package pb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	durationpb "google.golang.org/protobuf/types/known/durationpb"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// AssignedBy says how a device came to be in a group.
+type AssignedBy int32
+
+const (
+	AssignedBy_ASSIGNED_BY_UNSPECIFIED AssignedBy = 0
+	AssignedBy_ASSIGNED_BY_MANUAL      AssignedBy = 1 // The device was added to the group by the user.
+	AssignedBy_ASSIGNED_BY_DEFAULT     AssignedBy = 2 // No device groups are configured so all devices are tracked in the default group.
+	AssignedBy_ASSIGNED_BY_IDENTITY    AssignedBy = 3 // The device was seen with another MAC, but with the identity of a device in the group.
+	AssignedBy_ASSIGNED_BY_GUEST       AssignedBy = 4 // The device was put in the guests group when it was first seen.
+)
+
+// Enum value maps for AssignedBy.
+var (
+	AssignedBy_name = map[int32]string{
+		0: "ASSIGNED_BY_UNSPECIFIED",
+		1: "ASSIGNED_BY_MANUAL",
+		2: "ASSIGNED_BY_DEFAULT",
+		3: "ASSIGNED_BY_IDENTITY",
+		4: "ASSIGNED_BY_GUEST",
+	}
+	AssignedBy_value = map[string]int32{
+		"ASSIGNED_BY_UNSPECIFIED": 0,
+		"ASSIGNED_BY_MANUAL":      1,
+		"ASSIGNED_BY_DEFAULT":     2,
+		"ASSIGNED_BY_IDENTITY":    3,
+		"ASSIGNED_BY_GUEST":       4,
+	}
+)
+
+func (x AssignedBy) Enum() *AssignedBy {
+	p := new(AssignedBy)
+	*p = x
+	return p
+}
+
+func (x AssignedBy) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (AssignedBy) Descriptor() protoreflect.EnumDescriptor {
+	return file_tubetimeout_proto_enumTypes[0].Descriptor()
+}
+
+func (AssignedBy) Type() protoreflect.EnumType {
+	return &file_tubetimeout_proto_enumTypes[0]
+}
+
+func (x AssignedBy) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use AssignedBy.Descriptor instead.
+func (AssignedBy) EnumDescriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{0}
+}
+
+// TrackerMode matches the modes of the web API.
+type TrackerMode int32
+
+const (
+	TrackerMode_TRACKER_MODE_MONITOR TrackerMode = 0 // The group's usage is tracked against its threshold.
+	TrackerMode_TRACKER_MODE_ALLOW   TrackerMode = 1 // The group is allowed whatever its usage.
+	TrackerMode_TRACKER_MODE_BLOCK   TrackerMode = 2 // The group is blocked whatever its usage.
+)
+
+// Enum value maps for TrackerMode.
+var (
+	TrackerMode_name = map[int32]string{
+		0: "TRACKER_MODE_MONITOR",
+		1: "TRACKER_MODE_ALLOW",
+		2: "TRACKER_MODE_BLOCK",
+	}
+	TrackerMode_value = map[string]int32{
+		"TRACKER_MODE_MONITOR": 0,
+		"TRACKER_MODE_ALLOW":   1,
+		"TRACKER_MODE_BLOCK":   2,
+	}
+)
+
+func (x TrackerMode) Enum() *TrackerMode {
+	p := new(TrackerMode)
+	*p = x
+	return p
+}
+
+func (x TrackerMode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (TrackerMode) Descriptor() protoreflect.EnumDescriptor {
+	return file_tubetimeout_proto_enumTypes[1].Descriptor()
+}
+
+func (TrackerMode) Type() protoreflect.EnumType {
+	return &file_tubetimeout_proto_enumTypes[1]
+}
+
+func (x TrackerMode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use TrackerMode.Descriptor instead.
+func (TrackerMode) EnumDescriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{1}
+}
+
+type Placement struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	AssignedBy    AssignedBy             `protobuf:"varint,2,opt,name=assigned_by,json=assignedBy,proto3,enum=tubetimeout.v1.AssignedBy" json:"assigned_by,omitempty"`
+	Since         *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=since,proto3" json:"since,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Placement) Reset() {
+	*x = Placement{}
+	mi := &file_tubetimeout_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Placement) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Placement) ProtoMessage() {}
+
+func (x *Placement) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Placement.ProtoReflect.Descriptor instead.
+func (*Placement) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Placement) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *Placement) GetAssignedBy() AssignedBy {
+	if x != nil {
+		return x.AssignedBy
+	}
+	return AssignedBy_ASSIGNED_BY_UNSPECIFIED
+}
+
+func (x *Placement) GetSince() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Since
+	}
+	return nil
+}
+
+type Device struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"` // group is empty if the device isn't in a group.
+	Mac           string                 `protobuf:"bytes,2,opt,name=mac,proto3" json:"mac,omitempty"`
+	Name          string                 `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	Identity      string                 `protobuf:"bytes,4,opt,name=identity,proto3" json:"identity,omitempty"`
+	Placement     *Placement             `protobuf:"bytes,5,opt,name=placement,proto3" json:"placement,omitempty"` // placement is set if the device was seen on the network in the last scan.
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Device) Reset() {
+	*x = Device{}
+	mi := &file_tubetimeout_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Device) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Device) ProtoMessage() {}
+
+func (x *Device) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Device.ProtoReflect.Descriptor instead.
+func (*Device) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Device) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *Device) GetMac() string {
+	if x != nil {
+		return x.Mac
+	}
+	return ""
+}
+
+func (x *Device) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Device) GetIdentity() string {
+	if x != nil {
+		return x.Identity
+	}
+	return ""
+}
+
+func (x *Device) GetPlacement() *Placement {
+	if x != nil {
+		return x.Placement
+	}
+	return nil
+}
+
+type ListDevicesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"` // group optionally returns only the devices in the group.
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDevicesRequest) Reset() {
+	*x = ListDevicesRequest{}
+	mi := &file_tubetimeout_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDevicesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDevicesRequest) ProtoMessage() {}
+
+func (x *ListDevicesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDevicesRequest.ProtoReflect.Descriptor instead.
+func (*ListDevicesRequest) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ListDevicesRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+type ListDevicesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Devices       []*Device              `protobuf:"bytes,1,rep,name=devices,proto3" json:"devices,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDevicesResponse) Reset() {
+	*x = ListDevicesResponse{}
+	mi := &file_tubetimeout_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDevicesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDevicesResponse) ProtoMessage() {}
+
+func (x *ListDevicesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDevicesResponse.ProtoReflect.Descriptor instead.
+func (*ListDevicesResponse) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListDevicesResponse) GetDevices() []*Device {
+	if x != nil {
+		return x.Devices
+	}
+	return nil
+}
+
+type SetDeviceGroupRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Mac           string                 `protobuf:"bytes,1,opt,name=mac,proto3" json:"mac,omitempty"`
+	Group         string                 `protobuf:"bytes,2,opt,name=group,proto3" json:"group,omitempty"`
+	Name          *string                `protobuf:"bytes,3,opt,name=name,proto3,oneof" json:"name,omitempty"` // name replaces the device's name if it's set.
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetDeviceGroupRequest) Reset() {
+	*x = SetDeviceGroupRequest{}
+	mi := &file_tubetimeout_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetDeviceGroupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetDeviceGroupRequest) ProtoMessage() {}
+
+func (x *SetDeviceGroupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetDeviceGroupRequest.ProtoReflect.Descriptor instead.
+func (*SetDeviceGroupRequest) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *SetDeviceGroupRequest) GetMac() string {
+	if x != nil {
+		return x.Mac
+	}
+	return ""
+}
+
+func (x *SetDeviceGroupRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *SetDeviceGroupRequest) GetName() string {
+	if x != nil && x.Name != nil {
+		return *x.Name
+	}
+	return ""
+}
+
+type DayThreshold struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Days          []int32                `protobuf:"varint,1,rep,packed,name=days,proto3" json:"days,omitempty"` // days are the days of the week from 0, Sunday.
+	Threshold     *durationpb.Duration   `protobuf:"bytes,2,opt,name=threshold,proto3" json:"threshold,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DayThreshold) Reset() {
+	*x = DayThreshold{}
+	mi := &file_tubetimeout_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DayThreshold) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DayThreshold) ProtoMessage() {}
+
+func (x *DayThreshold) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DayThreshold.ProtoReflect.Descriptor instead.
+func (*DayThreshold) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DayThreshold) GetDays() []int32 {
+	if x != nil {
+		return x.Days
+	}
+	return nil
+}
+
+func (x *DayThreshold) GetThreshold() *durationpb.Duration {
+	if x != nil {
+		return x.Threshold
+	}
+	return nil
+}
+
+// PacketPolicy is how packets of a group over its threshold are dropped, delayed or shaped.
+type PacketPolicy struct {
+	state            protoimpl.MessageState `protogen:"open.v1"`
+	DropPercentage   float32                `protobuf:"fixed32,1,opt,name=drop_percentage,json=dropPercentage,proto3" json:"drop_percentage,omitempty"`    // drop_percentage is the fraction of packets to drop, from 0 to 1.
+	DelayPercentage  float32                `protobuf:"fixed32,2,opt,name=delay_percentage,json=delayPercentage,proto3" json:"delay_percentage,omitempty"` // delay_percentage is the fraction of packets to delay, after dropping.
+	Delay            *durationpb.Duration   `protobuf:"bytes,3,opt,name=delay,proto3" json:"delay,omitempty"`
+	Jitter           *durationpb.Duration   `protobuf:"bytes,4,opt,name=jitter,proto3" json:"jitter,omitempty"`
+	DropUdp          bool                   `protobuf:"varint,5,opt,name=drop_udp,json=dropUdp,proto3" json:"drop_udp,omitempty"`
+	RateLimitKbps    int32                  `protobuf:"varint,6,opt,name=rate_limit_kbps,json=rateLimitKbps,proto3" json:"rate_limit_kbps,omitempty"`            // rate_limit_kbps shapes traffic instead of dropping and delaying it, if it's set.
+	UdpRateLimitKbps int32                  `protobuf:"varint,7,opt,name=udp_rate_limit_kbps,json=udpRateLimitKbps,proto3" json:"udp_rate_limit_kbps,omitempty"` // udp_rate_limit_kbps shapes UDP instead of dropping it when drop_udp is set.
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
+}
+
+func (x *PacketPolicy) Reset() {
+	*x = PacketPolicy{}
+	mi := &file_tubetimeout_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PacketPolicy) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PacketPolicy) ProtoMessage() {}
+
+func (x *PacketPolicy) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PacketPolicy.ProtoReflect.Descriptor instead.
+func (*PacketPolicy) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *PacketPolicy) GetDropPercentage() float32 {
+	if x != nil {
+		return x.DropPercentage
+	}
+	return 0
+}
+
+func (x *PacketPolicy) GetDelayPercentage() float32 {
+	if x != nil {
+		return x.DelayPercentage
+	}
+	return 0
+}
+
+func (x *PacketPolicy) GetDelay() *durationpb.Duration {
+	if x != nil {
+		return x.Delay
+	}
+	return nil
+}
+
+func (x *PacketPolicy) GetJitter() *durationpb.Duration {
+	if x != nil {
+		return x.Jitter
+	}
+	return nil
+}
+
+func (x *PacketPolicy) GetDropUdp() bool {
+	if x != nil {
+		return x.DropUdp
+	}
+	return false
+}
+
+func (x *PacketPolicy) GetRateLimitKbps() int32 {
+	if x != nil {
+		return x.RateLimitKbps
+	}
+	return 0
+}
+
+func (x *PacketPolicy) GetUdpRateLimitKbps() int32 {
+	if x != nil {
+		return x.UdpRateLimitKbps
+	}
+	return 0
+}
+
+// Category splits a group's usage by destination, with its own threshold.
+type Category struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Name          string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Domains       []string               `protobuf:"bytes,2,rep,name=domains,proto3" json:"domains,omitempty"`
+	Sni           []string               `protobuf:"bytes,3,rep,name=sni,proto3" json:"sni,omitempty"`
+	Threshold     *durationpb.Duration   `protobuf:"bytes,4,opt,name=threshold,proto3" json:"threshold,omitempty"`
+	Unlimited     bool                   `protobuf:"varint,5,opt,name=unlimited,proto3" json:"unlimited,omitempty"` // unlimited counts the usage without ever blocking it.
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Category) Reset() {
+	*x = Category{}
+	mi := &file_tubetimeout_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Category) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Category) ProtoMessage() {}
+
+func (x *Category) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Category.ProtoReflect.Descriptor instead.
+func (*Category) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Category) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Category) GetDomains() []string {
+	if x != nil {
+		return x.Domains
+	}
+	return nil
+}
+
+func (x *Category) GetSni() []string {
+	if x != nil {
+		return x.Sni
+	}
+	return nil
+}
+
+func (x *Category) GetThreshold() *durationpb.Duration {
+	if x != nil {
+		return x.Threshold
+	}
+	return nil
+}
+
+func (x *Category) GetUnlimited() bool {
+	if x != nil {
+		return x.Unlimited
+	}
+	return false
+}
+
+type TrackerConfig struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Group             string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Retention         *durationpb.Duration   `protobuf:"bytes,2,opt,name=retention,proto3" json:"retention,omitempty"`
+	Threshold         *durationpb.Duration   `protobuf:"bytes,3,opt,name=threshold,proto3" json:"threshold,omitempty"`
+	DayThresholds     []*DayThreshold        `protobuf:"bytes,4,rep,name=day_thresholds,json=dayThresholds,proto3" json:"day_thresholds,omitempty"`
+	StartDay          int32                  `protobuf:"varint,5,opt,name=start_day,json=startDay,proto3" json:"start_day,omitempty"`
+	StartDayOfMonth   int32                  `protobuf:"varint,6,opt,name=start_day_of_month,json=startDayOfMonth,proto3" json:"start_day_of_month,omitempty"`
+	StartTime         *durationpb.Duration   `protobuf:"bytes,7,opt,name=start_time,json=startTime,proto3" json:"start_time,omitempty"`
+	CountFrom         *durationpb.Duration   `protobuf:"bytes,8,opt,name=count_from,json=countFrom,proto3" json:"count_from,omitempty"`
+	CountUntil        *durationpb.Duration   `protobuf:"bytes,9,opt,name=count_until,json=countUntil,proto3" json:"count_until,omitempty"`
+	BlockOutsideHours bool                   `protobuf:"varint,10,opt,name=block_outside_hours,json=blockOutsideHours,proto3" json:"block_outside_hours,omitempty"`
+	MaxSession        *durationpb.Duration   `protobuf:"bytes,11,opt,name=max_session,json=maxSession,proto3" json:"max_session,omitempty"`
+	BreakDuration     *durationpb.Duration   `protobuf:"bytes,12,opt,name=break_duration,json=breakDuration,proto3" json:"break_duration,omitempty"`
+	MinActive         *durationpb.Duration   `protobuf:"bytes,13,opt,name=min_active,json=minActive,proto3" json:"min_active,omitempty"`
+	MinActiveWindow   *durationpb.Duration   `protobuf:"bytes,14,opt,name=min_active_window,json=minActiveWindow,proto3" json:"min_active_window,omitempty"`
+	PacketSampling    bool                   `protobuf:"varint,15,opt,name=packet_sampling,json=packetSampling,proto3" json:"packet_sampling,omitempty"`
+	Rollover          string                 `protobuf:"bytes,16,opt,name=rollover,proto3" json:"rollover,omitempty"` // rollover is none, capped or full.
+	RolloverCap       *durationpb.Duration   `protobuf:"bytes,17,opt,name=rollover_cap,json=rolloverCap,proto3" json:"rollover_cap,omitempty"`
+	WarnAt            int32                  `protobuf:"varint,18,opt,name=warn_at,json=warnAt,proto3" json:"warn_at,omitempty"`
+	GracePeriod       *durationpb.Duration   `protobuf:"bytes,19,opt,name=grace_period,json=gracePeriod,proto3" json:"grace_period,omitempty"`
+	Allowlist         []string               `protobuf:"bytes,20,rep,name=allowlist,proto3" json:"allowlist,omitempty"`
+	Window            string                 `protobuf:"bytes,21,opt,name=window,proto3" json:"window,omitempty"`                                 // window is empty to reset every retention, or monthly to reset on start_day_of_month.
+	PacketPolicy      *PacketPolicy          `protobuf:"bytes,22,opt,name=packet_policy,json=packetPolicy,proto3" json:"packet_policy,omitempty"` // packet_policy is unset to use the filter's defaults.
+	Categories        []*Category            `protobuf:"bytes,23,rep,name=categories,proto3" json:"categories,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *TrackerConfig) Reset() {
+	*x = TrackerConfig{}
+	mi := &file_tubetimeout_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TrackerConfig) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TrackerConfig) ProtoMessage() {}
+
+func (x *TrackerConfig) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TrackerConfig.ProtoReflect.Descriptor instead.
+func (*TrackerConfig) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *TrackerConfig) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *TrackerConfig) GetRetention() *durationpb.Duration {
+	if x != nil {
+		return x.Retention
+	}
+	return nil
+}
+
+func (x *TrackerConfig) GetThreshold() *durationpb.Duration {
+	if x != nil {
+		return x.Threshold
+	}
+	return nil
+}
+
+func (x *TrackerConfig) GetDayThresholds() []*DayThreshold {
+	if x != nil {
+		return x.DayThresholds
+	}
+	return nil
+}
+
+func (x *TrackerConfig) GetStartDay() int32 {
+	if x != nil {
+		return x.StartDay
+	}
+	return 0
+}
+
+func (x *TrackerConfig) GetStartDayOfMonth() int32 {
+	if x != nil {
+		return x.StartDayOfMonth
+	}
+	return 0
+}
+
+func (x *TrackerConfig) GetStartTime() *durationpb.Duration {
+	if x != nil {
+		return x.StartTime
+	}
+	return nil
+}
+
+func (x *TrackerConfig) GetCountFrom() *durationpb.Duration {
+	if x != nil {
+		return x.CountFrom
+	}
+	return nil
+}
+
+func (x *TrackerConfig) GetCountUntil() *durationpb.Duration {
+	if x != nil {
+		return x.CountUntil
+	}
+	return nil
+}
+
+func (x *TrackerConfig) GetBlockOutsideHours() bool {
+	if x != nil {
+		return x.BlockOutsideHours
+	}
+	return false
+}
+
+func (x *TrackerConfig) GetMaxSession() *durationpb.Duration {
+	if x != nil {
+		return x.MaxSession
+	}
+	return nil
+}
+
+func (x *TrackerConfig) GetBreakDuration() *durationpb.Duration {
+	if x != nil {
+		return x.BreakDuration
+	}
+	return nil
+}
+
+func (x *TrackerConfig) GetMinActive() *durationpb.Duration {
+	if x != nil {
+		return x.MinActive
+	}
+	return nil
+}
+
+func (x *TrackerConfig) GetMinActiveWindow() *durationpb.Duration {
+	if x != nil {
+		return x.MinActiveWindow
+	}
+	return nil
+}
+
+func (x *TrackerConfig) GetPacketSampling() bool {
+	if x != nil {
+		return x.PacketSampling
+	}
+	return false
+}
+
+func (x *TrackerConfig) GetRollover() string {
+	if x != nil {
+		return x.Rollover
+	}
+	return ""
+}
+
+func (x *TrackerConfig) GetRolloverCap() *durationpb.Duration {
+	if x != nil {
+		return x.RolloverCap
+	}
+	return nil
+}
+
+func (x *TrackerConfig) GetWarnAt() int32 {
+	if x != nil {
+		return x.WarnAt
+	}
+	return 0
+}
+
+func (x *TrackerConfig) GetGracePeriod() *durationpb.Duration {
+	if x != nil {
+		return x.GracePeriod
+	}
+	return nil
+}
+
+func (x *TrackerConfig) GetAllowlist() []string {
+	if x != nil {
+		return x.Allowlist
+	}
+	return nil
+}
+
+func (x *TrackerConfig) GetWindow() string {
+	if x != nil {
+		return x.Window
+	}
+	return ""
+}
+
+func (x *TrackerConfig) GetPacketPolicy() *PacketPolicy {
+	if x != nil {
+		return x.PacketPolicy
+	}
+	return nil
+}
+
+func (x *TrackerConfig) GetCategories() []*Category {
+	if x != nil {
+		return x.Categories
+	}
+	return nil
+}
+
+type GetTrackerConfigRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTrackerConfigRequest) Reset() {
+	*x = GetTrackerConfigRequest{}
+	mi := &file_tubetimeout_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTrackerConfigRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTrackerConfigRequest) ProtoMessage() {}
+
+func (x *GetTrackerConfigRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTrackerConfigRequest.ProtoReflect.Descriptor instead.
+func (*GetTrackerConfigRequest) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{9}
+}
+
+type GetTrackerConfigResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Groups        []*TrackerConfig       `protobuf:"bytes,1,rep,name=groups,proto3" json:"groups,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetTrackerConfigResponse) Reset() {
+	*x = GetTrackerConfigResponse{}
+	mi := &file_tubetimeout_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetTrackerConfigResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetTrackerConfigResponse) ProtoMessage() {}
+
+func (x *GetTrackerConfigResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetTrackerConfigResponse.ProtoReflect.Descriptor instead.
+func (*GetTrackerConfigResponse) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *GetTrackerConfigResponse) GetGroups() []*TrackerConfig {
+	if x != nil {
+		return x.Groups
+	}
+	return nil
+}
+
+type SetTrackerConfigRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Config        *TrackerConfig         `protobuf:"bytes,1,opt,name=config,proto3" json:"config,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetTrackerConfigRequest) Reset() {
+	*x = SetTrackerConfigRequest{}
+	mi := &file_tubetimeout_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetTrackerConfigRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetTrackerConfigRequest) ProtoMessage() {}
+
+func (x *SetTrackerConfigRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetTrackerConfigRequest.ProtoReflect.Descriptor instead.
+func (*SetTrackerConfigRequest) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *SetTrackerConfigRequest) GetConfig() *TrackerConfig {
+	if x != nil {
+		return x.Config
+	}
+	return nil
+}
+
+type Mode struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Mode          TrackerMode            `protobuf:"varint,2,opt,name=mode,proto3,enum=tubetimeout.v1.TrackerMode" json:"mode,omitempty"`
+	EndTime       *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=end_time,json=endTime,proto3" json:"end_time,omitempty"` // end_time is when an allow or block ends.
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Mode) Reset() {
+	*x = Mode{}
+	mi := &file_tubetimeout_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Mode) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Mode) ProtoMessage() {}
+
+func (x *Mode) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Mode.ProtoReflect.Descriptor instead.
+func (*Mode) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *Mode) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *Mode) GetMode() TrackerMode {
+	if x != nil {
+		return x.Mode
+	}
+	return TrackerMode_TRACKER_MODE_MONITOR
+}
+
+func (x *Mode) GetEndTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.EndTime
+	}
+	return nil
+}
+
+type GetModeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetModeRequest) Reset() {
+	*x = GetModeRequest{}
+	mi := &file_tubetimeout_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetModeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetModeRequest) ProtoMessage() {}
+
+func (x *GetModeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetModeRequest.ProtoReflect.Descriptor instead.
+func (*GetModeRequest) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *GetModeRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+type SetModeRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	Mode          TrackerMode            `protobuf:"varint,2,opt,name=mode,proto3,enum=tubetimeout.v1.TrackerMode" json:"mode,omitempty"`
+	Duration      *durationpb.Duration   `protobuf:"bytes,3,opt,name=duration,proto3" json:"duration,omitempty"` // duration is how long to allow or block for, in whole minutes.
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SetModeRequest) Reset() {
+	*x = SetModeRequest{}
+	mi := &file_tubetimeout_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SetModeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SetModeRequest) ProtoMessage() {}
+
+func (x *SetModeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SetModeRequest.ProtoReflect.Descriptor instead.
+func (*SetModeRequest) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *SetModeRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *SetModeRequest) GetMode() TrackerMode {
+	if x != nil {
+		return x.Mode
+	}
+	return TrackerMode_TRACKER_MODE_MONITOR
+}
+
+func (x *SetModeRequest) GetDuration() *durationpb.Duration {
+	if x != nil {
+		return x.Duration
+	}
+	return nil
+}
+
+type DeviceUsage struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Mac           string                 `protobuf:"bytes,1,opt,name=mac,proto3" json:"mac,omitempty"`
+	UsedMinutes   int32                  `protobuf:"varint,2,opt,name=used_minutes,json=usedMinutes,proto3" json:"used_minutes,omitempty"` // used_minutes is set when TRACKER_TRACK_DEVICES is on.
+	LastActive    *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=last_active,json=lastActive,proto3" json:"last_active,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeviceUsage) Reset() {
+	*x = DeviceUsage{}
+	mi := &file_tubetimeout_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeviceUsage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeviceUsage) ProtoMessage() {}
+
+func (x *DeviceUsage) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeviceUsage.ProtoReflect.Descriptor instead.
+func (*DeviceUsage) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *DeviceUsage) GetMac() string {
+	if x != nil {
+		return x.Mac
+	}
+	return ""
+}
+
+func (x *DeviceUsage) GetUsedMinutes() int32 {
+	if x != nil {
+		return x.UsedMinutes
+	}
+	return 0
+}
+
+func (x *DeviceUsage) GetLastActive() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastActive
+	}
+	return nil
+}
+
+type DomainUsage struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Domain        string                 `protobuf:"bytes,1,opt,name=domain,proto3" json:"domain,omitempty"`
+	IngressBytes  int64                  `protobuf:"varint,2,opt,name=ingress_bytes,json=ingressBytes,proto3" json:"ingress_bytes,omitempty"`
+	EgressBytes   int64                  `protobuf:"varint,3,opt,name=egress_bytes,json=egressBytes,proto3" json:"egress_bytes,omitempty"`
+	LastSeen      *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=last_seen,json=lastSeen,proto3" json:"last_seen,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DomainUsage) Reset() {
+	*x = DomainUsage{}
+	mi := &file_tubetimeout_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DomainUsage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DomainUsage) ProtoMessage() {}
+
+func (x *DomainUsage) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DomainUsage.ProtoReflect.Descriptor instead.
+func (*DomainUsage) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *DomainUsage) GetDomain() string {
+	if x != nil {
+		return x.Domain
+	}
+	return ""
+}
+
+func (x *DomainUsage) GetIngressBytes() int64 {
+	if x != nil {
+		return x.IngressBytes
+	}
+	return 0
+}
+
+func (x *DomainUsage) GetEgressBytes() int64 {
+	if x != nil {
+		return x.EgressBytes
+	}
+	return 0
+}
+
+func (x *DomainUsage) GetLastSeen() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastSeen
+	}
+	return nil
+}
+
+type GroupUsage struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Group             string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"`
+	UsedMinutes       int32                  `protobuf:"varint,2,opt,name=used_minutes,json=usedMinutes,proto3" json:"used_minutes,omitempty"`
+	ThresholdMinutes  int32                  `protobuf:"varint,3,opt,name=threshold_minutes,json=thresholdMinutes,proto3" json:"threshold_minutes,omitempty"` // threshold_minutes includes any day threshold, transfers and time carried over.
+	Percentage        int32                  `protobuf:"varint,4,opt,name=percentage,proto3" json:"percentage,omitempty"`
+	AdjustmentMinutes int32                  `protobuf:"varint,5,opt,name=adjustment_minutes,json=adjustmentMinutes,proto3" json:"adjustment_minutes,omitempty"`
+	CarriedMinutes    int32                  `protobuf:"varint,6,opt,name=carried_minutes,json=carriedMinutes,proto3" json:"carried_minutes,omitempty"`
+	OutsideHours      bool                   `protobuf:"varint,7,opt,name=outside_hours,json=outsideHours,proto3" json:"outside_hours,omitempty"`
+	SessionMinutes    int32                  `protobuf:"varint,8,opt,name=session_minutes,json=sessionMinutes,proto3" json:"session_minutes,omitempty"`
+	BreakEndTime      *timestamppb.Timestamp `protobuf:"bytes,9,opt,name=break_end_time,json=breakEndTime,proto3" json:"break_end_time,omitempty"`
+	Warning           bool                   `protobuf:"varint,10,opt,name=warning,proto3" json:"warning,omitempty"`
+	Exceeded          bool                   `protobuf:"varint,11,opt,name=exceeded,proto3" json:"exceeded,omitempty"`
+	Devices           []*DeviceUsage         `protobuf:"bytes,12,rep,name=devices,proto3" json:"devices,omitempty"`
+	Domains           []*DomainUsage         `protobuf:"bytes,13,rep,name=domains,proto3" json:"domains,omitempty"` // domains are sorted by domain.
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *GroupUsage) Reset() {
+	*x = GroupUsage{}
+	mi := &file_tubetimeout_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GroupUsage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GroupUsage) ProtoMessage() {}
+
+func (x *GroupUsage) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GroupUsage.ProtoReflect.Descriptor instead.
+func (*GroupUsage) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *GroupUsage) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+func (x *GroupUsage) GetUsedMinutes() int32 {
+	if x != nil {
+		return x.UsedMinutes
+	}
+	return 0
+}
+
+func (x *GroupUsage) GetThresholdMinutes() int32 {
+	if x != nil {
+		return x.ThresholdMinutes
+	}
+	return 0
+}
+
+func (x *GroupUsage) GetPercentage() int32 {
+	if x != nil {
+		return x.Percentage
+	}
+	return 0
+}
+
+func (x *GroupUsage) GetAdjustmentMinutes() int32 {
+	if x != nil {
+		return x.AdjustmentMinutes
+	}
+	return 0
+}
+
+func (x *GroupUsage) GetCarriedMinutes() int32 {
+	if x != nil {
+		return x.CarriedMinutes
+	}
+	return 0
+}
+
+func (x *GroupUsage) GetOutsideHours() bool {
+	if x != nil {
+		return x.OutsideHours
+	}
+	return false
+}
+
+func (x *GroupUsage) GetSessionMinutes() int32 {
+	if x != nil {
+		return x.SessionMinutes
+	}
+	return 0
+}
+
+func (x *GroupUsage) GetBreakEndTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.BreakEndTime
+	}
+	return nil
+}
+
+func (x *GroupUsage) GetWarning() bool {
+	if x != nil {
+		return x.Warning
+	}
+	return false
+}
+
+func (x *GroupUsage) GetExceeded() bool {
+	if x != nil {
+		return x.Exceeded
+	}
+	return false
+}
+
+func (x *GroupUsage) GetDevices() []*DeviceUsage {
+	if x != nil {
+		return x.Devices
+	}
+	return nil
+}
+
+func (x *GroupUsage) GetDomains() []*DomainUsage {
+	if x != nil {
+		return x.Domains
+	}
+	return nil
+}
+
+type GetUsageRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Group         string                 `protobuf:"bytes,1,opt,name=group,proto3" json:"group,omitempty"` // group optionally returns only the usage of the group.
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetUsageRequest) Reset() {
+	*x = GetUsageRequest{}
+	mi := &file_tubetimeout_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetUsageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetUsageRequest) ProtoMessage() {}
+
+func (x *GetUsageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetUsageRequest.ProtoReflect.Descriptor instead.
+func (*GetUsageRequest) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *GetUsageRequest) GetGroup() string {
+	if x != nil {
+		return x.Group
+	}
+	return ""
+}
+
+type GetUsageResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Groups        []*GroupUsage          `protobuf:"bytes,1,rep,name=groups,proto3" json:"groups,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetUsageResponse) Reset() {
+	*x = GetUsageResponse{}
+	mi := &file_tubetimeout_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetUsageResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetUsageResponse) ProtoMessage() {}
+
+func (x *GetUsageResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_tubetimeout_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetUsageResponse.ProtoReflect.Descriptor instead.
+func (*GetUsageResponse) Descriptor() ([]byte, []int) {
+	return file_tubetimeout_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *GetUsageResponse) GetGroups() []*GroupUsage {
+	if x != nil {
+		return x.Groups
+	}
+	return nil
+}
+
+var File_tubetimeout_proto protoreflect.FileDescriptor
+
+var file_tubetimeout_proto_rawDesc = string([]byte{
+	0x0a, 0x11, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x12, 0x0e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74,
+	0x2e, 0x76, 0x31, 0x1a, 0x1e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2f, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x22, 0x90, 0x01, 0x0a, 0x09, 0x50, 0x6c, 0x61, 0x63, 0x65, 0x6d, 0x65,
+	0x6e, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x12, 0x3b, 0x0a, 0x0b, 0x61, 0x73, 0x73, 0x69,
+	0x67, 0x6e, 0x65, 0x64, 0x5f, 0x62, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x1a, 0x2e,
+	0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x41,
+	0x73, 0x73, 0x69, 0x67, 0x6e, 0x65, 0x64, 0x42, 0x79, 0x52, 0x0a, 0x61, 0x73, 0x73, 0x69, 0x67,
+	0x6e, 0x65, 0x64, 0x42, 0x79, 0x12, 0x30, 0x0a, 0x05, 0x73, 0x69, 0x6e, 0x63, 0x65, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70,
+	0x52, 0x05, 0x73, 0x69, 0x6e, 0x63, 0x65, 0x22, 0x99, 0x01, 0x0a, 0x06, 0x44, 0x65, 0x76, 0x69,
+	0x63, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x12, 0x10, 0x0a, 0x03, 0x6d, 0x61, 0x63, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6d, 0x61, 0x63, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61,
+	0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x1a,
+	0x0a, 0x08, 0x69, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x74, 0x79, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x08, 0x69, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x74, 0x79, 0x12, 0x37, 0x0a, 0x09, 0x70, 0x6c,
+	0x61, 0x63, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e,
+	0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x50,
+	0x6c, 0x61, 0x63, 0x65, 0x6d, 0x65, 0x6e, 0x74, 0x52, 0x09, 0x70, 0x6c, 0x61, 0x63, 0x65, 0x6d,
+	0x65, 0x6e, 0x74, 0x22, 0x2a, 0x0a, 0x12, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x65, 0x76, 0x69, 0x63,
+	0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x67, 0x72, 0x6f,
+	0x75, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x22,
+	0x47, 0x0a, 0x13, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x73, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x30, 0x0a, 0x07, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65,
+	0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69,
+	0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x52,
+	0x07, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x73, 0x22, 0x61, 0x0a, 0x15, 0x53, 0x65, 0x74, 0x44,
+	0x65, 0x76, 0x69, 0x63, 0x65, 0x47, 0x72, 0x6f, 0x75, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x12, 0x10, 0x0a, 0x03, 0x6d, 0x61, 0x63, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03,
+	0x6d, 0x61, 0x63, 0x12, 0x14, 0x0a, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x12, 0x17, 0x0a, 0x04, 0x6e, 0x61, 0x6d,
+	0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x48, 0x00, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x88,
+	0x01, 0x01, 0x42, 0x07, 0x0a, 0x05, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x22, 0x5b, 0x0a, 0x0c, 0x44,
+	0x61, 0x79, 0x54, 0x68, 0x72, 0x65, 0x73, 0x68, 0x6f, 0x6c, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x64,
+	0x61, 0x79, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x05, 0x52, 0x04, 0x64, 0x61, 0x79, 0x73, 0x12,
+	0x37, 0x0a, 0x09, 0x74, 0x68, 0x72, 0x65, 0x73, 0x68, 0x6f, 0x6c, 0x64, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x09, 0x74,
+	0x68, 0x72, 0x65, 0x73, 0x68, 0x6f, 0x6c, 0x64, 0x22, 0xb8, 0x02, 0x0a, 0x0c, 0x50, 0x61, 0x63,
+	0x6b, 0x65, 0x74, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x12, 0x27, 0x0a, 0x0f, 0x64, 0x72, 0x6f,
+	0x70, 0x5f, 0x70, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x61, 0x67, 0x65, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x02, 0x52, 0x0e, 0x64, 0x72, 0x6f, 0x70, 0x50, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x61,
+	0x67, 0x65, 0x12, 0x29, 0x0a, 0x10, 0x64, 0x65, 0x6c, 0x61, 0x79, 0x5f, 0x70, 0x65, 0x72, 0x63,
+	0x65, 0x6e, 0x74, 0x61, 0x67, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x02, 0x52, 0x0f, 0x64, 0x65,
+	0x6c, 0x61, 0x79, 0x50, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x61, 0x67, 0x65, 0x12, 0x2f, 0x0a,
+	0x05, 0x64, 0x65, 0x6c, 0x61, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67,
+	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44,
+	0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x05, 0x64, 0x65, 0x6c, 0x61, 0x79, 0x12, 0x31,
+	0x0a, 0x06, 0x6a, 0x69, 0x74, 0x74, 0x65, 0x72, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19,
+	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x06, 0x6a, 0x69, 0x74, 0x74, 0x65,
+	0x72, 0x12, 0x19, 0x0a, 0x08, 0x64, 0x72, 0x6f, 0x70, 0x5f, 0x75, 0x64, 0x70, 0x18, 0x05, 0x20,
+	0x01, 0x28, 0x08, 0x52, 0x07, 0x64, 0x72, 0x6f, 0x70, 0x55, 0x64, 0x70, 0x12, 0x26, 0x0a, 0x0f,
+	0x72, 0x61, 0x74, 0x65, 0x5f, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x5f, 0x6b, 0x62, 0x70, 0x73, 0x18,
+	0x06, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0d, 0x72, 0x61, 0x74, 0x65, 0x4c, 0x69, 0x6d, 0x69, 0x74,
+	0x4b, 0x62, 0x70, 0x73, 0x12, 0x2d, 0x0a, 0x13, 0x75, 0x64, 0x70, 0x5f, 0x72, 0x61, 0x74, 0x65,
+	0x5f, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x5f, 0x6b, 0x62, 0x70, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x10, 0x75, 0x64, 0x70, 0x52, 0x61, 0x74, 0x65, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x4b,
+	0x62, 0x70, 0x73, 0x22, 0xa1, 0x01, 0x0a, 0x08, 0x43, 0x61, 0x74, 0x65, 0x67, 0x6f, 0x72, 0x79,
+	0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04,
+	0x6e, 0x61, 0x6d, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x64, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x73, 0x18,
+	0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x07, 0x64, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x73, 0x12, 0x10,
+	0x0a, 0x03, 0x73, 0x6e, 0x69, 0x18, 0x03, 0x20, 0x03, 0x28, 0x09, 0x52, 0x03, 0x73, 0x6e, 0x69,
+	0x12, 0x37, 0x0a, 0x09, 0x74, 0x68, 0x72, 0x65, 0x73, 0x68, 0x6f, 0x6c, 0x64, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x09,
+	0x74, 0x68, 0x72, 0x65, 0x73, 0x68, 0x6f, 0x6c, 0x64, 0x12, 0x1c, 0x0a, 0x09, 0x75, 0x6e, 0x6c,
+	0x69, 0x6d, 0x69, 0x74, 0x65, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x09, 0x75, 0x6e,
+	0x6c, 0x69, 0x6d, 0x69, 0x74, 0x65, 0x64, 0x22, 0x92, 0x09, 0x0a, 0x0d, 0x54, 0x72, 0x61, 0x63,
+	0x6b, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x14, 0x0a, 0x05, 0x67, 0x72, 0x6f,
+	0x75, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x12,
+	0x37, 0x0a, 0x09, 0x72, 0x65, 0x74, 0x65, 0x6e, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x09, 0x72,
+	0x65, 0x74, 0x65, 0x6e, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x37, 0x0a, 0x09, 0x74, 0x68, 0x72, 0x65,
+	0x73, 0x68, 0x6f, 0x6c, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f,
+	0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75,
+	0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x09, 0x74, 0x68, 0x72, 0x65, 0x73, 0x68, 0x6f, 0x6c,
+	0x64, 0x12, 0x43, 0x0a, 0x0e, 0x64, 0x61, 0x79, 0x5f, 0x74, 0x68, 0x72, 0x65, 0x73, 0x68, 0x6f,
+	0x6c, 0x64, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x74, 0x75, 0x62, 0x65,
+	0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x61, 0x79, 0x54, 0x68,
+	0x72, 0x65, 0x73, 0x68, 0x6f, 0x6c, 0x64, 0x52, 0x0d, 0x64, 0x61, 0x79, 0x54, 0x68, 0x72, 0x65,
+	0x73, 0x68, 0x6f, 0x6c, 0x64, 0x73, 0x12, 0x1b, 0x0a, 0x09, 0x73, 0x74, 0x61, 0x72, 0x74, 0x5f,
+	0x64, 0x61, 0x79, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x73, 0x74, 0x61, 0x72, 0x74,
+	0x44, 0x61, 0x79, 0x12, 0x2b, 0x0a, 0x12, 0x73, 0x74, 0x61, 0x72, 0x74, 0x5f, 0x64, 0x61, 0x79,
+	0x5f, 0x6f, 0x66, 0x5f, 0x6d, 0x6f, 0x6e, 0x74, 0x68, 0x18, 0x06, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x0f, 0x73, 0x74, 0x61, 0x72, 0x74, 0x44, 0x61, 0x79, 0x4f, 0x66, 0x4d, 0x6f, 0x6e, 0x74, 0x68,
+	0x12, 0x38, 0x0a, 0x0a, 0x73, 0x74, 0x61, 0x72, 0x74, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x07,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52,
+	0x09, 0x73, 0x74, 0x61, 0x72, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x38, 0x0a, 0x0a, 0x63, 0x6f,
+	0x75, 0x6e, 0x74, 0x5f, 0x66, 0x72, 0x6f, 0x6d, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19,
+	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x09, 0x63, 0x6f, 0x75, 0x6e, 0x74,
+	0x46, 0x72, 0x6f, 0x6d, 0x12, 0x3a, 0x0a, 0x0b, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x5f, 0x75, 0x6e,
+	0x74, 0x69, 0x6c, 0x18, 0x09, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x52, 0x0a, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x55, 0x6e, 0x74, 0x69, 0x6c,
+	0x12, 0x2e, 0x0a, 0x13, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x5f, 0x6f, 0x75, 0x74, 0x73, 0x69, 0x64,
+	0x65, 0x5f, 0x68, 0x6f, 0x75, 0x72, 0x73, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x08, 0x52, 0x11, 0x62,
+	0x6c, 0x6f, 0x63, 0x6b, 0x4f, 0x75, 0x74, 0x73, 0x69, 0x64, 0x65, 0x48, 0x6f, 0x75, 0x72, 0x73,
+	0x12, 0x3a, 0x0a, 0x0b, 0x6d, 0x61, 0x78, 0x5f, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x18,
+	0x0b, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e,
+	0x52, 0x0a, 0x6d, 0x61, 0x78, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x40, 0x0a, 0x0e,
+	0x62, 0x72, 0x65, 0x61, 0x6b, 0x5f, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x0c,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52,
+	0x0d, 0x62, 0x72, 0x65, 0x61, 0x6b, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x38,
+	0x0a, 0x0a, 0x6d, 0x69, 0x6e, 0x5f, 0x61, 0x63, 0x74, 0x69, 0x76, 0x65, 0x18, 0x0d, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x09, 0x6d,
+	0x69, 0x6e, 0x41, 0x63, 0x74, 0x69, 0x76, 0x65, 0x12, 0x45, 0x0a, 0x11, 0x6d, 0x69, 0x6e, 0x5f,
+	0x61, 0x63, 0x74, 0x69, 0x76, 0x65, 0x5f, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x18, 0x0e, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x0f,
+	0x6d, 0x69, 0x6e, 0x41, 0x63, 0x74, 0x69, 0x76, 0x65, 0x57, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x12,
+	0x27, 0x0a, 0x0f, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x5f, 0x73, 0x61, 0x6d, 0x70, 0x6c, 0x69,
+	0x6e, 0x67, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0e, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74,
+	0x53, 0x61, 0x6d, 0x70, 0x6c, 0x69, 0x6e, 0x67, 0x12, 0x1a, 0x0a, 0x08, 0x72, 0x6f, 0x6c, 0x6c,
+	0x6f, 0x76, 0x65, 0x72, 0x18, 0x10, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x72, 0x6f, 0x6c, 0x6c,
+	0x6f, 0x76, 0x65, 0x72, 0x12, 0x3c, 0x0a, 0x0c, 0x72, 0x6f, 0x6c, 0x6c, 0x6f, 0x76, 0x65, 0x72,
+	0x5f, 0x63, 0x61, 0x70, 0x18, 0x11, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72,
+	0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x0b, 0x72, 0x6f, 0x6c, 0x6c, 0x6f, 0x76, 0x65, 0x72, 0x43,
+	0x61, 0x70, 0x12, 0x17, 0x0a, 0x07, 0x77, 0x61, 0x72, 0x6e, 0x5f, 0x61, 0x74, 0x18, 0x12, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x06, 0x77, 0x61, 0x72, 0x6e, 0x41, 0x74, 0x12, 0x3c, 0x0a, 0x0c, 0x67,
+	0x72, 0x61, 0x63, 0x65, 0x5f, 0x70, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x18, 0x13, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x0b, 0x67, 0x72,
+	0x61, 0x63, 0x65, 0x50, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x12, 0x1c, 0x0a, 0x09, 0x61, 0x6c, 0x6c,
+	0x6f, 0x77, 0x6c, 0x69, 0x73, 0x74, 0x18, 0x14, 0x20, 0x03, 0x28, 0x09, 0x52, 0x09, 0x61, 0x6c,
+	0x6c, 0x6f, 0x77, 0x6c, 0x69, 0x73, 0x74, 0x12, 0x16, 0x0a, 0x06, 0x77, 0x69, 0x6e, 0x64, 0x6f,
+	0x77, 0x18, 0x15, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x77, 0x69, 0x6e, 0x64, 0x6f, 0x77, 0x12,
+	0x41, 0x0a, 0x0d, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x5f, 0x70, 0x6f, 0x6c, 0x69, 0x63, 0x79,
+	0x18, 0x16, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1c, 0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d,
+	0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x50, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x50, 0x6f,
+	0x6c, 0x69, 0x63, 0x79, 0x52, 0x0c, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x50, 0x6f, 0x6c, 0x69,
+	0x63, 0x79, 0x12, 0x38, 0x0a, 0x0a, 0x63, 0x61, 0x74, 0x65, 0x67, 0x6f, 0x72, 0x69, 0x65, 0x73,
+	0x18, 0x17, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x18, 0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d,
+	0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x61, 0x74, 0x65, 0x67, 0x6f, 0x72, 0x79,
+	0x52, 0x0a, 0x63, 0x61, 0x74, 0x65, 0x67, 0x6f, 0x72, 0x69, 0x65, 0x73, 0x22, 0x19, 0x0a, 0x17,
+	0x47, 0x65, 0x74, 0x54, 0x72, 0x61, 0x63, 0x6b, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x51, 0x0a, 0x18, 0x47, 0x65, 0x74, 0x54, 0x72,
+	0x61, 0x63, 0x6b, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x12, 0x35, 0x0a, 0x06, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x73, 0x18, 0x01, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x1d, 0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75,
+	0x74, 0x2e, 0x76, 0x31, 0x2e, 0x54, 0x72, 0x61, 0x63, 0x6b, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66,
+	0x69, 0x67, 0x52, 0x06, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x73, 0x22, 0x50, 0x0a, 0x17, 0x53, 0x65,
+	0x74, 0x54, 0x72, 0x61, 0x63, 0x6b, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x35, 0x0a, 0x06, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1d, 0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65,
+	0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x54, 0x72, 0x61, 0x63, 0x6b, 0x65, 0x72, 0x43, 0x6f,
+	0x6e, 0x66, 0x69, 0x67, 0x52, 0x06, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x22, 0x84, 0x01, 0x0a,
+	0x04, 0x4d, 0x6f, 0x64, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x12, 0x2f, 0x0a, 0x04, 0x6d,
+	0x6f, 0x64, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x1b, 0x2e, 0x74, 0x75, 0x62, 0x65,
+	0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x54, 0x72, 0x61, 0x63, 0x6b,
+	0x65, 0x72, 0x4d, 0x6f, 0x64, 0x65, 0x52, 0x04, 0x6d, 0x6f, 0x64, 0x65, 0x12, 0x35, 0x0a, 0x08,
+	0x65, 0x6e, 0x64, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a,
+	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x07, 0x65, 0x6e, 0x64, 0x54,
+	0x69, 0x6d, 0x65, 0x22, 0x26, 0x0a, 0x0e, 0x47, 0x65, 0x74, 0x4d, 0x6f, 0x64, 0x65, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x22, 0x8e, 0x01, 0x0a, 0x0e,
+	0x53, 0x65, 0x74, 0x4d, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x14,
+	0x0a, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x67,
+	0x72, 0x6f, 0x75, 0x70, 0x12, 0x2f, 0x0a, 0x04, 0x6d, 0x6f, 0x64, 0x65, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0e, 0x32, 0x1b, 0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74,
+	0x2e, 0x76, 0x31, 0x2e, 0x54, 0x72, 0x61, 0x63, 0x6b, 0x65, 0x72, 0x4d, 0x6f, 0x64, 0x65, 0x52,
+	0x04, 0x6d, 0x6f, 0x64, 0x65, 0x12, 0x35, 0x0a, 0x08, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x44, 0x75, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x52, 0x08, 0x64, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x22, 0x7f, 0x0a, 0x0b,
+	0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x55, 0x73, 0x61, 0x67, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x6d,
+	0x61, 0x63, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6d, 0x61, 0x63, 0x12, 0x21, 0x0a,
+	0x0c, 0x75, 0x73, 0x65, 0x64, 0x5f, 0x6d, 0x69, 0x6e, 0x75, 0x74, 0x65, 0x73, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x0b, 0x75, 0x73, 0x65, 0x64, 0x4d, 0x69, 0x6e, 0x75, 0x74, 0x65, 0x73,
+	0x12, 0x3b, 0x0a, 0x0b, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x61, 0x63, 0x74, 0x69, 0x76, 0x65, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d,
+	0x70, 0x52, 0x0a, 0x6c, 0x61, 0x73, 0x74, 0x41, 0x63, 0x74, 0x69, 0x76, 0x65, 0x22, 0xa6, 0x01,
+	0x0a, 0x0b, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x55, 0x73, 0x61, 0x67, 0x65, 0x12, 0x16, 0x0a,
+	0x06, 0x64, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x64,
+	0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x12, 0x23, 0x0a, 0x0d, 0x69, 0x6e, 0x67, 0x72, 0x65, 0x73, 0x73,
+	0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c, 0x69, 0x6e,
+	0x67, 0x72, 0x65, 0x73, 0x73, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x65, 0x67,
+	0x72, 0x65, 0x73, 0x73, 0x5f, 0x62, 0x79, 0x74, 0x65, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x0b, 0x65, 0x67, 0x72, 0x65, 0x73, 0x73, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x37, 0x0a,
+	0x09, 0x6c, 0x61, 0x73, 0x74, 0x5f, 0x73, 0x65, 0x65, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x08, 0x6c, 0x61,
+	0x73, 0x74, 0x53, 0x65, 0x65, 0x6e, 0x22, 0x9e, 0x04, 0x0a, 0x0a, 0x47, 0x72, 0x6f, 0x75, 0x70,
+	0x55, 0x73, 0x61, 0x67, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x12, 0x21, 0x0a, 0x0c, 0x75,
+	0x73, 0x65, 0x64, 0x5f, 0x6d, 0x69, 0x6e, 0x75, 0x74, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x0b, 0x75, 0x73, 0x65, 0x64, 0x4d, 0x69, 0x6e, 0x75, 0x74, 0x65, 0x73, 0x12, 0x2b,
+	0x0a, 0x11, 0x74, 0x68, 0x72, 0x65, 0x73, 0x68, 0x6f, 0x6c, 0x64, 0x5f, 0x6d, 0x69, 0x6e, 0x75,
+	0x74, 0x65, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x10, 0x74, 0x68, 0x72, 0x65, 0x73,
+	0x68, 0x6f, 0x6c, 0x64, 0x4d, 0x69, 0x6e, 0x75, 0x74, 0x65, 0x73, 0x12, 0x1e, 0x0a, 0x0a, 0x70,
+	0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x61, 0x67, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x0a, 0x70, 0x65, 0x72, 0x63, 0x65, 0x6e, 0x74, 0x61, 0x67, 0x65, 0x12, 0x2d, 0x0a, 0x12, 0x61,
+	0x64, 0x6a, 0x75, 0x73, 0x74, 0x6d, 0x65, 0x6e, 0x74, 0x5f, 0x6d, 0x69, 0x6e, 0x75, 0x74, 0x65,
+	0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x11, 0x61, 0x64, 0x6a, 0x75, 0x73, 0x74, 0x6d,
+	0x65, 0x6e, 0x74, 0x4d, 0x69, 0x6e, 0x75, 0x74, 0x65, 0x73, 0x12, 0x27, 0x0a, 0x0f, 0x63, 0x61,
+	0x72, 0x72, 0x69, 0x65, 0x64, 0x5f, 0x6d, 0x69, 0x6e, 0x75, 0x74, 0x65, 0x73, 0x18, 0x06, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x0e, 0x63, 0x61, 0x72, 0x72, 0x69, 0x65, 0x64, 0x4d, 0x69, 0x6e, 0x75,
+	0x74, 0x65, 0x73, 0x12, 0x23, 0x0a, 0x0d, 0x6f, 0x75, 0x74, 0x73, 0x69, 0x64, 0x65, 0x5f, 0x68,
+	0x6f, 0x75, 0x72, 0x73, 0x18, 0x07, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x6f, 0x75, 0x74, 0x73,
+	0x69, 0x64, 0x65, 0x48, 0x6f, 0x75, 0x72, 0x73, 0x12, 0x27, 0x0a, 0x0f, 0x73, 0x65, 0x73, 0x73,
+	0x69, 0x6f, 0x6e, 0x5f, 0x6d, 0x69, 0x6e, 0x75, 0x74, 0x65, 0x73, 0x18, 0x08, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x0e, 0x73, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x4d, 0x69, 0x6e, 0x75, 0x74, 0x65,
+	0x73, 0x12, 0x40, 0x0a, 0x0e, 0x62, 0x72, 0x65, 0x61, 0x6b, 0x5f, 0x65, 0x6e, 0x64, 0x5f, 0x74,
+	0x69, 0x6d, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x0c, 0x62, 0x72, 0x65, 0x61, 0x6b, 0x45, 0x6e, 0x64, 0x54,
+	0x69, 0x6d, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x77, 0x61, 0x72, 0x6e, 0x69, 0x6e, 0x67, 0x18, 0x0a,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x77, 0x61, 0x72, 0x6e, 0x69, 0x6e, 0x67, 0x12, 0x1a, 0x0a,
+	0x08, 0x65, 0x78, 0x63, 0x65, 0x65, 0x64, 0x65, 0x64, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x08, 0x65, 0x78, 0x63, 0x65, 0x65, 0x64, 0x65, 0x64, 0x12, 0x35, 0x0a, 0x07, 0x64, 0x65, 0x76,
+	0x69, 0x63, 0x65, 0x73, 0x18, 0x0c, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1b, 0x2e, 0x74, 0x75, 0x62,
+	0x65, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x44, 0x65, 0x76, 0x69,
+	0x63, 0x65, 0x55, 0x73, 0x61, 0x67, 0x65, 0x52, 0x07, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x73,
+	0x12, 0x35, 0x0a, 0x07, 0x64, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x73, 0x18, 0x0d, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x1b, 0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e,
+	0x76, 0x31, 0x2e, 0x44, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x55, 0x73, 0x61, 0x67, 0x65, 0x52, 0x07,
+	0x64, 0x6f, 0x6d, 0x61, 0x69, 0x6e, 0x73, 0x22, 0x27, 0x0a, 0x0f, 0x47, 0x65, 0x74, 0x55, 0x73,
+	0x61, 0x67, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x67, 0x72,
+	0x6f, 0x75, 0x70, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x67, 0x72, 0x6f, 0x75, 0x70,
+	0x22, 0x46, 0x0a, 0x10, 0x47, 0x65, 0x74, 0x55, 0x73, 0x61, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x32, 0x0a, 0x06, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x73, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65, 0x6f,
+	0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x72, 0x6f, 0x75, 0x70, 0x55, 0x73, 0x61, 0x67, 0x65,
+	0x52, 0x06, 0x67, 0x72, 0x6f, 0x75, 0x70, 0x73, 0x2a, 0x8b, 0x01, 0x0a, 0x0a, 0x41, 0x73, 0x73,
+	0x69, 0x67, 0x6e, 0x65, 0x64, 0x42, 0x79, 0x12, 0x1b, 0x0a, 0x17, 0x41, 0x53, 0x53, 0x49, 0x47,
+	0x4e, 0x45, 0x44, 0x5f, 0x42, 0x59, 0x5f, 0x55, 0x4e, 0x53, 0x50, 0x45, 0x43, 0x49, 0x46, 0x49,
+	0x45, 0x44, 0x10, 0x00, 0x12, 0x16, 0x0a, 0x12, 0x41, 0x53, 0x53, 0x49, 0x47, 0x4e, 0x45, 0x44,
+	0x5f, 0x42, 0x59, 0x5f, 0x4d, 0x41, 0x4e, 0x55, 0x41, 0x4c, 0x10, 0x01, 0x12, 0x17, 0x0a, 0x13,
+	0x41, 0x53, 0x53, 0x49, 0x47, 0x4e, 0x45, 0x44, 0x5f, 0x42, 0x59, 0x5f, 0x44, 0x45, 0x46, 0x41,
+	0x55, 0x4c, 0x54, 0x10, 0x02, 0x12, 0x18, 0x0a, 0x14, 0x41, 0x53, 0x53, 0x49, 0x47, 0x4e, 0x45,
+	0x44, 0x5f, 0x42, 0x59, 0x5f, 0x49, 0x44, 0x45, 0x4e, 0x54, 0x49, 0x54, 0x59, 0x10, 0x03, 0x12,
+	0x15, 0x0a, 0x11, 0x41, 0x53, 0x53, 0x49, 0x47, 0x4e, 0x45, 0x44, 0x5f, 0x42, 0x59, 0x5f, 0x47,
+	0x55, 0x45, 0x53, 0x54, 0x10, 0x04, 0x2a, 0x57, 0x0a, 0x0b, 0x54, 0x72, 0x61, 0x63, 0x6b, 0x65,
+	0x72, 0x4d, 0x6f, 0x64, 0x65, 0x12, 0x18, 0x0a, 0x14, 0x54, 0x52, 0x41, 0x43, 0x4b, 0x45, 0x52,
+	0x5f, 0x4d, 0x4f, 0x44, 0x45, 0x5f, 0x4d, 0x4f, 0x4e, 0x49, 0x54, 0x4f, 0x52, 0x10, 0x00, 0x12,
+	0x16, 0x0a, 0x12, 0x54, 0x52, 0x41, 0x43, 0x4b, 0x45, 0x52, 0x5f, 0x4d, 0x4f, 0x44, 0x45, 0x5f,
+	0x41, 0x4c, 0x4c, 0x4f, 0x57, 0x10, 0x01, 0x12, 0x16, 0x0a, 0x12, 0x54, 0x52, 0x41, 0x43, 0x4b,
+	0x45, 0x52, 0x5f, 0x4d, 0x4f, 0x44, 0x45, 0x5f, 0x42, 0x4c, 0x4f, 0x43, 0x4b, 0x10, 0x02, 0x32,
+	0xca, 0x04, 0x0a, 0x0b, 0x54, 0x75, 0x62, 0x65, 0x54, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x12,
+	0x56, 0x0a, 0x0b, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x73, 0x12, 0x22,
+	0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e,
+	0x4c, 0x69, 0x73, 0x74, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x23, 0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74,
+	0x2e, 0x76, 0x31, 0x2e, 0x4c, 0x69, 0x73, 0x74, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x73, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x4f, 0x0a, 0x0e, 0x53, 0x65, 0x74, 0x44, 0x65,
+	0x76, 0x69, 0x63, 0x65, 0x47, 0x72, 0x6f, 0x75, 0x70, 0x12, 0x25, 0x2e, 0x74, 0x75, 0x62, 0x65,
+	0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x65, 0x74, 0x44, 0x65,
+	0x76, 0x69, 0x63, 0x65, 0x47, 0x72, 0x6f, 0x75, 0x70, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x16, 0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76,
+	0x31, 0x2e, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x12, 0x65, 0x0a, 0x10, 0x47, 0x65, 0x74, 0x54,
+	0x72, 0x61, 0x63, 0x6b, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x27, 0x2e, 0x74,
+	0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65,
+	0x74, 0x54, 0x72, 0x61, 0x63, 0x6b, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x28, 0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65,
+	0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x54, 0x72, 0x61, 0x63, 0x6b, 0x65,
+	0x72, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12,
+	0x5a, 0x0a, 0x10, 0x53, 0x65, 0x74, 0x54, 0x72, 0x61, 0x63, 0x6b, 0x65, 0x72, 0x43, 0x6f, 0x6e,
+	0x66, 0x69, 0x67, 0x12, 0x27, 0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75,
+	0x74, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x65, 0x74, 0x54, 0x72, 0x61, 0x63, 0x6b, 0x65, 0x72, 0x43,
+	0x6f, 0x6e, 0x66, 0x69, 0x67, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1d, 0x2e, 0x74,
+	0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x54, 0x72,
+	0x61, 0x63, 0x6b, 0x65, 0x72, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x12, 0x3f, 0x0a, 0x07, 0x47,
+	0x65, 0x74, 0x4d, 0x6f, 0x64, 0x65, 0x12, 0x1e, 0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d,
+	0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x4d, 0x6f, 0x64, 0x65, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d,
+	0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x4d, 0x6f, 0x64, 0x65, 0x12, 0x3f, 0x0a, 0x07,
+	0x53, 0x65, 0x74, 0x4d, 0x6f, 0x64, 0x65, 0x12, 0x1e, 0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69,
+	0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x65, 0x74, 0x4d, 0x6f, 0x64, 0x65,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x14, 0x2e, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69,
+	0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x4d, 0x6f, 0x64, 0x65, 0x12, 0x4d, 0x0a,
+	0x08, 0x47, 0x65, 0x74, 0x55, 0x73, 0x61, 0x67, 0x65, 0x12, 0x1f, 0x2e, 0x74, 0x75, 0x62, 0x65,
+	0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x55, 0x73,
+	0x61, 0x67, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x20, 0x2e, 0x74, 0x75, 0x62,
+	0x65, 0x74, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x47, 0x65, 0x74, 0x55,
+	0x73, 0x61, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x42, 0x20, 0x5a, 0x1e,
+	0x72, 0x65, 0x6c, 0x6c, 0x6f, 0x79, 0x64, 0x2f, 0x74, 0x75, 0x62, 0x65, 0x74, 0x69, 0x6d, 0x65,
+	0x6f, 0x75, 0x74, 0x2f, 0x67, 0x72, 0x70, 0x63, 0x61, 0x70, 0x69, 0x2f, 0x70, 0x62, 0x62, 0x06,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+})
+
+var (
+	file_tubetimeout_proto_rawDescOnce sync.Once
+	file_tubetimeout_proto_rawDescData []byte
+)
+
+func file_tubetimeout_proto_rawDescGZIP() []byte {
+	file_tubetimeout_proto_rawDescOnce.Do(func() {
+		file_tubetimeout_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_tubetimeout_proto_rawDesc), len(file_tubetimeout_proto_rawDesc)))
+	})
+	return file_tubetimeout_proto_rawDescData
+}
+
+var file_tubetimeout_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_tubetimeout_proto_msgTypes = make([]protoimpl.MessageInfo, 20)
+var file_tubetimeout_proto_goTypes = []any{
+	(AssignedBy)(0),                  // 0: tubetimeout.v1.AssignedBy
+	(TrackerMode)(0),                 // 1: tubetimeout.v1.TrackerMode
+	(*Placement)(nil),                // 2: tubetimeout.v1.Placement
+	(*Device)(nil),                   // 3: tubetimeout.v1.Device
+	(*ListDevicesRequest)(nil),       // 4: tubetimeout.v1.ListDevicesRequest
+	(*ListDevicesResponse)(nil),      // 5: tubetimeout.v1.ListDevicesResponse
+	(*SetDeviceGroupRequest)(nil),    // 6: tubetimeout.v1.SetDeviceGroupRequest
+	(*DayThreshold)(nil),             // 7: tubetimeout.v1.DayThreshold
+	(*PacketPolicy)(nil),             // 8: tubetimeout.v1.PacketPolicy
+	(*Category)(nil),                 // 9: tubetimeout.v1.Category
+	(*TrackerConfig)(nil),            // 10: tubetimeout.v1.TrackerConfig
+	(*GetTrackerConfigRequest)(nil),  // 11: tubetimeout.v1.GetTrackerConfigRequest
+	(*GetTrackerConfigResponse)(nil), // 12: tubetimeout.v1.GetTrackerConfigResponse
+	(*SetTrackerConfigRequest)(nil),  // 13: tubetimeout.v1.SetTrackerConfigRequest
+	(*Mode)(nil),                     // 14: tubetimeout.v1.Mode
+	(*GetModeRequest)(nil),           // 15: tubetimeout.v1.GetModeRequest
+	(*SetModeRequest)(nil),           // 16: tubetimeout.v1.SetModeRequest
+	(*DeviceUsage)(nil),              // 17: tubetimeout.v1.DeviceUsage
+	(*DomainUsage)(nil),              // 18: tubetimeout.v1.DomainUsage
+	(*GroupUsage)(nil),               // 19: tubetimeout.v1.GroupUsage
+	(*GetUsageRequest)(nil),          // 20: tubetimeout.v1.GetUsageRequest
+	(*GetUsageResponse)(nil),         // 21: tubetimeout.v1.GetUsageResponse
+	(*timestamppb.Timestamp)(nil),    // 22: google.protobuf.Timestamp
+	(*durationpb.Duration)(nil),      // 23: google.protobuf.Duration
+}
+var file_tubetimeout_proto_depIdxs = []int32{
+	0,  // 0: tubetimeout.v1.Placement.assigned_by:type_name -> tubetimeout.v1.AssignedBy
+	22, // 1: tubetimeout.v1.Placement.since:type_name -> google.protobuf.Timestamp
+	2,  // 2: tubetimeout.v1.Device.placement:type_name -> tubetimeout.v1.Placement
+	3,  // 3: tubetimeout.v1.ListDevicesResponse.devices:type_name -> tubetimeout.v1.Device
+	23, // 4: tubetimeout.v1.DayThreshold.threshold:type_name -> google.protobuf.Duration
+	23, // 5: tubetimeout.v1.PacketPolicy.delay:type_name -> google.protobuf.Duration
+	23, // 6: tubetimeout.v1.PacketPolicy.jitter:type_name -> google.protobuf.Duration
+	23, // 7: tubetimeout.v1.Category.threshold:type_name -> google.protobuf.Duration
+	23, // 8: tubetimeout.v1.TrackerConfig.retention:type_name -> google.protobuf.Duration
+	23, // 9: tubetimeout.v1.TrackerConfig.threshold:type_name -> google.protobuf.Duration
+	7,  // 10: tubetimeout.v1.TrackerConfig.day_thresholds:type_name -> tubetimeout.v1.DayThreshold
+	23, // 11: tubetimeout.v1.TrackerConfig.start_time:type_name -> google.protobuf.Duration
+	23, // 12: tubetimeout.v1.TrackerConfig.count_from:type_name -> google.protobuf.Duration
+	23, // 13: tubetimeout.v1.TrackerConfig.count_until:type_name -> google.protobuf.Duration
+	23, // 14: tubetimeout.v1.TrackerConfig.max_session:type_name -> google.protobuf.Duration
+	23, // 15: tubetimeout.v1.TrackerConfig.break_duration:type_name -> google.protobuf.Duration
+	23, // 16: tubetimeout.v1.TrackerConfig.min_active:type_name -> google.protobuf.Duration
+	23, // 17: tubetimeout.v1.TrackerConfig.min_active_window:type_name -> google.protobuf.Duration
+	23, // 18: tubetimeout.v1.TrackerConfig.rollover_cap:type_name -> google.protobuf.Duration
+	23, // 19: tubetimeout.v1.TrackerConfig.grace_period:type_name -> google.protobuf.Duration
+	8,  // 20: tubetimeout.v1.TrackerConfig.packet_policy:type_name -> tubetimeout.v1.PacketPolicy
+	9,  // 21: tubetimeout.v1.TrackerConfig.categories:type_name -> tubetimeout.v1.Category
+	10, // 22: tubetimeout.v1.GetTrackerConfigResponse.groups:type_name -> tubetimeout.v1.TrackerConfig
+	10, // 23: tubetimeout.v1.SetTrackerConfigRequest.config:type_name -> tubetimeout.v1.TrackerConfig
+	1,  // 24: tubetimeout.v1.Mode.mode:type_name -> tubetimeout.v1.TrackerMode
+	22, // 25: tubetimeout.v1.Mode.end_time:type_name -> google.protobuf.Timestamp
+	1,  // 26: tubetimeout.v1.SetModeRequest.mode:type_name -> tubetimeout.v1.TrackerMode
+	23, // 27: tubetimeout.v1.SetModeRequest.duration:type_name -> google.protobuf.Duration
+	22, // 28: tubetimeout.v1.DeviceUsage.last_active:type_name -> google.protobuf.Timestamp
+	22, // 29: tubetimeout.v1.DomainUsage.last_seen:type_name -> google.protobuf.Timestamp
+	22, // 30: tubetimeout.v1.GroupUsage.break_end_time:type_name -> google.protobuf.Timestamp
+	17, // 31: tubetimeout.v1.GroupUsage.devices:type_name -> tubetimeout.v1.DeviceUsage
+	18, // 32: tubetimeout.v1.GroupUsage.domains:type_name -> tubetimeout.v1.DomainUsage
+	19, // 33: tubetimeout.v1.GetUsageResponse.groups:type_name -> tubetimeout.v1.GroupUsage
+	4,  // 34: tubetimeout.v1.TubeTimeout.ListDevices:input_type -> tubetimeout.v1.ListDevicesRequest
+	6,  // 35: tubetimeout.v1.TubeTimeout.SetDeviceGroup:input_type -> tubetimeout.v1.SetDeviceGroupRequest
+	11, // 36: tubetimeout.v1.TubeTimeout.GetTrackerConfig:input_type -> tubetimeout.v1.GetTrackerConfigRequest
+	13, // 37: tubetimeout.v1.TubeTimeout.SetTrackerConfig:input_type -> tubetimeout.v1.SetTrackerConfigRequest
+	15, // 38: tubetimeout.v1.TubeTimeout.GetMode:input_type -> tubetimeout.v1.GetModeRequest
+	16, // 39: tubetimeout.v1.TubeTimeout.SetMode:input_type -> tubetimeout.v1.SetModeRequest
+	20, // 40: tubetimeout.v1.TubeTimeout.GetUsage:input_type -> tubetimeout.v1.GetUsageRequest
+	5,  // 41: tubetimeout.v1.TubeTimeout.ListDevices:output_type -> tubetimeout.v1.ListDevicesResponse
+	3,  // 42: tubetimeout.v1.TubeTimeout.SetDeviceGroup:output_type -> tubetimeout.v1.Device
+	12, // 43: tubetimeout.v1.TubeTimeout.GetTrackerConfig:output_type -> tubetimeout.v1.GetTrackerConfigResponse
+	10, // 44: tubetimeout.v1.TubeTimeout.SetTrackerConfig:output_type -> tubetimeout.v1.TrackerConfig
+	14, // 45: tubetimeout.v1.TubeTimeout.GetMode:output_type -> tubetimeout.v1.Mode
+	14, // 46: tubetimeout.v1.TubeTimeout.SetMode:output_type -> tubetimeout.v1.Mode
+	21, // 47: tubetimeout.v1.TubeTimeout.GetUsage:output_type -> tubetimeout.v1.GetUsageResponse
+	41, // [41:48] is the sub-list for method output_type
+	34, // [34:41] is the sub-list for method input_type
+	34, // [34:34] is the sub-list for extension type_name
+	34, // [34:34] is the sub-list for extension extendee
+	0,  // [0:34] is the sub-list for field type_name
+}
+
+func init() { file_tubetimeout_proto_init() }
+func file_tubetimeout_proto_init() {
+	if File_tubetimeout_proto != nil {
+		return
+	}
+	file_tubetimeout_proto_msgTypes[4].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_tubetimeout_proto_rawDesc), len(file_tubetimeout_proto_rawDesc)),
+			NumEnums:      2,
+			NumMessages:   20,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_tubetimeout_proto_goTypes,
+		DependencyIndexes: file_tubetimeout_proto_depIdxs,
+		EnumInfos:         file_tubetimeout_proto_enumTypes,
+		MessageInfos:      file_tubetimeout_proto_msgTypes,
+	}.Build()
+	File_tubetimeout_proto = out.File
+	file_tubetimeout_proto_goTypes = nil
+	file_tubetimeout_proto_depIdxs = nil
+}
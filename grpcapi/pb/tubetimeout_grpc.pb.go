@@ -0,0 +1,368 @@
+// The gRPC API for automation, e.g. home automation systems. It serves the same groups, tracker config, modes and
+// usage as the web API, with the same API keys and roles.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: tubetimeout.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	TubeTimeout_ListDevices_FullMethodName      = "/tubetimeout.v1.TubeTimeout/ListDevices"
+	TubeTimeout_SetDeviceGroup_FullMethodName   = "/tubetimeout.v1.TubeTimeout/SetDeviceGroup"
+	TubeTimeout_GetTrackerConfig_FullMethodName = "/tubetimeout.v1.TubeTimeout/GetTrackerConfig"
+	TubeTimeout_SetTrackerConfig_FullMethodName = "/tubetimeout.v1.TubeTimeout/SetTrackerConfig"
+	TubeTimeout_GetMode_FullMethodName          = "/tubetimeout.v1.TubeTimeout/GetMode"
+	TubeTimeout_SetMode_FullMethodName          = "/tubetimeout.v1.TubeTimeout/SetMode"
+	TubeTimeout_GetUsage_FullMethodName         = "/tubetimeout.v1.TubeTimeout/GetUsage"
+)
+
+// TubeTimeoutClient is the client API for TubeTimeout service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type TubeTimeoutClient interface {
+	// ListDevices returns the devices in each group and the devices seen on the network that aren't in a group.
+	ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error)
+	// SetDeviceGroup moves a device to a group, or out of its group if the group is empty. It needs an admin key.
+	SetDeviceGroup(ctx context.Context, in *SetDeviceGroupRequest, opts ...grpc.CallOption) (*Device, error)
+	// GetTrackerConfig returns the config of each group's usage tracker.
+	GetTrackerConfig(ctx context.Context, in *GetTrackerConfigRequest, opts ...grpc.CallOption) (*GetTrackerConfigResponse, error)
+	// SetTrackerConfig adds or replaces the tracker config of a group, keeping its mode, which is set by SetMode. It
+	// saves the same config as the web API does for the same settings. It needs an admin key.
+	SetTrackerConfig(ctx context.Context, in *SetTrackerConfigRequest, opts ...grpc.CallOption) (*TrackerConfig, error)
+	// GetMode returns a group's mode and when it ends.
+	GetMode(ctx context.Context, in *GetModeRequest, opts ...grpc.CallOption) (*Mode, error)
+	// SetMode allows or blocks a group for a duration, or resumes monitoring it. It needs an operator or admin key.
+	SetMode(ctx context.Context, in *SetModeRequest, opts ...grpc.CallOption) (*Mode, error)
+	// GetUsage returns the usage of each group in its current window, or of one group.
+	GetUsage(ctx context.Context, in *GetUsageRequest, opts ...grpc.CallOption) (*GetUsageResponse, error)
+}
+
+type tubeTimeoutClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTubeTimeoutClient(cc grpc.ClientConnInterface) TubeTimeoutClient {
+	return &tubeTimeoutClient{cc}
+}
+
+func (c *tubeTimeoutClient) ListDevices(ctx context.Context, in *ListDevicesRequest, opts ...grpc.CallOption) (*ListDevicesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListDevicesResponse)
+	err := c.cc.Invoke(ctx, TubeTimeout_ListDevices_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tubeTimeoutClient) SetDeviceGroup(ctx context.Context, in *SetDeviceGroupRequest, opts ...grpc.CallOption) (*Device, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Device)
+	err := c.cc.Invoke(ctx, TubeTimeout_SetDeviceGroup_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tubeTimeoutClient) GetTrackerConfig(ctx context.Context, in *GetTrackerConfigRequest, opts ...grpc.CallOption) (*GetTrackerConfigResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetTrackerConfigResponse)
+	err := c.cc.Invoke(ctx, TubeTimeout_GetTrackerConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tubeTimeoutClient) SetTrackerConfig(ctx context.Context, in *SetTrackerConfigRequest, opts ...grpc.CallOption) (*TrackerConfig, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(TrackerConfig)
+	err := c.cc.Invoke(ctx, TubeTimeout_SetTrackerConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tubeTimeoutClient) GetMode(ctx context.Context, in *GetModeRequest, opts ...grpc.CallOption) (*Mode, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Mode)
+	err := c.cc.Invoke(ctx, TubeTimeout_GetMode_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tubeTimeoutClient) SetMode(ctx context.Context, in *SetModeRequest, opts ...grpc.CallOption) (*Mode, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(Mode)
+	err := c.cc.Invoke(ctx, TubeTimeout_SetMode_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *tubeTimeoutClient) GetUsage(ctx context.Context, in *GetUsageRequest, opts ...grpc.CallOption) (*GetUsageResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetUsageResponse)
+	err := c.cc.Invoke(ctx, TubeTimeout_GetUsage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TubeTimeoutServer is the server API for TubeTimeout service.
+// All implementations must embed UnimplementedTubeTimeoutServer
+// for forward compatibility.
+type TubeTimeoutServer interface {
+	// ListDevices returns the devices in each group and the devices seen on the network that aren't in a group.
+	ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error)
+	// SetDeviceGroup moves a device to a group, or out of its group if the group is empty. It needs an admin key.
+	SetDeviceGroup(context.Context, *SetDeviceGroupRequest) (*Device, error)
+	// GetTrackerConfig returns the config of each group's usage tracker.
+	GetTrackerConfig(context.Context, *GetTrackerConfigRequest) (*GetTrackerConfigResponse, error)
+	// SetTrackerConfig adds or replaces the tracker config of a group, keeping its mode, which is set by SetMode. It
+	// saves the same config as the web API does for the same settings. It needs an admin key.
+	SetTrackerConfig(context.Context, *SetTrackerConfigRequest) (*TrackerConfig, error)
+	// GetMode returns a group's mode and when it ends.
+	GetMode(context.Context, *GetModeRequest) (*Mode, error)
+	// SetMode allows or blocks a group for a duration, or resumes monitoring it. It needs an operator or admin key.
+	SetMode(context.Context, *SetModeRequest) (*Mode, error)
+	// GetUsage returns the usage of each group in its current window, or of one group.
+	GetUsage(context.Context, *GetUsageRequest) (*GetUsageResponse, error)
+	mustEmbedUnimplementedTubeTimeoutServer()
+}
+
+// UnimplementedTubeTimeoutServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTubeTimeoutServer struct{}
+
+func (UnimplementedTubeTimeoutServer) ListDevices(context.Context, *ListDevicesRequest) (*ListDevicesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListDevices not implemented")
+}
+func (UnimplementedTubeTimeoutServer) SetDeviceGroup(context.Context, *SetDeviceGroupRequest) (*Device, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetDeviceGroup not implemented")
+}
+func (UnimplementedTubeTimeoutServer) GetTrackerConfig(context.Context, *GetTrackerConfigRequest) (*GetTrackerConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTrackerConfig not implemented")
+}
+func (UnimplementedTubeTimeoutServer) SetTrackerConfig(context.Context, *SetTrackerConfigRequest) (*TrackerConfig, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetTrackerConfig not implemented")
+}
+func (UnimplementedTubeTimeoutServer) GetMode(context.Context, *GetModeRequest) (*Mode, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMode not implemented")
+}
+func (UnimplementedTubeTimeoutServer) SetMode(context.Context, *SetModeRequest) (*Mode, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetMode not implemented")
+}
+func (UnimplementedTubeTimeoutServer) GetUsage(context.Context, *GetUsageRequest) (*GetUsageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUsage not implemented")
+}
+func (UnimplementedTubeTimeoutServer) mustEmbedUnimplementedTubeTimeoutServer() {}
+func (UnimplementedTubeTimeoutServer) testEmbeddedByValue()                     {}
+
+// UnsafeTubeTimeoutServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TubeTimeoutServer will
+// result in compilation errors.
+type UnsafeTubeTimeoutServer interface {
+	mustEmbedUnimplementedTubeTimeoutServer()
+}
+
+func RegisterTubeTimeoutServer(s grpc.ServiceRegistrar, srv TubeTimeoutServer) {
+	// If the following call pancis, it indicates UnimplementedTubeTimeoutServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TubeTimeout_ServiceDesc, srv)
+}
+
+func _TubeTimeout_ListDevices_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListDevicesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TubeTimeoutServer).ListDevices(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TubeTimeout_ListDevices_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TubeTimeoutServer).ListDevices(ctx, req.(*ListDevicesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TubeTimeout_SetDeviceGroup_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetDeviceGroupRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TubeTimeoutServer).SetDeviceGroup(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TubeTimeout_SetDeviceGroup_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TubeTimeoutServer).SetDeviceGroup(ctx, req.(*SetDeviceGroupRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TubeTimeout_GetTrackerConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTrackerConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TubeTimeoutServer).GetTrackerConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TubeTimeout_GetTrackerConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TubeTimeoutServer).GetTrackerConfig(ctx, req.(*GetTrackerConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TubeTimeout_SetTrackerConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetTrackerConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TubeTimeoutServer).SetTrackerConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TubeTimeout_SetTrackerConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TubeTimeoutServer).SetTrackerConfig(ctx, req.(*SetTrackerConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TubeTimeout_GetMode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetModeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TubeTimeoutServer).GetMode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TubeTimeout_GetMode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TubeTimeoutServer).GetMode(ctx, req.(*GetModeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TubeTimeout_SetMode_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetModeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TubeTimeoutServer).SetMode(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TubeTimeout_SetMode_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TubeTimeoutServer).SetMode(ctx, req.(*SetModeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TubeTimeout_GetUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TubeTimeoutServer).GetUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TubeTimeout_GetUsage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TubeTimeoutServer).GetUsage(ctx, req.(*GetUsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// TubeTimeout_ServiceDesc is the grpc.ServiceDesc for TubeTimeout service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TubeTimeout_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "tubetimeout.v1.TubeTimeout",
+	HandlerType: (*TubeTimeoutServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListDevices",
+			Handler:    _TubeTimeout_ListDevices_Handler,
+		},
+		{
+			MethodName: "SetDeviceGroup",
+			Handler:    _TubeTimeout_SetDeviceGroup_Handler,
+		},
+		{
+			MethodName: "GetTrackerConfig",
+			Handler:    _TubeTimeout_GetTrackerConfig_Handler,
+		},
+		{
+			MethodName: "SetTrackerConfig",
+			Handler:    _TubeTimeout_SetTrackerConfig_Handler,
+		},
+		{
+			MethodName: "GetMode",
+			Handler:    _TubeTimeout_GetMode_Handler,
+		},
+		{
+			MethodName: "SetMode",
+			Handler:    _TubeTimeout_SetMode_Handler,
+		},
+		{
+			MethodName: "GetUsage",
+			Handler:    _TubeTimeout_GetUsage_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "tubetimeout.proto",
+}
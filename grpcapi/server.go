@@ -0,0 +1,462 @@
+// Package grpcapi serves a typed gRPC API alongside the web API, for automation such as home automation systems.
+// It makes its changes through the same control service as the web handlers, and is authorised by the same API keys.
+package grpcapi
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"relloyd/tubetimeout/apikeys"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/control"
+	"relloyd/tubetimeout/grpcapi/pb"
+	"relloyd/tubetimeout/models"
+)
+
+// UsageTracker returns the usage, config and modes of the groups' trackers.
+type UsageTracker interface {
+	GetSummary() map[string]*models.TrackerSummary
+	GetDeviceSummary() map[string]map[models.MAC]*models.TrackerSummary
+	GetConfig() (models.MapGroupTrackerConfig, error)
+	GetModeEndTime(id string) (models.TrackerMode, error)
+	HasExceededThreshold(id string) bool
+}
+
+// GroupMACs loads the devices in each group.
+type GroupMACs interface {
+	GetAllGroupMACs(logger *zap.SugaredLogger) ([]config.FlatGroupMAC, error)
+}
+
+// ConfigChanger validates, saves and audits the changes to device groups, tracker config and modes.
+type ConfigChanger interface {
+	SetDeviceGroup(o control.Origin, mac, group string, name *string) (config.FlatGroupMAC, error)
+	SaveGroupTrackerConfig(o control.Origin, c models.FlatTrackerConfig) (models.FlatTrackerConfig, error)
+	SetMode(o control.Origin, group string, d time.Duration, mode models.UsageTrackerMode) (models.TrackerMode, error)
+}
+
+// Monitor returns when each device was last active and the traffic of each group with each domain.
+type Monitor interface {
+	GetTrafficLastActiveTimes() map[models.Group]map[models.MAC]time.Time
+	GetDomainTraffic() map[models.Group]map[models.Domain]*models.DomainTraffic
+}
+
+// PlacementSource reports why each device seen on the network is in its groups.
+type PlacementSource interface {
+	Placements() map[models.MAC][]models.Placement
+}
+
+// APIKeyStore looks up the API keys that calls are authorised with.
+type APIKeyStore interface {
+	Lookup(token string) (apikeys.Key, bool)
+	HasRole(role apikeys.Role) bool
+}
+
+// Server implements pb.TubeTimeoutServer.
+type Server struct {
+	pb.UnimplementedTubeTimeoutServer
+	logger     *zap.SugaredLogger
+	tracker    UsageTracker
+	groupMACs  GroupMACs
+	monitor    Monitor
+	placements PlacementSource
+	apiKeys    APIKeyStore
+	changer    ConfigChanger
+}
+
+// NewServer returns a gRPC server for the API, serving TLS if tlsConfig isn't nil, e.g. the web server's config so
+// that both use the same certificate. Calls are authorised by the API key in their "authorization: Bearer <key>"
+// metadata.
+func NewServer(logger *zap.SugaredLogger, ut UsageTracker, gm GroupMACs, m Monitor, pl PlacementSource, keys APIKeyStore, ch ConfigChanger, tlsConfig *tls.Config) *grpc.Server {
+	s := &Server{logger: logger, tracker: ut, groupMACs: gm, monitor: m, placements: pl, apiKeys: keys, changer: ch}
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(s.authorise)}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig.Clone())))
+	}
+	gs := grpc.NewServer(opts...)
+	pb.RegisterTubeTimeoutServer(gs, s)
+	return gs
+}
+
+type apiKeyContextKey struct{}
+
+var (
+	// viewerMethods only read, so any key but a kiosk key may call them.
+	viewerMethods = map[string]bool{pb.TubeTimeout_ListDevices_FullMethodName: true, pb.TubeTimeout_GetTrackerConfig_FullMethodName: true,
+		pb.TubeTimeout_GetMode_FullMethodName: true, pb.TubeTimeout_GetUsage_FullMethodName: true}
+	// operatorMethods are the changes an operator can make: giving groups time, but not changing their config.
+	operatorMethods = map[string]bool{pb.TubeTimeout_SetMode_FullMethodName: true}
+)
+
+// roleAllows returns true if the role may call the method, by the same rules as the web API.
+func roleAllows(role apikeys.Role, method string) bool {
+	switch role {
+	case apikeys.RoleAdmin:
+		return true
+	case apikeys.RoleOperator:
+		return viewerMethods[method] || operatorMethods[method]
+	case apikeys.RoleViewer:
+		return viewerMethods[method]
+	default: // kiosk keys...
+		return false
+	}
+}
+
+// authorise checks the call's API key against the method. Calls without a key are passed through, unless
+// WEB_AUTH_REQUIRED is set and an admin key exists, as they are by the web server.
+func (s *Server) authorise(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	var token string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get("authorization"); len(v) > 0 {
+			token = strings.TrimSpace(strings.TrimPrefix(v[0], "Bearer "))
+		}
+	}
+	if token == "" { // if this isn't an API key call...
+		if config.Current().WebConfig.AuthRequired && s.apiKeys.HasRole(apikeys.RoleAdmin) {
+			return nil, status.Error(codes.Unauthenticated, "API key required")
+		}
+		return handler(ctx, req)
+	}
+	key, ok := s.apiKeys.Lookup(token)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid API key")
+	}
+	if !roleAllows(key.Role, info.FullMethod) {
+		return nil, status.Error(codes.PermissionDenied, "API key is not allowed to call this method")
+	}
+	return handler(context.WithValue(ctx, apiKeyContextKey{}, key), req)
+}
+
+// origin returns where the call came from, for the audit log.
+func origin(ctx context.Context) control.Origin {
+	var o control.Origin
+	if p, ok := peer.FromContext(ctx); ok {
+		o.SourceIP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(o.SourceIP); err == nil {
+			o.SourceIP = host
+		}
+	}
+	if key, ok := ctx.Value(apiKeyContextKey{}).(apikeys.Key); ok {
+		o.Actor = fmt.Sprintf("%v (%v)", key.Name, key.ID)
+	}
+	return o
+}
+
+// changeError returns the status of a failed change: InvalidArgument or NotFound if the request was at fault, else
+// Internal with the error logged.
+func (s *Server) changeError(err error, msg string) error {
+	switch {
+	case errors.Is(err, control.ErrInvalidChange):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, models.ErrGroupNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	}
+	s.logger.Errorf("Error via gRPC, %v: %v", msg, err)
+	return status.Error(codes.Internal, msg)
+}
+
+// ListDevices returns the devices, grouped ones first, sorted by group and MAC.
+func (s *Server) ListDevices(_ context.Context, req *pb.ListDevicesRequest) (*pb.ListDevicesResponse, error) {
+	gm, err := s.groupMACs.GetAllGroupMACs(s.logger)
+	if err != nil {
+		s.logger.Errorf("Error getting device group data: %v", err)
+		return nil, status.Error(codes.Internal, "failed to get the devices")
+	}
+	slices.SortStableFunc(gm, func(a, b config.FlatGroupMAC) int {
+		if (a.Group == "") != (b.Group == "") { // if only one is ungrouped, put it last...
+			return strings.Compare(b.Group, a.Group)
+		}
+		return cmp.Or(strings.Compare(a.Group, b.Group), strings.Compare(a.MAC, b.MAC))
+	})
+	placements := s.placements.Placements()
+	resp := &pb.ListDevicesResponse{}
+	for _, d := range gm {
+		if req.GetGroup() != "" && d.Group != req.GetGroup() {
+			continue
+		}
+		resp.Devices = append(resp.Devices, toDevice(d, placements[models.MAC(d.MAC)]))
+	}
+	return resp, nil
+}
+
+// SetDeviceGroup saves the device in its new group, keeping the rest of the devices as they are.
+func (s *Server) SetDeviceGroup(ctx context.Context, req *pb.SetDeviceGroupRequest) (*pb.Device, error) {
+	d, err := s.changer.SetDeviceGroup(origin(ctx), req.GetMac(), req.GetGroup(), req.Name)
+	if err != nil {
+		return nil, s.changeError(err, "failed to save the devices")
+	}
+	return toDevice(d, s.placements.Placements()[models.MAC(d.MAC)]), nil
+}
+
+// GetTrackerConfig returns the tracker config of each group, sorted by group.
+func (s *Server) GetTrackerConfig(context.Context, *pb.GetTrackerConfigRequest) (*pb.GetTrackerConfigResponse, error) {
+	gtc, err := s.tracker.GetConfig()
+	if err != nil {
+		s.logger.Errorf("Failed to get tracker config: %v", err)
+		return nil, status.Error(codes.Internal, "failed to get the tracker config")
+	}
+	resp := &pb.GetTrackerConfigResponse{}
+	for _, group := range slices.Sorted(maps.Keys(gtc)) {
+		resp.Groups = append(resp.Groups, toTrackerConfig(control.ToFlatTrackerConfig(group, gtc[group])))
+	}
+	return resp, nil
+}
+
+// SetTrackerConfig saves the group's config with the rest of the groups.
+func (s *Server) SetTrackerConfig(ctx context.Context, req *pb.SetTrackerConfigRequest) (*pb.TrackerConfig, error) {
+	saved, err := s.changer.SaveGroupTrackerConfig(origin(ctx), fromTrackerConfig(req.GetConfig()))
+	if err != nil {
+		return nil, s.changeError(err, "failed to save the tracker config")
+	}
+	return toTrackerConfig(saved), nil
+}
+
+// GetMode returns the group's mode.
+func (s *Server) GetMode(_ context.Context, req *pb.GetModeRequest) (*pb.Mode, error) {
+	m, err := s.mode(req.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	return toMode(req.GetGroup(), m), nil
+}
+
+// SetMode allows or blocks the group for the request's duration, or resumes monitoring it.
+func (s *Server) SetMode(ctx context.Context, req *pb.SetModeRequest) (*pb.Mode, error) {
+	mode := models.UsageTrackerMode(req.GetMode())
+	after, err := s.changer.SetMode(origin(ctx), req.GetGroup(), req.GetDuration().AsDuration(), mode)
+	if err != nil {
+		return nil, s.changeError(err, "failed to set the mode")
+	}
+	s.logger.Infof("Usage tracker for group %v set to mode %v via gRPC", req.GetGroup(), mode)
+	return toMode(req.GetGroup(), after), nil
+}
+
+// mode returns the group's mode or a NotFound error.
+func (s *Server) mode(group string) (models.TrackerMode, error) {
+	if group == "" {
+		return models.TrackerMode{}, status.Error(codes.InvalidArgument, "invalid group")
+	}
+	m, err := s.tracker.GetModeEndTime(group)
+	if errors.Is(err, models.ErrGroupNotFound) {
+		return models.TrackerMode{}, status.Errorf(codes.NotFound, "group %v not found", group)
+	} else if err != nil {
+		s.logger.Errorf("Error getting group mode end time: %v", err)
+		return models.TrackerMode{}, status.Error(codes.Internal, "failed to get the mode")
+	}
+	return m, nil
+}
+
+// GetUsage returns the usage of the groups, sorted by group, with the last active times and usage of their devices
+// and their traffic with each domain.
+func (s *Server) GetUsage(_ context.Context, req *pb.GetUsageRequest) (*pb.GetUsageResponse, error) {
+	summary := s.tracker.GetSummary()
+	if req.GetGroup() != "" {
+		if _, ok := summary[req.GetGroup()]; !ok {
+			return nil, status.Errorf(codes.NotFound, "group %v not found", req.GetGroup())
+		}
+	}
+	lastActive := s.monitor.GetTrafficLastActiveTimes()
+	devices := s.tracker.GetDeviceSummary()
+	domains := s.monitor.GetDomainTraffic()
+	resp := &pb.GetUsageResponse{}
+	for _, group := range slices.Sorted(maps.Keys(summary)) {
+		if req.GetGroup() != "" && group != req.GetGroup() {
+			continue
+		}
+		gs := summary[group]
+		gs.Domains = domains[models.Group(group)]
+		u := toGroupUsage(group, gs, lastActive[models.Group(group)], devices[group])
+		u.Exceeded = s.tracker.HasExceededThreshold(group)
+		resp.Groups = append(resp.Groups, u)
+	}
+	return resp, nil
+}
+
+func toDevice(d config.FlatGroupMAC, placements []models.Placement) *pb.Device {
+	dev := &pb.Device{Group: d.Group, Mac: d.MAC, Name: d.Name, Identity: d.Identity}
+	for _, p := range placements { // find why the device is in its effective group, like the web handlers do...
+		if string(p.Group) == d.Group || (d.Group == "" && p.AssignedBy == models.AssignedByDefault) {
+			dev.Placement = &pb.Placement{Group: string(p.Group), AssignedBy: toAssignedBy(p.AssignedBy), Since: toTimestamp(p.Since)}
+			break
+		}
+	}
+	return dev
+}
+
+func toAssignedBy(a models.AssignedBy) pb.AssignedBy {
+	switch a {
+	case models.AssignedByManual:
+		return pb.AssignedBy_ASSIGNED_BY_MANUAL
+	case models.AssignedByDefault:
+		return pb.AssignedBy_ASSIGNED_BY_DEFAULT
+	case models.AssignedByIdentity:
+		return pb.AssignedBy_ASSIGNED_BY_IDENTITY
+	case models.AssignedByGuest:
+		return pb.AssignedBy_ASSIGNED_BY_GUEST
+	}
+	return pb.AssignedBy_ASSIGNED_BY_UNSPECIFIED
+}
+
+func toTrackerConfig(c models.FlatTrackerConfig) *pb.TrackerConfig {
+	tc := &pb.TrackerConfig{
+		Group:             string(c.Group),
+		Retention:         durationpb.New(c.Retention),
+		Threshold:         durationpb.New(c.Threshold),
+		StartDay:          int32(c.StartDayInt),
+		StartDayOfMonth:   int32(c.StartDayOfMonth),
+		Window:            string(c.Window),
+		StartTime:         durationpb.New(c.StartDuration),
+		CountFrom:         durationpb.New(c.CountFrom),
+		CountUntil:        durationpb.New(c.CountUntil),
+		BlockOutsideHours: c.BlockOutsideHours,
+		MaxSession:        durationpb.New(c.MaxSession),
+		BreakDuration:     durationpb.New(c.BreakDuration),
+		MinActive:         durationpb.New(c.MinActive),
+		MinActiveWindow:   durationpb.New(c.MinActiveWindow),
+		PacketSampling:    c.PacketSampling,
+		Rollover:          string(c.Rollover),
+		RolloverCap:       durationpb.New(c.RolloverCap),
+		WarnAt:            int32(c.WarnAt),
+		GracePeriod:       durationpb.New(c.GracePeriod),
+		Allowlist:         c.Allowlist,
+	}
+	for _, dt := range c.DayThresholds {
+		days := make([]int32, len(dt.Days))
+		for i, d := range dt.Days {
+			days[i] = int32(d)
+		}
+		tc.DayThresholds = append(tc.DayThresholds, &pb.DayThreshold{Days: days, Threshold: durationpb.New(dt.Threshold)})
+	}
+	if p := c.PacketPolicy; p != nil {
+		tc.PacketPolicy = &pb.PacketPolicy{
+			DropPercentage:   p.DropPercentage,
+			DelayPercentage:  p.DelayPercentage,
+			Delay:            durationpb.New(p.Delay),
+			Jitter:           durationpb.New(p.Jitter),
+			DropUdp:          p.DropUDP,
+			RateLimitKbps:    int32(p.RateLimitKbps),
+			UdpRateLimitKbps: int32(p.UDPRateLimitKbps),
+		}
+	}
+	for _, cat := range c.Categories {
+		tc.Categories = append(tc.Categories, &pb.Category{Name: cat.Name, Domains: cat.Domains, Sni: cat.SNI, Threshold: durationpb.New(cat.Threshold), Unlimited: cat.Unlimited})
+	}
+	return tc
+}
+
+// fromTrackerConfig returns the tracker config of tc as the web API takes it, so that the control service saves the
+// same config for both APIs.
+func fromTrackerConfig(tc *pb.TrackerConfig) models.FlatTrackerConfig {
+	c := models.FlatTrackerConfig{
+		Group:             models.Group(tc.GetGroup()),
+		Retention:         tc.GetRetention().AsDuration(),
+		Threshold:         tc.GetThreshold().AsDuration(),
+		StartDayInt:       int(tc.GetStartDay()),
+		StartDayOfMonth:   int(tc.GetStartDayOfMonth()),
+		Window:            models.TrackerWindow(tc.GetWindow()),
+		StartDuration:     tc.GetStartTime().AsDuration(),
+		CountFrom:         tc.GetCountFrom().AsDuration(),
+		CountUntil:        tc.GetCountUntil().AsDuration(),
+		BlockOutsideHours: tc.GetBlockOutsideHours(),
+		MaxSession:        tc.GetMaxSession().AsDuration(),
+		BreakDuration:     tc.GetBreakDuration().AsDuration(),
+		MinActive:         tc.GetMinActive().AsDuration(),
+		MinActiveWindow:   tc.GetMinActiveWindow().AsDuration(),
+		PacketSampling:    tc.GetPacketSampling(),
+		Rollover:          models.RolloverPolicy(tc.GetRollover()),
+		RolloverCap:       tc.GetRolloverCap().AsDuration(),
+		WarnAt:            int(tc.GetWarnAt()),
+		GracePeriod:       tc.GetGracePeriod().AsDuration(),
+		Allowlist:         tc.GetAllowlist(),
+	}
+	for _, dt := range tc.GetDayThresholds() {
+		days := make([]time.Weekday, len(dt.GetDays()))
+		for i, d := range dt.GetDays() {
+			days[i] = time.Weekday(d)
+		}
+		c.DayThresholds = append(c.DayThresholds, models.DayThreshold{Days: days, Threshold: dt.GetThreshold().AsDuration()})
+	}
+	if p := tc.GetPacketPolicy(); p != nil {
+		c.PacketPolicy = &models.PacketPolicy{
+			DropPercentage:   p.GetDropPercentage(),
+			DelayPercentage:  p.GetDelayPercentage(),
+			Delay:            p.GetDelay().AsDuration(),
+			Jitter:           p.GetJitter().AsDuration(),
+			DropUDP:          p.GetDropUdp(),
+			RateLimitKbps:    int(p.GetRateLimitKbps()),
+			UDPRateLimitKbps: int(p.GetUdpRateLimitKbps()),
+		}
+	}
+	for _, cat := range tc.GetCategories() {
+		c.Categories = append(c.Categories, models.Category{Name: cat.GetName(), Domains: cat.GetDomains(), SNI: cat.GetSni(), Threshold: cat.GetThreshold().AsDuration(), Unlimited: cat.GetUnlimited()})
+	}
+	return c
+}
+
+func toMode(group string, m models.TrackerMode) *pb.Mode {
+	mode := &pb.Mode{Group: group, Mode: pb.TrackerMode(m.Mode)}
+	if m.Mode != models.ModeMonitor {
+		mode.EndTime = toTimestamp(m.ModeEndTime)
+	}
+	return mode
+}
+
+func toGroupUsage(group string, s *models.TrackerSummary, lastActive map[models.MAC]time.Time, devices map[models.MAC]*models.TrackerSummary) *pb.GroupUsage {
+	u := &pb.GroupUsage{
+		Group:             group,
+		UsedMinutes:       int32(s.Used),
+		ThresholdMinutes:  int32(s.Threshold),
+		Percentage:        int32(s.Percentage),
+		AdjustmentMinutes: int32(s.Adjustment),
+		CarriedMinutes:    int32(s.Carried),
+		OutsideHours:      s.OutsideHours,
+		SessionMinutes:    int32(s.SessionMinutes),
+		Warning:           s.Warning,
+	}
+	if s.BreakEndTime != nil {
+		u.BreakEndTime = toTimestamp(*s.BreakEndTime)
+	}
+	macs := make(map[models.MAC]bool)
+	for mac := range lastActive {
+		macs[mac] = true
+	}
+	for mac := range devices {
+		macs[mac] = true
+	}
+	for _, mac := range slices.Sorted(maps.Keys(macs)) {
+		d := &pb.DeviceUsage{Mac: string(mac), LastActive: toTimestamp(lastActive[mac])}
+		if ds, ok := devices[mac]; ok {
+			d.UsedMinutes = int32(ds.Used)
+		}
+		u.Devices = append(u.Devices, d)
+	}
+	for _, domain := range slices.Sorted(maps.Keys(s.Domains)) {
+		du := s.Domains[domain]
+		u.Domains = append(u.Domains, &pb.DomainUsage{Domain: string(domain), IngressBytes: du.IngressBytes, EgressBytes: du.EgressBytes, LastSeen: toTimestamp(du.LastSeen)})
+	}
+	return u
+}
+
+// toTimestamp returns nil for the zero time, so that unknown times are left out.
+func toTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
@@ -0,0 +1,370 @@
+package grpcapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"relloyd/tubetimeout/apikeys"
+	"relloyd/tubetimeout/audit"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/control"
+	"relloyd/tubetimeout/grpcapi/pb"
+	"relloyd/tubetimeout/models"
+	"relloyd/tubetimeout/web"
+)
+
+type mockTracker struct {
+	summary map[string]*models.TrackerSummary
+	devices map[string]map[models.MAC]*models.TrackerSummary
+	cfg     models.MapGroupTrackerConfig
+	modes   map[string]models.TrackerMode
+}
+
+func (m *mockTracker) GetSummary() map[string]*models.TrackerSummary { return m.summary }
+func (m *mockTracker) GetDeviceSummary() map[string]map[models.MAC]*models.TrackerSummary {
+	return m.devices
+}
+func (m *mockTracker) GetConfig() (models.MapGroupTrackerConfig, error) { return m.cfg, nil }
+func (m *mockTracker) SetConfig(c models.MapGroupTrackerConfig) error {
+	m.cfg = c
+	return nil
+}
+func (m *mockTracker) GetModeEndTime(id string) (models.TrackerMode, error) {
+	mode, ok := m.modes[id]
+	if !ok {
+		return models.TrackerMode{}, models.ErrGroupNotFound
+	}
+	return mode, nil
+}
+func (m *mockTracker) SetMode(id string, d time.Duration, mode models.UsageTrackerMode) error {
+	m.modes[id] = models.TrackerMode{Mode: mode, ModeEndTime: time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC).Add(d)}
+	return nil
+}
+func (m *mockTracker) HasExceededThreshold(id string) bool { return id == "kids" }
+
+type mockGroupMACs struct {
+	macs []config.FlatGroupMAC
+}
+
+func (m *mockGroupMACs) GetAllGroupMACs(*zap.SugaredLogger) ([]config.FlatGroupMAC, error) {
+	return m.macs, nil
+}
+func (m *mockGroupMACs) SaveGroupMACs(_ *zap.SugaredLogger, macs []config.FlatGroupMAC) error {
+	m.macs = macs
+	return nil
+}
+
+type mockMonitor struct {
+	lastActive map[models.Group]map[models.MAC]time.Time
+	domains    map[models.Group]map[models.Domain]*models.DomainTraffic
+}
+
+func (m mockMonitor) GetTrafficLastActiveTimes() map[models.Group]map[models.MAC]time.Time {
+	return m.lastActive
+}
+func (m mockMonitor) GetDomainTraffic() map[models.Group]map[models.Domain]*models.DomainTraffic {
+	return m.domains
+}
+
+type mockPlacements map[models.MAC][]models.Placement
+
+func (m mockPlacements) Placements() map[models.MAC][]models.Placement { return m }
+
+type mockKeys map[string]apikeys.Key
+
+func (m mockKeys) Lookup(token string) (apikeys.Key, bool) {
+	k, ok := m[token]
+	return k, ok
+}
+func (m mockKeys) HasRole(role apikeys.Role) bool {
+	for _, k := range m {
+		if k.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+type mockAuditLog struct {
+	entries []audit.Entry
+}
+
+func (m *mockAuditLog) Record(e audit.Entry) error {
+	m.entries = append(m.entries, e)
+	return nil
+}
+
+// newTestClient serves the API over an in-memory connection and returns a client for it. Changes are made by the
+// control service, as they are for the web API.
+func newTestClient(t *testing.T, ut *mockTracker, gm *mockGroupMACs, al *mockAuditLog) pb.TubeTimeoutClient {
+	keys := mockKeys{
+		"admin-token":  {ID: "a1", Name: "admin", Role: apikeys.RoleAdmin},
+		"viewer-token": {ID: "v1", Name: "viewer", Role: apikeys.RoleViewer},
+		"op-token":     {ID: "o1", Name: "operator", Role: apikeys.RoleOperator},
+		"kiosk-token":  {ID: "k1", Name: "kiosk", Role: apikeys.RoleKiosk, Group: "kids"},
+	}
+	mon := mockMonitor{
+		lastActive: map[models.Group]map[models.MAC]time.Time{"kids": {"AA-BB-CC-DD-EE-FF": time.Date(2025, 3, 10, 11, 0, 0, 0, time.UTC)}},
+		domains: map[models.Group]map[models.Domain]*models.DomainTraffic{"kids": {
+			"ytimg.com":       {IngressBytes: 2000, EgressBytes: 100},
+			"googlevideo.com": {IngressBytes: 90000, EgressBytes: 3000, LastSeen: time.Date(2025, 3, 10, 11, 0, 0, 0, time.UTC)},
+		}},
+	}
+	placements := mockPlacements{"AA-BB-CC-DD-EE-FF": {{Group: "kids", AssignedBy: models.AssignedByManual}}}
+	ch := control.NewService(config.MustGetLogger(), ut, gm, al)
+	gs := NewServer(config.MustGetLogger(), ut, gm, mon, placements, keys, ch, nil)
+	lis := bufconn.Listen(1 << 20)
+	go func() { _ = gs.Serve(lis) }()
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return pb.NewTubeTimeoutClient(conn)
+}
+
+func withKey(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func TestServer_Auth(t *testing.T) {
+	orig := config.AppCfg.WebConfig.AuthRequired
+	t.Cleanup(func() { config.AppCfg.WebConfig.AuthRequired = orig })
+	tracker := &mockTracker{modes: map[string]models.TrackerMode{"kids": {}}}
+	c := newTestClient(t, tracker, &mockGroupMACs{}, &mockAuditLog{})
+
+	config.AppCfg.WebConfig.AuthRequired = false
+	_, err := c.GetMode(context.Background(), &pb.GetModeRequest{Group: "kids"})
+	assert.NoError(t, err, "expected calls without a key to be allowed unless auth is required")
+
+	config.AppCfg.WebConfig.AuthRequired = true
+	_, err = c.GetMode(context.Background(), &pb.GetModeRequest{Group: "kids"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err), "expected a key to be required once an admin key exists")
+	_, err = c.GetMode(withKey("bad-token"), &pb.GetModeRequest{Group: "kids"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = c.GetMode(withKey("kiosk-token"), &pb.GetModeRequest{Group: "kids"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "expected kiosk keys to be refused")
+
+	setMode := &pb.SetModeRequest{Group: "kids", Mode: pb.TrackerMode_TRACKER_MODE_ALLOW, Duration: durationpb.New(30 * time.Minute)}
+	_, err = c.GetMode(withKey("viewer-token"), &pb.GetModeRequest{Group: "kids"})
+	assert.NoError(t, err)
+	_, err = c.SetMode(withKey("viewer-token"), setMode)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "expected viewers not to change anything")
+	_, err = c.SetMode(withKey("op-token"), setMode)
+	assert.NoError(t, err, "expected operators to give groups time")
+	_, err = c.SetTrackerConfig(withKey("op-token"), &pb.SetTrackerConfigRequest{Config: &pb.TrackerConfig{Group: "kids"}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err), "expected operators not to change the config")
+	_, err = c.SetDeviceGroup(withKey("op-token"), &pb.SetDeviceGroupRequest{Mac: "AA-BB-CC-DD-EE-FF", Group: "kids"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestServer_Devices(t *testing.T) {
+	gm := &mockGroupMACs{macs: []config.FlatGroupMAC{
+		{MAC: "11-22-33-44-55-66", Name: "tv"},
+		{Group: "kids", MAC: "AA-BB-CC-DD-EE-FF", Name: "phone"},
+	}}
+	al := &mockAuditLog{}
+	c := newTestClient(t, &mockTracker{}, gm, al)
+
+	resp, err := c.ListDevices(withKey("viewer-token"), &pb.ListDevicesRequest{})
+	require.NoError(t, err)
+	if assert.Len(t, resp.GetDevices(), 2) {
+		d := resp.GetDevices()[0]
+		assert.Equal(t, "kids", d.GetGroup(), "expected grouped devices first")
+		assert.Equal(t, pb.AssignedBy_ASSIGNED_BY_MANUAL, d.GetPlacement().GetAssignedBy())
+		assert.Nil(t, resp.GetDevices()[1].GetPlacement())
+	}
+	resp, err = c.ListDevices(withKey("viewer-token"), &pb.ListDevicesRequest{Group: "kids"})
+	require.NoError(t, err)
+	assert.Len(t, resp.GetDevices(), 1)
+
+	d, err := c.SetDeviceGroup(withKey("admin-token"), &pb.SetDeviceGroupRequest{Mac: "11:22:33:44:55:66", Group: "kids"})
+	require.NoError(t, err)
+	assert.Equal(t, "kids", d.GetGroup())
+	assert.Equal(t, "11-22-33-44-55-66", d.GetMac())
+	assert.Equal(t, "tv", d.GetName(), "expected the name to be kept")
+	assert.ElementsMatch(t, []config.FlatGroupMAC{
+		{Group: "kids", MAC: "11-22-33-44-55-66", Name: "tv"},
+		{Group: "kids", MAC: "AA-BB-CC-DD-EE-FF", Name: "phone"},
+	}, gm.macs, "expected the other devices to be kept")
+	if assert.Len(t, al.entries, 1) {
+		assert.Equal(t, "groupMACs.save", al.entries[0].Action)
+		assert.Equal(t, "admin (a1)", al.entries[0].Actor)
+	}
+
+	_, err = c.SetDeviceGroup(withKey("admin-token"), &pb.SetDeviceGroupRequest{Mac: "not-a-mac", Group: "kids"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer_TrackerConfig(t *testing.T) {
+	blockedUntil := time.Date(2025, 3, 10, 13, 0, 0, 0, time.UTC)
+	tracker := &mockTracker{cfg: models.MapGroupTrackerConfig{
+		"kids":  {Threshold: time.Hour, PacketPolicy: &models.PacketPolicy{DropPercentage: 0.5}, Allowlist: []string{"bbc.co.uk"}, Mode: models.ModeBlock, ModeEndTime: blockedUntil},
+		"teens": {Threshold: 2 * time.Hour},
+	}}
+	c := newTestClient(t, tracker, &mockGroupMACs{}, &mockAuditLog{})
+
+	resp, err := c.GetTrackerConfig(withKey("viewer-token"), &pb.GetTrackerConfigRequest{})
+	require.NoError(t, err)
+	if assert.Len(t, resp.GetGroups(), 2) {
+		assert.Equal(t, "kids", resp.GetGroups()[0].GetGroup())
+		assert.Equal(t, time.Hour, resp.GetGroups()[0].GetThreshold().AsDuration())
+		assert.Equal(t, []string{"bbc.co.uk"}, resp.GetGroups()[0].GetAllowlist())
+		assert.Equal(t, float32(0.5), resp.GetGroups()[0].GetPacketPolicy().GetDropPercentage())
+	}
+
+	tc, err := c.SetTrackerConfig(withKey("admin-token"), &pb.SetTrackerConfigRequest{Config: &pb.TrackerConfig{
+		Group:         "kids",
+		Threshold:     durationpb.New(90 * time.Minute),
+		DayThresholds: []*pb.DayThreshold{{Days: []int32{0, 6}, Threshold: durationpb.New(3 * time.Hour)}},
+		Categories:    []*pb.Category{{Name: "games", Domains: []string{"roblox.com"}, Threshold: durationpb.New(time.Hour)}},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Minute, tc.GetThreshold().AsDuration())
+	assert.Len(t, tc.GetCategories(), 1)
+	assert.Equal(t, 90*time.Minute, tracker.cfg["kids"].Threshold)
+	assert.Equal(t, []models.DayThreshold{{Days: []time.Weekday{time.Sunday, time.Saturday}, Threshold: 3 * time.Hour}}, tracker.cfg["kids"].DayThresholds)
+	assert.Equal(t, []models.Category{{Name: "games", Domains: []string{"roblox.com"}, Threshold: time.Hour}}, tracker.cfg["kids"].Categories)
+	assert.Nil(t, tracker.cfg["kids"].PacketPolicy, "expected the packet policy to be cleared, as the web API does")
+	assert.Equal(t, models.ModeBlock, tracker.cfg["kids"].Mode, "expected the mode to be kept")
+	assert.Equal(t, blockedUntil, tracker.cfg["kids"].ModeEndTime)
+	assert.Equal(t, 2*time.Hour, tracker.cfg["teens"].Threshold, "expected the other groups to be kept")
+
+	_, err = c.SetTrackerConfig(withKey("admin-token"), &pb.SetTrackerConfigRequest{Config: &pb.TrackerConfig{}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestServer_Mode(t *testing.T) {
+	tracker := &mockTracker{modes: map[string]models.TrackerMode{"kids": {}}}
+	al := &mockAuditLog{}
+	c := newTestClient(t, tracker, &mockGroupMACs{}, al)
+
+	m, err := c.GetMode(withKey("viewer-token"), &pb.GetModeRequest{Group: "kids"})
+	require.NoError(t, err)
+	assert.Equal(t, pb.TrackerMode_TRACKER_MODE_MONITOR, m.GetMode())
+	assert.Nil(t, m.GetEndTime())
+	_, err = c.GetMode(withKey("viewer-token"), &pb.GetModeRequest{Group: "nobody"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	m, err = c.SetMode(withKey("op-token"), &pb.SetModeRequest{Group: "kids", Mode: pb.TrackerMode_TRACKER_MODE_BLOCK, Duration: durationpb.New(30 * time.Minute)})
+	require.NoError(t, err)
+	assert.Equal(t, pb.TrackerMode_TRACKER_MODE_BLOCK, m.GetMode())
+	assert.Equal(t, time.Date(2025, 3, 10, 12, 30, 0, 0, time.UTC), m.GetEndTime().AsTime())
+
+	_, err = c.SetMode(withKey("op-token"), &pb.SetModeRequest{Group: "kids", Mode: pb.TrackerMode_TRACKER_MODE_ALLOW})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err), "expected a duration to be needed to allow a group")
+	_, err = c.SetMode(withKey("op-token"), &pb.SetModeRequest{Group: "kids", Mode: 7, Duration: durationpb.New(time.Minute)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	m, err = c.SetMode(withKey("op-token"), &pb.SetModeRequest{Group: "kids", Mode: pb.TrackerMode_TRACKER_MODE_MONITOR})
+	require.NoError(t, err)
+	assert.Equal(t, pb.TrackerMode_TRACKER_MODE_MONITOR, m.GetMode())
+	if assert.Len(t, al.entries, 2) {
+		assert.Equal(t, "mode.set", al.entries[0].Action)
+		assert.Equal(t, "mode.resume", al.entries[1].Action)
+		assert.Equal(t, "kids", al.entries[1].Target)
+	}
+}
+
+func TestServer_Usage(t *testing.T) {
+	tracker := &mockTracker{
+		summary: map[string]*models.TrackerSummary{
+			"kids":  {Used: 60, Threshold: 60, Percentage: 100},
+			"teens": {Used: 10, Threshold: 120, Percentage: 8},
+		},
+		devices: map[string]map[models.MAC]*models.TrackerSummary{"kids": {"AA-BB-CC-DD-EE-FF": {Used: 45}}},
+	}
+	c := newTestClient(t, tracker, &mockGroupMACs{}, &mockAuditLog{})
+
+	resp, err := c.GetUsage(withKey("viewer-token"), &pb.GetUsageRequest{})
+	require.NoError(t, err)
+	if assert.Len(t, resp.GetGroups(), 2) {
+		kids := resp.GetGroups()[0]
+		assert.Equal(t, "kids", kids.GetGroup())
+		assert.Equal(t, int32(60), kids.GetUsedMinutes())
+		assert.True(t, kids.GetExceeded())
+		if assert.Len(t, kids.GetDevices(), 1) {
+			assert.Equal(t, int32(45), kids.GetDevices()[0].GetUsedMinutes())
+			assert.Equal(t, time.Date(2025, 3, 10, 11, 0, 0, 0, time.UTC), kids.GetDevices()[0].GetLastActive().AsTime())
+		}
+		if assert.Len(t, kids.GetDomains(), 2) {
+			assert.Equal(t, "googlevideo.com", kids.GetDomains()[0].GetDomain(), "expected the domains to be sorted")
+			assert.Equal(t, int64(90000), kids.GetDomains()[0].GetIngressBytes())
+			assert.Equal(t, int64(3000), kids.GetDomains()[0].GetEgressBytes())
+			assert.Nil(t, kids.GetDomains()[1].GetLastSeen())
+		}
+		assert.False(t, resp.GetGroups()[1].GetExceeded())
+	}
+
+	resp, err = c.GetUsage(withKey("viewer-token"), &pb.GetUsageRequest{Group: "teens"})
+	require.NoError(t, err)
+	assert.Len(t, resp.GetGroups(), 1)
+	_, err = c.GetUsage(withKey("viewer-token"), &pb.GetUsageRequest{Group: "nobody"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestServer_SetTrackerConfigMatchesWebAPI(t *testing.T) {
+	orig := config.AppCfg.WebConfig.AuthRequired
+	t.Cleanup(func() { config.AppCfg.WebConfig.AuthRequired = orig })
+	config.AppCfg.WebConfig.AuthRequired = false
+	blockedUntil := time.Date(2025, 3, 10, 13, 0, 0, 0, time.UTC)
+	newTracker := func() *mockTracker {
+		return &mockTracker{cfg: models.MapGroupTrackerConfig{"kids": {Threshold: time.Hour, Mode: models.ModeBlock, ModeEndTime: blockedUntil}}}
+	}
+	want := models.FlatTrackerConfig{
+		Group:           "kids",
+		Retention:       31 * 24 * time.Hour,
+		Threshold:       90 * time.Minute,
+		DayThresholds:   []models.DayThreshold{{Days: []time.Weekday{time.Saturday}, Threshold: 3 * time.Hour}},
+		StartDayOfMonth: 15,
+		Window:          models.WindowMonthly,
+		CountFrom:       7 * time.Hour,
+		CountUntil:      21 * time.Hour,
+		MaxSession:      time.Hour,
+		BreakDuration:   15 * time.Minute,
+		Rollover:        models.RolloverCapped,
+		RolloverCap:     30 * time.Minute,
+		WarnAt:          80,
+		GracePeriod:     5 * time.Minute,
+		PacketPolicy:    &models.PacketPolicy{DropPercentage: 0.2, Delay: 100 * time.Millisecond, DropUDP: true, RateLimitKbps: 512},
+		Allowlist:       []string{"bbc.co.uk"},
+		Categories:      []models.Category{{Name: "games", Domains: []string{"roblox.com"}, SNI: []string{"*.roblox.com"}, Threshold: time.Hour}},
+		Mode:            models.ModeAllow, // a stale mode from the dashboard shouldn't change the group's mode.
+	}
+
+	webTracker := newTracker()
+	ws := web.NewServer(config.MustGetLogger(), web.ServerDeps{
+		Control: control.NewService(config.MustGetLogger(), webTracker, &mockGroupMACs{}, &mockAuditLog{}),
+	})
+	body, err := json.Marshal([]models.FlatTrackerConfig{want})
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	ws.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/trackerConfig", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	grpcTracker := newTracker()
+	c := newTestClient(t, grpcTracker, &mockGroupMACs{}, &mockAuditLog{})
+	_, err = c.SetTrackerConfig(withKey("admin-token"), &pb.SetTrackerConfigRequest{Config: toTrackerConfig(want)})
+	require.NoError(t, err)
+
+	assert.Equal(t, webTracker.cfg, grpcTracker.cfg, "expected both APIs to store the same config")
+	assert.Equal(t, models.ModeBlock, grpcTracker.cfg["kids"].Mode)
+}
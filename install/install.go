@@ -0,0 +1,123 @@
+// Package install sets the device up to run the app as a systemd service, in place of the manual steps in the
+// README: it checks that the commands the app runs are installed, writes the unit and the sysctl settings that turn
+// on IPv4 forwarding, and enables the service. Uninstall removes them again.
+package install
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/dhcp"
+	"relloyd/tubetimeout/group"
+)
+
+const serviceName = "tubetimeout"
+
+var (
+	unitPath   = "/etc/systemd/system/" + serviceName + ".service"
+	sysctlPath = "/etc/sysctl.d/90-" + serviceName + ".conf"
+	fnCheckCmd = config.CheckCmdAvailability
+)
+
+// unitTemplate is the systemd unit, with the binary to run. It matches services/tubetimeout.service.
+const unitTemplate = `[Unit]
+Description=tubetimeout
+After=network.target
+
+[Service]
+ExecStart=%v
+ExecReload=/bin/kill -HUP $MAINPID
+Environment=LOG_LEVEL=info
+EnvironmentFile=-/root/.tubetimeout/tubetimeout.env
+WorkingDirectory=/root
+Restart=always
+WatchdogSec=60
+NotifyAccess=main
+User=root
+Group=root
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// sysctlSettings lets the device forward the traffic of the devices that it's the gateway for.
+const sysctlSettings = `# Written by tubetimeout install. Removed by tubetimeout install -uninstall.
+net.ipv4.ip_forward=1
+`
+
+// CheckDependencies returns an error naming each command that the app runs but that isn't on the PATH.
+func CheckDependencies() error {
+	var errs []error
+	for _, cmd := range slices.Concat(group.RequiredCommands(), dhcp.RequiredCommands()) {
+		if err := fnCheckCmd(cmd); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Install checks the dependencies, then writes the systemd unit to run binary and the sysctl settings, applies the
+// settings and enables the service to start at boot. It reports each step to w. The service isn't started, so that
+// the config can be set first. Installing again replaces the files.
+func Install(w io.Writer, binary string) error {
+	if err := CheckDependencies(); err != nil {
+		return fmt.Errorf("missing dependencies, install them and try again: %w", err)
+	}
+	_, _ = fmt.Fprintln(w, "Dependencies found")
+
+	if err := config.Commands.WriteFile(unitPath, []byte(fmt.Sprintf(unitTemplate, binary)), 0644); err != nil {
+		return fmt.Errorf("failed to write the systemd unit: %w", err)
+	}
+	_, _ = fmt.Fprintf(w, "Wrote %v to run %v\n", unitPath, binary)
+
+	if err := config.Commands.WriteFile(sysctlPath, []byte(sysctlSettings), 0644); err != nil {
+		return fmt.Errorf("failed to write the sysctl settings: %w", err)
+	}
+	if err := change("sysctl", "-p", sysctlPath); err != nil {
+		return fmt.Errorf("failed to apply the sysctl settings: %w", err)
+	}
+	_, _ = fmt.Fprintf(w, "Wrote %v and turned on IPv4 forwarding\n", sysctlPath)
+
+	if err := change("systemctl", "daemon-reload"); err != nil {
+		return err
+	}
+	if err := change("systemctl", "enable", serviceName); err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintf(w, "Enabled the %v service, start it with: systemctl start %v\n", serviceName, serviceName)
+	return nil
+}
+
+// Uninstall stops and disables the service and removes the files written by Install, carrying on after a failed step
+// so that as much as possible is removed. It reports each step to w. IPv4 forwarding stays on until the next reboot
+// since other services may need it.
+func Uninstall(w io.Writer) error {
+	var errs []error
+	if err := change("systemctl", "disable", "--now", serviceName); err != nil {
+		errs = append(errs, err)
+	} else {
+		_, _ = fmt.Fprintf(w, "Stopped and disabled the %v service\n", serviceName)
+	}
+	for _, path := range []string{unitPath, sysctlPath} {
+		if err := config.Commands.RemoveFile(path); err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove %v: %w", path, err))
+		} else {
+			_, _ = fmt.Fprintf(w, "Removed %v\n", path)
+		}
+	}
+	if err := change("systemctl", "daemon-reload"); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// change runs the command, returning an error with its output if it fails.
+func change(name string, args ...string) error {
+	if output, err := config.Commands.Change(name, args...); err != nil {
+		return fmt.Errorf("failed to run %v: %w: %s", config.CommandLine(name, args...), err, output)
+	}
+	return nil
+}
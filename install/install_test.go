@@ -0,0 +1,75 @@
+package install
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+)
+
+func mockCheckCmd(t *testing.T, missing ...string) {
+	orig := fnCheckCmd
+	t.Cleanup(func() { fnCheckCmd = orig })
+	fnCheckCmd = func(cmd string) error {
+		for _, m := range missing {
+			if cmd == m {
+				return fmt.Errorf("%v command not found on the system", cmd)
+			}
+		}
+		return nil
+	}
+}
+
+func TestInstall(t *testing.T) {
+	mockCheckCmd(t)
+	f := config.UseFakeExec(t)
+
+	var out bytes.Buffer
+	assert.NoError(t, Install(&out, "/usr/local/bin/tt"))
+	unit, ok := f.File(unitPath)
+	if assert.True(t, ok, "expected the unit to be written") {
+		assert.Contains(t, unit, "ExecStart=/usr/local/bin/tt\n")
+		assert.Contains(t, unit, "WantedBy=multi-user.target")
+		assert.Contains(t, unit, "WatchdogSec=60\nNotifyAccess=main\n", "expected systemd to restart the app if it hangs")
+	}
+	sysctl, ok := f.File(sysctlPath)
+	if assert.True(t, ok, "expected the sysctl settings to be written") {
+		assert.Contains(t, sysctl, "net.ipv4.ip_forward=1\n")
+	}
+	assert.Equal(t, []string{"sysctl -p " + sysctlPath, "systemctl daemon-reload", "systemctl enable tubetimeout"}, f.Calls())
+	assert.Contains(t, out.String(), "systemctl start tubetimeout")
+}
+
+func TestInstall_MissingDependencies(t *testing.T) {
+	mockCheckCmd(t, "arp")
+	f := config.UseFakeExec(t)
+
+	err := Install(&bytes.Buffer{}, "/usr/local/bin/tt")
+	assert.ErrorContains(t, err, "arp command not found")
+	_, ok := f.File(unitPath)
+	assert.False(t, ok, "expected nothing to be installed without the dependencies")
+	assert.Empty(t, f.Calls())
+}
+
+func TestUninstall(t *testing.T) {
+	f := config.UseFakeExec(t)
+	f.Results = map[string]config.FakeResult{"systemctl disable --now tubetimeout": {Output: "Unit tubetimeout.service not loaded.", Err: errors.New("exit status 1")}}
+
+	var out bytes.Buffer
+	err := Uninstall(&out)
+	assert.ErrorContains(t, err, "not loaded")
+	assert.Equal(t, []string{unitPath, sysctlPath}, f.Removed(), "expected the files to be removed after a failed step")
+	assert.Equal(t, []string{"systemctl disable --now tubetimeout", "systemctl daemon-reload"}, f.Calls())
+	assert.Contains(t, out.String(), "Removed "+unitPath)
+}
+
+func TestCheckDependencies(t *testing.T) {
+	mockCheckCmd(t)
+	assert.NoError(t, CheckDependencies())
+
+	mockCheckCmd(t, "arp")
+	assert.ErrorContains(t, CheckDependencies(), "arp")
+}
@@ -0,0 +1,118 @@
+// Package inventory reads the client table of the upstream router, which knows every device on the LAN, while the
+// ARP table only holds the devices that have talked to this device recently.
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+// The INVENTORY_BACKEND values.
+const (
+	backendUPnP = "upnp"
+	backendSNMP = "snmp"
+)
+
+// source reads the router's clients.
+type source interface {
+	clients(ctx context.Context) ([]models.NamedMAC, error)
+}
+
+// Inventory keeps the devices from the last read of the router's client table. It implements models.DeviceSource so
+// that they can be added to groups.
+type Inventory struct {
+	logger   *zap.SugaredLogger
+	cfg      *config.InventoryConfig
+	source   source
+	mu       sync.Mutex
+	devices  []models.NamedMAC // devices are the clients from the last successful read, guarded by mu.
+	lastPoll time.Time
+	lastErr  error
+}
+
+// NewInventory creates an Inventory for the router backend named by cfg.Backend; call Start to begin reading it.
+func NewInventory(logger *zap.SugaredLogger, cfg *config.InventoryConfig) (*Inventory, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("router address must be supplied for inventory backend %v", cfg.Backend)
+	}
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("inventory interval must be positive")
+	}
+	var src source
+	switch cfg.Backend {
+	case backendUPnP:
+		src = newUPnPSource(cfg)
+	case backendSNMP:
+		src = newSNMPSource(cfg)
+	default:
+		return nil, fmt.Errorf("unknown inventory backend %q, expected %v or %v", cfg.Backend, backendUPnP, backendSNMP)
+	}
+	return &Inventory{logger: logger, cfg: cfg, source: src}, nil
+}
+
+// Start reads the client table now and every cfg.Interval until ctx is cancelled.
+func (inv *Inventory) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(inv.cfg.Interval)
+		defer ticker.Stop()
+		inv.poll(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				inv.poll(ctx)
+			}
+		}
+	}()
+}
+
+func (inv *Inventory) poll(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, inv.cfg.Interval)
+	defer cancel()
+	devices, err := inv.source.clients(ctx)
+
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	inv.lastPoll, inv.lastErr = time.Now(), err
+	if err != nil { // keep the devices from the last read since the router's clients rarely change...
+		inv.logger.Errorf("Failed to read the router's clients over %v: %v", inv.cfg.Backend, err)
+		return
+	}
+	if len(devices) != len(inv.devices) {
+		inv.logger.Infof("Router inventory found %v clients", len(devices))
+	}
+	inv.devices = devices
+}
+
+// Devices implements models.DeviceSource.
+func (inv *Inventory) Devices() []models.NamedMAC {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	return slices.Clone(inv.devices)
+}
+
+// Health reports whether the router's client table can be read.
+func (inv *Inventory) Health() models.SubsystemHealth {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+
+	h := models.SubsystemHealth{Name: "inventory", Status: models.HealthOK}
+	h.Details = map[string]any{"backend": inv.cfg.Backend, "lastPoll": inv.lastPoll, "clients": len(inv.devices)}
+	switch {
+	case inv.lastErr != nil:
+		h.Status = models.HealthDegraded
+		h.Message = inv.lastErr.Error()
+	case inv.lastPoll.IsZero():
+		h.Status = models.HealthDegraded
+		h.Message = "the router's clients have not been read yet"
+	}
+	return h
+}
@@ -0,0 +1,139 @@
+package inventory
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+	"relloyd/tubetimeout/tr064"
+)
+
+func TestNewInventory(t *testing.T) {
+	for _, cfg := range []config.InventoryConfig{
+		{Backend: backendUPnP, Interval: time.Minute},
+		{Backend: backendSNMP, Address: "192.168.1.1", Interval: 0},
+		{Backend: "telnet", Address: "192.168.1.1", Interval: time.Minute},
+	} {
+		_, err := NewInventory(config.MustGetLogger(), &cfg)
+		assert.Error(t, err, "%+v", cfg)
+	}
+
+	inv, err := NewInventory(config.MustGetLogger(), &config.InventoryConfig{Backend: backendSNMP, Address: "192.168.1.1", Community: "public", Interval: time.Minute})
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.1.1:161", inv.source.(*snmpSource).addr, "expected the default SNMP port")
+	assert.Equal(t, models.HealthDegraded, inv.Health().Status, "expected degraded health before the first read")
+}
+
+func TestUPnPSource(t *testing.T) {
+	hosts := []string{
+		"<NewMACAddress>AA:BB:CC:DD:EE:01</NewMACAddress><NewHostName>kids-tablet</NewHostName>",
+		"<NewMACAddress></NewMACAddress><NewHostName>vpn</NewHostName>",
+		"<NewMACAddress>aa:bb:cc:dd:ee:02</NewMACAddress><NewHostName></NewHostName>",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, tr064.HostsURL, r.URL.Path)
+		var resp string
+		switch action := r.Header.Get("SoapAction"); action {
+		case tr064.HostsService + "#GetHostNumberOfEntries":
+			resp = "<u:GetHostNumberOfEntriesResponse><NewHostNumberOfEntries>3</NewHostNumberOfEntries></u:GetHostNumberOfEntriesResponse>"
+		case tr064.HostsService + "#GetGenericHostEntry":
+			i := strings.Index(string(body), "<NewIndex>") + len("<NewIndex>")
+			resp = "<u:GetGenericHostEntryResponse>" + hosts[body[i]-'0'] + "</u:GetGenericHostEntryResponse>"
+		default:
+			t.Errorf("unexpected action %v", action)
+		}
+		_, _ = io.WriteString(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+			resp+`</s:Body></s:Envelope>`)
+	}))
+	defer srv.Close()
+
+	inv, err := NewInventory(config.MustGetLogger(), &config.InventoryConfig{Backend: backendUPnP, Address: srv.URL, Interval: time.Minute})
+	require.NoError(t, err)
+	inv.poll(context.Background())
+	assert.Equal(t, []models.NamedMAC{
+		{MAC: models.NewMAC("AA:BB:CC:DD:EE:01"), Name: "kids-tablet"},
+		{MAC: models.NewMAC("aa:bb:cc:dd:ee:02")},
+	}, inv.Devices(), "expected entries without a MAC to be skipped")
+	assert.Equal(t, models.HealthOK, inv.Health().Status)
+}
+
+// fakeSNMPAgent answers GetBulk requests with the varbinds that follow the requested OID, at most two at a time.
+func fakeSNMPAgent(t *testing.T, table []varbind) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buf := make([]byte, maxSNMPPacketSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			// Decode the request by swapping its PDU tag for a response's.
+			req := slices.Clone(buf[:n])
+			i := slices.Index(req, tagGetBulk)
+			req[i] = tagResponse
+			id, vbs, err := decodeResponse(req)
+			if !assert.NoError(t, err) || !assert.Len(t, vbs, 1) {
+				return
+			}
+			var list []byte
+			count := 0
+			for _, vb := range table {
+				if slices.Compare(vb.oid, vbs[0].oid) > 0 && count < 2 {
+					list = append(list, berTLV(tagSequence, slices.Concat(berTLV(tagOID, encodeOID(vb.oid)), berTLV(vb.tag, vb.value)))...)
+					count++
+				}
+			}
+			if len(list) == 0 {
+				list = berTLV(tagSequence, slices.Concat(berTLV(tagOID, encodeOID(vbs[0].oid)), berTLV(tagEndOfMIB, nil)))
+			}
+			pdu := berTLV(tagResponse, slices.Concat(berInt(int64(id)), berInt(0), berInt(0), berTLV(tagSequence, list)))
+			resp := berTLV(tagSequence, slices.Concat(berInt(snmpVersion2c), berTLV(tagOctetString, []byte("public")), pdu))
+			_, _ = conn.WriteTo(resp, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestSNMPSource(t *testing.T) {
+	entry := func(ip ...uint32) []uint32 { return slices.Concat(oidPhysAddress, []uint32{300}, ip) }
+	addr := fakeSNMPAgent(t, []varbind{
+		{oid: entry(192, 168, 1, 10), tag: tagOctetString, value: []byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x01}},
+		{oid: entry(192, 168, 1, 11), tag: tagOctetString, value: []byte{0, 0, 0, 0, 0, 0}},
+		{oid: entry(192, 168, 1, 12), tag: tagOctetString, value: []byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x02}},
+		{oid: entry(10, 0, 0, 12), tag: tagOctetString, value: []byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x02}},
+		{oid: []uint32{1, 3, 6, 1, 2, 1, 4, 22, 1, 3, 300, 192, 168, 1, 10}, tag: tagOctetString, value: []byte{0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0x03}},
+	})
+
+	inv, err := NewInventory(config.MustGetLogger(), &config.InventoryConfig{Backend: backendSNMP, Address: addr, Community: "public", Interval: time.Minute})
+	require.NoError(t, err)
+	inv.poll(context.Background())
+	assert.Equal(t, []models.NamedMAC{
+		{MAC: models.NewMAC("aa:bb:cc:dd:ee:01")},
+		{MAC: models.NewMAC("aa:bb:cc:dd:ee:02")},
+	}, inv.Devices(), "expected the walk to stop at the end of the ARP table and to skip blank and repeated MACs")
+	assert.Equal(t, models.HealthOK, inv.Health().Status)
+}
+
+func TestOIDEncoding(t *testing.T) {
+	oid := []uint32{1, 3, 6, 1, 2, 1, 4, 22, 1, 2, 300, 192, 168, 1, 10, 70000}
+	assert.Equal(t, oid, decodeOID(encodeOID(oid)))
+	for _, v := range []int64{0, 127, 128, 255, 256, -1, -129, 1 << 30} {
+		b, _, err := readTLV(berInt(v), tagInteger)
+		require.NoError(t, err)
+		assert.Equal(t, v, decodeInt(b))
+	}
+}
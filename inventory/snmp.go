@@ -0,0 +1,276 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+// The BER tags of the SNMPv2c messages used.
+const (
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagNull        = 0x05
+	tagOID         = 0x06
+	tagSequence    = 0x30
+	tagResponse    = 0xa2
+	tagGetBulk     = 0xa5
+	tagEndOfMIB    = 0x82 // tagEndOfMIB is the endOfMibView exception returned after the last OID.
+)
+
+const (
+	snmpVersion2c     = 1
+	snmpPort          = "161"
+	snmpTimeout       = 5 * time.Second
+	snmpRepetitions   = 32   // snmpRepetitions is the number of OIDs asked for in each GetBulk request.
+	maxSNMPRequests   = 64   // maxSNMPRequests caps the walk, in case the agent keeps returning the same OIDs.
+	maxSNMPPacketSize = 9000 // maxSNMPPacketSize is the largest response read.
+)
+
+// oidPhysAddress is ipNetToMediaPhysAddress in RFC 1213, the MACs in the router's ARP table. Each is indexed by the
+// interface and IP, e.g. .1.192.168.1.10.
+var oidPhysAddress = []uint32{1, 3, 6, 1, 2, 1, 4, 22, 1, 2}
+
+// snmpSource walks the router's ARP table over SNMPv2c. It has no hostnames, so the devices it finds are unnamed.
+type snmpSource struct {
+	addr      string
+	community string
+	requestID int32
+}
+
+func newSNMPSource(cfg *config.InventoryConfig) *snmpSource {
+	addr := cfg.Address
+	if _, _, err := net.SplitHostPort(addr); err != nil { // if there's no port...
+		addr = net.JoinHostPort(addr, snmpPort)
+	}
+	return &snmpSource{addr: addr, community: cfg.Community}
+}
+
+// varbind is an OID and its value in an SNMP response.
+type varbind struct {
+	oid   []uint32
+	tag   byte
+	value []byte
+}
+
+func (s *snmpSource) clients(ctx context.Context) ([]models.NamedMAC, error) {
+	conn, err := (&net.Dialer{}).DialContext(ctx, "udp", s.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the SNMP agent: %w", err)
+	}
+	defer conn.Close()
+
+	var result []models.NamedMAC
+	seen := make(map[string]bool)
+	next := oidPhysAddress
+	for range maxSNMPRequests {
+		varbinds, err := s.getBulk(ctx, conn, next)
+		if err != nil {
+			return nil, err
+		}
+		if len(varbinds) == 0 {
+			break
+		}
+		for _, vb := range varbinds {
+			if vb.tag == tagEndOfMIB || !slices.Equal(vb.oid[:min(len(vb.oid), len(oidPhysAddress))], oidPhysAddress) { // if we've walked past the ARP table...
+				return result, nil
+			}
+			next = vb.oid
+			hw := net.HardwareAddr(vb.value)
+			if vb.tag != tagOctetString || len(hw) != 6 || slices.Equal(hw, make(net.HardwareAddr, 6)) {
+				continue
+			}
+			mac := models.NewMAC(hw.String())
+			if !seen[mac] { // the same MAC may be listed for more than one interface or IP...
+				result = append(result, models.NamedMAC{MAC: mac})
+				seen[mac] = true
+			}
+		}
+	}
+	return result, nil
+}
+
+// getBulk asks for the snmpRepetitions OIDs that follow oid and returns them.
+func (s *snmpSource) getBulk(ctx context.Context, conn net.Conn, oid []uint32) ([]varbind, error) {
+	s.requestID++
+	req := encodeGetBulk(s.community, s.requestID, oid)
+	deadline := time.Now().Add(snmpTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return nil, fmt.Errorf("failed to send SNMP request: %w", err)
+	}
+	buf := make([]byte, maxSNMPPacketSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SNMP response: %w", err)
+		}
+		id, varbinds, err := decodeResponse(buf[:n])
+		if err != nil {
+			return nil, err
+		}
+		if id == s.requestID { // else it's a late answer to an earlier request...
+			return varbinds, nil
+		}
+	}
+}
+
+// encodeGetBulk returns a GetBulk request for the OIDs that follow oid.
+func encodeGetBulk(community string, requestID int32, oid []uint32) []byte {
+	vb := berTLV(tagSequence, berTLV(tagSequence, slices.Concat(berTLV(tagOID, encodeOID(oid)), berTLV(tagNull, nil))))
+	pdu := berTLV(tagGetBulk, slices.Concat(berInt(int64(requestID)), berInt(0), berInt(snmpRepetitions), vb)) // non-repeaters, then max-repetitions.
+	return berTLV(tagSequence, slices.Concat(berInt(snmpVersion2c), berTLV(tagOctetString, []byte(community)), pdu))
+}
+
+// decodeResponse returns the request ID and varbinds of a response, or an error if the agent returned one.
+func decodeResponse(b []byte) (int32, []varbind, error) {
+	errMalformed := errors.New("malformed SNMP response")
+	msg, _, err := readTLV(b, tagSequence)
+	if err != nil {
+		return 0, nil, errMalformed
+	}
+	_, rest, err := readTLV(msg, tagInteger) // version
+	if err != nil {
+		return 0, nil, errMalformed
+	}
+	if _, rest, err = readTLV(rest, tagOctetString); err != nil { // community
+		return 0, nil, errMalformed
+	}
+	pdu, _, err := readTLV(rest, tagResponse)
+	if err != nil {
+		return 0, nil, errMalformed
+	}
+	var fields [3]int64 // request ID, error status and error index.
+	for i := range fields {
+		var v []byte
+		if v, pdu, err = readTLV(pdu, tagInteger); err != nil {
+			return 0, nil, errMalformed
+		}
+		fields[i] = decodeInt(v)
+	}
+	if fields[1] != 0 {
+		return 0, nil, fmt.Errorf("SNMP agent returned error status %v", fields[1])
+	}
+	list, _, err := readTLV(pdu, tagSequence)
+	if err != nil {
+		return 0, nil, errMalformed
+	}
+	var varbinds []varbind
+	for len(list) > 0 {
+		var seq []byte
+		if seq, list, err = readTLV(list, tagSequence); err != nil {
+			return 0, nil, errMalformed
+		}
+		oid, rest, err := readTLV(seq, tagOID)
+		if err != nil || len(rest) < 2 {
+			return 0, nil, errMalformed
+		}
+		value, _, err := readTLV(rest, rest[0])
+		if err != nil {
+			return 0, nil, errMalformed
+		}
+		varbinds = append(varbinds, varbind{oid: decodeOID(oid), tag: rest[0], value: value})
+	}
+	return int32(fields[0]), varbinds, nil
+}
+
+// readTLV returns the value of the BER element at the start of b, which must have the tag, and what follows it.
+func readTLV(b []byte, tag byte) ([]byte, []byte, error) {
+	if len(b) < 2 || b[0] != tag {
+		return nil, nil, errors.New("unexpected BER tag")
+	}
+	n, header := int(b[1]), 2
+	if n&0x80 != 0 { // if the length is in the next bytes...
+		size := n & 0x7f
+		if size == 0 || size > 2 || len(b) < 2+size {
+			return nil, nil, errors.New("unsupported BER length")
+		}
+		n = 0
+		for _, c := range b[2 : 2+size] {
+			n = n<<8 | int(c)
+		}
+		header += size
+	}
+	if len(b) < header+n {
+		return nil, nil, errors.New("truncated BER element")
+	}
+	return b[header : header+n], b[header+n:], nil
+}
+
+// berTLV returns the BER element with the tag and value.
+func berTLV(tag byte, value []byte) []byte {
+	n := len(value)
+	switch {
+	case n < 0x80:
+		return slices.Concat([]byte{tag, byte(n)}, value)
+	case n <= 0xff:
+		return slices.Concat([]byte{tag, 0x81, byte(n)}, value)
+	default:
+		return slices.Concat([]byte{tag, 0x82, byte(n >> 8), byte(n)}, value)
+	}
+}
+
+// berInt returns v as a BER integer in the fewest bytes.
+func berInt(v int64) []byte {
+	b := []byte{byte(v)}
+	for v >= 0x80 || v < -0x80 {
+		v >>= 8
+		b = append([]byte{byte(v)}, b...)
+	}
+	return berTLV(tagInteger, b)
+}
+
+func decodeInt(b []byte) int64 {
+	var v int64
+	for i, c := range b {
+		if i == 0 && c&0x80 != 0 { // if it's negative...
+			v = -1
+		}
+		v = v<<8 | int64(c)
+	}
+	return v
+}
+
+// encodeOID returns the BER value of the OID, whose first two numbers share a byte.
+func encodeOID(oid []uint32) []byte {
+	if len(oid) < 2 {
+		return nil
+	}
+	b := []byte{byte(oid[0]*40 + oid[1])}
+	for _, n := range oid[2:] {
+		var enc []byte
+		for enc = []byte{byte(n & 0x7f)}; n >= 0x80; {
+			n >>= 7
+			enc = append([]byte{byte(n&0x7f) | 0x80}, enc...)
+		}
+		b = append(b, enc...)
+	}
+	return b
+}
+
+func decodeOID(b []byte) []uint32 {
+	if len(b) == 0 {
+		return nil
+	}
+	oid := []uint32{uint32(b[0]) / 40, uint32(b[0]) % 40}
+	var n uint32
+	for _, c := range b[1:] {
+		n = n<<7 | uint32(c&0x7f)
+		if c&0x80 == 0 { // if it's the last byte of the number...
+			oid = append(oid, n)
+			n = 0
+		}
+	}
+	return oid
+}
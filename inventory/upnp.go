@@ -0,0 +1,59 @@
+package inventory
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+	"relloyd/tubetimeout/tr064"
+)
+
+const maxUPnPHosts = 1024 // maxUPnPHosts is the most host entries read, in case the router reports nonsense.
+
+// upnpSource reads the host table of the router's TR-064 Hosts service, one entry per call, as AVM Fritz!Box and
+// other TR-064 routers serve it. TR-064 access may need enabling on the router first.
+type upnpSource struct {
+	soap *tr064.Client
+}
+
+func newUPnPSource(cfg *config.InventoryConfig) *upnpSource {
+	return &upnpSource{soap: tr064.NewClient(cfg.Address, cfg.Username, cfg.Password, &http.Client{Timeout: 10 * time.Second})}
+}
+
+func (u *upnpSource) clients(ctx context.Context) ([]models.NamedMAC, error) {
+	resp, err := u.soap.Call(ctx, tr064.HostsURL, tr064.HostsService, "GetHostNumberOfEntries", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count hosts: %w", err)
+	}
+	var count struct {
+		N int `xml:"Body>GetHostNumberOfEntriesResponse>NewHostNumberOfEntries"`
+	}
+	if err = xml.Unmarshal(resp, &count); err != nil {
+		return nil, fmt.Errorf("failed to decode host count: %w", err)
+	}
+
+	var result []models.NamedMAC
+	for i := range min(count.N, maxUPnPHosts) {
+		resp, err = u.soap.Call(ctx, tr064.HostsURL, tr064.HostsService, "GetGenericHostEntry", [][2]string{{"NewIndex", strconv.Itoa(i)}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read host %v: %w", i, err)
+		}
+		var entry struct {
+			MAC  string `xml:"Body>GetGenericHostEntryResponse>NewMACAddress"`
+			Name string `xml:"Body>GetGenericHostEntryResponse>NewHostName"`
+		}
+		if err = xml.Unmarshal(resp, &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode host %v: %w", i, err)
+		}
+		if entry.MAC == "" { // if the entry is e.g. a VPN client...
+			continue
+		}
+		result = append(result, models.NamedMAC{MAC: models.NewMAC(entry.MAC), Name: entry.Name})
+	}
+	return result, nil
+}
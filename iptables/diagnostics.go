@@ -0,0 +1,140 @@
+package iptables
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+// missingRules describes the first of the app's chains, or the jumps to them, that's missing, or returns "" if
+// they're all present. This should be done under a mutex.
+func (q *Rules) missingRules() string {
+	chains := q.chains()
+	for _, table := range []string{tableFilter, tableNAT} {
+		for _, c := range chains[table] {
+			if _, err := config.Commands.Query("iptables", "-w", "-t", table, "-S", c); err != nil {
+				return fmt.Sprintf("chain %q is missing from table %q", c, table)
+			}
+		}
+	}
+	for _, j := range q.jumps() {
+		if !hasJump(j) {
+			return fmt.Sprintf("the jump from %v to %v is missing", j.chain, j.to)
+		}
+	}
+	return ""
+}
+
+// Reconcile repairs the rules if the app's chains or the jumps to them have been removed, e.g. because
+// "iptables -F" was run or a firewall reload replaced them. It returns true if the rules needed repairing.
+func (q *Rules) Reconcile() (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.removed { // if Clean has deleted the rules on purpose...
+		return false, nil
+	}
+	reason := q.missingRules()
+	if reason == "" {
+		return false, nil
+	}
+
+	q.logger.Warnf("iptables rules need repairing: %v", reason)
+	if err := q.install(); err != nil {
+		return true, fmt.Errorf("failed to repair iptables rules: %w", err)
+	}
+	q.repairs++
+	return true, nil
+}
+
+// StartReconciler calls Reconcile every interval until ctx is done. Zero or less disables it.
+func (q *Rules) StartReconciler(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if repaired, err := q.Reconcile(); err != nil {
+					q.logger.Errorf("iptables reconciliation failed: %v", err)
+				} else if repaired {
+					q.logger.Info("iptables rules repaired")
+				}
+			}
+		}
+	}()
+}
+
+// Health reports the rules as unhealthy if the app's chains or the jumps to them have been removed, since packets
+// would no longer be queued for filtering until Reconcile repairs them.
+func (q *Rules) Health() models.SubsystemHealth {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	h := models.SubsystemHealth{
+		Name:    "iptables",
+		Status:  models.HealthOK,
+		Details: map[string]any{"localIPs": len(q.localIPs), "remoteIPs": len(q.remoteIPs), "repairs": q.repairs},
+	}
+	if msg := q.missingRules(); msg != "" {
+		h.Status = models.HealthUnhealthy
+		h.Message = msg
+	}
+	return h
+}
+
+// Snapshot returns the app's chains and rules as the kernel has them. The chains of local and remote IPs are also
+// listed as sets, so that it's possible to check whether an IP made it into the rules.
+func (q *Rules) Snapshot() (models.NFTSnapshot, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	snap := models.NFTSnapshot{Table: strings.TrimSuffix(chainPrefix, "-"), Family: "iptables", Chains: []models.NFTChain{}, Sets: []models.NFTSet{}}
+	hooks := make(map[string]string)
+	for _, j := range q.jumps() {
+		hooks[j.to] = strings.ToLower(j.chain)
+	}
+	chains := q.chains()
+	for _, table := range []string{tableFilter, tableNAT} {
+		out, err := config.Commands.Query("iptables", "-w", "-t", table, "-S")
+		if err != nil {
+			return snap, fmt.Errorf("failed to list iptables table %v: %w", table, err)
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 2 || !slices.Contains(chains[table], fields[1]) {
+				continue
+			}
+			switch fields[0] {
+			case "-N":
+				snap.Chains = append(snap.Chains, models.NFTChain{Name: fields[1], Type: table, Hook: hooks[fields[1]], Rules: []models.NFTRule{}})
+			case "-A":
+				if i := slices.IndexFunc(snap.Chains, func(c models.NFTChain) bool { return c.Name == fields[1] }); i >= 0 {
+					c := &snap.Chains[i]
+					c.Rules = append(c.Rules, models.NFTRule{Handle: uint64(len(c.Rules) + 1), Exprs: []string{strings.Join(fields[2:], " ")}})
+				}
+			}
+		}
+	}
+
+	for _, s := range []struct{ name, chain, flag string }{{"local_ip_set", chainLocalSrc, "-s"}, {"remote_ip_set", chainRemoteDst, "-d"}} {
+		set := models.NFTSet{Name: s.name, KeyType: "ipv4_addr", Elements: []string{}}
+		if i := slices.IndexFunc(snap.Chains, func(c models.NFTChain) bool { return c.Name == s.chain }); i >= 0 {
+			for _, r := range snap.Chains[i].Rules {
+				if fields := strings.Fields(r.Exprs[0]); len(fields) > 1 && fields[0] == s.flag {
+					set.Elements = append(set.Elements, strings.TrimSuffix(fields[1], "/32"))
+				}
+			}
+		}
+		snap.Sets = append(snap.Sets, set)
+	}
+	return snap, nil
+}
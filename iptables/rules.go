@@ -0,0 +1,532 @@
+package iptables
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+var (
+	// defaultRestoreFilePath is where the rules are written for iptables-restore.
+	defaultRestoreFilePath = filepath.Join(os.TempDir(), "tubetimeout.iptables")
+
+	errLocalIPsNotReady  = errors.New("local IPs aren't ready")
+	errRemoteIPsNotReady = errors.New("remote IPs aren't ready")
+	errRemoved           = errors.New("the rules have been removed")
+)
+
+// The chains are all prefixed so that they can be found, and so they're clear of other firewall tools. User
+// defined chains stand in for the NFT sets: each holds a rule per IP that jumps to the next chain or gives the verdict.
+const (
+	chainPrefix       = "TUBETIMEOUT-"
+	chainForward      = chainPrefix + "FORWARD"      // chainForward is jumped to from FORWARD.
+	chainFilter       = chainPrefix + "FILTER"       // chainFilter queues the tracked traffic, also for the gateway's own apps.
+	chainKill         = chainPrefix + "KILL"         // chainKill drops the local IPs while the kill switch is on.
+	chainBypass       = chainPrefix + "BYPASS"       // chainBypass sends the local IPs to the bypass chains below.
+	chainBypassDrop   = chainPrefix + "BYPASS-DROP"  // chainBypassDrop drops the traffic to the bypass ports and IPs.
+	chainBypassQueue  = chainPrefix + "BYPASS-QUEUE" // chainBypassQueue queues the traffic to the bypass ports and IPs.
+	chainDNS          = chainPrefix + "DNS"          // chainDNS queues the DNS answers to the local IPs for inspection.
+	chainLocalSrc     = chainPrefix + "LOCAL-SRC"
+	chainRemoteDst    = chainPrefix + "REMOTE-DST"
+	chainRemoteSrc    = chainPrefix + "REMOTE-SRC"
+	chainLocalDst     = chainPrefix + "LOCAL-DST"
+	chainPostRouting  = chainPrefix + "POSTROUTING" // chainPostRouting masquerades IPv4, as the NFT table does.
+	tableFilter       = "filter"
+	tableNAT          = "nat"
+	maxMultiportPorts = 15 // maxMultiportPorts is the most ports the multiport match takes in one rule.
+)
+
+// The FILTER_UDP_MODE values.
+const (
+	udpModeQueue  = "queue"
+	udpModeDrop   = "drop"
+	udpModeAccept = "accept"
+)
+
+// jump is a rule in a built-in chain that jumps to one of the app's chains.
+type jump struct {
+	table string
+	chain string
+	to    string
+}
+
+// Rules queues packets to the NFQs with iptables, for systems whose kernels don't support nftables. The rules are
+// written by iptables-restore in one go each time the IPs change, so they're never left half updated.
+// Packet sampling and the block page aren't supported, so every packet of the tracked devices is queued and the
+// plain HTTP of blocked devices is dropped like the rest of their traffic.
+type Rules struct {
+	logger        *zap.SugaredLogger
+	cfg           *config.FilterConfig
+	mu            sync.Mutex
+	localIPs      []models.Ip // localIPs are the IPv4 addresses of the tracked devices, sorted and guarded by mu.
+	remoteIPs     []models.Ip // remoteIPs are the IPv4 addresses of the tracked domains, sorted and guarded by mu.
+	killSwitch    bool        // killSwitch is true while the kill switch is on, guarded by mu.
+	failOpen      bool        // failOpen is true while the tracked traffic bypasses the queues, guarded by mu.
+	bypassBlocked []models.Ip // bypassBlocked are the local IPs blocked from the bypass ports and IPs, guarded by mu.
+	lanNets       []string    // lanNets are the networks whose traffic between each other is left alone.
+	installed     bool        // installed is true once the rules have been written with local and remote IPs, guarded by mu.
+	repairs       int         // repairs is the number of times Reconcile has repaired the rules, guarded by mu.
+	removed       bool        // removed is true once Clean has deleted the chains, so that they aren't put back, guarded by mu.
+
+	// allLocalIPs are the local IPs including the exempt ones, and exemptIPs are the sorted IPv4 addresses of the
+	// exemptions, found with the MACs of the source IPs in srcIpMACs. They're all guarded by mu.
+	allLocalIPs []models.Ip
+	exemptIPs   []models.Ip
+	exemptions  []models.Exemption
+	srcIpMACs   models.MapIpMACs
+}
+
+// NewIPTablesRules creates the chains and the rules that jump to them from the built-in chains.
+func NewIPTablesRules(logger *zap.SugaredLogger, cfg *config.FilterConfig) (*Rules, error) {
+	if err := checkUDPMode(cfg.UDPMode); err != nil {
+		return nil, err
+	}
+	for _, s := range slices.Concat(cfg.BypassResolverIPs, cfg.BypassVPNIPs) {
+		if cfg.BypassDetection && net.ParseIP(s).To4() == nil {
+			return nil, fmt.Errorf("bypass IP %q isn't an IPv4 address", s)
+		}
+	}
+	nets, err := cfg.LANNets()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SampleRate > 1 {
+		logger.Warn("Packet sampling isn't supported by the iptables backend, so every packet will be queued")
+	}
+	if cfg.BlockPagePort > 0 {
+		logger.Warn("The block page isn't supported by the iptables backend, so blocked devices will time out instead")
+	}
+	q := &Rules{logger: logger, cfg: cfg}
+	for _, n := range nets {
+		q.lanNets = append(q.lanNets, n.String())
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.install(); err != nil {
+		return nil, err
+	}
+	logger.Info("iptables chains installed")
+	return q, nil
+}
+
+// install writes the chains and rules, then adds the jumps to them that are missing. This should be done under a mutex.
+func (q *Rules) install() error {
+	if err := q.apply(); err != nil {
+		return err
+	}
+	for _, j := range q.jumps() {
+		if hasJump(j) {
+			continue
+		}
+		if out, err := config.Commands.Change("iptables", "-w", "-t", j.table, "-I", j.chain, "1", "-j", j.to); err != nil {
+			return fmt.Errorf("failed to add the jump from %v to %v: %w: %s", j.chain, j.to, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// jumps returns the rules needed in the built-in chains.
+func (q *Rules) jumps() []jump {
+	jumps := []jump{{tableFilter, "FORWARD", chainForward}, {tableNAT, "POSTROUTING", chainPostRouting}}
+	if q.cfg.LocalDevice { // if traffic from the gateway's own apps should be filtered too...
+		jumps = append(jumps, jump{tableFilter, "OUTPUT", chainFilter}, jump{tableFilter, "INPUT", chainFilter})
+	} else if q.cfg.DNSInspection { // else if the answers from the gateway's own DNS server should be inspected...
+		jumps = append(jumps, jump{tableFilter, "OUTPUT", chainDNS})
+	}
+	return jumps
+}
+
+// hasJump returns true if the jump is in its built-in chain.
+func hasJump(j jump) bool {
+	_, err := config.Commands.Query("iptables", "-w", "-t", j.table, "-C", j.chain, "-j", j.to)
+	return err == nil
+}
+
+// chains returns the app's chains in each table.
+func (q *Rules) chains() map[string][]string {
+	filter := []string{chainForward, chainFilter, chainKill, chainLocalSrc, chainRemoteDst, chainRemoteSrc, chainLocalDst}
+	if q.cfg.BypassDetection {
+		filter = append(filter, chainBypass, chainBypassDrop, chainBypassQueue)
+	}
+	if q.cfg.DNSInspection {
+		filter = append(filter, chainDNS)
+	}
+	return map[string][]string{tableFilter: filter, tableNAT: {chainPostRouting}}
+}
+
+// apply writes the rules with iptables-restore. Declaring the chains empties them first, and other chains are left
+// alone. This should be done under a mutex.
+func (q *Rules) apply() error {
+	if err := config.Commands.WriteFile(defaultRestoreFilePath, []byte(q.render()), 0600); err != nil {
+		return fmt.Errorf("failed to write iptables rules: %w", err)
+	}
+	if out, err := config.Commands.Change("iptables-restore", "--noflush", defaultRestoreFilePath); err != nil {
+		return fmt.Errorf("failed to restore iptables rules: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// render returns the rules in the format of iptables-restore.
+func (q *Rules) render() string {
+	var sb strings.Builder
+	chains := q.chains()
+	add := func(chain string, args ...string) {
+		sb.WriteString("-A " + chain + " " + strings.Join(args, " ") + "\n")
+	}
+	queue := func(num uint16) string { return "NFQUEUE --queue-num " + strconv.Itoa(int(num)) }
+	// lan leaves the traffic between LAN addresses in the chain to the rules after the jump to it.
+	lan := func(chain string) {
+		for _, src := range q.lanNets {
+			for _, dst := range q.lanNets {
+				add(chain, "-s", src, "-d", dst, "-j", "RETURN")
+			}
+		}
+	}
+
+	// exempt leaves the traffic of the exempt devices to the rules after the jump to the chain.
+	exempt := func(chain string) {
+		for _, ip := range q.exemptIPs {
+			add(chain, "-s", host(ip), "-j", "RETURN")
+			add(chain, "-d", host(ip), "-j", "RETURN")
+		}
+	}
+
+	sb.WriteString("*" + tableFilter + "\n")
+	for _, c := range chains[tableFilter] {
+		sb.WriteString(":" + c + " - [0:0]\n")
+	}
+
+	// Leave the exempt devices alone ahead of all the other rules, so their traffic is never queued or dropped.
+	exempt(chainForward)
+	exempt(chainFilter)
+
+	// Drop everything to and from the local IPs while the kill switch is on, ahead of the other rules.
+	add(chainForward, "-j", chainKill)
+	if q.killSwitch {
+		for _, ip := range q.localIPs {
+			add(chainKill, "-s", host(ip), "-j", "DROP")
+			add(chainKill, "-d", host(ip), "-j", "DROP")
+		}
+	}
+
+	// Leave the rest of the traffic alone, as if the app's rules weren't there, while the packet handler can't keep up.
+	if q.failOpen {
+		add(chainForward, "-j", "RETURN")
+		add(chainFilter, "-j", "RETURN")
+	}
+
+	// Maybe queue, or drop for the devices caught, the traffic to the ports and IPs used to get around the filter.
+	// These are only in the forward chain since the gateway's own apps use other resolvers.
+	if q.cfg.BypassDetection {
+		add(chainForward, "-j", chainBypass)
+		lan(chainBypass)
+		if q.cfg.BypassBlock {
+			for _, ip := range q.bypassBlocked {
+				add(chainBypass, "-s", host(ip), "-j", chainBypassDrop)
+			}
+		}
+		for _, ip := range q.localIPs {
+			add(chainBypass, "-s", host(ip), "-j", chainBypassQueue)
+		}
+		for _, v := range []struct{ chain, verdict string }{{chainBypassDrop, "DROP"}, {chainBypassQueue, queue(q.cfg.OutboundQueueNumber)}} {
+			for _, ip := range slices.Concat(q.cfg.BypassResolverIPs, q.cfg.BypassVPNIPs) {
+				add(v.chain, "-d", host(models.Ip(ip)), "-j", v.verdict)
+			}
+			for _, ports := range multiports(q.cfg.BypassPorts) {
+				add(v.chain, "-p", "tcp", "-m", "multiport", "--dports", ports, "-j", v.verdict)
+				add(v.chain, "-p", "udp", "-m", "multiport", "--dports", ports, "-j", v.verdict)
+			}
+		}
+	}
+	add(chainForward, "-j", chainFilter)
+
+	// Maybe queue the DNS answers to the local IPs so the IPs of the tracked domains are learned as they're handed out.
+	// The chain is also jumped to from OUTPUT for the gateway's own DNS server, so it's left empty while failing open.
+	if q.cfg.DNSInspection {
+		add(chainFilter, "-p", "udp", "--sport", "53", "-j", chainDNS)
+		if !q.failOpen {
+			for _, ip := range q.localIPs {
+				add(chainDNS, "-d", host(ip), "-p", "udp", "--sport", "53", "-j", queue(q.cfg.InboundQueueNumber))
+			}
+		}
+	}
+
+	// Leave the traffic between LAN addresses alone, e.g. casting to a TV, even if an address is also a remote IP.
+	// This comes after the DNS answers so that those from resolvers on the LAN are still inspected.
+	lan(chainFilter)
+
+	// Queue or drop UDP to/from the local IPs on cfg.UDPPorts whatever the remote IP, so that QUIC to IPs that haven't
+	// resolved yet is queued too.
+	if q.cfg.UDPMode != udpModeAccept {
+		out, in := queue(q.cfg.OutboundQueueNumber), queue(q.cfg.InboundQueueNumber)
+		if q.cfg.UDPMode == udpModeDrop {
+			out, in = "DROP", "DROP"
+		}
+		for _, ports := range multiports(q.cfg.UDPPorts) {
+			for _, ip := range q.localIPs {
+				add(chainFilter, "-s", host(ip), "-p", "udp", "-m", "multiport", "--dports", ports, "-j", out)
+				add(chainFilter, "-d", host(ip), "-p", "udp", "-m", "multiport", "--sports", ports, "-j", in)
+			}
+		}
+	}
+
+	// Queue TCP and UDP between the local and remote IPs in both directions.
+	for _, proto := range []string{"tcp", "udp"} {
+		add(chainFilter, "-p", proto, "-j", chainLocalSrc)
+		add(chainFilter, "-p", proto, "-j", chainRemoteSrc)
+	}
+	for _, ip := range q.localIPs {
+		add(chainLocalSrc, "-s", host(ip), "-j", chainRemoteDst)
+		add(chainLocalDst, "-d", host(ip), "-j", queue(q.cfg.InboundQueueNumber))
+	}
+	for _, ip := range q.remoteIPs {
+		add(chainRemoteSrc, "-s", host(ip), "-j", chainLocalDst)
+		add(chainRemoteDst, "-d", host(ip), "-j", queue(q.cfg.OutboundQueueNumber))
+	}
+	sb.WriteString("COMMIT\n")
+
+	// Masquerade IPv4 leaving the gateway.
+	sb.WriteString("*" + tableNAT + "\n")
+	for _, c := range chains[tableNAT] {
+		sb.WriteString(":" + c + " - [0:0]\n")
+	}
+	add(chainPostRouting, "-j", "MASQUERADE")
+	sb.WriteString("COMMIT\n")
+	return sb.String()
+}
+
+// host returns the IP as a single host match.
+func host(ip models.Ip) string {
+	return string(ip) + "/32"
+}
+
+// multiports returns the ports as comma separated lists short enough for the multiport match.
+func multiports(ports []uint16) []string {
+	var retval []string
+	for chunk := range slices.Chunk(ports, maxMultiportPorts) {
+		s := make([]string, 0, len(chunk))
+		for _, p := range chunk {
+			s = append(s, strconv.Itoa(int(p)))
+		}
+		retval = append(retval, strings.Join(s, ","))
+	}
+	return retval
+}
+
+// checkUDPMode returns an error if the mode isn't one of the UDP modes.
+func checkUDPMode(mode string) error {
+	switch mode {
+	case udpModeQueue, udpModeDrop, udpModeAccept, "":
+		return nil
+	}
+	return fmt.Errorf("unknown UDP mode %q, expected %v, %v or %v", mode, udpModeQueue, udpModeDrop, udpModeAccept)
+}
+
+// ipv4s returns the IPv4 addresses in ips sorted, and the number of others discarded.
+func ipv4s(ips []models.Ip) ([]models.Ip, int) {
+	var retval []models.Ip
+	discarded := 0
+	for _, ip := range slices.Sorted(slices.Values(ips)) {
+		if net.ParseIP(string(ip)).To4() != nil {
+			retval = append(retval, ip)
+		} else {
+			discarded++
+		}
+	}
+	return retval, discarded
+}
+
+// UpdateDestIpDomains is a callback that saves the supplied Ip addresses and updates the rules using them.
+func (q *Rules) UpdateDestIpDomains(newData models.MapIpDomain) {
+	q.logger.Debugf("iptables callback with new destination IPs: %v", newData)
+	ips, discarded := ipv4s(slices.Collect(maps.Keys(newData)))
+	if discarded > 0 {
+		q.logger.Infof("iptables destination IP callback discarded %v address(es)", discarded)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.remoteIPs = ips
+	q.logUpdateError("destination", q.update())
+}
+
+// UpdateSourceIpGroups is a callback that saves the supplied Ip addresses and updates the rules using them.
+func (q *Rules) UpdateSourceIpGroups(newData models.MapIpGroups) {
+	q.logger.Debugf("iptables callback with new source IPs: %v", newData)
+	ips, discarded := ipv4s(slices.Collect(maps.Keys(newData)))
+	if discarded > 0 {
+		q.logger.Infof("iptables source IP callback discarded %v address(es)", discarded)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.allLocalIPs = ips
+	q.updateLocalIPs()
+	q.logUpdateError("source", q.update())
+}
+
+// UpdateSourceIpMACs implements the SourceIpMACReceiver interface to find the IPs of the devices exempt by MAC.
+func (q *Rules) UpdateSourceIpMACs(newData models.MapIpMACs) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.srcIpMACs = newData
+	if q.updateLocalIPs() {
+		q.logUpdateError("exempt", q.update())
+	}
+}
+
+// UpdateExemptions implements the ExemptionReceiver interface to let the traffic of the exempt devices through
+// without queuing it.
+func (q *Rules) UpdateExemptions(exemptions []models.Exemption) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.exemptions = exemptions
+	if q.updateLocalIPs() {
+		q.logUpdateError("exempt", q.update())
+	}
+}
+
+// updateLocalIPs saves the exempt IPs and the local IPs without them, and returns true if the exempt IPs changed.
+// This should be done under a mutex.
+func (q *Rules) updateLocalIPs() bool {
+	exempt := models.ExemptIPs(q.exemptions, q.srcIpMACs)
+	q.localIPs = slices.DeleteFunc(slices.Clone(q.allLocalIPs), func(ip models.Ip) bool { return exempt[ip] })
+	exemptIPs := slices.Sorted(maps.Keys(exempt))
+	if slices.Equal(exemptIPs, q.exemptIPs) {
+		return false
+	}
+	q.exemptIPs = exemptIPs
+	return true
+}
+
+// UpdateKillSwitch implements the KillSwitchReceiver interface to drop all traffic to and from the local IPs while
+// the kill switch is on.
+func (q *Rules) UpdateKillSwitch(on bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.killSwitch = on
+	q.logUpdateError("kill switch", q.update())
+	q.logger.Infof("iptables kill switch updated (on=%v, %d local IPs)", q.killSwitch, len(q.localIPs))
+}
+
+// UpdateFailOpen implements the FailOpenReceiver interface to let the traffic to and from the local IPs through
+// without queuing it while the packet handler can't keep up.
+func (q *Rules) UpdateFailOpen(on bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.failOpen = on
+	q.logUpdateError("fail-open", q.update())
+	q.logger.Infof("iptables fail-open updated (on=%v)", q.failOpen)
+}
+
+// UpdateThresholdState implements the ThresholdStateReceiver interface. There's nothing to change since every packet
+// is queued and the block page isn't supported.
+func (q *Rules) UpdateThresholdState(models.Group, bool) {}
+
+// UpdateBypassBlocked implements bypass.Blocker to drop the traffic from the IPs to the bypass ports and IPs.
+func (q *Rules) UpdateBypassBlocked(ips []models.Ip) {
+	blocked, _ := ipv4s(ips)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.bypassBlocked = blocked
+	q.logUpdateError("bypass blocked", q.update())
+}
+
+// SampleRate returns 1 since packet sampling isn't supported, so every packet is queued.
+func (q *Rules) SampleRate(models.Ip) int {
+	return 1
+}
+
+// update writes the rules with the latest IPs. It returns errLocalIPsNotReady or errRemoteIPsNotReady after writing
+// them if there aren't any packets to queue yet. This should be done under a mutex.
+func (q *Rules) update() error {
+	if q.removed {
+		return errRemoved
+	}
+	if err := q.apply(); err != nil {
+		return err
+	}
+	if len(q.localIPs) == 0 {
+		return errLocalIPsNotReady
+	}
+	if len(q.remoteIPs) == 0 {
+		return errRemoteIPsNotReady
+	}
+	if !q.installed {
+		q.logger.Info("iptables rules installed, filtering is active")
+	}
+	q.installed = true
+	q.logger.Infof("iptables rules updated with %d local IPs and %d remote IPs", len(q.localIPs), len(q.remoteIPs))
+	return nil
+}
+
+// logUpdateError logs errors from update. Waiting for the first source or destination IPs is expected at startup
+// and is reported by Readiness instead, so it is only logged at debug level.
+func (q *Rules) logUpdateError(kind string, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, errLocalIPsNotReady) || errors.Is(err, errRemoteIPsNotReady):
+		q.logger.Debugf("iptables callback with new %v IPs deferred the update: %v", kind, err)
+	case errors.Is(err, errRemoved):
+		q.logger.Debugf("iptables callback with new %v IPs skipped the update: %v", kind, err)
+	default:
+		q.logger.Warnf("iptables callback with new %v IPs couldn't make the update: %v", kind, err)
+	}
+}
+
+// Readiness reports whether the rules have been written with local and remote IPs so that packets are queued for
+// filtering.
+func (q *Rules) Readiness() models.Readiness {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch {
+	case q.installed:
+		return models.Readiness{State: models.ReadinessReady}
+	case len(q.localIPs) == 0:
+		return models.Readiness{State: models.ReadinessWaitingForSources, Cause: "no source IPs have been found for the iptables rules"}
+	case len(q.remoteIPs) == 0:
+		return models.Readiness{State: models.ReadinessWaitingForDestinations, Cause: "no destination IPs have been resolved for the iptables rules"}
+	}
+	return models.Readiness{State: models.ReadinessWaitingForDestinations, Cause: "the iptables rules couldn't be updated"}
+}
+
+// Clean deletes the jumps to the app's chains, then the chains and therefore all their rules. The rules aren't put
+// back by Reconcile or the updates that follow, so that traffic isn't queued again once the app has stopped filtering it.
+func (q *Rules) Clean(logger *zap.SugaredLogger) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var errs []error
+	for _, j := range q.jumps() {
+		if !hasJump(j) {
+			continue
+		}
+		if out, err := config.Commands.Change("iptables", "-w", "-t", j.table, "-D", j.chain, "-j", j.to); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete the jump from %v to %v: %w: %s", j.chain, j.to, err, strings.TrimSpace(string(out))))
+		}
+	}
+	chains := q.chains()
+	for _, op := range []string{"-F", "-X"} { // empty all the chains first since they jump to each other.
+		for _, table := range []string{tableFilter, tableNAT} {
+			for _, c := range chains[table] {
+				if out, err := config.Commands.Change("iptables", "-w", "-t", table, op, c); err != nil {
+					errs = append(errs, fmt.Errorf("failed to run %v on chain %v: %w: %s", op, c, err, strings.TrimSpace(string(out))))
+				}
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	q.removed = true
+	logger.Info("iptables chains deleted")
+	return nil
+}
@@ -0,0 +1,249 @@
+package iptables
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		assert.NoError(t, os.MkdirAll("testdata", 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(got), 0644))
+	}
+	want, err := os.ReadFile(path)
+	assert.NoError(t, err, "run the tests with -update to create the golden file")
+	assert.Equal(t, string(want), got, "output differs from %v; run the tests with -update if the change is intended", path)
+}
+
+// missingJumps makes the checks for the jumps fail, as on a system without the app's rules.
+func missingJumps(fake *config.FakeExec, cfg *config.FilterConfig) {
+	fake.Results = make(map[string]config.FakeResult)
+	for _, j := range (&Rules{cfg: cfg}).jumps() {
+		fake.Results[config.CommandLine("iptables", "-w", "-t", j.table, "-C", j.chain, "-j", j.to)] = config.FakeResult{Err: errors.New("exit status 1")}
+	}
+}
+
+func testConfig() *config.FilterConfig {
+	return &config.FilterConfig{UDPPorts: []uint16{443}, UDPMode: udpModeQueue, OutboundQueueNumber: 100, InboundQueueNumber: 101}
+}
+
+func TestNewIPTablesRules_Golden(t *testing.T) {
+	fake := config.UseFakeExec(t)
+	cfg := testConfig()
+	missingJumps(fake, cfg)
+	rules, err := NewIPTablesRules(config.MustGetLogger(), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"iptables-restore --noflush " + defaultRestoreFilePath,
+		"iptables -w -t filter -C FORWARD -j TUBETIMEOUT-FORWARD",
+		"iptables -w -t filter -I FORWARD 1 -j TUBETIMEOUT-FORWARD",
+		"iptables -w -t nat -C POSTROUTING -j TUBETIMEOUT-POSTROUTING",
+		"iptables -w -t nat -I POSTROUTING 1 -j TUBETIMEOUT-POSTROUTING",
+	}, fake.Calls())
+	file, _ := fake.File(defaultRestoreFilePath)
+	assertGolden(t, "rules.golden", file)
+	assert.Equal(t, models.ReadinessWaitingForSources, rules.Readiness().State)
+
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.11": {"kids"}, "192.168.1.10": {"kids"}, "fd00::1": {"kids"}})
+	rules.UpdateDestIpDomains(models.MapIpDomain{"142.250.1.1": "youtube.com"})
+	rules.UpdateKillSwitch(true)
+	file, _ = fake.File(defaultRestoreFilePath)
+	assertGolden(t, "rules-ips.golden", file)
+	assert.Equal(t, models.ReadinessReady, rules.Readiness().State)
+	assert.Equal(t, 1, rules.SampleRate("192.168.1.10"), "expected every packet to be queued")
+}
+
+func TestNewIPTablesRules_GoldenBypass(t *testing.T) {
+	fake := config.UseFakeExec(t)
+	cfg := testConfig()
+	cfg.UDPMode = udpModeDrop
+	cfg.LocalDevice = true
+	cfg.BypassDetection = true
+	cfg.BypassBlock = true
+	cfg.BypassPorts = []uint16{853, 51820}
+	cfg.BypassResolverIPs = []string{"1.1.1.1"}
+	rules, err := NewIPTablesRules(config.MustGetLogger(), cfg)
+	require.NoError(t, err)
+	assert.Len(t, rules.jumps(), 4, "expected the gateway's own traffic to be filtered too")
+
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}, "192.168.1.11": {"kids"}})
+	rules.UpdateBypassBlocked([]models.Ip{"192.168.1.11"})
+	file, _ := fake.File(defaultRestoreFilePath)
+	assertGolden(t, "rules-bypass.golden", file)
+
+	cfg.BypassResolverIPs = []string{"dns.google"}
+	_, err = NewIPTablesRules(config.MustGetLogger(), cfg)
+	assert.Error(t, err)
+}
+
+func TestRules_UpdateFailOpen(t *testing.T) {
+	fake := config.UseFakeExec(t)
+	rules, err := NewIPTablesRules(config.MustGetLogger(), testConfig())
+	require.NoError(t, err)
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}})
+	rules.UpdateKillSwitch(true)
+
+	rules.UpdateFailOpen(true)
+	file, _ := fake.File(defaultRestoreFilePath)
+	assert.Less(t, strings.Index(file, "-A TUBETIMEOUT-FORWARD -j TUBETIMEOUT-KILL\n"), strings.Index(file, "-A TUBETIMEOUT-FORWARD -j RETURN\n"), "expected the kill switch to apply before the traffic is let through")
+	assert.Less(t, strings.Index(file, "-A TUBETIMEOUT-FILTER -j RETURN\n"), strings.Index(file, "-A TUBETIMEOUT-FILTER -s 192.168.1.10/32"), "expected the gateway's own traffic not to be queued either")
+
+	rules.UpdateFailOpen(false)
+	file, _ = fake.File(defaultRestoreFilePath)
+	assert.NotContains(t, file, "RETURN")
+}
+
+func TestRules_Exemptions(t *testing.T) {
+	fake := config.UseFakeExec(t)
+	rules, err := NewIPTablesRules(config.MustGetLogger(), testConfig())
+	require.NoError(t, err)
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}, "192.168.1.11": {"kids"}})
+	rules.UpdateKillSwitch(true)
+
+	// Expect the device exempt by MAC to be let through ahead of the kill switch, and left out of the local IPs.
+	rules.UpdateExemptions([]models.Exemption{{MAC: "AA-BB-CC-DD-EE-FF"}})
+	rules.UpdateSourceIpMACs(models.MapIpMACs{"192.168.1.10": "11-22-33-44-55-66", "192.168.1.11": "AA-BB-CC-DD-EE-FF"})
+	file, _ := fake.File(defaultRestoreFilePath)
+	assert.Less(t, strings.Index(file, "-A TUBETIMEOUT-FORWARD -s 192.168.1.11/32 -j RETURN\n"), strings.Index(file, "-A TUBETIMEOUT-FORWARD -j TUBETIMEOUT-KILL\n"))
+	assert.Contains(t, file, "-A TUBETIMEOUT-FILTER -d 192.168.1.11/32 -j RETURN\n", "expected the gateway's own traffic with the device not to be queued either")
+	assert.NotContains(t, file, "-A TUBETIMEOUT-KILL -s 192.168.1.11/32")
+	assert.Contains(t, file, "-A TUBETIMEOUT-KILL -s 192.168.1.10/32 -j DROP\n")
+	assert.Equal(t, []models.Ip{"192.168.1.10"}, rules.localIPs)
+
+	// Expect the device to be tracked again once it's no longer exempt.
+	rules.UpdateExemptions(nil)
+	file, _ = fake.File(defaultRestoreFilePath)
+	assert.NotContains(t, file, "RETURN")
+	assert.Equal(t, []models.Ip{"192.168.1.10", "192.168.1.11"}, rules.localIPs)
+}
+
+func TestRules_DNSInspection(t *testing.T) {
+	fake := config.UseFakeExec(t)
+	cfg := testConfig()
+	cfg.DNSInspection = true
+	rules, err := NewIPTablesRules(config.MustGetLogger(), cfg)
+	require.NoError(t, err)
+	assert.Contains(t, rules.jumps(), jump{tableFilter, "OUTPUT", chainDNS}, "expected the gateway's own DNS answers to be queued")
+
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}})
+	file, _ := fake.File(defaultRestoreFilePath)
+	assert.Contains(t, file, "-A TUBETIMEOUT-FILTER -p udp --sport 53 -j TUBETIMEOUT-DNS\n")
+	assert.Contains(t, file, "-A TUBETIMEOUT-DNS -d 192.168.1.10/32 -p udp --sport 53 -j NFQUEUE --queue-num 101\n")
+
+	rules.UpdateFailOpen(true)
+	file, _ = fake.File(defaultRestoreFilePath)
+	assert.NotContains(t, file, "-A TUBETIMEOUT-DNS ", "expected the answers not to be queued while failing open")
+
+	cfg.LocalDevice = true
+	assert.NotContains(t, rules.jumps(), jump{tableFilter, "OUTPUT", chainDNS}, "expected the local device jumps to cover the gateway's answers")
+}
+
+func TestRules_LAN(t *testing.T) {
+	fake := config.UseFakeExec(t)
+	cfg := testConfig()
+	cfg.DNSInspection = true
+	cfg.BypassDetection = true
+	cfg.BypassPorts = []uint16{53}
+	cfg.LANCIDRs = []string{"192.168.0.0/16", "192.168.1.0/24", "10.0.0.0/8"}
+	rules, err := NewIPTablesRules(config.MustGetLogger(), cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"10.0.0.0/8", "192.168.0.0/16"}, rules.lanNets)
+
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}})
+	rules.UpdateDestIpDomains(models.MapIpDomain{"192.168.1.20": "youtube.com"})
+	file, _ := fake.File(defaultRestoreFilePath)
+	assertGolden(t, "rules-lan.golden", file)
+	lan := "-A TUBETIMEOUT-FILTER -s 192.168.0.0/16 -d 192.168.0.0/16 -j RETURN\n"
+	assert.Less(t, strings.Index(file, "-A TUBETIMEOUT-FILTER -p udp --sport 53 -j TUBETIMEOUT-DNS\n"), strings.Index(file, lan), "expected the DNS answers on the LAN to be inspected")
+	assert.Less(t, strings.Index(file, lan), strings.Index(file, "-A TUBETIMEOUT-FILTER -p tcp -j TUBETIMEOUT-LOCAL-SRC\n"), "expected the LAN traffic not to be queued")
+	assert.Less(t, strings.Index(file, "-A TUBETIMEOUT-BYPASS -s 192.168.0.0/16 -d 192.168.0.0/16 -j RETURN\n"), strings.Index(file, "-A TUBETIMEOUT-BYPASS -s 192.168.1.10/32"), "expected a resolver on the LAN not to count as a bypass")
+
+	cfg.LANCIDRs = []string{"192.168.1.1"}
+	_, err = NewIPTablesRules(config.MustGetLogger(), cfg)
+	assert.Error(t, err)
+}
+
+func TestRules_Reconcile(t *testing.T) {
+	fake := config.UseFakeExec(t)
+	cfg := testConfig()
+	rules, err := NewIPTablesRules(config.MustGetLogger(), cfg)
+	require.NoError(t, err)
+
+	repaired, err := rules.Reconcile()
+	assert.NoError(t, err)
+	assert.False(t, repaired)
+	assert.Equal(t, models.HealthOK, rules.Health().Status)
+
+	missingJumps(fake, cfg) // e.g. after "iptables -F".
+	assert.Equal(t, models.HealthUnhealthy, rules.Health().Status)
+	repaired, err = rules.Reconcile()
+	assert.NoError(t, err)
+	assert.True(t, repaired)
+	assert.Contains(t, fake.Calls(), "iptables -w -t filter -I FORWARD 1 -j TUBETIMEOUT-FORWARD")
+	assert.Equal(t, 1, rules.repairs)
+}
+
+func TestRules_Clean(t *testing.T) {
+	fake := config.UseFakeExec(t)
+	rules, err := NewIPTablesRules(config.MustGetLogger(), testConfig())
+	require.NoError(t, err)
+	n := len(fake.Calls())
+	assert.NoError(t, rules.Clean(config.MustGetLogger()))
+	calls := fake.Calls()[n:]
+	assert.Contains(t, calls, "iptables -w -t filter -D FORWARD -j TUBETIMEOUT-FORWARD")
+	assert.Less(t, slices.Index(calls, "iptables -w -t filter -F TUBETIMEOUT-LOCAL-DST"), slices.Index(calls, "iptables -w -t filter -X TUBETIMEOUT-FILTER"), "expected the chains to be emptied before any are deleted")
+	assert.Contains(t, calls, "iptables -w -t nat -X TUBETIMEOUT-POSTROUTING")
+
+	// Expect the rules not to be put back once they've been removed.
+	n = len(fake.Calls())
+	repaired, err := rules.Reconcile()
+	assert.NoError(t, err)
+	assert.False(t, repaired)
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}})
+	rules.UpdateFailOpen(true)
+	assert.Len(t, fake.Calls(), n, "expected no iptables commands after Clean")
+}
+
+func TestRules_Snapshot(t *testing.T) {
+	fake := config.UseFakeExec(t)
+	rules, err := NewIPTablesRules(config.MustGetLogger(), testConfig())
+	require.NoError(t, err)
+	fake.Results = map[string]config.FakeResult{
+		"iptables -w -t filter -S": {Output: "-P FORWARD ACCEPT\n-N TUBETIMEOUT-FORWARD\n-N TUBETIMEOUT-LOCAL-SRC\n-N OTHER\n" +
+			"-A FORWARD -j TUBETIMEOUT-FORWARD\n-A TUBETIMEOUT-FORWARD -j TUBETIMEOUT-KILL\n" +
+			"-A TUBETIMEOUT-LOCAL-SRC -s 192.168.1.10/32 -j TUBETIMEOUT-REMOTE-DST\n-A OTHER -j ACCEPT\n"},
+	}
+	snap, err := rules.Snapshot()
+	require.NoError(t, err)
+	assert.Equal(t, "iptables", snap.Family)
+	if assert.Len(t, snap.Chains, 2) {
+		assert.Equal(t, models.NFTChain{Name: "TUBETIMEOUT-FORWARD", Type: "filter", Hook: "forward", Rules: []models.NFTRule{{Handle: 1, Exprs: []string{"-j TUBETIMEOUT-KILL"}}}}, snap.Chains[0])
+	}
+	assert.Equal(t, []models.NFTSet{
+		{Name: "local_ip_set", KeyType: "ipv4_addr", Elements: []string{"192.168.1.10"}},
+		{Name: "remote_ip_set", KeyType: "ipv4_addr", Elements: []string{}},
+	}, snap.Sets)
+}
+
+func TestMultiports(t *testing.T) {
+	ports := make([]uint16, 16)
+	for i := range ports {
+		ports[i] = uint16(i + 1)
+	}
+	assert.Equal(t, []string{"1,2,3,4,5,6,7,8,9,10,11,12,13,14,15", "16"}, multiports(ports))
+	assert.Empty(t, multiports(nil))
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"go.uber.org/zap"
+	"relloyd/tubetimeout/models"
 )
 
 var (
@@ -60,6 +61,17 @@ func (c *Checker) IsEnabled() Status {
 	}
 }
 
+// Health reports IPv6 as degraded while it is enabled, since IPv6 traffic isn't filtered.
+func (c *Checker) Health() models.SubsystemHealth {
+	status := c.IsEnabled()
+	h := models.SubsystemHealth{Name: "ipv6", Status: models.HealthOK, Details: status}
+	if status.Enabled {
+		h.Status = models.HealthDegraded
+		h.Message = "IPv6 detected - disable IPv6 on the router"
+	}
+	return h
+}
+
 // isIPv6Enabled tries to dial a public IPv6 address.
 // Returns true if connection is successful, else false.
 func isIPv6Enabled() bool {
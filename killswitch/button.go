@@ -0,0 +1,71 @@
+package killswitch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"relloyd/tubetimeout/config"
+)
+
+var (
+	gpioSysfsPath      = "/sys/class/gpio"
+	buttonPollInterval = 50 * time.Millisecond
+)
+
+// WatchButton toggles the switch each time the button on cfg.ButtonPin is pressed, until ctx is done.
+// The pin is read using the sysfs GPIO interface, so it's the GPIO number in /sys/class/gpio rather than the header
+// pin number. Holding the button down toggles the switch once.
+func (s *Switch) WatchButton(ctx context.Context, cfg *config.KillSwitchConfig) error {
+	valuePath, err := exportGPIOInput(cfg.ButtonPin)
+	if err != nil {
+		return err
+	}
+	pressedValue := "1"
+	if cfg.ButtonActiveLow {
+		pressedValue = "0"
+	}
+
+	go func() {
+		ticker := time.NewTicker(buttonPollInterval)
+		defer ticker.Stop()
+		wasPressed := true // wait for the button to be released first in case it reads pressed while floating.
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				b, err := os.ReadFile(valuePath)
+				if err != nil {
+					s.logger.Errorf("Failed to read kill switch button: %v", err)
+					continue
+				}
+				pressed := strings.TrimSpace(string(b)) == pressedValue
+				if pressed && !wasPressed { // if the button has just been pressed...
+					s.Toggle(SourceButton)
+				}
+				wasPressed = pressed
+			}
+		}
+	}()
+	return nil
+}
+
+// exportGPIOInput makes the pin available in sysfs as an input and returns the path of its value file.
+func exportGPIOInput(pin int) (string, error) {
+	dir := filepath.Join(gpioSysfsPath, "gpio"+strconv.Itoa(pin))
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) { // if the pin hasn't been exported yet...
+		if err = os.WriteFile(filepath.Join(gpioSysfsPath, "export"), []byte(strconv.Itoa(pin)), 0200); err != nil {
+			return "", fmt.Errorf("failed to export GPIO %v: %w", pin, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "direction"), []byte("in"), 0644); err != nil {
+		return "", fmt.Errorf("failed to set GPIO %v as an input: %w", pin, err)
+	}
+	return filepath.Join(dir, "value"), nil
+}
@@ -0,0 +1,58 @@
+package killswitch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"relloyd/tubetimeout/config"
+)
+
+func TestWatchButton(t *testing.T) {
+	defer func(p string, d time.Duration) { gpioSysfsPath, buttonPollInterval = p, d }(gpioSysfsPath, buttonPollInterval)
+	gpioSysfsPath, buttonPollInterval = t.TempDir(), time.Millisecond
+	dir := filepath.Join(gpioSysfsPath, "gpio17")
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	value := filepath.Join(dir, "value")
+	press := func(pressed bool) {
+		v := "1"
+		if pressed {
+			v = "0"
+		}
+		require.NoError(t, os.WriteFile(value, []byte(v+"\n"), 0644))
+	}
+	press(false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := NewSwitch(config.MustGetLogger())
+	require.NoError(t, s.WatchButton(ctx, &config.KillSwitchConfig{ButtonPin: 17, ButtonActiveLow: true}))
+	direction, err := os.ReadFile(filepath.Join(dir, "direction"))
+	assert.NoError(t, err)
+	assert.Equal(t, "in", string(direction))
+
+	// Expect a press to toggle the switch once however long it's held.
+	time.Sleep(20 * time.Millisecond) // let the watcher see the button released first.
+	press(true)
+	assert.Eventually(t, func() bool { return s.State().On }, time.Second, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	assert.True(t, s.State().On)
+	assert.Equal(t, SourceButton, s.State().Source)
+
+	// Expect the next press to turn it off again.
+	press(false)
+	time.Sleep(20 * time.Millisecond)
+	press(true)
+	assert.Eventually(t, func() bool { return !s.State().On }, time.Second, time.Millisecond)
+}
+
+func TestWatchButton_ExportFails(t *testing.T) {
+	defer func(p string) { gpioSysfsPath = p }(gpioSysfsPath)
+	gpioSysfsPath = filepath.Join(t.TempDir(), "missing")
+	s := NewSwitch(config.MustGetLogger())
+	assert.Error(t, s.WatchButton(context.Background(), &config.KillSwitchConfig{ButtonPin: 17}))
+}
@@ -0,0 +1,72 @@
+package killswitch
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/models"
+)
+
+var nowFunc = time.Now
+
+// Sources that change the kill switch.
+const (
+	SourceAPI    = "api"
+	SourceButton = "button"
+)
+
+// Switch is a global hard block of the internet for all tracked devices, e.g. for dinner time, that is toggled from
+// the API or a physical button. It isn't saved so the switch is off after a restart.
+type Switch struct {
+	logger    *zap.SugaredLogger
+	mu        sync.Mutex
+	state     models.KillSwitchState
+	receivers []models.KillSwitchReceiver
+}
+
+func NewSwitch(logger *zap.SugaredLogger) *Switch {
+	return &Switch{logger: logger}
+}
+
+// RegisterKillSwitchReceivers registers receivers to be notified when the switch is turned on or off.
+func (s *Switch) RegisterKillSwitchReceivers(receivers ...models.KillSwitchReceiver) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.receivers = append(s.receivers, receivers...)
+}
+
+// State returns whether the switch is on and what last changed it.
+func (s *Switch) State() models.KillSwitchState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Set turns the switch on or off and notifies the receivers if it changed.
+func (s *Switch) Set(on bool, source string) models.KillSwitchState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(on, source)
+	return s.state
+}
+
+// Toggle flips the switch and notifies the receivers.
+func (s *Switch) Toggle(source string) models.KillSwitchState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.set(!s.state.On, source)
+	return s.state
+}
+
+// set changes the state and notifies the receivers, which is done under the mutex so they see changes in order.
+func (s *Switch) set(on bool, source string) {
+	if s.state.On == on {
+		return
+	}
+	s.state = models.KillSwitchState{On: on, Since: nowFunc(), Source: source}
+	s.logger.Infof("Kill switch turned on=%v by %v", on, source)
+	for _, r := range s.receivers {
+		r.UpdateKillSwitch(on)
+	}
+}
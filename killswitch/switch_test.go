@@ -0,0 +1,42 @@
+package killswitch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+)
+
+type mockReceiver struct {
+	updates []bool
+}
+
+func (m *mockReceiver) UpdateKillSwitch(on bool) {
+	m.updates = append(m.updates, on)
+}
+
+func TestSwitch(t *testing.T) {
+	now := time.Date(2025, 1, 10, 18, 0, 0, 0, time.UTC)
+	defer func(f func() time.Time) { nowFunc = f }(nowFunc)
+	nowFunc = func() time.Time { return now }
+
+	s := NewSwitch(config.MustGetLogger())
+	r := &mockReceiver{}
+	s.RegisterKillSwitchReceivers(r)
+	assert.False(t, s.State().On, "expected the switch to start off")
+
+	state := s.Set(true, SourceAPI)
+	assert.True(t, state.On)
+	assert.Equal(t, now, state.Since)
+	assert.Equal(t, SourceAPI, state.Source)
+
+	now = now.Add(time.Minute)
+	s.Set(true, SourceButton)
+	assert.Equal(t, SourceAPI, s.State().Source, "expected no change when the switch is already on")
+
+	state = s.Toggle(SourceButton)
+	assert.False(t, state.On)
+	assert.Equal(t, now, state.Since)
+	assert.Equal(t, []bool{true, false}, r.updates, "expected receivers to hear about changes only")
+}
@@ -3,17 +3,18 @@
 package led
 
 import (
+	"context"
 	"testing"
 
 	"relloyd/tubetimeout/config"
 )
 
 func TestController_EnableWarning(t *testing.T) {
-	lc := NewController(config.MustGetLogger())
+	lc := NewController(context.Background(), config.MustGetLogger())
 	lc.EnableWarning()
 }
 
 func TestController_DisableWarning(t *testing.T) {
-	lc := NewController(config.MustGetLogger())
+	lc := NewController(context.Background(), config.MustGetLogger())
 	lc.DisableWarning()
 }
@@ -1,100 +1,263 @@
 package led
 
 import (
-	"os"
-	"path/filepath"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
 
 	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
 )
 
 var (
-	sysfsPath = "/sys/class/leds"
+	defaultSettingsFilePath = "leds.yaml"
+	fnGetSettings           = config.GetConfig[Settings]
 )
 
-type Config struct {
-	Name              string
-	EnableTrigger     string
-	EnableBrightness  string
-	DisableTrigger    string
-	DisableBrightness string
+func init() {
+	config.Backups.Register(defaultSettingsFilePath, "LED settings")
 }
 
+// Signal is a state of the box that's shown on an LED.
+type Signal string
+
+const (
+	SignalKillSwitch       = Signal("killSwitch")       // SignalKillSwitch shows while the kill switch is on.
+	SignalDHCPDown         = Signal("dhcpDown")         // SignalDHCPDown shows while the local DHCP service isn't serving.
+	SignalNoUpstream       = Signal("noUpstream")       // SignalNoUpstream shows while no tracked domains can be resolved.
+	SignalThresholdTripped = Signal("thresholdTripped") // SignalThresholdTripped shows while any group is over its threshold.
+)
+
+// signalPriority orders the signals so that the first active signal mapped to an LED is shown.
+var signalPriority = []Signal{SignalKillSwitch, SignalDHCPDown, SignalNoUpstream, SignalThresholdTripped}
+
+// Settings is the leds.yaml file in the app's home directory. The LEDs of known boards and the default signals are
+// used for anything left out.
+type Settings struct {
+	LEDs    []LEDConfig             `yaml:"leds"`
+	Signals map[Signal]SignalConfig `yaml:"signals"`
+}
+
+// LEDConfig is an LED that signals can be shown on. Set one of Sysfs or GPIO.
+type LEDConfig struct {
+	Name      string `yaml:"name"`      // Name is used by the signals to choose the LED.
+	Sysfs     string `yaml:"sysfs"`     // Sysfs is the name of the LED in /sys/class/leds, e.g. ACT.
+	GPIO      *int   `yaml:"gpio"`      // GPIO is the sysfs GPIO number of a pin wired to an LED.
+	ActiveLow bool   `yaml:"activeLow"` // ActiveLow is true if a GPIO LED lights while the pin is low.
+	Idle      string `yaml:"idle"`      // Idle is the pattern shown while no signal is, off if empty.
+}
+
+// SignalConfig shows a signal on an LED with a pattern, e.g. "heartbeat" or the blink code "blink-2".
+type SignalConfig struct {
+	LED     string `yaml:"led"`
+	Pattern string `yaml:"pattern"`
+}
+
+// board is a device with status LEDs that's detected by the first of its LEDs being in sysfs.
+type board struct {
+	name string
+	leds []LEDConfig
+}
+
+// knownBoards are the boards whose LEDs are used when leds.yaml doesn't list any.
+var knownBoards = []board{
+	{name: "OrangePi Zero3", leds: []LEDConfig{{Name: "status", Sysfs: "red:status", Idle: "off"}}},
+	{name: "Raspberry Pi Zero 2 W", leds: []LEDConfig{{Name: "status", Sysfs: "ACT", Idle: "on"}}},
+}
+
+// defaultSignals show every signal on the status LED of the known boards. The DHCP warning keeps the heartbeat it had
+// before there were other signals.
+var defaultSignals = map[Signal]SignalConfig{
+	SignalKillSwitch:       {LED: "status", Pattern: "blink"},
+	SignalDHCPDown:         {LED: "status", Pattern: "heartbeat"},
+	SignalNoUpstream:       {LED: "status", Pattern: "blink-3"},
+	SignalThresholdTripped: {LED: "status", Pattern: "blink-1"},
+}
+
+// ledState is an LED and the pattern it's showing.
+type ledState struct {
+	cfg     LEDConfig
+	player  *player
+	showing string // showing is the name of the pattern being shown, guarded by the Controller's mu.
+}
+
+// Controller shows the signals sent by other subsystems on the LEDs, e.g. a blink code while the internet is down.
+// It implements the kill switch, threshold state and upstream state receivers, and the DHCP server's warning.
 type Controller struct {
-	name       string
-	basePath   string
-	trigger    string
-	brightness string
-	logger     *zap.SugaredLogger
-	exists     bool
-	config     Config
-}
-
-// List of known LED configurations
-var knownLEDs = []Config{
-	{ // OrangePiZero3
-		Name:              "red:status",
-		EnableTrigger:     "heartbeat",
-		EnableBrightness:  "",
-		DisableTrigger:    "none",
-		DisableBrightness: "0",
-	},
-	{ // RaspberryPi Zero 2w
-		Name:              "ACT",
-		EnableTrigger:     "heartbeat",
-		EnableBrightness:  "",
-		DisableTrigger:    "default-on",
-		DisableBrightness: "1",
-	},
-}
-
-func NewController(logger *zap.SugaredLogger) *Controller {
-	for _, cfg := range knownLEDs {
-		base := filepath.Join(sysfsPath, cfg.Name)
-		if _, err := os.Stat(base); err == nil {
-			logger.Infof("Using LED: %s", cfg.Name)
-			return &Controller{
-				name:       cfg.Name,
-				basePath:   base,
-				trigger:    filepath.Join(base, "trigger"),
-				brightness: filepath.Join(base, "brightness"),
-				logger:     logger,
-				exists:     true,
-				config:     cfg,
+	logger   *zap.SugaredLogger
+	leds     map[string]*ledState
+	signals  map[Signal]SignalConfig
+	mu       sync.Mutex
+	active   map[Signal]bool       // active are the signals to show, guarded by mu.
+	exceeded map[models.Group]bool // exceeded are the groups over their thresholds, guarded by mu.
+}
+
+// NewController loads the LED settings and shows the signals on the LEDs until ctx is done. If no LEDs can be used,
+// the controller does nothing.
+func NewController(ctx context.Context, logger *zap.SugaredLogger) *Controller {
+	l := &Controller{
+		logger:   logger,
+		leds:     make(map[string]*ledState),
+		active:   make(map[Signal]bool),
+		exceeded: make(map[models.Group]bool),
+	}
+
+	var mu sync.Mutex
+	settings, err := fnGetSettings(&mu, defaultSettingsFilePath, func() Settings { return Settings{} })
+	if err != nil {
+		logger.Errorf("Failed to load LED settings, using the defaults: %v", err)
+		settings = Settings{}
+	}
+	if err = l.setup(settings); err != nil {
+		logger.Errorf("Bad LED settings, using the defaults: %v", err)
+		l.leds = make(map[string]*ledState)
+		_ = l.setup(Settings{})
+	}
+
+	if len(l.leds) == 0 {
+		logger.Warn("No LEDs found. LED control will be disabled.")
+		return l
+	}
+	for name, s := range l.leds {
+		go s.player.run(ctx)
+		logger.Infof("Using LED %q", name)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.apply()
+	return l
+}
+
+// setup opens the LEDs in settings, or those of the first known board found, and checks the signals.
+func (l *Controller) setup(settings Settings) error {
+	leds := settings.LEDs
+	if len(leds) == 0 {
+		for _, b := range knownBoards {
+			if l.open(b.leds[0]) == nil { // if the board's first LED exists...
+				l.logger.Infof("Using the LEDs of board %v", b.name)
+				leds = b.leds[1:]
+				break
 			}
 		}
 	}
+	for _, cfg := range leds {
+		if err := l.open(cfg); err != nil {
+			return err
+		}
+	}
 
-	logger.Warn("No known LED sysfs path found. LED control will be disabled.")
-	return &Controller{
-		logger: logger,
-		exists: false,
+	for signal, sc := range settings.Signals {
+		if !slices.Contains(signalPriority, signal) {
+			return fmt.Errorf("unknown signal %q", signal)
+		}
+		if _, ok := l.leds[sc.LED]; !ok {
+			return fmt.Errorf("signal %v uses unknown LED %q", signal, sc.LED)
+		}
+		if _, err := lookupPattern(sc.Pattern); err != nil {
+			return fmt.Errorf("signal %v: %w", signal, err)
+		}
 	}
+	l.signals = maps.Clone(defaultSignals) // defaults for LEDs that don't exist are never shown.
+	maps.Copy(l.signals, settings.Signals)
+	return nil
 }
 
-func (l *Controller) EnableWarning() {
-	if !l.exists {
-		l.logger.Warn("EnableWarning called, but no LED available on this hardware.")
-		return
+// open adds the LED so that signals can be shown on it.
+func (l *Controller) open(cfg LEDConfig) error {
+	if cfg.Name == "" {
+		return fmt.Errorf("LED needs a name")
+	}
+	if _, ok := l.leds[cfg.Name]; ok {
+		return fmt.Errorf("LED %q is listed twice", cfg.Name)
 	}
-	l.writeLEDAttribute(l.trigger, l.config.EnableTrigger)
-	l.writeLEDAttribute(l.brightness, l.config.EnableBrightness)
+	if cfg.Idle == "" {
+		cfg.Idle = "off"
+	}
+	if _, err := lookupPattern(cfg.Idle); err != nil {
+		return fmt.Errorf("LED %q: %w", cfg.Name, err)
+	}
+
+	var lt light
+	var err error
+	switch {
+	case cfg.Sysfs != "" && cfg.GPIO != nil:
+		return fmt.Errorf("LED %q needs one of sysfs or gpio, not both", cfg.Name)
+	case cfg.Sysfs != "":
+		lt, err = newSysfsLight(cfg.Sysfs)
+	case cfg.GPIO != nil:
+		lt, err = newGPIOLight(*cfg.GPIO, cfg.ActiveLow)
+	default:
+		return fmt.Errorf("LED %q needs a sysfs name or gpio number", cfg.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	name := cfg.Name
+	l.leds[name] = &ledState{cfg: cfg, player: newPlayer(lt, func(err error) {
+		l.logger.Warnf("Failed to set LED %q: %v", name, err)
+	})}
+	return nil
+}
+
+// EnableWarning shows that the local DHCP service isn't serving.
+func (l *Controller) EnableWarning() {
+	l.setSignal(SignalDHCPDown, true)
 }
 
+// DisableWarning clears the DHCP warning.
 func (l *Controller) DisableWarning() {
-	if !l.exists {
-		l.logger.Warn("DisableWarning called, but no LED available on this hardware.")
-		return
-	}
-	l.writeLEDAttribute(l.trigger, l.config.DisableTrigger)
-	l.writeLEDAttribute(l.brightness, l.config.DisableBrightness)
+	l.setSignal(SignalDHCPDown, false)
+}
+
+// UpdateKillSwitch implements the KillSwitchReceiver interface to show that the kill switch is on.
+func (l *Controller) UpdateKillSwitch(on bool) {
+	l.setSignal(SignalKillSwitch, on)
+}
+
+// UpdateUpstreamState implements the UpstreamStateReceiver interface to show that the internet looks to be down.
+func (l *Controller) UpdateUpstreamState(up bool) {
+	l.setSignal(SignalNoUpstream, !up)
 }
 
-// writeLEDAttribute writes the given value to the given sysfs file, if the value is not empty.
-func (l *Controller) writeLEDAttribute(path, value string) {
-	if value == "" {
-		return
+// UpdateThresholdState implements the ThresholdStateReceiver interface to show that a group is over its threshold.
+func (l *Controller) UpdateThresholdState(group models.Group, exceeded bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if exceeded {
+		l.exceeded[group] = true
+	} else {
+		delete(l.exceeded, group)
 	}
-	if err := os.WriteFile(path, []byte(value), 0644); err != nil {
-		l.logger.Warnf("Failed to write '%s' to %s: %v", value, path, err)
+	l.active[SignalThresholdTripped] = len(l.exceeded) > 0
+	l.apply()
+}
+
+func (l *Controller) setSignal(signal Signal, on bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active[signal] = on
+	l.apply()
+}
+
+// apply shows the first active signal of each LED, else its idle pattern. This should be done under the mutex.
+func (l *Controller) apply() {
+	for name, s := range l.leds {
+		want := s.cfg.Idle
+		for _, signal := range signalPriority {
+			if sc := l.signals[signal]; l.active[signal] && sc.LED == name {
+				want = sc.Pattern
+				break
+			}
+		}
+		if want == s.showing {
+			continue
+		}
+		p, _ := lookupPattern(want) // patterns are checked by setup.
+		s.player.play(p)
+		s.showing = want
 	}
 }
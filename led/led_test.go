@@ -1,21 +1,27 @@
 package led
 
 import (
+	"context"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap/zaptest"
 )
 
-func createTestLEDConfig(t *testing.T, basePath string, config Config) {
+// createTestLED creates a sysfs LED directory for name with the given max brightness.
+func createTestLED(t *testing.T, basePath, name, maxBrightness string) {
 	t.Helper()
 
-	ledPath := filepath.Join(basePath, config.Name)
+	ledPath := filepath.Join(basePath, name)
 	require.NoError(t, os.MkdirAll(ledPath, 0755))
-	require.NoError(t, os.WriteFile(filepath.Join(ledPath, "trigger"), []byte(""), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(ledPath, "trigger"), []byte("mmc0"), 0644))
 	require.NoError(t, os.WriteFile(filepath.Join(ledPath, "brightness"), []byte(""), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(ledPath, "max_brightness"), []byte(maxBrightness), 0644))
 }
 
 func readFileContent(t *testing.T, path string) string {
@@ -25,64 +31,138 @@ func readFileContent(t *testing.T, path string) string {
 	return string(content)
 }
 
-func TestEnableWarning(t *testing.T) {
-	tmpDir := t.TempDir()
-
-	// Override sysfsPath for the test
-	originalSysfsPath := sysfsPath
-	sysfsPath = tmpDir
-	defer func() { sysfsPath = originalSysfsPath }()
-
-	cfg := Config{
-		Name:              "test-led",
-		EnableTrigger:     "heartbeat",
-		EnableBrightness:  "1",
-		DisableTrigger:    "none",
-		DisableBrightness: "0",
+// useSettings overrides the sysfs paths, known boards and settings for the test.
+func useSettings(t *testing.T, settings Settings, boards ...board) {
+	originalSysfs, originalGPIO, originalBoards, originalGet := sysfsPath, gpioSysfsPath, knownBoards, fnGetSettings
+	t.Cleanup(func() {
+		sysfsPath, gpioSysfsPath, knownBoards, fnGetSettings = originalSysfs, originalGPIO, originalBoards, originalGet
+	})
+	sysfsPath, gpioSysfsPath, knownBoards = t.TempDir(), t.TempDir(), boards
+	fnGetSettings = func(_ *sync.Mutex, _ string, _ func() Settings) (Settings, error) {
+		return settings, nil
 	}
-	createTestLEDConfig(t, tmpDir, cfg)
-	knownLEDs = []Config{cfg}
-
-	logger := zaptest.NewLogger(t).Sugar()
-	ctrl := NewController(logger)
-
-	require.True(t, ctrl.exists)
-	ctrl.EnableWarning()
-
-	triggerPath := filepath.Join(tmpDir, cfg.Name, "trigger")
-	brightnessPath := filepath.Join(tmpDir, cfg.Name, "brightness")
+}
 
-	require.Equal(t, "heartbeat", readFileContent(t, triggerPath))
-	require.Equal(t, "1", readFileContent(t, brightnessPath))
+// showing returns the pattern each LED is showing.
+func showing(l *Controller) map[string]string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	retval := make(map[string]string)
+	for name, s := range l.leds {
+		retval[name] = s.showing
+	}
+	return retval
 }
 
-func TestDisableWarning(t *testing.T) {
-	tmpDir := t.TempDir()
+func TestLookupPattern(t *testing.T) {
+	p, err := lookupPattern("blink-2")
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{blinkCodeFlash, blinkCodeFlash, blinkCodeFlash, blinkCodeFlash + blinkCodePause}, p.steps)
 
-	// Override sysfsPath for the test
-	originalSysfsPath := sysfsPath
-	sysfsPath = tmpDir
-	defer func() { sysfsPath = originalSysfsPath }()
+	p, err = lookupPattern("on")
+	require.NoError(t, err)
+	assert.True(t, p.on)
+	assert.Empty(t, p.steps)
 
-	cfg := Config{
-		Name:              "test-led",
-		EnableTrigger:     "heartbeat",
-		EnableBrightness:  "1",
-		DisableTrigger:    "none",
-		DisableBrightness: "0",
+	for _, name := range []string{"blink-0", "blink-10", "disco"} {
+		_, err = lookupPattern(name)
+		assert.Error(t, err, name)
 	}
-	createTestLEDConfig(t, tmpDir, cfg)
-	knownLEDs = []Config{cfg}
+}
 
-	logger := zaptest.NewLogger(t).Sugar()
-	ctrl := NewController(logger)
+func TestController_KnownBoard(t *testing.T) {
+	useSettings(t, Settings{}, board{name: "other", leds: []LEDConfig{{Name: "status", Sysfs: "missing"}}},
+		board{name: "test", leds: []LEDConfig{{Name: "status", Sysfs: "ACT", Idle: "on"}}})
+	createTestLED(t, sysfsPath, "ACT", "255")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := NewController(ctx, zaptest.NewLogger(t).Sugar())
+	require.Len(t, l.leds, 1)
+	assert.Equal(t, "none", readFileContent(t, filepath.Join(sysfsPath, "ACT", "trigger")), "expected the kernel trigger to be turned off")
+	assert.Eventually(t, func() bool {
+		return readFileContent(t, filepath.Join(sysfsPath, "ACT", "brightness")) == "255"
+	}, time.Second, time.Millisecond, "expected the idle pattern to be shown")
+
+	// Expect the signal with the highest priority to be shown.
+	l.UpdateThresholdState("kids", true)
+	assert.Equal(t, "blink-1", showing(l)["status"])
+	l.EnableWarning()
+	l.UpdateUpstreamState(false)
+	assert.Equal(t, "heartbeat", showing(l)["status"])
+	l.UpdateKillSwitch(true)
+	assert.Equal(t, "blink", showing(l)["status"])
+
+	l.UpdateKillSwitch(false)
+	l.DisableWarning()
+	assert.Equal(t, "blink-3", showing(l)["status"])
+	l.UpdateUpstreamState(true)
+	l.UpdateThresholdState("teens", true)
+	l.UpdateThresholdState("kids", false)
+	assert.Equal(t, "blink-1", showing(l)["status"], "expected the threshold signal to show while any group is over")
+	l.UpdateThresholdState("teens", false)
+	assert.Equal(t, "on", showing(l)["status"])
+}
 
-	require.True(t, ctrl.exists)
-	ctrl.DisableWarning()
+func TestController_Settings(t *testing.T) {
+	pin := 17
+	useSettings(t, Settings{
+		LEDs: []LEDConfig{
+			{Name: "status", Sysfs: "red:status"},
+			{Name: "alert", GPIO: &pin, ActiveLow: true},
+		},
+		Signals: map[Signal]SignalConfig{
+			SignalDHCPDown:   {LED: "alert", Pattern: "blink-2"},
+			SignalNoUpstream: {LED: "alert", Pattern: "on"},
+		},
+	}, board{name: "test", leds: []LEDConfig{{Name: "status", Sysfs: "ACT"}}})
+	createTestLED(t, sysfsPath, "red:status", "1")
+	gpioDir := filepath.Join(gpioSysfsPath, "gpio17")
+	require.NoError(t, os.MkdirAll(gpioDir, 0755))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l := NewController(ctx, zaptest.NewLogger(t).Sugar())
+	require.Len(t, l.leds, 2)
+	assert.Equal(t, "out", readFileContent(t, filepath.Join(gpioDir, "direction")))
+
+	l.UpdateUpstreamState(false)
+	l.UpdateKillSwitch(true)
+	assert.Equal(t, map[string]string{"status": "blink", "alert": "on"}, showing(l))
+	assert.Eventually(t, func() bool {
+		return readFileContent(t, filepath.Join(gpioDir, "value")) == "0"
+	}, time.Second, time.Millisecond, "expected an active low LED to be lit by a low pin")
+	l.EnableWarning()
+	assert.Equal(t, "blink-2", showing(l)["alert"])
+	l.DisableWarning()
+	l.UpdateUpstreamState(true)
+	assert.Equal(t, "off", showing(l)["alert"])
+}
 
-	triggerPath := filepath.Join(tmpDir, cfg.Name, "trigger")
-	brightnessPath := filepath.Join(tmpDir, cfg.Name, "brightness")
+func TestController_BadSettings(t *testing.T) {
+	for name, settings := range map[string]Settings{
+		"unknown LED":     {Signals: map[Signal]SignalConfig{SignalDHCPDown: {LED: "alert", Pattern: "on"}}},
+		"unknown pattern": {Signals: map[Signal]SignalConfig{SignalDHCPDown: {LED: "status", Pattern: "disco"}}},
+		"unknown signal":  {Signals: map[Signal]SignalConfig{"disco": {LED: "status", Pattern: "on"}}},
+		"missing LED":     {LEDs: []LEDConfig{{Name: "status", Sysfs: "missing"}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			useSettings(t, settings, board{name: "test", leds: []LEDConfig{{Name: "status", Sysfs: "ACT"}}})
+			createTestLED(t, sysfsPath, "ACT", "1")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			l := NewController(ctx, zaptest.NewLogger(t).Sugar())
+			assert.Equal(t, map[string]string{"status": "off"}, showing(l), "expected the board defaults to be used")
+			assert.Equal(t, defaultSignals, l.signals)
+		})
+	}
+}
 
-	require.Equal(t, "none", readFileContent(t, triggerPath))
-	require.Equal(t, "0", readFileContent(t, brightnessPath))
+func TestController_NoLEDs(t *testing.T) {
+	useSettings(t, Settings{}, board{name: "test", leds: []LEDConfig{{Name: "status", Sysfs: "ACT"}}})
+	l := NewController(context.Background(), zaptest.NewLogger(t).Sugar())
+	assert.Empty(t, l.leds)
+	l.EnableWarning() // expect no panic without LEDs.
+	l.UpdateKillSwitch(true)
 }
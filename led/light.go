@@ -0,0 +1,76 @@
+package led
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	sysfsPath     = "/sys/class/leds"
+	gpioSysfsPath = "/sys/class/gpio"
+)
+
+// light turns an LED on or off.
+type light interface {
+	set(on bool) error
+}
+
+// sysfsLight is an LED in /sys/class/leds, e.g. a board's status LED. Its kernel trigger is turned off so that the
+// patterns are timed by the controller.
+type sysfsLight struct {
+	brightness string
+	max        string // max is the brightness written to turn the LED on.
+}
+
+func newSysfsLight(name string) (*sysfsLight, error) {
+	base := filepath.Join(sysfsPath, name)
+	if _, err := os.Stat(base); err != nil {
+		return nil, fmt.Errorf("LED %v not found: %w", name, err)
+	}
+	if err := os.WriteFile(filepath.Join(base, "trigger"), []byte("none"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to turn off the trigger of LED %v: %w", name, err)
+	}
+	l := &sysfsLight{brightness: filepath.Join(base, "brightness"), max: "1"}
+	if b, err := os.ReadFile(filepath.Join(base, "max_brightness")); err == nil && strings.TrimSpace(string(b)) != "" {
+		l.max = strings.TrimSpace(string(b))
+	}
+	return l, nil
+}
+
+func (l *sysfsLight) set(on bool) error {
+	value := "0"
+	if on {
+		value = l.max
+	}
+	return os.WriteFile(l.brightness, []byte(value), 0644)
+}
+
+// gpioLight is an LED wired to a GPIO pin, using the sysfs GPIO number rather than the header pin number.
+type gpioLight struct {
+	value     string
+	activeLow bool // activeLow is true if the LED lights while the pin is low, e.g. an LED wired to 3.3V.
+}
+
+func newGPIOLight(pin int, activeLow bool) (*gpioLight, error) {
+	dir := filepath.Join(gpioSysfsPath, "gpio"+strconv.Itoa(pin))
+	if _, err := os.Stat(dir); os.IsNotExist(err) { // if the pin hasn't been exported yet...
+		if err = os.WriteFile(filepath.Join(gpioSysfsPath, "export"), []byte(strconv.Itoa(pin)), 0200); err != nil {
+			return nil, fmt.Errorf("failed to export GPIO %v: %w", pin, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "direction"), []byte("out"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to set GPIO %v as an output: %w", pin, err)
+	}
+	return &gpioLight{value: filepath.Join(dir, "value"), activeLow: activeLow}, nil
+}
+
+func (l *gpioLight) set(on bool) error {
+	value := "0"
+	if on != l.activeLow {
+		value = "1"
+	}
+	return os.WriteFile(l.value, []byte(value), 0644)
+}
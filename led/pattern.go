@@ -0,0 +1,105 @@
+package led
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	blinkCodeFlash = 200 * time.Millisecond  // blinkCodeFlash is how long each flash of a blink code and the gap after it last.
+	blinkCodePause = 1500 * time.Millisecond // blinkCodePause is the gap between repeats of a blink code.
+	maxBlinkCode   = 9
+)
+
+// pattern is a sequence of steps that alternate the LED on and off, starting on, and repeat. A pattern without steps
+// holds the LED on or off.
+type pattern struct {
+	steps []time.Duration
+	on    bool
+}
+
+// patterns are the named patterns besides the blink codes, "blink-1" to "blink-9", which flash the LED that many
+// times then pause.
+var patterns = map[string]pattern{
+	"off":       {},
+	"on":        {on: true},
+	"blink":     {steps: []time.Duration{500 * time.Millisecond, 500 * time.Millisecond}},
+	"fast":      {steps: []time.Duration{100 * time.Millisecond, 100 * time.Millisecond}},
+	"heartbeat": {steps: []time.Duration{100 * time.Millisecond, 150 * time.Millisecond, 100 * time.Millisecond, 650 * time.Millisecond}},
+}
+
+// lookupPattern returns the named pattern.
+func lookupPattern(name string) (pattern, error) {
+	if p, ok := patterns[name]; ok {
+		return p, nil
+	}
+	if s, ok := strings.CutPrefix(name, "blink-"); ok {
+		if n, err := strconv.Atoi(s); err == nil && n >= 1 && n <= maxBlinkCode {
+			p := pattern{steps: make([]time.Duration, 2*n)}
+			for i := range p.steps {
+				p.steps[i] = blinkCodeFlash
+			}
+			p.steps[len(p.steps)-1] += blinkCodePause
+			return p, nil
+		}
+	}
+	return pattern{}, fmt.Errorf("unknown LED pattern %q", name)
+}
+
+// player shows patterns on a light. Patterns are sent to next and played by run.
+type player struct {
+	light light
+	next  chan pattern
+	onErr func(err error)
+}
+
+func newPlayer(l light, onErr func(err error)) *player {
+	return &player{light: l, next: make(chan pattern, 1), onErr: onErr}
+}
+
+// play replaces the pattern being shown. It doesn't block, so it should only be called by one goroutine at a time.
+func (p *player) play(pat pattern) {
+	select {
+	case <-p.next: // drop a pattern that hasn't been shown yet.
+	default:
+	}
+	p.next <- pat
+}
+
+// run shows the patterns sent to play until ctx is done.
+func (p *player) run(ctx context.Context) {
+	var steps []time.Duration
+	step := 0
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case pat := <-p.next:
+			timer.Stop()
+			steps, step = pat.steps, 0
+			if len(steps) == 0 {
+				p.set(pat.on)
+				continue
+			}
+		case <-timer.C:
+			if len(steps) == 0 { // if the pattern changed as the timer fired...
+				continue
+			}
+			step = (step + 1) % len(steps)
+		}
+		p.set(step%2 == 0)
+		timer.Reset(steps[step])
+	}
+}
+
+func (p *player) set(on bool) {
+	if err := p.light.set(on); err != nil && p.onErr != nil {
+		p.onErr(err)
+	}
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -12,15 +14,38 @@ import (
 	"time"
 
 	"go.uber.org/zap"
+	"relloyd/tubetimeout/apikeys"
+	"relloyd/tubetimeout/audit"
+	"relloyd/tubetimeout/bypass"
 	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/control"
 	"relloyd/tubetimeout/dhcp"
+	"relloyd/tubetimeout/dnsblock"
+	"relloyd/tubetimeout/dnsforward"
+	"relloyd/tubetimeout/enforcer"
+	"relloyd/tubetimeout/eventbus"
+	"relloyd/tubetimeout/exempt"
+	"relloyd/tubetimeout/firewall"
 	"relloyd/tubetimeout/group"
+	"relloyd/tubetimeout/grpcapi"
+	"relloyd/tubetimeout/install"
+	"relloyd/tubetimeout/inventory"
 	"relloyd/tubetimeout/ipv6"
+	"relloyd/tubetimeout/killswitch"
 	"relloyd/tubetimeout/led"
+	"relloyd/tubetimeout/models"
 	"relloyd/tubetimeout/monitor"
 	"relloyd/tubetimeout/nfq"
-	"relloyd/tubetimeout/nft"
+	"relloyd/tubetimeout/notify"
+	"relloyd/tubetimeout/pihole"
+	"relloyd/tubetimeout/privilege"
+	"relloyd/tubetimeout/profile"
+	"relloyd/tubetimeout/report"
+	"relloyd/tubetimeout/storage"
+	"relloyd/tubetimeout/telemetry"
+	"relloyd/tubetimeout/timerequest"
 	"relloyd/tubetimeout/usage"
+	"relloyd/tubetimeout/watchdog"
 	"relloyd/tubetimeout/web"
 )
 
@@ -35,6 +60,38 @@ import (
 
 type cleanupFunc func() error
 
+type reloadFunc func() error
+
+// handleReload runs the reload functions in order each time SIGHUP is received, so that configuration changes
+// can be applied without a full restart (and the delayed start that comes with it).
+func handleReload(ctx context.Context, logger *zap.SugaredLogger, reloadFuncs []reloadFunc) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				signal.Stop(sigs)
+				return
+			case <-sigs:
+				logger.Info("SIGHUP received, reloading config...")
+				failure := false
+				for _, f := range reloadFuncs {
+					if err := f(); err != nil {
+						logger.Errorf("Error during reload: %v", err)
+						failure = true
+					}
+				}
+				if failure {
+					logger.Warn("Reload completed with errors")
+				} else {
+					logger.Info("Reload complete")
+				}
+			}
+		}
+	}()
+}
+
 func handleDelayedStart(logger *zap.SugaredLogger, appConfig *config.AppConfig) {
 	if appConfig.DelayStart && !appConfig.DebugConfig.DebugEnabled { // if we should delay startup, and we're not in debug mode...
 		delay := time.Second * 30
@@ -61,14 +118,99 @@ func handleDebugging(logger *zap.SugaredLogger, appCfg *config.DebugConfig) {
 
 func recoverFunc(logger *zap.Logger) {
 	if r := recover(); r != nil {
-		logger.Error("Recovered from panic",
-			zap.Any("message", r),
-			zap.String("stack", string(debug.Stack())),
-		)
+		logPanic(logger, r)
 	}
 }
 
+// recoverFuncWatched returns a recoverFunc that also counts each panic with the watchdog.
+func recoverFuncWatched(wd *watchdog.Watchdog) func(logger *zap.Logger) {
+	return func(logger *zap.Logger) {
+		if r := recover(); r != nil {
+			wd.RecordPanic()
+			logPanic(logger, r)
+		}
+	}
+}
+
+func logPanic(logger *zap.Logger, r any) {
+	logger.Error("Recovered from panic",
+		zap.Any("message", r),
+		zap.String("stack", string(debug.Stack())),
+	)
+}
+
+// runFsck checks the persisted config and samples files and prints a report, repairing them if -repair is given.
+// It returns the exit code, which is non-zero if a file still needs attention.
+func runFsck(args []string) int {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "fix files with problems and quarantine files that can't be used, keeping a copy of each original")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(fs.Output(), "Usage: tubetimeout fsck [-repair]")
+		_, _ = fmt.Fprintln(fs.Output(), "Checks the files in the app home directory. Stop the service first.")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	results, err := config.Fsck.Run(*repair, time.Now())
+	clean := config.WriteFsckReport(os.Stdout, results)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "fsck failed: %v\n", err)
+		return 2
+	}
+	if !clean {
+		_, _ = fmt.Fprintln(os.Stdout, "Run tubetimeout fsck -repair to fix the problems found.")
+		return 1
+	}
+	return 0
+}
+
+// runInstall sets the device up to run the app as a systemd service, or removes the setup if -uninstall is given.
+// It returns the exit code, which is non-zero if a step failed.
+func runInstall(args []string) int {
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	uninstall := fs.Bool("uninstall", false, "stop and disable the service and remove its systemd unit and sysctl settings")
+	dryRun := fs.Bool("dry-run", false, "log the commands and system files that would change instead of running or writing them")
+	binary, _ := os.Executable()
+	fs.StringVar(&binary, "binary", binary, "the path of the binary for the service to run, e.g. /usr/local/bin/tt to pick up upgrades made by make install")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintln(fs.Output(), "Usage: tubetimeout install [-uninstall] [-dry-run] [-binary path]")
+		_, _ = fmt.Fprintln(fs.Output(), "Checks the dependencies, writes the systemd unit and turns on IPv4 forwarding. Run it as root.")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if *dryRun {
+		config.Commands = config.NewDryRunExec(config.MustGetLogger())
+	} else if !privilege.Detect().Root {
+		_, _ = fmt.Fprintln(os.Stderr, "install must be run as root")
+		return 2
+	}
+	var err error
+	if *uninstall {
+		err = install.Uninstall(os.Stdout)
+	} else {
+		err = install.Install(os.Stdout, binary)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "install failed: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fsck" { // if we're run as the maintenance command...
+		os.Exit(runFsck(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "install" { // if we're run to set up the service...
+		os.Exit(runInstall(os.Args[2:]))
+	}
+	dryRun := flag.Bool("dry-run", false, "log the commands, system files and router blocks that would change the network setup instead of making them")
+	flag.Parse()
+	if *dryRun {
+		config.AppCfg.DryRun = true
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -79,6 +221,13 @@ func main() {
 	}(logger)
 
 	logger.Infof("Build version %v", config.BuildVersion)
+	if err := install.CheckDependencies(); err != nil {
+		logger.Fatalf("Error: %v. Please ensure the commands are installed and available on your PATH, or run tubetimeout install to check the setup.", err)
+	}
+	if config.AppCfg.DryRun {
+		config.Commands = config.NewDryRunExec(logger)
+		logger.Warn("Dry run: commands and files that change the network setup are logged instead of run, and the router and DHCP clients are left alone")
+	}
 
 	// Recovery.
 	defer recoverFunc(logger.Desugar())
@@ -86,6 +235,10 @@ func main() {
 	// Cleanup functions.
 	var cleanupFuncs []cleanupFunc
 
+	// Watchdog to ping systemd while the app is alive, starting now so that the delayed start counts.
+	wd := watchdog.NewWatchdog(logger.Named("watchdog"), &config.AppCfg.WatchdogConfig)
+	wd.Start(ctx)
+
 	handleDelayedStart(logger, &config.AppCfg)
 	handleDebugging(logger, &config.AppCfg.DebugConfig)
 
@@ -93,58 +246,298 @@ func main() {
 	ipv6Checker := ipv6.NewIPv6Checker(ctx, logger)
 	logger.Info("IPv6 status checker created")
 
+	// LEDs for DHCP warnings, kill switch, upstream and threshold feedback.
+	ledController := led.NewController(ctx, logger)
+
+	// Turn off the features that the process doesn't have the capabilities for, instead of failing when they start.
+	privileges := privilege.Detect()
+	if !config.AppCfg.DHCPServerDisabled {
+		if err := dhcp.CheckPrivileges(privileges); err != nil {
+			logger.Warnf("DHCP management is disabled: %v", err)
+			config.AppCfg.DHCPServerDisabled = true
+		}
+	}
+	if port := config.AppCfg.WebConfig.WebPort; config.AppCfg.WebConfig.WebEnabled && port < 1024 && !privileges.Has(privilege.CapNetBindService) {
+		logger.Warnf("The web server may fail to listen on port %v without %v, set WEB_PORT to 1024 or above to use it without", port, privilege.CapNetBindService)
+	}
+	if port := config.AppCfg.WebConfig.TLSPort; config.AppCfg.WebConfig.WebEnabled && config.AppCfg.WebConfig.TLSEnabled && port < 1024 && !privileges.Has(privilege.CapNetBindService) {
+		logger.Warnf("The web server may fail to listen on port %v without %v, set WEB_TLS_PORT to 1024 or above to use it without", port, privilege.CapNetBindService)
+	}
+
+	// The subsystems publish their changes to the bus, and the consumers below subscribe to the topics they need.
+	bus := eventbus.NewBus()
+
 	// Maybe start DHCP server.
-	dhcpServer, err := dhcp.NewServer(ctx, logger, config.AppCfg.DHCPServerDisabled, led.NewController(logger))
+	dhcpServer, err := dhcp.NewServer(ctx, logger.Named("dhcp"), config.AppCfg.DHCPServerDisabled, ledController, bus)
 	if err != nil {
 		logger.Fatalf("Failed to setup DHCP server: %v", err)
 	}
 	cleanupFuncs = append(cleanupFuncs, dhcpServer.Stop)
 
-	// NFT rules to send traffic to NFQueue.
-	// There won't be any NFT rules until dest IPs are supplied by manager callbacks.
-	rules, err := nft.NewNFTRules(logger, &config.AppCfg.FilterConfig)
-	if err != nil {
-		logger.Fatal("Failed to setup nft rules:", err)
+	// Check that the devices' traffic can pass through this gateway, e.g. that there's no double NAT.
+	topology := dhcp.NewTopologyChecker(logger.Named("topology"), dhcpServer)
+	topology.Start(ctx, config.AppCfg.DHCPConfig.TopologyCheckInterval)
+
+	// Pick free queue numbers before the NFT rules are generated since they reference them.
+	if err = nfq.ResolveQueueNumbers(logger, &config.AppCfg.FilterConfig); err != nil {
+		logger.Fatal("Failed to resolve NFQueue numbers:", err)
+	}
+
+	// Storage for the tracker's samples and mode history.
+	var closeStorage cleanupFunc
+	switch config.AppCfg.StorageConfig.Backend {
+	case storage.BackendFile:
+	case storage.BackendSQLite:
+		dbFile, err := config.DefaultStore.Path(config.AppCfg.StorageConfig.Path)
+		if err != nil {
+			logger.Fatalf("Failed to get the storage database path: %v", err)
+		}
+		db, err := storage.NewSQLiteStore(ctx, logger, dbFile, config.AppCfg.StorageConfig.FlushInterval)
+		if err != nil {
+			logger.Fatalf("Failed to open the storage database: %v", err)
+		}
+		closeStorage = db.Close
+		storage.Default = db
+		logger.Infof("Samples and mode history are stored in %q", dbFile)
+	default:
+		logger.Fatalf("Unknown STORAGE_BACKEND %q, expected %v or %v", config.AppCfg.StorageConfig.Backend, storage.BackendFile, storage.BackendSQLite)
 	}
-	logger.Info("NFTables rules created")
 
 	// Usage tracker.
-	t, err := usage.NewTracker(ctx, logger, &config.AppCfg.TrackerConfig)
+	t, err := usage.NewTracker(ctx, logger.Named("usage"), &config.AppCfg.TrackerConfig, bus)
 	if err != nil {
 		logger.Fatalln("Failed to setup usage tracker:", err)
 	}
 	logger.Info("Usage tracker created")
 
+	// Firewall rules to send traffic to NFQueue, with nftables or iptables.
+	// There won't be any rules until dest IPs are supplied by manager callbacks.
+	// The tracker decides which groups only need a sample of packets queued.
+	rules, err := firewall.New(logger.Named("firewall"), &config.AppCfg.FilterConfig, t)
+	if err != nil {
+		logger.Fatal("Failed to setup firewall rules:", err)
+	}
+	bus.ThresholdStates.Subscribe(eventbus.ThresholdStateFunc(rules))
+	bus.ThresholdStates.Subscribe(eventbus.ThresholdStateFunc(ledController))
+	rules.StartReconciler(ctx, config.AppCfg.FilterConfig.NFTReconcileInterval)
+	logger.Info("Firewall rules created")
+
+	// Devices whose traffic the firewall leaves alone, e.g. work laptops, so that it's never queued.
+	var exemptions web.ExemptionList
+	if list, err := exempt.NewList(logger); err != nil {
+		logger.Errorf("Failed to setup the exempt devices: %v", err)
+	} else {
+		list.RegisterExemptionReceivers(rules)
+		exemptions = list
+		logger.Info("Exempt devices loaded")
+	}
+
+	// Kill switch to block all tracked devices from the API or a button.
+	killSwitch := killswitch.NewSwitch(logger)
+	killSwitch.RegisterKillSwitchReceivers(rules, t, ledController)
+	if config.AppCfg.KillSwitchConfig.ButtonPin >= 0 {
+		if err = killSwitch.WatchButton(ctx, &config.AppCfg.KillSwitchConfig); err != nil {
+			logger.Errorf("Failed to watch kill switch button: %v", err)
+		}
+	}
+
+	// Notifications about thresholds, manual blocks, the DHCP service, bypass attempts, time requests, the watchdog and weekly reports.
+	var reportSender report.Sender
+	var bypassReceivers []models.BypassAttemptReceiver
+	var timeRequestReceivers []models.TimeRequestReceiver
+	if notifier, err := notify.NewNotifier(ctx, logger); err != nil {
+		logger.Errorf("Failed to setup notifications: %v", err)
+	} else {
+		bus.Live.Subscribe(notifier.PublishEvent)
+		bus.ModeTransitions.Subscribe(notifier.UpdateModeTransition)
+		bus.WarningStates.Subscribe(eventbus.WarningStateFunc(notifier))
+		bus.DHCPStates.Subscribe(notifier.UpdateDHCPState)
+		bus.Failsafe.Subscribe(notifier.UpdateFailsafe)
+		reportSender = notifier
+		bypassReceivers = append(bypassReceivers, notifier)
+		timeRequestReceivers = append(timeRequestReceivers, notifier)
+	}
+
+	// Maybe spot tracked devices using VPNs or other DNS resolvers to get around the filter.
+	var bypassDetector nfq.BypassDetector
+	var detector *bypass.Detector
+	if config.AppCfg.FilterConfig.BypassDetection {
+		detector, err = bypass.NewDetector(ctx, logger, &config.AppCfg.FilterConfig, rules)
+		if err != nil {
+			logger.Fatalln("Failed to setup bypass detection:", err)
+		}
+		detector.RegisterBypassAttemptReceivers(bypassReceivers...)
+		bypassDetector = detector
+		logger.Info("Bypass detector created")
+	}
+
 	// Traffic Monitor.
-	trafficMap := monitor.NewTrafficMap(logger, 5)
+	trafficMap := monitor.NewTrafficMap(logger.Named("monitor"), 5, bus)
 	logger.Info("Traffic monitor started")
 
 	// Group manager.
-	mgr := group.NewManager(logger)
+	mgr := group.NewManager(logger.Named("group"))
 	logger.Info("Group manager created")
 
+	// Maybe read the Pi-hole query log to find hosts of tracked domains that devices look up.
+	var piholeWatcher *pihole.Watcher
+	if config.AppCfg.PiholeConfig.URL != "" {
+		piholeWatcher = pihole.NewWatcher(logger, &config.AppCfg.PiholeConfig)
+		logger.Infof("Pi-hole watcher created for %v", config.AppCfg.PiholeConfig.URL)
+	}
+
+	// Maybe serve DNS to the devices from this gateway, which the DHCP server hands out in place of dnsmasq.
+	var dnsForwarder *dnsforward.Forwarder
+	var dnsForwarderAPI web.DNSForwarder
+	if config.AppCfg.DNSForwarderConfig.Enabled {
+		dnsForwarder, err = dnsforward.NewForwarder(logger.Named("dnsforward"), &config.AppCfg.DNSForwarderConfig)
+		if err != nil {
+			logger.Fatalln("Failed to setup DNS forwarder:", err)
+		}
+		dnsForwarderAPI = dnsForwarder
+		logger.Info("DNS forwarder created")
+	}
+
 	// Sources.
-	w := group.NewNetWatcher(logger)
-	w.RegisterSourceIpGroupsReceivers(mgr, rules)
-	w.RegisterSourceIpMACReceivers(trafficMap)
+	w := group.NewNetWatcher(logger.Named("group"), bus)
+	w.SetIdentitySource(dhcpServer) // bind devices using private MACs to their identity in the DHCP leases.
+	config.GroupMACs.RegisterIdentitySource(dhcpServer)
+	bus.SourceIpGroups.Subscribe(mgr.UpdateSourceIpGroups)
+	bus.SourceIpGroups.Subscribe(rules.UpdateSourceIpGroups)
+	bus.SourceIpGroups.Subscribe(topology.UpdateSourceIpGroups)
+	if piholeWatcher != nil {
+		bus.SourceIpGroups.Subscribe(piholeWatcher.UpdateSourceIpGroups)
+	}
+	if detector != nil {
+		bus.SourceIpGroups.Subscribe(detector.UpdateSourceIpGroups)
+	}
+	if dnsForwarder != nil {
+		bus.SourceIpGroups.Subscribe(dnsForwarder.UpdateSourceIpGroups)
+	}
+	bus.SourceIpMACs.Subscribe(trafficMap.UpdateSourceIpMACs)
+	bus.SourceIpMACs.Subscribe(mgr.UpdateSourceIpMACs)
+	bus.SourceIpMACs.Subscribe(rules.UpdateSourceIpMACs)
+	if config.AppCfg.DiscoveryConfig.DiscoveryEnabled { // if we should suggest names for devices from mDNS/SSDP...
+		discovery := group.NewDiscovery(logger)
+		bus.SourceIpMACs.Subscribe(discovery.UpdateSourceIpMACs)
+		config.GroupMACs.RegisterNameSource(discovery.Name)
+		discovery.Start(ctx)
+	}
+	var routerInventory *inventory.Inventory
+	if config.AppCfg.InventoryConfig.Backend != "" { // if we should add the devices in the router's client table...
+		routerInventory, err = inventory.NewInventory(logger, &config.AppCfg.InventoryConfig)
+		if err != nil {
+			logger.Fatalln("Failed to setup router inventory:", err)
+		}
+		config.GroupMACs.RegisterDeviceSources(routerInventory)
+		routerInventory.Start(ctx)
+		logger.Infof("Router inventory created for %v", config.AppCfg.InventoryConfig.Backend)
+	}
 	w.Start(ctx)
+	group.NewProber(logger, dhcpServer.Range).Start(ctx, config.AppCfg.DiscoveryConfig.ProbeInterval)
 	logger.Info("Sources mapped")
 
 	// Destinations.
-	dw := group.NewDomainWatcher(logger)
-	dw.RegisterDestIpGroupReceivers(mgr)
-	dw.RegisterDestDomainGroupReceivers(mgr)     // TODO: remove unused DestDomainGroupReceivers in mgr if/when the proxy feature is removed as it is essentially wasted effort keeping the structs sync'd.
-	dw.RegisterDestIpDomainReceivers(mgr, rules) // TODO: remove unused DestIpDomainReceivers in mgr if/when the proxy feature is removed as it is essentially wasted effort keeping the structs sync'd.
+	dw := group.NewDomainWatcher(logger.Named("group"), bus)
+	bus.DestIpGroups.Subscribe(mgr.UpdateDestIpGroups)
+	bus.DestDomainGroups.Subscribe(mgr.UpdateDestDomainGroups) // TODO: remove unused DestDomainGroupReceivers in mgr if/when the proxy feature is removed as it is essentially wasted effort keeping the structs sync'd.
+	bus.DestIpDomains.Subscribe(mgr.UpdateDestIpDomains)       // TODO: remove unused DestIpDomainReceivers in mgr if/when the proxy feature is removed as it is essentially wasted effort keeping the structs sync'd.
+	bus.DestIpDomains.Subscribe(rules.UpdateDestIpDomains)
+	bus.UpstreamState.Subscribe(ledController.UpdateUpstreamState)
+	dw.RegisterAllowlistSources(t)
+	bus.AllowedIpGroups.Subscribe(mgr.UpdateAllowedIpGroups)
+	if piholeWatcher != nil {
+		bus.DestDomainGroups.Subscribe(piholeWatcher.UpdateDestDomainGroups)
+		dw.RegisterDomainSources(piholeWatcher)
+		piholeWatcher.Start(ctx)
+	}
+	if dnsForwarder != nil {
+		dnsForwarder.RegisterAnswerObservers(dw) // filter the IPs of tracked domains as soon as devices look them up.
+		dnsForwarder.Start(ctx)
+	}
+
+	// Maybe block domains via dnsmasq while groups are over their thresholds.
+	if config.AppCfg.DNSBlockConfig.DNSBlockEnabled {
+		dnsBlocker := dnsblock.NewController(logger, &config.AppCfg.DNSBlockConfig, dhcpServer)
+		bus.ThresholdStates.Subscribe(eventbus.ThresholdStateFunc(dnsBlocker))
+		bus.DestDomainGroups.Subscribe(dnsBlocker.UpdateDestDomainGroups)
+		logger.Info("DNS block controller created")
+	}
+
+	// Maybe mirror group block state to the router's own client blocking.
+	var routerMirror *enforcer.Mirror
+	if config.AppCfg.RouterConfig.Backend != "" {
+		routerMirror, err = enforcer.NewMirror(ctx, logger, &config.AppCfg.RouterConfig)
+		if err != nil {
+			logger.Fatalln("Failed to setup router enforcer:", err)
+		}
+		bus.ThresholdStates.Subscribe(eventbus.ThresholdStateFunc(routerMirror))
+		logger.Infof("Router enforcer created for %v", config.AppCfg.RouterConfig.Backend)
+	}
+
+	// Maybe record each group's minutes and domains for weekly reports.
+	var reports web.UsageReports
+	var destinationCounter models.DestinationCounter
+	if config.AppCfg.ReportConfig.ReportEnabled {
+		reporter, err := report.NewReporter(ctx, logger, &config.AppCfg.ReportConfig, t, reportSender)
+		if err != nil {
+			logger.Errorf("Failed to setup weekly reports: %v", err)
+		} else {
+			bus.DestIpDomains.Subscribe(reporter.UpdateDestIpDomains)
+			reports, destinationCounter = reporter, reporter
+			logger.Info("Weekly reports created")
+		}
+	}
+
 	dw.Start(ctx)
 	logger.Info("Destinations mapped")
 
 	// NFQueue to process packets in user space.
-	q, err := nfq.NewNFQueueFilter(ctx, logger, &config.AppCfg.FilterConfig, t, mgr, trafficMap, recoverFunc)
+	q, err := nfq.NewNFQueueFilter(ctx, logger.Named("nfq"), &config.AppCfg.FilterConfig, t, mgr, trafficMap, rules, destinationCounter, bypassDetector, dw, bus, recoverFuncWatched(wd))
 	if err != nil {
 		logger.Fatalln("Failed to setup NFQueue filter:", err)
 	}
+	bus.FailOpen.Subscribe(rules.UpdateFailOpen) // let the tracked traffic bypass the queues if the filter falls too far behind.
+	wd.Watch(ctx, q, rules, bus)                 // remove the rules if the filter stops responding altogether.
 	logger.Info("NFQueue listener started")
 
+	// Opt-in anonymized usage statistics.
+	tel, err := telemetry.NewReporter(ctx, logger, &config.AppCfg.TelemetryConfig, t, config.GroupMACs, q)
+	if err != nil {
+		logger.Fatalln("Failed to setup telemetry reporter:", err)
+	}
+	logger.Info("Telemetry reporter created")
+
+	// Configuration profiles, e.g. holiday mode, applied to the trackers from the API or on a schedule.
+	var profiles web.ProfileScheduler
+	if scheduler, err := profile.NewScheduler(ctx, logger, t); err != nil {
+		logger.Errorf("Failed to setup configuration profiles: %v", err)
+	} else {
+		profiles = scheduler
+		logger.Info("Configuration profiles loaded")
+	}
+
+	// Requests for more time made by devices from /my-time, for an admin to approve or deny.
+	var timeRequests web.TimeRequestQueue
+	if queue, err := timerequest.NewQueue(logger, t); err != nil {
+		logger.Errorf("Failed to setup time requests: %v", err)
+	} else {
+		queue.RegisterTimeRequestReceivers(timeRequestReceivers...)
+		timeRequests = queue
+	}
+
+	// Nightly snapshots of the config and tracker state, in case the SD card is corrupted.
+	var snapshotter *config.Snapshotter
+	var snapshots web.SnapshotStore
+	if config.AppCfg.SnapshotConfig.Time != "" {
+		if s, err := config.NewSnapshotter(logger.Named("snapshot"), &config.AppCfg.SnapshotConfig); err != nil {
+			logger.Errorf("Failed to setup snapshots: %v", err)
+		} else {
+			s.Start(ctx)
+			snapshotter, snapshots = s, s
+		}
+	}
+
 	cleanupFuncs = append(cleanupFuncs, func() error {
 		// Cancel the NFQ before closing NFQ else it will block!
 		// We probably want to remove the NFT rules before closing the NFQ but NFQ will have packets in flight that it cannot Accept with error: "netlink send: sendmsg: bad file descriptor".
@@ -152,7 +545,7 @@ func main() {
 		cancel()
 		err = rules.Clean(logger)
 		if err != nil {
-			return fmt.Errorf("error removing NFT rules: %w", err)
+			return fmt.Errorf("error removing firewall rules: %w", err)
 		}
 		for _, nf := range q.Nfq {
 			err = nf.Close() // cancel its context above before calling Close() else it will block.
@@ -165,15 +558,132 @@ func main() {
 
 	// Web server start.
 	if config.AppCfg.WebConfig.WebEnabled {
-		s := web.NewServer(logger, t, config.GroupMACs, trafficMap, dhcpServer, ipv6Checker)
+		keys, err := apikeys.NewStore()
+		if err != nil {
+			logger.Fatalln("Failed to load API keys:", err)
+		}
+		auditLog, err := audit.NewLog()
+		if err != nil {
+			logger.Fatalln("Failed to setup audit log:", err)
+		}
+		changes := control.NewService(logger.Named("control"), t, config.GroupMACs, auditLog) // shared by the web and gRPC APIs.
+		healthCheckers := []web.HealthChecker{q, rules, wd, dhcpServer, topology, dw, t, ipv6Checker}
+		if routerMirror != nil {
+			healthCheckers = append(healthCheckers, routerMirror)
+		}
+		if piholeWatcher != nil {
+			healthCheckers = append(healthCheckers, piholeWatcher)
+		}
+		if routerInventory != nil {
+			healthCheckers = append(healthCheckers, routerInventory)
+		}
+		if snapshotter != nil {
+			healthCheckers = append(healthCheckers, snapshotter)
+		}
+		if dnsForwarder != nil {
+			healthCheckers = append(healthCheckers, dnsForwarder)
+		}
+		liveHub := web.NewLiveHub() // push usage, activity and block changes to the dashboard as they happen.
+		bus.Live.Subscribe(liveHub.PublishEvent)
+		bus.ThresholdStates.Subscribe(eventbus.ThresholdStateFunc(liveHub))
+		s := web.NewServer(logger.Named("web"), web.ServerDeps{
+			UsageTracker:       t,
+			GroupMACs:          config.GroupMACs,
+			Monitor:            trafficMap,
+			DHCPConfig:         dhcpServer,
+			IPV6Checker:        ipv6Checker,
+			Telemetry:          tel,
+			PacketStats:        q,
+			APIKeys:            keys,
+			AuditLog:           auditLog,
+			Control:            changes,
+			Backup:             config.Backups,
+			HealthCheckers:     healthCheckers,
+			FreshnessSources:   []web.FreshnessSource{w, dw, trafficMap, dhcpServer},
+			ReadinessReporters: []web.ReadinessReporter{w, dw, rules},
+			Placements:         w,
+			LiveEvents:         liveHub,
+			KillSwitch:         killSwitch,
+			NFTDiagnostics:     rules,
+			PacketCapture:      q,
+			ResolutionPauser:   dw,
+			Devices:            trafficMap,
+			Reports:            reports,
+			GroupDomains:       config.GroupDomains,
+			DomainReloader:     dw,
+			Bandwidth:          trafficMap,
+			NetworkDetector:    dhcpServer,
+			DHCPEvents:         dhcpServer,
+			Membership:         mgr,
+			Profiles:           profiles,
+			LogLevels:          config.Logging,
+			TimeRequests:       timeRequests,
+			Snapshots:          snapshots,
+			DNSForwarder:       dnsForwarderAPI,
+			Tracer:             q,
+			Exemptions:         exemptions,
+		})
+		if config.AppCfg.WebConfig.TLSEnabled {
+			if err := web.UseTLS(logger, s, &config.AppCfg.WebConfig); err != nil {
+				logger.Fatalln("Error setting up TLS for the web server:", err)
+			}
+			// Redirect plain HTTP to HTTPS.
+			rs := web.NewRedirectServer(&config.AppCfg.WebConfig)
+			go func() {
+				if err := rs.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					logger.Errorf("Error starting HTTPS redirect server: %v", err)
+				}
+			}()
+			cleanupFuncs = append(cleanupFuncs, func() error {
+				ctxSrv, cancelSrv := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancelSrv()
+				if err := rs.Shutdown(ctxSrv); err != nil {
+					return fmt.Errorf("error shutting down HTTPS redirect server: %w", err)
+				}
+				return nil
+			})
+		}
 		go func() {
-			if err := s.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			var err error
+			if s.TLSConfig != nil {
+				err = s.ListenAndServeTLS("", "") // the certificate comes from TLSConfig.GetCertificate.
+			} else {
+				err = s.ListenAndServe()
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
 				logger.Fatalln("Error starting web server:", err)
 			}
 			logger.Info("Web server quit")
 		}()
 		logger.Info("Web server started")
 
+		if config.AppCfg.WebConfig.GRPCPort > 0 { // if the gRPC API should be served too...
+			gs := grpcapi.NewServer(logger.Named("grpc"), t, config.GroupMACs, trafficMap, w, keys, changes, s.TLSConfig)
+			lis, err := net.Listen("tcp", fmt.Sprintf(":%d", config.AppCfg.WebConfig.GRPCPort))
+			if err != nil {
+				logger.Fatalln("Error listening for the gRPC API:", err)
+			}
+			go func() {
+				if err := gs.Serve(lis); err != nil {
+					logger.Errorf("Error serving the gRPC API: %v", err)
+				}
+			}()
+			logger.Infof("gRPC API started on port %v", config.AppCfg.WebConfig.GRPCPort)
+			cleanupFuncs = append(cleanupFuncs, func() error {
+				stopped := make(chan struct{})
+				go func() {
+					gs.GracefulStop()
+					close(stopped)
+				}()
+				select {
+				case <-stopped:
+				case <-time.After(5 * time.Second): // if calls are still running...
+					gs.Stop()
+				}
+				return nil
+			})
+		}
+
 		cleanupFuncs = append(cleanupFuncs, func() error {
 			// Shutdown the web server.
 			ctxSrv, cancelSrv := context.WithTimeout(context.Background(), 5*time.Second)
@@ -185,6 +695,43 @@ func main() {
 		})
 	}
 
+	// Maybe show blocked devices a "time's up" page instead of dropping their plain HTTP.
+	if config.AppCfg.FilterConfig.BlockPagePort > 0 {
+		bp := web.NewBlockPageServer(logger, t, w, trafficMap)
+		go func() {
+			if err := bp.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Errorf("Error starting block page server: %v", err)
+			}
+		}()
+		logger.Infof("Block page started on port %v", config.AppCfg.FilterConfig.BlockPagePort)
+
+		cleanupFuncs = append(cleanupFuncs, func() error {
+			ctxSrv, cancelSrv := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancelSrv()
+			if err := bp.Shutdown(ctxSrv); err != nil {
+				return fmt.Errorf("error shutting down block page server: %w", err)
+			}
+			return nil
+		})
+	}
+
+	// Reload config on SIGHUP.
+	// App config is applied first so that the components below see the new values. Components that read it from
+	// config.Current pick them up by themselves, while the filter is given its new config.
+	// The NFT sets are rebuilt by the domain and net watchers notifying their receivers.
+	handleReload(ctx, logger, []reloadFunc{
+		func() error { return config.ReloadAppConfig(logger) },
+		func() error { q.SetConfig(&config.Current().FilterConfig); return nil },
+		t.ReloadConfig,
+		dhcpServer.Reload,
+		func() error { w.Reload(); return nil },
+		dw.Reload,
+	})
+
+	if closeStorage != nil { // close the storage last so that it flushes whatever is written while shutting down.
+		cleanupFuncs = append(cleanupFuncs, closeStorage)
+	}
+
 	// Capture SIGINT and SIGTERM to shut down gracefully.
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
@@ -6,13 +6,85 @@ import (
 
 // FlatTrackerConfig is used by the API.
 type FlatTrackerConfig struct {
-	Group         Group            `json:"name"`
-	Retention     time.Duration    `json:"retention"`
-	Threshold     time.Duration    `json:"threshold"`
-	StartDayInt   int              `json:"startDay"`
-	StartDuration time.Duration    `json:"startDuration"`
-	Mode          UsageTrackerMode `json:"mode"`
-	ModeEndTime   time.Time        `json:"modeEndTime"`
+	Group             Group            `json:"name"`
+	Retention         time.Duration    `json:"retention"`
+	Threshold         time.Duration    `json:"threshold"`
+	DayThresholds     []DayThreshold   `json:"dayThresholds"`
+	StartDayInt       int              `json:"startDay"`
+	StartDayOfMonth   int              `json:"startDayOfMonth"`
+	Window            TrackerWindow    `json:"window"`
+	StartDuration     time.Duration    `json:"startDuration"`
+	CountFrom         time.Duration    `json:"countFrom"`
+	CountUntil        time.Duration    `json:"countUntil"`
+	BlockOutsideHours bool             `json:"blockOutsideHours"`
+	MaxSession        time.Duration    `json:"maxSession"`
+	BreakDuration     time.Duration    `json:"breakDuration"`
+	MinActive         time.Duration    `json:"minActive"`
+	MinActiveWindow   time.Duration    `json:"minActiveWindow"`
+	PacketSampling    bool             `json:"packetSampling"`
+	Rollover          RolloverPolicy   `json:"rollover"`
+	RolloverCap       time.Duration    `json:"rolloverCap"`
+	WarnAt            int              `json:"warnAt"`
+	GracePeriod       time.Duration    `json:"gracePeriod"`
+	PacketPolicy      *PacketPolicy    `json:"packetPolicy"`
+	Allowlist         []string         `json:"allowlist"`
+	Categories        []Category       `json:"categories"`
+	Mode              UsageTrackerMode `json:"mode"`
+	ModeEndTime       time.Time        `json:"modeEndTime"`
+}
+
+// Bandwidth is the traffic of a device through the filter in each of the last few minutes, for /api/bandwidth.
+type Bandwidth struct {
+	MAC         MAC               `json:"mac"`
+	IngressKbps int64             `json:"ingressKbps"` // IngressKbps is the download rate over the last complete minute.
+	EgressKbps  int64             `json:"egressKbps"`  // EgressKbps is the upload rate over the last complete minute.
+	Minutes     []BandwidthMinute `json:"minutes"`     // Minutes are oldest first, ending with the current minute so far.
+}
+
+// BandwidthMinute is the bytes a device received and sent in the minute from Start.
+type BandwidthMinute struct {
+	Start        time.Time `json:"start"`
+	IngressBytes int64     `json:"ingressBytes"`
+	EgressBytes  int64     `json:"egressBytes"`
+}
+
+// Sources that activate a profile.
+const (
+	ProfileSourceAPI      = "api"
+	ProfileSourceSchedule = "schedule"
+)
+
+// ProfileState is the profiles and the one that is active, if any, for /api/profiles.
+type ProfileState struct {
+	Active   string    `json:"active,omitempty"`
+	Source   string    `json:"source,omitempty"` // Source says what activated the profile, api or schedule.
+	Since    time.Time `json:"since"`
+	Until    time.Time `json:"until"` // Until is when the profile ends by itself, or zero if it's on until deactivated.
+	Profiles []Profile `json:"profiles"`
+}
+
+// Verdicts of a group's packets in a GroupLookup.
+const (
+	VerdictAccept   = "accept"   // VerdictAccept is given while the group is within its threshold.
+	VerdictThrottle = "throttle" // VerdictThrottle is given while the group is over its threshold, so its Policy applies.
+)
+
+// Lookup is the group membership of a device and how its traffic is being treated, for /api/lookup.
+type Lookup struct {
+	MAC    MAC           `json:"mac,omitempty"` // MAC is empty if the IP looked up isn't known on the LAN.
+	Ips    []Ip          `json:"ips"`           // Ips are the IPs the device was last seen on.
+	Groups []GroupLookup `json:"groups"`
+}
+
+// GroupLookup is the state of one of a device's groups in a Lookup.
+type GroupLookup struct {
+	Group    Group           `json:"group"`
+	Domains  []Domain        `json:"domains"` // Domains are the domains tracked for the group.
+	Tracker  *TrackerSummary `json:"tracker,omitempty"`
+	Mode     *TrackerMode    `json:"mode,omitempty"`
+	Exceeded bool            `json:"exceeded"`
+	Verdict  string          `json:"verdict"`
+	Policy   PacketPolicy    `json:"policy"` // Policy is applied to the group's packets while it's over its threshold.
 }
 
 // TrackerMode is used by the API to return data to the web page.
@@ -23,8 +95,468 @@ type TrackerMode struct {
 
 // TrackerSummary contains the used and total count of a group used by the usage tracker and web for reporting.
 type TrackerSummary struct {
-	Used            int               `json:"used"`
-	Total           int               `json:"total"`
-	Percentage      int               `json:"percentage"`
-	LastActiveTimes map[MAC]time.Time `json:"activity"`
+	Used            int                       `json:"used"`  // Used is the minutes used in the current window.
+	Total           int                       `json:"total"` // Total is the number of samples in the window.
+	Percentage      int                       `json:"percentage"`
+	Threshold       int                       `json:"threshold"` // Threshold is the minutes allowed in the current window, including any day threshold, transfers and time carried over.
+	LastActiveTimes map[MAC]time.Time         `json:"activity"`
+	Devices         map[MAC]*TrackerSummary   `json:"devices,omitempty"`      // Devices contains per-MAC usage when device tracking is enabled.
+	Adjustment      int                       `json:"adjustment"`             // Adjustment is the number of minutes transferred in (positive) or out (negative) for the current window.
+	Carried         int                       `json:"carried"`                // Carried is the number of unused minutes rolled over from the previous window.
+	OutsideHours    bool                      `json:"outsideHours"`           // OutsideHours is true while usage isn't being counted due to the group's counting hours.
+	SessionMinutes  int                       `json:"sessionMinutes"`         // SessionMinutes is the length of the current continuous session.
+	BreakEndTime    *time.Time                `json:"breakEndTime,omitempty"` // BreakEndTime is set while a forced break is in progress.
+	Warning         bool                      `json:"warning"`                // Warning is true while the group is past WarnAt or in its grace period, and not blocked.
+	Categories      map[string]*CategoryUsage `json:"categories,omitempty"`   // Categories is the usage of each of the group's categories.
+	Domains         map[Domain]*DomainTraffic `json:"domains,omitempty"`      // Domains is the traffic counted with each of the group's domains.
+}
+
+// DomainTraffic is the traffic a group has had with a destination domain since it was first seen, until it's gone
+// unseen for the monitor's purge duration.
+type DomainTraffic struct {
+	IngressBytes int64     `json:"ingressBytes"`
+	EgressBytes  int64     `json:"egressBytes"`
+	LastSeen     time.Time `json:"lastSeen"`
+}
+
+// CategoryUsage is the usage of one of a group's categories in the current window.
+type CategoryUsage struct {
+	Used       int  `json:"used"`      // Used is the minutes used in the current window.
+	Threshold  int  `json:"threshold"` // Threshold is the minutes allowed, or 0 if Unlimited.
+	Percentage int  `json:"percentage"`
+	Unlimited  bool `json:"unlimited"`
+	Blocked    bool `json:"blocked"`
+}
+
+// TrackerSamples is the raw usage of a group in its current window, e.g. to draw a per-minute heatmap of the day.
+type TrackerSamples struct {
+	WindowStart time.Time       `json:"windowStart"`
+	WindowEnd   time.Time       `json:"windowEnd"`
+	Granularity int             `json:"granularitySeconds"` // Granularity is the seconds covered by each sample.
+	Samples     []TrackerSample `json:"samples"`            // Samples are in time order from the start of the window.
+}
+
+// TrackerSample says whether a group was active during the Granularity seconds from Time.
+type TrackerSample struct {
+	Time   time.Time `json:"time"`
+	Active bool      `json:"active"`
+}
+
+// PacketRates contains packet counts and rates handled by a single NFQueue.
+type PacketRates struct {
+	Queue          uint16    `json:"queue"`
+	Direction      Direction `json:"direction"`
+	Total          uint64    `json:"total"`
+	PerSecond      float64   `json:"perSecond"`      // packets handled in the last second
+	PerSecondAvg1m float64   `json:"perSecondAvg1m"` // moving average over the last minute
+	Attached       bool      `json:"attached"`       // attached is false once the queue has stopped receiving packets
+	LatencyP50     float64   `json:"latencyP50Ms"`   // LatencyP50 is the median milliseconds from receiving packets to deciding their verdicts over the last minute.
+	LatencyP99     float64   `json:"latencyP99Ms"`   // LatencyP99 is the 99th percentile of the same.
+}
+
+// PacketDecisions counts the decisions made for each group's packets since Since. While Simulated, packets are only
+// counted with what would have been done to them and accepted instead.
+type PacketDecisions struct {
+	Simulated bool             `json:"simulated"`
+	Since     time.Time        `json:"since"`
+	Groups    []GroupDecisions `json:"groups"`
+}
+
+// GroupDecisions counts the decisions made for a group's packets.
+type GroupDecisions struct {
+	Group       Group  `json:"group"`
+	Accepted    uint64 `json:"accepted"`
+	Allowlisted uint64 `json:"allowlisted"` // Allowlisted packets were accepted without being counted.
+	Dropped     uint64 `json:"dropped"`
+	Delayed     uint64 `json:"delayed"`
+	Shaped      uint64 `json:"shaped"` // Shaped packets were held to keep to a rate limit.
+}
+
+// VerdictLatency is the histogram of the time from receiving packets to deciding their verdicts for a single NFQueue.
+type VerdictLatency struct {
+	Queue     uint16
+	Direction Direction
+	Buckets   []LatencyBucket // Buckets are cumulative, in order of UpperBound.
+	Count     uint64          // Count includes verdicts slower than the largest bucket.
+	Sum       time.Duration
+}
+
+// LatencyBucket is the number of verdicts that took UpperBound or less.
+type LatencyBucket struct {
+	UpperBound time.Duration
+	Count      uint64
+}
+
+type HealthStatus string
+
+const (
+	HealthOK        = HealthStatus("ok")
+	HealthDegraded  = HealthStatus("degraded")  // HealthDegraded means the subsystem works but needs attention.
+	HealthUnhealthy = HealthStatus("unhealthy") // HealthUnhealthy means filtering or tracking is broken.
+)
+
+// Worse returns the more severe of s and other.
+func (s HealthStatus) Worse(other HealthStatus) HealthStatus {
+	rank := map[HealthStatus]int{HealthOK: 0, HealthDegraded: 1, HealthUnhealthy: 2}
+	if rank[other] > rank[s] {
+		return other
+	}
+	return s
+}
+
+// SubsystemHealth is the status of a single subsystem returned by /api/health.
+type SubsystemHealth struct {
+	Name    string       `json:"name"`
+	Status  HealthStatus `json:"status"`
+	Message string       `json:"message,omitempty"`
+	Details any          `json:"details,omitempty"`
+}
+
+// ResolverHealth is the status of an upstream DNS resolver reported in the details of the dns subsystem.
+type ResolverHealth struct {
+	Server      string    `json:"server"`
+	Healthy     bool      `json:"healthy"`
+	Failures    int       `json:"failures"` // Failures is the number of consecutive failed lookups.
+	LastError   string    `json:"lastError,omitempty"`
+	LastSuccess time.Time `json:"lastSuccess"`
+}
+
+// LiveEventType is the kind of LiveEvent pushed to the dashboard.
+type LiveEventType string
+
+const (
+	LiveEventSummary      = LiveEventType("summary")      // LiveEventSummary data is a map of group to TrackerSummary for the groups that changed.
+	LiveEventActivity     = LiveEventType("activity")     // LiveEventActivity data is an ActivityEvent.
+	LiveEventVerdict      = LiveEventType("verdict")      // LiveEventVerdict data is a VerdictEvent.
+	LiveEventBackpressure = LiveEventType("backpressure") // LiveEventBackpressure data is a BackpressureEvent.
+	LiveEventFailsafe     = LiveEventType("failsafe")     // LiveEventFailsafe data is a FailsafeEvent.
+)
+
+// LiveEventSchemaVersion is bumped when the data of any LiveEventType changes in a way that breaks consumers.
+// Fields may be added without bumping it.
+const LiveEventSchemaVersion = "v1"
+
+// Schema returns the identifier of the event's data schema, e.g. tubetimeout.verdict.v1.
+func (t LiveEventType) Schema() string {
+	return "tubetimeout." + string(t) + "." + LiveEventSchemaVersion
+}
+
+// LiveEvent is pushed to connected browsers over /ws as it happens, and kept for /api/v1/events/replay.
+type LiveEvent struct {
+	ID     uint64        `json:"id"`     // ID increases with each event published and starts again from 1 when the app restarts.
+	Schema string        `json:"schema"` // Schema identifies the format of Data; see LiveEventType.Schema.
+	Type   LiveEventType `json:"type"`
+	Time   time.Time     `json:"time"`
+	Data   any           `json:"data"`
+}
+
+// EventReplay is returned by /api/v1/events/replay.
+type EventReplay struct {
+	Events    []LiveEvent `json:"events"`
+	Truncated bool        `json:"truncated"` // Truncated is true if events since the requested time have been dropped from the replay buffer.
+}
+
+// ActivityEvent is sent when a device is first seen in a group or its last active time moves on.
+type ActivityEvent struct {
+	Group      Group     `json:"group"`
+	MAC        MAC       `json:"mac"`
+	LastActive time.Time `json:"lastActive"`
+}
+
+// VerdictEvent is sent when a group starts or stops being blocked.
+type VerdictEvent struct {
+	Group   Group `json:"group"`
+	Blocked bool  `json:"blocked"`
+}
+
+// BackpressureLevel is how far the packet handler has backed off because it can't keep up.
+type BackpressureLevel string
+
+const (
+	BackpressureNormal   = BackpressureLevel("normal")   // BackpressureNormal means every packet is handled in full.
+	BackpressureReduced  = BackpressureLevel("reduced")  // BackpressureReduced means work not needed for enforcement is skipped.
+	BackpressureFailOpen = BackpressureLevel("failOpen") // BackpressureFailOpen means the tracked devices' traffic isn't queued.
+)
+
+// BackpressureEvent is sent when the packet handler's backpressure level changes.
+type BackpressureEvent struct {
+	Level  BackpressureLevel `json:"level"`
+	Reason string            `json:"reason"` // Reason says why the level changed, e.g. the worker backlog is full.
+}
+
+// FailsafeEvent is sent when the watchdog removes the firewall rules because the packet handler stopped responding.
+type FailsafeEvent struct {
+	Reason string `json:"reason"`
+}
+
+// TransitionCause says what changed a group between blocked and allowed.
+type TransitionCause string
+
+const (
+	TransitionManual     = TransitionCause("manual")     // TransitionManual means the mode was set from the web page or API.
+	TransitionTracker    = TransitionCause("tracker")    // TransitionTracker means the tracker changed state, e.g. the threshold was reached or a mode expired.
+	TransitionKillSwitch = TransitionCause("killSwitch") // TransitionKillSwitch means the kill switch was turned on or off.
+)
+
+// ModeTransition is a change to whether a group is blocked, returned by /api/mode-history.
+type ModeTransition struct {
+	Time    time.Time        `json:"time"`
+	Group   Group            `json:"group"`
+	Cause   TransitionCause  `json:"cause"`
+	Mode    UsageTrackerMode `json:"mode"`    // Mode is the tracker's mode after the transition.
+	Blocked bool             `json:"blocked"` // Blocked is true if the group is blocked after the transition.
+	Until   time.Time        `json:"until"`   // Until is when a manual mode ends, or zero.
+	Reason  string           `json:"reason"`
+}
+
+// BypassKind is the way a device tried to get around the filter.
+type BypassKind string
+
+const (
+	BypassDNS = BypassKind("dns") // BypassDNS is DNS, or DNS over TLS, to a resolver other than the gateway.
+	BypassDoH = BypassKind("doh") // BypassDoH is traffic to a public DNS over HTTPS resolver.
+	BypassVPN = BypassKind("vpn") // BypassVPN is traffic to a VPN port or endpoint.
+)
+
+// BypassAttempt is a tracked device caught trying to get around the filter.
+type BypassAttempt struct {
+	Time    time.Time  `json:"time"`
+	IP      Ip         `json:"ip"` // IP is the device's local IP.
+	Groups  []Group    `json:"groups"`
+	Kind    BypassKind `json:"kind"`
+	Remote  Ip         `json:"remote"`
+	Port    uint16     `json:"port"`
+	Blocked bool       `json:"blocked"` // Blocked is true if the device's traffic to the bypass ports and IPs is dropped from now on.
+}
+
+// LogLevels is returned by /api/log-level.
+type LogLevels struct {
+	Level    string            `json:"level"`    // Level applies to every package without its own.
+	Packages map[string]string `json:"packages"` // Packages are the levels of the packages that have them, e.g. nfq.
+}
+
+// KillSwitchState is returned by /api/kill-switch.
+type KillSwitchState struct {
+	On     bool      `json:"on"`
+	Since  time.Time `json:"since"`            // Since is when the switch last changed, or zero.
+	Source string    `json:"source,omitempty"` // Source says what last changed the switch, e.g. api or button.
+}
+
+// UsageReport is a group's usage over a week, returned by /api/reports and sent each week as a notification.
+type UsageReport struct {
+	Group           Group            `json:"group"`
+	From            time.Time        `json:"from"`
+	To              time.Time        `json:"to"`
+	TotalMinutes    int              `json:"totalMinutes"`
+	PreviousMinutes int              `json:"previousMinutes"` // PreviousMinutes is the total of the week before, to compare with.
+	Days            []DayUsage       `json:"days"`
+	BusiestHours    []HourOfDayUsage `json:"busiestHours"` // BusiestHours are the hours of the day used most, busiest first.
+	TopDomains      []DomainUsage    `json:"topDomains"`   // TopDomains are the domains with the most traffic, busiest first.
+}
+
+// DayUsage is the minutes a group used on a day of a UsageReport.
+type DayUsage struct {
+	Date    string `json:"date"` // Date is YYYY-MM-DD in local time.
+	Minutes int    `json:"minutes"`
+}
+
+// HourOfDayUsage is the minutes a group used in an hour of the day, e.g. 19 for 7pm, over the days of a UsageReport.
+type HourOfDayUsage struct {
+	Hour    int `json:"hour"`
+	Minutes int `json:"minutes"`
+}
+
+// DomainUsage is the traffic between a group and a domain over the days of a UsageReport.
+type DomainUsage struct {
+	Domain Domain `json:"domain"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// NFTSnapshot is the contents of the app's nftables table as the kernel has it, returned by /api/diagnostics/nft.
+type NFTSnapshot struct {
+	Table  string     `json:"table"`
+	Family string     `json:"family"` // Family is inet, or ip on kernels too old for inet NAT chains.
+	Chains []NFTChain `json:"chains"`
+	Sets   []NFTSet   `json:"sets"`
+}
+
+// NFTChain is a chain in an NFTSnapshot.
+type NFTChain struct {
+	Name     string    `json:"name"`
+	Type     string    `json:"type,omitempty"`
+	Hook     string    `json:"hook,omitempty"` // Hook is empty for chains that are only jumped to.
+	Priority int32     `json:"priority"`
+	Rules    []NFTRule `json:"rules"`
+}
+
+// NFTRule is a rule in an NFTChain with a description of each of its expressions.
+type NFTRule struct {
+	Handle uint64   `json:"handle"`
+	Exprs  []string `json:"exprs"`
+}
+
+// NFTSet is a named set in an NFTSnapshot and its elements, e.g. IP addresses.
+type NFTSet struct {
+	Name     string   `json:"name"`
+	KeyType  string   `json:"keyType"`
+	Elements []string `json:"elements"`
+}
+
+// CaptureState describes the packet capture of a group, returned by /api/capture.
+type CaptureState struct {
+	Group   Group     `json:"group"`
+	Enabled bool      `json:"enabled"`
+	Since   time.Time `json:"since"`          // Since is when the capture was started.
+	Packets int       `json:"packets"`        // Packets is the number of packets held, up to FILTER_CAPTURE_MAX_PACKETS.
+	File    string    `json:"file,omitempty"` // File is the path of the pcap file written when the capture was stopped.
+}
+
+// TraceState describes the trace of a device's verdict decisions, returned by /api/trace.
+type TraceState struct {
+	MAC     MAC       `json:"mac"`
+	Enabled bool      `json:"enabled"`
+	Since   time.Time `json:"since"`          // Since is when the trace was started.
+	Until   time.Time `json:"until"`          // Until is when the trace stops by itself.
+	Entries int       `json:"entries"`        // Entries is the number of decisions held, up to FILTER_TRACE_MAX_ENTRIES.
+	Skipped int       `json:"skipped"`        // Skipped is the number of decisions left out once the trace was full.
+	File    string    `json:"file,omitempty"` // File is the path of the JSON file written when the trace stopped.
+}
+
+// TraceEntry is a verdict decision for one of a traced device's packets in one of its groups, with what it was
+// decided on.
+type TraceEntry struct {
+	Time        time.Time        `json:"time"`
+	Direction   Direction        `json:"direction"`
+	Proto       string           `json:"proto"`
+	Length      int              `json:"length"` // Length is the size of the whole packet in bytes.
+	Device      Ip               `json:"device"`
+	Remote      Ip               `json:"remote"`
+	Port        uint16           `json:"port,omitempty"` // Port is the packet's TCP or UDP destination port.
+	Domain      Domain           `json:"domain,omitempty"`
+	SNI         string           `json:"sni,omitempty"`
+	Group       Group            `json:"group"`
+	Category    string           `json:"category,omitempty"`
+	Mode        UsageTrackerMode `json:"mode"`
+	Used        int              `json:"used"`      // Used is the minutes the group has used in its window.
+	Threshold   int              `json:"threshold"` // Threshold is the group's minutes in its window.
+	Allowlisted bool             `json:"allowlisted"`
+	Active      bool             `json:"active"`   // Active is true if the packet counted toward the group's usage.
+	Exceeded    bool             `json:"exceeded"` // Exceeded is true if the group, or the packet's category, was over its threshold.
+	Warning     bool             `json:"warning"`
+	Decision    string           `json:"decision"`
+	Hold        time.Duration    `json:"hold"` // Hold is how long the packet was held back, in nanoseconds.
+	Simulated   bool             `json:"simulated"`
+}
+
+// ResolutionPauseMode says what happens to the IPs of a domain group while resolving its domains is paused.
+type ResolutionPauseMode string
+
+const (
+	ResolutionFreeze = ResolutionPauseMode("freeze") // ResolutionFreeze keeps the group's current IPs without adding or expiring any.
+	ResolutionClear  = ResolutionPauseMode("clear")  // ResolutionClear removes all the group's IPs so none of its traffic is matched.
+)
+
+// ResolutionPause is a domain group whose resolution is paused, returned by /api/resolution-pause.
+type ResolutionPause struct {
+	Group Group               `json:"group"`
+	Mode  ResolutionPauseMode `json:"mode"`
+	Until time.Time           `json:"until"` // Until is when resolution resumes automatically.
+	IPs   int                 `json:"ips"`   // IPs is the number of IPs kept for the group while it's paused.
+}
+
+// The results of a DNSQuery.
+const (
+	DNSForwarded  = "forwarded"
+	DNSCached     = "cached"
+	DNSBlocked    = "blocked"
+	DNSRedirected = "redirected"
+	DNSFailed     = "failed"
+)
+
+// DNSQuery is a lookup made by a device via the DNS forwarder, returned by /api/dns/queries.
+type DNSQuery struct {
+	Time   time.Time `json:"time"`
+	Client Ip        `json:"client"`
+	Name   Domain    `json:"name"`
+	Type   string    `json:"type"` // Type is the record type looked up, e.g. A or AAAA.
+	Result string    `json:"result"`
+	Group  Group     `json:"group,omitempty"` // Group is the group whose DNS policy blocked or redirected the lookup.
+}
+
+// AssignedBy says how a device came to be in a group.
+type AssignedBy string
+
+const (
+	AssignedByManual  = AssignedBy("manual")  // AssignedByManual means the device was added to the group by the user.
+	AssignedByDefault = AssignedBy("default") // AssignedByDefault means no device groups are configured so all devices are tracked in the default group.
+	// AssignedByIdentity means the device was seen with another MAC, but with the identity of a device the user added.
+	AssignedByIdentity = AssignedBy("identity")
+	// AssignedByGuest means the device was put in the guests group when it was first seen, see config.GuestConfig.
+	AssignedByGuest = AssignedBy("guest")
+)
+
+// Placement records why a device is in one of its effective groups, for debugging misclassified devices.
+type Placement struct {
+	Group      Group      `json:"group"`
+	AssignedBy AssignedBy `json:"assignedBy"`
+	Since      time.Time  `json:"since"` // Since is when the device was assigned, or first seen in the group if that isn't known.
+}
+
+// ReadinessState says whether filtering is in place. At startup the NFT sets stay empty, so nothing is enforced,
+// until devices in groups are found on the network and the tracked domains resolve.
+type ReadinessState string
+
+const (
+	ReadinessWaitingForSources      = ReadinessState("waitingForSources")
+	ReadinessWaitingForDestinations = ReadinessState("waitingForDestinations")
+	ReadinessReady                  = ReadinessState("ready")
+)
+
+// Readiness is a ReadinessState with the cause when filtering isn't ready.
+type Readiness struct {
+	State ReadinessState `json:"state"`
+	Cause string         `json:"cause,omitempty"`
+}
+
+// Sources of watcher-fed data that report their Freshness.
+const (
+	FreshnessSourceIpGroups = "sourceIpGroups"
+	FreshnessDestinations   = "destinations"
+	FreshnessActivity       = "activity"
+	FreshnessDHCP           = "dhcp"
+)
+
+// Freshness describes when a watcher last updated the data it feeds to the web layer.
+type Freshness struct {
+	Source      string        `json:"source"`
+	LastUpdated time.Time     `json:"lastUpdated"` // LastUpdated is zero until the first update.
+	Interval    time.Duration `json:"interval"`    // Interval is how often the watcher is expected to update.
+	Stale       bool          `json:"stale"`
+}
+
+// IsStale returns true if the data has never been updated or has missed more than one expected update.
+func (f Freshness) IsStale(now time.Time) bool {
+	return f.LastUpdated.IsZero() || now.Sub(f.LastUpdated) > 2*f.Interval
+}
+
+// KioskSummary is the read-only view of a single group returned to group-scoped API keys, e.g. for a kiosk display.
+type KioskSummary struct {
+	Group                 Group            `json:"group"`
+	UsedMinutes           int              `json:"usedMinutes"`
+	ThresholdMinutes      int              `json:"thresholdMinutes"`
+	RemainingMinutes      int              `json:"remainingMinutes"`
+	Percentage            int              `json:"percentage"`
+	Mode                  UsageTrackerMode `json:"mode"`
+	ModeEndTime           time.Time        `json:"modeEndTime"`
+	BreakEndTime          *time.Time       `json:"breakEndTime,omitempty"`
+	BreakRemainingSeconds int              `json:"breakRemainingSeconds"`
+}
+
+// BudgetTransfer describes remaining time moved from one group to another for the current window.
+type BudgetTransfer struct {
+	From                 Group `json:"from"`
+	To                   Group `json:"to"`
+	Minutes              int   `json:"minutes"`
+	FromRemainingMinutes int   `json:"fromRemainingMinutes"`
+	ToRemainingMinutes   int   `json:"toRemainingMinutes"`
 }
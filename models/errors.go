@@ -5,5 +5,11 @@ import (
 )
 
 var (
-	ErrGroupNotFound = errors.New("group not found")
+	ErrGroupNotFound      = errors.New("group not found")
+	ErrInsufficientBudget = errors.New("insufficient remaining time")
+	ErrHistoryDisabled    = errors.New("mode history is disabled")
+	ErrCaptureNotFound    = errors.New("no capture for group")
+	ErrTraceNotFound      = errors.New("no trace for device")
+	ErrInvalidTrace       = errors.New("invalid trace duration")
+	ErrInvalidSamples     = errors.New("invalid usage samples")
 )
\ No newline at end of file
@@ -20,6 +20,17 @@ func NewMapGroupTrackerConfig() MapGroupTrackerConfig {
 	return make(MapGroupTrackerConfig)
 }
 
+// IsRandomized returns true if the MAC is locally administered, i.e. made up by the device rather than assigned by
+// its maker, as phones do when they use a private address for each network. Such a MAC may change, e.g. after the
+// network is forgotten, so the device is better known by its identity. See MapMACIdentity.
+func (m MAC) IsRandomized() bool {
+	hw, err := net.ParseMAC(strings.ReplaceAll(string(m), "-", ":"))
+	if err != nil || len(hw) == 0 {
+		return false
+	}
+	return hw[0]&0x02 != 0 && m != LocalDeviceMAC
+}
+
 func (m *MAC) WithColons() string {
 	if m == nil {
 		return ""
@@ -43,3 +54,23 @@ func (m *MAC) UnmarshalText(text []byte) error {
 	*m = MAC(NewMAC(string(text)))
 	return nil
 }
+
+// ExemptIPs returns the IPv4 addresses of the exemptions: their IPs and the IPs in ipMACs with their MACs.
+func ExemptIPs(exemptions []Exemption, ipMACs MapIpMACs) map[Ip]bool {
+	ips := make(map[Ip]bool)
+	macs := make(map[MAC]bool)
+	for _, e := range exemptions {
+		if e.MAC != "" {
+			macs[MAC(NewMAC(string(e.MAC)))] = true
+		}
+		if net.ParseIP(string(e.IP)).To4() != nil {
+			ips[e.IP] = true
+		}
+	}
+	for ip, mac := range ipMACs {
+		if macs[MAC(NewMAC(string(mac)))] && net.ParseIP(string(ip)).To4() != nil {
+			ips[ip] = true
+		}
+	}
+	return ips
+}
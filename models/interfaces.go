@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type SourceIpGroupsReceiver interface {
 	UpdateSourceIpGroups(newData MapIpGroups)
 }
@@ -24,11 +26,118 @@ type DestDomainGroupsReceiver interface {
 	UpdateDestDomainGroups(newGroups MapDomainGroups)
 }
 
+// DomainSource supplies domains to resolve for each group in addition to the configured group domains, e.g. hosts
+// of tracked domains seen in a DNS query log.
+type DomainSource interface {
+	ObservedDomains() MapGroupDomains
+}
+
+// DeviceSource supplies devices seen on the network by other means than the ARP table, e.g. the router's client
+// table, with the names they're known by there, if any.
+type DeviceSource interface {
+	Devices() []NamedMAC
+}
+
+// IdentitySource supplies the stable identity of the devices it knows about, e.g. from their DHCP leases.
+type IdentitySource interface {
+	Identities() MapMACIdentity
+}
+
+// AllowlistSource supplies the domains and IPs that each group can always reach. Every configured group is
+// included, with no entries if it has no allowlist.
+type AllowlistSource interface {
+	Allowlists() MapGroupAllowlist
+}
+
+// AllowedIpGroupsReceiver is sent the resolved IPs of the allowlists, with the groups that can always reach them.
+type AllowedIpGroupsReceiver interface {
+	UpdateAllowedIpGroups(newData MapIpGroups)
+}
+
+type ThresholdStateReceiver interface {
+	UpdateThresholdState(group Group, exceeded bool)
+}
+
+// WarningStateReceiver is notified when a group starts or stops being warned that it's nearly out of time.
+type WarningStateReceiver interface {
+	UpdateWarningState(group Group, warning bool, reason string)
+}
+
+// ModeTransitionReceiver is sent each change to whether a group is blocked, whether it was made by the tracker or
+// manually. UpdateModeTransition must not block.
+type ModeTransitionReceiver interface {
+	UpdateModeTransition(e ModeTransition)
+}
+
+// TimeRequestReceiver is sent each new request for more time and each decision on one. UpdateTimeRequest must not
+// block.
+type TimeRequestReceiver interface {
+	UpdateTimeRequest(r TimeRequest)
+}
+
+// DHCPStateReceiver is notified when the state of the local DHCP service changes, e.g. from active to inactive.
+type DHCPStateReceiver interface {
+	UpdateDHCPState(state string)
+}
+
+// UpstreamStateReceiver is notified when the upstream DNS resolvers stop or start answering, which usually means the
+// internet connection is down or back.
+type UpstreamStateReceiver interface {
+	UpdateUpstreamState(up bool)
+}
+
 type ManagerI interface {
 	IsSrcIpDestDomainKnown(ip Ip, domain Domain) ([]Group, bool)
 }
 
+// DestinationCounter is sent the bytes of each tracked packet by group and the public IP it was to or from.
+// CountDestination must not block.
+type DestinationCounter interface {
+	CountDestination(group Group, ip Ip, bytes int)
+}
+
 type TrackerI interface {
 	AddSample(id string, active bool)
+	AddDeviceSample(id string, mac MAC, active bool)
 	HasExceededThreshold(id string) bool
+	IsWarning(id string) bool
+	PacketPolicy(id string) *PacketPolicy
+	// Category returns the name of the group's category that the destination domain or server name belongs to, or
+	// an empty string if it isn't in one.
+	Category(id string, domain Domain, sni string) string
+	AddCategorySample(id, category string, active bool)
+	HasExceededCategoryThreshold(id, category string) bool
+	// Usage returns the time the group has used in its window, its threshold and its current mode.
+	Usage(id string) (used, threshold time.Duration, mode UsageTrackerMode)
+}
+
+// LiveEventReceiver is notified of events to push to the dashboard. PublishEvent must not block.
+type LiveEventReceiver interface {
+	PublishEvent(e LiveEvent)
+}
+
+// BypassAttemptReceiver is notified when a tracked device is caught trying to get around the filter.
+// UpdateBypassAttempt must not block.
+type BypassAttemptReceiver interface {
+	UpdateBypassAttempt(a BypassAttempt)
+}
+
+// KillSwitchReceiver is notified when the kill switch is turned on or off.
+type KillSwitchReceiver interface {
+	UpdateKillSwitch(on bool)
+}
+
+// ExemptionReceiver is sent the devices whose traffic the firewall should leave alone each time they change.
+type ExemptionReceiver interface {
+	UpdateExemptions(exemptions []Exemption)
+}
+
+// FailOpenReceiver is notified when the packet handler falls so far behind that the tracked devices' traffic should
+// be let through without being queued, and again once it should be queued again.
+type FailOpenReceiver interface {
+	UpdateFailOpen(on bool)
+}
+
+type PacketSampler interface {
+	IsPacketSampled(id string) bool
 }
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"slices"
 	"sync"
 	"time"
 )
@@ -10,11 +11,20 @@ type Domain string
 type Group string
 type MAC string
 
+// LocalDeviceMAC stands in for the gateway itself so that traffic from its own apps, e.g. Kodi running on the Pi, can be
+// grouped and budgeted like any other device.
+const LocalDeviceMAC = MAC("00-00-00-00-00-00")
+
 type MapGroupDomains map[Group][]Domain
 type MapIpDomain map[Ip]Domain
 type MapIpGroups map[Ip][]Group
 type MapIpMACs map[Ip]MAC
+
+// MapMACIdentity maps the MACs of devices to their stable identity, which stays the same when a device uses another
+// MAC, e.g. "hostname:kids-iphone" or "client-id:ff:00:11:22:33" from their DHCP lease.
+type MapMACIdentity map[MAC]string
 type MapDomainGroups map[Domain][]Group
+type MapGroupAllowlist map[Group][]string
 
 type IpDomains struct {
 	Data MapIpDomain
@@ -37,8 +47,16 @@ type DomainGroups struct {
 }
 
 type NamedMAC struct {
-	MAC  string `yaml:"mac"`
-	Name string `yaml:"name"`
+	MAC        string    `yaml:"mac"`
+	Name       string    `yaml:"name"`
+	AssignedAt time.Time `yaml:"assignedAt,omitempty"` // AssignedAt is when the MAC was added to the group.
+	// Identity binds the device to its stable identity as well as the MAC, so that it stays in its group when it uses
+	// another MAC, e.g. a phone's private address for the network. See MapMACIdentity.
+	Identity string `yaml:"identity,omitempty"`
+	// Guest is true if the MAC was put in the guests group when it was first seen, rather than by the user, and
+	// LastSeen is when a guest was last seen on the network, so it can be removed once it stops visiting.
+	Guest    bool      `yaml:"guest,omitempty"`
+	LastSeen time.Time `yaml:"lastSeen,omitempty"`
 }
 
 type MapGroupTrackerConfig map[Group]*TrackerConfig
@@ -51,14 +69,68 @@ type TrackerConfig struct {
 	Retention time.Duration `yaml:"retention" envconfig:"RETENTION" default:"168h"` // 168h == 1 week
 	// Threshold is duration for exceeding conditions.
 	Threshold time.Duration `yaml:"threshold" envconfig:"THRESHOLD" default:"180m"`
+	// DayThresholds optionally replace Threshold on the given days of the week, e.g. more time at weekends.
+	DayThresholds []DayThreshold `yaml:"dayThresholds,omitempty" ignored:"true"`
 	// StartDayInt is the day of the week to start the window.
 	StartDayInt int `yaml:"startDay" envconfig:"START_DAY" default:"5"` // Friday
+	// StartDayOfMonth is the day of the month, 1 to 28, to start the window when Window is monthly.
+	StartDayOfMonth int `yaml:"startDayOfMonth" envconfig:"START_DAY_OF_MONTH" default:"1"`
+	// Window is how the window resets: empty resets it every Retention, of up to a week, while monthly resets it on
+	// StartDayOfMonth whatever the Retention.
+	Window TrackerWindow `yaml:"window,omitempty" envconfig:"WINDOW" default:""`
 	// StartDuration is the duration past midnight to start the window.
 	StartDuration time.Duration `yaml:"startTime" envconfig:"START_TIME" default:"0h"` // 12 am
+	// CountFrom is the duration past local midnight from which usage counts toward the threshold each day.
+	CountFrom time.Duration `yaml:"countFrom" envconfig:"COUNT_FROM" default:"0h"`
+	// CountUntil is the duration past local midnight at which usage stops counting each day.
+	// If CountFrom equals CountUntil then usage counts all day; if CountUntil is earlier, the hours span midnight.
+	CountUntil time.Duration `yaml:"countUntil" envconfig:"COUNT_UNTIL" default:"0h"`
+	// BlockOutsideHours when set true blocks the group outside the counting hours, else usage simply isn't counted.
+	BlockOutsideHours bool `yaml:"blockOutsideHours" envconfig:"BLOCK_OUTSIDE_HOURS" default:"false"`
+	// MaxSession is the longest continuous usage allowed before a forced break; zero disables forced breaks.
+	MaxSession time.Duration `yaml:"maxSession" envconfig:"MAX_SESSION" default:"0"`
+	// BreakDuration is the length of a forced break. Being idle for this long also ends a session.
+	BreakDuration time.Duration `yaml:"breakDuration" envconfig:"BREAK_DURATION" default:"15m"`
+	// MinActive is the active time needed within MinActiveWindow before usage counts, so that background traffic,
+	// e.g. a thumbnail loading, isn't counted as watching. Zero counts every active minute.
+	MinActive time.Duration `yaml:"minActive" envconfig:"MIN_ACTIVE" default:"0"`
+	// MinActiveWindow is how recent the active time must be to count toward MinActive. Being idle for this long
+	// after counting starts means MinActive must be reached again.
+	MinActiveWindow time.Duration `yaml:"minActiveWindow" envconfig:"MIN_ACTIVE_WINDOW" default:"5m"`
+	// PacketSampling when set true only queues 1 in FILTER_SAMPLE_RATE packets of the group while it is under its
+	// threshold, to save CPU on fast links. Every packet is queued again once the group needs blocking.
+	PacketSampling bool `yaml:"packetSampling" envconfig:"PACKET_SAMPLING" default:"false"`
+	// Rollover is what happens to unused time when the window resets: none, capped or full.
+	Rollover RolloverPolicy `yaml:"rollover" envconfig:"ROLLOVER" default:"none"`
+	// RolloverCap is the most unused time carried into the next window when Rollover is capped.
+	RolloverCap time.Duration `yaml:"rolloverCap" envconfig:"ROLLOVER_CAP" default:"0"`
+	// WarnAt is the percentage of the threshold at which the group's packets are slowed slightly by FILTER_WARN_DELAY
+	// and a warning is sent, as a hint to finish up before being blocked. Zero disables the warning.
+	WarnAt int `yaml:"warnAt" envconfig:"WARN_AT" default:"0"`
+	// GracePeriod is the usage allowed past the threshold, still only slowed, before the group is blocked, so that a
+	// video can be finished rather than cut off mid-way.
+	GracePeriod time.Duration `yaml:"gracePeriod" envconfig:"GRACE_PERIOD" default:"0"`
+	// PacketPolicy is how packets are handled once the group is over its threshold. Nil uses the FILTER_ settings.
+	PacketPolicy *PacketPolicy `yaml:"packetPolicy,omitempty" ignored:"true"`
+	// Allowlist are the domains and IPs the group can always reach, e.g. educational sites, without being counted
+	// or throttled, even when they share IPs with tracked domains.
+	Allowlist []string `yaml:"allowlist,omitempty" ignored:"true"`
+	// Categories split the group's usage by destination, e.g. YouTube Music apart from videos, each with its own
+	// samples and threshold. Usage that matches no category counts toward Threshold.
+	Categories []Category `yaml:"categories,omitempty" ignored:"true"`
 	// SampleFilePath is the path to the file to save/read the device ID samples from.
-	SampleFilePath string `yaml:"-" envconfig:"FILE_PATH" default:"samples.json"`
+	SampleFilePath string `yaml:"-" envconfig:"FILE_PATH" default:"samples.json" reload:"startup"`
 	// SampleFileSaveInterval is the interval at which the samples are saved to the file.
-	SampleFileSaveInterval time.Duration `yaml:"-" envconfig:"SAVE_INTERVAL" default:"1m"`
+	SampleFileSaveInterval time.Duration `yaml:"-" envconfig:"SAVE_INTERVAL" default:"1m" reload:"startup"`
+	// StateCheckInterval is the interval at which all groups are evaluated to notify threshold state changes.
+	StateCheckInterval time.Duration `yaml:"-" envconfig:"STATE_CHECK_INTERVAL" default:"15s" reload:"startup"`
+	// TrackDevices when set true also keeps samples per MAC within each group so usage can be shown per device.
+	TrackDevices bool `yaml:"-" envconfig:"TRACK_DEVICES" default:"false" reload:"startup"`
+	// HistoryRetention is how long block and allow transitions are kept for /api/mode-history. Zero disables the history.
+	HistoryRetention time.Duration `yaml:"-" envconfig:"HISTORY_RETENTION" default:"720h"`
+	// Timezone is the IANA name of the time zone, e.g. Europe/London, whose clock the windows, counting hours and day
+	// thresholds follow. Empty uses the system's, e.g. from TZ.
+	Timezone string `yaml:"-" envconfig:"TIMEZONE" default:"" reload:"startup"`
 	// SampleSize is the number of slots in the circular buffer.
 	SampleSize int `yaml:"sampleSize"`
 	// Mode is the mode of the tracker.
@@ -67,6 +139,120 @@ type TrackerConfig struct {
 	ModeEndTime time.Time `yaml:"modeEndTime"`
 }
 
+// DayThreshold is the threshold that applies to windows starting on any of Days.
+type DayThreshold struct {
+	Days      []time.Weekday `yaml:"days" json:"days"`
+	Threshold time.Duration  `yaml:"threshold" json:"threshold"`
+}
+
+// Category is a part of a group's usage that is counted and limited apart from the rest, matched by the domain the
+// destination IP was resolved from or the server name (SNI) a device asked for when it opened the connection.
+// Patterns match the name and its subdomains, e.g. "music.youtube.com" matches "www.music.youtube.com".
+type Category struct {
+	Name      string        `yaml:"name" json:"name"`
+	Domains   []string      `yaml:"domains,omitempty" json:"domains"`
+	SNI       []string      `yaml:"sni,omitempty" json:"sni"`
+	Threshold time.Duration `yaml:"threshold" json:"threshold"`
+	Unlimited bool          `yaml:"unlimited,omitempty" json:"unlimited"` // Unlimited counts the usage without ever blocking it.
+}
+
+// PacketPolicy is how packets of a group over its threshold are dropped, delayed or shaped.
+type PacketPolicy struct {
+	// DropPercentage is the fraction of packets to drop, from 0 to 1.
+	DropPercentage float32 `yaml:"dropPercentage" json:"dropPercentage"`
+	// DelayPercentage is the fraction of packets to delay, evaluated after dropping.
+	DelayPercentage float32       `yaml:"delayPercentage" json:"delayPercentage"`
+	Delay           time.Duration `yaml:"delay" json:"delay"`
+	Jitter          time.Duration `yaml:"jitter" json:"jitter"`
+	DropUDP         bool          `yaml:"dropUDP" json:"dropUDP"`
+	// RateLimitKbps shapes traffic to this rate per direction instead of dropping and delaying packets at random.
+	// 0 disables rate limiting.
+	RateLimitKbps int `yaml:"rateLimitKbps" json:"rateLimitKbps"`
+	// UDPRateLimitKbps shapes UDP, e.g. QUIC, to this rate per direction instead of dropping it when DropUDP is set.
+	// 0 drops it.
+	UDPRateLimitKbps int `yaml:"udpRateLimitKbps" json:"udpRateLimitKbps"`
+}
+
+// Profile is a named bundle of tracker changes, e.g. for school holidays or exams, that is applied to the groups
+// while it's active.
+type Profile struct {
+	Name string `yaml:"name" json:"name"`
+	// ThresholdFactor multiplies the threshold and day thresholds of every group, e.g. 2 doubles them. 0 leaves them.
+	ThresholdFactor float64 `yaml:"thresholdFactor" json:"thresholdFactor"`
+	// Groups change individual groups, in place of ThresholdFactor for the groups whose threshold they set.
+	Groups map[Group]ProfileGroup `yaml:"groups,omitempty" json:"groups,omitempty"`
+	// Schedule is when the profile is activated by itself, e.g. each school holiday.
+	Schedule []ProfilePeriod `yaml:"schedule,omitempty" json:"schedule,omitempty"`
+}
+
+// ProfileGroup is how a profile changes a group.
+type ProfileGroup struct {
+	// Threshold replaces the group's threshold and day thresholds. Nil uses the profile's ThresholdFactor.
+	Threshold *time.Duration `yaml:"threshold,omitempty" json:"threshold,omitempty"`
+	// Mode puts the group in the mode while the profile is active, e.g. block for exams. Nil leaves the mode.
+	Mode *UsageTrackerMode `yaml:"mode,omitempty" json:"mode,omitempty"`
+}
+
+// ProfilePeriod is a time that a profile is active for.
+type ProfilePeriod struct {
+	Start time.Time `yaml:"start" json:"start"`
+	End   time.Time `yaml:"end" json:"end"`
+}
+
+// The statuses of a TimeRequest.
+const (
+	TimeRequestPending  = "pending"
+	TimeRequestApproved = "approved"
+	TimeRequestDenied   = "denied"
+)
+
+// TimeRequest is a device's request for more time for its group, which waits for an admin to approve or deny it.
+type TimeRequest struct {
+	ID      string    `yaml:"id" json:"id"`
+	Group   Group     `yaml:"group" json:"group"`
+	MAC     MAC       `yaml:"mac" json:"mac"` // MAC is the device that asked.
+	Minutes int       `yaml:"minutes" json:"minutes"`
+	Reason  string    `yaml:"reason,omitempty" json:"reason,omitempty"`
+	Status  string    `yaml:"status" json:"status"`
+	Created time.Time `yaml:"created" json:"created"`
+	Decided time.Time `yaml:"decided,omitempty" json:"decided,omitempty"`
+	// Granted is the minutes given on approval, which may differ from those asked for.
+	Granted int `yaml:"granted,omitempty" json:"granted,omitempty"`
+	// Allow is set if the group was allowed without tracking for the Granted minutes, rather than given them as a bonus.
+	Allow bool   `yaml:"allow,omitempty" json:"allow,omitempty"`
+	Note  string `yaml:"note,omitempty" json:"note,omitempty"` // Note is the admin's reply.
+}
+
+// Exemption is a device whose traffic the firewall leaves alone, e.g. a parent's phone or a work laptop, so that it's
+// never queued for filtering or dropped by the kill switch. It's matched by MAC, on every IP the device was last seen
+// on, or by IP, e.g. for a device with a static address that doesn't answer ARP.
+type Exemption struct {
+	MAC  MAC    `yaml:"mac,omitempty" json:"mac,omitempty"`
+	IP   Ip     `yaml:"ip,omitempty" json:"ip,omitempty"`
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+}
+
+type MapGroupDNSPolicy map[Group]DNSPolicy
+
+// DNSPolicy is how the DNS forwarder answers a group's devices when they look up the domains listed, or their
+// subdomains, instead of forwarding the lookup. Block wins if a domain is in both.
+type DNSPolicy struct {
+	Block []Domain `yaml:"block,omitempty" json:"block"` // Block is answered with NXDOMAIN.
+	// Redirect is answered with the IPv4 address given for each domain, e.g. a SafeSearch address.
+	Redirect map[Domain]Ip `yaml:"redirect,omitempty" json:"redirect"`
+}
+
+// ThresholdOn returns the threshold for a window starting on day: the first DayThresholds entry that includes the
+// day, else Threshold.
+func (c *TrackerConfig) ThresholdOn(day time.Weekday) time.Duration {
+	for _, dt := range c.DayThresholds {
+		if slices.Contains(dt.Days, day) {
+			return dt.Threshold
+		}
+	}
+	return c.Threshold
+}
+
 type Direction string
 
 const (
@@ -82,6 +268,24 @@ const (
 	ModeBlock
 )
 
+// TrackerWindow says how a usage window resets.
+type TrackerWindow string
+
+const (
+	WindowRetention = TrackerWindow("")        // WindowRetention resets the window every Retention.
+	WindowMonthly   = TrackerWindow("monthly") // WindowMonthly resets the window on StartDayOfMonth each month.
+)
+
+// RolloverPolicy says how much unused time is carried into the next window.
+// At most one window's threshold is ever carried so unused time can't build up week after week.
+type RolloverPolicy string
+
+const (
+	RolloverNone   = RolloverPolicy("none")   // RolloverNone discards unused time at the end of each window.
+	RolloverCapped = RolloverPolicy("capped") // RolloverCapped carries unused time up to RolloverCap.
+	RolloverFull   = RolloverPolicy("full")   // RolloverFull carries all unused time.
+)
+
 type DHCPMode int
 
 const (
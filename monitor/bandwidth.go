@@ -0,0 +1,55 @@
+package monitor
+
+import (
+	"time"
+
+	"relloyd/tubetimeout/models"
+)
+
+const bandwidthMinutes = 15 // bandwidthMinutes is how many minutes of traffic are kept for each device.
+
+// bandwidthStats is the traffic of a device in each of the last bandwidthMinutes minutes, in a ring indexed by the
+// minute since the epoch. Slots whose start doesn't match the minute being read are left over from an earlier lap and
+// count as no traffic.
+type bandwidthStats struct {
+	minutes  [bandwidthMinutes]models.BandwidthMinute
+	lastSeen time.Time
+}
+
+func bandwidthSlot(start time.Time) int {
+	return int(start.Unix()/60) % bandwidthMinutes
+}
+
+// add counts the bytes in the minute of now. It should be called under TrafficMap.muBandwidth.
+func (b *bandwidthStats) add(now time.Time, direction models.Direction, bytes int) {
+	start := now.Truncate(time.Minute)
+	m := &b.minutes[bandwidthSlot(start)]
+	if !m.Start.Equal(start) { // if the slot is from an earlier lap...
+		*m = models.BandwidthMinute{Start: start}
+	}
+	if direction == models.Ingress {
+		m.IngressBytes += int64(bytes)
+	} else {
+		m.EgressBytes += int64(bytes)
+	}
+	b.lastSeen = now
+}
+
+// snapshot returns the minutes up to and including the minute of now, and the rates over the last complete minute.
+// It should be called under TrafficMap.muBandwidth.
+func (b *bandwidthStats) snapshot(mac models.MAC, now time.Time) models.Bandwidth {
+	retval := models.Bandwidth{MAC: mac, Minutes: make([]models.BandwidthMinute, 0, bandwidthMinutes)}
+	current := now.Truncate(time.Minute)
+	for i := bandwidthMinutes - 1; i >= 0; i-- {
+		start := current.Add(-time.Duration(i) * time.Minute)
+		m := b.minutes[bandwidthSlot(start)]
+		if !m.Start.Equal(start) {
+			m = models.BandwidthMinute{Start: start}
+		}
+		retval.Minutes = append(retval.Minutes, m)
+	}
+	last := retval.Minutes[len(retval.Minutes)-2]
+	retval.IngressKbps = last.IngressBytes * 8 / 60 / 1000
+	retval.EgressKbps = last.EgressBytes * 8 / 60 / 1000
+	return retval
+}
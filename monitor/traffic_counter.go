@@ -1,22 +1,29 @@
 package monitor
 
 import (
+	"cmp"
 	"fmt"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"go.uber.org/zap"
 	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/eventbus"
 	"relloyd/tubetimeout/models"
 )
 
 var (
 	defaultTrafficMapKeySeparator = "/"
+	ipMACUpdateInterval           = time.Minute // ipMACUpdateInterval is how often the ARP scan sends new IP-MAC data.
 )
 
 type TrafficCounter interface {
 	CountTraffic(group models.Group, ip models.Ip, direction models.Direction, count int, packetLen int) bool
+	CountBandwidth(ip models.Ip, direction models.Direction, bytes int)
+	CountDomain(group models.Group, domain models.Domain, direction models.Direction, bytes int)
+	GetMAC(ip models.Ip) (models.MAC, bool)
 }
 
 type TrafficMap struct {
@@ -26,13 +33,26 @@ type TrafficMap struct {
 	trafficMapLen     int
 	muTrafficMapLen   sync.Mutex
 	ipMACs            models.IpMACs
+	lastIpMACUpdate   time.Time     // lastIpMACUpdate is the time IP-MAC data was last received, guarded by ipMACs.Mu.
+	bus               *eventbus.Bus // bus is where activity events are published.
+	muBandwidth       sync.Mutex
+	bandwidth         map[models.MAC]*bandwidthStats // bandwidth is the recent traffic of each device, guarded by muBandwidth.
+	muDomains         sync.Mutex
+	domains           map[models.Group]map[models.Domain]*models.DomainTraffic // domains is the traffic of each group with each domain, guarded by muDomains.
 }
 
-func NewTrafficMap(logger *zap.SugaredLogger, rollingWindowSize int) *TrafficMap {
+// NewTrafficMap returns a TrafficMap that publishes activity events to the bus, or to a bus of its own if that's nil.
+func NewTrafficMap(logger *zap.SugaredLogger, rollingWindowSize int, bus *eventbus.Bus) *TrafficMap {
+	if bus == nil {
+		bus = eventbus.NewBus()
+	}
 	return &TrafficMap{
 		logger:            logger,
+		bus:               bus,
 		rollingWindowSize: rollingWindowSize,
 		trafficMap:        &sync.Map{},
+		bandwidth:         make(map[models.MAC]*bandwidthStats),
+		domains:           make(map[models.Group]map[models.Domain]*models.DomainTraffic),
 		ipMACs:            models.IpMACs{Data: make(models.MapIpMACs), Mu: sync.RWMutex{}}, // TODO test that the map is not nil.
 	}
 }
@@ -53,7 +73,140 @@ func (t *TrafficMap) CountTraffic(group models.Group, ip models.Ip, direction mo
 		t.trafficMapLen++ // track of the number of trafficMap values.
 		t.muTrafficMapLen.Unlock()
 	}
-	return tm.(*trafficStats).countTraffic(count, packetLen, direction)
+	ts := tm.(*trafficStats)
+	before := ts.getLastActiveTime()
+	active := ts.countTraffic(count, packetLen, direction)
+	if after := ts.getLastActiveTime(); !loaded || !after.Equal(before) { // if the device is new or has been active since...
+		t.publishActivity(group, mac, after)
+	}
+	return active
+}
+
+// CountBandwidth adds the bytes of a packet to the traffic of the device with the given IP, once per packet whatever
+// the number of groups it's in. Traffic from IPs without a known MAC isn't counted.
+func (t *TrafficMap) CountBandwidth(ip models.Ip, direction models.Direction, bytes int) {
+	mac, ok := t.GetMAC(ip)
+	if !ok {
+		return
+	}
+	t.muBandwidth.Lock()
+	defer t.muBandwidth.Unlock()
+	b, ok := t.bandwidth[mac]
+	if !ok {
+		b = &bandwidthStats{}
+		t.bandwidth[mac] = b
+	}
+	b.add(nowFunc(), direction, bytes)
+}
+
+// GetBandwidth returns the recent traffic of the device, or false if it hasn't been seen in the last few minutes.
+func (t *TrafficMap) GetBandwidth(mac models.MAC) (models.Bandwidth, bool) {
+	t.muBandwidth.Lock()
+	defer t.muBandwidth.Unlock()
+	b, ok := t.bandwidth[mac]
+	if !ok {
+		return models.Bandwidth{}, false
+	}
+	return b.snapshot(mac, nowFunc()), true
+}
+
+// GetAllBandwidth returns the recent traffic of every device seen in the last few minutes, busiest first by their
+// rates over the last complete minute.
+func (t *TrafficMap) GetAllBandwidth() []models.Bandwidth {
+	t.muBandwidth.Lock()
+	defer t.muBandwidth.Unlock()
+	now := nowFunc()
+	retval := make([]models.Bandwidth, 0, len(t.bandwidth))
+	for mac, b := range t.bandwidth {
+		retval = append(retval, b.snapshot(mac, now))
+	}
+	slices.SortFunc(retval, func(a, b models.Bandwidth) int {
+		return cmp.Or(cmp.Compare(b.IngressKbps+b.EgressKbps, a.IngressKbps+a.EgressKbps), cmp.Compare(a.MAC, b.MAC))
+	})
+	return retval
+}
+
+// purgeBandwidth removes the devices that haven't been seen for longer than the minutes kept.
+func (t *TrafficMap) purgeBandwidth(now time.Time) {
+	t.muBandwidth.Lock()
+	defer t.muBandwidth.Unlock()
+	for mac, b := range t.bandwidth {
+		if now.Sub(b.lastSeen) > bandwidthMinutes*time.Minute {
+			delete(t.bandwidth, mac)
+		}
+	}
+}
+
+// CountDomain adds the bytes of a packet to the group's traffic with the domain its destination IP resolved from, so
+// that the domains behind a group's usage can be seen.
+func (t *TrafficMap) CountDomain(group models.Group, domain models.Domain, direction models.Direction, bytes int) {
+	t.muDomains.Lock()
+	defer t.muDomains.Unlock()
+	if t.domains[group] == nil {
+		t.domains[group] = make(map[models.Domain]*models.DomainTraffic)
+	}
+	u, ok := t.domains[group][domain]
+	if !ok {
+		u = &models.DomainTraffic{}
+		t.domains[group][domain] = u
+	}
+	if direction == models.Ingress {
+		u.IngressBytes += int64(bytes)
+	} else {
+		u.EgressBytes += int64(bytes)
+	}
+	u.LastSeen = nowFunc()
+}
+
+// GetDomainTraffic returns a copy of the traffic counted for each group with each domain.
+func (t *TrafficMap) GetDomainTraffic() map[models.Group]map[models.Domain]*models.DomainTraffic {
+	t.muDomains.Lock()
+	defer t.muDomains.Unlock()
+	retval := make(map[models.Group]map[models.Domain]*models.DomainTraffic, len(t.domains))
+	for group, domains := range t.domains {
+		retval[group] = make(map[models.Domain]*models.DomainTraffic, len(domains))
+		for domain, u := range domains {
+			c := *u
+			retval[group][domain] = &c
+		}
+	}
+	return retval
+}
+
+// purgeDomains removes the domains that haven't been seen since minAllowedTime, and the groups left without any.
+func (t *TrafficMap) purgeDomains(minAllowedTime time.Time) {
+	t.muDomains.Lock()
+	defer t.muDomains.Unlock()
+	for group, domains := range t.domains {
+		for domain, u := range domains {
+			if u.LastSeen.Before(minAllowedTime) {
+				delete(domains, domain)
+			}
+		}
+		if len(domains) == 0 {
+			delete(t.domains, group)
+		}
+	}
+}
+
+// RegisterLiveEventReceivers subscribes receivers to the bus's live events, which the traffic map sends activity
+// events to when a device is first seen in a group or its last active time moves on.
+func (t *TrafficMap) RegisterLiveEventReceivers(receivers ...models.LiveEventReceiver) {
+	for _, r := range receivers {
+		t.bus.Live.Subscribe(r.PublishEvent)
+	}
+}
+
+func (t *TrafficMap) publishActivity(group models.Group, mac models.MAC, lastActive time.Time) {
+	t.bus.Live.Publish(models.LiveEvent{Type: models.LiveEventActivity, Time: nowFunc(), Data: models.ActivityEvent{Group: group, MAC: mac, LastActive: lastActive}})
+}
+
+// GetMAC returns the MAC address for the given IP using the latest IP-MAC data.
+func (t *TrafficMap) GetMAC(ip models.Ip) (models.MAC, bool) {
+	t.ipMACs.Mu.RLock()
+	defer t.ipMACs.Mu.RUnlock()
+	mac, ok := t.ipMACs.Data[ip]
+	return mac, ok
 }
 
 // UpdateSourceIpMACs implements SourceIpGroupsReceiver and is used to remove old data from the trafficMap.
@@ -62,11 +215,14 @@ func (t *TrafficMap) UpdateSourceIpMACs(newData models.MapIpMACs) {
 	t.ipMACs.Mu.Lock()
 	defer t.ipMACs.Mu.Unlock()
 	t.ipMACs.Data = newData
+	t.lastIpMACUpdate = time.Now()
 
 	t.logger.Debugf("TrafficMap received new IP MAC data: %v", newData)
+	t.purgeBandwidth(nowFunc())
 
 	// Remove old data from the trafficMap.
-	minAllowedTime := time.Now().Add(-config.AppCfg.MonitorConfig.PurgeStatsAfterDuration) // remove trafficMaps older than this.
+	minAllowedTime := time.Now().Add(-config.Current().MonitorConfig.PurgeStatsAfterDuration) // remove trafficMaps older than this.
+	t.purgeDomains(nowFunc().Add(-config.Current().MonitorConfig.PurgeStatsAfterDuration))
 
 	t.muTrafficMapLen.Lock()
 	defer t.muTrafficMapLen.Unlock()
@@ -92,6 +248,13 @@ func (t *TrafficMap) UpdateSourceIpMACs(newData models.MapIpMACs) {
 	}
 }
 
+// Freshness returns when IP-MAC data was last received, since activity can't be attributed to devices without it.
+func (t *TrafficMap) Freshness() models.Freshness {
+	t.ipMACs.Mu.RLock()
+	defer t.ipMACs.Mu.RUnlock()
+	return models.Freshness{Source: models.FreshnessActivity, LastUpdated: t.lastIpMACUpdate, Interval: ipMACUpdateInterval}
+}
+
 func getTrafficMapKey(group models.Group, mac models.MAC) string {
 	return fmt.Sprintf("%v%v%v", group, defaultTrafficMapKeySeparator, mac)
 }
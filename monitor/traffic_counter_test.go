@@ -1,6 +1,8 @@
 package monitor
 
 import (
+	"maps"
+	"slices"
 	"testing"
 	"time"
 
@@ -30,7 +32,7 @@ func TestTrafficMap(t *testing.T) {
 	// Mock the time.
 	now := mockNowFunc(time.Time{})
 
-	tm := NewTrafficMap(logger, windowSize)
+	tm := NewTrafficMap(logger, windowSize, nil)
 	assert.Equal(t, windowSize, tm.rollingWindowSize, "unexpected rolling window size")
 	assert.Equal(t, tm.trafficMapLen, 0, "unexpected traffic map len initially")
 	assert.Same(t, logger, tm.logger, "unexpected logger")
@@ -65,7 +67,7 @@ func TestTrafficMap_UpdateSourceIpGroups(t *testing.T) {
 	logger := config.MustGetLogger()
 	windowSize := 5
 
-	tm := NewTrafficMap(logger, windowSize)
+	tm := NewTrafficMap(logger, windowSize, nil)
 	tm.UpdateSourceIpMACs(models.MapIpMACs{ // Initial data.
 		testIp:  testMac,
 		testIp2: testMac2,
@@ -86,3 +88,129 @@ func TestTrafficMap_UpdateSourceIpGroups(t *testing.T) {
 
 	assert.Equal(t, 1, tm.trafficMapLen, "unexpected traffic map len")
 }
+
+type mockLiveEventReceiver struct {
+	events []models.LiveEvent
+}
+
+func (m *mockLiveEventReceiver) PublishEvent(e models.LiveEvent) {
+	m.events = append(m.events, e)
+}
+
+func TestTrafficMap_PublishesActivity(t *testing.T) {
+	testIp := models.Ip("1.1.1.1")
+	start := mockNowFunc(time.Date(2025, 3, 10, 12, 0, 30, 0, time.UTC))
+	t.Cleanup(func() { nowFunc = time.Now })
+
+	tm := NewTrafficMap(config.MustGetLogger(), 5, nil)
+	r := &mockLiveEventReceiver{}
+	tm.RegisterLiveEventReceivers(r)
+	tm.UpdateSourceIpMACs(models.MapIpMACs{testIp: "aa"})
+
+	tm.CountTraffic("test", testIp, models.Ingress, 1, 100)
+	tm.CountTraffic("test", testIp, models.Ingress, 1, 100)
+	if assert.Len(t, r.events, 1, "expected an event for a new device only") {
+		assert.Equal(t, models.LiveEventActivity, r.events[0].Type)
+		assert.Equal(t, models.ActivityEvent{Group: "test", MAC: "aa", LastActive: start.UTC()}, r.events[0].Data)
+	}
+
+	// Expect an event once the last active time moves on, which happens when a later minute sees the traffic above.
+	for i := 1; i <= 2; i++ {
+		mockNowFunc(start.Add(time.Duration(i) * time.Minute))
+		tm.CountTraffic("test", testIp, models.Ingress, 1, 100)
+	}
+	if assert.Len(t, r.events, 2) {
+		assert.Equal(t, start.Add(2*time.Minute).Truncate(time.Minute), r.events[1].Data.(models.ActivityEvent).LastActive)
+	}
+}
+
+func TestTrafficMap_Bandwidth(t *testing.T) {
+	start := mockNowFunc(time.Date(2025, 3, 10, 12, 0, 30, 0, time.UTC))
+	t.Cleanup(func() { nowFunc = time.Now })
+
+	tm := NewTrafficMap(config.MustGetLogger(), 5, nil)
+	tm.UpdateSourceIpMACs(models.MapIpMACs{"1.1.1.1": "aa", "2.2.2.2": "bb"})
+	tm.CountBandwidth("1.1.1.1", models.Ingress, 600_000)
+	tm.CountBandwidth("1.1.1.1", models.Egress, 60_000)
+	tm.CountBandwidth("2.2.2.2", models.Ingress, 1000)
+	tm.CountBandwidth("3.3.3.3", models.Ingress, 1000) // unknown IPs aren't counted.
+	mockNowFunc(start.Add(time.Minute))
+	tm.CountBandwidth("1.1.1.1", models.Ingress, 500)
+
+	bw, ok := tm.GetBandwidth("aa")
+	assert.True(t, ok)
+	assert.Equal(t, int64(80), bw.IngressKbps, "expected the rate over the last complete minute")
+	assert.Equal(t, int64(8), bw.EgressKbps)
+	if assert.Len(t, bw.Minutes, bandwidthMinutes) {
+		assert.Equal(t, models.BandwidthMinute{Start: start.Truncate(time.Minute), IngressBytes: 600_000, EgressBytes: 60_000}, bw.Minutes[bandwidthMinutes-2])
+		assert.Equal(t, models.BandwidthMinute{Start: start.Add(time.Minute).Truncate(time.Minute), IngressBytes: 500}, bw.Minutes[bandwidthMinutes-1])
+	}
+	_, ok = tm.GetBandwidth("cc")
+	assert.False(t, ok)
+
+	all := tm.GetAllBandwidth()
+	if assert.Len(t, all, 2) {
+		assert.Equal(t, models.MAC("aa"), all[0].MAC, "expected the busiest device first")
+	}
+
+	// Expect a lap of the ring to forget the old minutes, and devices that go quiet to be removed.
+	mockNowFunc(start.Add(bandwidthMinutes * time.Minute))
+	tm.CountBandwidth("2.2.2.2", models.Ingress, 1000)
+	bw, _ = tm.GetBandwidth("bb")
+	assert.Equal(t, int64(1000), bw.Minutes[bandwidthMinutes-1].IngressBytes)
+	assert.Zero(t, bw.Minutes[0].IngressBytes)
+	mockNowFunc(start.Add(bandwidthMinutes*time.Minute + 2*time.Minute))
+	tm.UpdateSourceIpMACs(models.MapIpMACs{"1.1.1.1": "aa", "2.2.2.2": "bb"})
+	_, ok = tm.GetBandwidth("aa")
+	assert.False(t, ok)
+	_, ok = tm.GetBandwidth("bb")
+	assert.True(t, ok)
+}
+
+func TestTrafficMap_MultipleIPs(t *testing.T) {
+	start := mockNowFunc(time.Date(2025, 3, 10, 12, 0, 30, 0, time.UTC))
+	t.Cleanup(func() { nowFunc = time.Now })
+
+	// Expect the traffic of each of a device's IPs, e.g. from its IPv4 and IPv6 addresses or its private MACs, to be
+	// counted once for the device.
+	tm := NewTrafficMap(config.MustGetLogger(), 5, nil)
+	tm.UpdateSourceIpMACs(models.MapIpMACs{"192.168.1.10": "aa", "192.168.1.11": "aa", "2001:db8::5": "aa"})
+	tm.CountTraffic("kids", "192.168.1.10", models.Ingress, 1, 100)
+	tm.CountTraffic("kids", "192.168.1.11", models.Ingress, 1, 100)
+	tm.CountTraffic("kids", "2001:db8::5", models.Ingress, 1, 100)
+	assert.Equal(t, 1, tm.trafficMapLen, "expected one device")
+	tm.CountBandwidth("192.168.1.10", models.Ingress, 1000)
+	tm.CountBandwidth("192.168.1.11", models.Ingress, 2000)
+	mockNowFunc(start.Add(time.Minute))
+	bw, ok := tm.GetBandwidth("aa")
+	assert.True(t, ok)
+	assert.Equal(t, int64(3000), bw.Minutes[bandwidthMinutes-2].IngressBytes)
+}
+
+func TestTrafficMap_Domains(t *testing.T) {
+	start := mockNowFunc(time.Date(2025, 3, 10, 12, 0, 30, 0, time.UTC))
+	t.Cleanup(func() { nowFunc = time.Now })
+
+	tm := NewTrafficMap(config.MustGetLogger(), 5, nil)
+	tm.CountDomain("kids", "googlevideo.com", models.Ingress, 90_000)
+	tm.CountDomain("kids", "googlevideo.com", models.Egress, 3000)
+	tm.CountDomain("kids", "ytimg.com", models.Ingress, 2000)
+	tm.CountDomain("teens", "googlevideo.com", models.Ingress, 500)
+
+	du := tm.GetDomainTraffic()
+	assert.Equal(t, &models.DomainTraffic{IngressBytes: 90_000, EgressBytes: 3000, LastSeen: start}, du["kids"]["googlevideo.com"])
+	assert.Equal(t, int64(2000), du["kids"]["ytimg.com"].IngressBytes)
+	assert.Equal(t, int64(500), du["teens"]["googlevideo.com"].IngressBytes, "expected each group to be counted apart")
+	du["kids"]["ytimg.com"].IngressBytes = 0
+	assert.Equal(t, int64(2000), tm.GetDomainTraffic()["kids"]["ytimg.com"].IngressBytes, "expected a copy to be returned")
+
+	// Expect domains that go unseen for the purge duration to be removed, and groups left without any.
+	mockNowFunc(start.Add(config.AppCfg.MonitorConfig.PurgeStatsAfterDuration))
+	tm.CountDomain("kids", "ytimg.com", models.Ingress, 1000)
+	mockNowFunc(start.Add(config.AppCfg.MonitorConfig.PurgeStatsAfterDuration + time.Minute))
+	tm.UpdateSourceIpMACs(models.MapIpMACs{})
+	du = tm.GetDomainTraffic()
+	assert.Equal(t, []models.Domain{"ytimg.com"}, slices.Collect(maps.Keys(du["kids"])))
+	assert.Equal(t, int64(3000), du["kids"]["ytimg.com"].IngressBytes)
+	assert.NotContains(t, du, models.Group("teens"))
+}
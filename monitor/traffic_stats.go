@@ -10,7 +10,7 @@ import (
 )
 
 var (
-	nowFunc                  = time.Now
+	nowFunc = time.Now
 )
 
 // TODO: maybe remove rollingCounts of packets if packet len is good enough to determine activity.
@@ -110,11 +110,19 @@ func (a *trafficStats) countTraffic(count int, packetLen int, trafficDirection m
 	return a.isLastMinuteActive
 }
 
+// getLastActiveTime returns the time at which the device was last deemed active.
+func (a *trafficStats) getLastActiveTime() time.Time {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lastActiveTimeUTC
+}
+
 // isActive determines if the traffic rate is deemed "active" i.e. true, based on the current rate.
 func (a *trafficStats) isActive(lastMinuteIndex int, logStats bool) bool {
 	activeStatus := false // assume inactive; give the benefit of doubt to start with.
-	if config.AppCfg.ActivityMonitorConfig.EnableThresholdLogic { // if ingress should be compared to egress...
-		if a.rollingPacketLenTotal[models.Ingress][lastMinuteIndex] >= config.AppCfg.ActivityMonitorConfig.ThresholdIngressEgressKB &&
+	cfg := config.Current().ActivityMonitorConfig
+	if cfg.EnableThresholdLogic { // if ingress should be compared to egress...
+		if a.rollingPacketLenTotal[models.Ingress][lastMinuteIndex] >= cfg.ThresholdIngressEgressKB &&
 			a.rollingPacketLenTotal[models.Ingress][lastMinuteIndex] > a.rollingPacketLenTotal[models.Egress][lastMinuteIndex] { // // if ingress is xKB more than egress...
 			activeStatus = true
 		}
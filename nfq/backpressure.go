@@ -0,0 +1,161 @@
+package nfq
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/eventbus"
+	"relloyd/tubetimeout/models"
+)
+
+// The FILTER_BACKPRESSURE values.
+const (
+	backpressureOff      = "off"
+	backpressureReduce   = "reduce"
+	backpressureFailOpen = "failopen"
+)
+
+const (
+	backpressureMinPackets = 20 // backpressureMinPackets is the fewest verdicts in a second for their latency to count.
+	backpressureSlowShare  = 10 // backpressureSlowShare is N where over 1 in N slow verdicts in a second is overloaded.
+	backpressureFullShare  = 90 // backpressureFullShare is the percentage of the worker backlog in use that is overloaded.
+)
+
+// backpressure backs off the packet handling when it can't keep up, so that a slow handler doesn't stall every
+// device's traffic. It's ticked once a second with whether any queue was behind, and moves up a level after the
+// window of seconds behind, or down a level after the window of seconds keeping up. Fail-open lasts for a fixed time
+// since the queues have nothing to measure while traffic isn't queued.
+type backpressure struct {
+	logger      *zap.Logger
+	mode        string
+	latency     time.Duration // latency is the verdict latency over which packets count as slow.
+	window      int           // window is the number of ticks before the level changes.
+	failOpenFor time.Duration
+	reducing    atomic.Bool // reducing is read by the packet handler to skip non-essential work.
+
+	mu                sync.Mutex
+	level             models.BackpressureLevel
+	behind, keepingUp int       // behind and keepingUp are the consecutive ticks in each state.
+	failOpenUntil     time.Time // failOpenUntil is when the queues are tried again after failing open.
+	bus               *eventbus.Bus
+}
+
+// checkBackpressureMode returns an error if the mode isn't one of the FILTER_BACKPRESSURE values.
+func checkBackpressureMode(mode string) error {
+	switch mode {
+	case backpressureOff, backpressureReduce, backpressureFailOpen, "":
+		return nil
+	}
+	return fmt.Errorf("unknown backpressure mode %q, expected %v, %v or %v", mode, backpressureOff, backpressureReduce, backpressureFailOpen)
+}
+
+func newBackpressure(logger *zap.Logger, cfg *config.FilterConfig, bus *eventbus.Bus) *backpressure {
+	window := int(cfg.BackpressureWindow / time.Second)
+	if window < 1 {
+		window = 1
+	}
+	return &backpressure{
+		logger:      logger,
+		mode:        cfg.Backpressure,
+		latency:     cfg.BackpressureLatency,
+		window:      window,
+		failOpenFor: cfg.BackpressureFailOpenDuration,
+		level:       models.BackpressureNormal,
+		bus:         bus,
+	}
+}
+
+// enabled returns true if the handler should back off when it can't keep up.
+func (b *backpressure) enabled() bool {
+	return b.mode == backpressureReduce || b.mode == backpressureFailOpen
+}
+
+// isSlow returns true if a verdict that took d counts as slow.
+func (b *backpressure) isSlow(d time.Duration) bool {
+	return b.enabled() && b.latency > 0 && d > b.latency
+}
+
+// reduced returns true while work that isn't needed to enforce the thresholds should be skipped.
+func (b *backpressure) reduced() bool {
+	return b.reducing.Load()
+}
+
+// tick moves the level up or down. reason says why the queues were behind in the last second, or is empty if they
+// kept up.
+func (b *backpressure) tick(now time.Time, reason string) {
+	if !b.enabled() {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.level == models.BackpressureFailOpen { // if traffic isn't being queued, there's nothing to measure...
+		if now.Before(b.failOpenUntil) {
+			return
+		}
+		b.setLevel(models.BackpressureReduced, fmt.Sprintf("queuing again after failing open for %v", b.failOpenFor))
+		return
+	}
+
+	if reason != "" {
+		b.behind++
+		b.keepingUp = 0
+	} else {
+		b.keepingUp++
+		b.behind = 0
+	}
+	switch {
+	case b.behind >= b.window && b.level == models.BackpressureNormal:
+		b.setLevel(models.BackpressureReduced, reason)
+	case b.behind >= b.window && b.level == models.BackpressureReduced && b.mode == backpressureFailOpen:
+		b.failOpenUntil = now.Add(b.failOpenFor)
+		b.setLevel(models.BackpressureFailOpen, reason)
+	case b.keepingUp >= b.window && b.level == models.BackpressureReduced:
+		b.setLevel(models.BackpressureNormal, "the packet handler is keeping up")
+	}
+}
+
+// setLevel changes the level, publishes whether traffic should be queued if that changed, and publishes a live event.
+// This should be done under mu.
+func (b *backpressure) setLevel(level models.BackpressureLevel, reason string) {
+	failOpen := level == models.BackpressureFailOpen
+	if failOpen != (b.level == models.BackpressureFailOpen) {
+		b.bus.FailOpen.Publish(failOpen)
+	}
+	b.level = level
+	b.behind, b.keepingUp = 0, 0
+	b.reducing.Store(level != models.BackpressureNormal)
+	if level == models.BackpressureNormal {
+		b.logger.Sugar().Infof("Packet handling backpressure is back to %v: %v", level, reason)
+	} else {
+		b.logger.Sugar().Warnf("Packet handling backpressure is now %v: %v", level, reason)
+	}
+	b.bus.Live.Publish(models.LiveEvent{Type: models.LiveEventBackpressure, Time: time.Now(), Data: models.BackpressureEvent{Level: level, Reason: reason}})
+}
+
+// current returns the level and true if it isn't normal.
+func (b *backpressure) current() (models.BackpressureLevel, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.level, b.level != models.BackpressureNormal
+}
+
+// RegisterFailOpenReceivers subscribes receivers to the bus's fail-open state, to be told when the tracked devices'
+// traffic should bypass the queues.
+func (f *NFQueueFilter) RegisterFailOpenReceivers(receivers ...models.FailOpenReceiver) {
+	for _, r := range receivers {
+		f.backpressure.bus.FailOpen.Subscribe(r.UpdateFailOpen)
+	}
+}
+
+// RegisterLiveEventReceivers subscribes receivers to the bus's live events, which the filter sends an event to each
+// time the backpressure level changes.
+func (f *NFQueueFilter) RegisterLiveEventReceivers(receivers ...models.LiveEventReceiver) {
+	for _, r := range receivers {
+		f.backpressure.bus.Live.Subscribe(r.PublishEvent)
+	}
+}
@@ -0,0 +1,118 @@
+package nfq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/eventbus"
+	"relloyd/tubetimeout/models"
+)
+
+type mockFailOpenReceiver struct {
+	updates []bool
+}
+
+func (m *mockFailOpenReceiver) UpdateFailOpen(on bool) {
+	m.updates = append(m.updates, on)
+}
+
+type mockLiveEventReceiver struct {
+	levels []models.BackpressureLevel
+}
+
+func (m *mockLiveEventReceiver) PublishEvent(e models.LiveEvent) {
+	m.levels = append(m.levels, e.Data.(models.BackpressureEvent).Level)
+}
+
+func TestBackpressure_Tick(t *testing.T) {
+	cfg := &config.FilterConfig{Backpressure: backpressureFailOpen, BackpressureLatency: 100 * time.Millisecond, BackpressureWindow: 3 * time.Second, BackpressureFailOpenDuration: time.Minute}
+	fo, live := &mockFailOpenReceiver{}, &mockLiveEventReceiver{}
+	f := &NFQueueFilter{backpressure: newBackpressure(zap.NewNop(), cfg, eventbus.NewBus())}
+	f.RegisterFailOpenReceivers(fo)
+	f.RegisterLiveEventReceivers(live)
+	b := f.backpressure
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	tick := func(n int, reason string) {
+		for range n {
+			now = now.Add(time.Second)
+			b.tick(now, reason)
+		}
+	}
+
+	assert.True(t, b.isSlow(200*time.Millisecond))
+	assert.False(t, b.isSlow(50*time.Millisecond))
+
+	// Expect a second that keeps up to reset the count of seconds behind.
+	tick(2, "behind")
+	tick(1, "")
+	tick(2, "behind")
+	assert.False(t, b.reduced())
+	tick(1, "behind")
+	assert.True(t, b.reduced(), "expected work to be reduced after the window behind")
+	assert.Empty(t, fo.updates)
+
+	tick(3, "behind")
+	level, _ := b.current()
+	assert.Equal(t, models.BackpressureFailOpen, level)
+	assert.Equal(t, []bool{true}, fo.updates, "expected traffic to bypass the queues when reducing work isn't enough")
+
+	// Expect fail-open to last its duration whatever the queues report, then queuing to be tried again.
+	tick(59, "")
+	level, _ = b.current()
+	assert.Equal(t, models.BackpressureFailOpen, level)
+	tick(1, "")
+	level, _ = b.current()
+	assert.Equal(t, models.BackpressureReduced, level)
+	assert.Equal(t, []bool{true, false}, fo.updates)
+
+	tick(3, "")
+	level, degraded := b.current()
+	assert.Equal(t, models.BackpressureNormal, level)
+	assert.False(t, degraded)
+	assert.False(t, b.reduced())
+	assert.Equal(t, []models.BackpressureLevel{models.BackpressureReduced, models.BackpressureFailOpen, models.BackpressureReduced, models.BackpressureNormal}, live.levels)
+}
+
+func TestBackpressure_Modes(t *testing.T) {
+	cfg := &config.FilterConfig{Backpressure: backpressureReduce, BackpressureWindow: time.Second}
+	b := newBackpressure(zap.NewNop(), cfg, eventbus.NewBus())
+	for range 5 {
+		b.tick(time.Now(), "behind")
+	}
+	level, _ := b.current()
+	assert.Equal(t, models.BackpressureReduced, level, "expected reduce mode never to fail open")
+
+	cfg.Backpressure = backpressureOff
+	b = newBackpressure(zap.NewNop(), cfg, eventbus.NewBus())
+	b.tick(time.Now(), "behind")
+	assert.False(t, b.reduced())
+	assert.False(t, b.isSlow(time.Hour))
+
+	assert.NoError(t, checkBackpressureMode(""))
+	assert.Error(t, checkBackpressureMode("fail-open"))
+}
+
+func TestQueueStats_Behind(t *testing.T) {
+	s := newQueueStats(100, models.Egress)
+	s.count.Add(100)
+	s.slow.Add(5)
+	s.tick()
+	assert.Empty(t, s.behind(), "expected a few slow verdicts to be fine")
+
+	s.count.Add(100)
+	s.slow.Add(20)
+	s.tick()
+	assert.Contains(t, s.behind(), "20 of 100 verdicts")
+
+	s.pool = &workerPool{workers: []chan packet{make(chan packet, 5), make(chan packet, 5)}} // a pool without workers to drain it.
+	for _, c := range s.pool.workers {
+		for range cap(c) {
+			c <- packet{}
+		}
+	}
+	s.tick()
+	assert.Contains(t, s.behind(), "backlog of queue 100 (out) is 10 of 10 packets")
+}
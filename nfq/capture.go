@@ -0,0 +1,183 @@
+package nfq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+const (
+	captureSnapLen    = 128 // captureSnapLen is enough bytes for the IPv4 and TCP headers with options.
+	captureLinkType   = 101 // captureLinkType is LINKTYPE_RAW since queued packets start at the IP header.
+	captureFilePrefix = "capture-"
+)
+
+var nowFunc = time.Now
+
+// capturedPacket is the headers of a packet matched to a group being captured.
+type capturedPacket struct {
+	time   time.Time
+	length int    // length is the length of the whole packet.
+	header []byte // header is the first captureSnapLen bytes or fewer.
+}
+
+// groupCapture holds the most recent packets of a group in a ring buffer.
+type groupCapture struct {
+	enabled bool
+	since   time.Time
+	packets []capturedPacket
+	next    int // next is the index in packets to overwrite once the ring is full.
+	max     int
+	file    string
+}
+
+func (g *groupCapture) add(p capturedPacket) {
+	if len(g.packets) < g.max {
+		g.packets = append(g.packets, p)
+		return
+	}
+	g.packets[g.next] = p
+	g.next = (g.next + 1) % g.max
+}
+
+// ordered returns the packets oldest first.
+func (g *groupCapture) ordered() []capturedPacket {
+	return append(slices.Clone(g.packets[g.next:]), g.packets[:g.next]...)
+}
+
+// capture records the headers of packets for the groups it's enabled for, so that it's possible to see why an app
+// isn't being throttled without running tcpdump. It's off for every group at startup.
+type capture struct {
+	cfg    *config.FilterConfig
+	active atomic.Int32 // active is the number of groups being captured, checked for each packet before copying headers.
+	mu     sync.Mutex
+	groups map[models.Group]*groupCapture
+}
+
+func newCapture(cfg *config.FilterConfig) *capture {
+	return &capture{cfg: cfg, groups: make(map[models.Group]*groupCapture)}
+}
+
+// enabled returns true if any group is being captured.
+func (c *capture) enabled() bool {
+	return c.active.Load() > 0
+}
+
+// record adds the packet to the group's capture if it's enabled.
+func (c *capture) record(group models.Group, received time.Time, header []byte, length int) {
+	if header == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if g, ok := c.groups[group]; ok && g.enabled {
+		g.add(capturedPacket{time: received, length: length, header: header})
+	}
+}
+
+// SetCapture starts or stops capturing packets for the group. Starting clears any earlier capture. Stopping writes
+// the packets captured to a pcap file in the app's home directory, which stays available to download.
+func (c *capture) SetCapture(group models.Group, enabled bool) (models.CaptureState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	g, ok := c.groups[group]
+	if enabled {
+		if !ok || !g.enabled {
+			c.active.Add(1)
+		}
+		g = &groupCapture{enabled: true, since: nowFunc(), max: max(c.cfg.CaptureMaxPackets, 1)}
+		c.groups[group] = g
+		return captureState(group, g), nil
+	}
+	if !ok || !g.enabled { // if there's nothing to stop...
+		return models.CaptureState{Group: group}, nil
+	}
+	g.enabled = false
+	c.active.Add(-1)
+	path, err := config.DefaultStore.Path(captureFilePrefix + string(group) + ".pcap")
+	if err != nil {
+		return captureState(group, g), fmt.Errorf("failed to get capture file path: %w", err)
+	}
+	var buf bytes.Buffer
+	if err = writePcap(&buf, g.ordered()); err == nil {
+		err = config.DefaultStore.WriteFile(path, buf.String())
+	}
+	if err != nil {
+		return captureState(group, g), fmt.Errorf("failed to write capture file: %w", err)
+	}
+	g.file = path
+	return captureState(group, g), nil
+}
+
+// Captures returns the state of the capture of each group that has one, running or stopped.
+func (c *capture) Captures() []models.CaptureState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	retval := make([]models.CaptureState, 0, len(c.groups))
+	for group, g := range c.groups {
+		retval = append(retval, captureState(group, g))
+	}
+	slices.SortFunc(retval, func(a, b models.CaptureState) int { return strings.Compare(string(a.Group), string(b.Group)) })
+	return retval
+}
+
+// WritePcap writes the packets captured for the group so far in pcap format.
+func (c *capture) WritePcap(w io.Writer, group models.Group) error {
+	c.mu.Lock()
+	g, ok := c.groups[group]
+	var packets []capturedPacket
+	if ok {
+		packets = g.ordered()
+	}
+	c.mu.Unlock()
+	if !ok {
+		return models.ErrCaptureNotFound
+	}
+	return writePcap(w, packets)
+}
+
+func captureState(group models.Group, g *groupCapture) models.CaptureState {
+	return models.CaptureState{Group: group, Enabled: g.enabled, Since: g.since, Packets: len(g.packets), File: g.file}
+}
+
+// writePcap writes the packets as a pcap file with microsecond timestamps.
+func writePcap(w io.Writer, packets []capturedPacket) error {
+	hdr := struct {
+		Magic        uint32
+		VersionMajor uint16
+		VersionMinor uint16
+		ThisZone     int32
+		SigFigs      uint32
+		SnapLen      uint32
+		LinkType     uint32
+	}{0xa1b2c3d4, 2, 4, 0, 0, captureSnapLen, captureLinkType}
+	if err := binary.Write(w, binary.LittleEndian, hdr); err != nil {
+		return err
+	}
+	for _, p := range packets {
+		rec := struct {
+			Sec, Usec, InclLen, OrigLen uint32
+		}{uint32(p.time.Unix()), uint32(p.time.Nanosecond() / 1000), uint32(len(p.header)), uint32(p.length)}
+		if err := binary.Write(w, binary.LittleEndian, rec); err != nil {
+			return err
+		}
+		if _, err := w.Write(p.header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// captureHeader returns a copy of the start of the payload for capturing.
+func captureHeader(payload []byte) []byte {
+	return slices.Clone(payload[:min(len(payload), captureSnapLen)])
+}
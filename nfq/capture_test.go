@@ -0,0 +1,66 @@
+package nfq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+func TestCapture(t *testing.T) {
+	dir := t.TempDir()
+	orig := config.DefaultStore
+	defer func() { config.DefaultStore = orig }()
+	config.DefaultStore = config.NewStore(dir)
+
+	c := newCapture(&config.FilterConfig{CaptureMaxPackets: 2})
+	assert.False(t, c.enabled())
+	_, err := c.SetCapture("kids", true)
+	assert.NoError(t, err)
+	assert.True(t, c.enabled())
+
+	start := time.Unix(1717257600, 0)
+	for i := 0; i < 3; i++ {
+		c.record("kids", start.Add(time.Duration(i)*time.Second), []byte{0x45, byte(i)}, 1500)
+	}
+	c.record("teens", start, []byte{0x45}, 60) // expect groups that aren't captured to be ignored.
+	c.record("kids", start, nil, 60)           // expect packets read before the capture started to be ignored.
+
+	state, err := c.SetCapture("kids", false)
+	assert.NoError(t, err)
+	assert.False(t, c.enabled())
+	assert.Equal(t, 2, state.Packets, "expected the ring to keep the most recent packets only")
+	assert.Equal(t, filepath.Join(dir, "capture-kids.pcap"), state.File)
+
+	data, err := os.ReadFile(state.File)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(0xa1b2c3d4), binary.LittleEndian.Uint32(data[0:4]))
+	assert.Equal(t, uint32(captureLinkType), binary.LittleEndian.Uint32(data[20:24]))
+	rec := data[24:]
+	assert.Equal(t, uint32(start.Unix()+1), binary.LittleEndian.Uint32(rec[0:4]), "expected the oldest packet kept first")
+	assert.Equal(t, uint32(2), binary.LittleEndian.Uint32(rec[8:12]))
+	assert.Equal(t, uint32(1500), binary.LittleEndian.Uint32(rec[12:16]))
+	assert.Equal(t, []byte{0x45, 1}, rec[16:18])
+	assert.Len(t, data, 24+2*(16+2))
+
+	var buf bytes.Buffer
+	assert.NoError(t, c.WritePcap(&buf, "kids"), "expected a stopped capture to stay available")
+	assert.Equal(t, data, buf.Bytes())
+	assert.ErrorIs(t, c.WritePcap(&buf, "teens"), models.ErrCaptureNotFound)
+	assert.Equal(t, []models.CaptureState{state}, c.Captures())
+}
+
+func TestCaptureHeader(t *testing.T) {
+	payload := make([]byte, 1500)
+	header := captureHeader(payload)
+	assert.Len(t, header, captureSnapLen)
+	payload[0] = 0x45
+	assert.Equal(t, byte(0), header[0], "expected a copy since the netlink buffer is reused")
+	assert.Len(t, captureHeader(payload[:40]), 40)
+}
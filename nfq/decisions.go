@@ -0,0 +1,101 @@
+package nfq
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"relloyd/tubetimeout/models"
+)
+
+// The decisions made for a group's packets.
+const (
+	decisionAccept      = "accept"
+	decisionAllowlisted = "allowlisted"
+	decisionDrop        = "drop"
+	decisionDelay       = "delay"
+	decisionShape       = "shape"
+)
+
+type decisionKey struct {
+	group    models.Group
+	decision string
+}
+
+// decisions counts the decisions made for each group's packets since the filter started or FilterConfig.Simulate last
+// changed, so that simulated decisions aren't mixed with enforced ones.
+type decisions struct {
+	simulated atomic.Bool
+	mu        sync.RWMutex
+	since     time.Time
+	counts    map[decisionKey]*atomic.Uint64
+}
+
+func newDecisions(simulated bool) *decisions {
+	d := &decisions{since: time.Now(), counts: make(map[decisionKey]*atomic.Uint64)}
+	d.simulated.Store(simulated)
+	return d
+}
+
+// record counts the decision for the group's packet, starting over if simulated has changed.
+func (d *decisions) record(simulated bool, group models.Group, decision string) {
+	if d.simulated.Load() != simulated {
+		d.reset(simulated)
+	}
+	key := decisionKey{group: group, decision: decision}
+	d.mu.RLock()
+	c, ok := d.counts[key]
+	d.mu.RUnlock()
+	if !ok {
+		d.mu.Lock()
+		if c, ok = d.counts[key]; !ok {
+			c = &atomic.Uint64{}
+			d.counts[key] = c
+		}
+		d.mu.Unlock()
+	}
+	c.Add(1)
+}
+
+func (d *decisions) reset(simulated bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.simulated.Load() == simulated { // if another worker got here first...
+		return
+	}
+	d.since, d.counts = time.Now(), make(map[decisionKey]*atomic.Uint64)
+	d.simulated.Store(simulated)
+}
+
+func (d *decisions) snapshot() models.PacketDecisions {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	result := models.PacketDecisions{Simulated: d.simulated.Load(), Since: d.since, Groups: []models.GroupDecisions{}}
+	groups := make(map[models.Group]*models.GroupDecisions)
+	for key, c := range d.counts {
+		g, ok := groups[key.group]
+		if !ok {
+			g = &models.GroupDecisions{Group: key.group}
+			groups[key.group] = g
+		}
+		switch key.decision {
+		case decisionAccept:
+			g.Accepted = c.Load()
+		case decisionAllowlisted:
+			g.Allowlisted = c.Load()
+		case decisionDrop:
+			g.Dropped = c.Load()
+		case decisionDelay:
+			g.Delayed = c.Load()
+		case decisionShape:
+			g.Shaped = c.Load()
+		}
+	}
+	for _, g := range groups {
+		result.Groups = append(result.Groups, *g)
+	}
+	slices.SortFunc(result.Groups, func(a, b models.GroupDecisions) int { return strings.Compare(string(a.Group), string(b.Group)) })
+	return result
+}
@@ -0,0 +1,27 @@
+package nfq
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/models"
+)
+
+func TestDecisions(t *testing.T) {
+	d := newDecisions(false)
+	d.record(false, "teen", decisionAccept)
+	d.record(false, "kids", decisionDrop)
+	d.record(false, "kids", decisionDrop)
+	d.record(false, "kids", decisionDelay)
+	s := d.snapshot()
+	assert.False(t, s.Simulated)
+	assert.Equal(t, []models.GroupDecisions{{Group: "kids", Dropped: 2, Delayed: 1}, {Group: "teen", Accepted: 1}}, s.Groups)
+
+	// Expect the counts to start over when simulating so that they aren't mixed with the enforced ones.
+	since := s.Since
+	d.record(true, "kids", decisionShape)
+	s = d.snapshot()
+	assert.True(t, s.Simulated)
+	assert.False(t, s.Since.Before(since))
+	assert.Equal(t, []models.GroupDecisions{{Group: "kids", Shaped: 1}}, s.Groups)
+}
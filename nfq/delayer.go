@@ -0,0 +1,108 @@
+package nfq
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// delayer holds packets back without blocking the goroutine that handles the queue. Each flow, i.e. the packets
+// between a pair of IPs, has its own line of held packets with a timer for the packet at the front, so only the
+// delayed flow waits and its packets are released in the order they arrived.
+type delayer struct {
+	mu     sync.Mutex
+	flows  map[string]*heldFlow
+	closed bool
+}
+
+type heldFlow struct {
+	packets []heldPacket // packets are in the order they arrived.
+	timer   *time.Timer  // timer releases the packet at the front.
+}
+
+type heldPacket struct {
+	release   time.Time
+	fnRelease func()
+}
+
+// newDelayer returns a delayer that releases any packets still held once ctx is done.
+func newDelayer(ctx context.Context) *delayer {
+	d := &delayer{flows: make(map[string]*heldFlow)}
+	go func() {
+		<-ctx.Done()
+		d.close()
+	}()
+	return d
+}
+
+// hold calls fnRelease once wait has passed and the packets held before it in the same flow have been released.
+// fnRelease is called straight away if there's nothing to wait for.
+func (d *delayer) hold(flow string, wait time.Duration, fnRelease func()) {
+	d.mu.Lock()
+	fl, held := d.flows[flow]
+	if d.closed || (!held && wait <= 0) { // if there's nothing to wait for...
+		d.mu.Unlock()
+		fnRelease()
+		return
+	}
+	defer d.mu.Unlock()
+	p := heldPacket{release: time.Now().Add(wait), fnRelease: fnRelease}
+	if held { // if the flow's timer is already running for an earlier packet...
+		fl.packets = append(fl.packets, p)
+		return
+	}
+	fl = &heldFlow{packets: []heldPacket{p}}
+	fl.timer = time.AfterFunc(wait, func() { d.release(flow) })
+	d.flows[flow] = fl
+}
+
+// release calls fnRelease for the packets at the front of the flow that are due, then sets the timer for the next.
+// The packets are released while holding the lock so that packets arriving meanwhile can't jump the line.
+func (d *delayer) release(flow string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fl, ok := d.flows[flow]
+	if !ok { // if the delayer was closed...
+		return
+	}
+	now := time.Now()
+	for len(fl.packets) > 0 && !fl.packets[0].release.After(now) {
+		fl.packets[0].fnRelease()
+		fl.packets = fl.packets[1:]
+	}
+	if len(fl.packets) == 0 {
+		delete(d.flows, flow)
+		return
+	}
+	fl.timer = time.AfterFunc(fl.packets[0].release.Sub(now), func() { d.release(flow) })
+}
+
+// close releases every held packet now and stops holding new ones.
+func (d *delayer) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	for flow, fl := range d.flows {
+		fl.timer.Stop()
+		for _, p := range fl.packets {
+			p.fnRelease()
+		}
+		delete(d.flows, flow)
+	}
+}
+
+// held returns the number of packets being held.
+func (d *delayer) held() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for _, fl := range d.flows {
+		n += len(fl.packets)
+	}
+	return n
+}
+
+// flowKey returns the key of the flow between the packet's IPs.
+func flowKey(pips packetIPs) string {
+	return string(pips.src) + string(pips.dst)
+}
@@ -0,0 +1,58 @@
+package nfq
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelayer_HoldsOnlyTheDelayedFlow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := newDelayer(ctx)
+
+	var mu sync.Mutex
+	var released []string
+	fnRelease := func(name string) func() {
+		return func() {
+			mu.Lock()
+			defer mu.Unlock()
+			released = append(released, name)
+		}
+	}
+
+	d.hold("slow", 50*time.Millisecond, fnRelease("slow-1"))
+	d.hold("slow", 0, fnRelease("slow-2")) // expect this to wait behind slow-1.
+	d.hold("fast", 0, fnRelease("fast-1"))
+	mu.Lock()
+	assert.Equal(t, []string{"fast-1"}, released, "expected other flows not to wait for the delayed one")
+	mu.Unlock()
+	assert.Equal(t, 2, d.held())
+
+	assert.Eventually(t, func() bool { return d.held() == 0 }, time.Second, 5*time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, []string{"fast-1", "slow-1", "slow-2"}, released, "expected the flow's packets to be released in order")
+	mu.Unlock()
+}
+
+func TestDelayer_ReleasesHeldPacketsOnClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := newDelayer(ctx)
+	done := make(chan struct{})
+	d.hold("slow", time.Hour, func() { close(done) })
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the held packet to be released once the context is done")
+	}
+	assert.Equal(t, 0, d.held())
+
+	var releasedNow bool
+	d.hold("slow", time.Hour, func() { releasedNow = true })
+	assert.True(t, releasedNow, "expected packets not to be held after closing")
+}
@@ -2,9 +2,13 @@ package nfq
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
+	"io"
 	"math/rand"
 	"net"
+	"slices"
+	"sync/atomic"
 	"time"
 
 	"github.com/florianl/go-nfqueue"
@@ -12,9 +16,11 @@ import (
 	"go.uber.org/zap"
 	"golang.org/x/sys/unix"
 	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/eventbus"
 	"relloyd/tubetimeout/group"
 	"relloyd/tubetimeout/models"
 	"relloyd/tubetimeout/monitor"
+	"relloyd/tubetimeout/ratelimit"
 )
 
 type packetIPs struct {
@@ -22,12 +28,51 @@ type packetIPs struct {
 	dst net.IP
 }
 
+// SampleRater returns N if only 1 in N packets from the local ip are queued, so that counts can be scaled up to match.
+type SampleRater interface {
+	SampleRate(ip models.Ip) int
+}
+
+// BypassDetector is sent the outbound packets to spot devices trying to get around the filter. Detect is called for
+// each packet before its verdict is set, so it mustn't block.
+type BypassDetector interface {
+	Detect(src, dst models.Ip, port uint16)
+}
+
+// DNSAnswerObserver is sent the inbound DNS answers to learn the IPs of the tracked domains. ObserveDNSAnswer is called
+// with a copy of each IPv4 packet before its verdict is set, so it mustn't block.
+type DNSAnswerObserver interface {
+	ObserveDNSAnswer(packet []byte)
+}
+
 type NFQueueFilter struct {
-	Nfq    []*nfqueue.Nfqueue
-	ut     models.TrackerI
-	gm     group.ManagerI
-	tc     monitor.TrafficCounter
-	logger *zap.Logger
+	Nfq     []*nfqueue.Nfqueue
+	ut      models.TrackerI
+	gm      group.ManagerI
+	tc      monitor.TrafficCounter
+	sr      SampleRater
+	dc      models.DestinationCounter
+	bd      BypassDetector
+	do      DNSAnswerObserver
+	logger  *zap.Logger
+	stats   []*queueStats
+	limiter *ratelimit.Limiter
+	delayer *delayer
+	capture *capture
+	// trace records the decisions made for the packets of the devices being traced.
+	trace *trace
+	// names are the server names seen in outbound ClientHellos, for putting packets in their group's categories.
+	names *serverNames
+	// decisions counts the decisions made for each group's packets, which are only simulated if FilterConfig.Simulate.
+	decisions *decisions
+	// backpressure backs off the packet handling when it can't keep up.
+	backpressure *backpressure
+	// writeTimeout is FilterConfig.WriteTimeout, which verdict latency is compared with.
+	writeTimeout time.Duration
+	// cfg is the filter config that each packet's verdict is decided with, which SetConfig replaces.
+	cfg atomic.Pointer[config.FilterConfig]
+	// lan are the LAN networks, whose traffic between each other is accepted without being counted or throttled.
+	lan []*net.IPNet
 }
 
 // NewNFQueueFilter creates a new nfqueue filtering outbound packets.
@@ -35,14 +80,36 @@ type NFQueueFilter struct {
 // Ip addresses for which to perform filtering.
 // If the packets are destined for any of the injected Ips then filtering happens based on
 // <LOGIC-TBC>
+// Packets from sampled IPs reported by sr are counted sr.SampleRate times over. sr may be nil if packets aren't sampled.
+// The bytes of tracked packets are also counted by dc against their public IP, unless dc is nil.
+// Outbound packets are sent to bd to spot bypass attempts, unless bd is nil.
+// Inbound DNS answers are sent to do if cfg.DNSInspection is set, unless do is nil.
+// Fail-open and backpressure events are published to bus, or to a bus of its own if bus is nil.
 // TODO: unit test captuing two NFQs to ensure they are both created and running.
-func NewNFQueueFilter(ctx context.Context, logger *zap.SugaredLogger, cfg *config.FilterConfig, ut models.TrackerI, gm group.ManagerI, tc monitor.TrafficCounter, fnRecover func(logger *zap.Logger)) (*NFQueueFilter, error) {
+func NewNFQueueFilter(ctx context.Context, logger *zap.SugaredLogger, cfg *config.FilterConfig, ut models.TrackerI, gm group.ManagerI, tc monitor.TrafficCounter, sr SampleRater, dc models.DestinationCounter, bd BypassDetector, do DNSAnswerObserver, bus *eventbus.Bus, fnRecover func(logger *zap.Logger)) (*NFQueueFilter, error) {
 	var err error
 
 	if cfg.PacketDropPercentage < 0 || cfg.PacketDropPercentage > 1 {
 		return nil, fmt.Errorf("packet drop percentage must be between 0 and 100")
 	}
 
+	if cfg.RateLimitKbps < 0 {
+		return nil, fmt.Errorf("rate limit must not be negative")
+	}
+
+	if cfg.WorkerQueueLen < 0 {
+		return nil, fmt.Errorf("worker queue length must not be negative")
+	}
+
+	if err = checkBackpressureMode(cfg.Backpressure); err != nil {
+		return nil, err
+	}
+
+	lan, err := cfg.LANNets()
+	if err != nil {
+		return nil, err
+	}
+
 	if ut == nil {
 		return nil, fmt.Errorf("tracker must be supplied")
 	}
@@ -60,6 +127,25 @@ func NewNFQueueFilter(ctx context.Context, logger *zap.SugaredLogger, cfg *confi
 	f.gm = gm
 	f.ut = ut
 	f.tc = tc
+	f.sr = sr
+	f.dc = dc
+	f.bd = bd
+	if cfg.DNSInspection {
+		f.do = do
+	}
+	f.cfg.Store(cfg)
+	f.lan = lan
+	f.limiter = ratelimit.NewLimiter()
+	f.delayer = newDelayer(ctx)
+	f.capture = newCapture(cfg)
+	f.trace = newTrace(cfg, f.logger)
+	f.names = newServerNames()
+	f.decisions = newDecisions(cfg.Simulate)
+	if bus == nil {
+		bus = eventbus.NewBus()
+	}
+	f.backpressure = newBackpressure(f.logger, cfg, bus)
+	f.writeTimeout = cfg.WriteTimeout
 
 	nfq1, err := f.startNFQueueFilter(ctx, cfg, cfg.OutboundQueueNumber, models.Egress, fnRecover)
 	if err != nil {
@@ -68,10 +154,12 @@ func NewNFQueueFilter(ctx context.Context, logger *zap.SugaredLogger, cfg *confi
 
 	nfq2, err := f.startNFQueueFilter(ctx, cfg, cfg.InboundQueueNumber, models.Ingress, fnRecover)
 	if err != nil {
+		_ = nfq1.Close() // release the outbound queue binding so it isn't left behind for the next start.
 		return nil, err
 	}
 
 	f.Nfq = []*nfqueue.Nfqueue{nfq1, nfq2}
+	f.startStatsWorker(ctx)
 
 	return f, nil
 }
@@ -92,7 +180,7 @@ func (f *NFQueueFilter) startNFQueueFilter(ctx context.Context, cfg *config.Filt
 		MaxPacketLen: 4096, // we only need enough length for a packet which is MTU bounced to user space.
 		Copymode:     nfqueue.NfQnlCopyPacket,
 		Flags:        0,
-		WriteTimeout: 15 * time.Millisecond, // TODO: align timeout with packet delay ms
+		WriteTimeout: cfg.WriteTimeout,
 		AfFamily:     unix.AF_INET,
 		// ReadTimeout:  0,
 		// WriteTimeout: 15 * time.Second,
@@ -106,13 +194,33 @@ func (f *NFQueueFilter) startNFQueueFilter(ctx context.Context, cfg *config.Filt
 
 	// Avoid receiving ENOBUFS errors.
 	if err := nf.SetOption(netlink.NoENOBUFS, true); err != nil {
+		_ = nf.Close()
 		return nil, fmt.Errorf("failed to set netlink option %v: %w", netlink.NoENOBUFS, err)
 	}
 
+	stats := newQueueStats(queueNumber, direction)
+	f.stats = append(f.stats, stats)
+
+	var pool *workerPool
+	if cfg.Workers > 1 { // if packets should be handled off the netlink callback...
+		pool = newWorkerPool(ctx, f.logger, cfg.Workers, cfg.WorkerQueueLen, func(p packet) {
+			defer stats.finished()
+			f.handlePacket(nf, direction, stats, p)
+		}, fnRecover)
+		stats.pool = pool
+	}
+
 	fnPacketHandler := func(a nfqueue.Attribute) int {
 		defer fnRecover(f.logger)
-
-		var retval = 0 // 0 to continue the loop; 1 to exit cleanly; -1 to stop receiving messages
+		received := time.Now()
+		stats.count.Add(1)
+		stats.pending.Add(1)
+		handedOff := false // handedOff is set once a worker has the packet, which it finishes instead.
+		defer func() {
+			if !handedOff {
+				stats.finished()
+			}
+		}()
 
 		id := *a.PacketID
 
@@ -123,81 +231,30 @@ func (f *NFQueueFilter) startNFQueueFilter(ctx context.Context, cfg *config.Filt
 			return 0 // 1 to exit clean; -1 to signal error; 0 to continue
 		}
 
-		// Check if the packet is for any of the resolved IPs.
-		// TODO: add a tracker for each group as there may be many.
-		var groups []models.Group
-		var ok bool
-		var decision string
-		var verdict = nfqueue.NfAccept
-		var proto = "proto-unknown"
-		var srcIp, dstIp models.Ip
-
 		protocol := (*a.Payload)[9] // Protocol field in IPv4
-		if protocol == 6 {
-			proto = "TCP"
-		} else if protocol == 17 {
-			proto = "UDP"
+		port := getPacketDstPort(*a.Payload, protocol)
+		var header []byte
+		if f.capture.enabled() { // if any group's packets are being captured...
+			header = captureHeader(*a.Payload)
 		}
-
-		// TODO: test that source and dest IPs are reversed in filter for Egress vs Ingress.
-		if direction == models.Egress { // if the direction is outbound...
-			srcIp = models.Ip(pips.src.String())
-			dstIp = models.Ip(pips.dst.String())
-		} else { // else if the mode is inbound...
-			// Expect the source and destination to be reversed.
-			// Source IPs will be the public IPs that we added to our destination mapping.
-			// Destinations IPs will be the local network.
-			srcIp = models.Ip(pips.dst.String())
-			dstIp = models.Ip(pips.src.String())
+		if direction == models.Ingress && f.do != nil && isDNSAnswer(*a.Payload, protocol) && !f.backpressure.reduced() {
+			f.do.ObserveDNSAnswer(slices.Clone(*a.Payload)) // the payload is reused for the next packet.
 		}
-
-		groups, ok = f.gm.IsSrcDestIpKnown(srcIp, dstIp) // check if the source and destination Ip addresses are known.
-		if ok {                                          // if the packet IPs are known...
-			for _, grp := range groups { // for each group...
-				decision = "accept" // assume success
-				active := f.tc.CountTraffic(grp, srcIp, direction, 1, l)
-				f.ut.AddSample(string(grp), active)         // remember that we saw this group (optionally count the sample if active)
-				if f.ut.HasExceededThreshold(string(grp)) { // if the threshold is exceeded for this group...
-					if rand.Float32() < cfg.PacketDropPercentage || (proto == "UDP" && cfg.PacketDropUDP) { // if we should drop the packet...
-						decision = "drop"
-						verdict = nfqueue.NfDrop
-					} else { // else introduce a delay for the packet and accept...
-						if cfg.PacketDelayMs > 0 && rand.Float32() < cfg.PacketDelayPercentage {
-							decision = "delay"
-							time.Sleep(ApplyJitter(cfg.PacketDelayMs, cfg.PacketJitterMs)) // Delay the packet
-						} else {
-							decision = "accept"
-						}
-					}
-				} // else accept the packet as the threshold is not exceeded...
-				f.logger.Debug("handled packet",
-					zap.String("decision", decision),
-					zap.String("direction", string(direction)),
-					zap.String("proto", proto),
-					zap.Uint8("protocol-byte", protocol),
-					zap.String("src", pips.src.String()),
-					zap.String("dest", pips.dst.String()),
-					zap.String("group", string(grp)),
-					zap.Bool("active", active))
-			}
-		} else { // else accept the packet since the src/dest are not known...
-			f.logger.Debug("Accept unregistered",
-				zap.String("direction", string(direction)),
-				zap.String("proto", proto),
-				zap.String("src", pips.src.String()),
-				zap.String("dest", pips.dst.String()))
+		var sni string
+		if direction == models.Egress && port == 443 { // if the packet may start a TLS connection...
+			sni = serverName(*a.Payload, protocol)
 		}
-
-		err = nf.SetVerdict(id, verdict)
-		if err != nil {
-			f.logger.Error("Error setting verdict", zap.Error(err))
-			retval = 0 // 1 to exit clean; -1 to signal error; 0 to continue
+		if pool == nil { // if packets are handled inline...
+			f.handlePacket(nf, direction, stats, packet{id: id, pips: pips, length: l, protocol: protocol, port: port, received: received, header: header, sni: sni})
+		} else if handedOff = pool.submit(ctx, newPacket(id, pips, l, protocol, port, received, header, sni)); !handedOff { // else if we're shutting down...
+			acceptPacket(f.logger, nf, id)
 		}
-
-		return retval
+		return 0 // 1 to exit clean; -1 to signal error; 0 to continue
 	}
 
 	fnErrorHandler := func(err error) int {
+		// Returning -1 below stops the queue receiving packets.
+		stats.attached.Store(false)
 		if err != nil { // if there is an error...
 			if err := ctx.Err(); err == nil { // if the context is still active...
 				f.logger.Error("NFQ error handler caught", zap.Error(err))
@@ -208,15 +265,259 @@ func (f *NFQueueFilter) startNFQueueFilter(ctx context.Context, cfg *config.Filt
 
 	err = nf.RegisterWithErrorFunc(ctx, fnPacketHandler, fnErrorHandler)
 	if err != nil {
-		return nil, fmt.Errorf("error registering nfqueue callback: %w", err)
+		_ = nf.Close()
+		if holder := describeQueueHolder(queueNumber); holder != "" { // if we can name the process holding the queue...
+			return nil, fmt.Errorf("error registering nfqueue callback (%v): %w", holder, err)
+		}
+		return nil, fmt.Errorf("error registering nfqueue callback for queue %v: %w", queueNumber, err)
 	}
+	stats.attached.Store(true)
 
 	return nf, nil
 }
 
+// handlePacket counts the packet against the groups it belongs to and sets its verdict, dropping, delaying or
+// shaping it if a group is over its threshold, or slowing it slightly if a group is nearly out of time. Delayed
+// packets are held by the delayer so that the caller can move on to the next packet. If FilterConfig.Simulate, the
+// decisions are only counted and the packet is accepted.
+func (f *NFQueueFilter) handlePacket(nf *nfqueue.Nfqueue, direction models.Direction, stats *queueStats, p packet) {
+	cfg := f.cfg.Load()
+	// Check if the packet is for any of the resolved IPs.
+	// TODO: add a tracker for each group as there may be many.
+	var groups []models.Group
+	var ok bool
+	var decision string
+	var verdict = nfqueue.NfAccept
+	var hold time.Duration
+	var proto = "proto-unknown"
+	var srcIp, dstIp models.Ip
+
+	pips, l, protocol := p.pips, p.length, p.protocol
+	if protocol == 6 {
+		proto = "TCP"
+	} else if protocol == 17 {
+		proto = "UDP"
+	}
+
+	// TODO: test that source and dest IPs are reversed in filter for Egress vs Ingress.
+	if direction == models.Egress { // if the direction is outbound...
+		srcIp = models.Ip(pips.src.String())
+		dstIp = models.Ip(pips.dst.String())
+	} else { // else if the mode is inbound...
+		// Expect the source and destination to be reversed.
+		// Source IPs will be the public IPs that we added to our destination mapping.
+		// Destinations IPs will be the local network.
+		srcIp = models.Ip(pips.dst.String())
+		dstIp = models.Ip(pips.src.String())
+	}
+
+	// Leave the traffic between LAN addresses alone, which the firewall rules shouldn't have queued, in case one of
+	// them is also an IP that a tracked domain resolved to.
+	lan := inNets(f.lan, pips.src) && inNets(f.lan, pips.dst)
+
+	// Skip the work that isn't needed to enforce the thresholds while the handler is behind.
+	reduced := f.backpressure.reduced()
+	if direction == models.Egress && f.bd != nil && !reduced && !lan { // if bypass attempts are being detected...
+		f.bd.Detect(srcIp, dstIp, p.port)
+	}
+
+	if !lan {
+		groups, ok = f.gm.IsSrcDestIpKnown(srcIp, dstIp) // check if the source and destination Ip addresses are known.
+	}
+	if ok { // if the packet IPs are known...
+		scale := 1
+		if f.sr != nil { // if only a sample of packets may be queued...
+			scale = f.sr.SampleRate(srcIp)
+		}
+		f.tc.CountBandwidth(srcIp, direction, l*scale)
+		sni := f.names.lookup(srcIp, dstIp, p.sni, p.received)
+		domain, _ := f.gm.DomainForIP(dstIp)
+		var traceMAC models.MAC
+		var tracing bool
+		if f.trace.enabled() { // if any device is being traced, find out if this is one of them...
+			traceMAC, tracing = f.tc.GetMAC(srcIp)
+		}
+		traced := models.TraceEntry{Time: p.received, Direction: direction, Proto: proto, Length: l, Device: srcIp, Remote: dstIp, Port: p.port, Domain: domain, SNI: sni, Simulated: cfg.Simulate}
+		for _, grp := range groups { // for each group...
+			decision = decisionAccept           // assume success
+			if f.gm.IsDestAllowed(grp, dstIp) { // if the group can always reach the destination, e.g. an educational site...
+				f.decisions.record(cfg.Simulate, grp, decisionAllowlisted)
+				if tracing {
+					e := traced
+					e.Group, e.Allowlisted, e.Decision = grp, true, decisionAllowlisted
+					f.traceDecision(traceMAC, e)
+				}
+				f.logger.Debug("Accept allowlisted",
+					zap.String("direction", string(direction)),
+					zap.String("src", pips.src.String()),
+					zap.String("dest", pips.dst.String()),
+					zap.String("group", string(grp)))
+				continue // don't count or throttle it.
+			}
+			if !reduced {
+				f.capture.record(grp, p.received, p.header, l)
+			}
+			active := f.tc.CountTraffic(grp, srcIp, direction, scale, l*scale)
+			if f.dc != nil && !reduced {
+				f.dc.CountDestination(grp, dstIp, l*scale) // dstIp is the public IP in both directions.
+			}
+			if domain != "" && !reduced { // if the destination's domain is known, e.g. googlevideo.com rather than ytimg.com...
+				f.tc.CountDomain(grp, domain, direction, l*scale)
+			}
+			var exceeded bool
+			category := f.ut.Category(string(grp), domain, sni)
+			if category != "" { // if the packet counts toward one of the group's categories instead of the group...
+				f.ut.AddCategorySample(string(grp), category, active)
+				exceeded = f.ut.HasExceededCategoryThreshold(string(grp), category)
+			} else {
+				f.ut.AddSample(string(grp), active)    // remember that we saw this group (optionally count the sample if active)
+				if mac, ok := f.tc.GetMAC(srcIp); ok { // if the device is known, also remember which device used the time...
+					f.ut.AddDeviceSample(string(grp), mac, active)
+				}
+				exceeded = f.ut.HasExceededThreshold(string(grp))
+			}
+			if exceeded { // if the threshold is exceeded for this group or its category...
+				policy := cfg.GroupPacketPolicy(f.ut.PacketPolicy(string(grp)))
+				dropUDP := proto == "UDP" && policy.DropUDP
+				shape := func(key string, kbps int) {
+					wait, ok := f.limiter.Reserve(key, l, kbps, cfg.RateLimitMaxDelay, time.Now())
+					if !ok { // if the packet can't be sent within the rate...
+						decision = decisionDrop
+						verdict = nfqueue.NfDrop
+					} else if wait > 0 {
+						decision = decisionShape
+						hold += wait // hold the packet until it fits the rate.
+					}
+				}
+				if dropUDP && policy.UDPRateLimitKbps > 0 { // if we should throttle UDP, e.g. QUIC, instead of dropping it...
+					shape(string(grp)+"/"+string(direction)+"/udp", policy.UDPRateLimitKbps)
+				} else if policy.RateLimitKbps > 0 && !dropUDP { // if we should shape the traffic...
+					shape(string(grp)+"/"+string(direction), policy.RateLimitKbps)
+				} else if rand.Float32() < policy.DropPercentage || dropUDP { // if we should drop the packet...
+					decision = decisionDrop
+					verdict = nfqueue.NfDrop
+				} else { // else introduce a delay for the packet and accept...
+					if policy.Delay > 0 && rand.Float32() < policy.DelayPercentage {
+						decision = decisionDelay
+						hold += ApplyJitter(policy.Delay, policy.Jitter) // Delay the packet
+					} else {
+						decision = decisionAccept
+					}
+				}
+			} else if category == "" && cfg.WarnDelay > 0 && f.ut.IsWarning(string(grp)) { // if the group is nearly out of time...
+				decision = decisionDelay
+				hold = max(hold, cfg.WarnDelay) // slow it slightly without dropping anything.
+			} // else accept the packet as the threshold is not exceeded...
+			f.decisions.record(cfg.Simulate, grp, decision)
+			if tracing {
+				e := traced
+				e.Group, e.Category, e.Active, e.Exceeded, e.Decision, e.Hold = grp, category, active, exceeded, decision, hold
+				e.Warning = !exceeded && category == "" && f.ut.IsWarning(string(grp))
+				f.traceDecision(traceMAC, e)
+			}
+			f.logger.Debug("handled packet",
+				zap.String("decision", decision),
+				zap.Bool("simulated", cfg.Simulate),
+				zap.String("direction", string(direction)),
+				zap.String("proto", proto),
+				zap.Uint8("protocol-byte", protocol),
+				zap.String("src", pips.src.String()),
+				zap.String("dest", pips.dst.String()),
+				zap.String("group", string(grp)),
+				zap.String("category", category),
+				zap.Bool("active", active))
+		}
+	} else if lan { // else accept the packet since it doesn't leave the LAN...
+		f.logger.Debug("Accept LAN",
+			zap.String("direction", string(direction)),
+			zap.String("proto", proto),
+			zap.String("src", pips.src.String()),
+			zap.String("dest", pips.dst.String()))
+	} else { // else accept the packet since the src/dest are not known...
+		f.logger.Debug("Accept unregistered",
+			zap.String("direction", string(direction)),
+			zap.String("proto", proto),
+			zap.String("src", pips.src.String()),
+			zap.String("dest", pips.dst.String()))
+	}
+
+	if cfg.Simulate { // if the decisions are only counted...
+		verdict, hold = nfqueue.NfAccept, 0
+	}
+
+	latency := time.Since(p.received)
+	stats.latency.observe(latency)
+	if f.backpressure.isSlow(latency) {
+		stats.slow.Add(1)
+	}
+	setVerdict := func() {
+		if err := nf.SetVerdict(p.id, verdict); err != nil {
+			f.logger.Error("Error setting verdict", zap.Error(err))
+		}
+	}
+	if verdict == nfqueue.NfDrop { // if the packet is dropped, it can't overtake the flow's held packets...
+		setVerdict()
+		return
+	}
+	f.delayer.hold(flowKey(pips), hold, setVerdict)
+}
+
+// SetConfig replaces the filter config that packets' verdicts are decided with, e.g. after the app config is
+// reloaded. The settings tagged reload:"startup", such as the queue numbers, keep the values the filter started with.
+func (f *NFQueueFilter) SetConfig(cfg *config.FilterConfig) {
+	f.cfg.Store(cfg)
+}
+
+// SetCapture starts or stops capturing the headers of the group's packets.
+func (f *NFQueueFilter) SetCapture(group models.Group, enabled bool) (models.CaptureState, error) {
+	return f.capture.SetCapture(group, enabled)
+}
+
+// Captures returns the state of each group's packet capture.
+func (f *NFQueueFilter) Captures() []models.CaptureState {
+	return f.capture.Captures()
+}
+
+// WritePcap writes the packets captured for the group in pcap format.
+func (f *NFQueueFilter) WritePcap(w io.Writer, group models.Group) error {
+	return f.capture.WritePcap(w, group)
+}
+
+// traceDecision adds the group's usage and mode to the decision for a traced device's packet and records it.
+func (f *NFQueueFilter) traceDecision(mac models.MAC, e models.TraceEntry) {
+	used, threshold, mode := f.ut.Usage(string(e.Group))
+	e.Used, e.Threshold, e.Mode = int(used/time.Minute), int(threshold/time.Minute), mode
+	f.trace.record(mac, e)
+}
+
+// StartTrace starts tracing the decisions made for the device's packets for the duration.
+func (f *NFQueueFilter) StartTrace(mac models.MAC, duration time.Duration) (models.TraceState, error) {
+	return f.trace.StartTrace(mac, duration)
+}
+
+// StopTrace stops tracing the device before its time is up.
+func (f *NFQueueFilter) StopTrace(mac models.MAC) (models.TraceState, error) {
+	return f.trace.StopTrace(mac)
+}
+
+// Traces returns the state of each device's trace.
+func (f *NFQueueFilter) Traces() []models.TraceState {
+	return f.trace.Traces()
+}
+
+// WriteTrace writes the decisions traced for the device as JSON.
+func (f *NFQueueFilter) WriteTrace(w io.Writer, mac models.MAC) error {
+	return f.trace.WriteTrace(w, mac)
+}
+
 // Source Ip (bytes 12-15 in IPv4 header)
 // Destination Ip (bytes 16-19 in IPv4 header)
 // getPacketIPs extracts the source and destination Ip addresses, and packet length from the packet payload.
+// inNets returns true if the IP is in one of the networks.
+func inNets(nets []*net.IPNet, ip net.IP) bool {
+	return slices.ContainsFunc(nets, func(n *net.IPNet) bool { return n.Contains(ip) })
+}
+
 func getPacketIPs(a nfqueue.Attribute) (packetIPs, int, error) {
 	if a.Payload == nil { // if there's no payload...
 		return packetIPs{}, 0, fmt.Errorf("payload is nil")
@@ -238,6 +539,31 @@ func getPacketIPs(a nfqueue.Attribute) (packetIPs, int, error) {
 	}, length, nil
 }
 
+// getPacketDstPort returns the TCP or UDP destination port from the IPv4 packet payload, or 0 if it has none.
+// The ports of both protocols are at bytes 2-3 of their headers, which follow the IPv4 header of IHL 32-bit words.
+func getPacketDstPort(payload []byte, protocol uint8) uint16 {
+	if protocol != 6 && protocol != 17 {
+		return 0
+	}
+	ihl := int(payload[0]&0x0f) * 4
+	if ihl < 20 || len(payload) < ihl+4 { // if the payload is too short for the transport header...
+		return 0
+	}
+	return binary.BigEndian.Uint16(payload[ihl+2 : ihl+4])
+}
+
+// isDNSAnswer returns true if the IPv4 packet payload is UDP from port 53, whose port is at bytes 0-1 of the header.
+func isDNSAnswer(payload []byte, protocol uint8) bool {
+	if protocol != 17 {
+		return false
+	}
+	ihl := int(payload[0]&0x0f) * 4
+	if ihl < 20 || len(payload) < ihl+8 { // if the payload is too short for the UDP header...
+		return false
+	}
+	return binary.BigEndian.Uint16(payload[ihl:ihl+2]) == 53
+}
+
 // applyJitter generates a random delay based on a base delay and jitter range.
 // Suggest ms values for baseDelayMs and jitterRangeMs.
 func ApplyJitter(baseDelayMs, jitterRangeMs time.Duration) time.Duration {
@@ -2,6 +2,7 @@ package nfq
 
 import (
 	"context"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -16,12 +17,14 @@ import (
 func TestNewNFQueueFilter(t *testing.T) {
 	ctx := context.Background()
 	logger := config.MustGetLogger()
-	counter := monitor.NewTrafficMap(logger, 5)
+	counter := monitor.NewTrafficMap(logger, 5, nil)
 
-	tracker, err := usage.NewTracker(ctx, logger, &config.AppCfg.TrackerConfig)
+	tracker, err := usage.NewTracker(ctx, logger, &config.AppCfg.TrackerConfig, nil)
 	assert.NoError(t, err, "unexpected error getting NewTrafficMap")
 
 	manager := group.NewManager(logger)
+	badLAN := config.AppCfg.FilterConfig
+	badLAN.LANCIDRs = []string{"192.168.1"}
 
 	type args struct {
 		cfg *config.FilterConfig
@@ -37,11 +40,12 @@ func TestNewNFQueueFilter(t *testing.T) {
 		{"nil tracker causes error", args{&config.AppCfg.FilterConfig, nil, manager, counter}, true},
 		{"nil manager causes error", args{&config.AppCfg.FilterConfig, tracker, nil, counter}, true},
 		{"nil counter causes error", args{&config.AppCfg.FilterConfig, tracker, manager, nil}, true},
+		{"bad LAN CIDR causes error", args{&badLAN, tracker, manager, counter}, true},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			_, err := NewNFQueueFilter(context.Background(), config.MustGetLogger(), tt.args.cfg, tt.args.t, tt.args.m, tt.args.c,
+			_, err := NewNFQueueFilter(context.Background(), config.MustGetLogger(), tt.args.cfg, tt.args.t, tt.args.m, tt.args.c, nil, nil, nil, nil, nil,
 				func(*zap.Logger) {
 					return
 				},
@@ -53,3 +57,36 @@ func TestNewNFQueueFilter(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPacketDstPort(t *testing.T) {
+	payload := make([]byte, 28)
+	payload[0] = 0x45 // IPv4 with a 20 byte header.
+	payload[22], payload[23] = 0x03, 0x55
+	assert.Equal(t, uint16(853), getPacketDstPort(payload, 6))
+	assert.Equal(t, uint16(853), getPacketDstPort(payload, 17))
+	assert.Equal(t, uint16(0), getPacketDstPort(payload, 1), "expected ICMP to have no port")
+
+	payload[0] = 0x46 // IPv4 with options that leave no room for the ports.
+	assert.Equal(t, uint16(0), getPacketDstPort(payload, 6))
+}
+
+func TestIsDNSAnswer(t *testing.T) {
+	payload := make([]byte, 28)
+	payload[0] = 0x45 // IPv4 with a 20 byte header.
+	payload[20], payload[21] = 0x00, 0x35
+	assert.True(t, isDNSAnswer(payload, 17))
+	assert.False(t, isDNSAnswer(payload, 6), "expected only UDP to be inspected")
+
+	payload[20] = 0x01
+	assert.False(t, isDNSAnswer(payload, 17), "expected other source ports to be skipped")
+	assert.False(t, isDNSAnswer(payload[:27], 17), "expected a short UDP header to be skipped")
+}
+
+func TestInNets(t *testing.T) {
+	nets, err := (&config.FilterConfig{LANCIDRs: []string{"192.168.0.0/16", "10.0.0.0/8"}}).LANNets()
+	assert.NoError(t, err)
+	assert.True(t, inNets(nets, net.ParseIP("192.168.1.20")))
+	assert.True(t, inNets(nets, net.ParseIP("10.1.2.3").To4()))
+	assert.False(t, inNets(nets, net.ParseIP("142.250.1.1")))
+	assert.False(t, inNets(nil, net.ParseIP("192.168.1.20")))
+}
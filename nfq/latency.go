@@ -0,0 +1,99 @@
+package nfq
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"relloyd/tubetimeout/models"
+)
+
+const latencyWindowSize = 60 // latencyWindowSize is the number of stats ticks between latency percentile updates.
+
+// latencyBuckets are the upper bounds of the verdict latency histogram buckets.
+var latencyBuckets = []time.Duration{
+	100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second,
+}
+
+// latencyHistogram counts the time from receiving packets to deciding their verdicts. Packets held on purpose by
+// delays or rate limiting aren't counted for the time they're held.
+type latencyHistogram struct {
+	counts []atomic.Uint64 // counts has a slot per bucket plus one for slower verdicts, incremented by the packet handler.
+	sum    atomic.Int64    // sum is the total nanoseconds observed.
+	mu     sync.Mutex
+	last   []uint64      // last is the counts at the last update, so that percentiles are for the latest window.
+	p50    time.Duration // p50 and p99 are for the latest window, guarded by mu.
+	p99    time.Duration
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{
+		counts: make([]atomic.Uint64, len(latencyBuckets)+1),
+		last:   make([]uint64, len(latencyBuckets)+1),
+	}
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// update sets the percentiles for the verdicts seen since the last update and returns the count of them.
+// Percentiles are the upper bound of the bucket they fall in, or twice the largest bound for slower verdicts.
+func (h *latencyHistogram) update() (count uint64, p99 time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	window := make([]uint64, len(h.counts))
+	for i := range h.counts {
+		c := h.counts[i].Load()
+		window[i] = c - h.last[i]
+		h.last[i] = c
+		count += window[i]
+	}
+	h.p50, h.p99 = percentile(window, count, 0.5), percentile(window, count, 0.99)
+	return count, h.p99
+}
+
+func percentile(counts []uint64, total uint64, q float64) time.Duration {
+	if total == 0 {
+		return 0
+	}
+	target := uint64(math.Ceil(q * float64(total)))
+	var seen uint64
+	for i, c := range counts[:len(latencyBuckets)] {
+		seen += c
+		if seen >= target {
+			return latencyBuckets[i]
+		}
+	}
+	return 2 * latencyBuckets[len(latencyBuckets)-1]
+}
+
+// percentiles returns the median and 99th percentile latency for the latest window.
+func (h *latencyHistogram) percentiles() (p50, p99 time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.p50, h.p99
+}
+
+// snapshot returns the cumulative histogram since startup.
+func (h *latencyHistogram) snapshot(queue uint16, direction models.Direction) models.VerdictLatency {
+	l := models.VerdictLatency{Queue: queue, Direction: direction, Buckets: make([]models.LatencyBucket, 0, len(latencyBuckets))}
+	var cumulative uint64
+	for i, le := range latencyBuckets {
+		cumulative += h.counts[i].Load()
+		l.Buckets = append(l.Buckets, models.LatencyBucket{UpperBound: le, Count: cumulative})
+	}
+	l.Count = cumulative + h.counts[len(latencyBuckets)].Load()
+	l.Sum = time.Duration(h.sum.Load())
+	return l
+}
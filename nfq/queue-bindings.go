@@ -0,0 +1,179 @@
+package nfq
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+)
+
+var (
+	// fnQueueBindingsPath lists the queues bound by userspace programs, one per line.
+	fnQueueBindingsPath = "/proc/net/netfilter/nfnetlink_queue"
+	fnProcRoot          = "/proc"
+)
+
+var ErrNoFreeQueue = errors.New("no free nfqueue number available")
+
+// queueBinding is a line from /proc/net/netfilter/nfnetlink_queue.
+// The kernel reports the netlink port ID of the bound socket, which is the holder's PID for the first socket
+// opened by a process, so we use it to name the holder where we can.
+type queueBinding struct {
+	Queue  uint16
+	PortID uint32
+}
+
+// queueHolder describes the process bound to a queue.
+type queueHolder struct {
+	PID     uint32
+	Command string // empty if there is no such process, i.e. the binding is orphaned
+}
+
+func (h queueHolder) String() string {
+	if h.Command == "" {
+		return fmt.Sprintf("netlink port %v (no matching process; the binding may be orphaned)", h.PID)
+	}
+	return fmt.Sprintf("pid %v (%v)", h.PID, h.Command)
+}
+
+// readQueueBindings parses the kernel's list of bound queues.
+// If the file doesn't exist then the nfnetlink_queue module isn't loaded yet, so nothing can be bound.
+func readQueueBindings() (map[uint16]queueBinding, error) {
+	f, err := os.Open(fnQueueBindingsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[uint16]queueBinding{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read nfqueue bindings: %w", err)
+	}
+	defer f.Close()
+
+	bindings := make(map[uint16]queueBinding)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 { // if the line doesn't have queue number and port ID...
+			continue
+		}
+		queue, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			continue
+		}
+		portID, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			continue
+		}
+		bindings[uint16(queue)] = queueBinding{Queue: uint16(queue), PortID: uint32(portID)}
+	}
+	return bindings, scanner.Err()
+}
+
+// lookupQueueHolder finds the process that owns the netlink port ID bound to a queue.
+func lookupQueueHolder(b queueBinding) queueHolder {
+	holder := queueHolder{PID: b.PortID}
+	comm, err := os.ReadFile(filepath.Join(fnProcRoot, strconv.FormatUint(uint64(b.PortID), 10), "comm"))
+	if err == nil {
+		holder.Command = strings.TrimSpace(string(comm))
+	}
+	return holder
+}
+
+// describeQueueHolder returns a message naming the holder of a queue, or an empty string if it isn't bound.
+func describeQueueHolder(queue uint16) string {
+	bindings, err := readQueueBindings()
+	if err != nil {
+		return ""
+	}
+	b, ok := bindings[queue]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("queue %v is bound by %v", queue, lookupQueueHolder(b))
+}
+
+// ResolveQueueNumbers checks the configured outbound and inbound queue numbers against the queues already bound
+// by other processes, e.g. a previous instance that didn't exit cleanly, and the queues that other software's
+// firewall rules send packets to, e.g. an IDS that isn't running right now.
+// If a queue is taken and cfg.QueueAutoSelect is set then the queue used instead last time, or else the next free
+// queue number, is chosen and saved into cfg, so this must be called before the NFT rules are generated, since they
+// send packets to these queue numbers. The mapping is recorded in queue-numbers.yaml.
+func ResolveQueueNumbers(logger *zap.SugaredLogger, cfg *config.FilterConfig) error {
+	bindings, err := readQueueBindings()
+	if err != nil {
+		return err
+	}
+	rules := readQueueRules(logger)
+	prev, err := fnGetQueueMapping(queueMappingMu, defaultQueueMappingFilePath, newQueueMapping)
+	if err != nil {
+		logger.Warnf("Unable to read the queue numbers used last time: %v", err)
+	}
+
+	ourPID := uint32(os.Getpid())
+	bound := func(q uint16) bool {
+		b, ok := bindings[q]
+		return ok && b.PortID != ourPID
+	}
+	taken := func(q uint16) bool {
+		_, used := rules[q]
+		return used || bound(q)
+	}
+	describe := func(q uint16) string {
+		if bound(q) {
+			return fmt.Sprintf("bound by %v", lookupQueueHolder(bindings[q]))
+		}
+		return fmt.Sprintf("used by the rules in %v", rules[q])
+	}
+
+	m := &queueMapping{ConfiguredOutbound: cfg.OutboundQueueNumber, ConfiguredInbound: cfg.InboundQueueNumber, Updated: time.Now()}
+	var reasons []string
+
+	for _, q := range []struct {
+		name   string
+		number *uint16
+		other  *uint16
+	}{
+		{"outbound", &cfg.OutboundQueueNumber, &cfg.InboundQueueNumber},
+		{"inbound", &cfg.InboundQueueNumber, &cfg.OutboundQueueNumber},
+	} {
+		if !taken(*q.number) { // if the queue is free...
+			continue
+		}
+		why := describe(*q.number)
+		if !cfg.QueueAutoSelect {
+			return fmt.Errorf("%v queue %v is %v; stop that software or set FILTER_QUEUE_AUTO_SELECT=true", q.name, *q.number, why)
+		}
+		alt, ok := prev.alternative(*q.number)
+		if !ok || alt == *q.other || taken(alt) { // if last time's queue can't be used again...
+			if alt, err = nextFreeQueue(*q.number, *q.other, taken); err != nil {
+				return fmt.Errorf("%v queue %v is %v: %w", q.name, *q.number, why, err)
+			}
+		}
+		logger.Warnf("The %v queue %v is %v; using queue %v instead", q.name, *q.number, why, alt)
+		reasons = append(reasons, fmt.Sprintf("queue %v is %v", *q.number, why))
+		*q.number = alt
+	}
+
+	m.Outbound, m.Inbound, m.Reason = cfg.OutboundQueueNumber, cfg.InboundQueueNumber, strings.Join(reasons, "; ")
+	resolvedQueues.Store(m)
+	if err = recordQueueMapping(prev, m); err != nil {
+		logger.Warnf("Unable to record the queue numbers in use: %v", err)
+	}
+	return nil
+}
+
+// nextFreeQueue searches upwards from start, wrapping around, for a queue that isn't taken and isn't reserved.
+func nextFreeQueue(start, reserved uint16, taken func(uint16) bool) (uint16, error) {
+	for i := uint32(1); i <= 0xFFFF; i++ {
+		q := uint16((uint32(start) + i) % 0x10000)
+		if q != reserved && !taken(q) {
+			return q, nil
+		}
+	}
+	return 0, ErrNoFreeQueue
+}
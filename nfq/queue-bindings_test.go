@@ -0,0 +1,180 @@
+package nfq
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+)
+
+func setupQueueBindings(t *testing.T, contents string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	// Fake a holder process with PID 4242.
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "4242"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "4242", "comm"), []byte("tubetimeout\n"), 0644))
+
+	path := filepath.Join(dir, "nfnetlink_queue")
+	assert.NoError(t, os.WriteFile(path, []byte(contents), 0644))
+
+	origPath, origRoot := fnQueueBindingsPath, fnProcRoot
+	fnQueueBindingsPath, fnProcRoot = path, dir
+	t.Cleanup(func() {
+		fnQueueBindingsPath, fnProcRoot = origPath, origRoot
+	})
+}
+
+// setupQueueMapping fakes the firewall commands and the recorded queue mapping, returning the mapping saved.
+func setupQueueMapping(t *testing.T, prev *queueMapping) (*config.FakeExec, **queueMapping) {
+	t.Helper()
+	fake := config.UseFakeExec(t)
+	origGet, origSet := fnGetQueueMapping, fnSetQueueMapping
+	t.Cleanup(func() {
+		fnGetQueueMapping, fnSetQueueMapping = origGet, origSet
+		resolvedQueues.Store(nil)
+	})
+	saved := new(*queueMapping)
+	fnGetQueueMapping = func(mu *sync.Mutex, configPath string, newInstance func() *queueMapping) (*queueMapping, error) {
+		return prev, nil
+	}
+	fnSetQueueMapping = func(mu *sync.Mutex, configPath string, validate func(v *queueMapping) error, updateInMemory func(v *queueMapping), v *queueMapping) error {
+		*saved = v
+		return nil
+	}
+	return fake, saved
+}
+
+func TestResolveQueueNumbers(t *testing.T) {
+	ourPID := strconv.Itoa(os.Getpid())
+
+	tests := []struct {
+		name         string
+		bindings     string
+		autoSelect   bool
+		wantOutbound uint16
+		wantInbound  uint16
+		wantErr      bool
+	}{
+		{
+			name:         "No bindings keeps configured queues",
+			bindings:     "",
+			autoSelect:   true,
+			wantOutbound: 100,
+			wantInbound:  101,
+		},
+		{
+			name:         "Our own bindings are not conflicts",
+			bindings:     "100 " + ourPID + " 0 2 4096 0 0 10 1\n",
+			autoSelect:   true,
+			wantOutbound: 100,
+			wantInbound:  101,
+		},
+		{
+			name:         "Outbound queue held by another process moves past the inbound queue",
+			bindings:     "100 4242 0 2 4096 0 0 10 1\n",
+			autoSelect:   true,
+			wantOutbound: 102,
+			wantInbound:  101,
+		},
+		{
+			name:         "Both queues held by orphaned bindings",
+			bindings:     "100 4242 0 2 4096 0 0 10 1\n101 9999 0 2 4096 0 0 10 1\n102 9999 0 2 4096 0 0 10 1\n",
+			autoSelect:   true,
+			wantOutbound: 103,
+			wantInbound:  104,
+		},
+		{
+			name:     "Conflict is an error without auto select",
+			bindings: "101 4242 0 2 4096 0 0 10 1\n",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupQueueBindings(t, tt.bindings)
+			setupQueueMapping(t, nil)
+			cfg := &config.FilterConfig{OutboundQueueNumber: 100, InboundQueueNumber: 101, QueueAutoSelect: tt.autoSelect}
+
+			err := ResolveQueueNumbers(config.MustGetLogger(), cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Contains(t, err.Error(), "pid 4242 (tubetimeout)", "expected the holder to be named")
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantOutbound, cfg.OutboundQueueNumber)
+			assert.Equal(t, tt.wantInbound, cfg.InboundQueueNumber)
+		})
+	}
+}
+
+func TestResolveQueueNumbers_Rules(t *testing.T) {
+	setupQueueBindings(t, "")
+	fake, saved := setupQueueMapping(t, nil)
+	fake.Results = map[string]config.FakeResult{
+		"nft list ruleset": {Output: `table inet suricata {
+	chain forward {
+		type filter hook forward priority filter; policy accept;
+		queue flags bypass to 100-101
+	}
+}
+table inet tubetimeout-table {
+	chain forward {
+		ip saddr @local queue num 102
+	}
+}
+`},
+		"iptables-save": {Output: "*filter\n-A FORWARD -j NFQUEUE --queue-num 103\n-A TUBETIMEOUT-FORWARD -j NFQUEUE --queue-num 104\nCOMMIT\n"},
+	}
+	cfg := &config.FilterConfig{OutboundQueueNumber: 100, InboundQueueNumber: 101, QueueAutoSelect: true}
+
+	assert.NoError(t, ResolveQueueNumbers(config.MustGetLogger(), cfg))
+	assert.Equal(t, uint16(102), cfg.OutboundQueueNumber, "expected our own stale table to be ignored")
+	assert.Equal(t, uint16(104), cfg.InboundQueueNumber, "expected queues used by other rules to be skipped")
+	if assert.NotNil(t, *saved, "expected the mapping to be recorded") {
+		assert.Equal(t, uint16(100), (*saved).ConfiguredOutbound)
+		assert.Equal(t, uint16(104), (*saved).Inbound)
+		assert.Contains(t, (*saved).Reason, "queue 100 is used by the rules in nftables table inet suricata")
+	}
+	assert.Contains(t, queueMappingMessage(), "using queues 102 (outbound) and 104 (inbound) instead of 100 and 101")
+
+	cfg = &config.FilterConfig{OutboundQueueNumber: 100, InboundQueueNumber: 101}
+	err := ResolveQueueNumbers(config.MustGetLogger(), cfg)
+	assert.ErrorContains(t, err, "used by the rules in nftables table inet suricata")
+}
+
+func TestResolveQueueNumbers_ReusesLastMapping(t *testing.T) {
+	setupQueueBindings(t, "100 4242 0 2 4096 0 0 10 1\n")
+	prev := &queueMapping{ConfiguredOutbound: 100, ConfiguredInbound: 101, Outbound: 200, Inbound: 101, Reason: "queue 100 is bound by pid 4242 (tubetimeout)"}
+	_, saved := setupQueueMapping(t, prev)
+	cfg := &config.FilterConfig{OutboundQueueNumber: 100, InboundQueueNumber: 101, QueueAutoSelect: true}
+
+	assert.NoError(t, ResolveQueueNumbers(config.MustGetLogger(), cfg))
+	assert.Equal(t, uint16(200), cfg.OutboundQueueNumber, "expected the queue used last time")
+	assert.Equal(t, uint16(101), cfg.InboundQueueNumber)
+	assert.Nil(t, *saved, "expected an unchanged mapping not to be saved again")
+}
+
+func TestDescribeQueueHolder(t *testing.T) {
+	setupQueueBindings(t, "100 4242 0 2 4096 0 0 10 1\n101 9999 0 2 4096 0 0 10 1\n")
+
+	assert.Equal(t, "queue 100 is bound by pid 4242 (tubetimeout)", describeQueueHolder(100))
+	assert.Contains(t, describeQueueHolder(101), "orphaned")
+	assert.Empty(t, describeQueueHolder(102))
+}
+
+func TestReadQueueBindings_MissingFile(t *testing.T) {
+	orig := fnQueueBindingsPath
+	fnQueueBindingsPath = filepath.Join(t.TempDir(), "missing")
+	defer func() { fnQueueBindingsPath = orig }()
+
+	bindings, err := readQueueBindings()
+	assert.NoError(t, err)
+	assert.Empty(t, bindings)
+}
@@ -0,0 +1,158 @@
+package nfq
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+)
+
+var (
+	defaultQueueMappingFilePath = "queue-numbers.yaml"
+	fnGetQueueMapping           = config.GetConfig[*queueMapping]
+	fnSetQueueMapping           = config.SetConfig[*queueMapping]
+	queueMappingMu              = &sync.Mutex{}
+	resolvedQueues              atomic.Pointer[queueMapping] // resolvedQueues is the mapping chosen at startup, for health checks.
+)
+
+const (
+	ownTablePrefix = "tubetimeout"  // ownTablePrefix starts the name of the NFT table created by the nft package.
+	ownChainPrefix = "TUBETIMEOUT-" // ownChainPrefix starts the names of the chains created by the iptables package.
+)
+
+var (
+	// nftQueueRule matches the queue statements in "nft list ruleset", e.g. "queue num 100 bypass" or "queue flags bypass to 100-103".
+	nftQueueRule = regexp.MustCompile(`\bqueue\b.*?\b(?:num|to) (\d+)(?:-(\d+))?`)
+	// iptablesQueueRule matches the NFQUEUE targets in iptables-save, e.g. "--queue-num 100" or "--queue-balance 100:103".
+	iptablesQueueRule = regexp.MustCompile(`--queue-(?:num|balance) (\d+)(?::(\d+))?`)
+)
+
+// queueMapping is the queue numbers chosen for the configured ones, saved so that later runs and anyone debugging
+// the firewall can see which queues TubeTimeout uses. It isn't backed up since it depends on the host's other software.
+type queueMapping struct {
+	ConfiguredOutbound uint16    `yaml:"configuredOutbound"`
+	ConfiguredInbound  uint16    `yaml:"configuredInbound"`
+	Outbound           uint16    `yaml:"outbound"`
+	Inbound            uint16    `yaml:"inbound"`
+	Reason             string    `yaml:"reason,omitempty"` // Reason says why a configured queue was replaced.
+	Updated            time.Time `yaml:"updated"`
+}
+
+func newQueueMapping() *queueMapping {
+	return &queueMapping{}
+}
+
+// remapped returns true if either queue differs from the configured one.
+func (m *queueMapping) remapped() bool {
+	return m.Outbound != m.ConfiguredOutbound || m.Inbound != m.ConfiguredInbound
+}
+
+// alternative returns the queue used last time instead of the configured one, if there was one.
+func (m *queueMapping) alternative(configured uint16) (uint16, bool) {
+	if m == nil {
+		return 0, false
+	}
+	if m.ConfiguredOutbound == configured && m.Outbound != configured {
+		return m.Outbound, true
+	}
+	if m.ConfiguredInbound == configured && m.Inbound != configured {
+		return m.Inbound, true
+	}
+	return 0, false
+}
+
+// readQueueRules lists the queues that other software's firewall rules send packets to, with a description of the
+// rule's table or chain. Those queues may not be bound yet, e.g. if the IDS that uses them is restarting, but packets
+// would still be mixed up with ours. Our own table and chains are skipped, and missing commands are ignored.
+func readQueueRules(logger *zap.SugaredLogger) map[uint16]string {
+	rules := make(map[uint16]string)
+	if out, err := config.Commands.Query("nft", "list", "ruleset"); err != nil {
+		logger.Debugf("Unable to list the nftables ruleset to check for queue conflicts: %v", err)
+	} else {
+		parseNFTQueueRules(out, rules)
+	}
+	if out, err := config.Commands.Query("iptables-save"); err != nil {
+		logger.Debugf("Unable to list the iptables rules to check for queue conflicts: %v", err)
+	} else {
+		parseIPTablesQueueRules(out, rules)
+	}
+	return rules
+}
+
+// parseNFTQueueRules adds the queues referenced by "nft list ruleset" outside our own table.
+func parseNFTQueueRules(out []byte, rules map[uint16]string) {
+	var family, table string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if fields := strings.Fields(line); len(fields) >= 3 && fields[0] == "table" { // if a new table starts, e.g. "table inet filter {"...
+			family, table = fields[1], fields[2]
+			continue
+		}
+		if strings.HasPrefix(table, ownTablePrefix) {
+			continue
+		}
+		if m := nftQueueRule.FindStringSubmatch(line); m != nil {
+			addQueueRange(rules, m[1], m[2], fmt.Sprintf("nftables table %v %v", family, table))
+		}
+	}
+}
+
+// parseIPTablesQueueRules adds the queues referenced by iptables-save outside our own chains.
+func parseIPTablesQueueRules(out []byte, rules map[uint16]string) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "-A" || strings.HasPrefix(fields[1], ownChainPrefix) { // if it isn't a rule or it's ours...
+			continue
+		}
+		if m := iptablesQueueRule.FindStringSubmatch(scanner.Text()); m != nil {
+			addQueueRange(rules, m[1], m[2], "iptables chain "+fields[1])
+		}
+	}
+}
+
+// addQueueRange records the queues from first to last, or just first if last is empty.
+func addQueueRange(rules map[uint16]string, first, last, where string) {
+	from, err := strconv.ParseUint(first, 10, 16)
+	if err != nil {
+		return
+	}
+	to := from
+	if last != "" {
+		if to, err = strconv.ParseUint(last, 10, 16); err != nil || to < from {
+			to = from
+		}
+	}
+	for q := from; q <= to; q++ {
+		if _, ok := rules[uint16(q)]; !ok {
+			rules[uint16(q)] = where
+		}
+	}
+}
+
+// recordQueueMapping saves the mapping if it has changed since it was last saved.
+func recordQueueMapping(prev *queueMapping, m *queueMapping) error {
+	if prev != nil && prev.ConfiguredOutbound == m.ConfiguredOutbound && prev.ConfiguredInbound == m.ConfiguredInbound &&
+		prev.Outbound == m.Outbound && prev.Inbound == m.Inbound && prev.Reason == m.Reason {
+		return nil
+	}
+	return fnSetQueueMapping(queueMappingMu, defaultQueueMappingFilePath, func(v *queueMapping) error { return nil }, func(v *queueMapping) {}, m)
+}
+
+// queueMappingMessage describes the queues in use if they aren't the configured ones, or returns an empty string.
+func queueMappingMessage() string {
+	m := resolvedQueues.Load()
+	if m == nil || !m.remapped() {
+		return ""
+	}
+	return fmt.Sprintf("using queues %v (outbound) and %v (inbound) instead of %v and %v: %v", m.Outbound, m.Inbound, m.ConfiguredOutbound, m.ConfiguredInbound, m.Reason)
+}
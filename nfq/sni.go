@@ -0,0 +1,127 @@
+package nfq
+
+import (
+	"encoding/binary"
+	"strings"
+	"sync"
+	"time"
+
+	"relloyd/tubetimeout/models"
+)
+
+const (
+	serverNameTTL        = 10 * time.Minute // serverNameTTL is how long a server name is used for the packets between a device and a remote IP.
+	serverNameMaxEntries = 8192             // serverNameMaxEntries is the most device and remote IP pairs remembered.
+)
+
+// serverName returns the server name (SNI) from a TLS ClientHello at the start of the TCP payload of the IPv4 packet,
+// or an empty string if the packet doesn't start one. Only the part of the ClientHello in this packet is read, so the
+// name is missed if it's in a later segment of a large ClientHello.
+func serverName(payload []byte, protocol uint8) string {
+	if protocol != 6 || len(payload) < 20 {
+		return ""
+	}
+	ihl := int(payload[0]&0x0f) * 4
+	if ihl < 20 || len(payload) < ihl+20 { // if the payload is too short for the TCP header...
+		return ""
+	}
+	offset := ihl + int(payload[ihl+12]>>4)*4
+	if offset+9 > len(payload) {
+		return ""
+	}
+	data := payload[offset:]
+	if data[0] != 0x16 || data[1] != 0x03 || data[5] != 0x01 { // if it isn't a TLS handshake record with a ClientHello...
+		return ""
+	}
+	b := data[9:] // skip the record header and the handshake type and length.
+
+	skip := func(n int) bool {
+		if n > len(b) {
+			return false
+		}
+		b = b[n:]
+		return true
+	}
+	skipVector := func(lenBytes int) bool {
+		if len(b) < lenBytes {
+			return false
+		}
+		n := int(b[0])
+		if lenBytes == 2 {
+			n = int(binary.BigEndian.Uint16(b))
+		}
+		return skip(lenBytes + n)
+	}
+	if !skip(2+32) || !skipVector(1) || !skipVector(2) || !skipVector(1) || len(b) < 2 { // if the version, random, session ID, cipher suites or compression methods are cut short...
+		return ""
+	}
+	b = b[2:] // the extensions' length is ignored since the ClientHello may continue in the next segment.
+	for len(b) >= 4 {
+		extType, extLen := binary.BigEndian.Uint16(b), int(binary.BigEndian.Uint16(b[2:]))
+		b = b[4:]
+		if extLen > len(b) {
+			return ""
+		}
+		if extType == 0 { // if it's the server_name extension...
+			ext := b[:extLen]
+			if len(ext) < 5 || ext[2] != 0 { // if there's no list or the first entry isn't a host name...
+				return ""
+			}
+			n := int(binary.BigEndian.Uint16(ext[3:]))
+			if 5+n > len(ext) {
+				return ""
+			}
+			return strings.ToLower(string(ext[5 : 5+n]))
+		}
+		b = b[extLen:]
+	}
+	return ""
+}
+
+// serverNames remembers the server name each device last asked for from each remote IP, so that the packets that
+// follow a ClientHello can be put in the right category.
+type serverNames struct {
+	mu      sync.Mutex
+	entries map[string]serverNameEntry
+}
+
+type serverNameEntry struct {
+	name string
+	seen time.Time
+}
+
+func newServerNames() *serverNames {
+	return &serverNames{entries: make(map[string]serverNameEntry)}
+}
+
+// lookup remembers the name for the device and remote IP if it isn't empty, and returns the name last seen for them
+// within serverNameTTL, or an empty string.
+func (s *serverNames) lookup(local, remote models.Ip, name string, now time.Time) string {
+	key := string(local) + "/" + string(remote)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if name == "" {
+		e, ok := s.entries[key]
+		if !ok {
+			return ""
+		}
+		if now.Sub(e.seen) > serverNameTTL {
+			delete(s.entries, key)
+			return ""
+		}
+		s.entries[key] = serverNameEntry{name: e.name, seen: now}
+		return e.name
+	}
+	if _, ok := s.entries[key]; !ok && len(s.entries) >= serverNameMaxEntries { // if there's no room for another pair...
+		for k, e := range s.entries {
+			if now.Sub(e.seen) > serverNameTTL {
+				delete(s.entries, k)
+			}
+		}
+		if len(s.entries) >= serverNameMaxEntries {
+			clear(s.entries)
+		}
+	}
+	s.entries[key] = serverNameEntry{name: name, seen: now}
+	return name
+}
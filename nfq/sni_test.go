@@ -0,0 +1,62 @@
+package nfq
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// clientHelloPacket returns an IPv4 TCP packet whose payload is a TLS ClientHello for the server name.
+func clientHelloPacket(name string) []byte {
+	u16 := func(v int) []byte { return binary.BigEndian.AppendUint16(nil, uint16(v)) }
+
+	sni := append(append([]byte{0}, u16(len(name))...), name...)        // host name entry
+	sni = append(u16(len(sni)), sni...)                                 // server name list
+	ext := append(append(u16(0), u16(len(sni))...), sni...)             // server_name extension
+	ext = append(append(append(u16(0x0017), u16(0)...), ext...), 0, 10) // preceded by extended_master_secret...
+	ext = append(ext, u16(0)...)                                        // ...and followed by the start of a cut short extension.
+
+	hello := []byte{0x03, 0x03}
+	hello = append(hello, make([]byte, 32)...)           // random
+	hello = append(hello, 0)                             // session ID
+	hello = append(hello, append(u16(2), 0x13, 0x01)...) // cipher suites
+	hello = append(hello, 1, 0)                          // compression methods
+	hello = append(hello, u16(len(ext))...)
+	hello = append(hello, ext...)
+
+	handshake := append([]byte{0x01, 0, byte(len(hello) >> 8), byte(len(hello))}, hello...)
+	record := append(append([]byte{0x16, 0x03, 0x01}, u16(len(handshake))...), handshake...)
+
+	ip := make([]byte, 20)
+	ip[0] = 0x45
+	ip[9] = 6
+	tcp := make([]byte, 20)
+	binary.BigEndian.PutUint16(tcp[2:], 443)
+	tcp[12] = 5 << 4
+	return append(append(ip, tcp...), record...)
+}
+
+func TestServerName(t *testing.T) {
+	packet := clientHelloPacket("Music.YouTube.com")
+	assert.Equal(t, "music.youtube.com", serverName(packet, 6))
+	assert.Empty(t, serverName(packet, 17), "expected only TCP to be parsed")
+	assert.Empty(t, serverName(packet[:60], 6), "expected a ClientHello cut short before the name to be ignored")
+
+	data := clientHelloPacket("example.com")
+	data[40] = 0x17 // application data rather than a handshake
+	assert.Empty(t, serverName(data, 6))
+	assert.Empty(t, serverName(make([]byte, 40), 6))
+}
+
+func TestServerNames_Lookup(t *testing.T) {
+	s := newServerNames()
+	now := time.Now()
+
+	assert.Empty(t, s.lookup("192.168.1.10", "1.2.3.4", "", now))
+	assert.Equal(t, "music.youtube.com", s.lookup("192.168.1.10", "1.2.3.4", "music.youtube.com", now))
+	assert.Equal(t, "music.youtube.com", s.lookup("192.168.1.10", "1.2.3.4", "", now.Add(time.Minute)), "expected the name to be used for the rest of the connection")
+	assert.Empty(t, s.lookup("192.168.1.11", "1.2.3.4", "", now), "expected names to be per device")
+	assert.Empty(t, s.lookup("192.168.1.10", "1.2.3.4", "", now.Add(time.Minute+serverNameTTL+time.Second)), "expected the name to expire")
+}
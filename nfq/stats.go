@@ -0,0 +1,241 @@
+package nfq
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"relloyd/tubetimeout/models"
+)
+
+const (
+	statsWindowSize = 60 // statsWindowSize is the number of 1s slots used for the 1m moving average.
+)
+
+// queueStats counts packets for a single queue and keeps a rolling window of per-second counts.
+type queueStats struct {
+	queue     uint16
+	direction models.Direction
+	count     atomic.Uint64 // count is incremented by the packet handler.
+	attached  atomic.Bool   // attached is set while the queue callback is registered and receiving packets.
+	slow      atomic.Uint64 // slow is incremented by the packet handler for verdicts slower than the backpressure latency.
+	pending   atomic.Int64  // pending is the number of packets received that the handler hasn't finished with.
+	done      atomic.Uint64 // done is incremented as the handler finishes with each packet, even if it panicked.
+	latency   *latencyHistogram
+	pool      *workerPool // pool is nil if packets are handled in the queue's netlink callback.
+	mu        sync.Mutex
+	lastCount uint64
+	lastSlow  uint64
+	lastDone  uint64
+	progress  time.Time // progress is when the handler last finished a packet or had none waiting.
+	window    [statsWindowSize]uint64
+	idx       int
+	filled    int
+}
+
+func newQueueStats(queue uint16, direction models.Direction) *queueStats {
+	return &queueStats{queue: queue, direction: direction, latency: newLatencyHistogram()}
+}
+
+// tick records the number of packets seen since the last tick into the rolling window.
+// It is expected to be called once per second.
+func (s *queueStats) tick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.count.Load()
+	s.idx = (s.idx + 1) % statsWindowSize
+	s.window[s.idx] = c - s.lastCount
+	s.lastCount = c
+	if s.filled < statsWindowSize {
+		s.filled++
+	}
+}
+
+// behind returns why the queue's handler didn't keep up in the last second, or "" if it did.
+// It is expected to be called after each tick.
+func (s *queueStats) behind() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slow := s.slow.Load()
+	n := slow - s.lastSlow
+	s.lastSlow = slow
+	if total := s.window[s.idx]; total >= backpressureMinPackets && n*backpressureSlowShare > total {
+		return fmt.Sprintf("%v of %v verdicts on queue %v (%v) were slow", n, total, s.queue, s.direction)
+	}
+	if s.pool != nil {
+		if queued, capacity := s.pool.backlog(); capacity > 0 && queued*100 >= capacity*backpressureFullShare {
+			return fmt.Sprintf("the worker backlog of queue %v (%v) is %v of %v packets", s.queue, s.direction, queued, capacity)
+		}
+	}
+	return ""
+}
+
+// finished is deferred by the packet handler for each packet it receives, so that packets aren't left pending if the
+// handler panics.
+func (s *queueStats) finished() {
+	s.pending.Add(-1)
+	s.done.Add(1)
+}
+
+// checkProgress records whether the handler has finished a packet or had none waiting since it was last called.
+// It is expected to be called after each tick.
+func (s *queueStats) checkProgress(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	done := s.done.Load()
+	if done != s.lastDone || s.pending.Load() <= 0 || s.progress.IsZero() {
+		s.progress = now
+	}
+	s.lastDone = done
+}
+
+// stalled returns how long the handler has had packets waiting without finishing any, as of the last progress check.
+func (s *queueStats) stalled(now time.Time) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.progress.IsZero() {
+		return 0
+	}
+	return now.Sub(s.progress)
+}
+
+// rates returns the current packet rates.
+func (s *queueStats) rates() models.PacketRates {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := models.PacketRates{
+		Queue:     s.queue,
+		Direction: s.direction,
+		Total:     s.count.Load(),
+		Attached:  s.attached.Load(),
+	}
+	p50, p99 := s.latency.percentiles()
+	r.LatencyP50, r.LatencyP99 = toMillis(p50), toMillis(p99)
+	if s.filled == 0 {
+		return r
+	}
+	r.PerSecond = float64(s.window[s.idx])
+	var sum uint64
+	for i := 0; i < s.filled; i++ {
+		sum += s.window[(s.idx-i+statsWindowSize)%statsWindowSize]
+	}
+	r.PerSecondAvg1m = float64(sum) / float64(s.filled)
+	return r
+}
+
+// startStatsWorker ticks all queue stats every second until the context is cancelled, then the backpressure with
+// whether any queue fell behind. Verdict latency percentiles are updated every minute, with a warning if the 99th
+// percentile is over the write timeout.
+func (f *NFQueueFilter) startStatsWorker(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	go func() {
+		ticks := 0
+		for {
+			select {
+			case <-ctx.Done():
+				ticker.Stop()
+				return
+			case now := <-ticker.C:
+				ticks++
+				reason := ""
+				for _, s := range f.stats {
+					s.tick()
+					s.checkProgress(now)
+					if r := s.behind(); reason == "" {
+						reason = r
+					}
+					if ticks%latencyWindowSize == 0 {
+						f.updateLatency(s)
+					}
+				}
+				f.backpressure.tick(now, reason)
+			}
+		}
+	}()
+}
+
+func (f *NFQueueFilter) updateLatency(s *queueStats) {
+	if count, p99 := s.latency.update(); count > 0 && f.writeTimeout > 0 && p99 > f.writeTimeout {
+		f.logger.Sugar().Warnf("Verdict latency p99 for queue %v (%v) is %v, over the write timeout of %v, across %v packets in the last minute: the gateway may be undersized or a policy too slow",
+			s.queue, s.direction, p99, f.writeTimeout, count)
+	}
+}
+
+// GetPacketRates returns the packet counts and rates for each queue.
+func (f *NFQueueFilter) GetPacketRates() []models.PacketRates {
+	retval := make([]models.PacketRates, 0, len(f.stats))
+	for _, s := range f.stats {
+		retval = append(retval, s.rates())
+	}
+	return retval
+}
+
+// GetPacketDecisions returns the decisions made for each group's packets, or that would have been made if simulating.
+func (f *NFQueueFilter) GetPacketDecisions() models.PacketDecisions {
+	return f.decisions.snapshot()
+}
+
+// GetVerdictLatencies returns the verdict latency histogram for each queue.
+func (f *NFQueueFilter) GetVerdictLatencies() []models.VerdictLatency {
+	retval := make([]models.VerdictLatency, 0, len(f.stats))
+	for _, s := range f.stats {
+		retval = append(retval, s.latency.snapshot(s.queue, s.direction))
+	}
+	return retval
+}
+
+// Health reports the filter as unhealthy if any queue has stopped receiving packets, or degraded if deciding verdicts
+// is taking longer than the write timeout or the handler has backed off.
+func (f *NFQueueFilter) Health() models.SubsystemHealth {
+	rates := f.GetPacketRates()
+	h := models.SubsystemHealth{Name: "nfq", Status: models.HealthOK, Details: rates, Message: queueMappingMessage()}
+	if f.decisions.simulated.Load() {
+		h.Message = "simulating: tracked packets are accepted and only counted with what would have been done"
+	}
+	if level, ok := f.backpressure.current(); ok { // if the handler has backed off...
+		h.Status = models.HealthDegraded
+		h.Message = fmt.Sprintf("packet handling backpressure is %v", level)
+	}
+	for _, r := range rates {
+		if f.writeTimeout > 0 && r.LatencyP99 > toMillis(f.writeTimeout) && h.Status == models.HealthOK {
+			h.Status = models.HealthDegraded
+			h.Message = fmt.Sprintf("queue %v (%v) verdict latency p99 is %vms, over the write timeout of %v", r.Queue, r.Direction, r.LatencyP99, f.writeTimeout)
+		}
+		if !r.Attached {
+			h.Status = models.HealthUnhealthy
+			h.Message = fmt.Sprintf("queue %v (%v) is not attached", r.Queue, r.Direction)
+		}
+	}
+	return h
+}
+
+// Stalled returns the longest time any queue's handler has had packets waiting without finishing any, and why, or
+// zero if the handlers are keeping up. A handler that's stuck, or whose worker died in a panic, stops finishing the
+// packets it has been given, and the kernel drops the packets of the tracked devices once the queue is full.
+func (f *NFQueueFilter) Stalled() (time.Duration, string) {
+	now := time.Now()
+	var longest time.Duration
+	var reason string
+	for _, s := range f.stats {
+		if d := s.stalled(now); d > longest {
+			longest = d
+			reason = fmt.Sprintf("queue %v (%v) has had %v packets waiting for %v without a verdict", s.queue, s.direction, s.pending.Load(), d.Truncate(time.Second))
+		}
+	}
+	return longest, reason
+}
+
+// PacketCount returns the total number of packets handled by all queues since startup.
+func (f *NFQueueFilter) PacketCount() uint64 {
+	var total uint64
+	for _, s := range f.stats {
+		total += s.count.Load()
+	}
+	return total
+}
+
+func toMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,128 @@
+package nfq
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/eventbus"
+	"relloyd/tubetimeout/models"
+)
+
+func TestQueueStats_Rates(t *testing.T) {
+	s := newQueueStats(1, models.Egress)
+
+	r := s.rates()
+	assert.Equal(t, uint16(1), r.Queue)
+	assert.Equal(t, models.Egress, r.Direction)
+	assert.Equal(t, float64(0), r.PerSecond, "expected no rate before the first tick")
+
+	s.count.Add(10)
+	s.tick()
+	s.count.Add(20)
+	s.tick()
+
+	r = s.rates()
+	assert.Equal(t, uint64(30), r.Total)
+	assert.Equal(t, float64(20), r.PerSecond)
+	assert.Equal(t, float64(15), r.PerSecondAvg1m)
+}
+
+func TestQueueStats_WindowWraps(t *testing.T) {
+	s := newQueueStats(0, models.Ingress)
+
+	for i := 0; i < statsWindowSize; i++ {
+		s.count.Add(1)
+		s.tick()
+	}
+	for i := 0; i < statsWindowSize/2; i++ {
+		s.count.Add(3)
+		s.tick()
+	}
+
+	r := s.rates()
+	assert.Equal(t, float64(3), r.PerSecond)
+	assert.Equal(t, float64(2), r.PerSecondAvg1m, "expected half the window at 1/s and half at 3/s")
+}
+
+func TestQueueStats_Stalled(t *testing.T) {
+	start := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	s := newQueueStats(100, models.Egress)
+	f := &NFQueueFilter{stats: []*queueStats{s}}
+	d, _ := f.Stalled()
+	assert.Zero(t, d, "expected no stall before the first check")
+
+	// Expect a handler with nothing to do not to be stalled, however long it's idle.
+	s.checkProgress(start)
+	s.checkProgress(start.Add(time.Minute))
+	assert.Zero(t, s.stalled(start.Add(time.Minute)))
+
+	// Expect packets waiting without any being finished to be a stall from the last check that had none waiting,
+	// until one is finished.
+	s.pending.Add(2)
+	s.checkProgress(start.Add(time.Minute + time.Second))
+	s.checkProgress(start.Add(time.Minute + 10*time.Second))
+	assert.Equal(t, 10*time.Second, s.stalled(start.Add(time.Minute+10*time.Second)))
+	s.finished()
+	s.checkProgress(start.Add(time.Minute + 11*time.Second))
+	assert.Zero(t, s.stalled(start.Add(time.Minute+11*time.Second)), "expected finishing a packet to be progress")
+	assert.Equal(t, int64(1), s.pending.Load())
+
+	s.progress = time.Now().Add(-30 * time.Second)
+	d, reason := f.Stalled()
+	assert.GreaterOrEqual(t, d, 30*time.Second)
+	assert.Contains(t, reason, "queue 100 (out) has had 1 packets waiting")
+}
+
+func TestNFQueueFilter_Health(t *testing.T) {
+	out, in := newQueueStats(100, models.Egress), newQueueStats(101, models.Ingress)
+	out.attached.Store(true)
+	in.attached.Store(true)
+	f := &NFQueueFilter{stats: []*queueStats{out, in}, backpressure: newBackpressure(zap.NewNop(), &config.FilterConfig{}, eventbus.NewBus()), decisions: newDecisions(false)}
+	assert.Equal(t, models.HealthOK, f.Health().Status)
+
+	f.decisions.record(true, "kids", decisionDrop)
+	h := f.Health()
+	assert.Equal(t, models.HealthOK, h.Status, "expected simulating not to degrade the health")
+	assert.Contains(t, h.Message, "simulating")
+
+	f.writeTimeout = 15 * time.Millisecond
+	for i := 0; i < 100; i++ {
+		out.latency.observe(20 * time.Millisecond)
+	}
+	out.latency.update()
+	h = f.Health()
+	assert.Equal(t, models.HealthDegraded, h.Status, "expected slow verdicts to degrade the health")
+	assert.Contains(t, h.Message, "latency")
+
+	in.attached.Store(false)
+	h = f.Health()
+	assert.Equal(t, models.HealthUnhealthy, h.Status)
+	assert.Contains(t, h.Message, "queue 101")
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	h := newLatencyHistogram()
+	for i := 0; i < 98; i++ {
+		h.observe(200 * time.Microsecond)
+	}
+	h.observe(3 * time.Millisecond)
+	h.observe(2 * time.Second)
+
+	count, p99 := h.update()
+	assert.Equal(t, uint64(100), count)
+	assert.Equal(t, 5*time.Millisecond, p99, "expected the upper bound of the 99th verdict's bucket")
+	p50, _ := h.percentiles()
+	assert.Equal(t, 250*time.Microsecond, p50)
+
+	l := h.snapshot(100, models.Egress)
+	assert.Equal(t, uint64(100), l.Count)
+	assert.Equal(t, uint64(99), l.Buckets[len(l.Buckets)-1].Count, "expected the slowest verdict only in the +Inf count")
+	assert.Equal(t, 98*200*time.Microsecond+3*time.Millisecond+2*time.Second, l.Sum)
+
+	count, p99 = h.update()
+	assert.Equal(t, uint64(0), count, "expected percentiles to cover the latest window only")
+	assert.Equal(t, time.Duration(0), p99)
+}
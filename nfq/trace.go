@@ -0,0 +1,175 @@
+package nfq
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+const traceFilePrefix = "trace-"
+
+// deviceTrace holds the decisions made for a device's packets until the trace ends.
+type deviceTrace struct {
+	enabled bool
+	since   time.Time
+	until   time.Time
+	entries []models.TraceEntry
+	skipped int
+	max     int
+	file    string
+	timer   *time.Timer
+}
+
+// trace records every verdict decision made for the devices it's started for, with what each decision was based on,
+// so that one misbehaving device can be diagnosed without turning on debug logging for all of them. Each trace stops
+// by itself after the time it was started for.
+type trace struct {
+	cfg    *config.FilterConfig
+	logger *zap.Logger
+	active atomic.Int32 // active is the number of devices being traced, checked for each packet before looking up its MAC.
+	mu     sync.Mutex
+	macs   map[models.MAC]*deviceTrace
+}
+
+func newTrace(cfg *config.FilterConfig, logger *zap.Logger) *trace {
+	return &trace{cfg: cfg, logger: logger, macs: make(map[models.MAC]*deviceTrace)}
+}
+
+// enabled returns true if any device is being traced.
+func (t *trace) enabled() bool {
+	return t.active.Load() > 0
+}
+
+// record adds the decision to the device's trace if it's running.
+func (t *trace) record(mac models.MAC, e models.TraceEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.macs[mac]
+	if !ok || !d.enabled || !e.Time.Before(d.until) {
+		return
+	}
+	if len(d.entries) >= d.max {
+		d.skipped++
+		return
+	}
+	d.entries = append(d.entries, e)
+}
+
+// StartTrace starts tracing the device's decisions for the duration, which must be positive and no more than
+// FilterConfig.TraceMaxDuration, or an error wrapping models.ErrInvalidTrace is returned. Starting clears any earlier
+// trace of the device. When it ends, the trace is written to a JSON file in the app's home directory, which stays
+// available to download.
+func (t *trace) StartTrace(mac models.MAC, duration time.Duration) (models.TraceState, error) {
+	if duration <= 0 || duration > t.cfg.TraceMaxDuration {
+		return models.TraceState{}, fmt.Errorf("%w: must be more than 0 and at most %v", models.ErrInvalidTrace, t.cfg.TraceMaxDuration)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.macs[mac]
+	if ok && d.enabled { // if the device is already being traced, restart it...
+		d.timer.Stop()
+	} else {
+		t.active.Add(1)
+	}
+	now := nowFunc()
+	d = &deviceTrace{enabled: true, since: now, until: now.Add(duration), max: max(t.cfg.TraceMaxEntries, 1)}
+	d.timer = time.AfterFunc(duration, func() { t.expire(mac, d) })
+	t.macs[mac] = d
+	return traceState(mac, d), nil
+}
+
+// StopTrace stops tracing the device early and writes the decisions traced to a JSON file in the app's home directory.
+func (t *trace) StopTrace(mac models.MAC) (models.TraceState, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.macs[mac]
+	if !ok {
+		return models.TraceState{}, models.ErrTraceNotFound
+	}
+	return t.stop(mac, d)
+}
+
+// expire stops the trace d once its time is up, unless it's already been stopped or replaced by a new trace.
+func (t *trace) expire(mac models.MAC, d *deviceTrace) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.macs[mac] != d {
+		return
+	}
+	if _, err := t.stop(mac, d); err != nil {
+		t.logger.Error("Error stopping trace", zap.String("mac", string(mac)), zap.Error(err))
+	}
+}
+
+// stop stops the trace d and writes its file. The caller must hold t.mu.
+func (t *trace) stop(mac models.MAC, d *deviceTrace) (models.TraceState, error) {
+	if !d.enabled { // if there's nothing to stop...
+		return traceState(mac, d), nil
+	}
+	d.enabled = false
+	d.timer.Stop()
+	t.active.Add(-1)
+	path, err := config.DefaultStore.Path(traceFilePrefix + string(mac) + ".json")
+	if err != nil {
+		return traceState(mac, d), fmt.Errorf("failed to get trace file path: %w", err)
+	}
+	var b strings.Builder
+	if err = writeTrace(&b, d.entries); err == nil {
+		err = config.DefaultStore.WriteFile(path, b.String())
+	}
+	if err != nil {
+		return traceState(mac, d), fmt.Errorf("failed to write trace file: %w", err)
+	}
+	d.file = path
+	return traceState(mac, d), nil
+}
+
+// Traces returns the state of the trace of each device that has one, running or stopped.
+func (t *trace) Traces() []models.TraceState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	retval := make([]models.TraceState, 0, len(t.macs))
+	for mac, d := range t.macs {
+		retval = append(retval, traceState(mac, d))
+	}
+	slices.SortFunc(retval, func(a, b models.TraceState) int { return strings.Compare(string(a.MAC), string(b.MAC)) })
+	return retval
+}
+
+// WriteTrace writes the decisions traced for the device so far as a JSON array, oldest first.
+func (t *trace) WriteTrace(w io.Writer, mac models.MAC) error {
+	t.mu.Lock()
+	d, ok := t.macs[mac]
+	var entries []models.TraceEntry
+	if ok {
+		entries = slices.Clone(d.entries)
+	}
+	t.mu.Unlock()
+	if !ok {
+		return models.ErrTraceNotFound
+	}
+	return writeTrace(w, entries)
+}
+
+func traceState(mac models.MAC, d *deviceTrace) models.TraceState {
+	return models.TraceState{MAC: mac, Enabled: d.enabled, Since: d.since, Until: d.until, Entries: len(d.entries), Skipped: d.skipped, File: d.file}
+}
+
+// writeTrace writes the entries as an indented JSON array, so that the file is readable as it is.
+func writeTrace(w io.Writer, entries []models.TraceEntry) error {
+	if entries == nil {
+		entries = []models.TraceEntry{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
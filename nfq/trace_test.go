@@ -0,0 +1,82 @@
+package nfq
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+func TestTrace(t *testing.T) {
+	dir := t.TempDir()
+	orig := config.DefaultStore
+	defer func() { config.DefaultStore = orig }()
+	config.DefaultStore = config.NewStore(dir)
+
+	tr := newTrace(&config.FilterConfig{TraceMaxEntries: 2, TraceMaxDuration: time.Minute}, zap.NewNop())
+	mac := models.MAC("AA-BB-CC-DD-EE-FF")
+	_, err := tr.StartTrace(mac, 2*time.Minute)
+	assert.ErrorIs(t, err, models.ErrInvalidTrace, "expected traces to be limited to TraceMaxDuration")
+	assert.False(t, tr.enabled())
+
+	state, err := tr.StartTrace(mac, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, tr.enabled())
+	assert.Equal(t, state.Since.Add(time.Minute), state.Until)
+
+	now := time.Now()
+	tr.record(mac, models.TraceEntry{Time: now, Group: "kids", Decision: decisionAccept})
+	tr.record(mac, models.TraceEntry{Time: now, Group: "kids", Decision: decisionDrop, Exceeded: true})
+	tr.record(mac, models.TraceEntry{Time: now, Group: "kids", Decision: decisionDrop})                 // expect the trace to be full.
+	tr.record(mac, models.TraceEntry{Time: state.Until, Group: "kids", Decision: decisionDrop})         // expect packets after the trace to be ignored.
+	tr.record("11-22-33-44-55-66", models.TraceEntry{Time: now, Group: "kids", Decision: decisionDrop}) // expect other devices to be ignored.
+
+	state, err = tr.StopTrace(mac)
+	assert.NoError(t, err)
+	assert.False(t, tr.enabled())
+	assert.Equal(t, 2, state.Entries)
+	assert.Equal(t, 1, state.Skipped, "expected decisions after the trace was full to be counted")
+	assert.Equal(t, filepath.Join(dir, "trace-AA-BB-CC-DD-EE-FF.json"), state.File)
+
+	data, err := os.ReadFile(state.File)
+	assert.NoError(t, err)
+	var entries []models.TraceEntry
+	assert.NoError(t, json.Unmarshal(data, &entries))
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, decisionAccept, entries[0].Decision, "expected the oldest decision first")
+		assert.True(t, entries[1].Exceeded)
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, tr.WriteTrace(&buf, mac), "expected a stopped trace to stay available")
+	assert.Equal(t, data, buf.Bytes())
+	assert.ErrorIs(t, tr.WriteTrace(&buf, "11-22-33-44-55-66"), models.ErrTraceNotFound)
+	_, err = tr.StopTrace("11-22-33-44-55-66")
+	assert.ErrorIs(t, err, models.ErrTraceNotFound)
+	assert.Equal(t, []models.TraceState{state}, tr.Traces())
+}
+
+func TestTrace_Expires(t *testing.T) {
+	dir := t.TempDir()
+	orig := config.DefaultStore
+	defer func() { config.DefaultStore = orig }()
+	config.DefaultStore = config.NewStore(dir)
+
+	tr := newTrace(&config.FilterConfig{TraceMaxEntries: 10, TraceMaxDuration: time.Minute}, zap.NewNop())
+	mac := models.MAC("AA-BB-CC-DD-EE-FF")
+	_, err := tr.StartTrace(mac, time.Minute)
+	assert.NoError(t, err)
+	_, err = tr.StartTrace(mac, 20*time.Millisecond) // expect restarting to replace the first trace and its timer.
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return tr.Traces()[0].File != "" }, time.Second, 5*time.Millisecond, "expected the trace to stop by itself")
+	assert.False(t, tr.enabled())
+	assert.FileExists(t, filepath.Join(dir, "trace-AA-BB-CC-DD-EE-FF.json"))
+	assert.Len(t, tr.Traces(), 1)
+}
@@ -0,0 +1,93 @@
+package nfq
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// packet holds the parts of a queued packet needed to give it a verdict. They're copied out of the netlink message
+// since its buffer is reused once the callback returns.
+type packet struct {
+	id       uint32
+	pips     packetIPs
+	length   int
+	protocol uint8
+	port     uint16    // port is the TCP or UDP destination port, or 0 for other protocols.
+	received time.Time // received is when the packet was read from the queue, for measuring verdict latency.
+	header   []byte    // header is a copy of the start of the packet while captures are running, or nil.
+	sni      string    // sni is the server name if the packet starts a TLS connection, else empty.
+}
+
+func newPacket(id uint32, pips packetIPs, length int, protocol uint8, port uint16, received time.Time, header []byte, sni string) packet {
+	return packet{
+		id:       id,
+		pips:     packetIPs{src: append(net.IP(nil), pips.src...), dst: append(net.IP(nil), pips.dst...)},
+		length:   length,
+		protocol: protocol,
+		port:     port,
+		received: received,
+		header:   header,
+		sni:      sni,
+	}
+}
+
+// workerPool hands packets from a queue's netlink callback to a fixed number of workers so that the work of counting
+// packets and setting verdicts is spread across CPUs. Packets are sharded by their IPs, so the packets of a flow are
+// handled in order by the same worker.
+type workerPool struct {
+	workers []chan packet
+}
+
+// newWorkerPool starts n workers that call fnHandle for each packet until ctx is done. Each worker buffers up to
+// queueLen packets before submit blocks.
+func newWorkerPool(ctx context.Context, logger *zap.Logger, n, queueLen int, fnHandle func(p packet), fnRecover func(logger *zap.Logger)) *workerPool {
+	wp := &workerPool{workers: make([]chan packet, n)}
+	for i := range wp.workers {
+		c := make(chan packet, queueLen)
+		wp.workers[i] = c
+		go func() {
+			defer fnRecover(logger)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case p := <-c:
+					fnHandle(p)
+				}
+			}
+		}()
+	}
+	return wp
+}
+
+// submit queues the packet for its worker, blocking while the worker is busy so that the kernel queue takes the
+// backlog. It returns false if ctx is done before the packet is queued.
+func (wp *workerPool) submit(ctx context.Context, p packet) bool {
+	select {
+	case wp.workers[wp.shard(p.pips)] <- p:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// backlog returns the number of packets waiting for the workers and the most that can wait before submit blocks.
+func (wp *workerPool) backlog() (queued, capacity int) {
+	for _, c := range wp.workers {
+		queued += len(c)
+		capacity += cap(c)
+	}
+	return queued, capacity
+}
+
+// shard returns the index of the worker for packets between the given IPs.
+func (wp *workerPool) shard(pips packetIPs) int {
+	h := fnv.New32a()
+	_, _ = h.Write(pips.src)
+	_, _ = h.Write(pips.dst)
+	return int(h.Sum32() % uint32(len(wp.workers)))
+}
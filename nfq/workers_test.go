@@ -0,0 +1,89 @@
+package nfq
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestWorkerPool_KeepsFlowsInOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var mu sync.Mutex
+	got := make(map[string][]uint32)
+	var wg sync.WaitGroup
+	wp := newWorkerPool(ctx, zap.NewNop(), 4, 1, func(p packet) {
+		defer wg.Done()
+		mu.Lock()
+		defer mu.Unlock()
+		got[p.pips.src.String()] = append(got[p.pips.src.String()], p.id)
+	}, func(*zap.Logger) {})
+
+	srcs := []net.IP{net.IPv4(192, 168, 1, 1).To4(), net.IPv4(192, 168, 1, 2).To4(), net.IPv4(192, 168, 1, 3).To4()}
+	dst := net.IPv4(10, 0, 0, 1).To4()
+	for id := uint32(0); id < 300; id++ {
+		wg.Add(1)
+		assert.True(t, wp.submit(ctx, newPacket(id, packetIPs{src: srcs[id%3], dst: dst}, 60, 6, 0, time.Time{}, nil, "")))
+	}
+	wg.Wait()
+
+	for i, src := range srcs {
+		ids := got[src.String()]
+		assert.Len(t, ids, 100)
+		for j, id := range ids {
+			assert.Equal(t, uint32(i+3*j), id, "expected packets of a flow to be handled in the order they arrived")
+		}
+	}
+}
+
+func TestWorkerPool_SlowFlowDoesNotBlockOthers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	slow := packetIPs{src: net.IPv4(192, 168, 1, 1).To4(), dst: net.IPv4(10, 0, 0, 1).To4()}
+	fast := packetIPs{src: net.IPv4(192, 168, 1, 2).To4(), dst: net.IPv4(10, 0, 0, 1).To4()}
+	release := make(chan struct{})
+	handled := make(chan uint32, 1)
+	wp := newWorkerPool(ctx, zap.NewNop(), 2, 0, func(p packet) {
+		if p.pips.src.Equal(slow.src) {
+			<-release
+			return
+		}
+		handled <- p.id
+	}, func(*zap.Logger) {})
+	defer close(release)
+	for wp.shard(fast) == wp.shard(slow) { // make sure the flows land on different workers...
+		fast.src[3]++
+	}
+
+	assert.True(t, wp.submit(ctx, newPacket(1, slow, 60, 6, 0, time.Time{}, nil, "")))
+	assert.True(t, wp.submit(ctx, newPacket(2, fast, 60, 6, 0, time.Time{}, nil, "")))
+	select {
+	case id := <-handled:
+		assert.Equal(t, uint32(2), id)
+	case <-time.After(time.Second):
+		t.Fatal("expected the fast flow to be handled while the slow one is held")
+	}
+}
+
+func TestWorkerPool_SubmitReturnsOnceDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	wp := newWorkerPool(ctx, zap.NewNop(), 2, 0, func(packet) {}, func(*zap.Logger) {})
+	cancel()
+	time.Sleep(10 * time.Millisecond) // let the workers stop.
+	assert.False(t, wp.submit(ctx, newPacket(1, packetIPs{src: net.IPv4(192, 168, 1, 1).To4(), dst: net.IPv4(10, 0, 0, 1).To4()}, 60, 6, 0, time.Time{}, nil, "")))
+}
+
+func TestNewPacket_CopiesIPs(t *testing.T) {
+	buf := []byte{192, 168, 1, 1, 10, 0, 0, 1}
+	p := newPacket(1, packetIPs{src: buf[0:4], dst: buf[4:8]}, 60, 6, 0, time.Time{}, nil, "")
+	buf[0], buf[4] = 0, 0
+	assert.Equal(t, "192.168.1.1", p.pips.src.String())
+	assert.Equal(t, "10.0.0.1", p.pips.dst.String())
+}
@@ -0,0 +1,193 @@
+package nft
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"relloyd/tubetimeout/models"
+)
+
+var chainHookNames = map[nftables.ChainHook]string{
+	*nftables.ChainHookPrerouting:  "prerouting",
+	*nftables.ChainHookInput:       "input",
+	*nftables.ChainHookForward:     "forward",
+	*nftables.ChainHookOutput:      "output",
+	*nftables.ChainHookPostrouting: "postrouting",
+}
+
+// Snapshot returns the chains, rules and set elements in the table as the kernel has them, so that it's possible to
+// check whether an IP made it into a set without running "nft list ruleset" on the gateway.
+func (q *Rules) Snapshot() (models.NFTSnapshot, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	snap := models.NFTSnapshot{Table: q.tableName, Family: tableFamilyNames[q.table.Family], Chains: []models.NFTChain{}, Sets: []models.NFTSet{}}
+	chains, err := q.conn.ListChainsOfTableFamily(q.table.Family)
+	if err != nil {
+		return snap, fmt.Errorf("failed to list chains: %w", err)
+	}
+	for _, c := range chains {
+		if c.Table == nil || c.Table.Name != q.tableName {
+			continue
+		}
+		rules, err := q.conn.GetRules(q.table, c)
+		if err != nil {
+			return snap, fmt.Errorf("failed to get rules for chain %q: %w", c.Name, err)
+		}
+		snap.Chains = append(snap.Chains, snapshotChain(c, rules))
+	}
+
+	sets, err := q.conn.GetSets(q.table)
+	if err != nil {
+		return snap, fmt.Errorf("failed to get sets: %w", err)
+	}
+	for _, s := range sets {
+		if s.Anonymous {
+			continue
+		}
+		elements, err := q.conn.GetSetElements(s)
+		if err != nil {
+			return snap, fmt.Errorf("failed to get elements of set %q: %w", s.Name, err)
+		}
+		snap.Sets = append(snap.Sets, snapshotSet(s, elements))
+	}
+	slices.SortFunc(snap.Sets, func(a, b models.NFTSet) int { return strings.Compare(a.Name, b.Name) })
+	return snap, nil
+}
+
+func snapshotChain(c *nftables.Chain, rules []*nftables.Rule) models.NFTChain {
+	chain := models.NFTChain{Name: c.Name, Type: string(c.Type), Rules: []models.NFTRule{}}
+	if c.Hooknum != nil {
+		chain.Hook = chainHookNames[*c.Hooknum]
+	}
+	if c.Priority != nil {
+		chain.Priority = int32(*c.Priority)
+	}
+	for _, r := range rules {
+		rule := models.NFTRule{Handle: r.Handle, Exprs: make([]string, 0, len(r.Exprs))}
+		for _, e := range r.Exprs {
+			rule.Exprs = append(rule.Exprs, describeExpr(e))
+		}
+		chain.Rules = append(chain.Rules, rule)
+	}
+	return chain
+}
+
+// snapshotSet returns the elements of s in order, rendered according to the set's key type.
+func snapshotSet(s *nftables.Set, elements []nftables.SetElement) models.NFTSet {
+	set := models.NFTSet{Name: s.Name, KeyType: s.KeyType.Name, Elements: make([]string, 0, len(elements))}
+	for _, e := range elements {
+		if e.IntervalEnd {
+			continue
+		}
+		set.Elements = append(set.Elements, describeKey(s.KeyType, e.Key))
+	}
+	slices.Sort(set.Elements)
+	return set
+}
+
+// describeKey renders IP addresses, ports and protocol numbers as nft would, and anything else as hex.
+func describeKey(keyType nftables.SetDatatype, key []byte) string {
+	switch {
+	case keyType.Name == nftables.TypeIPAddr.Name && len(key) == net.IPv4len,
+		keyType.Name == nftables.TypeIP6Addr.Name && len(key) == net.IPv6len:
+		return net.IP(key).String()
+	case keyType.Name == nftables.TypeInetService.Name && len(key) == 2:
+		return fmt.Sprint(binary.BigEndian.Uint16(key))
+	case keyType.Name == nftables.TypeInetProto.Name && len(key) == 1:
+		return fmt.Sprint(key[0])
+	}
+	return hex.EncodeToString(key)
+}
+
+// describeExpr returns a short description of the expressions used by the rules, falling back to the Go value.
+func describeExpr(e expr.Any) string {
+	switch e := e.(type) {
+	case *expr.Payload:
+		base := map[expr.PayloadBase]string{
+			expr.PayloadBaseLLHeader:        "link",
+			expr.PayloadBaseNetworkHeader:   "network",
+			expr.PayloadBaseTransportHeader: "transport",
+		}[e.Base]
+		return fmt.Sprintf("payload load %vb @ %v header + %v => reg %v", e.Len, base, e.Offset, e.DestRegister)
+	case *expr.Meta:
+		return fmt.Sprintf("meta load %v => reg %v", metaKeyName(e.Key), e.Register)
+	case *expr.Cmp:
+		return fmt.Sprintf("cmp %v reg %v 0x%v", cmpOpName(e.Op), e.Register, hex.EncodeToString(e.Data))
+	case *expr.Range:
+		return fmt.Sprintf("range %v reg %v 0x%v 0x%v", cmpOpName(e.Op), e.Register, hex.EncodeToString(e.FromData), hex.EncodeToString(e.ToData))
+	case *expr.Lookup:
+		if e.Invert {
+			return fmt.Sprintf("lookup reg %v not in set %v", e.SourceRegister, e.SetName)
+		}
+		return fmt.Sprintf("lookup reg %v in set %v", e.SourceRegister, e.SetName)
+	case *expr.Numgen:
+		return fmt.Sprintf("numgen reg %v = random mod %v", e.Register, e.Modulus)
+	case *expr.Immediate:
+		return fmt.Sprintf("immediate reg %v 0x%v", e.Register, hex.EncodeToString(e.Data))
+	case *expr.Queue:
+		if e.Flag&expr.QueueFlagBypass != 0 {
+			return fmt.Sprintf("queue num %v bypass", e.Num)
+		}
+		return fmt.Sprintf("queue num %v", e.Num)
+	case *expr.Counter:
+		return fmt.Sprintf("counter packets %v bytes %v", e.Packets, e.Bytes)
+	case *expr.Masq:
+		return "masq"
+	case *expr.Verdict:
+		switch e.Kind {
+		case expr.VerdictAccept:
+			return "accept"
+		case expr.VerdictDrop:
+			return "drop"
+		case expr.VerdictReturn:
+			return "return"
+		case expr.VerdictJump:
+			return "jump " + e.Chain
+		case expr.VerdictGoto:
+			return "goto " + e.Chain
+		}
+		return fmt.Sprintf("verdict %v", e.Kind)
+	}
+	return strings.TrimPrefix(fmt.Sprintf("%T %+v", e, e), "*expr.")
+}
+
+func metaKeyName(k expr.MetaKey) string {
+	switch k {
+	case expr.MetaKeyL4PROTO:
+		return "l4proto"
+	case expr.MetaKeyNFPROTO:
+		return "nfproto"
+	case expr.MetaKeyMARK:
+		return "mark"
+	case expr.MetaKeyOIF:
+		return "oif"
+	case expr.MetaKeyIIF:
+		return "iif"
+	}
+	return fmt.Sprintf("key %v", k)
+}
+
+func cmpOpName(op expr.CmpOp) string {
+	switch op {
+	case expr.CmpOpEq:
+		return "eq"
+	case expr.CmpOpNeq:
+		return "neq"
+	case expr.CmpOpLt:
+		return "lt"
+	case expr.CmpOpLte:
+		return "lte"
+	case expr.CmpOpGt:
+		return "gt"
+	case expr.CmpOpGte:
+		return "gte"
+	}
+	return fmt.Sprintf("op %v", op)
+}
@@ -0,0 +1,45 @@
+package nft
+
+import (
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/models"
+)
+
+func Test_snapshotSet(t *testing.T) {
+	ips := &nftables.Set{Name: "local_ip_set", KeyType: nftables.TypeIPAddr}
+	got := snapshotSet(ips, []nftables.SetElement{{Key: []byte{192, 168, 1, 20}}, {Key: []byte{192, 168, 1, 10}}})
+	assert.Equal(t, models.NFTSet{Name: "local_ip_set", KeyType: "ipv4_addr", Elements: []string{"192.168.1.10", "192.168.1.20"}}, got)
+
+	ports := &nftables.Set{Name: "udp_ports", KeyType: nftables.TypeInetService}
+	got = snapshotSet(ports, []nftables.SetElement{{Key: []byte{0x01, 0xbb}}, {Key: []byte{0x01, 0xbc}, IntervalEnd: true}})
+	assert.Equal(t, []string{"443"}, got.Elements, "expected interval ends to be left out")
+
+	empty := snapshotSet(&nftables.Set{Name: "remote_ip_set", KeyType: nftables.TypeIPAddr}, nil)
+	assert.NotNil(t, empty.Elements, "expected an empty set to have an empty list of elements")
+}
+
+func Test_snapshotChain(t *testing.T) {
+	c := &nftables.Chain{Name: "filter", Type: nftables.ChainTypeFilter, Hooknum: nftables.ChainHookForward, Priority: nftables.ChainPriorityFilter}
+	rules := []*nftables.Rule{{Handle: 4, Exprs: []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4},
+		&expr.Lookup{SourceRegister: 1, SetName: "local_ip_set"},
+		&expr.Queue{Num: 2000, Flag: expr.QueueFlagBypass},
+	}}}
+
+	got := snapshotChain(c, rules)
+	assert.Equal(t, "forward", got.Hook)
+	if assert.Len(t, got.Rules, 1) {
+		assert.Equal(t, uint64(4), got.Rules[0].Handle)
+		assert.Equal(t, []string{
+			"payload load 4b @ network header + 12 => reg 1",
+			"lookup reg 1 in set local_ip_set",
+			"queue num 2000 bypass",
+		}, got.Rules[0].Exprs)
+	}
+	assert.Equal(t, "meta load nfproto => reg 1", describeExpr(&expr.Meta{Key: expr.MetaKeyNFPROTO, Register: 1}))
+	assert.Contains(t, describeExpr(&expr.Ct{Key: expr.CtKeySTATE}), "Ct", "expected other expressions to fall back to their type")
+}
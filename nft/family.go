@@ -0,0 +1,162 @@
+package nft
+
+import (
+	"fmt"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	tableFamilyAuto = "auto"
+	tableFamilyInet = "inet"
+	tableFamilyIP   = "ip"
+)
+
+// tableFamilyNames are the names of the table families for the logs and the NFT snapshot.
+var tableFamilyNames = map[nftables.TableFamily]string{
+	nftables.TableFamilyINet: tableFamilyInet,
+	nftables.TableFamilyIPv4: tableFamilyIP,
+	nftables.TableFamilyIPv6: "ip6",
+}
+
+// addrField is an address in the network header.
+type addrField int
+
+const (
+	srcAddr addrField = iota
+	dstAddr
+)
+
+// addrFamily is where a layer 3 protocol keeps its addresses in the network header, so that the same rules can be
+// built for IPv4 and IPv6.
+type addrFamily struct {
+	nfproto     byte                 // nfproto is matched first in inet tables, which see the packets of both.
+	tableFamily nftables.TableFamily // tableFamily is the family of the table used instead when inet isn't supported.
+	srcOffset   uint32
+	dstOffset   uint32
+	addrLen     uint32
+	keyType     nftables.SetDatatype
+}
+
+var (
+	familyIPv4 = addrFamily{nfproto: unix.NFPROTO_IPV4, tableFamily: nftables.TableFamilyIPv4, srcOffset: 12, dstOffset: 16, addrLen: 4, keyType: nftables.TypeIPAddr}
+	familyIPv6 = addrFamily{nfproto: unix.NFPROTO_IPV6, tableFamily: nftables.TableFamilyIPv6, srcOffset: 8, dstOffset: 24, addrLen: 16, keyType: nftables.TypeIP6Addr}
+)
+
+func (f addrFamily) offset(field addrField) uint32 {
+	if field == srcAddr {
+		return f.srcOffset
+	}
+	return f.dstOffset
+}
+
+// matchFamily returns the expressions that limit a rule to packets of the family. Tables of the family's own
+// table family only see its packets, so they need none.
+func matchFamily(table *nftables.Table, f addrFamily) []expr.Any {
+	if table.Family != nftables.TableFamilyINet {
+		return nil
+	}
+	return []expr.Any{
+		&expr.Meta{
+			Key:      expr.MetaKeyNFPROTO,
+			Register: 1,
+		},
+		&expr.Cmp{
+			Op:       expr.CmpOpEq,
+			Register: 1,
+			Data:     []byte{f.nfproto},
+		},
+	}
+}
+
+// matchAddrSet returns the expressions that load the address into the register and look it up in the set.
+func matchAddrSet(f addrFamily, field addrField, register uint32, setName string) []expr.Any {
+	return []expr.Any{
+		&expr.Payload{
+			DestRegister: register,
+			Base:         expr.PayloadBaseNetworkHeader,
+			Offset:       f.offset(field),
+			Len:          f.addrLen,
+		},
+		&expr.Lookup{
+			SourceRegister: register,
+			SetName:        setName,
+		},
+	}
+}
+
+// matchL4Proto returns the expressions that load the transport protocol into the register. Unlike the protocol field
+// in the IPv4 header, it's also found after any IPv6 extension headers.
+func matchL4Proto(register uint32) []expr.Any {
+	return []expr.Any{
+		&expr.Meta{
+			Key:      expr.MetaKeyL4PROTO,
+			Register: register,
+		},
+	}
+}
+
+// exprs joins the groups of expressions into one rule.
+func exprs(groups ...[]expr.Any) []expr.Any {
+	var retval []expr.Any
+	for _, g := range groups {
+		retval = append(retval, g...)
+	}
+	return retval
+}
+
+// chooseTableFamily returns the family to create the table in: inet so that one table handles IPv4 and IPv6, unless
+// it's turned off by setting or the kernel can't add NAT chains to inet tables, which needs Linux 5.2. The table
+// then falls back to the ip family, leaving IPv6 to a table of the ip6 family.
+func chooseTableFamily(logger *zap.SugaredLogger, conn *nftables.Conn, setting, tableName string) (nftables.TableFamily, error) {
+	switch setting {
+	case tableFamilyInet:
+		return nftables.TableFamilyINet, nil
+	case tableFamilyIP:
+		return nftables.TableFamilyIPv4, nil
+	case tableFamilyAuto, "":
+	default:
+		return 0, fmt.Errorf("unknown nftables table family %q, expected %v, %v or %v", setting, tableFamilyAuto, tableFamilyInet, tableFamilyIP)
+	}
+
+	if tableExists(logger, conn, tableName, nftables.TableFamilyINet) { // if an earlier run found inet works...
+		return nftables.TableFamilyINet, nil
+	}
+	probe := &nftables.Table{Name: tableName + "-probe", Family: nftables.TableFamilyINet}
+	conn.AddTable(probe)
+	conn.AddChain(&nftables.Chain{
+		Name:     "probe",
+		Table:    probe,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookPostrouting,
+		Priority: nftables.ChainPriorityNATSource,
+	})
+	if err := conn.Flush(); err != nil { // the batch is rolled back, so there's nothing to delete.
+		logger.Infof("NFT inet tables aren't supported by the kernel, falling back to family ip: %v", err)
+		return nftables.TableFamilyIPv4, nil
+	}
+	conn.DelTable(probe)
+	if err := conn.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to delete nftables probe table: %w", err)
+	}
+	return nftables.TableFamilyINet, nil
+}
+
+// removeOtherFamilies deletes tables with our name in the families that aren't used, e.g. the ip table left by an
+// earlier version, so that their rules don't queue packets as well. This should be done before the table is installed.
+func (q *Rules) removeOtherFamilies() error {
+	for _, family := range []nftables.TableFamily{nftables.TableFamilyINet, nftables.TableFamilyIPv4} {
+		if family == q.family || !tableExists(q.logger, q.conn, q.tableName, family) {
+			continue
+		}
+		q.conn.DelTable(&nftables.Table{Name: q.tableName, Family: family})
+		if err := q.conn.Flush(); err != nil {
+			return fmt.Errorf("failed to delete nftables table %q of family %v: %w", q.tableName, tableFamilyNames[family], err)
+		}
+		q.logger.Infof("NFT table %q of family %v is no longer used and was deleted", q.tableName, tableFamilyNames[family])
+	}
+	return nil
+}
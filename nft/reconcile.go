@@ -0,0 +1,173 @@
+package nft
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/google/nftables"
+)
+
+// tableState is the chains and sets in the table, to compare those installed with those in the kernel.
+type tableState struct {
+	chains map[string]int // chains maps the name of each chain to its number of rules.
+	sets   []string       // sets are the names of the named sets.
+}
+
+// readTableState returns the chains and sets in the kernel's copy of the table, or nil if it doesn't exist.
+func (q *Rules) readTableState() (*tableState, error) {
+	tables, err := q.conn.ListTablesOfFamily(q.family)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	i := slices.IndexFunc(tables, func(t *nftables.Table) bool { return t.Name == q.tableName })
+	if i < 0 {
+		return nil, nil
+	}
+	table := tables[i]
+
+	state := &tableState{chains: make(map[string]int)}
+	chains, err := q.conn.ListChainsOfTableFamily(q.family)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chains: %w", err)
+	}
+	for _, c := range chains {
+		if c.Table == nil || c.Table.Name != q.tableName {
+			continue
+		}
+		rules, err := q.conn.GetRules(table, c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get rules for chain %q: %w", c.Name, err)
+		}
+		state.chains[c.Name] = len(rules)
+	}
+
+	sets, err := q.conn.GetSets(table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sets: %w", err)
+	}
+	for _, s := range sets {
+		if !s.Anonymous {
+			state.sets = append(state.sets, s.Name)
+		}
+	}
+	return state, nil
+}
+
+// useChain records the chain as one installed and, if got has it from an earlier run, removes its rules so that
+// they're added again rather than duplicated.
+func (q *Rules) useChain(chain *nftables.Chain, got *tableState) {
+	q.want.chains[chain.Name] = 0
+	if got == nil {
+		return
+	}
+	if _, ok := got.chains[chain.Name]; ok {
+		q.conn.FlushChain(chain)
+	}
+}
+
+// removeUnused deletes the chains and sets in got that weren't installed, e.g. the local device chains after
+// FILTER_LOCAL_DEVICE is turned off.
+func (q *Rules) removeUnused(got *tableState) {
+	for _, name := range slices.Sorted(maps.Keys(got.chains)) {
+		if _, ok := q.want.chains[name]; !ok {
+			chain := &nftables.Chain{Name: name, Table: q.table}
+			q.conn.FlushChain(chain)
+			q.conn.DelChain(chain)
+			q.logger.Infof("NFT chain %q is no longer used and will be deleted", name)
+		}
+	}
+	for _, name := range got.sets {
+		if !slices.Contains(q.want.sets, name) {
+			q.conn.DelSet(&nftables.Set{Name: name, Table: q.table})
+			q.logger.Infof("NFT set %q is no longer used and will be deleted", name)
+		}
+	}
+}
+
+// drift describes the first difference between the chains and sets installed and those in the kernel, or returns ""
+// if there isn't one. Extra chains, rules and sets added by others are left alone unless they change a rule count.
+func drift(tableName string, want tableState, got *tableState) string {
+	if got == nil {
+		return fmt.Sprintf("table %q is missing", tableName)
+	}
+	for _, name := range slices.Sorted(maps.Keys(want.chains)) {
+		n, ok := got.chains[name]
+		if !ok {
+			return fmt.Sprintf("chain %q is missing", name)
+		}
+		if n != want.chains[name] {
+			return fmt.Sprintf("chain %q has %v rules instead of %v", name, n, want.chains[name])
+		}
+	}
+	for _, name := range want.sets {
+		if !slices.Contains(got.sets, name) {
+			return fmt.Sprintf("set %q is missing", name)
+		}
+	}
+	return ""
+}
+
+// Reconcile repairs the table if its chains, rules or sets no longer match those installed, e.g. because
+// "nft flush ruleset" was run or another firewall tool replaced them, then fills the sets again.
+// It returns true if the table needed repairing.
+func (q *Rules) Reconcile() (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.removed { // if Clean has deleted the table on purpose...
+		return false, nil
+	}
+	got, err := q.readTableState()
+	if err != nil {
+		return false, fmt.Errorf("failed to read nftables table: %w", err)
+	}
+	reason := drift(q.tableName, q.want, got)
+	if reason == "" {
+		return false, nil
+	}
+
+	q.logger.Warnf("NFT table needs repairing: %v", reason)
+	if err = q.install(); err != nil {
+		return true, fmt.Errorf("failed to repair nftables table: %w", err)
+	}
+	q.repairs++
+	q.logUpdateError("repaired", q.updateIpSets())
+	if len(q.exemptIPs) > 0 { // if the exempt set needs filling again...
+		q.logUpdateError("exempt", q.updateExemptSet())
+	}
+	if q.killSwitch { // if the kill switch set needs filling again...
+		q.logUpdateError("kill switch", q.updateKilledSet())
+	}
+	if q.setBlocked != nil && len(q.blockedIPs) > 0 { // if the blocked set needs filling again...
+		q.logUpdateError("blocked", q.updateBlockedSet())
+	}
+	if q.setBypassBlocked != nil && len(q.bypassBlocked) > 0 { // if the bypass blocked set needs filling again...
+		q.logUpdateError("bypass blocked", q.updateBypassBlockedSet())
+	}
+	return true, nil
+}
+
+// StartReconciler calls Reconcile every interval until ctx is done. Zero or less disables it.
+func (q *Rules) StartReconciler(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if repaired, err := q.Reconcile(); err != nil {
+					q.logger.Errorf("NFT reconciliation failed: %v", err)
+				} else if repaired {
+					q.logger.Info("NFT table repaired")
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,59 @@
+package nft
+
+import (
+	"testing"
+
+	"github.com/google/nftables"
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+	"relloyd/tubetimeout/config"
+)
+
+func Test_drift(t *testing.T) {
+	want := tableState{chains: map[string]int{"filter": 6, "post-routing": 1}, sets: []string{"local_ip_set", "remote_ip_set"}}
+
+	assert.Empty(t, drift("tubetimeout", want, &tableState{chains: map[string]int{"filter": 6, "post-routing": 1, "other": 3}, sets: []string{"remote_ip_set", "local_ip_set", "other_set"}}),
+		"expected chains and sets added by others to be left alone")
+	assert.Equal(t, `table "tubetimeout" is missing`, drift("tubetimeout", want, nil))
+	assert.Equal(t, `chain "post-routing" is missing`, drift("tubetimeout", want, &tableState{chains: map[string]int{"filter": 6}}))
+	assert.Equal(t, `chain "filter" has 0 rules instead of 6`, drift("tubetimeout", want, &tableState{chains: map[string]int{"filter": 0, "post-routing": 1}}),
+		"expected a flushed chain to need repairing")
+	assert.Equal(t, `set "local_ip_set" is missing`, drift("tubetimeout", want, &tableState{chains: map[string]int{"filter": 6, "post-routing": 1}, sets: []string{"remote_ip_set"}}))
+}
+
+func Test_adoptTable(t *testing.T) {
+	var types []uint16
+	conn, err := nftables.New(nftables.WithTestDial(func(req []netlink.Message) ([]netlink.Message, error) {
+		var acks []netlink.Message
+		for _, msg := range req {
+			types = append(types, uint16(msg.Header.Type)&0xff)
+			if msg.Header.Flags&netlink.Acknowledge != 0 {
+				acks = append(acks, netlink.Message{Header: netlink.Header{Type: netlink.Error, Sequence: msg.Header.Sequence, PID: msg.Header.PID}, Data: make([]byte, 4)})
+			}
+		}
+		return acks, nil
+	}))
+	assert.NoError(t, err)
+
+	q := &Rules{logger: config.MustGetLogger(), conn: conn, table: &nftables.Table{Name: "tubetimeout", Family: nftables.TableFamilyIPv4}}
+	q.want = tableState{chains: make(map[string]int)}
+	got := &tableState{chains: map[string]int{"filter": 12, "local-output": 4}, sets: []string{"local_ip_set", "sampled_local_ip_set"}}
+
+	// An earlier run left the local device chain and the sampled set, which are no longer wanted.
+	q.useChain(&nftables.Chain{Name: "filter", Table: q.table}, got)
+	q.useChain(&nftables.Chain{Name: "post-routing", Table: q.table}, got)
+	assert.NoError(t, q.addSet(&nftables.Set{Name: "local_ip_set", Table: q.table, KeyType: nftables.TypeIPAddr}, nil))
+	q.removeUnused(got)
+	assert.NoError(t, conn.Flush())
+
+	assert.Equal(t, map[string]int{"filter": 0, "post-routing": 0}, q.want.chains)
+	assert.Equal(t, []uint16{
+		unix.NFNL_MSG_BATCH_BEGIN & 0xff,
+		unix.NFT_MSG_DELRULE, // flush the adopted filter chain, but not post-routing since it's new
+		unix.NFT_MSG_NEWSET,
+		unix.NFT_MSG_DELRULE, unix.NFT_MSG_DELCHAIN, // delete the local device chain
+		unix.NFT_MSG_DELSET, // delete the sampled set
+		unix.NFNL_MSG_BATCH_END & 0xff,
+	}, types)
+}
@@ -3,82 +3,214 @@ package nft
 import (
 	"errors"
 	"fmt"
-	"log"
+	"maps"
 	"net"
-	"os"
+	"slices"
 	"sync"
 
 	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
 	"github.com/google/nftables/expr"
 	"go.uber.org/zap"
 	"golang.org/x/sys/unix"
 	"relloyd/tubetimeout/config"
 	"relloyd/tubetimeout/models"
+	"relloyd/tubetimeout/privilege"
 )
 
 func init() {
-	if os.Geteuid() != 0 {
-		config.MustGetLogger().Fatalf("You must be root to run this program.")
+	if err := privilege.Detect().Require(privilege.CapNetAdmin); err != nil {
+		config.MustGetLogger().Fatalf("Unable to manage the NFT rules: %v", err)
 	}
 }
 
 var (
 	defaultTableName = "tubetimeout-table"
+
+	errLocalIPsNotReady  = errors.New("local IPs aren't ready")
+	errRemoteIPsNotReady = errors.New("remote IPs aren't ready")
+	errRemoved           = errors.New("the table has been removed")
+)
+
+const (
+	defaultFilterChainName      = "filter"
+	defaultNATChainName         = "post-routing"
+	defaultPreNATChainName      = "pre-routing"  // defaultPreNATChainName redirects plain HTTP from blocked devices to the block page.
+	defaultOutputChainName      = "local-output" // defaultOutputChainName filters traffic from the gateway's own apps.
+	defaultInputChainName       = "local-input"  // defaultInputChainName filters traffic to the gateway's own apps.
+	defaultDNSOutputChainName   = "dns-output"   // defaultDNSOutputChainName queues the gateway's own DNS answers for inspection.
+	defaultSrcIpSetName         = "local_ip_set"
+	defaultDestIpSetName        = "remote_ip_set"
+	defaultSampledSetName       = "sampled_local_ip_set"
+	defaultKilledSetName        = "killed_local_ip_set"
+	defaultBlockedSetName       = "blocked_local_ip_set"
+	defaultProtocolSetName      = "protocol_set"
+	defaultBypassIPSetName      = "bypass_ip_set"
+	defaultBypassPortSetName    = "bypass_port_set"
+	defaultBypassBlockedSetName = "bypass_blocked_local_ip_set"
+	defaultFailOpenSetName      = "fail_open_local_ip_set"
+	defaultExemptSetName        = "exempt_local_ip_set"
+	defaultLANSetName           = "lan_net_set"
+	defaultQueueNumDest         = uint16(100) // defaultQueueNumDest only used by unused code 🤣
 )
 
+// The FILTER_UDP_MODE values.
 const (
-	defaultFilterChainName = "filter"
-	defaultNATChainName    = "post-routing"
-	defaultSrcIpSetName    = "local_ip_set"
-	defaultDestIpSetName   = "remote_ip_set"
-	defaultProtocolSetName = "protocol_set"
-	defaultQueueNumDest    = uint16(100) // defaultQueueNumDest only used by unused code 🤣
+	udpModeQueue  = "queue"
+	udpModeDrop   = "drop"
+	udpModeAccept = "accept"
 )
 
+// backpressureFailOpen is the FILTER_BACKPRESSURE value that lets traffic bypass the queues while the filter is behind.
+const backpressureFailOpen = "failopen"
+
 type Rules struct {
-	logger        *zap.SugaredLogger
-	conn          *nftables.Conn
-	tableName     string
-	chainName     string
-	table         *nftables.Table
-	chain         *nftables.Chain
-	nameSetLocal  string
-	nameSetRemote string
-	setLocal      *nftables.Set
-	setRemote     *nftables.Set
-	setProto      *nftables.Set
-	remoteIPs     []nftables.SetElement
-	localIPs      []nftables.SetElement
-	mu            sync.Mutex
-}
-
-func NewNFTRules(logger *zap.SugaredLogger, cfg *config.FilterConfig) (*Rules, error) {
+	logger           *zap.SugaredLogger
+	conn             *nftables.Conn
+	tableName        string
+	chainName        string
+	family           nftables.TableFamily // family is the table's family, inet unless the kernel is too old for it.
+	table            *nftables.Table
+	chain            *nftables.Chain
+	nameSetLocal     string
+	nameSetRemote    string
+	setLocal         *nftables.Set
+	setRemote        *nftables.Set
+	setProto         *nftables.Set
+	setSampled       *nftables.Set     // setSampled is nil unless packet sampling is enabled.
+	localChains      []*nftables.Chain // localChains filter the gateway's own traffic, if enabled, with the same rules as chain.
+	setKilled        *nftables.Set     // setKilled holds the local IPs while the kill switch is on.
+	killSwitch       bool              // killSwitch is true while the kill switch is on, guarded by mu.
+	setFailOpen      *nftables.Set     // setFailOpen is nil unless the filter can fail open, and holds the local IPs while it has.
+	failOpen         bool              // failOpen is true while the tracked traffic bypasses the queues, guarded by mu.
+	setBlocked       *nftables.Set     // setBlocked is nil unless the block page is enabled.
+	blockedIPs       []nftables.SetElement
+	setBypassBlocked *nftables.Set // setBypassBlocked is nil unless bypass detection and blocking are enabled.
+	bypassBlocked    []nftables.SetElement
+	setExempt        *nftables.Set         // setExempt holds the IPs of the exempt devices, whose traffic is accepted first.
+	exemptions       []models.Exemption    // exemptions are the devices left alone, guarded by mu.
+	exemptIPs        []nftables.SetElement // exemptIPs are the IPv4 addresses of the exemptions, guarded by mu.
+	srcIpMACs        models.MapIpMACs      // srcIpMACs is the last MAC of each source IP, guarded by mu.
+	allSrcIpGroups   models.MapIpGroups    // allSrcIpGroups is the last source IP data with the exempt IPs, guarded by mu.
+	exceeded         map[models.Group]bool // exceeded are the groups over their thresholds, guarded by mu.
+	lanNets          []*net.IPNet          // lanNets are the networks whose traffic between each other is accepted without queuing it.
+	remoteIPs        []nftables.SetElement
+	localIPs         []nftables.SetElement
+	sampledIPs       []nftables.SetElement
+	sampler          models.PacketSampler
+	sampleRate       uint32
+	srcIpGroups      models.MapIpGroups // srcIpGroups is the last source IP data, used to find the IPs to sample.
+	sampled          map[models.Ip]bool // sampled are the local IPs that only have 1 in sampleRate packets queued.
+	installed        bool               // installed is true once the sets have been filled, guarded by mu.
+	cfg              *config.FilterConfig
+	want             tableState // want is the chains, rule counts and sets added by install, guarded by mu.
+	repairs          int        // repairs is the number of times Reconcile has repaired the table, guarded by mu.
+	removed          bool       // removed is true once Clean has deleted the table, so that it isn't put back, guarded by mu.
+	mu               sync.Mutex
+}
+
+// NewNFTRules creates the NFT rules. If cfg.SampleRate is more than 1, sampler decides which groups only need 1 in
+// SampleRate packets queued for accounting. sampler may be nil to queue every packet.
+func NewNFTRules(logger *zap.SugaredLogger, cfg *config.FilterConfig, sampler models.PacketSampler) (*Rules, error) {
+	return newNFTRules(logger, cfg, sampler, &nftables.Conn{})
+}
+
+// Available returns an error if the kernel doesn't support nftables, e.g. on older boxes that only have iptables.
+func Available() error {
+	if _, err := (&nftables.Conn{}).ListTables(); err != nil {
+		return fmt.Errorf("failed to list nftables: %w", err)
+	}
+	return nil
+}
+
+// newNFTRules creates the table, chains, sets and rules using conn.
+func newNFTRules(logger *zap.SugaredLogger, cfg *config.FilterConfig, sampler models.PacketSampler, conn *nftables.Conn) (*Rules, error) {
 	var err error
 	rules := &Rules{
 		logger:        logger,
-		conn:          &nftables.Conn{},
+		conn:          conn,
 		tableName:     defaultTableName,
 		chainName:     defaultFilterChainName,
 		nameSetLocal:  defaultSrcIpSetName,
 		nameSetRemote: defaultDestIpSetName,
 		localIPs:      make([]nftables.SetElement, 0),
 		remoteIPs:     make([]nftables.SetElement, 0),
+		sampled:       make(map[models.Ip]bool),
+		exceeded:      make(map[models.Group]bool),
+		cfg:           cfg,
+	}
+	if sampler != nil && cfg.SampleRate > 1 {
+		rules.sampler, rules.sampleRate = sampler, cfg.SampleRate
+	}
+
+	if err = checkUDPMode(cfg.UDPMode); err != nil {
+		return nil, err
+	}
+	if rules.lanNets, err = cfg.LANNets(); err != nil {
+		return nil, err
+	}
+	rules.family, err = chooseTableFamily(logger, conn, cfg.TableFamily, rules.tableName)
+	if err != nil {
+		return nil, err
 	}
+	logger.Infof("NFT table %q uses family %v", rules.tableName, tableFamilyNames[rules.family])
+	if err = rules.removeOtherFamilies(); err != nil {
+		return nil, err
+	}
+	if err = rules.install(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
 
-	rules.table, err = getOrCreateTable(rules.logger, rules.conn, rules.tableName)
+// install adds the table, chains, sets and rules. If the table is left from an earlier run, e.g. after a crash, it's
+// adopted: the rules in its chains are replaced rather than duplicated and the chains and sets that are no longer used
+// are deleted. The local and remote IP sets keep their contents so that filtering carries on until they're updated,
+// but the kill switch set is emptied since the switch starts off. This should be done under a mutex after startup.
+func (q *Rules) install() error {
+	got, err := q.readTableState()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create nftables table: %v", err)
+		return fmt.Errorf("failed to read nftables table: %w", err)
+	}
+	if got != nil {
+		q.logger.Infof("NFT table %q exists already and will be adopted", q.tableName)
+	}
+	q.want = tableState{chains: make(map[string]int)}
+
+	q.table, err = getOrCreateTable(q.logger, q.conn, q.tableName, q.family)
+	if err != nil {
+		return fmt.Errorf("failed to create nftables table: %v", err)
 	}
 
-	rules.chain, err = getOrCreateFilterChain(rules.logger, rules.conn, rules.table, rules.chainName)
+	q.chain, err = getOrCreateFilterChain(q.logger, q.conn, q.table, q.chainName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create nftables chain: %v", err)
+		return fmt.Errorf("failed to create nftables chain: %v", err)
+	}
+	q.useChain(q.chain, got)
+
+	q.localChains = nil
+	if q.cfg.LocalDevice { // if traffic from the gateway's own apps should be filtered too...
+		for _, c := range []struct {
+			name string
+			hook *nftables.ChainHook
+		}{
+			{defaultOutputChainName, nftables.ChainHookOutput},
+			{defaultInputChainName, nftables.ChainHookInput},
+		} {
+			chain, err := getOrCreateHookChain(q.logger, q.conn, q.table, c.name, c.hook)
+			if err != nil {
+				return fmt.Errorf("failed to create nftables %v chain: %v", c.name, err)
+			}
+			q.useChain(chain, got)
+			q.localChains = append(q.localChains, chain)
+		}
 	}
 
-	nat, err := getOrCreateNATPostRoutingChain(rules.logger, rules.conn, rules.table, defaultNATChainName)
+	nat, err := getOrCreateNATPostRoutingChain(q.logger, q.conn, q.table, defaultNATChainName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create nftables NAT chain: %v", err)
+		return fmt.Errorf("failed to create nftables NAT chain: %v", err)
 	}
+	q.useChain(nat, got)
 
 	// // Get the interface index for "wlan0"
 	// oif, err := net.InterfaceByName("wlan0") // TODO: make masquerading interface configurable
@@ -87,10 +219,11 @@ func NewNFTRules(logger *zap.SugaredLogger, cfg *config.FilterConfig) (*Rules, e
 	// }
 
 	// Add NAT in post routing chain, to rewrite source IP address. This should be masquerading.
-	rules.conn.AddRule(&nftables.Rule{
-		Table: rules.table,
+	// Only IPv4 is masqueraded, as it was before the table could be inet, since IPv6 devices have addresses of their own.
+	q.addRule(&nftables.Rule{
+		Table: q.table,
 		Chain: nat,
-		Exprs: []expr.Any{
+		Exprs: exprs(matchFamily(q.table, familyIPv4), []expr.Any{
 			// &expr.Meta{
 			// 	Key:      expr.MetaKeyOIF, // Match interface name
 			// 	Register: 2,
@@ -112,132 +245,205 @@ func NewNFTRules(logger *zap.SugaredLogger, cfg *config.FilterConfig) (*Rules, e
 			// 	Data:     []byte{1, 0, 0, 0}, // match the mark 1
 			// },
 			&expr.Masq{},
-		},
+		}),
 	})
 
 	// Create TCP/UDP set.
-	rules.setProto = &nftables.Set{
+	q.setProto = &nftables.Set{
 		Name:    defaultProtocolSetName,
-		Table:   rules.table,
+		Table:   q.table,
 		KeyType: nftables.TypeInetProto,
 	}
-	err = rules.conn.AddSet(rules.setProto, []nftables.SetElement{
+	err = q.addSet(q.setProto, []nftables.SetElement{
 		{Key: []byte{6}},  // TCP
 		{Key: []byte{17}}, // UDP
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create protocol set")
+		return fmt.Errorf("failed to create protocol set")
 	}
 
 	// Create local IP address set.
-	rules.setLocal = &nftables.Set{
-		Name:    rules.nameSetLocal,
-		Table:   rules.table,
+	q.setLocal = &nftables.Set{
+		Name:    q.nameSetLocal,
+		Table:   q.table,
 		KeyType: nftables.TypeIPAddr,
 		Dynamic: true,
 	}
-	err = rules.conn.AddSet(rules.setLocal, nil)
+	err = q.addSet(q.setLocal, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create local IP set")
+		return fmt.Errorf("failed to create local IP set")
 	}
 
 	// Create remote IP address set.
-	rules.setRemote = &nftables.Set{
-		Name:    rules.nameSetRemote,
-		Table:   rules.table,
+	q.setRemote = &nftables.Set{
+		Name:    q.nameSetRemote,
+		Table:   q.table,
 		KeyType: nftables.TypeIPAddr,
 		Dynamic: true,
 	}
-	err = rules.conn.AddSet(rules.setRemote, nil) // start with empty sets so we can update them later
+	err = q.addSet(q.setRemote, nil) // start with empty sets so we can update them later
 	if err != nil {
-		return nil, fmt.Errorf("failed to create remote IP set")
+		return fmt.Errorf("failed to create remote IP set")
 	}
 
-	rules.dropUDPFromToLocalIPs(cfg.OutboundQueueNumber, cfg.InboundQueueNumber) // drop UDP to/from the local IP set.
-
-	// Create NFTables rules for src-dest and dest-src combinations.
-	err = rules.addNFTablesRuleForSets(cfg.OutboundQueueNumber, rules.nameSetLocal, rules.nameSetRemote)
+	// Create the exempt set and rules that accept the traffic of the exempt devices ahead of all the others, so that
+	// it's never queued or dropped. Their IPs are also left out of the local IP set for the rules in the other chains.
+	q.setExempt = &nftables.Set{
+		Name:    defaultExemptSetName,
+		Table:   q.table,
+		KeyType: nftables.TypeIPAddr,
+		Dynamic: true,
+	}
+	err = q.addSet(q.setExempt, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create NFT rule for src-dest combination")
+		return fmt.Errorf("failed to create exempt IP set")
+	}
+	if got != nil && slices.Contains(got.sets, defaultExemptSetName) { // if the set may hold IPs from an earlier run...
+		q.conn.FlushSet(q.setExempt)
+	}
+	q.addExemptRule(srcAddr)
+	q.addExemptRule(dstAddr)
+
+	// Create the kill switch set and rules that drop everything to and from its IPs, ahead of the other q.
+	// They're only in the forward chain so the gateway's own apps and the web page still work.
+	q.setKilled = &nftables.Set{
+		Name:    defaultKilledSetName,
+		Table:   q.table,
+		KeyType: nftables.TypeIPAddr,
+		Dynamic: true,
 	}
-	err = rules.addNFTablesRuleForSets(cfg.InboundQueueNumber, rules.nameSetRemote, rules.nameSetLocal)
+	err = q.addSet(q.setKilled, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create NFT rule for dest-src combination")
+		return fmt.Errorf("failed to create kill switch IP set")
+	}
+	if got != nil && slices.Contains(got.sets, defaultKilledSetName) { // if the set may hold IPs from an earlier run...
+		q.conn.FlushSet(q.setKilled)
 	}
+	q.addKillSwitchRule(srcAddr)
+	q.addKillSwitchRule(dstAddr)
 
-	// Flush changes to the kernel.
-	if err = rules.conn.Flush(); err != nil {
-		return nil, fmt.Errorf("failed to flush nftables rules: %v", err)
+	// Maybe create the fail-open set and rules that accept the traffic of its IPs ahead of the rules that queue it, for
+	// while the packet handler is too far behind to keep up.
+	q.setFailOpen = nil
+	if q.cfg.Backpressure == backpressureFailOpen {
+		q.setFailOpen = &nftables.Set{
+			Name:    defaultFailOpenSetName,
+			Table:   q.table,
+			KeyType: nftables.TypeIPAddr,
+			Dynamic: true,
+		}
+		err = q.addSet(q.setFailOpen, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create fail-open IP set")
+		}
+		if got != nil && slices.Contains(got.sets, defaultFailOpenSetName) { // if the set may hold IPs from an earlier run...
+			q.conn.FlushSet(q.setFailOpen)
+		}
+		q.addFailOpenRule(srcAddr)
+		q.addFailOpenRule(dstAddr)
 	}
 
-	return rules, nil
-}
+	// Maybe queue the DNS answers to the local IPs so the IPs of the tracked domains are learned as they're handed out.
+	if q.cfg.DNSInspection {
+		if err = q.addDNSInspectionRules(got); err != nil {
+			return err
+		}
+	}
 
-// dropUDPPorts creates a rule to drop UDP packets for IPs in the source/local IP set.
-// TODO: test that UDP packets are dropped from local IPs.
-func (q *Rules) dropUDPPorts() {
-	// Define a set for UDP ports to match
-	udpPortSet := &nftables.Set{
-		Table:   q.table,
-		Name:    "udp_ports",
-		KeyType: nftables.TypeInetService, // Port number type
+	// Maybe accept the traffic between LAN addresses ahead of the rules that would queue it. This comes after the DNS
+	// answers so that those from resolvers on the LAN are still inspected.
+	if len(q.lanNets) > 0 {
+		if err = q.addLANRules(got); err != nil {
+			return err
+		}
 	}
-	err := q.conn.AddSet(udpPortSet, nil)
-	if err != nil {
-		q.logger.Fatalf("Failed to create set of UDP ports: %v", err)
+
+	// Maybe queue, or drop for the devices caught, the traffic to the ports and IPs used to get around the filter.
+	q.setBypassBlocked = nil
+	if q.cfg.BypassDetection {
+		if err = q.addBypassRules(got); err != nil {
+			return err
+		}
 	}
 
-	// Add elements to the set (UDP ports to block)
-	elements := []nftables.SetElement{
-		{Key: []byte{0x01, 0xf4}}, // Port 500 NAT-T
-		{Key: []byte{0x11, 0x94}}, // Port 4500 NAT-T
-		{Key: []byte{0x01, 0xBB}}, // Port 443
+	// Maybe create the blocked local IP set and a rule that redirects their plain HTTP to the block page.
+	q.setBlocked = nil
+	if q.cfg.BlockPagePort > 0 {
+		pre, err := getOrCreateNATPreRoutingChain(q.logger, q.conn, q.table, defaultPreNATChainName)
+		if err != nil {
+			return fmt.Errorf("failed to create nftables pre-routing NAT chain: %v", err)
+		}
+		q.useChain(pre, got)
+		q.setBlocked = &nftables.Set{
+			Name:    defaultBlockedSetName,
+			Table:   q.table,
+			KeyType: nftables.TypeIPAddr,
+			Dynamic: true,
+		}
+		err = q.addSet(q.setBlocked, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create blocked local IP set")
+		}
+		if got != nil && slices.Contains(got.sets, defaultBlockedSetName) { // if the set may hold IPs from an earlier run...
+			q.conn.FlushSet(q.setBlocked)
+		}
+		q.addBlockPageRule(pre, uint16(q.cfg.BlockPagePort))
 	}
-	if err := q.conn.SetAddElements(udpPortSet, elements); err != nil {
-		log.Fatalf("Failed to add elements to set: %v", err)
+
+	// Maybe create the sampled local IP set and rules that accept most of their packets before they can be queued.
+	// The sampled IPs stay in the local IP set so that 1 in sampleRate packets fall through to the queue q.
+	if q.sampler != nil {
+		q.setSampled = &nftables.Set{
+			Name:    defaultSampledSetName,
+			Table:   q.table,
+			KeyType: nftables.TypeIPAddr,
+			Dynamic: true,
+		}
+		err = q.addSet(q.setSampled, nil)
+		if err != nil {
+			return fmt.Errorf("failed to create sampled local IP set")
+		}
+		q.addNFTablesSamplingRuleForSets(defaultSampledSetName, q.nameSetRemote)
+		q.addNFTablesSamplingRuleForSets(q.nameSetRemote, defaultSampledSetName)
 	}
 
-	// Create a rule to match UDP packets with destination ports in the set
-	q.conn.AddRule(&nftables.Rule{
-		Table: q.table,
-		Chain: q.chain,
-		Exprs: []expr.Any{
-			// Match UDP protocol
-			&expr.Meta{
-				Key:      expr.MetaKeyL4PROTO,
-				Register: 1,
-			},
-			&expr.Cmp{
-				Op:       expr.CmpOpEq,
-				Register: 1,
-				Data:     []byte{unix.IPPROTO_UDP},
-			},
-			// Match destination port in set
-			&expr.Payload{
-				DestRegister: 2,
-				Base:         expr.PayloadBaseTransportHeader,
-				Offset:       2, // UDP header destination port offset
-				Len:          2, // Length of port
-			},
-			&expr.Lookup{
-				SourceRegister: 2,
-				SetName:        udpPortSet.Name,
-			},
-			// Drop the packet
-			&expr.Verdict{
-				Kind: expr.VerdictDrop,
-			},
-		},
-	})
+	q.addUDPPortRules() // queue or drop UDP to/from the local IP set.
+
+	// Create NFTables rules for src-dest and dest-src combinations.
+	err = q.addNFTablesRuleForSets(q.cfg.OutboundQueueNumber, q.nameSetLocal, q.nameSetRemote)
+	if err != nil {
+		return fmt.Errorf("failed to create NFT rule for src-dest combination")
+	}
+	err = q.addNFTablesRuleForSets(q.cfg.InboundQueueNumber, q.nameSetRemote, q.nameSetLocal)
+	if err != nil {
+		return fmt.Errorf("failed to create NFT rule for dest-src combination")
+	}
+
+	if got != nil {
+		q.removeUnused(got)
+	}
+
+	// Flush changes to the kernel.
+	if err = q.conn.Flush(); err != nil {
+		return fmt.Errorf("failed to flush nftables rules: %v", err)
+	}
+	return nil
 }
 
-func (q *Rules) dropUDPFromToLocalIPs(outboundQueueNumber uint16, inboundQueueNumber uint16) {
+// addUDPPortRules sends UDP between the local IPs and cfg.UDPPorts to the queues, or drops it, whatever the remote
+// IP, so that QUIC to IPs that haven't resolved yet is queued too. Outbound packets are matched on their destination
+// port and inbound packets on their source port.
+func (q *Rules) addUDPPortRules() {
+	if q.cfg.UDPMode == udpModeAccept || len(q.cfg.UDPPorts) == 0 { // if UDP should be left to the other rules...
+		return
+	}
 	data := []struct {
-		direction   uint32
+		field       addrField
+		portOffset  uint32
 		queueNumber uint16
 	}{
-		{12, inboundQueueNumber},  // 12 for source IP
-		{16, outboundQueueNumber}, // 16 for destination IP
+		{srcAddr, 2, q.cfg.OutboundQueueNumber}, // UDP header destination port offset
+		{dstAddr, 0, q.cfg.InboundQueueNumber},  // UDP header source port offset
 	}
 
 	// Define a set for UDP ports to match
@@ -246,92 +452,306 @@ func (q *Rules) dropUDPFromToLocalIPs(outboundQueueNumber uint16, inboundQueueNu
 		Name:    "udp_ports",
 		KeyType: nftables.TypeInetService, // Port number type
 	}
-	err := q.conn.AddSet(udpPortSet, nil)
+	err := q.addSet(udpPortSet, nil)
 	if err != nil {
 		q.logger.Fatalf("Failed to create set of UDP ports: %v", err)
 	}
-
-	// Add elements to the set (UDP ports to block)
-	elements := []nftables.SetElement{
-		{Key: []byte{0x01, 0xf4}}, // Port 500 NAT-T
-		{Key: []byte{0x11, 0x94}}, // Port 4500 NAT-T
-		{Key: []byte{0x01, 0xBB}}, // Port 443
+	elements := make([]nftables.SetElement, 0, len(q.cfg.UDPPorts))
+	for _, port := range q.cfg.UDPPorts {
+		elements = append(elements, nftables.SetElement{Key: binaryutil.BigEndian.PutUint16(port)})
 	}
 	if err := q.conn.SetAddElements(udpPortSet, elements); err != nil {
-		log.Fatalf("Failed to add elements to set: %v", err)
+		q.logger.Fatalf("Failed to add elements to set: %v", err)
 	}
 
-	// Drop UDP
 	for _, direction := range data {
+		var verdict expr.Any = &expr.Queue{
+			Num:   direction.queueNumber,
+			Total: 1,
+			Flag:  0, // 0 = block; use expr.QueueFlagBypass (1) to bypass if the net filter is not running or if the queue is full
+		}
+		if q.cfg.UDPMode == udpModeDrop {
+			verdict = &expr.Verdict{Kind: expr.VerdictDrop}
+		}
 		rule := &nftables.Rule{
 			Table: q.table,
 			Chain: q.chain,
-			Exprs: []expr.Any{
-				// Match source IP in the set
-				&expr.Payload{
-					DestRegister: 1,                             // Extract the source IP address into register 1
-					Base:         expr.PayloadBaseNetworkHeader, // Network header
-					Offset:       direction.direction,           // Offset based on direction
-					Len:          4,                             // Length: 4 bytes for IPv4 address
-				},
-				// Check if the source IP is in the YouTube IP set
-				&expr.Lookup{
-					SourceRegister: 1,
-					SetName:        q.nameSetLocal, // drop UDP to/from the local IPs.
-				},
-
-				// Match UDP protocol
-				&expr.Payload{
-					DestRegister: 2,                             // Store in register 2
-					Base:         expr.PayloadBaseNetworkHeader, // Network header
-					Offset:       9,                             // Protocol field offset in IPv4 header
-					Len:          1,                             // Length: 1 byte
-				},
+			Exprs: exprs(matchFamily(q.table, familyIPv4), matchAddrSet(familyIPv4, direction.field, 1, q.nameSetLocal), matchL4Proto(2), []expr.Any{ // queue or drop UDP to/from the local IPs.
 				&expr.Cmp{
 					Op:       expr.CmpOpEq,
 					Register: 2,
 					Data:     []byte{unix.IPPROTO_UDP}, // 17 = UDP
 				},
 
-				// Match destination port in set
+				// Match the remote port in set
 				&expr.Payload{
 					DestRegister: 3,
 					Base:         expr.PayloadBaseTransportHeader,
-					Offset:       2, // UDP header destination port offset
+					Offset:       direction.portOffset,
 					Len:          2, // Length of port
 				},
 				&expr.Lookup{
 					SourceRegister: 3,
 					SetName:        udpPortSet.Name,
 				},
+				verdict,
+			}),
+		}
+		q.addFilterRule(rule)
+	}
+}
 
-				// Match UDP destination port range (0-65535)
-				// &expr.Payload{
-				// 	DestRegister: 3,
-				// 	Base:         expr.PayloadBaseTransportHeader,
-				// 	Offset:       2, // UDP header - Destination Port (2 bytes at offset 2)
-				// 	Len:          2, // Match 2 bytes (16-bit port)
-				// },
-				// &expr.Range{
-				// 	Register: 3,
-				// 	FromData: []byte{0x00, 0x00}, // Port 0
-				// 	ToData:   []byte{0xFF, 0xFF}, // Port 65535
-				// },
+// addDNSInspectionRules adds rules that queue UDP from port 53 to the local IPs to the inbound queue, where the
+// answers are inspected before they're accepted. Answers from the gateway's own DNS server don't pass the forward
+// chain, so the rule is also added to an output chain of its own, unless the local chains already hold it.
+// The caller should flush the changes to the kernel after.
+func (q *Rules) addDNSInspectionRules(got *tableState) error {
+	rule := &nftables.Rule{
+		Table: q.table,
+		Chain: q.chain,
+		Exprs: exprs(matchFamily(q.table, familyIPv4), matchAddrSet(familyIPv4, dstAddr, 1, q.nameSetLocal), matchL4Proto(2), []expr.Any{
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: 2,
+				Data:     []byte{unix.IPPROTO_UDP},
+			},
+			&expr.Payload{ // the UDP source port.
+				DestRegister: 3,
+				Base:         expr.PayloadBaseTransportHeader,
+				Offset:       0,
+				Len:          2,
+			},
+			&expr.Cmp{
+				Op:       expr.CmpOpEq,
+				Register: 3,
+				Data:     binaryutil.BigEndian.PutUint16(53),
+			},
+			&expr.Queue{Num: q.cfg.InboundQueueNumber, Total: 1},
+		}),
+	}
+	q.addFilterRule(rule)
+	if q.cfg.LocalDevice {
+		return nil
+	}
 
-				// Drop action
-				// &expr.Verdict{
-				// 	Kind: expr.VerdictDrop,
-				// },
+	chain, err := getOrCreateHookChain(q.logger, q.conn, q.table, defaultDNSOutputChainName, nftables.ChainHookOutput)
+	if err != nil {
+		return fmt.Errorf("failed to create nftables %v chain: %v", defaultDNSOutputChainName, err)
+	}
+	q.useChain(chain, got)
+	exempt := *q.exemptRule(dstAddr) // the exempt IPs may be left in the local IP set if no others are tracked.
+	exempt.Chain = chain
+	q.addRule(&exempt)
+	if q.setFailOpen != nil { // if the answers should stop being queued while the filter has failed open...
+		failOpen := *q.failOpenRule(dstAddr)
+		failOpen.Chain = chain
+		q.addRule(&failOpen)
+	}
+	r := *rule
+	r.Chain = chain
+	q.addRule(&r)
+	return nil
+}
 
-				&expr.Queue{
-					Num:   direction.queueNumber,
-					Total: 1,
-					Flag:  0, // 0 = block; use expr.QueueFlagBypass (1) to bypass if the net filter is not running or if the queue is full
+// addLANRules adds an interval set of the LAN networks and a rule to the filter chains that accepts the packets from
+// and to addresses in it, so that traffic that doesn't leave the LAN is never queued, e.g. casting to a TV, even if
+// the TV's IP is also one that a tracked domain resolved to.
+// The caller should flush the changes to the kernel after.
+func (q *Rules) addLANRules(got *tableState) error {
+	set := &nftables.Set{Name: defaultLANSetName, Table: q.table, KeyType: nftables.TypeIPAddr, Interval: true}
+	if err := q.addSet(set, nil); err != nil {
+		return fmt.Errorf("failed to create LAN network set")
+	}
+	if got != nil && slices.Contains(got.sets, defaultLANSetName) { // if the set may hold networks that are no longer configured...
+		q.conn.FlushSet(set)
+	}
+	if err := q.conn.SetAddElements(set, intervalElements(q.lanNets)); err != nil {
+		return fmt.Errorf("failed to add elements to set %v: %w", set.Name, err)
+	}
+	q.addFilterRule(&nftables.Rule{
+		Table: q.table,
+		Chain: q.chain,
+		Exprs: exprs(
+			matchFamily(q.table, familyIPv4),
+			matchAddrSet(familyIPv4, srcAddr, 1, defaultLANSetName),
+			matchAddrSet(familyIPv4, dstAddr, 2, defaultLANSetName),
+			[]expr.Any{&expr.Verdict{Kind: expr.VerdictAccept}},
+		),
+	})
+	return nil
+}
+
+// intervalElements returns the elements of an interval set holding the IPv4 networks, which mustn't overlap: the
+// first address of each, and the address after its last to end the interval, unless it's the last address there is.
+func intervalElements(nets []*net.IPNet) []nftables.SetElement {
+	var elements []nftables.SetElement
+	for _, n := range nets {
+		start := n.IP.To4()
+		end := make(net.IP, len(start))
+		for i := range start {
+			end[i] = start[i] | ^n.Mask[len(n.Mask)-len(start)+i]
+		}
+		elements = append(elements, nftables.SetElement{Key: start})
+		for i := len(end) - 1; i >= 0; i-- { // add one to the last address.
+			end[i]++
+			if end[i] != 0 {
+				elements = append(elements, nftables.SetElement{Key: end, IntervalEnd: true})
+				break
+			}
+		}
+	}
+	return elements
+}
+
+// addBypassRules adds sets of the bypass ports and IPs, and rules to the forward chain that queue the traffic from the
+// local IPs to them for the bypass detector. If bypass blocking is on, the traffic of the local IPs in the bypass
+// blocked set is dropped instead. The rules aren't added to the local chains since the gateway's own apps use other
+// resolvers.
+// The caller should flush the changes to the kernel after.
+func (q *Rules) addBypassRules(got *tableState) error {
+	var ips []nftables.SetElement
+	for _, s := range slices.Concat(q.cfg.BypassResolverIPs, q.cfg.BypassVPNIPs) {
+		ip := net.ParseIP(s).To4()
+		if ip == nil {
+			return fmt.Errorf("bypass IP %q isn't an IPv4 address", s)
+		}
+		ips = append(ips, nftables.SetElement{Key: ip})
+	}
+	ipSet := &nftables.Set{Name: defaultBypassIPSetName, Table: q.table, KeyType: nftables.TypeIPAddr}
+	if err := q.addSet(ipSet, nil); err != nil {
+		return fmt.Errorf("failed to create bypass IP set")
+	}
+	portSet := &nftables.Set{Name: defaultBypassPortSetName, Table: q.table, KeyType: nftables.TypeInetService}
+	if err := q.addSet(portSet, nil); err != nil {
+		return fmt.Errorf("failed to create bypass port set")
+	}
+	for _, set := range []*nftables.Set{ipSet, portSet} {
+		if got != nil && slices.Contains(got.sets, set.Name) { // if the set may hold ports or IPs that are no longer configured...
+			q.conn.FlushSet(set)
+		}
+	}
+	var ports []nftables.SetElement
+	for _, port := range q.cfg.BypassPorts {
+		ports = append(ports, nftables.SetElement{Key: binaryutil.BigEndian.PutUint16(port)})
+	}
+	for _, s := range []struct {
+		set      *nftables.Set
+		elements []nftables.SetElement
+	}{{ipSet, ips}, {portSet, ports}} {
+		if len(s.elements) == 0 {
+			continue
+		}
+		if err := q.conn.SetAddElements(s.set, s.elements); err != nil {
+			return fmt.Errorf("failed to add elements to set %v: %w", s.set.Name, err)
+		}
+	}
+
+	if q.cfg.BypassBlock {
+		q.setBypassBlocked = &nftables.Set{Name: defaultBypassBlockedSetName, Table: q.table, KeyType: nftables.TypeIPAddr, Dynamic: true}
+		if err := q.addSet(q.setBypassBlocked, nil); err != nil {
+			return fmt.Errorf("failed to create bypass blocked local IP set")
+		}
+		if got != nil && slices.Contains(got.sets, defaultBypassBlockedSetName) { // if the set may hold IPs from an earlier run...
+			q.conn.FlushSet(q.setBypassBlocked)
+		}
+		q.addBypassRulesForSet(q.setBypassBlocked.Name, &expr.Verdict{Kind: expr.VerdictDrop})
+	}
+	q.addBypassRulesForSet(q.nameSetLocal, &expr.Queue{Num: q.cfg.OutboundQueueNumber, Total: 1})
+	return nil
+}
+
+// addBypassRulesForSet adds rules to the forward chain with the verdict for the traffic from the IPs in the set to
+// the bypass IPs, and to the bypass ports over TCP or UDP.
+// The caller should flush the changes to the kernel after.
+func (q *Rules) addBypassRulesForSet(srcSetName string, verdict expr.Any) {
+	q.addRule(&nftables.Rule{
+		Table: q.table,
+		Chain: q.chain,
+		Exprs: exprs(
+			matchFamily(q.table, familyIPv4),
+			matchAddrSet(familyIPv4, srcAddr, 1, srcSetName),
+			matchAddrSet(familyIPv4, dstAddr, 2, defaultBypassIPSetName),
+			[]expr.Any{verdict},
+		),
+	})
+	q.addRule(&nftables.Rule{
+		Table: q.table,
+		Chain: q.chain,
+		Exprs: exprs(
+			matchFamily(q.table, familyIPv4),
+			matchAddrSet(familyIPv4, srcAddr, 1, srcSetName),
+			matchL4Proto(2),
+			[]expr.Any{
+				&expr.Lookup{
+					SourceRegister: 2,
+					SetName:        q.setProto.Name,
+				},
+				&expr.Payload{ // the TCP and UDP destination ports are at the same offset.
+					DestRegister: 3,
+					Base:         expr.PayloadBaseTransportHeader,
+					Offset:       2,
+					Len:          2,
 				},
+				&expr.Lookup{
+					SourceRegister: 3,
+					SetName:        defaultBypassPortSetName,
+				},
+				verdict,
 			},
+		),
+	})
+}
+
+// UpdateBypassBlocked implements bypass.Blocker to drop the traffic from the IPs to the bypass ports and IPs.
+func (q *Rules) UpdateBypassBlocked(ips []models.Ip) {
+	var blocked []nftables.SetElement
+	for _, ip := range ips {
+		if addr := net.ParseIP(string(ip)).To4(); addr != nil {
+			blocked = append(blocked, nftables.SetElement{Key: addr})
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.bypassBlocked = blocked
+	if q.setBypassBlocked == nil {
+		return
+	}
+	q.logUpdateError("bypass blocked", q.updateBypassBlockedSet())
+}
+
+// updateBypassBlockedSet replaces the contents of the bypass blocked set with the bypass blocked IPs.
+// This should be done under a mutex.
+func (q *Rules) updateBypassBlockedSet() error {
+	if q.removed {
+		return errRemoved
+	}
+	existing, err := q.conn.GetSetElements(q.setBypassBlocked)
+	if err != nil {
+		return fmt.Errorf("unable to get existing bypass blocked IPs from set: %w", err)
+	}
+	if err = q.conn.SetDeleteElements(q.setBypassBlocked, existing); err != nil {
+		return fmt.Errorf("unable to delete bypass blocked set contents: %w", err)
+	}
+	if len(q.bypassBlocked) > 0 {
+		if err = q.conn.SetAddElements(q.setBypassBlocked, q.bypassBlocked); err != nil {
+			return fmt.Errorf("unable to add bypass blocked IPs to set: %w", err)
 		}
-		q.conn.AddRule(rule)
 	}
+	if err = q.conn.Flush(); err != nil {
+		return fmt.Errorf("failed to flush nftables bypass blocked set: %v", err)
+	}
+	q.logger.Infof("NFT bypass blocked set updated with %d local IPs", len(q.bypassBlocked))
+	return nil
+}
+
+// checkUDPMode returns an error if the mode isn't one of the UDP modes.
+func checkUDPMode(mode string) error {
+	switch mode {
+	case udpModeQueue, udpModeDrop, udpModeAccept, "":
+		return nil
+	}
+	return fmt.Errorf("unknown UDP mode %q, expected %v, %v or %v", mode, udpModeQueue, udpModeDrop, udpModeAccept)
 }
 
 // UpdateDestIpDomains is a callback that saves the supplied Ip addresses and updates the nft rules using them.
@@ -341,7 +761,7 @@ func (q *Rules) UpdateDestIpDomains(newData models.MapIpDomain) {
 	// Convert to set elements and save.
 	discarded := 0
 	var newIps []nftables.SetElement
-	for k := range newData {
+	for _, k := range slices.Sorted(maps.Keys(newData)) { // sort for a stable order of set elements.
 		ip := net.ParseIP(string(k)).To4()
 		if ip != nil {
 			newIps = append(newIps, nftables.SetElement{Key: ip})
@@ -360,49 +780,324 @@ func (q *Rules) UpdateDestIpDomains(newData models.MapIpDomain) {
 
 	// Refresh the NFTables rules.
 	err := q.updateIpSets()
-	if err != nil {
-		q.logger.Warnf("NFT callback with new destination IPs couldn't make the update: %v", err)
-	}
+	q.logUpdateError("destination", err)
 }
 
 // UpdateSourceIpGroups is a callback that saves the supplied Ip addresses and updates the nft rules using them.
+// The IPs of the exempt devices are put in the exempt set instead of the local IP set.
 func (q *Rules) UpdateSourceIpGroups(newData models.MapIpGroups) {
 	q.logger.Debugf("NFT callback with new source IPs: %v", newData)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.allSrcIpGroups = newData
+	q.applySourceIpGroups()
+}
+
+// UpdateSourceIpMACs implements the SourceIpMACReceiver interface to find the IPs of the devices exempt by MAC.
+func (q *Rules) UpdateSourceIpMACs(newData models.MapIpMACs) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.srcIpMACs = newData
+	if q.updateExemptIPs() {
+		q.logUpdateError("exempt", q.updateExemptSet())
+		q.applySourceIpGroups()
+	}
+}
+
+// UpdateExemptions implements the ExemptionReceiver interface to accept the traffic of the exempt devices without
+// queuing it.
+func (q *Rules) UpdateExemptions(exemptions []models.Exemption) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.exemptions = exemptions
+	if q.updateExemptIPs() {
+		q.logUpdateError("exempt", q.updateExemptSet())
+		q.applySourceIpGroups()
+	}
+}
+
+// applySourceIpGroups updates the sets with the last source IP data, leaving out the exempt IPs.
+// This should be done under a mutex.
+func (q *Rules) applySourceIpGroups() {
+	if q.allSrcIpGroups == nil { // if there haven't been any source IPs yet...
+		return
+	}
+	exempt := make(map[models.Ip]bool, len(q.exemptIPs))
+	for _, e := range q.exemptIPs {
+		exempt[models.Ip(net.IP(e.Key).String())] = true
+	}
 
 	// Convert to set elements and save.
 	discarded := 0
 	var newIps []nftables.SetElement
-	for k := range newData {
+	srcIpGroups := make(models.MapIpGroups, len(q.allSrcIpGroups))
+	for _, k := range slices.Sorted(maps.Keys(q.allSrcIpGroups)) { // sort for a stable order of set elements.
 		ip := net.ParseIP(string(k)).To4()
-		if ip != nil {
-			newIps = append(newIps, nftables.SetElement{Key: ip})
-		} else {
+		switch {
+		case ip == nil:
 			discarded++
+		case !exempt[models.Ip(ip.String())]:
+			newIps = append(newIps, nftables.SetElement{Key: ip})
+			srcIpGroups[k] = q.allSrcIpGroups[k]
 		}
 	}
 
 	if discarded > 0 {
-		q.logger.Infof("NFT destination IP callback discarded %v address(es)", discarded)
+		q.logger.Infof("NFT source IP callback discarded %v address(es), e.g. IPv6 ones, that the sets can't hold", discarded)
 	}
 
+	q.localIPs = newIps
+	q.srcIpGroups = srcIpGroups
+	q.updateSampledIPs()
+
+	err := q.updateIpSets()
+	q.logUpdateError("source", err)
+	if q.updateBlockedIPs() {
+		q.logUpdateError("blocked", q.updateBlockedSet())
+	}
+	if q.killSwitch { // if the new IPs need blocking too...
+		q.logUpdateError("kill switch", q.updateKilledSet())
+	}
+	if q.failOpen { // if the new IPs shouldn't be queued either...
+		q.logUpdateError("fail-open", q.updateFailOpenSet())
+	}
+}
+
+// updateExemptIPs saves the IPv4 addresses of the exemptions and returns true if they changed.
+// This should be done under a mutex.
+func (q *Rules) updateExemptIPs() bool {
+	var exempt []nftables.SetElement
+	for _, ip := range slices.Sorted(maps.Keys(models.ExemptIPs(q.exemptions, q.srcIpMACs))) {
+		exempt = append(exempt, nftables.SetElement{Key: net.ParseIP(string(ip)).To4()})
+	}
+	if slices.EqualFunc(exempt, q.exemptIPs, func(a, b nftables.SetElement) bool { return slices.Equal(a.Key, b.Key) }) {
+		return false
+	}
+	q.exemptIPs = exempt
+	return true
+}
+
+// updateExemptSet replaces the contents of the exempt set with the exempt IPs.
+// This should be done under a mutex.
+func (q *Rules) updateExemptSet() error {
+	if q.removed {
+		return errRemoved
+	}
+	existing, err := q.conn.GetSetElements(q.setExempt)
+	if err != nil {
+		return fmt.Errorf("unable to get existing exempt IPs from set: %w", err)
+	}
+	if err = q.conn.SetDeleteElements(q.setExempt, existing); err != nil {
+		return fmt.Errorf("unable to delete exempt set contents: %w", err)
+	}
+	if len(q.exemptIPs) > 0 {
+		if err = q.conn.SetAddElements(q.setExempt, q.exemptIPs); err != nil {
+			return fmt.Errorf("unable to add exempt IPs to set: %w", err)
+		}
+	}
+	if err = q.conn.Flush(); err != nil {
+		return fmt.Errorf("failed to flush nftables exempt set: %v", err)
+	}
+	q.logger.Infof("NFT exempt set updated with %d IPs", len(q.exemptIPs))
+	return nil
+}
+
+// UpdateKillSwitch implements the KillSwitchReceiver interface to drop all traffic to and from the local IPs while
+// the kill switch is on.
+func (q *Rules) UpdateKillSwitch(on bool) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	q.localIPs = newIps
+	q.killSwitch = on
+	q.logUpdateError("kill switch", q.updateKilledSet())
+}
+
+// updateKilledSet fills the kill switch set with the local IPs if the kill switch is on, else empties it.
+// This should be done under a mutex.
+func (q *Rules) updateKilledSet() error {
+	if q.removed {
+		return errRemoved
+	}
+	existing, err := q.conn.GetSetElements(q.setKilled)
+	if err != nil {
+		return fmt.Errorf("unable to get existing kill switch IPs from set: %w", err)
+	}
+	if err = q.conn.SetDeleteElements(q.setKilled, existing); err != nil {
+		return fmt.Errorf("unable to delete kill switch set contents: %w", err)
+	}
+	if q.killSwitch && len(q.localIPs) > 0 {
+		if err = q.conn.SetAddElements(q.setKilled, q.localIPs); err != nil {
+			return fmt.Errorf("unable to add local IPs to kill switch set: %w", err)
+		}
+	}
+	if err = q.conn.Flush(); err != nil {
+		return fmt.Errorf("failed to flush nftables kill switch set: %v", err)
+	}
+	q.logger.Infof("NFT kill switch set updated (on=%v, %d local IPs)", q.killSwitch, len(q.localIPs))
+	return nil
+}
 
+// UpdateFailOpen implements the FailOpenReceiver interface to accept the traffic to and from the local IPs without
+// queuing it while the packet handler can't keep up.
+func (q *Rules) UpdateFailOpen(on bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.setFailOpen == nil {
+		return
+	}
+	q.failOpen = on
+	q.logUpdateError("fail-open", q.updateFailOpenSet())
+}
+
+// updateFailOpenSet fills the fail-open set with the local IPs while the filter has failed open, else empties it.
+// This should be done under a mutex.
+func (q *Rules) updateFailOpenSet() error {
+	if q.removed {
+		return errRemoved
+	}
+	existing, err := q.conn.GetSetElements(q.setFailOpen)
+	if err != nil {
+		return fmt.Errorf("unable to get existing fail-open IPs from set: %w", err)
+	}
+	if err = q.conn.SetDeleteElements(q.setFailOpen, existing); err != nil {
+		return fmt.Errorf("unable to delete fail-open set contents: %w", err)
+	}
+	if q.failOpen && len(q.localIPs) > 0 {
+		if err = q.conn.SetAddElements(q.setFailOpen, q.localIPs); err != nil {
+			return fmt.Errorf("unable to add local IPs to fail-open set: %w", err)
+		}
+	}
+	if err = q.conn.Flush(); err != nil {
+		return fmt.Errorf("failed to flush nftables fail-open set: %v", err)
+	}
+	q.logger.Infof("NFT fail-open set updated (on=%v, %d local IPs)", q.failOpen, len(q.localIPs))
+	return nil
+}
+
+// UpdateThresholdState implements the ThresholdStateReceiver interface so that every packet is queued again for groups
+// that need blocking, and sampling resumes once they are allowed. Devices whose groups are all blocked see the block
+// page, if it's enabled.
+func (q *Rules) UpdateThresholdState(group models.Group, exceeded bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if exceeded {
+		q.exceeded[group] = true
+	} else {
+		delete(q.exceeded, group)
+	}
+	if q.updateBlockedIPs() {
+		q.logUpdateError("blocked", q.updateBlockedSet())
+	}
+	if !q.updateSampledIPs() {
+		return
+	}
+	q.logger.Debugf("NFT callback with threshold state for group %v exceeded=%v changed the sampled IPs", group, exceeded)
 	err := q.updateIpSets()
+	q.logUpdateError("sampled", err)
+}
+
+// updateBlockedIPs saves the local IPs whose groups are all over their thresholds and returns true if they changed.
+// IPs in any group that's allowed aren't blocked so that they still reach that group's sites.
+// This should be done under a mutex.
+func (q *Rules) updateBlockedIPs() bool {
+	if q.setBlocked == nil {
+		return false
+	}
+	var blocked []nftables.SetElement
+	for _, ip := range slices.Sorted(maps.Keys(q.srcIpGroups)) {
+		groups, addr := q.srcIpGroups[ip], net.ParseIP(string(ip)).To4()
+		if len(groups) == 0 || addr == nil {
+			continue
+		}
+		if !slices.ContainsFunc(groups, func(g models.Group) bool { return !q.exceeded[g] }) {
+			blocked = append(blocked, nftables.SetElement{Key: addr})
+		}
+	}
+	if slices.EqualFunc(blocked, q.blockedIPs, func(a, b nftables.SetElement) bool { return slices.Equal(a.Key, b.Key) }) {
+		return false
+	}
+	q.blockedIPs = blocked
+	return true
+}
+
+// updateBlockedSet replaces the contents of the blocked set with the blocked IPs.
+// This should be done under a mutex.
+func (q *Rules) updateBlockedSet() error {
+	if q.removed {
+		return errRemoved
+	}
+	existing, err := q.conn.GetSetElements(q.setBlocked)
 	if err != nil {
-		q.logger.Warnf("NFT callback with new source IPs couldn't make the update: %v", err)
+		return fmt.Errorf("unable to get existing blocked IPs from set: %w", err)
+	}
+	if err = q.conn.SetDeleteElements(q.setBlocked, existing); err != nil {
+		return fmt.Errorf("unable to delete blocked set contents: %w", err)
+	}
+	if len(q.blockedIPs) > 0 {
+		if err = q.conn.SetAddElements(q.setBlocked, q.blockedIPs); err != nil {
+			return fmt.Errorf("unable to add blocked IPs to set: %w", err)
+		}
+	}
+	if err = q.conn.Flush(); err != nil {
+		return fmt.Errorf("failed to flush nftables blocked set: %v", err)
+	}
+	q.logger.Infof("NFT blocked set updated with %d local IPs", len(q.blockedIPs))
+	return nil
+}
+
+// updateSampledIPs saves the local IPs whose groups all have packet sampling enabled and returns true if they changed.
+// This should be done under a mutex.
+func (q *Rules) updateSampledIPs() bool {
+	if q.sampler == nil {
+		return false
+	}
+	sampled := sampledIPs(q.srcIpGroups, q.sampler)
+	if maps.Equal(sampled, q.sampled) {
+		return false
+	}
+	q.sampled = sampled
+	q.sampledIPs = nil
+	for _, ip := range slices.Sorted(maps.Keys(sampled)) {
+		q.sampledIPs = append(q.sampledIPs, nftables.SetElement{Key: net.ParseIP(string(ip)).To4()})
+	}
+	return true
+}
+
+// sampledIPs returns the IPv4 addresses in srcIpGroups whose groups are all sampled. IPs in any group that needs
+// every packet, e.g. to enforce a block, aren't sampled.
+func sampledIPs(srcIpGroups models.MapIpGroups, sampler models.PacketSampler) map[models.Ip]bool {
+	sampled := make(map[models.Ip]bool)
+	for ip, groups := range srcIpGroups {
+		if len(groups) == 0 || net.ParseIP(string(ip)).To4() == nil {
+			continue
+		}
+		if !slices.ContainsFunc(groups, func(g models.Group) bool { return !sampler.IsPacketSampled(string(g)) }) {
+			sampled[ip] = true
+		}
+	}
+	return sampled
+}
+
+// SampleRate returns N if only 1 in N packets from the local ip are queued, or 1 if every packet is queued.
+func (q *Rules) SampleRate(ip models.Ip) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.sampled[ip] {
+		return int(q.sampleRate)
 	}
+	return 1
 }
 
 // updateIpSets adds nftables rules to send packets to the default NFQs.
 // This should be done under a mutex since it reads the Rules srcIps and destIps.
 func (q *Rules) updateIpSets() error {
+	if q.removed {
+		return errRemoved
+	}
 	if len(q.localIPs) == 0 {
-		return fmt.Errorf("local IPs aren't ready")
+		return errLocalIPsNotReady
 	}
 	if len(q.remoteIPs) == 0 {
-		return fmt.Errorf("remote IPs aren't ready")
+		return errRemoteIPsNotReady
 	}
 
 	// Clear all existing local IP in the set.
@@ -425,6 +1120,18 @@ func (q *Rules) updateIpSets() error {
 		return fmt.Errorf("unable to delete remote set contents: %w", err)
 	}
 
+	// Clear all existing sampled IPs in the set.
+	if q.setSampled != nil {
+		existingSetSampledIps, err := q.conn.GetSetElements(q.setSampled)
+		if err != nil {
+			return fmt.Errorf("unable to get existing sampled IPs from set: %w", err)
+		}
+		err = q.conn.SetDeleteElements(q.setSampled, existingSetSampledIps)
+		if err != nil {
+			return fmt.Errorf("unable to delete sampled set contents: %w", err)
+		}
+	}
+
 	// Add local IPs to set.
 	err = q.conn.SetAddElements(q.setLocal, q.localIPs)
 	if err != nil {
@@ -437,15 +1144,88 @@ func (q *Rules) updateIpSets() error {
 		return fmt.Errorf("unable to add new remote IPs to set: %w", err)
 	}
 
+	// Add sampled IPs to set.
+	if q.setSampled != nil && len(q.sampledIPs) > 0 {
+		err = q.conn.SetAddElements(q.setSampled, q.sampledIPs)
+		if err != nil {
+			return fmt.Errorf("unable to add new sampled IPs to set: %w", err)
+		}
+	}
+
 	// Flush changes to the kernel.
 	if err := q.conn.Flush(); err != nil {
 		return fmt.Errorf("failed to flush nftables sets: %v", err)
 	}
 
-	q.logger.Infof("NFT rules updated with %d local IPs and %d remote IPs", len(q.localIPs), len(q.remoteIPs))
+	if !q.installed {
+		q.logger.Info("NFT sets installed, filtering is active")
+	}
+	q.installed = true
+	q.logger.Infof("NFT rules updated with %d local IPs (%d sampled) and %d remote IPs", len(q.localIPs), len(q.sampledIPs), len(q.remoteIPs))
 	return nil
 }
 
+// logUpdateError logs errors from updateIpSets. Waiting for the first source or destination IPs is expected at startup
+// and is reported by Readiness instead, so it is only logged at debug level.
+func (q *Rules) logUpdateError(kind string, err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, errLocalIPsNotReady) || errors.Is(err, errRemoteIPsNotReady):
+		q.logger.Debugf("NFT callback with new %v IPs deferred the update: %v", kind, err)
+	case errors.Is(err, errRemoved):
+		q.logger.Debugf("NFT callback with new %v IPs skipped the update: %v", kind, err)
+	default:
+		q.logger.Warnf("NFT callback with new %v IPs couldn't make the update: %v", kind, err)
+	}
+}
+
+// Readiness reports whether the NFT sets have been filled so that packets are queued for filtering.
+func (q *Rules) Readiness() models.Readiness {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return readiness(q.installed, len(q.localIPs), len(q.remoteIPs))
+}
+
+func readiness(installed bool, localIPs, remoteIPs int) models.Readiness {
+	switch {
+	case installed:
+		return models.Readiness{State: models.ReadinessReady}
+	case localIPs == 0:
+		return models.Readiness{State: models.ReadinessWaitingForSources, Cause: "no source IPs have been found for the NFT sets"}
+	case remoteIPs == 0:
+		return models.Readiness{State: models.ReadinessWaitingForDestinations, Cause: "no destination IPs have been resolved for the NFT sets"}
+	}
+	return models.Readiness{State: models.ReadinessWaitingForDestinations, Cause: "the NFT sets couldn't be updated"}
+}
+
+// addRule adds the rule and counts it as one of the rules in its chain for Reconcile.
+func (q *Rules) addRule(rule *nftables.Rule) {
+	q.conn.AddRule(rule)
+	q.want.chains[rule.Chain.Name]++
+}
+
+// addSet adds the set and records it as one for Reconcile.
+func (q *Rules) addSet(set *nftables.Set, elements []nftables.SetElement) error {
+	if err := q.conn.AddSet(set, elements); err != nil {
+		return err
+	}
+	if !slices.Contains(q.want.sets, set.Name) {
+		q.want.sets = append(q.want.sets, set.Name)
+	}
+	return nil
+}
+
+// addFilterRule adds the rule to the filter chain and to each of the local chains.
+// Rules only match packets in the direction of the chain they're in, so they're the same for all chains.
+func (q *Rules) addFilterRule(rule *nftables.Rule) {
+	q.addRule(rule)
+	for _, chain := range q.localChains {
+		r := *rule
+		r.Chain = chain
+		q.addRule(&r)
+	}
+}
+
 // addNFTablesRuleSet creates NFTables rules by creating a rule that sends traffic to the given NFQueue number.
 // It uses a set for each of the source and dest IP slices supplied.
 // The caller should flush the changes to the kernel after.
@@ -455,63 +1235,175 @@ func (q *Rules) addNFTablesRuleForSets(nfqNumber uint16, srcSetName, destSetName
 	rule := &nftables.Rule{
 		Table: q.table,
 		Chain: q.chain,
-		Exprs: []expr.Any{
-			// Extract the source IP address into register 1
-			&expr.Payload{
-				DestRegister: 1,                             // Store in register 1
-				Base:         expr.PayloadBaseNetworkHeader, // Network header
-				Offset:       12,                            // Offset 12 for IPv4 source IP
-				Len:          4,                             // Length: 4 bytes for IPv4 address
-			},
-			// Check if the source IP is in the YouTube IP set
-			&expr.Lookup{
-				SourceRegister: 1,
-				SetName:        srcSetName,
+		Exprs: exprs(
+			matchFamily(q.table, familyIPv4),
+			matchAddrSet(familyIPv4, srcAddr, 1, srcSetName),  // check if the source IP is in the YouTube IP set
+			matchAddrSet(familyIPv4, dstAddr, 2, destSetName), // check if the destination IP is in the destination hosts set
+			matchL4Proto(3),
+			[]expr.Any{
+				// Check if the protocol is in the protocol set
+				&expr.Lookup{
+					SourceRegister: 3,
+					SetName:        q.setProto.Name,
+				},
+				// TODO: figure out how to mark packets by using tracing!
+				// // Add a mark to the packet.
+				// &expr.Meta{
+				// 	Key:            expr.MetaKeyMARK,
+				// 	Register:       4,
+				// },
+				// &expr.Immediate{
+				// 	Register: 4,
+				// 	Data:     []byte{1, 0, 0, 0}, // Set a mark; see also the reading of this mark in the NAT chain.
+				// },
+				// Send matching packets to NFQUEUE for further processing
+				&expr.Queue{
+					Num:   nfqNumber,
+					Total: 1,
+					Flag:  0, // 0 = block; use expr.QueueFlagBypass (1) to bypass if the net filter is not running or if the queue is full
+				},
 			},
-			// Extract the destination IP address into register 2
-			&expr.Payload{
-				DestRegister: 2,                             // Store in register 2
-				Base:         expr.PayloadBaseNetworkHeader, // Network header
-				Offset:       16,                            // Offset 16 for IPv4 destination IP
-				Len:          4,                             // Length: 4 bytes for IPv4 address
+		),
+	}
+	q.addFilterRule(rule)
+	return nil
+}
+
+// addKillSwitchRule adds a rule to the forward chain that drops packets whose IPv4 address in the given field is in
+// the kill switch set.
+// The caller should flush the changes to the kernel after.
+func (q *Rules) addKillSwitchRule(field addrField) {
+	q.addRule(&nftables.Rule{
+		Table: q.table,
+		Chain: q.chain,
+		Exprs: exprs(matchFamily(q.table, familyIPv4), matchAddrSet(familyIPv4, field, 1, q.setKilled.Name), []expr.Any{
+			&expr.Verdict{
+				Kind: expr.VerdictDrop,
 			},
-			// Check if the destination IP is in the destination hosts set
-			&expr.Lookup{
-				SourceRegister: 2,
-				SetName:        destSetName,
+		}),
+	})
+}
+
+// addExemptRule adds a rule to the filter chains that accepts packets whose IPv4 address in the given field is in the
+// exempt set.
+// The caller should flush the changes to the kernel after.
+func (q *Rules) addExemptRule(field addrField) {
+	q.addFilterRule(q.exemptRule(field))
+}
+
+// exemptRule returns the rule for the filter chain that accepts packets whose IPv4 address in the given field is in
+// the exempt set.
+func (q *Rules) exemptRule(field addrField) *nftables.Rule {
+	return &nftables.Rule{
+		Table: q.table,
+		Chain: q.chain,
+		Exprs: exprs(matchFamily(q.table, familyIPv4), matchAddrSet(familyIPv4, field, 1, q.setExempt.Name), []expr.Any{
+			&expr.Verdict{
+				Kind: expr.VerdictAccept,
 			},
-			// Extract the protocol into register 3
-			&expr.Payload{
-				DestRegister: 3,                             // Store in register 3
-				Base:         expr.PayloadBaseNetworkHeader, // Network header
-				Offset:       9,                             // Protocol field offset in IPv4 header
-				Len:          1,                             // Length: 1 byte
+		}),
+	}
+}
+
+// addFailOpenRule adds a rule to the filter chains that accepts packets whose IPv4 address in the given field is in
+// the fail-open set.
+// The caller should flush the changes to the kernel after.
+func (q *Rules) addFailOpenRule(field addrField) {
+	q.addFilterRule(q.failOpenRule(field))
+}
+
+// failOpenRule returns the rule for the filter chain that accepts packets whose IPv4 address in the given field is in
+// the fail-open set.
+func (q *Rules) failOpenRule(field addrField) *nftables.Rule {
+	return &nftables.Rule{
+		Table: q.table,
+		Chain: q.chain,
+		Exprs: exprs(matchFamily(q.table, familyIPv4), matchAddrSet(familyIPv4, field, 1, q.setFailOpen.Name), []expr.Any{
+			&expr.Verdict{
+				Kind: expr.VerdictAccept,
 			},
-			// Check if the protocol is in the protocol set
-			&expr.Lookup{
-				SourceRegister: 3,
-				SetName:        q.setProto.Name,
+		}),
+	}
+}
+
+// addBlockPageRule adds a rule to the chain that redirects TCP port 80 from the blocked set to the remote set to the
+// given port on this device, i.e. a dnat to the address the packet arrived on, so that browsers show the block page
+// instead of timing out. Other traffic is still dropped by the filter.
+// The caller should flush the changes to the kernel after.
+func (q *Rules) addBlockPageRule(chain *nftables.Chain, port uint16) {
+	q.addRule(&nftables.Rule{
+		Table: q.table,
+		Chain: chain,
+		Exprs: exprs(
+			matchFamily(q.table, familyIPv4),
+			matchAddrSet(familyIPv4, srcAddr, 1, q.setBlocked.Name),
+			matchAddrSet(familyIPv4, dstAddr, 1, q.nameSetRemote),
+			matchL4Proto(1),
+			[]expr.Any{
+				&expr.Cmp{
+					Op:       expr.CmpOpEq,
+					Register: 1,
+					Data:     []byte{unix.IPPROTO_TCP},
+				},
+				&expr.Payload{
+					DestRegister: 1,
+					Base:         expr.PayloadBaseTransportHeader,
+					Offset:       2, // Offset 2 for the TCP destination port
+					Len:          2,
+				},
+				&expr.Cmp{
+					Op:       expr.CmpOpEq,
+					Register: 1,
+					Data:     binaryutil.BigEndian.PutUint16(80),
+				},
+				&expr.Immediate{
+					Register: 1,
+					Data:     binaryutil.BigEndian.PutUint16(port),
+				},
+				&expr.Redir{
+					RegisterProtoMin: 1,
+				},
 			},
-			// TODO: figure out how to mark packets by using tracing!
-			// // Add a mark to the packet.
-			// &expr.Meta{
-			// 	Key:            expr.MetaKeyMARK,
-			// 	Register:       4,
-			// },
-			// &expr.Immediate{
-			// 	Register: 4,
-			// 	Data:     []byte{1, 0, 0, 0}, // Set a mark; see also the reading of this mark in the NAT chain.
-			// },
-			// Send matching packets to NFQUEUE for further processing
-			&expr.Queue{
-				Num:   nfqNumber,
-				Total: 1,
-				Flag:  0, // 0 = block; use expr.QueueFlagBypass (1) to bypass if the net filter is not running or if the queue is full
+		),
+	})
+}
+
+// addNFTablesSamplingRuleForSets adds a rule that accepts packets between the given sets, except for a random 1 in
+// sampleRate, which continue to the rules that queue them.
+// The caller should flush the changes to the kernel after.
+func (q *Rules) addNFTablesSamplingRuleForSets(srcSetName, destSetName string) {
+	rule := &nftables.Rule{
+		Table: q.table,
+		Chain: q.chain,
+		Exprs: exprs(
+			matchFamily(q.table, familyIPv4),
+			matchAddrSet(familyIPv4, srcAddr, 1, srcSetName),
+			matchAddrSet(familyIPv4, dstAddr, 2, destSetName),
+			matchL4Proto(3),
+			[]expr.Any{
+				&expr.Lookup{
+					SourceRegister: 3,
+					SetName:        q.setProto.Name,
+				},
+				// Pick a random number from 0 to sampleRate-1 into register 4
+				&expr.Numgen{
+					Register: 4,
+					Modulus:  q.sampleRate,
+					Type:     unix.NFT_NG_RANDOM,
+				},
+				// Accept the packet without queueing it unless the number is 0
+				&expr.Cmp{
+					Op:       expr.CmpOpNeq,
+					Register: 4,
+					Data:     binaryutil.NativeEndian.PutUint32(0),
+				},
+				&expr.Verdict{
+					Kind: expr.VerdictAccept,
+				},
 			},
-		},
+		),
 	}
-	q.conn.AddRule(rule)
-	return nil
+	q.addFilterRule(rule)
 }
 
 // addNFTablesRuleForSingleDestAddr adds a rule to send traffic to the NFQUEUE for this app.
@@ -525,34 +1417,26 @@ func (q *Rules) addNFTablesRuleForSingleDestAddr(dAddr models.Ip) error {
 		return errors.New("invalid net IP address")
 	}
 
-	var offset, length uint32
-	var ipBytes []byte
-
-	if ip.To4() != nil {
-		offset = 16
-		length = 4
-		ipBytes = ip.To4()
-	} else {
-		if ip.To16() != nil {
+	f, ipBytes := familyIPv4, ip.To4()
+	if ipBytes == nil {
+		if q.table.Family != nftables.TableFamilyINet { // if IPv6 would need a table of its own...
 			q.logger.Infof("Skipped IP6 address %q\n", dAddr)
-		} else {
-			q.logger.Infof("Skipped bad IP address %q\n", dAddr)
+			return nil
 		}
-		return nil
+		f, ipBytes = familyIPv6, ip.To16()
 	}
 
 	// Add a rule to send traffic to NFQUEUE
 	rule := &nftables.Rule{
 		Table: q.table,
 		Chain: q.chain,
-		Exprs: []expr.Any{
+		Exprs: exprs(matchFamily(q.table, f), []expr.Any{
 			// Match destination Ip address
 			&expr.Payload{
 				DestRegister: 1,                             // Store the payload in register 1
 				Base:         expr.PayloadBaseNetworkHeader, // Match the network header
-				Offset:       offset,
-				Len:          length,
-				// TODO: handle IPv6 in nftables rules
+				Offset:       f.dstOffset,
+				Len:          f.addrLen,
 			},
 			&expr.Cmp{
 				Op:       expr.CmpOpEq,
@@ -565,14 +1449,15 @@ func (q *Rules) addNFTablesRuleForSingleDestAddr(dAddr models.Ip) error {
 				Total: 1,                   // Single queue
 				Flag:  0,                   // 0 = block; use expr.QueueFlagBypass (1) to bypass if the net filter is not running or if the queue is full
 			},
-		},
+		}),
 	}
-	q.conn.AddRule(rule)
+	q.addRule(rule)
 	return nil
 }
 
-func tableExists(logger *zap.SugaredLogger, conn *nftables.Conn, tableName string) bool {
-	tables, err := conn.ListTables()
+// tableExists returns true if there's a table with the name in the family, or in any family if it's unspecified.
+func tableExists(logger *zap.SugaredLogger, conn *nftables.Conn, tableName string, family nftables.TableFamily) bool {
+	tables, err := conn.ListTablesOfFamily(family)
 	if err != nil {
 		logger.Fatalf("Failed to list nftables tables: %v\n", err)
 	}
@@ -584,27 +1469,28 @@ func tableExists(logger *zap.SugaredLogger, conn *nftables.Conn, tableName strin
 	return false
 }
 
-func chainExists(logger *zap.SugaredLogger, conn *nftables.Conn, chainName string) bool {
+// chainExists returns true if the table has the chain. Chains with the same name in other tables, including tables
+// with the same name in other families, don't count.
+func chainExists(logger *zap.SugaredLogger, conn *nftables.Conn, table *nftables.Table, chainName string) bool {
 	chains, err := conn.ListChains()
 	if err != nil {
 		logger.Fatalf("Failed to list nftables chains: %v\n", err)
 	}
 	for _, v := range chains {
-		if v.Name == chainName {
+		if v.Name == chainName && v.Table != nil && v.Table.Name == table.Name && v.Table.Family == table.Family {
 			return true
 		}
 	}
 	return false
 }
 
-func getOrCreateTable(logger *zap.SugaredLogger, conn *nftables.Conn, tableName string) (*nftables.Table, error) {
+func getOrCreateTable(logger *zap.SugaredLogger, conn *nftables.Conn, tableName string, family nftables.TableFamily) (*nftables.Table, error) {
 	var err error
 	table := &nftables.Table{
-		Family: nftables.TableFamilyIPv4, // TODO: work out if we can use family inet instead for both ip4 and ip16 addresses
-		// NOTE: Family: nftables.TableFamilyINet doesn't work for both IPv4 and IPv6 addresses (it produces lower-level rules)
-		Name: tableName,
+		Family: family, // inet tables see IPv4 and IPv6, so their rules match the family before its payload offsets.
+		Name:   tableName,
 	}
-	if !tableExists(logger, conn, tableName) { // TODO: decide if we want to delete/replace the table if it exists already
+	if !tableExists(logger, conn, tableName, family) { // TODO: decide if we want to delete/replace the table if it exists already
 		conn.AddTable(table)
 		err = conn.Flush()
 	}
@@ -620,7 +1506,25 @@ func getOrCreateFilterChain(logger *zap.SugaredLogger, conn *nftables.Conn, tabl
 		Hooknum:  nftables.ChainHookForward, // input chain is for packets destined for the local machine; forward chain is for packets that are being routed through the local machine; output chain is for packets originating from the local machine
 		Priority: nftables.ChainPriorityFilter,
 	}
-	if !chainExists(logger, conn, table.Name) { // TODO: decide if we want to delete/replace the chain if it exists already
+	if !chainExists(logger, conn, table, chainName) {
+		conn.AddChain(chain)
+		err = conn.Flush()
+	}
+	return chain, err
+}
+
+// getOrCreateHookChain creates a filter chain on the given hook, e.g. the output hook for packets from the local
+// machine, which don't pass through the forward chain.
+func getOrCreateHookChain(logger *zap.SugaredLogger, conn *nftables.Conn, table *nftables.Table, chainName string, hook *nftables.ChainHook) (*nftables.Chain, error) {
+	var err error
+	chain := &nftables.Chain{
+		Name:     chainName,
+		Table:    table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  hook,
+		Priority: nftables.ChainPriorityFilter,
+	}
+	if !chainExists(logger, conn, table, chainName) {
 		conn.AddChain(chain)
 		err = conn.Flush()
 	}
@@ -652,7 +1556,23 @@ func getOrCreateNATPostRoutingChain(logger *zap.SugaredLogger, conn *nftables.Co
 		Hooknum:  nftables.ChainHookPostrouting,
 		Priority: nftables.ChainPriorityNATSource,
 	}
-	if !chainExists(logger, conn, chainName) {
+	if !chainExists(logger, conn, table, chainName) {
+		conn.AddChain(chain)
+		err = conn.Flush()
+	}
+	return chain, err
+}
+
+func getOrCreateNATPreRoutingChain(logger *zap.SugaredLogger, conn *nftables.Conn, table *nftables.Table, chainName string) (*nftables.Chain, error) {
+	var err error
+	chain := &nftables.Chain{
+		Name:     chainName,
+		Table:    table,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookPrerouting,
+		Priority: nftables.ChainPriorityNATDest,
+	}
+	if !chainExists(logger, conn, table, chainName) {
 		conn.AddChain(chain)
 		err = conn.Flush()
 	}
@@ -660,22 +1580,69 @@ func getOrCreateNATPostRoutingChain(logger *zap.SugaredLogger, conn *nftables.Co
 }
 
 func deleteTable(logger *zap.SugaredLogger, conn *nftables.Conn, tableName string) error {
-	// Delete the table and all its chains and rules.
+	// Delete the table and all its chains and rules, in every family since the family is unspecified.
 	conn.DelTable(&nftables.Table{Name: tableName})
 	err := conn.Flush()
 	if err != nil {
 		return fmt.Errorf("failed to flush nft: %v", err)
 	}
-	if tableExists(logger, conn, tableName) {
+	if tableExists(logger, conn, tableName, nftables.TableFamilyUnspecified) {
 		return fmt.Errorf("nft table %q not deleted", defaultTableName)
 	}
 	logger.Infof("NFT table %q deleted", tableName)
 	return nil
 }
 
-// Clean deletes the nftables table and therefore all its chains and rules.
+// Health reports the rules as unhealthy if the table or chain have been removed, e.g. by "nft flush ruleset" or a
+// firewall reload, since packets would no longer be queued for filtering until Reconcile repairs them.
+func (q *Rules) Health() models.SubsystemHealth {
+	q.mu.Lock()
+	tables, err := q.conn.ListTables()
+	var chains []*nftables.Chain
+	if err == nil {
+		chains, err = q.conn.ListChains()
+	}
+	details := map[string]any{"table": q.tableName, "localIPs": len(q.localIPs), "remoteIPs": len(q.remoteIPs), "exemptIPs": len(q.exemptIPs), "repairs": q.repairs}
+	if q.setSampled != nil {
+		details["sampledIPs"] = len(q.sampledIPs)
+	}
+	q.mu.Unlock()
+
+	h := models.SubsystemHealth{Name: "nft", Status: models.HealthOK, Details: details}
+	if err != nil {
+		h.Status = models.HealthUnhealthy
+		h.Message = fmt.Sprintf("failed to list nftables: %v", err)
+	} else if msg := missingRules(tables, chains, q.tableName, q.chainName); msg != "" {
+		h.Status = models.HealthUnhealthy
+		h.Message = msg
+	}
+	return h
+}
+
+// missingRules describes which of the table and chain are missing, or returns "" if both are present.
+func missingRules(tables []*nftables.Table, chains []*nftables.Chain, tableName, chainName string) string {
+	if !slices.ContainsFunc(tables, func(t *nftables.Table) bool { return t.Name == tableName }) {
+		return fmt.Sprintf("table %q is missing", tableName)
+	}
+	inTable := func(c *nftables.Chain) bool {
+		return c.Table != nil && c.Table.Name == tableName && c.Name == chainName
+	}
+	if !slices.ContainsFunc(chains, inTable) {
+		return fmt.Sprintf("chain %q is missing from table %q", chainName, tableName)
+	}
+	return ""
+}
+
+// Clean deletes the nftables table and therefore all its chains and rules. The table isn't put back by Reconcile or
+// the updates that follow, so that traffic isn't queued again once the app has stopped filtering it.
 func (q *Rules) Clean(logger *zap.SugaredLogger) error {
-	return deleteTable(logger, q.conn, q.table.Name)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := deleteTable(logger, q.conn, q.table.Name); err != nil {
+		return err
+	}
+	q.removed = true
+	return nil
 }
 
 // // getDiffAMinusB returns all elements in a that are not in b.
@@ -1,12 +1,22 @@
 package nft
 
 import (
+	"encoding/binary"
+	"flag"
 	"fmt"
+	"io"
+	"maps"
 	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/google/nftables"
+	"github.com/mdlayher/netlink"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
 	"relloyd/tubetimeout/config"
 	"relloyd/tubetimeout/models"
 )
@@ -19,7 +29,7 @@ func cleanupFunc() {
 func Test_New(t *testing.T) {
 	t.Cleanup(cleanupFunc)
 	defaultTableName = "test_table"
-	nfq, err := NewNFTRules(config.MustGetLogger(), &config.FilterConfig{})
+	nfq, err := NewNFTRules(config.MustGetLogger(), &config.FilterConfig{UDPPorts: testUDPPorts}, nil)
 	assert.NoError(t, err, "NewNFTRules() error = %v", err)
 	assert.NotNil(t, nfq, "NewNFTRules() returned nil")
 	assert.NotNil(t, nfq.conn, "NewNFTRules() conn is nil")
@@ -35,14 +45,14 @@ func Test_addNFTablesRuleForSingleDestAddr(t *testing.T) {
 	t.Cleanup(cleanupFunc)
 	defaultTableName = "test_table"
 
-	rules, err := NewNFTRules(config.MustGetLogger(), &config.FilterConfig{})
+	rules, err := NewNFTRules(config.MustGetLogger(), &config.FilterConfig{UDPPorts: testUDPPorts}, nil)
 	assert.NoError(t, err, "NewNFTRules() error = %v", err)
 
 	// Check length of chain rules.
 	r, err := rules.conn.GetRules(rules.table, rules.chain)
 	t.Log("num rules = ", r)
 	assert.NoError(t, err, "conn.GetRules() error = %v", err)
-	assert.Equal(t, 4, len(r), "expected 4 default rules") // 2 src-dest rules; 2 udp blocking rules
+	assert.Equal(t, 6, len(r), "expected 6 default rules") // 2 kill switch rules; 2 src-dest rules; 2 udp blocking rules
 
 	// Add a single rule.
 	err = rules.addNFTablesRuleForSingleDestAddr("10.20.30.1") // add any old rule
@@ -54,19 +64,19 @@ func Test_addNFTablesRuleForSingleDestAddr(t *testing.T) {
 	r, err = rules.conn.GetRules(rules.table, rules.chain)
 	t.Log("num rules = ", r)
 	assert.NoError(t, err, "conn.GetRules() error = %v", err)
-	assert.Equal(t, 5, len(r), "expected 3 default plus 1 rules = 4") // 2 src-dest rules; 2 udp blocking rules; 1 new rule
+	assert.Equal(t, 7, len(r), "expected 6 default plus 1 rules = 7") // 2 kill switch rules; 2 src-dest rules; 2 udp blocking rules; 1 new rule
 }
 
 func Test_addNFTablesRuleForSets(t *testing.T) {
 	t.Cleanup(cleanupFunc)
 	defaultTableName = "test_table"
 
-	rules, err := NewNFTRules(config.MustGetLogger(), &config.FilterConfig{})
+	rules, err := NewNFTRules(config.MustGetLogger(), &config.FilterConfig{UDPPorts: testUDPPorts}, nil)
 	assert.NoError(t, err, "NewNFTRules() error = %v", err)
 
 	r, err := rules.conn.GetRules(rules.table, rules.chain)
 	assert.NoError(t, err, "conn.GetRules() error = %v", err)
-	assert.Equal(t, 4, len(r), "4 default rules expected") // 2 src-dest rules; 2 udp blocking rule
+	assert.Equal(t, 6, len(r), "6 default rules expected") // 2 kill switch rules; 2 src-dest rules; 2 udp blocking rules
 	for _, v := range r {
 		assert.Equal(t, rules.tableName, v.Table.Name, "rule created for unexpected table")
 		assert.Equal(t, rules.chainName, v.Chain.Name, "rule created for unexpected chain")
@@ -126,14 +136,484 @@ func Test_Clean(t *testing.T) {
 
 	logger := config.MustGetLogger()
 
-	rules, err := NewNFTRules(logger, &config.FilterConfig{})
+	rules, err := NewNFTRules(logger, &config.FilterConfig{UDPPorts: testUDPPorts}, nil)
 	assert.NoError(t, err, "NewNFTRules() error = %v", err)
 
 	err = rules.Clean(logger)
 	assert.NoError(t, err, "Clean() error = %v", err)
 
 	// Check tables.
-	if tableExists(logger, rules.conn, rules.tableName) {
+	if tableExists(logger, rules.conn, rules.tableName, nftables.TableFamilyUnspecified) {
 		t.Errorf("Table %v found when it should be gone", rules.tableName)
 	}
+
+	// Expect the table not to be put back once it's been removed.
+	repaired, err := rules.Reconcile()
+	assert.NoError(t, err)
+	assert.False(t, repaired)
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}})
+	assert.False(t, tableExists(logger, rules.conn, rules.tableName, nftables.TableFamilyUnspecified), "expected the table to stay removed")
+}
+
+func Test_missingRules(t *testing.T) {
+	table := &nftables.Table{Name: "tubetimeout"}
+	tables := []*nftables.Table{{Name: "other"}, table}
+	chains := []*nftables.Chain{{Name: "filter", Table: table}}
+
+	assert.Empty(t, missingRules(tables, chains, "tubetimeout", "filter"))
+	assert.Contains(t, missingRules(tables[:1], chains, "tubetimeout", "filter"), "table")
+	assert.Contains(t, missingRules(tables, nil, "tubetimeout", "filter"), "chain")
+	assert.Contains(t, missingRules(tables, []*nftables.Chain{{Name: "filter", Table: tables[0]}}, "tubetimeout", "filter"), "chain", "expected a chain in another table not to count")
+}
+
+func Test_readiness(t *testing.T) {
+	assert.Equal(t, models.ReadinessWaitingForSources, readiness(false, 0, 0).State)
+	assert.Equal(t, models.ReadinessWaitingForDestinations, readiness(false, 2, 0).State)
+	assert.Equal(t, models.ReadinessReady, readiness(true, 2, 5).State)
+	assert.Equal(t, models.ReadinessReady, readiness(true, 2, 0).State, "expected filled sets to stay ready when later updates are empty")
+}
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// testUDPPorts are the UDP ports queued by the rules in the golden files.
+var testUDPPorts = []uint16{500, 4500, 443}
+
+// assertGolden compares got with testdata/name, or rewrites the file when the tests are run with -update.
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		assert.NoError(t, os.MkdirAll("testdata", 0755))
+		assert.NoError(t, os.WriteFile(path, []byte(got), 0644))
+	}
+	want, err := os.ReadFile(path)
+	assert.NoError(t, err, "run the tests with -update to create the golden file")
+	assert.Equal(t, string(want), got, "output differs from %v; run the tests with -update if the change is intended", path)
+}
+
+// recordingConn returns a conn that sends nothing to the kernel and renders each message it would have sent to out.
+// Lists return nothing, as for a system without our table.
+func recordingConn(t *testing.T, out *strings.Builder) *nftables.Conn {
+	return fakeKernel{}.conn(t, out)
+}
+
+// fakeKernel is the state of the kernel seen by a recording conn.
+type fakeKernel struct {
+	tables map[nftables.TableFamily][]string // tables are the names of the tables listed in each family.
+	reject func(msg netlink.Message) bool    // reject returns true for messages to fail, as a kernel without support would.
+}
+
+// conn returns a recording conn for the kernel.
+func (k fakeKernel) conn(t *testing.T, out *strings.Builder) *nftables.Conn {
+	conn, err := nftables.New(nftables.WithTestDial(func(req []netlink.Message) ([]netlink.Message, error) {
+		for _, msg := range req {
+			renderMessage(out, msg)
+		}
+		if len(req) == 1 && req[0].Header.Flags&netlink.Dump != 0 { // if this is a list...
+			return k.list(req[0])
+		}
+		var acks []netlink.Message
+		for _, msg := range req {
+			if msg.Header.Flags&netlink.Acknowledge != 0 {
+				errno, code := make([]byte, 4), int32(unix.EOPNOTSUPP)
+				if k.reject != nil && k.reject(msg) {
+					binary.NativeEndian.PutUint32(errno, uint32(-code)) // errors are acks with a negative errno.
+				}
+				acks = append(acks, netlink.Message{Header: netlink.Header{Type: netlink.Error, Sequence: msg.Header.Sequence, PID: msg.Header.PID}, Data: errno})
+			}
+		}
+		return acks, nil
+	}))
+	assert.NoError(t, err)
+	return conn
+}
+
+// list replies to a list of tables with those in the family requested, or all families if it's unspecified.
+// Other lists return nothing.
+func (k fakeKernel) list(req netlink.Message) ([]netlink.Message, error) {
+	family := nftables.TableFamily(req.Data[0])
+	if uint16(req.Header.Type)&0xff != unix.NFT_MSG_GETTABLE {
+		return nil, io.EOF
+	}
+	var msgs []netlink.Message
+	for _, f := range slices.Sorted(maps.Keys(k.tables)) {
+		if family != nftables.TableFamilyUnspecified && family != f {
+			continue
+		}
+		for _, name := range k.tables[f] {
+			ae := netlink.NewAttributeEncoder()
+			ae.String(unix.NFTA_TABLE_NAME, name)
+			attrs, err := ae.Encode()
+			if err != nil {
+				return nil, err
+			}
+			msgs = append(msgs, netlink.Message{
+				Header: netlink.Header{Type: netlink.HeaderType(unix.NFNL_SUBSYS_NFTABLES<<8 | unix.NFT_MSG_NEWTABLE), Sequence: req.Header.Sequence, PID: req.Header.PID},
+				Data:   append([]byte{byte(f), unix.NFNETLINK_V0, 0, 0}, attrs...),
+			})
+		}
+	}
+	if len(msgs) == 0 {
+		return nil, io.EOF
+	}
+	return msgs, nil
+}
+
+// renderMessage writes the nftables message type and its attributes as a tree, with set IDs masked since they
+// are allocated from a counter shared by all conns.
+func renderMessage(out *strings.Builder, msg netlink.Message) {
+	msgType := uint16(msg.Header.Type)
+	names := map[uint16]string{
+		unix.NFNL_MSG_BATCH_BEGIN: "BATCH_BEGIN",
+		unix.NFNL_MSG_BATCH_END:   "BATCH_END",
+	}
+	nftNames := map[uint16]string{
+		unix.NFT_MSG_NEWTABLE: "NEWTABLE", unix.NFT_MSG_GETTABLE: "GETTABLE", unix.NFT_MSG_DELTABLE: "DELTABLE",
+		unix.NFT_MSG_NEWCHAIN: "NEWCHAIN", unix.NFT_MSG_GETCHAIN: "GETCHAIN",
+		unix.NFT_MSG_NEWRULE: "NEWRULE", unix.NFT_MSG_GETRULE: "GETRULE",
+		unix.NFT_MSG_NEWSET: "NEWSET", unix.NFT_MSG_NEWSETELEM: "NEWSETELEM", unix.NFT_MSG_GETSETELEM: "GETSETELEM",
+		unix.NFT_MSG_DELSETELEM: "DELSETELEM",
+	}
+	name, ok := names[msgType]
+	if msgType>>8 == unix.NFNL_SUBSYS_NFTABLES {
+		name, ok = nftNames[msgType&0xff]
+	}
+	if !ok {
+		name = fmt.Sprintf("type %#x", msgType)
+	}
+	if len(msg.Data) < 4 {
+		fmt.Fprintf(out, "%v\n", name)
+		return
+	}
+	fmt.Fprintf(out, "%v family=%v\n", name, msg.Data[0]) // skip the nfgenmsg header.
+
+	mask := map[uint16]uint16{
+		unix.NFT_MSG_NEWSET:     unix.NFTA_SET_ID,
+		unix.NFT_MSG_NEWSETELEM: unix.NFTA_SET_ELEM_LIST_SET_ID,
+		unix.NFT_MSG_DELSETELEM: unix.NFTA_SET_ELEM_LIST_SET_ID,
+	}
+	maskAttr, masked := mask[msgType&0xff]
+	renderAttributes(out, msg.Data[4:], 1, func(typ uint16) bool { return masked && typ == maskAttr })
+}
+
+func renderAttributes(out *strings.Builder, data []byte, depth int, isMasked func(typ uint16) bool) {
+	ad, err := netlink.NewAttributeDecoder(data)
+	if err != nil {
+		fmt.Fprintf(out, "%v%x\n", strings.Repeat("  ", depth), data)
+		return
+	}
+	for ad.Next() {
+		indent := strings.Repeat("  ", depth)
+		typ, b := ad.Type(), ad.Bytes()
+		switch {
+		case isMasked(typ):
+			fmt.Fprintf(out, "%vattr %v: <id>\n", indent, typ)
+		case ad.TypeFlags()&netlink.Nested != 0:
+			fmt.Fprintf(out, "%vattr %v:\n", indent, typ)
+			renderAttributes(out, b, depth+1, func(uint16) bool { return false })
+		case len(b) > 1 && b[len(b)-1] == 0 && isPrintable(b[:len(b)-1]):
+			fmt.Fprintf(out, "%vattr %v: %q\n", indent, typ, b[:len(b)-1])
+		default:
+			fmt.Fprintf(out, "%vattr %v: %x\n", indent, typ, b)
+		}
+	}
+}
+
+func isPrintable(b []byte) bool {
+	for _, c := range b {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func Test_newNFTRules_Golden(t *testing.T) {
+	defaultTableName = "tubetimeout-table"
+	var out strings.Builder
+	rules, err := newNFTRules(config.MustGetLogger(), &config.FilterConfig{UDPPorts: testUDPPorts, OutboundQueueNumber: 100, InboundQueueNumber: 101}, nil, recordingConn(t, &out))
+	assert.NoError(t, err)
+	assertGolden(t, "rules.golden", out.String())
+
+	out.Reset()
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}, "192.168.1.11": {"kids"}})
+	rules.UpdateDestIpDomains(models.MapIpDomain{"142.250.1.1": "youtube.com"})
+	assertGolden(t, "sets.golden", out.String())
+}
+
+type mockPacketSampler map[models.Group]bool
+
+func (m mockPacketSampler) IsPacketSampled(id string) bool {
+	return m[models.Group(id)]
+}
+
+func Test_newNFTRules_GoldenSampling(t *testing.T) {
+	defaultTableName = "tubetimeout-table"
+	sampler := mockPacketSampler{"kids": true, "teens": false}
+	var out strings.Builder
+	cfg := &config.FilterConfig{UDPPorts: testUDPPorts, OutboundQueueNumber: 100, InboundQueueNumber: 101, SampleRate: 10}
+	rules, err := newNFTRules(config.MustGetLogger(), cfg, sampler, recordingConn(t, &out))
+	assert.NoError(t, err)
+	assertGolden(t, "rules-sampling.golden", out.String())
+
+	out.Reset()
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}, "192.168.1.11": {"kids", "teens"}})
+	rules.UpdateDestIpDomains(models.MapIpDomain{"142.250.1.1": "youtube.com"})
+	assertGolden(t, "sets-sampling.golden", out.String())
+	assert.Equal(t, 10, rules.SampleRate("192.168.1.10"))
+	assert.Equal(t, 1, rules.SampleRate("192.168.1.11"), "expected an IP in a group that isn't sampled to see every packet")
+
+	// Queue every packet once the group needs blocking.
+	sampler["kids"] = false
+	rules.UpdateThresholdState("kids", true)
+	assert.Equal(t, 1, rules.SampleRate("192.168.1.10"))
+	assert.Empty(t, rules.sampledIPs)
+}
+
+func Test_newNFTRules_GoldenLocalDevice(t *testing.T) {
+	defaultTableName = "tubetimeout-table"
+	var out strings.Builder
+	cfg := &config.FilterConfig{UDPPorts: testUDPPorts, OutboundQueueNumber: 100, InboundQueueNumber: 101, LocalDevice: true}
+	rules, err := newNFTRules(config.MustGetLogger(), cfg, nil, recordingConn(t, &out))
+	assert.NoError(t, err)
+	assert.Len(t, rules.localChains, 2)
+	assertGolden(t, "rules-local.golden", out.String())
+}
+
+func Test_UpdateKillSwitch_Golden(t *testing.T) {
+	defaultTableName = "tubetimeout-table"
+	var out strings.Builder
+	rules, err := newNFTRules(config.MustGetLogger(), &config.FilterConfig{UDPPorts: testUDPPorts, OutboundQueueNumber: 100, InboundQueueNumber: 101}, nil, recordingConn(t, &out))
+	assert.NoError(t, err)
+
+	// Expect the local IPs to be added to the kill switch set, even before the remote IPs are known.
+	out.Reset()
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}})
+	rules.UpdateKillSwitch(true)
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}, "192.168.1.11": {"kids"}})
+	rules.UpdateKillSwitch(false)
+	assertGolden(t, "kill-switch.golden", out.String())
+}
+
+func Test_UpdateFailOpen_Golden(t *testing.T) {
+	defaultTableName = "tubetimeout-table"
+	var out strings.Builder
+	cfg := &config.FilterConfig{UDPPorts: testUDPPorts, OutboundQueueNumber: 100, InboundQueueNumber: 101, Backpressure: backpressureFailOpen}
+	rules, err := newNFTRules(config.MustGetLogger(), cfg, nil, recordingConn(t, &out))
+	assert.NoError(t, err)
+	assert.NotNil(t, rules.setFailOpen)
+	assertGolden(t, "rules-fail-open.golden", out.String())
+
+	// Expect new local IPs to bypass the queues too while the filter has failed open.
+	out.Reset()
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}})
+	rules.UpdateFailOpen(true)
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}, "192.168.1.11": {"kids"}})
+	rules.UpdateFailOpen(false)
+	assertGolden(t, "fail-open.golden", out.String())
+
+	// Expect nothing to change for the other modes since there's no set to fill.
+	out.Reset()
+	cfg.Backpressure = "reduce"
+	rules, err = newNFTRules(config.MustGetLogger(), cfg, nil, recordingConn(t, &out))
+	assert.NoError(t, err)
+	out.Reset()
+	rules.UpdateFailOpen(true)
+	assert.Empty(t, out.String())
+}
+
+func Test_UpdateBypassBlocked_Golden(t *testing.T) {
+	defaultTableName = "tubetimeout-table"
+	var out strings.Builder
+	cfg := &config.FilterConfig{
+		UDPPorts:            testUDPPorts,
+		OutboundQueueNumber: 100,
+		InboundQueueNumber:  101,
+		BypassDetection:     true,
+		BypassPorts:         []uint16{853, 51820},
+		BypassResolverIPs:   []string{"1.1.1.1"},
+		BypassBlock:         true,
+	}
+	rules, err := newNFTRules(config.MustGetLogger(), cfg, nil, recordingConn(t, &out))
+	assert.NoError(t, err)
+	assert.NotNil(t, rules.setBypassBlocked)
+	assertGolden(t, "rules-bypass.golden", out.String())
+
+	out.Reset()
+	rules.UpdateBypassBlocked([]models.Ip{"192.168.1.10"})
+	rules.UpdateBypassBlocked(nil)
+	assertGolden(t, "bypass.golden", out.String())
+}
+
+func Test_newNFTRules_GoldenDNSInspection(t *testing.T) {
+	defaultTableName = "tubetimeout-table"
+	var out strings.Builder
+	cfg := &config.FilterConfig{UDPPorts: testUDPPorts, OutboundQueueNumber: 100, InboundQueueNumber: 101, Backpressure: backpressureFailOpen, DNSInspection: true}
+	rules, err := newNFTRules(config.MustGetLogger(), cfg, nil, recordingConn(t, &out))
+	assert.NoError(t, err)
+	assert.Contains(t, rules.want.chains, defaultDNSOutputChainName, "expected the gateway's own DNS answers to be queued")
+	assertGolden(t, "rules-dns-inspection.golden", out.String())
+}
+
+func Test_newNFTRules_GoldenLAN(t *testing.T) {
+	defaultTableName = "tubetimeout-table"
+	var out strings.Builder
+	cfg := &config.FilterConfig{UDPPorts: testUDPPorts, OutboundQueueNumber: 100, InboundQueueNumber: 101, DNSInspection: true, LocalDevice: true, LANCIDRs: []string{"192.168.0.0/16", "10.0.0.0/8"}}
+	rules, err := newNFTRules(config.MustGetLogger(), cfg, nil, recordingConn(t, &out))
+	assert.NoError(t, err)
+	assert.Len(t, rules.lanNets, 2)
+	assertGolden(t, "rules-lan.golden", out.String())
+
+	cfg.LANCIDRs = []string{"fd00::/8"}
+	_, err = newNFTRules(config.MustGetLogger(), cfg, nil, recordingConn(t, &strings.Builder{}))
+	assert.Error(t, err, "expected IPv6 networks to be rejected")
+}
+
+func Test_intervalElements(t *testing.T) {
+	_, lan, _ := net.ParseCIDR("192.168.0.0/16")
+	_, top, _ := net.ParseCIDR("255.255.255.0/24")
+	assert.Equal(t, []nftables.SetElement{
+		{Key: net.IP{192, 168, 0, 0}},
+		{Key: net.IP{192, 169, 0, 0}, IntervalEnd: true},
+		{Key: net.IP{255, 255, 255, 0}},
+	}, intervalElements([]*net.IPNet{lan, top}), "expected the end of the last network to be left open")
+}
+
+func Test_newNFTRules_BadBypassIP(t *testing.T) {
+	cfg := &config.FilterConfig{UDPPorts: testUDPPorts, BypassDetection: true, BypassResolverIPs: []string{"dns.google"}}
+	_, err := newNFTRules(config.MustGetLogger(), cfg, nil, recordingConn(t, &strings.Builder{}))
+	assert.Error(t, err)
+}
+
+func Test_UpdateThresholdState_GoldenBlockPage(t *testing.T) {
+	defaultTableName = "tubetimeout-table"
+	var out strings.Builder
+	cfg := &config.FilterConfig{UDPPorts: testUDPPorts, OutboundQueueNumber: 100, InboundQueueNumber: 101, BlockPagePort: 8081}
+	rules, err := newNFTRules(config.MustGetLogger(), cfg, nil, recordingConn(t, &out))
+	assert.NoError(t, err)
+	assertGolden(t, "rules-block-page.golden", out.String())
+
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}, "192.168.1.11": {"kids", "teens"}})
+	rules.UpdateDestIpDomains(models.MapIpDomain{"142.250.1.1": "youtube.com"})
+
+	// Expect only the IP whose groups are all blocked to see the block page.
+	out.Reset()
+	rules.UpdateThresholdState("kids", true)
+	rules.UpdateThresholdState("teens", false)
+	rules.UpdateThresholdState("kids", false)
+	assertGolden(t, "blocked.golden", out.String())
+	assert.Empty(t, rules.blockedIPs)
+
+	rules.UpdateThresholdState("kids", true)
+	rules.UpdateThresholdState("teens", true)
+	assert.Len(t, rules.blockedIPs, 2)
+}
+
+func Test_UpdateExemptions_Golden(t *testing.T) {
+	defaultTableName = "tubetimeout-table"
+	var out strings.Builder
+	cfg := &config.FilterConfig{UDPPorts: testUDPPorts, OutboundQueueNumber: 100, InboundQueueNumber: 101, BlockPagePort: 8081}
+	rules, err := newNFTRules(config.MustGetLogger(), cfg, nil, recordingConn(t, &out))
+	assert.NoError(t, err)
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}, "192.168.1.11": {"kids"}, "192.168.1.12": {"kids"}})
+	rules.UpdateDestIpDomains(models.MapIpDomain{"142.250.1.1": "youtube.com"})
+	rules.UpdateThresholdState("kids", true)
+	assert.Len(t, rules.blockedIPs, 3)
+
+	// Expect the IPs exempt by IP, and by MAC once the MACs of the IPs are known, to move to the exempt set.
+	out.Reset()
+	rules.UpdateExemptions([]models.Exemption{{MAC: "AA-BB-CC-DD-EE-FF", Name: "Work laptop"}, {IP: "192.168.1.12"}})
+	rules.UpdateSourceIpMACs(models.MapIpMACs{"192.168.1.10": "11-22-33-44-55-66", "192.168.1.11": "aa:bb:cc:dd:ee:ff"})
+	assertGolden(t, "exempt.golden", out.String())
+	assert.Len(t, rules.exemptIPs, 2)
+	assert.Equal(t, []nftables.SetElement{{Key: net.ParseIP("192.168.1.10").To4()}}, rules.localIPs)
+	assert.Equal(t, models.MapIpGroups{"192.168.1.10": {"kids"}}, rules.srcIpGroups)
+	assert.Len(t, rules.blockedIPs, 1, "expected the exempt IPs not to see the block page")
+
+	// Expect the exempt IPs to stay out of the local IP set when the source IPs change, and to be put back once the
+	// devices are no longer exempt.
+	rules.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.10": {"kids"}, "192.168.1.11": {"kids"}, "192.168.1.12": {"kids"}, "192.168.1.13": {"kids"}})
+	assert.Len(t, rules.localIPs, 2)
+	rules.UpdateExemptions(nil)
+	assert.Empty(t, rules.exemptIPs)
+	assert.Len(t, rules.localIPs, 4)
+}
+
+func Test_newNFTRules_GoldenFallback(t *testing.T) {
+	defaultTableName = "tubetimeout-table"
+	var out strings.Builder
+	k := fakeKernel{reject: func(msg netlink.Message) bool { // reject NAT chains in inet tables, as before Linux 5.2.
+		return uint16(msg.Header.Type)&0xff == unix.NFT_MSG_NEWCHAIN && msg.Data[0] == unix.NFPROTO_INET
+	}}
+	rules, err := newNFTRules(config.MustGetLogger(), &config.FilterConfig{UDPPorts: testUDPPorts, OutboundQueueNumber: 100, InboundQueueNumber: 101}, nil, k.conn(t, &out))
+	assert.NoError(t, err)
+	assert.Equal(t, nftables.TableFamilyIPv4, rules.table.Family)
+	assertGolden(t, "rules-ip.golden", out.String())
+}
+
+func Test_newNFTRules_UDPMode(t *testing.T) {
+	defaultTableName = "tubetimeout-table"
+	render := func(mode string, ports []uint16) (string, error) {
+		var out strings.Builder
+		cfg := &config.FilterConfig{UDPPorts: ports, UDPMode: mode, OutboundQueueNumber: 100, InboundQueueNumber: 101}
+		_, err := newNFTRules(config.MustGetLogger(), cfg, nil, recordingConn(t, &out))
+		return out.String(), err
+	}
+	queued, err := render("queue", testUDPPorts)
+	assert.NoError(t, err)
+	assert.Contains(t, queued, `"udp_ports"`)
+
+	// Expect drop to replace the two UDP queue rules with drops, and accept to leave UDP to the other rules.
+	dropped, err := render("drop", testUDPPorts)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Count(queued, `"queue"`)-2, strings.Count(dropped, `"queue"`))
+	assert.Contains(t, dropped, `"udp_ports"`)
+	for _, out := range []func() (string, error){
+		func() (string, error) { return render("accept", testUDPPorts) },
+		func() (string, error) { return render("queue", nil) },
+	} {
+		accepted, err := out()
+		assert.NoError(t, err)
+		assert.NotContains(t, accepted, `"udp_ports"`)
+	}
+
+	_, err = render("throttle", testUDPPorts)
+	assert.Error(t, err)
+}
+
+func Test_chooseTableFamily(t *testing.T) {
+	logger := config.MustGetLogger()
+	var out strings.Builder
+	conn := fakeKernel{}.conn(t, &out)
+	for setting, want := range map[string]nftables.TableFamily{"inet": nftables.TableFamilyINet, "ip": nftables.TableFamilyIPv4, "auto": nftables.TableFamilyINet} {
+		got, err := chooseTableFamily(logger, conn, setting, "tubetimeout-table")
+		assert.NoError(t, err)
+		assert.Equal(t, want, got, setting)
+	}
+	_, err := chooseTableFamily(logger, conn, "ip6", "tubetimeout-table")
+	assert.Error(t, err)
+
+	// Expect an inet table from an earlier run to be used without probing the kernel again.
+	out.Reset()
+	conn = fakeKernel{tables: map[nftables.TableFamily][]string{nftables.TableFamilyINet: {"tubetimeout-table"}}}.conn(t, &out)
+	got, err := chooseTableFamily(logger, conn, "auto", "tubetimeout-table")
+	assert.NoError(t, err)
+	assert.Equal(t, nftables.TableFamilyINet, got)
+	assert.NotContains(t, out.String(), "BATCH_BEGIN")
+}
+
+func Test_removeOtherFamilies(t *testing.T) {
+	defaultTableName = "tubetimeout-table"
+	var out strings.Builder
+	k := fakeKernel{tables: map[nftables.TableFamily][]string{nftables.TableFamilyIPv4: {"tubetimeout-table", "other"}}}
+	rules := &Rules{logger: config.MustGetLogger(), conn: k.conn(t, &out), tableName: defaultTableName, family: nftables.TableFamilyINet}
+
+	// Expect the ip table left by an earlier version to be deleted once the inet table is used.
+	assert.NoError(t, rules.removeOtherFamilies())
+	assert.Contains(t, out.String(), "DELTABLE family=2\n  attr 1: \"tubetimeout-table\"\n")
+
+	out.Reset()
+	rules.family = nftables.TableFamilyIPv4
+	assert.NoError(t, rules.removeOtherFamilies())
+	assert.NotContains(t, out.String(), "DELTABLE", "expected the table in use to be kept")
 }
@@ -0,0 +1,247 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+const (
+	queueSize   = 100              // queueSize is the number of notifications waiting to be sent before more are dropped.
+	sendTimeout = 10 * time.Second // sendTimeout is how long each provider has to send a notification.
+)
+
+var (
+	defaultSettingsFilePath = "notifications.yaml"
+	defaultThresholds       = []int{80, 100}
+	fnGetSettings           = config.GetConfig[Settings]
+)
+
+func init() {
+	config.Backups.Register(defaultSettingsFilePath, "notification providers")
+}
+
+// EventKind is the type of thing a notification is about.
+type EventKind string
+
+const (
+	EventThreshold = EventKind("threshold") // EventThreshold is sent when a group uses one of the percentages of its threshold.
+	EventMode      = EventKind("mode")      // EventMode is sent when a group is blocked or allowed manually.
+	EventDHCP      = EventKind("dhcp")      // EventDHCP is sent when the state of the local DHCP service changes.
+	EventReport    = EventKind("report")    // EventReport is sent with each group's weekly usage report.
+	EventBypass    = EventKind("bypass")    // EventBypass is sent when a tracked device tries to get around the filter, e.g. with a VPN.
+	EventRequest   = EventKind("request")   // EventRequest is sent when a device asks for more time.
+	EventWarning   = EventKind("warning")   // EventWarning is sent when a group is nearly out of time and is being slowed.
+	EventFailsafe  = EventKind("failsafe")  // EventFailsafe is sent when the watchdog removes the firewall rules so that traffic fails open.
+)
+
+// Event is a notification sent to every provider.
+type Event struct {
+	Kind    EventKind    `json:"kind"`
+	Time    time.Time    `json:"time"`
+	Group   models.Group `json:"group,omitempty"`
+	Message string       `json:"message"`
+	HTML    string       `json:"html,omitempty"` // HTML is a richer version of the message sent by email, e.g. a report.
+}
+
+// Settings is the notifications.yaml file in the app's home directory. Notifications are off without any providers.
+type Settings struct {
+	Thresholds []int            `yaml:"thresholds"` // Thresholds are the percentages of a group's threshold to notify at, 80 and 100 if empty.
+	Events     []EventKind      `yaml:"events"`     // Events are the kinds of event to send, or all if empty.
+	Providers  []ProviderConfig `yaml:"providers"`
+}
+
+// Provider sends notifications somewhere, e.g. to a Telegram chat.
+type Provider interface {
+	Send(ctx context.Context, e Event) error
+}
+
+// Notifier sends notifications about usage thresholds, manual mode changes, the DHCP service, bypass attempts, requests for more time, the watchdog and weekly reports to the providers
+// configured. Events are queued and sent by a worker so that the receivers never block their callers.
+type Notifier struct {
+	logger    *zap.SugaredLogger
+	settings  Settings
+	providers []Provider
+	events    chan Event
+	mu        sync.Mutex
+	levels    map[models.Group]int // levels are the highest threshold percentage each group has reached, guarded by mu.
+	nowFunc   func() time.Time
+}
+
+// NewNotifier loads the notification settings and starts a worker to send them until ctx is done.
+func NewNotifier(ctx context.Context, logger *zap.SugaredLogger) (*Notifier, error) {
+	n := &Notifier{
+		logger:  logger,
+		events:  make(chan Event, queueSize),
+		levels:  make(map[models.Group]int),
+		nowFunc: time.Now,
+	}
+
+	var err error
+	n.settings, err = fnGetSettings(&n.mu, defaultSettingsFilePath, func() Settings { return Settings{} })
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification settings: %w", err)
+	}
+	if len(n.settings.Thresholds) == 0 {
+		n.settings.Thresholds = defaultThresholds
+	}
+	slices.Sort(n.settings.Thresholds)
+	for i, pc := range n.settings.Providers {
+		p, err := newProvider(pc)
+		if err != nil {
+			return nil, fmt.Errorf("notification provider %v: %w", i+1, err)
+		}
+		n.providers = append(n.providers, p)
+	}
+
+	if len(n.providers) > 0 {
+		logger.Infof("Notifications will be sent to %v providers", len(n.providers))
+		go n.startWorker(ctx)
+	}
+	return n, nil
+}
+
+func (n *Notifier) startWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-n.events:
+			n.send(ctx, e)
+		}
+	}
+}
+
+// send sends the event to every provider, logging those that fail.
+func (n *Notifier) send(ctx context.Context, e Event) {
+	for i, p := range n.providers {
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		if err := p.Send(sendCtx, e); err != nil {
+			n.logger.Warnf("Notification provider %v failed to send %v event: %v", i+1, e.Kind, err)
+		}
+		cancel()
+	}
+}
+
+// notify queues the event unless its kind is turned off, or drops it if the queue is full.
+func (n *Notifier) notify(kind EventKind, group models.Group, msg string) {
+	n.queue(Event{Kind: kind, Time: n.nowFunc(), Group: group, Message: msg})
+}
+
+func (n *Notifier) queue(e Event) {
+	if len(n.providers) == 0 || (len(n.settings.Events) > 0 && !slices.Contains(n.settings.Events, e.Kind)) {
+		return
+	}
+	select {
+	case n.events <- e:
+	default:
+		n.logger.Warnf("Notification queue is full, dropping %v event: %v", e.Kind, e.Message)
+	}
+}
+
+// PublishEvent implements models.LiveEventReceiver to notify when a group's usage reaches each threshold percentage.
+// The level each group is at when first seen isn't notified, so restarting doesn't repeat notifications, and a level
+// is notified again only after usage drops below it, e.g. when the next window starts.
+func (n *Notifier) PublishEvent(e models.LiveEvent) {
+	summaries, ok := e.Data.(map[string]*models.TrackerSummary)
+	if e.Type != models.LiveEventSummary || !ok {
+		return
+	}
+	for id, s := range summaries {
+		if s == nil {
+			continue
+		}
+		group, level := models.Group(id), n.level(s.Percentage)
+		n.mu.Lock()
+		prev, seen := n.levels[group]
+		n.levels[group] = level
+		n.mu.Unlock()
+		if seen && level > prev {
+			n.notify(EventThreshold, group, fmt.Sprintf("%v has used %v%% of its time (%v of %v minutes)", group, level, s.Used, s.Threshold))
+		}
+	}
+}
+
+// level returns the highest threshold percentage reached, or zero if none are.
+func (n *Notifier) level(percentage int) int {
+	level := 0
+	for _, t := range n.settings.Thresholds {
+		if percentage >= t {
+			level = t
+		}
+	}
+	return level
+}
+
+// UpdateModeTransition implements models.ModeTransitionReceiver to notify when a group is blocked or allowed manually.
+func (n *Notifier) UpdateModeTransition(e models.ModeTransition) {
+	if e.Cause != models.TransitionManual {
+		return
+	}
+	n.notify(EventMode, e.Group, fmt.Sprintf("%v was %v", e.Group, e.Reason))
+}
+
+// UpdateWarningState implements models.WarningStateReceiver to notify when a group is nearly out of time. The end of
+// a warning isn't notified since the group is then either blocked or has been given more time.
+func (n *Notifier) UpdateWarningState(group models.Group, warning bool, reason string) {
+	if !warning {
+		return
+	}
+	n.notify(EventWarning, group, fmt.Sprintf("%v is nearly out of time: %v", group, reason))
+}
+
+// UpdateDHCPState implements models.DHCPStateReceiver to notify when the local DHCP service changes state.
+func (n *Notifier) UpdateDHCPState(state string) {
+	n.notify(EventDHCP, "", fmt.Sprintf("The DHCP service is now %v", state))
+}
+
+// UpdateFailsafe is subscribed to the bus's failsafe topic to notify when the watchdog removes the firewall rules
+// because the packet handler stopped responding, since nothing is filtered until the service is restarted.
+func (n *Notifier) UpdateFailsafe(reason string) {
+	n.notify(EventFailsafe, "", fmt.Sprintf("The packet filter stopped responding, so its firewall rules were removed and traffic isn't filtered until the service is restarted: %v", reason))
+}
+
+// UpdateBypassAttempt implements models.BypassAttemptReceiver to notify when a tracked device tries to get around the
+// filter. The event is for the device's first group.
+func (n *Notifier) UpdateBypassAttempt(a models.BypassAttempt) {
+	var group models.Group
+	if len(a.Groups) > 0 {
+		group = a.Groups[0]
+	}
+	msg := fmt.Sprintf("A device in %v (%v) tried to get around the filter: %v to %v port %v", group, a.IP, bypassKindText[a.Kind], a.Remote, a.Port)
+	if a.Blocked {
+		msg += ", and has been blocked from it for a while"
+	}
+	n.notify(EventBypass, group, msg)
+}
+
+// UpdateTimeRequest implements models.TimeRequestReceiver to notify when a device asks for more time, so that the
+// admin can approve or deny it. Decisions aren't notified since the admin made them.
+func (n *Notifier) UpdateTimeRequest(r models.TimeRequest) {
+	if r.Status != models.TimeRequestPending {
+		return
+	}
+	msg := fmt.Sprintf("%v asked for %v more minutes", r.Group, r.Minutes)
+	if r.Reason != "" {
+		msg += ": " + r.Reason
+	}
+	n.notify(EventRequest, r.Group, msg)
+}
+
+// bypassKindText describes each kind of bypass attempt in notifications.
+var bypassKindText = map[models.BypassKind]string{
+	models.BypassDNS: "DNS",
+	models.BypassDoH: "DNS over HTTPS",
+	models.BypassVPN: "VPN",
+}
+
+// SendReport implements report.Sender to send a group's weekly report, as HTML by email and as text otherwise.
+func (n *Notifier) SendReport(group models.Group, text, html string) {
+	n.queue(Event{Kind: EventReport, Time: n.nowFunc(), Group: group, Message: text, HTML: html})
+}
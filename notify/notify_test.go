@@ -0,0 +1,222 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+type mockHTTPClient struct {
+	requests []*http.Request
+	bodies   []string
+}
+
+func (m *mockHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	b, _ := io.ReadAll(req.Body)
+	m.requests = append(m.requests, req)
+	m.bodies = append(m.bodies, string(b))
+	return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+type mockProvider struct{}
+
+func (m *mockProvider) Send(_ context.Context, _ Event) error {
+	return nil
+}
+
+// newTestNotifier returns a notifier without a worker so that the queued events can be read by the test.
+func newTestNotifier(settings Settings) *Notifier {
+	if len(settings.Thresholds) == 0 {
+		settings.Thresholds = defaultThresholds
+	}
+	return &Notifier{
+		logger:    config.MustGetLogger(),
+		settings:  settings,
+		providers: []Provider{&mockProvider{}},
+		events:    make(chan Event, queueSize),
+		levels:    make(map[models.Group]int),
+		nowFunc:   time.Now,
+	}
+}
+
+func queued(n *Notifier) []Event {
+	var retval []Event
+	for len(n.events) > 0 {
+		retval = append(retval, <-n.events)
+	}
+	return retval
+}
+
+func summary(group string, percentage int) models.LiveEvent {
+	return models.LiveEvent{Type: models.LiveEventSummary, Data: map[string]*models.TrackerSummary{
+		group: {Used: percentage * 60 / 100, Percentage: percentage, Threshold: 60},
+	}}
+}
+
+func TestNotifier_Thresholds(t *testing.T) {
+	n := newTestNotifier(Settings{})
+
+	n.PublishEvent(summary("kids", 85))
+	assert.Empty(t, queued(n), "expected the level a group is at when first seen not to be notified")
+
+	n.PublishEvent(summary("kids", 90))
+	n.PublishEvent(summary("kids", 100))
+	events := queued(n)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, EventThreshold, events[0].Kind)
+		assert.Equal(t, models.Group("kids"), events[0].Group)
+		assert.Equal(t, "kids has used 100% of its time (60 of 60 minutes)", events[0].Message)
+	}
+
+	// The next window starts.
+	n.PublishEvent(summary("kids", 0))
+	n.PublishEvent(summary("kids", 80))
+	events = queued(n)
+	if assert.Len(t, events, 1, "expected levels to be notified again after usage drops") {
+		assert.Contains(t, events[0].Message, "80%")
+	}
+
+	n.PublishEvent(models.LiveEvent{Type: models.LiveEventActivity, Data: models.ActivityEvent{Group: "kids"}})
+	assert.Empty(t, queued(n), "expected other live events to be ignored")
+}
+
+func TestNotifier_ModeAndDHCP(t *testing.T) {
+	n := newTestNotifier(Settings{})
+	n.UpdateModeTransition(models.ModeTransition{Group: "kids", Cause: models.TransitionManual, Mode: models.ModeBlock, Reason: "blocked for 1h0m0s"})
+	n.UpdateModeTransition(models.ModeTransition{Group: "kids", Cause: models.TransitionTracker, Blocked: true, Reason: "threshold exceeded"})
+	n.UpdateDHCPState("inactive")
+	events := queued(n)
+	if assert.Len(t, events, 2, "expected transitions made by the tracker to be left to the threshold notifications") {
+		assert.Equal(t, Event{Kind: EventMode, Time: events[0].Time, Group: "kids", Message: "kids was blocked for 1h0m0s"}, events[0])
+		assert.Equal(t, EventDHCP, events[1].Kind)
+		assert.Equal(t, "The DHCP service is now inactive", events[1].Message)
+	}
+
+	n = newTestNotifier(Settings{Events: []EventKind{EventDHCP}})
+	n.UpdateModeTransition(models.ModeTransition{Group: "kids", Cause: models.TransitionManual})
+	n.UpdateDHCPState("active")
+	events = queued(n)
+	if assert.Len(t, events, 1, "expected only the kinds of event configured to be sent") {
+		assert.Equal(t, EventDHCP, events[0].Kind)
+	}
+}
+
+func TestNotifier_UpdateFailsafe(t *testing.T) {
+	n := newTestNotifier(Settings{})
+	n.UpdateFailsafe("queue 100 (out) has had 12 packets waiting for 15s without a verdict")
+	events := queued(n)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, EventFailsafe, events[0].Kind)
+		assert.Contains(t, events[0].Message, "firewall rules were removed")
+		assert.Contains(t, events[0].Message, "queue 100 (out)")
+	}
+}
+
+func TestNotifier_SendReport(t *testing.T) {
+	n := newTestNotifier(Settings{})
+	n.SendReport("kids", "kids used 1h 00m", "<h2>kids</h2>")
+	events := queued(n)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, Event{Kind: EventReport, Time: events[0].Time, Group: "kids", Message: "kids used 1h 00m", HTML: "<h2>kids</h2>"}, events[0])
+	}
+
+	var mail string
+	original := fnSendMail
+	t.Cleanup(func() { fnSendMail = original })
+	fnSendMail = func(_ string, _ smtp.Auth, _ string, _ []string, msg []byte) error {
+		mail = string(msg)
+		return nil
+	}
+	p := &email{cfg: ProviderConfig{SMTPAddr: "smtp.example.com:25", From: "tt@example.com", To: []string{"me@example.com"}}}
+	assert.NoError(t, p.Send(context.Background(), events[0]))
+	assert.Contains(t, mail, "Content-Type: text/html; charset=UTF-8")
+	assert.Contains(t, mail, "<h2>kids</h2>")
+}
+
+func TestNotifier_UpdateBypassAttempt(t *testing.T) {
+	n := newTestNotifier(Settings{})
+	n.UpdateBypassAttempt(models.BypassAttempt{IP: "192.168.1.10", Groups: []models.Group{"kids"}, Kind: models.BypassDoH, Remote: "1.1.1.1", Port: 443, Blocked: true})
+	events := queued(n)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, EventBypass, events[0].Kind)
+		assert.Equal(t, models.Group("kids"), events[0].Group)
+		assert.Equal(t, "A device in kids (192.168.1.10) tried to get around the filter: DNS over HTTPS to 1.1.1.1 port 443, and has been blocked from it for a while", events[0].Message)
+	}
+}
+
+func TestNotifier_UpdateTimeRequest(t *testing.T) {
+	n := newTestNotifier(Settings{})
+	n.UpdateTimeRequest(models.TimeRequest{ID: "a1", Group: "kids", Minutes: 30, Reason: "homework", Status: models.TimeRequestPending})
+	n.UpdateTimeRequest(models.TimeRequest{ID: "a1", Group: "kids", Minutes: 30, Status: models.TimeRequestApproved, Granted: 30})
+	events := queued(n)
+	if assert.Len(t, events, 1, "expected only the pending request to be notified") {
+		assert.Equal(t, EventRequest, events[0].Kind)
+		assert.Equal(t, models.Group("kids"), events[0].Group)
+		assert.Equal(t, "kids asked for 30 more minutes: homework", events[0].Message)
+	}
+}
+
+func TestNotifier_UpdateWarningState(t *testing.T) {
+	n := newTestNotifier(Settings{})
+	n.UpdateWarningState("kids", true, "used 90% of the threshold")
+	n.UpdateWarningState("kids", false, "usage was reset")
+	events := queued(n)
+	if assert.Len(t, events, 1, "expected only the start of the warning to be notified") {
+		assert.Equal(t, EventWarning, events[0].Kind)
+		assert.Equal(t, models.Group("kids"), events[0].Group)
+		assert.Equal(t, "kids is nearly out of time: used 90% of the threshold", events[0].Message)
+	}
+}
+
+func TestNewNotifier_Providers(t *testing.T) {
+	originalGet, originalClient, originalSendMail := fnGetSettings, httpClient, fnSendMail
+	t.Cleanup(func() { fnGetSettings, httpClient, fnSendMail = originalGet, originalClient, originalSendMail })
+
+	settings := Settings{Providers: []ProviderConfig{
+		{Type: "telegram", Token: "123:abc", ChatID: "42"},
+		{Type: "webhook", URL: "http://localhost/hook"},
+		{Type: "email", SMTPAddr: "smtp.example.com:587", Username: "me", Password: "secret", From: "tt@example.com", To: []string{"me@example.com"}},
+	}}
+	fnGetSettings = func(_ *sync.Mutex, _ string, _ func() Settings) (Settings, error) {
+		return settings, nil
+	}
+	client := &mockHTTPClient{}
+	httpClient = client
+	var mails []string
+	fnSendMail = func(addr string, _ smtp.Auth, from string, to []string, msg []byte) error {
+		mails = append(mails, string(msg))
+		return nil
+	}
+
+	n, err := NewNotifier(context.Background(), config.MustGetLogger())
+	assert.NoError(t, err)
+	assert.Len(t, n.providers, 3)
+	n.send(context.Background(), Event{Kind: EventMode, Group: "kids", Message: "kids was blocked for 1h0m0s"})
+
+	if assert.Len(t, client.requests, 2) {
+		assert.Equal(t, "https://api.telegram.org/bot123:abc/sendMessage", client.requests[0].URL.String())
+		assert.JSONEq(t, `{"chat_id":"42","text":"kids was blocked for 1h0m0s"}`, client.bodies[0])
+		assert.Equal(t, "http://localhost/hook", client.requests[1].URL.String())
+		var e Event
+		assert.NoError(t, json.Unmarshal([]byte(client.bodies[1]), &e))
+		assert.Equal(t, models.Group("kids"), e.Group)
+	}
+	if assert.Len(t, mails, 1) {
+		assert.Contains(t, mails[0], "Subject: TubeTimeout mode notification")
+		assert.Contains(t, mails[0], "kids was blocked for 1h0m0s")
+	}
+
+	settings = Settings{Providers: []ProviderConfig{{Type: "telegram"}}}
+	_, err = NewNotifier(context.Background(), config.MustGetLogger())
+	assert.Error(t, err, "expected a provider without its settings to be rejected")
+}
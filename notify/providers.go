@@ -0,0 +1,131 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+var (
+	telegramAPIURL            = "https://api.telegram.org"
+	httpClient     HTTPClient = &http.Client{Timeout: sendTimeout}
+	fnSendMail                = smtp.SendMail
+)
+
+// HTTPClient interface for mocking.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// ProviderConfig configures one provider in notifications.yaml. The fields used depend on the Type.
+type ProviderConfig struct {
+	Type string `yaml:"type"` // Type is telegram, webhook or email.
+
+	// Telegram sends messages from a bot created with @BotFather.
+	Token  string `yaml:"token"`
+	ChatID string `yaml:"chatId"`
+
+	// Webhook POSTs the Event as JSON.
+	URL string `yaml:"url"`
+
+	// Email is sent with SMTP, using PLAIN auth if a username is supplied.
+	SMTPAddr string   `yaml:"smtpAddr"` // SMTPAddr is the host:port of the mail server, e.g. smtp.gmail.com:587.
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+func newProvider(pc ProviderConfig) (Provider, error) {
+	switch pc.Type {
+	case "telegram":
+		if pc.Token == "" || pc.ChatID == "" {
+			return nil, fmt.Errorf("telegram needs a token and chatId")
+		}
+		return &telegram{token: pc.Token, chatID: pc.ChatID}, nil
+	case "webhook":
+		if pc.URL == "" {
+			return nil, fmt.Errorf("webhook needs a url")
+		}
+		return &webhook{url: pc.URL}, nil
+	case "email":
+		if pc.SMTPAddr == "" || pc.From == "" || len(pc.To) == 0 {
+			return nil, fmt.Errorf("email needs an smtpAddr, from and to")
+		}
+		return &email{cfg: pc}, nil
+	default:
+		return nil, fmt.Errorf("unknown type %q", pc.Type)
+	}
+}
+
+type telegram struct {
+	token  string
+	chatID string
+}
+
+func (p *telegram) Send(ctx context.Context, e Event) error {
+	body := map[string]string{"chat_id": p.chatID, "text": e.Message}
+	return postJSON(ctx, fmt.Sprintf("%v/bot%v/sendMessage", telegramAPIURL, p.token), body)
+}
+
+type webhook struct {
+	url string
+}
+
+func (p *webhook) Send(ctx context.Context, e Event) error {
+	return postJSON(ctx, p.url, e)
+}
+
+func postJSON(ctx context.Context, url string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response status: %v", resp.Status)
+	}
+	return nil
+}
+
+type email struct {
+	cfg ProviderConfig
+}
+
+// Send sends the message as a plain text email, or the HTML version if there is one. The context isn't used since
+// net/smtp doesn't support one.
+func (p *email) Send(_ context.Context, e Event) error {
+	var auth smtp.Auth
+	if p.cfg.Username != "" {
+		host, _, err := net.SplitHostPort(p.cfg.SMTPAddr)
+		if err != nil {
+			return fmt.Errorf("bad smtpAddr: %w", err)
+		}
+		auth = smtp.PlainAuth("", p.cfg.Username, p.cfg.Password, host)
+	}
+	contentType, body := "text/plain", e.Message
+	if e.HTML != "" {
+		contentType, body = "text/html", e.HTML
+	}
+	msg := fmt.Sprintf("From: %v\r\nTo: %v\r\nSubject: TubeTimeout %v notification\r\nDate: %v\r\nMIME-Version: 1.0\r\nContent-Type: %v; charset=UTF-8\r\n\r\n%v\r\n",
+		p.cfg.From, strings.Join(p.cfg.To, ", "), e.Kind, e.Time.Format(time.RFC1123Z), contentType, body)
+	if err := fnSendMail(p.cfg.SMTPAddr, auth, p.cfg.From, p.cfg.To, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+//go:build ignore
+
+// gen writes oui.txt from the IEEE MA-L registry, which is downloaded unless a CSV file is given, e.g.
+//
+//	go run gen.go [oui.csv]
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const registryURL = "https://standards-oui.ieee.org/oui/oui.csv"
+
+// suffix matches the company types that make vendor names longer than they need to be in the UI.
+var suffix = regexp.MustCompile(`(?i)[ ,.]*\b(co|corp|corporation|company|inc|incorporated|ltd|limited|llc|gmbh|ag|sa|s\.a|bv|b\.v|oy|ab|as|srl|spa|pty|plc|kk|k\.k)\b\.?$`)
+
+// unassigned are the organisations of blocks that don't identify a maker: the registry splits its own into smaller
+// assignments and private ones aren't published.
+var unassigned = map[string]bool{"IEEE Registration Authority": true, "Private": true}
+
+func main() {
+	r, err := open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer r.Close()
+
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		log.Fatalf("failed to read the registry: %v", err)
+	}
+	lines := make([]string, 0, len(records))
+	for _, rec := range records[1:] { // skip the header
+		if len(rec) < 3 || len(rec[1]) != 6 || unassigned[rec[2]] {
+			continue
+		}
+		lines = append(lines, strings.ToUpper(rec[1])+"\t"+shorten(rec[2]))
+	}
+	sort.Strings(lines)
+
+	f, err := os.Create("oui.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	for _, l := range lines {
+		if _, err = fmt.Fprintln(f, l); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func open() (io.ReadCloser, error) {
+	if len(os.Args) > 1 {
+		return os.Open(os.Args[1])
+	}
+	resp, err := http.Get(registryURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download the registry: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download the registry: %v", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// shorten removes the company types from the end of the name, e.g. "Nintendo Co.,Ltd" becomes "Nintendo".
+func shorten(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	for {
+		s := strings.TrimRight(suffix.ReplaceAllString(name, ""), " ,.")
+		if s == name || s == "" {
+			return name
+		}
+		name = s
+	}
+}
@@ -0,0 +1,44 @@
+// Package oui looks up the makers of devices by the first three bytes of their MACs, the organisationally unique
+// identifier (OUI) that the IEEE assigns to each maker.
+package oui
+
+import (
+	"bufio"
+	_ "embed"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// The vendors are taken from the IEEE MA-L registry, which is published at https://standards-oui.ieee.org/oui/oui.csv.
+//
+//go:generate go run gen.go
+//go:embed oui.txt
+var ouiFile string
+
+var (
+	vendors     map[string]string // vendors holds the vendor names by OUI, e.g. "F0D1A9".
+	vendorsOnce sync.Once
+)
+
+func load() {
+	vendors = make(map[string]string, strings.Count(ouiFile, "\n"))
+	scanner := bufio.NewScanner(strings.NewReader(ouiFile))
+	for scanner.Scan() {
+		if prefix, name, ok := strings.Cut(scanner.Text(), "\t"); ok {
+			vendors[prefix] = name
+		}
+	}
+}
+
+// Vendor returns the name of the maker of the device with the MAC, or "" if the MAC isn't valid or its maker isn't
+// known. MACs that are locally administered, like the private ones phones use, don't have a maker.
+func Vendor(mac string) string {
+	hw, err := net.ParseMAC(strings.ReplaceAll(mac, "-", ":"))
+	if err != nil || len(hw) < 3 || hw[0]&0x02 != 0 {
+		return ""
+	}
+	vendorsOnce.Do(load)
+	return vendors[fmt.Sprintf("%02X%02X%02X", hw[0], hw[1], hw[2])]
+}
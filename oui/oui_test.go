@@ -0,0 +1,27 @@
+package oui
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVendor(t *testing.T) {
+	tests := []struct {
+		name string
+		mac  string
+		want string
+	}{
+		{"colons", "f0:d1:a9:12:34:56", "Apple"},
+		{"dashes", "60-1A-C7-12-34-56", "Nintendo"},
+		{"company type removed", "64:1B:2F:12:34:56", "Samsung Electronics"},
+		{"randomized", "f2:d1:a9:12:34:56", ""},
+		{"unknown", "00:ff:ff:12:34:56", ""},
+		{"invalid", "not-a-mac", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Vendor(tt.mac))
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package pihole
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+const queryPageSize = 1000 // queryPageSize is the most queries read from the log per poll.
+
+var errUnauthorized = errors.New("pi-hole session is not valid")
+
+// Query is a single lookup from the Pi-hole query log.
+type Query struct {
+	Time     time.Time
+	Domain   models.Domain
+	ClientIP models.Ip
+}
+
+// client calls the Pi-hole v6 REST API, logging in with the app password when one is configured.
+type client struct {
+	cfg    *config.PiholeConfig
+	http   *http.Client
+	url    string
+	mu     sync.Mutex
+	sid    string
+	authed bool
+}
+
+func newClient(cfg *config.PiholeConfig) *client {
+	return &client{
+		cfg: cfg,
+		http: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}},
+		},
+		url: strings.TrimSuffix(cfg.URL, "/") + "/api",
+	}
+}
+
+// queries returns the lookups logged from since until now, oldest first.
+func (c *client) queries(ctx context.Context, since, now time.Time) ([]Query, error) {
+	var resp struct {
+		Queries []struct {
+			Time   float64 `json:"time"`
+			Domain string  `json:"domain"`
+			Client struct {
+				IP string `json:"ip"`
+			} `json:"client"`
+		} `json:"queries"`
+	}
+	v := url.Values{}
+	v.Set("from", strconv.FormatInt(since.Unix(), 10))
+	v.Set("until", strconv.FormatInt(now.Unix(), 10))
+	v.Set("length", strconv.Itoa(queryPageSize))
+	if err := c.get(ctx, "/queries?"+v.Encode(), &resp); err != nil {
+		return nil, err
+	}
+	result := make([]Query, 0, len(resp.Queries))
+	for i := len(resp.Queries) - 1; i >= 0; i-- { // Pi-hole returns the newest first.
+		q := resp.Queries[i]
+		sec := int64(q.Time)
+		result = append(result, Query{
+			Time:     time.Unix(sec, int64((q.Time-float64(sec))*1e9)),
+			Domain:   models.Domain(strings.ToLower(strings.TrimSuffix(q.Domain, "."))),
+			ClientIP: models.Ip(q.Client.IP),
+		})
+	}
+	return result, nil
+}
+
+// dhcpActive returns true if Pi-hole's own DHCP server is enabled.
+func (c *client) dhcpActive(ctx context.Context) (bool, error) {
+	var resp struct {
+		Config struct {
+			DHCP struct {
+				Active bool `json:"active"`
+			} `json:"dhcp"`
+		} `json:"config"`
+	}
+	if err := c.get(ctx, "/config/dhcp/active", &resp); err != nil {
+		return false, err
+	}
+	return resp.Config.DHCP.Active, nil
+}
+
+// get decodes the response to a GET of path into v, logging in first if needed and again if the session expired.
+func (c *client) get(ctx context.Context, path string, v any) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.authed {
+		if err := c.login(ctx); err != nil {
+			return err
+		}
+	}
+	err := c.do(ctx, path, v)
+	if !errors.Is(err, errUnauthorized) {
+		return err
+	}
+	if err = c.login(ctx); err != nil {
+		return err
+	}
+	return c.do(ctx, path, v)
+}
+
+// login gets a session ID for the configured password. It should be called under lock.
+func (c *client) login(ctx context.Context) error {
+	c.sid, c.authed = "", false
+	if c.cfg.Password == "" { // if Pi-hole doesn't need a password...
+		c.authed = true
+		return nil
+	}
+	body, _ := json.Marshal(map[string]string{"password": c.cfg.Password})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/auth", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create pi-hole login request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("pi-hole login failed: %w", err)
+	}
+	defer resp.Body.Close()
+	var auth struct {
+		Session struct {
+			Valid bool   `json:"valid"`
+			SID   string `json:"sid"`
+		} `json:"session"`
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&auth); err != nil {
+		return fmt.Errorf("failed to decode pi-hole login response (status %v): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || !auth.Session.Valid {
+		return fmt.Errorf("pi-hole login was refused (status %v), check PIHOLE_PASSWORD", resp.StatusCode)
+	}
+	c.sid, c.authed = auth.Session.SID, true
+	return nil
+}
+
+// do should be called under lock.
+func (c *client) do(ctx context.Context, path string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+path, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create pi-hole request: %w", err)
+	}
+	if c.sid != "" {
+		req.Header.Set("X-FTL-SID", c.sid)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("pi-hole request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized:
+		return errUnauthorized
+	default:
+		return fmt.Errorf("pi-hole returned status %v for %v", resp.StatusCode, strings.Split(path, "?")[0])
+	}
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode pi-hole response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package pihole
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+// Watcher reads the Pi-hole query log to find the hosts of tracked domains that devices in groups look up, e.g. the
+// rotating googlevideo.com hosts that serve YouTube video, so that their IPs are tracked too.
+// Only lookups made by devices in a group are used, and a host is forgotten once it hasn't been looked up for
+// DomainRetention.
+// It also checks whether Pi-hole's own DHCP server is enabled, since devices that get a lease from Pi-hole use the
+// router as their gateway and aren't filtered.
+type Watcher struct {
+	logger         *zap.SugaredLogger
+	cfg            *config.PiholeConfig
+	client         *client
+	nowFunc        func() time.Time
+	mu             sync.Mutex
+	domainGroups   models.MapDomainGroups // domainGroups are the configured tracked domains.
+	sourceIpGroups models.MapIpGroups
+	observed       map[models.Group]map[models.Domain]time.Time // observed is when each host of a domain group was last looked up.
+	since          time.Time                                    // since is the end of the last poll of the query log.
+	lastPoll       time.Time
+	lastErr        error
+	dhcpActive     bool
+}
+
+func NewWatcher(logger *zap.SugaredLogger, cfg *config.PiholeConfig) *Watcher {
+	return &Watcher{
+		logger:   logger,
+		cfg:      cfg,
+		client:   newClient(cfg),
+		nowFunc:  time.Now,
+		observed: make(map[models.Group]map[models.Domain]time.Time),
+	}
+}
+
+// Start reads the query log now and every cfg.Interval until ctx is cancelled.
+func (w *Watcher) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(w.cfg.Interval)
+		defer ticker.Stop()
+		w.poll(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				w.poll(ctx)
+			}
+		}
+	}()
+}
+
+// UpdateDestDomainGroups implements the DestDomainGroupsReceiver interface.
+func (w *Watcher) UpdateDestDomainGroups(newData models.MapDomainGroups) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.domainGroups = newData
+}
+
+// UpdateSourceIpGroups implements the SourceIpGroupsReceiver interface.
+func (w *Watcher) UpdateSourceIpGroups(newData models.MapIpGroups) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.sourceIpGroups = newData
+}
+
+// ObservedDomains implements the DomainSource interface.
+func (w *Watcher) ObservedDomains() models.MapGroupDomains {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	m := make(models.MapGroupDomains)
+	for g, domains := range w.observed {
+		m[g] = slices.Sorted(maps.Keys(domains))
+	}
+	return m
+}
+
+func (w *Watcher) poll(ctx context.Context) {
+	now := w.nowFunc()
+	w.mu.Lock()
+	since := w.since
+	w.mu.Unlock()
+	if since.IsZero() {
+		since = now.Add(-w.cfg.Interval)
+	}
+
+	queries, err := w.client.queries(ctx, since, now)
+	var dhcpActive bool
+	if err == nil {
+		dhcpActive, err = w.client.dhcpActive(ctx)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastPoll, w.lastErr = now, err
+	if err != nil {
+		w.logger.Errorf("Failed to read the Pi-hole query log: %v", err)
+		return
+	}
+	if dhcpActive && !w.dhcpActive {
+		w.logger.Warn("Pi-hole's DHCP server is enabled, so devices that get a lease from it aren't filtered")
+	}
+	w.dhcpActive = dhcpActive
+	w.since = now
+
+	added := 0
+	for _, q := range queries {
+		for _, g := range w.groupsFor(q) {
+			if w.observed[g] == nil {
+				w.observed[g] = make(map[models.Domain]time.Time)
+			}
+			if _, ok := w.observed[g][q.Domain]; !ok {
+				added++
+			}
+			w.observed[g][q.Domain] = q.Time
+		}
+	}
+	w.expire(now)
+	if added > 0 {
+		w.logger.Infof("Pi-hole watcher found %v new hosts of tracked domains", added)
+	}
+}
+
+// groupsFor returns the domain groups of the tracked domain that the query looked up a host of, if the client that
+// made it is in a group. Tracked domains themselves are already resolved so only their sub-domains are matched.
+// It should be called under w.mu.
+func (w *Watcher) groupsFor(q Query) []models.Group {
+	if _, ok := w.domainGroups[q.Domain]; ok {
+		return nil
+	}
+	if len(w.sourceIpGroups[q.ClientIP]) == 0 { // if the lookup wasn't made by a tracked device...
+		return nil
+	}
+	var result []models.Group
+	for d := string(q.Domain); ; {
+		_, parent, ok := strings.Cut(d, ".")
+		if !ok {
+			break
+		}
+		for _, g := range w.domainGroups[models.Domain(parent)] {
+			if !slices.Contains(result, g) {
+				result = append(result, g)
+			}
+		}
+		d = parent
+	}
+	return result
+}
+
+// expire forgets hosts that haven't been looked up within the retention period. It should be called under w.mu.
+func (w *Watcher) expire(now time.Time) {
+	for g, domains := range w.observed {
+		for d, seen := range domains {
+			if now.Sub(seen) > w.cfg.DomainRetention {
+				delete(domains, d)
+			}
+		}
+		if len(domains) == 0 {
+			delete(w.observed, g)
+		}
+	}
+}
+
+// Health reports whether the query log can be read and warns if Pi-hole's DHCP server is enabled.
+func (w *Watcher) Health() models.SubsystemHealth {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	hosts := 0
+	for _, domains := range w.observed {
+		hosts += len(domains)
+	}
+	h := models.SubsystemHealth{Name: "pihole", Status: models.HealthOK}
+	h.Details = map[string]any{"url": w.cfg.URL, "lastPoll": w.lastPoll, "observedHosts": hosts, "dhcpActive": w.dhcpActive}
+	switch {
+	case w.lastErr != nil:
+		h.Status = models.HealthDegraded
+		h.Message = w.lastErr.Error()
+	case w.lastPoll.IsZero():
+		h.Status = models.HealthDegraded
+		h.Message = "Pi-hole query log has not been read yet"
+	case w.dhcpActive:
+		h.Status = models.HealthDegraded
+		h.Message = "Pi-hole's DHCP server is enabled, disable it in Pi-hole so that devices use this gateway"
+	}
+	return h
+}
@@ -0,0 +1,128 @@
+package pihole
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+// fakePihole serves the parts of the Pi-hole v6 API that the watcher uses.
+type fakePihole struct {
+	mu         sync.Mutex
+	password   string
+	sid        string
+	logins     int
+	queries    []map[string]any
+	dhcpActive bool
+}
+
+func (f *fakePihole) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.URL.Path == "/api/auth" {
+		var body struct {
+			Password string `json:"password"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		f.logins++
+		if body.Password != f.password {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]any{"session": map[string]any{"valid": false}})
+			return
+		}
+		f.sid = "sid" + string(rune('0'+f.logins))
+		_ = json.NewEncoder(w).Encode(map[string]any{"session": map[string]any{"valid": true, "sid": f.sid}})
+		return
+	}
+	if f.password != "" && r.Header.Get("X-FTL-SID") != f.sid {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch r.URL.Path {
+	case "/api/queries":
+		_ = json.NewEncoder(w).Encode(map[string]any{"queries": f.queries})
+	case "/api/config/dhcp/active":
+		_ = json.NewEncoder(w).Encode(map[string]any{"config": map[string]any{"dhcp": map[string]any{"active": f.dhcpActive}}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestWatcher(url string, password string, now time.Time) *Watcher {
+	w := NewWatcher(config.MustGetLogger(), &config.PiholeConfig{URL: url, Password: password, Interval: time.Minute, DomainRetention: time.Hour})
+	w.nowFunc = func() time.Time { return now }
+	return w
+}
+
+func TestWatcher_ObservedDomains(t *testing.T) {
+	now := time.Now()
+	fake := &fakePihole{queries: []map[string]any{ // newest first, as Pi-hole returns them.
+		{"time": float64(now.Unix()) - 1, "domain": "rr2---sn-abc.googlevideo.com", "client": map[string]any{"ip": "192.168.1.20"}},
+		{"time": float64(now.Unix()) - 2, "domain": "googlevideo.com", "client": map[string]any{"ip": "192.168.1.20"}},
+		{"time": float64(now.Unix()) - 3, "domain": "rr1---sn-abc.googlevideo.com.", "client": map[string]any{"ip": "192.168.1.99"}},
+		{"time": float64(now.Unix()) - 4, "domain": "example.com", "client": map[string]any{"ip": "192.168.1.20"}},
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	w := newTestWatcher(srv.URL, "", now)
+	w.UpdateDestDomainGroups(models.MapDomainGroups{"googlevideo.com": {"youtube"}})
+	w.UpdateSourceIpGroups(models.MapIpGroups{"192.168.1.20": {"kids"}})
+	w.poll(context.Background())
+
+	assert.Equal(t, models.MapGroupDomains{"youtube": {"rr2---sn-abc.googlevideo.com"}}, w.ObservedDomains(),
+		"expected only hosts of tracked domains looked up by tracked devices")
+	assert.Equal(t, models.HealthOK, w.Health().Status)
+
+	// Expect hosts to be forgotten once they haven't been looked up for the retention period.
+	fake.queries = nil
+	w.nowFunc = func() time.Time { return now.Add(2 * time.Hour) }
+	w.poll(context.Background())
+	assert.Empty(t, w.ObservedDomains())
+}
+
+func TestWatcher_DHCPActive(t *testing.T) {
+	fake := &fakePihole{dhcpActive: true}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	w := newTestWatcher(srv.URL, "", time.Now())
+	w.poll(context.Background())
+	h := w.Health()
+	assert.Equal(t, models.HealthDegraded, h.Status)
+	assert.Contains(t, h.Message, "DHCP server is enabled")
+}
+
+func TestClient_Login(t *testing.T) {
+	fake := &fakePihole{password: "secret"}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	w := newTestWatcher(srv.URL, "secret", time.Now())
+	w.poll(context.Background())
+	assert.Equal(t, models.HealthOK, w.Health().Status)
+	assert.Equal(t, 1, fake.logins)
+
+	// Expect to log in again once the session expires.
+	fake.mu.Lock()
+	fake.sid = "expired"
+	fake.mu.Unlock()
+	w.poll(context.Background())
+	assert.Equal(t, models.HealthOK, w.Health().Status)
+	assert.Equal(t, 2, fake.logins)
+
+	// Expect a wrong password to be reported.
+	w = newTestWatcher(srv.URL, "wrong", time.Now())
+	w.poll(context.Background())
+	h := w.Health()
+	assert.Equal(t, models.HealthDegraded, h.Status)
+	assert.Contains(t, h.Message, "PIHOLE_PASSWORD")
+}
@@ -0,0 +1,120 @@
+// Package privilege detects the Linux capabilities the app runs with, so that it can run as a non-root user with
+// just the capabilities it needs, e.g. granted by AmbientCapabilities in a systemd unit, and turn off the features
+// whose capabilities are missing instead of refusing to start.
+package privilege
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Capability is a Linux capability, numbered as in linux/capability.h.
+type Capability uint
+
+const (
+	CapDACOverride    Capability = 1  // CapDACOverride writes files the user doesn't own, e.g. /etc/dnsmasq.conf.
+	CapNetBindService Capability = 10 // CapNetBindService binds ports below 1024, e.g. the web server and DHCP.
+	CapNetAdmin       Capability = 12 // CapNetAdmin changes the NFT rules, NFQueues and interface addresses.
+	CapNetRaw         Capability = 13 // CapNetRaw sends and receives DHCP broadcasts before an interface has an address.
+)
+
+var capabilityNames = map[Capability]string{
+	CapDACOverride:    "CAP_DAC_OVERRIDE",
+	CapNetBindService: "CAP_NET_BIND_SERVICE",
+	CapNetAdmin:       "CAP_NET_ADMIN",
+	CapNetRaw:         "CAP_NET_RAW",
+}
+
+func (c Capability) String() string {
+	if name, ok := capabilityNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("capability %d", uint(c))
+}
+
+var (
+	procStatusPath = "/proc/self/status"
+	fnReadFile     = os.ReadFile
+	fnGeteuid      = os.Geteuid
+)
+
+// Privileges are the effective capabilities of the process.
+type Privileges struct {
+	Root      bool   // Root is true if the effective user is root.
+	effective uint64 // effective is the bit mask of effective capabilities.
+}
+
+// New returns privileges with the capabilities given, e.g. for tests.
+func New(root bool, caps ...Capability) Privileges {
+	p := Privileges{Root: root}
+	for _, c := range caps {
+		p.effective |= 1 << c
+	}
+	return p
+}
+
+// Detect returns the effective capabilities of the process. If they can't be read, e.g. on a system without /proc,
+// root is assumed to have every capability and other users none, as in the past.
+func Detect() Privileges {
+	p := Privileges{Root: fnGeteuid() == 0}
+	if eff, err := readEffective(); err == nil {
+		p.effective = eff
+	} else if p.Root {
+		p.effective = ^uint64(0)
+	}
+	return p
+}
+
+// readEffective reads the CapEff bit mask from the process status.
+func readEffective() (uint64, error) {
+	b, err := fnReadFile(procStatusPath)
+	if err != nil {
+		return 0, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "CapEff:"); ok {
+			return strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		}
+	}
+	return 0, fmt.Errorf("no CapEff in %v", procStatusPath)
+}
+
+// Has returns true if the capability is effective.
+func (p Privileges) Has(c Capability) bool {
+	return p.effective&(1<<c) != 0
+}
+
+// Missing returns the capabilities that aren't effective, in the order given.
+func (p Privileges) Missing(caps ...Capability) []Capability {
+	var missing []Capability
+	for _, c := range caps {
+		if !p.Has(c) {
+			missing = append(missing, c)
+		}
+	}
+	return missing
+}
+
+// Require returns an error naming the capabilities that are missing, or nil if they're all effective.
+func (p Privileges) Require(caps ...Capability) error {
+	missing := p.Missing(caps...)
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("missing %v: run as root or grant them, e.g. with AmbientCapabilities=%v in the systemd unit",
+		Join(missing, ", "), Join(caps, " "))
+}
+
+// Join returns the names of the capabilities separated by sep.
+func Join(caps []Capability, sep string) string {
+	names := make([]string, 0, len(caps))
+	for _, c := range caps {
+		names = append(names, c.String())
+	}
+	return strings.Join(names, sep)
+}
@@ -0,0 +1,48 @@
+package privilege
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetect(t *testing.T) {
+	origRead, origEuid := fnReadFile, fnGeteuid
+	t.Cleanup(func() { fnReadFile, fnGeteuid = origRead, origEuid })
+
+	// CAP_NET_ADMIN and CAP_NET_RAW, as granted by AmbientCapabilities to a non-root user.
+	fnReadFile = func(string) ([]byte, error) {
+		return []byte("Name:\ttubetimeout\nCapInh:\t0000000000003000\nCapEff:\t0000000000003000\n"), nil
+	}
+	fnGeteuid = func() int { return 1000 }
+	p := Detect()
+	assert.False(t, p.Root)
+	assert.True(t, p.Has(CapNetAdmin))
+	assert.True(t, p.Has(CapNetRaw))
+	assert.False(t, p.Has(CapNetBindService))
+	assert.Equal(t, []Capability{CapNetBindService}, p.Missing(CapNetAdmin, CapNetBindService))
+	assert.NoError(t, p.Require(CapNetAdmin, CapNetRaw))
+	err := p.Require(CapNetAdmin, CapNetBindService)
+	if assert.Error(t, err) {
+		assert.Equal(t, "missing CAP_NET_BIND_SERVICE: run as root or grant them, e.g. with AmbientCapabilities=CAP_NET_ADMIN CAP_NET_BIND_SERVICE in the systemd unit", err.Error())
+	}
+
+	// Root in a container can have fewer capabilities than usual.
+	fnReadFile = func(string) ([]byte, error) { return []byte("CapEff:\t0000000000002000\n"), nil }
+	fnGeteuid = func() int { return 0 }
+	p = Detect()
+	assert.True(t, p.Root)
+	assert.False(t, p.Has(CapNetAdmin), "expected the effective capabilities to be used for root too")
+
+	// Without /proc, root is assumed to have every capability.
+	fnReadFile = func(string) ([]byte, error) { return nil, errors.New("no such file") }
+	assert.True(t, Detect().Has(CapNetAdmin))
+	fnGeteuid = func() int { return 1000 }
+	assert.False(t, Detect().Has(CapNetAdmin))
+}
+
+func TestCapability_String(t *testing.T) {
+	assert.Equal(t, "CAP_NET_ADMIN", CapNetAdmin.String())
+	assert.Equal(t, "capability 21", Capability(21).String())
+}
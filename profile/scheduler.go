@@ -0,0 +1,355 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+var (
+	ErrProfileNotFound      = errors.New("profile not found")
+	ErrInvalidProfile       = errors.New("invalid profile")
+	defaultProfilesFilePath = "profiles.yaml"
+	fnGetProfiles           = config.GetConfig[*profilesFile]
+	fnSetProfiles           = config.SetConfig[*profilesFile]
+	nowFunc                 = time.Now
+	// maxModeEnd is the mode end time of groups put in a mode by a profile that's on until it's deactivated.
+	maxModeEnd = time.Date(9999, time.January, 1, 0, 0, 0, 0, time.UTC)
+)
+
+const checkInterval = time.Minute // checkInterval is how often the schedules are checked.
+
+func init() {
+	config.Backups.Register(defaultProfilesFilePath, "configuration profiles")
+}
+
+// TrackerConfigGetterSetter loads and saves the tracker config of every group.
+type TrackerConfigGetterSetter interface {
+	GetConfig() (models.MapGroupTrackerConfig, error)
+	SetConfig(m models.MapGroupTrackerConfig) error
+}
+
+// profilesFile is the YAML structure of the profiles file.
+type profilesFile struct {
+	Profiles []models.Profile `yaml:"profiles"`
+	Active   *activation      `yaml:"active,omitempty"`
+	// SkipUntil is the end of the scheduled period whose profile was deactivated from the API, so that the schedule
+	// doesn't activate it again straight away.
+	SkipUntil time.Time `yaml:"skipUntil,omitempty"`
+}
+
+// activation is the active profile and the tracker settings it replaced, which are put back when it ends.
+type activation struct {
+	Name   string                        `yaml:"name"`
+	Source string                        `yaml:"source"`
+	Since  time.Time                     `yaml:"since"`
+	Until  time.Time                     `yaml:"until,omitempty"`
+	Base   map[models.Group]baseSettings `yaml:"base"`
+}
+
+// baseSettings are the settings of a group before a profile was applied.
+type baseSettings struct {
+	Threshold     time.Duration            `yaml:"threshold"`
+	DayThresholds []models.DayThreshold    `yaml:"dayThresholds,omitempty"`
+	Mode          *models.UsageTrackerMode `yaml:"mode,omitempty"` // Mode and ModeEndTime are only set if the profile changed the mode.
+	ModeEndTime   time.Time                `yaml:"modeEndTime,omitempty"`
+}
+
+// Scheduler applies profiles, which are named bundles of tracker changes such as doubling every threshold for the
+// school holidays, to the tracker config when they're activated from the API or their schedule comes round. The
+// settings a profile replaces are saved so that they're put back when it ends, even after a restart.
+type Scheduler struct {
+	logger  *zap.SugaredLogger
+	tracker TrackerConfigGetterSetter
+	mu      sync.Mutex // mu protects state and the file via fnGetProfiles/fnSetProfiles.
+	muApply sync.Mutex // muApply serialises the changes made from the API and the schedule.
+	state   *profilesFile
+}
+
+// NewScheduler loads the profiles and checks their schedules until ctx is done.
+func NewScheduler(ctx context.Context, logger *zap.SugaredLogger, tracker TrackerConfigGetterSetter) (*Scheduler, error) {
+	s := &Scheduler{logger: logger, tracker: tracker}
+	var err error
+	s.state, err = fnGetProfiles(&s.mu, defaultProfilesFilePath, func() *profilesFile { return &profilesFile{} })
+	if err != nil {
+		return nil, fmt.Errorf("failed to load profiles: %w", err)
+	}
+	if s.state == nil { // if the file is new...
+		s.state = &profilesFile{}
+	}
+	s.check(nowFunc())
+	go s.startWorker(ctx)
+	return s, nil
+}
+
+func (s *Scheduler) startWorker(ctx context.Context) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.check(nowFunc())
+		}
+	}
+}
+
+// State returns the profiles and the one that is active.
+func (s *Scheduler) State() models.ProfileState {
+	f := s.current()
+	state := models.ProfileState{Profiles: slices.Clone(f.Profiles)}
+	if state.Profiles == nil {
+		state.Profiles = []models.Profile{}
+	}
+	if f.Active != nil {
+		state.Active, state.Source, state.Since, state.Until = f.Active.Name, f.Active.Source, f.Active.Since, f.Active.Until
+	}
+	return state
+}
+
+// SetProfiles replaces the profiles. If the active profile is changed, the change is applied straight away, and if
+// it's removed, it's deactivated. An error wrapping ErrInvalidProfile is returned if any profile is invalid.
+func (s *Scheduler) SetProfiles(profiles []models.Profile) (models.ProfileState, error) {
+	profiles, err := cleanProfiles(profiles)
+	if err != nil {
+		return models.ProfileState{}, err
+	}
+	s.muApply.Lock()
+	defer s.muApply.Unlock()
+	f := s.current()
+	next := &profilesFile{Profiles: profiles, SkipUntil: f.SkipUntil}
+	if f.Active == nil {
+		err = s.save(next)
+	} else if i := slices.IndexFunc(profiles, func(p models.Profile) bool { return p.Name == f.Active.Name }); i >= 0 {
+		err = s.switchTo(f, next, &profiles[i], f.Active.Source, f.Active.Until)
+	} else {
+		s.logger.Infof("Deactivating profile %q since it was removed", f.Active.Name)
+		err = s.switchTo(f, next, nil, "", time.Time{})
+	}
+	if err != nil {
+		return models.ProfileState{}, err
+	}
+	return s.State(), nil
+}
+
+// Activate applies the named profile in place of the active one, if any, until it's deactivated or until, if it
+// isn't zero.
+func (s *Scheduler) Activate(name string, until time.Time) (models.ProfileState, error) {
+	s.muApply.Lock()
+	defer s.muApply.Unlock()
+	f := s.current()
+	i := slices.IndexFunc(f.Profiles, func(p models.Profile) bool { return p.Name == name })
+	if i < 0 {
+		return models.ProfileState{}, fmt.Errorf("%w: %q", ErrProfileNotFound, name)
+	}
+	if !until.IsZero() && !until.After(nowFunc()) {
+		return models.ProfileState{}, fmt.Errorf("%w: the end time must be in the future", ErrInvalidProfile)
+	}
+	next := &profilesFile{Profiles: f.Profiles, SkipUntil: f.SkipUntil}
+	if err := s.switchTo(f, next, &f.Profiles[i], models.ProfileSourceAPI, until); err != nil {
+		return models.ProfileState{}, err
+	}
+	s.logger.Infof("Activated profile %q", name)
+	return s.State(), nil
+}
+
+// Deactivate puts back the settings replaced by the active profile. A scheduled profile isn't activated again
+// until its next period.
+func (s *Scheduler) Deactivate() (models.ProfileState, error) {
+	s.muApply.Lock()
+	defer s.muApply.Unlock()
+	f := s.current()
+	if f.Active == nil {
+		return s.State(), nil
+	}
+	next := &profilesFile{Profiles: f.Profiles, SkipUntil: f.SkipUntil}
+	if _, period, ok := scheduled(f.Profiles, nowFunc()); ok {
+		next.SkipUntil = period.End
+	}
+	if err := s.switchTo(f, next, nil, "", time.Time{}); err != nil {
+		return models.ProfileState{}, err
+	}
+	s.logger.Infof("Deactivated profile %q", f.Active.Name)
+	return s.State(), nil
+}
+
+// check ends the active profile if its time is up, then activates the profile scheduled now, if any, unless
+// another profile is still active.
+func (s *Scheduler) check(now time.Time) {
+	s.muApply.Lock()
+	defer s.muApply.Unlock()
+	f := s.current()
+	if f.Active != nil && !f.Active.Until.IsZero() && !now.Before(f.Active.Until) {
+		next := &profilesFile{Profiles: f.Profiles, SkipUntil: f.SkipUntil}
+		if err := s.switchTo(f, next, nil, "", time.Time{}); err != nil {
+			s.logger.Errorf("Error ending profile %q: %v", f.Active.Name, err)
+			return
+		}
+		s.logger.Infof("Profile %q has ended", f.Active.Name)
+		f = s.current()
+	}
+	if f.Active != nil || now.Before(f.SkipUntil) {
+		return
+	}
+	p, period, ok := scheduled(f.Profiles, now)
+	if !ok {
+		return
+	}
+	next := &profilesFile{Profiles: f.Profiles}
+	if err := s.switchTo(f, next, &p, models.ProfileSourceSchedule, period.End); err != nil {
+		s.logger.Errorf("Error activating scheduled profile %q: %v", p.Name, err)
+		return
+	}
+	s.logger.Infof("Activated profile %q until %v as scheduled", p.Name, period.End)
+}
+
+// switchTo puts back the settings replaced by the active profile in f, if any, then applies p, if it isn't nil, in a
+// single tracker config update, and saves next with the new activation. This should be done under muApply.
+func (s *Scheduler) switchTo(f, next *profilesFile, p *models.Profile, source string, until time.Time) error {
+	cfg, err := s.tracker.GetConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load tracker config: %w", err)
+	}
+	if f.Active != nil {
+		restore(cfg, f.Active.Base)
+	}
+	next.Active = nil
+	if p != nil {
+		next.Active = &activation{Name: p.Name, Source: source, Since: nowFunc(), Until: until, Base: apply(cfg, *p, until)}
+		if f.Active != nil && f.Active.Name == p.Name { // if the profile is being re-applied, e.g. after it's edited...
+			next.Active.Since = f.Active.Since
+		}
+	}
+	// Save the profiles first so that the settings to put back aren't lost if the app stops in between.
+	if err = s.save(next); err != nil {
+		return err
+	}
+	if err = s.tracker.SetConfig(cfg); err != nil {
+		if errSave := s.save(f); errSave != nil {
+			s.logger.Errorf("Error putting back the profiles after failing to apply them: %v", errSave)
+		}
+		return fmt.Errorf("failed to save tracker config: %w", err)
+	}
+	return nil
+}
+
+// save writes f to the profiles file and makes it the current state.
+func (s *Scheduler) save(f *profilesFile) error {
+	err := fnSetProfiles(&s.mu, defaultProfilesFilePath, nil, func(v *profilesFile) { s.state = v }, f)
+	if err != nil {
+		return fmt.Errorf("failed to save profiles: %w", err)
+	}
+	return nil
+}
+
+// current returns the latest state. It mustn't be changed since it's replaced rather than updated.
+func (s *Scheduler) current() *profilesFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// apply changes cfg by the profile and returns the settings of each group that it replaced. Groups put in a mode
+// stay in it until the profile ends.
+func apply(cfg models.MapGroupTrackerConfig, p models.Profile, until time.Time) map[models.Group]baseSettings {
+	base := make(map[models.Group]baseSettings, len(cfg))
+	for group, tc := range cfg {
+		if tc == nil {
+			continue
+		}
+		b := baseSettings{Threshold: tc.Threshold, DayThresholds: slices.Clone(tc.DayThresholds)}
+		pg := p.Groups[group]
+		switch {
+		case pg.Threshold != nil:
+			tc.Threshold, tc.DayThresholds = *pg.Threshold, nil
+		case p.ThresholdFactor > 0:
+			tc.Threshold = scale(tc.Threshold, p.ThresholdFactor)
+			tc.DayThresholds = slices.Clone(tc.DayThresholds)
+			for i := range tc.DayThresholds {
+				tc.DayThresholds[i].Threshold = scale(tc.DayThresholds[i].Threshold, p.ThresholdFactor)
+			}
+		}
+		if pg.Mode != nil {
+			mode := tc.Mode
+			b.Mode, b.ModeEndTime = &mode, tc.ModeEndTime
+			tc.Mode, tc.ModeEndTime = *pg.Mode, until
+			if until.IsZero() {
+				tc.ModeEndTime = maxModeEnd
+			}
+		}
+		base[group] = b
+	}
+	return base
+}
+
+// restore puts back the settings that a profile replaced. Groups added since the profile was applied are left alone.
+func restore(cfg models.MapGroupTrackerConfig, base map[models.Group]baseSettings) {
+	for group, b := range base {
+		tc, ok := cfg[group]
+		if !ok || tc == nil {
+			continue
+		}
+		tc.Threshold, tc.DayThresholds = b.Threshold, b.DayThresholds
+		if b.Mode != nil {
+			tc.Mode, tc.ModeEndTime = *b.Mode, b.ModeEndTime
+		}
+	}
+}
+
+// scale multiplies d by factor to the nearest minute.
+func scale(d time.Duration, factor float64) time.Duration {
+	return time.Duration(float64(d) * factor).Round(time.Minute)
+}
+
+// scheduled returns the first profile with a period that includes now and the period.
+func scheduled(profiles []models.Profile, now time.Time) (models.Profile, models.ProfilePeriod, bool) {
+	for _, p := range profiles {
+		for _, period := range p.Schedule {
+			if !now.Before(period.Start) && now.Before(period.End) {
+				return p, period, true
+			}
+		}
+	}
+	return models.Profile{}, models.ProfilePeriod{}, false
+}
+
+// cleanProfiles trims the profile names and returns an error wrapping ErrInvalidProfile if a name is blank or used
+// twice, or a setting is out of range.
+func cleanProfiles(profiles []models.Profile) ([]models.Profile, error) {
+	cleaned := make([]models.Profile, 0, len(profiles))
+	for _, p := range profiles {
+		p.Name = strings.TrimSpace(p.Name)
+		switch {
+		case p.Name == "":
+			return nil, fmt.Errorf("%w: a name is required", ErrInvalidProfile)
+		case slices.ContainsFunc(cleaned, func(c models.Profile) bool { return c.Name == p.Name }):
+			return nil, fmt.Errorf("%w: %q is used more than once", ErrInvalidProfile, p.Name)
+		case p.ThresholdFactor < 0:
+			return nil, fmt.Errorf("%w: %q has a negative threshold factor", ErrInvalidProfile, p.Name)
+		}
+		for group, pg := range p.Groups {
+			if pg.Threshold != nil && *pg.Threshold < 0 {
+				return nil, fmt.Errorf("%w: %q has a negative threshold for group %v", ErrInvalidProfile, p.Name, group)
+			}
+			if pg.Mode != nil && (*pg.Mode < models.ModeMonitor || *pg.Mode > models.ModeBlock) {
+				return nil, fmt.Errorf("%w: %q has an unknown mode for group %v", ErrInvalidProfile, p.Name, group)
+			}
+		}
+		for _, period := range p.Schedule {
+			if !period.End.After(period.Start) {
+				return nil, fmt.Errorf("%w: %q has a scheduled period that ends before it starts", ErrInvalidProfile, p.Name)
+			}
+		}
+		cleaned = append(cleaned, p)
+	}
+	return cleaned, nil
+}
@@ -0,0 +1,224 @@
+package profile
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+// mockTracker keeps the tracker config as YAML so that each GetConfig returns a fresh copy, as if read from disk.
+type mockTracker struct {
+	data    []byte
+	sets    int
+	failSet bool
+}
+
+func newMockTracker(t *testing.T, cfg models.MapGroupTrackerConfig) *mockTracker {
+	t.Helper()
+	m := &mockTracker{}
+	require.NoError(t, m.SetConfig(cfg))
+	m.sets = 0
+	return m
+}
+
+func (m *mockTracker) GetConfig() (models.MapGroupTrackerConfig, error) {
+	cfg := models.NewMapGroupTrackerConfig()
+	err := yaml.Unmarshal(m.data, &cfg)
+	return cfg, err
+}
+
+func (m *mockTracker) SetConfig(cfg models.MapGroupTrackerConfig) error {
+	if m.failSet {
+		return errors.New("disk full")
+	}
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	m.data = data
+	m.sets++
+	return nil
+}
+
+func (m *mockTracker) group(t *testing.T, group models.Group) *models.TrackerConfig {
+	t.Helper()
+	cfg, err := m.GetConfig()
+	require.NoError(t, err)
+	return cfg[group]
+}
+
+// mockProfilesFile replaces the profiles file with one in memory and returns it.
+func mockProfilesFile(t *testing.T) **profilesFile {
+	origGet, origSet := fnGetProfiles, fnSetProfiles
+	t.Cleanup(func() {
+		fnGetProfiles, fnSetProfiles = origGet, origSet
+	})
+	var saved *profilesFile
+	fnGetProfiles = func(mu *sync.Mutex, configPath string, newInstance func() *profilesFile) (*profilesFile, error) {
+		return saved, nil
+	}
+	fnSetProfiles = func(mu *sync.Mutex, configPath string, validate func(v *profilesFile) error, updateInMemory func(v *profilesFile), v *profilesFile) error {
+		mu.Lock()
+		defer mu.Unlock()
+		saved = v
+		updateInMemory(v)
+		return nil
+	}
+	return &saved
+}
+
+// useNow fixes the time returned by nowFunc for the test.
+func useNow(t *testing.T, now *time.Time) {
+	orig := nowFunc
+	nowFunc = func() time.Time { return *now }
+	t.Cleanup(func() { nowFunc = orig })
+}
+
+func newTestTracker(t *testing.T) *mockTracker {
+	return newMockTracker(t, models.MapGroupTrackerConfig{
+		"kids": {Threshold: 60 * time.Minute, DayThresholds: []models.DayThreshold{{Days: []time.Weekday{time.Saturday}, Threshold: 120 * time.Minute}}},
+		"teen": {Threshold: 90 * time.Minute, Mode: models.ModeAllow, ModeEndTime: time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)},
+	})
+}
+
+func TestScheduler_ActivateAndDeactivate(t *testing.T) {
+	saved := mockProfilesFile(t)
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	useNow(t, &now)
+	tracker := newTestTracker(t)
+	s, err := NewScheduler(context.Background(), config.MustGetLogger(), tracker)
+	require.NoError(t, err)
+
+	block := models.ModeBlock
+	exam := 30 * time.Minute
+	_, err = s.SetProfiles([]models.Profile{
+		{Name: " holiday ", ThresholdFactor: 2},
+		{Name: "exam", Groups: map[models.Group]models.ProfileGroup{"teen": {Threshold: &exam, Mode: &block}}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, tracker.sets, "expected saving profiles not to change the trackers")
+
+	_, err = s.Activate("missing", time.Time{})
+	assert.ErrorIs(t, err, ErrProfileNotFound)
+
+	state, err := s.Activate("holiday", time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, "holiday", state.Active, "expected the name to be trimmed")
+	assert.Equal(t, models.ProfileSourceAPI, state.Source)
+	assert.Equal(t, 1, tracker.sets, "expected the profile to be applied in a single update")
+	assert.Equal(t, 120*time.Minute, tracker.group(t, "kids").Threshold)
+	assert.Equal(t, 240*time.Minute, tracker.group(t, "kids").DayThresholds[0].Threshold)
+	assert.Equal(t, 180*time.Minute, tracker.group(t, "teen").Threshold)
+	assert.NotNil(t, (*saved).Active, "expected the replaced settings to be saved")
+
+	// Expect switching profiles to put back the old settings before applying the new ones.
+	until := now.Add(24 * time.Hour)
+	_, err = s.Activate("exam", until)
+	require.NoError(t, err)
+	assert.Equal(t, 60*time.Minute, tracker.group(t, "kids").Threshold)
+	teen := tracker.group(t, "teen")
+	assert.Equal(t, 30*time.Minute, teen.Threshold)
+	assert.Equal(t, models.ModeBlock, teen.Mode)
+	assert.True(t, teen.ModeEndTime.Equal(until), "expected the mode to end with the profile")
+
+	state, err = s.Deactivate()
+	require.NoError(t, err)
+	assert.Empty(t, state.Active)
+	kids, teen := tracker.group(t, "kids"), tracker.group(t, "teen")
+	assert.Equal(t, 60*time.Minute, kids.Threshold)
+	assert.Equal(t, 120*time.Minute, kids.DayThresholds[0].Threshold)
+	assert.Equal(t, 90*time.Minute, teen.Threshold)
+	assert.Equal(t, models.ModeAllow, teen.Mode, "expected the mode to be put back")
+	assert.True(t, teen.ModeEndTime.Equal(time.Date(2026, 10, 20, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestScheduler_Schedule(t *testing.T) {
+	mockProfilesFile(t)
+	now := time.Date(2026, 12, 19, 23, 59, 0, 0, time.UTC)
+	useNow(t, &now)
+	tracker := newTestTracker(t)
+	s, err := NewScheduler(context.Background(), config.MustGetLogger(), tracker)
+	require.NoError(t, err)
+
+	start, end := time.Date(2026, 12, 20, 0, 0, 0, 0, time.UTC), time.Date(2027, 1, 5, 0, 0, 0, 0, time.UTC)
+	_, err = s.SetProfiles([]models.Profile{{Name: "holiday", ThresholdFactor: 2, Schedule: []models.ProfilePeriod{{Start: start, End: end}}}})
+	require.NoError(t, err)
+	s.check(now)
+	assert.Empty(t, s.State().Active, "expected nothing before the period starts")
+
+	now = start
+	s.check(now)
+	state := s.State()
+	assert.Equal(t, "holiday", state.Active)
+	assert.Equal(t, models.ProfileSourceSchedule, state.Source)
+	assert.True(t, state.Until.Equal(end))
+	assert.Equal(t, 120*time.Minute, tracker.group(t, "kids").Threshold)
+
+	// Expect a scheduled profile deactivated from the API to stay off for the rest of its period.
+	_, err = s.Deactivate()
+	require.NoError(t, err)
+	now = now.Add(time.Hour)
+	s.check(now)
+	assert.Empty(t, s.State().Active)
+	assert.Equal(t, 60*time.Minute, tracker.group(t, "kids").Threshold)
+
+	// Expect the profile to end by itself with its period.
+	_, err = s.Activate("holiday", end)
+	require.NoError(t, err)
+	now = end
+	s.check(now)
+	assert.Empty(t, s.State().Active)
+	assert.Equal(t, 60*time.Minute, tracker.group(t, "kids").Threshold)
+}
+
+func TestScheduler_SetProfiles(t *testing.T) {
+	mockProfilesFile(t)
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	useNow(t, &now)
+	tracker := newTestTracker(t)
+	s, err := NewScheduler(context.Background(), config.MustGetLogger(), tracker)
+	require.NoError(t, err)
+
+	unknown := models.UsageTrackerMode(7)
+	for _, profiles := range [][]models.Profile{
+		{{Name: ""}},
+		{{Name: "a"}, {Name: "a"}},
+		{{Name: "a", ThresholdFactor: -1}},
+		{{Name: "a", Groups: map[models.Group]models.ProfileGroup{"kids": {Mode: &unknown}}}},
+		{{Name: "a", Schedule: []models.ProfilePeriod{{Start: now, End: now}}}},
+	} {
+		_, err = s.SetProfiles(profiles)
+		assert.ErrorIs(t, err, ErrInvalidProfile, "%+v", profiles)
+	}
+
+	_, err = s.SetProfiles([]models.Profile{{Name: "holiday", ThresholdFactor: 2}})
+	require.NoError(t, err)
+	_, err = s.Activate("holiday", time.Time{})
+	require.NoError(t, err)
+
+	// Expect changes to the active profile to be applied to the settings from before it.
+	_, err = s.SetProfiles([]models.Profile{{Name: "holiday", ThresholdFactor: 1.5}})
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Minute, tracker.group(t, "kids").Threshold)
+
+	// Expect the settings to be left alone if they can't be saved.
+	tracker.failSet = true
+	_, err = s.Deactivate()
+	assert.Error(t, err)
+	assert.Equal(t, "holiday", s.State().Active)
+	tracker.failSet = false
+
+	state, err := s.SetProfiles(nil)
+	require.NoError(t, err)
+	assert.Empty(t, state.Active, "expected a removed profile to be deactivated")
+	assert.Empty(t, state.Profiles)
+	assert.Equal(t, 60*time.Minute, tracker.group(t, "kids").Threshold)
+}
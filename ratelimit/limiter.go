@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+const minBurstBytes = 1500 // minBurstBytes lets at least one full-size packet through at very low rates.
+
+type bucket struct {
+	tokens float64 // tokens is the number of bytes that can be sent now, negative while packets wait for the rate.
+	last   time.Time
+}
+
+// Limiter shapes traffic to a rate using a token bucket per key, e.g. per group and direction.
+// Buckets hold up to one second of traffic so short bursts aren't delayed.
+type Limiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewLimiter() *Limiter {
+	return &Limiter{buckets: make(map[string]*bucket)}
+}
+
+// Reserve takes n bytes from the bucket for key, which refills at rateKbps, and returns how long the caller should
+// hold the packet so the rate is kept. If the packet would be held for longer than maxWait, no bytes are taken and
+// false is returned so the caller can drop it instead.
+// The rate is given on each call so that config changes apply straight away.
+func (l *Limiter) Reserve(key string, n int, rateKbps int, maxWait time.Duration, now time.Time) (time.Duration, bool) {
+	if rateKbps <= 0 {
+		return 0, true
+	}
+	rate := float64(rateKbps) * 1000 / 8 // bytes per second
+	burst := max(rate, minBurstBytes)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(burst, b.tokens+elapsed.Seconds()*rate)
+		b.last = now
+	}
+
+	remaining := b.tokens - float64(n)
+	if remaining >= 0 {
+		b.tokens = remaining
+		return 0, true
+	}
+	wait := time.Duration(-remaining / rate * float64(time.Second))
+	if wait > maxWait {
+		return 0, false
+	}
+	b.tokens = remaining // borrow from the next refill so packets queued behind this one wait their turn.
+	return wait, true
+}
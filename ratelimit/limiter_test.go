@@ -0,0 +1,57 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_Reserve(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	maxWait := 200 * time.Millisecond
+
+	t.Run("Burst then shape to the rate", func(t *testing.T) {
+		l := NewLimiter()
+		// 200kbps is 25000 bytes per second, which is also the burst.
+		wait, ok := l.Reserve("kids/egress", 25000, 200, maxWait, now)
+		assert.True(t, ok)
+		assert.Zero(t, wait, "expected the burst to be sent straight away")
+
+		wait, ok = l.Reserve("kids/egress", 2500, 200, maxWait, now)
+		assert.True(t, ok)
+		assert.Equal(t, 100*time.Millisecond, wait, "expected the packet to wait for the bucket to refill")
+
+		wait, ok = l.Reserve("kids/egress", 2500, 200, maxWait, now)
+		assert.True(t, ok)
+		assert.Equal(t, 200*time.Millisecond, wait, "expected queued packets to wait behind earlier ones")
+
+		_, ok = l.Reserve("kids/egress", 1500, 200, maxWait, now)
+		assert.False(t, ok, "expected a packet over the max wait to be refused")
+
+		wait, ok = l.Reserve("kids/egress", 2500, 200, maxWait, now.Add(200*time.Millisecond))
+		assert.True(t, ok)
+		assert.Equal(t, 100*time.Millisecond, wait, "expected the refused packet not to use any tokens")
+	})
+
+	t.Run("Keys are independent", func(t *testing.T) {
+		l := NewLimiter()
+		_, _ = l.Reserve("kids/ingress", 25000, 200, maxWait, now)
+		wait, ok := l.Reserve("teens/ingress", 1500, 200, maxWait, now)
+		assert.True(t, ok)
+		assert.Zero(t, wait)
+	})
+
+	t.Run("Refill is capped at the burst", func(t *testing.T) {
+		l := NewLimiter()
+		_, _ = l.Reserve("kids/ingress", 1, 200, maxWait, now)
+		_, ok := l.Reserve("kids/ingress", 31000, 200, maxWait, now.Add(time.Hour))
+		assert.False(t, ok, "expected idle time not to build up more than one second of traffic")
+	})
+
+	t.Run("Disabled without a rate", func(t *testing.T) {
+		wait, ok := NewLimiter().Reserve("kids/ingress", 1<<20, 0, maxWait, now)
+		assert.True(t, ok)
+		assert.Zero(t, wait)
+	})
+}
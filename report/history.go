@@ -0,0 +1,126 @@
+package report
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+const retention = 5 * 7 * 24 * time.Hour // retention is how long usage is kept, enough to compare a week with the one before.
+
+var (
+	defaultHistoryFilePath = "usage-reports.yaml"
+	fnGetHistory           = config.GetConfig[savedHistory]
+	fnSetHistory           = config.SetConfig[savedHistory]
+)
+
+func init() {
+	config.Backups.Register(defaultHistoryFilePath, "weekly report history")
+}
+
+// savedHistory is the usage-reports.yaml file in the app's home directory.
+type savedHistory struct {
+	LastSent time.Time                          `yaml:"lastSent"` // LastSent is when the last reports were due.
+	Groups   map[models.Group]savedGroupHistory `yaml:"groups"`
+}
+
+type savedGroupHistory struct {
+	Hours   []savedHour   `yaml:"hours"`
+	Domains []savedDomain `yaml:"domains"`
+}
+
+type savedHour struct {
+	Start   time.Time `yaml:"start"`
+	Minutes int       `yaml:"minutes"`
+}
+
+type savedDomain struct {
+	Day    time.Time     `yaml:"day"`
+	Domain models.Domain `yaml:"domain"`
+	Bytes  int64         `yaml:"bytes"`
+}
+
+// groupHistory is the usage of a group kept for its reports.
+type groupHistory struct {
+	minutes map[time.Time]int                     // minutes are the minutes used in each local hour, keyed by its start in UTC.
+	bytes   map[time.Time]map[models.Domain]int64 // bytes are the traffic with each domain on each day, keyed by local midnight.
+}
+
+func newGroupHistory() *groupHistory {
+	return &groupHistory{minutes: make(map[time.Time]int), bytes: make(map[time.Time]map[models.Domain]int64)}
+}
+
+// addMinutes records the minutes used in the hour. Each hour keeps the most minutes seen for it since the tracker's
+// samples only go back to the start of its window, which can begin part way through an hour.
+func (gh *groupHistory) addMinutes(hour time.Time, minutes int) {
+	gh.minutes[hour] = max(gh.minutes[hour], minutes)
+}
+
+func (gh *groupHistory) addBytes(day time.Time, domain models.Domain, bytes int64) {
+	if gh.bytes[day] == nil {
+		gh.bytes[day] = make(map[models.Domain]int64)
+	}
+	gh.bytes[day][domain] += bytes
+}
+
+// prune drops the usage from before the cutoff.
+func (gh *groupHistory) prune(cutoff time.Time) {
+	for hour := range gh.minutes {
+		if hour.Before(cutoff) {
+			delete(gh.minutes, hour)
+		}
+	}
+	for day := range gh.bytes {
+		if day.AddDate(0, 0, 1).Before(cutoff) {
+			delete(gh.bytes, day)
+		}
+	}
+}
+
+// loadHistory reads the history file into groups and returns when the last reports were due.
+func loadHistory(mu *sync.Mutex) (map[models.Group]*groupHistory, time.Time, error) {
+	saved, err := fnGetHistory(mu, defaultHistoryFilePath, func() savedHistory { return savedHistory{} })
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	groups := make(map[models.Group]*groupHistory)
+	for g, sg := range saved.Groups {
+		gh := newGroupHistory()
+		for _, h := range sg.Hours {
+			gh.addMinutes(h.Start.UTC(), h.Minutes)
+		}
+		for _, d := range sg.Domains {
+			gh.addBytes(d.Day.In(time.Local), d.Domain, d.Bytes)
+		}
+		groups[g] = gh
+	}
+	return groups, saved.LastSent, nil
+}
+
+// saveHistory writes the groups and when the last reports were due to the history file.
+func saveHistory(mu *sync.Mutex, groups map[models.Group]*groupHistory, lastSent time.Time) error {
+	saved := savedHistory{LastSent: lastSent, Groups: make(map[models.Group]savedGroupHistory)}
+	for g, gh := range groups {
+		var sg savedGroupHistory
+		for hour, minutes := range gh.minutes {
+			if minutes > 0 {
+				sg.Hours = append(sg.Hours, savedHour{Start: hour, Minutes: minutes})
+			}
+		}
+		for day, domains := range gh.bytes {
+			for domain, bytes := range domains {
+				sg.Domains = append(sg.Domains, savedDomain{Day: day, Domain: domain, Bytes: bytes})
+			}
+		}
+		slices.SortFunc(sg.Hours, func(a, b savedHour) int { return a.Start.Compare(b.Start) })
+		slices.SortFunc(sg.Domains, func(a, b savedDomain) int {
+			return cmp.Or(a.Day.Compare(b.Day), cmp.Compare(a.Domain, b.Domain))
+		})
+		saved.Groups[g] = sg
+	}
+	return fnSetHistory(mu, defaultHistoryFilePath, nil, func(savedHistory) {}, saved)
+}
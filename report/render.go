@@ -0,0 +1,145 @@
+package report
+
+import (
+	"cmp"
+	"embed"
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"relloyd/tubetimeout/models"
+)
+
+const (
+	reportDays      = 7
+	maxBusiestHours = 3
+	maxTopDomains   = 10
+)
+
+//go:embed templates/*
+var embeddedFiles embed.FS
+
+var reportTemplate = template.Must(template.New("weekly-report.html").Funcs(template.FuncMap{
+	"duration": minutesText,
+	"size":     bytesText,
+	"hour":     hourText,
+}).ParseFS(embeddedFiles, "templates/weekly-report.html"))
+
+// buildReport returns the group's usage over the days up to and including the day of end, stopping at end.
+func buildReport(group models.Group, gh *groupHistory, end time.Time) models.UsageReport {
+	from := localDay(end).AddDate(0, 0, 1-reportDays)
+	rep := models.UsageReport{
+		Group:        group,
+		From:         from,
+		To:           end,
+		Days:         make([]models.DayUsage, reportDays),
+		BusiestHours: make([]models.HourOfDayUsage, 0),
+		TopDomains:   make([]models.DomainUsage, 0),
+	}
+	for i := range rep.Days {
+		rep.Days[i].Date = from.AddDate(0, 0, i).Format(time.DateOnly)
+	}
+
+	hours := make(map[int]int)
+	for hour, minutes := range gh.minutes {
+		switch {
+		case !hour.Before(from) && hour.Before(end):
+			local := hour.In(time.Local)
+			rep.TotalMinutes += minutes
+			if i := slices.IndexFunc(rep.Days, func(d models.DayUsage) bool { return d.Date == local.Format(time.DateOnly) }); i >= 0 {
+				rep.Days[i].Minutes += minutes
+			}
+			hours[local.Hour()] += minutes
+		case !hour.Before(from.AddDate(0, 0, -reportDays)) && hour.Before(from):
+			rep.PreviousMinutes += minutes
+		}
+	}
+	for hour, minutes := range hours {
+		if minutes > 0 {
+			rep.BusiestHours = append(rep.BusiestHours, models.HourOfDayUsage{Hour: hour, Minutes: minutes})
+		}
+	}
+	slices.SortFunc(rep.BusiestHours, func(a, b models.HourOfDayUsage) int {
+		return cmp.Or(cmp.Compare(b.Minutes, a.Minutes), cmp.Compare(a.Hour, b.Hour))
+	})
+	rep.BusiestHours = rep.BusiestHours[:min(len(rep.BusiestHours), maxBusiestHours)]
+
+	domains := make(map[models.Domain]int64)
+	for day, counts := range gh.bytes {
+		if !day.Before(from) && day.Before(end) {
+			for domain, b := range counts {
+				domains[domain] += b
+			}
+		}
+	}
+	for _, domain := range slices.Sorted(maps.Keys(domains)) {
+		rep.TopDomains = append(rep.TopDomains, models.DomainUsage{Domain: domain, Bytes: domains[domain]})
+	}
+	slices.SortStableFunc(rep.TopDomains, func(a, b models.DomainUsage) int { return cmp.Compare(b.Bytes, a.Bytes) })
+	rep.TopDomains = rep.TopDomains[:min(len(rep.TopDomains), maxTopDomains)]
+	return rep
+}
+
+// WriteHTML renders the reports as an HTML page that stands alone, so that it can be emailed or downloaded.
+func WriteHTML(w io.Writer, reports []models.UsageReport) error {
+	return reportTemplate.Execute(w, reports)
+}
+
+// summaryText returns the report as a short message for providers that only send text.
+func summaryText(rep models.UsageReport) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%v used %v in the week to %v", rep.Group, minutesText(rep.TotalMinutes), rep.To.Format("Mon 2 Jan"))
+	switch diff := rep.TotalMinutes - rep.PreviousMinutes; {
+	case diff > 0:
+		fmt.Fprintf(&sb, ", %v more than the week before", minutesText(diff))
+	case diff < 0:
+		fmt.Fprintf(&sb, ", %v less than the week before", minutesText(-diff))
+	}
+	sb.WriteString(".")
+	if len(rep.BusiestHours) > 0 {
+		var hours []string
+		for _, h := range rep.BusiestHours {
+			hours = append(hours, hourText(h.Hour))
+		}
+		fmt.Fprintf(&sb, " Busiest hours: %v.", strings.Join(hours, ", "))
+	}
+	if len(rep.TopDomains) > 0 {
+		var domains []string
+		for _, d := range rep.TopDomains[:min(len(rep.TopDomains), maxBusiestHours)] {
+			domains = append(domains, string(d.Domain))
+		}
+		fmt.Fprintf(&sb, " Top domains: %v.", strings.Join(domains, ", "))
+	}
+	return sb.String()
+}
+
+// minutesText returns the minutes as hours and minutes, e.g. "2h 05m".
+func minutesText(minutes int) string {
+	if minutes < 60 {
+		return fmt.Sprintf("%dm", minutes)
+	}
+	return fmt.Sprintf("%dh %02dm", minutes/60, minutes%60)
+}
+
+// hourText returns the hour of the day as the start of a 24-hour clock hour, e.g. "19:00".
+func hourText(hour int) string {
+	return fmt.Sprintf("%02d:00", hour)
+}
+
+// bytesText returns the bytes in the largest unit that keeps a whole number in front, e.g. "1.5 GB".
+func bytesText(b int64) string {
+	const unit = 1000
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(b)/float64(div), "kMGTPE"[exp])
+}
@@ -0,0 +1,256 @@
+package report
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+const (
+	collectInterval = 5 * time.Minute // collectInterval is how often the tracker's samples and the domain counts are recorded.
+	saveInterval    = time.Hour       // saveInterval is how often the history file is written.
+)
+
+// SampleSource returns the groups being tracked and the samples in their current windows.
+type SampleSource interface {
+	GetSummary() map[string]*models.TrackerSummary
+	GetSamples(id string) (models.TrackerSamples, error)
+}
+
+// Sender delivers a group's weekly report, e.g. as a notification.
+type Sender interface {
+	SendReport(group models.Group, text, html string)
+}
+
+// Reporter records the minutes each group uses per hour and its traffic with each domain per day, and makes weekly
+// reports of them that are sent on the day and hour configured, or downloaded from the API.
+type Reporter struct {
+	logger    *zap.SugaredLogger
+	cfg       *config.ReportConfig
+	tracker   SampleSource
+	sender    Sender
+	fileMu    sync.Mutex
+	mu        sync.Mutex
+	groups    map[models.Group]*groupHistory // groups are the usage recorded, guarded by mu.
+	lastSent  time.Time                      // lastSent is when the last reports were due, guarded by mu.
+	muCount   sync.Mutex
+	ipDomains models.MapIpDomain                       // ipDomains are the domains of the tracked IPs, guarded by muCount.
+	counts    map[models.Group]map[models.Domain]int64 // counts are the bytes counted since the last collect, guarded by muCount.
+	nowFunc   func() time.Time
+}
+
+// NewReporter loads the usage recorded so far and records more until ctx is done. Reports are sent to sender, which
+// may be nil if they're only downloaded.
+func NewReporter(ctx context.Context, logger *zap.SugaredLogger, cfg *config.ReportConfig, tracker SampleSource, sender Sender) (*Reporter, error) {
+	if cfg.Day < 0 || cfg.Day > 6 || cfg.Hour < 0 || cfg.Hour > 23 {
+		return nil, fmt.Errorf("report day must be 0 to 6 and hour 0 to 23, got day %v and hour %v", cfg.Day, cfg.Hour)
+	}
+	r := &Reporter{
+		logger:    logger,
+		cfg:       cfg,
+		tracker:   tracker,
+		sender:    sender,
+		ipDomains: make(models.MapIpDomain),
+		counts:    make(map[models.Group]map[models.Domain]int64),
+		nowFunc:   time.Now,
+	}
+
+	var err error
+	r.groups, r.lastSent, err = loadHistory(&r.fileMu)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load report history: %w", err)
+	}
+	if r.lastSent.IsZero() { // if this is the first run, don't send reports for a week that wasn't recorded...
+		r.lastSent = lastDue(r.cfg, r.nowFunc())
+	}
+
+	go r.startWorker(ctx)
+	return r, nil
+}
+
+func (r *Reporter) startWorker(ctx context.Context) {
+	ticker := time.NewTicker(collectInterval)
+	defer ticker.Stop()
+	lastSave := r.nowFunc()
+	for {
+		select {
+		case <-ctx.Done():
+			r.collect(r.nowFunc())
+			r.save()
+			return
+		case <-ticker.C:
+			now := r.nowFunc()
+			r.collect(now)
+			if r.sendDue(now) || now.Sub(lastSave) >= saveInterval {
+				r.save()
+				lastSave = now
+			}
+		}
+	}
+}
+
+// UpdateDestIpDomains implements the DestIpDomainReceiver interface so that the traffic counted can be put against
+// the domains of the IPs.
+func (r *Reporter) UpdateDestIpDomains(newIps models.MapIpDomain) {
+	r.muCount.Lock()
+	defer r.muCount.Unlock()
+	r.ipDomains = newIps
+}
+
+// CountDestination implements the DestinationCounter interface to count a group's traffic with a domain. Traffic
+// with IPs whose domains aren't known yet isn't counted.
+func (r *Reporter) CountDestination(group models.Group, ip models.Ip, bytes int) {
+	r.muCount.Lock()
+	defer r.muCount.Unlock()
+	domain, ok := r.ipDomains[ip]
+	if !ok {
+		return
+	}
+	if r.counts[group] == nil {
+		r.counts[group] = make(map[models.Domain]int64)
+	}
+	r.counts[group][domain] += int64(bytes)
+}
+
+// collect records the minutes in the tracker's samples and moves the domain counts into today's usage.
+func (r *Reporter) collect(now time.Time) {
+	r.muCount.Lock()
+	counts := r.counts
+	r.counts = make(map[models.Group]map[models.Domain]int64)
+	r.muCount.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id := range r.tracker.GetSummary() {
+		samples, err := r.tracker.GetSamples(id)
+		if err != nil { // if the group was removed since...
+			continue
+		}
+		used := make(map[time.Time]int)
+		for _, s := range samples.Samples {
+			if s.Active {
+				used[localHour(s.Time)]++
+			}
+		}
+		gh := r.group(models.Group(id))
+		for hour, n := range used {
+			gh.addMinutes(hour, int(time.Duration(n)*time.Duration(samples.Granularity)*time.Second/time.Minute))
+		}
+	}
+	today := localDay(now)
+	for group, domains := range counts {
+		gh := r.group(group)
+		for domain, b := range domains {
+			gh.addBytes(today, domain, b)
+		}
+	}
+	for _, gh := range r.groups {
+		gh.prune(now.Add(-retention))
+	}
+}
+
+// group returns the usage of the group, adding it if it's new. This should be done under r.mu.
+func (r *Reporter) group(group models.Group) *groupHistory {
+	gh, ok := r.groups[group]
+	if !ok {
+		gh = newGroupHistory()
+		r.groups[group] = gh
+	}
+	return gh
+}
+
+func (r *Reporter) save() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := saveHistory(&r.fileMu, r.groups, r.lastSent); err != nil {
+		r.logger.Errorf("Failed to save report history: %v", err)
+	}
+}
+
+// sendDue sends the reports of the week ending now if they're due and haven't been sent, and returns true if they were.
+func (r *Reporter) sendDue(now time.Time) bool {
+	due := lastDue(r.cfg, now)
+	r.mu.Lock()
+	if !due.After(r.lastSent) {
+		r.mu.Unlock()
+		return false
+	}
+	r.lastSent = due
+	reports := r.reports(now)
+	r.mu.Unlock()
+
+	if r.sender == nil {
+		return true
+	}
+	for _, rep := range reports {
+		var html bytes.Buffer
+		if err := WriteHTML(&html, []models.UsageReport{rep}); err != nil {
+			r.logger.Errorf("Failed to render the weekly report of group %v: %v", rep.Group, err)
+			continue
+		}
+		r.sender.SendReport(rep.Group, summaryText(rep), html.String())
+	}
+	r.logger.Infof("Sent %v weekly reports", len(reports))
+	return true
+}
+
+// Reports returns the reports of every group for the week ending at end, sorted by group.
+func (r *Reporter) Reports(end time.Time) []models.UsageReport {
+	r.collect(r.nowFunc()) // so that the minutes since the last collect are included.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reports(end)
+}
+
+// Report returns the group's report for the week ending at end.
+func (r *Reporter) Report(group models.Group, end time.Time) (models.UsageReport, error) {
+	r.collect(r.nowFunc())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	gh, ok := r.groups[group]
+	if !ok {
+		return models.UsageReport{}, models.ErrGroupNotFound
+	}
+	return buildReport(group, gh, end), nil
+}
+
+// reports should be called under r.mu.
+func (r *Reporter) reports(end time.Time) []models.UsageReport {
+	retval := make([]models.UsageReport, 0, len(r.groups))
+	for _, group := range slices.Sorted(maps.Keys(r.groups)) {
+		retval = append(retval, buildReport(group, r.groups[group], end))
+	}
+	return retval
+}
+
+// lastDue returns the latest time the reports were due at or before now.
+func lastDue(cfg *config.ReportConfig, now time.Time) time.Time {
+	local := now.In(time.Local)
+	due := time.Date(local.Year(), local.Month(), local.Day(), cfg.Hour, 0, 0, 0, time.Local)
+	due = due.AddDate(0, 0, -((int(local.Weekday()) - cfg.Day + 7) % 7))
+	if due.After(now) {
+		due = due.AddDate(0, 0, -7)
+	}
+	return due
+}
+
+// localHour returns the start of the local hour of t in UTC. Unlike t.Truncate, it keeps to the local clock in time
+// zones that are offset by part of an hour.
+func localHour(t time.Time) time.Time {
+	local := t.In(time.Local)
+	return time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), 0, 0, 0, time.Local).UTC()
+}
+
+// localDay returns the local midnight that starts the day of t.
+func localDay(t time.Time) time.Time {
+	local := t.In(time.Local)
+	return time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.Local)
+}
@@ -0,0 +1,174 @@
+package report
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+type mockSampleSource struct {
+	samples map[string]models.TrackerSamples
+}
+
+func (m *mockSampleSource) GetSummary() map[string]*models.TrackerSummary {
+	retval := make(map[string]*models.TrackerSummary)
+	for id := range m.samples {
+		retval[id] = &models.TrackerSummary{}
+	}
+	return retval
+}
+
+func (m *mockSampleSource) GetSamples(id string) (models.TrackerSamples, error) {
+	s, ok := m.samples[id]
+	if !ok {
+		return models.TrackerSamples{}, models.ErrGroupNotFound
+	}
+	return s, nil
+}
+
+type mockSender struct {
+	groups []models.Group
+	texts  []string
+	htmls  []string
+}
+
+func (m *mockSender) SendReport(group models.Group, text, html string) {
+	m.groups = append(m.groups, group)
+	m.texts = append(m.texts, text)
+	m.htmls = append(m.htmls, html)
+}
+
+// activeSamples returns minute samples from start with the first n active.
+func activeSamples(start time.Time, length, n int) models.TrackerSamples {
+	s := models.TrackerSamples{WindowStart: start, Granularity: 60, Samples: make([]models.TrackerSample, length)}
+	for i := range s.Samples {
+		s.Samples[i] = models.TrackerSample{Time: start.Add(time.Duration(i) * time.Minute), Active: i < n}
+	}
+	return s
+}
+
+func newTestReporter(tracker SampleSource, sender Sender, now time.Time) *Reporter {
+	return &Reporter{
+		logger:    config.MustGetLogger(),
+		cfg:       &config.ReportConfig{Day: int(time.Sunday), Hour: 18},
+		tracker:   tracker,
+		sender:    sender,
+		groups:    make(map[models.Group]*groupHistory),
+		ipDomains: make(models.MapIpDomain),
+		counts:    make(map[models.Group]map[models.Domain]int64),
+		nowFunc:   func() time.Time { return now },
+	}
+}
+
+func TestReporter_Report(t *testing.T) {
+	sunday := time.Date(2025, 3, 2, 18, 0, 0, 0, time.Local)
+	tracker := &mockSampleSource{samples: map[string]models.TrackerSamples{
+		"kids": activeSamples(time.Date(2025, 3, 1, 19, 0, 0, 0, time.Local), 120, 90), // Saturday 19:00 to 20:30.
+	}}
+	r := newTestReporter(tracker, nil, sunday)
+
+	r.UpdateDestIpDomains(models.MapIpDomain{"1.1.1.1": "youtube.com", "2.2.2.2": "googlevideo.com"})
+	r.CountDestination("kids", "1.1.1.1", 1000)
+	r.CountDestination("kids", "2.2.2.2", 5000)
+	r.CountDestination("kids", "3.3.3.3", 9000) // unknown IPs aren't counted.
+	r.collect(sunday)
+
+	// The week before and a window that started part way through an hour don't lose minutes.
+	r.groups["kids"].addMinutes(time.Date(2025, 2, 22, 10, 0, 0, 0, time.Local).UTC(), 45)
+	tracker.samples["kids"] = activeSamples(time.Date(2025, 3, 1, 20, 0, 0, 0, time.Local), 10, 10)
+	r.collect(sunday)
+
+	rep, err := r.Report("kids", sunday)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 2, 24, 0, 0, 0, 0, time.Local), rep.From, "expected the week to start on the Monday")
+	assert.Equal(t, 90, rep.TotalMinutes)
+	assert.Equal(t, 45, rep.PreviousMinutes)
+	if assert.Len(t, rep.Days, 7) {
+		assert.Equal(t, models.DayUsage{Date: "2025-03-01", Minutes: 90}, rep.Days[5])
+		assert.Equal(t, models.DayUsage{Date: "2025-03-02", Minutes: 0}, rep.Days[6])
+	}
+	assert.Equal(t, []models.HourOfDayUsage{{Hour: 19, Minutes: 60}, {Hour: 20, Minutes: 30}}, rep.BusiestHours)
+	assert.Equal(t, []models.DomainUsage{{Domain: "googlevideo.com", Bytes: 5000}, {Domain: "youtube.com", Bytes: 1000}}, rep.TopDomains)
+
+	_, err = r.Report("teens", sunday)
+	assert.ErrorIs(t, err, models.ErrGroupNotFound)
+	assert.Len(t, r.Reports(sunday), 1)
+}
+
+func TestReporter_SendDue(t *testing.T) {
+	sunday := time.Date(2025, 3, 2, 18, 0, 0, 0, time.Local)
+	tracker := &mockSampleSource{samples: map[string]models.TrackerSamples{
+		"kids": activeSamples(time.Date(2025, 3, 1, 19, 0, 0, 0, time.Local), 60, 60),
+	}}
+	sender := &mockSender{}
+	r := newTestReporter(tracker, sender, sunday)
+	r.lastSent = sunday.AddDate(0, 0, -7)
+	r.collect(sunday)
+
+	assert.False(t, r.sendDue(sunday.Add(-time.Minute)), "expected nothing to be sent before the reports are due")
+	assert.True(t, r.sendDue(sunday.Add(time.Minute)))
+	if assert.Len(t, sender.groups, 1) {
+		assert.Equal(t, models.Group("kids"), sender.groups[0])
+		assert.Equal(t, "kids used 1h 00m in the week to Sun 2 Mar, 1h 00m more than the week before. Busiest hours: 19:00.", sender.texts[0])
+		assert.Contains(t, sender.htmls[0], "<h2>kids</h2>")
+	}
+	assert.False(t, r.sendDue(sunday.Add(time.Hour)), "expected the reports to be sent once a week")
+	assert.Len(t, sender.groups, 1)
+}
+
+func TestLastDue(t *testing.T) {
+	cfg := &config.ReportConfig{Day: int(time.Sunday), Hour: 18}
+	sunday := time.Date(2025, 3, 2, 18, 0, 0, 0, time.Local)
+	assert.Equal(t, sunday, lastDue(cfg, sunday))
+	assert.Equal(t, sunday, lastDue(cfg, time.Date(2025, 3, 5, 9, 0, 0, 0, time.Local)))
+	assert.Equal(t, sunday.AddDate(0, 0, -7), lastDue(cfg, sunday.Add(-time.Second)))
+}
+
+func TestHistory_SaveAndLoad(t *testing.T) {
+	originalGet, originalSet := fnGetHistory, fnSetHistory
+	t.Cleanup(func() { fnGetHistory, fnSetHistory = originalGet, originalSet })
+	var saved savedHistory
+	fnSetHistory = func(_ *sync.Mutex, _ string, _ func(savedHistory) error, _ func(savedHistory), v savedHistory) error {
+		saved = v
+		return nil
+	}
+	fnGetHistory = func(_ *sync.Mutex, _ string, _ func() savedHistory) (savedHistory, error) {
+		return saved, nil
+	}
+
+	hour := time.Date(2025, 3, 1, 19, 0, 0, 0, time.UTC)
+	gh := newGroupHistory()
+	gh.addMinutes(hour, 30)
+	gh.addBytes(localDay(hour), "youtube.com", 1000)
+	lastSent := time.Date(2025, 2, 23, 18, 0, 0, 0, time.Local)
+	var mu sync.Mutex
+	assert.NoError(t, saveHistory(&mu, map[models.Group]*groupHistory{"kids": gh}, lastSent))
+
+	groups, gotLastSent, err := loadHistory(&mu)
+	assert.NoError(t, err)
+	assert.True(t, lastSent.Equal(gotLastSent))
+	if assert.Contains(t, groups, models.Group("kids")) {
+		assert.Equal(t, gh.minutes, groups["kids"].minutes)
+		assert.Equal(t, gh.bytes, groups["kids"].bytes)
+	}
+
+	gh.prune(hour.Add(retention + time.Hour))
+	assert.Empty(t, gh.minutes, "expected usage older than the retention to be dropped")
+	assert.Empty(t, gh.bytes)
+}
+
+func TestNewReporter_BadSchedule(t *testing.T) {
+	_, err := NewReporter(context.Background(), config.MustGetLogger(), &config.ReportConfig{Day: 7, Hour: 18}, &mockSampleSource{}, nil)
+	assert.Error(t, err)
+}
+
+func TestBytesText(t *testing.T) {
+	assert.Equal(t, "999 B", bytesText(999))
+	assert.Equal(t, "1.5 kB", bytesText(1500))
+	assert.Equal(t, "2.3 GB", bytesText(2_300_000_000))
+}
@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	_ "modernc.org/sqlite" // registers the "sqlite" driver.
+)
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS documents (
+	name       TEXT PRIMARY KEY,
+	data       BLOB NOT NULL,
+	updated_at TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS log_lines (
+	id   INTEGER PRIMARY KEY AUTOINCREMENT,
+	name TEXT NOT NULL,
+	line BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS log_lines_name ON log_lines (name, id);
+`
+
+var errStoreClosed = errors.New("storage is closed")
+
+// SQLiteStore keeps the documents and logs in a SQLite database in WAL mode. Writes are held in memory and flushed
+// together in one transaction every flush interval, so that a save of the samples and the lines logged since the
+// last one cost a single sync of the SD card instead of one each. Reads see the writes that haven't been flushed.
+// Documents and logs are keyed by the base name of the file the FileStore would use.
+type SQLiteStore struct {
+	logger *zap.SugaredLogger
+	db     *sql.DB
+	mu     sync.Mutex
+	docs   map[string][]byte      // docs are the documents written since the last flush, guarded by mu.
+	logs   map[string]*pendingLog // logs are the lines logged since the last flush, guarded by mu.
+	closed bool                   // closed is set once the database is closed, guarded by mu.
+}
+
+// pendingLog is the lines of a log that haven't been flushed.
+type pendingLog struct {
+	replace bool // replace is set if the flushed lines are to be deleted first, i.e. after WriteLines.
+	lines   [][]byte
+}
+
+// NewSQLiteStore opens or creates the database at path and flushes it every flushInterval until ctx is done, when
+// it's flushed and closed.
+func NewSQLiteStore(ctx context.Context, logger *zap.SugaredLogger, path string, flushInterval time.Duration) (*SQLiteStore, error) {
+	if flushInterval <= 0 {
+		return nil, fmt.Errorf("storage flush interval must be positive, got %v", flushInterval)
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database %q: %w", path, err)
+	}
+	db.SetMaxOpenConns(1) // there's only this process, so one connection avoids waiting on our own locks.
+	if _, err = db.ExecContext(ctx, sqliteSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to create the tables in database %q: %w", path, err)
+	}
+	s := &SQLiteStore{logger: logger, db: db, docs: make(map[string][]byte), logs: make(map[string]*pendingLog)}
+	go s.flushPeriodically(ctx, flushInterval)
+	return s, nil
+}
+
+func (s *SQLiteStore) flushPeriodically(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := s.Close(); err != nil {
+				s.logger.Errorf("Failed to close the storage database: %v", err)
+			}
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			err := s.flush()
+			s.mu.Unlock()
+			if err != nil {
+				s.logger.Errorf("Failed to flush the storage database, retrying next time: %v", err)
+			}
+		}
+	}
+}
+
+// Close flushes the writes and closes the database. Writes after it fail.
+func (s *SQLiteStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return errors.Join(s.flush(), s.db.Close())
+}
+
+func (s *SQLiteStore) Read(name string) ([]byte, error) {
+	key := filepath.Base(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if data, ok := s.docs[key]; ok {
+		return bytes.Clone(data), nil
+	}
+	if s.closed {
+		return nil, errStoreClosed
+	}
+	var data []byte
+	err := s.db.QueryRow(`SELECT data FROM documents WHERE name = ?`, key).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("document %q: %w", key, os.ErrNotExist)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read document %q: %w", key, err)
+	}
+	return data, nil
+}
+
+func (s *SQLiteStore) Write(name string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errStoreClosed
+	}
+	s.docs[filepath.Base(name)] = bytes.Clone(data)
+	return nil
+}
+
+// Rename flushes the writes so that the document can be moved in the database.
+func (s *SQLiteStore) Rename(name, newName string) error {
+	key, newKey := filepath.Base(name), filepath.Base(newName)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errStoreClosed
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err = tx.Exec(`DELETE FROM documents WHERE name = ?`, newKey); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`UPDATE documents SET name = ? WHERE name = ?`, newKey, key)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("document %q: %w", key, os.ErrNotExist)
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) Append(name string, line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errStoreClosed
+	}
+	p := s.pendingLog(filepath.Base(name))
+	p.lines = append(p.lines, bytes.Clone(line))
+	return nil
+}
+
+func (s *SQLiteStore) ReadLines(name string) ([][]byte, error) {
+	key := filepath.Base(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.logs[key]
+	if p != nil && p.replace {
+		return slices.Clone(p.lines), nil
+	}
+	if s.closed {
+		return nil, errStoreClosed
+	}
+	rows, err := s.db.Query(`SELECT line FROM log_lines WHERE name = ? ORDER BY id`, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read log %q: %w", key, err)
+	}
+	defer rows.Close()
+	var lines [][]byte
+	for rows.Next() {
+		var line []byte
+		if err = rows.Scan(&line); err != nil {
+			return nil, fmt.Errorf("failed to read log %q: %w", key, err)
+		}
+		lines = append(lines, line)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read log %q: %w", key, err)
+	}
+	if p != nil {
+		lines = append(lines, p.lines...)
+	}
+	return lines, nil
+}
+
+func (s *SQLiteStore) WriteLines(name string, lines [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errStoreClosed
+	}
+	p := s.pendingLog(filepath.Base(name))
+	p.replace = true
+	p.lines = p.lines[:0]
+	for _, line := range lines {
+		p.lines = append(p.lines, bytes.Clone(line))
+	}
+	return nil
+}
+
+// pendingLog returns the lines of the log that haven't been flushed, adding it if it's new. It should be called
+// under s.mu.
+func (s *SQLiteStore) pendingLog(key string) *pendingLog {
+	p, ok := s.logs[key]
+	if !ok {
+		p = &pendingLog{}
+		s.logs[key] = p
+	}
+	return p
+}
+
+// flush writes the pending documents and lines in one transaction. They're kept to retry if it fails. It should be
+// called under s.mu.
+func (s *SQLiteStore) flush() error {
+	if len(s.docs) == 0 && len(s.logs) == 0 {
+		return nil
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+	now := time.Now().UTC().Format(time.RFC3339)
+	for key, data := range s.docs {
+		if _, err = tx.Exec(`INSERT INTO documents (name, data, updated_at) VALUES (?, ?, ?)
+			ON CONFLICT (name) DO UPDATE SET data = excluded.data, updated_at = excluded.updated_at`, key, data, now); err != nil {
+			return fmt.Errorf("failed to write document %q: %w", key, err)
+		}
+	}
+	for key, p := range s.logs {
+		if p.replace {
+			if _, err = tx.Exec(`DELETE FROM log_lines WHERE name = ?`, key); err != nil {
+				return fmt.Errorf("failed to replace log %q: %w", key, err)
+			}
+		}
+		for _, line := range p.lines {
+			if _, err = tx.Exec(`INSERT INTO log_lines (name, line) VALUES (?, ?)`, key, line); err != nil {
+				return fmt.Errorf("failed to append to log %q: %w", key, err)
+			}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	clear(s.docs)
+	clear(s.logs)
+	return nil
+}
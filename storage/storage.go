@@ -0,0 +1,93 @@
+// Package storage keeps the data the app rewrites often, i.e. the tracker's samples and the mode history, in files in
+// the app's home directory or in a SQLite database, selected by STORAGE_BACKEND.
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+
+	"relloyd/tubetimeout/config"
+)
+
+const (
+	BackendFile   = "file"
+	BackendSQLite = "sqlite"
+)
+
+// Default is the store used by the tracker. main replaces it with a SQLite store when STORAGE_BACKEND=sqlite.
+var Default Store = FileStore{}
+
+// Store saves documents, which are replaced whole, and logs, which are appended to a line at a time. Names are the
+// paths of the files in the app's home directory that the file store uses.
+type Store interface {
+	// Read returns the document saved as name, or an error that wraps os.ErrNotExist if there isn't one.
+	Read(name string) ([]byte, error)
+	// Write replaces the document saved as name.
+	Write(name string, data []byte) error
+	// Rename moves the document saved as name to newName, replacing any there.
+	Rename(name, newName string) error
+	// Append adds a line to the log saved as name.
+	Append(name string, line []byte) error
+	// ReadLines returns the lines of the log saved as name, oldest first, or none if there isn't one.
+	ReadLines(name string) ([][]byte, error)
+	// WriteLines replaces the lines of the log saved as name.
+	WriteLines(name string, lines [][]byte) error
+}
+
+// FileStore saves each document and log in its own file.
+type FileStore struct{}
+
+func (FileStore) Read(name string) ([]byte, error) {
+	return os.ReadFile(name)
+}
+
+func (FileStore) Write(name string, data []byte) error {
+	return config.DefaultStore.WriteFile(name, string(data))
+}
+
+func (FileStore) Rename(name, newName string) error {
+	return os.Rename(name, newName)
+}
+
+func (FileStore) Append(name string, line []byte) error {
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (FileStore) ReadLines(name string) ([][]byte, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, bytes.Clone(scanner.Bytes()))
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %v: %w", name, err)
+	}
+	return lines, nil
+}
+
+func (FileStore) WriteLines(name string, lines [][]byte) error {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return config.DefaultStore.WriteFile(name, buf.String())
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+)
+
+// testStore checks the behaviour that the tracker relies on from every store.
+func testStore(t *testing.T, s Store, dir string) {
+	doc, kept, log := filepath.Join(dir, "samples.json"), filepath.Join(dir, "samples.json.unreadable"), filepath.Join(dir, "mode-history.jsonl")
+
+	_, err := s.Read(doc)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoError(t, s.Write(doc, []byte(`{"version":1}`)))
+	assert.NoError(t, s.Write(doc, []byte(`{"version":2}`)))
+	data, err := s.Read(doc)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"version":2}`, string(data))
+
+	assert.NoError(t, s.Rename(doc, kept))
+	_, err = s.Read(doc)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	data, err = s.Read(kept)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"version":2}`, string(data))
+
+	lines, err := s.ReadLines(log)
+	assert.NoError(t, err)
+	assert.Empty(t, lines)
+	assert.NoError(t, s.Append(log, []byte("a")))
+	assert.NoError(t, s.Append(log, []byte("b")))
+	lines, err = s.ReadLines(log)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b")}, lines)
+	assert.NoError(t, s.WriteLines(log, [][]byte{[]byte("b")}))
+	assert.NoError(t, s.Append(log, []byte("c")))
+	lines, err = s.ReadLines(log)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("b"), []byte("c")}, lines)
+}
+
+func TestFileStore(t *testing.T) {
+	testStore(t, FileStore{}, t.TempDir())
+}
+
+func TestSQLiteStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tubetimeout.db")
+	s, err := NewSQLiteStore(context.Background(), config.MustGetLogger(), path, time.Hour)
+	assert.NoError(t, err)
+	testStore(t, s, "/home/pi/.tubetimeout")
+
+	// Expect the writes to be saved once they're flushed, under the base names of the files.
+	assert.NoError(t, s.Write("/home/pi/.tubetimeout/device-samples.json", []byte("{}")))
+	assert.NoError(t, s.Close())
+	assert.ErrorIs(t, s.Write("samples.json", nil), errStoreClosed)
+
+	s, err = NewSQLiteStore(context.Background(), config.MustGetLogger(), path, time.Hour)
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+	data, err := s.Read("device-samples.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(data))
+	lines, err := s.ReadLines("mode-history.jsonl")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("b"), []byte("c")}, lines)
+}
+
+func TestNewSQLiteStore_FlushesPeriodically(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tubetimeout.db")
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := NewSQLiteStore(ctx, config.MustGetLogger(), path, 10*time.Millisecond)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Append("mode-history.jsonl", []byte("a")))
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.logs) == 0
+	}, time.Second, 10*time.Millisecond, "expected the lines to be flushed")
+	cancel()
+	assert.Eventually(t, func() bool {
+		return s.Append("mode-history.jsonl", []byte("b")) != nil
+	}, time.Second, 10*time.Millisecond, "expected the store to be closed once the context is done")
+}
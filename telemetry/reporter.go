@@ -0,0 +1,284 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+const (
+	payloadSchemaVersion = 1
+)
+
+var (
+	defaultSettingsFilePath            = "telemetry.yaml"
+	boardModelPath                     = "/proc/device-tree/model"
+	fnGetSettings                      = config.GetConfig[Settings]
+	fnSetSettings                      = config.SetConfig[Settings]
+	httpClient              HTTPClient = &http.Client{Timeout: 10 * time.Second}
+)
+
+func init() {
+	config.Backups.Register(defaultSettingsFilePath, "telemetry opt-in")
+}
+
+// HTTPClient interface for mocking.
+type HTTPClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// GroupConfigGetter returns the tracker group config used to count groups.
+type GroupConfigGetter interface {
+	GetConfig() (models.MapGroupTrackerConfig, error)
+}
+
+// GroupMACsGetter returns the group-MACs config used to count devices.
+type GroupMACsGetter interface {
+	GetConfig(logger *zap.SugaredLogger) (config.GroupMACsConfig, error)
+}
+
+// PacketCounter returns the total number of packets handled.
+type PacketCounter interface {
+	PacketCount() uint64
+}
+
+// Settings is the YAML structure saved to disk to remember whether the user opted in.
+type Settings struct {
+	Enabled    bool   `yaml:"enabled" json:"enabled"`
+	InstanceID string `yaml:"instanceId" json:"instanceId"` // random ID so reports can be de-duplicated without identifying the network.
+}
+
+// Payload contains anonymized aggregate stats only; it must never contain MACs, IPs or domains.
+type Payload struct {
+	SchemaVersion    int             `json:"schemaVersion"`
+	InstanceID       string          `json:"instanceId"`
+	BuildVersion     string          `json:"buildVersion"`
+	OS               string          `json:"os"`
+	Arch             string          `json:"arch"`
+	BoardModel       string          `json:"boardModel"`
+	NumCPU           int             `json:"numCPU"`
+	UptimeHours      int             `json:"uptimeHours"`
+	GroupCount       int             `json:"groupCount"`
+	DeviceCount      int             `json:"deviceCount"`
+	PacketsPerSecond float64         `json:"packetsPerSecond"`
+	Features         map[string]bool `json:"features"`
+}
+
+// Preview is returned to the web UI so the user can see exactly what would be sent.
+type Preview struct {
+	Settings Settings `json:"settings"`
+	Endpoint string   `json:"endpoint"`
+	Payload  Payload  `json:"payload"`
+}
+
+type Reporter struct {
+	logger      *zap.SugaredLogger
+	cfg         *config.TelemetryConfig
+	groups      GroupConfigGetter
+	groupMACs   GroupMACsGetter
+	packets     PacketCounter
+	startTime   time.Time
+	mu          sync.Mutex
+	settings    Settings
+	lastCount   uint64
+	lastCountAt time.Time
+	nowFunc     func() time.Time
+}
+
+// NewReporter loads the opt-in settings and starts a worker that submits stats periodically if the user has opted in.
+func NewReporter(ctx context.Context, logger *zap.SugaredLogger, cfg *config.TelemetryConfig, groups GroupConfigGetter, groupMACs GroupMACsGetter, packets PacketCounter) (*Reporter, error) {
+	if groups == nil || groupMACs == nil || packets == nil {
+		return nil, fmt.Errorf("telemetry sources must be supplied")
+	}
+
+	r := &Reporter{
+		logger:      logger,
+		cfg:         cfg,
+		groups:      groups,
+		groupMACs:   groupMACs,
+		packets:     packets,
+		startTime:   time.Now(),
+		lastCountAt: time.Now(),
+		nowFunc:     time.Now,
+	}
+
+	var err error
+	r.settings, err = fnGetSettings(&r.mu, defaultSettingsFilePath, func() Settings { return Settings{} })
+	if err != nil {
+		return nil, fmt.Errorf("failed to load telemetry settings: %w", err)
+	}
+
+	if cfg.Interval > 0 {
+		go r.startWorker(ctx)
+	}
+
+	return r, nil
+}
+
+func (r *Reporter) startWorker(ctx context.Context) {
+	ticker := time.NewTicker(r.cfg.Interval)
+	for {
+		select {
+		case <-ctx.Done():
+			ticker.Stop()
+			return
+		case <-ticker.C:
+			if err := r.submit(ctx); err != nil {
+				r.logger.Warnf("Telemetry: %v", err)
+			}
+		}
+	}
+}
+
+// GetPreview returns the current settings and the payload that would be sent next.
+func (r *Reporter) GetPreview() Preview {
+	r.mu.Lock()
+	s := r.settings
+	r.mu.Unlock()
+	return Preview{
+		Settings: s,
+		Endpoint: r.cfg.Endpoint,
+		Payload:  r.buildPayload(s.InstanceID),
+	}
+}
+
+// SetEnabled saves the user's opt-in choice.
+// An anonymous instance ID is generated the first time the user opts in.
+func (r *Reporter) SetEnabled(enabled bool) error {
+	r.mu.Lock()
+	s := r.settings
+	r.mu.Unlock()
+
+	s.Enabled = enabled
+	if enabled && s.InstanceID == "" {
+		id, err := newInstanceID()
+		if err != nil {
+			return fmt.Errorf("failed to generate telemetry instance ID: %w", err)
+		}
+		s.InstanceID = id
+	}
+
+	return fnSetSettings(&r.mu, defaultSettingsFilePath, nil, func(v Settings) { r.settings = v }, s)
+}
+
+// submit posts the payload to the configured endpoint if the user opted in.
+func (r *Reporter) submit(ctx context.Context) error {
+	r.mu.Lock()
+	s := r.settings
+	r.mu.Unlock()
+
+	if !s.Enabled || r.cfg.Endpoint == "" { // if there is nothing to do...
+		return nil
+	}
+
+	p := r.buildPayload(s.InstanceID)
+	r.resetPacketRate()
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.Endpoint, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to submit stats: %w", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status submitting stats: %v", resp.Status)
+	}
+
+	r.logger.Infof("Telemetry submitted to %v", r.cfg.Endpoint)
+	return nil
+}
+
+// buildPayload collects the anonymized stats.
+func (r *Reporter) buildPayload(instanceID string) Payload {
+	app := config.Current()
+	p := Payload{
+		SchemaVersion: payloadSchemaVersion,
+		InstanceID:    instanceID,
+		BuildVersion:  config.BuildVersion,
+		OS:            runtime.GOOS,
+		Arch:          runtime.GOARCH,
+		BoardModel:    getBoardModel(),
+		NumCPU:        runtime.NumCPU(),
+		UptimeHours:   int(r.nowFunc().Sub(r.startTime).Hours()),
+		Features: map[string]bool{
+			"dhcpServer":        !app.DHCPServerDisabled,
+			"dnsBlock":          app.DNSBlockConfig.DNSBlockEnabled,
+			"activityThreshold": app.ActivityMonitorConfig.EnableThresholdLogic,
+			"packetDropUDP":     app.FilterConfig.PacketDropUDP,
+		},
+	}
+
+	if gtc, err := r.groups.GetConfig(); err == nil {
+		p.GroupCount = len(gtc)
+	} else {
+		r.logger.Warnf("Telemetry failed to count groups: %v", err)
+	}
+
+	if gm, err := r.groupMACs.GetConfig(r.logger); err == nil {
+		for _, macs := range gm.Groups {
+			p.DeviceCount += len(macs)
+		}
+	} else {
+		r.logger.Warnf("Telemetry failed to count devices: %v", err)
+	}
+
+	r.mu.Lock()
+	elapsed := r.nowFunc().Sub(r.lastCountAt).Seconds()
+	if elapsed > 0 {
+		p.PacketsPerSecond = float64(r.packets.PacketCount()-r.lastCount) / elapsed
+	}
+	r.mu.Unlock()
+
+	return p
+}
+
+// resetPacketRate starts a new measurement period for the packet rate.
+func (r *Reporter) resetPacketRate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastCount = r.packets.PacketCount()
+	r.lastCountAt = r.nowFunc()
+}
+
+// getBoardModel returns the device tree model, e.g. "Raspberry Pi Zero 2 W Rev 1.0", or "unknown".
+func getBoardModel() string {
+	b, err := os.ReadFile(boardModelPath)
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(strings.Trim(string(b), "\x00"))
+}
+
+func newInstanceID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
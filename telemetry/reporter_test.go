@@ -0,0 +1,124 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+type mockGroups struct{}
+
+func (m *mockGroups) GetConfig() (models.MapGroupTrackerConfig, error) {
+	return models.MapGroupTrackerConfig{"kids": {}, "teens": {}}, nil
+}
+
+type mockGroupMACs struct{}
+
+func (m *mockGroupMACs) GetConfig(_ *zap.SugaredLogger) (config.GroupMACsConfig, error) {
+	return config.GroupMACsConfig{Groups: map[models.Group][]models.NamedMAC{
+		"kids":  {{MAC: "00-11-22-33-44-55", Name: "tablet"}, {MAC: "66-77-88-99-AA-BB", Name: "tv"}},
+		"teens": {{MAC: "CC-DD-EE-FF-00-11", Name: "laptop"}},
+	}}, nil
+}
+
+type mockPackets struct {
+	count uint64
+}
+
+func (m *mockPackets) PacketCount() uint64 {
+	return m.count
+}
+
+type mockHTTPClient struct {
+	requests []*http.Request
+	bodies   []string
+}
+
+func (m *mockHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	b, _ := io.ReadAll(req.Body)
+	m.requests = append(m.requests, req)
+	m.bodies = append(m.bodies, string(b))
+	return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: io.NopCloser(strings.NewReader(""))}, nil
+}
+
+func setupReporter(t *testing.T, settings Settings) (*Reporter, *mockHTTPClient, *mockPackets) {
+	originalGet, originalSet, originalClient := fnGetSettings, fnSetSettings, httpClient
+	t.Cleanup(func() {
+		fnGetSettings, fnSetSettings, httpClient = originalGet, originalSet, originalClient
+	})
+
+	fnGetSettings = func(_ *sync.Mutex, _ string, _ func() Settings) (Settings, error) {
+		return settings, nil
+	}
+	fnSetSettings = func(mu *sync.Mutex, _ string, _ func(v Settings) error, updateInMemory func(v Settings), v Settings) error {
+		mu.Lock()
+		defer mu.Unlock()
+		updateInMemory(v)
+		return nil
+	}
+	client := &mockHTTPClient{}
+	httpClient = client
+
+	packets := &mockPackets{}
+	r, err := NewReporter(context.Background(), config.MustGetLogger(), &config.TelemetryConfig{Endpoint: "http://localhost/report"}, &mockGroups{}, &mockGroupMACs{}, packets)
+	assert.NoError(t, err, "unexpected error creating reporter")
+	return r, client, packets
+}
+
+func TestNewReporter_NilSources(t *testing.T) {
+	_, err := NewReporter(context.Background(), config.MustGetLogger(), &config.TelemetryConfig{}, nil, &mockGroupMACs{}, &mockPackets{})
+	assert.Error(t, err, "expected error for nil sources")
+}
+
+func TestReporter_GetPreview(t *testing.T) {
+	r, _, packets := setupReporter(t, Settings{})
+
+	now := r.lastCountAt.Add(10 * time.Second)
+	r.nowFunc = func() time.Time { return now }
+	packets.count = 100
+
+	p := r.GetPreview()
+	assert.False(t, p.Settings.Enabled, "expected telemetry to be disabled by default")
+	assert.Equal(t, 2, p.Payload.GroupCount, "unexpected group count")
+	assert.Equal(t, 3, p.Payload.DeviceCount, "unexpected device count")
+	assert.InDelta(t, 10.0, p.Payload.PacketsPerSecond, 0.01, "unexpected packet rate")
+
+	// The payload must not leak any MACs or names.
+	b, err := json.Marshal(p.Payload)
+	assert.NoError(t, err)
+	for _, s := range []string{"00-11-22-33-44-55", "tablet", "kids"} {
+		assert.NotContains(t, string(b), s, "payload should not contain identifying data")
+	}
+}
+
+func TestReporter_Submit(t *testing.T) {
+	r, client, _ := setupReporter(t, Settings{})
+
+	// Case 1: nothing is sent until the user opts in.
+	assert.NoError(t, r.submit(context.Background()))
+	assert.Len(t, client.requests, 0, "expected no requests before opting in")
+
+	// Case 2: opting in generates an instance ID and allows submission.
+	assert.NoError(t, r.SetEnabled(true))
+	assert.NotEmpty(t, r.settings.InstanceID, "expected an instance ID to be generated")
+	assert.NoError(t, r.submit(context.Background()))
+	assert.Len(t, client.requests, 1, "expected a request after opting in")
+	assert.Contains(t, client.bodies[0], r.settings.InstanceID, "expected the payload to contain the instance ID")
+
+	// Case 3: opting out stops submission but keeps the instance ID.
+	id := r.settings.InstanceID
+	assert.NoError(t, r.SetEnabled(false))
+	assert.NoError(t, r.submit(context.Background()))
+	assert.Len(t, client.requests, 1, "expected no more requests after opting out")
+	assert.Equal(t, id, r.settings.InstanceID, "expected the instance ID to be kept")
+}
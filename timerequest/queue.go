@@ -0,0 +1,227 @@
+// Package timerequest keeps the requests for more time that devices make from the /my-time page, so that an admin can
+// approve or deny them instead of the nightly negotiation, with a record of each decision.
+package timerequest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+var (
+	ErrRequestNotFound      = errors.New("time request not found")
+	ErrRequestDecided       = errors.New("time request has already been decided")
+	ErrRequestPending       = errors.New("a time request is already pending for the group")
+	ErrInvalidRequest       = errors.New("invalid time request")
+	defaultRequestsFilePath = "time-requests.yaml"
+	fnGetRequests           = config.GetConfig[*requestsFile]
+	fnSetRequests           = config.SetConfig[*requestsFile]
+	nowFunc                 = time.Now
+)
+
+const (
+	maxRequestMinutes = 240 // maxRequestMinutes is the most time that can be asked for at once.
+	maxReasonLen      = 200 // maxReasonLen is the longest reason kept, in characters.
+	maxDecided        = 100 // maxDecided is the number of decided requests kept, after which the oldest are dropped.
+)
+
+func init() {
+	config.Backups.Register(defaultRequestsFilePath, "time requests")
+}
+
+// Tracker gives a group more time.
+type Tracker interface {
+	AddBonus(id string, minutes int) (int, error)
+	SetMode(id string, d time.Duration, mode models.UsageTrackerMode) error
+}
+
+// requestsFile is the YAML structure of the requests file.
+type requestsFile struct {
+	Requests []models.TimeRequest `yaml:"requests"`
+}
+
+// Queue holds the pending requests for more time, at most one per group, and the latest decided ones.
+type Queue struct {
+	logger    *zap.SugaredLogger
+	tracker   Tracker
+	mu        sync.Mutex // mu protects state and the file via fnGetRequests/fnSetRequests.
+	muApply   sync.Mutex // muApply serialises the changes.
+	state     *requestsFile
+	receivers []models.TimeRequestReceiver
+}
+
+// NewQueue loads the requests saved before a restart.
+func NewQueue(logger *zap.SugaredLogger, tracker Tracker) (*Queue, error) {
+	q := &Queue{logger: logger, tracker: tracker}
+	var err error
+	q.state, err = fnGetRequests(&q.mu, defaultRequestsFilePath, func() *requestsFile { return &requestsFile{} })
+	if err != nil {
+		return nil, fmt.Errorf("failed to load time requests: %w", err)
+	}
+	if q.state == nil { // if the file is new...
+		q.state = &requestsFile{}
+	}
+	return q, nil
+}
+
+// RegisterTimeRequestReceivers adds receivers to be sent new requests and decisions, e.g. to notify the admin.
+func (q *Queue) RegisterTimeRequestReceivers(receivers ...models.TimeRequestReceiver) {
+	q.receivers = append(q.receivers, receivers...)
+}
+
+// Request queues the device's request for more minutes for its group. An error wrapping ErrRequestPending is returned
+// if the group already has a request waiting, so that a device can't flood the admin with them.
+func (q *Queue) Request(group models.Group, mac models.MAC, minutes int, reason string) (models.TimeRequest, error) {
+	if group == "" {
+		return models.TimeRequest{}, fmt.Errorf("%w: the group is missing", ErrInvalidRequest)
+	}
+	if minutes <= 0 || minutes > maxRequestMinutes {
+		return models.TimeRequest{}, fmt.Errorf("%w: minutes must be between 1 and %v", ErrInvalidRequest, maxRequestMinutes)
+	}
+	if reason = strings.TrimSpace(reason); len([]rune(reason)) > maxReasonLen {
+		reason = string([]rune(reason)[:maxReasonLen])
+	}
+	id, err := randomID()
+	if err != nil {
+		return models.TimeRequest{}, err
+	}
+
+	q.muApply.Lock()
+	defer q.muApply.Unlock()
+	f := q.current()
+	if slices.ContainsFunc(f.Requests, func(r models.TimeRequest) bool { return r.Group == group && r.Status == models.TimeRequestPending }) {
+		return models.TimeRequest{}, fmt.Errorf("%w: %v", ErrRequestPending, group)
+	}
+	req := models.TimeRequest{ID: id, Group: group, MAC: mac, Minutes: minutes, Reason: reason, Status: models.TimeRequestPending, Created: nowFunc()}
+	if err = q.save(&requestsFile{Requests: append(slices.Clone(f.Requests), req)}); err != nil {
+		return models.TimeRequest{}, err
+	}
+	q.logger.Infof("Group %v asked for %v more minutes", group, minutes)
+	q.publish(req)
+	return req, nil
+}
+
+// Approve gives the request's group more time: minutes as a bonus on top of its threshold today, or allowed without
+// tracking for minutes if allow is set. Zero minutes grants the minutes asked for.
+func (q *Queue) Approve(id string, minutes int, allow bool, note string) (models.TimeRequest, error) {
+	if minutes < 0 || minutes > maxRequestMinutes {
+		return models.TimeRequest{}, fmt.Errorf("%w: minutes must be between 0 and %v", ErrInvalidRequest, maxRequestMinutes)
+	}
+	return q.decide(id, func(r *models.TimeRequest) error {
+		if minutes == 0 {
+			minutes = r.Minutes
+		}
+		var err error
+		if allow {
+			err = q.tracker.SetMode(string(r.Group), time.Duration(minutes)*time.Minute, models.ModeAllow)
+		} else {
+			_, err = q.tracker.AddBonus(string(r.Group), minutes)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to give group %v more time: %w", r.Group, err)
+		}
+		r.Status, r.Granted, r.Allow, r.Note = models.TimeRequestApproved, minutes, allow, note
+		return nil
+	})
+}
+
+// Deny turns the request down, with an optional note for the device.
+func (q *Queue) Deny(id string, note string) (models.TimeRequest, error) {
+	return q.decide(id, func(r *models.TimeRequest) error {
+		r.Status, r.Note = models.TimeRequestDenied, note
+		return nil
+	})
+}
+
+// decide changes the pending request with the id by fn and saves it, dropping the oldest decided requests past
+// maxDecided.
+func (q *Queue) decide(id string, fn func(r *models.TimeRequest) error) (models.TimeRequest, error) {
+	q.muApply.Lock()
+	defer q.muApply.Unlock()
+	f := q.current()
+	i := slices.IndexFunc(f.Requests, func(r models.TimeRequest) bool { return r.ID == id })
+	if i < 0 {
+		return models.TimeRequest{}, fmt.Errorf("%w: %v", ErrRequestNotFound, id)
+	}
+	req := f.Requests[i]
+	if req.Status != models.TimeRequestPending {
+		return models.TimeRequest{}, fmt.Errorf("%w: %v", ErrRequestDecided, id)
+	}
+	if err := fn(&req); err != nil {
+		return models.TimeRequest{}, err
+	}
+	req.Decided = nowFunc()
+	next := slices.Clone(f.Requests)
+	next[i] = req
+	if err := q.save(&requestsFile{Requests: trim(next)}); err != nil {
+		return models.TimeRequest{}, err
+	}
+	q.logger.Infof("Time request %v for group %v was %v", id, req.Group, req.Status)
+	q.publish(req)
+	return req, nil
+}
+
+// Requests returns the requests, newest first, optionally only those of the group and the status.
+func (q *Queue) Requests(group models.Group, status string) []models.TimeRequest {
+	f := q.current()
+	result := make([]models.TimeRequest, 0, len(f.Requests))
+	for i := len(f.Requests) - 1; i >= 0; i-- {
+		r := f.Requests[i]
+		if (group == "" || r.Group == group) && (status == "" || r.Status == status) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// trim drops the oldest decided requests past maxDecided. Pending requests are always kept.
+func trim(requests []models.TimeRequest) []models.TimeRequest {
+	decided := 0
+	for i := len(requests) - 1; i >= 0; i-- {
+		if requests[i].Status == models.TimeRequestPending {
+			continue
+		}
+		if decided++; decided > maxDecided {
+			requests = slices.Delete(requests, i, i+1)
+		}
+	}
+	return requests
+}
+
+func (q *Queue) publish(r models.TimeRequest) {
+	for _, receiver := range q.receivers {
+		receiver.UpdateTimeRequest(r)
+	}
+}
+
+func (q *Queue) save(f *requestsFile) error {
+	err := fnSetRequests(&q.mu, defaultRequestsFilePath, nil, func(v *requestsFile) { q.state = v }, f)
+	if err != nil {
+		return fmt.Errorf("failed to save time requests: %w", err)
+	}
+	return nil
+}
+
+// current returns the latest state. It mustn't be changed since it's replaced rather than updated.
+func (q *Queue) current() *requestsFile {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.state
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate time request ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,167 @@
+package timerequest
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+// mockRequestsFile replaces the requests file with one in memory and returns it.
+func mockRequestsFile(t *testing.T) **requestsFile {
+	origGet, origSet, origNow := fnGetRequests, fnSetRequests, nowFunc
+	t.Cleanup(func() {
+		fnGetRequests, fnSetRequests, nowFunc = origGet, origSet, origNow
+	})
+	var saved *requestsFile
+	fnGetRequests = func(mu *sync.Mutex, configPath string, newInstance func() *requestsFile) (*requestsFile, error) {
+		return saved, nil
+	}
+	fnSetRequests = func(mu *sync.Mutex, configPath string, validate func(v *requestsFile) error, updateInMemory func(v *requestsFile), v *requestsFile) error {
+		mu.Lock()
+		defer mu.Unlock()
+		saved = v
+		updateInMemory(v)
+		return nil
+	}
+	now := time.Date(2026, 10, 14, 19, 0, 0, 0, time.UTC)
+	nowFunc = func() time.Time { return now }
+	return &saved
+}
+
+type mockTracker struct {
+	bonus map[string]int
+	modes map[string]time.Duration
+	err   error
+}
+
+func (m *mockTracker) AddBonus(id string, minutes int) (int, error) {
+	if m.err != nil {
+		return 0, m.err
+	}
+	m.bonus[id] += minutes
+	return m.bonus[id], nil
+}
+
+func (m *mockTracker) SetMode(id string, d time.Duration, mode models.UsageTrackerMode) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.modes[id] = d
+	return nil
+}
+
+type mockReceiver struct {
+	requests []models.TimeRequest
+}
+
+func (m *mockReceiver) UpdateTimeRequest(r models.TimeRequest) {
+	m.requests = append(m.requests, r)
+}
+
+func newTestQueue(t *testing.T) (*Queue, *mockTracker, *mockReceiver) {
+	tracker := &mockTracker{bonus: make(map[string]int), modes: make(map[string]time.Duration)}
+	q, err := NewQueue(config.MustGetLogger(), tracker)
+	require.NoError(t, err)
+	receiver := &mockReceiver{}
+	q.RegisterTimeRequestReceivers(receiver)
+	return q, tracker, receiver
+}
+
+func TestQueue_Request(t *testing.T) {
+	saved := mockRequestsFile(t)
+	q, _, receiver := newTestQueue(t)
+
+	r, err := q.Request("kids", "aa:bb:cc:dd:ee:ff", 30, " homework ")
+	require.NoError(t, err)
+	assert.NotEmpty(t, r.ID)
+	assert.Equal(t, models.TimeRequestPending, r.Status)
+	assert.Equal(t, "homework", r.Reason)
+	assert.Len(t, (*saved).Requests, 1, "expected the request to be saved")
+	assert.Equal(t, []models.TimeRequest{r}, receiver.requests)
+
+	_, err = q.Request("kids", "aa:bb:cc:dd:ee:01", 15, "")
+	assert.ErrorIs(t, err, ErrRequestPending, "expected a second pending request for the group to be refused")
+	_, err = q.Request("teen", "aa:bb:cc:dd:ee:02", 15, "")
+	assert.NoError(t, err, "expected another group to be able to ask")
+
+	for _, minutes := range []int{0, -5, maxRequestMinutes + 1} {
+		_, err = q.Request("adults", "aa:bb:cc:dd:ee:03", minutes, "")
+		assert.ErrorIs(t, err, ErrInvalidRequest, "expected %v minutes to be invalid", minutes)
+	}
+	_, err = q.Request("", "aa:bb:cc:dd:ee:03", 10, "")
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+
+	requests := q.Requests("", "")
+	if assert.Len(t, requests, 2) {
+		assert.Equal(t, models.Group("teen"), requests[0].Group, "expected the newest first")
+	}
+	assert.Len(t, q.Requests("kids", models.TimeRequestPending), 1)
+	assert.Empty(t, q.Requests("kids", models.TimeRequestDenied))
+}
+
+func TestQueue_Approve(t *testing.T) {
+	mockRequestsFile(t)
+	q, tracker, receiver := newTestQueue(t)
+
+	r, err := q.Request("kids", "aa:bb:cc:dd:ee:ff", 30, "")
+	require.NoError(t, err)
+	approved, err := q.Approve(r.ID, 0, false, "just this once")
+	require.NoError(t, err)
+	assert.Equal(t, models.TimeRequestApproved, approved.Status)
+	assert.Equal(t, 30, approved.Granted, "expected zero minutes to grant those asked for")
+	assert.False(t, approved.Decided.IsZero())
+	assert.Equal(t, 30, tracker.bonus["kids"])
+	assert.Len(t, receiver.requests, 2, "expected the decision to be published")
+
+	_, err = q.Approve(r.ID, 0, false, "")
+	assert.ErrorIs(t, err, ErrRequestDecided)
+	_, err = q.Deny(r.ID, "")
+	assert.ErrorIs(t, err, ErrRequestDecided)
+	_, err = q.Approve("missing", 0, false, "")
+	assert.ErrorIs(t, err, ErrRequestNotFound)
+
+	r, err = q.Request("kids", "aa:bb:cc:dd:ee:ff", 30, "")
+	require.NoError(t, err, "expected the group to be able to ask again once decided")
+	approved, err = q.Approve(r.ID, 20, true, "")
+	require.NoError(t, err)
+	assert.True(t, approved.Allow)
+	assert.Equal(t, 20*time.Minute, tracker.modes["kids"])
+	assert.Equal(t, 30, tracker.bonus["kids"], "expected allowing not to add a bonus")
+
+	r, err = q.Request("kids", "aa:bb:cc:dd:ee:ff", 30, "")
+	require.NoError(t, err)
+	tracker.err = errors.New("unknown group")
+	_, err = q.Approve(r.ID, 0, false, "")
+	assert.Error(t, err)
+	assert.Len(t, q.Requests("kids", models.TimeRequestPending), 1, "expected the request to stay pending if the tracker fails")
+}
+
+func TestQueue_Deny(t *testing.T) {
+	mockRequestsFile(t)
+	q, tracker, _ := newTestQueue(t)
+
+	r, err := q.Request("kids", "aa:bb:cc:dd:ee:ff", 30, "")
+	require.NoError(t, err)
+	denied, err := q.Deny(r.ID, "bedtime")
+	require.NoError(t, err)
+	assert.Equal(t, models.TimeRequestDenied, denied.Status)
+	assert.Equal(t, "bedtime", denied.Note)
+	assert.Empty(t, tracker.bonus)
+}
+
+func TestTrim(t *testing.T) {
+	var requests []models.TimeRequest
+	for i := 0; i < maxDecided+5; i++ {
+		requests = append(requests, models.TimeRequest{ID: string(rune('a' + i%26)), Status: models.TimeRequestDenied})
+	}
+	requests[0].Status = models.TimeRequestPending
+	requests = trim(requests)
+	assert.Len(t, requests, maxDecided+1)
+	assert.Equal(t, models.TimeRequestPending, requests[0].Status, "expected pending requests to be kept")
+}
@@ -0,0 +1,130 @@
+// Package tr064 calls the TR-064 SOAP services that routers serve over UPnP, e.g. AVM Fritz!Box routers, answering
+// their digest auth challenges.
+package tr064
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	HostsURL           = "/upnp/control/hosts"
+	HostsService       = "urn:dslforum-org:service:Hosts:1"
+	soapEnvelopePrefix = `<?xml version="1.0" encoding="utf-8"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>`
+	soapEnvelopeSuffix = `</s:Body></s:Envelope>`
+)
+
+var reDigestParam = regexp.MustCompile(`(\w+)=(?:"([^"]*)"|([^,\s]*))`)
+
+// Client calls the services of the router at baseURL, e.g. http://fritz.box:49000.
+type Client struct {
+	baseURL  string
+	username string
+	password string
+	http     *http.Client
+	mu       sync.Mutex
+	digest   map[string]string // digest holds the last digest auth challenge.
+	nc       int               // nc counts the requests made with the current challenge.
+}
+
+func NewClient(baseURL, username, password string, client *http.Client) *Client {
+	return &Client{baseURL: strings.TrimSuffix(baseURL, "/"), username: username, password: password, http: client}
+}
+
+// Call calls action on the service with the given arguments and returns the response body.
+// Digest auth is answered when challenged.
+func (c *Client) Call(ctx context.Context, controlURL, service, action string, args [][2]string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var body bytes.Buffer
+	body.WriteString(soapEnvelopePrefix)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, service)
+	for _, a := range args {
+		fmt.Fprintf(&body, "<%s>", a[0])
+		_ = xml.EscapeText(&body, []byte(a[1]))
+		fmt.Fprintf(&body, "</%s>", a[0])
+	}
+	fmt.Fprintf(&body, "</u:%s>", action)
+	body.WriteString(soapEnvelopeSuffix)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+controlURL, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create TR-064 request: %w", err)
+		}
+		req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+		req.Header.Set("SoapAction", service+"#"+action)
+		if c.digest != nil {
+			req.Header.Set("Authorization", c.digestAuthorization(http.MethodPost, controlURL))
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("TR-064 request failed: %w", err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TR-064 response: %w", err)
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 { // if we need to answer a new challenge...
+			c.digest, c.nc = ParseDigestChallenge(resp.Header.Get("WWW-Authenticate")), 0
+			if c.digest == nil {
+				return nil, fmt.Errorf("TR-064 %v needs unsupported authentication", action)
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("TR-064 %v returned status %v", action, resp.StatusCode)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("TR-064 %v was not authorized", action)
+}
+
+// ParseDigestChallenge returns the parameters of a digest WWW-Authenticate header or nil for other schemes.
+func ParseDigestChallenge(header string) map[string]string {
+	scheme, params, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Digest") {
+		return nil
+	}
+	challenge := make(map[string]string)
+	for _, m := range reDigestParam.FindAllStringSubmatch(params, -1) {
+		challenge[strings.ToLower(m[1])] = m[2] + m[3] // only one of the quoted or unquoted values is set.
+	}
+	return challenge
+}
+
+// digestAuthorization answers the saved challenge using MD5 with qop=auth as described in RFC 2617.
+// It should be called under lock.
+func (c *Client) digestAuthorization(method, uri string) string {
+	hash := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	cnonceBytes := make([]byte, 8)
+	_, _ = rand.Read(cnonceBytes)
+	cnonce := hex.EncodeToString(cnonceBytes)
+	c.nc++
+	nc := fmt.Sprintf("%08x", c.nc)
+
+	ha1 := hash(c.username + ":" + c.digest["realm"] + ":" + c.password)
+	ha2 := hash(method + ":" + uri)
+	response := hash(ha1 + ":" + c.digest["nonce"] + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+	auth := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", qop=auth, nc=%s, cnonce="%s", response="%s"`,
+		c.username, c.digest["realm"], c.digest["nonce"], uri, nc, cnonce, response)
+	if opaque, ok := c.digest["opaque"]; ok {
+		auth += fmt.Sprintf(`, opaque="%s"`, opaque)
+	}
+	return auth
+}
@@ -0,0 +1,13 @@
+package tr064
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDigestChallenge(t *testing.T) {
+	c := ParseDigestChallenge(`Digest realm="HTTPS Access", nonce="ABC123", qop="auth,auth-int", algorithm=MD5`)
+	assert.Equal(t, map[string]string{"realm": "HTTPS Access", "nonce": "ABC123", "qop": "auth,auth-int", "algorithm": "MD5"}, c)
+	assert.Nil(t, ParseDigestChallenge(`Basic realm="x"`))
+}
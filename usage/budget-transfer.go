@@ -0,0 +1,98 @@
+package usage
+
+import (
+	"fmt"
+	"time"
+
+	"relloyd/tubetimeout/models"
+)
+
+// TransferBudget moves up to the remaining time of one group to another group for the current window only,
+// e.g. to finish a show on the TV after the tablet battery died. The total time across both groups does not
+// change and both trackers are adjusted under lock so the transfer is all or nothing.
+func (t *Tracker) TransferBudget(from, to string, minutes int) (models.BudgetTransfer, error) {
+	if minutes <= 0 {
+		return models.BudgetTransfer{}, fmt.Errorf("minutes must be positive")
+	}
+	if from == to {
+		return models.BudgetTransfer{}, fmt.Errorf("from and to groups must be different")
+	}
+
+	fromData, ok := t.devices.Load(from)
+	if !ok {
+		return models.BudgetTransfer{}, fmt.Errorf("%w: %v", models.ErrGroupNotFound, from)
+	}
+	toData, ok := t.devices.Load(to)
+	if !ok {
+		return models.BudgetTransfer{}, fmt.Errorf("%w: %v", models.ErrGroupNotFound, to)
+	}
+	ddFrom := fromData.(*deviceData)
+	ddTo := toData.(*deviceData)
+
+	// Lock in a consistent order to avoid deadlocks with concurrent transfers.
+	first, second := ddFrom, ddTo
+	if to < from {
+		first, second = ddTo, ddFrom
+	}
+	first.mu.Lock()
+	defer first.mu.Unlock()
+	second.mu.Lock()
+	defer second.mu.Unlock()
+
+	now := t.nowFunc()
+	ddFrom.syncWindow(t.logger, now)
+	ddTo.syncWindow(t.logger, now)
+
+	fromSamples := int(time.Duration(minutes) * time.Minute / ddFrom.config.Granularity)
+	toSamples := int(time.Duration(minutes) * time.Minute / ddTo.config.Granularity)
+	if fromSamples > remainingSamples(ddFrom) {
+		return models.BudgetTransfer{}, fmt.Errorf("%w: group %v has %v minutes remaining", models.ErrInsufficientBudget, from, remainingMinutes(ddFrom))
+	}
+
+	ddFrom.adjustment -= fromSamples
+	ddTo.adjustment += toSamples
+	ddFrom.verdict.Store(nil)
+	ddTo.verdict.Store(nil)
+
+	t.logger.Infof("Usage tracker transferred %v minutes from group %v to group %v", minutes, from, to)
+
+	return models.BudgetTransfer{
+		From:                 models.Group(from),
+		To:                   models.Group(to),
+		Minutes:              minutes,
+		FromRemainingMinutes: remainingMinutes(ddFrom),
+		ToRemainingMinutes:   remainingMinutes(ddTo),
+	}, nil
+}
+
+// AddBonus gives a group extra minutes for the current window only, e.g. for an approved request for more time. It
+// returns the group's remaining minutes.
+func (t *Tracker) AddBonus(id string, minutes int) (int, error) {
+	if minutes <= 0 {
+		return 0, fmt.Errorf("minutes must be positive")
+	}
+	data, ok := t.devices.Load(id)
+	if !ok {
+		return 0, fmt.Errorf("%w: %v", models.ErrGroupNotFound, id)
+	}
+	dd := data.(*deviceData)
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	dd.syncWindow(t.logger, t.nowFunc())
+	dd.adjustment += int(time.Duration(minutes) * time.Minute / dd.config.Granularity)
+	dd.verdict.Store(nil)
+	t.logger.Infof("Usage tracker gave group %v %v bonus minutes", id, minutes)
+	return remainingMinutes(dd), nil
+}
+
+// remainingSamples returns the number of samples left before the threshold is reached.
+// It should be called under d.mu.
+func remainingSamples(d *deviceData) int {
+	return max(int(d.threshold()/d.config.Granularity)-d.countUsed(), 0)
+}
+
+// remainingMinutes returns the time left before the threshold is reached.
+// It should be called under d.mu.
+func remainingMinutes(d *deviceData) int {
+	return int(time.Duration(remainingSamples(d)) * d.config.Granularity / time.Minute)
+}
@@ -0,0 +1,103 @@
+package usage
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+func TestTracker_TransferBudget(t *testing.T) {
+	now := time.Now() // HasExceededThreshold uses the wall clock
+	cfg := &models.TrackerConfig{
+		Granularity: time.Minute,
+		Retention:   24 * time.Hour,
+		Threshold:   60 * time.Minute,
+	}
+	tracker := &Tracker{
+		logger:     config.MustGetLogger(),
+		mu:         &sync.Mutex{},
+		devices:    &sync.Map{},
+		macDevices: &sync.Map{},
+		nowFunc:    func() time.Time { return now },
+	}
+	tablet := newDeviceData(now, cfg, time.Local)
+	tv := newDeviceData(now, cfg, time.Local)
+	for i := 0; i < 20; i++ { // tablet has used 20 minutes.
+		tablet.samples[i] = true
+	}
+	for i := 0; i < 60; i++ { // tv has used all of its time.
+		tv.samples[i] = true
+	}
+	tracker.devices.Store("tablet", tablet)
+	tracker.devices.Store("tv", tv)
+
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		minutes int
+		wantErr error
+	}{
+		{name: "Zero minutes", from: "tablet", to: "tv", minutes: 0},
+		{name: "Same group", from: "tablet", to: "tablet", minutes: 10},
+		{name: "Unknown group", from: "tablet", to: "phone", minutes: 10, wantErr: models.ErrGroupNotFound},
+		{name: "More than remaining", from: "tablet", to: "tv", minutes: 41, wantErr: models.ErrInsufficientBudget},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tracker.TransferBudget(tt.from, tt.to, tt.minutes)
+			assert.Error(t, err)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+	assert.Equal(t, 0, tablet.adjustment, "expected failed transfers to leave the tracker unchanged")
+	assert.Equal(t, 0, tv.adjustment, "expected failed transfers to leave the tracker unchanged")
+	assert.True(t, tracker.HasExceededThreshold("tv"))
+
+	transfer, err := tracker.TransferBudget("tablet", "tv", 30)
+	assert.NoError(t, err)
+	assert.Equal(t, models.BudgetTransfer{From: "tablet", To: "tv", Minutes: 30, FromRemainingMinutes: 10, ToRemainingMinutes: 30}, transfer)
+	assert.False(t, tracker.HasExceededThreshold("tv"), "expected transferred time to be available")
+
+	summary := tracker.GetSummary()
+	assert.Equal(t, -30, summary["tablet"].Adjustment)
+	assert.Equal(t, 30, summary["tv"].Adjustment)
+
+	// The transfer only applies to the current window.
+	tv.syncWindow(tracker.logger, now.Add(24*time.Hour))
+	assert.Equal(t, 0, tv.adjustment, "expected the transfer to be reset in the next window")
+}
+
+func TestTracker_AddBonus(t *testing.T) {
+	now := time.Now()
+	cfg := &models.TrackerConfig{Granularity: time.Minute, Retention: 24 * time.Hour, Threshold: 60 * time.Minute}
+	tracker := &Tracker{
+		logger:     config.MustGetLogger(),
+		mu:         &sync.Mutex{},
+		devices:    &sync.Map{},
+		macDevices: &sync.Map{},
+		nowFunc:    func() time.Time { return now },
+	}
+	tv := newDeviceData(now, cfg, time.Local)
+	for i := 0; i < 60; i++ { // tv has used all of its time.
+		tv.samples[i] = true
+	}
+	tracker.devices.Store("tv", tv)
+	assert.True(t, tracker.HasExceededThreshold("tv"))
+
+	_, err := tracker.AddBonus("tv", 0)
+	assert.Error(t, err)
+	_, err = tracker.AddBonus("phone", 10)
+	assert.ErrorIs(t, err, models.ErrGroupNotFound)
+
+	remaining, err := tracker.AddBonus("tv", 15)
+	assert.NoError(t, err)
+	assert.Equal(t, 15, remaining)
+	assert.False(t, tracker.HasExceededThreshold("tv"), "expected the bonus to lift the group under its threshold")
+}
@@ -0,0 +1,190 @@
+package usage
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/models"
+)
+
+// Category implements models.TrackerI. It returns the name of the first of the group's categories with a domain
+// pattern matching the destination domain or an SNI pattern matching the server name, or an empty string if none do.
+func (t *Tracker) Category(id string, domain models.Domain, sni string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cfg, ok := t.cfgGroups[models.Group(id)]
+	if !ok {
+		return ""
+	}
+	for _, c := range cfg.Categories {
+		if (domain != "" && matchesAnyPattern(c.Domains, string(domain))) || (sni != "" && matchesAnyPattern(c.SNI, sni)) {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+// AddCategorySample records a sample for the group's category at the current time. The usage counts toward the
+// category's threshold instead of the group's, and doesn't count toward forced breaks.
+func (t *Tracker) AddCategorySample(id, category string, active bool) {
+	now := t.nowFunc()
+
+	t.mu.Lock()
+	cfg := t.getGroupConfig(id)
+	counted := addCategorySampleToDevice(t.logger, t.devices, id, category, cfg, t.location, now, active)
+	t.mu.Unlock()
+
+	if counted { // if the usage went up...
+		t.publishSummaries(id)
+	}
+}
+
+// HasExceededCategoryThreshold returns true if packets in the group's category should be blocked.
+// See deviceData.isCategoryBlocked for how it's evaluated.
+func (t *Tracker) HasExceededCategoryThreshold(id, category string) bool {
+	data, ok := t.devices.Load(id)
+	if !ok {
+		t.logger.Errorf("Unable to load config for group %v, returning false has-not-exceeded-threshold", id)
+		return false
+	}
+	dd := data.(*deviceData)
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	c := dd.category(category)
+	if c == nil { // if the category was removed since the packet was classified...
+		blocked, _ := dd.isBlocked(t.logger, time.Now())
+		return blocked
+	}
+	blocked, reason := dd.isCategoryBlocked(t.logger, time.Now(), c)
+	t.logger.Debugf("Usage tracker %s category %v blocked=%v: %v", id, category, blocked, reason)
+	return blocked
+}
+
+// isCategoryBlocked evaluates whether the category should be blocked at the given time. The group's mode and
+// counting hours apply as in isBlocked, but forced breaks don't since they're for the group's own usage. Then the
+// category is blocked once its usage reaches its threshold, so a threshold of zero blocks it outright, unless it's
+// unlimited.
+// It should be called under d.mu.
+func (d *deviceData) isCategoryBlocked(logger *zap.SugaredLogger, now time.Time, c *models.Category) (bool, string) {
+	if blocked, reason, decided := d.isBlockedByMode(now); decided {
+		return blocked, reason
+	}
+	if c.Unlimited {
+		return false, "unlimited"
+	}
+	d.syncWindow(logger, now)
+	used := time.Duration(d.countCategoryUsed(c.Name)) * d.config.Granularity
+	return used >= c.Threshold, fmt.Sprintf("used %v of %v", used, c.Threshold)
+}
+
+// category returns the config of the named category or nil if the group doesn't have it.
+// It should be called under d.mu.
+func (d *deviceData) category(name string) *models.Category {
+	for i := range d.config.Categories {
+		if d.config.Categories[i].Name == name {
+			return &d.config.Categories[i]
+		}
+	}
+	return nil
+}
+
+// categorySamples returns the samples of the category, creating them to match the group's window if needed.
+// It should be called under d.mu.
+func (d *deviceData) categorySamples(name string) []bool {
+	if d.categories == nil {
+		d.categories = make(map[string][]bool)
+	}
+	samples, ok := d.categories[name]
+	if !ok || len(samples) != len(d.samples) { // if the category is new or the window changed size...
+		samples = make([]bool, len(d.samples))
+		d.categories[name] = samples
+	}
+	return samples
+}
+
+// countCategoryUsed returns the number of samples seen for the category in the window.
+// It should be called under d.mu.
+func (d *deviceData) countCategoryUsed(name string) int {
+	count := 0
+	for _, seen := range d.categories[name] {
+		if seen {
+			count++
+		}
+	}
+	return count
+}
+
+// pruneCategories drops the samples of categories that are no longer configured.
+// It should be called under d.mu.
+func (d *deviceData) pruneCategories() {
+	for name := range d.categories {
+		if d.category(name) == nil {
+			delete(d.categories, name)
+		}
+	}
+}
+
+// summariseCategories returns the usage of each of the group's categories.
+// It should be called under d.mu.
+func (d *deviceData) summariseCategories(logger *zap.SugaredLogger, now time.Time) map[string]*models.CategoryUsage {
+	if len(d.config.Categories) == 0 {
+		return nil
+	}
+	usage := make(map[string]*models.CategoryUsage, len(d.config.Categories))
+	for i := range d.config.Categories {
+		c := &d.config.Categories[i]
+		used := time.Duration(d.countCategoryUsed(c.Name)) * d.config.Granularity
+		u := &models.CategoryUsage{Used: int(used / time.Minute), Unlimited: c.Unlimited}
+		if !c.Unlimited {
+			u.Threshold = int(c.Threshold / time.Minute)
+			u.Percentage = 100
+			if c.Threshold > 0 {
+				u.Percentage = min(int(used*100/c.Threshold), 100)
+			}
+		}
+		u.Blocked, _ = d.isCategoryBlocked(logger, now, c)
+		usage[c.Name] = u
+	}
+	return usage
+}
+
+// cleanCategories trims the names of the categories and cleans their patterns like allowlists, dropping categories
+// without a name or any patterns, and repeated names.
+func cleanCategories(categories []models.Category) []models.Category {
+	var cleaned []models.Category
+	for _, c := range categories {
+		c.Name = strings.TrimSpace(c.Name)
+		c.Domains = cleanPatterns(c.Domains)
+		c.SNI = cleanPatterns(c.SNI)
+		c.Threshold = max(c.Threshold, 0)
+		if c.Name == "" || (len(c.Domains) == 0 && len(c.SNI) == 0) || slices.ContainsFunc(cleaned, func(o models.Category) bool { return o.Name == c.Name }) {
+			continue
+		}
+		cleaned = append(cleaned, c)
+	}
+	return cleaned
+}
+
+// cleanPatterns cleans domain patterns like allowlist entries, accepting and dropping a leading "*." since patterns
+// match subdomains anyway.
+func cleanPatterns(patterns []string) []string {
+	trimmed := make([]string, 0, len(patterns))
+	for _, p := range patterns {
+		trimmed = append(trimmed, strings.TrimPrefix(strings.TrimSpace(p), "*."))
+	}
+	return cleanAllowlist(trimmed)
+}
+
+// matchesAnyPattern returns true if the name is one of the patterns or a subdomain of one.
+func matchesAnyPattern(patterns []string, name string) bool {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for _, p := range patterns {
+		if name == p || strings.HasSuffix(name, "."+p) {
+			return true
+		}
+	}
+	return false
+}
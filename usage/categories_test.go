@@ -0,0 +1,97 @@
+package usage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+func newCategoriesTestTracker(t *testing.T, now *time.Time) *Tracker {
+	fnGetGroupTrackerConfig = func(mu *sync.Mutex, configPath string, _ func() models.MapGroupTrackerConfig) (models.MapGroupTrackerConfig, error) {
+		return models.MapGroupTrackerConfig{
+			"kids": {Retention: 24 * time.Hour, Threshold: 60 * time.Minute, Mode: models.ModeMonitor, Categories: []models.Category{
+				{Name: "music", Domains: []string{"music.youtube.com"}, SNI: []string{"music.youtube.com"}, Unlimited: true},
+				{Name: "shorts", SNI: []string{"shorts.example.com"}, Threshold: 2 * time.Minute},
+			}},
+		}, nil
+	}
+	t.Cleanup(func() { fnGetGroupTrackerConfig = config.GetConfig })
+
+	tracker, err := NewTracker(context.Background(), config.MustGetLogger(), &models.TrackerConfig{Granularity: time.Minute, Retention: 24 * time.Hour}, nil)
+	require.NoError(t, err, "NewTracker failed")
+	tracker.nowFunc = func() time.Time { return *now }
+	return tracker
+}
+
+func TestTracker_Category(t *testing.T) {
+	now := time.Now()
+	tracker := newCategoriesTestTracker(t, &now)
+
+	assert.Equal(t, "music", tracker.Category("kids", "music.youtube.com", ""))
+	assert.Equal(t, "music", tracker.Category("kids", "", "www.Music.YouTube.com"), "expected subdomains to match case insensitively")
+	assert.Equal(t, "shorts", tracker.Category("kids", "youtube.com", "shorts.example.com"), "expected the SNI to match when the domain doesn't")
+	assert.Empty(t, tracker.Category("kids", "youtube.com", "www.youtube.com"))
+	assert.Empty(t, tracker.Category("kids", "notmusic.youtube.com", ""))
+	assert.Empty(t, tracker.Category("teens", "music.youtube.com", ""), "expected groups without categories not to match")
+}
+
+func TestTracker_AddCategorySample(t *testing.T) {
+	now := time.Now()
+	tracker := newCategoriesTestTracker(t, &now)
+
+	for i := 0; i < 3; i++ {
+		tracker.AddCategorySample("kids", "music", true)
+		tracker.AddCategorySample("kids", "shorts", true)
+		now = now.Add(time.Minute)
+	}
+	tracker.AddSample("kids", true)
+
+	summary := tracker.GetSummary()["kids"]
+	require.NotNil(t, summary)
+	assert.Equal(t, 1, summary.Used, "expected category usage not to count toward the group")
+	assert.Equal(t, &models.CategoryUsage{Used: 3, Unlimited: true}, summary.Categories["music"])
+	assert.Equal(t, &models.CategoryUsage{Used: 3, Threshold: 2, Percentage: 100, Blocked: true}, summary.Categories["shorts"])
+
+	assert.False(t, tracker.HasExceededThreshold("kids"))
+	assert.False(t, tracker.HasExceededCategoryThreshold("kids", "music"), "expected unlimited categories not to be blocked")
+	assert.True(t, tracker.HasExceededCategoryThreshold("kids", "shorts"))
+
+	assert.NoError(t, tracker.SetMode("kids", time.Hour, models.ModeBlock))
+	assert.True(t, tracker.HasExceededCategoryThreshold("kids", "music"), "expected the group's mode to apply to its categories")
+}
+
+func TestSyncWindow_ClearsCategories(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	d := newDeviceData(start, &models.TrackerConfig{
+		Granularity: time.Minute,
+		Retention:   24 * time.Hour,
+		Threshold:   60 * time.Minute,
+		Categories:  []models.Category{{Name: "music", Domains: []string{"music.youtube.com"}, Threshold: time.Minute}},
+	}, time.Local)
+	d.categorySamples("music")[0] = true
+	assert.Equal(t, 1, d.countCategoryUsed("music"))
+	d.syncWindow(config.MustGetLogger(), start.Add(24*time.Hour))
+	assert.Zero(t, d.countCategoryUsed("music"), "expected the category usage to reset with the window")
+}
+
+func TestValidateGroupTrackerConfig_Categories(t *testing.T) {
+	cfg := models.MapGroupTrackerConfig{"kids": {
+		Retention: 24 * time.Hour,
+		Categories: []models.Category{
+			{Name: " music ", Domains: []string{"*.Music.YouTube.com.", "music.youtube.com", "https://bad"}, Threshold: -time.Minute, Unlimited: true},
+			{Name: "music", SNI: []string{"duplicate.example.com"}},
+			{Name: "empty"},
+			{Name: "", SNI: []string{"unnamed.example.com"}},
+		},
+	}}
+	assert.NoError(t, validateGroupTrackerConfig(cfg))
+	assert.Equal(t, []models.Category{
+		{Name: "music", Domains: []string{"music.youtube.com"}, SNI: nil, Threshold: 0, Unlimited: true},
+	}, cfg["kids"].Categories)
+}
@@ -0,0 +1,154 @@
+package usage
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+var fsckTrackerConfigFile = defaultGroupTrackerConfigFilePath // fsckTrackerConfigFile is the name registered with the backups.
+
+func init() {
+	config.Fsck.Register(fsckTrackerConfigFile, checkTrackerConfigFile)
+	if f := config.AppCfg.TrackerConfig.SampleFilePath; f != "" {
+		config.Fsck.Register(f, checkSamplesFile(false))
+		dir, file := filepath.Split(f)
+		config.Fsck.Register(filepath.Join(dir, deviceSamplesFilePrefix+file), checkSamplesFile(true))
+	}
+}
+
+// checkTrackerConfigFile checks the tracker config the same way as it's validated when saved from the web page.
+// Repairs save the validated config.
+func checkTrackerConfigFile(data []byte, _ map[string][]byte) ([]byte, []string, error) {
+	cfg, err := unmarshalTrackerConfig(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(cfg) == 0 {
+		return nil, nil, nil
+	}
+	validated, _ := unmarshalTrackerConfig(data)
+	_ = validateGroupTrackerConfig(validated) // an error means the config is empty, which is fine, or was emptied, which is reported below.
+
+	present := make(map[models.Group]map[string]any) // present holds the settings in the file for each tracker.
+	_ = yaml.Unmarshal(data, &present)
+
+	var problems []string
+	for g, v := range cfg {
+		cleanGroup := models.Group(models.NewGroup(string(g)))
+		if _, ok := validated[cleanGroup]; !ok || v == nil {
+			problems = append(problems, fmt.Sprintf("tracker %q is invalid", g))
+			continue
+		}
+		if cleanGroup != g {
+			problems = append(problems, fmt.Sprintf("tracker %q has an invalid name, %v is used instead", g, cleanGroup))
+		}
+		if v.ModeEndTime.Before(time.Now().UTC()) { // if the mode has expired, which isn't a problem...
+			v.Mode, v.ModeEndTime = validated[cleanGroup].Mode, validated[cleanGroup].ModeEndTime
+		}
+		before, after := yamlFields(v), yamlFields(validated[cleanGroup])
+		var invalid, missing []string
+		for k, value := range after {
+			if k == "sampleSize" || fmt.Sprint(before[k]) == fmt.Sprint(value) { // if the setting is unchanged or derived...
+				continue
+			}
+			if _, ok := present[g][k]; ok {
+				invalid = append(invalid, k)
+			} else {
+				missing = append(missing, k)
+			}
+		}
+		slices.Sort(invalid)
+		slices.Sort(missing)
+		if len(invalid) > 0 {
+			problems = append(problems, fmt.Sprintf("tracker %v has invalid settings: %v", cleanGroup, strings.Join(invalid, ", ")))
+		}
+		if len(missing) > 0 {
+			problems = append(problems, fmt.Sprintf("tracker %v is missing settings: %v", cleanGroup, strings.Join(missing, ", ")))
+		}
+	}
+	if len(problems) == 0 {
+		return nil, nil, nil
+	}
+	slices.Sort(problems)
+	fixed, err := yaml.Marshal(validated)
+	if err != nil {
+		return nil, problems, fmt.Errorf("failed to marshal tracker config: %w", err)
+	}
+	return fixed, problems, nil
+}
+
+func unmarshalTrackerConfig(data []byte) (models.MapGroupTrackerConfig, error) {
+	cfg := make(models.MapGroupTrackerConfig)
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tracker config: %w", err)
+	}
+	return cfg, nil
+}
+
+// yamlFields returns the settings in cfg by their names in the config file.
+func yamlFields(cfg *models.TrackerConfig) map[string]any {
+	b, _ := yaml.Marshal(cfg)
+	fields := make(map[string]any)
+	_ = yaml.Unmarshal(b, &fields)
+	return fields
+}
+
+// checkSamplesFile returns a check that the samples file decodes, migrating older versions, and that the samples belong
+// to groups, or devices in groups if perDevice is true, that still exist. Repairs remove the samples that don't, along
+// with samples whose length doesn't match their config, which can't be used.
+func checkSamplesFile(perDevice bool) config.FileCheck {
+	return func(data []byte, files map[string][]byte) ([]byte, []string, error) {
+		if len(strings.TrimSpace(string(data))) == 0 {
+			return nil, nil, nil
+		}
+		doc, err := decodeSamples(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to unmarshal samples: %w", err)
+		}
+		samples := doc.Samples
+
+		// Find the groups that samples may belong to.
+		trackerCfg := make(models.MapGroupTrackerConfig)
+		if b, ok := files[fsckTrackerConfigFile]; ok {
+			trackerCfg, _ = unmarshalTrackerConfig(b)
+		}
+		groupMACs, haveGroups := config.FsckGroups(files)
+		knownGroup := func(g models.Group) bool {
+			_, tracked := trackerCfg[g]
+			_, grouped := groupMACs[g]
+			return tracked || grouped || len(groupMACs) == 0 // all devices are in the default group if there are no groups.
+		}
+
+		var problems []string
+		for k, v := range samples {
+			group, mac, _ := strings.Cut(k, deviceKeySeparator)
+			switch {
+			case v.Config != nil && len(v.Samples) != v.Config.SampleSize:
+				problems = append(problems, fmt.Sprintf("%v has %v samples but expects %v", k, len(v.Samples), v.Config.SampleSize))
+			case haveGroups && !knownGroup(models.Group(group)):
+				problems = append(problems, fmt.Sprintf("%v is for a group that no longer exists", k))
+			case perDevice && len(groupMACs) > 0 && !slices.Contains(groupMACs[models.Group(group)], models.MAC(mac)):
+				problems = append(problems, fmt.Sprintf("%v is for a device that is no longer in the group", k))
+			default:
+				continue
+			}
+			delete(samples, k)
+		}
+		if len(problems) == 0 {
+			return nil, nil, nil
+		}
+		slices.Sort(problems)
+		fixed, err := encodeSamples(samples, nil)
+		if err != nil {
+			return nil, problems, fmt.Errorf("failed to marshal samples: %w", err)
+		}
+		return fixed, problems, nil
+	}
+}
@@ -0,0 +1,75 @@
+package usage
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v3"
+	"relloyd/tubetimeout/models"
+)
+
+func TestCheckTrackerConfigFile(t *testing.T) {
+	fixed, problems, err := checkTrackerConfigFile([]byte("kids:\n  threshold: 1h\n  retention: 24h\n  startDay: 1\n  rollover: none\n"), nil)
+	assert.NoError(t, err)
+	assert.Nil(t, fixed, "expected a valid config not to need fixing")
+	assert.Empty(t, problems)
+
+	fixed, problems, err = checkTrackerConfigFile([]byte("kids:\n  threshold: -5m\n  retention: 24h\n  startDay: 1\n  rollover: none\nbad/name:\n  threshold: 1h\n"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`tracker "bad/name" has an invalid name, badname is used instead`,
+		"tracker badname is missing settings: retention, rollover, startDay",
+		"tracker kids has invalid settings: threshold",
+	}, problems)
+	cfg := make(models.MapGroupTrackerConfig)
+	assert.NoError(t, yaml.Unmarshal(fixed, &cfg))
+	assert.Contains(t, cfg, models.Group("badname"))
+	assert.Zero(t, cfg["kids"].Threshold)
+
+	_, _, err = checkTrackerConfigFile([]byte("kids: [\n"), nil)
+	assert.Error(t, err)
+}
+
+func TestCheckSamplesFile(t *testing.T) {
+	files := map[string][]byte{
+		fsckTrackerConfigFile: []byte("teens:\n  threshold: 1h\n"),
+		"group-macs.yaml":     []byte("groups:\n  kids:\n    - mac: aa:bb:cc:dd:ee:ff\n"),
+	}
+	samples := map[string]deviceDataDTO{
+		"kids":  {Config: &models.TrackerConfig{SampleSize: 2}, Samples: []bool{true, false}},
+		"teens": {Samples: []bool{true}},
+		"gone":  {Samples: []bool{true}},
+		"short": {Config: &models.TrackerConfig{SampleSize: 3}, Samples: []bool{true}},
+	}
+	data, _ := json.Marshal(samples)
+	fixed, problems, err := checkSamplesFile(false)(data, files)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gone is for a group that no longer exists", "short has 1 samples but expects 3"}, problems)
+	kept, err := decodeSamples(fixed)
+	assert.NoError(t, err)
+	assert.Equal(t, samplesVersion, kept.Version, "expected the repair to be saved in the current version")
+	assert.Len(t, kept.Samples, 2)
+	assert.Contains(t, kept.Samples, "kids")
+	assert.Contains(t, kept.Samples, "teens", "expected groups with a tracker but no devices to be kept")
+
+	// Per-device samples must be for devices in the group.
+	data, _ = json.Marshal(map[string]deviceDataDTO{
+		"kids/AA-BB-CC-DD-EE-FF":  {Samples: []bool{}},
+		"kids/11-22-33-44-55-66":  {Samples: []bool{}},
+		"teens/AA-BB-CC-DD-EE-FF": {Samples: []bool{}},
+	})
+	_, problems, err = checkSamplesFile(true)(data, files)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kids/11-22-33-44-55-66 is for a device that is no longer in the group", "teens/AA-BB-CC-DD-EE-FF is for a device that is no longer in the group"}, problems)
+
+	// All groups are valid without group-macs since devices are tracked in the default group.
+	data, _ = json.Marshal(map[string]deviceDataDTO{"default": {Samples: []bool{}}})
+	fixed, problems, err = checkSamplesFile(false)(data, map[string][]byte{"group-macs.yaml": []byte("")})
+	assert.NoError(t, err)
+	assert.Nil(t, fixed)
+	assert.Empty(t, problems)
+
+	_, _, err = checkSamplesFile(false)([]byte("{"), files)
+	assert.Error(t, err)
+}
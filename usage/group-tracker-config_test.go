@@ -25,18 +25,15 @@ func TestGetGroupTrackerConfig_FileNotExist_CreatesFile(t *testing.T) {
 	_ = os.Remove(testFile.Name()) // remove the file immediately so we have the file name only.
 
 	defaultGroupTrackerConfigFilePath = testFile.Name()
-	config.FnDefaultCreateAppHomeDirAndGetConfigFilePath = func(path string) (string, error) {
-		return testFile.Name(), nil
-	}
 
 	configFileWritten := false
-	config.FnDefaultSafeWriteViaTemp = func(filePath string, data string) error {
+	useWriter(t, func(filePath string, data string) error {
 		if filePath != testFile.Name() {
 			return errors.New("unexpected file path")
 		}
 		configFileWritten = true
 		return nil
-	}
+	})
 
 	cfg, err := config.GetConfig[models.MapGroupTrackerConfig](tkr.mu, defaultGroupTrackerConfigFilePath, models.NewMapGroupTrackerConfig)
 	assert.NoError(t, err)
@@ -57,9 +54,6 @@ func TestGetGroupTrackerConfig_FileExists_ParsesYAML(t *testing.T) {
 	})
 
 	defaultGroupTrackerConfigFilePath = testFile.Name()
-	config.FnDefaultCreateAppHomeDirAndGetConfigFilePath = func(path string) (string, error) {
-		return testFile.Name(), nil
-	}
 
 	data := models.MapGroupTrackerConfig{
 		"group1": &models.TrackerConfig{},
@@ -88,9 +82,6 @@ func TestGetGroupTrackerConfig_YAMLError_ReturnsError(t *testing.T) {
 	})
 
 	defaultGroupTrackerConfigFilePath = testFile.Name()
-	config.FnDefaultCreateAppHomeDirAndGetConfigFilePath = func(path string) (string, error) {
-		return testFile.Name(), nil
-	}
 
 	err := os.WriteFile(testFile.Name(), []byte("invalid_yaml"), 0644)
 	assert.NoError(t, err)
@@ -115,19 +106,16 @@ func TestSetGroupTrackerConfig_SuccessfulWrite(t *testing.T) {
 	})
 
 	defaultGroupTrackerConfigFilePath = testFile.Name()
-	config.FnDefaultCreateAppHomeDirAndGetConfigFilePath = func(path string) (string, error) {
-		return testFile.Name(), nil
-	}
 
 	data := models.MapGroupTrackerConfig{
 		"group1": &models.TrackerConfig{},
 	}
 
 	configFileWritten := false
-	config.FnDefaultSafeWriteViaTemp = func(filePath string, content string) error {
+	useWriter(t, func(filePath string, content string) error {
 		configFileWritten = true
 		return nil
-	}
+	})
 
 	err := config.SetConfig[models.MapGroupTrackerConfig](tkr.mu, defaultGroupTrackerConfigFilePath, validateGroupTrackerConfig, nil, data)
 
@@ -156,13 +144,10 @@ func TestSetGroupTrackerConfig_EntriesAreFiltered(t *testing.T) {
 	}
 
 	defaultGroupTrackerConfigFilePath = testFile.Name()
-	config.FnDefaultCreateAppHomeDirAndGetConfigFilePath = func(path string) (string, error) {
-		return testFile.Name(), nil
-	}
 
-	config.FnDefaultSafeWriteViaTemp = func(filePath string, content string) error {
+	useWriter(t, func(filePath string, content string) error {
 		return nil
-	}
+	})
 
 	dataThatWillBeFiltered := models.MapGroupTrackerConfig{"": nil, "group": nil}
 	err := config.SetConfig[models.MapGroupTrackerConfig](tkr.mu, defaultGroupTrackerConfigFilePath, validateGroupTrackerConfig, nil, dataThatWillBeFiltered)
@@ -196,13 +181,10 @@ func TestSetGroupTrackerConfig_InvalidGroup(t *testing.T) {
 	}
 
 	defaultGroupTrackerConfigFilePath = testFile.Name()
-	config.FnDefaultCreateAppHomeDirAndGetConfigFilePath = func(path string) (string, error) {
-		return testFile.Name(), nil
-	}
 
-	config.FnDefaultSafeWriteViaTemp = func(filePath string, content string) error {
+	useWriter(t, func(filePath string, content string) error {
 		return nil
-	}
+	})
 
 	tkr := &Tracker{
 		logger:    config.MustGetLogger(),
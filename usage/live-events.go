@@ -0,0 +1,33 @@
+package usage
+
+import (
+	"relloyd/tubetimeout/models"
+)
+
+// RegisterLiveEventReceivers subscribes receivers to the bus's live events, which the tracker sends usage summaries
+// to as usage goes up, and for all groups on each threshold check so that resets and mode changes are seen too.
+func (t *Tracker) RegisterLiveEventReceivers(receivers ...models.LiveEventReceiver) {
+	for _, r := range receivers {
+		t.bus.Live.Subscribe(r.PublishEvent)
+	}
+}
+
+// publishSummaries publishes the summaries of the given groups, or all groups if none are given, as live events.
+func (t *Tracker) publishSummaries(ids ...string) {
+	if t.bus.Live.Subscribers() == 0 { // if nobody is listening...
+		return
+	}
+
+	var summary map[string]*models.TrackerSummary
+	if len(ids) == 0 {
+		summary = t.GetSummary()
+	} else {
+		summary = make(map[string]*models.TrackerSummary)
+		for _, id := range ids {
+			if data, ok := t.devices.Load(id); ok {
+				summary[id] = t.summarise(id, data.(*deviceData))
+			}
+		}
+	}
+	t.bus.Live.Publish(models.LiveEvent{Type: models.LiveEventSummary, Time: t.nowFunc(), Data: summary})
+}
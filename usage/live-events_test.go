@@ -0,0 +1,54 @@
+package usage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+type mockLiveEventReceiver struct {
+	mu     sync.Mutex
+	events []models.LiveEvent
+}
+
+func (m *mockLiveEventReceiver) PublishEvent(e models.LiveEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, e)
+}
+
+func TestTracker_PublishSummaries(t *testing.T) {
+	cfg := &models.TrackerConfig{
+		Granularity: 1 * time.Minute,
+		Retention:   1 * time.Hour,
+		Threshold:   10 * time.Minute,
+		Mode:        models.ModeMonitor,
+	}
+	tracker, err := NewTracker(context.Background(), config.MustGetLogger(), cfg, nil)
+	assert.NoError(t, err, "NewTracker failed")
+	now := time.Now()
+	tracker.nowFunc = func() time.Time { return now }
+
+	r := &mockLiveEventReceiver{}
+	tracker.RegisterLiveEventReceivers(r)
+
+	tracker.AddSample("kids", false)
+	assert.Empty(t, r.events, "expected no event for an inactive sample")
+	tracker.AddSample("kids", true)
+	tracker.AddSample("kids", true)
+	if assert.Len(t, r.events, 1, "expected one event when the usage went up") {
+		summary := r.events[0].Data.(map[string]*models.TrackerSummary)
+		assert.Equal(t, 1, summary["kids"].Used)
+	}
+
+	tracker.AddSample("teens", false)
+	tracker.publishSummaries()
+	if assert.Len(t, r.events, 2) {
+		assert.Len(t, r.events[1].Data.(map[string]*models.TrackerSummary), 2, "expected all groups to be sent")
+	}
+}
@@ -0,0 +1,139 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+	"relloyd/tubetimeout/storage"
+)
+
+var defaultModeHistoryFilePath = "mode-history.jsonl"
+
+func init() {
+	config.Backups.Register(defaultModeHistoryFilePath, "block and allow history")
+}
+
+// modeHistory is an append-only JSONL log in storage.Default of the times each group was blocked or allowed, and why.
+// Entries older than retention are dropped when the file is loaded.
+type modeHistory struct {
+	mu        sync.Mutex
+	filePath  string
+	retention time.Duration
+	blocked   map[models.Group]bool // blocked is the state of each group in its last entry.
+}
+
+func newModeHistory(filePath string, retention time.Duration, now time.Time) (*modeHistory, error) {
+	h := &modeHistory{filePath: filePath, retention: retention, blocked: make(map[models.Group]bool)}
+	if err := h.compact(now); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// record appends the transition to the file. Transitions made by the tracker are skipped if the group is already
+// in that state, e.g. because a manual block was recorded before the tracker noticed it.
+func (h *modeHistory) record(e models.ModeTransition) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal mode transition: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if blocked, ok := h.blocked[e.Group]; ok && blocked == e.Blocked && e.Cause == models.TransitionTracker {
+		return nil
+	}
+	h.blocked[e.Group] = e.Blocked
+	if err = storage.Default.Append(h.filePath, b); err != nil {
+		return fmt.Errorf("failed to write mode history: %w", err)
+	}
+	return nil
+}
+
+// recent returns up to limit transitions of the group within the retention period, newest first.
+func (h *modeHistory) recent(group models.Group, limit int, now time.Time) ([]models.ModeTransition, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries, err := h.readAll(now)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]models.ModeTransition, 0)
+	for i := len(entries) - 1; i >= 0 && len(result) < limit; i-- {
+		if entries[i].Group == group {
+			result = append(result, entries[i])
+		}
+	}
+	return result, nil
+}
+
+// compact rewrites the file without the entries that are older than the retention period.
+func (h *modeHistory) compact(now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries, err := h.readAll(now)
+	if err != nil {
+		return err
+	}
+	lines := make([][]byte, 0, len(entries))
+	for _, e := range entries {
+		h.blocked[e.Group] = e.Blocked
+		b, _ := json.Marshal(e)
+		lines = append(lines, b)
+	}
+	return storage.Default.WriteLines(h.filePath, lines)
+}
+
+// readAll returns the entries within the retention period, skipping lines that can't be parsed.
+// It should be called under h.mu.
+func (h *modeHistory) readAll(now time.Time) ([]models.ModeTransition, error) {
+	lines, err := storage.Default.ReadLines(h.filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mode history: %w", err)
+	}
+
+	var entries []models.ModeTransition
+	cutoff := now.Add(-h.retention)
+	for _, line := range lines {
+		var e models.ModeTransition
+		if err = json.Unmarshal(line, &e); err != nil { // if the line is corrupt, e.g. after a power cut...
+			continue
+		}
+		if e.Time.After(cutoff) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+
+// ModeHistory returns up to limit recent transitions of the group between blocked and allowed, newest first.
+func (t *Tracker) ModeHistory(group models.Group, limit int) ([]models.ModeTransition, error) {
+	if t.history == nil {
+		return nil, models.ErrHistoryDisabled
+	}
+	return t.history.recent(group, limit, t.nowFunc())
+}
+
+// RegisterModeTransitionReceivers subscribes receivers to the bus's mode transitions, to be sent each one as it's
+// recorded.
+func (t *Tracker) RegisterModeTransitionReceivers(receivers ...models.ModeTransitionReceiver) {
+	for _, r := range receivers {
+		t.bus.ModeTransitions.Subscribe(r.UpdateModeTransition)
+	}
+}
+
+// recordTransition saves a transition to the history, if it's enabled, and publishes it.
+func (t *Tracker) recordTransition(e models.ModeTransition) {
+	e.Time = t.nowFunc().UTC()
+	t.bus.ModeTransitions.Publish(e)
+	if t.history == nil {
+		return
+	}
+	if err := t.history.record(e); err != nil {
+		t.logger.Errorf("Failed to record mode transition for group %v: %v", e.Group, err)
+	}
+}
@@ -0,0 +1,88 @@
+package usage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+func TestModeHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), defaultModeHistoryFilePath)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	old := `{"time":"2024-04-01T12:00:00Z","group":"kids","cause":"manual","blocked":true}`
+	kept := `{"time":"2024-06-01T11:00:00Z","group":"kids","cause":"tracker","blocked":true,"reason":"used 1h0m0s of 1h0m0s"}`
+	assert.NoError(t, os.WriteFile(path, []byte(old+"\n{corrupt\n"+kept+"\n"), 0644))
+
+	h, err := newModeHistory(path, 7*24*time.Hour, now)
+	assert.NoError(t, err)
+	data, _ := os.ReadFile(path)
+	assert.Equal(t, `{"time":"2024-06-01T11:00:00Z","group":"kids","cause":"tracker","mode":0,"blocked":true,"until":"0001-01-01T00:00:00Z","reason":"used 1h0m0s of 1h0m0s"}`+"\n", string(data), "expected old and corrupt entries to be dropped")
+
+	// The tracker noticing the state it's already in isn't recorded.
+	assert.NoError(t, h.record(models.ModeTransition{Time: now, Group: "kids", Cause: models.TransitionTracker, Blocked: true}))
+	assert.NoError(t, h.record(models.ModeTransition{Time: now.Add(time.Minute), Group: "teens", Cause: models.TransitionManual, Blocked: true}))
+	assert.NoError(t, h.record(models.ModeTransition{Time: now.Add(2 * time.Minute), Group: "kids", Cause: models.TransitionManual, Mode: models.ModeAllow, Reason: "allowed for 30m0s"}))
+	assert.NoError(t, h.record(models.ModeTransition{Time: now.Add(3 * time.Minute), Group: "kids", Cause: models.TransitionTracker, Reason: "allowed until later"}))
+
+	transitions, err := h.recent("kids", 10, now)
+	assert.NoError(t, err)
+	if assert.Len(t, transitions, 2) {
+		assert.Equal(t, "allowed for 30m0s", transitions[0].Reason, "expected the newest first")
+		assert.Equal(t, "used 1h0m0s of 1h0m0s", transitions[1].Reason)
+	}
+	transitions, err = h.recent("kids", 1, now)
+	assert.NoError(t, err)
+	assert.Len(t, transitions, 1)
+	transitions, err = h.recent("missing", 10, now)
+	assert.NoError(t, err)
+	assert.Empty(t, transitions)
+}
+
+func TestTracker_ModeHistory(t *testing.T) {
+	cfg := &models.TrackerConfig{Granularity: time.Minute, Retention: time.Hour, Threshold: 10 * time.Minute}
+	fnGetGroupTrackerConfig = func(mu *sync.Mutex, configPath string, _ func() models.MapGroupTrackerConfig) (models.MapGroupTrackerConfig, error) {
+		return models.MapGroupTrackerConfig{}, nil
+	}
+	useWriter(t, func(filePath string, data string) error { return nil })
+	t.Cleanup(restoreFunctions)
+
+	tracker, err := NewTracker(context.Background(), config.MustGetLogger(), cfg, nil)
+	assert.NoError(t, err)
+	_, err = tracker.ModeHistory("kids", 10)
+	assert.ErrorIs(t, err, models.ErrHistoryDisabled)
+
+	tracker.history = &modeHistory{filePath: filepath.Join(t.TempDir(), defaultModeHistoryFilePath), retention: time.Hour, blocked: make(map[models.Group]bool)}
+	data := newDeviceData(time.Now(), cfg, time.Local)
+	tracker.devices.Store("kids", data)
+
+	// The threshold is reached.
+	for i := 0; i < 10; i++ {
+		data.samples[i] = true
+	}
+	tracker.checkThresholds()
+	// Allowed from the web page.
+	tracker.cfgGroups["kids"] = getDefaultGroupTrackerConfig(cfg)
+	assert.NoError(t, tracker.SetMode("kids", 30*time.Minute, models.ModeAllow))
+	tracker.checkThresholds()
+
+	transitions, err := tracker.ModeHistory("kids", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, transitions, 2, "expected the tracker noticing the manual mode not to be recorded again") {
+		assert.Equal(t, models.TransitionManual, transitions[0].Cause)
+		assert.Equal(t, models.ModeAllow, transitions[0].Mode)
+		assert.False(t, transitions[0].Blocked)
+		assert.Equal(t, "allowed for 30m0s", transitions[0].Reason)
+		assert.False(t, transitions[0].Until.IsZero())
+		assert.Equal(t, models.TransitionTracker, transitions[1].Cause)
+		assert.Equal(t, models.ModeMonitor, transitions[1].Mode)
+		assert.True(t, transitions[1].Blocked)
+		assert.Equal(t, "used 10m0s of 10m0s", transitions[1].Reason)
+	}
+}
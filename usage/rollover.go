@@ -0,0 +1,27 @@
+package usage
+
+import (
+	"time"
+
+	"relloyd/tubetimeout/models"
+)
+
+// carryOver returns the number of unused samples to roll into the window starting at nextWindowStart, according to
+// the group's rollover policy. If whole windows went by without any samples, e.g. the device was away, the time left
+// in the last of them is its full threshold.
+// It should be called under d.mu before the samples are cleared.
+func (d *deviceData) carryOver(nextWindowStart time.Time) int {
+	if d.config.Rollover != models.RolloverCapped && d.config.Rollover != models.RolloverFull {
+		return 0
+	}
+	unused := remainingSamples(d)
+	// Never carry more than one window's allowance.
+	limit := int(d.baseThreshold() / d.config.Granularity)
+	if nextWindowStart.After(d.windowEnd()) { // if windows were skipped...
+		unused = limit
+	}
+	if d.config.Rollover == models.RolloverCapped {
+		limit = min(limit, int(d.config.RolloverCap/d.config.Granularity))
+	}
+	return min(unused, limit)
+}
@@ -0,0 +1,72 @@
+package usage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+func TestSyncWindow_Rollover(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		rollover    models.RolloverPolicy
+		cap         time.Duration
+		used        int
+		nextWindow  time.Duration // nextWindow is how long after start the next sample is seen.
+		wantCarried int
+	}{
+		{name: "None", rollover: models.RolloverNone, used: 20, nextWindow: 24 * time.Hour, wantCarried: 0},
+		{name: "Full", rollover: models.RolloverFull, used: 20, nextWindow: 24 * time.Hour, wantCarried: 40},
+		{name: "Capped", rollover: models.RolloverCapped, cap: 30 * time.Minute, used: 20, nextWindow: 24 * time.Hour, wantCarried: 30},
+		{name: "Capped above unused", rollover: models.RolloverCapped, cap: 30 * time.Minute, used: 50, nextWindow: 24 * time.Hour, wantCarried: 10},
+		{name: "Nothing unused", rollover: models.RolloverFull, used: 60, nextWindow: 24 * time.Hour, wantCarried: 0},
+		{name: "Skipped windows", rollover: models.RolloverFull, used: 60, nextWindow: 72 * time.Hour, wantCarried: 60},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDeviceData(start, &models.TrackerConfig{
+				Granularity: time.Minute,
+				Retention:   24 * time.Hour,
+				Threshold:   60 * time.Minute,
+				Rollover:    tt.rollover,
+				RolloverCap: tt.cap,
+			}, time.Local)
+			for i := 0; i < tt.used; i++ {
+				d.samples[i] = true
+			}
+			d.syncWindow(config.MustGetLogger(), start.Add(tt.nextWindow))
+			assert.Equal(t, tt.wantCarried, d.carried)
+			assert.Equal(t, 60*time.Minute+time.Duration(tt.wantCarried)*time.Minute, d.threshold())
+		})
+	}
+}
+
+func TestSyncWindow_RolloverIsAtMostOneWindow(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	d := newDeviceData(start, &models.TrackerConfig{
+		Granularity: time.Minute,
+		Retention:   24 * time.Hour,
+		Threshold:   60 * time.Minute,
+		Rollover:    models.RolloverFull,
+	}, time.Local)
+	d.syncWindow(config.MustGetLogger(), start.Add(24*time.Hour))
+	assert.Equal(t, 60, d.carried)
+	d.syncWindow(config.MustGetLogger(), start.Add(48*time.Hour))
+	assert.Equal(t, 60, d.carried, "expected unused carried time not to build up")
+}
+
+func TestValidateGroupTrackerConfig_Rollover(t *testing.T) {
+	cfg := models.MapGroupTrackerConfig{
+		"full":    {Retention: 24 * time.Hour, Rollover: models.RolloverFull},
+		"no-cap":  {Retention: 24 * time.Hour, Rollover: models.RolloverCapped},
+		"unknown": {Retention: 24 * time.Hour, Rollover: "lots"},
+	}
+	assert.NoError(t, validateGroupTrackerConfig(cfg))
+	assert.Equal(t, models.RolloverFull, cfg["full"].Rollover)
+	assert.Equal(t, models.RolloverNone, cfg["no-cap"].Rollover, "expected capped rollover without a cap to be disabled")
+	assert.Equal(t, models.RolloverNone, cfg["unknown"].Rollover)
+}
@@ -2,37 +2,181 @@ package usage
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+	"relloyd/tubetimeout/storage"
 )
 
-func loadSamples(path string) (*sync.Map, error) {
-	if _, err := os.Stat(path); err != nil {
-		return nil, fmt.Errorf("usage samples file %q does not exist", path)
+// saveStatus records the outcome of the periodic saves of a samples file.
+type saveStatus struct {
+	mu       sync.Mutex
+	filePath string
+	interval time.Duration
+	started  time.Time
+	lastSave time.Time // lastSave is the time of the last successful save.
+	lastErr  error     // lastErr is the error from the last save, or nil if it succeeded.
+}
+
+func newSaveStatus(filePath string, interval time.Duration) *saveStatus {
+	return &saveStatus{filePath: filePath, interval: interval, started: time.Now()}
+}
+
+func (s *saveStatus) record(now time.Time, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = err
+	if err == nil {
+		s.lastSave = now
+	}
+}
+
+// check returns a message describing a failed or overdue save, or "" if saves are up to date.
+func (s *saveStatus) check(now time.Time) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastErr != nil {
+		return fmt.Sprintf("failed to save %v: %v", filepath.Base(s.filePath), s.lastErr)
 	}
+	last := s.lastSave
+	if last.IsZero() {
+		last = s.started
+	}
+	if now.Sub(last) > 2*s.interval {
+		return fmt.Sprintf("%v has not been saved since %v", filepath.Base(s.filePath), last.Format(time.RFC3339))
+	}
+	return ""
+}
+
+// Health reports the tracker as degraded if samples couldn't be saved, since usage would be lost on restart.
+func (t *Tracker) Health() models.SubsystemHealth {
+	h := models.SubsystemHealth{Name: "tracker", Status: models.HealthOK}
+	if len(t.saves) == 0 {
+		h.Message = "saving samples is disabled"
+		return h
+	}
+	now := t.nowFunc()
+	files := make(map[string]time.Time)
+	for _, s := range t.saves {
+		if msg := s.check(now); msg != "" {
+			h.Status = models.HealthDegraded
+			h.Message = msg
+		}
+		s.mu.Lock()
+		files[filepath.Base(s.filePath)] = s.lastSave
+		s.mu.Unlock()
+	}
+	h.Details = map[string]any{"lastSaved": files}
+	return h
+}
+
+// samplesVersion is the version of the samples schema written by this build. Bump it, and add a migration from the
+// previous version to sampleMigrations, whenever deviceDataDTO changes in a way that older files wouldn't load as.
+const samplesVersion = 2
+
+// samplesDocument is the schema of the samples files and of /api/usage/export:
+//
+//	{"version": 2, "samples": {"<group>": <deviceDataDTO>}, "devices": {"<group>/<MAC>": <deviceDataDTO>}}
+//
+// Version 1 is the samples map on its own, as saved before the schema was versioned. The samples files keep one map
+// each so only exports have devices.
+type samplesDocument struct {
+	Version int                      `json:"version"`
+	Samples map[string]deviceDataDTO `json:"samples"`
+	Devices map[string]deviceDataDTO `json:"devices,omitempty"`
+}
+
+// sampleMigrations convert a samples document from the version of the key to the next one.
+var sampleMigrations = map[int]func(data []byte) ([]byte, error){
+	1: func(data []byte) ([]byte, error) { // wrap the samples map in a versioned document.
+		return json.Marshal(struct {
+			Version int             `json:"version"`
+			Samples json.RawMessage `json:"samples"`
+		}{Version: 2, Samples: data})
+	},
+}
+
+// decodeSamples reads a samples document of any version up to samplesVersion, migrating older versions in turn.
+// Documents from a newer build return an error wrapping models.ErrInvalidSamples rather than losing the fields this
+// build doesn't know about.
+func decodeSamples(data []byte) (samplesDocument, error) {
+	var top map[string]json.RawMessage
+	if err := json.Unmarshal(data, &top); err != nil {
+		return samplesDocument{}, fmt.Errorf("%w: %v", models.ErrInvalidSamples, err)
+	}
+	version := 1
+	if err := json.Unmarshal(top["version"], &version); err != nil || version < 1 { // if the samples are from before the schema was versioned...
+		version = 1
+	}
+	if version > samplesVersion {
+		return samplesDocument{}, fmt.Errorf("%w: version %v is newer than the latest supported version %v", models.ErrInvalidSamples, version, samplesVersion)
+	}
+	for ; version < samplesVersion; version++ {
+		var err error
+		if data, err = sampleMigrations[version](data); err != nil {
+			return samplesDocument{}, fmt.Errorf("failed to migrate samples from version %v: %w", version, err)
+		}
+	}
+
+	var doc samplesDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return samplesDocument{}, fmt.Errorf("%w: %v", models.ErrInvalidSamples, err)
+	}
+	if doc.Samples == nil {
+		doc.Samples = make(map[string]deviceDataDTO)
+	}
+	return doc, nil
+}
 
+// encodeSamples returns the samples as a document of the current version.
+func encodeSamples(samples, devices map[string]deviceDataDTO) ([]byte, error) {
+	return json.Marshal(samplesDocument{Version: samplesVersion, Samples: samples, Devices: devices})
+}
+
+func loadSamples(path string, loc *time.Location) (*sync.Map, error) {
 	// Read file contents.
-	b, err := os.ReadFile(path)
-	if err != nil {
+	b, err := storage.Default.Read(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("usage samples file %q does not exist", path)
+	} else if err != nil {
 		return nil, fmt.Errorf("failed to read samples from file: %v", err)
 	}
 
 	// Unmarshal into DTO.
-	loadedData := make(map[string]deviceDataDTO)
-	err = json.Unmarshal(b, &loadedData)
+	doc, err := decodeSamples(b)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal samples: %v", err)
+		return nil, fmt.Errorf("failed to unmarshal samples: %w", err)
 	}
+	return samplesToMap(doc.Samples, loc), nil
+}
 
-	// Convert DTO to sync.Map.
+// keepUnreadableSamples moves a samples file that couldn't be loaded out of the way, so that it isn't overwritten by
+// the next save and the usage in it can still be migrated by a later build or imported once it's fixed.
+func keepUnreadableSamples(logger *zap.SugaredLogger, path string) {
+	if _, err := storage.Default.Read(path); errors.Is(err, os.ErrNotExist) { // if there's nothing to keep...
+		return
+	}
+	kept := path + ".unreadable"
+	if err := storage.Default.Rename(path, kept); err != nil {
+		logger.Errorf("Failed to keep the unreadable samples file %q: %v", path, err)
+		return
+	}
+	logger.Warnf("Kept the unreadable samples file as %q", kept)
+}
+
+// samplesToMap converts the DTOs to the device data stored by the tracker, following the clock in loc.
+func samplesToMap(loadedData map[string]deviceDataDTO, loc *time.Location) *sync.Map {
 	m := &sync.Map{}
 	for k, v := range loadedData {
 		if v.Config == nil { // if the samples file doesn't have tracker config persisted...
-			v.Config = getDefaultGroupTrackerConfig(&config.AppCfg.TrackerConfig) // set starter values.
+			v.Config = getDefaultGroupTrackerConfig(&config.Current().TrackerConfig) // set starter values.
 			// TODO: test for default TrackerConfig being set when loading samples files if it's not present. It's needed for window synchronisation.
 			//   TrackerConfig data will be set by the web interface eventually and should come before or at the same time as groupMAC data.
 			//   Remember that the web interface writes groupMAC data back to the API and tracker config data back to the API separately.
@@ -43,16 +187,19 @@ func loadSamples(path string) (*sync.Map, error) {
 			config:          v.Config,
 			samples:         v.Samples,
 			windowStartTime: v.WindowStartTime,
+			adjustment:      v.Adjustment,
+			carried:         v.Carried,
+			categories:      v.Categories,
+			session:         session{start: v.SessionStart, lastActive: v.LastActive, breakUntil: v.BreakUntil},
+			location:        loc,
 		})
 	}
-
-	return m, nil
+	return m
 }
 
-func saveSamples(logger *zap.SugaredLogger, path string, devices *sync.Map) error {
-	// Prepare the DTO map.
+// samplesFromMap converts the tracker's device data to DTOs.
+func samplesFromMap(devices *sync.Map) map[string]deviceDataDTO {
 	samples := make(map[string]deviceDataDTO)
-
 	devices.Range(func(k, v interface{}) bool {
 		data := v.(*deviceData)
 		data.mu.Lock()
@@ -61,16 +208,75 @@ func saveSamples(logger *zap.SugaredLogger, path string, devices *sync.Map) erro
 			Config:          data.config,
 			Samples:         data.samples,
 			WindowStartTime: data.windowStartTime,
+			Adjustment:      data.adjustment,
+			Carried:         data.carried,
+			Categories:      data.categories,
+			SessionStart:    data.session.start,
+			LastActive:      data.session.lastActive,
+			BreakUntil:      data.session.breakUntil,
 		}
 		return true
 	})
+	return samples
+}
 
-	// Marshal the DTO map.
-	b, err := json.Marshal(samples)
+func saveSamples(logger *zap.SugaredLogger, path string, devices *sync.Map) error {
+	b, err := encodeSamples(samplesFromMap(devices), nil)
 	if err != nil {
 		return err
 	}
 
 	// Write the samples to the file.
-	return config.FnDefaultSafeWriteViaTemp(path, string(b))
+	return storage.Default.Write(path, b)
+}
+
+// ExportSamples returns the samples of every group, and of every device if they're tracked, as a samplesDocument.
+func (t *Tracker) ExportSamples() ([]byte, error) {
+	var devices map[string]deviceDataDTO
+	if t.cfgTrackerDefaults.TrackDevices {
+		devices = samplesFromMap(t.macDevices)
+	}
+	return encodeSamples(samplesFromMap(t.devices), devices)
+}
+
+// ImportSamples replaces the samples of every group, and of every device if they're tracked, with those in a
+// samplesDocument of the current or an older version. Nothing is replaced if any samples don't fit their config.
+// The samples are saved to file on the next periodic save.
+func (t *Tracker) ImportSamples(data []byte) error {
+	doc, err := decodeSamples(data)
+	if err != nil {
+		return err
+	}
+	for _, m := range []map[string]deviceDataDTO{doc.Samples, doc.Devices} {
+		for k, v := range m {
+			size := getSampleSize(&config.Current().TrackerConfig)
+			if v.Config != nil {
+				size = v.Config.SampleSize
+			}
+			if size <= 0 || len(v.Samples) != size {
+				return fmt.Errorf("%w: %v has %v samples but expects %v", models.ErrInvalidSamples, k, len(v.Samples), size)
+			}
+			for name, samples := range v.Categories {
+				if len(samples) != size {
+					return fmt.Errorf("%w: %v category %v has %v samples but expects %v", models.ErrInvalidSamples, k, name, len(samples), size)
+				}
+			}
+		}
+	}
+
+	replace(t.devices, samplesToMap(doc.Samples, t.location))
+	if t.cfgTrackerDefaults.TrackDevices {
+		replace(t.macDevices, samplesToMap(doc.Devices, t.location))
+	}
+	t.logger.Infof("Imported the samples of %v groups and %v devices", len(doc.Samples), len(doc.Devices))
+	return nil
+}
+
+// replace swaps the contents of dst for those of src in place, since dst is shared with the periodic saves.
+func replace(dst, src *sync.Map) {
+	dst.Clear()
+	src.Range(func(k, v any) bool {
+		dst.Store(k, v)
+		return true
+	})
 }
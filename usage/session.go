@@ -0,0 +1,54 @@
+package usage
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// session tracks continuous usage so that a forced break can be applied once it reaches the group's MaxSession.
+type session struct {
+	start      time.Time // start is the time of the first active sample in the current session.
+	lastActive time.Time // lastActive is the time of the most recent active sample.
+	breakUntil time.Time // breakUntil is the end time of the current forced break, if any.
+}
+
+// recordSessionActivity extends the current session with an active sample at the given time and starts a forced
+// break once the session reaches MaxSession. A session ends after BreakDuration without activity, so a device
+// left idle for as long as a forced break would have lasted starts a fresh session.
+// It should be called under d.mu.
+func (d *deviceData) recordSessionActivity(logger *zap.SugaredLogger, now time.Time) {
+	if d.config.MaxSession <= 0 { // if forced breaks are disabled...
+		return
+	}
+	if d.onBreak(now) { // if usage during a break shouldn't extend the next session...
+		return
+	}
+
+	if d.session.lastActive.IsZero() || now.Sub(d.session.lastActive) >= d.config.BreakDuration { // if this is a new session...
+		d.session.start = now
+	}
+	d.session.lastActive = now
+
+	if now.Sub(d.session.start)+d.config.Granularity >= d.config.MaxSession { // if this sample completes the session...
+		d.session.breakUntil = now.Add(d.config.BreakDuration)
+		d.session.start = time.Time{}
+		d.session.lastActive = time.Time{}
+		logger.Infof("Usage tracker session reached %v, forcing a break until %v", d.config.MaxSession, d.session.breakUntil)
+	}
+}
+
+// onBreak returns true while a forced break is in progress.
+// It should be called under d.mu.
+func (d *deviceData) onBreak(now time.Time) bool {
+	return d.config.MaxSession > 0 && now.Before(d.session.breakUntil)
+}
+
+// sessionLength returns the length of the current session, which is zero once it has been idle for BreakDuration.
+// It should be called under d.mu.
+func (d *deviceData) sessionLength(now time.Time) time.Duration {
+	if d.session.lastActive.IsZero() || now.Sub(d.session.lastActive) >= d.config.BreakDuration {
+		return 0
+	}
+	return d.session.lastActive.Sub(d.session.start) + d.config.Granularity
+}
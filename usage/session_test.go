@@ -0,0 +1,102 @@
+package usage
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+func TestRecordSessionActivity(t *testing.T) {
+	logger := config.MustGetLogger()
+	start := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+	newSessionData := func() *deviceData {
+		return newDeviceData(start, &models.TrackerConfig{
+			Retention:     24 * time.Hour,
+			Granularity:   time.Minute,
+			Threshold:     3 * time.Hour,
+			MaxSession:    45 * time.Minute,
+			BreakDuration: 15 * time.Minute,
+		}, time.Local)
+	}
+
+	t.Run("Continuous use forces a break", func(t *testing.T) {
+		dd := newSessionData()
+		for i := 0; i < 44; i++ {
+			dd.recordSessionActivity(logger, start.Add(time.Duration(i)*time.Minute))
+		}
+		assert.Equal(t, 44*time.Minute, dd.sessionLength(start.Add(43*time.Minute)))
+		assert.False(t, dd.onBreak(start.Add(43*time.Minute)), "expected no break before the session limit")
+
+		end := start.Add(44 * time.Minute)
+		dd.recordSessionActivity(logger, end)
+		assert.True(t, dd.onBreak(end), "expected a break once the session limit is reached")
+		assert.True(t, dd.onBreak(end.Add(14*time.Minute)))
+		assert.False(t, dd.onBreak(end.Add(15*time.Minute)), "expected the break to end after BreakDuration")
+
+		blocked, _ := dd.isBlocked(logger, end.Add(time.Minute))
+		assert.True(t, blocked, "expected the group to be blocked during the break")
+	})
+
+	t.Run("Activity during a break doesn't extend the next session", func(t *testing.T) {
+		dd := newSessionData()
+		for i := 0; i < 45; i++ {
+			dd.recordSessionActivity(logger, start.Add(time.Duration(i)*time.Minute))
+		}
+		dd.recordSessionActivity(logger, start.Add(50*time.Minute))
+		assert.Zero(t, dd.sessionLength(start.Add(50*time.Minute)))
+	})
+
+	t.Run("Idle for the break duration starts a new session", func(t *testing.T) {
+		dd := newSessionData()
+		for i := 0; i < 30; i++ {
+			dd.recordSessionActivity(logger, start.Add(time.Duration(i)*time.Minute))
+		}
+		resume := start.Add(29*time.Minute + 15*time.Minute)
+		dd.recordSessionActivity(logger, resume)
+		assert.Equal(t, time.Minute, dd.sessionLength(resume))
+	})
+
+	t.Run("Short pauses don't end a session", func(t *testing.T) {
+		dd := newSessionData()
+		dd.recordSessionActivity(logger, start)
+		dd.recordSessionActivity(logger, start.Add(10*time.Minute))
+		assert.Equal(t, 11*time.Minute, dd.sessionLength(start.Add(10*time.Minute)))
+	})
+
+	t.Run("Disabled without MaxSession", func(t *testing.T) {
+		dd := newSessionData()
+		dd.config.MaxSession = 0
+		for i := 0; i < 120; i++ {
+			dd.recordSessionActivity(logger, start.Add(time.Duration(i)*time.Minute))
+		}
+		assert.False(t, dd.onBreak(start.Add(120*time.Minute)))
+	})
+}
+
+func TestTracker_GetSummary_Session(t *testing.T) {
+	logger := config.MustGetLogger()
+	start := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+	now := start.Add(44 * time.Minute)
+	tracker := &Tracker{logger: logger, devices: &sync.Map{}, nowFunc: func() time.Time { return now }}
+	dd := newDeviceData(start, &models.TrackerConfig{
+		Retention:     24 * time.Hour,
+		Granularity:   time.Minute,
+		Threshold:     3 * time.Hour,
+		MaxSession:    45 * time.Minute,
+		BreakDuration: 15 * time.Minute,
+	}, time.Local)
+	for i := 0; i <= 44; i++ {
+		dd.recordSessionActivity(logger, start.Add(time.Duration(i)*time.Minute))
+	}
+	tracker.devices.Store("kids", dd)
+
+	summary := tracker.GetSummary()["kids"]
+	assert.Zero(t, summary.SessionMinutes, "expected the session to end with the break")
+	if assert.NotNil(t, summary.BreakEndTime, "expected the break to be in force at the tracker's time") {
+		assert.Equal(t, now.Add(15*time.Minute), *summary.BreakEndTime)
+	}
+}
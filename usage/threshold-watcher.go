@@ -0,0 +1,127 @@
+package usage
+
+import (
+	"context"
+	"time"
+
+	"relloyd/tubetimeout/eventbus"
+	"relloyd/tubetimeout/models"
+)
+
+// RegisterThresholdStateReceivers subscribes receivers to the bus's threshold states, to be notified when a group
+// crosses its threshold in either direction.
+func (t *Tracker) RegisterThresholdStateReceivers(receivers ...models.ThresholdStateReceiver) {
+	for _, r := range receivers {
+		t.bus.ThresholdStates.Subscribe(eventbus.ThresholdStateFunc(r))
+	}
+}
+
+// RegisterWarningStateReceivers subscribes receivers to the bus's warning states, to be notified when a group starts
+// or stops being warned that it's nearly out of time.
+func (t *Tracker) RegisterWarningStateReceivers(receivers ...models.WarningStateReceiver) {
+	for _, r := range receivers {
+		t.bus.WarningStates.Subscribe(eventbus.WarningStateFunc(r))
+	}
+}
+
+// watchThresholdsPeriodically evaluates all groups on each tick so that state changes are noticed even when
+// no packets are flowing, e.g. when a block expires or the retention window rolls over.
+// The summaries of all groups are also sent to live event receivers.
+func watchThresholdsPeriodically(ctx context.Context, t *Tracker, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	for {
+		select {
+		case <-ctx.Done():
+			ticker.Stop()
+			return
+		case <-ticker.C:
+			t.checkThresholds()
+			t.publishSummaries()
+		}
+	}
+}
+
+// checkThresholds evaluates every known group and publishes the groups whose state changed since the last check.
+// Groups that are seen for the first time are only notified if they have already exceeded their threshold.
+func (t *Tracker) checkThresholds() {
+	// Collect the device IDs first so we don't hold the sync.Map range while evaluating.
+	ids := make(map[string]bool)
+	t.devices.Range(func(k, _ any) bool {
+		ids[k.(string)] = true
+		return true
+	})
+
+	type change struct {
+		group    models.Group
+		exceeded bool
+		reason   string
+		mode     models.UsageTrackerMode
+	}
+	var changes []change
+	type warningChange struct {
+		group   models.Group
+		warning bool
+		reason  string
+	}
+	var warnings []warningChange
+
+	now := t.nowFunc()
+	for id := range ids {
+		data, ok := t.devices.Load(id)
+		if !ok { // if the group was reset since we collected the IDs...
+			continue
+		}
+		exceeded, reason, mode := t.blockedState(data.(*deviceData), now)
+		t.muThreshold.Lock()
+		prev, seen := t.thresholdStates[id]
+		t.thresholdStates[id] = exceeded
+		t.muThreshold.Unlock()
+		if (seen && prev != exceeded) || (!seen && exceeded) { // if the state flipped or starts exceeded...
+			changes = append(changes, change{group: models.Group(id), exceeded: exceeded, reason: reason, mode: mode})
+		}
+
+		warning, reason := t.warningState(data.(*deviceData))
+		t.muThreshold.Lock()
+		prevWarning := t.warningStates[id]
+		if warning {
+			t.warningStates[id] = true
+		} else {
+			delete(t.warningStates, id)
+		}
+		t.muThreshold.Unlock()
+		if warning != prevWarning {
+			warnings = append(warnings, warningChange{group: models.Group(id), warning: warning, reason: reason})
+		}
+	}
+
+	// Release groups that have been removed from the tracker, e.g. after a reset.
+	t.muThreshold.Lock()
+	for id, exceeded := range t.thresholdStates {
+		if !ids[id] {
+			delete(t.thresholdStates, id)
+			if exceeded {
+				changes = append(changes, change{group: models.Group(id), exceeded: false, reason: "usage was reset"})
+			}
+		}
+	}
+	for id := range t.warningStates {
+		if !ids[id] {
+			delete(t.warningStates, id)
+			warnings = append(warnings, warningChange{group: models.Group(id), warning: false, reason: "usage was reset"})
+		}
+	}
+	t.muThreshold.Unlock()
+
+	for _, w := range warnings {
+		if w.warning {
+			t.logger.Infof("Usage tracker %v is nearly out of time: %v", w.group, w.reason)
+		}
+		t.bus.WarningStates.Publish(eventbus.WarningState{Group: w.group, Warning: w.warning, Reason: w.reason})
+	}
+
+	for _, c := range changes {
+		t.logger.Infof("Usage tracker %v threshold state changed: exceeded=%v: %v", c.group, c.exceeded, c.reason)
+		t.recordTransition(models.ModeTransition{Group: c.group, Cause: models.TransitionTracker, Mode: c.mode, Blocked: c.exceeded, Reason: c.reason})
+		t.bus.ThresholdStates.Publish(eventbus.ThresholdState{Group: c.group, Exceeded: c.exceeded})
+	}
+}
@@ -0,0 +1,157 @@
+package usage
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+type mockThresholdStateReceiver struct {
+	mu      sync.Mutex
+	updates []models.Group
+	states  map[models.Group]bool
+}
+
+func (m *mockThresholdStateReceiver) UpdateThresholdState(group models.Group, exceeded bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.states == nil {
+		m.states = make(map[models.Group]bool)
+	}
+	m.updates = append(m.updates, group)
+	m.states[group] = exceeded
+}
+
+func TestCheckThresholds(t *testing.T) {
+	cfg := &models.TrackerConfig{
+		Granularity: 1 * time.Minute,
+		Retention:   1 * time.Hour,
+		Threshold:   10 * time.Minute,
+		Mode:        models.ModeMonitor,
+	}
+
+	tracker, err := NewTracker(context.Background(), config.MustGetLogger(), cfg, nil)
+	assert.NoError(t, err, "NewTracker failed")
+
+	receiver := &mockThresholdStateReceiver{}
+	tracker.RegisterThresholdStateReceivers(receiver)
+
+	deviceID := "test-device"
+	data := newDeviceData(time.Now(), cfg, time.Local)
+	tracker.devices.Store(deviceID, data)
+
+	// Case 1: a new group under its threshold is not notified.
+	tracker.checkThresholds()
+	assert.Len(t, receiver.updates, 0, "expected no notification for a new group under threshold")
+
+	// Case 2: the group exceeds its threshold.
+	for i := 0; i < 10; i++ {
+		data.samples[i] = true
+	}
+	tracker.checkThresholds()
+	assert.Len(t, receiver.updates, 1, "expected a notification when the threshold is exceeded")
+	assert.True(t, receiver.states[models.Group(deviceID)], "expected the group to be exceeded")
+
+	// Case 3: no change means no notification.
+	tracker.checkThresholds()
+	assert.Len(t, receiver.updates, 1, "expected no notification without a state change")
+
+	// Case 4: the group is allowed again.
+	data.config.Mode = models.ModeAllow
+	data.config.ModeEndTime = time.Now().Add(time.Minute)
+	tracker.checkThresholds()
+	assert.Len(t, receiver.updates, 2, "expected a notification when the group is allowed")
+	assert.False(t, receiver.states[models.Group(deviceID)], "expected the group to be under threshold")
+
+	// Case 5: a reset group that was exceeded is released.
+	data.config.Mode = models.ModeMonitor
+	tracker.checkThresholds()
+	assert.True(t, receiver.states[models.Group(deviceID)], "expected the group to be exceeded again")
+	tracker.Reset(deviceID)
+	tracker.checkThresholds()
+	assert.Len(t, receiver.updates, 4, "expected a notification after the group was reset")
+	assert.False(t, receiver.states[models.Group(deviceID)], "expected the reset group to be released")
+}
+
+type mockWarningStateReceiver struct {
+	mu       sync.Mutex
+	warnings []bool
+}
+
+func (m *mockWarningStateReceiver) UpdateWarningState(_ models.Group, warning bool, _ string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.warnings = append(m.warnings, warning)
+}
+
+func TestCheckThresholds_Warnings(t *testing.T) {
+	cfg := &models.TrackerConfig{
+		Granularity: 1 * time.Minute,
+		Retention:   1 * time.Hour,
+		Threshold:   10 * time.Minute,
+		WarnAt:      80,
+		GracePeriod: 2 * time.Minute,
+		Mode:        models.ModeMonitor,
+	}
+
+	tracker, err := NewTracker(context.Background(), config.MustGetLogger(), cfg, nil)
+	assert.NoError(t, err, "NewTracker failed")
+
+	thresholds := &mockThresholdStateReceiver{}
+	warnings := &mockWarningStateReceiver{}
+	tracker.RegisterThresholdStateReceivers(thresholds)
+	tracker.RegisterWarningStateReceivers(warnings)
+
+	deviceID := "test-device"
+	data := newDeviceData(time.Now(), cfg, time.Local)
+	tracker.devices.Store(deviceID, data)
+
+	tracker.checkThresholds()
+	assert.Empty(t, warnings.warnings, "expected no warning under the warning percentage")
+	assert.False(t, tracker.IsWarning(deviceID))
+
+	// The group reaches its warning percentage.
+	for i := 0; i < 8; i++ {
+		data.samples[i] = true
+	}
+	tracker.checkThresholds()
+	assert.Equal(t, []bool{true}, warnings.warnings)
+	assert.True(t, tracker.IsWarning(deviceID))
+
+	// The group is still only warned in its grace period.
+	for i := 8; i < 11; i++ {
+		data.samples[i] = true
+	}
+	tracker.checkThresholds()
+	assert.Equal(t, []bool{true}, warnings.warnings, "expected no notification while the warning continues")
+	assert.Empty(t, thresholds.updates, "expected the group not to be blocked in its grace period")
+
+	// The grace period ends.
+	data.samples[11] = true
+	tracker.checkThresholds()
+	assert.Equal(t, []bool{true, false}, warnings.warnings)
+	assert.False(t, tracker.IsWarning(deviceID))
+	assert.True(t, thresholds.states[models.Group(deviceID)], "expected the group to be blocked after its grace period")
+}
+
+func TestWarningState_UsesTrackerClock(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+	tracker := &Tracker{logger: config.MustGetLogger(), nowFunc: func() time.Time { return now }}
+	data := newDeviceData(now, &models.TrackerConfig{
+		Retention:   time.Hour,
+		Granularity: time.Minute,
+		Threshold:   10 * time.Minute,
+		WarnAt:      80,
+	}, time.Local)
+	for i := 0; i < 8; i++ {
+		data.samples[i] = true
+	}
+	warning, reason := tracker.warningState(data)
+	assert.True(t, warning, "expected the window to be evaluated at the tracker's time, not the wall clock")
+	assert.NotEmpty(t, reason)
+}
@@ -3,52 +3,108 @@ package usage
 import (
 	"context"
 	"fmt"
+	"maps"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
 	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/eventbus"
 	"relloyd/tubetimeout/models"
 )
 
 // type saveSamplesFunc func(*zap.SugaredLogger, string, *sync.Map) error
 
+const (
+	// monthlyRetention is the length of the longest monthly window, i.e. 31 days and the hour gained when the clocks
+	// go back, and so the samples kept for a monthly window.
+	monthlyRetention = (31*24 + 1) * time.Hour
+	// monthlyGranularity is the coarsest granularity of monthly windows, so that a month of samples takes no more
+	// space than a week of minutes.
+	monthlyGranularity = 5 * time.Minute
+	// clockChange is the most that a window of a day or more can be lengthened by the clocks going back, and so the
+	// extra samples kept for daily and weekly windows.
+	clockChange = time.Hour
+)
+
 var (
 	fnLoadSamples                       = loadSamples
 	fnSaveSamples                       = saveSamples
 	fnGetGroupTrackerConfig             = config.GetConfig[models.MapGroupTrackerConfig]
-	fnGetTrackerSamplesFile             = config.FnDefaultCreateAppHomeDirAndGetConfigFilePath
+	fnGetTrackerSamplesFile             = func(name string) (string, error) { return config.DefaultStore.Path(name) }
 	fnSaveSamplesPeriodically           = saveSamplesPeriodically
+	fnWatchThresholdsPeriodically       = watchThresholdsPeriodically
 	defaultGroupTrackerConfigFilePath   = "usage-tracker-config.yaml"
-	groupTrackerConfigFileUpdated       = false
 	ErrorGroupTrackerConfigFileNotFound = fmt.Errorf("usage-tracker config file not found")
+	deviceSamplesFilePrefix             = "devices-" // deviceSamplesFilePrefix is added to the samples file name to save per-MAC samples.
+	deviceKeySeparator                  = "/"
 )
 
+func init() {
+	config.Backups.Register(defaultGroupTrackerConfigFilePath, "usage trackers and schedules")
+	if f := config.AppCfg.TrackerConfig.SampleFilePath; f != "" {
+		config.Backups.Register(f, "usage samples")
+		dir, file := filepath.Split(f)
+		config.Backups.Register(filepath.Join(dir, deviceSamplesFilePrefix+file), "per-device usage samples")
+	}
+}
+
 type Tracker struct {
 	logger             *zap.SugaredLogger
 	cfgTrackerDefaults *models.TrackerConfig
 	cfgGroups          models.MapGroupTrackerConfig
 	mu                 *sync.Mutex
 	devices            *sync.Map        // Map of device IDs (string) to *deviceData
+	macDevices         *sync.Map        // Map of "group/MAC" keys to *deviceData when device tracking is enabled
 	nowFunc            func() time.Time // Function to get the current time (defaults to time.Now)
+	muThreshold        sync.Mutex
+	thresholdStates    map[string]bool // last known threshold state per device ID
+	warningStates      map[string]bool // warningStates are the last known warning state per device ID, guarded by muThreshold.
+	bus                *eventbus.Bus   // bus is where threshold, warning, mode and live events are published.
+	saves              []*saveStatus   // saves records the outcome of the periodic saves of each samples file
+	history            *modeHistory    // history records the times groups were blocked and allowed, or is nil if disabled
+	muKillSwitch       sync.Mutex
+	killSwitchEnd      time.Time                           // killSwitchEnd is when the kill switch's Block mode ends, guarded by muKillSwitch.
+	killSwitchModes    map[models.Group]models.TrackerMode // killSwitchModes are the modes to restore when the kill switch is turned off, guarded by muKillSwitch.
+	location           *time.Location                      // location is the time zone of TRACKER_TIMEZONE, whose clock the windows follow.
 }
 
-// NewTracker initializes a Tracker with pre-allocated slices for each device.
-func NewTracker(ctx context.Context, logger *zap.SugaredLogger, cfg *models.TrackerConfig) (*Tracker, error) {
+// NewTracker initializes a Tracker with pre-allocated slices for each device. It publishes its events to the bus, or
+// to a bus of its own if that's nil.
+func NewTracker(ctx context.Context, logger *zap.SugaredLogger, cfg *models.TrackerConfig, bus *eventbus.Bus) (*Tracker, error) {
 	if logger == nil || cfg == nil {
 		return nil, fmt.Errorf("logger and config must be provided")
 	}
+	if !isMonthly(cfg) && cfg.Retention > 7*24*time.Hour {
+		return nil, fmt.Errorf("tracker retention %v is longer than a week: set TRACKER_WINDOW=monthly for a monthly quota", cfg.Retention)
+	}
+	loc, err := loadLocation(cfg.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	if bus == nil {
+		bus = eventbus.NewBus()
+	}
 
 	t := &Tracker{
 		logger:             logger,
 		mu:                 &sync.Mutex{},
 		devices:            &sync.Map{},
+		macDevices:         &sync.Map{},
 		nowFunc:            time.Now, // Default to time.Now
 		cfgTrackerDefaults: cfg,
+		thresholdStates:    make(map[string]bool),
+		warningStates:      make(map[string]bool),
+		bus:                bus,
+		location:           loc,
 	}
 
 	// Load groups config from file.
-	var err error
 	t.cfgGroups, err = fnGetGroupTrackerConfig(t.mu, defaultGroupTrackerConfigFilePath, func() models.MapGroupTrackerConfig { return make(models.MapGroupTrackerConfig) })
 	if err != nil {
 		return nil, err
@@ -57,15 +113,27 @@ func NewTracker(ctx context.Context, logger *zap.SugaredLogger, cfg *models.Trac
 		t.cfgGroups = make(models.MapGroupTrackerConfig)
 	}
 
+	// Keep a history of the times groups were blocked and allowed.
+	if cfg.HistoryRetention > 0 {
+		historyFile, err := fnGetTrackerSamplesFile(defaultModeHistoryFilePath)
+		if err == nil {
+			t.history, err = newModeHistory(historyFile, cfg.HistoryRetention, t.nowFunc())
+		}
+		if err != nil {
+			logger.Errorf("Failed to load mode history, it won't be recorded: %v", err)
+		}
+	}
+
 	// Load & save existing sample data.
 	if cfg.SampleFilePath != "" { // TODO: test when SampleFilePath is empty that no files are saved
 		samplesFile, err := fnGetTrackerSamplesFile(cfg.SampleFilePath)
 		if err != nil {
 			return nil, err
 		}
-		s, err := fnLoadSamples(samplesFile)
+		s, err := fnLoadSamples(samplesFile, t.location)
 		if err != nil {
 			logger.Errorf("Failed to load samples from file: %v", err)
+			keepUnreadableSamples(logger, samplesFile)
 		} else {
 			// Load the samples into the devices map.
 			logger.Infof("Samples loaded from file: %q", samplesFile)
@@ -73,18 +141,45 @@ func NewTracker(ctx context.Context, logger *zap.SugaredLogger, cfg *models.Trac
 		}
 		// Save samples to the file on context cancellation.
 		if cfg.SampleFileSaveInterval > 0 {
-			go fnSaveSamplesPeriodically(ctx, t.logger, t.devices, samplesFile, cfg.SampleFileSaveInterval)
+			status := newSaveStatus(samplesFile, cfg.SampleFileSaveInterval)
+			t.saves = append(t.saves, status)
+			go fnSaveSamplesPeriodically(ctx, t.logger, t.devices, samplesFile, cfg.SampleFileSaveInterval, status)
+		}
+		// Same again for per-MAC samples.
+		if cfg.TrackDevices {
+			dir, file := filepath.Split(samplesFile)
+			deviceSamplesFile := filepath.Join(dir, deviceSamplesFilePrefix+file)
+			s, err = fnLoadSamples(deviceSamplesFile, t.location)
+			if err != nil {
+				logger.Errorf("Failed to load device samples from file: %v", err)
+				keepUnreadableSamples(logger, deviceSamplesFile)
+			} else {
+				logger.Infof("Device samples loaded from file: %q", deviceSamplesFile)
+				t.macDevices = s
+			}
+			if cfg.SampleFileSaveInterval > 0 {
+				status := newSaveStatus(deviceSamplesFile, cfg.SampleFileSaveInterval)
+				t.saves = append(t.saves, status)
+				go fnSaveSamplesPeriodically(ctx, t.logger, t.macDevices, deviceSamplesFile, cfg.SampleFileSaveInterval, status)
+			}
 		}
 	}
 
+	// Notify threshold state changes to any registered receivers.
+	if cfg.StateCheckInterval > 0 {
+		go fnWatchThresholdsPeriodically(ctx, t, cfg.StateCheckInterval)
+	}
+
 	return t, nil
 }
 
 // TODO: only save samples if there are changes to the samples.
-func saveSamplesPeriodically(ctx context.Context, logger *zap.SugaredLogger, devicesToSave *sync.Map, filePath string, interval time.Duration) {
+func saveSamplesPeriodically(ctx context.Context, logger *zap.SugaredLogger, devicesToSave *sync.Map, filePath string, interval time.Duration, status *saveStatus) {
 	ticker := time.NewTicker(interval)
 	fn := func() {
-		if err := fnSaveSamples(logger, filePath, devicesToSave); err != nil {
+		err := fnSaveSamples(logger, filePath, devicesToSave)
+		status.record(time.Now(), err)
+		if err != nil {
 			logger.Errorf("Failed to save samples to file: %v", err)
 		} else {
 			logger.Infof("Saved samples to file %q", filePath)
@@ -104,8 +199,32 @@ func saveSamplesPeriodically(ctx context.Context, logger *zap.SugaredLogger, dev
 type deviceData struct {
 	mu              *sync.Mutex
 	config          *models.TrackerConfig
-	samples         []bool    // Slice of fixed size to represent the rotating window
-	windowStartTime time.Time // Start time of the slice window
+	samples         []bool            // Slice of fixed size to represent the rotating window
+	windowStartTime time.Time         // Start time of the slice window
+	session         session           // session tracks continuous usage for forced breaks
+	watch           watch             // watch tracks recent activity so that background traffic isn't counted
+	adjustment      int               // adjustment is the number of samples added to (positive) or removed from (negative) the threshold by transfers in the current window
+	carried         int               // carried is the number of unused samples rolled over from the previous window
+	categories      map[string][]bool // categories are the samples of each of the group's categories, in step with samples
+	location        *time.Location    // location is the time zone whose clock the windows, counting hours and day thresholds follow
+	// verdict caches whether the group is blocked for the packet filter, so that it can be read without d.mu or
+	// counting the samples for each packet. It's stored under d.mu and cleared by the changes that can affect it.
+	verdict atomic.Pointer[verdict]
+	// syncedConfig is the group config last copied into config by a sample. The group configs are replaced rather
+	// than changed when they're saved or reloaded, so a different one means the verdict may have changed.
+	syncedConfig *models.TrackerConfig
+}
+
+// verdict is whether a group is blocked between the times it can't change without a change to the group's samples,
+// mode or budget.
+type verdict struct {
+	blocked     bool
+	from, until time.Time
+}
+
+// covers returns true if the verdict still applies at the given time.
+func (v *verdict) covers(now time.Time) bool {
+	return !now.Before(v.from) && now.Before(v.until)
 }
 
 // deviceDataDTO is used to save/load deviceData{}. It is a DTO to avoid saving the mutex.
@@ -113,27 +232,45 @@ type deviceDataDTO struct {
 	Config          *models.TrackerConfig `json:"config"`
 	Samples         []bool                `json:"samples"`
 	WindowStartTime time.Time             `json:"windowStartTime"`
+	Adjustment      int                   `json:"adjustment,omitempty"`
+	Carried         int                   `json:"carried,omitempty"`
+	Categories      map[string][]bool     `json:"categories,omitempty"`
+	SessionStart    time.Time             `json:"sessionStart"`
+	LastActive      time.Time             `json:"lastActive"`
+	BreakUntil      time.Time             `json:"breakUntil"`
 }
 
 func getDefaultGroupTrackerConfig(t *models.TrackerConfig) *models.TrackerConfig {
 	return &models.TrackerConfig{
-		Granularity:   t.Granularity,
-		Retention:     t.Retention,
-		Threshold:     t.Threshold,
-		StartDayInt:   t.StartDayInt,
-		StartDuration: t.StartDuration,
-		SampleSize:    getSampleSize(t),
-		Mode:          models.ModeMonitor,
-		ModeEndTime:   time.Time{},
+		Granularity:       t.Granularity,
+		Retention:         t.Retention,
+		Threshold:         t.Threshold,
+		DayThresholds:     t.DayThresholds,
+		StartDayInt:       t.StartDayInt,
+		StartDayOfMonth:   t.StartDayOfMonth,
+		Window:            t.Window,
+		StartDuration:     t.StartDuration,
+		CountFrom:         t.CountFrom,
+		CountUntil:        t.CountUntil,
+		BlockOutsideHours: t.BlockOutsideHours,
+		MaxSession:        t.MaxSession,
+		BreakDuration:     t.BreakDuration,
+		MinActive:         t.MinActive,
+		MinActiveWindow:   t.MinActiveWindow,
+		PacketSampling:    t.PacketSampling,
+		Rollover:          t.Rollover,
+		RolloverCap:       t.RolloverCap,
+		WarnAt:            t.WarnAt,
+		GracePeriod:       t.GracePeriod,
+		PacketPolicy:      t.PacketPolicy,
+		Categories:        t.Categories,
+		SampleSize:        getSampleSize(t),
+		Mode:              models.ModeMonitor,
+		ModeEndTime:       time.Time{},
 	}
 }
 
-func newDeviceData(now time.Time, cfg *models.TrackerConfig) *deviceData {
-	// TODO: support more that 7*24h retention windows!
-	if cfg.Retention > 7*24*time.Hour {
-		cfg.Retention = 7 * 24 * time.Hour
-	}
-
+func newDeviceData(now time.Time, cfg *models.TrackerConfig, loc *time.Location) *deviceData {
 	if cfg.Retention < 24*time.Hour {
 		cfg.StartDayInt = 0
 	}
@@ -146,14 +283,20 @@ func newDeviceData(now time.Time, cfg *models.TrackerConfig) *deviceData {
 		cfg.Granularity = 1 * time.Minute
 	}
 
+	if isMonthly(cfg) {
+		cfg.Granularity = max(cfg.Granularity, monthlyGranularity)
+		cfg.StartDayOfMonth = min(max(cfg.StartDayOfMonth, 1), 28)
+	}
+
 	cfg.SampleSize = getSampleSize(cfg)
 
 	cfgCopy := *cfg
 
 	dd := &deviceData{
-		config:  &cfgCopy,
-		mu:      &sync.Mutex{},
-		samples: make([]bool, cfg.SampleSize),
+		config:   &cfgCopy,
+		mu:       &sync.Mutex{},
+		samples:  make([]bool, cfg.SampleSize),
+		location: loc,
 		// windowStartTime is set below
 	}
 
@@ -164,72 +307,206 @@ func newDeviceData(now time.Time, cfg *models.TrackerConfig) *deviceData {
 }
 
 func getSampleSize(cfg *models.TrackerConfig) int {
+	if isMonthly(cfg) { // if the window's length depends on the month...
+		return int(monthlyRetention / cfg.Granularity)
+	}
+	if cfg.Retention >= 24*time.Hour { // if the window follows the clock...
+		return int((cfg.Retention + clockChange) / cfg.Granularity)
+	}
 	return int(cfg.Retention / cfg.Granularity)
 }
 
+// loadLocation returns the time zone with the given IANA name, e.g. Europe/London, or the local time zone if it's
+// empty.
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tracker time zone %q, check that the tz database is installed: %w", name, err)
+	}
+	return loc, nil
+}
+
+// wallClock returns the time that the clock in loc shows d past midnight on the given day, so that e.g. a window
+// starting at 6am starts at 6am on the days the clocks change too. time.Date normalises days, and months, outside
+// their range.
+func wallClock(loc *time.Location, y int, m time.Month, day int, d time.Duration) time.Time {
+	return time.Date(y, m, day, 0, 0, int(d/time.Second), int(d%time.Second), loc)
+}
+
+// clockTime returns the time of day that the clock in loc shows at t, which differs from the time since midnight on
+// the days the clocks change.
+func clockTime(t time.Time, loc *time.Location) time.Duration {
+	h, m, s := t.In(loc).Clock()
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second + time.Duration(t.Nanosecond())
+}
+
+// isMonthly returns true if the window starts on the same day each month.
+func isMonthly(cfg *models.TrackerConfig) bool {
+	return cfg.Window == models.WindowMonthly
+}
+
 // AddSample records a sample for a given identifier at the current time.
 // TODO: add test for AddSample() when tracker is paused
 func (t *Tracker) AddSample(id string, active bool) {
 	now := t.nowFunc() // Use nowFunc instead of time.Now
 
 	// Load the config for the group/id or use defaults.
+	t.mu.Lock()
+	cfg := t.getGroupConfig(id)
+	counted := addSampleToDevice(t.logger, t.devices, id, cfg, t.location, now, active)
+	t.mu.Unlock()
+
+	if counted { // if the usage went up...
+		t.publishSummaries(id)
+	}
+}
+
+// AddDeviceSample records a sample for the given MAC within a group, when device tracking is enabled.
+// The group's config is used for the window and the sample is only counted when the group's tracker is
+// monitoring, so that paused groups don't add to device usage either.
+func (t *Tracker) AddDeviceSample(id string, mac models.MAC, active bool) {
+	if !t.cfgTrackerDefaults.TrackDevices {
+		return
+	}
+	now := t.nowFunc()
+
+	if data, ok := t.devices.Load(id); ok { // if the group is tracked...
+		dd := data.(*deviceData)
+		dd.mu.Lock()
+		if dd.config.Mode != models.ModeMonitor {
+			active = false
+		}
+		dd.mu.Unlock()
+	}
+
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	cfg := t.getGroupConfig(id)
+
+	_ = addSampleToDevice(t.logger, t.macDevices, getDeviceKey(id, mac), cfg, t.location, now, active)
+}
+
+// getGroupConfig returns the config for the group or saves and returns the defaults.
+// It should be called under t.mu.
+func (t *Tracker) getGroupConfig(id string) *models.TrackerConfig {
 	cfg, ok := t.cfgGroups[models.Group(id)]
 	if !ok {
 		t.logger.Errorf("Unable to load config for group %v, using defaults", id)
 		cfg = getDefaultGroupTrackerConfig(t.cfgTrackerDefaults)
 		t.cfgGroups[models.Group(id)] = cfg // save the config, so we don't have to set this again until data is overridden by global group tracker config
 	}
+	return cfg
+}
+
+func getDeviceKey(id string, mac models.MAC) string {
+	return id + deviceKeySeparator + string(mac)
+}
 
+// addSampleToDevice records a sample in the devices map for the given ID, syncing the device config first.
+// It returns true if the sample added to the usage, i.e. it is the first active sample in its slot.
+// New devices follow the clock in loc.
+func addSampleToDevice(logger *zap.SugaredLogger, devices *sync.Map, id string, cfg *models.TrackerConfig, loc *time.Location, now time.Time, active bool) bool {
+	return addCategorySampleToDevice(logger, devices, id, "", cfg, loc, now, active)
+}
+
+// addCategorySampleToDevice is addSampleToDevice for the samples of a category, or of the group itself if the
+// category is empty. Category samples don't wait for MinActive or count toward forced breaks.
+func addCategorySampleToDevice(logger *zap.SugaredLogger, devices *sync.Map, id, category string, cfg *models.TrackerConfig, loc *time.Location, now time.Time, active bool) bool {
 	// Get or initialize the device data.
-	data, loaded := t.devices.LoadOrStore(id, newDeviceData(now, cfg))
+	data, loaded := devices.LoadOrStore(id, newDeviceData(now, cfg, loc))
 	dd := data.(*deviceData)
 	dd.mu.Lock()
 	defer dd.mu.Unlock()
+	counted := false
+	changed := cfg != dd.syncedConfig // changed is true if the verdict may no longer apply.
+	breakUntil := dd.session.breakUntil
 
-	t.logger.Debugf("Usage tracker for group %v: retention=%v, threshold=%v, mode=%v, modeEndTime=%v", id, cfg.Retention, cfg.Threshold, cfg.Mode, cfg.ModeEndTime)
+	logger.Debugf("Usage tracker for group %v: retention=%v, threshold=%v, mode=%v, modeEndTime=%v", id, cfg.Retention, cfg.Threshold, cfg.Mode, cfg.ModeEndTime)
 
 	if loaded {
 		// Ensure the config is up to date.
-		if dd.config.SampleSize != cfg.SampleSize || dd.config.Threshold != cfg.Threshold { // if the tracker size or threshold has changed...
+		if dd.config.SampleSize != cfg.SampleSize && dd.config.Retention == cfg.Retention && dd.config.Granularity == cfg.Granularity && !isMonthly(cfg) { // if only the samples kept for the clocks changing differ...
+			dd.resizeSamples(cfg.SampleSize)
+		}
+		if dd.config.SampleSize != cfg.SampleSize || dd.config.Threshold != cfg.Threshold || dd.config.Window != cfg.Window { // if the tracker size, threshold or window has changed...
 			// Reset the samples to zero usage.
-			t.logger.Info("Tracker sample size changed for group %v, resetting now", id)
+			logger.Info("Tracker sample size changed for group %v, resetting now", id)
 			mode := dd.config.Mode // preserve values
 			modeEnd := dd.config.ModeEndTime
-			dd = newDeviceData(now, cfg)
+			dd = newDeviceData(now, cfg, loc)
 			dd.config.Mode = mode
 			dd.config.ModeEndTime = modeEnd
-			t.devices.Store(id, dd)
+			devices.Store(id, dd)
 			dd.mu.Lock()
 			defer dd.mu.Unlock()
 		}
 		// Update other attributes that don't affect retention or thresholds.
 		// TODO: test that latest config is set.
-		if cfg.StartDuration != dd.config.StartDuration || cfg.StartDayInt != dd.config.StartDayInt {
+		if cfg.StartDuration != dd.config.StartDuration || cfg.StartDayInt != dd.config.StartDayInt || cfg.StartDayOfMonth != dd.config.StartDayOfMonth {
 			dd.config.StartDuration = cfg.StartDuration
 			dd.config.StartDayInt = cfg.StartDayInt
+			dd.config.StartDayOfMonth = cfg.StartDayOfMonth
 		}
+		dd.config.CountFrom = cfg.CountFrom
+		dd.config.CountUntil = cfg.CountUntil
+		dd.config.BlockOutsideHours = cfg.BlockOutsideHours
+		dd.config.MaxSession = cfg.MaxSession
+		dd.config.BreakDuration = cfg.BreakDuration
+		dd.config.MinActive = cfg.MinActive
+		dd.config.MinActiveWindow = cfg.MinActiveWindow
+		dd.config.PacketSampling = cfg.PacketSampling
+		dd.config.Rollover = cfg.Rollover
+		dd.config.DayThresholds = cfg.DayThresholds
+		dd.config.RolloverCap = cfg.RolloverCap
+		dd.config.WarnAt = cfg.WarnAt
+		dd.config.GracePeriod = cfg.GracePeriod
+		dd.config.PacketPolicy = cfg.PacketPolicy
+		dd.config.Categories = cfg.Categories
+		dd.pruneCategories()
 	}
+	dd.syncedConfig = cfg
 
-	if active && dd.config.Mode == models.ModeMonitor { // if the group is active and the tracker is not paused...
+	if active && dd.config.Mode == models.ModeMonitor && dd.inCountingHours(now) { // if the group is active, the tracker is not paused and usage counts right now...
 		// Ensure the time window is synchronized.
-		dd.syncWindow(t.logger, now)
-		// Mark the sample as seen.
-		index := dd.getIndex(now, dd.windowStartTime)
-		dd.samples[index] = true
-		t.logger.Debugf("Usage tracker %v in monitor mode (counting the sample)", id)
+		dd.syncWindow(logger, now)
+		if category != "" { // if the usage counts toward a category instead of the group...
+			samples := dd.categorySamples(category)
+			index := dd.getIndex(now, dd.windowStartTime)
+			counted = !samples[index]
+			samples[index] = true
+			logger.Debugf("Usage tracker %v in monitor mode (counting the sample for category %v)", id, category)
+		} else if watching, backfilled := dd.isWatching(now); watching { // if the activity is more than background traffic...
+			// Mark the sample as seen.
+			index := dd.getIndex(now, dd.windowStartTime)
+			counted = !dd.samples[index] || backfilled
+			dd.samples[index] = true
+			dd.recordSessionActivity(logger, now)
+			logger.Debugf("Usage tracker %v in monitor mode (counting the sample)", id)
+		} else {
+			logger.Debugf("Usage tracker %v in monitor mode (waiting for %v active before counting)", id, dd.config.MinActive)
+		}
 	}
 
 	// Reset the mode.
 	if (dd.config.Mode == models.ModeAllow || dd.config.Mode == models.ModeBlock) &&
 		dd.config.ModeEndTime.Before(now) { // if the tracker block/allow time has expired...
-		t.logger.Infof("Usage tracker %v is active again (monitor mode set)", id)
+		logger.Infof("Usage tracker %v is active again (monitor mode set)", id)
 		dd.config.Mode = models.ModeMonitor // TODO: add test for mode being reset in addSample
+		changed = true
 	}
+	if changed || counted || !dd.session.breakUntil.Equal(breakUntil) { // if the usage, mode, config or break changed...
+		dd.verdict.Store(nil)
+	}
+	return counted
 }
 
 // HasExceededThreshold checks if a device has exceeded the threshold duration.
+// See deviceData.isBlocked for the order in which modes, counting hours and the threshold are evaluated.
+// It's called for each packet, so the verdict is cached until the next sample, change of mode or budget, or time at
+// which it could change, e.g. the end of the sample's slot, and is read without locking the group in between.
 // TODO: add test for HasExceededThreshold() when tracker is paused
 func (t *Tracker) HasExceededThreshold(id string) bool {
 	data, ok := t.devices.Load(id)
@@ -237,33 +514,277 @@ func (t *Tracker) HasExceededThreshold(id string) bool {
 		t.logger.Errorf("Unable to load config for group %v, returning false has-not-exceeded-threshold", id)
 		return false
 	}
+	dd := data.(*deviceData)
+	now := t.nowFunc()
+	if v := dd.verdict.Load(); v != nil && v.covers(now) {
+		return v.blocked
+	}
+
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	blocked, reason := dd.isBlocked(t.logger, now)
+	dd.verdict.Store(&verdict{blocked: blocked, from: now, until: dd.verdictEnd(now)})
+	t.logger.Debugf("Usage tracker %s blocked=%v: %v", id, blocked, reason)
+	return blocked
+}
+
+// IsWarning returns true if the group was in its warning stage when the thresholds were last checked, i.e. it has
+// used WarnAt percent of its threshold or is in its grace period, but isn't blocked.
+func (t *Tracker) IsWarning(id string) bool {
+	t.muThreshold.Lock()
+	defer t.muThreshold.Unlock()
+	return t.warningStates[id]
+}
+
+// warningState returns whether the group is in its warning stage with the reason.
+func (t *Tracker) warningState(dd *deviceData) (bool, string) {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	now := t.nowFunc()
+	if blocked, _ := dd.isBlocked(t.logger, now); blocked {
+		return false, ""
+	}
+	return dd.isWarning(now)
+}
+
+// blockedState returns whether the group is blocked at the given time with the reason and its current mode.
+func (t *Tracker) blockedState(dd *deviceData, now time.Time) (bool, string, models.UsageTrackerMode) {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	blocked, reason := dd.isBlocked(t.logger, now)
+	mode := dd.config.Mode
+	if !now.Before(dd.config.ModeEndTime) { // if the mode has expired...
+		mode = models.ModeMonitor
+	}
+	return blocked, reason, mode
+}
 
+// Usage returns the time the group has used in its window, its threshold and its current mode, which is monitor once
+// a block or allow has expired. It returns zeroes if the group isn't tracked.
+func (t *Tracker) Usage(id string) (time.Duration, time.Duration, models.UsageTrackerMode) {
+	data, ok := t.devices.Load(id)
+	if !ok {
+		return 0, 0, models.ModeMonitor
+	}
 	dd := data.(*deviceData)
 	dd.mu.Lock()
 	defer dd.mu.Unlock()
+	mode := dd.config.Mode
+	if !t.nowFunc().Before(dd.config.ModeEndTime) { // if the mode has expired...
+		mode = models.ModeMonitor
+	}
+	return time.Duration(dd.countUsed()) * dd.config.Granularity, dd.threshold(), mode
+}
+
+// PacketPolicy returns how packets of the group are handled once it's over its threshold, or nil if it uses the
+// filter defaults.
+func (t *Tracker) PacketPolicy(id string) *models.PacketPolicy {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	cfg, ok := t.cfgGroups[models.Group(id)]
+	if !ok || cfg.PacketPolicy == nil { // if the group hasn't been configured or has no policy of its own...
+		return nil
+	}
+	p := *cfg.PacketPolicy
+	return &p
+}
+
+// Allowlists implements the AllowlistSource interface. It returns the allowlist of every configured group.
+func (t *Tracker) Allowlists() models.MapGroupAllowlist {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := make(models.MapGroupAllowlist, len(t.cfgGroups))
+	for group, cfg := range t.cfgGroups {
+		m[group] = slices.Clone(cfg.Allowlist)
+	}
+	return m
+}
+
+// cleanAllowlist trims, lower cases and de-duplicates the domains and IPs of an allowlist. Blank entries and
+// entries that are neither an IP nor a host name, e.g. URLs, are dropped.
+func cleanAllowlist(entries []string) []string {
+	var cleaned []string
+	for _, e := range entries {
+		e = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(e)), ".")
+		if e == "" || (net.ParseIP(e) == nil && strings.ContainsAny(e, " \t/:@")) {
+			continue
+		}
+		if !slices.Contains(cleaned, e) {
+			cleaned = append(cleaned, e)
+		}
+	}
+	return cleaned
+}
 
-	if dd.config.Mode == models.ModeAllow && time.Now().Before(dd.config.ModeEndTime) { // if the tracker is paused...
-		t.logger.Debugf("Usage tracker %s is allowed until %v", id, dd.config.ModeEndTime)
+// IsPacketSampled implements the PacketSampler interface. It returns true if the group has packet sampling enabled
+// and isn't blocked, since only a sample of packets is needed for accounting while blocked groups need a verdict for
+// every packet.
+func (t *Tracker) IsPacketSampled(id string) bool {
+	t.mu.Lock()
+	cfg, ok := t.cfgGroups[models.Group(id)]
+	if !ok { // if the group hasn't been configured yet it will take the defaults...
+		cfg = t.cfgTrackerDefaults
+	}
+	sampling := cfg.PacketSampling
+	t.mu.Unlock()
+	if !sampling {
 		return false
-	} else if dd.config.Mode == models.ModeBlock && time.Now().Before(dd.config.ModeEndTime) { // if the tracker is paused...
-		t.logger.Debugf("Usage tracker %s is blocked until %v", id, dd.config.ModeEndTime)
+	}
+
+	data, ok := t.devices.Load(id)
+	if !ok { // if the group hasn't been seen yet it can't be over its threshold...
 		return true
-	} // else the tracker is in monitor mode
+	}
+	dd := data.(*deviceData)
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	blocked, _ := dd.isBlocked(t.logger, t.nowFunc())
+	return !blocked
+}
+
+// isBlocked evaluates whether the group should be blocked at the given time, returning the reason for logging.
+// The order of evaluation is:
+//  1. an explicit allow or block mode that hasn't expired wins outright;
+//  2. outside the counting hours the group is blocked if BlockOutsideHours is set, else allowed (and not counted);
+//  3. a forced break, started once a continuous session reaches MaxSession, blocks until it ends;
+//  4. otherwise the group is blocked once the usage in the current window reaches the threshold plus any grace period.
+//
+// It should be called under d.mu.
+func (d *deviceData) isBlocked(logger *zap.SugaredLogger, now time.Time) (bool, string) {
+	if blocked, reason, decided := d.isBlockedByMode(now); decided {
+		return blocked, reason
+	}
+
+	if d.onBreak(now) { // if the group is taking a forced break...
+		return true, fmt.Sprintf("on a break until %v", d.session.breakUntil)
+	}
 
 	// Ensure the time window is synchronized.
-	dd.syncWindow(t.logger, time.Now())
+	d.syncWindow(logger, now)
 
 	// Count the number of true samples in the window.
+	count := d.countUsed()
+
+	used := time.Duration(count) * d.config.Granularity
+	if d.config.GracePeriod > 0 {
+		return used >= d.threshold()+d.config.GracePeriod, fmt.Sprintf("used %v of %v plus a grace period of %v", used, d.threshold(), d.config.GracePeriod)
+	}
+	return used >= d.threshold(), fmt.Sprintf("used %v of %v", used, d.threshold())
+}
+
+// verdictEnd returns the earliest time after now at which isBlocked could change without a change to the group, i.e.
+// the end of the current slot or window, mode or forced break, or the start or end of the counting hours.
+// It should be called under d.mu after isBlocked.
+func (d *deviceData) verdictEnd(now time.Time) time.Time {
+	end := d.windowStartTime.Add((now.Sub(d.windowStartTime)/d.config.Granularity + 1) * d.config.Granularity)
+	ends := []time.Time{d.windowEnd(), d.config.ModeEndTime, d.session.breakUntil}
+	if from, until := d.config.CountFrom, d.config.CountUntil; from != until { // if there are counting hours...
+		loc := d.location
+		y, m, day := now.In(loc).Date()
+		ends = append(ends, wallClock(loc, y, m, day, from), wallClock(loc, y, m, day, until), wallClock(loc, y, m, day+1, from), wallClock(loc, y, m, day+1, until))
+	}
+	for _, e := range ends {
+		if e.After(now) && e.Before(end) {
+			end = e
+		}
+	}
+	return end.In(now.Location())
+}
+
+// isBlockedByMode evaluates the first two steps of isBlocked, the explicit modes and the counting hours, returning
+// decided false if the usage needs evaluating.
+// It should be called under d.mu.
+func (d *deviceData) isBlockedByMode(now time.Time) (blocked bool, reason string, decided bool) {
+	if d.config.Mode == models.ModeAllow && now.Before(d.config.ModeEndTime) { // if the tracker is paused...
+		return false, fmt.Sprintf("allowed until %v", d.config.ModeEndTime), true
+	} else if d.config.Mode == models.ModeBlock && now.Before(d.config.ModeEndTime) { // if the tracker is paused...
+		return true, fmt.Sprintf("blocked until %v", d.config.ModeEndTime), true
+	} // else the tracker is in monitor mode
+
+	if !d.inCountingHours(now) { // if usage doesn't count right now...
+		return d.config.BlockOutsideHours, "outside counting hours", true
+	}
+	return false, "", false
+}
+
+// isWarning returns true with the reason if the group isn't blocked but has used WarnAt percent of its threshold,
+// or is in its grace period.
+// It should be called under d.mu after isBlocked.
+func (d *deviceData) isWarning(now time.Time) (bool, string) {
+	if d.config.Mode != models.ModeMonitor && now.Before(d.config.ModeEndTime) { // if the group is allowed or blocked manually...
+		return false, ""
+	}
+	if !d.inCountingHours(now) || d.onBreak(now) {
+		return false, ""
+	}
+	used, threshold := time.Duration(d.countUsed())*d.config.Granularity, d.threshold()
+	if d.config.GracePeriod > 0 && used >= threshold {
+		return true, fmt.Sprintf("in its grace period, blocked in %v", max(threshold+d.config.GracePeriod-used, 0))
+	}
+	if d.config.WarnAt > 0 && threshold > 0 && used*100 >= threshold*time.Duration(d.config.WarnAt) {
+		return true, fmt.Sprintf("used %v of %v, blocked in %v", used, threshold, max(threshold+d.config.GracePeriod-used, 0))
+	}
+	return false, ""
+}
+
+// inCountingHours returns true if usage counts toward the threshold at the given time of day by the clock.
+// It should be called under d.mu.
+func (d *deviceData) inCountingHours(now time.Time) bool {
+	from, until := d.config.CountFrom, d.config.CountUntil
+	if from == until { // if there are no counting hours...
+		return true
+	}
+	sinceMidnight := clockTime(now, d.location)
+	if from < until {
+		return sinceMidnight >= from && sinceMidnight < until
+	}
+	return sinceMidnight >= from || sinceMidnight < until // the counting hours span midnight
+}
+
+// countUsed returns the number of samples seen in the window.
+// It should be called under d.mu.
+func (d *deviceData) countUsed() int {
 	count := 0
-	for _, seen := range dd.samples {
+	for _, seen := range d.samples {
 		if seen {
 			count++
 		}
 	}
+	return count
+}
+
+// baseThreshold returns the threshold for the day of the week on which the current window started, so that e.g. a
+// daily window starting at 6am on Saturday keeps the weekend threshold until 6am on Sunday. Day thresholds don't
+// apply to monthly windows.
+// It should be called under d.mu.
+func (d *deviceData) baseThreshold() time.Duration {
+	if isMonthly(d.config) {
+		return d.config.Threshold
+	}
+	return d.config.ThresholdOn(d.windowStartTime.In(d.location).Weekday())
+}
 
-	t.logger.Debugf("Usage tracker has seen %v %vx", id, count)
+// threshold returns the threshold including any time transferred in or out during the current window and any time
+// carried over from the previous window.
+// It should be called under d.mu.
+func (d *deviceData) threshold() time.Duration {
+	return d.baseThreshold() + time.Duration(d.adjustment+d.carried)*d.config.Granularity
+}
 
-	return time.Duration(count)*dd.config.Granularity >= dd.config.Threshold
+// resizeSamples keeps size samples, and as many of each category, keeping those in use so that the usage isn't reset,
+// e.g. where samples saved before the extra samples for the clocks changing were kept.
+// It should be called under d.mu.
+func (d *deviceData) resizeSamples(size int) {
+	resize := func(samples []bool) []bool {
+		resized := make([]bool, size)
+		copy(resized, samples)
+		return resized
+	}
+	d.samples = resize(d.samples)
+	for name, samples := range d.categories {
+		d.categories[name] = resize(samples)
+	}
+	d.config.SampleSize = size
 }
 
 // getIndex calculates the index in the slice for the current time.
@@ -277,49 +798,68 @@ func (d *deviceData) getIndex(now time.Time, bufferStart time.Time) int {
 func (d *deviceData) syncWindow(logger *zap.SugaredLogger, now time.Time) {
 	// Calculate number of time slices that have elapsed since the start of the window.
 	elapsed := int(now.Sub(d.windowStartTime) / d.config.Granularity)
-	if elapsed >= d.config.SampleSize || elapsed < 0 {
+	if elapsed >= d.config.SampleSize || elapsed < 0 || !now.Before(d.windowEnd()) { // if the window has ended or the clock went back...
 		// If elapsed time exceeds the buffer size, reset the entire window.
+		lastWindowStart, _ := d.calculateWindow(now)
+		carried := 0
+		if elapsed >= 0 {
+			carried = d.carryOver(lastWindowStart)
+		}
 		for i := range d.samples {
 			d.samples[i] = false
 		}
-		lastWindowStart, _ := d.calculateWindow(now)
+		clear(d.categories)
+		d.adjustment = 0 // transfers only apply to the window in which they were made.
+		d.carried = carried
 		d.windowStartTime = lastWindowStart // Reset the start as we roll into a new window.
-		logger.Infof("Renew retention window (%v) for device %s", now, d.config.Retention)
+		logger.Infof("Renew retention window (%v) for device %s, carrying over %v samples", now, d.config.Retention, carried)
 	}
 }
 
-// CalculateWindow determines the start times for the last and next windows.
-// Return the start time of the last window and the start time of the next window respectively.
-// it uses t.retention to determine the duration of the window
-// it uses StartDay and StartTime to determine the start time of the window as follows
-// it uses StartDay to determine the day of the week the window starts on if t.retention is 7 days
-// it uses StartTime to determine the time of day the window starts on if t.retention is 7 days
-// it uses StartTime to determine the time of day the window starts on if t.retention is 24 hours
-// if t.retention is 7 days, the window starts on StartDay at StartTime
-// if t.retention is 24 hours, the window starts StartTime after midnight and StartDay is ignored
-// if t.retention is less than 24 hours, the window starts StartTime after the current time and StartDay is ignored
-// TODO: make CalculateWindow work for monthly
+// windowEnd returns when the current window ends, i.e. when the next one starts. Windows are kept long enough for the
+// longest month, or for the clocks going back, so they end before their samples are used up.
+// It should be called under d.mu.
+func (d *deviceData) windowEnd() time.Time {
+	_, next := d.calculateWindow(d.windowStartTime)
+	return next
+}
+
+// calculateWindow returns the start of the window that now is in and the start of the next one. Windows of a day
+// or more follow the clock in d.location, so that they start at the same time of day whether or not the clocks have
+// changed, and are an hour shorter or longer if the clocks change during them:
+//   - monthly windows start on StartDayOfMonth at StartDuration past midnight, every month
+//   - retentions of 7 days start on StartDayInt at StartDuration past midnight, every week
+//   - retentions of 24 hours or more start at StartDuration past midnight, every whole number of days in Retention
+//
+// A start time that the clock shows twice when the clocks go back is the later of the two.
+// Shorter retentions start every Retention from StartDuration, in elapsed time, so the clocks changing doesn't
+// affect them.
+// Both times are in now's location.
 func (d *deviceData) calculateWindow(now time.Time) (time.Time, time.Time) {
+	local := now.In(d.location)
+	y, m, day := local.Date()
+	start := d.config.StartDuration.Truncate(d.config.Granularity)
 	var lastWindowStart, nextWindowStart time.Time
 
-	if d.config.Retention >= 7*24*time.Hour {
-		// Weekly retention logic
-		startOfWeek := now.Truncate(7*24*time.Hour).AddDate(0, 0, d.config.StartDayInt-int(now.Weekday()))
-		lastWindowStart = startOfWeek.Add(d.config.StartDuration).Truncate(d.config.Granularity)
-		if now.Before(lastWindowStart) {
-			lastWindowStart = lastWindowStart.Add(-7 * 24 * time.Hour).Truncate(d.config.Granularity)
+	switch {
+	case isMonthly(d.config):
+		dom := d.config.StartDayOfMonth
+		if now.Before(wallClock(d.location, y, m, dom, start)) {
+			m--
 		}
-		nextWindowStart = lastWindowStart.Add(7 * 24 * time.Hour).Truncate(d.config.Granularity)
-	} else if d.config.Retention >= 24*time.Hour {
-		// Daily retention logic
-		startOfDay := now.Truncate(24 * time.Hour)
-		lastWindowStart = startOfDay.Add(d.config.StartDuration)
-		if now.Before(lastWindowStart) {
-			lastWindowStart = lastWindowStart.Add(-24 * time.Hour).Truncate(d.config.Granularity)
+		lastWindowStart, nextWindowStart = wallClock(d.location, y, m, dom, start), wallClock(d.location, y, m+1, dom, start)
+	case d.config.Retention >= 7*24*time.Hour:
+		day += d.config.StartDayInt - int(local.Weekday())
+		if now.Before(wallClock(d.location, y, m, day, start)) {
+			day -= 7
+		}
+		lastWindowStart, nextWindowStart = wallClock(d.location, y, m, day, start), wallClock(d.location, y, m, day+7, start)
+	case d.config.Retention >= 24*time.Hour:
+		if now.Before(wallClock(d.location, y, m, day, start)) {
+			day--
 		}
-		nextWindowStart = lastWindowStart.Add(24 * time.Hour).Truncate(d.config.Granularity)
-	} else {
-		// Sub-daily retention logic
+		lastWindowStart, nextWindowStart = wallClock(d.location, y, m, day, start), wallClock(d.location, y, m, day+int(d.config.Retention/(24*time.Hour)), start)
+	default:
 		baseWindowStart := now.Truncate(d.config.Retention)
 		lastWindowStart = baseWindowStart.Add(d.config.StartDuration).Truncate(d.config.Granularity)
 		if now.Before(lastWindowStart) {
@@ -329,8 +869,7 @@ func (d *deviceData) calculateWindow(now time.Time) (time.Time, time.Time) {
 		nextWindowStart = lastWindowStart.Add(d.config.Retention).Truncate(d.config.Granularity)
 	}
 
-	return lastWindowStart, nextWindowStart
-
+	return lastWindowStart.In(now.Location()), nextWindowStart.In(now.Location())
 }
 
 // GetSummary returns a map of device IDs to the number of samples seen.
@@ -339,40 +878,107 @@ func (t *Tracker) GetSummary() map[string]*models.TrackerSummary {
 	samples := make(map[string]*models.TrackerSummary)
 
 	t.devices.Range(func(k, v interface{}) bool {
-		dd := v.(*deviceData)
-		dd.mu.Lock()
-		defer dd.mu.Unlock()
-		count := 0
-		total := 0
-		for _, seen := range dd.samples {
-			if seen {
-				count++
-			}
-			total++
-		}
+		samples[k.(string)] = t.summarise(k.(string), v.(*deviceData))
+		return true
+	})
 
-		t.logger.Debugf("Usage tracker summary for %v: %v samples seen (threshold %v)", k, count, dd.config.Threshold.Minutes())
+	return samples
+}
 
-		usagePercent := int(float64(count) / dd.config.Threshold.Minutes() * 100) // TODO: test that summary data uses the local device data config not global config.AppCfg.
-		if usagePercent > 100 {
-			usagePercent = 100
-		}
+// GetDeviceSummary returns a map of device IDs (groups) to the usage of each MAC seen in the group.
+// It is empty unless device tracking is enabled.
+func (t *Tracker) GetDeviceSummary() map[string]map[models.MAC]*models.TrackerSummary {
+	retval := make(map[string]map[models.MAC]*models.TrackerSummary)
 
-		samples[k.(string)] = &models.TrackerSummary{
-			Used:       count,
-			Total:      total,
-			Percentage: usagePercent,
+	t.macDevices.Range(func(k, v interface{}) bool {
+		id, mac, ok := strings.Cut(k.(string), deviceKeySeparator)
+		if !ok {
+			return true
 		}
-
+		if retval[id] == nil {
+			retval[id] = make(map[models.MAC]*models.TrackerSummary)
+		}
+		retval[id][models.MAC(mac)] = t.summarise(k.(string), v.(*deviceData))
 		return true
 	})
 
-	return samples
+	return retval
+}
+
+// summarise counts the samples seen for the device data.
+func (t *Tracker) summarise(id string, dd *deviceData) *models.TrackerSummary {
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	count := dd.countUsed()
+	used := time.Duration(count) * dd.config.Granularity
+
+	t.logger.Debugf("Usage tracker summary for %v: %v samples seen (threshold %v)", id, count, dd.threshold().Minutes())
+
+	usagePercent := 100
+	if dd.threshold() > 0 {
+		usagePercent = int(used.Minutes() / dd.threshold().Minutes() * 100) // TODO: test that summary data uses the local device data config not global config.AppCfg.
+	}
+	if usagePercent > 100 {
+		usagePercent = 100
+	}
+
+	now := t.nowFunc()
+	summary := &models.TrackerSummary{
+		Used:           int(used / time.Minute),
+		Total:          int(dd.windowEnd().Sub(dd.windowStartTime) / dd.config.Granularity),
+		Percentage:     usagePercent,
+		Threshold:      int(dd.threshold() / time.Minute),
+		Adjustment:     int(time.Duration(dd.adjustment) * dd.config.Granularity / time.Minute),
+		Carried:        int(time.Duration(dd.carried) * dd.config.Granularity / time.Minute),
+		OutsideHours:   !dd.inCountingHours(now),
+		SessionMinutes: int(dd.sessionLength(now) / time.Minute),
+	}
+	if dd.onBreak(now) {
+		breakEnd := dd.session.breakUntil
+		summary.BreakEndTime = &breakEnd
+	}
+	if blocked, _ := dd.isBlocked(t.logger, now); !blocked {
+		summary.Warning, _ = dd.isWarning(now)
+	}
+	summary.Categories = dd.summariseCategories(t.logger, now)
+	return summary
+}
+
+// GetSamples returns the samples in the group's current window with the time each one starts.
+func (t *Tracker) GetSamples(id string) (models.TrackerSamples, error) {
+	data, ok := t.devices.Load(id)
+	if !ok {
+		return models.TrackerSamples{}, models.ErrGroupNotFound
+	}
+	dd := data.(*deviceData)
+
+	dd.mu.Lock()
+	defer dd.mu.Unlock()
+	dd.syncWindow(t.logger, t.nowFunc()) // so that a window that has ended isn't returned.
+
+	end := dd.windowEnd()
+	samples := dd.samples[:min(len(dd.samples), int(end.Sub(dd.windowStartTime)/dd.config.Granularity))] // monthly windows don't use every sample in shorter months.
+	retval := models.TrackerSamples{
+		WindowStart: dd.windowStartTime,
+		WindowEnd:   end,
+		Granularity: int(dd.config.Granularity / time.Second),
+		Samples:     make([]models.TrackerSample, len(samples)),
+	}
+	for i, active := range samples { // the window is reset rather than wrapped, so index i starts i samples into it.
+		retval.Samples[i] = models.TrackerSample{Time: dd.windowStartTime.Add(time.Duration(i) * dd.config.Granularity), Active: active}
+	}
+	return retval, nil
 }
 
-// Reset resets the tracker sample data for the given device.
+// Reset resets the tracker sample data for the given device, including any per-MAC data.
 func (t *Tracker) Reset(id string) {
 	t.devices.Delete(id)
+	t.macDevices.Range(func(k, _ interface{}) bool {
+		if strings.HasPrefix(k.(string), id+deviceKeySeparator) {
+			t.macDevices.Delete(k)
+		}
+		return true
+	})
 }
 
 // SetMode pauses the tracker for the given device for the specified duration.
@@ -383,22 +989,190 @@ func (t *Tracker) SetMode(id string, d time.Duration, mode models.UsageTrackerMo
 	}
 	dd := data.(*deviceData)
 
+	// Lock the config before the group, as AddSample does.
+	t.mu.Lock()
+	if _, ok = t.cfgGroups[models.Group(id)]; !ok {
+		t.mu.Unlock()
+		t.logger.Errorf("group %v not found while setting a allow/block mode", id)
+		return fmt.Errorf("group %v not found while setting a allow/block mode", id)
+	}
 	dd.mu.Lock()
-	defer dd.mu.Unlock()
 
 	// Save the mode requested.
 	dd.config.Mode = mode
 	dd.config.ModeEndTime = t.nowFunc().Add(d)
+	dd.verdict.Store(nil)
 
-	// Load the global usage tracker data for the group, and save the new tracker mode to the config file.
-	grp, ok := t.cfgGroups[models.Group(id)]
-	if !ok {
-		t.logger.Errorf("group %v not found while setting a allow/block mode", id)
-		return fmt.Errorf("group %v not found while setting a allow/block mode", id)
+	e := models.ModeTransition{Group: models.Group(id), Cause: models.TransitionManual, Mode: mode, Reason: modeReason(mode, d)}
+	switch mode {
+	case models.ModeAllow:
+		e.Until = dd.config.ModeEndTime.UTC()
+	case models.ModeBlock:
+		e.Until, e.Blocked = dd.config.ModeEndTime.UTC(), true
+	default:
+		e.Blocked, _ = dd.isBlocked(t.logger, t.nowFunc())
+	}
+	cfg := t.withModes(map[models.Group]models.TrackerMode{models.Group(id): {Mode: dd.config.Mode, ModeEndTime: dd.config.ModeEndTime}})
+	dd.syncedConfig = cfg[models.Group(id)] // the group has the mode already.
+	dd.mu.Unlock()
+	t.mu.Unlock()
+
+	// Save the new tracker mode to the config file, which locks the config again.
+	if err := t.SetConfig(cfg); err != nil {
+		return err
+	}
+	t.recordTransition(e)
+	return nil
+}
+
+// SetModeAll sets every group being tracked to the mode for the specified duration in one save, e.g. to block them
+// all for dinner time, and returns the modes they were in so that they can be restored.
+func (t *Tracker) SetModeAll(d time.Duration, mode models.UsageTrackerMode) (map[models.Group]models.TrackerMode, error) {
+	end := t.nowFunc().Add(d)
+	return t.setModes(models.TransitionManual, func(models.Group, models.TrackerMode) (modeChange, bool) {
+		return modeChange{mode: models.TrackerMode{Mode: mode, ModeEndTime: end}, reason: modeReason(mode, d)}, true
+	})
+}
+
+// UpdateKillSwitch implements the KillSwitchReceiver interface to put every group in Block mode while the kill switch
+// is on, so that the web page shows them blocked, and to put them back in their earlier modes when it's turned off.
+// Groups whose mode was changed while the switch was on are left as they are.
+func (t *Tracker) UpdateKillSwitch(on bool) {
+	t.muKillSwitch.Lock()
+	defer t.muKillSwitch.Unlock()
+
+	now := t.nowFunc()
+	if on {
+		d := config.Current().KillSwitchConfig.BlockDuration
+		end := now.Add(d)
+		prev, err := t.setModes(models.TransitionKillSwitch, func(models.Group, models.TrackerMode) (modeChange, bool) {
+			return modeChange{mode: models.TrackerMode{Mode: models.ModeBlock, ModeEndTime: end}, reason: fmt.Sprintf("kill switch turned on, blocked for %v", d)}, true
+		})
+		if err != nil {
+			t.logger.Errorf("Failed to save the groups' Block mode while the kill switch is on: %v", err)
+		}
+		t.killSwitchEnd, t.killSwitchModes = end, prev
+		return
+	}
+
+	_, err := t.setModes(models.TransitionKillSwitch, func(group models.Group, cur models.TrackerMode) (modeChange, bool) {
+		prev, ok := t.killSwitchModes[group]
+		if !ok || cur.Mode != models.ModeBlock || !cur.ModeEndTime.Equal(t.killSwitchEnd) { // if the mode was changed since...
+			return modeChange{}, false
+		}
+		if prev.Mode != models.ModeMonitor && !now.Before(prev.ModeEndTime) { // if the earlier mode would have ended...
+			prev = models.TrackerMode{Mode: models.ModeMonitor, ModeEndTime: now}
+		}
+		return modeChange{mode: prev, reason: "kill switch turned off"}, true
+	})
+	if err != nil {
+		t.logger.Errorf("Failed to save the groups' modes after the kill switch was turned off: %v", err)
+	}
+	t.killSwitchModes = nil
+}
+
+// modeChange is a mode to set on a group and the reason recorded in the mode history.
+type modeChange struct {
+	mode   models.TrackerMode
+	reason string
+}
+
+// setModes sets the modes returned by change on the groups being tracked, holding all their locks so that no sample
+// sees some groups changed and not others, and saves the config once. change returns false to leave a group as it
+// is. The modes of the groups changed are returned as they were before.
+func (t *Tracker) setModes(cause models.TransitionCause, change func(group models.Group, cur models.TrackerMode) (modeChange, bool)) (map[models.Group]models.TrackerMode, error) {
+	var ids []string
+	t.devices.Range(func(k, _ interface{}) bool {
+		ids = append(ids, k.(string))
+		return true
+	})
+	slices.Sort(ids) // lock in order.
+
+	// Lock the config before the groups, as AddSample does, and release them all before saving the config, which
+	// locks it again.
+	t.mu.Lock()
+	locked := make(map[models.Group]*deviceData)
+	now := t.nowFunc()
+	prev := make(map[models.Group]models.TrackerMode)
+	modes := make(map[models.Group]models.TrackerMode)
+	var transitions []models.ModeTransition
+	for _, id := range ids {
+		data, ok := t.devices.Load(id)
+		if !ok { // if the group was reset since...
+			continue
+		}
+		dd := data.(*deviceData)
+		group := models.Group(id)
+		dd.mu.Lock()
+		locked[group] = dd
+
+		cur := models.TrackerMode{Mode: dd.config.Mode, ModeEndTime: dd.config.ModeEndTime}
+		c, ok := change(group, cur)
+		if !ok {
+			continue
+		}
+		if _, ok = t.cfgGroups[group]; !ok {
+			t.logger.Errorf("group %v not found while setting a allow/block mode", id)
+			continue
+		}
+		prev[group] = cur
+		dd.config.Mode, dd.config.ModeEndTime = c.mode.Mode, c.mode.ModeEndTime
+		dd.verdict.Store(nil)
+		modes[group] = c.mode
+
+		e := models.ModeTransition{Group: group, Cause: cause, Mode: c.mode.Mode, Reason: c.reason}
+		if c.mode.Mode != models.ModeMonitor {
+			e.Until = c.mode.ModeEndTime.UTC()
+		}
+		e.Blocked, _ = dd.isBlocked(t.logger, now)
+		transitions = append(transitions, e)
+	}
+	cfg := t.withModes(modes)
+	for group, dd := range locked {
+		if _, ok := modes[group]; ok {
+			dd.syncedConfig = cfg[group] // the group has the mode already.
+		}
+		dd.mu.Unlock()
+	}
+	t.mu.Unlock()
+
+	if len(transitions) == 0 {
+		return prev, nil
+	}
+	if err := t.SetConfig(cfg); err != nil {
+		return prev, err
+	}
+	for _, e := range transitions {
+		t.recordTransition(e)
+	}
+	return prev, nil
+}
+
+// withModes returns a copy of the group configs with the modes set, to save with SetConfig. The configs in use aren't
+// changed since samples read them under t.mu, which SetConfig takes again.
+// It should be called under t.mu.
+func (t *Tracker) withModes(modes map[models.Group]models.TrackerMode) models.MapGroupTrackerConfig {
+	m := maps.Clone(t.cfgGroups)
+	for group, mode := range modes {
+		if cfg, ok := m[group]; ok {
+			c := *cfg
+			c.Mode, c.ModeEndTime = mode.Mode, mode.ModeEndTime
+			m[group] = &c
+		}
+	}
+	return m
+}
+
+// modeReason returns the reason recorded in the mode history for a mode set for the duration.
+func modeReason(mode models.UsageTrackerMode, d time.Duration) string {
+	switch mode {
+	case models.ModeAllow:
+		return fmt.Sprintf("allowed for %v", d)
+	case models.ModeBlock:
+		return fmt.Sprintf("blocked for %v", d)
+	default:
+		return "returned to monitoring"
 	}
-	grp.Mode = dd.config.Mode
-	grp.ModeEndTime = dd.config.ModeEndTime
-	return t.SetConfig(t.cfgGroups)
 }
 
 // GetModeEndTime returns the end time of the pause for the given device.
@@ -417,22 +1191,84 @@ func (t *Tracker) GetModeEndTime(id string) (models.TrackerMode, error) {
 
 // validateGroupTrackerConfig contains the validation and sanitization logic.
 func validateGroupTrackerConfig(cfg models.MapGroupTrackerConfig) error {
+	defaults := &config.Current().TrackerConfig
 	for k, v := range cfg {
 		if k == "" || v == nil { // if there is a bad key...
 			delete(cfg, k)
 		} else {
-			v.Granularity = config.AppCfg.TrackerConfig.Granularity // always keep the default granularity
+			v.Granularity = defaults.Granularity // always keep the default granularity
 			if v.Retention == 0 {
-				v.Retention = config.AppCfg.TrackerConfig.Retention
+				v.Retention = defaults.Retention
 			}
 			if v.Threshold < 0 {
 				v.Threshold = 0
 			}
+			var dayThresholds []models.DayThreshold
+			for _, dt := range v.DayThresholds { // for each day threshold, keep the valid days...
+				var days []time.Weekday
+				for _, day := range dt.Days {
+					if day >= time.Sunday && day <= time.Saturday {
+						days = append(days, day)
+					}
+				}
+				if len(days) > 0 {
+					dayThresholds = append(dayThresholds, models.DayThreshold{Days: days, Threshold: max(dt.Threshold, 0)})
+				}
+			}
+			v.DayThresholds = dayThresholds
 			if v.StartDayInt == 0 {
-				v.StartDayInt = config.AppCfg.TrackerConfig.StartDayInt
+				v.StartDayInt = defaults.StartDayInt
 			}
 			if v.StartDuration == 0 {
-				v.StartDuration = config.AppCfg.TrackerConfig.StartDuration
+				v.StartDuration = defaults.StartDuration
+			}
+			switch v.Window {
+			case models.WindowMonthly: // if the window is a month, sample it more coarsely so the samples stay small...
+				v.Granularity = max(v.Granularity, monthlyGranularity)
+				v.StartDayOfMonth = min(max(v.StartDayOfMonth, 1), 28)
+			case models.WindowRetention:
+				if v.Retention > 7*24*time.Hour { // if the window would need to be longer than a week...
+					return fmt.Errorf("group %v has a retention of %v: retentions longer than a week need a monthly window", k, v.Retention)
+				}
+			default:
+				return fmt.Errorf("group %v has an unknown window %q", k, v.Window)
+			}
+			if v.CountFrom < 0 || v.CountFrom >= 24*time.Hour || v.CountUntil < 0 || v.CountUntil >= 24*time.Hour { // if the counting hours aren't times of day...
+				v.CountFrom = 0
+				v.CountUntil = 0
+			}
+			switch v.Rollover {
+			case models.RolloverFull:
+			case models.RolloverCapped:
+				if v.RolloverCap <= 0 { // if there's nothing to carry...
+					v.Rollover = models.RolloverNone
+				}
+			default:
+				v.Rollover = models.RolloverNone
+			}
+			if p := v.PacketPolicy; p != nil {
+				p.DropPercentage = min(max(p.DropPercentage, 0), 1)
+				p.DelayPercentage = min(max(p.DelayPercentage, 0), 1)
+				p.Delay = max(p.Delay, 0)
+				p.Jitter = max(p.Jitter, 0)
+				p.RateLimitKbps = max(p.RateLimitKbps, 0)
+				p.UDPRateLimitKbps = max(p.UDPRateLimitKbps, 0)
+			}
+			v.Allowlist = cleanAllowlist(v.Allowlist)
+			v.Categories = cleanCategories(v.Categories)
+			if v.MinActive < 0 {
+				v.MinActive = 0
+			}
+			if v.MinActive > 0 && v.MinActiveWindow < v.MinActive { // if the active time could never fit in the window...
+				v.MinActiveWindow = max(v.MinActive, defaults.MinActiveWindow)
+			}
+			if v.MaxSession < 0 {
+				v.MaxSession = 0
+			}
+			v.WarnAt = min(max(v.WarnAt, 0), 100)
+			v.GracePeriod = max(v.GracePeriod, 0)
+			if v.MaxSession > 0 && v.BreakDuration <= 0 { // if a session limit needs a break length...
+				v.BreakDuration = defaults.BreakDuration
 			}
 			if v.ModeEndTime.Before(time.Now().UTC()) { // if the input mode has expired...
 				// Reset it to monitoring.
@@ -474,3 +1310,19 @@ func (t *Tracker) SetConfig(m models.MapGroupTrackerConfig) error {
 		m,
 	)
 }
+
+// ReloadConfig re-reads the group tracker config file and applies it in memory, e.g. after the file has
+// been edited by hand. Devices pick up the new config on their next sample (see AddSample).
+func (t *Tracker) ReloadConfig() error {
+	m, err := fnGetGroupTrackerConfig(t.mu, defaultGroupTrackerConfigFilePath, models.NewMapGroupTrackerConfig)
+	if err != nil {
+		return fmt.Errorf("failed to load group tracker config: %w", err)
+	}
+	if err = validateGroupTrackerConfig(m); err != nil {
+		return fmt.Errorf("invalid group tracker config: %w", err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cfgGroups = m
+	return nil
+}
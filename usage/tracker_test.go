@@ -21,6 +21,7 @@ var (
 	originalFnGetTrackerSamplesFile   = fnGetTrackerSamplesFile
 	originalFnGetGroupTrackerConfig   = fnGetGroupTrackerConfig
 	originalFnSaveSamplesPeriodically = fnSaveSamplesPeriodically
+	originalStore                     = config.DefaultStore
 )
 
 func restoreFunctions() {
@@ -29,7 +30,16 @@ func restoreFunctions() {
 	fnGetTrackerSamplesFile = originalFnGetTrackerSamplesFile
 	fnGetGroupTrackerConfig = originalFnGetGroupTrackerConfig
 	fnSaveSamplesPeriodically = originalFnSaveSamplesPeriodically
-	config.FnDefaultSafeWriteViaTemp = config.SafeWriteViaTemp
+	config.DefaultStore = originalStore
+}
+
+// useWriter keeps the files that the test writes in a temp store, which writes them with fn.
+func useWriter(t *testing.T, fn func(path, data string) error) {
+	s := config.NewStore(t.TempDir())
+	s.SetWriter(fn)
+	orig := config.DefaultStore
+	config.DefaultStore = s
+	t.Cleanup(func() { config.DefaultStore = orig })
 }
 
 type mockTrafficCounter struct {
@@ -90,11 +100,11 @@ func TestNewTracker(t *testing.T) {
 	}
 
 	// Tracker returns error if not supplied with correct args.
-	tracker, err := NewTracker(ctx, config.MustGetLogger(), nil)
+	tracker, err := NewTracker(ctx, config.MustGetLogger(), nil, nil)
 	assert.Error(t, err, "NewTracker did not return error when not supplied with correct cfg")
 	assert.Nil(t, tracker, "NewTracker did not return nil when not supplied with correct cfg")
 
-	tracker, err = NewTracker(ctx, nil, cfgTrackerDefaults)
+	tracker, err = NewTracker(ctx, nil, cfgTrackerDefaults, nil)
 	assert.Error(t, err, "NewTracker did not return error when not supplied with correct logger")
 	assert.Nil(t, tracker, "NewTracker did not return nil when not supplied with correct logger")
 
@@ -102,7 +112,7 @@ func TestNewTracker(t *testing.T) {
 	fnLoadSamples = originalFnLoadSamples
 
 	// Tracker with threshold 0 should default to 1 minute.
-	tracker, err = NewTracker(ctx, config.MustGetLogger(), cfgTrackerDefaults)
+	tracker, err = NewTracker(ctx, config.MustGetLogger(), cfgTrackerDefaults, nil)
 	assert.NoError(t, err, "NewTracker failed")
 	assert.NotNil(t, tracker, "NewTracker returned nil")
 	assert.NotNil(t, tracker.devices, "NewTracker did not initialize devices map")
@@ -141,16 +151,18 @@ func TestHasExceededThreshold(t *testing.T) {
 		SampleFileSaveInterval: 50 * time.Millisecond,
 	}
 
-	tracker, err := NewTracker(ctx, config.MustGetLogger(), cfg)
+	tracker, err := NewTracker(ctx, config.MustGetLogger(), cfg, nil)
 	assert.NoError(t, err, "NewTracker failed")
 
 	// Simulate a device data structure with pre-allocated samples.
 	startTime := time.Now().Truncate(cfg.Granularity)
 	deviceID := "test-device"
-	data := newDeviceData(time.Now(), cfg)
+	data := newDeviceData(time.Now(), cfg, time.Local)
 
 	// Add device data to the tracker.
 	tracker.devices.Store(deviceID, data)
+	// The cases change the samples and modes directly, so forget the verdict as AddSample and SetMode would.
+	forget := func() { data.verdict.Store(nil) }
 
 	// Case 1: No samples recorded.
 	if tracker.HasExceededThreshold(deviceID) {
@@ -161,6 +173,7 @@ func TestHasExceededThreshold(t *testing.T) {
 	for i := 0; i < 5; i++ {
 		data.samples[i] = true // Mark 5 minutes as seen.
 	}
+	forget()
 	if tracker.HasExceededThreshold(deviceID) {
 		t.Error("HasExceededThreshold returned true with samples below the threshold")
 	}
@@ -174,6 +187,7 @@ func TestHasExceededThreshold(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		data.samples[i] = true // Mark 10 minutes as seen.
 	}
+	forget()
 	if !tracker.HasExceededThreshold(deviceID) {
 		t.Error("HasExceededThreshold returned false with samples meeting the threshold")
 	}
@@ -187,6 +201,7 @@ func TestHasExceededThreshold(t *testing.T) {
 	// This simulates an old start time being used.
 	data.windowStartTime = startTime.Add(-2 * time.Hour)
 	// Step 4c: Test that stale samples are ignored and the buffer is reset.
+	forget()
 	if tracker.HasExceededThreshold(deviceID) {
 		t.Errorf("HasExceededThreshold incorrectly included stale samples or did not reset after backward time adjustment")
 	}
@@ -198,6 +213,7 @@ func TestHasExceededThreshold(t *testing.T) {
 	for i := 0; i < 10; i++ {
 		data.samples[i] = true
 	}
+	forget()
 	if !tracker.HasExceededThreshold(deviceID) {
 		t.Error("HasExceededThreshold returned false with valid samples meeting the threshold")
 	}
@@ -208,8 +224,10 @@ func TestHasExceededThreshold(t *testing.T) {
 	}
 	data.config.Mode = models.ModeAllow
 	data.config.ModeEndTime = time.Now().Add(-time.Minute) // set an expired allow time.
+	forget()
 	assert.True(t, tracker.HasExceededThreshold(deviceID), "HasExceededThreshold should return true for expired tracker and allow mode")
 	data.config.ModeEndTime = time.Now().Add(time.Minute) // set an allow time in the future.
+	forget()
 	assert.False(t, tracker.HasExceededThreshold(deviceID), "HasExceededThreshold should return false for expired tracker but valid allow mode")
 
 	// Case 5b: block mode
@@ -218,11 +236,82 @@ func TestHasExceededThreshold(t *testing.T) {
 	}
 	data.config.Mode = models.ModeBlock
 	data.config.ModeEndTime = time.Now().Add(-time.Minute) // set an expired block time.
+	forget()
 	assert.False(t, tracker.HasExceededThreshold(deviceID), "HasExceededThreshold should return false for open tracker and expired block mode")
 	data.config.ModeEndTime = time.Now().Add(time.Minute) // set an expired block time.
+	forget()
 	assert.True(t, tracker.HasExceededThreshold(deviceID), "HasExceededThreshold should return true for open tracker and valid block mode")
 }
 
+func TestHasExceededThreshold_CachedVerdict(t *testing.T) {
+	cfg := &models.TrackerConfig{Granularity: time.Minute, Retention: time.Hour, Threshold: 2 * time.Minute}
+	tracker, err := NewTracker(context.Background(), config.MustGetLogger(), cfg, nil)
+	assert.NoError(t, err)
+	now := time.Date(2026, 10, 14, 19, 0, 10, 0, time.UTC)
+	tracker.nowFunc = func() time.Time { return now }
+	tracker.cfgGroups["kids"] = getDefaultGroupTrackerConfig(cfg)
+
+	tracker.AddSample("kids", true)
+	assert.False(t, tracker.HasExceededThreshold("kids"))
+	data, _ := tracker.devices.Load("kids")
+	dd := data.(*deviceData)
+	assert.Equal(t, time.Date(2026, 10, 14, 19, 1, 0, 0, time.UTC), dd.verdict.Load().until, "expected the verdict to last until the end of the slot")
+
+	// Expect a change that bypasses the tracker not to be seen until the end of the slot.
+	dd.samples[1] = true
+	assert.False(t, tracker.HasExceededThreshold("kids"), "expected the cached verdict")
+	now = now.Add(time.Minute)
+	assert.True(t, tracker.HasExceededThreshold("kids"), "expected the verdict to be refreshed in the next slot")
+
+	// Expect modes to replace the verdict and to end it when they end.
+	assert.NoError(t, tracker.SetMode("kids", 30*time.Second, models.ModeAllow))
+	assert.False(t, tracker.HasExceededThreshold("kids"))
+	assert.Equal(t, now.Add(30*time.Second), dd.verdict.Load().until)
+	now = now.Add(30 * time.Second)
+	assert.True(t, tracker.HasExceededThreshold("kids"), "expected the allow mode to have ended")
+
+	// Expect bonus time to replace the verdict.
+	assert.NoError(t, tracker.SetMode("kids", time.Minute, models.ModeMonitor))
+	_, err = tracker.AddBonus("kids", 5)
+	assert.NoError(t, err)
+	assert.False(t, tracker.HasExceededThreshold("kids"))
+	cached := dd.verdict.Load()
+
+	// Expect a repeat sample in the same slot to keep the verdict, and the verdict to be read without locking the group.
+	tracker.AddSample("kids", true)
+	assert.Same(t, cached, dd.verdict.Load(), "expected a repeat sample to keep the verdict")
+	dd.mu.Lock()
+	done := make(chan bool)
+	go func() { done <- tracker.HasExceededThreshold("kids") }()
+	select {
+	case blocked := <-done:
+		assert.False(t, blocked)
+	case <-time.After(time.Second):
+		t.Fatal("expected the cached verdict to be read without locking the group")
+	}
+	dd.mu.Unlock()
+
+	// Expect a sample that counts to replace the verdict.
+	now = now.Add(time.Minute)
+	dd.verdict.Store(&verdict{blocked: false, from: now.Add(-time.Minute), until: now.Add(time.Hour)})
+	tracker.AddSample("kids", true)
+	assert.Nil(t, dd.verdict.Load())
+
+	// Expect the verdict not to be used if the clock goes back.
+	assert.False(t, tracker.HasExceededThreshold("kids"))
+	dd.adjustment = 0
+	now = now.Add(-time.Second)
+	assert.True(t, tracker.HasExceededThreshold("kids"))
+}
+
+func TestVerdictEnd_CountingHours(t *testing.T) {
+	cfg := &models.TrackerConfig{Granularity: time.Minute, Retention: 24 * time.Hour, Threshold: time.Hour, CountFrom: 19*time.Hour + 30*time.Second, CountUntil: 7 * time.Hour}
+	now := time.Date(2026, 10, 14, 19, 0, 10, 0, time.UTC)
+	dd := newDeviceData(now, cfg, time.Local)
+	dd.isBlocked(config.MustGetLogger(), now)
+	assert.Equal(t, time.Date(2026, 10, 14, 19, 0, 30, 0, time.UTC), dd.verdictEnd(now), "expected the verdict to end when counting starts")
+}
+
 func TestAddSample_GroupDefaults(t *testing.T) {
 	ctx := context.Background()
 	logger := config.MustGetLogger()
@@ -238,7 +327,7 @@ func TestAddSample_GroupDefaults(t *testing.T) {
 		Mode:        models.ModeMonitor,
 	}
 
-	tracker, err := NewTracker(ctx, logger, cfg)
+	tracker, err := NewTracker(ctx, logger, cfg, nil)
 	assert.NoError(t, err, "NewTracker failed")
 	// Setup groups so we can test the mode handling.
 	tracker.cfgGroups = models.MapGroupTrackerConfig{
@@ -312,7 +401,7 @@ func TestAddSample_ChangeSampleSize(t *testing.T) {
 		Mode:        models.ModeMonitor,
 	}
 
-	tracker, err := NewTracker(ctx, logger, cfg)
+	tracker, err := NewTracker(ctx, logger, cfg, nil)
 	assert.NoError(t, err, "NewTracker failed")
 
 	// Setup groups so we can test regeneration of samples.
@@ -402,7 +491,7 @@ func TestAddSample_SamplesAreSaved(t *testing.T) {
 		Threshold:   10 * time.Minute,
 	}
 
-	tracker, err := NewTracker(ctx, config.MustGetLogger(), cfg)
+	tracker, err := NewTracker(ctx, config.MustGetLogger(), cfg, nil)
 	assert.NoError(t, err, "NewTracker failed")
 
 	deviceID := "Test-Device"
@@ -492,7 +581,7 @@ func TestGetIndex(t *testing.T) {
 
 	startTime := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
 
-	data := newDeviceData(startTime, cfg)
+	data := newDeviceData(startTime, cfg, time.Local)
 
 	tests := []struct {
 		now      time.Time
@@ -522,7 +611,7 @@ func TestSyncWindow(t *testing.T) {
 		Retention:   1 * time.Hour,
 	}
 
-	data := newDeviceData(time.Now(), cfg) // No threshold needed for this test.
+	data := newDeviceData(time.Now(), cfg, time.Local) // No threshold needed for this test.
 
 	// Simulate a device data structure.
 	// startTime := time.Now().Truncate(granularity)
@@ -605,11 +694,35 @@ func TestCalculateWindow(t *testing.T) {
 			expectedLast: time.Date(2024, 12, 2, 13, 43, 0, 0, time.UTC),
 			expectedNext: time.Date(2024, 12, 2, 13, 53, 0, 0, time.UTC),
 		},
+		{
+			name: "Monthly Retention - Current Month",
+			config: &models.TrackerConfig{
+				Retention:       30 * 24 * time.Hour,
+				Window:          models.WindowMonthly,
+				StartDayOfMonth: 15,
+				StartDuration:   6 * time.Hour,
+			},
+			now:          time.Date(2025, 2, 20, 15, 0, 0, 0, time.Local),
+			expectedLast: time.Date(2025, 2, 15, 6, 0, 0, 0, time.Local),
+			expectedNext: time.Date(2025, 3, 15, 6, 0, 0, 0, time.Local),
+		},
+		{
+			name: "Monthly Retention - Previous Year",
+			config: &models.TrackerConfig{
+				Retention:       31 * 24 * time.Hour,
+				Window:          models.WindowMonthly,
+				StartDayOfMonth: 1,
+				StartDuration:   6 * time.Hour,
+			},
+			now:          time.Date(2025, 1, 1, 5, 0, 0, 0, time.Local), // before the start time on the 1st
+			expectedLast: time.Date(2024, 12, 1, 6, 0, 0, 0, time.Local),
+			expectedNext: time.Date(2025, 1, 1, 6, 0, 0, 0, time.Local),
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			data := newDeviceData(tt.now, tt.config)
+			data := newDeviceData(tt.now, tt.config, time.Local)
 			lastWindowStart, nextWindowStart := data.calculateWindow(tt.now)
 
 			if !lastWindowStart.Equal(tt.expectedLast) {
@@ -622,6 +735,39 @@ func TestCalculateWindow(t *testing.T) {
 	}
 }
 
+func TestMonthlyWindow(t *testing.T) {
+	logger := config.MustGetLogger()
+	cfg := &models.TrackerConfig{Granularity: time.Minute, Retention: 30 * 24 * time.Hour, Window: models.WindowMonthly, Threshold: 20 * time.Hour, StartDayOfMonth: 40,
+		DayThresholds: []models.DayThreshold{{Days: []time.Weekday{time.Saturday}, Threshold: time.Hour}}}
+	feb := time.Date(2025, 2, 10, 12, 0, 0, 0, time.Local)
+	dd := newDeviceData(feb, cfg, time.Local)
+
+	assert.Equal(t, monthlyGranularity, dd.config.Granularity, "expected monthly windows to be sampled more coarsely")
+	assert.Equal(t, 28, dd.config.StartDayOfMonth, "expected the start day to be one that every month has")
+	assert.Equal(t, int(monthlyRetention/monthlyGranularity), len(dd.samples))
+	assert.Equal(t, time.Date(2025, 1, 28, 0, 0, 0, 0, time.Local), dd.windowStartTime)
+	assert.Equal(t, 20*time.Hour, dd.baseThreshold(), "expected day thresholds not to apply to monthly windows")
+
+	// January's window has 31 days and the samples returned stop at its end.
+	dd.samples[dd.getIndex(feb, dd.windowStartTime)] = true
+	tr := &Tracker{logger: logger, devices: &sync.Map{}, nowFunc: func() time.Time { return feb }}
+	tr.devices.Store("kids", dd)
+	samples, err := tr.GetSamples("kids")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 2, 28, 0, 0, 0, 0, time.Local), samples.WindowEnd)
+	assert.Equal(t, int(samples.WindowEnd.Sub(samples.WindowStart)/monthlyGranularity), len(samples.Samples))
+	assert.Equal(t, 5, tr.summarise("kids", dd).Used, "expected a sample to count as its granularity")
+
+	// February's window ends after 28 days, before its samples are used up.
+	dd.syncWindow(logger, time.Date(2025, 2, 28, 0, 0, 0, 0, time.Local))
+	assert.Equal(t, time.Date(2025, 2, 28, 0, 0, 0, 0, time.Local), dd.windowStartTime)
+	assert.Equal(t, 0, dd.countUsed())
+	dd.syncWindow(logger, time.Date(2025, 3, 27, 23, 59, 0, 0, time.Local))
+	assert.Equal(t, time.Date(2025, 2, 28, 0, 0, 0, 0, time.Local), dd.windowStartTime)
+	dd.syncWindow(logger, time.Date(2025, 3, 28, 0, 0, 0, 0, time.Local))
+	assert.Equal(t, time.Date(2025, 3, 28, 0, 0, 0, 0, time.Local), dd.windowStartTime)
+}
+
 func saveSomeSamples(t *testing.T) (*sync.Map, *os.File, error) {
 	// Create a temporary file for testing.
 	tmpFile, err := os.CreateTemp("", "samples_test_*.json")
@@ -634,12 +780,15 @@ func saveSomeSamples(t *testing.T) (*sync.Map, *os.File, error) {
 		samples:         []bool{true, false, true, false},
 		windowStartTime: time.Now().UTC(),
 		mu:              &sync.Mutex{},
+		location:        time.Local,
 	})
 	devices.Store("device2", &deviceData{
 		config:          getDefaultGroupTrackerConfig(&config.AppCfg.TrackerConfig),
 		samples:         []bool{false, true, false, true},
 		windowStartTime: time.Now().Add(-time.Hour).UTC(),
+		carried:         30,
 		mu:              &sync.Mutex{},
+		location:        time.Local,
 	})
 
 	err = saveSamples(config.MustGetLogger(), tmpFile.Name(), devices)
@@ -647,6 +796,158 @@ func saveSomeSamples(t *testing.T) (*sync.Map, *os.File, error) {
 	return devices, tmpFile, err
 }
 
+// useLocation returns the time zone for the windows to follow in the test, skipping it if the zone isn't installed.
+func useLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %v isn't installed: %v", name, err)
+	}
+	return loc
+}
+
+func TestCalculateWindow_ClockChanges(t *testing.T) {
+	london := useLocation(t, "Europe/London") // the clocks go forward on 30 March 2025 and back on 26 October 2025.
+	tests := []struct {
+		name         string
+		config       *models.TrackerConfig
+		now          time.Time
+		expectedLast time.Time
+		expectedNext time.Time
+	}{
+		{
+			name:         "Weekly window over the clocks going forward",
+			config:       &models.TrackerConfig{Retention: 7 * 24 * time.Hour, StartDayInt: int(time.Friday), StartDuration: 18 * time.Hour},
+			now:          time.Date(2025, 4, 4, 17, 30, 0, 0, time.UTC), // 6:30pm BST, after the window's start by the clock
+			expectedLast: time.Date(2025, 4, 4, 18, 0, 0, 0, london),
+			expectedNext: time.Date(2025, 4, 11, 18, 0, 0, 0, london),
+		},
+		{
+			name:         "Weekly window that the clocks went forward in",
+			config:       &models.TrackerConfig{Retention: 7 * 24 * time.Hour, StartDayInt: int(time.Friday), StartDuration: 18 * time.Hour},
+			now:          time.Date(2025, 4, 4, 16, 30, 0, 0, time.UTC), // 5:30pm BST, 168h after the start but before it by the clock
+			expectedLast: time.Date(2025, 3, 28, 18, 0, 0, 0, london),
+			expectedNext: time.Date(2025, 4, 4, 18, 0, 0, 0, london),
+		},
+		{
+			name:         "Weekly window that the clocks went back in",
+			config:       &models.TrackerConfig{Retention: 7 * 24 * time.Hour, StartDayInt: int(time.Friday), StartDuration: 18 * time.Hour},
+			now:          time.Date(2025, 10, 31, 17, 30, 0, 0, time.UTC), // 5:30pm GMT, 168h after the start
+			expectedLast: time.Date(2025, 10, 24, 18, 0, 0, 0, london),
+			expectedNext: time.Date(2025, 10, 31, 18, 0, 0, 0, london),
+		},
+		{
+			name:         "Weekly window starting later in the week",
+			config:       &models.TrackerConfig{Retention: 7 * 24 * time.Hour, StartDayInt: int(time.Saturday), StartDuration: 6 * time.Hour},
+			now:          time.Date(2025, 10, 29, 12, 0, 0, 0, london), // Wednesday
+			expectedLast: time.Date(2025, 10, 25, 6, 0, 0, 0, london),
+			expectedNext: time.Date(2025, 11, 1, 6, 0, 0, 0, london),
+		},
+		{
+			name:         "Daily window on the day the clocks go back",
+			config:       &models.TrackerConfig{Retention: 24 * time.Hour, StartDuration: 6 * time.Hour},
+			now:          time.Date(2025, 10, 27, 5, 30, 0, 0, time.UTC), // 5:30am GMT, 24h after the start
+			expectedLast: time.Date(2025, 10, 26, 6, 0, 0, 0, london),
+			expectedNext: time.Date(2025, 10, 27, 6, 0, 0, 0, london),
+		},
+		{
+			name:         "Daily window starting at midnight by the local clock",
+			config:       &models.TrackerConfig{Retention: 24 * time.Hour},
+			now:          time.Date(2025, 7, 1, 23, 30, 0, 0, time.UTC), // 00:30 BST on 2 July
+			expectedLast: time.Date(2025, 7, 2, 0, 0, 0, 0, london),
+			expectedNext: time.Date(2025, 7, 3, 0, 0, 0, 0, london),
+		},
+		{
+			name:         "Monthly window over the clocks going forward",
+			config:       &models.TrackerConfig{Window: models.WindowMonthly, StartDayOfMonth: 15, StartDuration: 6 * time.Hour},
+			now:          time.Date(2025, 4, 15, 5, 30, 0, 0, time.UTC), // 6:30am BST
+			expectedLast: time.Date(2025, 4, 15, 6, 0, 0, 0, london),
+			expectedNext: time.Date(2025, 5, 15, 6, 0, 0, 0, london),
+		},
+		{
+			name:         "Sub-daily windows in elapsed time",
+			config:       &models.TrackerConfig{Retention: time.Hour},
+			now:          time.Date(2025, 10, 26, 1, 30, 0, 0, time.UTC), // 1:30am GMT, the second time the clock shows it
+			expectedLast: time.Date(2025, 10, 26, 1, 0, 0, 0, time.UTC),
+			expectedNext: time.Date(2025, 10, 26, 2, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := newDeviceData(tt.now, tt.config, london)
+			last, next := data.calculateWindow(tt.now)
+			assert.True(t, last.Equal(tt.expectedLast), "lastWindowStart: got %v, want %v", last, tt.expectedLast)
+			assert.True(t, next.Equal(tt.expectedNext), "nextWindowStart: got %v, want %v", next, tt.expectedNext)
+		})
+	}
+}
+
+func TestSyncWindow_ClockChanges(t *testing.T) {
+	london := useLocation(t, "Europe/London")
+	logger := config.MustGetLogger()
+	cfg := &models.TrackerConfig{Granularity: time.Minute, Retention: 24 * time.Hour, Threshold: 2 * time.Hour}
+	start := time.Date(2025, 10, 25, 23, 0, 0, 0, time.UTC) // midnight BST on the day the clocks go back
+	dd := newDeviceData(start, cfg, london)
+	assert.Equal(t, 25*60, len(dd.samples), "expected samples for the hour gained")
+
+	// Expect the usage in the first and the 25th hour of the day to be counted apart, and kept until the day ends.
+	first, last := start.Add(30*time.Minute), start.Add(24*time.Hour+30*time.Minute)
+	for _, now := range []time.Time{first, last} {
+		dd.syncWindow(logger, now)
+		dd.samples[dd.getIndex(now, dd.windowStartTime)] = true
+	}
+	assert.Equal(t, 2, dd.countUsed())
+	assert.True(t, dd.windowEnd().Equal(start.Add(25*time.Hour)), "expected the window to end at midnight GMT, got %v", dd.windowEnd())
+
+	next := start.Add(25 * time.Hour)
+	dd.syncWindow(logger, next)
+	assert.Zero(t, dd.countUsed(), "expected the samples to be reset at midnight")
+	assert.True(t, dd.windowStartTime.Equal(next))
+	assert.True(t, dd.windowEnd().Equal(next.Add(24*time.Hour)))
+}
+
+func TestAddSample_KeepsSamplesOfTheOldSize(t *testing.T) {
+	logger := config.MustGetLogger()
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+	cfg := &models.TrackerConfig{Granularity: time.Minute, Retention: 24 * time.Hour, Threshold: time.Hour}
+	devices := &sync.Map{}
+	dd := newDeviceData(now, cfg, time.Local)
+	dd.config.SampleSize = 24 * 60 // as saved before the samples for the clocks changing were kept
+	dd.samples = make([]bool, dd.config.SampleSize)
+	dd.samples[dd.getIndex(now, dd.windowStartTime)] = true
+	devices.Store("kids", dd)
+
+	cfg.SampleSize = getSampleSize(cfg)
+	addSampleToDevice(logger, devices, "kids", cfg, time.Local, now.Add(time.Minute), true)
+	v, _ := devices.Load("kids")
+	dd = v.(*deviceData)
+	assert.Equal(t, 25*60, len(dd.samples))
+	assert.Equal(t, 2, dd.countUsed(), "expected the samples to be kept")
+}
+
+func TestInCountingHours_ClockChanges(t *testing.T) {
+	london := useLocation(t, "Europe/London")
+	dd := newDeviceData(time.Date(2025, 3, 30, 0, 0, 0, 0, london), &models.TrackerConfig{Retention: 24 * time.Hour, Threshold: time.Hour, CountFrom: 7 * time.Hour, CountUntil: 21 * time.Hour}, london)
+	assert.False(t, dd.inCountingHours(time.Date(2025, 3, 30, 5, 30, 0, 0, time.UTC)), "expected 6:30am BST not to count")
+	assert.True(t, dd.inCountingHours(time.Date(2025, 3, 30, 6, 0, 0, 0, time.UTC)), "expected 7am BST to count")
+	assert.True(t, dd.verdictEnd(time.Date(2025, 3, 30, 5, 59, 30, 0, time.UTC)).Equal(time.Date(2025, 3, 30, 6, 0, 0, 0, time.UTC)),
+		"expected the verdict to end when counting starts by the clock")
+}
+
+func TestLoadLocation(t *testing.T) {
+	loc, err := loadLocation("")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Local, loc)
+	if loc, err = loadLocation("Europe/London"); err == nil {
+		assert.Equal(t, "Europe/London", loc.String())
+	}
+	_, err = loadLocation("Nowhere/Special")
+	assert.Error(t, err)
+
+	_, err = NewTracker(context.Background(), config.MustGetLogger(), &models.TrackerConfig{Retention: 24 * time.Hour, Timezone: "Nowhere/Special"}, nil)
+	assert.ErrorContains(t, err, "Nowhere/Special")
+}
+
 // TestSaveAndLoadSamples tests saving and loading samples to/from a file.
 func TestSaveAndLoadSamples(t *testing.T) {
 	// Test SaveSamples
@@ -654,7 +955,7 @@ func TestSaveAndLoadSamples(t *testing.T) {
 	assert.NoError(t, err, "Failed to save samples")
 
 	// Test LoadSamples
-	loadedDevices, err := loadSamples(tmpFile.Name())
+	loadedDevices, err := loadSamples(tmpFile.Name(), time.Local)
 	assert.NoError(t, err, "Failed to load samples")
 
 	// Verify loaded data.
@@ -672,11 +973,12 @@ func TestSaveAndLoadSamples(t *testing.T) {
 	// Validate device2
 	assert.Equal(t, []bool{false, true, false, true}, ld2.samples, "Device2 samples mismatch")
 	assert.WithinDuration(t, ld2.windowStartTime, time.Now().Add(-time.Hour), time.Minute, "Device2 start time mismatch")
+	assert.Equal(t, 30, ld2.carried, "Device2 carried over samples mismatch")
 }
 
 // TestLoadNonExistentFile tests loading from a non-existent file.
 func TestLoadNonExistentFile(t *testing.T) {
-	_, err := loadSamples("nonexistent_file.json")
+	_, err := loadSamples("nonexistent_file.json", time.Local)
 	assert.Error(t, err, "Expected error for non-existent file")
 }
 
@@ -695,7 +997,7 @@ func TestCorruptFile(t *testing.T) {
 	_ = tmpFile.Close()
 
 	// Try loading the corrupt file.
-	_, err = loadSamples(tmpFile.Name())
+	_, err = loadSamples(tmpFile.Name(), time.Local)
 	assert.Error(t, err, "Expected error for corrupt file")
 }
 
@@ -710,7 +1012,7 @@ func TestResetSamples(t *testing.T) {
 
 	testDevice := "test-device"
 
-	tracker, err := NewTracker(context.Background(), config.MustGetLogger(), cfg)
+	tracker, err := NewTracker(context.Background(), config.MustGetLogger(), cfg, nil)
 	assert.NoError(t, err, "NewTracker failed")
 
 	tracker.AddSample(testDevice, true)
@@ -722,6 +1024,48 @@ func TestResetSamples(t *testing.T) {
 	assert.False(t, ok, "Device should not be found in tracker")
 }
 
+func TestTracker_GetSamples(t *testing.T) {
+	t.Cleanup(func() {
+		restoreFunctions()
+	})
+	fnGetGroupTrackerConfig = func(mu *sync.Mutex, configPath string, _ func() models.MapGroupTrackerConfig) (models.MapGroupTrackerConfig, error) {
+		return models.MapGroupTrackerConfig{}, nil
+	}
+	cfg := &models.TrackerConfig{
+		Retention:   10 * time.Minute,
+		Granularity: 1 * time.Minute,
+		Threshold:   10 * time.Minute,
+	}
+	tracker, err := NewTracker(context.Background(), config.MustGetLogger(), cfg, nil)
+	assert.NoError(t, err, "NewTracker failed")
+
+	_, err = tracker.GetSamples("kids")
+	assert.ErrorIs(t, err, models.ErrGroupNotFound)
+
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	now := start.Add(2*time.Minute + 30*time.Second)
+	tracker.nowFunc = func() time.Time { return now }
+	tracker.AddSample("kids", true)
+
+	samples, err := tracker.GetSamples("kids")
+	assert.NoError(t, err)
+	assert.Equal(t, start, samples.WindowStart)
+	assert.Equal(t, start.Add(10*time.Minute), samples.WindowEnd)
+	assert.Equal(t, 60, samples.Granularity)
+	if assert.Len(t, samples.Samples, 10) {
+		assert.Equal(t, models.TrackerSample{Time: start.Add(2 * time.Minute), Active: true}, samples.Samples[2])
+		assert.False(t, samples.Samples[3].Active)
+		assert.Equal(t, start.Add(9*time.Minute), samples.Samples[9].Time)
+	}
+
+	// The window ends without any more samples.
+	now = start.Add(12 * time.Minute)
+	samples, err = tracker.GetSamples("kids")
+	assert.NoError(t, err)
+	assert.Equal(t, start.Add(10*time.Minute), samples.WindowStart, "expected a window that has ended not to be returned")
+	assert.False(t, samples.Samples[2].Active)
+}
+
 func TestNewTracker_GetGroupConfig(t *testing.T) {
 	ctx := context.Background()
 	logger := config.MustGetLogger()
@@ -736,13 +1080,13 @@ func TestNewTracker_GetGroupConfig(t *testing.T) {
 	}
 
 	d := &sync.Map{}
-	fnLoadSamples = func(path string) (*sync.Map, error) {
+	fnLoadSamples = func(path string, loc *time.Location) (*sync.Map, error) {
 		return d, nil
 	}
 
 	saveSamplesPeriodicallyWasCalled := false
 	done := make(chan struct{})
-	fnSaveSamplesPeriodically = func(ctx context.Context, logger *zap.SugaredLogger, devicesToSave *sync.Map, filePath string, interval time.Duration) {
+	fnSaveSamplesPeriodically = func(ctx context.Context, logger *zap.SugaredLogger, devicesToSave *sync.Map, filePath string, interval time.Duration, _ *saveStatus) {
 		saveSamplesPeriodicallyWasCalled = true
 		done <- struct{}{}
 	}
@@ -751,7 +1095,7 @@ func TestNewTracker_GetGroupConfig(t *testing.T) {
 	fnGetGroupTrackerConfig = func(mu *sync.Mutex, configPath string, _ func() models.MapGroupTrackerConfig) (models.MapGroupTrackerConfig, error) {
 		return nil, errors.New("mocked error for getGroupTrackerConfig")
 	}
-	tracker, err := NewTracker(ctx, logger, cfg)
+	tracker, err := NewTracker(ctx, logger, cfg, nil)
 	assert.Error(t, err, "NewTracker should fail")
 	assert.Nil(t, tracker, "Tracker should be nil")
 
@@ -759,14 +1103,14 @@ func TestNewTracker_GetGroupConfig(t *testing.T) {
 	fnGetGroupTrackerConfig = func(mu *sync.Mutex, configPath string, _ func() models.MapGroupTrackerConfig) (models.MapGroupTrackerConfig, error) {
 		return models.MapGroupTrackerConfig{}, nil
 	}
-	tracker, err = NewTracker(ctx, logger, cfg)
+	tracker, err = NewTracker(ctx, logger, cfg, nil)
 	assert.NoError(t, err, "NewTracker failed")
 	assert.NotNil(t, tracker, "Tracker should not be nil")
 	assert.False(t, saveSamplesPeriodicallyWasCalled, "saveSamples should not be called when SampleFilePath is empty")
 
 	// Test that samples are loaded and periodically.
 	cfg.SampleFilePath = "dummy-file.json"
-	tracker, err = NewTracker(ctx, logger, cfg)
+	tracker, err = NewTracker(ctx, logger, cfg, nil)
 	assert.NoError(t, err, "NewTracker failed")
 	assert.NotNil(t, tracker, "Tracker should not be nil")
 	<-done
@@ -796,7 +1140,7 @@ func TestNewTracker_SampleFilePathInvalid(t *testing.T) {
 		return "", errors.New("mocked error for missing sample file path")
 	}
 
-	tracker, err := NewTracker(ctx, logger, cfg)
+	tracker, err := NewTracker(ctx, logger, cfg, nil)
 	assert.Error(t, err, "expected error for missing sample file path")
 	assert.Nil(t, tracker, "expected tracker to be nil, but got a valid instance")
 }
@@ -824,11 +1168,11 @@ func TestNewTracker_LoadSamples(t *testing.T) {
 	}
 
 	// Mock loadSamples to return an error.
-	fnLoadSamples = func(path string) (*sync.Map, error) {
+	fnLoadSamples = func(path string, loc *time.Location) (*sync.Map, error) {
 		return nil, errors.New("mocked error for loadSamples")
 	}
 
-	tracker, err := NewTracker(ctx, logger, cfg)
+	tracker, err := NewTracker(ctx, logger, cfg, nil)
 	assert.NoError(t, err, "expected no error from unable to load samples, but got: %v", err)
 	assert.NotNil(t, tracker, "expected tracker to be non-nil, but got nil")
 }
@@ -854,7 +1198,7 @@ func TestNewTracker_HandlesEmptySampleFilePath(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	tracker, err := NewTracker(ctx, logger, cfg)
+	tracker, err := NewTracker(ctx, logger, cfg, nil)
 	assert.NoError(t, err, "expected no error, but got: %v", err)
 	assert.NotNil(t, tracker, "expected tracker to be non-nil, but got nil")
 }
@@ -892,19 +1236,18 @@ func TestTracker_SetMode(t *testing.T) {
 	}
 
 	configWasSaved := false
-	config.FnDefaultSafeWriteViaTemp = func(filePath string, data string) error {
+	useWriter(t, func(filePath string, data string) error {
 		configWasSaved = true
 		return nil
-	}
+	})
 
 	t.Cleanup(func() {
-		config.FnDefaultSafeWriteViaTemp = config.SafeWriteViaTemp // restore the file writer func
 		fnGetGroupTrackerConfig = config.GetConfig
 	})
 
 	// Test Cases.
 
-	tracker, err := NewTracker(ctx, config.MustGetLogger(), cfg)
+	tracker, err := NewTracker(ctx, config.MustGetLogger(), cfg, nil)
 	assert.NoError(t, err, "NewTracker failed")
 
 	now := time.Now()
@@ -933,6 +1276,128 @@ func TestTracker_SetMode(t *testing.T) {
 	assert.True(t, configWasSaved, "expected central group config to be saved")
 }
 
+// transitionRecorder is a ModeTransitionReceiver that keeps the transitions sent to it.
+type transitionRecorder struct {
+	transitions []models.ModeTransition
+}
+
+func (r *transitionRecorder) UpdateModeTransition(e models.ModeTransition) {
+	r.transitions = append(r.transitions, e)
+}
+
+// newModesTestTracker returns a tracker whose groups GroupA and GroupB are being tracked in Monitor and Allow mode,
+// and the number of times the config was saved.
+func newModesTestTracker(t *testing.T, now time.Time) (*Tracker, *int) {
+	fnGetGroupTrackerConfig = func(mu *sync.Mutex, configPath string, _ func() models.MapGroupTrackerConfig) (models.MapGroupTrackerConfig, error) {
+		return models.MapGroupTrackerConfig{
+			"GroupA": {Retention: time.Hour, Threshold: 5 * time.Minute, Mode: models.ModeMonitor},
+			"GroupB": {Retention: time.Hour, Threshold: 5 * time.Minute, Mode: models.ModeAllow, ModeEndTime: now.Add(time.Hour)},
+		}, nil
+	}
+	saves := 0
+	useWriter(t, func(filePath string, data string) error {
+		saves++
+		return nil
+	})
+	t.Cleanup(func() {
+		fnGetGroupTrackerConfig = config.GetConfig
+	})
+
+	tracker, err := NewTracker(context.Background(), config.MustGetLogger(), &models.TrackerConfig{Granularity: time.Minute, Retention: time.Hour}, nil)
+	assert.NoError(t, err, "NewTracker failed")
+	tracker.nowFunc = func() time.Time { return now }
+	tracker.AddSample("GroupA", false)
+	tracker.AddSample("GroupB", false)
+	saves = 0
+	return tracker, &saves
+}
+
+func trackerMode(t *testing.T, tracker *Tracker, group string) models.TrackerMode {
+	m, err := tracker.GetModeEndTime(group)
+	assert.NoError(t, err)
+	return m
+}
+
+func TestTracker_SetModeAll(t *testing.T) {
+	now := time.Now()
+	tracker, saves := newModesTestTracker(t, now)
+	r := &transitionRecorder{}
+	tracker.RegisterModeTransitionReceivers(r)
+
+	prev, err := tracker.SetModeAll(30*time.Minute, models.ModeBlock)
+	assert.NoError(t, err)
+	assert.Equal(t, map[models.Group]models.TrackerMode{
+		"GroupA": {Mode: models.ModeMonitor},
+		"GroupB": {Mode: models.ModeAllow, ModeEndTime: now.Add(time.Hour)},
+	}, prev, "expected the earlier modes to be returned")
+	for _, g := range []string{"GroupA", "GroupB"} {
+		assert.Equal(t, models.TrackerMode{Mode: models.ModeBlock, ModeEndTime: now.Add(30 * time.Minute)}, trackerMode(t, tracker, g), "expected group %v to be blocked", g)
+		assert.Equal(t, models.ModeBlock, tracker.cfgGroups[models.Group(g)].Mode, "expected the mode of group %v to be saved", g)
+	}
+	assert.Equal(t, 1, *saves, "expected the config to be saved once")
+	assert.Len(t, r.transitions, 2)
+	for _, e := range r.transitions {
+		assert.True(t, e.Blocked)
+		assert.Equal(t, models.TransitionManual, e.Cause)
+		assert.Equal(t, "blocked for 30m0s", e.Reason)
+	}
+}
+
+func TestTracker_UpdateKillSwitch(t *testing.T) {
+	now := time.Now()
+	tracker, _ := newModesTestTracker(t, now)
+	r := &transitionRecorder{}
+	tracker.RegisterModeTransitionReceivers(r)
+
+	tracker.UpdateKillSwitch(true)
+	blocked := models.TrackerMode{Mode: models.ModeBlock, ModeEndTime: now.Add(config.AppCfg.KillSwitchConfig.BlockDuration)}
+	assert.Equal(t, blocked, trackerMode(t, tracker, "GroupA"), "expected the kill switch to block GroupA")
+	assert.Equal(t, blocked, trackerMode(t, tracker, "GroupB"), "expected the kill switch to block GroupB")
+	assert.Len(t, r.transitions, 2)
+	assert.Equal(t, models.TransitionKillSwitch, r.transitions[0].Cause)
+
+	// GroupA is allowed while the switch is on so it's left allowed, and GroupB's Allow mode has ended.
+	assert.NoError(t, tracker.SetMode("GroupA", time.Minute, models.ModeAllow))
+	now = now.Add(2 * time.Hour)
+	tracker.nowFunc = func() time.Time { return now }
+	tracker.UpdateKillSwitch(false)
+	assert.Equal(t, models.ModeAllow, trackerMode(t, tracker, "GroupA").Mode, "expected the mode set while the switch was on to be kept")
+	assert.Equal(t, models.TrackerMode{Mode: models.ModeMonitor, ModeEndTime: now}, trackerMode(t, tracker, "GroupB"), "expected GroupB to return to monitoring")
+	assert.Equal(t, "kill switch turned off", r.transitions[len(r.transitions)-1].Reason)
+}
+
+// TestTracker_SetModes_ConcurrentSamples ensures that modes can be set while samples are being added, which lock the
+// config and the groups in the same order.
+func TestTracker_SetModes_ConcurrentSamples(t *testing.T) {
+	tracker, _ := newModesTestTracker(t, time.Now())
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for _, g := range []string{"GroupA", "GroupB"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				tracker.AddSample(g, true)
+			}
+		}()
+	}
+	go func() {
+		defer close(done)
+		for i := 0; i < 1000; i++ {
+			_, _ = tracker.SetModeAll(time.Minute, models.ModeBlock)
+			_ = tracker.SetMode("GroupA", time.Minute, models.ModeAllow)
+		}
+		wg.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected setting modes not to deadlock with samples")
+	}
+	assert.Equal(t, models.ModeAllow, tracker.cfgGroups["GroupA"].Mode, "expected the last mode to be saved")
+}
+
 // TestValidateGroupTrackerConfig_SampleSize ensures that validateGroupTrackerConfig
 // correctly sets the SampleSize value for each valid group.
 func TestValidateGroupTrackerConfig_SampleSize(t *testing.T) {
@@ -962,3 +1427,489 @@ func TestValidateGroupTrackerConfig_SampleSize(t *testing.T) {
 
 	// TODO: test more of the validateGroupTrackerConfig() mutations.
 }
+
+func TestTracker_ReloadConfig(t *testing.T) {
+	t.Cleanup(func() {
+		restoreFunctions()
+	})
+
+	tracker := &Tracker{
+		logger:    config.MustGetLogger(),
+		mu:        &sync.Mutex{},
+		devices:   &sync.Map{},
+		cfgGroups: models.MapGroupTrackerConfig{"old-group": {Threshold: time.Minute}},
+	}
+
+	// Reload fails when the config can't be read and the existing config is kept.
+	fnGetGroupTrackerConfig = func(mu *sync.Mutex, configPath string, _ func() models.MapGroupTrackerConfig) (models.MapGroupTrackerConfig, error) {
+		return nil, errors.New("mocked error for getGroupTrackerConfig")
+	}
+	assert.Error(t, tracker.ReloadConfig(), "expected error when config can't be read")
+	assert.Contains(t, tracker.cfgGroups, models.Group("old-group"), "expected existing config to be kept")
+
+	// Reload replaces the config.
+	fnGetGroupTrackerConfig = func(mu *sync.Mutex, configPath string, _ func() models.MapGroupTrackerConfig) (models.MapGroupTrackerConfig, error) {
+		return models.MapGroupTrackerConfig{"new-group": {Retention: time.Hour, Threshold: 30 * time.Minute}}, nil
+	}
+	assert.NoError(t, tracker.ReloadConfig())
+	assert.NotContains(t, tracker.cfgGroups, models.Group("old-group"))
+	if assert.Contains(t, tracker.cfgGroups, models.Group("new-group")) {
+		assert.Equal(t, 30*time.Minute, tracker.cfgGroups["new-group"].Threshold)
+		assert.Equal(t, 60, tracker.cfgGroups["new-group"].SampleSize, "expected config to be validated")
+	}
+}
+
+func TestAddDeviceSample(t *testing.T) {
+	t.Cleanup(func() {
+		restoreFunctions()
+	})
+	fnGetGroupTrackerConfig = func(mu *sync.Mutex, configPath string, _ func() models.MapGroupTrackerConfig) (models.MapGroupTrackerConfig, error) {
+		return models.MapGroupTrackerConfig{}, nil
+	}
+
+	group := "kids"
+	mac1 := models.MAC("AA-AA-AA-AA-AA-AA")
+	mac2 := models.MAC("BB-BB-BB-BB-BB-BB")
+	cfg := &models.TrackerConfig{
+		Retention:   10 * time.Minute,
+		Granularity: 1 * time.Minute,
+		Threshold:   10 * time.Minute,
+	}
+
+	tracker, err := NewTracker(context.Background(), config.MustGetLogger(), cfg, nil)
+	assert.NoError(t, err, "NewTracker failed")
+
+	// Device tracking is disabled by default.
+	tracker.AddSample(group, true)
+	tracker.AddDeviceSample(group, mac1, true)
+	assert.Empty(t, tracker.GetDeviceSummary(), "expected no device samples when device tracking is disabled")
+
+	// Enable device tracking.
+	cfg.TrackDevices = true
+	tracker.AddDeviceSample(group, mac1, true)
+	tracker.AddDeviceSample(group, mac2, false)
+
+	summary := tracker.GetDeviceSummary()
+	if assert.Contains(t, summary, group) {
+		assert.Equal(t, 1, summary[group][mac1].Used, "expected active device sample to be counted")
+		assert.Equal(t, 0, summary[group][mac2].Used, "expected inactive device sample not to be counted")
+	}
+
+	// Samples aren't counted while the group is paused.
+	tracker.Reset(group)
+	assert.Empty(t, tracker.GetDeviceSummary(), "expected device samples to be reset with the group")
+	tracker.AddSample(group, false)
+	d, _ := tracker.devices.Load(group)
+	d.(*deviceData).config.Mode = models.ModeAllow
+	d.(*deviceData).config.ModeEndTime = time.Now().Add(time.Hour)
+	tracker.AddDeviceSample(group, mac1, true)
+	assert.Equal(t, 0, tracker.GetDeviceSummary()[group][mac1].Used, "expected device sample not to be counted while the group is paused")
+}
+
+func TestInCountingHours(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2025, 3, 10, hour, min, 0, 0, time.Local)
+	}
+	tests := []struct {
+		name  string
+		from  time.Duration
+		until time.Duration
+		now   time.Time
+		want  bool
+	}{
+		{"No counting hours counts all day", 0, 0, at(3, 0), true},
+		{"Inside daytime hours", 7 * time.Hour, 21 * time.Hour, at(7, 0), true},
+		{"Before daytime hours", 7 * time.Hour, 21 * time.Hour, at(6, 59), false},
+		{"End of daytime hours is exclusive", 7 * time.Hour, 21 * time.Hour, at(21, 0), false},
+		{"Hours spanning midnight, late evening", 22 * time.Hour, 2 * time.Hour, at(23, 30), true},
+		{"Hours spanning midnight, early morning", 22 * time.Hour, 2 * time.Hour, at(1, 59), true},
+		{"Hours spanning midnight, daytime", 22 * time.Hour, 2 * time.Hour, at(12, 0), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dd := newDeviceData(tt.now, &models.TrackerConfig{Retention: 24 * time.Hour, Threshold: time.Hour, CountFrom: tt.from, CountUntil: tt.until}, time.Local)
+			assert.Equal(t, tt.want, dd.inCountingHours(tt.now))
+		})
+	}
+}
+
+func TestIsBlocked_EvaluationOrder(t *testing.T) {
+	now := time.Date(2025, 3, 10, 22, 0, 0, 0, time.Local) // outside 07:00-21:00
+	tests := []struct {
+		name         string
+		mode         models.UsageTrackerMode
+		blockOutside bool
+		countFrom    time.Duration
+		used         int
+		want         bool
+	}{
+		{name: "Allow mode wins over blocking outside hours", mode: models.ModeAllow, blockOutside: true, countFrom: 7 * time.Hour, want: false},
+		{name: "Block mode wins over un-counted hours", mode: models.ModeBlock, countFrom: 7 * time.Hour, want: true},
+		{name: "Outside hours blocks when configured", blockOutside: true, countFrom: 7 * time.Hour, want: true},
+		{name: "Outside hours ignores an exceeded threshold when un-counted", countFrom: 7 * time.Hour, used: 60, want: false},
+		{name: "Inside hours uses the threshold", countFrom: 21 * time.Hour, blockOutside: true, used: 60, want: true},
+		{name: "Inside hours under the threshold", countFrom: 21 * time.Hour, blockOutside: true, used: 59, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			until := 23 * time.Hour
+			if tt.countFrom == 7*time.Hour {
+				until = 21 * time.Hour
+			}
+			dd := newDeviceData(now, &models.TrackerConfig{
+				Retention:         24 * time.Hour,
+				Granularity:       time.Minute,
+				Threshold:         time.Hour,
+				CountFrom:         tt.countFrom,
+				CountUntil:        until,
+				BlockOutsideHours: tt.blockOutside,
+				Mode:              tt.mode,
+				ModeEndTime:       now.Add(time.Hour),
+			}, time.Local)
+			for i := 0; i < tt.used; i++ {
+				dd.samples[i] = true
+			}
+			got, _ := dd.isBlocked(config.MustGetLogger(), now)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAddSample_OutsideCountingHours(t *testing.T) {
+	t.Cleanup(func() {
+		restoreFunctions()
+	})
+	fnGetGroupTrackerConfig = func(mu *sync.Mutex, configPath string, _ func() models.MapGroupTrackerConfig) (models.MapGroupTrackerConfig, error) {
+		return models.MapGroupTrackerConfig{}, nil
+	}
+
+	cfg := &models.TrackerConfig{Retention: 24 * time.Hour, Granularity: time.Minute, Threshold: time.Hour, CountFrom: 7 * time.Hour, CountUntil: 21 * time.Hour}
+	tracker, err := NewTracker(context.Background(), config.MustGetLogger(), cfg, nil)
+	assert.NoError(t, err, "NewTracker failed")
+
+	tracker.nowFunc = func() time.Time { return time.Date(2025, 3, 10, 22, 0, 0, 0, time.Local) }
+	tracker.AddSample("kids", true)
+	d, _ := tracker.devices.Load("kids")
+	assert.Equal(t, 0, d.(*deviceData).countUsed(), "expected usage outside counting hours not to be counted")
+
+	tracker.nowFunc = func() time.Time { return time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local) }
+	tracker.AddSample("kids", true)
+	assert.Equal(t, 1, d.(*deviceData).countUsed(), "expected usage inside counting hours to be counted")
+}
+
+func TestTracker_Health(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	tracker := &Tracker{logger: config.MustGetLogger(), nowFunc: func() time.Time { return now }}
+	assert.Equal(t, models.HealthOK, tracker.Health().Status, "expected ok when saving is disabled")
+
+	status := &saveStatus{filePath: "/tmp/samples.json", interval: time.Minute, started: now.Add(-time.Minute)}
+	tracker.saves = []*saveStatus{status}
+	assert.Equal(t, models.HealthOK, tracker.Health().Status, "expected ok before the first save is due")
+
+	status.record(now, errors.New("disk full"))
+	h := tracker.Health()
+	assert.Equal(t, models.HealthDegraded, h.Status)
+	assert.Equal(t, "failed to save samples.json: disk full", h.Message)
+
+	status.record(now.Add(-time.Minute), nil)
+	assert.Equal(t, models.HealthOK, tracker.Health().Status)
+
+	status.record(now.Add(-3*time.Minute), nil)
+	h = tracker.Health()
+	assert.Equal(t, models.HealthDegraded, h.Status, "expected an overdue save to be degraded")
+	assert.Contains(t, h.Message, "has not been saved since")
+}
+
+func TestTracker_IsPacketSampled(t *testing.T) {
+	cfg := &models.TrackerConfig{
+		Granularity:            1 * time.Minute,
+		Retention:              1 * time.Hour,
+		Threshold:              10 * time.Minute,
+		Mode:                   models.ModeMonitor,
+		SampleFileSaveInterval: 50 * time.Millisecond,
+	}
+
+	fnGetGroupTrackerConfig = func(mu *sync.Mutex, configPath string, _ func() models.MapGroupTrackerConfig) (models.MapGroupTrackerConfig, error) {
+		return models.MapGroupTrackerConfig{
+			"sampled":     {Granularity: time.Minute, Retention: time.Hour, Threshold: 10 * time.Minute, Mode: models.ModeMonitor, PacketSampling: true},
+			"not-sampled": {Granularity: time.Minute, Retention: time.Hour, Threshold: 10 * time.Minute, Mode: models.ModeMonitor},
+		}, nil
+	}
+	useWriter(t, func(filePath string, data string) error { return nil })
+	t.Cleanup(func() {
+		fnGetGroupTrackerConfig = config.GetConfig
+	})
+
+	tracker, err := NewTracker(context.Background(), config.MustGetLogger(), cfg, nil)
+	assert.NoError(t, err, "NewTracker failed")
+
+	assert.True(t, tracker.IsPacketSampled("sampled"), "expected a sampled group to be sampled before it is seen")
+	tracker.AddSample("sampled", true)
+	tracker.AddSample("not-sampled", true)
+	assert.True(t, tracker.IsPacketSampled("sampled"))
+	assert.False(t, tracker.IsPacketSampled("not-sampled"))
+	assert.False(t, tracker.IsPacketSampled("unknown"), "expected groups without config to take the default")
+
+	err = tracker.SetMode("sampled", time.Minute, models.ModeBlock)
+	assert.NoError(t, err)
+	assert.False(t, tracker.IsPacketSampled("sampled"), "expected a blocked group to queue every packet")
+
+	tracker.nowFunc = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	assert.True(t, tracker.IsPacketSampled("sampled"), "expected the block to have expired at the tracker's time")
+}
+
+func TestTracker_Usage(t *testing.T) {
+	cfg := &models.TrackerConfig{
+		Granularity:            1 * time.Minute,
+		Retention:              1 * time.Hour,
+		Threshold:              10 * time.Minute,
+		Mode:                   models.ModeMonitor,
+		SampleFileSaveInterval: 50 * time.Millisecond,
+	}
+	useWriter(t, func(filePath string, data string) error { return nil })
+
+	tracker, err := NewTracker(context.Background(), config.MustGetLogger(), cfg, nil)
+	assert.NoError(t, err, "NewTracker failed")
+
+	used, threshold, mode := tracker.Usage("kids")
+	assert.Zero(t, used, "expected an unknown group to have no usage")
+	assert.Zero(t, threshold)
+	assert.Equal(t, models.ModeMonitor, mode)
+
+	data := newDeviceData(time.Now(), cfg, time.Local)
+	data.samples[0] = true
+	data.config.Mode, data.config.ModeEndTime = models.ModeBlock, time.Now().Add(time.Minute)
+	tracker.devices.Store("kids", data)
+	used, threshold, mode = tracker.Usage("kids")
+	assert.Equal(t, time.Minute, used)
+	assert.Equal(t, 10*time.Minute, threshold)
+	assert.Equal(t, models.ModeBlock, mode)
+
+	// The mode expires at the tracker's time, not the wall clock's.
+	tracker.nowFunc = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	_, _, mode = tracker.Usage("kids")
+	assert.Equal(t, models.ModeMonitor, mode, "expected the block to have expired at the tracker's time")
+	_, _, mode = tracker.blockedState(data, time.Now())
+	assert.Equal(t, models.ModeBlock, mode, "expected the block to be in force at the given time")
+}
+
+func TestIsBlocked_DayThresholds(t *testing.T) {
+	defer func(l *time.Location) { time.Local = l }(time.Local)
+	time.Local = time.UTC // windows are calculated in UTC.
+
+	cfg := &models.TrackerConfig{
+		Granularity:   time.Minute,
+		Retention:     24 * time.Hour,
+		Threshold:     60 * time.Minute,
+		StartDuration: 6 * time.Hour,
+		DayThresholds: []models.DayThreshold{
+			{Days: []time.Weekday{time.Saturday, time.Sunday}, Threshold: 120 * time.Minute},
+		},
+	}
+	tests := []struct {
+		name    string
+		now     time.Time
+		used    int
+		blocked bool
+	}{
+		{name: "Friday uses the default threshold", now: time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC), used: 60, blocked: true},
+		{name: "Saturday uses the weekend threshold", now: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), used: 60, blocked: false},
+		{name: "Saturday over the weekend threshold", now: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), used: 120, blocked: true},
+		{name: "Early Monday is still in Sunday's window", now: time.Date(2024, 6, 3, 5, 0, 0, 0, time.UTC), used: 90, blocked: false},
+		{name: "Monday after the window resets", now: time.Date(2024, 6, 3, 7, 0, 0, 0, time.UTC), used: 60, blocked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDeviceData(tt.now, cfg, time.Local)
+			for i := 0; i < tt.used; i++ {
+				d.samples[i] = true
+			}
+			blocked, _ := d.isBlocked(config.MustGetLogger(), tt.now)
+			assert.Equal(t, tt.blocked, blocked)
+		})
+	}
+}
+
+func TestIsWarning_GracePeriod(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name    string
+		mode    models.UsageTrackerMode
+		used    int
+		warning bool
+		blocked bool
+	}{
+		{name: "Under the warning percentage", used: 44, warning: false, blocked: false},
+		{name: "At the warning percentage", used: 45, warning: true, blocked: false},
+		{name: "In the grace period", used: 60, warning: true, blocked: false},
+		{name: "After the grace period", used: 70, warning: false, blocked: true},
+		{name: "Allowed manually", mode: models.ModeAllow, used: 60, warning: false, blocked: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dd := newDeviceData(now, &models.TrackerConfig{
+				Retention:   24 * time.Hour,
+				Granularity: time.Minute,
+				Threshold:   time.Hour,
+				WarnAt:      75,
+				GracePeriod: 10 * time.Minute,
+				Mode:        tt.mode,
+				ModeEndTime: now.Add(time.Hour),
+			}, time.Local)
+			for i := 0; i < tt.used; i++ {
+				dd.samples[i] = true
+			}
+			blocked, _ := dd.isBlocked(config.MustGetLogger(), now)
+			warning, reason := dd.isWarning(now)
+			assert.Equal(t, tt.blocked, blocked)
+			assert.Equal(t, tt.warning, warning && !blocked)
+			if warning {
+				assert.NotEmpty(t, reason)
+			}
+		})
+	}
+}
+
+func TestValidateGroupTrackerConfig_Warnings(t *testing.T) {
+	cfg := models.MapGroupTrackerConfig{
+		"kids":  {Retention: 24 * time.Hour, WarnAt: 150, GracePeriod: -time.Minute},
+		"teens": {Retention: 24 * time.Hour, WarnAt: -1, GracePeriod: 5 * time.Minute},
+	}
+	assert.NoError(t, validateGroupTrackerConfig(cfg))
+	assert.Equal(t, 100, cfg["kids"].WarnAt)
+	assert.Zero(t, cfg["kids"].GracePeriod)
+	assert.Zero(t, cfg["teens"].WarnAt)
+	assert.Equal(t, 5*time.Minute, cfg["teens"].GracePeriod)
+}
+
+func TestValidateGroupTrackerConfig_Window(t *testing.T) {
+	err := validateGroupTrackerConfig(models.MapGroupTrackerConfig{"kids": {Retention: 14 * 24 * time.Hour}})
+	assert.ErrorContains(t, err, "monthly window", "expected a 14 day retention not to become a monthly window silently")
+
+	err = validateGroupTrackerConfig(models.MapGroupTrackerConfig{"kids": {Retention: 24 * time.Hour, Window: "weekly"}})
+	assert.ErrorContains(t, err, "unknown window")
+
+	cfg := models.MapGroupTrackerConfig{
+		"kids":  {Retention: 14 * 24 * time.Hour, Window: models.WindowMonthly, StartDayOfMonth: 31},
+		"teens": {Retention: 7 * 24 * time.Hour},
+	}
+	assert.NoError(t, validateGroupTrackerConfig(cfg))
+	assert.True(t, isMonthly(cfg["kids"]))
+	assert.Equal(t, 28, cfg["kids"].StartDayOfMonth)
+	assert.False(t, isMonthly(cfg["teens"]), "expected a week's retention to keep a weekly window")
+
+	_, err = NewTracker(context.Background(), config.MustGetLogger(), &models.TrackerConfig{Retention: 14 * 24 * time.Hour}, nil)
+	assert.ErrorContains(t, err, "TRACKER_WINDOW")
+}
+
+func TestValidateGroupTrackerConfig_DayThresholds(t *testing.T) {
+	cfg := models.MapGroupTrackerConfig{"kids": {
+		Retention: 24 * time.Hour,
+		DayThresholds: []models.DayThreshold{
+			{Days: []time.Weekday{time.Saturday, 9}, Threshold: 120 * time.Minute},
+			{Days: []time.Weekday{-1}, Threshold: 30 * time.Minute},
+			{Days: []time.Weekday{time.Monday}, Threshold: -time.Minute},
+		},
+	}}
+	assert.NoError(t, validateGroupTrackerConfig(cfg))
+	assert.Equal(t, []models.DayThreshold{
+		{Days: []time.Weekday{time.Saturday}, Threshold: 120 * time.Minute},
+		{Days: []time.Weekday{time.Monday}, Threshold: 0},
+	}, cfg["kids"].DayThresholds, "expected invalid days to be removed")
+}
+
+func TestValidateGroupTrackerConfig_PacketPolicy(t *testing.T) {
+	cfg := models.MapGroupTrackerConfig{
+		"teens":    {Retention: 24 * time.Hour, PacketPolicy: &models.PacketPolicy{DropPercentage: 2, DropUDP: true, RateLimitKbps: -1}},
+		"toddlers": {Retention: 24 * time.Hour, PacketPolicy: &models.PacketPolicy{DelayPercentage: -0.5, Delay: -time.Second, Jitter: -time.Second}},
+		"default":  {Retention: 24 * time.Hour},
+	}
+	assert.NoError(t, validateGroupTrackerConfig(cfg))
+	assert.Equal(t, &models.PacketPolicy{DropPercentage: 1, DropUDP: true}, cfg["teens"].PacketPolicy)
+	assert.Equal(t, &models.PacketPolicy{}, cfg["toddlers"].PacketPolicy)
+	assert.Nil(t, cfg["default"].PacketPolicy, "expected groups without a policy to keep using the filter defaults")
+
+	tracker := &Tracker{mu: &sync.Mutex{}, cfgGroups: cfg}
+	p := tracker.PacketPolicy("teens")
+	p.DropPercentage = 0
+	assert.Equal(t, float32(1), tracker.PacketPolicy("teens").DropPercentage, "expected a copy of the policy")
+	assert.Nil(t, tracker.PacketPolicy("default"))
+	assert.Nil(t, tracker.PacketPolicy("unknown"))
+}
+
+func TestValidateGroupTrackerConfig_Allowlist(t *testing.T) {
+	cfg := models.MapGroupTrackerConfig{
+		"kids":  {Retention: 24 * time.Hour, Allowlist: []string{" KhanAcademy.org. ", "khanacademy.org", "", "https://scratch.mit.edu", "fd00::1", "10.0.0.1"}},
+		"teens": {Retention: 24 * time.Hour},
+	}
+	assert.NoError(t, validateGroupTrackerConfig(cfg))
+	assert.Equal(t, []string{"khanacademy.org", "fd00::1", "10.0.0.1"}, cfg["kids"].Allowlist)
+
+	tracker := &Tracker{mu: &sync.Mutex{}, cfgGroups: cfg}
+	allowlists := tracker.Allowlists()
+	assert.Equal(t, models.MapGroupAllowlist{"kids": {"khanacademy.org", "fd00::1", "10.0.0.1"}, "teens": nil}, allowlists, "expected every configured group")
+	allowlists["kids"][0] = "changed"
+	assert.Equal(t, "khanacademy.org", cfg["kids"].Allowlist[0], "expected a copy of the allowlists")
+}
+
+func TestValidateGroupTrackerConfig_MinActive(t *testing.T) {
+	cfg := models.MapGroupTrackerConfig{
+		"kids":  {Retention: 24 * time.Hour, MinActive: 10 * time.Minute, MinActiveWindow: 5 * time.Minute},
+		"teens": {Retention: 24 * time.Hour, MinActive: -time.Minute},
+	}
+	assert.NoError(t, validateGroupTrackerConfig(cfg))
+	assert.Equal(t, 10*time.Minute, cfg["kids"].MinActiveWindow, "expected the window to fit the minimum active time")
+	assert.Zero(t, cfg["teens"].MinActive)
+}
+
+func TestDecodeSamples_Versions(t *testing.T) {
+	legacy := []byte(`{"kids":{"samples":[true,false],"windowStartTime":"2025-03-10T00:00:00Z","carried":3}}`)
+	doc, err := decodeSamples(legacy)
+	assert.NoError(t, err, "expected samples saved before versioning to be migrated")
+	assert.Equal(t, samplesVersion, doc.Version)
+	if assert.Contains(t, doc.Samples, "kids") {
+		assert.Equal(t, []bool{true, false}, doc.Samples["kids"].Samples)
+		assert.Equal(t, 3, doc.Samples["kids"].Carried)
+	}
+
+	b, err := encodeSamples(doc.Samples, nil)
+	assert.NoError(t, err)
+	again, err := decodeSamples(b)
+	assert.NoError(t, err)
+	assert.Equal(t, doc, again)
+
+	_, err = decodeSamples([]byte(`{"version":99,"samples":{}}`))
+	assert.ErrorIs(t, err, models.ErrInvalidSamples, "expected samples from a newer build to be refused")
+	_, err = decodeSamples([]byte(`[]`))
+	assert.ErrorIs(t, err, models.ErrInvalidSamples)
+}
+
+func TestTracker_ExportAndImportSamples(t *testing.T) {
+	cfg := &models.TrackerConfig{Retention: time.Hour, Granularity: time.Minute, Threshold: 10 * time.Minute}
+	now := time.Date(2025, 3, 10, 12, 30, 0, 0, time.UTC)
+	source := &Tracker{logger: config.MustGetLogger(), mu: &sync.Mutex{}, devices: &sync.Map{}, macDevices: &sync.Map{}, cfgTrackerDefaults: &models.TrackerConfig{}}
+	dd := newDeviceData(now, cfg, time.Local)
+	dd.samples[0], dd.samples[5] = true, true
+	source.devices.Store("kids", dd)
+
+	exported, err := source.ExportSamples()
+	assert.NoError(t, err)
+
+	target := &Tracker{logger: config.MustGetLogger(), mu: &sync.Mutex{}, devices: &sync.Map{}, macDevices: &sync.Map{}, cfgTrackerDefaults: &models.TrackerConfig{}}
+	devices := target.devices
+	target.devices.Store("stale", newDeviceData(now, cfg, time.Local))
+	assert.NoError(t, target.ImportSamples(exported))
+	assert.Same(t, devices, target.devices, "expected the map shared with the periodic saves to be kept")
+	_, ok := target.devices.Load("stale")
+	assert.False(t, ok, "expected the import to replace the existing samples")
+	if data, ok := target.devices.Load("kids"); assert.True(t, ok) {
+		assert.Equal(t, 2, data.(*deviceData).countUsed())
+	}
+
+	bad := []byte(`{"version":2,"samples":{"kids":{"config":{"sampleSize":60},"samples":[true]}}}`)
+	assert.ErrorIs(t, target.ImportSamples(bad), models.ErrInvalidSamples)
+	_, ok = target.devices.Load("kids")
+	assert.True(t, ok, "expected a failed import to leave the samples alone")
+}
@@ -0,0 +1,46 @@
+package usage
+
+import (
+	"time"
+)
+
+// watch holds back the usage of a group until it has been active for MinActive within MinActiveWindow, so that the
+// activity monitor seeing a burst of background traffic, e.g. a thumbnail loading, doesn't cost a whole minute.
+type watch struct {
+	pending     []time.Time // pending are the starts of the active sample slots not counted yet, oldest first.
+	lastCounted time.Time   // lastCounted is the time of the most recent active sample that was counted.
+}
+
+// isWatching records an active sample at the given time and returns true if it should be counted. Once enough
+// active time is pending, the pending slots are counted too and backfilled is true. Counting continues until the
+// group is idle for MinActiveWindow.
+// It should be called under d.mu after syncWindow.
+func (d *deviceData) isWatching(now time.Time) (watching, backfilled bool) {
+	if d.config.MinActive <= d.config.Granularity { // if a single active sample is enough...
+		return true, false
+	}
+	if !d.watch.lastCounted.IsZero() && now.Sub(d.watch.lastCounted) < d.config.MinActiveWindow { // if the group is still being watched...
+		d.watch.lastCounted = now
+		return true, false
+	}
+
+	slot := d.windowStartTime.Add(now.Sub(d.windowStartTime).Truncate(d.config.Granularity))
+	cutoff := slot.Add(-d.config.MinActiveWindow)
+	pending := d.watch.pending[:0]
+	for _, p := range d.watch.pending { // for each pending slot, keep those still in the window...
+		if p.After(cutoff) && !p.Before(d.windowStartTime) && p.Before(slot) {
+			pending = append(pending, p)
+		}
+	}
+	d.watch.pending = append(pending, slot)
+	if time.Duration(len(d.watch.pending))*d.config.Granularity < d.config.MinActive { // if it may only be background traffic...
+		return false, false
+	}
+
+	for _, p := range d.watch.pending { // for each slot held back, count it now that the group is being watched...
+		d.samples[d.getIndex(p, d.windowStartTime)] = true
+	}
+	d.watch.pending = nil
+	d.watch.lastCounted = now
+	return true, true
+}
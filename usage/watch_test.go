@@ -0,0 +1,61 @@
+package usage
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+func TestAddSampleToDevice_MinActive(t *testing.T) {
+	logger := config.MustGetLogger()
+	start := time.Date(2025, 3, 10, 12, 0, 0, 0, time.Local)
+	cfg := &models.TrackerConfig{
+		Retention:       24 * time.Hour,
+		Granularity:     time.Minute,
+		Threshold:       3 * time.Hour,
+		MinActive:       3 * time.Minute,
+		MinActiveWindow: 5 * time.Minute,
+		Mode:            models.ModeMonitor,
+	}
+	used := func(devices *sync.Map) int {
+		data, _ := devices.Load("kids")
+		return data.(*deviceData).countUsed()
+	}
+	at := func(minutes int, seconds int) time.Time {
+		return start.Add(time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second)
+	}
+
+	t.Run("Background traffic isn't counted", func(t *testing.T) {
+		devices := &sync.Map{}
+		assert.False(t, addSampleToDevice(logger, devices, "kids", cfg, time.Local, at(0, 5), true))
+		assert.False(t, addSampleToDevice(logger, devices, "kids", cfg, time.Local, at(0, 30), true), "expected more samples in the same minute not to count")
+		assert.False(t, addSampleToDevice(logger, devices, "kids", cfg, time.Local, at(4, 0), true))
+		assert.False(t, addSampleToDevice(logger, devices, "kids", cfg, time.Local, at(9, 0), true), "expected activity older than the window to be forgotten")
+		assert.Zero(t, used(devices))
+	})
+
+	t.Run("Watching counts the minutes held back", func(t *testing.T) {
+		devices := &sync.Map{}
+		assert.False(t, addSampleToDevice(logger, devices, "kids", cfg, time.Local, at(0, 0), true))
+		assert.False(t, addSampleToDevice(logger, devices, "kids", cfg, time.Local, at(1, 0), true))
+		assert.True(t, addSampleToDevice(logger, devices, "kids", cfg, time.Local, at(3, 0), true), "expected the usage to go up once the minimum is reached")
+		assert.Equal(t, 3, used(devices))
+
+		assert.True(t, addSampleToDevice(logger, devices, "kids", cfg, time.Local, at(7, 0), true), "expected a gap shorter than the window to keep counting")
+		assert.Equal(t, 4, used(devices))
+		assert.False(t, addSampleToDevice(logger, devices, "kids", cfg, time.Local, at(13, 0), true), "expected the minimum again after being idle for the window")
+		assert.Equal(t, 4, used(devices))
+	})
+
+	t.Run("Zero counts every active minute", func(t *testing.T) {
+		devices := &sync.Map{}
+		noGrace := *cfg
+		noGrace.MinActive = 0
+		assert.True(t, addSampleToDevice(logger, devices, "kids", &noGrace, time.Local, at(0, 5), true))
+		assert.Equal(t, 1, used(devices))
+	})
+}
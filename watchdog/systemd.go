@@ -0,0 +1,43 @@
+package watchdog
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+var getenv = os.Getenv
+
+// systemdWatchdogInterval returns how often systemd expects to be pinged, half its WatchdogSec, or 0 if it isn't
+// watching this process.
+func systemdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) { // if it's watching another process...
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// sdNotify sends the state, e.g. WATCHDOG=1, to systemd's notify socket, as sd_notify does.
+func sdNotify(socket, state string) error {
+	if socket == "" {
+		return fmt.Errorf("NOTIFY_SOCKET isn't set, so set NotifyAccess=main in the unit")
+	}
+	if socket[0] == '@' { // if it's an abstract socket...
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to the notify socket: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to write to the notify socket: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+// Package watchdog removes the firewall rules so that traffic fails open if the packet handler stops responding, and
+// pings systemd's watchdog while it's checking. A handler that's stuck, or whose workers keep panicking, otherwise
+// leaves the tracked devices' traffic queued without a verdict, which cuts them off the internet.
+package watchdog
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/eventbus"
+	"relloyd/tubetimeout/models"
+)
+
+// aliveChecks is the number of check intervals that can pass without a check before the watchdog stops pinging
+// systemd, so that systemd restarts the app if the checks themselves hang.
+const aliveChecks = 3
+
+// PacketHandler reports how long it has had packets waiting without finishing any, and why.
+type PacketHandler interface {
+	Stalled() (time.Duration, string)
+}
+
+// Firewall is the rules that queue the tracked devices' traffic to the packet handler.
+type Firewall interface {
+	Clean(logger *zap.SugaredLogger) error
+}
+
+// Watchdog checks the packet handler and removes the firewall rules once, if the handler stalls on Stalls checks in
+// a row or panics Panics times within PanicWindow. The rules stay removed until the app is restarted.
+type Watchdog struct {
+	logger  *zap.SugaredLogger
+	cfg     *config.WatchdogConfig
+	nowFunc func() time.Time
+
+	mu          sync.Mutex
+	watching    bool        // watching is true once Watch has started the checks, guarded by mu.
+	lastCheck   time.Time   // lastCheck is when the handler was last checked, guarded by mu.
+	stalls      int         // stalls is the number of checks in a row that found the handler stalled, guarded by mu.
+	panics      []time.Time // panics are the times of the panics within the last PanicWindow, guarded by mu.
+	tripped     string      // tripped is why the rules were removed, or empty if they haven't been, guarded by mu.
+	trippedTime time.Time   // trippedTime is when the rules were removed, guarded by mu.
+}
+
+func NewWatchdog(logger *zap.SugaredLogger, cfg *config.WatchdogConfig) *Watchdog {
+	return &Watchdog{logger: logger, cfg: cfg, nowFunc: time.Now}
+}
+
+// Start tells systemd that the app is alive every half of its WatchdogSec until ctx is done, for as long as the
+// checks keep running, and while the app starts up before they do. It does nothing unless systemd is watching.
+func (w *Watchdog) Start(ctx context.Context) {
+	interval := systemdWatchdogInterval()
+	if interval <= 0 {
+		return
+	}
+	socket := getenv("NOTIFY_SOCKET")
+	w.logger.Infof("Pinging the systemd watchdog every %v", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !w.alive(w.nowFunc()) {
+					w.logger.Errorf("The watchdog hasn't checked the packet handler for over %v, leaving systemd to restart the app", aliveChecks*w.cfg.Interval)
+					continue
+				}
+				if err := sdNotify(socket, "WATCHDOG=1"); err != nil {
+					w.logger.Warnf("Failed to ping the systemd watchdog: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Watch checks the handler every Interval until ctx is done, removing fw's rules if the handler has stopped
+// responding and publishing why to the bus.
+func (w *Watchdog) Watch(ctx context.Context, handler PacketHandler, fw Firewall, bus *eventbus.Bus) {
+	w.mu.Lock()
+	w.watching, w.lastCheck = true, w.nowFunc()
+	w.mu.Unlock()
+	go func() {
+		ticker := time.NewTicker(w.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				w.check(w.nowFunc(), handler, fw, bus)
+			}
+		}
+	}()
+}
+
+// RecordPanic counts a panic recovered in the packet handler. It's called while recovering, so it only records the
+// time and leaves the next check to act on it.
+func (w *Watchdog) RecordPanic() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.panics = append(w.panics, w.nowFunc())
+}
+
+// check removes the rules if the handler has stalled on Stalls checks in a row or has panicked Panics times within
+// PanicWindow, unless they've already been removed or the watchdog isn't enabled.
+func (w *Watchdog) check(now time.Time, handler PacketHandler, fw Firewall, bus *eventbus.Bus) {
+	d, stall := handler.Stalled()
+
+	w.mu.Lock()
+	w.lastCheck = now
+	if d >= w.cfg.StallDuration && d > 0 {
+		w.stalls++
+		if w.cfg.Enabled && w.tripped == "" {
+			w.logger.Warnf("Packet handler stall %v of %v: %v", w.stalls, w.cfg.Stalls, stall)
+		}
+	} else {
+		w.stalls = 0
+	}
+	w.panics = slices.DeleteFunc(w.panics, func(t time.Time) bool { return now.Sub(t) > w.cfg.PanicWindow })
+	var reason string
+	switch {
+	case !w.cfg.Enabled || w.tripped != "":
+	case w.cfg.Stalls > 0 && w.stalls >= w.cfg.Stalls:
+		reason = fmt.Sprintf("the packet handler stalled on %v checks in a row: %v", w.stalls, stall)
+	case w.cfg.Panics > 0 && len(w.panics) >= w.cfg.Panics:
+		reason = fmt.Sprintf("the packet handler panicked %v times within %v", len(w.panics), w.cfg.PanicWindow)
+	}
+	if reason != "" {
+		w.tripped, w.trippedTime = reason, now
+	}
+	w.mu.Unlock()
+	if reason == "" {
+		return
+	}
+
+	w.logger.Errorf("Removing the firewall rules so that traffic fails open, since %v. Restart the service to filter again.", reason)
+	if err := fw.Clean(w.logger); err != nil {
+		w.logger.Errorf("Failed to remove the firewall rules: %v", err)
+	}
+	bus.Failsafe.Publish(reason)
+	bus.Live.Publish(models.LiveEvent{Type: models.LiveEventFailsafe, Time: now, Data: models.FailsafeEvent{Reason: reason}})
+}
+
+// alive returns true while the app is starting up or the checks ran within the last few intervals.
+func (w *Watchdog) alive(now time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !w.watching || now.Sub(w.lastCheck) <= aliveChecks*w.cfg.Interval
+}
+
+// Health reports the watchdog as unhealthy once it has removed the firewall rules, since traffic isn't filtered
+// until the app is restarted.
+func (w *Watchdog) Health() models.SubsystemHealth {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	h := models.SubsystemHealth{Name: "watchdog", Status: models.HealthOK, Message: "the packet handler is responding"}
+	switch {
+	case w.tripped != "":
+		h.Status = models.HealthUnhealthy
+		h.Message = fmt.Sprintf("the firewall rules were removed at %v since %v; restart the service to filter again", w.trippedTime.Format(time.RFC3339), w.tripped)
+	case !w.cfg.Enabled:
+		h.Message = "disabled"
+	}
+	return h
+}
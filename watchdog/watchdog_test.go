@@ -0,0 +1,152 @@
+package watchdog
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/eventbus"
+	"relloyd/tubetimeout/models"
+)
+
+type mockHandler struct {
+	stalled time.Duration
+}
+
+func (m *mockHandler) Stalled() (time.Duration, string) {
+	return m.stalled, "queue 100 (out) has had 12 packets waiting"
+}
+
+type mockFirewall struct {
+	cleaned int
+}
+
+func (m *mockFirewall) Clean(*zap.SugaredLogger) error {
+	m.cleaned++
+	return nil
+}
+
+func testConfig() *config.WatchdogConfig {
+	return &config.WatchdogConfig{Enabled: true, Interval: 5 * time.Second, StallDuration: 10 * time.Second, Stalls: 3, Panics: 5, PanicWindow: time.Minute}
+}
+
+func TestWatchdog_Stalls(t *testing.T) {
+	start := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	bus := eventbus.NewBus()
+	var reasons []string
+	var events []models.LiveEvent
+	bus.Failsafe.Subscribe(func(r string) { reasons = append(reasons, r) })
+	bus.Live.Subscribe(func(e models.LiveEvent) { events = append(events, e) })
+	h, fw := &mockHandler{}, &mockFirewall{}
+	w := NewWatchdog(config.MustGetLogger(), testConfig())
+
+	// Expect short stalls, and stalls that clear before enough checks in a row, to be left alone.
+	h.stalled = 5 * time.Second
+	w.check(start, h, fw, bus)
+	h.stalled = 20 * time.Second
+	w.check(start.Add(5*time.Second), h, fw, bus)
+	w.check(start.Add(10*time.Second), h, fw, bus)
+	h.stalled = 0
+	w.check(start.Add(15*time.Second), h, fw, bus)
+	assert.Zero(t, fw.cleaned)
+	assert.Equal(t, models.HealthOK, w.Health().Status)
+
+	h.stalled = 20 * time.Second
+	for i := 0; i < 3; i++ {
+		w.check(start.Add(time.Duration(20+5*i)*time.Second), h, fw, bus)
+	}
+	assert.Equal(t, 1, fw.cleaned, "expected the rules to be removed after 3 stalls in a row")
+	if assert.Len(t, reasons, 1) {
+		assert.Contains(t, reasons[0], "stalled on 3 checks in a row: queue 100 (out)")
+	}
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, models.LiveEventFailsafe, events[0].Type)
+		assert.Equal(t, models.FailsafeEvent{Reason: reasons[0]}, events[0].Data)
+	}
+	health := w.Health()
+	assert.Equal(t, models.HealthUnhealthy, health.Status)
+	assert.Contains(t, health.Message, "restart the service")
+
+	w.check(start.Add(time.Minute), h, fw, bus)
+	assert.Equal(t, 1, fw.cleaned, "expected the rules to be removed once")
+}
+
+func TestWatchdog_Panics(t *testing.T) {
+	start := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	h, fw := &mockHandler{}, &mockFirewall{}
+	w := NewWatchdog(config.MustGetLogger(), testConfig())
+	now := start
+	w.nowFunc = func() time.Time { return now }
+
+	// Expect panics spread over more than the window to be left alone.
+	for i := 0; i < 4; i++ {
+		w.RecordPanic()
+		now = now.Add(30 * time.Second)
+	}
+	w.check(now, h, fw, eventbus.NewBus())
+	assert.Zero(t, fw.cleaned)
+
+	for i := 0; i < 5; i++ {
+		w.RecordPanic()
+	}
+	w.check(now, h, fw, eventbus.NewBus())
+	assert.Equal(t, 1, fw.cleaned)
+	assert.Contains(t, w.Health().Message, "panicked")
+}
+
+func TestWatchdog_Disabled(t *testing.T) {
+	start := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	cfg := testConfig()
+	cfg.Enabled = false
+	h, fw := &mockHandler{stalled: time.Minute}, &mockFirewall{}
+	w := NewWatchdog(config.MustGetLogger(), cfg)
+	for i := 0; i < 5; i++ {
+		w.check(start.Add(time.Duration(i)*cfg.Interval), h, fw, eventbus.NewBus())
+	}
+	assert.Zero(t, fw.cleaned)
+	assert.Equal(t, "disabled", w.Health().Message)
+}
+
+func TestWatchdog_Alive(t *testing.T) {
+	start := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	w := NewWatchdog(config.MustGetLogger(), testConfig())
+	assert.True(t, w.alive(start.Add(time.Hour)), "expected the app to be alive while it starts up")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w.nowFunc = func() time.Time { return start }
+	w.Watch(ctx, &mockHandler{}, &mockFirewall{}, eventbus.NewBus())
+	assert.True(t, w.alive(start.Add(15*time.Second)))
+	assert.False(t, w.alive(start.Add(16*time.Second)), "expected the app not to be alive once the checks stop")
+}
+
+func TestSystemd(t *testing.T) {
+	env := map[string]string{}
+	getenv = func(k string) string { return env[k] }
+	t.Cleanup(func() { getenv = os.Getenv })
+
+	assert.Zero(t, systemdWatchdogInterval(), "expected no pings unless systemd is watching")
+	env["WATCHDOG_USEC"] = "30000000"
+	assert.Equal(t, 15*time.Second, systemdWatchdogInterval())
+	env["WATCHDOG_PID"] = strconv.Itoa(os.Getpid() + 1)
+	assert.Zero(t, systemdWatchdogInterval(), "expected no pings if systemd is watching another process")
+
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	require.NoError(t, err)
+	defer func() { _ = conn.Close() }()
+	require.NoError(t, sdNotify(socket, "WATCHDOG=1"))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "WATCHDOG=1", string(buf[:n]))
+	assert.Error(t, sdNotify("", "WATCHDOG=1"))
+}
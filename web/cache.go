@@ -0,0 +1,35 @@
+package web
+
+import (
+	"sync"
+	"time"
+)
+
+var defaultCacheTTL = 5 * time.Second // defaultCacheTTL stops each browser poll re-reading data that watchers update far less often.
+
+// readThroughCache holds a value loaded on demand and reused until it is older than ttl.
+type readThroughCache[T any] struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	value    T
+	loadedAt time.Time
+}
+
+func newReadThroughCache[T any](ttl time.Duration) *readThroughCache[T] {
+	return &readThroughCache[T]{ttl: ttl}
+}
+
+// get returns the cached value, calling load to refresh it first if it has expired.
+// A nil cache always calls load.
+func (c *readThroughCache[T]) get(load func() T) T {
+	if c == nil {
+		return load()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loadedAt.IsZero() || time.Since(c.loadedAt) >= c.ttl {
+		c.value = load()
+		c.loadedAt = time.Now()
+	}
+	return c.value
+}
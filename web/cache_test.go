@@ -0,0 +1,26 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadThroughCache(t *testing.T) {
+	calls := 0
+	load := func() int {
+		calls++
+		return calls
+	}
+
+	c := newReadThroughCache[int](time.Hour)
+	assert.Equal(t, 1, c.get(load))
+	assert.Equal(t, 1, c.get(load), "expected the cached value before the ttl")
+
+	c.loadedAt = time.Now().Add(-time.Hour)
+	assert.Equal(t, 2, c.get(load), "expected the value to be reloaded after the ttl")
+
+	var nilCache *readThroughCache[int]
+	assert.Equal(t, 3, nilCache.get(load), "expected a nil cache to always load")
+}
@@ -1,23 +1,52 @@
 package web
 
 import (
+	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"html/template"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"relloyd/tubetimeout/apikeys"
+	"relloyd/tubetimeout/audit"
 	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/control"
 	"relloyd/tubetimeout/dhcp"
+	"relloyd/tubetimeout/dnsforward"
+	"relloyd/tubetimeout/exempt"
+	"relloyd/tubetimeout/killswitch"
 	"relloyd/tubetimeout/models"
+	"relloyd/tubetimeout/profile"
+	"relloyd/tubetimeout/report"
+	"relloyd/tubetimeout/timerequest"
 )
 
+const (
+	maxRestoreSize    = 64 << 20
+	restartDelay      = time.Second
+	tokenCookieName   = "tubetimeout_token"
+	tokenCookieMaxAge = 30 * 24 * time.Hour
+)
+
+// fnRequestRestart asks the app to shut down cleanly; systemd restarts it (see Restart=always in the unit file).
+var fnRequestRestart = func() {
+	_ = syscall.Kill(os.Getpid(), syscall.SIGTERM)
+}
+
 func (h *Handler) rootHandler(w http.ResponseWriter, r *http.Request) {
 	// Parse the HTML template from the embedded file system
-	tmpl, err := template.ParseFS(embeddedFiles, "templates/index.html")
+	l := h.locale(w, r)
+	tmpl, err := parseTemplate("index.html", l)
 	if err != nil {
 		http.Error(w, "Error loading template", http.StatusInternalServerError)
 		return
@@ -27,7 +56,8 @@ func (h *Handler) rootHandler(w http.ResponseWriter, r *http.Request) {
 	td := TemplateData{
 		BuildTime:    config.BuildTime,
 		BuildVersion: config.BuildVersion,
-		StartTime:    h.startTime.Format(time.RFC822),
+		StartTime:    h.startTime.Format(l.Formats.DateTime),
+		UI:           l.UIData(),
 	}
 
 	tmpl.Option("missingkey=default") // TODO: fix the error when keys are missing.
@@ -51,7 +81,8 @@ func (h *Handler) staticHandler(w http.ResponseWriter, r *http.Request) {
 	http.ServeContent(w, r, path, fileModTime(), strings.NewReader(string(data)))
 }
 
-// groupMACHandler
+// groupMACHandler returns the devices on GET, optionally filtered by the group and name query params and paged with
+// offset and limit, and saves them on POST.
 func (h *Handler) groupMACHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		gm, err := h.groupMACsGetterSetter.GetAllGroupMACs(h.logger)
@@ -60,8 +91,22 @@ func (h *Handler) groupMACHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
-		w.Header().Set("Content-Type", "application/json")
-		err = json.NewEncoder(w).Encode(gm)
+		group, name := r.URL.Query().Get("group"), r.URL.Query().Get("name")
+		gm = slices.DeleteFunc(slices.Clone(gm), func(v config.FlatGroupMAC) bool {
+			return (group != "" && v.Group != group) || !matchesName(name, v.Name, v.MAC)
+		})
+		slices.SortStableFunc(gm, func(a, b config.FlatGroupMAC) int { // sort since the devices come from maps, so pages and ETags are stable...
+			if (a.Group == "") != (b.Group == "") { // if only one is ungrouped, put it last...
+				return strings.Compare(b.Group, a.Group)
+			}
+			return cmp.Or(strings.Compare(a.Group, b.Group), strings.Compare(a.MAC, b.MAC))
+		})
+		setPageHeaders(w, len(gm))
+		start, end := parsePage(r).bounds(len(gm))
+		gm = gm[start:end]
+		h.addPlacements(gm)
+		h.setFreshnessHeaders(w, models.FreshnessSourceIpGroups)
+		err = writeJSONWithETag(w, r, gm)
 		if err != nil {
 			h.logger.Errorf("Error encoding device group response: %v", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -77,7 +122,7 @@ func (h *Handler) groupMACHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid request payload", http.StatusBadRequest)
 			return
 		}
-		err := h.groupMACsGetterSetter.SaveGroupMACs(h.logger, flatGroupMACs)
+		err := h.control.SaveGroupMACs(origin(r), flatGroupMACs)
 		if err != nil {
 			h.logger.Errorf("Error saving device group data: %v", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -94,6 +139,213 @@ func (h *Handler) groupMACHandler(w http.ResponseWriter, r *http.Request) {
 	http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 }
 
+// groupDomainsHandler returns the domains tracked for each group on GET, and replaces them on POST. The domain
+// watcher is reloaded after a save so that the new domains are resolved and filtered.
+func (h *Handler) groupDomainsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.groupDomains == nil {
+		http.Error(w, "Group domains aren't available", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		m, err := h.groupDomains.GetGroupDomains(h.logger)
+		if err != nil {
+			h.logger.Errorf("Error getting group domains: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(m); err != nil {
+			h.logger.Errorf("Error encoding group domains response: %v", err)
+		}
+	case http.MethodPost:
+		var m models.MapGroupDomains
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			h.logger.Errorf("Invalid request group domains payload: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		before, _ := h.groupDomains.GetGroupDomains(h.logger)
+		if err := h.groupDomains.SaveGroupDomains(h.logger, m); errors.Is(err, config.ErrInvalidDomain) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error saving group domains: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "groupDomains.save", "", audit.Snapshot(before), m)
+
+		if h.domainReloader != nil { // resolving every domain can take a while so don't hold up the response...
+			go func() {
+				if err := h.domainReloader.Reload(); err != nil {
+					h.logger.Errorf("Error reloading the domain watcher after saving group domains: %v", err)
+				}
+			}()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(map[string]string{"message": "configuration saved successfully"})
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// setupProposal is what the setup wizard proposes on GET /api/setup.
+type setupProposal struct {
+	FirstRun bool                   `json:"firstRun"` // FirstRun is true if no devices are in groups yet.
+	Network  dhcp.Network           `json:"network"`
+	DHCP     *dhcp.DNSMasqConfig    `json:"dhcp"`
+	Devices  []config.FlatGroupMAC  `json:"devices"` // Devices are the devices configured or seen on the network.
+	Domains  models.MapGroupDomains `json:"domains"`
+}
+
+// setupRequest is the choices made in the setup wizard, applied by POST /api/setup. Domains and DHCP are left as they
+// are if they're omitted.
+type setupRequest struct {
+	Devices []config.FlatGroupMAC  `json:"devices"`
+	Domains models.MapGroupDomains `json:"domains,omitempty"`
+	DHCP    *dhcp.DNSMasqConfig    `json:"dhcp,omitempty"`
+}
+
+// setupHandler returns the network, the DHCP settings proposed for it, the devices seen and the group domains on GET.
+// POST applies the choices in one go: the device groups, then the group domains, then the DHCP settings, putting back
+// the groups and domains saved before if a later step fails.
+func (h *Handler) setupHandler(w http.ResponseWriter, r *http.Request) {
+	if h.networkDetector == nil || h.groupMACsGetterSetter == nil || h.dhcpConfigGetterSetter == nil || h.groupDomains == nil {
+		http.Error(w, "Setup isn't available", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var p setupProposal
+		var err error
+		if p.Network, p.DHCP, err = h.networkDetector.ProposeConfig(); err != nil {
+			h.logger.Errorf("Error detecting the network for setup: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if p.Devices, err = h.groupMACsGetterSetter.GetAllGroupMACs(h.logger); err != nil {
+			h.logger.Errorf("Error getting devices for setup: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if p.Domains, err = h.groupDomains.GetGroupDomains(h.logger); err != nil {
+			h.logger.Errorf("Error getting group domains for setup: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		p.FirstRun = !slices.ContainsFunc(p.Devices, func(d config.FlatGroupMAC) bool { return d.Group != "" })
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(p); err != nil {
+			h.logger.Errorf("Error encoding setup response: %v", err)
+		}
+	case http.MethodPost:
+		var req setupRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.logger.Errorf("Invalid request setup payload: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		if !slices.ContainsFunc(req.Devices, func(d config.FlatGroupMAC) bool { return d.Group != "" && d.MAC != "" }) {
+			http.Error(w, "At least one device must be put in a group", http.StatusBadRequest)
+			return
+		}
+
+		var before setupRequest
+		var err error
+		if before.Devices, err = h.groupMACsGetterSetter.GetAllGroupMACs(h.logger); err != nil {
+			h.logger.Errorf("Error getting devices before setup: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if before.Domains, err = h.groupDomains.GetGroupDomains(h.logger); err != nil {
+			h.logger.Errorf("Error getting group domains before setup: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if oldConfig, err := h.dhcpConfigGetterSetter.GetConfig(h.logger); err == nil {
+			var copied dhcp.DNSMasqConfig
+			_ = json.Unmarshal(audit.Snapshot(oldConfig), &copied) // copy now since the config is updated in place
+			before.DHCP = &copied
+		}
+
+		if err = h.groupMACsGetterSetter.SaveGroupMACs(h.logger, req.Devices); err != nil {
+			h.logger.Errorf("Error saving devices in setup: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if req.Domains != nil {
+			if err = h.groupDomains.SaveGroupDomains(h.logger, req.Domains); err != nil {
+				h.undoSetup(before, false)
+				if errors.Is(err, config.ErrInvalidDomain) {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				h.logger.Errorf("Error saving group domains in setup: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+		}
+		if req.DHCP != nil {
+			if err = h.dhcpConfigGetterSetter.SetConfig(h.logger, req.DHCP); err != nil {
+				h.undoSetup(before, req.Domains != nil)
+				h.logger.Errorf("Error saving DHCP configuration in setup: %v", err)
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		h.audit(r, "setup.apply", "", audit.Snapshot(before), req)
+
+		if req.Domains != nil && h.domainReloader != nil { // resolving every domain can take a while so don't hold up the response...
+			go func() {
+				if err := h.domainReloader.Reload(); err != nil {
+					h.logger.Errorf("Error reloading the domain watcher after setup: %v", err)
+				}
+			}()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(map[string]string{"message": "setup saved successfully"})
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// undoSetup puts back the devices saved before a setup failed part way through, and the group domains if they were
+// saved too.
+func (h *Handler) undoSetup(before setupRequest, domains bool) {
+	if err := h.groupMACsGetterSetter.SaveGroupMACs(h.logger, before.Devices); err != nil {
+		h.logger.Errorf("Error putting back the devices after a failed setup: %v", err)
+	}
+	if domains {
+		if err := h.groupDomains.SaveGroupDomains(h.logger, before.Domains); err != nil {
+			h.logger.Errorf("Error putting back the group domains after a failed setup: %v", err)
+		}
+	}
+}
+
+// addPlacements sets why each device is in its effective group. Devices without a group take the default group
+// placement, if any.
+func (h *Handler) addPlacements(gm []config.FlatGroupMAC) {
+	if h.placements == nil {
+		return
+	}
+	placements := h.placements.Placements()
+	for i := range gm {
+		for _, p := range placements[models.MAC(gm[i].MAC)] {
+			if string(p.Group) == gm[i].Group || (gm[i].Group == "" && p.AssignedBy == models.AssignedByDefault) {
+				gm[i].Placement = &p
+				break
+			}
+		}
+	}
+}
+
 func (h *Handler) activityHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodPost {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
@@ -101,10 +353,10 @@ func (h *Handler) activityHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if r.Method == http.MethodGet {
-		lastActiveTimes := h.monitor.GetTrafficLastActiveTimes() //  map[models.Group]map[models.MAC]time.Time, where the string is the group
+		lastActiveTimes := h.filterActivity(w, r, h.lastActiveTimes())
 
-		w.Header().Set("Content-Type", "application/json")
-		err := json.NewEncoder(w).Encode(lastActiveTimes)
+		h.setFreshnessHeaders(w, models.FreshnessActivity)
+		err := writeJSONWithETag(w, r, lastActiveTimes)
 		if err != nil {
 			h.logger.Errorf("Error encoding monitor response: %v", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -113,22 +365,88 @@ func (h *Handler) activityHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (h *Handler) usageHandler(w http.ResponseWriter, r *http.Request) {
-	// TODO: test the methods for usageHandler as we borked them before!
-	if r.Method == http.MethodGet {
-		summary := h.usageTracker.GetSummary()                   // map[string]models.TrackerSummary, where string is the device ID, which is a group
-		lastActiveTimes := h.monitor.GetTrafficLastActiveTimes() //  map[models.Group]map[models.MAC]time.Time, where the string is the group
+// filterActivity returns the last active times of the devices matching the group and name query params, where the
+// name matches a device's MAC or configured name, paged with offset and limit over the devices sorted by group and
+// MAC. It sets X-Total-Count to the number of devices matching. The map passed in is left as it is since it's cached.
+func (h *Handler) filterActivity(w http.ResponseWriter, r *http.Request, all map[models.Group]map[models.MAC]time.Time) map[models.Group]map[models.MAC]time.Time {
+	group, name := r.URL.Query().Get("group"), r.URL.Query().Get("name")
+	names := make(map[string]string)
+	if name != "" && h.groupMACsGetterSetter != nil {
+		gm, err := h.groupMACsGetterSetter.GetAllGroupMACs(h.logger)
+		if err != nil {
+			h.logger.Errorf("Error getting device names to filter activity: %v", err)
+		}
+		for _, v := range gm {
+			names[v.MAC] = v.Name
+		}
+	}
 
-		for group, v := range lastActiveTimes {
-			s, ok := summary[string(group)]
-			if ok { // if the group exists in the usage data...
-				s.LastActiveTimes = v // save the MAC last active time map.
-			} else {
-				h.logger.Errorf("monitor: group %v not found with last active data: %v", group, v)
+	type device struct {
+		group models.Group
+		mac   models.MAC
+	}
+	var devices []device
+	for g, macs := range all {
+		if group != "" && string(g) != group {
+			continue
+		}
+		for mac := range macs {
+			if matchesName(name, string(mac), names[string(mac)]) {
+				devices = append(devices, device{g, mac})
 			}
 		}
+	}
+	slices.SortFunc(devices, func(a, b device) int {
+		return cmp.Or(strings.Compare(string(a.group), string(b.group)), strings.Compare(string(a.mac), string(b.mac)))
+	})
+
+	setPageHeaders(w, len(devices))
+	start, end := parsePage(r).bounds(len(devices))
+	filtered := make(map[models.Group]map[models.MAC]time.Time)
+	for _, d := range devices[start:end] {
+		if filtered[d.group] == nil {
+			filtered[d.group] = make(map[models.MAC]time.Time)
+		}
+		filtered[d.group][d.mac] = all[d.group][d.mac]
+	}
+	return filtered
+}
+
+// usageSummary returns the usage of each group with the last active times and usage of its devices and its traffic
+// with each domain.
+func (h *Handler) usageSummary() map[string]*models.TrackerSummary {
+	summary := h.usageTracker.GetSummary() // map[string]models.TrackerSummary, where string is the device ID, which is a group
+	lastActiveTimes := h.lastActiveTimes()
+
+	for group, v := range lastActiveTimes {
+		s, ok := summary[string(group)]
+		if ok { // if the group exists in the usage data...
+			s.LastActiveTimes = v // save the MAC last active time map.
+		} else {
+			h.logger.Errorf("monitor: group %v not found with last active data: %v", group, v)
+		}
+	}
+
+	for group, v := range h.usageTracker.GetDeviceSummary() { // for each group with per-MAC usage...
+		if s, ok := summary[group]; ok {
+			s.Devices = v
+		}
+	}
+
+	for group, v := range h.monitor.GetDomainTraffic() { // for each group with traffic to known domains...
+		if s, ok := summary[string(group)]; ok {
+			s.Domains = v
+		}
+	}
+	return summary
+}
 
+func (h *Handler) usageHandler(w http.ResponseWriter, r *http.Request) {
+	// TODO: test the methods for usageHandler as we borked them before!
+	if r.Method == http.MethodGet {
+		summary := h.usageSummary()
 		w.Header().Set("Content-Type", "application/json")
+		h.setFreshnessHeaders(w, models.FreshnessActivity)
 		err := json.NewEncoder(w).Encode(summary)
 		if err != nil {
 			h.logger.Errorf("Error encoding sample summary response: %v", err)
@@ -139,6 +457,7 @@ func (h *Handler) usageHandler(w http.ResponseWriter, r *http.Request) {
 		deviceID := r.URL.Query().Get("deviceID")
 		if deviceID != "" {
 			h.usageTracker.Reset(deviceID)
+			h.audit(r, "usage.reset", deviceID, nil, nil)
 			w.WriteHeader(http.StatusOK)
 			_ = json.NewEncoder(w).Encode(map[string]string{"message": "samples reset for deviceID"})
 		}
@@ -161,15 +480,7 @@ func (h *Handler) trackerConfigHandler(w http.ResponseWriter, r *http.Request) {
 		// Flatten the tracker config.
 		flatConfig := make([]models.FlatTrackerConfig, 0) // make empty slice so we marshall at least something below
 		for k, v := range gtc {
-			flatConfig = append(flatConfig, models.FlatTrackerConfig{
-				Group:         k,
-				Retention:     v.Retention,
-				Threshold:     v.Threshold,
-				StartDayInt:   v.StartDayInt,
-				StartDuration: v.StartDuration,
-				Mode:          v.Mode,
-				ModeEndTime:   v.ModeEndTime,
-			})
+			flatConfig = append(flatConfig, control.ToFlatTrackerConfig(k, v))
 		}
 
 		w.WriteHeader(http.StatusOK)
@@ -179,30 +490,16 @@ func (h *Handler) trackerConfigHandler(w http.ResponseWriter, r *http.Request) {
 		var flatConfig []models.FlatTrackerConfig
 		if err := json.NewDecoder(r.Body).Decode(&flatConfig); err != nil {
 			h.logger.Errorf("Failed to unmarshall tracker config: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
-		}
-
-		// Convert flat config to map.
-		gtc := make(models.MapGroupTrackerConfig)
-		for _, v := range flatConfig {
-			if v.Group == "" {
-				continue
-			}
-			gtc[v.Group] = &models.TrackerConfig{
-				Retention:     v.Retention,
-				Threshold:     v.Threshold,
-				StartDayInt:   v.StartDayInt,
-				StartDuration: v.StartDuration,
-				Mode:          v.Mode,
-				ModeEndTime:   v.ModeEndTime,
-			}
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
 		}
 
-		// Save the config.
-		err := h.usageTracker.SetConfig(gtc)
+		// Save the config, keeping the groups' modes.
+		err := h.control.SaveTrackerConfig(origin(r), flatConfig)
 		if err != nil {
 			h.logger.Errorf("Failed to set tracker config: %v", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
 		}
 	} else {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
@@ -289,10 +586,10 @@ func (h *Handler) modeHandler(w http.ResponseWriter, r *http.Request) {
 		h.logger.Infof(logMsg)
 
 		// Set the pause/allow/block.
-		err = h.usageTracker.SetMode(group, time.Duration(duration)*time.Minute, models.UsageTrackerMode(intMode))
+		_, err = h.control.SetMode(origin(r), group, time.Duration(duration)*time.Minute, mode)
 		if err != nil {
 			h.logger.Errorf("Error setting block/allow timer: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			modeError(w, err)
 			return
 		}
 
@@ -309,10 +606,10 @@ func (h *Handler) modeHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Resume the usage tracker.
-		err := h.usageTracker.SetMode(group, 0, models.ModeMonitor)
+		_, err := h.control.SetMode(origin(r), group, 0, models.ModeMonitor)
 		if err != nil {
 			h.logger.Errorf("Error resetting group block/allow timer: %v", err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			modeError(w, err)
 			return
 		}
 		h.logger.Infof("Pause timer reset triggered for group %v", group)
@@ -326,6 +623,18 @@ func (h *Handler) modeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// modeError writes the response for an error setting a group's mode.
+func modeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, control.ErrInvalidChange):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, models.ErrGroupNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
 func (h *Handler) resetGroupHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
@@ -343,12 +652,51 @@ func (h *Handler) resetGroupHandler(w http.ResponseWriter, r *http.Request) {
 	// Reset the group sample data.
 	h.usageTracker.Reset(group)
 	h.logger.Infof("Reset usage for group: %v", group)
+	h.audit(r, "usage.reset", group, nil, nil)
 
 	// Respond.
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write([]byte(fmt.Sprintf("Reset group %v successfully", group)))
 }
 
+// transferHandler moves remaining time from one group to another for the current window.
+func (h *Handler) transferHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var req struct {
+			From    string `json:"from"`
+			To      string `json:"to"`
+			Minutes int    `json:"minutes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.From == "" || req.To == "" || req.Minutes <= 0 {
+			h.logger.Errorf("Invalid transfer payload: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+
+		transfer, err := h.usageTracker.TransferBudget(req.From, req.To, req.Minutes)
+		if errors.Is(err, models.ErrGroupNotFound) {
+			h.logger.Errorf("Error transferring time: %v", err)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		} else if errors.Is(err, models.ErrInsufficientBudget) {
+			h.logger.Errorf("Error transferring time: %v", err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error transferring time: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.audit(r, "budget.transfer", req.From, nil, transfer)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(transfer)
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
 func (h *Handler) dhcpHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		// Handle GET request: Retrieve DHCP configuration
@@ -361,6 +709,7 @@ func (h *Handler) dhcpHandler(w http.ResponseWriter, r *http.Request) {
 
 		// Return DHCP configuration as JSON
 		w.Header().Set("Content-Type", "application/json")
+		h.setFreshnessHeaders(w, models.FreshnessDHCP)
 		if err := json.NewEncoder(w).Encode(dhcpConfig); err != nil {
 			h.logger.Errorf("Error encoding DHCP configuration response: %v", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
@@ -375,12 +724,17 @@ func (h *Handler) dhcpHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Save DHCP configuration
+		var before json.RawMessage
+		if oldConfig, err := h.dhcpConfigGetterSetter.GetConfig(h.logger); err == nil {
+			before = audit.Snapshot(oldConfig) // snapshot now since the config is updated in place
+		}
 		err := h.dhcpConfigGetterSetter.SetConfig(h.logger, &dhcpConfig)
 		if err != nil {
 			h.logger.Errorf("Error saving DHCP configuration: %v", err)
 			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			return
 		}
+		h.audit(r, "dhcp.save", "", before, &dhcpConfig)
 
 		// Respond with success message
 		w.WriteHeader(http.StatusOK)
@@ -403,3 +757,1712 @@ func (h *Handler) ipv6Handler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
 	}
 }
+
+// telemetryHandler returns a preview of the anonymized stats payload (GET) and saves the user's opt-in choice (POST).
+func (h *Handler) telemetryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(h.telemetry.GetPreview()); err != nil {
+			h.logger.Errorf("Error encoding telemetry preview: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	} else if r.Method == http.MethodPost {
+		var req struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			h.logger.Errorf("Invalid telemetry payload: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		before := h.telemetry.GetPreview().Settings
+		if err := h.telemetry.SetEnabled(req.Enabled); err != nil {
+			h.logger.Errorf("Error saving telemetry settings: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "telemetry.save", "", audit.Snapshot(before), h.telemetry.GetPreview().Settings)
+		h.logger.Infof("Telemetry enabled set to %v", req.Enabled)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(map[string]string{"message": "telemetry settings saved successfully"})
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// healthHandler returns the service status, packet rates per queue and the decisions made for each group's packets.
+func (h *Handler) healthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		resp := struct {
+			Status          string                 `json:"status"`
+			StartTime       time.Time              `json:"startTime"`
+			Uptime          string                 `json:"uptime"`
+			PacketRates     []models.PacketRates   `json:"packetRates"`
+			PacketDecisions models.PacketDecisions `json:"packetDecisions"`
+		}{
+			Status:          "ok",
+			StartTime:       h.startTime,
+			Uptime:          formatDuration(time.Since(h.startTime)),
+			PacketRates:     h.packetStats.GetPacketRates(),
+			PacketDecisions: h.packetStats.GetPacketDecisions(),
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			h.logger.Errorf("Error encoding health status: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// apiHealthHandler returns the status of each subsystem and the overall status, which is the worst of them.
+// It responds with 503 when unhealthy so that uptime monitors can alert on the status code alone.
+func (h *Handler) apiHealthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		resp := struct {
+			Status     models.HealthStatus      `json:"status"`
+			StartTime  time.Time                `json:"startTime"`
+			Uptime     string                   `json:"uptime"`
+			Version    string                   `json:"version"`
+			Readiness  models.Readiness         `json:"readiness"`
+			Subsystems []models.SubsystemHealth `json:"subsystems"`
+		}{
+			Status:     models.HealthOK,
+			StartTime:  h.startTime,
+			Uptime:     formatDuration(time.Since(h.startTime)),
+			Version:    config.BuildVersion,
+			Readiness:  h.readiness(),
+			Subsystems: make([]models.SubsystemHealth, 0, len(h.healthCheckers)),
+		}
+		if resp.Readiness.State != models.ReadinessReady { // if nothing is being enforced yet...
+			resp.Status = models.HealthDegraded
+		}
+		for _, c := range h.healthCheckers {
+			sh := c.Health()
+			resp.Status = resp.Status.Worse(sh.Status)
+			resp.Subsystems = append(resp.Subsystems, sh)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if resp.Status == models.HealthUnhealthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			h.logger.Errorf("Error encoding health status: %v", err)
+		}
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// readiness returns the first stage of filtering that isn't ready, in the order the reporters were given, so that
+// the cause nearest the source is reported.
+func (h *Handler) readiness() models.Readiness {
+	for _, r := range h.readinessReporters {
+		if rd := r.Readiness(); rd.State != models.ReadinessReady {
+			return rd
+		}
+	}
+	return models.Readiness{State: models.ReadinessReady}
+}
+
+// freshnessHandler returns when each watcher last updated its data, marking sources that have missed their
+// expected refresh as stale.
+func (h *Handler) freshnessHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(h.freshness()); err != nil {
+			h.logger.Errorf("Error encoding freshness response: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) freshness() []models.Freshness {
+	now := time.Now()
+	resp := make([]models.Freshness, 0, len(h.freshnessSources))
+	for _, s := range h.freshnessSources {
+		f := s.Freshness()
+		f.Stale = f.IsStale(now)
+		resp = append(resp, f)
+	}
+	return resp
+}
+
+// setFreshnessHeaders sets Last-Modified to the time the watcher behind source last updated its data and
+// X-Data-Stale to whether that update is overdue. Nothing is set for unknown sources.
+func (h *Handler) setFreshnessHeaders(w http.ResponseWriter, source string) {
+	for _, f := range h.freshness() {
+		if f.Source != source {
+			continue
+		}
+		if !f.LastUpdated.IsZero() {
+			w.Header().Set("Last-Modified", f.LastUpdated.UTC().Format(http.TimeFormat))
+		}
+		w.Header().Set("X-Data-Stale", strconv.FormatBool(f.Stale))
+		return
+	}
+}
+
+// lastActiveTimes returns the monitor's last active times per group and MAC via the short-lived cache, since the
+// UI polls /usage and /activity far more often than the data changes.
+func (h *Handler) lastActiveTimes() map[models.Group]map[models.MAC]time.Time {
+	return h.activityCache.get(h.monitor.GetTrafficLastActiveTimes)
+}
+
+// metricsHandler returns packet counts and rates per queue in Prometheus text format.
+func (h *Handler) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		rates := h.packetStats.GetPacketRates()
+		var sb strings.Builder
+		sb.WriteString("# HELP tubetimeout_packets_total Total packets handled per queue.\n")
+		sb.WriteString("# TYPE tubetimeout_packets_total counter\n")
+		for _, v := range rates {
+			sb.WriteString(fmt.Sprintf("tubetimeout_packets_total{queue=\"%d\",direction=\"%s\"} %d\n", v.Queue, v.Direction, v.Total))
+		}
+		sb.WriteString("# HELP tubetimeout_packets_per_second Packets handled per second per queue.\n")
+		sb.WriteString("# TYPE tubetimeout_packets_per_second gauge\n")
+		for _, v := range rates {
+			sb.WriteString(fmt.Sprintf("tubetimeout_packets_per_second{queue=\"%d\",direction=\"%s\",window=\"1s\"} %g\n", v.Queue, v.Direction, v.PerSecond))
+			sb.WriteString(fmt.Sprintf("tubetimeout_packets_per_second{queue=\"%d\",direction=\"%s\",window=\"1m\"} %g\n", v.Queue, v.Direction, v.PerSecondAvg1m))
+		}
+		sb.WriteString("# HELP tubetimeout_verdict_latency_seconds Time from receiving packets to deciding their verdicts per queue, not counting deliberate delays.\n")
+		sb.WriteString("# TYPE tubetimeout_verdict_latency_seconds histogram\n")
+		for _, v := range h.packetStats.GetVerdictLatencies() {
+			labels := fmt.Sprintf("queue=\"%d\",direction=\"%s\"", v.Queue, v.Direction)
+			for _, b := range v.Buckets {
+				sb.WriteString(fmt.Sprintf("tubetimeout_verdict_latency_seconds_bucket{%s,le=\"%g\"} %d\n", labels, b.UpperBound.Seconds(), b.Count))
+			}
+			sb.WriteString(fmt.Sprintf("tubetimeout_verdict_latency_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, v.Count))
+			sb.WriteString(fmt.Sprintf("tubetimeout_verdict_latency_seconds_sum{%s} %g\n", labels, v.Sum.Seconds()))
+			sb.WriteString(fmt.Sprintf("tubetimeout_verdict_latency_seconds_count{%s} %d\n", labels, v.Count))
+		}
+		decisions := h.packetStats.GetPacketDecisions()
+		sb.WriteString("# HELP tubetimeout_packet_decisions_total Decisions made for each group's packets, which are only counted while simulated.\n")
+		sb.WriteString("# TYPE tubetimeout_packet_decisions_total counter\n")
+		for _, g := range decisions.Groups {
+			for _, d := range []struct {
+				name  string
+				count uint64
+			}{{"accept", g.Accepted}, {"allowlisted", g.Allowlisted}, {"drop", g.Dropped}, {"delay", g.Delayed}, {"shape", g.Shaped}} {
+				sb.WriteString(fmt.Sprintf("tubetimeout_packet_decisions_total{group=\"%s\",decision=\"%s\",simulated=\"%t\"} %d\n", g.Group, d.name, decisions.Simulated, d.count))
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte(sb.String()))
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// getAPIToken returns the API key supplied as a bearer token or the sign-in cookie set by /login.
+func getAPIToken(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	if c, err := r.Cookie(tokenCookieName); err == nil && c.Value != "" {
+		return c.Value
+	}
+	return ""
+}
+
+// getKioskToken returns the API key in the token query parameter of a GET of /kiosk, for simple displays that can't
+// set headers. URLs end up in logs and browser history, so no other route reads the query parameter.
+func getKioskToken(r *http.Request) string {
+	if r.URL.Path != "/kiosk" || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		return ""
+	}
+	return r.URL.Query().Get("token")
+}
+
+type apiKeyContextKey struct{}
+
+// requestKey returns the API key that the request was authorised with, if any.
+func requestKey(r *http.Request) (apikeys.Key, bool) {
+	key, ok := r.Context().Value(apiKeyContextKey{}).(apikeys.Key)
+	return key, ok
+}
+
+// apiKeyScope authorises requests that present an API key by the key's role. Requests without a key are passed
+// through unchanged, unless WEB_AUTH_REQUIRED is set and an admin key exists, in which case only the pages that
+// devices use are open.
+func (h *Handler) apiKeyScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, inURL := getAPIToken(r), false
+		if token == "" { // if the key isn't in a header or cookie, it may be in the kiosk's URL...
+			token = getKioskToken(r)
+			inURL = token != ""
+		}
+		if token == "" { // if this isn't an API key request...
+			if !config.Current().WebConfig.AuthRequired || publicPaths[r.URL.Path] || strings.HasPrefix(r.URL.Path, "/static/") || !h.apiKeys.HasRole(apikeys.RoleAdmin) {
+				next.ServeHTTP(w, r)
+			} else if r.URL.Path == "/" {
+				http.Redirect(w, r, "/login", http.StatusSeeOther)
+			} else {
+				http.Error(w, "API key required", http.StatusUnauthorized)
+			}
+			return
+		}
+		key, ok := h.apiKeys.Lookup(token)
+		if !ok {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		if inURL && key.Role != apikeys.RoleKiosk && key.Role != apikeys.RoleViewer { // if a key that can change things was put in a URL...
+			http.Error(w, "Only kiosk and viewer keys can be sent in the URL", http.StatusUnauthorized)
+			return
+		}
+		if !roleAllows(key.Role, r) {
+			http.Error(w, "API key is not allowed to access this endpoint", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	})
+}
+
+var (
+	// publicPaths are open without a key when auth is required, since devices and displays use them.
+	publicPaths = map[string]bool{"/my-time": true, "/api/my-time": true, "/my-time/request": true, "/api/my-time/requests": true, "/kiosk": true, "/health": true, "/login": true, "/logout": true, "/api/ui": true}
+	// adminPaths need an admin key whatever the method, since they expose secrets or the raw traffic.
+	adminPaths = map[string]bool{"/api/dns/queries": true, "/apiKeys": true, "/api/backup": true, "/api/restore": true, "/api/snapshots": true, "/api/snapshots/restore": true, "/api/capture": true, "/api/capture/pcap": true, "/api/trace": true, "/api/usage/import": true, "/api/setup": true}
+	// operatorPaths are the changes an operator can make: giving groups time, but not changing their config.
+	operatorPaths = map[string]bool{"/mode": true, "/reset": true, "/api/time-requests/approve": true, "/api/time-requests/deny": true}
+)
+
+// roleAllows returns true if the role may make the request. Viewers may read anything but the admin paths, and
+// operators may also change the operator paths.
+func roleAllows(role apikeys.Role, r *http.Request) bool {
+	p := r.URL.Path
+	switch role {
+	case apikeys.RoleAdmin:
+		return true
+	case apikeys.RoleOperator, apikeys.RoleViewer:
+		if publicPaths[p] && p != "/kiosk" {
+			return true
+		}
+		if adminPaths[p] {
+			return false
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			return true
+		}
+		return role == apikeys.RoleOperator && operatorPaths[p]
+	default: // kiosk keys...
+		return p == "/kiosk"
+	}
+}
+
+// loginHandler shows a form to sign in with an API key on GET, and on POST sets a cookie with the key so that the
+// dashboard can be used when WEB_AUTH_REQUIRED is set. Kiosk keys can't sign in.
+func (h *Handler) loginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		h.renderLogin(w, r, "")
+	} else if r.Method == http.MethodPost {
+		token := strings.TrimSpace(r.FormValue("token"))
+		key, ok := h.apiKeys.Lookup(token)
+		if !ok || key.Role == apikeys.RoleKiosk {
+			w.WriteHeader(http.StatusUnauthorized)
+			h.renderLogin(w, r, "login.keyRejected")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: tokenCookieName, Value: token, Path: "/", MaxAge: int(tokenCookieMaxAge / time.Second),
+			HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteStrictMode})
+		h.logger.Infof("API key %v signed in with role %v", key.ID, key.Role)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// logoutHandler clears the sign-in cookie.
+func (h *Handler) logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet || r.Method == http.MethodPost {
+		http.SetCookie(w, &http.Cookie{Name: tokenCookieName, Value: "", Path: "/", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteStrictMode})
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// renderLogin shows the sign-in page with the string of the message key, if any.
+func (h *Handler) renderLogin(w http.ResponseWriter, r *http.Request, message string) {
+	l := h.locale(w, r)
+	tmpl, err := parseTemplate("login.html", l)
+	if err != nil {
+		http.Error(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	if message != "" {
+		message = l.T(message)
+	}
+	if err = tmpl.Execute(w, struct{ Lang, Message string }{l.Lang, message}); err != nil {
+		h.logger.Errorf("Error rendering login page: %v", err)
+	}
+}
+
+// kioskHandler returns the usage summary and mode countdown for the group of the supplied API key only.
+func (h *Handler) kioskHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		key, ok := requestKey(r)
+		if !ok {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return
+		}
+		resp, err := h.groupSummary(key.Group)
+		if err != nil {
+			h.logger.Errorf("Error getting kiosk summary: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(resp); err != nil {
+			h.logger.Errorf("Error encoding kiosk summary: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// myTimeHandler shows the requesting device's group its remaining time and mode, so that kids can check without the
+// admin UI. The device is found by the source IP of the request rather than anything it sends, so that it can't ask for
+// another group's time. /api/my-time returns the summary as JSON and /my-time renders it as a page.
+func (h *Handler) myTimeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	asJSON := strings.HasPrefix(r.URL.Path, "/api/")
+	l := locales[fallbackLang]
+	if !asJSON { // if the page is shown, rather than the JSON returned...
+		l = h.locale(w, r)
+	}
+	fnError := func(key string, code int) {
+		if asJSON {
+			http.Error(w, l.T(key), code)
+			return
+		}
+		w.WriteHeader(code)
+		h.renderMyTime(w, l, MyTimeData{Error: l.T(key)})
+	}
+
+	group, ok := h.deviceGroup(r)
+	if !ok {
+		fnError("myTime.noGroup", http.StatusNotFound)
+		return
+	}
+	resp, err := h.groupSummary(group)
+	if err != nil {
+		h.logger.Errorf("Error getting device portal summary: %v", err)
+		fnError("error.internal", http.StatusInternalServerError)
+		return
+	}
+	if asJSON {
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(resp); err != nil {
+			h.logger.Errorf("Error encoding device portal summary: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+		return
+	}
+	td := MyTimeData{Summary: resp, ModeName: modeName(l, resp.Mode)}
+	if h.timeRequests != nil {
+		td.CanRequest = true
+		if requests := h.timeRequests.Requests(group, ""); len(requests) > 0 {
+			td.Request = &requests[0]
+			td.CanRequest = requests[0].Status != models.TimeRequestPending
+		}
+	}
+	h.renderMyTime(w, l, td)
+}
+
+// myTimeRequestHandler lets the requesting device ask for more time for its group, found by source IP as for
+// myTimeHandler, and see its group's requests. /my-time/request takes the form on the /my-time page and redirects
+// back to it, while /api/my-time/requests takes and returns JSON.
+func (h *Handler) myTimeRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if h.timeRequests == nil {
+		http.Error(w, "Time requests are not available", http.StatusServiceUnavailable)
+		return
+	}
+	asJSON := strings.HasPrefix(r.URL.Path, "/api/")
+	mac, group, ok := h.deviceMACGroup(r)
+	if !ok {
+		http.Error(w, "This device isn't in a group", http.StatusNotFound)
+		return
+	}
+	var resp any
+	if r.Method == http.MethodGet && asJSON {
+		resp = h.timeRequests.Requests(group, "")
+	} else if r.Method == http.MethodPost {
+		var req struct {
+			Minutes int    `json:"minutes"`
+			Reason  string `json:"reason"`
+		}
+		if asJSON {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+		} else {
+			req.Minutes, _ = strconv.Atoi(r.FormValue("minutes"))
+			req.Reason = r.FormValue("reason")
+		}
+		tr, err := h.timeRequests.Request(group, mac, req.Minutes, req.Reason)
+		if errors.Is(err, timerequest.ErrInvalidRequest) {
+			http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		} else if errors.Is(err, timerequest.ErrRequestPending) {
+			http.Error(w, "A request is already waiting for an answer", http.StatusConflict)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error saving time request for group %v: %v", group, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "timeRequest.create", string(group), nil, tr)
+		if !asJSON {
+			http.Redirect(w, r, "/my-time", http.StatusSeeOther)
+			return
+		}
+		resp = tr
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.logger.Errorf("Error encoding time requests: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) renderMyTime(w http.ResponseWriter, l *Locale, td MyTimeData) {
+	tmpl, err := parseTemplate("my-time.html", l)
+	if err != nil {
+		http.Error(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	td.Lang = l.Lang
+	if err = tmpl.Execute(w, td); err != nil {
+		h.logger.Errorf("Error rendering device portal: %v", err)
+	}
+}
+
+// blockPageHandler explains to a device whose plain HTTP was redirected by the NFT rules that its group has used its
+// time, rather than leaving the browser to time out. The page mustn't be cached as it's shown in place of other sites.
+func (h *Handler) blockPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	l := h.locale(w, r)
+	td := MyTimeData{Lang: l.Lang}
+	if group, ok := h.deviceGroup(r); ok { // if the device's usage can be shown too...
+		resp, err := h.groupSummary(group)
+		if err != nil {
+			h.logger.Errorf("Error getting block page summary: %v", err)
+		} else {
+			td.Summary, td.ModeName = resp, modeName(l, resp.Mode)
+		}
+	}
+
+	tmpl, err := parseTemplate("block-page.html", l)
+	if err != nil {
+		http.Error(w, "Error loading template", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if err = tmpl.Execute(w, td); err != nil {
+		h.logger.Errorf("Error rendering block page: %v", err)
+	}
+}
+
+// deviceGroup returns the group of the device that sent the request, using its source IP to find its MAC.
+func (h *Handler) deviceGroup(r *http.Request) (models.Group, bool) {
+	_, group, ok := h.deviceMACGroup(r)
+	return group, ok
+}
+
+// deviceMACGroup returns the MAC and group of the device that sent the request.
+func (h *Handler) deviceMACGroup(r *http.Request) (models.MAC, models.Group, bool) {
+	if h.devices == nil || h.placements == nil {
+		return "", "", false
+	}
+	sourceIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		sourceIP = r.RemoteAddr
+	}
+	mac, ok := h.devices.GetMAC(models.Ip(sourceIP))
+	if !ok {
+		return "", "", false
+	}
+	placements := h.placements.Placements()[mac]
+	if len(placements) == 0 {
+		return "", "", false
+	}
+	return mac, placements[0].Group, true
+}
+
+// modeName returns the name of the mode as shown by the pages in the locale.
+func modeName(l *Locale, m models.UsageTrackerMode) string {
+	switch m {
+	case models.ModeAllow:
+		return l.T("mode.allowed")
+	case models.ModeBlock:
+		return l.T("mode.blocked")
+	default:
+		return l.T("mode.monitoring")
+	}
+}
+
+// groupSummary returns the read-only usage summary and mode countdown of a single group.
+func (h *Handler) groupSummary(group models.Group) (models.KioskSummary, error) {
+	resp := models.KioskSummary{Group: group, Mode: models.ModeMonitor}
+
+	gtc, err := h.usageTracker.GetConfig()
+	if err != nil {
+		return resp, fmt.Errorf("failed to get tracker config: %w", err)
+	}
+	if cfg, ok := gtc[group]; ok && cfg != nil {
+		resp.ThresholdMinutes = int(cfg.ThresholdOn(time.Now().Weekday()).Minutes())
+	}
+
+	if s, ok := h.usageTracker.GetSummary()[string(group)]; ok { // if the group has usage data...
+		resp.UsedMinutes = s.Used
+		resp.ThresholdMinutes = s.Threshold // include time transferred in or out and carried over
+		resp.Percentage = s.Percentage
+		if s.BreakEndTime != nil { // if the group is on a forced break...
+			resp.BreakEndTime = s.BreakEndTime
+			resp.BreakRemainingSeconds = max(int(time.Until(*s.BreakEndTime).Seconds()), 0)
+		}
+	}
+	resp.RemainingMinutes = max(resp.ThresholdMinutes-resp.UsedMinutes, 0)
+
+	modeData, err := h.usageTracker.GetModeEndTime(string(group))
+	if err != nil && !errors.Is(err, models.ErrGroupNotFound) {
+		return resp, fmt.Errorf("failed to get group mode end time: %w", err)
+	} else if err == nil {
+		resp.Mode = modeData.Mode
+		resp.ModeEndTime = modeData.ModeEndTime
+	}
+	return resp, nil
+}
+
+// apiKeysHandler lists (GET), creates (POST) and deletes (DELETE) group-scoped API keys.
+func (h *Handler) apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(h.apiKeys.List()); err != nil {
+			h.logger.Errorf("Error encoding API keys: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	} else if r.Method == http.MethodPost {
+		var req struct {
+			Name  string       `json:"name"`
+			Group models.Group `json:"group"`
+			Role  apikeys.Role `json:"role"` // Role defaults to a kiosk key for the group.
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Group == "" && req.Role == "") {
+			h.logger.Errorf("Invalid API key payload: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		if req.Role == "" {
+			req.Role = apikeys.RoleKiosk
+		}
+		if req.Group != "" {
+			req.Group = models.Group(models.NewGroup(string(req.Group)))
+		}
+		key, token, err := h.apiKeys.Create(req.Name, req.Group, req.Role)
+		if errors.Is(err, apikeys.ErrInvalidRole) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error creating API key: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.logger.Infof("API key %v created with role %v", key.ID, key.Role)
+		h.audit(r, "apiKey.create", key.ID, nil, key)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(struct {
+			Key   apikeys.Key `json:"key"`
+			Token string      `json:"token"` // the token is only returned once.
+		}{key, token})
+	} else if r.Method == http.MethodDelete {
+		id := r.URL.Query().Get("id")
+		err := h.apiKeys.Delete(id)
+		if errors.Is(err, apikeys.ErrKeyNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error deleting API key: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.logger.Infof("API key %v deleted", id)
+		h.audit(r, "apiKey.delete", id, nil, nil)
+
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(map[string]string{"message": "API key deleted successfully"})
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// audit records a config change made by the request.
+// Failures are logged rather than returned so that the change itself isn't reported as failed.
+func (h *Handler) audit(r *http.Request, action, target string, before json.RawMessage, after any) {
+	o := origin(r)
+	err := h.auditLog.Record(audit.Entry{
+		SourceIP: o.SourceIP,
+		Actor:    o.Actor,
+		Action:   action,
+		Target:   target,
+		Before:   before,
+		After:    audit.Snapshot(after),
+	})
+	if err != nil {
+		h.logger.Errorf("Error recording audit entry for %v: %v", action, err)
+	}
+}
+
+// origin returns where the request came from, for the audit log.
+func origin(r *http.Request) control.Origin {
+	sourceIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		sourceIP = r.RemoteAddr
+	}
+	var actor string
+	if key, ok := requestKey(r); ok {
+		actor = fmt.Sprintf("%v (%v)", key.Name, key.ID)
+	}
+	return control.Origin{SourceIP: sourceIP, Actor: actor}
+}
+
+// auditHandler returns a page of audit entries, newest first, using the offset and limit query params.
+func (h *Handler) auditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+		if err != nil || offset < 0 {
+			offset = 0
+		}
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 50
+		}
+		limit = min(limit, 500)
+
+		entries, total, err := h.auditLog.Page(offset, limit)
+		if err != nil {
+			h.logger.Errorf("Error reading audit log: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(struct {
+			Total   int           `json:"total"`
+			Offset  int           `json:"offset"`
+			Limit   int           `json:"limit"`
+			Entries []audit.Entry `json:"entries"`
+		}{total, offset, limit, entries})
+		if err != nil {
+			h.logger.Errorf("Error encoding audit log: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// modeHistoryHandler returns the recent times a group was blocked or allowed, newest first, with the reasons.
+func (h *Handler) modeHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		group := r.URL.Query().Get("group")
+		if group == "" {
+			http.Error(w, "Missing group", http.StatusBadRequest)
+			return
+		}
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit <= 0 {
+			limit = 50
+		}
+		limit = min(limit, 500)
+
+		transitions, err := h.usageTracker.ModeHistory(models.Group(group), limit)
+		if errors.Is(err, models.ErrHistoryDisabled) {
+			http.Error(w, "Mode history is disabled", http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error reading mode history: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(transitions); err != nil {
+			h.logger.Errorf("Error encoding mode history: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// usageSamplesHandler returns whether a group was active in each sample of its current window.
+func (h *Handler) usageSamplesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		group := r.URL.Query().Get("group")
+		if group == "" {
+			http.Error(w, "Missing group", http.StatusBadRequest)
+			return
+		}
+
+		samples, err := h.usageTracker.GetSamples(group)
+		if errors.Is(err, models.ErrGroupNotFound) {
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error reading usage samples: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		h.setFreshnessHeaders(w, models.FreshnessActivity)
+		if err = json.NewEncoder(w).Encode(samples); err != nil {
+			h.logger.Errorf("Error encoding usage samples: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// usageExportHandler downloads the samples of every group, and every device if they're tracked, as a versioned JSON
+// document that /api/usage/import accepts.
+func (h *Handler) usageExportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		b, err := h.usageTracker.ExportSamples()
+		if err != nil {
+			h.logger.Errorf("Error exporting usage samples: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tubetimeout-usage-%v.json"`, time.Now().Format("20060102-150405")))
+		_, _ = w.Write(b)
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// usageImportHandler replaces the samples of every group with those exported by /api/usage/export, from this or an
+// older version of the app.
+func (h *Handler) usageImportHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRestoreSize))
+		if err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		err = h.usageTracker.ImportSamples(b)
+		if errors.Is(err, models.ErrInvalidSamples) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error importing usage samples: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "usage.import", "", nil, nil) // the samples are too big to keep in the audit log.
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"message": "usage imported successfully"})
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// reportsHandler returns the weekly usage reports of every group, or the one given, as JSON or downloaded as HTML.
+// The week ends now, or at the end of the date given as YYYY-MM-DD.
+func (h *Handler) reportsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if h.reports == nil {
+			http.Error(w, "Reports are disabled", http.StatusServiceUnavailable)
+			return
+		}
+		end := time.Now()
+		date := end.Format(time.DateOnly)
+		if s := r.URL.Query().Get("end"); s != "" {
+			day, err := time.ParseInLocation(time.DateOnly, s, time.Local)
+			if err != nil {
+				http.Error(w, "Invalid end date, expected YYYY-MM-DD", http.StatusBadRequest)
+				return
+			}
+			end, date = day.AddDate(0, 0, 1), s
+		}
+
+		var reports []models.UsageReport
+		if group := r.URL.Query().Get("group"); group != "" {
+			rep, err := h.reports.Report(models.Group(group), end)
+			if errors.Is(err, models.ErrGroupNotFound) {
+				http.Error(w, "No usage recorded for group", http.StatusNotFound)
+				return
+			} else if err != nil {
+				h.logger.Errorf("Error making report for group %v: %v", group, err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			reports = []models.UsageReport{rep}
+		} else {
+			reports = h.reports.Reports(end)
+		}
+
+		if r.URL.Query().Get("format") == "html" {
+			var buf bytes.Buffer
+			if err := report.WriteHTML(&buf, reports); err != nil {
+				h.logger.Errorf("Error rendering reports: %v", err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tubetimeout-report-%v.html"`, date))
+			_, _ = w.Write(buf.Bytes())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(reports); err != nil {
+			h.logger.Errorf("Error encoding reports: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// bandwidthHandler returns the recent traffic of every device, busiest first, or of the device given by ?mac=.
+func (h *Handler) bandwidthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.bandwidth == nil {
+		http.Error(w, "Bandwidth is not available", http.StatusServiceUnavailable)
+		return
+	}
+	var retval any
+	if mac := r.URL.Query().Get("mac"); mac != "" {
+		bw, ok := h.bandwidth.GetBandwidth(models.MAC(models.NewMAC(mac)))
+		if !ok {
+			http.Error(w, "No recent traffic for device", http.StatusNotFound)
+			return
+		}
+		retval = bw
+	} else {
+		retval = h.bandwidth.GetAllBandwidth()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(retval); err != nil {
+		h.logger.Errorf("Error encoding bandwidth: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// lookupHandler returns the groups of the device with the ip or mac query param, the domains tracked for each group,
+// and whether its packets are being accepted or throttled.
+func (h *Handler) lookupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.membership == nil {
+		http.Error(w, "Group lookup is not available", http.StatusServiceUnavailable)
+		return
+	}
+	var retval models.Lookup
+	var groups []models.Group
+	if ip := r.URL.Query().Get("ip"); ip != "" {
+		if net.ParseIP(ip) == nil {
+			http.Error(w, "Invalid IP", http.StatusBadRequest)
+			return
+		}
+		retval.Ips = []models.Ip{models.Ip(ip)}
+		if h.devices != nil {
+			retval.MAC, _ = h.devices.GetMAC(models.Ip(ip))
+		}
+		groups = h.membership.GroupsForIP(models.Ip(ip))
+	} else if mac := r.URL.Query().Get("mac"); mac != "" {
+		if _, err := net.ParseMAC(strings.ReplaceAll(mac, "-", ":")); err != nil {
+			http.Error(w, "Invalid MAC", http.StatusBadRequest)
+			return
+		}
+		retval.MAC = models.MAC(models.NewMAC(mac))
+		retval.Ips = h.membership.IpsForMAC(retval.MAC)
+		groups = h.membership.GroupsForMAC(retval.MAC)
+	} else {
+		http.Error(w, "Missing ip or mac", http.StatusBadRequest)
+		return
+	}
+	if retval.Ips == nil {
+		retval.Ips = []models.Ip{}
+	}
+
+	var summary map[string]*models.TrackerSummary
+	if h.usageTracker != nil {
+		summary = h.usageTracker.GetSummary()
+	}
+	retval.Groups = make([]models.GroupLookup, 0, len(groups))
+	for _, group := range groups {
+		g := models.GroupLookup{Group: group, Domains: h.membership.DomainsForGroup(group), Verdict: models.VerdictAccept}
+		if g.Domains == nil {
+			g.Domains = []models.Domain{}
+		}
+		var policy *models.PacketPolicy
+		if h.usageTracker != nil {
+			g.Tracker = summary[string(group)]
+			if mode, err := h.usageTracker.GetModeEndTime(string(group)); err == nil {
+				g.Mode = &mode
+			}
+			g.Exceeded = h.usageTracker.HasExceededThreshold(string(group))
+			policy = h.usageTracker.PacketPolicy(string(group))
+		}
+		if g.Exceeded {
+			g.Verdict = models.VerdictThrottle
+		}
+		g.Policy = config.Current().FilterConfig.GroupPacketPolicy(policy)
+		retval.Groups = append(retval.Groups, g)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(retval); err != nil {
+		h.logger.Errorf("Error encoding lookup: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// profilesHandler returns the configuration profiles and the one that is active, or replaces the profiles.
+func (h *Handler) profilesHandler(w http.ResponseWriter, r *http.Request) {
+	if h.profiles == nil {
+		http.Error(w, "Profiles are not available", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method == http.MethodGet {
+		h.writeProfileState(w, h.profiles.State())
+	} else if r.Method == http.MethodPost {
+		var profiles []models.Profile
+		if err := json.NewDecoder(r.Body).Decode(&profiles); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		before := audit.Snapshot(h.profiles.State().Profiles)
+		state, err := h.profiles.SetProfiles(profiles)
+		if err != nil {
+			h.writeProfileError(w, err)
+			return
+		}
+		h.audit(r, "profiles.set", "", before, state.Profiles)
+		h.writeProfileState(w, state)
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// activeProfileHandler activates a profile, optionally until a time, with POST or deactivates it with DELETE.
+func (h *Handler) activeProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if h.profiles == nil {
+		http.Error(w, "Profiles are not available", http.StatusServiceUnavailable)
+		return
+	}
+	var state models.ProfileState
+	var err error
+	before := h.profiles.State()
+	if r.Method == http.MethodPost {
+		var req struct {
+			Name  string    `json:"name"`
+			Until time.Time `json:"until"`
+		}
+		if err = json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if state, err = h.profiles.Activate(req.Name, req.Until); err == nil {
+			h.audit(r, "profile.activate", req.Name, audit.Snapshot(before.Active), state.Active)
+		}
+	} else if r.Method == http.MethodDelete {
+		if state, err = h.profiles.Deactivate(); err == nil {
+			h.audit(r, "profile.deactivate", before.Active, audit.Snapshot(before.Active), state.Active)
+		}
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		h.writeProfileError(w, err)
+		return
+	}
+	h.writeProfileState(w, state)
+}
+
+func (h *Handler) writeProfileState(w http.ResponseWriter, state models.ProfileState) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		h.logger.Errorf("Error encoding profiles: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) writeProfileError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, profile.ErrInvalidProfile):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, profile.ErrProfileNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		h.logger.Errorf("Error changing profiles: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// killSwitchHandler returns the state of the kill switch or turns it on or off.
+func (h *Handler) killSwitchHandler(w http.ResponseWriter, r *http.Request) {
+	if h.killSwitch == nil {
+		http.Error(w, "Kill switch is not available", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method == http.MethodGet {
+		h.writeKillSwitchState(w, h.killSwitch.State())
+	} else if r.Method == http.MethodPost {
+		var req struct {
+			On *bool `json:"on"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.On == nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		before := audit.Snapshot(h.killSwitch.State())
+		state := h.killSwitch.Set(*req.On, killswitch.SourceAPI)
+		h.audit(r, "killSwitch.set", "", before, state)
+		h.writeKillSwitchState(w, state)
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) writeKillSwitchState(w http.ResponseWriter, state models.KillSwitchState) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		h.logger.Errorf("Error encoding kill switch state: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// timeRequestsHandler lists the requests for more time, newest first, optionally only those with the status query
+// parameter, e.g. pending.
+func (h *Handler) timeRequestsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if h.timeRequests == nil {
+			http.Error(w, "Time requests are not available", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		requests := h.timeRequests.Requests(models.Group(r.URL.Query().Get("group")), r.URL.Query().Get("status"))
+		if err := json.NewEncoder(w).Encode(requests); err != nil {
+			h.logger.Errorf("Error encoding time requests: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// timeRequestDecisionHandler approves or denies a pending request for more time, going by the path. Approving gives
+// the minutes asked for, or those given, as a bonus, or allows the group for them if allow is set.
+func (h *Handler) timeRequestDecisionHandler(w http.ResponseWriter, r *http.Request) {
+	if h.timeRequests == nil {
+		http.Error(w, "Time requests are not available", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID      string `json:"id"`
+		Minutes int    `json:"minutes"`
+		Allow   bool   `json:"allow"`
+		Note    string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	var tr models.TimeRequest
+	var err error
+	action := "timeRequest.deny"
+	if strings.HasSuffix(r.URL.Path, "/approve") {
+		action = "timeRequest.approve"
+		tr, err = h.timeRequests.Approve(req.ID, req.Minutes, req.Allow, req.Note)
+	} else {
+		tr, err = h.timeRequests.Deny(req.ID, req.Note)
+	}
+	if errors.Is(err, timerequest.ErrRequestNotFound) {
+		http.Error(w, "Time request not found", http.StatusNotFound)
+		return
+	} else if errors.Is(err, timerequest.ErrRequestDecided) {
+		http.Error(w, "Time request has already been decided", http.StatusConflict)
+		return
+	} else if errors.Is(err, timerequest.ErrInvalidRequest) {
+		http.Error(w, "Invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	} else if err != nil {
+		h.logger.Errorf("Error deciding time request %v: %v", req.ID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	h.audit(r, action, string(tr.Group), nil, tr)
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(tr); err != nil {
+		h.logger.Errorf("Error encoding time request: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// logLevelHandler returns the log levels or changes the level of a package, or the overall level if no package is
+// given. An empty level removes a package's own level. Changes last until the app config is reloaded or restarted.
+func (h *Handler) logLevelHandler(w http.ResponseWriter, r *http.Request) {
+	if h.logLevels == nil {
+		http.Error(w, "Log levels are not available", http.StatusServiceUnavailable)
+		return
+	}
+	var resp models.LogLevels
+	if r.Method == http.MethodGet {
+		resp = h.logLevels.State()
+	} else if r.Method == http.MethodPost {
+		var req struct {
+			Package string `json:"package"`
+			Level   string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		before := audit.Snapshot(h.logLevels.State())
+		state, err := h.logLevels.Set(req.Package, req.Level)
+		if errors.Is(err, config.ErrInvalidLogLevel) {
+			http.Error(w, "Invalid log level", http.StatusBadRequest)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error setting log level: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "logLevel.set", req.Package, before, state)
+		resp = state
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.logger.Errorf("Error encoding log levels: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// nftDiagnosticsHandler returns the chains, rules and set contents of the NFT table as the kernel has them.
+func (h *Handler) nftDiagnosticsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if h.nftDiagnostics == nil {
+			http.Error(w, "NFT diagnostics are not available", http.StatusServiceUnavailable)
+			return
+		}
+		snap, err := h.nftDiagnostics.Snapshot()
+		if err != nil {
+			h.logger.Errorf("Error reading NFT rules: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err = json.NewEncoder(w).Encode(snap); err != nil {
+			h.logger.Errorf("Error encoding NFT rules: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// captureHandler returns the state of each group's packet capture, or starts or stops the capture for a group.
+func (h *Handler) captureHandler(w http.ResponseWriter, r *http.Request) {
+	if h.packetCapture == nil {
+		http.Error(w, "Packet capture is not available", http.StatusServiceUnavailable)
+		return
+	}
+	var resp any
+	if r.Method == http.MethodGet {
+		resp = h.packetCapture.Captures()
+	} else if r.Method == http.MethodPost {
+		var req struct {
+			Group   models.Group `json:"group"`
+			Enabled bool         `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Group == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		state, err := h.packetCapture.SetCapture(req.Group, req.Enabled)
+		if err != nil {
+			h.logger.Errorf("Error setting packet capture for group %v: %v", req.Group, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "capture.set", string(req.Group), nil, state)
+		resp = state
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.logger.Errorf("Error encoding packet capture: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// capturePcapHandler downloads the packets captured for a group as a pcap file, e.g. to open in Wireshark.
+func (h *Handler) capturePcapHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		if h.packetCapture == nil {
+			http.Error(w, "Packet capture is not available", http.StatusServiceUnavailable)
+			return
+		}
+		group := r.URL.Query().Get("group")
+		if group == "" {
+			http.Error(w, "Missing group", http.StatusBadRequest)
+			return
+		}
+		var buf bytes.Buffer
+		err := h.packetCapture.WritePcap(&buf, models.Group(group))
+		if errors.Is(err, models.ErrCaptureNotFound) {
+			http.Error(w, "No capture for group", http.StatusNotFound)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error writing packet capture for group %v: %v", group, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="capture-%v.pcap"`, group))
+		_, _ = w.Write(buf.Bytes())
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// traceHandler lists the device traces, or downloads one as JSON with ?mac. POST starts tracing the device with ?mac
+// for ?seconds, 60 by default, and DELETE stops its trace early.
+func (h *Handler) traceHandler(w http.ResponseWriter, r *http.Request) {
+	if h.tracer == nil {
+		http.Error(w, "Tracing is not available", http.StatusServiceUnavailable)
+		return
+	}
+	q := r.URL.Query()
+	var mac models.MAC
+	if s := q.Get("mac"); s != "" {
+		if _, err := net.ParseMAC(strings.ReplaceAll(s, "-", ":")); err != nil {
+			http.Error(w, "Invalid MAC", http.StatusBadRequest)
+			return
+		}
+		mac = models.MAC(models.NewMAC(s))
+	} else if r.Method != http.MethodGet {
+		http.Error(w, "Missing mac", http.StatusBadRequest)
+		return
+	}
+	var resp any
+	switch r.Method {
+	case http.MethodGet:
+		if mac == "" {
+			resp = h.tracer.Traces()
+			break
+		}
+		var buf bytes.Buffer
+		err := h.tracer.WriteTrace(&buf, mac)
+		if errors.Is(err, models.ErrTraceNotFound) {
+			http.Error(w, "No trace for device", http.StatusNotFound)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error writing trace for device %v: %v", mac, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="trace-%v.json"`, mac))
+		_, _ = w.Write(buf.Bytes())
+		return
+	case http.MethodPost:
+		seconds := 60
+		if s := q.Get("seconds"); s != "" {
+			var err error
+			if seconds, err = strconv.Atoi(s); err != nil {
+				http.Error(w, "Invalid seconds", http.StatusBadRequest)
+				return
+			}
+		}
+		state, err := h.tracer.StartTrace(mac, time.Duration(seconds)*time.Second)
+		if errors.Is(err, models.ErrInvalidTrace) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error starting trace for device %v: %v", mac, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "trace.start", string(mac), nil, state)
+		resp = state
+	case http.MethodDelete:
+		state, err := h.tracer.StopTrace(mac)
+		if errors.Is(err, models.ErrTraceNotFound) {
+			http.Error(w, "No trace for device", http.StatusNotFound)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error stopping trace for device %v: %v", mac, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "trace.stop", string(mac), nil, state)
+		resp = state
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.logger.Errorf("Error encoding trace: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+// resolutionPauseHandler lists, pauses and resumes the resolution of domain groups' IPs.
+// POST freezes or clears a group's IPs for the given minutes, or the configured default, and DELETE resumes it.
+func (h *Handler) resolutionPauseHandler(w http.ResponseWriter, r *http.Request) {
+	if h.resolutionPauser == nil {
+		http.Error(w, "Resolution pausing is not available", http.StatusServiceUnavailable)
+		return
+	}
+	var resp any
+	if r.Method == http.MethodGet {
+		resp = h.resolutionPauser.ResolutionPauses()
+	} else if r.Method == http.MethodPost {
+		var req struct {
+			Group   models.Group               `json:"group"`
+			Mode    models.ResolutionPauseMode `json:"mode"`
+			Minutes int                        `json:"minutes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Group == "" || req.Minutes < 0 ||
+			(req.Mode != models.ResolutionFreeze && req.Mode != models.ResolutionClear) {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		p, err := h.resolutionPauser.PauseResolution(req.Group, req.Mode, time.Duration(req.Minutes)*time.Minute)
+		if errors.Is(err, models.ErrGroupNotFound) {
+			http.Error(w, "Group not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error pausing resolution for group %v: %v", req.Group, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "resolution.pause", string(req.Group), nil, p)
+		resp = p
+	} else if r.Method == http.MethodDelete {
+		group := r.URL.Query().Get("group")
+		if group == "" {
+			http.Error(w, "Missing group", http.StatusBadRequest)
+			return
+		}
+		err := h.resolutionPauser.ResumeResolution(models.Group(group))
+		if errors.Is(err, models.ErrGroupNotFound) {
+			http.Error(w, "Group is not paused", http.StatusNotFound)
+			return
+		} else if err != nil { // if the pause was removed but resolving failed...
+			h.logger.Errorf("Error resuming resolution for group %v: %v", group, err)
+		}
+		h.audit(r, "resolution.resume", group, nil, nil)
+		resp = h.resolutionPauser.ResolutionPauses()
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.logger.Errorf("Error encoding resolution pauses: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) backupHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="tubetimeout-backup-%v.tar.gz"`, time.Now().Format("20060102-150405")))
+		if err := h.backup.WriteBackup(w); err != nil {
+			h.logger.Errorf("Error writing backup: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "config.backup", "", nil, nil)
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// restoreHandler accepts a backup archive either as the raw request body or as the "archive" field of a
+// multipart form, then restarts the app so that every component loads the restored config.
+func (h *Handler) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		r.Body = http.MaxBytesReader(w, r.Body, maxRestoreSize)
+		var archive io.Reader = r.Body
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+			f, _, err := r.FormFile("archive")
+			if err != nil {
+				http.Error(w, "Missing archive file", http.StatusBadRequest)
+				return
+			}
+			defer f.Close()
+			archive = f
+		}
+
+		restored, err := h.backup.RestoreBackup(archive)
+		if errors.Is(err, config.ErrInvalidBackup) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error restoring backup: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "config.restore", "", nil, restored)
+		h.logger.Infof("Restored config files %v, restarting...", restored)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Restored   []string `json:"restored"`
+			Restarting bool     `json:"restarting"`
+		}{restored, true})
+
+		go func() {
+			time.Sleep(restartDelay) // give the response time to reach the client.
+			fnRequestRestart()
+		}()
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// snapshotsHandler lists the snapshots, or downloads the one named by the "name" parameter, on GET, and takes a
+// snapshot now on POST, e.g. before making big changes.
+func (h *Handler) snapshotsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.snapshots == nil {
+		http.Error(w, "Snapshots are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	var resp any
+	if r.Method == http.MethodGet {
+		if name := r.URL.Query().Get("name"); name != "" {
+			h.downloadSnapshot(w, name)
+			return
+		}
+		list, err := h.snapshots.Snapshots()
+		if err != nil {
+			h.logger.Errorf("Error listing snapshots: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		if list == nil {
+			list = []config.SnapshotInfo{}
+		}
+		resp = list
+	} else if r.Method == http.MethodPost {
+		info, err := h.snapshots.Snapshot()
+		if err != nil {
+			h.logger.Errorf("Error taking snapshot: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "config.snapshot", info.Name, nil, nil)
+		resp = info
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.logger.Errorf("Error encoding snapshots: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) downloadSnapshot(w http.ResponseWriter, name string) {
+	f, err := h.snapshots.OpenSnapshot(name)
+	if errors.Is(err, config.ErrSnapshotNotFound) {
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		h.logger.Errorf("Error opening snapshot %v: %v", name, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v"`, name))
+	if _, err = io.Copy(w, f); err != nil {
+		h.logger.Errorf("Error sending snapshot %v: %v", name, err)
+	}
+}
+
+// snapshotRestoreHandler restores the named snapshot and then restarts the app, as restoreHandler does for an
+// uploaded backup.
+func (h *Handler) snapshotRestoreHandler(w http.ResponseWriter, r *http.Request) {
+	if h.snapshots == nil {
+		http.Error(w, "Snapshots are not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method == http.MethodPost {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		restored, err := h.snapshots.RestoreSnapshot(req.Name)
+		if errors.Is(err, config.ErrSnapshotNotFound) {
+			http.Error(w, "Snapshot not found", http.StatusNotFound)
+			return
+		} else if errors.Is(err, config.ErrInvalidBackup) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error restoring snapshot %v: %v", req.Name, err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "config.snapshotRestore", req.Name, nil, restored)
+		h.logger.Infof("Restored config files %v from snapshot %v, restarting...", restored, req.Name)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Restored   []string `json:"restored"`
+			Restarting bool     `json:"restarting"`
+		}{restored, true})
+
+		go func() {
+			time.Sleep(restartDelay) // give the response time to reach the client.
+			fnRequestRestart()
+		}()
+	} else {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+	}
+}
+
+// dnsQueriesHandler returns the lookups made via the DNS forwarder, latest first, optionally only those of the device
+// with the "client" IP or whose name contains "name". The "offset" and "limit" params page through them.
+func (h *Handler) dnsQueriesHandler(w http.ResponseWriter, r *http.Request) {
+	if h.dnsForwarder == nil {
+		http.Error(w, "The DNS forwarder is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	queries := slices.DeleteFunc(h.dnsForwarder.Queries(models.Ip(r.URL.Query().Get("client"))), func(q models.DNSQuery) bool {
+		return !matchesName(name, string(q.Name))
+	})
+	start, end := parsePage(r).bounds(len(queries))
+	setPageHeaders(w, len(queries))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(append([]models.DNSQuery{}, queries[start:end]...)); err != nil {
+		h.logger.Errorf("Error encoding DNS queries: %v", err)
+	}
+}
+
+// dnsPolicyHandler returns the DNS policy of each group on GET and replaces it on POST.
+func (h *Handler) dnsPolicyHandler(w http.ResponseWriter, r *http.Request) {
+	if h.dnsForwarder == nil {
+		http.Error(w, "The DNS forwarder is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var m models.MapGroupDNSPolicy
+		if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+			h.logger.Errorf("Invalid request DNS policy payload: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		before := h.dnsForwarder.Policy()
+		if err := h.dnsForwarder.SetPolicy(m); errors.Is(err, dnsforward.ErrInvalidPolicy) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error saving DNS policy: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "dnsPolicy.save", "", audit.Snapshot(before), h.dnsForwarder.Policy())
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.dnsForwarder.Policy()); err != nil {
+		h.logger.Errorf("Error encoding DNS policy: %v", err)
+	}
+}
+
+// exemptionsHandler returns the devices whose traffic the firewall leaves alone on GET and replaces them on POST.
+func (h *Handler) exemptionsHandler(w http.ResponseWriter, r *http.Request) {
+	if h.exemptions == nil {
+		http.Error(w, "The exempt devices are not available", http.StatusServiceUnavailable)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var exemptions []models.Exemption
+		if err := json.NewDecoder(r.Body).Decode(&exemptions); err != nil {
+			h.logger.Errorf("Invalid request exemptions payload: %v", err)
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		before := h.exemptions.Exemptions()
+		if err := h.exemptions.SetExemptions(exemptions); errors.Is(err, exempt.ErrInvalidExemption) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			h.logger.Errorf("Error saving exemptions: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		h.audit(r, "exemptions.save", "", audit.Snapshot(before), h.exemptions.Exemptions())
+	default:
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.exemptions.Exemptions()); err != nil {
+		h.logger.Errorf("Error encoding exemptions: %v", err)
+	}
+}
@@ -0,0 +1,2104 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/apikeys"
+	"relloyd/tubetimeout/audit"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/dhcp"
+	"relloyd/tubetimeout/dnsforward"
+	"relloyd/tubetimeout/exempt"
+	"relloyd/tubetimeout/models"
+	"relloyd/tubetimeout/profile"
+	"relloyd/tubetimeout/timerequest"
+)
+
+type mockPacketStats struct {
+	rates     []models.PacketRates
+	latencies []models.VerdictLatency
+	decisions models.PacketDecisions
+}
+
+func (m *mockPacketStats) GetPacketRates() []models.PacketRates {
+	return m.rates
+}
+
+func (m *mockPacketStats) GetVerdictLatencies() []models.VerdictLatency {
+	return m.latencies
+}
+
+func (m *mockPacketStats) GetPacketDecisions() models.PacketDecisions {
+	return m.decisions
+}
+
+func newTestPacketStats() *mockPacketStats {
+	return &mockPacketStats{rates: []models.PacketRates{
+		{Queue: 100, Direction: models.Egress, Total: 42, PerSecond: 5, PerSecondAvg1m: 2.5, LatencyP50: 0.5, LatencyP99: 10},
+	}, latencies: []models.VerdictLatency{
+		{Queue: 100, Direction: models.Egress, Count: 42, Sum: 21 * time.Millisecond, Buckets: []models.LatencyBucket{
+			{UpperBound: 500 * time.Microsecond, Count: 30},
+			{UpperBound: 10 * time.Millisecond, Count: 41},
+		}},
+	}, decisions: models.PacketDecisions{Simulated: true, Groups: []models.GroupDecisions{{Group: "kids", Accepted: 10, Dropped: 4}}}}
+}
+
+func TestHealthHandler(t *testing.T) {
+	h := &Handler{logger: config.MustGetLogger(), packetStats: newTestPacketStats()}
+	rec := httptest.NewRecorder()
+	h.healthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp struct {
+		Status          string                 `json:"status"`
+		PacketRates     []models.PacketRates   `json:"packetRates"`
+		PacketDecisions models.PacketDecisions `json:"packetDecisions"`
+	}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, "ok", resp.Status)
+	assert.Equal(t, newTestPacketStats().rates, resp.PacketRates)
+	assert.Equal(t, newTestPacketStats().decisions, resp.PacketDecisions)
+}
+
+type mockHealthChecker struct {
+	health models.SubsystemHealth
+}
+
+func (m *mockHealthChecker) Health() models.SubsystemHealth {
+	return m.health
+}
+
+func TestAPIHealthHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		statuses   []models.HealthStatus
+		wantStatus models.HealthStatus
+		wantCode   int
+	}{
+		{"All ok", []models.HealthStatus{models.HealthOK, models.HealthOK}, models.HealthOK, http.StatusOK},
+		{"Degraded", []models.HealthStatus{models.HealthOK, models.HealthDegraded}, models.HealthDegraded, http.StatusOK},
+		{"Unhealthy wins", []models.HealthStatus{models.HealthUnhealthy, models.HealthDegraded}, models.HealthUnhealthy, http.StatusServiceUnavailable},
+		{"No checks", nil, models.HealthOK, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var checkers []HealthChecker
+			for i, s := range tt.statuses {
+				checkers = append(checkers, &mockHealthChecker{health: models.SubsystemHealth{Name: fmt.Sprintf("sub%d", i), Status: s}})
+			}
+			h := &Handler{logger: config.MustGetLogger(), healthCheckers: checkers}
+			rec := httptest.NewRecorder()
+			h.apiHealthHandler(rec, httptest.NewRequest(http.MethodGet, "/api/health", nil))
+
+			assert.Equal(t, tt.wantCode, rec.Code)
+			var resp struct {
+				Status     models.HealthStatus      `json:"status"`
+				Subsystems []models.SubsystemHealth `json:"subsystems"`
+			}
+			assert.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+			assert.Equal(t, tt.wantStatus, resp.Status)
+			assert.Len(t, resp.Subsystems, len(tt.statuses))
+		})
+	}
+
+	h := &Handler{logger: config.MustGetLogger()}
+	rec := httptest.NewRecorder()
+	h.apiHealthHandler(rec, httptest.NewRequest(http.MethodPost, "/api/health", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+type mockReadinessReporter struct {
+	readiness models.Readiness
+}
+
+func (m *mockReadinessReporter) Readiness() models.Readiness {
+	return m.readiness
+}
+
+func TestAPIHealthHandler_Readiness(t *testing.T) {
+	h := &Handler{logger: config.MustGetLogger(), readinessReporters: []ReadinessReporter{
+		&mockReadinessReporter{models.Readiness{State: models.ReadinessReady}},
+		&mockReadinessReporter{models.Readiness{State: models.ReadinessWaitingForDestinations, Cause: "DNS resolution is failing for all tracked domains"}},
+		&mockReadinessReporter{models.Readiness{State: models.ReadinessWaitingForDestinations, Cause: "no destination IPs have been resolved for the NFT sets"}},
+	}}
+	rec := httptest.NewRecorder()
+	h.apiHealthHandler(rec, httptest.NewRequest(http.MethodGet, "/api/health", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code, "expected waiting at startup not to fail the health check")
+	var resp struct {
+		Status    models.HealthStatus `json:"status"`
+		Readiness models.Readiness    `json:"readiness"`
+	}
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, models.HealthDegraded, resp.Status)
+	assert.Equal(t, "DNS resolution is failing for all tracked domains", resp.Readiness.Cause, "expected the first stage that isn't ready to be reported")
+}
+
+type mockFreshnessSource struct {
+	freshness models.Freshness
+}
+
+func (m *mockFreshnessSource) Freshness() models.Freshness {
+	return m.freshness
+}
+
+type mockMonitor struct {
+	calls    int
+	activity map[models.Group]map[models.MAC]time.Time
+}
+
+func (m *mockMonitor) GetTrafficLastActiveTimes() map[models.Group]map[models.MAC]time.Time {
+	m.calls++
+	if m.activity != nil {
+		return m.activity
+	}
+	return map[models.Group]map[models.MAC]time.Time{"kids": {"aa-bb-cc-dd-ee-ff": time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)}}
+}
+
+func (m *mockMonitor) GetDomainTraffic() map[models.Group]map[models.Domain]*models.DomainTraffic {
+	return map[models.Group]map[models.Domain]*models.DomainTraffic{"kids": {"googlevideo.com": {IngressBytes: 90000, EgressBytes: 3000}}}
+}
+
+func TestFreshnessHandler(t *testing.T) {
+	updated := time.Now().Add(-30 * time.Second)
+	h := &Handler{logger: config.MustGetLogger(), freshnessSources: []FreshnessSource{
+		&mockFreshnessSource{models.Freshness{Source: models.FreshnessSourceIpGroups, LastUpdated: updated, Interval: time.Minute}},
+		&mockFreshnessSource{models.Freshness{Source: models.FreshnessActivity, LastUpdated: time.Now().Add(-5 * time.Minute), Interval: time.Minute}},
+		&mockFreshnessSource{models.Freshness{Source: models.FreshnessDHCP, Interval: 15 * time.Second}},
+	}}
+	rec := httptest.NewRecorder()
+	h.freshnessHandler(rec, httptest.NewRequest(http.MethodGet, "/api/freshness", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp []models.Freshness
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Len(t, resp, 3)
+	assert.False(t, resp[0].Stale, "expected data updated within its interval to be fresh")
+	assert.True(t, resp[1].Stale, "expected data that missed its refresh to be stale")
+	assert.True(t, resp[2].Stale, "expected data that was never updated to be stale")
+
+	rec = httptest.NewRecorder()
+	h.freshnessHandler(rec, httptest.NewRequest(http.MethodPost, "/api/freshness", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestActivityHandler_FreshnessAndCache(t *testing.T) {
+	updated := time.Now().Add(-3 * time.Minute)
+	m := &mockMonitor{}
+	h := &Handler{
+		logger:           config.MustGetLogger(),
+		monitor:          m,
+		activityCache:    newReadThroughCache[map[models.Group]map[models.MAC]time.Time](time.Hour),
+		freshnessSources: []FreshnessSource{&mockFreshnessSource{models.Freshness{Source: models.FreshnessActivity, LastUpdated: updated, Interval: time.Minute}}},
+	}
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.activityHandler(rec, httptest.NewRequest(http.MethodGet, "/activity", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, updated.UTC().Format(http.TimeFormat), rec.Header().Get("Last-Modified"))
+		assert.Equal(t, "true", rec.Header().Get("X-Data-Stale"))
+		assert.Contains(t, rec.Body.String(), "aa-bb-cc-dd-ee-ff")
+	}
+	assert.Equal(t, 1, m.calls, "expected the second request to be served from the cache")
+}
+
+func TestMetricsHandler(t *testing.T) {
+	h := &Handler{logger: config.MustGetLogger(), packetStats: newTestPacketStats()}
+	rec := httptest.NewRecorder()
+	h.metricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, `tubetimeout_packets_total{queue="100",direction="out"} 42`)
+	assert.Contains(t, body, `tubetimeout_packets_per_second{queue="100",direction="out",window="1s"} 5`)
+	assert.Contains(t, body, `tubetimeout_packets_per_second{queue="100",direction="out",window="1m"} 2.5`)
+	assert.Contains(t, body, `tubetimeout_verdict_latency_seconds_bucket{queue="100",direction="out",le="0.0005"} 30`)
+	assert.Contains(t, body, `tubetimeout_verdict_latency_seconds_bucket{queue="100",direction="out",le="+Inf"} 42`)
+	assert.Contains(t, body, `tubetimeout_verdict_latency_seconds_sum{queue="100",direction="out"} 0.021`)
+	assert.Contains(t, body, `tubetimeout_verdict_latency_seconds_count{queue="100",direction="out"} 42`)
+	assert.Contains(t, body, `tubetimeout_packet_decisions_total{group="kids",decision="drop",simulated="true"} 4`)
+}
+
+type mockUsageTracker struct {
+	UsageTracker
+	summary map[string]*models.TrackerSummary
+	cfg     models.MapGroupTrackerConfig
+	modes   map[string]models.TrackerMode
+	history []models.ModeTransition
+	samples map[string]models.TrackerSamples
+}
+
+func (m *mockUsageTracker) GetSamples(id string) (models.TrackerSamples, error) {
+	samples, ok := m.samples[id]
+	if !ok {
+		return models.TrackerSamples{}, models.ErrGroupNotFound
+	}
+	return samples, nil
+}
+
+func (m *mockUsageTracker) ModeHistory(group models.Group, limit int) ([]models.ModeTransition, error) {
+	if m.history == nil {
+		return nil, models.ErrHistoryDisabled
+	}
+	var result []models.ModeTransition
+	for _, e := range m.history {
+		if e.Group == group && len(result) < limit {
+			result = append(result, e)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockUsageTracker) GetSummary() map[string]*models.TrackerSummary {
+	return m.summary
+}
+
+func (m *mockUsageTracker) GetConfig() (models.MapGroupTrackerConfig, error) {
+	return m.cfg, nil
+}
+
+func (m *mockUsageTracker) GetModeEndTime(id string) (models.TrackerMode, error) {
+	mode, ok := m.modes[id]
+	if !ok {
+		return models.TrackerMode{}, models.ErrGroupNotFound
+	}
+	return mode, nil
+}
+
+type mockAPIKeyStore struct {
+	APIKeyStore
+	keys map[string]apikeys.Key // keys by token
+}
+
+func (m *mockAPIKeyStore) Lookup(token string) (apikeys.Key, bool) {
+	k, ok := m.keys[token]
+	if ok && k.Role == "" {
+		k.Role = apikeys.RoleKiosk
+	}
+	return k, ok
+}
+
+func (m *mockAPIKeyStore) HasRole(role apikeys.Role) bool {
+	for _, k := range m.keys {
+		if k.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAPIKeyScope(t *testing.T) {
+	h := &Handler{logger: config.MustGetLogger(), apiKeys: &mockAPIKeyStore{keys: map[string]apikeys.Key{"good": {ID: "1", Group: "kids"}}}}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name     string
+		path     string
+		header   string
+		expected int
+	}{
+		{name: "No key passes through", path: "/groups", expected: http.StatusOK},
+		{name: "Bad key is rejected", path: "/kiosk", header: "Bearer bad", expected: http.StatusUnauthorized},
+		{name: "Key can't access admin endpoints", path: "/groups", header: "Bearer good", expected: http.StatusForbidden},
+		{name: "Key can't manage keys", path: "/apiKeys", header: "Bearer good", expected: http.StatusForbidden},
+		{name: "Key can access kiosk", path: "/kiosk", header: "Bearer good", expected: http.StatusOK},
+		{name: "Key can be supplied as a query param", path: "/kiosk?token=good", expected: http.StatusOK},
+		{name: "Query param is only read by the kiosk", path: "/groups?token=good", expected: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			h.apiKeyScope(next).ServeHTTP(rec, req)
+			assert.Equal(t, tt.expected, rec.Code)
+		})
+	}
+}
+
+func TestAPIKeyScope_Roles(t *testing.T) {
+	h := &Handler{logger: config.MustGetLogger(), apiKeys: &mockAPIKeyStore{keys: map[string]apikeys.Key{
+		"kiosk":    {ID: "1", Group: "kids"},
+		"viewer":   {ID: "2", Role: apikeys.RoleViewer},
+		"operator": {ID: "3", Role: apikeys.RoleOperator},
+		"admin":    {ID: "4", Role: apikeys.RoleAdmin},
+	}}}
+	var gotKey apikeys.Key
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey, _ = requestKey(r)
+		w.WriteHeader(http.StatusOK)
+	})
+	orig := config.AppCfg.WebConfig.AuthRequired
+	config.AppCfg.WebConfig.AuthRequired = true
+	t.Cleanup(func() { config.AppCfg.WebConfig.AuthRequired = orig })
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		cookie   bool
+		query    bool
+		expected int
+	}{
+		{name: "No key is rejected when auth is required", method: http.MethodGet, path: "/groups", expected: http.StatusUnauthorized},
+		{name: "No key is sent to sign in from the dashboard", method: http.MethodGet, path: "/", expected: http.StatusSeeOther},
+		{name: "No key can use my-time", method: http.MethodGet, path: "/my-time", expected: http.StatusOK},
+		{name: "No key can load static files", method: http.MethodGet, path: "/static/style.css", expected: http.StatusOK},
+		{name: "Viewer can read", method: http.MethodGet, path: "/groups", token: "viewer", expected: http.StatusOK},
+		{name: "Viewer can't change modes", method: http.MethodPost, path: "/mode", token: "viewer", expected: http.StatusForbidden},
+		{name: "Viewer can't download backups", method: http.MethodGet, path: "/api/backup", token: "viewer", expected: http.StatusForbidden},
+		{name: "Operator can change modes", method: http.MethodPost, path: "/mode", token: "operator", expected: http.StatusOK},
+		{name: "Operator can approve time requests", method: http.MethodPost, path: "/api/time-requests/approve", token: "operator", expected: http.StatusOK},
+		{name: "Operator can't change DHCP", method: http.MethodPost, path: "/dhcp", token: "operator", expected: http.StatusForbidden},
+		{name: "Operator can't change schedules", method: http.MethodPost, path: "/trackerConfig", token: "operator", expected: http.StatusForbidden},
+		{name: "Operator can't list API keys", method: http.MethodGet, path: "/apiKeys", token: "operator", expected: http.StatusForbidden},
+		{name: "Admin can change DHCP", method: http.MethodPost, path: "/dhcp", token: "admin", expected: http.StatusOK},
+		{name: "Admin can sign in with a cookie", method: http.MethodPost, path: "/dhcp", token: "admin", cookie: true, expected: http.StatusOK},
+		{name: "Kiosk can't read the dashboard", method: http.MethodGet, path: "/groups", token: "kiosk", expected: http.StatusForbidden},
+		{name: "Kiosk key can be sent in the URL", method: http.MethodGet, path: "/kiosk", token: "kiosk", query: true, expected: http.StatusOK},
+		{name: "Viewer key can be sent in the URL", method: http.MethodGet, path: "/kiosk", token: "viewer", query: true, expected: http.StatusOK},
+		{name: "Admin key can't be sent in the URL", method: http.MethodGet, path: "/kiosk", token: "admin", query: true, expected: http.StatusUnauthorized},
+		{name: "URL key is ignored off the kiosk", method: http.MethodGet, path: "/groups", token: "viewer", query: true, expected: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotKey = apikeys.Key{}
+			path := tt.path
+			if tt.query {
+				path += "?token=" + tt.token
+			}
+			req := httptest.NewRequest(tt.method, path, nil)
+			if tt.cookie {
+				req.AddCookie(&http.Cookie{Name: tokenCookieName, Value: tt.token})
+			} else if tt.token != "" && !tt.query {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.apiKeyScope(next).ServeHTTP(rec, req)
+			assert.Equal(t, tt.expected, rec.Code)
+			if rec.Code == http.StatusOK && tt.token != "" {
+				assert.Equal(t, apikeys.Role(tt.token), gotKey.Role, "expected the key to be passed to the handler")
+			}
+		})
+	}
+}
+
+func TestLoginHandler(t *testing.T) {
+	h := &Handler{logger: config.MustGetLogger(), apiKeys: &mockAPIKeyStore{keys: map[string]apikeys.Key{
+		"kiosk": {ID: "1", Group: "kids"},
+		"admin": {ID: "4", Role: apikeys.RoleAdmin},
+	}}}
+
+	rec := httptest.NewRecorder()
+	h.loginHandler(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `name="token"`)
+
+	for token, expected := range map[string]int{"admin": http.StatusSeeOther, "kiosk": http.StatusUnauthorized, "bad": http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("token="+token))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec = httptest.NewRecorder()
+		h.loginHandler(rec, req)
+		assert.Equal(t, expected, rec.Code, "token %v", token)
+		if expected == http.StatusSeeOther {
+			assert.Contains(t, rec.Header().Get("Set-Cookie"), tokenCookieName+"="+token)
+		} else {
+			assert.Empty(t, rec.Header().Get("Set-Cookie"))
+		}
+	}
+}
+
+func TestKioskHandler(t *testing.T) {
+	modeEnd := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	h := &Handler{
+		logger:  config.MustGetLogger(),
+		apiKeys: &mockAPIKeyStore{keys: map[string]apikeys.Key{"good": {ID: "1", Group: "kids"}}},
+		usageTracker: &mockUsageTracker{
+			summary: map[string]*models.TrackerSummary{
+				"kids":   {Used: 45, Total: 100, Percentage: 75, Threshold: 60},
+				"adults": {Used: 10, Total: 100, Percentage: 10},
+			},
+			cfg:   models.MapGroupTrackerConfig{"kids": {Threshold: 60 * time.Minute}},
+			modes: map[string]models.TrackerMode{"kids": {Mode: models.ModeAllow, ModeEndTime: modeEnd}},
+		},
+	}
+
+	kiosk := h.apiKeyScope(http.HandlerFunc(h.kioskHandler))
+
+	// Missing key.
+	rec := httptest.NewRecorder()
+	kiosk.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/kiosk", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Only the key's group is returned.
+	rec = httptest.NewRecorder()
+	kiosk.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/kiosk?token=good", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp models.KioskSummary
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, models.KioskSummary{
+		Group:            "kids",
+		UsedMinutes:      45,
+		ThresholdMinutes: 60,
+		RemainingMinutes: 15,
+		Percentage:       75,
+		Mode:             models.ModeAllow,
+		ModeEndTime:      modeEnd,
+	}, resp)
+}
+
+type mockDeviceLookup map[models.Ip]models.MAC
+
+func (m mockDeviceLookup) GetMAC(ip models.Ip) (models.MAC, bool) {
+	mac, ok := m[ip]
+	return mac, ok
+}
+
+func TestMyTimeHandler(t *testing.T) {
+	h := &Handler{
+		logger: config.MustGetLogger(),
+		usageTracker: &mockUsageTracker{
+			summary: map[string]*models.TrackerSummary{
+				"kids":   {Used: 45, Total: 100, Percentage: 75, Threshold: 60},
+				"adults": {Used: 10, Total: 100, Percentage: 10},
+			},
+			cfg: models.MapGroupTrackerConfig{"kids": {Threshold: 60 * time.Minute}},
+		},
+		devices:    mockDeviceLookup{"192.168.1.20": "aa:bb:cc:dd:ee:ff", "192.168.1.30": "11:22:33:44:55:66"},
+		placements: mockPlacementSource{"aa:bb:cc:dd:ee:ff": {{Group: "kids", AssignedBy: models.AssignedByManual}}},
+	}
+
+	// Only the requesting device's group is returned.
+	req := httptest.NewRequest(http.MethodGet, "/api/my-time?group=adults", nil)
+	req.RemoteAddr = "192.168.1.20:51234"
+	rec := httptest.NewRecorder()
+	h.myTimeHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resp models.KioskSummary
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&resp))
+	assert.Equal(t, models.Group("kids"), resp.Group)
+	assert.Equal(t, 15, resp.RemainingMinutes)
+
+	req = httptest.NewRequest(http.MethodGet, "/my-time", nil)
+	req.RemoteAddr = "192.168.1.20:51234"
+	rec = httptest.NewRecorder()
+	h.myTimeHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "<strong>15</strong> of 60 minutes left today")
+	assert.Contains(t, rec.Body.String(), "Tracking time")
+
+	// Devices that aren't in a group or aren't known are told so.
+	for _, ip := range []string{"192.168.1.30", "192.168.1.40"} {
+		req = httptest.NewRequest(http.MethodGet, "/api/my-time", nil)
+		req.RemoteAddr = ip + ":51234"
+		rec = httptest.NewRecorder()
+		h.myTimeHandler(rec, req)
+		assert.Equal(t, http.StatusNotFound, rec.Code, ip)
+	}
+}
+
+func TestBlockPageHandler(t *testing.T) {
+	h := &Handler{
+		logger: config.MustGetLogger(),
+		usageTracker: &mockUsageTracker{
+			summary: map[string]*models.TrackerSummary{"kids": {Used: 60, Total: 100, Percentage: 100, Threshold: 60}},
+			cfg:     models.MapGroupTrackerConfig{"kids": {Threshold: 60 * time.Minute}},
+		},
+		devices:    mockDeviceLookup{"192.168.1.20": "aa:bb:cc:dd:ee:ff"},
+		placements: mockPlacementSource{"aa:bb:cc:dd:ee:ff": {{Group: "kids", AssignedBy: models.AssignedByManual}}},
+	}
+
+	// A request redirected from another site.
+	req := httptest.NewRequest(http.MethodGet, "http://www.youtube.com/watch?v=abc", nil)
+	req.RemoteAddr = "192.168.1.20:51234"
+	rec := httptest.NewRecorder()
+	h.blockPageHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	assert.Contains(t, rec.Body.String(), "You've used <strong>60</strong> of 60 minutes")
+
+	req = httptest.NewRequest(http.MethodGet, "http://www.youtube.com/", nil)
+	req.RemoteAddr = "192.168.1.40:51234"
+	rec = httptest.NewRecorder()
+	h.blockPageHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, "expected a device that isn't known to still see the page")
+	assert.Contains(t, rec.Body.String(), "used all of your time")
+	assert.NotContains(t, rec.Body.String(), "minutes")
+
+	rec = httptest.NewRecorder()
+	h.blockPageHandler(rec, httptest.NewRequest(http.MethodPost, "http://www.youtube.com/", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+type mockAuditLog struct {
+	entries []audit.Entry
+}
+
+func (m *mockAuditLog) Record(e audit.Entry) error {
+	m.entries = append(m.entries, e)
+	return nil
+}
+
+func (m *mockAuditLog) Page(offset, limit int) ([]audit.Entry, int, error) {
+	return m.entries, len(m.entries), nil
+}
+
+type mockResetUsageTracker struct {
+	UsageTracker
+	reset string
+}
+
+func (m *mockResetUsageTracker) Reset(id string) {
+	m.reset = id
+}
+
+func TestResetGroupHandler_Audited(t *testing.T) {
+	al := &mockAuditLog{}
+	ut := &mockResetUsageTracker{}
+	h := &Handler{logger: config.MustGetLogger(), usageTracker: ut, auditLog: al}
+
+	req := httptest.NewRequest(http.MethodGet, "/reset?group=kids", nil)
+	req.RemoteAddr = "192.168.1.20:51234"
+	rec := httptest.NewRecorder()
+	h.resetGroupHandler(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "kids", ut.reset)
+	if assert.Len(t, al.entries, 1) {
+		assert.Equal(t, "usage.reset", al.entries[0].Action)
+		assert.Equal(t, "kids", al.entries[0].Target)
+		assert.Equal(t, "192.168.1.20", al.entries[0].SourceIP)
+	}
+}
+
+type mockTransferUsageTracker struct {
+	UsageTracker
+	err error
+}
+
+func (m *mockTransferUsageTracker) TransferBudget(from, to string, minutes int) (models.BudgetTransfer, error) {
+	if m.err != nil {
+		return models.BudgetTransfer{}, m.err
+	}
+	return models.BudgetTransfer{From: models.Group(from), To: models.Group(to), Minutes: minutes}, nil
+}
+
+func TestTransferHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		err      error
+		expected int
+	}{
+		{name: "Transfer ok", body: `{"from":"tablet","to":"tv","minutes":30}`, expected: http.StatusOK},
+		{name: "Bad payload", body: `{"from":"tablet","minutes":30}`, expected: http.StatusBadRequest},
+		{name: "Unknown group", body: `{"from":"tablet","to":"tv","minutes":30}`, err: models.ErrGroupNotFound, expected: http.StatusNotFound},
+		{name: "Not enough time", body: `{"from":"tablet","to":"tv","minutes":30}`, err: models.ErrInsufficientBudget, expected: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			al := &mockAuditLog{}
+			h := &Handler{logger: config.MustGetLogger(), usageTracker: &mockTransferUsageTracker{err: tt.err}, auditLog: al}
+			rec := httptest.NewRecorder()
+			h.transferHandler(rec, httptest.NewRequest(http.MethodPost, "/transfer", strings.NewReader(tt.body)))
+			assert.Equal(t, tt.expected, rec.Code)
+			if tt.expected == http.StatusOK {
+				assert.Len(t, al.entries, 1, "expected the transfer to be recorded")
+			} else {
+				assert.Empty(t, al.entries, "expected failed transfers not to be recorded")
+			}
+		})
+	}
+}
+
+type mockConfigBackup struct {
+	ConfigBackup
+	err error
+}
+
+func (m *mockConfigBackup) RestoreBackup(r io.Reader) ([]string, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return []string{"group-macs.yaml"}, nil
+}
+
+func TestRestoreHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		expected    int
+		wantRestart bool
+	}{
+		{name: "Restore ok", expected: http.StatusOK, wantRestart: true},
+		{name: "Invalid archive", err: fmt.Errorf("%w: missing manifest", config.ErrInvalidBackup), expected: http.StatusBadRequest},
+		{name: "Write failure", err: errors.New("disk full"), expected: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restarted := make(chan struct{}, 1)
+			orig := fnRequestRestart
+			fnRequestRestart = func() { restarted <- struct{}{} }
+			defer func() { fnRequestRestart = orig }()
+
+			al := &mockAuditLog{}
+			h := &Handler{logger: config.MustGetLogger(), backup: &mockConfigBackup{err: tt.err}, auditLog: al}
+			rec := httptest.NewRecorder()
+			h.restoreHandler(rec, httptest.NewRequest(http.MethodPost, "/api/restore", strings.NewReader("archive")))
+			assert.Equal(t, tt.expected, rec.Code)
+
+			if tt.wantRestart {
+				select {
+				case <-restarted:
+				case <-time.After(3 * restartDelay):
+					t.Fatal("expected a restart to be requested")
+				}
+				assert.Len(t, al.entries, 1, "expected the restore to be recorded")
+			} else {
+				assert.Empty(t, al.entries, "expected failed restores not to be recorded")
+			}
+		})
+	}
+}
+
+type mockSnapshots struct {
+	restoreErr error
+	taken      int
+}
+
+func (m *mockSnapshots) Snapshot() (config.SnapshotInfo, error) {
+	m.taken++
+	return config.SnapshotInfo{Name: "tubetimeout-snapshot-20240301-030000.tar.gz", Size: 10}, nil
+}
+
+func (m *mockSnapshots) Snapshots() ([]config.SnapshotInfo, error) {
+	return []config.SnapshotInfo{{Name: "tubetimeout-snapshot-20240301-030000.tar.gz", Size: 10}}, nil
+}
+
+func (m *mockSnapshots) OpenSnapshot(name string) (io.ReadCloser, error) {
+	if name != "tubetimeout-snapshot-20240301-030000.tar.gz" {
+		return nil, config.ErrSnapshotNotFound
+	}
+	return io.NopCloser(strings.NewReader("archive")), nil
+}
+
+func (m *mockSnapshots) RestoreSnapshot(name string) ([]string, error) {
+	if m.restoreErr != nil {
+		return nil, m.restoreErr
+	}
+	return []string{"group-macs.yaml"}, nil
+}
+
+func TestSnapshotsHandler(t *testing.T) {
+	al := &mockAuditLog{}
+	ss := &mockSnapshots{}
+	h := &Handler{logger: config.MustGetLogger(), snapshots: ss, auditLog: al}
+
+	rec := httptest.NewRecorder()
+	h.snapshotsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/snapshots", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "tubetimeout-snapshot-20240301-030000.tar.gz")
+
+	rec = httptest.NewRecorder()
+	h.snapshotsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/snapshots?name=tubetimeout-snapshot-20240301-030000.tar.gz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "archive", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	h.snapshotsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/snapshots?name=../env", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	h.snapshotsHandler(rec, httptest.NewRequest(http.MethodPost, "/api/snapshots", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 1, ss.taken)
+	assert.Len(t, al.entries, 1, "expected the snapshot to be recorded")
+
+	rec = httptest.NewRecorder()
+	(&Handler{logger: config.MustGetLogger()}).snapshotsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/snapshots", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestSnapshotRestoreHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		err         error
+		expected    int
+		wantRestart bool
+	}{
+		{name: "Restore ok", body: `{"name":"tubetimeout-snapshot-20240301-030000.tar.gz"}`, expected: http.StatusOK, wantRestart: true},
+		{name: "Missing name", body: `{}`, expected: http.StatusBadRequest},
+		{name: "Not found", body: `{"name":"other"}`, err: config.ErrSnapshotNotFound, expected: http.StatusNotFound},
+		{name: "Invalid archive", body: `{"name":"broken"}`, err: fmt.Errorf("%w: missing manifest", config.ErrInvalidBackup), expected: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			restarted := make(chan struct{}, 1)
+			orig := fnRequestRestart
+			fnRequestRestart = func() { restarted <- struct{}{} }
+			defer func() { fnRequestRestart = orig }()
+
+			al := &mockAuditLog{}
+			h := &Handler{logger: config.MustGetLogger(), snapshots: &mockSnapshots{restoreErr: tt.err}, auditLog: al}
+			rec := httptest.NewRecorder()
+			h.snapshotRestoreHandler(rec, httptest.NewRequest(http.MethodPost, "/api/snapshots/restore", strings.NewReader(tt.body)))
+			assert.Equal(t, tt.expected, rec.Code)
+
+			if tt.wantRestart {
+				select {
+				case <-restarted:
+				case <-time.After(3 * restartDelay):
+					t.Fatal("expected a restart to be requested")
+				}
+				assert.Len(t, al.entries, 1, "expected the restore to be recorded")
+			} else {
+				assert.Empty(t, al.entries, "expected failed restores not to be recorded")
+			}
+		})
+	}
+}
+
+type mockGroupMACs struct {
+	GroupMACsGroupGetterSetter
+	gm []config.FlatGroupMAC
+}
+
+func (m *mockGroupMACs) GetAllGroupMACs(_ *zap.SugaredLogger) ([]config.FlatGroupMAC, error) {
+	return m.gm, nil
+}
+
+type mockPlacementSource map[models.MAC][]models.Placement
+
+func (m mockPlacementSource) Placements() map[models.MAC][]models.Placement {
+	return m
+}
+
+func TestGroupMACHandler_Placements(t *testing.T) {
+	since := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	h := &Handler{
+		logger: config.MustGetLogger(),
+		groupMACsGetterSetter: &mockGroupMACs{gm: []config.FlatGroupMAC{
+			{Group: "kids", MAC: "aa"},
+			{Group: "teens", MAC: "aa"},
+			{MAC: "bb"},
+			{MAC: "cc"},
+		}},
+		placements: mockPlacementSource{
+			"aa": {{Group: "teens", AssignedBy: models.AssignedByManual, Since: since}, {Group: "kids", AssignedBy: models.AssignedByManual}},
+			"bb": {{Group: "default", AssignedBy: models.AssignedByDefault, Since: since}},
+		},
+	}
+	rec := httptest.NewRecorder()
+	h.groupMACHandler(rec, httptest.NewRequest(http.MethodGet, "/groups", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var got []config.FlatGroupMAC
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+	if assert.Len(t, got, 4) {
+		assert.Equal(t, models.Group("kids"), got[0].Placement.Group)
+		assert.Equal(t, &models.Placement{Group: "teens", AssignedBy: models.AssignedByManual, Since: since}, got[1].Placement)
+		assert.Equal(t, &models.Placement{Group: "default", AssignedBy: models.AssignedByDefault, Since: since}, got[2].Placement)
+		assert.Nil(t, got[3].Placement, "expected no placement for a device that hasn't been seen")
+	}
+}
+
+func TestGroupMACHandler_FilterPageAndETag(t *testing.T) {
+	h := &Handler{
+		logger: config.MustGetLogger(),
+		groupMACsGetterSetter: &mockGroupMACs{gm: []config.FlatGroupMAC{
+			{MAC: "ff"},
+			{Group: "teens", MAC: "dd", Name: "Laptop"},
+			{Group: "kids", MAC: "cc", Name: "Tablet"},
+			{Group: "kids", MAC: "aa", Name: "Phone"},
+			{Group: "kids", MAC: "bb", Name: "phone 2"},
+		}},
+	}
+	get := func(target, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		h.groupMACHandler(rec, req)
+		return rec
+	}
+	macs := func(rec *httptest.ResponseRecorder) []string {
+		var got []config.FlatGroupMAC
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+		var macs []string
+		for _, v := range got {
+			macs = append(macs, v.MAC)
+		}
+		return macs
+	}
+
+	rec := get("/groups", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "5", rec.Header().Get("X-Total-Count"))
+	etag := rec.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, []string{"aa", "bb", "cc", "dd", "ff"}, macs(rec), "expected grouped devices first, sorted by group and MAC")
+
+	rec = get("/groups", etag)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	assert.Equal(t, http.StatusNotModified, get("/groups", `"other", W/`+etag).Code, "expected weak and listed ETags to match")
+
+	rec = get("/groups?group=kids&name=PHONE", etag)
+	assert.Equal(t, http.StatusOK, rec.Code, "expected a different list to have a different ETag")
+	assert.Equal(t, "2", rec.Header().Get("X-Total-Count"))
+	assert.Equal(t, []string{"aa", "bb"}, macs(rec))
+
+	rec = get("/groups?offset=1&limit=2", "")
+	assert.Equal(t, "5", rec.Header().Get("X-Total-Count"), "expected the total to count every matching device")
+	assert.Equal(t, []string{"bb", "cc"}, macs(rec))
+	assert.Empty(t, macs(get("/groups?offset=10", "")))
+}
+
+func TestActivityHandler_FilterAndPage(t *testing.T) {
+	active := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	all := map[models.Group]map[models.MAC]time.Time{
+		"kids":  {"AA-00-00-00-00-01": active, "BB-00-00-00-00-01": active, "CC-00-00-00-00-01": active},
+		"teens": {"DD-00-00-00-00-01": active},
+	}
+	h := &Handler{
+		logger:                config.MustGetLogger(),
+		monitor:               &mockMonitor{activity: all},
+		groupMACsGetterSetter: &mockGroupMACs{gm: []config.FlatGroupMAC{{Group: "kids", MAC: "BB-00-00-00-00-01", Name: "Tablet"}}},
+	}
+	get := func(target string) (*httptest.ResponseRecorder, map[models.Group]map[models.MAC]time.Time) {
+		rec := httptest.NewRecorder()
+		h.activityHandler(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		var got map[models.Group]map[models.MAC]time.Time
+		require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+		return rec, got
+	}
+
+	rec, got := get("/activity")
+	assert.Equal(t, "4", rec.Header().Get("X-Total-Count"))
+	assert.Equal(t, all, got)
+
+	rec, got = get("/activity?group=kids&offset=1&limit=1")
+	assert.Equal(t, "3", rec.Header().Get("X-Total-Count"))
+	assert.Equal(t, map[models.Group]map[models.MAC]time.Time{"kids": {"BB-00-00-00-00-01": active}}, got)
+
+	_, got = get("/activity?name=tablet")
+	assert.Equal(t, map[models.Group]map[models.MAC]time.Time{"kids": {"BB-00-00-00-00-01": active}}, got, "expected devices to match by configured name")
+	assert.Len(t, all["kids"], 3, "expected the cached activity to be left as it is")
+}
+
+func TestUsageSamplesHandler(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	want := models.TrackerSamples{WindowStart: start, WindowEnd: start.Add(2 * time.Minute), Granularity: 60, Samples: []models.TrackerSample{
+		{Time: start, Active: true},
+		{Time: start.Add(time.Minute), Active: false},
+	}}
+	h := &Handler{logger: config.MustGetLogger(), usageTracker: &mockUsageTracker{samples: map[string]models.TrackerSamples{"kids": want}}}
+
+	rr := httptest.NewRecorder()
+	h.usageSamplesHandler(rr, httptest.NewRequest(http.MethodGet, "/api/usage/samples?group=kids", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var got models.TrackerSamples
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+	assert.Equal(t, want, got)
+
+	rr = httptest.NewRecorder()
+	h.usageSamplesHandler(rr, httptest.NewRequest(http.MethodGet, "/api/usage/samples?group=teens", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h.usageSamplesHandler(rr, httptest.NewRequest(http.MethodGet, "/api/usage/samples", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "expected the group to be required")
+
+	rr = httptest.NewRecorder()
+	h.usageSamplesHandler(rr, httptest.NewRequest(http.MethodPost, "/api/usage/samples?group=kids", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestModeHistoryHandler(t *testing.T) {
+	until := time.Date(2024, 6, 1, 13, 0, 0, 0, time.UTC)
+	h := &Handler{logger: config.MustGetLogger(), usageTracker: &mockUsageTracker{history: []models.ModeTransition{
+		{Time: until.Add(-time.Hour), Group: "kids", Cause: models.TransitionManual, Mode: models.ModeAllow, Until: until, Reason: "allowed for 1h0m0s"},
+		{Time: until.Add(-2 * time.Hour), Group: "kids", Cause: models.TransitionTracker, Blocked: true, Reason: "used 1h0m0s of 1h0m0s"},
+		{Time: until.Add(-3 * time.Hour), Group: "teens", Cause: models.TransitionTracker, Blocked: true},
+	}}}
+
+	rr := httptest.NewRecorder()
+	h.modeHistoryHandler(rr, httptest.NewRequest(http.MethodGet, "/api/mode-history?group=kids&limit=1", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var transitions []models.ModeTransition
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &transitions))
+	if assert.Len(t, transitions, 1) {
+		assert.Equal(t, "allowed for 1h0m0s", transitions[0].Reason)
+		assert.Equal(t, until, transitions[0].Until)
+	}
+
+	rr = httptest.NewRecorder()
+	h.modeHistoryHandler(rr, httptest.NewRequest(http.MethodGet, "/api/mode-history", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "expected the group to be required")
+
+	rr = httptest.NewRecorder()
+	h.modeHistoryHandler(rr, httptest.NewRequest(http.MethodPost, "/api/mode-history?group=kids", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	h.usageTracker = &mockUsageTracker{}
+	rr = httptest.NewRecorder()
+	h.modeHistoryHandler(rr, httptest.NewRequest(http.MethodGet, "/api/mode-history?group=kids", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+type mockKillSwitch struct {
+	state models.KillSwitchState
+}
+
+func (m *mockKillSwitch) State() models.KillSwitchState {
+	return m.state
+}
+
+func (m *mockKillSwitch) Set(on bool, source string) models.KillSwitchState {
+	m.state = models.KillSwitchState{On: on, Since: time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC), Source: source}
+	return m.state
+}
+
+func TestKillSwitchHandler(t *testing.T) {
+	al := &mockAuditLog{}
+	h := &Handler{logger: config.MustGetLogger(), killSwitch: &mockKillSwitch{}, auditLog: al}
+
+	rr := httptest.NewRecorder()
+	h.killSwitchHandler(rr, httptest.NewRequest(http.MethodPost, "/api/kill-switch", strings.NewReader(`{"on":true}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var state models.KillSwitchState
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &state))
+	assert.True(t, state.On)
+	assert.Equal(t, "api", state.Source)
+	if assert.Len(t, al.entries, 1) {
+		assert.Equal(t, "killSwitch.set", al.entries[0].Action)
+	}
+
+	rr = httptest.NewRecorder()
+	h.killSwitchHandler(rr, httptest.NewRequest(http.MethodGet, "/api/kill-switch", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	state = models.KillSwitchState{}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &state))
+	assert.True(t, state.On)
+
+	rr = httptest.NewRecorder()
+	h.killSwitchHandler(rr, httptest.NewRequest(http.MethodPost, "/api/kill-switch", strings.NewReader(`{}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "expected on to be required")
+
+	rr = httptest.NewRecorder()
+	h.killSwitchHandler(rr, httptest.NewRequest(http.MethodDelete, "/api/kill-switch", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	h.killSwitch = nil
+	rr = httptest.NewRecorder()
+	h.killSwitchHandler(rr, httptest.NewRequest(http.MethodGet, "/api/kill-switch", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+type mockNFTDiagnostics struct {
+	snap models.NFTSnapshot
+	err  error
+}
+
+func (m *mockNFTDiagnostics) Snapshot() (models.NFTSnapshot, error) {
+	return m.snap, m.err
+}
+
+func TestNFTDiagnosticsHandler(t *testing.T) {
+	snap := models.NFTSnapshot{Table: "tubetimeout-table", Sets: []models.NFTSet{{Name: "local_ip_set", KeyType: "ipv4_addr", Elements: []string{"192.168.1.10"}}}}
+	h := &Handler{logger: config.MustGetLogger(), nftDiagnostics: &mockNFTDiagnostics{snap: snap}}
+
+	rr := httptest.NewRecorder()
+	h.nftDiagnosticsHandler(rr, httptest.NewRequest(http.MethodGet, "/api/diagnostics/nft", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var got models.NFTSnapshot
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+	assert.Equal(t, snap, got)
+
+	rr = httptest.NewRecorder()
+	h.nftDiagnosticsHandler(rr, httptest.NewRequest(http.MethodPost, "/api/diagnostics/nft", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	h.nftDiagnostics = &mockNFTDiagnostics{err: errors.New("netlink receive: operation not permitted")}
+	rr = httptest.NewRecorder()
+	h.nftDiagnosticsHandler(rr, httptest.NewRequest(http.MethodGet, "/api/diagnostics/nft", nil))
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+}
+
+type mockPacketCapture struct {
+	states map[models.Group]models.CaptureState
+}
+
+func (m *mockPacketCapture) Captures() []models.CaptureState {
+	var retval []models.CaptureState
+	for _, s := range m.states {
+		retval = append(retval, s)
+	}
+	return retval
+}
+
+func (m *mockPacketCapture) SetCapture(group models.Group, enabled bool) (models.CaptureState, error) {
+	m.states[group] = models.CaptureState{Group: group, Enabled: enabled}
+	return m.states[group], nil
+}
+
+func (m *mockPacketCapture) WritePcap(w io.Writer, group models.Group) error {
+	if _, ok := m.states[group]; !ok {
+		return models.ErrCaptureNotFound
+	}
+	_, err := w.Write([]byte("pcap"))
+	return err
+}
+
+func TestCaptureHandlers(t *testing.T) {
+	al := &mockAuditLog{}
+	h := &Handler{logger: config.MustGetLogger(), packetCapture: &mockPacketCapture{states: map[models.Group]models.CaptureState{}}, auditLog: al}
+
+	rr := httptest.NewRecorder()
+	h.captureHandler(rr, httptest.NewRequest(http.MethodPost, "/api/capture", strings.NewReader(`{"group":"kids","enabled":true}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	if assert.Len(t, al.entries, 1) {
+		assert.Equal(t, "capture.set", al.entries[0].Action)
+		assert.Equal(t, "kids", al.entries[0].Target)
+	}
+
+	rr = httptest.NewRecorder()
+	h.captureHandler(rr, httptest.NewRequest(http.MethodGet, "/api/capture", nil))
+	var states []models.CaptureState
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &states))
+	assert.Equal(t, []models.CaptureState{{Group: "kids", Enabled: true}}, states)
+
+	rr = httptest.NewRecorder()
+	h.captureHandler(rr, httptest.NewRequest(http.MethodPost, "/api/capture", strings.NewReader(`{"enabled":true}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "expected the group to be required")
+
+	rr = httptest.NewRecorder()
+	h.capturePcapHandler(rr, httptest.NewRequest(http.MethodGet, "/api/capture/pcap?group=kids", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/vnd.tcpdump.pcap", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "pcap", rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	h.capturePcapHandler(rr, httptest.NewRequest(http.MethodGet, "/api/capture/pcap?group=teens", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+type mockDeviceTracer struct {
+	states map[models.MAC]models.TraceState
+	d      time.Duration
+}
+
+func (m *mockDeviceTracer) Traces() []models.TraceState {
+	var retval []models.TraceState
+	for _, s := range m.states {
+		retval = append(retval, s)
+	}
+	return retval
+}
+
+func (m *mockDeviceTracer) StartTrace(mac models.MAC, duration time.Duration) (models.TraceState, error) {
+	if duration > time.Minute {
+		return models.TraceState{}, models.ErrInvalidTrace
+	}
+	m.d = duration
+	m.states[mac] = models.TraceState{MAC: mac, Enabled: true}
+	return m.states[mac], nil
+}
+
+func (m *mockDeviceTracer) StopTrace(mac models.MAC) (models.TraceState, error) {
+	s, ok := m.states[mac]
+	if !ok {
+		return models.TraceState{}, models.ErrTraceNotFound
+	}
+	s.Enabled = false
+	m.states[mac] = s
+	return s, nil
+}
+
+func (m *mockDeviceTracer) WriteTrace(w io.Writer, mac models.MAC) error {
+	if _, ok := m.states[mac]; !ok {
+		return models.ErrTraceNotFound
+	}
+	_, err := w.Write([]byte("[]"))
+	return err
+}
+
+func TestTraceHandler(t *testing.T) {
+	al := &mockAuditLog{}
+	tr := &mockDeviceTracer{states: map[models.MAC]models.TraceState{}}
+	h := &Handler{logger: config.MustGetLogger(), tracer: tr, auditLog: al}
+
+	rr := httptest.NewRecorder()
+	h.traceHandler(rr, httptest.NewRequest(http.MethodPost, "/api/trace?mac=AA:BB:CC:DD:EE:FF", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, time.Minute, tr.d, "expected traces to default to 60 seconds")
+	if assert.Len(t, al.entries, 1) {
+		assert.Equal(t, "trace.start", al.entries[0].Action)
+		assert.Equal(t, "AA-BB-CC-DD-EE-FF", al.entries[0].Target)
+	}
+
+	rr = httptest.NewRecorder()
+	h.traceHandler(rr, httptest.NewRequest(http.MethodPost, "/api/trace?mac=aa-bb-cc-dd-ee-ff&seconds=30", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 30*time.Second, tr.d)
+
+	for _, target := range []string{"/api/trace?mac=aa-bb-cc-dd-ee-ff&seconds=600", "/api/trace?mac=aa-bb-cc-dd-ee-ff&seconds=x", "/api/trace?mac=nope", "/api/trace"} {
+		rr = httptest.NewRecorder()
+		h.traceHandler(rr, httptest.NewRequest(http.MethodPost, target, nil))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, target)
+	}
+
+	rr = httptest.NewRecorder()
+	h.traceHandler(rr, httptest.NewRequest(http.MethodGet, "/api/trace", nil))
+	var states []models.TraceState
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &states))
+	assert.Equal(t, []models.TraceState{{MAC: "AA-BB-CC-DD-EE-FF", Enabled: true}}, states)
+
+	rr = httptest.NewRecorder()
+	h.traceHandler(rr, httptest.NewRequest(http.MethodGet, "/api/trace?mac=aa-bb-cc-dd-ee-ff", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `attachment; filename="trace-AA-BB-CC-DD-EE-FF.json"`, rr.Header().Get("Content-Disposition"))
+	assert.Equal(t, "[]", rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	h.traceHandler(rr, httptest.NewRequest(http.MethodDelete, "/api/trace?mac=aa-bb-cc-dd-ee-ff", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.False(t, tr.states["AA-BB-CC-DD-EE-FF"].Enabled)
+
+	rr = httptest.NewRecorder()
+	h.traceHandler(rr, httptest.NewRequest(http.MethodGet, "/api/trace?mac=11-22-33-44-55-66", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr = httptest.NewRecorder()
+	h.traceHandler(rr, httptest.NewRequest(http.MethodDelete, "/api/trace?mac=11-22-33-44-55-66", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+type mockResolutionPauser struct {
+	pauses map[models.Group]models.ResolutionPause
+	d      time.Duration
+}
+
+func (m *mockResolutionPauser) ResolutionPauses() []models.ResolutionPause {
+	retval := []models.ResolutionPause{}
+	for _, p := range m.pauses {
+		retval = append(retval, p)
+	}
+	return retval
+}
+
+func (m *mockResolutionPauser) PauseResolution(group models.Group, mode models.ResolutionPauseMode, d time.Duration) (models.ResolutionPause, error) {
+	if group != "youtube" {
+		return models.ResolutionPause{}, models.ErrGroupNotFound
+	}
+	m.d = d
+	m.pauses[group] = models.ResolutionPause{Group: group, Mode: mode}
+	return m.pauses[group], nil
+}
+
+func (m *mockResolutionPauser) ResumeResolution(group models.Group) error {
+	if _, ok := m.pauses[group]; !ok {
+		return models.ErrGroupNotFound
+	}
+	delete(m.pauses, group)
+	return nil
+}
+
+func TestResolutionPauseHandler(t *testing.T) {
+	al := &mockAuditLog{}
+	rp := &mockResolutionPauser{pauses: map[models.Group]models.ResolutionPause{}}
+	h := &Handler{logger: config.MustGetLogger(), resolutionPauser: rp, auditLog: al}
+
+	rr := httptest.NewRecorder()
+	h.resolutionPauseHandler(rr, httptest.NewRequest(http.MethodPost, "/api/resolution-pause", strings.NewReader(`{"group":"youtube","mode":"freeze","minutes":30}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 30*time.Minute, rp.d)
+	if assert.Len(t, al.entries, 1) {
+		assert.Equal(t, "resolution.pause", al.entries[0].Action)
+		assert.Equal(t, "youtube", al.entries[0].Target)
+	}
+
+	rr = httptest.NewRecorder()
+	h.resolutionPauseHandler(rr, httptest.NewRequest(http.MethodGet, "/api/resolution-pause", nil))
+	var pauses []models.ResolutionPause
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &pauses))
+	assert.Equal(t, []models.ResolutionPause{{Group: "youtube", Mode: models.ResolutionFreeze}}, pauses)
+
+	rr = httptest.NewRecorder()
+	h.resolutionPauseHandler(rr, httptest.NewRequest(http.MethodPost, "/api/resolution-pause", strings.NewReader(`{"group":"youtube","mode":"stop"}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "expected an unknown mode to be rejected")
+
+	rr = httptest.NewRecorder()
+	h.resolutionPauseHandler(rr, httptest.NewRequest(http.MethodPost, "/api/resolution-pause", strings.NewReader(`{"group":"games","mode":"clear"}`)))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h.resolutionPauseHandler(rr, httptest.NewRequest(http.MethodDelete, "/api/resolution-pause?group=youtube", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, rp.pauses)
+	assert.Equal(t, "resolution.resume", al.entries[len(al.entries)-1].Action)
+
+	rr = httptest.NewRecorder()
+	h.resolutionPauseHandler(rr, httptest.NewRequest(http.MethodDelete, "/api/resolution-pause?group=youtube", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "expected an error resuming a group that isn't paused")
+}
+
+type mockUsageReports struct {
+	end time.Time
+}
+
+func (m *mockUsageReports) Reports(end time.Time) []models.UsageReport {
+	m.end = end
+	return []models.UsageReport{{Group: "kids", TotalMinutes: 90}, {Group: "teens", TotalMinutes: 300}}
+}
+
+func (m *mockUsageReports) Report(group models.Group, end time.Time) (models.UsageReport, error) {
+	m.end = end
+	if group != "kids" {
+		return models.UsageReport{}, models.ErrGroupNotFound
+	}
+	return models.UsageReport{Group: group, TotalMinutes: 90}, nil
+}
+
+func TestReportsHandler(t *testing.T) {
+	ur := &mockUsageReports{}
+	h := &Handler{logger: config.MustGetLogger(), reports: ur}
+
+	rr := httptest.NewRecorder()
+	h.reportsHandler(rr, httptest.NewRequest(http.MethodGet, "/api/reports", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var reports []models.UsageReport
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &reports))
+	assert.Len(t, reports, 2)
+
+	rr = httptest.NewRecorder()
+	h.reportsHandler(rr, httptest.NewRequest(http.MethodGet, "/api/reports?group=kids&end=2025-03-02&format=html", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, time.Date(2025, 3, 3, 0, 0, 0, 0, time.Local), ur.end, "expected the week to end at the end of the date given")
+	assert.Equal(t, `attachment; filename="tubetimeout-report-2025-03-02.html"`, rr.Header().Get("Content-Disposition"))
+	assert.Contains(t, rr.Body.String(), "<h2>kids</h2>")
+	assert.Contains(t, rr.Body.String(), "1h 30m")
+
+	rr = httptest.NewRecorder()
+	h.reportsHandler(rr, httptest.NewRequest(http.MethodGet, "/api/reports?group=games", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h.reportsHandler(rr, httptest.NewRequest(http.MethodGet, "/api/reports?end=yesterday", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h.reportsHandler(rr, httptest.NewRequest(http.MethodPost, "/api/reports", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	h.reports = nil
+	rr = httptest.NewRecorder()
+	h.reportsHandler(rr, httptest.NewRequest(http.MethodGet, "/api/reports", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+type mockGroupDomains struct {
+	m     models.MapGroupDomains
+	saved models.MapGroupDomains
+}
+
+func (m *mockGroupDomains) GetGroupDomains(_ *zap.SugaredLogger) (models.MapGroupDomains, error) {
+	return m.m, nil
+}
+
+func (m *mockGroupDomains) SaveGroupDomains(_ *zap.SugaredLogger, gd models.MapGroupDomains) error {
+	for _, domains := range gd {
+		for _, d := range domains {
+			if strings.Contains(string(d), "/") {
+				return config.ErrInvalidDomain
+			}
+		}
+	}
+	m.saved = gd
+	return nil
+}
+
+type mockDomainReloader struct {
+	reloaded chan struct{}
+}
+
+func (m *mockDomainReloader) Reload() error {
+	m.reloaded <- struct{}{}
+	return nil
+}
+
+func TestGroupDomainsHandler(t *testing.T) {
+	gd := &mockGroupDomains{m: models.MapGroupDomains{"youtube": {"youtube.com"}}}
+	dr := &mockDomainReloader{reloaded: make(chan struct{}, 1)}
+	al := &mockAuditLog{}
+	h := &Handler{logger: config.MustGetLogger(), groupDomains: gd, domainReloader: dr, auditLog: al}
+
+	rr := httptest.NewRecorder()
+	h.groupDomainsHandler(rr, httptest.NewRequest(http.MethodGet, "/api/group-domains", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"youtube":["youtube.com"]}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	h.groupDomainsHandler(rr, httptest.NewRequest(http.MethodPost, "/api/group-domains", strings.NewReader(`{"games":["roblox.com"]}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, models.MapGroupDomains{"games": {"roblox.com"}}, gd.saved)
+	if assert.Len(t, al.entries, 1) {
+		assert.Equal(t, "groupDomains.save", al.entries[0].Action)
+	}
+	select {
+	case <-dr.reloaded:
+	case <-time.After(time.Second):
+		t.Error("expected the domain watcher to be reloaded after a save")
+	}
+
+	rr = httptest.NewRecorder()
+	h.groupDomainsHandler(rr, httptest.NewRequest(http.MethodPost, "/api/group-domains", strings.NewReader(`{"games":["roblox.com/games"]}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h.groupDomainsHandler(rr, httptest.NewRequest(http.MethodPost, "/api/group-domains", strings.NewReader(`["roblox.com"]`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h.groupDomainsHandler(rr, httptest.NewRequest(http.MethodDelete, "/api/group-domains", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	h.groupDomains = nil
+	rr = httptest.NewRecorder()
+	h.groupDomainsHandler(rr, httptest.NewRequest(http.MethodGet, "/api/group-domains", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+type mockBandwidthSource struct {
+	bandwidth []models.Bandwidth
+}
+
+func (m *mockBandwidthSource) GetBandwidth(mac models.MAC) (models.Bandwidth, bool) {
+	for _, bw := range m.bandwidth {
+		if bw.MAC == mac {
+			return bw, true
+		}
+	}
+	return models.Bandwidth{}, false
+}
+
+func (m *mockBandwidthSource) GetAllBandwidth() []models.Bandwidth {
+	return m.bandwidth
+}
+
+func TestBandwidthHandler(t *testing.T) {
+	bw := &mockBandwidthSource{bandwidth: []models.Bandwidth{{MAC: "AA-BB-CC-DD-EE-FF", IngressKbps: 80, Minutes: []models.BandwidthMinute{}}}}
+	h := &Handler{logger: config.MustGetLogger(), bandwidth: bw}
+
+	rr := httptest.NewRecorder()
+	h.bandwidthHandler(rr, httptest.NewRequest(http.MethodGet, "/api/bandwidth", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `[{"mac":"AA-BB-CC-DD-EE-FF","ingressKbps":80,"egressKbps":0,"minutes":[]}]`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	h.bandwidthHandler(rr, httptest.NewRequest(http.MethodGet, "/api/bandwidth?mac=aa:bb:cc:dd:ee:ff", nil))
+	assert.Equal(t, http.StatusOK, rr.Code, "expected the MAC to be sanitised")
+	assert.JSONEq(t, `{"mac":"AA-BB-CC-DD-EE-FF","ingressKbps":80,"egressKbps":0,"minutes":[]}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	h.bandwidthHandler(rr, httptest.NewRequest(http.MethodGet, "/api/bandwidth?mac=11-22-33-44-55-66", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h.bandwidthHandler(rr, httptest.NewRequest(http.MethodPost, "/api/bandwidth", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	h.bandwidth = nil
+	rr = httptest.NewRecorder()
+	h.bandwidthHandler(rr, httptest.NewRequest(http.MethodGet, "/api/bandwidth", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+type mockSamplesUsageTracker struct {
+	mockUsageTracker
+	imported []byte
+}
+
+func (m *mockSamplesUsageTracker) ExportSamples() ([]byte, error) {
+	return []byte(`{"version":2,"samples":{}}`), nil
+}
+
+func (m *mockSamplesUsageTracker) ImportSamples(data []byte) error {
+	if !json.Valid(data) {
+		return models.ErrInvalidSamples
+	}
+	m.imported = data
+	return nil
+}
+
+func TestUsageExportAndImportHandlers(t *testing.T) {
+	ut := &mockSamplesUsageTracker{}
+	al := &mockAuditLog{}
+	h := &Handler{logger: config.MustGetLogger(), usageTracker: ut, auditLog: al}
+
+	rr := httptest.NewRecorder()
+	h.usageExportHandler(rr, httptest.NewRequest(http.MethodGet, "/api/usage/export", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"version":2,"samples":{}}`, rr.Body.String())
+	assert.Contains(t, rr.Header().Get("Content-Disposition"), "tubetimeout-usage-")
+
+	rr = httptest.NewRecorder()
+	h.usageImportHandler(rr, httptest.NewRequest(http.MethodPost, "/api/usage/import", strings.NewReader(`{"version":2,"samples":{}}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, `{"version":2,"samples":{}}`, string(ut.imported))
+	if assert.Len(t, al.entries, 1) {
+		assert.Equal(t, "usage.import", al.entries[0].Action)
+	}
+
+	rr = httptest.NewRecorder()
+	h.usageImportHandler(rr, httptest.NewRequest(http.MethodPost, "/api/usage/import", strings.NewReader(`{`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h.usageImportHandler(rr, httptest.NewRequest(http.MethodGet, "/api/usage/import", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+type mockNetworkDetector struct{}
+
+func (mockNetworkDetector) ProposeConfig() (dhcp.Network, *dhcp.DNSMasqConfig, error) {
+	return dhcp.Network{Interface: "eth0", DefaultGateway: net.ParseIP("192.168.1.1")},
+		&dhcp.DNSMasqConfig{ServiceEnabled: true, DefaultGateway: net.ParseIP("192.168.1.1")}, nil
+}
+
+type mockSetupGroupMACs struct {
+	GroupMACsGroupGetterSetter
+	gm []config.FlatGroupMAC
+}
+
+func (m *mockSetupGroupMACs) GetAllGroupMACs(_ *zap.SugaredLogger) ([]config.FlatGroupMAC, error) {
+	return m.gm, nil
+}
+
+func (m *mockSetupGroupMACs) SaveGroupMACs(_ *zap.SugaredLogger, gm []config.FlatGroupMAC) error {
+	m.gm = gm
+	return nil
+}
+
+type mockDHCPConfig struct {
+	DHCPConfigGetterSetter
+	cfg *dhcp.DNSMasqConfig
+	err error
+}
+
+func (m *mockDHCPConfig) GetConfig(_ *zap.SugaredLogger) (*dhcp.DNSMasqConfig, error) {
+	return m.cfg, nil
+}
+
+func (m *mockDHCPConfig) SetConfig(_ *zap.SugaredLogger, cfg *dhcp.DNSMasqConfig) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.cfg = cfg
+	return nil
+}
+
+func TestSetupHandler(t *testing.T) {
+	gm := &mockSetupGroupMACs{gm: []config.FlatGroupMAC{{MAC: "aa:bb:cc:dd:ee:ff", Name: "tablet"}}}
+	gd := &mockGroupDomains{m: models.MapGroupDomains{"youtube": {"youtube.com"}}}
+	dc := &mockDHCPConfig{cfg: &dhcp.DNSMasqConfig{}}
+	al := &mockAuditLog{}
+	h := &Handler{logger: config.MustGetLogger(), networkDetector: mockNetworkDetector{}, groupMACsGetterSetter: gm,
+		dhcpConfigGetterSetter: dc, groupDomains: gd, auditLog: al}
+
+	rr := httptest.NewRecorder()
+	h.setupHandler(rr, httptest.NewRequest(http.MethodGet, "/api/setup", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var p setupProposal
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &p))
+	assert.True(t, p.FirstRun, "expected the first run since no device is in a group")
+	assert.Equal(t, "eth0", p.Network.Interface)
+	assert.Equal(t, "192.168.1.1", p.DHCP.DefaultGateway.String())
+	assert.Len(t, p.Devices, 1)
+
+	rr = httptest.NewRecorder()
+	h.setupHandler(rr, httptest.NewRequest(http.MethodPost, "/api/setup", strings.NewReader(`{"devices":[{"mac":"aa:bb:cc:dd:ee:ff"}]}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "expected a device to be required in a group")
+
+	// A failed DHCP change puts back the devices and domains.
+	dc.err = errors.New("bad range")
+	body := `{"devices":[{"group":"kids","mac":"aa:bb:cc:dd:ee:ff"}],"domains":{"kids":["youtube.com"]},"dhcp":{"serviceEnabled":true}}`
+	rr = httptest.NewRecorder()
+	h.setupHandler(rr, httptest.NewRequest(http.MethodPost, "/api/setup", strings.NewReader(body)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "", gm.gm[0].Group, "expected the devices to be put back")
+	assert.Equal(t, models.MapGroupDomains{"youtube": {"youtube.com"}}, gd.saved, "expected the domains to be put back")
+	assert.Empty(t, al.entries)
+
+	dc.err = nil
+	rr = httptest.NewRecorder()
+	h.setupHandler(rr, httptest.NewRequest(http.MethodPost, "/api/setup", strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"message":"setup saved successfully"}`, rr.Body.String())
+	assert.Equal(t, "kids", gm.gm[0].Group)
+	assert.Equal(t, models.MapGroupDomains{"kids": {"youtube.com"}}, gd.saved)
+	assert.True(t, dc.cfg.ServiceEnabled)
+	if assert.Len(t, al.entries, 1) {
+		assert.Equal(t, "setup.apply", al.entries[0].Action)
+	}
+
+	rr = httptest.NewRecorder()
+	h.setupHandler(rr, httptest.NewRequest(http.MethodDelete, "/api/setup", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	rr = httptest.NewRecorder()
+	(&Handler{logger: config.MustGetLogger()}).setupHandler(rr, httptest.NewRequest(http.MethodGet, "/api/setup", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+type mockGroupMembership struct {
+	ipGroups     models.MapIpGroups
+	ipMACs       models.MapIpMACs
+	domainGroups models.MapDomainGroups
+}
+
+func (m *mockGroupMembership) GroupsForIP(ip models.Ip) []models.Group {
+	return m.ipGroups[ip]
+}
+
+func (m *mockGroupMembership) IpsForMAC(mac models.MAC) []models.Ip {
+	var ips []models.Ip
+	for ip, v := range m.ipMACs {
+		if v == mac {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+func (m *mockGroupMembership) GroupsForMAC(mac models.MAC) []models.Group {
+	var groups []models.Group
+	for _, ip := range m.IpsForMAC(mac) {
+		groups = append(groups, m.ipGroups[ip]...)
+	}
+	return groups
+}
+
+func (m *mockGroupMembership) DomainsForGroup(group models.Group) []models.Domain {
+	var domains []models.Domain
+	for d, groups := range m.domainGroups {
+		if slices.Contains(groups, group) {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+type mockLookupUsageTracker struct {
+	mockUsageTracker
+	exceeded map[string]bool
+	policies map[string]*models.PacketPolicy
+}
+
+func (m *mockLookupUsageTracker) HasExceededThreshold(id string) bool {
+	return m.exceeded[id]
+}
+
+func (m *mockLookupUsageTracker) PacketPolicy(id string) *models.PacketPolicy {
+	return m.policies[id]
+}
+
+func TestLookupHandler(t *testing.T) {
+	orig := config.AppCfg.FilterConfig
+	t.Cleanup(func() { config.AppCfg.FilterConfig = orig })
+	config.AppCfg.FilterConfig = config.FilterConfig{PacketDropPercentage: 0.4, PacketDropUDP: true}
+
+	ut := &mockLookupUsageTracker{
+		mockUsageTracker: mockUsageTracker{
+			summary: map[string]*models.TrackerSummary{"kids": {Used: 70, Threshold: 60}},
+			modes:   map[string]models.TrackerMode{"kids": {Mode: models.ModeMonitor}},
+		},
+		exceeded: map[string]bool{"kids": true},
+		policies: map[string]*models.PacketPolicy{"family": {DelayPercentage: 1, Delay: 200 * time.Millisecond}},
+	}
+	h := &Handler{
+		logger:       config.MustGetLogger(),
+		usageTracker: ut,
+		devices:      mockDeviceLookup{"192.168.1.20": "AA-BB-CC-DD-EE-FF"},
+		membership: &mockGroupMembership{
+			ipGroups:     models.MapIpGroups{"192.168.1.20": {"kids"}, "192.168.1.21": {"family"}},
+			ipMACs:       models.MapIpMACs{"192.168.1.20": "AA-BB-CC-DD-EE-FF", "192.168.1.21": "11-22-33-44-55-66"},
+			domainGroups: models.MapDomainGroups{"youtube.com": {"kids"}},
+		},
+	}
+
+	rr := httptest.NewRecorder()
+	h.lookupHandler(rr, httptest.NewRequest(http.MethodGet, "/api/lookup?ip=192.168.1.20", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var got models.Lookup
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+	assert.Equal(t, models.MAC("AA-BB-CC-DD-EE-FF"), got.MAC)
+	require.Len(t, got.Groups, 1)
+	kids := got.Groups[0]
+	assert.Equal(t, []models.Domain{"youtube.com"}, kids.Domains)
+	assert.Equal(t, 70, kids.Tracker.Used)
+	assert.Equal(t, models.ModeMonitor, kids.Mode.Mode)
+	assert.Equal(t, models.VerdictThrottle, kids.Verdict)
+	assert.Equal(t, models.PacketPolicy{DropPercentage: 0.4, DropUDP: true}, kids.Policy, "expected the filter defaults for groups without a policy")
+
+	rr = httptest.NewRecorder()
+	h.lookupHandler(rr, httptest.NewRequest(http.MethodGet, "/api/lookup?mac=11:22:33:44:55:66", nil))
+	assert.Equal(t, http.StatusOK, rr.Code, "expected the MAC to be sanitised")
+	got = models.Lookup{}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&got))
+	assert.Equal(t, []models.Ip{"192.168.1.21"}, got.Ips)
+	require.Len(t, got.Groups, 1)
+	assert.Equal(t, models.VerdictAccept, got.Groups[0].Verdict)
+	assert.Equal(t, 200*time.Millisecond, got.Groups[0].Policy.Delay, "expected the group's own policy")
+	assert.Empty(t, got.Groups[0].Domains)
+
+	for _, target := range []string{"/api/lookup", "/api/lookup?ip=nope", "/api/lookup?mac=nope"} {
+		rr = httptest.NewRecorder()
+		h.lookupHandler(rr, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusBadRequest, rr.Code, target)
+	}
+
+	rr = httptest.NewRecorder()
+	h.lookupHandler(rr, httptest.NewRequest(http.MethodPost, "/api/lookup?ip=192.168.1.20", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	h.membership = nil
+	rr = httptest.NewRecorder()
+	h.lookupHandler(rr, httptest.NewRequest(http.MethodGet, "/api/lookup?ip=192.168.1.20", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+type mockProfileScheduler struct {
+	state models.ProfileState
+}
+
+func (m *mockProfileScheduler) State() models.ProfileState {
+	return m.state
+}
+
+func (m *mockProfileScheduler) SetProfiles(profiles []models.Profile) (models.ProfileState, error) {
+	for _, p := range profiles {
+		if p.Name == "" {
+			return models.ProfileState{}, profile.ErrInvalidProfile
+		}
+	}
+	m.state.Profiles = profiles
+	return m.state, nil
+}
+
+func (m *mockProfileScheduler) Activate(name string, until time.Time) (models.ProfileState, error) {
+	if !slices.ContainsFunc(m.state.Profiles, func(p models.Profile) bool { return p.Name == name }) {
+		return models.ProfileState{}, profile.ErrProfileNotFound
+	}
+	m.state.Active, m.state.Source, m.state.Until = name, models.ProfileSourceAPI, until
+	return m.state, nil
+}
+
+func (m *mockProfileScheduler) Deactivate() (models.ProfileState, error) {
+	m.state.Active, m.state.Source, m.state.Until = "", "", time.Time{}
+	return m.state, nil
+}
+
+func TestProfilesHandlers(t *testing.T) {
+	ps := &mockProfileScheduler{}
+	al := &mockAuditLog{}
+	h := &Handler{logger: config.MustGetLogger(), profiles: ps, auditLog: al}
+
+	rr := httptest.NewRecorder()
+	h.profilesHandler(rr, httptest.NewRequest(http.MethodPost, "/api/profiles", strings.NewReader(`[{"name":"holiday","thresholdFactor":2}]`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []models.Profile{{Name: "holiday", ThresholdFactor: 2}}, ps.state.Profiles)
+
+	rr = httptest.NewRecorder()
+	h.profilesHandler(rr, httptest.NewRequest(http.MethodPost, "/api/profiles", strings.NewReader(`[{"name":""}]`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h.activeProfileHandler(rr, httptest.NewRequest(http.MethodPost, "/api/profiles/active", strings.NewReader(`{"name":"exam"}`)))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h.activeProfileHandler(rr, httptest.NewRequest(http.MethodPost, "/api/profiles/active", strings.NewReader(`{"name":"holiday","until":"2027-01-05T00:00:00Z"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "holiday", ps.state.Active)
+	assert.True(t, ps.state.Until.Equal(time.Date(2027, 1, 5, 0, 0, 0, 0, time.UTC)))
+
+	rr = httptest.NewRecorder()
+	h.profilesHandler(rr, httptest.NewRequest(http.MethodGet, "/api/profiles", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"active":"holiday","source":"api","since":"0001-01-01T00:00:00Z","until":"2027-01-05T00:00:00Z","profiles":[{"name":"holiday","thresholdFactor":2}]}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	h.activeProfileHandler(rr, httptest.NewRequest(http.MethodDelete, "/api/profiles/active", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, ps.state.Active)
+
+	require.Len(t, al.entries, 3)
+	assert.Equal(t, "profiles.set", al.entries[0].Action)
+	assert.Equal(t, "profile.activate", al.entries[1].Action)
+	assert.Equal(t, "profile.deactivate", al.entries[2].Action)
+	assert.Equal(t, "holiday", al.entries[2].Target)
+
+	rr = httptest.NewRecorder()
+	h.activeProfileHandler(rr, httptest.NewRequest(http.MethodPut, "/api/profiles/active", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	h.profiles = nil
+	rr = httptest.NewRecorder()
+	h.profilesHandler(rr, httptest.NewRequest(http.MethodGet, "/api/profiles", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+type mockLogLevels struct {
+	state models.LogLevels
+}
+
+func (m *mockLogLevels) State() models.LogLevels {
+	return m.state
+}
+
+func (m *mockLogLevels) Set(pkg, level string) (models.LogLevels, error) {
+	if level == "verbose" {
+		return models.LogLevels{}, config.ErrInvalidLogLevel
+	}
+	if pkg == "" {
+		m.state.Level = level
+	} else {
+		m.state.Packages[pkg] = level
+	}
+	return m.state, nil
+}
+
+func TestLogLevelHandler(t *testing.T) {
+	al := &mockAuditLog{}
+	h := &Handler{logger: config.MustGetLogger(), logLevels: &mockLogLevels{state: models.LogLevels{Level: "info", Packages: map[string]string{}}}, auditLog: al}
+
+	rr := httptest.NewRecorder()
+	h.logLevelHandler(rr, httptest.NewRequest(http.MethodPost, "/api/log-level", strings.NewReader(`{"package":"nfq","level":"debug"}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	if assert.Len(t, al.entries, 1) {
+		assert.Equal(t, "logLevel.set", al.entries[0].Action)
+		assert.Equal(t, "nfq", al.entries[0].Target)
+	}
+
+	rr = httptest.NewRecorder()
+	h.logLevelHandler(rr, httptest.NewRequest(http.MethodGet, "/api/log-level", nil))
+	var state models.LogLevels
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &state))
+	assert.Equal(t, models.LogLevels{Level: "info", Packages: map[string]string{"nfq": "debug"}}, state)
+
+	rr = httptest.NewRecorder()
+	h.logLevelHandler(rr, httptest.NewRequest(http.MethodPost, "/api/log-level", strings.NewReader(`{"level":"verbose"}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h.logLevelHandler(rr, httptest.NewRequest(http.MethodDelete, "/api/log-level", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	h.logLevels = nil
+	rr = httptest.NewRecorder()
+	h.logLevelHandler(rr, httptest.NewRequest(http.MethodGet, "/api/log-level", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+type mockTimeRequests struct {
+	requests []models.TimeRequest
+}
+
+func (m *mockTimeRequests) Request(group models.Group, mac models.MAC, minutes int, reason string) (models.TimeRequest, error) {
+	if minutes <= 0 {
+		return models.TimeRequest{}, timerequest.ErrInvalidRequest
+	}
+	for _, r := range m.requests {
+		if r.Group == group && r.Status == models.TimeRequestPending {
+			return models.TimeRequest{}, timerequest.ErrRequestPending
+		}
+	}
+	r := models.TimeRequest{ID: fmt.Sprint(len(m.requests) + 1), Group: group, MAC: mac, Minutes: minutes, Reason: reason, Status: models.TimeRequestPending}
+	m.requests = append([]models.TimeRequest{r}, m.requests...)
+	return r, nil
+}
+
+func (m *mockTimeRequests) decide(id string, status string, minutes int, note string) (models.TimeRequest, error) {
+	for i, r := range m.requests {
+		if r.ID != id {
+			continue
+		}
+		if r.Status != models.TimeRequestPending {
+			return models.TimeRequest{}, timerequest.ErrRequestDecided
+		}
+		m.requests[i].Status, m.requests[i].Granted, m.requests[i].Note = status, minutes, note
+		return m.requests[i], nil
+	}
+	return models.TimeRequest{}, timerequest.ErrRequestNotFound
+}
+
+func (m *mockTimeRequests) Approve(id string, minutes int, allow bool, note string) (models.TimeRequest, error) {
+	return m.decide(id, models.TimeRequestApproved, minutes, note)
+}
+
+func (m *mockTimeRequests) Deny(id string, note string) (models.TimeRequest, error) {
+	return m.decide(id, models.TimeRequestDenied, 0, note)
+}
+
+func (m *mockTimeRequests) Requests(group models.Group, status string) []models.TimeRequest {
+	var retval []models.TimeRequest
+	for _, r := range m.requests {
+		if (group == "" || r.Group == group) && (status == "" || r.Status == status) {
+			retval = append(retval, r)
+		}
+	}
+	return retval
+}
+
+func TestTimeRequestHandlers(t *testing.T) {
+	tr := &mockTimeRequests{}
+	al := &mockAuditLog{}
+	h := &Handler{
+		logger: config.MustGetLogger(),
+		usageTracker: &mockUsageTracker{
+			summary: map[string]*models.TrackerSummary{"kids": {Used: 60, Total: 100, Percentage: 100, Threshold: 60}},
+			cfg:     models.MapGroupTrackerConfig{"kids": {Threshold: 60 * time.Minute}},
+		},
+		devices:      mockDeviceLookup{"192.168.1.20": "aa:bb:cc:dd:ee:ff", "192.168.1.30": "11:22:33:44:55:66"},
+		placements:   mockPlacementSource{"aa:bb:cc:dd:ee:ff": {{Group: "kids", AssignedBy: models.AssignedByManual}}},
+		timeRequests: tr,
+		auditLog:     al,
+	}
+	deviceRequest := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if !strings.HasPrefix(target, "/api/") {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		req.RemoteAddr = "192.168.1.20:51234"
+		rr := httptest.NewRecorder()
+		h.myTimeRequestHandler(rr, req)
+		return rr
+	}
+
+	// The /my-time page offers the form until a request is pending.
+	req := httptest.NewRequest(http.MethodGet, "/my-time", nil)
+	req.RemoteAddr = "192.168.1.20:51234"
+	rr := httptest.NewRecorder()
+	h.myTimeHandler(rr, req)
+	assert.Contains(t, rr.Body.String(), `action="/my-time/request"`)
+
+	rr = deviceRequest(http.MethodPost, "/my-time/request", "minutes=30&reason=homework")
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, "/my-time", rr.Header().Get("Location"))
+	require.Len(t, tr.requests, 1)
+	assert.Equal(t, models.TimeRequest{ID: "1", Group: "kids", MAC: "aa:bb:cc:dd:ee:ff", Minutes: 30, Reason: "homework", Status: models.TimeRequestPending}, tr.requests[0])
+
+	rr = httptest.NewRecorder()
+	h.myTimeHandler(rr, req)
+	assert.Contains(t, rr.Body.String(), "Asked for 30 more minutes: pending")
+	assert.NotContains(t, rr.Body.String(), `action="/my-time/request"`)
+
+	rr = deviceRequest(http.MethodPost, "/api/my-time/requests", `{"minutes":15}`)
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	rr = deviceRequest(http.MethodPost, "/api/my-time/requests", `{"minutes":0}`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = deviceRequest(http.MethodPost, "/api/my-time/requests", `{`)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	// Devices that aren't in a group can't ask.
+	req = httptest.NewRequest(http.MethodPost, "/api/my-time/requests", strings.NewReader(`{"minutes":15}`))
+	req.RemoteAddr = "192.168.1.30:51234"
+	rr = httptest.NewRecorder()
+	h.myTimeRequestHandler(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// The admin lists and decides the requests.
+	rr = httptest.NewRecorder()
+	h.timeRequestsHandler(rr, httptest.NewRequest(http.MethodGet, "/api/time-requests?status=pending", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var requests []models.TimeRequest
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &requests))
+	assert.Len(t, requests, 1)
+
+	rr = httptest.NewRecorder()
+	h.timeRequestDecisionHandler(rr, httptest.NewRequest(http.MethodPost, "/api/time-requests/approve", strings.NewReader(`{"id":"1","minutes":20}`)))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, models.TimeRequestApproved, tr.requests[0].Status)
+	assert.Equal(t, 20, tr.requests[0].Granted)
+
+	rr = httptest.NewRecorder()
+	h.timeRequestDecisionHandler(rr, httptest.NewRequest(http.MethodPost, "/api/time-requests/deny", strings.NewReader(`{"id":"1"}`)))
+	assert.Equal(t, http.StatusConflict, rr.Code)
+	rr = httptest.NewRecorder()
+	h.timeRequestDecisionHandler(rr, httptest.NewRequest(http.MethodPost, "/api/time-requests/deny", strings.NewReader(`{"id":"9"}`)))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr = httptest.NewRecorder()
+	h.timeRequestDecisionHandler(rr, httptest.NewRequest(http.MethodPost, "/api/time-requests/deny", strings.NewReader(`{}`)))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = httptest.NewRecorder()
+	h.timeRequestDecisionHandler(rr, httptest.NewRequest(http.MethodGet, "/api/time-requests/deny", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+
+	rr = deviceRequest(http.MethodGet, "/api/my-time/requests", "")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &requests))
+	if assert.Len(t, requests, 1) {
+		assert.Equal(t, models.TimeRequestApproved, requests[0].Status)
+	}
+
+	require.Len(t, al.entries, 2)
+	assert.Equal(t, "timeRequest.create", al.entries[0].Action)
+	assert.Equal(t, "timeRequest.approve", al.entries[1].Action)
+	assert.Equal(t, "kids", al.entries[1].Target)
+
+	h.timeRequests = nil
+	rr = httptest.NewRecorder()
+	h.timeRequestsHandler(rr, httptest.NewRequest(http.MethodGet, "/api/time-requests", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
+
+type mockDNSForwarder struct {
+	queries []models.DNSQuery
+	policy  models.MapGroupDNSPolicy
+}
+
+func (m *mockDNSForwarder) Queries(client models.Ip) []models.DNSQuery {
+	return slices.DeleteFunc(slices.Clone(m.queries), func(q models.DNSQuery) bool { return client != "" && q.Client != client })
+}
+
+func (m *mockDNSForwarder) Policy() models.MapGroupDNSPolicy {
+	return m.policy
+}
+
+func (m *mockDNSForwarder) SetPolicy(p models.MapGroupDNSPolicy) error {
+	if _, ok := p["bad"]; ok {
+		return fmt.Errorf("%w: domain in group bad", dnsforward.ErrInvalidPolicy)
+	}
+	m.policy = p
+	return nil
+}
+
+func TestDNSQueriesHandler(t *testing.T) {
+	df := &mockDNSForwarder{queries: []models.DNSQuery{
+		{Client: "192.168.1.10", Name: "www.youtube.com", Result: models.DNSForwarded},
+		{Client: "192.168.1.11", Name: "tiktok.com", Result: models.DNSBlocked},
+		{Client: "192.168.1.10", Name: "i.ytimg.com", Result: models.DNSCached},
+	}}
+	h := &Handler{logger: config.MustGetLogger(), dnsForwarder: df}
+
+	rec := httptest.NewRecorder()
+	h.dnsQueriesHandler(rec, httptest.NewRequest(http.MethodGet, "/api/dns/queries?client=192.168.1.10&limit=1", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("X-Total-Count"))
+	var got []models.DNSQuery
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+	assert.Equal(t, df.queries[:1], got)
+
+	rec = httptest.NewRecorder()
+	h.dnsQueriesHandler(rec, httptest.NewRequest(http.MethodGet, "/api/dns/queries?name=TIKTOK", nil))
+	got = nil
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&got))
+	assert.Equal(t, df.queries[1:2], got)
+
+	rec = httptest.NewRecorder()
+	h.dnsQueriesHandler(rec, httptest.NewRequest(http.MethodGet, "/api/dns/queries?client=192.168.1.99", nil))
+	assert.Equal(t, "[]\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	(&Handler{logger: config.MustGetLogger()}).dnsQueriesHandler(rec, httptest.NewRequest(http.MethodGet, "/api/dns/queries", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
+
+func TestDNSPolicyHandler(t *testing.T) {
+	al := &mockAuditLog{}
+	df := &mockDNSForwarder{policy: models.MapGroupDNSPolicy{}}
+	h := &Handler{logger: config.MustGetLogger(), dnsForwarder: df, auditLog: al}
+
+	rec := httptest.NewRecorder()
+	h.dnsPolicyHandler(rec, httptest.NewRequest(http.MethodPost, "/api/dns/policy", strings.NewReader(`{"kids":{"block":["tiktok.com"],"redirect":{"youtube.com":"216.239.38.120"}}}`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, models.DNSPolicy{Block: []models.Domain{"tiktok.com"}, Redirect: map[models.Domain]models.Ip{"youtube.com": "216.239.38.120"}}, df.policy["kids"])
+	assert.Len(t, al.entries, 1, "expected the change to be recorded")
+
+	rec = httptest.NewRecorder()
+	h.dnsPolicyHandler(rec, httptest.NewRequest(http.MethodGet, "/api/dns/policy", nil))
+	assert.JSONEq(t, `{"kids":{"block":["tiktok.com"],"redirect":{"youtube.com":"216.239.38.120"}}}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	h.dnsPolicyHandler(rec, httptest.NewRequest(http.MethodPost, "/api/dns/policy", strings.NewReader(`{"bad":{"block":["x"]}}`)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, df.policy, models.Group("kids"), "expected an invalid policy not to be saved")
+
+	rec = httptest.NewRecorder()
+	h.dnsPolicyHandler(rec, httptest.NewRequest(http.MethodDelete, "/api/dns/policy", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+type mockExemptionList struct {
+	exemptions []models.Exemption
+}
+
+func (m *mockExemptionList) Exemptions() []models.Exemption {
+	return slices.Clone(m.exemptions)
+}
+
+func (m *mockExemptionList) SetExemptions(exemptions []models.Exemption) error {
+	if slices.ContainsFunc(exemptions, func(e models.Exemption) bool { return e.MAC == "" && e.IP == "" }) {
+		return fmt.Errorf("%w: neither a MAC nor an IP", exempt.ErrInvalidExemption)
+	}
+	m.exemptions = exemptions
+	return nil
+}
+
+func TestExemptionsHandler(t *testing.T) {
+	al := &mockAuditLog{}
+	el := &mockExemptionList{}
+	h := &Handler{logger: config.MustGetLogger(), exemptions: el, auditLog: al}
+
+	rec := httptest.NewRecorder()
+	h.exemptionsHandler(rec, httptest.NewRequest(http.MethodPost, "/api/exemptions", strings.NewReader(`[{"mac":"aa:bb:cc:dd:ee:ff","name":"Work laptop"},{"ip":"192.168.1.20"}]`)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []models.Exemption{{MAC: "AA-BB-CC-DD-EE-FF", Name: "Work laptop"}, {IP: "192.168.1.20"}}, el.exemptions)
+	assert.Len(t, al.entries, 1, "expected the change to be recorded")
+
+	rec = httptest.NewRecorder()
+	h.exemptionsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/exemptions", nil))
+	assert.JSONEq(t, `[{"mac":"AA-BB-CC-DD-EE-FF","name":"Work laptop"},{"ip":"192.168.1.20"}]`, rec.Body.String())
+
+	for _, body := range []string{`[{"name":"laptop"}]`, `[{"mac":"laptop"}]`} {
+		rec = httptest.NewRecorder()
+		h.exemptionsHandler(rec, httptest.NewRequest(http.MethodPost, "/api/exemptions", strings.NewReader(body)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+	}
+	assert.Len(t, el.exemptions, 2, "expected invalid exemptions not to be saved")
+
+	rec = httptest.NewRecorder()
+	h.exemptionsHandler(rec, httptest.NewRequest(http.MethodDelete, "/api/exemptions", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = httptest.NewRecorder()
+	(&Handler{logger: config.MustGetLogger()}).exemptionsHandler(rec, httptest.NewRequest(http.MethodGet, "/api/exemptions", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+}
@@ -0,0 +1,234 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+	"relloyd/tubetimeout/config"
+)
+
+const (
+	fallbackLang     = "en"
+	langCookieName   = "tubetimeout_lang"
+	langCookieMaxAge = 365 * 24 * time.Hour
+)
+
+// Locale is a language the pages can be shown in, with its strings and how it formats values.
+type Locale struct {
+	Lang    string            `json:"lang"`
+	Name    string            `json:"name"`
+	Formats LocaleFormats     `json:"formats"`
+	Strings map[string]string `json:"strings"`
+}
+
+// LocaleFormats are the formatting decisions the server makes for a locale, so that the pages and the script agree.
+type LocaleFormats struct {
+	// Time and DateTime are Go layouts for the times shown by the pages rendered on the server.
+	Time     string `json:"time"`
+	DateTime string `json:"dateTime"`
+	// Hour12 shows the times formatted by the script with a 12-hour clock.
+	Hour12 bool `json:"hour12"`
+	// FirstDayOfWeek is the day that the lists of days start with.
+	FirstDayOfWeek time.Weekday `json:"firstDayOfWeek"`
+}
+
+// Language is a locale that can be picked in the UI.
+type Language struct {
+	Lang string `json:"lang"`
+	Name string `json:"name"`
+}
+
+// UIData is what the dashboard's script shows text with: the chosen locale's strings, with the missing ones in
+// English, and its formats.
+type UIData struct {
+	Lang      string            `json:"lang"`
+	Languages []Language        `json:"languages"`
+	Formats   LocaleFormats     `json:"formats"`
+	Weekdays  []time.Weekday    `json:"weekdays"` // Weekdays are the days of the week starting with FirstDayOfWeek.
+	Strings   map[string]string `json:"strings"`
+}
+
+// locales are the catalogs embedded in locales/ by language, with the English strings filled in where a catalog
+// doesn't have them. They're loaded once as they can't change.
+var locales, localeTags = mustLoadLocales()
+
+// localeMatcher picks the closest locale to the languages a browser asks for.
+var localeMatcher = language.NewMatcher(localeTags)
+
+func mustLoadLocales() (map[string]*Locale, []language.Tag) {
+	m, tags, err := loadLocales(embeddedFiles, "locales")
+	if err != nil {
+		panic(err)
+	}
+	return m, tags
+}
+
+// loadLocales reads each <lang>.json catalog in dir. The English catalog must exist, as it's the fallback for the
+// strings and the locale used when no other matches; it's first in the returned tags for the same reason.
+func loadLocales(fsys fs.FS, dir string) (map[string]*Locale, []language.Tag, error) {
+	files, err := fs.Glob(fsys, dir+"/*.json")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list the locales: %w", err)
+	}
+	m := make(map[string]*Locale)
+	for _, name := range files {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read locale %v: %w", name, err)
+		}
+		l := &Locale{}
+		if err = json.Unmarshal(data, l); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse locale %v: %w", name, err)
+		}
+		if l.Lang != strings.TrimSuffix(path.Base(name), ".json") {
+			return nil, nil, fmt.Errorf("locale %v is for language %q", name, l.Lang)
+		}
+		m[l.Lang] = l
+	}
+	en, ok := m[fallbackLang]
+	if !ok {
+		return nil, nil, fmt.Errorf("the %v locale is missing", fallbackLang)
+	}
+	tags := []language.Tag{language.Make(fallbackLang)}
+	for _, lang := range sortedLangs(m) {
+		if lang == fallbackLang {
+			continue
+		}
+		for k, v := range en.Strings {
+			if _, ok := m[lang].Strings[k]; !ok {
+				m[lang].Strings[k] = v
+			}
+		}
+		tags = append(tags, language.Make(lang))
+	}
+	return m, tags, nil
+}
+
+func sortedLangs(m map[string]*Locale) []string {
+	langs := make([]string, 0, len(m))
+	for lang := range m {
+		langs = append(langs, lang)
+	}
+	slices.Sort(langs)
+	return langs
+}
+
+// T returns the string for key with the args in place of {0}, {1} and so on, or the key itself if there's no
+// string, so that a missing one shows up on the page rather than leaving a gap.
+func (l *Locale) T(key string, args ...any) string {
+	s, ok := l.Strings[key]
+	if !ok {
+		return key
+	}
+	for i, arg := range args {
+		s = strings.ReplaceAll(s, "{"+strconv.Itoa(i)+"}", fmt.Sprint(arg))
+	}
+	return s
+}
+
+// N returns the plural form of key for n, i.e. the string of key.one if n is 1 or key.other otherwise, with n as
+// {0}. Both languages so far only have these forms.
+func (l *Locale) N(key string, n int, args ...any) string {
+	form := ".other"
+	if n == 1 {
+		form = ".one"
+	}
+	return l.T(key+form, append([]any{n}, args...)...)
+}
+
+// HTML returns the string for key as T does, but with the args escaped and the string's own markup kept, for strings
+// that emphasise part of themselves, e.g. <strong>{0}</strong> minutes left.
+func (l *Locale) HTML(key string, args ...any) template.HTML {
+	escaped := make([]any, len(args))
+	for i, arg := range args {
+		escaped[i] = template.HTMLEscapeString(fmt.Sprint(arg))
+	}
+	return template.HTML(l.T(key, escaped...))
+}
+
+// Clock returns t as a time of day in the locale's layout.
+func (l *Locale) Clock(t time.Time) string {
+	return t.Format(l.Formats.Time)
+}
+
+// Weekdays returns the days of the week starting with the locale's first day.
+func (l *Locale) Weekdays() []time.Weekday {
+	days := make([]time.Weekday, 7)
+	for i := range days {
+		days[i] = (l.Formats.FirstDayOfWeek + time.Weekday(i)) % 7
+	}
+	return days
+}
+
+// UIData returns the locale's strings and formats for the dashboard's script.
+func (l *Locale) UIData() UIData {
+	d := UIData{Lang: l.Lang, Formats: l.Formats, Weekdays: l.Weekdays(), Strings: l.Strings}
+	for _, lang := range sortedLangs(locales) {
+		d.Languages = append(d.Languages, Language{Lang: lang, Name: locales[lang].Name})
+	}
+	return d
+}
+
+// funcs returns the template funcs that show a page in the locale.
+func (l *Locale) funcs() template.FuncMap {
+	return template.FuncMap{
+		"t":     l.T,
+		"n":     l.N,
+		"tHTML": l.HTML,
+		"clock": l.Clock,
+		"day":   func(d time.Weekday) string { return l.T("day." + strconv.Itoa(int(d))) },
+	}
+}
+
+// parseTemplate parses the named page in templates/ with the funcs that show it in the locale.
+func parseTemplate(name string, l *Locale) (*template.Template, error) {
+	return template.New(name).Funcs(l.funcs()).ParseFS(embeddedFiles, "templates/"+name)
+}
+
+// locale returns the locale to show the request in: the one in its lang parameter, which is kept in a cookie for the
+// next pages, else the cookie's, else the closest to the browser's languages, else WEB_LANGUAGE.
+func (h *Handler) locale(w http.ResponseWriter, r *http.Request) *Locale {
+	if l, ok := locales[r.URL.Query().Get("lang")]; ok {
+		http.SetCookie(w, &http.Cookie{Name: langCookieName, Value: l.Lang, Path: "/", MaxAge: int(langCookieMaxAge / time.Second),
+			HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode})
+		return l
+	}
+	if c, err := r.Cookie(langCookieName); err == nil {
+		if l, ok := locales[c.Value]; ok {
+			return l
+		}
+	}
+	if tags, _, err := language.ParseAcceptLanguage(r.Header.Get("Accept-Language")); err == nil && len(tags) > 0 {
+		if _, i, conf := localeMatcher.Match(tags...); conf != language.No {
+			return locales[localeTags[i].String()]
+		}
+	}
+	if l, ok := locales[config.Current().WebConfig.Language]; ok {
+		return l
+	}
+	return locales[fallbackLang]
+}
+
+// uiHandler returns the strings and formats of the request's locale, as embedded in the dashboard, so that other
+// clients such as the kiosk display can show the same text.
+func (h *Handler) uiHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Vary", "Accept-Language, Cookie")
+	if err := json.NewEncoder(w).Encode(h.locale(w, r).UIData()); err != nil {
+		h.logger.Errorf("Error encoding UI data: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
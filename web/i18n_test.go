@@ -0,0 +1,280 @@
+package web
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/models"
+)
+
+func TestLoadLocales(t *testing.T) {
+	// Every catalog has every English string, with the same placeholders.
+	placeholders := regexp.MustCompile(`\{\d+\}`)
+	fnPlaceholders := func(s string) []string {
+		p := placeholders.FindAllString(s, -1)
+		slices.Sort(p)
+		return slices.Compact(p)
+	}
+	files, err := embeddedFiles.ReadDir("locales")
+	require.NoError(t, err)
+	var en Locale
+	data, err := embeddedFiles.ReadFile("locales/en.json")
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(data, &en))
+	for _, f := range files {
+		var l Locale
+		data, err = embeddedFiles.ReadFile("locales/" + f.Name())
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &l), f.Name())
+		assert.NotEmpty(t, l.Name, f.Name())
+		assert.NotEmpty(t, l.Formats.Time, f.Name())
+		assert.NotEmpty(t, l.Formats.DateTime, f.Name())
+		for k, v := range en.Strings {
+			s, ok := l.Strings[k]
+			if assert.True(t, ok, "expected %v to have %v", f.Name(), k) {
+				assert.Equal(t, fnPlaceholders(v), fnPlaceholders(s), "expected %v's %v to have the same placeholders", f.Name(), k)
+			}
+		}
+		for k := range l.Strings {
+			_, ok := en.Strings[k]
+			assert.True(t, ok, "expected %v's %v to be in the English catalog", f.Name(), k)
+		}
+	}
+	assert.Contains(t, locales, "es")
+	assert.Equal(t, fallbackLang, localeTags[0].String(), "expected English to be the matcher's fallback")
+
+	// Missing strings fall back to English.
+	m, _, err := loadLocales(fstest.MapFS{
+		"locales/en.json": {Data: []byte(`{"lang": "en", "strings": {"a": "A", "b": "B"}}`)},
+		"locales/xx.json": {Data: []byte(`{"lang": "xx", "strings": {"a": "X"}}`)},
+	}, "locales")
+	require.NoError(t, err)
+	assert.Equal(t, "X", m["xx"].T("a"))
+	assert.Equal(t, "B", m["xx"].T("b"))
+
+	_, _, err = loadLocales(fstest.MapFS{"locales/xx.json": {Data: []byte(`{"lang": "xx"}`)}}, "locales")
+	assert.Error(t, err, "expected English to be required")
+	_, _, err = loadLocales(fstest.MapFS{
+		"locales/en.json": {Data: []byte(`{"lang": "en"}`)},
+		"locales/xx.json": {Data: []byte(`{"lang": "yy"}`)},
+	}, "locales")
+	assert.Error(t, err, "expected a catalog's language to match its file name")
+}
+
+func TestLocales_HaveTheStringsUsed(t *testing.T) {
+	// The keys used by the templates and the script must be in the English catalog, else the key is shown instead.
+	tests := []struct {
+		pattern string
+		re      *regexp.Regexp
+		plural  bool
+	}{
+		{"templates/*.html", regexp.MustCompile(`\b(?:t|tHTML) "([^"]+)"`), false},
+		{"templates/*.html", regexp.MustCompile(`\bn "([^"]+)"`), true},
+		{"static/script.js", regexp.MustCompile(`\bt\('([^']+)'`), false},
+		{"static/script.js", regexp.MustCompile(`\? '(config\.[a-zA-Z]+)'`), false},
+		{"static/script.js", regexp.MustCompile(`\btn\('([^']+)'`), true},
+	}
+	en := locales[fallbackLang]
+	for _, tt := range tests {
+		files, err := fs.Glob(embeddedFiles, tt.pattern)
+		require.NoError(t, err)
+		var matches [][]string
+		for _, name := range files {
+			data, err := embeddedFiles.ReadFile(name)
+			require.NoError(t, err)
+			matches = append(matches, tt.re.FindAllStringSubmatch(string(data), -1)...)
+		}
+		assert.NotEmpty(t, matches, tt.re.String())
+		for _, m := range matches {
+			keys := []string{m[1]}
+			if tt.plural {
+				keys = []string{m[1] + ".one", m[1] + ".other"}
+			}
+			for _, k := range keys {
+				_, ok := en.Strings[k]
+				assert.True(t, ok, "expected %v's %v to be in the English catalog", tt.pattern, k)
+			}
+		}
+	}
+
+	// The keys made up by the handlers and the script.
+	keys := []string{"login.keyRejected", "myTime.noGroup", "error.internal", "mode.allowed", "mode.blocked", "mode.monitoring"}
+	for d := range 7 {
+		keys = append(keys, "day."+strconv.Itoa(d), "day.short."+strconv.Itoa(d))
+	}
+	for _, status := range []string{models.TimeRequestPending, models.TimeRequestApproved, models.TimeRequestDenied} {
+		keys = append(keys, "timeRequest.status."+status)
+	}
+	for _, k := range keys {
+		_, ok := en.Strings[k]
+		assert.True(t, ok, "expected %v to be in the English catalog", k)
+	}
+}
+
+func TestLocale_T(t *testing.T) {
+	l := &Locale{Lang: "en", Strings: map[string]string{
+		"a":          "{0} of {1}, {0} again",
+		"x.one":      "{0} minute of {1}",
+		"x.other":    "{0} minutes of {1}",
+		"strong":     "<strong>{0}</strong> left",
+		"no.args":    "Plain",
+		"unused.arg": "{0} and {2}",
+	}, Formats: LocaleFormats{Time: "15:04", FirstDayOfWeek: time.Monday}}
+
+	assert.Equal(t, "1 of 2, 1 again", l.T("a", 1, 2))
+	assert.Equal(t, "Plain", l.T("no.args", "x"))
+	assert.Equal(t, "x and {2}", l.T("unused.arg", "x"), "expected placeholders without an arg to be kept")
+	assert.Equal(t, "missing", l.T("missing"), "expected the key when there's no string")
+	assert.Equal(t, "1 minute of 60", l.N("x", 1, 60))
+	assert.Equal(t, "0 minutes of 60", l.N("x", 0, 60))
+	assert.Equal(t, "2 minutes of 60", l.N("x", 2, 60))
+	assert.Equal(t, "<strong>&lt;b&gt;</strong> left", string(l.HTML("strong", "<b>")), "expected the args to be escaped")
+	assert.Equal(t, "19:05", l.Clock(time.Date(2026, 10, 14, 19, 5, 0, 0, time.UTC)))
+	assert.Equal(t, []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}, l.Weekdays())
+}
+
+func TestHandler_Locale(t *testing.T) {
+	orig := config.AppCfg.WebConfig.Language
+	t.Cleanup(func() { config.AppCfg.WebConfig.Language = orig })
+	h := &Handler{logger: config.MustGetLogger()}
+
+	tests := []struct {
+		name           string
+		query          string
+		cookie         string
+		acceptLanguage string
+		defaultLang    string
+		want           string
+		wantCookie     bool
+	}{
+		{name: "Nothing asked for", want: "en"},
+		{name: "Browser language", acceptLanguage: "es-MX,es;q=0.9,en;q=0.8", want: "es"},
+		{name: "Browser prefers English", acceptLanguage: "en-GB,es;q=0.5", want: "en"},
+		{name: "Browser language not available", acceptLanguage: "fr-FR", defaultLang: "es", want: "es"},
+		{name: "Invalid browser language", acceptLanguage: ";;;", defaultLang: "es", want: "es"},
+		{name: "Cookie beats the browser", cookie: "es", acceptLanguage: "en", want: "es"},
+		{name: "Unknown cookie", cookie: "xx", acceptLanguage: "es", want: "es"},
+		{name: "Parameter beats the cookie", query: "?lang=en", cookie: "es", want: "en", wantCookie: true},
+		{name: "Unknown parameter", query: "?lang=xx", cookie: "es", want: "es"},
+		{name: "Unknown default", defaultLang: "xx", want: "en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.AppCfg.WebConfig.Language = tt.defaultLang
+			req := httptest.NewRequest(http.MethodGet, "/"+tt.query, nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: langCookieName, Value: tt.cookie})
+			}
+			if tt.acceptLanguage != "" {
+				req.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			rec := httptest.NewRecorder()
+			assert.Equal(t, tt.want, h.locale(rec, req).Lang)
+			cookies := rec.Result().Cookies()
+			if tt.wantCookie && assert.Len(t, cookies, 1) {
+				assert.Equal(t, langCookieName, cookies[0].Name)
+				assert.Equal(t, tt.want, cookies[0].Value)
+			} else if !tt.wantCookie {
+				assert.Empty(t, cookies)
+			}
+		})
+	}
+}
+
+func TestUIHandler(t *testing.T) {
+	h := &Handler{logger: config.MustGetLogger()}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/ui", nil)
+	req.Header.Set("Accept-Language", "es-ES")
+	rec := httptest.NewRecorder()
+	h.uiHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var d UIData
+	require.NoError(t, json.NewDecoder(rec.Body).Decode(&d))
+	assert.Equal(t, "es", d.Lang)
+	assert.Equal(t, "Guardar", d.Strings["common.save"])
+	assert.Equal(t, time.Monday, d.Formats.FirstDayOfWeek)
+	assert.Equal(t, time.Monday, d.Weekdays[0])
+	assert.Equal(t, []Language{{Lang: "en", Name: "English"}, {Lang: "es", Name: "Español"}}, d.Languages)
+
+	rec = httptest.NewRecorder()
+	h.uiHandler(rec, httptest.NewRequest(http.MethodPost, "/api/ui", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestRootHandler_Locale(t *testing.T) {
+	h := &Handler{logger: config.MustGetLogger(), startTime: time.Date(2026, 10, 14, 19, 0, 0, 0, time.UTC)}
+
+	req := httptest.NewRequest(http.MethodGet, "/?lang=es", nil)
+	rec := httptest.NewRecorder()
+	h.rootHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, `<html lang="es">`)
+	assert.Contains(t, body, "Contadores de uso")
+	assert.Contains(t, body, "Inicio: 14/10/2026 19:00 UTC")
+	assert.Contains(t, body, `<option value="1">lunes</option>`)
+	assert.Less(t, strings.Index(body, ">lunes<"), strings.Index(body, ">domingo<"), "expected the days to start on Monday")
+	assert.Contains(t, body, `<option value="es" selected>Español</option>`)
+
+	// The script's strings are embedded in the page as JSON.
+	start := strings.Index(body, `<script id="ui-data" type="application/json">`)
+	require.GreaterOrEqual(t, start, 0)
+	data := body[start+len(`<script id="ui-data" type="application/json">`):]
+	data = data[:strings.Index(data, "</script>")]
+	var d UIData
+	require.NoError(t, json.Unmarshal([]byte(data), &d), data)
+	assert.Equal(t, "es", d.Lang)
+	assert.Equal(t, "Guardar", d.Strings["common.save"])
+}
+
+func TestMyTimeHandler_Locale(t *testing.T) {
+	h := &Handler{
+		logger: config.MustGetLogger(),
+		usageTracker: &mockUsageTracker{
+			summary: map[string]*models.TrackerSummary{"kids": {Used: 45, Total: 100, Percentage: 75, Threshold: 60}},
+			cfg:     models.MapGroupTrackerConfig{"kids": {Threshold: 60 * time.Minute}},
+		},
+		devices:    mockDeviceLookup{"192.168.1.20": "aa:bb:cc:dd:ee:ff"},
+		placements: mockPlacementSource{"aa:bb:cc:dd:ee:ff": {{Group: "kids", AssignedBy: models.AssignedByManual}}},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/my-time", nil)
+	req.RemoteAddr = "192.168.1.20:51234"
+	req.Header.Set("Accept-Language", "es")
+	rec := httptest.NewRecorder()
+	h.myTimeHandler(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `<html lang="es">`)
+	assert.Contains(t, rec.Body.String(), "Quedan <strong>15</strong> de 60 minutos hoy")
+	assert.Contains(t, rec.Body.String(), "Contando el tiempo")
+
+	// The JSON API's errors stay in English.
+	req = httptest.NewRequest(http.MethodGet, "/api/my-time", nil)
+	req.RemoteAddr = "192.168.1.40:51234"
+	req.Header.Set("Accept-Language", "es")
+	rec = httptest.NewRecorder()
+	h.myTimeHandler(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "This device isn't in a group\n", rec.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, "/my-time", nil)
+	req.RemoteAddr = "192.168.1.40:51234"
+	req.Header.Set("Accept-Language", "es")
+	rec = httptest.NewRecorder()
+	h.myTimeHandler(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Este dispositivo no está en ningún grupo")
+}
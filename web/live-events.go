@@ -0,0 +1,237 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"relloyd/tubetimeout/models"
+)
+
+const (
+	liveClientBuffer       = 64               // liveClientBuffer is the number of events queued for each client before they're dropped.
+	liveClientWriteTimeout = 10 * time.Second // liveClientWriteTimeout is how long a client has to accept each event.
+	liveReplaySize         = 1000             // liveReplaySize is the number of events kept for consumers catching up via /api/v1/events/replay.
+	sseKeepAlive           = 30 * time.Second // sseKeepAlive is how often a comment is sent on idle event streams so proxies keep them open.
+)
+
+// LiveHub fans out live events from the usage tracker and traffic monitor to the browsers connected to /ws.
+// Clients that fall behind miss events rather than holding up the packet handling that publishes them.
+// Recent activity and verdict events are kept so that consumers that were offline can catch up. Summaries aren't kept
+// since each one replaces the last and the current usage is at /usage.
+type LiveHub struct {
+	mu          sync.Mutex
+	clients     map[chan models.LiveEvent]struct{}
+	nextID      uint64
+	replay      []models.LiveEvent // replay holds the most recent events, oldest first.
+	started     time.Time
+	lastDropped time.Time // lastDropped is the time of the newest event dropped from replay.
+}
+
+func NewLiveHub() *LiveHub {
+	return &LiveHub{clients: make(map[chan models.LiveEvent]struct{}), started: time.Now()}
+}
+
+// PublishEvent implements the LiveEventReceiver interface.
+// It sets the event's ID and schema before sending it to clients.
+func (l *LiveHub) PublishEvent(e models.LiveEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nextID++
+	e.ID = l.nextID
+	e.Schema = e.Type.Schema()
+	if e.Type != models.LiveEventSummary {
+		if len(l.replay) == liveReplaySize {
+			l.lastDropped = l.replay[0].Time
+			l.replay = append(l.replay[:0], l.replay[1:]...)
+		}
+		l.replay = append(l.replay, e)
+	}
+	for c := range l.clients {
+		select {
+		case c <- e:
+		default: // drop the event if the client's buffer is full.
+		}
+	}
+}
+
+// UpdateThresholdState implements the ThresholdStateReceiver interface to send verdict events.
+func (l *LiveHub) UpdateThresholdState(group models.Group, exceeded bool) {
+	l.PublishEvent(models.LiveEvent{Type: models.LiveEventVerdict, Time: time.Now(), Data: models.VerdictEvent{Group: group, Blocked: exceeded}})
+}
+
+// Replay returns the kept events published after since, oldest first. Truncated is set if some may be missing because
+// they were dropped to make room for newer ones or happened before the app started.
+func (l *LiveHub) Replay(since time.Time) models.EventReplay {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	r := models.EventReplay{
+		Events:    []models.LiveEvent{},
+		Truncated: since.Before(l.started) || since.Before(l.lastDropped),
+	}
+	for _, e := range l.replay {
+		if e.Time.After(since) {
+			r.Events = append(r.Events, e)
+		}
+	}
+	return r
+}
+
+// Subscribe returns a channel of events and a func to call once the caller is no longer reading from it.
+func (l *LiveHub) Subscribe() (<-chan models.LiveEvent, func()) {
+	c := make(chan models.LiveEvent, liveClientBuffer)
+	l.mu.Lock()
+	l.clients[c] = struct{}{}
+	l.mu.Unlock()
+	return c, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.clients, c)
+	}
+}
+
+// wsHandler pushes live events to the browser over a WebSocket, starting with the current usage summary.
+func (h *Handler) wsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.liveEvents == nil {
+		http.Error(w, "Live updates are not available", http.StatusServiceUnavailable)
+		return
+	}
+	s := websocket.Server{Handshake: checkSameOrigin, Handler: h.serveLiveEvents}
+	s.ServeHTTP(w, r)
+}
+
+// eventsReplayHandler returns the events published after the since query param, an RFC 3339 time, so that consumers
+// that were offline can catch up.
+func (h *Handler) eventsReplayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.liveEvents == nil {
+		http.Error(w, "Live updates are not available", http.StatusServiceUnavailable)
+		return
+	}
+	since, err := time.Parse(time.RFC3339, r.URL.Query().Get("since"))
+	if err != nil {
+		http.Error(w, "Invalid since time, expected RFC 3339", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(h.liveEvents.Replay(since)); err != nil {
+		h.logger.Errorf("Error encoding events: %v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) serveLiveEvents(ws *websocket.Conn) {
+	defer ws.Close()
+	events, unsubscribe := h.liveEvents.Subscribe()
+	defer unsubscribe()
+
+	// Notice when the browser goes away since we don't expect it to send anything.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		var msg string
+		for websocket.Message.Receive(ws, &msg) == nil {
+		}
+	}()
+
+	send := func(e models.LiveEvent) bool {
+		_ = ws.SetWriteDeadline(time.Now().Add(liveClientWriteTimeout))
+		if err := websocket.JSON.Send(ws, e); err != nil {
+			h.logger.Debugf("Live updates client %v went away: %v", ws.Request().RemoteAddr, err)
+			return false
+		}
+		return true
+	}
+
+	_ = ws.SetReadDeadline(time.Time{}) // clear the server's read timeout for this long-lived connection.
+	if !send(models.LiveEvent{Schema: models.LiveEventSummary.Schema(), Type: models.LiveEventSummary, Time: time.Now(), Data: h.usageSummary()}) {
+		return
+	}
+	for {
+		select {
+		case <-closed:
+			return
+		case e := <-events:
+			if !send(e) {
+				return
+			}
+		}
+	}
+}
+
+// dhcpEventsHandler streams the DHCP server's state changes, conflicts and static IP changes as server-sent events,
+// starting with the current state, so that the web page doesn't need to poll /dhcp.
+func (h *Handler) dhcpEventsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.dhcpEvents == nil {
+		http.Error(w, "DHCP events are not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{}) // clear the server's write timeout for this long-lived stream.
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		h.logger.Errorf("Error streaming DHCP events: %v", err)
+		return
+	}
+
+	events, unsubscribe := h.dhcpEvents.SubscribeEvents()
+	defer unsubscribe()
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		case e := <-events:
+			var b []byte
+			if b, err = json.Marshal(e); err != nil {
+				h.logger.Errorf("Error encoding DHCP event: %v", err)
+				continue
+			}
+			_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, b)
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			h.logger.Debugf("DHCP events client %v went away: %v", r.RemoteAddr, err)
+			return
+		}
+	}
+}
+
+// checkSameOrigin rejects WebSocket connections from pages served by other sites.
+func checkSameOrigin(cfg *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" { // if this isn't a browser...
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host != r.Host {
+		return websocket.ErrBadWebSocketOrigin
+	}
+	cfg.Origin = u
+	return nil
+}
@@ -0,0 +1,216 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/websocket"
+	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/dhcp"
+	"relloyd/tubetimeout/models"
+)
+
+type mockLiveUsageTracker struct {
+	mockUsageTracker
+}
+
+func (m *mockLiveUsageTracker) GetDeviceSummary() map[string]map[models.MAC]*models.TrackerSummary {
+	return nil
+}
+
+func TestLiveHub_DropsEventsForSlowClients(t *testing.T) {
+	hub := NewLiveHub()
+	events, unsubscribe := hub.Subscribe()
+	for i := 0; i < liveClientBuffer+10; i++ {
+		hub.PublishEvent(models.LiveEvent{Type: models.LiveEventActivity})
+	}
+	assert.Len(t, events, liveClientBuffer, "expected events beyond the client's buffer to be dropped")
+
+	unsubscribe()
+	hub.PublishEvent(models.LiveEvent{Type: models.LiveEventActivity})
+	assert.Len(t, events, liveClientBuffer, "expected no events after unsubscribing")
+}
+
+func TestLiveHub_Replay(t *testing.T) {
+	hub := NewLiveHub()
+	start := time.Now()
+	for i := 0; i < liveReplaySize+5; i++ {
+		hub.PublishEvent(models.LiveEvent{Type: models.LiveEventVerdict, Time: start.Add(time.Duration(i) * time.Second)})
+	}
+	hub.PublishEvent(models.LiveEvent{Type: models.LiveEventSummary, Time: start.Add(time.Hour)})
+
+	r := hub.Replay(start.Add(time.Duration(liveReplaySize) * time.Second))
+	if assert.Len(t, r.Events, 4, "expected events after since only, without summaries") {
+		assert.Equal(t, uint64(liveReplaySize+2), r.Events[0].ID)
+		assert.Equal(t, "tubetimeout.verdict.v1", r.Events[0].Schema)
+	}
+	assert.False(t, r.Truncated)
+
+	r = hub.Replay(start.Add(time.Second))
+	assert.Len(t, r.Events, liveReplaySize)
+	assert.True(t, r.Truncated, "expected dropped events to be reported")
+
+	assert.True(t, hub.Replay(start.Add(-time.Hour)).Truncated, "expected events from before the app started to be reported as missing")
+}
+
+func TestEventsReplayHandler(t *testing.T) {
+	hub := NewLiveHub()
+	h := &Handler{logger: config.MustGetLogger(), liveEvents: hub}
+	hub.UpdateThresholdState("kids", true)
+
+	rr := httptest.NewRecorder()
+	since := hub.started.Add(-time.Second).UTC().Format(time.RFC3339Nano)
+	h.eventsReplayHandler(rr, httptest.NewRequest(http.MethodGet, "/api/v1/events/replay?since="+since, nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var got struct {
+		Events []struct {
+			ID     uint64               `json:"id"`
+			Schema string               `json:"schema"`
+			Type   models.LiveEventType `json:"type"`
+			Data   map[string]any       `json:"data"`
+		} `json:"events"`
+		Truncated bool `json:"truncated"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &got))
+	if assert.Len(t, got.Events, 1) {
+		assert.Equal(t, uint64(1), got.Events[0].ID)
+		assert.Equal(t, "tubetimeout.verdict.v1", got.Events[0].Schema)
+		assert.Equal(t, map[string]any{"group": "kids", "blocked": true}, got.Events[0].Data)
+	}
+
+	rr = httptest.NewRecorder()
+	h.eventsReplayHandler(rr, httptest.NewRequest(http.MethodGet, "/api/v1/events/replay?since=yesterday", nil))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	h.eventsReplayHandler(rr, httptest.NewRequest(http.MethodPost, "/api/v1/events/replay", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+}
+
+func TestWsHandler(t *testing.T) {
+	hub := NewLiveHub()
+	h := &Handler{
+		logger:        config.MustGetLogger(),
+		usageTracker:  &mockLiveUsageTracker{mockUsageTracker{summary: map[string]*models.TrackerSummary{"kids": {Used: 5, Total: 60}}}},
+		monitor:       &mockMonitor{},
+		activityCache: newReadThroughCache[map[models.Group]map[models.MAC]time.Time](time.Hour),
+		liveEvents:    hub,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(h.wsHandler))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	ws, err := websocket.Dial(wsURL, "", srv.URL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer ws.Close()
+	_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// Expect the current usage first.
+	var e struct {
+		Type models.LiveEventType `json:"type"`
+		Data map[string]any       `json:"data"`
+	}
+	assert.NoError(t, websocket.JSON.Receive(ws, &e))
+	assert.Equal(t, models.LiveEventSummary, e.Type)
+	assert.Contains(t, e.Data, "kids")
+
+	// Expect published events to follow.
+	assert.Eventually(t, func() bool {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		return len(hub.clients) == 1
+	}, time.Second, 10*time.Millisecond)
+	hub.UpdateThresholdState("kids", true)
+	e.Data = nil
+	assert.NoError(t, websocket.JSON.Receive(ws, &e))
+	assert.Equal(t, models.LiveEventVerdict, e.Type)
+	assert.Equal(t, map[string]any{"group": "kids", "blocked": true}, e.Data)
+
+	// Expect the client to be removed once it goes away.
+	_ = ws.Close()
+	assert.Eventually(t, func() bool {
+		hub.mu.Lock()
+		defer hub.mu.Unlock()
+		return len(hub.clients) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestWsHandler_RejectsOtherOrigins(t *testing.T) {
+	h := &Handler{logger: config.MustGetLogger(), liveEvents: NewLiveHub()}
+	srv := httptest.NewServer(http.HandlerFunc(h.wsHandler))
+	defer srv.Close()
+
+	cfg, err := websocket.NewConfig("ws"+strings.TrimPrefix(srv.URL, "http"), "http://evil.example.com")
+	assert.NoError(t, err)
+	_, err = cfg.DialContext(context.Background())
+	assert.Error(t, err, "expected a page from another site to be refused")
+}
+
+type mockDHCPEvents struct {
+	events       chan dhcp.Event
+	unsubscribed chan struct{}
+}
+
+func (m *mockDHCPEvents) SubscribeEvents() (<-chan dhcp.Event, func()) {
+	return m.events, func() { close(m.unsubscribed) }
+}
+
+func TestDHCPEventsHandler(t *testing.T) {
+	de := &mockDHCPEvents{events: make(chan dhcp.Event, 2), unsubscribed: make(chan struct{})}
+	de.events <- dhcp.Event{ID: 1, Type: dhcp.EventState, State: "active"}
+	h := &Handler{logger: config.MustGetLogger(), dhcpEvents: de}
+	srv := httptest.NewServer(http.HandlerFunc(h.dhcpEventsHandler))
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		var lines []string
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil || line == "\n" {
+				return strings.Join(lines, "")
+			}
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, "id: 1\nevent: state\ndata: {\"id\":1,\"type\":\"state\",\"time\":\"0001-01-01T00:00:00Z\",\"state\":\"active\"}\n", readEvent())
+	de.events <- dhcp.Event{ID: 2, Type: dhcp.EventConflict, State: "active", Message: "router"}
+	assert.Contains(t, readEvent(), "event: conflict\n")
+
+	// Expect the subscription to end once the client goes away.
+	cancel()
+	select {
+	case <-de.unsubscribed:
+	case <-time.After(time.Second):
+		t.Error("expected the handler to unsubscribe")
+	}
+}
+
+func TestDHCPEventsHandler_Unavailable(t *testing.T) {
+	h := &Handler{logger: config.MustGetLogger()}
+	w := httptest.NewRecorder()
+	h.dhcpEventsHandler(w, httptest.NewRequest(http.MethodGet, "/api/dhcp/events", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	w = httptest.NewRecorder()
+	h.dhcpEventsHandler(w, httptest.NewRequest(http.MethodPost, "/api/dhcp/events", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
@@ -0,0 +1,92 @@
+package web
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxPageLimit = 500
+
+// page is the offset and limit query params of a list request. A zero limit means the rest of the list.
+type page struct {
+	offset int
+	limit  int
+}
+
+// parsePage reads the offset and limit query params, ignoring invalid values and capping the limit at maxPageLimit.
+func parsePage(r *http.Request) page {
+	var p page
+	if offset, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && offset > 0 {
+		p.offset = offset
+	}
+	if limit, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && limit > 0 {
+		p.limit = min(limit, maxPageLimit)
+	}
+	return p
+}
+
+// bounds returns the start and end indexes of the page in a list of n items.
+func (p page) bounds(n int) (int, int) {
+	start := min(p.offset, n)
+	if p.limit == 0 {
+		return start, n
+	}
+	return start, min(start+p.limit, n)
+}
+
+// setPageHeaders sets X-Total-Count to the number of items matching the filters, so that clients can page through
+// them while the body keeps its usual shape.
+func setPageHeaders(w http.ResponseWriter, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+}
+
+// matchesName returns true if the filter is empty or a case-insensitive substring of any of the values.
+func matchesName(filter string, values ...string) bool {
+	if filter == "" {
+		return true
+	}
+	filter = strings.ToLower(filter)
+	for _, v := range values {
+		if strings.Contains(strings.ToLower(v), filter) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSONWithETag encodes v with an ETag of its content and responds 304 Not Modified without a body if the request's
+// If-None-Match has it already. Cache-Control: no-cache makes browsers revalidate each poll, so an unchanged list
+// costs a round trip instead of a download.
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v any) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// etagMatches returns true if the If-None-Match header lists the ETag, comparing weakly as RFC 9110 asks, or is "*".
+func etagMatches(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimPrefix(strings.TrimSpace(v), "W/")
+		if v == "*" || v == etag {
+			return true
+		}
+	}
+	return false
+}
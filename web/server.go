@@ -3,23 +3,49 @@ package web
 import (
 	"embed"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
 	"go.uber.org/zap"
+	"relloyd/tubetimeout/apikeys"
+	"relloyd/tubetimeout/audit"
 	"relloyd/tubetimeout/config"
+	"relloyd/tubetimeout/control"
 	"relloyd/tubetimeout/dhcp"
 	"relloyd/tubetimeout/ipv6"
 	"relloyd/tubetimeout/models"
+	"relloyd/tubetimeout/telemetry"
 )
 
-//go:embed static/* templates/*
+//go:embed static/* templates/* locales/*
 var embeddedFiles embed.FS
 
+// MyTimeData is rendered by the device portal page.
+type MyTimeData struct {
+	Lang     string
+	Summary  models.KioskSummary
+	ModeName string
+	Error    string
+	// Request is the device group's latest request for more time, if any, and CanRequest is set if it can ask again.
+	Request    *models.TimeRequest
+	CanRequest bool
+}
+
 type TemplateData struct {
 	BuildTime    string
 	BuildVersion string
 	StartTime    string
+	// UI is the locale's strings and formats, embedded in the page for the script.
+	UI UIData
+}
+
+// ConfigChanger validates, saves and audits the changes to device groups, tracker config and modes, the same way for
+// the web and gRPC APIs.
+type ConfigChanger interface {
+	SaveGroupMACs(o control.Origin, gm []config.FlatGroupMAC) error
+	SaveTrackerConfig(o control.Origin, flat []models.FlatTrackerConfig) error
+	SetMode(o control.Origin, group string, d time.Duration, mode models.UsageTrackerMode) (models.TrackerMode, error)
 }
 
 type GroupMACsGroupGetterSetter interface {
@@ -30,15 +56,24 @@ type GroupMACsGroupGetterSetter interface {
 // UsageTracker returns info from the usage tracker.
 type UsageTracker interface {
 	GetSummary() map[string]*models.TrackerSummary
+	GetDeviceSummary() map[string]map[models.MAC]*models.TrackerSummary
+	GetSamples(id string) (models.TrackerSamples, error)
 	SetMode(id string, d time.Duration, mode models.UsageTrackerMode) error
 	GetModeEndTime(id string) (models.TrackerMode, error)
 	Reset(id string)
+	TransferBudget(from, to string, minutes int) (models.BudgetTransfer, error)
+	ModeHistory(group models.Group, limit int) ([]models.ModeTransition, error)
 	GetConfig() (models.MapGroupTrackerConfig, error)
 	SetConfig(m models.MapGroupTrackerConfig) error
+	ExportSamples() ([]byte, error)
+	ImportSamples(data []byte) error
+	HasExceededThreshold(id string) bool
+	PacketPolicy(id string) *models.PacketPolicy
 }
 
 type Monitor interface {
 	GetTrafficLastActiveTimes() map[models.Group]map[models.MAC]time.Time
+	GetDomainTraffic() map[models.Group]map[models.Domain]*models.DomainTraffic
 }
 
 type DHCPConfigGetterSetter interface {
@@ -50,6 +85,187 @@ type IPV6Checker interface {
 	IsEnabled() ipv6.Status
 }
 
+type Telemetry interface {
+	GetPreview() telemetry.Preview
+	SetEnabled(enabled bool) error
+}
+
+// PacketStats returns packet counts and rates handled by the nfqueue filter.
+type PacketStats interface {
+	GetPacketRates() []models.PacketRates
+	GetVerdictLatencies() []models.VerdictLatency
+	GetPacketDecisions() models.PacketDecisions
+}
+
+// APIKeyStore manages API keys and their roles.
+type APIKeyStore interface {
+	List() []apikeys.Key
+	Create(name string, group models.Group, role apikeys.Role) (apikeys.Key, string, error)
+	Delete(id string) error
+	Lookup(token string) (apikeys.Key, bool)
+	HasRole(role apikeys.Role) bool
+}
+
+// AuditLog records admin actions and returns them a page at a time.
+type AuditLog interface {
+	Record(e audit.Entry) error
+	Page(offset, limit int) ([]audit.Entry, int, error)
+}
+
+// ConfigBackup archives and restores all config files.
+type ConfigBackup interface {
+	WriteBackup(w io.Writer) error
+	RestoreBackup(r io.Reader) ([]string, error)
+}
+
+// HealthChecker reports the status of a subsystem for /api/health.
+type HealthChecker interface {
+	Health() models.SubsystemHealth
+}
+
+// ReadinessReporter reports whether a stage of filtering has what it needs at startup.
+type ReadinessReporter interface {
+	Readiness() models.Readiness
+}
+
+// PlacementSource reports why each device seen on the network is in its groups.
+type PlacementSource interface {
+	Placements() map[models.MAC][]models.Placement
+}
+
+// LiveEvents returns events to push to the dashboard as they happen.
+type LiveEvents interface {
+	Subscribe() (<-chan models.LiveEvent, func())
+	Replay(since time.Time) models.EventReplay
+}
+
+// FreshnessSource reports when a watcher last updated the data it feeds to the web layer.
+type FreshnessSource interface {
+	Freshness() models.Freshness
+}
+
+// NFTDiagnostics returns the live contents of the NFT table for troubleshooting.
+type NFTDiagnostics interface {
+	Snapshot() (models.NFTSnapshot, error)
+}
+
+// PacketCapture records the headers of a group's packets for debugging.
+type PacketCapture interface {
+	Captures() []models.CaptureState
+	SetCapture(group models.Group, enabled bool) (models.CaptureState, error)
+	WritePcap(w io.Writer, group models.Group) error
+}
+
+// DeviceTracer records every verdict decision for a device's packets for a time, to diagnose one device.
+type DeviceTracer interface {
+	Traces() []models.TraceState
+	StartTrace(mac models.MAC, duration time.Duration) (models.TraceState, error)
+	StopTrace(mac models.MAC) (models.TraceState, error)
+	WriteTrace(w io.Writer, mac models.MAC) error
+}
+
+// DeviceLookup finds the MAC address of a device on the LAN from its IP.
+type DeviceLookup interface {
+	GetMAC(ip models.Ip) (models.MAC, bool)
+}
+
+// ResolutionPauser stops updating a domain group's IPs for a while, to contain suspected false positives.
+type ResolutionPauser interface {
+	ResolutionPauses() []models.ResolutionPause
+	PauseResolution(group models.Group, mode models.ResolutionPauseMode, d time.Duration) (models.ResolutionPause, error)
+	ResumeResolution(group models.Group) error
+}
+
+// KillSwitch blocks all internet access for tracked devices while it's on.
+type KillSwitch interface {
+	State() models.KillSwitchState
+	Set(on bool, source string) models.KillSwitchState
+}
+
+// UsageReports makes weekly reports of each group's usage.
+type UsageReports interface {
+	Reports(end time.Time) []models.UsageReport
+	Report(group models.Group, end time.Time) (models.UsageReport, error)
+}
+
+// GroupDomainsGetterSetter loads and saves the domains tracked for each group.
+type GroupDomainsGetterSetter interface {
+	GetGroupDomains(logger *zap.SugaredLogger) (models.MapGroupDomains, error)
+	SaveGroupDomains(logger *zap.SugaredLogger, m models.MapGroupDomains) error
+}
+
+// DomainReloader reloads the group domains and resolves their IPs again.
+type DomainReloader interface {
+	Reload() error
+}
+
+// NetworkDetector detects the network and proposes the DHCP settings for it, for the setup wizard.
+type NetworkDetector interface {
+	ProposeConfig() (dhcp.Network, *dhcp.DNSMasqConfig, error)
+}
+
+// DHCPEvents streams the DHCP server's state changes, conflicts and static IP changes.
+type DHCPEvents interface {
+	SubscribeEvents() (<-chan dhcp.Event, func())
+}
+
+// GroupMembership resolves the groups of a device on the LAN and the domains tracked for each group.
+type GroupMembership interface {
+	GroupsForIP(ip models.Ip) []models.Group
+	GroupsForMAC(mac models.MAC) []models.Group
+	IpsForMAC(mac models.MAC) []models.Ip
+	DomainsForGroup(group models.Group) []models.Domain
+}
+
+// ProfileScheduler activates the configuration profiles, e.g. holiday mode, that change the trackers for a while.
+type ProfileScheduler interface {
+	State() models.ProfileState
+	SetProfiles(profiles []models.Profile) (models.ProfileState, error)
+	Activate(name string, until time.Time) (models.ProfileState, error)
+	Deactivate() (models.ProfileState, error)
+}
+
+// LogLevelSetter changes the log level overall or of a package at runtime, e.g. to debug one without the others.
+type LogLevelSetter interface {
+	State() models.LogLevels
+	Set(pkg, level string) (models.LogLevels, error)
+}
+
+// TimeRequestQueue keeps the devices' requests for more time for an admin to approve or deny.
+type TimeRequestQueue interface {
+	Request(group models.Group, mac models.MAC, minutes int, reason string) (models.TimeRequest, error)
+	Approve(id string, minutes int, allow bool, note string) (models.TimeRequest, error)
+	Deny(id string, note string) (models.TimeRequest, error)
+	Requests(group models.Group, status string) []models.TimeRequest
+}
+
+// SnapshotStore takes, lists and restores the nightly config snapshots.
+type SnapshotStore interface {
+	Snapshot() (config.SnapshotInfo, error)
+	Snapshots() ([]config.SnapshotInfo, error)
+	OpenSnapshot(name string) (io.ReadCloser, error)
+	RestoreSnapshot(name string) ([]string, error)
+}
+
+// DNSForwarder is the DNS forwarder's log of the devices' lookups and the DNS policy of each group.
+type DNSForwarder interface {
+	Queries(client models.Ip) []models.DNSQuery
+	Policy() models.MapGroupDNSPolicy
+	SetPolicy(m models.MapGroupDNSPolicy) error
+}
+
+// ExemptionList is the devices whose traffic the firewall leaves alone.
+type ExemptionList interface {
+	Exemptions() []models.Exemption
+	SetExemptions(exemptions []models.Exemption) error
+}
+
+// BandwidthSource returns the recent traffic of each device through the filter.
+type BandwidthSource interface {
+	GetBandwidth(mac models.MAC) (models.Bandwidth, bool)
+	GetAllBandwidth() []models.Bandwidth
+}
+
 type Handler struct {
 	logger                 *zap.SugaredLogger
 	startTime              time.Time
@@ -58,25 +274,182 @@ type Handler struct {
 	monitor                Monitor
 	dhcpConfigGetterSetter DHCPConfigGetterSetter
 	ipv6Checker            IPV6Checker
+	telemetry              Telemetry
+	packetStats            PacketStats
+	apiKeys                APIKeyStore
+	auditLog               AuditLog
+	control                ConfigChanger
+	backup                 ConfigBackup
+	healthCheckers         []HealthChecker
+	freshnessSources       []FreshnessSource
+	readinessReporters     []ReadinessReporter
+	placements             PlacementSource
+	liveEvents             LiveEvents
+	killSwitch             KillSwitch
+	nftDiagnostics         NFTDiagnostics
+	packetCapture          PacketCapture
+	resolutionPauser       ResolutionPauser
+	devices                DeviceLookup
+	reports                UsageReports
+	groupDomains           GroupDomainsGetterSetter
+	domainReloader         DomainReloader
+	bandwidth              BandwidthSource
+	networkDetector        NetworkDetector
+	dhcpEvents             DHCPEvents
+	membership             GroupMembership
+	profiles               ProfileScheduler
+	logLevels              LogLevelSetter
+	timeRequests           TimeRequestQueue
+	snapshots              SnapshotStore
+	dnsForwarder           DNSForwarder
+	tracer                 DeviceTracer
+	exemptions             ExemptionList
+	activityCache          *readThroughCache[map[models.Group]map[models.MAC]time.Time]
+}
+
+// ServerDeps are the components behind the web server's endpoints. The optional ones are nil when their feature is
+// off, e.g. DNSForwarder when DNS_FORWARDER_ENABLED isn't set.
+type ServerDeps struct {
+	UsageTracker       UsageTracker
+	GroupMACs          GroupMACsGroupGetterSetter
+	Monitor            Monitor
+	DHCPConfig         DHCPConfigGetterSetter
+	IPV6Checker        IPV6Checker
+	Telemetry          Telemetry
+	PacketStats        PacketStats
+	APIKeys            APIKeyStore
+	AuditLog           AuditLog
+	Control            ConfigChanger
+	Backup             ConfigBackup
+	HealthCheckers     []HealthChecker
+	FreshnessSources   []FreshnessSource
+	ReadinessReporters []ReadinessReporter
+	Placements         PlacementSource
+	LiveEvents         LiveEvents
+	KillSwitch         KillSwitch
+	NFTDiagnostics     NFTDiagnostics
+	PacketCapture      PacketCapture
+	ResolutionPauser   ResolutionPauser
+	Devices            DeviceLookup
+	Reports            UsageReports
+	GroupDomains       GroupDomainsGetterSetter
+	DomainReloader     DomainReloader
+	Bandwidth          BandwidthSource
+	NetworkDetector    NetworkDetector
+	DHCPEvents         DHCPEvents
+	Membership         GroupMembership
+	Profiles           ProfileScheduler
+	LogLevels          LogLevelSetter
+	TimeRequests       TimeRequestQueue
+	Snapshots          SnapshotStore
+	DNSForwarder       DNSForwarder
+	Tracer             DeviceTracer
+	Exemptions         ExemptionList
 }
 
-func NewServer(logger *zap.SugaredLogger, ut UsageTracker, gm GroupMACsGroupGetterSetter, m Monitor, d DHCPConfigGetterSetter, ipv6Checker IPV6Checker) *http.Server {
-	h := Handler{logger: logger, startTime: time.Now(), usageTracker: ut, groupMACsGetterSetter: gm, monitor: m, dhcpConfigGetterSetter: d, ipv6Checker: ipv6Checker}
+func NewServer(logger *zap.SugaredLogger, d ServerDeps) *http.Server {
+	h := Handler{
+		logger:                 logger,
+		startTime:              time.Now(),
+		usageTracker:           d.UsageTracker,
+		groupMACsGetterSetter:  d.GroupMACs,
+		monitor:                d.Monitor,
+		dhcpConfigGetterSetter: d.DHCPConfig,
+		ipv6Checker:            d.IPV6Checker,
+		telemetry:              d.Telemetry,
+		packetStats:            d.PacketStats,
+		apiKeys:                d.APIKeys,
+		auditLog:               d.AuditLog,
+		control:                d.Control,
+		backup:                 d.Backup,
+		healthCheckers:         d.HealthCheckers,
+		freshnessSources:       d.FreshnessSources,
+		readinessReporters:     d.ReadinessReporters,
+		placements:             d.Placements,
+		liveEvents:             d.LiveEvents,
+		killSwitch:             d.KillSwitch,
+		nftDiagnostics:         d.NFTDiagnostics,
+		packetCapture:          d.PacketCapture,
+		resolutionPauser:       d.ResolutionPauser,
+		devices:                d.Devices,
+		reports:                d.Reports,
+		groupDomains:           d.GroupDomains,
+		domainReloader:         d.DomainReloader,
+		bandwidth:              d.Bandwidth,
+		networkDetector:        d.NetworkDetector,
+		dhcpEvents:             d.DHCPEvents,
+		membership:             d.Membership,
+		profiles:               d.Profiles,
+		logLevels:              d.LogLevels,
+		timeRequests:           d.TimeRequests,
+		snapshots:              d.Snapshots,
+		dnsForwarder:           d.DNSForwarder,
+		tracer:                 d.Tracer,
+		exemptions:             d.Exemptions,
+	}
+	h.activityCache = newReadThroughCache[map[models.Group]map[models.MAC]time.Time](defaultCacheTTL)
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", h.rootHandler)
 	mux.HandleFunc("/static/", h.staticHandler)
+	mux.HandleFunc("/api/ui", h.uiHandler)
 	mux.HandleFunc("/groups", h.groupMACHandler)
 	mux.HandleFunc("/trackerConfig", h.trackerConfigHandler)
 	mux.HandleFunc("/usage", h.usageHandler)       // TODO: probably convert this to /tracker/<group-id>/usage
 	mux.HandleFunc("/activity", h.activityHandler) // TODO: rename either monitor or activity to be consistent
 	mux.HandleFunc("/mode", h.modeHandler)         // TODO: move /pause to a sub context under group
 	mux.HandleFunc("/reset", h.resetGroupHandler)
+	mux.HandleFunc("/transfer", h.transferHandler)
 	mux.HandleFunc("/dhcp", h.dhcpHandler)
 	mux.HandleFunc("/ipv6", h.ipv6Handler)
+	mux.HandleFunc("/telemetry", h.telemetryHandler)
+	mux.HandleFunc("/health", h.healthHandler)
+	mux.HandleFunc("/metrics", h.metricsHandler)
+	mux.HandleFunc("/apiKeys", h.apiKeysHandler)
+	mux.HandleFunc("/kiosk", h.kioskHandler)
+	mux.HandleFunc("/login", h.loginHandler)
+	mux.HandleFunc("/logout", h.logoutHandler)
+	mux.HandleFunc("/my-time", h.myTimeHandler)
+	mux.HandleFunc("/api/my-time", h.myTimeHandler)
+	mux.HandleFunc("/my-time/request", h.myTimeRequestHandler)
+	mux.HandleFunc("/api/my-time/requests", h.myTimeRequestHandler)
+	mux.HandleFunc("/api/time-requests", h.timeRequestsHandler)
+	mux.HandleFunc("/api/time-requests/approve", h.timeRequestDecisionHandler)
+	mux.HandleFunc("/api/time-requests/deny", h.timeRequestDecisionHandler)
+	mux.HandleFunc("/api/health", h.apiHealthHandler)
+	mux.HandleFunc("/api/freshness", h.freshnessHandler)
+	mux.HandleFunc("/api/audit", h.auditHandler)
+	mux.HandleFunc("/api/mode-history", h.modeHistoryHandler)
+	mux.HandleFunc("/api/usage/samples", h.usageSamplesHandler)
+	mux.HandleFunc("/api/usage/export", h.usageExportHandler)
+	mux.HandleFunc("/api/usage/import", h.usageImportHandler)
+	mux.HandleFunc("/api/reports", h.reportsHandler)
+	mux.HandleFunc("/api/group-domains", h.groupDomainsHandler)
+	mux.HandleFunc("/api/bandwidth", h.bandwidthHandler)
+	mux.HandleFunc("/api/lookup", h.lookupHandler)
+	mux.HandleFunc("/api/profiles", h.profilesHandler)
+	mux.HandleFunc("/api/profiles/active", h.activeProfileHandler)
+	mux.HandleFunc("/api/setup", h.setupHandler)
+	mux.HandleFunc("/api/kill-switch", h.killSwitchHandler)
+	mux.HandleFunc("/api/log-level", h.logLevelHandler)
+	mux.HandleFunc("/api/diagnostics/nft", h.nftDiagnosticsHandler)
+	mux.HandleFunc("/api/capture", h.captureHandler)
+	mux.HandleFunc("/api/capture/pcap", h.capturePcapHandler)
+	mux.HandleFunc("/api/trace", h.traceHandler)
+	mux.HandleFunc("/api/resolution-pause", h.resolutionPauseHandler)
+	mux.HandleFunc("/api/backup", h.backupHandler)
+	mux.HandleFunc("/api/restore", h.restoreHandler)
+	mux.HandleFunc("/api/snapshots", h.snapshotsHandler)
+	mux.HandleFunc("/api/snapshots/restore", h.snapshotRestoreHandler)
+	mux.HandleFunc("/api/dns/queries", h.dnsQueriesHandler)
+	mux.HandleFunc("/api/dns/policy", h.dnsPolicyHandler)
+	mux.HandleFunc("/api/exemptions", h.exemptionsHandler)
+	mux.HandleFunc("/ws", h.wsHandler)
+	mux.HandleFunc("/api/v1/events/replay", h.eventsReplayHandler)
+	mux.HandleFunc("/api/dhcp/events", h.dhcpEventsHandler)
 
 	return &http.Server{
 		Addr:                         fmt.Sprintf(":%d", config.AppCfg.WebConfig.WebPort),
-		Handler:                      mux,
+		Handler:                      h.apiKeyScope(mux),
 		DisableGeneralOptionsHandler: false,
 		TLSConfig:                    nil,
 		ReadTimeout:                  30 * time.Second, // Maximum duration for reading the request body
@@ -87,6 +460,25 @@ func NewServer(logger *zap.SugaredLogger, ut UsageTracker, gm GroupMACsGroupGett
 	}
 }
 
+// NewBlockPageServer returns a server for the "time's up" page that plain HTTP from blocked devices is redirected to
+// on FILTER_BLOCK_PAGE_PORT. Every path shows the page since the requests are for other sites.
+func NewBlockPageServer(logger *zap.SugaredLogger, ut UsageTracker, pl PlacementSource, dl DeviceLookup) *http.Server {
+	h := Handler{logger: logger, startTime: time.Now(), usageTracker: ut, placements: pl, devices: dl}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/static/", h.staticHandler)
+	mux.HandleFunc("/", h.blockPageHandler)
+
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", config.AppCfg.FilterConfig.BlockPagePort),
+		Handler:           mux,
+		ReadTimeout:       30 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       30 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+	}
+}
+
 // Mock file modification time (for cache control)
 func fileModTime() time.Time {
 	t, err := time.Parse(time.RFC3339, config.BuildTime)
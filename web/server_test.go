@@ -1,8 +1,14 @@
 package web
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
+
+	"github.com/stretchr/testify/assert"
+	"relloyd/tubetimeout/apikeys"
+	"relloyd/tubetimeout/config"
 )
 
 // TestFormatDuration tests the FormatDuration function
@@ -53,3 +59,21 @@ func TestFormatDuration(t *testing.T) {
 		})
 	}
 }
+
+func TestNewServer(t *testing.T) {
+	s := NewServer(config.MustGetLogger(), ServerDeps{
+		PacketStats: &mockPacketStats{},
+		APIKeys:     &mockAPIKeyStore{keys: map[string]apikeys.Key{"admin": {ID: "1", Role: apikeys.RoleAdmin}}},
+	})
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/health", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	s.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code, "expected the handlers to use the deps")
+
+	rec = httptest.NewRecorder()
+	req.Header.Set("Authorization", "Bearer bad")
+	s.Handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code, "expected requests to be checked against the API keys")
+}
@@ -9,18 +9,44 @@ document.addEventListener('DOMContentLoaded', () => {
     const UrlUsageAPI = '/usage';
     const UrlTrackerAPI = '/trackerConfig';
 
+    // The page's language, strings and formats are chosen by the server and embedded in the page, see /api/ui.
+    const ui = JSON.parse(document.getElementById('ui-data').textContent);
+
+    // t returns the string for key with the args in place of {0}, {1} and so on, or the key if there's no string.
+    function t(key, ...args) {
+        const s = ui.strings[key];
+        if (s === undefined) return key;
+        return s.replace(/\{(\d+)\}/g, (m, i) => i < args.length ? args[i] : m);
+    }
+
+    // tn returns the plural form of key for n, i.e. key.one if n is 1 or key.other, with n as {0}.
+    function tn(key, n, ...args) {
+        return t(`${key}.${n === 1 ? 'one' : 'other'}`, n, ...args);
+    }
+
+    // formatClock returns the time of day of date in the page's language.
+    function formatClock(date) {
+        return new Date(date).toLocaleTimeString(ui.lang, { hour: '2-digit', minute: '2-digit', hour12: ui.formats.hour12 });
+    }
+
+    // formatDateTime returns date with its time of day in the page's language.
+    function formatDateTime(date) {
+        return new Date(date).toLocaleString(ui.lang, { hour12: ui.formats.hour12 });
+    }
+
     const nanosecondsPerMinute = 1e9 * 60; // 1e9 nanoseconds per second * 60 seconds
     const nanosecondsPerHour = 1e9 * 60 * 60; // ... * 60 mins
     const nanosecondsPerDay = 1e9 * 60 * 60 * 24; // ... * 24 hours
 
     let groupMACs = []; // device groups
-    let groups = [];  // groups will be an array of objects, each with: { name, retention, threshold, startDay, startDuration, currentMode, modeEndTime }
+    let groups = [];  // groups will be an array of objects, each with: { name, retention, threshold, startDay, startDayOfMonth, window, startDuration, countFrom, countUntil, blockOutsideHours, maxSession, breakDuration, minActive, minActiveWindow, warnAt, gracePeriod, packetSampling, rollover, rolloverCap, dayThresholds, packetPolicy, currentMode, modeEndTime }
     let usageData = {};
     let availableMACs = [];
 
     // Hide group-start-day select box when group-retention is set to 1 day.
     const groupRetentionSelect = document.getElementById('group-retention');
     const groupStartDayField = document.querySelector('label[for="group-start-day"]').parentElement;
+    const groupStartDayOfMonthField = document.querySelector('label[for="group-start-day-of-month"]').parentElement;
 
     // ---------- Helper functions for AJAX requests ----------
     async function postData(url, data) {
@@ -33,7 +59,7 @@ document.addEventListener('DOMContentLoaded', () => {
             showNotification(await response.text(), false);
 
         } catch (error) {
-            showNotification("Error: " + error.message, true);
+            showNotification(t('error.prefix', error.message), true);
         }
     }
 
@@ -47,14 +73,14 @@ document.addEventListener('DOMContentLoaded', () => {
             });
             showNotification(await response.text(), false);
         } catch (error) {
-            showNotification("Error: " + error.message, true);
+            showNotification(t('error.prefix', error.message), true);
         }
     }
 
     /*
     * Converts a Go duration (in nanoseconds) to a human-readable string.
     * @param {number} duration - The duration in nanoseconds.
-    * @returns {string} - A human-readable string (e.g., "45 minutes" or "1 hour 15 minutes").
+    * @returns {string} - A human-readable string in the page's language (e.g., "45 minutes" or "1 hour 15 minutes").
     */
     function humaniseDuration(duration) {
         // Define conversion constants
@@ -67,7 +93,7 @@ document.addEventListener('DOMContentLoaded', () => {
             // For durations less than one hour, show minutes only.
             const minutes = Math.floor(duration / nsInMinute);
             if (minutes > 0) {
-                return `${minutes} minute${minutes === 1 ? "" : "s"}`;
+                return tn('duration.minutes', minutes);
             } else {
                 return ""
             }
@@ -75,9 +101,9 @@ document.addEventListener('DOMContentLoaded', () => {
             // For durations less than one day, show hours and minutes.
             const hours = Math.floor(duration / nsInHour);
             const minutes = Math.floor((duration % nsInHour) / nsInMinute);
-            let result = `${hours} hour${hours !== 1 ? "s" : ""}`;
+            let result = tn('duration.hours', hours);
             if (minutes > 0) {
-                result += ` ${minutes} minute${minutes !== 1 ? "s" : ""}`;
+                result += ' ' + tn('duration.minutes', minutes);
             }
             return result;
         } else {
@@ -87,12 +113,12 @@ document.addEventListener('DOMContentLoaded', () => {
             const hours = Math.floor(remainder / nsInHour);
             const minutes = Math.floor((remainder % nsInHour) / nsInMinute);
 
-            let result = `${days} day${days !== 1 ? "s" : ""}`;
+            let result = tn('duration.days', days);
             if (hours > 0) {
-                result += ` ${hours} hour${hours !== 1 ? "s" : ""}`;
+                result += ' ' + tn('duration.hours', hours);
             }
             if (minutes > 0) {
-                result += ` ${minutes} minute${minutes !== 1 ? "s" : ""}`;
+                result += ' ' + tn('duration.minutes', minutes);
             }
             return result;
         }
@@ -159,19 +185,20 @@ document.addEventListener('DOMContentLoaded', () => {
         const timestamp = new Date(timestampString);
         const now = new Date();
         const diffSeconds = Math.floor((now - timestamp) / 1000);
-        if (diffSeconds < 60) return `${diffSeconds} second${diffSeconds === 1 ? '' : 's'} ago`;
+        if (diffSeconds < 60) return tn('since.seconds', diffSeconds);
         const diffMinutes = Math.floor(diffSeconds / 60);
-        if (diffMinutes < 60) return `${diffMinutes} minute${diffMinutes === 1 ? '' : 's'} ago`;
+        if (diffMinutes < 60) return tn('since.minutes', diffMinutes);
         const diffHours = Math.floor(diffMinutes / 60);
-        if (diffHours < 24) return `${diffHours} hour${diffHours === 1 ? '' : 's'} ago`;
+        if (diffHours < 24) return tn('since.hours', diffHours);
         const diffDays = Math.floor(diffHours / 24);
-        return `${diffDays} day${diffDays === 1 ? '' : 's'} ago`;
+        return tn('since.days', diffDays);
     }
 
+    // formatMinutes returns the minutes since midnight as a time of day in the page's language.
     function formatMinutes(totalMinutes) {
         const hours = Math.floor(totalMinutes / 60);
         const minutes = totalMinutes % 60;
-        return `${hours.toString().padStart(2, '0')}:${minutes.toString().padStart(2, '0')}`;
+        return formatClock(new Date(1970, 0, 1, hours, minutes));
     }
 
     const modeMonitor = "Monitor";
@@ -191,6 +218,9 @@ document.addEventListener('DOMContentLoaded', () => {
         renderDhcpConfig();
         renderDhcpAddressReservations();
         await populateDhcpForms();
+        await populateTelemetryForm();
+        document.getElementById('backup-download-button').onclick = () => { window.location.href = '/api/backup'; };
+        document.getElementById('restore-button').onclick = restoreBackup;
 
         renderDevices();
         renderGroups();
@@ -202,11 +232,8 @@ document.addEventListener('DOMContentLoaded', () => {
     }
 
     function updateStartDayVisibility() {
-        if (groupRetentionSelect.value === '1') {
-            groupStartDayField.style.display = 'none';
-        } else {
-            groupStartDayField.style.display = '';
-        }
+        groupStartDayField.style.display = groupRetentionSelect.value === '7' ? '' : 'none';
+        groupStartDayOfMonthField.style.display = groupRetentionSelect.value === '31' ? '' : 'none';
     }
 
     // Fetch tracker configuration – ensure data is an array.
@@ -228,7 +255,7 @@ document.addEventListener('DOMContentLoaded', () => {
     function mergeDeviceGroups(deviceGroupNames) {
         deviceGroupNames.forEach(name => {
             if (!groups.find(g => g.name === name)) {
-                groups.push({ name: name, retention: 0, threshold: 0, startDay: 0, startDuration: 0, currentMode: modeMonitor, modeEndTime: new Date() });
+                groups.push({ name: name, retention: 0, threshold: 0, startDay: 0, startDayOfMonth: 1, window: "", startDuration: 0, countFrom: 0, countUntil: 0, blockOutsideHours: false, maxSession: 0, breakDuration: 0, minActive: 0, minActiveWindow: 0, warnAt: 0, gracePeriod: 0, packetSampling: false, rollover: "none", rolloverCap: 0, dayThresholds: [], packetPolicy: null, currentMode: modeMonitor, modeEndTime: new Date() });
             }
         });
     }
@@ -244,7 +271,7 @@ document.addEventListener('DOMContentLoaded', () => {
                 body: JSON.stringify(deviceGroupsToSave),
             });
             if (!response.ok) {
-                throw new Error('Failed to save device groups');
+                throw new Error(t('save.groupsFailed'));
             }
             // Save Tracker Config.
             const groupBody = JSON.stringify(groups)
@@ -254,12 +281,12 @@ document.addEventListener('DOMContentLoaded', () => {
                 body: groupBody,
             });
             if (response.ok) {
-                showNotification('Configuration saved successfully.', false);
+                showNotification(t('save.success'), false);
             } else {
-                showNotification('Failed to save tracker config.', true);
+                showNotification(t('save.trackerFailed'), true);
             }
         } catch (error) {
-            showNotification('Error saving configuration: ' + error.message, true);
+            showNotification(t('save.error', error.message), true);
         }
         hideSaveButtons();
     }
@@ -317,11 +344,17 @@ document.addEventListener('DOMContentLoaded', () => {
         const deviceDropdown = document.getElementById('device-dropdown');
         deviceDropdown.innerHTML = '';
         availableMACs = groupMACs;
-        availableMACs.forEach(({ mac, name, group }) => {
+        availableMACs.forEach(({ mac, name, group, placement, vendor }) => {
             const option = document.createElement('option');
             option.value = mac;
-            const label = name ? `${mac} - ${name}` : mac;
-            option.textContent = group ? `${label} (in ${group})` : label;
+            let label = name ? `${mac} - ${name}` : mac;
+            if (vendor) {
+                label += ` [${vendor}]`;
+            }
+            option.textContent = group ? t('devices.inGroup', label, group) : label;
+            if (placement) { // if the device has been seen in its effective group...
+                option.title = t('devices.placement', placement.group, placement.assignedBy, formatDateTime(placement.since));
+            }
             deviceDropdown.appendChild(option);
         });
         deviceDropdown.onchange = updateDeviceNameInput;
@@ -376,7 +409,31 @@ document.addEventListener('DOMContentLoaded', () => {
 
             const usageInfo = document.createElement('span');
             const usage = usageData[groupName] || { used: 0, percentage: 0, activity: {} };
-            usageInfo.textContent = `${usage.used} mins (${usage.percentage}%) usage`;
+            usageInfo.textContent = t('usage.used', usage.used, usage.percentage);
+            if (usage.adjustment) { // if time was transferred in or out of this group...
+                usageInfo.textContent += ` (${t('usage.transferred', (usage.adjustment > 0 ? '+' : '') + usage.adjustment)})`;
+            }
+            if (usage.carried) { // if unused time was rolled over from the last window...
+                usageInfo.textContent += ` (${t('usage.carried', usage.carried)})`;
+            }
+            if (usage.outsideHours) { // if usage isn't counted right now...
+                usageInfo.textContent += ` (${t('usage.outsideHours')})`;
+            }
+            if (usage.warning) { // if the group is nearly out of time and being slowed...
+                usageInfo.textContent += ` (${t('usage.warning')})`;
+            }
+            Object.entries(usage.categories || {}).forEach(([name, c]) => { // for each category with its own threshold...
+                let used = c.unlimited ? t('usage.category.unlimited', c.used) : t('usage.category.used', c.used, c.threshold);
+                if (c.blocked) {
+                    used = t('usage.category.blocked', used);
+                }
+                usageInfo.textContent += ` (${name}: ${used})`;
+            });
+            if (usage.breakEndTime) { // if the group is on a forced break...
+                usageInfo.textContent += ` (${t('usage.breakUntil', formatClock(usage.breakEndTime))})`;
+            } else if (usage.sessionMinutes) {
+                usageInfo.textContent += ` (${t('usage.session', usage.sessionMinutes)})`;
+            }
             groupHeader.appendChild(usageInfo);
 
             // const removeGroupBtn = document.createElement('button');
@@ -393,16 +450,49 @@ document.addEventListener('DOMContentLoaded', () => {
                 configInfo.classList.add('group-config-info');
                 const retention = humaniseDuration(groupConfig.retention);
                 if (Number(groupConfig.threshold) === 0) {
-                    configInfo.textContent = "Block group always.";
+                    configInfo.textContent = t('config.blockAlways');
                 } else {
                     const threshold = humaniseDuration(groupConfig.threshold);
-                    configInfo.textContent = `Block group after ${threshold} usage.`;
+                    configInfo.textContent = t('config.blockAfter', threshold);
 
                     const startDurationHHMM = formatMinutes(durationToMinutes(groupConfig.startDuration));
-                    if (groupConfig.retention >= daysToDuration(7)) {
-                        configInfo.textContent += ` Next reset on ${getDayName(groupConfig.startDay)} ${startDurationHHMM}`;
+                    if (groupConfig.window === 'monthly') {
+                        configInfo.textContent += ' ' + t('config.resetMonthly', groupConfig.startDayOfMonth, startDurationHHMM);
+                    } else if (groupConfig.retention >= daysToDuration(7)) {
+                        configInfo.textContent += ' ' + t('config.resetWeekly', getDayName(groupConfig.startDay), startDurationHHMM);
                     } else if (groupConfig.retention >= daysToDuration(1)){
-                        configInfo.textContent += ` Reset daily at ${startDurationHHMM}`;
+                        configInfo.textContent += ' ' + t('config.resetDaily', startDurationHHMM);
+                    }
+                    if (groupConfig.countFrom !== groupConfig.countUntil) { // if usage only counts during some hours...
+                        const fromHHMM = formatMinutes(durationToMinutes(groupConfig.countFrom));
+                        const untilHHMM = formatMinutes(durationToMinutes(groupConfig.countUntil));
+                        configInfo.textContent += ' ' + t(groupConfig.blockOutsideHours ? 'config.countsBlockedOtherwise' : 'config.countsOnly', fromHHMM, untilHHMM);
+                    }
+                    if (groupConfig.dayThresholds && groupConfig.dayThresholds.length > 0) { // if some days have their own limit...
+                        configInfo.textContent += ' ' + t('config.dayLimits', formatDayThresholds(groupConfig.dayThresholds));
+                    }
+                    if (groupConfig.maxSession > 0) { // if forced breaks are enabled...
+                        configInfo.textContent += ' ' + t('config.breaks', humaniseDuration(groupConfig.breakDuration), humaniseDuration(groupConfig.maxSession));
+                    }
+                    if (groupConfig.minActive > 0) { // if background traffic isn't counted...
+                        configInfo.textContent += ' ' + t('config.minActive', humaniseDuration(groupConfig.minActive), humaniseDuration(groupConfig.minActiveWindow));
+                    }
+                    if (groupConfig.warnAt > 0) { // if the group is warned before it runs out...
+                        configInfo.textContent += ' ' + t('config.warnAt', groupConfig.warnAt);
+                    }
+                    if (groupConfig.gracePeriod > 0) { // if blocking waits for a grace period...
+                        configInfo.textContent += ' ' + t('config.gracePeriod', humaniseDuration(groupConfig.gracePeriod));
+                    }
+                    if (groupConfig.rollover === "full") { // if all unused time rolls over...
+                        configInfo.textContent += ' ' + t('config.rolloverFull');
+                    } else if (groupConfig.rollover === "capped") {
+                        configInfo.textContent += ' ' + t('config.rolloverCapped', humaniseDuration(groupConfig.rolloverCap));
+                    }
+                    if (groupConfig.packetPolicy) { // if the group has its own packet handling...
+                        configInfo.textContent += ' ' + t('config.packetPolicy', describePacketPolicy(groupConfig.packetPolicy));
+                    }
+                    if (groupConfig.packetSampling) { // if only a sample of packets is counted...
+                        configInfo.textContent += ' ' + t('config.packetSampling');
                     }
                 }
 
@@ -415,13 +505,12 @@ document.addEventListener('DOMContentLoaded', () => {
             const now = new Date();
             // If the group has a current mode that is not "monitoring" and its end time is in the future...
             if (groupConfig && groupConfig.currentMode !== modeMonitor && groupConfig.modeEndTime && groupConfig.modeEndTime > now) {
+                const modeName = t(groupConfig.currentMode === modeAllow ? 'mode.allowed' : 'mode.blocked');
                 let diffMinutes = Math.round((groupConfig.modeEndTime - now) / 60000);
                 if (diffMinutes < 60) {
-                    modeStatus.textContent = `${groupConfig.currentMode} for ${diffMinutes} mins`;
+                    modeStatus.textContent = t('mode.for', modeName, diffMinutes);
                 } else {
-                    const hours = groupConfig.modeEndTime.getHours().toString().padStart(2, '0');
-                    const minutes = groupConfig.modeEndTime.getMinutes().toString().padStart(2, '0');
-                    modeStatus.textContent = `${groupConfig.currentMode}ed until ${hours}:${minutes}`;
+                    modeStatus.textContent = t('mode.until', modeName, formatClock(groupConfig.modeEndTime));
                 }
             } else {
                 modeStatus.textContent = ""; // empty text when in normal monitoring mode
@@ -440,11 +529,18 @@ document.addEventListener('DOMContentLoaded', () => {
                 if (lastActiveTimestamp) {
                     const activeTimeSpan = document.createElement('span');
                     activeTimeSpan.classList.add('group-config-info');
-                    activeTimeSpan.textContent = ` active ${formatTimeSince(lastActiveTimestamp)}`;
+                    activeTimeSpan.textContent = ' ' + t('devices.active', formatTimeSince(lastActiveTimestamp));
                     label.appendChild(activeTimeSpan);
                 }
+                const deviceUsage = usage.devices && usage.devices[mac];
+                if (deviceUsage) { // if device tracking is enabled...
+                    const deviceUsageSpan = document.createElement('span');
+                    deviceUsageSpan.classList.add('group-config-info');
+                    deviceUsageSpan.textContent = ' ' + t('devices.used', deviceUsage.used);
+                    label.appendChild(deviceUsageSpan);
+                }
                 const removeBtn = document.createElement('button');
-                removeBtn.textContent = 'Remove';
+                removeBtn.textContent = t('common.remove');
                 removeBtn.onclick = () => removeMacFromGroup(mac);
                 listItem.appendChild(label);
                 listItem.appendChild(removeBtn);
@@ -462,16 +558,16 @@ document.addEventListener('DOMContentLoaded', () => {
             modeWrapper.appendChild(modeControls)
 
             modeControls.classList.add('group-mode-controls');
-            modeControls.appendChild(document.createTextNode("Block / Allow: "));
+            modeControls.appendChild(document.createTextNode(t('mode.controls') + ' '));
             const modeSelect = document.createElement('select');
             modeSelect.classList.add('group-mode-select');
             const optionAllow = document.createElement('option');
             optionAllow.value = "1";
-            optionAllow.textContent = "Allow";
+            optionAllow.textContent = t('mode.allow');
             modeSelect.appendChild(optionAllow);
             const optionBlock = document.createElement('option');
             optionBlock.value = "2";
-            optionBlock.textContent = "Block";
+            optionBlock.textContent = t('mode.block');
             modeSelect.appendChild(optionBlock);
             modeControls.appendChild(modeSelect);
 
@@ -480,33 +576,33 @@ document.addEventListener('DOMContentLoaded', () => {
             durationSelect.classList.add('group-duration-select');
             const opt15 = document.createElement('option');
             opt15.value = "15";
-            opt15.textContent = "15 mins";
+            opt15.textContent = tn('duration.minutes', 15);
             durationSelect.appendChild(opt15);
             const opt30 = document.createElement('option');
             opt30.value = "30";
-            opt30.textContent = "30 mins";
+            opt30.textContent = tn('duration.minutes', 30);
             durationSelect.appendChild(opt30);
             const opt60 = document.createElement('option');
             opt60.value = "60";
-            opt60.textContent = "1 hour";
+            opt60.textContent = tn('duration.hours', 1);
             durationSelect.appendChild(opt60);
             const opt120 = document.createElement('option');
             opt120.value = "120";
-            opt120.textContent = "2 hours";
+            opt120.textContent = tn('duration.hours', 2);
             durationSelect.appendChild(opt120);
             const opt240 = document.createElement('option');
             opt240.value = "240";
-            opt240.textContent = "4 hours";
+            opt240.textContent = tn('duration.hours', 4);
             durationSelect.appendChild(opt240);
             const optUntilMidnight = document.createElement('option');
             optUntilMidnight.value = "untilMidnight";
-            optUntilMidnight.textContent = "Until Midnight";
+            optUntilMidnight.textContent = t('mode.untilMidnight');
             durationSelect.appendChild(optUntilMidnight);
             modeControls.appendChild(durationSelect);
 
             // "Apply Mode" button.
             const applyModeButton = document.createElement('button');
-            applyModeButton.textContent = "Apply";
+            applyModeButton.textContent = t('mode.apply');
             applyModeButton.onclick = () => {
                 let durationVal = durationSelect.value;
                 if (durationVal === "untilMidnight") {
@@ -527,12 +623,12 @@ document.addEventListener('DOMContentLoaded', () => {
 
             // "Resume" button sends a DELETE to /mode.
             const resumeModeButton = document.createElement('button');
-            resumeModeButton.textContent = "Resume";
+            resumeModeButton.textContent = t('mode.resume');
             resumeModeButton.onclick = () => {
                 fetch(`/mode?group=${encodeURIComponent(groupName)}`, { method: 'DELETE' })
                     .then(response => {
                         if (!response.ok) {
-                            throw new Error("Failed to resume mode");
+                            throw new Error(t('mode.resumeFailed'));
                         }
                         return response.text();
                     })
@@ -547,7 +643,7 @@ document.addEventListener('DOMContentLoaded', () => {
                         renderGroups(); // Re-render groups to update the UI.
                     })
                     .catch(error => {
-                        showNotification( "Error: " + error.message, true)
+                        showNotification(t('error.prefix', error.message), true)
                     });
             };
             modeControls.appendChild(resumeModeButton);
@@ -565,7 +661,7 @@ document.addEventListener('DOMContentLoaded', () => {
         });
         renderDevices();
         renderGroups();
-        showNotification("Device removed.", false, true);
+        showNotification(t('devices.removed'), false, true);
         showSaveButtons();
     }
 
@@ -578,7 +674,7 @@ document.addEventListener('DOMContentLoaded', () => {
         updateDeviceGroupDropdown();
         renderDevices();
         renderGroups();
-        showNotification("Group removed.", false, true);
+        showNotification(t('devices.groupRemoved'), false, true);
         showSaveButtons();
     }
 
@@ -596,14 +692,14 @@ document.addEventListener('DOMContentLoaded', () => {
             buttonRow.classList.add('button-row');
 
             const saveBtn = document.createElement('button');
-            saveBtn.textContent = 'Save Configuration';
+            saveBtn.textContent = t('common.saveConfiguration');
             saveBtn.classList.add('inline-save');
             saveBtn.addEventListener('click', async () => {
                 await saveConfig();
             });
 
             const undoBtn = document.createElement('button');
-            undoBtn.textContent = 'Undo';
+            undoBtn.textContent = t('common.undo');
             undoBtn.classList.add('inline-undo');
             undoBtn.addEventListener('click', () => {
                 location.reload();
@@ -640,8 +736,83 @@ document.addEventListener('DOMContentLoaded', () => {
     }
 
     function getDayName(day) {
-        const days = ["Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"];
-        return days[parseInt(day, 10)] || "Unknown";
+        const d = parseInt(day, 10);
+        return d >= 0 && d < 7 ? t(`day.${d}`) : t('day.unknown');
+    }
+
+    // The short day names are the page language's, with the English ones also understood when parsing day limits.
+    const shortDayNames = [0, 1, 2, 3, 4, 5, 6].map(d => t(`day.short.${d}`));
+    const englishShortDayNames = ["Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"];
+
+    // formatDayThresholds returns day thresholds as text like "Mon-Fri 60, Sat/Sun 120" with the limits in minutes.
+    // describePacketPolicy returns a summary of how packets are handled once a group is over its limit.
+    function describePacketPolicy(policy) {
+        const parts = [];
+        if (policy.rateLimitKbps > 0) {
+            parts.push(t('policy.slowed', policy.rateLimitKbps));
+        } else {
+            parts.push(t('policy.dropped', Math.round(policy.dropPercentage * 100)));
+            if (policy.delay > 0 && policy.delayPercentage > 0) {
+                parts.push(t('policy.delayed', Math.round(policy.delayPercentage * 100), Math.round(policy.delay / 1e6)));
+            }
+        }
+        if (!policy.dropUDP) {
+            parts.push(t('policy.udpAllowed'));
+        } else if (policy.udpRateLimitKbps > 0) {
+            parts.push(t('policy.udpSlowed', policy.udpRateLimitKbps));
+        } else {
+            parts.push(t('policy.udpDropped'));
+        }
+        return parts.join(", ");
+    }
+
+    function formatDayThresholds(dayThresholds) {
+        return (dayThresholds || []).map(dt => {
+            const days = [...dt.days].sort((a, b) => a - b);
+            const parts = [];
+            for (let i = 0; i < days.length; i++) {
+                let j = i;
+                while (j + 1 < days.length && days[j + 1] === days[j] + 1) {
+                    j++;
+                }
+                parts.push(j - i >= 2 ? `${shortDayNames[days[i]]}-${shortDayNames[days[j]]}` : days.slice(i, j + 1).map(d => shortDayNames[d]).join("/"));
+                i = j;
+            }
+            return `${parts.join("/")} ${durationToMinutes(dt.threshold)}`;
+        }).join(", ");
+    }
+
+    // parseDayThresholds is the reverse of formatDayThresholds. It returns null if the text isn't valid.
+    function parseDayThresholds(text) {
+        const dayIndex = name => {
+            const prefix = name.trim().toLowerCase();
+            const d = shortDayNames.findIndex(d => prefix.startsWith(d.toLowerCase()));
+            return d >= 0 ? d : englishShortDayNames.findIndex(d => d.toLowerCase() === prefix.slice(0, 3));
+        };
+        const result = [];
+        for (const entry of text.split(",").map(e => e.trim()).filter(e => e !== "")) {
+            const match = entry.match(/^(.+)\s+(\d+)$/);
+            if (!match) {
+                return null;
+            }
+            const days = [];
+            for (const part of match[1].split("/")) {
+                const [from, until] = part.split("-").map(dayIndex);
+                if (from < 0 || until < 0) {
+                    return null;
+                }
+                for (let d = from; ; d = (d + 1) % 7) { // allow ranges that wrap, e.g. Sat-Sun.
+                    if (!days.includes(d)) {
+                        days.push(d);
+                    }
+                    if (until === undefined || d === until) {
+                        break;
+                    }
+                }
+            }
+            result.push({ days: days, threshold: minutesToDuration(parseInt(match[2], 10)) });
+        }
+        return result;
     }
 
     // ---------- Consolidated Group Configuration Form Handlers ----------
@@ -650,7 +821,7 @@ document.addEventListener('DOMContentLoaded', () => {
         groupSelect.innerHTML = '';
         const newOption = document.createElement('option');
         newOption.value = "";
-        newOption.textContent = "-- New Tracker --";
+        newOption.textContent = t('trackers.new');
         groupSelect.appendChild(newOption);
         groups.forEach(groupObj => {
             const option = document.createElement('option');
@@ -679,7 +850,7 @@ document.addEventListener('DOMContentLoaded', () => {
         macDiv.className = 'form-field mac-field';
         const macInput = document.createElement('input');
         macInput.type = 'text';
-        macInput.placeholder = 'MAC Address';
+        macInput.placeholder = t('reservations.mac');
         macInput.value = r.macAddr || '';
         macDiv.appendChild(macInput);
 
@@ -687,7 +858,7 @@ document.addEventListener('DOMContentLoaded', () => {
         ipDiv.className = 'form-field ip-field';
         const ipInput = document.createElement('input');
         ipInput.type = 'text';
-        ipInput.placeholder = 'IP Address';
+        ipInput.placeholder = t('reservations.ip');
         ipInput.value = r.ipAddr || '';
         ipDiv.appendChild(ipInput);
 
@@ -699,12 +870,12 @@ document.addEventListener('DOMContentLoaded', () => {
         nameDiv.className = 'form-field';
         const nameInput = document.createElement('input');
         nameInput.type = 'text';
-        nameInput.placeholder = 'Name';
+        nameInput.placeholder = t('common.name');
         nameInput.value = r.name || '';
         nameDiv.appendChild(nameInput);
 
         const removeBtn = document.createElement('button');
-        removeBtn.textContent = 'Remove';
+        removeBtn.textContent = t('common.remove');
         removeBtn.type = 'button';
         removeBtn.onclick = () => container.removeChild(row);
 
@@ -722,12 +893,12 @@ document.addEventListener('DOMContentLoaded', () => {
         dhcpConfigForm.innerHTML = '';
 
         const formFields = [
-            { id: 'default-gateway', label: 'Default Gateway', placeholder: 'IP address' },
-            { id: 'this-gateway', label: 'This Gateway', placeholder: 'IP address' },
-            { id: 'lower-bound', label: 'IP Range: Lower Address', placeholder: 'IP address' },
-            { id: 'upper-bound', label: 'IP Range: Upper Address', placeholder: 'IP address' },
-            { id: 'dns-ip1', label: 'DNS IP Address (Primary)', placeholder: 'IP address' },
-            { id: 'dns-ip2', label: 'DNS IP Address (Secondary)', placeholder: 'IP address' }
+            { id: 'default-gateway', label: t('dhcp.defaultGateway'), placeholder: t('dhcp.ip.placeholder') },
+            { id: 'this-gateway', label: t('dhcp.thisGateway'), placeholder: t('dhcp.ip.placeholder') },
+            { id: 'lower-bound', label: t('dhcp.lowerBound'), placeholder: t('dhcp.ip.placeholder') },
+            { id: 'upper-bound', label: t('dhcp.upperBound'), placeholder: t('dhcp.ip.placeholder') },
+            { id: 'dns-ip1', label: t('dhcp.dns1'), placeholder: t('dhcp.ip.placeholder') },
+            { id: 'dns-ip2', label: t('dhcp.dns2'), placeholder: t('dhcp.ip.placeholder') }
         ];
 
         formFields.forEach(field => {
@@ -752,7 +923,7 @@ document.addEventListener('DOMContentLoaded', () => {
         enabledField.className = 'form-field';
         const enabledLabel = document.createElement('label');
         enabledLabel.setAttribute('for', 'service-enabled');
-        enabledLabel.textContent = 'Enable DHCP Service';
+        enabledLabel.textContent = t('dhcp.enabled');
         const enabledInput = document.createElement('input');
         enabledInput.id = 'service-enabled';
         enabledInput.type = 'checkbox';
@@ -763,7 +934,7 @@ document.addEventListener('DOMContentLoaded', () => {
         const statusField = document.createElement('div');
         statusField.className = 'form-field';
         const statusLabel = document.createElement('label');
-        statusLabel.textContent = 'DHCP Service Status';
+        statusLabel.textContent = t('dhcp.state');
         const statusText = document.createElement('span');
         statusText.id = 'service-state';
         statusText.textContent = ''; // default value or status
@@ -783,7 +954,7 @@ document.addEventListener('DOMContentLoaded', () => {
         addressReservationsForm.appendChild(reservationContainer);
 
         const addBtn = document.createElement('button');
-        addBtn.textContent = 'Add Another >>';
+        addBtn.textContent = t('reservations.add');
         addBtn.classList.add('button-full-bottom');
         addBtn.type = 'button';
         addBtn.onclick = () => {
@@ -810,7 +981,8 @@ document.addEventListener('DOMContentLoaded', () => {
         document.getElementById('service-enabled').checked = cfg.serviceEnabled || false;
         const status = cfg.serviceState || '';
         const capitalizedStatus = status.charAt(0).toUpperCase() + status.slice(1); // initial capital letter
-        document.getElementById('service-state').textContent = capitalizedStatus || '';
+        const stateKey = `dhcp.state.${status}`;
+        document.getElementById('service-state').textContent = status && ui.strings[stateKey] !== undefined ? t(stateKey) : capitalizedStatus;
 
         const container = document.getElementById('reservation-container');
         container.innerHTML = '';
@@ -854,10 +1026,64 @@ document.addEventListener('DOMContentLoaded', () => {
         });
 
         if (res.ok) {
-            showNotification(`Configuration saved successfully.`, false);
+            showNotification(t('save.success'), false);
+        } else {
+            const responseBody = (await res.text()).trim();
+            showNotification(t('save.failed', responseBody), true);
+        }
+    }
+
+    // ----------------------------------------------------------------------------
+    // Anonymous usage statistics (opt-in)
+    // ----------------------------------------------------------------------------
+    async function populateTelemetryForm() {
+        const res = await fetch('/telemetry');
+        if (!res.ok) return;
+
+        const preview = await res.json();
+        document.getElementById('telemetry-enabled').checked = preview.settings?.enabled || false;
+        document.getElementById('telemetry-preview').textContent = JSON.stringify(preview.payload, null, 2);
+        document.getElementById('telemetry-save-button').onclick = saveTelemetry;
+    }
+
+    async function saveTelemetry() {
+        const res = await fetch('/telemetry', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ enabled: document.getElementById('telemetry-enabled').checked })
+        });
+
+        if (res.ok) {
+            showNotification(t('telemetry.saved'), false);
+            await populateTelemetryForm();
         } else {
             const responseBody = (await res.text()).trim();
-            showNotification(`Failed to save configuration: "${responseBody}"`, true);
+            showNotification(t('telemetry.saveFailed', responseBody), true);
+        }
+    }
+
+    // ----------------------------------------------------------------------------
+    // Backup and restore
+    // ----------------------------------------------------------------------------
+    async function restoreBackup() {
+        const file = document.getElementById('restore-file').files[0];
+        if (!file) {
+            alert(t('backup.chooseFile'));
+            return;
+        }
+        if (!confirm(t('backup.confirmRestore'))) {
+            return;
+        }
+
+        const form = new FormData();
+        form.append('archive', file);
+        const res = await fetch('/api/restore', { method: 'POST', body: form });
+        if (res.ok) {
+            const result = await res.json();
+            showNotification(tn('backup.restored', result.restored.length), false);
+        } else {
+            const responseBody = (await res.text()).trim();
+            showNotification(t('backup.restoreFailed', responseBody), true);
         }
     }
 
@@ -891,7 +1117,7 @@ document.addEventListener('DOMContentLoaded', () => {
                     });
                     row.appendChild(circle);
                     row.appendChild(document.createTextNode(
-                        'IPv6 detected - disable IPv6 on the router'
+                        t('status.ipv6')
                     ));
                     container.appendChild(row);
                 }
@@ -918,12 +1144,73 @@ document.addEventListener('DOMContentLoaded', () => {
                 });
                 row.appendChild(circle);
                 row.appendChild(document.createTextNode(
-                    'DHCP configuration needs completing'
+                    t('status.dhcp')
                 ));
                 container.appendChild(row);
             }
         }
-        
+
+        try { // Startup readiness check
+            const resp = await fetch('/api/health');
+            if (resp.ok || resp.status === 503) {
+                const { readiness } = await resp.json();
+                if (readiness && readiness.state !== 'ready') {
+                    hasRed = true;
+                    const row = document.createElement('div');
+                    const circle = document.createElement('span');
+                    Object.assign(circle.style, {
+                        display:        'inline-block',
+                        width:          '10px',
+                        height:         '10px',
+                        borderRadius:   '50%',
+                        backgroundColor:'var(--pending-color)',
+                        marginRight:    '6px'
+                    });
+                    row.appendChild(circle);
+                    row.appendChild(document.createTextNode(
+                        t('status.notReady', readiness.cause)
+                    ));
+                    container.appendChild(row);
+                }
+            } else {
+                console.error('Failed to fetch /api/health:', resp.status);
+            }
+        } catch (e) {
+            console.error('Error fetching /api/health:', e);
+        }
+
+        try { // Watcher freshness check
+            const resp = await fetch('/api/freshness');
+            if (resp.ok) {
+                const sources = await resp.json();
+                sources.filter(f => f.stale).forEach(f => {
+                    hasRed = true;
+                    const row = document.createElement('div');
+                    const circle = document.createElement('span');
+                    Object.assign(circle.style, {
+                        display:        'inline-block',
+                        width:          '10px',
+                        height:         '10px',
+                        borderRadius:   '50%',
+                        backgroundColor:'var(--pending-color)',
+                        marginRight:    '6px'
+                    });
+                    const updated = new Date(f.lastUpdated).getTime() > 0 ? t('status.lastUpdated', formatTimeSince(f.lastUpdated)) : t('status.notUpdated');
+                    const sourceKey = `status.source.${f.source}`;
+                    const source = ui.strings[sourceKey] !== undefined ? t(sourceKey) : f.source;
+                    row.appendChild(circle);
+                    row.appendChild(document.createTextNode(
+                        t('status.stale', source, updated)
+                    ));
+                    container.appendChild(row);
+                });
+            } else {
+                console.error('Failed to fetch /api/freshness:', resp.status);
+            }
+        } catch (e) {
+            console.error('Error fetching /api/freshness:', e);
+        }
+
         if (!hasRed) { // Show green status
             const row = document.createElement('div');
             const circle = document.createElement('span');
@@ -936,7 +1223,7 @@ document.addEventListener('DOMContentLoaded', () => {
                 marginRight:    '6px'
             });
             row.appendChild(circle);
-            row.appendChild(document.createTextNode('Active'));
+            row.appendChild(document.createTextNode(t('status.active')));
             container.appendChild(row);
         }
     }
@@ -947,23 +1234,81 @@ document.addEventListener('DOMContentLoaded', () => {
         const retentionInput = document.getElementById('group-retention');
         const thresholdInput = document.getElementById('group-threshold');
         const startDaySelect = document.getElementById('group-start-day');
+        const startDayOfMonthInput = document.getElementById('group-start-day-of-month');
         const startTimeInput = document.getElementById('group-start-time');
+        const countFromInput = document.getElementById('group-count-from');
+        const countUntilInput = document.getElementById('group-count-until');
+        const blockOutsideSelect = document.getElementById('group-block-outside');
+        const maxSessionInput = document.getElementById('group-max-session');
+        const breakDurationInput = document.getElementById('group-break-duration');
+        const minActiveInput = document.getElementById('group-min-active');
+        const minActiveWindowInput = document.getElementById('group-min-active-window');
+        const warnAtInput = document.getElementById('group-warn-at');
+        const gracePeriodInput = document.getElementById('group-grace-period');
+        const packetSamplingSelect = document.getElementById('group-packet-sampling');
+        const rolloverSelect = document.getElementById('group-rollover');
+        const rolloverCapInput = document.getElementById('group-rollover-cap');
+        const dayThresholdsInput = document.getElementById('group-day-thresholds');
+        const packetPolicySelect = document.getElementById('group-packet-policy');
+        const dropPctInput = document.getElementById('group-drop-pct');
+        const delayPctInput = document.getElementById('group-delay-pct');
+        const delayMsInput = document.getElementById('group-delay-ms');
+        const dropUDPSelect = document.getElementById('group-drop-udp');
         if (selectedName === "") { // if we need to be ready for a new group...
             nameInput.value = "";
             nameInput.disabled = false;
             retentionInput.value = "";
             thresholdInput.value = "";
             startDaySelect.value = 0;
+            startDayOfMonthInput.value = "1";
             startTimeInput.value = "00:00:00";
+            countFromInput.value = "00:00:00";
+            countUntilInput.value = "00:00:00";
+            blockOutsideSelect.value = "false";
+            maxSessionInput.value = "";
+            breakDurationInput.value = "";
+            minActiveInput.value = "";
+            minActiveWindowInput.value = "";
+            warnAtInput.value = "";
+            gracePeriodInput.value = "";
+            packetSamplingSelect.value = "false";
+            rolloverSelect.value = "none";
+            rolloverCapInput.value = "";
+            dayThresholdsInput.value = "";
+            packetPolicySelect.value = "default";
+            dropPctInput.value = "";
+            delayPctInput.value = "";
+            delayMsInput.value = "";
+            dropUDPSelect.value = "true";
         } else { // else we're editing an existing group...
             const group = groups.find(g => g.name === selectedName);
             if (group) {
                 nameInput.value = group.name;
                 nameInput.disabled = true;
-                retentionInput.value = durationToDays(group.retention);
+                retentionInput.value = group.window === 'monthly' ? "31" : durationToDays(group.retention);
                 thresholdInput.value = durationToMinutes(group.threshold);
                 startDaySelect.value = group.startDay.toString();
+                startDayOfMonthInput.value = (group.startDayOfMonth || 1).toString();
                 startTimeInput.value = durationToTimeString(group.startDuration);
+                countFromInput.value = durationToTimeString(group.countFrom || 0);
+                countUntilInput.value = durationToTimeString(group.countUntil || 0);
+                blockOutsideSelect.value = group.blockOutsideHours ? "true" : "false";
+                maxSessionInput.value = group.maxSession ? durationToMinutes(group.maxSession) : "";
+                breakDurationInput.value = group.breakDuration ? durationToMinutes(group.breakDuration) : "";
+                minActiveInput.value = group.minActive ? durationToMinutes(group.minActive) : "";
+                minActiveWindowInput.value = group.minActiveWindow ? durationToMinutes(group.minActiveWindow) : "";
+                warnAtInput.value = group.warnAt ? group.warnAt : "";
+                gracePeriodInput.value = group.gracePeriod ? durationToMinutes(group.gracePeriod) : "";
+                packetSamplingSelect.value = group.packetSampling ? "true" : "false";
+                rolloverSelect.value = group.rollover || "none";
+                rolloverCapInput.value = group.rolloverCap ? durationToMinutes(group.rolloverCap) : "";
+                dayThresholdsInput.value = formatDayThresholds(group.dayThresholds);
+                const policy = group.packetPolicy;
+                packetPolicySelect.value = policy ? "custom" : "default";
+                dropPctInput.value = policy ? Math.round(policy.dropPercentage * 100) : "";
+                delayPctInput.value = policy ? Math.round(policy.delayPercentage * 100) : "";
+                delayMsInput.value = policy ? Math.round(policy.delay / 1e6) : "";
+                dropUDPSelect.value = policy && !policy.dropUDP ? "false" : "true";
             }
         }
         updateStartDayVisibility();
@@ -976,22 +1321,62 @@ document.addEventListener('DOMContentLoaded', () => {
         const retention = parseInt(document.getElementById('group-retention').value, 10);
         const threshold = parseInt(document.getElementById('group-threshold').value, 10);
         const startDay = parseInt(document.getElementById('group-start-day').value, 10);
+        const startDayOfMonth = Math.min(Math.max(parseInt(document.getElementById('group-start-day-of-month').value, 10) || 1, 1), 28);
         const startTime = document.getElementById('group-start-time').value;
+        const countFrom = document.getElementById('group-count-from').value || "00:00";
+        const countUntil = document.getElementById('group-count-until').value || "00:00";
+        const blockOutsideHours = document.getElementById('group-block-outside').value === "true";
+        const maxSession = parseInt(document.getElementById('group-max-session').value, 10) || 0;
+        const breakMinutes = parseInt(document.getElementById('group-break-duration').value, 10) || 0;
+        const minActiveMinutes = parseInt(document.getElementById('group-min-active').value, 10) || 0;
+        const minActiveWindowMinutes = parseInt(document.getElementById('group-min-active-window').value, 10) || 0;
+        const warnAt = Math.min(Math.max(parseInt(document.getElementById('group-warn-at').value, 10) || 0, 0), 100);
+        const gracePeriodMinutes = parseInt(document.getElementById('group-grace-period').value, 10) || 0;
+        const packetSampling = document.getElementById('group-packet-sampling').value === "true";
+        const rollover = document.getElementById('group-rollover').value;
+        const rolloverCapMinutes = parseInt(document.getElementById('group-rollover-cap').value, 10) || 0;
+        const dayThresholds = parseDayThresholds(document.getElementById('group-day-thresholds').value);
+        const customPolicy = document.getElementById('group-packet-policy').value === "custom";
+        const dropPct = parseInt(document.getElementById('group-drop-pct').value, 10) || 0;
+        const delayPct = parseInt(document.getElementById('group-delay-pct').value, 10) || 0;
+        const delayMs = parseInt(document.getElementById('group-delay-ms').value, 10) || 0;
+        const dropUDP = document.getElementById('group-drop-udp').value === "true";
         if (!nameInput || isNaN(retention) || isNaN(threshold) || !startTime) {
-            alert("Please fill in all fields.");
+            alert(t('trackers.fillAll'));
+            return;
+        }
+        if (dayThresholds === null) {
+            alert(t('trackers.dayThresholds.invalid', t('trackers.dayThresholds.example')));
             return;
         }
         const retentionDuration = daysToDuration(retention);
+        const trackerWindow = retention === 31 ? 'monthly' : ''; // the month option resets on a day of the month
         const thresholdDuration = minutesToDuration(threshold);
         const startDuration = timeStringToDuration(startTime);
+        const countFromDuration = timeStringToDuration(countFrom);
+        const countUntilDuration = timeStringToDuration(countUntil);
+        const maxSessionDuration = minutesToDuration(maxSession);
+        const breakDuration = minutesToDuration(breakMinutes);
+        const minActive = minutesToDuration(minActiveMinutes);
+        const minActiveWindow = minutesToDuration(minActiveWindowMinutes);
+        const gracePeriod = minutesToDuration(gracePeriodMinutes);
+        const rolloverCap = minutesToDuration(rolloverCapMinutes);
+        const existing = groups.find(g => g.name === selectedName);
+        const packetPolicy = customPolicy ? {
+            ...((existing && existing.packetPolicy) || { jitter: 0, rateLimitKbps: 0, udpRateLimitKbps: 0 }), // keep settings that are only set in the config file.
+            dropPercentage: Math.min(dropPct, 100) / 100,
+            delayPercentage: Math.min(delayPct, 100) / 100,
+            delay: delayMs * 1e6,
+            dropUDP: dropUDP,
+        } : null;
         if (selectedName === "") { // if we're editing a new group...
             if (!groups.find(g => g.name === nameInput)) {  // if the group doesn't exist in memory...
                 // Save the group in memory.
-                groups.push({ name: nameInput, retention: retentionDuration, threshold: thresholdDuration, startDay: startDay, startDuration: startDuration, currentMode: modeMonitor, modeEndTime: new Date() });
+                groups.push({ name: nameInput, retention: retentionDuration, threshold: thresholdDuration, startDay: startDay, startDayOfMonth: startDayOfMonth, window: trackerWindow, startDuration: startDuration, countFrom: countFromDuration, countUntil: countUntilDuration, blockOutsideHours: blockOutsideHours, maxSession: maxSessionDuration, breakDuration: breakDuration, minActive: minActive, minActiveWindow: minActiveWindow, warnAt: warnAt, gracePeriod: gracePeriod, packetSampling: packetSampling, rollover: rollover, rolloverCap: rolloverCap, dayThresholds: dayThresholds, packetPolicy: packetPolicy, currentMode: modeMonitor, modeEndTime: new Date() });
                 updateGroupSelect();
                 updateDeviceGroupDropdown();
             } else {
-                alert("Tracker already exists!");
+                alert(t('trackers.exists'));
             }
         } else { // else we're editing an existing group...
             const group = groups.find(g => g.name === selectedName);
@@ -1000,8 +1385,24 @@ document.addEventListener('DOMContentLoaded', () => {
                 group.retention = retentionDuration;
                 group.threshold = thresholdDuration;
                 group.startDay = startDay;
+                group.startDayOfMonth = startDayOfMonth;
+                group.window = trackerWindow;
                 group.startDuration = startDuration;
-                showNotification(`Tracker "${group.name}" updated. Please hit Save or Undo.`, false, true);
+                group.countFrom = countFromDuration;
+                group.countUntil = countUntilDuration;
+                group.blockOutsideHours = blockOutsideHours;
+                group.maxSession = maxSessionDuration;
+                group.breakDuration = breakDuration;
+                group.minActive = minActive;
+                group.minActiveWindow = minActiveWindow;
+                group.warnAt = warnAt;
+                group.gracePeriod = gracePeriod;
+                group.packetSampling = packetSampling;
+                group.rollover = rollover;
+                group.rolloverCap = rolloverCap;
+                group.dayThresholds = dayThresholds;
+                group.packetPolicy = packetPolicy;
+                showNotification(t('trackers.updated', group.name), false, true);
             }
         }
         showSaveButtons();
@@ -1013,7 +1414,7 @@ document.addEventListener('DOMContentLoaded', () => {
         const selectedName = groupSelect.value;
 
         if (!selectedName) {
-            alert("Please select a tracker to delete.");
+            alert(t('trackers.selectToDelete'));
             return;
         }
 
@@ -1023,11 +1424,11 @@ document.addEventListener('DOMContentLoaded', () => {
             updateGroupSelect();     // Refresh dropdown options
             updateDeviceGroupDropdown(); // In case device dropdown is linked
             groupSelect.value = "";  // Clear selection after deletion
-            showNotification(`Tracker "${selectedName}" deleted. Please hit Save or Undo.`, false, true);
+            showNotification(t('trackers.deleted', selectedName), false, true);
             showSaveButtons();
             renderGroups();
         } else {
-            alert("Selected tracker not found.");
+            alert(t('trackers.notFound'));
         }
     });
 
@@ -1044,7 +1445,7 @@ document.addEventListener('DOMContentLoaded', () => {
         });
         renderDevices();
         renderGroups();
-        showNotification(`Group "${group}" updated. Please hit Save or Undo.`, false, true);
+        showNotification(t('devices.groupUpdated', group), false, true);
         showSaveButtons();
     };
 
@@ -1087,10 +1488,52 @@ document.addEventListener('DOMContentLoaded', () => {
         });
     });
 
+    // Reload the page in the chosen language, which the server keeps in a cookie.
+    document.getElementById('language-select').addEventListener('change', (e) => {
+        window.location.href = window.location.pathname + '?lang=' + encodeURIComponent(e.target.value);
+    });
+
     groupRetentionSelect.addEventListener('change', updateStartDayVisibility);
     saveButtons.forEach(button => {
         button.addEventListener('click', saveConfig);
     });
 
-    fetchConfigAndRender();
+    // ---------- Live updates ----------
+    // Merge usage, activity and block changes pushed over /ws into the page, reconnecting if the connection drops.
+    let liveRenderTimer = null;
+    function scheduleLiveRender() {
+        if (liveRenderTimer) return; // if a render is already due...
+        liveRenderTimer = setTimeout(() => {
+            liveRenderTimer = null;
+            renderGroups();
+        }, 500);
+    }
+
+    function connectLiveUpdates() {
+        const scheme = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+        const ws = new WebSocket(`${scheme}//${window.location.host}/ws`);
+        ws.onmessage = (msg) => {
+            const e = JSON.parse(msg.data);
+            if (e.type === 'summary') {
+                Object.entries(e.data || {}).forEach(([group, summary]) => {
+                    const existing = usageData[group] || {};
+                    // Keep the activity and devices we have if the tracker didn't send them.
+                    if (!summary.activity) summary.activity = existing.activity;
+                    if (!summary.devices) summary.devices = existing.devices;
+                    usageData[group] = summary;
+                });
+                scheduleLiveRender();
+            } else if (e.type === 'activity') {
+                const usage = usageData[e.data.group] || (usageData[e.data.group] = { used: 0, percentage: 0 });
+                usage.activity = usage.activity || {};
+                usage.activity[e.data.mac] = e.data.lastActive;
+                scheduleLiveRender();
+            } else if (e.type === 'verdict') {
+                updateAllGroupModes();
+            }
+        };
+        ws.onclose = () => setTimeout(connectLiveUpdates, 5000);
+    }
+
+    fetchConfigAndRender().then(connectLiveUpdates);
 });
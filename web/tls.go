@@ -0,0 +1,227 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"relloyd/tubetimeout/config"
+)
+
+const (
+	selfSignedValidity = 5 * 365 * 24 * time.Hour // selfSignedValidity is how long the self-signed certificates last.
+	hostnameCheckTTL   = time.Minute              // hostnameCheckTTL is how often the hostname is checked for changes.
+)
+
+var (
+	defaultCertFilePath = "web-cert.pem"
+	defaultKeyFilePath  = "web-key.pem"
+	fnHostname          = os.Hostname
+	fnInterfaceAddrs    = net.InterfaceAddrs
+)
+
+// certSource returns the certificate to serve over HTTPS. It's either loaded from the files configured, or is a
+// self-signed certificate for the gateway's hostname that's made again if the hostname changes.
+type certSource struct {
+	logger     *zap.SugaredLogger
+	selfSigned bool
+	mu         sync.Mutex
+	cert       *tls.Certificate // cert is the certificate served, guarded by mu.
+	checked    time.Time        // checked is when the hostname was last compared with the certificate's, guarded by mu.
+	nowFunc    func() time.Time
+}
+
+// newCertSource loads the certificate in cfg, or the self-signed certificate from the app's home directory, making
+// it first if it doesn't exist or is for another hostname.
+func newCertSource(logger *zap.SugaredLogger, cfg *config.WebConfig) (*certSource, error) {
+	c := &certSource{logger: logger, nowFunc: time.Now}
+	if cfg.TLSCertFile != "" || cfg.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		c.cert = &cert
+		return c, nil
+	}
+
+	c.selfSigned = true
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.refresh(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate.
+func (c *certSource) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.selfSigned && c.nowFunc().Sub(c.checked) >= hostnameCheckTTL { // if the hostname may have changed...
+		if err := c.refresh(); err != nil {
+			c.logger.Errorf("Failed to refresh the self-signed TLS certificate: %v", err)
+		}
+	}
+	return c.cert, nil
+}
+
+// refresh loads the self-signed certificate, or makes and saves a new one if there isn't one for the hostname or it
+// has expired. This should be done under c.mu.
+func (c *certSource) refresh() error {
+	now := c.nowFunc()
+	c.checked = now
+	hostname, err := fnHostname()
+	if err != nil {
+		return fmt.Errorf("failed to get hostname: %w", err)
+	}
+	if c.cert == nil { // if the certificate hasn't been loaded yet...
+		if cert, err := loadSelfSigned(); err == nil {
+			c.cert = cert
+		}
+	}
+	if c.cert != nil && slices.Contains(c.cert.Leaf.DNSNames, hostname) && now.Before(c.cert.Leaf.NotAfter) {
+		return nil
+	}
+
+	cert, err := generateSelfSigned(hostname, now)
+	if err != nil {
+		return err
+	}
+	c.cert = cert
+	c.logger.Infof("Made a self-signed TLS certificate for %v", hostname)
+	return nil
+}
+
+// loadSelfSigned returns the self-signed certificate saved in the app's home directory.
+func loadSelfSigned() (*tls.Certificate, error) {
+	certPath, err := config.DefaultStore.Path(defaultCertFilePath)
+	if err != nil {
+		return nil, err
+	}
+	keyPath, err := config.DefaultStore.Path(defaultKeyFilePath)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+// generateSelfSigned makes a certificate for the hostname, the hostname in .local for mDNS, localhost and the
+// gateway's IPs, and saves it in the app's home directory.
+func generateSelfSigned(hostname string, now time.Time) (*tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TLS key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TLS certificate serial number: %w", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: hostname, Organization: []string{"TubeTimeout"}},
+		DNSNames:              []string{hostname, hostname + ".local", "localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             now.Add(-time.Hour), // allow for clocks that are a little behind.
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	if addrs, err := fnInterfaceAddrs(); err == nil {
+		for _, a := range addrs {
+			if ipNet, ok := a.(*net.IPNet); ok && !ipNet.IP.IsLoopback() && !ipNet.IP.IsLinkLocalUnicast() {
+				template.IPAddresses = append(template.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TLS certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal TLS key: %w", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	for _, f := range []struct {
+		name string
+		data []byte
+		perm os.FileMode
+	}{{defaultCertFilePath, certPEM, 0644}, {defaultKeyFilePath, keyPEM, 0600}} {
+		path, err := config.DefaultStore.Path(f.name)
+		if err != nil {
+			return nil, err
+		}
+		if err = os.WriteFile(path, f.data, f.perm); err != nil {
+			return nil, fmt.Errorf("failed to save TLS certificate: %w", err)
+		}
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the TLS certificate made: %w", err)
+	}
+	return &cert, nil
+}
+
+// UseTLS makes the server from NewServer listen on cfg.TLSPort with the certificate in cfg, or a self-signed one.
+// Call ListenAndServeTLS with empty file names to start it.
+func UseTLS(logger *zap.SugaredLogger, s *http.Server, cfg *config.WebConfig) error {
+	certs, err := newCertSource(logger, cfg)
+	if err != nil {
+		return err
+	}
+	s.Addr = fmt.Sprintf(":%d", cfg.TLSPort)
+	s.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12}
+	return nil
+}
+
+// NewRedirectServer returns a server on cfg.WebPort that redirects plain HTTP to HTTPS on cfg.TLSPort, so that
+// bookmarks and typed addresses still work once TLS is on.
+func NewRedirectServer(cfg *config.WebConfig) *http.Server {
+	return &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.WebPort),
+		Handler:           redirectHandler(cfg.TLSPort),
+		ReadTimeout:       30 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       30 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+	}
+}
+
+// redirectHandler redirects requests to the same host and path over HTTPS on the port. The redirect is permanent
+// and keeps the method, so that API clients' POSTs aren't turned into GETs.
+func redirectHandler(tlsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if tlsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(tlsPort))
+		} else if net.ParseIP(host) != nil && net.ParseIP(host).To4() == nil { // if the host is an IPv6 address...
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,117 @@
+package web
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"relloyd/tubetimeout/config"
+)
+
+func setupTLSHome(t *testing.T, hostname *string) string {
+	t.Helper()
+	dir := t.TempDir()
+	origHome, origHostname, origAddrs := config.DefaultStore, fnHostname, fnInterfaceAddrs
+	config.DefaultStore = config.NewStore(dir)
+	fnHostname = func() (string, error) { return *hostname, nil }
+	fnInterfaceAddrs = func() ([]net.Addr, error) {
+		return []net.Addr{&net.IPNet{IP: net.ParseIP("192.168.1.2"), Mask: net.CIDRMask(24, 32)}}, nil
+	}
+	t.Cleanup(func() {
+		config.DefaultStore, fnHostname, fnInterfaceAddrs = origHome, origHostname, origAddrs
+	})
+	return dir
+}
+
+func TestCertSource_SelfSigned(t *testing.T) {
+	hostname := "gateway"
+	dir := setupTLSHome(t, &hostname)
+
+	c, err := newCertSource(config.MustGetLogger(), &config.WebConfig{})
+	require.NoError(t, err)
+	leaf := c.cert.Leaf
+	assert.ElementsMatch(t, []string{"gateway", "gateway.local", "localhost"}, leaf.DNSNames)
+	assert.True(t, leaf.IPAddresses[1].Equal(net.ParseIP("192.168.1.2")), "expected the gateway's IP in the certificate")
+	info, err := os.Stat(filepath.Join(dir, defaultKeyFilePath))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "expected the key to be private")
+
+	// Expect the saved certificate to be used on the next run.
+	c, err = newCertSource(config.MustGetLogger(), &config.WebConfig{})
+	require.NoError(t, err)
+	assert.Equal(t, leaf.SerialNumber, c.cert.Leaf.SerialNumber)
+
+	// Expect the hostname to only be checked after hostnameCheckTTL.
+	now := time.Now()
+	c.nowFunc = func() time.Time { return now }
+	c.checked = now
+	hostname = "renamed"
+	cert, err := c.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Equal(t, leaf.SerialNumber, cert.Leaf.SerialNumber)
+
+	now = now.Add(hostnameCheckTTL)
+	cert, err = c.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, leaf.SerialNumber, cert.Leaf.SerialNumber, "expected a new certificate for the new hostname")
+	assert.Contains(t, cert.Leaf.DNSNames, "renamed")
+}
+
+func TestCertSource_Files(t *testing.T) {
+	hostname := "gateway"
+	dir := setupTLSHome(t, &hostname)
+	_, err := generateSelfSigned("provided", time.Now())
+	require.NoError(t, err)
+	cfg := &config.WebConfig{TLSCertFile: filepath.Join(dir, defaultCertFilePath), TLSKeyFile: filepath.Join(dir, defaultKeyFilePath)}
+
+	c, err := newCertSource(config.MustGetLogger(), cfg)
+	require.NoError(t, err)
+	hostname = "renamed"
+	cert, err := c.GetCertificate(nil)
+	require.NoError(t, err)
+	assert.Contains(t, cert.Leaf.DNSNames, "provided", "expected the provided certificate to be kept")
+
+	cfg.TLSKeyFile = filepath.Join(dir, "missing.pem")
+	_, err = newCertSource(config.MustGetLogger(), cfg)
+	assert.Error(t, err)
+}
+
+func TestUseTLS(t *testing.T) {
+	hostname := "gateway"
+	setupTLSHome(t, &hostname)
+	s := &http.Server{Addr: ":80"}
+	require.NoError(t, UseTLS(config.MustGetLogger(), s, &config.WebConfig{TLSPort: 8443}))
+	assert.Equal(t, ":8443", s.Addr)
+	assert.NotNil(t, s.TLSConfig.GetCertificate)
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		port     int
+		host     string
+		target   string
+		expected string
+	}{
+		{"default port", 443, "192.168.1.2", "/api/groups?x=1", "https://192.168.1.2/api/groups?x=1"},
+		{"drops the HTTP port", 443, "gateway.local:8080", "/", "https://gateway.local/"},
+		{"custom port", 8443, "gateway.local:8080", "/", "https://gateway.local:8443/"},
+		{"IPv6", 443, "[fd00::1]:80", "/", "https://[fd00::1]/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.target, nil)
+			r.Host = tt.host
+			w := httptest.NewRecorder()
+			redirectHandler(tt.port).ServeHTTP(w, r)
+			assert.Equal(t, http.StatusPermanentRedirect, w.Code)
+			assert.Equal(t, tt.expected, w.Header().Get("Location"))
+		})
+	}
+}